@@ -0,0 +1,26 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/metal3-community/metal-boot/internal/ipxe/binary"
+)
+
+// listArtifacts returns the iPXE binaries of the artifact registry with the
+// revision and options they were built with.
+func (h *handler) listArtifacts(w http.ResponseWriter, _ *http.Request) {
+	h.writeJSON(w, http.StatusOK, binary.Artifacts())
+}
+
+// getArtifact returns one binary of the artifact registry.
+func (h *handler) getArtifact(w http.ResponseWriter, r *http.Request) {
+	file := r.PathValue("file")
+	a, ok := binary.Lookup(file)
+	if !ok {
+		h.writeError(w, http.StatusNotFound, fmt.Errorf("unknown artifact %q", file))
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, a)
+}
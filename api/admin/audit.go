@@ -0,0 +1,59 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/metal3-community/metal-boot/internal/audit"
+	"github.com/metal3-community/metal-boot/internal/util"
+)
+
+var errAuditUnavailable = errors.New("audit log is not enabled")
+
+// listAudit returns the latest audited requests, newest first, optionally
+// only those about the host of the mac query parameter, by the actor query
+// parameter or since the RFC 3339 time of the since query parameter.
+func (h *handler) listAudit(w http.ResponseWriter, r *http.Request) {
+	if h.audit == nil {
+		h.writeError(w, http.StatusNotFound, errAuditUnavailable)
+		return
+	}
+
+	q := r.URL.Query()
+	var mac string
+	if v := q.Get("mac"); v != "" {
+		hw, err := util.ParseMAC(v)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		mac = hw.String()
+	}
+	var since time.Time
+	if v := q.Get("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, errors.New("since must be an RFC 3339 time"))
+			return
+		}
+		since = t
+	}
+	actor := q.Get("actor")
+
+	var out []audit.Entry
+	for _, e := range h.audit.Entries() {
+		if (mac == "" || e.MAC == mac) &&
+			(actor == "" || e.Actor == actor) &&
+			!e.Time.Before(since) {
+			out = append(out, e)
+		}
+	}
+
+	p, err := paginate(r, out)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, p)
+}
@@ -0,0 +1,70 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/metal3-community/metal-boot/internal/backup"
+)
+
+var errBackupUnavailable = errors.New("backup is not available")
+
+// restoreResponse is the result of a restore.
+type restoreResponse struct {
+	Manifest *backup.Manifest `json:"manifest"`
+	// RestartRequired is always true: the archive is staged and applied
+	// when metal-boot next starts, before any state is loaded.
+	RestartRequired bool `json:"restartRequired"`
+}
+
+// requireBackup wraps fn so that it answers 404 when no Archiver is set.
+func (h *handler) requireBackup(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.backups == nil {
+			h.writeError(w, http.StatusNotFound, errBackupUnavailable)
+			return
+		}
+		fn(w, r)
+	}
+}
+
+// getBackup streams an archive of all server state.
+func (h *handler) getBackup(w http.ResponseWriter, _ *http.Request) {
+	name := fmt.Sprintf("metal-boot-%s.tar.gz", time.Now().UTC().Format("20060102T150405Z"))
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+
+	m, err := h.backups.Export(w)
+	if err != nil {
+		// The archive is streamed, so the status has been sent already; the
+		// truncated archive fails its manifest check on restore.
+		h.logger.Error("Failed to export backup", "error", err)
+		return
+	}
+
+	h.logger.Info("Exported backup", "files", len(m.Files), "sources", len(m.Sources))
+}
+
+// postRestore stages the uploaded archive to replace server state at the
+// next start.
+func (h *handler) postRestore(w http.ResponseWriter, r *http.Request) {
+	m, err := h.backups.Restore(r.Body)
+	switch {
+	case errors.Is(err, backup.ErrInvalid):
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	case err != nil:
+		h.logger.Error("Failed to restore backup", "error", err)
+		h.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.logger.Warn("Staged backup, restart metal-boot to apply it",
+		"version", m.Version,
+		"created", m.Created,
+		"files", len(m.Files),
+	)
+	h.writeJSON(w, http.StatusOK, restoreResponse{Manifest: m, RestartRequired: true})
+}
@@ -0,0 +1,36 @@
+package admin
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/metal3-community/metal-boot/internal/hoststate"
+)
+
+// bootSourceResponse is the body returned by GET /api/v1/systems/{mac}/boot-source.
+type bootSourceResponse struct {
+	Requested   string                `json:"requested,omitempty"`
+	RequestedAt *time.Time            `json:"requestedAt,omitempty"`
+	Observed    *hoststate.BootSource `json:"observed,omitempty"`
+	Drift       bool                  `json:"drift"`
+}
+
+// getBootSource returns the requested and observed boot source of a host.
+func (h *handler) getBootSource(w http.ResponseWriter, r *http.Request) {
+	mac, ok := h.pathMAC(w, r)
+	if !ok {
+		return
+	}
+
+	host, _ := h.hosts.Get(mac)
+	resp := bootSourceResponse{
+		Requested: host.RequestedBootSource,
+		Observed:  host.ObservedBootSource,
+		Drift:     host.BootSourceDrift(),
+	}
+	if !host.RequestedBootSourceAt.IsZero() {
+		resp.RequestedAt = &host.RequestedBootSourceAt
+	}
+
+	h.writeJSON(w, http.StatusOK, resp)
+}
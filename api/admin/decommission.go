@@ -0,0 +1,52 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	errDecommissionUnavailable = errors.New("decommissioning is not enabled")
+	errNoDecommission          = errors.New("no decommission")
+)
+
+// startDecommission decommissions a host in the background and answers with
+// its task. A decommission of the host that is still running is returned
+// instead of starting another.
+func (h *handler) startDecommission(w http.ResponseWriter, r *http.Request) {
+	mac, ok := h.pathMAC(w, r)
+	if !ok {
+		return
+	}
+	if h.decommissioner == nil {
+		h.writeError(w, http.StatusNotFound, errDecommissionUnavailable)
+		return
+	}
+
+	id := h.decommissioner.Start(mac)
+	h.logger.Info("Started decommission", "mac", mac.String(), "task", id)
+
+	info, _ := h.decommissioner.Task(mac)
+	w.Header().Set("Location", r.URL.Path)
+	h.writeJSON(w, http.StatusAccepted, info)
+}
+
+// getDecommission returns the task of the latest decommission of a host.
+func (h *handler) getDecommission(w http.ResponseWriter, r *http.Request) {
+	mac, ok := h.pathMAC(w, r)
+	if !ok {
+		return
+	}
+	if h.decommissioner == nil {
+		h.writeError(w, http.StatusNotFound, errDecommissionUnavailable)
+		return
+	}
+
+	info, ok := h.decommissioner.Task(mac)
+	if !ok {
+		h.writeError(w, http.StatusNotFound, fmt.Errorf("%w of %s", errNoDecommission, mac))
+		return
+	}
+	h.writeJSON(w, http.StatusOK, info)
+}
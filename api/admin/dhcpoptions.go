@@ -0,0 +1,71 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/metal3-community/metal-boot/internal/hoststate"
+)
+
+// getDHCPOptions returns the extra DHCP options of a host.
+func (h *handler) getDHCPOptions(w http.ResponseWriter, r *http.Request) {
+	mac, ok := h.pathMAC(w, r)
+	if !ok {
+		return
+	}
+
+	host, _ := h.hosts.Get(mac)
+	opts := host.DHCPOptions
+	if opts == nil {
+		opts = []hoststate.DHCPOption{}
+	}
+	h.writeJSON(w, http.StatusOK, opts)
+}
+
+// putDHCPOptions replaces the extra DHCP options of a host. They are merged
+// into its next OFFER and ACK, overriding options of the same code.
+func (h *handler) putDHCPOptions(w http.ResponseWriter, r *http.Request) {
+	mac, ok := h.pathMAC(w, r)
+	if !ok {
+		return
+	}
+
+	var opts []hoststate.DHCPOption
+	if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := hoststate.ValidateDHCPOptions(opts); err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.hosts.UpdateAs(mac, actor(r), func(host *hoststate.Host) {
+		host.DHCPOptions = opts
+	}); err != nil {
+		h.logger.Error("Failed to store DHCP options", "mac", mac.String(), "error", err)
+		h.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.logger.Info("Updated DHCP options", "mac", mac.String(), "options", len(opts))
+	h.writeJSON(w, http.StatusOK, opts)
+}
+
+// deleteDHCPOptions clears the extra DHCP options of a host.
+func (h *handler) deleteDHCPOptions(w http.ResponseWriter, r *http.Request) {
+	mac, ok := h.pathMAC(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.hosts.UpdateAs(mac, actor(r), func(host *hoststate.Host) {
+		host.DHCPOptions = nil
+	}); err != nil {
+		h.logger.Error("Failed to clear DHCP options", "mac", mac.String(), "error", err)
+		h.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
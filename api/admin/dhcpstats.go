@@ -0,0 +1,19 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+)
+
+var errDHCPUnavailable = errors.New("the DHCP server is disabled")
+
+// getDHCPStats returns the reply rates of the DHCP server over the last
+// minute, its totals and the usage of its lease pool.
+func (h *handler) getDHCPStats(w http.ResponseWriter, _ *http.Request) {
+	if h.dhcpStats == nil {
+		h.writeError(w, http.StatusNotFound, errDHCPUnavailable)
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, h.dhcpStats.Snapshot())
+}
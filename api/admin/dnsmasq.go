@@ -0,0 +1,190 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	dnsmasqconfig "github.com/metal3-community/metal-boot/internal/backend/dnsmasq/config"
+)
+
+var errDnsmasqUnavailable = errors.New("dnsmasq config management is not available")
+
+// requireDnsmasq wraps fn so that it answers 404 when no ConfigManager is set.
+func (h *handler) requireDnsmasq(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.dnsmasq == nil {
+			h.writeError(w, http.StatusNotFound, errDnsmasqUnavailable)
+			return
+		}
+		fn(w, r)
+	}
+}
+
+// writeDnsmasqError maps ConfigManager errors to HTTP status codes.
+func (h *handler) writeDnsmasqError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, dnsmasqconfig.ErrNotFound):
+		h.writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, dnsmasqconfig.ErrInvalid):
+		h.writeError(w, http.StatusBadRequest, err)
+	default:
+		h.logger.Error("Failed to update dnsmasq config", "error", err)
+		h.writeError(w, http.StatusInternalServerError, err)
+	}
+}
+
+// listDnsmasqHosts returns all dhcp-host entries.
+func (h *handler) listDnsmasqHosts(w http.ResponseWriter, _ *http.Request) {
+	h.writeJSON(w, http.StatusOK, h.dnsmasq.Hosts())
+}
+
+// getDnsmasqHost returns the dhcp-host entry of one MAC.
+func (h *handler) getDnsmasqHost(w http.ResponseWriter, r *http.Request) {
+	mac, ok := h.pathMAC(w, r)
+	if !ok {
+		return
+	}
+
+	entry, ok := h.dnsmasq.GetHost(mac)
+	if !ok {
+		h.writeError(w, http.StatusNotFound, dnsmasqconfig.ErrNotFound)
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, entry)
+}
+
+// listDnsmasqOptions returns the options of every tag, keyed by tag.
+func (h *handler) listDnsmasqOptions(w http.ResponseWriter, _ *http.Request) {
+	out := make(map[string][]dnsmasqconfig.DHCPOption)
+	for _, tag := range h.dnsmasq.Tags() {
+		if opts, err := h.dnsmasq.Options(tag); err == nil {
+			out[tag] = opts
+		}
+	}
+
+	h.writeJSON(w, http.StatusOK, out)
+}
+
+// getDnsmasqOptions returns the options of one tag.
+func (h *handler) getDnsmasqOptions(w http.ResponseWriter, r *http.Request) {
+	opts, err := h.dnsmasq.Options(r.PathValue("tag"))
+	if err != nil {
+		h.writeDnsmasqError(w, err)
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, opts)
+}
+
+// putDnsmasqOptions replaces the options of a tag.
+func (h *handler) putDnsmasqOptions(w http.ResponseWriter, r *http.Request) {
+	tag := r.PathValue("tag")
+
+	var opts []dnsmasqconfig.DHCPOption
+	if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.dnsmasq.SetOptions(tag, opts); err != nil {
+		h.writeDnsmasqError(w, err)
+		return
+	}
+
+	h.logger.Info("Replaced dnsmasq options", "tag", tag, "count", len(opts))
+	h.writeJSON(w, http.StatusOK, opts)
+}
+
+// addDnsmasqOption appends one option to a tag.
+func (h *handler) addDnsmasqOption(w http.ResponseWriter, r *http.Request) {
+	tag := r.PathValue("tag")
+
+	var opt dnsmasqconfig.DHCPOption
+	if err := json.NewDecoder(r.Body).Decode(&opt); err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.dnsmasq.AddOption(tag, opt); err != nil {
+		h.writeDnsmasqError(w, err)
+		return
+	}
+
+	h.logger.Info("Added dnsmasq option", "tag", tag, "code", opt.Code)
+	h.writeJSON(w, http.StatusCreated, opt)
+}
+
+// deleteDnsmasqOptions removes the options file of a tag.
+func (h *handler) deleteDnsmasqOptions(w http.ResponseWriter, r *http.Request) {
+	tag := r.PathValue("tag")
+
+	if err := h.dnsmasq.DeleteOptions(tag); err != nil {
+		h.writeDnsmasqError(w, err)
+		return
+	}
+
+	h.logger.Info("Deleted dnsmasq options", "tag", tag)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeDnsmasqOption removes every option with the given code from a tag.
+func (h *handler) removeDnsmasqOption(w http.ResponseWriter, r *http.Request) {
+	tag := r.PathValue("tag")
+
+	code, err := dnsmasqconfig.ParseOptionCode(r.PathValue("code"))
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.dnsmasq.RemoveOption(tag, code); err != nil {
+		h.writeDnsmasqError(w, err)
+		return
+	}
+
+	h.logger.Info("Removed dnsmasq option", "tag", tag, "code", code)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getDnsmasqConfig returns every dhcp-host entry and the options of every tag.
+func (h *handler) getDnsmasqConfig(w http.ResponseWriter, _ *http.Request) {
+	h.writeJSON(w, http.StatusOK, h.dnsmasq.Snapshot())
+}
+
+// putDnsmasqConfig replaces the whole dnsmasq config, writing only the files
+// that change, and returns the changed files. With ?dry_run=true nothing is
+// written.
+func (h *handler) putDnsmasqConfig(w http.ResponseWriter, r *http.Request) {
+	dryRun := false
+	if v := r.URL.Query().Get("dry_run"); v != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid dry_run: %w", err))
+			return
+		}
+	}
+
+	var snapshot dnsmasqconfig.Snapshot
+	if err := json.NewDecoder(r.Body).Decode(&snapshot); err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	changes, err := h.dnsmasq.SaveConfig(snapshot, dryRun)
+	if err != nil {
+		h.writeDnsmasqError(w, err)
+		return
+	}
+	if changes == nil {
+		changes = []dnsmasqconfig.FileChange{}
+	}
+
+	if !dryRun {
+		h.logger.Info("Replaced dnsmasq config", "changed_files", len(changes))
+	}
+	h.writeJSON(w, http.StatusOK, changes)
+}
@@ -0,0 +1,24 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// listDownloads returns the progress of the running and recent background
+// downloads, oldest first.
+func (h *handler) listDownloads(w http.ResponseWriter, _ *http.Request) {
+	h.writeJSON(w, http.StatusOK, h.downloads.List())
+}
+
+// getDownload returns the progress of one download.
+func (h *handler) getDownload(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	p, ok := h.downloads.Get(id)
+	if !ok {
+		h.writeError(w, http.StatusNotFound, fmt.Errorf("unknown download %q", id))
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, p)
+}
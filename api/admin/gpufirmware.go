@@ -0,0 +1,147 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/metal3-community/metal-boot/internal/gpufw"
+)
+
+var errGPUFirmwareUnavailable = errors.New("gpu firmware management is not enabled")
+
+// gpuFirmwareSelection is the version a host's GPU boots.
+type gpuFirmwareSelection struct {
+	Group   string `json:"group"`
+	Version string `json:"version"`
+}
+
+// requireGPUFirmware wraps fn so that it answers 404 when no Store is set.
+func (h *handler) requireGPUFirmware(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.gpu == nil {
+			h.writeError(w, http.StatusNotFound, errGPUFirmwareUnavailable)
+			return
+		}
+		fn(w, r)
+	}
+}
+
+// writeGPUFirmwareError maps Store errors to HTTP status codes.
+func (h *handler) writeGPUFirmwareError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, gpufw.ErrNotFound):
+		h.writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, gpufw.ErrInvalid):
+		h.writeError(w, http.StatusBadRequest, err)
+	case errors.Is(err, gpufw.ErrInUse):
+		h.writeError(w, http.StatusConflict, err)
+	default:
+		h.logger.Error("Failed to update gpu firmware", "error", err)
+		h.writeError(w, http.StatusInternalServerError, err)
+	}
+}
+
+// listGPUFirmwareVersions returns the available boot file versions.
+func (h *handler) listGPUFirmwareVersions(w http.ResponseWriter, _ *http.Request) {
+	versions, err := h.gpu.Versions()
+	if err != nil {
+		h.writeGPUFirmwareError(w, err)
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, versions)
+}
+
+// getGPUFirmwareVersion returns one version.
+func (h *handler) getGPUFirmwareVersion(w http.ResponseWriter, r *http.Request) {
+	version, err := h.gpu.Version(r.PathValue("version"))
+	if err != nil {
+		h.writeGPUFirmwareError(w, err)
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, version)
+}
+
+// putGPUFirmwareFile uploads one boot file of a version, creating the version.
+func (h *handler) putGPUFirmwareFile(w http.ResponseWriter, r *http.Request) {
+	name, file := r.PathValue("version"), r.PathValue("file")
+	if err := h.gpu.Install(name, file, r.Body); err != nil {
+		h.writeGPUFirmwareError(w, err)
+		return
+	}
+
+	version, err := h.gpu.Version(name)
+	if err != nil {
+		h.writeGPUFirmwareError(w, err)
+		return
+	}
+
+	h.logger.Info("Installed gpu firmware file", "version", name, "file", file)
+	h.writeJSON(w, http.StatusOK, version)
+}
+
+// deleteGPUFirmwareVersion removes a version no group is pinned to.
+func (h *handler) deleteGPUFirmwareVersion(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("version")
+	if err := h.gpu.RemoveVersion(name); err != nil {
+		h.writeGPUFirmwareError(w, err)
+		return
+	}
+
+	h.logger.Info("Removed gpu firmware version", "version", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listGPUFirmwareGroups returns all host groups, default first.
+func (h *handler) listGPUFirmwareGroups(w http.ResponseWriter, _ *http.Request) {
+	h.writeJSON(w, http.StatusOK, h.gpu.Groups())
+}
+
+// putGPUFirmwareGroup pins a group of hosts to a version. Upgrading a group is
+// a PUT with the new version.
+func (h *handler) putGPUFirmwareGroup(w http.ResponseWriter, r *http.Request) {
+	var group gpufw.Group
+	if err := json.NewDecoder(r.Body).Decode(&group); err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	group.Name = r.PathValue("group")
+
+	group, err := h.gpu.SetGroup(group)
+	if err != nil {
+		h.writeGPUFirmwareError(w, err)
+		return
+	}
+
+	h.logger.Info("Updated gpu firmware group",
+		"group", group.Name,
+		"version", group.Version,
+		"hosts", len(group.Hosts),
+	)
+	h.writeJSON(w, http.StatusOK, group)
+}
+
+// deleteGPUFirmwareGroup removes a group; its hosts use the default group.
+func (h *handler) deleteGPUFirmwareGroup(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("group")
+	if err := h.gpu.DeleteGroup(name); err != nil {
+		h.writeGPUFirmwareError(w, err)
+		return
+	}
+
+	h.logger.Info("Deleted gpu firmware group", "group", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getGPUFirmwareSelection returns the group and version a host boots.
+func (h *handler) getGPUFirmwareSelection(w http.ResponseWriter, r *http.Request) {
+	mac, ok := h.pathMAC(w, r)
+	if !ok {
+		return
+	}
+
+	group, version := h.gpu.Resolve(mac)
+	h.writeJSON(w, http.StatusOK, gpuFirmwareSelection{Group: group, Version: version})
+}
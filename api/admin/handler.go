@@ -0,0 +1,249 @@
+// Package admin implements metal-boot's native administrative REST API.
+//
+// Unlike the Redfish emulation it is not constrained by a specification, so it
+// exposes metal-boot concepts directly as plain JSON under /api/v1/.
+package admin
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+
+	"github.com/metal3-community/metal-boot/internal/audit"
+	"github.com/metal3-community/metal-boot/internal/backend"
+	dnsmasqconfig "github.com/metal3-community/metal-boot/internal/backend/dnsmasq/config"
+	"github.com/metal3-community/metal-boot/internal/backup"
+	"github.com/metal3-community/metal-boot/internal/canary"
+	"github.com/metal3-community/metal-boot/internal/config"
+	"github.com/metal3-community/metal-boot/internal/decommission"
+	"github.com/metal3-community/metal-boot/internal/download"
+	"github.com/metal3-community/metal-boot/internal/gpufw"
+	"github.com/metal3-community/metal-boot/internal/hoststate"
+	"github.com/metal3-community/metal-boot/internal/imagecatalog"
+	"github.com/metal3-community/metal-boot/internal/leasedb"
+	"github.com/metal3-community/metal-boot/internal/metric"
+	"github.com/metal3-community/metal-boot/internal/readonly"
+	"github.com/metal3-community/metal-boot/internal/snapshot"
+	"github.com/metal3-community/metal-boot/internal/util"
+)
+
+// handler serves the admin API.
+type handler struct {
+	logger         *slog.Logger
+	config         *config.Config
+	backend        backend.BackendReader
+	power          backend.BackendPower
+	hosts          *hoststate.Store
+	dnsmasq        *dnsmasqconfig.ConfigManager
+	gpu            *gpufw.Store
+	images         *imagecatalog.Catalog
+	readOnly       *readonly.Switch
+	backups        *backup.Archiver
+	downloads      *download.Tracker
+	dhcpStats      *metric.DHCPStats
+	rollouts       *canary.Store
+	audit          *audit.Log
+	leases         *leasedb.DB
+	decommissioner *decommission.Decommissioner
+	snapshots      *snapshot.Caches
+	mux            *http.ServeMux
+}
+
+// New creates a new admin API handler.
+// dnsmasq may be nil when the backend does not manage dnsmasq files, in which
+// case the /api/v1/dnsmasq/ routes return 404. gpu may be nil when GPU
+// firmware management is disabled, likewise for /api/v1/gpu-firmware/.
+// images may be nil, in which case the /api/v1/images routes return 404.
+// While readOnly is enabled every mutating request except turning read-only
+// mode off is rejected with 403. backups may be nil, in which case the backup
+// and restore routes return 404. downloads reports the background downloads
+// under /api/v1/downloads; nil lists none. rollouts may be nil, in which case
+// the /api/v1/rollouts routes return 404, as they do without images. power
+// may be nil, in which case machines report no power state. The
+// /api/v1/hosts routes return 404 unless backend is a
+// backend.BackendHostWriter. decommissioner may be nil, in which case the
+// decommission routes return 404. snapshots may be nil, in which case
+// /api/v1/snapshot returns 404.
+func New(
+	logger *slog.Logger,
+	cfg *config.Config,
+	backend backend.BackendReader,
+	power backend.BackendPower,
+	hosts *hoststate.Store,
+	dnsmasq *dnsmasqconfig.ConfigManager,
+	gpu *gpufw.Store,
+	images *imagecatalog.Catalog,
+	readOnly *readonly.Switch,
+	backups *backup.Archiver,
+	downloads *download.Tracker,
+	dhcpStats *metric.DHCPStats,
+	rollouts *canary.Store,
+	auditLog *audit.Log,
+	leases *leasedb.DB,
+	decommissioner *decommission.Decommissioner,
+	snapshots *snapshot.Caches,
+) http.Handler {
+	h := &handler{
+		logger:         logger,
+		config:         cfg,
+		backend:        backend,
+		power:          power,
+		hosts:          hosts,
+		dnsmasq:        dnsmasq,
+		gpu:            gpu,
+		images:         images,
+		readOnly:       readOnly,
+		backups:        backups,
+		downloads:      downloads,
+		dhcpStats:      dhcpStats,
+		rollouts:       rollouts,
+		audit:          auditLog,
+		leases:         leases,
+		decommissioner: decommissioner,
+		snapshots:      snapshots,
+		mux:            http.NewServeMux(),
+	}
+
+	h.mux.HandleFunc("GET /api/v1/whoami", h.getWhoami)
+	h.mux.HandleFunc("GET "+readOnlyPath, h.getReadOnly)
+	h.mux.HandleFunc("PUT "+readOnlyPath, h.putReadOnly)
+	h.mux.HandleFunc("GET /api/v1/backup", h.requireBackup(h.getBackup))
+	h.mux.HandleFunc("POST /api/v1/restore", h.requireBackup(h.postRestore))
+	h.mux.HandleFunc("GET /api/v1/snapshot", h.getSnapshot)
+	h.mux.HandleFunc("GET /api/v1/downloads", h.listDownloads)
+	h.mux.HandleFunc("GET /api/v1/downloads/{id}", h.getDownload)
+	h.mux.HandleFunc("GET /api/v1/artifacts", h.listArtifacts)
+	h.mux.HandleFunc("GET /api/v1/artifacts/{file}", h.getArtifact)
+	h.mux.HandleFunc("GET /api/v1/dhcp/stats", h.getDHCPStats)
+	h.mux.HandleFunc("GET /api/v1/dhcp/leases", h.listLeaseDB)
+	h.mux.HandleFunc("GET /api/v1/dhcp/leases/{mac}", h.getLeaseDB)
+	h.mux.HandleFunc("GET /api/v1/dhcp/pools", h.listPools)
+	h.mux.HandleFunc("GET /api/v1/audit", h.listAudit)
+
+	h.mux.HandleFunc("GET /api/v1/systems/{mac}/kernel-args", h.getKernelArgs)
+	h.mux.HandleFunc("PUT /api/v1/systems/{mac}/kernel-args", h.putKernelArgs)
+	h.mux.HandleFunc("DELETE /api/v1/systems/{mac}/kernel-args", h.deleteKernelArgs)
+	h.mux.HandleFunc("GET /api/v1/systems/{mac}/metadata", h.getMetadata)
+	h.mux.HandleFunc("PUT /api/v1/systems/{mac}/metadata", h.putMetadata)
+	h.mux.HandleFunc("DELETE /api/v1/systems/{mac}/metadata", h.deleteMetadata)
+	h.mux.HandleFunc("PUT /api/v1/systems/{mac}/metadata/{key}", h.putMetadataKey)
+	h.mux.HandleFunc("DELETE /api/v1/systems/{mac}/metadata/{key}", h.deleteMetadataKey)
+	h.mux.HandleFunc("GET /api/v1/systems/{mac}/dhcp-options", h.getDHCPOptions)
+	h.mux.HandleFunc("PUT /api/v1/systems/{mac}/dhcp-options", h.putDHCPOptions)
+	h.mux.HandleFunc("DELETE /api/v1/systems/{mac}/dhcp-options", h.deleteDHCPOptions)
+	h.mux.HandleFunc("GET /api/v1/systems/{mac}/boot-source", h.getBootSource)
+	h.mux.HandleFunc("GET /api/v1/systems/{mac}/rendered", h.getRendered)
+	h.mux.HandleFunc("GET /api/v1/systems/{mac}/rendered/boot.ipxe", h.getRenderedIPXE)
+	h.mux.HandleFunc("GET /api/v1/systems/{mac}/history", h.getHistory)
+	h.mux.HandleFunc("POST /api/v1/systems/{mac}/history/{version}/revert", h.revertHistory)
+
+	h.mux.HandleFunc("GET /api/v1/dnsmasq/config", h.requireDnsmasq(h.getDnsmasqConfig))
+	h.mux.HandleFunc("PUT /api/v1/dnsmasq/config", h.requireDnsmasq(h.putDnsmasqConfig))
+	h.mux.HandleFunc("GET /api/v1/dnsmasq/hosts", h.requireDnsmasq(h.listDnsmasqHosts))
+	h.mux.HandleFunc("GET /api/v1/dnsmasq/hosts/{mac}", h.requireDnsmasq(h.getDnsmasqHost))
+	h.mux.HandleFunc("GET /api/v1/dnsmasq/opts", h.requireDnsmasq(h.listDnsmasqOptions))
+	h.mux.HandleFunc("GET /api/v1/dnsmasq/opts/{tag}", h.requireDnsmasq(h.getDnsmasqOptions))
+	h.mux.HandleFunc("PUT /api/v1/dnsmasq/opts/{tag}", h.requireDnsmasq(h.putDnsmasqOptions))
+	h.mux.HandleFunc("POST /api/v1/dnsmasq/opts/{tag}", h.requireDnsmasq(h.addDnsmasqOption))
+	h.mux.HandleFunc("DELETE /api/v1/dnsmasq/opts/{tag}", h.requireDnsmasq(h.deleteDnsmasqOptions))
+	h.mux.HandleFunc("DELETE /api/v1/dnsmasq/opts/{tag}/{code}", h.requireDnsmasq(h.removeDnsmasqOption))
+	h.mux.HandleFunc("GET /api/v1/dnsmasq/reservations", h.requireDnsmasq(h.listReservations))
+	h.mux.HandleFunc("POST /api/v1/dnsmasq/reservations/{mac}", h.requireDnsmasq(h.pinLease))
+	h.mux.HandleFunc("DELETE /api/v1/dnsmasq/reservations/{mac}", h.requireDnsmasq(h.releaseReservation))
+
+	h.mux.HandleFunc("GET /api/v1/machines", h.listMachines)
+	h.mux.HandleFunc("GET /api/v1/machines/{mac}", h.getMachine)
+	h.mux.HandleFunc("PUT /api/v1/machines/{mac}/netboot", h.putNetboot)
+	h.mux.HandleFunc("GET /api/v1/machines/{mac}/power", h.getPower)
+	h.mux.HandleFunc("GET /api/v1/machines/{mac}/firmware", h.getFirmware)
+	h.mux.HandleFunc("POST /api/v1/machines/{mac}/decommission", h.startDecommission)
+	h.mux.HandleFunc("GET /api/v1/machines/{mac}/decommission", h.getDecommission)
+	h.mux.HandleFunc("GET /api/v1/leases", h.listLeases)
+
+	h.mux.HandleFunc("GET /api/v1/hosts", h.listHosts)
+	h.mux.HandleFunc("POST /api/v1/hosts", h.createHost)
+	h.mux.HandleFunc("GET /api/v1/hosts/{mac}", h.getHost)
+	h.mux.HandleFunc("PUT /api/v1/hosts/{mac}", h.putHost)
+	h.mux.HandleFunc("DELETE /api/v1/hosts/{mac}", h.deleteHost)
+	h.mux.HandleFunc("PUT /api/v1/hosts/{mac}/options", h.putHostOptions)
+
+	h.mux.HandleFunc("GET /api/v1/images", h.requireImages(h.listImages))
+	h.mux.HandleFunc("POST /api/v1/images", h.requireImages(h.createImage))
+	h.mux.HandleFunc("GET /api/v1/images/{name}", h.requireImages(h.getImage))
+	h.mux.HandleFunc("PUT /api/v1/images/{name}", h.requireImages(h.putImage))
+	h.mux.HandleFunc("DELETE /api/v1/images/{name}", h.requireImages(h.deleteImage))
+
+	h.mux.HandleFunc("GET /api/v1/rollouts", h.requireRollouts(h.listRollouts))
+	h.mux.HandleFunc("GET /api/v1/rollouts/{name}", h.requireRollouts(h.getRollout))
+	h.mux.HandleFunc("PUT /api/v1/rollouts/{name}", h.requireRollouts(h.putRollout))
+	h.mux.HandleFunc("DELETE /api/v1/rollouts/{name}", h.requireRollouts(h.deleteRollout))
+	h.mux.HandleFunc("POST /api/v1/rollouts/{name}/percent",
+		h.requireRollouts(h.setRolloutPercent))
+	h.mux.HandleFunc("POST /api/v1/rollouts/{name}/promote", h.requireRollouts(h.promoteRollout))
+	h.mux.HandleFunc("POST /api/v1/rollouts/{name}/rollback",
+		h.requireRollouts(h.rollbackRollout))
+
+	h.mux.HandleFunc("GET /api/v1/systems/{mac}/gpu-firmware",
+		h.requireGPUFirmware(h.getGPUFirmwareSelection))
+	h.mux.HandleFunc("GET /api/v1/gpu-firmware/versions",
+		h.requireGPUFirmware(h.listGPUFirmwareVersions))
+	h.mux.HandleFunc("GET /api/v1/gpu-firmware/versions/{version}",
+		h.requireGPUFirmware(h.getGPUFirmwareVersion))
+	h.mux.HandleFunc("DELETE /api/v1/gpu-firmware/versions/{version}",
+		h.requireGPUFirmware(h.deleteGPUFirmwareVersion))
+	h.mux.HandleFunc("PUT /api/v1/gpu-firmware/versions/{version}/files/{file}",
+		h.requireGPUFirmware(h.putGPUFirmwareFile))
+	h.mux.HandleFunc("GET /api/v1/gpu-firmware/groups",
+		h.requireGPUFirmware(h.listGPUFirmwareGroups))
+	h.mux.HandleFunc("PUT /api/v1/gpu-firmware/groups/{group}",
+		h.requireGPUFirmware(h.putGPUFirmwareGroup))
+	h.mux.HandleFunc("DELETE /api/v1/gpu-firmware/groups/{group}",
+		h.requireGPUFirmware(h.deleteGPUFirmwareGroup))
+
+	return h
+}
+
+// ServeHTTP routes admin API requests.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.logger.Debug("Handling admin request", "path", r.URL.Path, "method", r.Method)
+
+	if err := h.readOnly.Check(r.Method); err != nil && r.URL.Path != readOnlyPath {
+		h.logger.Info("Rejected admin request in read-only mode",
+			"path", r.URL.Path,
+			"method", r.Method,
+		)
+		h.writeError(w, http.StatusForbidden, err)
+		return
+	}
+
+	h.mux.ServeHTTP(w, r)
+}
+
+// errorResponse is the body returned for failed requests.
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func (h *handler) writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		h.logger.Error("Failed to encode admin response", "error", err)
+	}
+}
+
+func (h *handler) writeError(w http.ResponseWriter, status int, err error) {
+	h.writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+// pathMAC parses the {mac} path wildcard, writing a 400 on failure.
+func (h *handler) pathMAC(w http.ResponseWriter, r *http.Request) (net.HardwareAddr, bool) {
+	mac, err := util.ParseMAC(r.PathValue("mac"))
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+
+	return mac, true
+}
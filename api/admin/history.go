@@ -0,0 +1,66 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/metal3-community/metal-boot/internal/adminauth"
+	"github.com/metal3-community/metal-boot/internal/hoststate"
+)
+
+// actor returns who made the request r, for the history of the hosts it
+// changes: the authenticated operator, or "api" without admin authentication.
+func actor(r *http.Request) string {
+	p := adminauth.FromContext(r.Context())
+	switch {
+	case p == nil:
+		return "api"
+	case p.Name != "":
+		return p.Name
+	default:
+		return p.Subject
+	}
+}
+
+// getHistory returns the revisions of the settings of a host, oldest first.
+func (h *handler) getHistory(w http.ResponseWriter, r *http.Request) {
+	mac, ok := h.pathMAC(w, r)
+	if !ok {
+		return
+	}
+
+	history := h.hosts.History(mac)
+	if history == nil {
+		history = []hoststate.Revision{}
+	}
+	h.writeJSON(w, http.StatusOK, history)
+}
+
+// revertHistory restores the settings of a host to those of a revision.
+func (h *handler) revertHistory(w http.ResponseWriter, r *http.Request) {
+	mac, ok := h.pathMAC(w, r)
+	if !ok {
+		return
+	}
+	version, err := strconv.Atoi(r.PathValue("version"))
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	rev, err := h.hosts.Revert(mac, version, actor(r))
+	if errors.Is(err, hoststate.ErrRevisionNotFound) {
+		h.writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to revert host settings", "mac", mac.String(), "error", err)
+		h.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.logger.Info("Reverted host settings", "mac", mac.String(), "version", version,
+		"actor", rev.Actor)
+	h.writeJSON(w, http.StatusOK, rev)
+}
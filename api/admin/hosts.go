@@ -0,0 +1,264 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/metal3-community/metal-boot/internal/audit"
+	"github.com/metal3-community/metal-boot/internal/backend"
+	"github.com/metal3-community/metal-boot/internal/util"
+)
+
+var errHostsUnsupported = errors.New("backend does not support writing hosts")
+
+// hostJSON is the wire form of backend.Host.
+type hostJSON struct {
+	MAC      string           `json:"mac"`
+	IP       net.IP           `json:"ip,omitempty"`
+	IPv6     net.IP           `json:"ipv6,omitempty"`
+	Hostname string           `json:"hostname,omitempty"`
+	Disabled bool             `json:"disabled,omitempty"`
+	Options  []hostOptionJSON `json:"options,omitempty"`
+}
+
+// hostOptionJSON is the wire form of backend.HostOption.
+type hostOptionJSON struct {
+	Code  uint8  `json:"code"`
+	Value string `json:"value"`
+}
+
+func toHostJSON(host backend.Host) hostJSON {
+	out := hostJSON{
+		MAC:      host.MAC.String(),
+		IP:       host.IP,
+		IPv6:     host.IPv6,
+		Hostname: host.Hostname,
+		Disabled: host.Disabled,
+	}
+	for _, o := range host.Options {
+		out.Options = append(out.Options, hostOptionJSON(o))
+	}
+
+	return out
+}
+
+// host returns the backend.Host of in for mac. The options are nil, which
+// keeps those of an existing host, when in has none.
+func (in hostJSON) host(mac net.HardwareAddr) backend.Host {
+	return backend.Host{
+		MAC:      mac,
+		IP:       in.IP,
+		IPv6:     in.IPv6,
+		Hostname: in.Hostname,
+		Disabled: in.Disabled,
+		Options:  hostOptions(in.Options),
+	}
+}
+
+func hostOptions(in []hostOptionJSON) []backend.HostOption {
+	if in == nil {
+		return nil
+	}
+	out := make([]backend.HostOption, 0, len(in))
+	for _, o := range in {
+		out = append(out, backend.HostOption(o))
+	}
+
+	return out
+}
+
+// hostWriter returns the backend as a backend.BackendHostWriter, writing a
+// 404 if it is not one.
+func (h *handler) hostWriter(w http.ResponseWriter) (backend.BackendHostWriter, bool) {
+	hw, ok := h.backend.(backend.BackendHostWriter)
+	if !ok {
+		h.writeError(w, http.StatusNotFound, errHostsUnsupported)
+		return nil, false
+	}
+
+	return hw, true
+}
+
+// writeHostError maps BackendHostWriter errors to HTTP status codes.
+func (h *handler) writeHostError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, backend.ErrHostNotFound):
+		h.writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, backend.ErrHostExists):
+		h.writeError(w, http.StatusConflict, err)
+	case errors.Is(err, backend.ErrInvalidHost):
+		h.writeError(w, http.StatusBadRequest, err)
+	default:
+		h.logger.Error("Failed to write host", "error", err)
+		h.writeError(w, http.StatusInternalServerError, err)
+	}
+}
+
+// listHosts returns every host of the backend.
+func (h *handler) listHosts(w http.ResponseWriter, r *http.Request) {
+	hw, ok := h.hostWriter(w)
+	if !ok {
+		return
+	}
+
+	hosts, err := hw.Hosts(r.Context())
+	if err != nil {
+		h.writeHostError(w, err)
+		return
+	}
+	out := make([]hostJSON, 0, len(hosts))
+	for _, host := range hosts {
+		out = append(out, toHostJSON(host))
+	}
+
+	h.writeJSON(w, http.StatusOK, out)
+}
+
+// getHost returns one host of the backend.
+func (h *handler) getHost(w http.ResponseWriter, r *http.Request) {
+	mac, ok := h.pathMAC(w, r)
+	if !ok {
+		return
+	}
+	hw, ok := h.hostWriter(w)
+	if !ok {
+		return
+	}
+
+	host, err := hw.GetHost(r.Context(), mac)
+	if err != nil {
+		h.writeHostError(w, err)
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, toHostJSON(host))
+}
+
+// createHost adds the host of the body, which names its MAC.
+func (h *handler) createHost(w http.ResponseWriter, r *http.Request) {
+	hw, ok := h.hostWriter(w)
+	if !ok {
+		return
+	}
+
+	var in hostJSON
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	mac, err := util.ParseMAC(in.MAC)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := hw.CreateHost(r.Context(), in.host(mac)); err != nil {
+		h.writeHostError(w, err)
+		return
+	}
+	h.writeStoredHost(w, r, hw, mac, http.StatusCreated)
+}
+
+// putHost replaces a host. Its options are kept when the body has none.
+func (h *handler) putHost(w http.ResponseWriter, r *http.Request) {
+	mac, ok := h.pathMAC(w, r)
+	if !ok {
+		return
+	}
+	hw, ok := h.hostWriter(w)
+	if !ok {
+		return
+	}
+	h.auditOldHost(r, hw, mac)
+
+	var in hostJSON
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := hw.UpdateHost(r.Context(), in.host(mac)); err != nil {
+		h.writeHostError(w, err)
+		return
+	}
+	h.writeStoredHost(w, r, hw, mac, http.StatusOK)
+}
+
+// deleteHost removes a host and its options.
+func (h *handler) deleteHost(w http.ResponseWriter, r *http.Request) {
+	mac, ok := h.pathMAC(w, r)
+	if !ok {
+		return
+	}
+	hw, ok := h.hostWriter(w)
+	if !ok {
+		return
+	}
+	h.auditOldHost(r, hw, mac)
+
+	if err := hw.DeleteHost(r.Context(), mac); err != nil {
+		h.writeHostError(w, err)
+		return
+	}
+
+	h.logger.Info("Deleted host", "mac", mac.String())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// putHostOptions replaces the options of a host.
+func (h *handler) putHostOptions(w http.ResponseWriter, r *http.Request) {
+	mac, ok := h.pathMAC(w, r)
+	if !ok {
+		return
+	}
+	hw, ok := h.hostWriter(w)
+	if !ok {
+		return
+	}
+	h.auditOldHost(r, hw, mac)
+
+	var in []hostOptionJSON
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	opts := hostOptions(in)
+	if opts == nil {
+		opts = []backend.HostOption{}
+	}
+	if err := hw.SetOptions(r.Context(), mac, opts); err != nil {
+		h.writeHostError(w, err)
+		return
+	}
+	h.writeStoredHost(w, r, hw, mac, http.StatusOK)
+}
+
+// writeStoredHost logs a write to the host of mac and answers with the host
+// as the backend now has it.
+func (h *handler) writeStoredHost(
+	w http.ResponseWriter,
+	r *http.Request,
+	hw backend.BackendHostWriter,
+	mac net.HardwareAddr,
+	status int,
+) {
+	host, err := hw.GetHost(r.Context(), mac)
+	if err != nil {
+		h.writeHostError(w, err)
+		return
+	}
+
+	h.logger.Info("Wrote host", "mac", mac.String(), "method", r.Method)
+	h.writeJSON(w, status, toHostJSON(host))
+}
+
+// auditOldHost records the stored host of mac as the value the request r
+// replaces.
+func (h *handler) auditOldHost(r *http.Request, hw backend.BackendHostWriter, mac net.HardwareAddr) {
+	if host, err := hw.GetHost(r.Context(), mac); err == nil {
+		audit.SetOld(r.Context(), toHostJSON(host))
+	}
+}
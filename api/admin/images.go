@@ -0,0 +1,106 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/metal3-community/metal-boot/internal/imagecatalog"
+)
+
+var errImagesUnavailable = errors.New("image catalog is not available")
+
+// requireImages wraps fn so that it answers 404 when no Catalog is set.
+func (h *handler) requireImages(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.images == nil {
+			h.writeError(w, http.StatusNotFound, errImagesUnavailable)
+			return
+		}
+		fn(w, r)
+	}
+}
+
+// writeImageError maps Catalog errors to HTTP status codes.
+func (h *handler) writeImageError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, imagecatalog.ErrNotFound):
+		h.writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, imagecatalog.ErrInvalid):
+		h.writeError(w, http.StatusBadRequest, err)
+	case errors.Is(err, imagecatalog.ErrExists):
+		h.writeError(w, http.StatusConflict, err)
+	default:
+		h.logger.Error("Failed to update image catalog", "error", err)
+		h.writeError(w, http.StatusInternalServerError, err)
+	}
+}
+
+// listImages returns every image in the catalog.
+func (h *handler) listImages(w http.ResponseWriter, _ *http.Request) {
+	h.writeJSON(w, http.StatusOK, h.images.List())
+}
+
+// getImage returns one image.
+func (h *handler) getImage(w http.ResponseWriter, r *http.Request) {
+	img, err := h.images.Get(r.PathValue("name"))
+	if err != nil {
+		h.writeImageError(w, err)
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, img)
+}
+
+// createImage adds an image whose name is not taken yet.
+func (h *handler) createImage(w http.ResponseWriter, r *http.Request) {
+	var img imagecatalog.Image
+	if err := json.NewDecoder(r.Body).Decode(&img); err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	img, err := h.images.Create(img)
+	if err != nil {
+		h.writeImageError(w, err)
+		return
+	}
+
+	h.logger.Info("Created image", "image", img.Name, "kind", img.Kind)
+	h.writeJSON(w, http.StatusCreated, img)
+}
+
+// putImage creates or replaces the image named in the path.
+func (h *handler) putImage(w http.ResponseWriter, r *http.Request) {
+	var img imagecatalog.Image
+	if err := json.NewDecoder(r.Body).Decode(&img); err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	img.Name = r.PathValue("name")
+
+	img, created, err := h.images.Put(img)
+	if err != nil {
+		h.writeImageError(w, err)
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	h.logger.Info("Updated image", "image", img.Name, "kind", img.Kind, "created", created)
+	h.writeJSON(w, status, img)
+}
+
+// deleteImage removes an image from the catalog.
+func (h *handler) deleteImage(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if err := h.images.Delete(name); err != nil {
+		h.writeImageError(w, err)
+		return
+	}
+
+	h.logger.Info("Deleted image", "image", name)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,452 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/metal3-community/metal-boot/internal/audit"
+	"github.com/metal3-community/metal-boot/internal/efivars"
+	"github.com/metal3-community/metal-boot/internal/hoststate"
+	"github.com/metal3-community/uefi-firmware-manager/edk2"
+	"github.com/metal3-community/uefi-firmware-manager/varstore"
+)
+
+// Pagination of the inventory lists.
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+var (
+	errPowerUnavailable = errors.New("power management is not available")
+	errNoFirmware       = errors.New("host has no firmware varstore")
+)
+
+// page is one page of a list, with the total number of items matching the
+// filters of the request.
+type page[T any] struct {
+	Items  []T `json:"items"`
+	Total  int `json:"total"`
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+}
+
+// paginate returns the page of items selected by the limit and offset query
+// parameters of r.
+func paginate[T any](r *http.Request, items []T) (page[T], error) {
+	limit, offset := defaultPageLimit, 0
+	q := r.URL.Query()
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageLimit {
+			return page[T]{}, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+		}
+		limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return page[T]{}, errors.New("offset must be a non-negative integer")
+		}
+		offset = n
+	}
+
+	start := min(offset, len(items))
+	end := min(start+limit, len(items))
+	out := page[T]{Items: items[start:end], Total: len(items), Limit: limit, Offset: offset}
+	if out.Items == nil {
+		out.Items = []T{}
+	}
+
+	return out, nil
+}
+
+// machine is the inventory entry of a host, combining what the backend and
+// the host state store know about it.
+type machine struct {
+	MAC      string          `json:"mac"`
+	State    hoststate.State `json:"state,omitempty"`
+	IP       string          `json:"ip,omitempty"`
+	Hostname string          `json:"hostname,omitempty"`
+	// Netboot reports whether the host is offered netboot: the backend
+	// allows it and it was not disabled for the host.
+	Netboot         bool      `json:"netboot"`
+	NetbootDisabled bool      `json:"netbootDisabled,omitempty"`
+	BootAttempts    int       `json:"bootAttempts,omitempty"`
+	LastDiscover    time.Time `json:"lastDiscover"`
+	PhonedHomeAt    time.Time `json:"phonedHomeAt"`
+	// Power and Firmware are only filled in for a single machine, as they
+	// take a request to the power device and a read of the varstore.
+	Power    string           `json:"power,omitempty"`
+	Firmware *firmwareSummary `json:"firmware,omitempty"`
+}
+
+// lease is the inventory entry of the address the backend hands a host.
+type lease struct {
+	MAC       string     `json:"mac"`
+	IP        string     `json:"ip"`
+	Hostname  string     `json:"hostname,omitempty"`
+	LeaseTime uint32     `json:"leaseTime"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// firmwareSummary summarizes the UEFI variables of a host.
+type firmwareSummary struct {
+	Variables  int  `json:"variables"`
+	SecureBoot bool `json:"secureBoot"`
+	// BootOrder holds the titles of the boot options, in the order they are
+	// tried.
+	BootOrder []string `json:"bootOrder"`
+}
+
+// leaseExpirer is implemented by backends that know when a lease expires.
+type leaseExpirer interface {
+	LeaseExpiry(mac net.HardwareAddr) (time.Time, bool)
+}
+
+// backendKeys returns the MACs the backend has records of, by their host
+// state key.
+func (h *handler) backendKeys(ctx context.Context) (map[string]net.HardwareAddr, error) {
+	out := map[string]net.HardwareAddr{}
+	if h.backend == nil {
+		return out, nil
+	}
+	keys, err := h.backend.GetKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, mac := range keys {
+		out[hoststate.Key(mac)] = mac
+	}
+
+	return out, nil
+}
+
+// describeMachine returns the inventory entry of mac without its power state
+// and firmware. The backend is only asked about the MACs it has records of,
+// as it may assign an address to any other it is asked about.
+func (h *handler) describeMachine(
+	ctx context.Context,
+	mac net.HardwareAddr,
+	inBackend bool,
+) machine {
+	host, _ := h.hosts.Get(mac)
+	m := machine{
+		MAC:             mac.String(),
+		State:           host.State,
+		NetbootDisabled: host.NetbootDisabled,
+		BootAttempts:    host.BootAttempts,
+		LastDiscover:    host.LastDiscover,
+		PhonedHomeAt:    host.PhonedHomeAt,
+	}
+	if !inBackend {
+		return m
+	}
+
+	d, n, err := h.backend.GetByMac(ctx, mac)
+	if err != nil {
+		return m
+	}
+	if d != nil {
+		if d.IPAddress.IsValid() {
+			m.IP = d.IPAddress.String()
+		}
+		m.Hostname = d.Hostname
+	}
+	m.Netboot = n != nil && n.AllowNetboot && !host.NetbootDisabled
+
+	return m
+}
+
+// matches reports whether m passes the state, netboot and q filters of r.
+func (m machine) matches(r *http.Request) (bool, error) {
+	q := r.URL.Query()
+	if v := q.Get("state"); v != "" && string(m.State) != v {
+		return false, nil
+	}
+	if v := q.Get("netboot"); v != "" {
+		want, err := strconv.ParseBool(v)
+		if err != nil {
+			return false, fmt.Errorf("netboot must be true or false: %w", err)
+		}
+		if m.Netboot != want {
+			return false, nil
+		}
+	}
+
+	return matchesQuery(q.Get("q"), m.MAC, m.IP, m.Hostname), nil
+}
+
+// matchesQuery reports whether any of fields contains query, ignoring case.
+// An empty query matches everything.
+func matchesQuery(query string, fields ...string) bool {
+	if query == "" {
+		return true
+	}
+	query = strings.ToLower(query)
+	for _, f := range fields {
+		if strings.Contains(strings.ToLower(f), query) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// listMachines returns a page of the machines that pass the filters of the
+// request.
+func (h *handler) listMachines(w http.ResponseWriter, r *http.Request) {
+	known, err := h.backendKeys(r.Context())
+	if err != nil {
+		h.logger.Error("Failed to list machines", "error", err)
+		h.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	macs := maps.Clone(known)
+	for _, host := range h.hosts.List() {
+		if mac, err := net.ParseMAC(host.MAC); err == nil {
+			macs[host.MAC] = mac
+		}
+	}
+
+	var out []machine
+	for _, key := range slices.Sorted(maps.Keys(macs)) {
+		_, inBackend := known[key]
+		m := h.describeMachine(r.Context(), macs[key], inBackend)
+		ok, err := m.matches(r)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if ok {
+			out = append(out, m)
+		}
+	}
+
+	p, err := paginate(r, out)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, p)
+}
+
+// getMachine returns one machine with its power state and firmware summary.
+// Either is left out when it cannot be read.
+func (h *handler) getMachine(w http.ResponseWriter, r *http.Request) {
+	mac, ok := h.pathMAC(w, r)
+	if !ok {
+		return
+	}
+
+	m, err := h.machine(r.Context(), mac)
+	if err != nil {
+		h.logger.Error("Failed to describe machine", "mac", mac.String(), "error", err)
+		h.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if state, err := h.powerState(r.Context(), mac); err == nil {
+		m.Power = state
+	}
+	if fw, err := h.firmwareSummary(mac); err == nil {
+		m.Firmware = &fw
+	}
+
+	h.writeJSON(w, http.StatusOK, m)
+}
+
+// machine returns the inventory entry of mac without its power state and
+// firmware.
+func (h *handler) machine(ctx context.Context, mac net.HardwareAddr) (machine, error) {
+	known, err := h.backendKeys(ctx)
+	if err != nil {
+		return machine{}, err
+	}
+	_, inBackend := known[hoststate.Key(mac)]
+
+	return h.describeMachine(ctx, mac, inBackend), nil
+}
+
+// putNetboot enables or disables netboot for a host.
+func (h *handler) putNetboot(w http.ResponseWriter, r *http.Request) {
+	mac, ok := h.pathMAC(w, r)
+	if !ok {
+		return
+	}
+
+	var in struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil || in.Enabled == nil {
+		h.writeError(w, http.StatusBadRequest, errors.New(`body must be {"enabled": true|false}`))
+		return
+	}
+	if old, err := h.hosts.Get(mac); err == nil {
+		audit.SetOld(r.Context(), map[string]bool{"enabled": !old.NetbootDisabled})
+	}
+
+	if err := h.hosts.UpdateAs(mac, actor(r), func(host *hoststate.Host) {
+		host.NetbootDisabled = !*in.Enabled
+	}); err != nil {
+		h.logger.Error("Failed to store netboot setting", "mac", mac.String(), "error", err)
+		h.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.logger.Info("Updated netboot", "mac", mac.String(), "enabled", *in.Enabled)
+	m, err := h.machine(r.Context(), mac)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, m)
+}
+
+// getPower returns the power state of a host.
+func (h *handler) getPower(w http.ResponseWriter, r *http.Request) {
+	mac, ok := h.pathMAC(w, r)
+	if !ok {
+		return
+	}
+
+	state, err := h.powerState(r.Context(), mac)
+	switch {
+	case errors.Is(err, errPowerUnavailable):
+		h.writeError(w, http.StatusNotFound, err)
+	case err != nil:
+		h.logger.Error("Failed to read power state", "mac", mac.String(), "error", err)
+		h.writeError(w, http.StatusBadGateway, err)
+	default:
+		h.writeJSON(w, http.StatusOK, map[string]string{"state": state})
+	}
+}
+
+func (h *handler) powerState(ctx context.Context, mac net.HardwareAddr) (string, error) {
+	if h.power == nil {
+		return "", errPowerUnavailable
+	}
+	state, err := h.power.GetPower(ctx, mac)
+	if err != nil {
+		return "", err
+	}
+
+	return state.String(), nil
+}
+
+// getFirmware returns the summary of the UEFI variables of a host.
+func (h *handler) getFirmware(w http.ResponseWriter, r *http.Request) {
+	mac, ok := h.pathMAC(w, r)
+	if !ok {
+		return
+	}
+
+	fw, err := h.firmwareSummary(mac)
+	switch {
+	case errors.Is(err, errNoFirmware):
+		h.writeError(w, http.StatusNotFound, err)
+	case err != nil:
+		h.logger.Error("Failed to read firmware variables", "mac", mac.String(), "error", err)
+		h.writeError(w, http.StatusInternalServerError, err)
+	default:
+		h.writeJSON(w, http.StatusOK, fw)
+	}
+}
+
+// firmwareSummary reads the varstore of the UEFI image mac boots from its
+// directory below the TFTP root.
+func (h *handler) firmwareSummary(mac net.HardwareAddr) (firmwareSummary, error) {
+	dir := filepath.Join(h.config.Tftp.RootDirectory, strings.ReplaceAll(mac.String(), ":", "-"))
+	image, err := os.ReadFile(filepath.Join(dir, edk2.FirmwareFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return firmwareSummary{}, fmt.Errorf("%w: %s", errNoFirmware, mac)
+	}
+	if err != nil {
+		return firmwareSummary{}, err
+	}
+	vs, err := varstore.New(image)
+	if err != nil {
+		return firmwareSummary{}, err
+	}
+	vars, err := vs.GetVarList()
+	if err != nil {
+		return firmwareSummary{}, err
+	}
+
+	decoded := efivars.DecodeAll(vars)
+	byName := make(map[string]efivars.Variable, len(decoded))
+	for _, v := range decoded {
+		byName[v.Name] = v
+	}
+	fw := firmwareSummary{Variables: len(decoded), BootOrder: []string{}}
+	fw.SecureBoot, _ = byName["SecureBoot"].Value.(bool)
+	order, _ := byName["BootOrder"].Value.([]string)
+	for _, name := range order {
+		title := name
+		if opt, ok := byName[name].Value.(efivars.LoadOption); ok && opt.Title != "" {
+			title = opt.Title
+		}
+		fw.BootOrder = append(fw.BootOrder, title)
+	}
+
+	return fw, nil
+}
+
+// listLeases returns a page of the addresses the backend hands out that pass
+// the q filter of the request.
+func (h *handler) listLeases(w http.ResponseWriter, r *http.Request) {
+	if h.backend == nil {
+		h.writeJSON(w, http.StatusOK, page[lease]{Items: []lease{}, Limit: defaultPageLimit})
+		return
+	}
+	keys, err := h.backend.GetKeys(r.Context())
+	if err != nil {
+		h.logger.Error("Failed to list leases", "error", err)
+		h.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	slices.SortFunc(keys, func(a, b net.HardwareAddr) int {
+		return strings.Compare(a.String(), b.String())
+	})
+	expirer, _ := h.backend.(leaseExpirer)
+
+	query := r.URL.Query().Get("q")
+	var out []lease
+	for _, mac := range keys {
+		d, _, err := h.backend.GetByMac(r.Context(), mac)
+		if err != nil || d == nil || !d.IPAddress.IsValid() {
+			continue
+		}
+		l := lease{
+			MAC:       mac.String(),
+			IP:        d.IPAddress.String(),
+			Hostname:  d.Hostname,
+			LeaseTime: d.LeaseTime,
+		}
+		if expirer != nil {
+			if t, ok := expirer.LeaseExpiry(mac); ok {
+				l.ExpiresAt = &t
+			}
+		}
+		if matchesQuery(query, l.MAC, l.IP, l.Hostname) {
+			out = append(out, l)
+		}
+	}
+
+	p, err := paginate(r, out)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, p)
+}
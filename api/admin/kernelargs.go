@@ -0,0 +1,81 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/metal3-community/metal-boot/internal/hoststate"
+)
+
+// getKernelArgs returns the kernel arg overrides of a host.
+func (h *handler) getKernelArgs(w http.ResponseWriter, r *http.Request) {
+	mac, ok := h.pathMAC(w, r)
+	if !ok {
+		return
+	}
+
+	host, _ := h.hosts.Get(mac)
+	h.writeJSON(w, http.StatusOK, host.KernelArgs)
+}
+
+// putKernelArgs replaces the kernel arg overrides of a host.
+func (h *handler) putKernelArgs(w http.ResponseWriter, r *http.Request) {
+	mac, ok := h.pathMAC(w, r)
+	if !ok {
+		return
+	}
+
+	var args hoststate.KernelArgs
+	if err := json.NewDecoder(r.Body).Decode(&args); err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := validateKernelArgs(args); err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.hosts.UpdateAs(mac, actor(r), func(host *hoststate.Host) {
+		host.KernelArgs = args
+	}); err != nil {
+		h.logger.Error("Failed to store kernel args", "mac", mac.String(), "error", err)
+		h.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.logger.Info("Updated kernel args", "mac", mac.String(), "add", args.Add, "remove", args.Remove)
+	h.writeJSON(w, http.StatusOK, args)
+}
+
+// deleteKernelArgs clears the kernel arg overrides of a host.
+func (h *handler) deleteKernelArgs(w http.ResponseWriter, r *http.Request) {
+	mac, ok := h.pathMAC(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.hosts.UpdateAs(mac, actor(r), func(host *hoststate.Host) {
+		host.KernelArgs = hoststate.KernelArgs{}
+	}); err != nil {
+		h.logger.Error("Failed to clear kernel args", "mac", mac.String(), "error", err)
+		h.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validateKernelArgs rejects arguments that would corrupt the kernel line.
+func validateKernelArgs(args hoststate.KernelArgs) error {
+	for _, list := range [][]string{args.Add, args.Remove} {
+		for _, arg := range list {
+			if arg == "" || strings.ContainsAny(arg, " \t\r\n") {
+				return fmt.Errorf("invalid kernel argument %q", arg)
+			}
+		}
+	}
+
+	return nil
+}
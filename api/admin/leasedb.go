@@ -0,0 +1,70 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/metal3-community/metal-boot/internal/leasedb"
+	"github.com/metal3-community/metal-boot/internal/util"
+)
+
+var errLeaseDBUnavailable = errors.New("the lease database is not enabled")
+
+// listLeaseDB returns the leases of the lease database ordered by MAC
+// address, optionally only those in the state of the state query parameter,
+// those in conflict with conflict=true, or those matching q.
+func (h *handler) listLeaseDB(w http.ResponseWriter, r *http.Request) {
+	if h.leases == nil {
+		h.writeError(w, http.StatusNotFound, errLeaseDBUnavailable)
+		return
+	}
+
+	q := r.URL.Query()
+	state := leasedb.State(q.Get("state"))
+	switch state {
+	case "", leasedb.StateOffered, leasedb.StateBound, leasedb.StateReleased,
+		leasedb.StateDeclined, leasedb.StateExpired:
+	default:
+		h.writeError(w, http.StatusBadRequest, fmt.Errorf("unknown lease state %q", state))
+		return
+	}
+	conflicts := q.Get("conflict") == "true"
+	query := q.Get("q")
+
+	out := []leasedb.Lease{}
+	for _, l := range h.leases.List() {
+		if (state == "" || l.State == state) &&
+			(!conflicts || l.Conflict != "") &&
+			matchesQuery(query, l.MAC, l.IP.String(), l.Hostname) {
+			out = append(out, l)
+		}
+	}
+
+	p, err := paginate(r, out)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, p)
+}
+
+// getLeaseDB returns the lease of one client.
+func (h *handler) getLeaseDB(w http.ResponseWriter, r *http.Request) {
+	if h.leases == nil {
+		h.writeError(w, http.StatusNotFound, errLeaseDBUnavailable)
+		return
+	}
+	mac, err := util.ParseMAC(r.PathValue("mac"))
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	l, ok := h.leases.Get(mac)
+	if !ok {
+		h.writeError(w, http.StatusNotFound, fmt.Errorf("no lease for %s", mac))
+		return
+	}
+	h.writeJSON(w, http.StatusOK, l)
+}
@@ -0,0 +1,168 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"regexp"
+
+	"github.com/metal3-community/metal-boot/api/ipxe/script"
+	"github.com/metal3-community/metal-boot/internal/hoststate"
+)
+
+// metadataKey matches the keys that can be used as iPXE setting names.
+var metadataKey = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// maxMetadataValue bounds the length of a metadata value.
+const maxMetadataValue = 1024
+
+// getMetadata returns the metadata of a host.
+func (h *handler) getMetadata(w http.ResponseWriter, r *http.Request) {
+	mac, ok := h.pathMAC(w, r)
+	if !ok {
+		return
+	}
+
+	host, _ := h.hosts.Get(mac)
+	h.writeJSON(w, http.StatusOK, metadataOrEmpty(host.Metadata))
+}
+
+// putMetadata replaces the metadata of a host.
+func (h *handler) putMetadata(w http.ResponseWriter, r *http.Request) {
+	mac, ok := h.pathMAC(w, r)
+	if !ok {
+		return
+	}
+
+	var metadata map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&metadata); err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	for k, v := range metadata {
+		if err := validateMetadata(k, v); err != nil {
+			h.writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	if err := h.hosts.UpdateAs(mac, actor(r), func(host *hoststate.Host) {
+		host.Metadata = metadata
+	}); err != nil {
+		h.logger.Error("Failed to store metadata", "mac", mac.String(), "error", err)
+		h.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.logger.Info("Updated metadata", "mac", mac.String(), "keys", len(metadata))
+	h.writeJSON(w, http.StatusOK, metadataOrEmpty(metadata))
+}
+
+// deleteMetadata clears the metadata of a host.
+func (h *handler) deleteMetadata(w http.ResponseWriter, r *http.Request) {
+	mac, ok := h.pathMAC(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.hosts.UpdateAs(mac, actor(r), func(host *hoststate.Host) {
+		host.Metadata = nil
+	}); err != nil {
+		h.logger.Error("Failed to clear metadata", "mac", mac.String(), "error", err)
+		h.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// putMetadataKey sets one metadata key of a host. The body is the value as a
+// JSON string.
+func (h *handler) putMetadataKey(w http.ResponseWriter, r *http.Request) {
+	mac, ok := h.pathMAC(w, r)
+	if !ok {
+		return
+	}
+
+	key := r.PathValue("key")
+	var value string
+	if err := json.NewDecoder(r.Body).Decode(&value); err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := validateMetadata(key, value); err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var metadata map[string]string
+	if err := h.hosts.UpdateAs(mac, actor(r), func(host *hoststate.Host) {
+		// Copies of the record returned by Get share the map, so replace it.
+		metadata = maps.Clone(host.Metadata)
+		if metadata == nil {
+			metadata = make(map[string]string)
+		}
+		metadata[key] = value
+		host.Metadata = metadata
+	}); err != nil {
+		h.logger.Error("Failed to store metadata", "mac", mac.String(), "key", key, "error", err)
+		h.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.logger.Info("Updated metadata", "mac", mac.String(), "key", key)
+	h.writeJSON(w, http.StatusOK, metadata)
+}
+
+// deleteMetadataKey removes one metadata key of a host.
+func (h *handler) deleteMetadataKey(w http.ResponseWriter, r *http.Request) {
+	mac, ok := h.pathMAC(w, r)
+	if !ok {
+		return
+	}
+
+	key := r.PathValue("key")
+	if err := h.hosts.UpdateAs(mac, actor(r), func(host *hoststate.Host) {
+		if _, ok := host.Metadata[key]; !ok {
+			return
+		}
+		metadata := maps.Clone(host.Metadata)
+		delete(metadata, key)
+		if len(metadata) == 0 {
+			metadata = nil
+		}
+		host.Metadata = metadata
+	}); err != nil {
+		h.logger.Error("Failed to remove metadata", "mac", mac.String(), "key", key, "error", err)
+		h.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validateMetadata rejects keys that are not usable as iPXE setting names and
+// values that would break or extend the iPXE command they are rendered in.
+func validateMetadata(key, value string) error {
+	if !metadataKey.MatchString(key) {
+		return fmt.Errorf("invalid metadata key %q", key)
+	}
+	if len(value) > maxMetadataValue {
+		return fmt.Errorf("metadata value of %q is longer than %d bytes", key, maxMetadataValue)
+	}
+	if err := script.ValidateSetting(value); err != nil {
+		return fmt.Errorf("metadata value of %q %w", key, err)
+	}
+
+	return nil
+}
+
+// metadataOrEmpty returns m, or an empty map so that it encodes as {}.
+func metadataOrEmpty(m map[string]string) map[string]string {
+	if m == nil {
+		return map[string]string{}
+	}
+
+	return m
+}
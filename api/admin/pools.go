@@ -0,0 +1,31 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/metal3-community/metal-boot/internal/backend/dnsmasq"
+)
+
+var errPoolsUnsupported = errors.New("backend does not assign addresses from pools")
+
+// poolBackend is implemented by backends that assign addresses from pools.
+type poolBackend interface {
+	PoolsUsage() []dnsmasq.PoolUsage
+}
+
+// listPools returns the automatic assignment pools of the backend with their
+// usage.
+func (h *handler) listPools(w http.ResponseWriter, _ *http.Request) {
+	pb, ok := h.backend.(poolBackend)
+	if !ok {
+		h.writeError(w, http.StatusNotFound, errPoolsUnsupported)
+		return
+	}
+
+	out := pb.PoolsUsage()
+	if out == nil {
+		out = []dnsmasq.PoolUsage{}
+	}
+	h.writeJSON(w, http.StatusOK, out)
+}
@@ -0,0 +1,40 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// readOnlyPath toggles read-only mode and stays writable while it is on.
+const readOnlyPath = "/api/v1/read-only"
+
+var errReadOnlyUnavailable = errors.New("read-only mode is not available")
+
+// readOnlyState is the body of the read-only mode endpoint.
+type readOnlyState struct {
+	Enabled bool `json:"enabled"`
+}
+
+// getReadOnly reports whether read-only mode is on.
+func (h *handler) getReadOnly(w http.ResponseWriter, _ *http.Request) {
+	h.writeJSON(w, http.StatusOK, readOnlyState{Enabled: h.readOnly.Enabled()})
+}
+
+// putReadOnly turns read-only mode on or off until the next restart.
+func (h *handler) putReadOnly(w http.ResponseWriter, r *http.Request) {
+	if h.readOnly == nil {
+		h.writeError(w, http.StatusNotFound, errReadOnlyUnavailable)
+		return
+	}
+
+	var state readOnlyState
+	if err := json.NewDecoder(r.Body).Decode(&state); err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	h.readOnly.Set(state.Enabled)
+
+	h.logger.Warn("Changed read-only mode", "enabled", state.Enabled)
+	h.writeJSON(w, http.StatusOK, state)
+}
@@ -0,0 +1,213 @@
+package admin
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/metal3-community/metal-boot/api/ipxe/script"
+	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+)
+
+// renderedResponse is the body returned by GET /api/v1/systems/{mac}/rendered.
+type renderedResponse struct {
+	MAC        string        `json:"mac"`
+	IPXE       renderedFile  `json:"ipxe"`
+	Grub       *renderedFile `json:"grub,omitempty"`
+	KernelArgs []string      `json:"kernelArgs"`
+	DHCP       *renderedDHCP `json:"dhcp,omitempty"`
+	DHCPError  string        `json:"dhcpError,omitempty"`
+}
+
+type renderedFile struct {
+	File    string `json:"file"`
+	Profile string `json:"profile,omitempty"`
+	Content string `json:"content"`
+}
+
+// renderedDHCP is the backend record the DHCP server answers a node with.
+type renderedDHCP struct {
+	IPAddress     string           `json:"ipAddress,omitempty"`
+	AllowNetboot  bool             `json:"allowNetboot"`
+	IPXEScriptURL string           `json:"ipxeScriptUrl,omitempty"`
+	Disabled      bool             `json:"disabled,omitempty"`
+	Options       []renderedOption `json:"options"`
+}
+
+type renderedOption struct {
+	Code  uint8  `json:"code"`
+	Name  string `json:"name,omitempty"`
+	Value string `json:"value"`
+}
+
+// getRendered returns everything that would currently be served to a node:
+// its iPXE script, grub config, kernel args and DHCP options.
+func (h *handler) getRendered(w http.ResponseWriter, r *http.Request) {
+	mac, ok := h.pathMAC(w, r)
+	if !ok {
+		return
+	}
+
+	ipxe, err := script.Render(h.config, h.hosts, mac)
+	if err != nil {
+		h.logger.Error("Failed to render iPXE script", "mac", mac.String(), "error", err)
+		h.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	resp := renderedResponse{
+		MAC: mac.String(),
+		IPXE: renderedFile{
+			File:    ipxe.File,
+			Profile: ipxe.Profile,
+			Content: string(ipxe.Script),
+		},
+		KernelArgs: ipxe.KernelArgs(),
+	}
+
+	grub, err := h.renderGrub(mac)
+	if err != nil {
+		h.logger.Error("Failed to read grub config", "mac", mac.String(), "error", err)
+		h.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	resp.Grub = grub
+
+	if h.backend != nil {
+		if d, n, err := h.backend.GetByMac(r.Context(), mac); err != nil {
+			resp.DHCPError = err.Error()
+		} else {
+			resp.DHCP = renderDHCP(d, n)
+		}
+	}
+
+	h.writeJSON(w, http.StatusOK, resp)
+}
+
+// getRenderedIPXE returns the iPXE script that would be served to a node as
+// plain text.
+func (h *handler) getRenderedIPXE(w http.ResponseWriter, r *http.Request) {
+	mac, ok := h.pathMAC(w, r)
+	if !ok {
+		return
+	}
+
+	ipxe, err := script.Render(h.config, h.hosts, mac)
+	if err != nil {
+		h.logger.Error("Failed to render iPXE script", "mac", mac.String(), "error", err)
+		h.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("X-Metal-Boot-File", ipxe.File)
+	if _, err := w.Write(ipxe.Script); err != nil {
+		h.logger.Error("Failed to write iPXE script", "mac", mac.String(), "error", err)
+	}
+}
+
+// renderGrub returns the per-node grub config Ironic wrote to the TFTP root,
+// or nil if there is none.
+func (h *handler) renderGrub(mac net.HardwareAddr) (*renderedFile, error) {
+	root, err := os.OpenRoot(h.config.Tftp.RootDirectory)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open TFTP root: %w", err)
+	}
+	defer root.Close()
+
+	macStr := strings.ToLower(mac.String())
+	candidates := []string{
+		"grub.cfg-01-" + strings.ReplaceAll(macStr, ":", "-"),
+		macStr + ".conf",
+		filepath.Join("grub", "grub.cfg-01-"+strings.ReplaceAll(macStr, ":", "-")),
+	}
+	for _, name := range candidates {
+		b, err := root.ReadFile(name)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		return &renderedFile{File: name, Content: string(b)}, nil
+	}
+
+	return nil, nil
+}
+
+// renderDHCP lists the options the DHCP server derives from a backend record,
+// in the order it sets them.
+func renderDHCP(d *data.DHCP, n *data.Netboot) *renderedDHCP {
+	out := &renderedDHCP{
+		Disabled: d.Disabled,
+		Options:  []renderedOption{},
+	}
+	if d.IPAddress.IsValid() {
+		out.IPAddress = d.IPAddress.String()
+	}
+	if n != nil {
+		out.AllowNetboot = n.AllowNetboot
+		if n.IPXEScriptURL != nil {
+			out.IPXEScriptURL = n.IPXEScriptURL.String()
+		}
+	}
+
+	add := func(code uint8, name, value string) {
+		out.Options = append(out.Options, renderedOption{Code: code, Name: name, Value: value})
+	}
+	if len(d.SubnetMask) > 0 {
+		add(1, "netmask", net.IP(d.SubnetMask).String())
+	}
+	if d.DefaultGateway.IsValid() {
+		add(3, "router", d.DefaultGateway.String())
+	}
+	if len(d.NameServers) > 0 {
+		add(6, "dns-server", joinIPs(d.NameServers))
+	}
+	if d.Hostname != "" {
+		add(12, "hostname", d.Hostname)
+	}
+	if d.DomainName != "" {
+		add(15, "domain-name", d.DomainName)
+	}
+	if d.BroadcastAddress.IsValid() {
+		add(28, "broadcast", d.BroadcastAddress.String())
+	}
+	if len(d.NTPServers) > 0 {
+		add(42, "ntp-server", joinIPs(d.NTPServers))
+	}
+	if d.LeaseTime != 0 {
+		add(51, "lease-time", fmt.Sprint(d.LeaseTime))
+	}
+	if d.ClientID != "" {
+		add(61, "client-id", d.ClientID)
+	}
+	if len(d.DomainSearch) > 0 {
+		add(119, "domain-search", strings.Join(d.DomainSearch, ","))
+	}
+	for _, o := range d.Options {
+		add(o.Code, "", hex.EncodeToString(o.Value))
+	}
+
+	return out
+}
+
+func joinIPs(ips []net.IP) string {
+	s := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		if addr, ok := netip.AddrFromSlice(ip); ok {
+			s = append(s, addr.Unmap().String())
+		}
+	}
+
+	return strings.Join(s, ",")
+}
@@ -0,0 +1,93 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/metal3-community/metal-boot/internal/audit"
+	dnsmasqconfig "github.com/metal3-community/metal-boot/internal/backend/dnsmasq/config"
+)
+
+var errReservationsUnsupported = errors.New("backend does not support lease reservations")
+
+// reservationBackend is implemented by backends that can convert between
+// dynamic leases and static reservations.
+type reservationBackend interface {
+	PinLease(ctx context.Context, mac net.HardwareAddr) (dnsmasqconfig.HostEntry, error)
+	ReleaseReservation(ctx context.Context, mac net.HardwareAddr) error
+}
+
+// reservations returns the backend as a reservationBackend, writing a 404 if
+// it is not one.
+func (h *handler) reservations(w http.ResponseWriter) (reservationBackend, bool) {
+	rb, ok := h.backend.(reservationBackend)
+	if !ok {
+		h.writeError(w, http.StatusNotFound, errReservationsUnsupported)
+		return nil, false
+	}
+
+	return rb, true
+}
+
+// listReservations returns the host entries that have a fixed address.
+func (h *handler) listReservations(w http.ResponseWriter, _ *http.Request) {
+	out := []dnsmasqconfig.HostEntry{}
+	for _, entry := range h.dnsmasq.Hosts() {
+		if entry.IP != nil {
+			out = append(out, entry)
+		}
+	}
+
+	h.writeJSON(w, http.StatusOK, out)
+}
+
+// pinLease converts the current lease of a host into a static reservation.
+func (h *handler) pinLease(w http.ResponseWriter, r *http.Request) {
+	mac, ok := h.pathMAC(w, r)
+	if !ok {
+		return
+	}
+	rb, ok := h.reservations(w)
+	if !ok {
+		return
+	}
+
+	h.auditOldReservation(r, mac)
+	entry, err := rb.PinLease(r.Context(), mac)
+	if err != nil {
+		h.writeDnsmasqError(w, err)
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, entry)
+}
+
+// releaseReservation returns the reserved address of a host to the pool.
+func (h *handler) releaseReservation(w http.ResponseWriter, r *http.Request) {
+	mac, ok := h.pathMAC(w, r)
+	if !ok {
+		return
+	}
+	rb, ok := h.reservations(w)
+	if !ok {
+		return
+	}
+
+	h.auditOldReservation(r, mac)
+	if err := rb.ReleaseReservation(r.Context(), mac); err != nil {
+		h.writeDnsmasqError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// auditOldReservation records the host entry of mac as the value the request
+// r replaces.
+func (h *handler) auditOldReservation(r *http.Request, mac net.HardwareAddr) {
+	if entry, ok := h.dnsmasq.GetHost(mac); ok {
+		audit.SetOld(r.Context(), entry)
+	}
+}
@@ -0,0 +1,216 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/metal3-community/metal-boot/internal/canary"
+)
+
+var errRolloutsUnavailable = errors.New("rollouts are not available")
+
+// rolloutResponse is a rollout with the known hosts of its group, split by
+// whether they boot its canary images.
+type rolloutResponse struct {
+	canary.Rollout
+	CanaryHosts []string `json:"canaryHosts"`
+	StableHosts []string `json:"stableHosts"`
+}
+
+// percentRequest is the body of POST /api/v1/rollouts/{name}/percent.
+type percentRequest struct {
+	Percent int `json:"percent"`
+}
+
+// requireRollouts wraps fn so that it answers 404 when no rollout Store is
+// set, or no Catalog to hold the images of rollouts.
+func (h *handler) requireRollouts(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.rollouts == nil || h.images == nil {
+			h.writeError(w, http.StatusNotFound, errRolloutsUnavailable)
+			return
+		}
+		fn(w, r)
+	}
+}
+
+// writeRolloutError maps rollout Store and Catalog errors to HTTP status
+// codes.
+func (h *handler) writeRolloutError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, canary.ErrNotFound):
+		h.writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, canary.ErrInvalid):
+		h.writeError(w, http.StatusBadRequest, err)
+	default:
+		h.writeImageError(w, err)
+	}
+}
+
+// rolloutResponse returns r with the hosts of its group.
+func (h *handler) rolloutResponse(r canary.Rollout) rolloutResponse {
+	resp := rolloutResponse{Rollout: r, CanaryHosts: []string{}, StableHosts: []string{}}
+	if h.hosts == nil {
+		return resp
+	}
+	for _, host := range h.hosts.List() {
+		mac, err := net.ParseMAC(host.MAC)
+		if err != nil || !r.Includes(mac, host.Metadata) {
+			continue
+		}
+		if r.Selects(mac, host.Metadata) {
+			resp.CanaryHosts = append(resp.CanaryHosts, mac.String())
+		} else {
+			resp.StableHosts = append(resp.StableHosts, mac.String())
+		}
+	}
+
+	return resp
+}
+
+// checkRolloutImages reports an error when an image of r is not in the
+// catalog, so that a rollout cannot send hosts to a missing image.
+func (h *handler) checkRolloutImages(r canary.Rollout) error {
+	for stable, name := range r.Images {
+		for _, n := range []string{stable, name} {
+			if _, err := h.images.Get(n); err != nil {
+				return fmt.Errorf("%w: %w", canary.ErrInvalid, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// listRollouts returns every rollout.
+func (h *handler) listRollouts(w http.ResponseWriter, _ *http.Request) {
+	rollouts := h.rollouts.List()
+	out := make([]rolloutResponse, 0, len(rollouts))
+	for _, r := range rollouts {
+		out = append(out, h.rolloutResponse(r))
+	}
+
+	h.writeJSON(w, http.StatusOK, out)
+}
+
+// getRollout returns one rollout and the hosts of its group.
+func (h *handler) getRollout(w http.ResponseWriter, r *http.Request) {
+	rollout, err := h.rollouts.Get(r.PathValue("name"))
+	if err != nil {
+		h.writeRolloutError(w, err)
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, h.rolloutResponse(rollout))
+}
+
+// putRollout creates or replaces the rollout named in the path.
+func (h *handler) putRollout(w http.ResponseWriter, r *http.Request) {
+	var rollout canary.Rollout
+	if err := json.NewDecoder(r.Body).Decode(&rollout); err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	rollout.Name = r.PathValue("name")
+	if err := h.checkRolloutImages(rollout); err != nil {
+		h.writeRolloutError(w, err)
+		return
+	}
+
+	rollout, created, err := h.rollouts.Put(rollout)
+	if err != nil {
+		h.writeRolloutError(w, err)
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	h.logger.Info("Updated rollout", "rollout", rollout.Name, "percent", rollout.Percent,
+		"created", created)
+	h.writeJSON(w, status, h.rolloutResponse(rollout))
+}
+
+// setRolloutPercent changes the share of the group of a rollout that boots
+// its canary images.
+func (h *handler) setRolloutPercent(w http.ResponseWriter, r *http.Request) {
+	var req percentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	rollout, err := h.rollouts.SetPercent(r.PathValue("name"), req.Percent)
+	if err != nil {
+		h.writeRolloutError(w, err)
+		return
+	}
+
+	h.logger.Info("Changed rollout percentage", "rollout", rollout.Name, "percent", rollout.Percent)
+	h.writeJSON(w, http.StatusOK, h.rolloutResponse(rollout))
+}
+
+// promoteRollout makes the canary images of a rollout the stable images of
+// every host, by copying them over the stable catalog entries, and removes
+// the rollout.
+func (h *handler) promoteRollout(w http.ResponseWriter, r *http.Request) {
+	rollout, err := h.rollouts.Get(r.PathValue("name"))
+	if err != nil {
+		h.writeRolloutError(w, err)
+		return
+	}
+	if err := h.checkRolloutImages(rollout); err != nil {
+		h.writeRolloutError(w, err)
+		return
+	}
+
+	for stable, name := range rollout.Images {
+		img, err := h.images.Get(name)
+		if err != nil {
+			h.writeImageError(w, err)
+			return
+		}
+		img.Name = stable
+		if _, _, err := h.images.Put(img); err != nil {
+			h.writeImageError(w, err)
+			return
+		}
+	}
+	if err := h.rollouts.Delete(rollout.Name); err != nil {
+		h.writeRolloutError(w, err)
+		return
+	}
+
+	h.logger.Info("Promoted rollout", "rollout", rollout.Name, "images", rollout.Images)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// rollbackRollout sends every host of a rollout back to the stable images,
+// keeping the rollout so that it can be resumed.
+func (h *handler) rollbackRollout(w http.ResponseWriter, r *http.Request) {
+	rollout, err := h.rollouts.SetPercent(r.PathValue("name"), 0)
+	if err != nil {
+		h.writeRolloutError(w, err)
+		return
+	}
+
+	h.logger.Info("Rolled back rollout", "rollout", rollout.Name)
+	h.writeJSON(w, http.StatusOK, h.rolloutResponse(rollout))
+}
+
+// deleteRollout removes a rollout, sending its hosts back to the stable
+// images.
+func (h *handler) deleteRollout(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if err := h.rollouts.Delete(name); err != nil {
+		h.writeRolloutError(w, err)
+		return
+	}
+
+	h.logger.Info("Deleted rollout", "rollout", name)
+	w.WriteHeader(http.StatusNoContent)
+}
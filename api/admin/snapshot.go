@@ -0,0 +1,31 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var errSnapshotUnavailable = errors.New("snapshots are not available")
+
+// getSnapshot returns a snapshot of the in-memory state of the server, which
+// a standby imports at startup.
+func (h *handler) getSnapshot(w http.ResponseWriter, _ *http.Request) {
+	if h.snapshots == nil {
+		h.writeError(w, http.StatusNotFound, errSnapshotUnavailable)
+		return
+	}
+
+	s := h.snapshots.Export()
+	name := fmt.Sprintf("metal-boot-snapshot-%s.json", s.Created.Format("20060102T150405Z"))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	h.writeJSON(w, http.StatusOK, s)
+
+	h.logger.Debug("Exported snapshot",
+		"hosts", len(s.Hosts),
+		"leases", len(s.Leases),
+		"tasks", len(s.Tasks),
+		"took", time.Since(s.Created),
+	)
+}
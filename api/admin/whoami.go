@@ -0,0 +1,21 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/metal3-community/metal-boot/internal/adminauth"
+)
+
+var errAuthDisabled = errors.New("admin authentication is not enabled")
+
+// getWhoami returns the authenticated operator and its role.
+func (h *handler) getWhoami(w http.ResponseWriter, r *http.Request) {
+	principal := adminauth.FromContext(r.Context())
+	if principal == nil {
+		h.writeError(w, http.StatusNotFound, errAuthDisabled)
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, principal)
+}
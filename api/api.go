@@ -2,29 +2,52 @@ package api
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
+	"os"
 	"strings"
 	"time"
 
 	"github.com/metal3-community/metal-boot/internal/config"
+	"github.com/metal3-community/metal-boot/internal/cors"
+	"github.com/metal3-community/metal-boot/internal/logging"
+	"github.com/metal3-community/metal-boot/internal/tlscert"
 	sloghttp "github.com/samber/slog-http"
 	"github.com/sebest/xff"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
+func init() {
+	// The access log names the request id like every other record.
+	sloghttp.RequestIDKey = logging.KeyRequestID
+}
+
 // HandlerMapping is a map of routes to http.HandlerFuncs.
 type HandlerMapping map[string]http.Handler
 
 type RegistrationFunc = func(router *http.ServeMux)
 
+// listener is an address the server listens on besides the configured one.
+type listener struct {
+	addr string
+	// paths are the route prefixes served on addr, all routes if empty.
+	paths []string
+}
+
 // Api represents the HTTP API server with all its dependencies.
 type Api struct {
-	config     *config.Config
-	logger     *slog.Logger
-	httpServer *http.Server
-	handlers   HandlerMapping
+	config      *config.Config
+	logger      *slog.Logger
+	httpServers []*http.Server
+	handlers    HandlerMapping
+	certs       *tlscert.Store
+	cors        *cors.Policy
+	listeners   []listener
+	socket      string
+	socketMode  os.FileMode
 }
 
 // New creates a new Api instance with the given configuration.
@@ -44,17 +67,110 @@ func (a *Api) AddHandler(path string, handler http.Handler) {
 	}
 }
 
-// Start initializes all dependencies and starts the HTTP server.
+// UseTLS makes the server listen for HTTPS with certificates from certs.
+// A nil store keeps plain HTTP.
+func (a *Api) UseTLS(certs *tlscert.Store) {
+	a.certs = certs
+}
+
+// UseCORS answers cross-origin requests with policy. A nil policy sends no
+// CORS headers.
+func (a *Api) UseCORS(policy *cors.Policy) {
+	a.cors = policy
+}
+
+// AddListener makes the server listen on addr too. With no paths, addr
+// serves the same routes as the configured address. Otherwise it serves only
+// the routes registered under paths, which the configured address and the
+// listeners without paths then no longer serve.
+func (a *Api) AddListener(addr string, paths ...string) {
+	a.listeners = append(a.listeners, listener{addr: addr, paths: paths})
+}
+
+// UseUnixSocket makes the server listen on the unix socket path too, with
+// file mode mode, so that local clients need no TCP port. The socket serves
+// every route over plain HTTP. An empty path adds no socket.
+func (a *Api) UseUnixSocket(path string, mode os.FileMode) {
+	a.socket = path
+	a.socketMode = mode
+}
+
+// Start initializes all dependencies and starts the HTTP server. It blocks
+// until every listener is closed or one of them fails.
 func (a *Api) Start(registrations ...RegistrationFunc) error {
-	// Setup HTTP routes
-	mux := http.NewServeMux()
+	var dedicated []string
+	for _, l := range a.listeners {
+		dedicated = append(dedicated, l.paths...)
+	}
 
+	servers := []*http.Server{a.newServer(a.getAddress(), a.mux(dedicated, nil))}
+	for _, l := range a.listeners {
+		if len(l.paths) == 0 {
+			servers = append(servers, a.newServer(l.addr, a.mux(dedicated, nil)))
+		} else {
+			servers = append(servers, a.newServer(l.addr, a.mux(nil, l.paths)))
+		}
+	}
+
+	var unix net.Listener
+	if a.socket != "" {
+		var err error
+		if unix, err = listenUnix(a.socket, a.socketMode); err != nil {
+			return err
+		}
+		servers = append(servers, a.newServer(a.socket, a.mux(nil, nil)))
+	}
+	a.httpServers = servers
+
+	errs := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func() {
+			if unix != nil && srv.Addr == a.socket {
+				errs <- a.serveListener(srv, unix)
+				return
+			}
+			errs <- a.serve(srv)
+		}()
+	}
+	for range servers {
+		if err := <-errs; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// mux returns the routes registered under one of the only prefixes, or all
+// routes if only is empty, leaving out those under one of the exclude
+// prefixes.
+func (a *Api) mux(exclude, only []string) *http.ServeMux {
+	under := func(path string, prefixes []string) bool {
+		for _, p := range prefixes {
+			if strings.HasPrefix(path, p) {
+				return true
+			}
+		}
+		return false
+	}
+
+	mux := http.NewServeMux()
 	for path, handler := range a.handlers {
+		if under(path, exclude) || len(only) > 0 && !under(path, only) {
+			continue
+		}
 		mux.Handle(path, handler)
 	}
 
+	return mux
+}
+
+// newServer returns an HTTP server for mux on addr with the middleware of
+// the API.
+func (a *Api) newServer(addr string, mux *http.ServeMux) *http.Server {
 	// wrap the mux with an OpenTelemetry interceptor
-	httpHandler := otelhttp.NewHandler(mux, "ironic-http")
+	httpHandler := otelhttp.NewHandler(
+		logging.Middleware(a.cors.Middleware(mux)), "ironic-http")
 
 	trustedProxies := strings.Split(a.config.TrustedProxies, ",")
 	if len(trustedProxies) > 0 && trustedProxies[0] != "" {
@@ -87,19 +203,28 @@ func (a *Api) Start(registrations ...RegistrationFunc) error {
 	httpHandler = sloghttp.NewWithConfig(a.logger, config)(httpHandler)
 
 	// Create and configure HTTP server
-	a.httpServer = &http.Server{
-		Addr:    a.getAddress(),
+	return &http.Server{
+		Addr:    addr,
 		Handler: httpHandler,
 		// Add reasonable timeouts
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 30 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
+}
 
-	a.logger.Info("Starting HTTP server", "address", a.httpServer.Addr)
+// serve runs srv until it is shut down.
+func (a *Api) serve(srv *http.Server) error {
+	a.logger.Info("Starting HTTP server", "address", srv.Addr, "tls", a.certs != nil)
 
 	// Start server - this blocks
-	err := a.httpServer.ListenAndServe()
+	var err error
+	if a.certs != nil {
+		srv.TLSConfig = a.certs.TLSConfig()
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
 	if err != nil && err != http.ErrServerClosed {
 		a.logger.Error("HTTP server failed to start", "error", err)
 		return err
@@ -108,22 +233,61 @@ func (a *Api) Start(registrations ...RegistrationFunc) error {
 	return nil
 }
 
-// Shutdown gracefully shuts down the HTTP server.
-func (a *Api) Shutdown() error {
-	if a.httpServer != nil {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-
-		a.logger.Info("Shutting down HTTP server...")
-		err := a.httpServer.Shutdown(ctx)
-		if err != nil {
-			a.logger.Error("Failed to shutdown HTTP server gracefully", "error", err)
-			return err
+// serveListener runs srv on ln over plain HTTP until it is shut down.
+func (a *Api) serveListener(srv *http.Server, ln net.Listener) error {
+	a.logger.Info("Starting HTTP server", "address", ln.Addr().String(), "tls", false)
+
+	if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+		a.logger.Error("HTTP server failed to start", "error", err)
+		return err
+	}
+
+	return nil
+}
+
+// listenUnix listens on the unix socket path with file mode mode, replacing
+// the socket left behind by an earlier run.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
 		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on unix socket: %w", err)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("failed to set unix socket mode: %w", err)
+	}
+
+	return ln, nil
+}
 
-		a.httpServer = nil
-		a.logger.Info("HTTP server shutdown complete")
+// Shutdown gracefully shuts down the HTTP servers of all listeners.
+func (a *Api) Shutdown() error {
+	if a.httpServers == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	a.logger.Info("Shutting down HTTP server...")
+	var errs []error
+	for _, srv := range a.httpServers {
+		if err := srv.Shutdown(ctx); err != nil {
+			a.logger.Error("Failed to shutdown HTTP server gracefully",
+				"address", srv.Addr, "error", err)
+			errs = append(errs, err)
+		}
 	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	a.httpServers = nil
+	a.logger.Info("HTTP server shutdown complete")
 
 	return nil
 }
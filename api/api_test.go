@@ -0,0 +1,89 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/metal3-community/metal-boot/internal/config"
+)
+
+func TestListenerRoutes(t *testing.T) {
+	a := New(&config.Config{}, slog.New(slog.DiscardHandler))
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {})
+	a.AddHandler("/redfish/v1/", ok)
+	a.AddHandler("/healthcheck", ok)
+
+	tests := map[string]struct {
+		mux  *http.ServeMux
+		path string
+		want int
+	}{
+		"main without redfish": {a.mux([]string{"/redfish/"}, nil), "/redfish/v1/", 404},
+		"main health":          {a.mux([]string{"/redfish/"}, nil), "/healthcheck", 200},
+		"redfish listener":     {a.mux(nil, []string{"/redfish/"}), "/redfish/v1/", 200},
+		"redfish listener only": {
+			a.mux(nil, []string{"/redfish/"}), "/healthcheck", 404,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.want {
+				t.Errorf("GET %s = %d, want %d", tt.path, rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api.sock")
+	a := New(&config.Config{Address: "127.0.0.1", Port: 0},
+		slog.New(slog.DiscardHandler))
+	a.AddHandler("/healthcheck", http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	a.UseUnixSocket(path, 0o600)
+
+	done := make(chan error, 1)
+	go func() { done <- a.Start() }()
+	t.Cleanup(func() {
+		a.Shutdown()
+		<-done
+	})
+
+	c := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}}
+	var resp *http.Response
+	var err error
+	for range 50 {
+		if resp, err = c.Get("http://metal-boot/healthcheck"); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /healthcheck = %d, want 200", resp.StatusCode)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o600 {
+		t.Errorf("socket mode = %v, want 0600", fi.Mode().Perm())
+	}
+}
@@ -0,0 +1,148 @@
+// Package artifacts serves the artifacts the pipelines of the boot profile of
+// a host derive for it, at /artifacts/<mac>/<name>, with their detached
+// signature at /artifacts/<mac>/<name>.sig when the pipeline signs them.
+package artifacts
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/metal3-community/metal-boot/internal/artifact"
+	"github.com/metal3-community/metal-boot/internal/backend"
+	"github.com/metal3-community/metal-boot/internal/bootflow"
+	"github.com/metal3-community/metal-boot/internal/hoststate"
+	"github.com/metal3-community/metal-boot/internal/imagecache"
+	"github.com/metal3-community/metal-boot/internal/logging"
+	"github.com/metal3-community/metal-boot/internal/util"
+)
+
+// Path is the route prefix artifacts are served under.
+const Path = "/artifacts/"
+
+// cacheName names the artifact cache in the image cache metrics.
+const cacheName = "artifacts"
+
+// handler serves derived artifacts.
+type handler struct {
+	logger  *slog.Logger
+	server  *artifact.Server
+	backend backend.BackendReader
+	hosts   *hoststate.Store
+}
+
+// New creates the artifact handler, to be registered under Path. backend and
+// hosts may be nil, in which case the host data of overlays is limited to
+// its MAC address and every host gets the pipelines of the default profile.
+func New(
+	logger *slog.Logger,
+	server *artifact.Server,
+	backend backend.BackendReader,
+	hosts *hoststate.Store,
+) http.Handler {
+	return &handler{logger: logger, server: server, backend: backend, hosts: hosts}
+}
+
+// ServeHTTP builds the requested artifact unless it is cached and serves it.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	reqLogger := h.logger.With("method", r.Method, "path", r.URL.Path)
+	reqLogger = bootflow.Logger(r.Context(), reqLogger)
+	reqLogger = logging.Logger(r.Context(), reqLogger)
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	macStr, name, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, Path), "/")
+	mac, err := util.ParseMAC(macStr)
+	if !ok || err != nil || name == "" || strings.Contains(name, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	name, signature := strings.CutSuffix(name, artifact.SignatureSuffix)
+
+	node := h.node(r, mac)
+	file, err := h.server.Build(r.Context(), name, node)
+	switch {
+	case errors.Is(err, artifact.ErrNotFound):
+		http.NotFound(w, r)
+		return
+	case err != nil:
+		reqLogger.Error("Failed to build artifact", "artifact", name, "profile", node.Profile,
+			"error", err)
+		http.Error(w, "failed to build artifact", http.StatusInternalServerError)
+		return
+	}
+	if signature {
+		file += artifact.SignatureSuffix
+	}
+
+	f, err := os.Open(file)
+	if errors.Is(err, os.ErrNotExist) {
+		imagecache.RecordMiss(cacheName)
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		reqLogger.Error("Failed to open artifact", "file", file, "error", err)
+		http.Error(w, "failed to open artifact", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, "failed to open artifact", http.StatusInternalServerError)
+		return
+	}
+
+	imagecache.RecordHit(cacheName)
+	if r.Method == http.MethodGet {
+		if err := imagecache.Touch(file); err != nil {
+			reqLogger.Debug("Failed to mark artifact as served", "file", file, "error", err)
+		}
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, name, info.ModTime(), f)
+	reqLogger.Info("Served artifact", "artifact", name, "profile", node.Profile,
+		"mac", mac.String())
+}
+
+// profile returns the boot profile of the host with mac: that of the
+// artifact it was last served, such as its iPXE script.
+func (h *handler) profile(mac net.HardwareAddr) string {
+	if h.hosts == nil {
+		return artifact.DefaultProfile
+	}
+	host, err := h.hosts.Get(mac)
+	if err != nil || host.ObservedBootSource == nil || host.ObservedBootSource.Profile == "" {
+		return artifact.DefaultProfile
+	}
+
+	return host.ObservedBootSource.Profile
+}
+
+// node gathers what the pipelines know of the host with mac.
+func (h *handler) node(r *http.Request, mac net.HardwareAddr) artifact.Node {
+	node := artifact.Node{MAC: mac.String(), Profile: h.profile(mac)}
+	if h.backend != nil {
+		if d, _, err := h.backend.GetByMac(r.Context(), mac); err == nil && d != nil {
+			node.Hostname = d.Hostname
+			node.Arch = d.Arch
+			if d.IPAddress.IsValid() {
+				node.IP = d.IPAddress.String()
+			}
+		}
+	}
+	if h.hosts != nil {
+		if host, err := h.hosts.Get(mac); err == nil {
+			node.Metadata = host.Metadata
+		}
+	}
+
+	return node
+}
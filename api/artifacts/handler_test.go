@@ -0,0 +1,76 @@
+package artifacts
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/metal3-community/metal-boot/internal/artifact"
+	"github.com/metal3-community/metal-boot/internal/hoststate"
+)
+
+func TestHandler(t *testing.T) {
+	root := t.TempDir()
+	for name, content := range map[string]string{"a": "A", "b": "B"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	concat, err := artifact.NewStep(artifact.StepConcat, artifact.StepOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &artifact.Server{
+		Root:     root,
+		CacheDir: t.TempDir(),
+		Profiles: map[string][]*artifact.Pipeline{
+			artifact.DefaultProfile: {{Name: "initrd", Sources: []string{"a", "b"}, Steps: []artifact.Step{concat}}},
+			"inspector":             {{Name: "initrd", Sources: []string{"b"}}},
+		},
+	}
+	hosts, err := hoststate.NewStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inspected, _ := net.ParseMAC("aa:bb:cc:dd:ee:02")
+	if err := hosts.RecordBootSource(inspected, hoststate.BootSource{Profile: "inspector"}); err != nil {
+		t.Fatal(err)
+	}
+	h := New(slog.New(slog.DiscardHandler), server, nil, hosts)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		want   int
+		body   string
+	}{
+		{name: "default profile", path: "/artifacts/aa:bb:cc:dd:ee:01/initrd", want: http.StatusOK, body: "AB"},
+		{name: "dashed MAC", path: "/artifacts/aa-bb-cc-dd-ee-01/initrd", want: http.StatusOK, body: "AB"},
+		{name: "host profile", path: "/artifacts/aa:bb:cc:dd:ee:02/initrd", want: http.StatusOK, body: "B"},
+		{name: "unsigned", path: "/artifacts/aa:bb:cc:dd:ee:01/initrd.sig", want: http.StatusNotFound},
+		{name: "unknown artifact", path: "/artifacts/aa:bb:cc:dd:ee:01/kernel", want: http.StatusNotFound},
+		{name: "bad MAC", path: "/artifacts/node1/initrd", want: http.StatusNotFound},
+		{name: "post", method: http.MethodPost, path: "/artifacts/aa:bb:cc:dd:ee:01/initrd", want: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(method, tt.path, nil))
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.body != "" && rec.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", rec.Body, tt.body)
+			}
+		})
+	}
+}
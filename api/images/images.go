@@ -16,6 +16,7 @@ import (
 
 	"github.com/go-logr/logr"
 	"github.com/metal3-community/metal-boot/internal/config"
+	"github.com/metal3-community/metal-boot/internal/logging"
 	"github.com/metal3-community/metal-boot/internal/util"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
@@ -43,23 +44,13 @@ func (s Handler) DownloadImages() error {
 
 	defer root.Close()
 
-	httpClient := &http.Client{
-		Transport: &http.Transport{
-			DialContext: (&net.Dialer{
-				Timeout:   30 * time.Second,
-				KeepAlive: 30 * time.Second,
-				DualStack: true,
-			}).DialContext,
-			MaxIdleConns:          100,
-			IdleConnTimeout:       90 * time.Second,
-			TLSHandshakeTimeout:   10 * time.Second,
-			ExpectContinueTimeout: 1 * time.Second,
-
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true,
-			},
-		},
+	// The default transport carries the outbound proxy settings.
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
 	}
+	transport.TLSClientConfig.InsecureSkipVerify = true
+	httpClient := &http.Client{Transport: transport}
 
 	for _, image := range s.ImageURLs {
 		if util.ExistsInRoot(root, image.Path) {
@@ -108,7 +99,7 @@ func (s Handler) Handle(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 	host, port, _ := net.SplitHostPort(req.RemoteAddr)
-	log := s.Log.WithValues("host", host, "port", port)
+	log := logging.Logr(req.Context(), s.Log).WithValues("host", host, "port", port)
 
 	filename := filepath.Base(req.URL.Path)
 	log = log.WithValues("filename", filename)
@@ -7,26 +7,40 @@ import (
 	"net"
 	"net/http"
 	"path"
-	"path/filepath"
 	"regexp"
-	"strings"
 	"time"
 
+	"github.com/metal3-community/metal-boot/internal/backend"
+	"github.com/metal3-community/metal-boot/internal/bootflow"
 	"github.com/metal3-community/metal-boot/internal/config"
+	"github.com/metal3-community/metal-boot/internal/dhcp/data"
 	"github.com/metal3-community/metal-boot/internal/ipxe/binary"
+	"github.com/metal3-community/metal-boot/internal/logging"
 )
 
 // binaryHandler handles requests for iPXE binary files.
 type binaryHandler struct {
-	logger *slog.Logger
-	config *config.Config
+	logger  *slog.Logger
+	config  *config.Config
+	backend backend.BackendReader
+	patches *binary.Patcher
 }
 
-// New creates a new iPXE binary handler.
-func New(logger *slog.Logger, cfg *config.Config) http.Handler {
+// New creates a new iPXE binary handler. Binaries are patched as they are over
+// TFTP. backend may be nil, in which case hosts are only known by the MAC in
+// the URL and get no script from the backend.
+func New(logger *slog.Logger, cfg *config.Config, backend backend.BackendReader) http.Handler {
+	patches, err := cfg.Tftp.IpxePatcher()
+	if err != nil {
+		logger.Error("Invalid iPXE patches, using the default script only", "error", err)
+		patches = &binary.Patcher{Default: binary.Patches{Script: cfg.Tftp.IpxePatch}}
+	}
+
 	return &binaryHandler{
-		logger: logger,
-		config: cfg,
+		logger:  logger,
+		config:  cfg,
+		backend: backend,
+		patches: patches,
 	}
 }
 
@@ -39,6 +53,8 @@ func Match(filename string) bool {
 // ServeHTTP handles GET and HEAD requests for iPXE binaries.
 func (h *binaryHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	reqLogger := h.logger.With("method", req.Method, "path", req.URL.Path)
+	reqLogger = bootflow.Logger(req.Context(), reqLogger)
+	reqLogger = logging.Logger(req.Context(), reqLogger)
 	reqLogger.Debug("Handling iPXE binary request")
 
 	if req.Method != http.MethodGet && req.Method != http.MethodHead {
@@ -50,12 +66,27 @@ func (h *binaryHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	host, port, _ := net.SplitHostPort(req.RemoteAddr)
 	reqLogger = reqLogger.With("host", host, "port", port)
 
-	// If a mac address is provided (/0a:00:27:00:00:02/snp.efi), parse and log it.
-	// Mac address is optional.
-	optionalMac, _ := net.ParseMAC(strings.TrimPrefix(path.Dir(req.URL.Path), "/"))
+	// If a mac address is provided (/ipxe/0a:00:27:00:00:02/snp.efi), parse and
+	// log it. Mac address is optional.
+	dir, filename := path.Split(req.URL.Path)
+	optionalMac, _ := net.ParseMAC(path.Base(dir))
 	reqLogger = reqLogger.With("mac_from_uri", optionalMac.String())
 
-	filename := filepath.Base(req.URL.Path)
+	// A request for a directory, such as /ipxe/?arch=arm64, gets the binary of
+	// the client's architecture.
+	if filename == "" {
+		a, fromUA, err := negotiate(req)
+		if fromUA {
+			w.Header().Add("Vary", "User-Agent")
+		}
+		if err != nil {
+			reqLogger.Info("Could not select a binary", "user_agent", req.UserAgent(), "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filename = a.File
+		reqLogger = reqLogger.With("negotiated", true)
+	}
 	reqLogger = reqLogger.With("filename", filename)
 
 	// clients can send traceparent over HTTP by appending the traceparent string
@@ -82,17 +113,22 @@ func (h *binaryHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	// Apply iPXE patch if configured
-	if h.config.Tftp.IpxePatch != "" {
-		file, err = binary.Patch(file, []byte(h.config.Tftp.IpxePatch))
-		if err != nil {
-			reqLogger.Error("Error patching file", "error", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
+	file, err = h.patchesFor(req.Context(), optionalMac, host).Apply(file)
+	if err != nil {
+		reqLogger.Error("Error patching file", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if a, ok := binary.Lookup(filename); ok {
+		reqLogger = reqLogger.With("sha256", a.SHA256, "git_rev", a.GitRev)
 	}
 
-	http.ServeContent(w, req, filename, time.Now(), bytes.NewReader(file))
+	// The patches depend on the host and on backend data that can change, so
+	// clients revalidate against the digest of what they would get.
+	w.Header().Set("ETag", `"`+binary.Digest(file)+`"`)
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, req, filename, time.Time{}, bytes.NewReader(file))
 
 	switch req.Method {
 	case http.MethodGet:
@@ -102,6 +138,31 @@ func (h *binaryHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// patchesFor returns the patches of the binaries of the host with mac, or of
+// the host at remoteIP when mac is nil.
+func (h *binaryHandler) patchesFor(ctx context.Context, mac net.HardwareAddr, remoteIP string) binary.Patches {
+	var script string
+	if h.backend != nil {
+		var (
+			d *data.DHCP
+			n *data.Netboot
+		)
+		if mac != nil {
+			d, n, _ = h.backend.GetByMac(ctx, mac)
+		} else if ip := net.ParseIP(remoteIP); ip != nil {
+			d, n, _ = h.backend.GetByIP(ctx, ip)
+		}
+		if mac == nil && d != nil {
+			mac = d.MACAddress
+		}
+		if n != nil && len(n.IPXEScript) > 1 {
+			script = n.IPXEScript
+		}
+	}
+
+	return h.patches.WithScript(mac, script)
+}
+
 // extractTraceparentFromFilename takes a context and filename and checks the filename for
 // a traceparent tacked onto the end of it. If there is a match, the traceparent is extracted
 // and used to create tracing context (though we're not using OpenTelemetry anymore, we keep
@@ -1,6 +1,7 @@
 package binary
 
 import (
+	"bytes"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
@@ -8,13 +9,14 @@ import (
 	"testing"
 
 	"github.com/metal3-community/metal-boot/internal/config"
+	"github.com/metal3-community/metal-boot/internal/ipxe/binary"
 )
 
 func TestNew(t *testing.T) {
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 	cfg := &config.Config{}
 
-	handler := New(logger, cfg)
+	handler := New(logger, cfg, nil)
 	if handler == nil {
 		t.Fatal("Expected non-nil handler")
 	}
@@ -24,7 +26,7 @@ func TestHandler_ServeHTTP_MethodNotAllowed(t *testing.T) {
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 	cfg := &config.Config{}
 
-	handler := New(logger, cfg)
+	handler := New(logger, cfg, nil)
 
 	req := httptest.NewRequest(http.MethodPost, "/test.efi", nil)
 	w := httptest.NewRecorder()
@@ -40,7 +42,7 @@ func TestHandler_ServeHTTP_FileNotFound(t *testing.T) {
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 	cfg := &config.Config{}
 
-	handler := New(logger, cfg)
+	handler := New(logger, cfg, nil)
 
 	req := httptest.NewRequest(http.MethodGet, "/nonexistent.efi", nil)
 	w := httptest.NewRecorder()
@@ -51,3 +53,106 @@ func TestHandler_ServeHTTP_FileNotFound(t *testing.T) {
 		t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
 	}
 }
+
+func TestHandler_ServeHTTP_Negotiate(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	handler := New(logger, &config.Config{}, nil)
+
+	tests := []struct {
+		name      string
+		target    string
+		userAgent string
+		wantCode  int
+		wantFile  string
+		wantVary  bool
+	}{
+		{name: "named file", target: "/ipxe/snp.efi", wantCode: http.StatusOK, wantFile: "snp.efi"},
+		{
+			name:     "named file with mac",
+			target:   "/ipxe/d8-3a-dd-00-00-01/undionly.kpxe",
+			wantCode: http.StatusOK,
+			wantFile: "undionly.kpxe",
+		},
+		{name: "arch query", target: "/ipxe/?arch=arm64", wantCode: http.StatusOK, wantFile: "snp.efi"},
+		{name: "ipxe buildarch", target: "/ipxe/?arch=x86_64", wantCode: http.StatusOK, wantFile: "ipxe.efi"},
+		{
+			name:     "ipxe platform",
+			target:   "/ipxe/?arch=x86_64&platform=pcbios",
+			wantCode: http.StatusOK,
+			wantFile: "undionly.kpxe",
+		},
+		{name: "option 93 type", target: "/ipxe/?arch=0", wantCode: http.StatusOK, wantFile: "undionly.kpxe"},
+		{
+			name:      "user agent",
+			target:    "/ipxe/d8-3a-dd-00-00-01/",
+			userAgent: "UefiHttpBoot/1.0 (aarch64)",
+			wantCode:  http.StatusOK,
+			wantFile:  "snp.efi",
+			wantVary:  true,
+		},
+		{name: "unknown arch", target: "/ipxe/?arch=mips", wantCode: http.StatusBadRequest},
+		{name: "32 bit arch", target: "/ipxe/?arch=i386", wantCode: http.StatusBadRequest},
+		{name: "32 bit option 93 type", target: "/ipxe/?arch=6", wantCode: http.StatusBadRequest},
+		{name: "32 bit arm family", target: "/ipxe/?arch=arm32_efi", wantCode: http.StatusBadRequest},
+		{
+			name:      "32 bit user agent",
+			target:    "/ipxe/",
+			userAgent: "UefiHttpBoot/1.0 (ia32)",
+			wantCode:  http.StatusBadRequest,
+			wantVary:  true,
+		},
+		{name: "no arch", target: "/ipxe/", userAgent: "curl/8.0", wantCode: http.StatusBadRequest, wantVary: true},
+		{name: "no platform binary", target: "/ipxe/?arch=arm64&platform=pcbios", wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			req.Header.Set("User-Agent", tt.userAgent)
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+			if got := w.Header().Get("Vary") == "User-Agent"; got != tt.wantVary {
+				t.Errorf("Vary = %q, want User-Agent: %v", w.Header().Get("Vary"), tt.wantVary)
+			}
+			if tt.wantFile == "" {
+				return
+			}
+			if !bytes.Equal(w.Body.Bytes(), binary.Files[tt.wantFile]) {
+				t.Errorf("body is not %s", tt.wantFile)
+			}
+		})
+	}
+}
+
+func TestHandler_ServeHTTP_ETag(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	handler := New(logger, &config.Config{}, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/ipxe/ipxe.efi", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" {
+		t.Fatalf("status = %d, ETag = %q", w.Code, etag)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/ipxe/ipxe.efi", nil)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("revalidation status = %d, want %d", w.Code, http.StatusNotModified)
+	}
+
+	// A different patch is a different ETag.
+	cfg := &config.Config{Tftp: config.TftpConfig{IpxePatch: "chain http://10.1.1.1/boot"}}
+	w = httptest.NewRecorder()
+	New(logger, cfg, nil).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ipxe/ipxe.efi", nil))
+	if got := w.Header().Get("ETag"); got == etag {
+		t.Errorf("ETag of the patched binary = %q, want a new one", got)
+	}
+}
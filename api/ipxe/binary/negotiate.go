@@ -0,0 +1,141 @@
+package binary
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/metal3-community/metal-boot/internal/dhcp"
+	"github.com/metal3-community/metal-boot/internal/ipxe/binary"
+)
+
+// errNoArch is returned when neither the query nor the User-Agent of a request
+// for no particular binary names an architecture.
+var errNoArch = errors.New("cannot determine the client architecture, set the arch query parameter")
+
+// target is an architecture and, optionally, a firmware platform of the
+// binary registry.
+type target struct {
+	arch     string
+	platform string
+}
+
+// archNames maps the architecture names clients use, such as iPXE's
+// ${buildarch} and the DHCP architecture families of the boot files, to the
+// binary they boot. 32 bit architectures have no binary in the registry, so
+// they are not named.
+var archNames = map[string]target{
+	"amd64":            {arch: binary.ArchAMD64},
+	"x86_64":           {arch: binary.ArchAMD64},
+	"x64":              {arch: binary.ArchAMD64},
+	"arm64":            {arch: binary.ArchARM64},
+	"aarch64":          {arch: binary.ArchARM64},
+	dhcp.ArchBIOS:      {arch: binary.ArchAMD64, platform: binary.PlatformBIOS},
+	dhcp.ArchX86EFI:    {arch: binary.ArchAMD64, platform: binary.PlatformEFI},
+	dhcp.ArchARM64EFI:  {arch: binary.ArchARM64, platform: binary.PlatformSNP},
+	dhcp.ArchRPi:       {arch: binary.ArchARM64, platform: binary.PlatformSNP},
+	"pcbios":           {platform: binary.PlatformBIOS},
+	"efi":              {platform: binary.PlatformEFI},
+	"uefi":             {platform: binary.PlatformEFI},
+	"uefihttpboot":     {platform: binary.PlatformEFI},
+	binary.PlatformSNP: {platform: binary.PlatformSNP},
+}
+
+// efi32Types are the option 93 architecture types of 32 bit x86 UEFI
+// clients. They share the x86_64_efi family for boot files, but the binary
+// of that family does not boot them.
+var efi32Types = []iana.Arch{iana.EFI_IA32, iana.EFI_XSCALE, iana.EFI_X86_HTTP}
+
+// parseArch returns the target of an arch query parameter: a name of
+// archNames or an option 93 architecture type in decimal.
+func parseArch(s string) (target, error) {
+	s = strings.ToLower(s)
+	if n, err := strconv.ParseUint(s, 10, 16); err == nil {
+		if slices.Contains(efi32Types, iana.Arch(n)) {
+			return target{}, fmt.Errorf("no binary for architecture type %d", n)
+		}
+		s = dhcp.ArchFamily(iana.Arch(n))
+	}
+	t, ok := archNames[s]
+	if !ok || t.arch == "" {
+		return target{}, fmt.Errorf("unknown architecture %q", s)
+	}
+
+	return t, nil
+}
+
+// parsePlatform returns the registry platform of a platform query parameter,
+// such as iPXE's ${platform}.
+func parsePlatform(s string) (string, error) {
+	t, ok := archNames[strings.ToLower(s)]
+	if !ok || t.arch != "" {
+		return "", fmt.Errorf("unknown platform %q", s)
+	}
+
+	return t.platform, nil
+}
+
+// userAgentTarget returns the target named by the words of a User-Agent, such
+// as "UefiHttpBoot/1.0 (arm64)". Later words fill in what earlier ones left
+// out; version numbers are never taken for architecture types.
+func userAgentTarget(ua string) target {
+	var t target
+	words := strings.FieldsFunc(strings.ToLower(ua), func(r rune) bool {
+		return (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '_'
+	})
+	for _, w := range words {
+		named, ok := archNames[w]
+		if !ok {
+			continue
+		}
+		if t.arch == "" {
+			t.arch = named.arch
+		}
+		if t.platform == "" {
+			t.platform = named.platform
+		}
+	}
+
+	return t
+}
+
+// negotiate returns the binary a request for no particular file gets: the one
+// of its arch and platform query parameters or, without an arch parameter, of
+// the architecture its User-Agent names. fromUA reports whether the
+// User-Agent was used, in which case responses vary by it.
+func negotiate(r *http.Request) (a binary.Artifact, fromUA bool, err error) {
+	var t target
+	q := r.URL.Query()
+	if s := q.Get("arch"); s != "" {
+		if t, err = parseArch(s); err != nil {
+			return binary.Artifact{}, false, err
+		}
+	} else {
+		t = userAgentTarget(r.UserAgent())
+		fromUA = true
+		if t.arch == "" {
+			return binary.Artifact{}, fromUA, errNoArch
+		}
+	}
+	if s := q.Get("platform"); s != "" {
+		if t.platform, err = parsePlatform(s); err != nil {
+			return binary.Artifact{}, fromUA, err
+		}
+	}
+
+	a, ok := binary.Select(t.arch, t.platform)
+	if !ok && fromUA && q.Get("platform") == "" {
+		// The platform a User-Agent names is only a preference: UEFI
+		// clients of one architecture boot either EFI binary.
+		a, ok = binary.Select(t.arch, "")
+	}
+	if !ok {
+		return binary.Artifact{}, fromUA, fmt.Errorf("no %s binary for %s", t.platform, t.arch)
+	}
+
+	return a, fromUA, nil
+}
@@ -10,7 +10,10 @@ import (
 	"github.com/metal3-community/metal-boot/api/ipxe/script"
 	"github.com/metal3-community/metal-boot/api/ipxe/static"
 	"github.com/metal3-community/metal-boot/internal/backend"
+	"github.com/metal3-community/metal-boot/internal/bootflow"
 	"github.com/metal3-community/metal-boot/internal/config"
+	"github.com/metal3-community/metal-boot/internal/integrity"
+	"github.com/metal3-community/metal-boot/internal/logging"
 )
 
 // handler routes iPXE requests to the appropriate sub-handlers.
@@ -22,20 +25,29 @@ type handler struct {
 	staticHandler http.Handler
 }
 
-// New creates a new iPXE router handler.
-func New(logger *slog.Logger, cfg *config.Config, backend backend.BackendReader) http.Handler {
+// New creates a new iPXE router handler. manifests may be nil, in which case
+// no integrity manifests are served.
+func New(
+	logger *slog.Logger,
+	cfg *config.Config,
+	backend backend.BackendReader,
+	manifests *integrity.Manifests,
+) http.Handler {
+	scriptLogger := logger.With("component", "script")
 	return &handler{
 		logger:        logger,
 		config:        cfg,
-		binaryHandler: binary.New(logger.With("component", "binary"), cfg),
-		scriptHandler: script.New(logger.With("component", "script"), cfg, backend),
-		staticHandler: static.New(logger.With("component", "static"), cfg),
+		binaryHandler: binary.New(logger.With("component", "binary"), cfg, backend),
+		scriptHandler: script.New(scriptLogger, cfg, backend, script.Options{}),
+		staticHandler: static.New(logger.With("component", "static"), cfg, backend, manifests),
 	}
 }
 
 // ServeHTTP routes requests to the appropriate handler based on the requested file.
 func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	reqLogger := h.logger.With("method", r.Method, "path", r.URL.Path)
+	reqLogger = bootflow.Logger(r.Context(), reqLogger)
+	reqLogger = logging.Logger(r.Context(), reqLogger)
 	reqLogger.Debug("Routing iPXE request")
 
 	basePath := filepath.Base(r.URL.Path)
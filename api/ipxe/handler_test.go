@@ -14,7 +14,7 @@ func TestNew(t *testing.T) {
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 	cfg := &config.Config{}
 
-	handler := New(logger, cfg, nil)
+	handler := New(logger, cfg, nil, nil)
 	if handler == nil {
 		t.Fatal("Expected non-nil handler")
 	}
@@ -28,7 +28,7 @@ func TestHandler_ServeHTTP_RoutingLogic(t *testing.T) {
 		},
 	}
 
-	handler := New(logger, cfg, nil)
+	handler := New(logger, cfg, nil, nil)
 
 	tests := []struct {
 		name           string
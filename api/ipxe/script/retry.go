@@ -0,0 +1,97 @@
+package script
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/metal3-community/metal-boot/internal/config"
+)
+
+// retryCommands download or boot an image and are retried when they fail.
+var retryCommands = map[string]bool{
+	"kernel":   true,
+	"initrd":   true,
+	"module":   true,
+	"imgfetch": true,
+	"chain":    true,
+	"imgexec":  true,
+	"boot":     true,
+	"sanboot":  true,
+}
+
+const (
+	retryCounter  = "metalboot-tries"
+	retryLabel    = "metalboot_retry"
+	failedLabel   = "metalboot_failed"
+	fallbackLabel = "metalboot_fallback"
+)
+
+// applyRetries restarts the script whenever a download or boot fails, up to
+// policy.Retries times, and runs the fallback of the policy once the retries
+// are used up. The iPXE scripting language has no arithmetic, so the delay
+// before every retry is rendered into its own line.
+func applyRetries(script []byte, policy config.ScriptRetryPolicy) []byte {
+	if policy.Retries <= 0 {
+		return script
+	}
+
+	lines := strings.Split(string(script), "\n")
+	out := make([]string, 0, len(lines)+policy.Retries+8)
+	changed := false
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || !retryCommands[fields[0]] ||
+			strings.Contains(line, "||") || strings.Contains(line, "&&") {
+			out = append(out, line)
+			continue
+		}
+		out = append(out, strings.TrimRight(line, " \t")+" || goto "+failedLabel)
+		changed = true
+	}
+	if !changed {
+		return script
+	}
+
+	header := 0
+	if len(out) > 0 && strings.HasPrefix(out[0], "#!ipxe") {
+		header = 1
+	}
+	prologue := []string{"set " + retryCounter + " 0", ":" + retryLabel}
+	out = append(out[:header], append(prologue, out[header:]...)...)
+
+	result := strings.TrimRight(strings.Join(out, "\n"), "\n") + "\n"
+	result += "exit\n\n:" + failedLabel + "\ninc " + retryCounter + "\n"
+	delay := max(policy.RetryDelay, 0)
+	for try := 1; try <= policy.Retries; try++ {
+		notice := fmt.Sprintf("Boot failed, retry %d of %d in %d seconds",
+			try, policy.Retries, delay)
+		result += fmt.Sprintf("iseq ${%s} %d && echo %s ||\n", retryCounter, try, notice)
+		result += fmt.Sprintf("iseq ${%s} %d && sleep %d && goto %s ||\n",
+			retryCounter, try, delay, retryLabel)
+		if policy.RetryBackoff {
+			delay *= 2
+		}
+	}
+	result += "goto " + fallbackLabel + "\n\n:" + fallbackLabel + "\n" + fallbackCommands(policy)
+
+	return []byte(result)
+}
+
+// fallbackCommands returns the lines run once the retries of policy are used
+// up. Unknown fallbacks boot locally.
+func fallbackCommands(policy config.ScriptRetryPolicy) string {
+	switch policy.Fallback {
+	case config.ScriptFallbackRescue:
+		if policy.RescueURL != "" {
+			return "echo Boot failed, chaining rescue script\nchain " + policy.RescueURL +
+				" || shell\n"
+		}
+		return "echo Boot failed and no rescue script is configured\nshell\n"
+	case config.ScriptFallbackShell:
+		return "echo Boot failed\nshell\n"
+	case config.ScriptFallbackReboot:
+		return "echo Boot failed, rebooting\nreboot\n"
+	default:
+		return "echo Boot failed, booting from the next device\nexit 1\n"
+	}
+}
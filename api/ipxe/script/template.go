@@ -0,0 +1,84 @@
+package script
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/metal3-community/metal-boot/internal/hoststate"
+	"github.com/metal3-community/metal-boot/internal/ipxe/scripttemplate"
+)
+
+// defaultTemplate is the template served to nodes without a template of
+// their own or a pxelinux.cfg entry.
+const defaultTemplate = "default"
+
+// TemplateData is what iPXE script templates are executed with.
+type TemplateData struct {
+	// MAC is the MAC address of the node, as "d8:3a:dd:01:02:03".
+	MAC string
+	// Hostname, IP, IPv6 and Arch are the backend data of the node.
+	Hostname string
+	IP       string
+	IPv6     string
+	Arch     string
+	// State is the provisioning state of the node.
+	State string
+	// Metadata is the free-form metadata of the node.
+	Metadata map[string]string
+	// KernelArgs are the extra kernel arguments with the overrides of the node
+	// applied. They are added to every kernel line of the script anyway, but
+	// not twice.
+	KernelArgs string
+	// Images maps the names of catalog images to the URLs the node boots them
+	// from, with the canary images of the rollouts it is in.
+	Images map[string]string
+}
+
+// templateNames returns the names of the templates of the node with mac and
+// hostname, most specific first: the node template, named after its MAC
+// address as "d8-3a-dd-01-02-03", or after its host name.
+func templateNames(mac net.HardwareAddr, hostname string) []string {
+	return []string{strings.ReplaceAll(mac.String(), ":", "-"), hostname}
+}
+
+// templateData gathers the variables of the templates of mac.
+func (h *scriptHandler) templateData(ctx context.Context, mac net.HardwareAddr) TemplateData {
+	d := TemplateData{MAC: mac.String()}
+	if h.backend != nil {
+		dhcp, _, err := h.backend.GetByMac(ctx, mac)
+		if err != nil {
+			h.logger.Warn("Failed to get backend data of template", "mac", mac.String(),
+				"error", err)
+		}
+		if dhcp != nil {
+			d.Hostname = dhcp.Hostname
+			d.Arch = dhcp.Arch
+			if dhcp.IPAddress.IsValid() {
+				d.IP = dhcp.IPAddress.String()
+			}
+			if dhcp.IPv6Address.IsValid() {
+				d.IPv6 = dhcp.IPv6Address.String()
+			}
+		}
+	}
+
+	var overrides hoststate.KernelArgs
+	if h.hosts != nil {
+		if host, err := h.hosts.Get(mac); err == nil {
+			d.State = string(host.State)
+			d.Metadata = host.Metadata
+			overrides = host.KernelArgs
+		}
+	}
+	extra := hoststate.KernelArgs{Add: h.config.IpxeHttpScript.ExtraKernelArgs}.Apply(nil)
+	d.KernelArgs = strings.Join(overrides.Apply(extra), " ")
+	d.Images, _ = h.imageURLs(mac)
+
+	return d
+}
+
+// templateFile is the file name of the template called name.
+func templateFile(name string) string {
+	return name + scripttemplate.Suffix
+}
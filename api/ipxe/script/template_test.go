@@ -0,0 +1,122 @@
+package script
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/metal3-community/metal-boot/internal/config"
+	"github.com/metal3-community/metal-boot/internal/filewatch"
+	"github.com/metal3-community/metal-boot/internal/hoststate"
+	"github.com/metal3-community/metal-boot/internal/ipxe/scripttemplate"
+)
+
+func TestRenderTemplates(t *testing.T) {
+	root, dir := t.TempDir(), t.TempDir()
+	for path, content := range map[string]string{
+		filepath.Join(root, "pxelinux.cfg", "d8-3a-dd-00-00-02"): "#!ipxe\nchain ironic\n",
+		filepath.Join(root, "pxelinux.cfg", "d8-3a-dd-00-00-04"): "#!ipxe\nchain wipe\n",
+		filepath.Join(root, "fallback.ipxe"):                     "#!ipxe\nexit\n",
+		filepath.Join(dir, "d8-3a-dd-00-00-04.tmpl"):             "#!ipxe\nchain deploy\n",
+		filepath.Join(dir, "d8-3a-dd-00-00-01.tmpl"): "#!ipxe\n" +
+			"echo {{.MAC}} in {{.Metadata.rack}}\n" +
+			"kernel http://x/vmlinuz {{.KernelArgs}}\n",
+		filepath.Join(dir, "default.tmpl"): "#!ipxe\necho default {{.MAC}} {{.State}}\n",
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	templates, err := scripttemplate.New(logr.Discard(), dir, filewatch.Options{})
+	if err != nil {
+		t.Fatalf("scripttemplate.New() error = %v", err)
+	}
+	defer templates.Close()
+
+	node, _ := net.ParseMAC("d8:3a:dd:00:00:01")
+	hosts, err := hoststate.NewStore("")
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	if err := hosts.Update(node, func(h *hoststate.Host) {
+		h.Metadata = map[string]string{"rack": "r1"}
+		h.KernelArgs = hoststate.KernelArgs{Add: []string{"debug"}}
+	}); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	cleaning, _ := net.ParseMAC("d8:3a:dd:00:00:04")
+	if err := hosts.SetState(cleaning, hoststate.StateCleaning, ""); err != nil {
+		t.Fatalf("SetState() error = %v", err)
+	}
+
+	cfg := &config.Config{}
+	cfg.Static.RootDirectory = root
+	cfg.IpxeHttpScript.ExtraKernelArgs = []string{"console=ttyS0"}
+	cfg.BootAttempts.FallbackScript = "fallback.ipxe"
+	h := &scriptHandler{
+		logger:    slog.New(slog.DiscardHandler),
+		config:    cfg,
+		hosts:     hosts,
+		templates: templates,
+	}
+
+	tests := []struct {
+		name     string
+		mac      string
+		fallback bool
+		file     string
+		want     string
+	}{
+		{
+			name: "node template",
+			mac:  "d8:3a:dd:00:00:01",
+			file: "d8-3a-dd-00-00-01.tmpl",
+			want: "#!ipxe\nset meta-rack r1\necho d8:3a:dd:00:00:01 in r1\n" +
+				"kernel http://x/vmlinuz console=ttyS0 debug\n",
+		},
+		{
+			name:     "fallback",
+			mac:      "d8:3a:dd:00:00:01",
+			fallback: true,
+			file:     "fallback.ipxe",
+			want:     "#!ipxe\nset meta-rack r1\nexit\n",
+		},
+		{
+			name: "pxelinux.cfg before default template",
+			mac:  "d8:3a:dd:00:00:02",
+			file: "pxelinux.cfg/d8-3a-dd-00-00-02",
+			want: "#!ipxe\nchain ironic\n",
+		},
+		{
+			name: "cleaning script before node template",
+			mac:  "d8:3a:dd:00:00:04",
+			file: "pxelinux.cfg/d8-3a-dd-00-00-04",
+			want: "#!ipxe\nchain wipe\n",
+		},
+		{
+			name: "default template",
+			mac:  "d8:3a:dd:00:00:03",
+			file: "default.tmpl",
+			want: "#!ipxe\necho default d8:3a:dd:00:00:03 \n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mac, _ := net.ParseMAC(tt.mac)
+			got, err := h.render(context.Background(), mac, tt.fallback)
+			if err != nil {
+				t.Fatalf("render() error = %v", err)
+			}
+			if got.File != tt.file || string(got.Script) != tt.want {
+				t.Errorf("render() = %s\n%s\nwant %s\n%s", got.File, got.Script, tt.file, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package script
+
+import (
+	"path"
+	"strings"
+
+	"github.com/metal3-community/metal-boot/internal/integrity"
+)
+
+// fetchCommands download an image that is used later in the script, so it can
+// be verified before it is booted.
+var fetchCommands = map[string]bool{
+	"kernel":   true,
+	"initrd":   true,
+	"module":   true,
+	"imgfetch": true,
+}
+
+// applyVerification follows every image download with an imgverify of the
+// image against its detached signature, which is served next to the image.
+func applyVerification(script []byte) []byte {
+	lines := strings.Split(string(script), "\n")
+	out := make([]string, 0, len(lines))
+	for _, line := range lines {
+		out = append(out, line)
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !fetchCommands[fields[0]] {
+			continue
+		}
+		name, uri := imageNameAndURI(fields[1:])
+		if uri == "" {
+			continue
+		}
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		out = append(out, indent+"imgverify "+name+" "+signatureURI(uri)+" || goto verify_failed")
+	}
+
+	result := strings.Join(out, "\n")
+	if result != string(script) {
+		result = strings.TrimRight(result, "\n") + "\n" +
+			"exit\n\n:verify_failed\necho Image signature verification failed\nshell\n"
+	}
+
+	return []byte(result)
+}
+
+// imageNameAndURI parses "[--option...] <uri> [args...]" and returns the name
+// iPXE gives the image, which is --name or the basename of the URI path.
+func imageNameAndURI(args []string) (string, string) {
+	var name string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--name" || arg == "-n":
+			if i+1 < len(args) {
+				i++
+				name = args[i]
+			}
+		case strings.HasPrefix(arg, "--name="):
+			name = strings.TrimPrefix(arg, "--name=")
+		case arg == "--timeout" || arg == "-t":
+			i++
+		case strings.HasPrefix(arg, "-"):
+		default:
+			if name == "" {
+				name = path.Base(strings.SplitN(arg, "?", 2)[0])
+			}
+			return name, arg
+		}
+	}
+
+	return "", ""
+}
+
+// signatureURI returns the URI of the detached signature of the image at uri.
+// The URI is not parsed so that iPXE settings such as ${next-server} survive.
+func signatureURI(uri string) string {
+	base, query, found := strings.Cut(uri, "?")
+	if !found {
+		return base + integrity.SignatureSuffix
+	}
+
+	return base + integrity.SignatureSuffix + "?" + query
+}
@@ -1,34 +1,156 @@
 package static
 
 import (
+	"bytes"
+	"errors"
 	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/metal3-community/metal-boot/internal/backend"
+	"github.com/metal3-community/metal-boot/internal/bootflow"
 	"github.com/metal3-community/metal-boot/internal/config"
+	"github.com/metal3-community/metal-boot/internal/imagecache"
+	"github.com/metal3-community/metal-boot/internal/integrity"
+	"github.com/metal3-community/metal-boot/internal/logging"
+	"github.com/metal3-community/metal-boot/internal/nodefs"
 )
 
+// imagesPrefix is the part of the static root holding downloaded images,
+// which is managed by the image cache janitor.
+const imagesPrefix = "/images/"
+
 // handler handles static file requests.
 type handler struct {
-	logger *slog.Logger
-	config *config.Config
+	logger    *slog.Logger
+	config    *config.Config
+	backend   backend.BackendReader
+	manifests *integrity.Manifests
 }
 
-// New creates a new static files handler.
-func New(logger *slog.Logger, cfg *config.Config) http.Handler {
+// New creates a new static files handler. When manifests is not nil, checksum
+// manifests and signatures are served for files that do not have them on disk.
+// Clients that backend knows by their address are served the files of their
+// own directory, nodes/<mac>, in place of the shared ones; backend may be nil,
+// in which case every client is served the shared files only.
+func New(
+	logger *slog.Logger,
+	cfg *config.Config,
+	backend backend.BackendReader,
+	manifests *integrity.Manifests,
+) http.Handler {
 	return &handler{
-		logger: logger,
-		config: cfg,
+		logger:    logger,
+		config:    cfg,
+		backend:   backend,
+		manifests: manifests,
 	}
 }
 
 // ServeHTTP handles static file requests.
 func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	reqLogger := h.logger.With("method", r.Method, "path", r.URL.Path)
+	reqLogger = bootflow.Logger(r.Context(), reqLogger)
+	reqLogger = logging.Logger(r.Context(), reqLogger)
 	reqLogger.Debug("Handling static file request")
 
+	name, err := nodefs.Resolve(h.config.Static.RootDirectory, h.clientMAC(r), r.URL.Path)
+	if err != nil {
+		reqLogger.Info("Refusing request of another host's file", "error", err)
+		http.NotFound(w, r)
+		return
+	}
+	if name = "/" + name; name != path.Clean(r.URL.Path) {
+		reqLogger.Debug("Serving host file", "file", name)
+		r = r.Clone(r.Context())
+		r.URL.Path = name
+	}
+
+	if h.manifests.IsDerived(r.URL.Path) && h.serveDerived(w, r) {
+		reqLogger.Info("Integrity manifest served")
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, imagesPrefix) {
+		h.recordImageRequest(r)
+	}
+
 	// Use the built-in file server for the configured static directory
 	fileServer := http.FileServer(http.Dir(h.config.Static.RootDirectory))
 	fileServer.ServeHTTP(w, r)
 
 	reqLogger.Info("Static file served")
 }
+
+// clientMAC returns the MAC of the host the backend has for the address of r,
+// or nil if it has none.
+func (h *handler) clientMAC(r *http.Request) net.HardwareAddr {
+	if h.backend == nil {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil
+	}
+	d, _, err := h.backend.GetByIP(r.Context(), ip)
+	if err != nil || d == nil {
+		return nil
+	}
+
+	return d.MACAddress
+}
+
+// recordImageRequest counts an image cache hit or miss and marks the image
+// as recently served so the janitor evicts it last.
+func (h *handler) recordImageRequest(r *http.Request) {
+	name := filepath.Join(h.config.Static.RootDirectory, filepath.FromSlash(path.Clean(r.URL.Path)))
+	info, err := os.Stat(name)
+	if err != nil || !info.Mode().IsRegular() {
+		imagecache.RecordMiss("images")
+		return
+	}
+
+	imagecache.RecordHit("images")
+	if r.Method == http.MethodGet {
+		if err := imagecache.Touch(name); err != nil {
+			h.logger.Debug("Failed to mark image as served", "path", name, "error", err)
+		}
+	}
+}
+
+// serveDerived serves a computed manifest or signature for r and reports
+// whether it did. Files present on disk are left to the file server.
+func (h *handler) serveDerived(w http.ResponseWriter, r *http.Request) bool {
+	root := h.config.Static.RootDirectory
+	name := path.Clean(r.URL.Path)
+	if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(name))); err == nil {
+		return false
+	}
+
+	content, err := h.manifests.Derived(root, name)
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, integrity.ErrNotDerived) {
+		return false
+	}
+	if err != nil {
+		h.logger.Error("Failed to compute integrity manifest", "path", name, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return true
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if strings.HasSuffix(name, integrity.ManifestSuffix) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	http.ServeContent(w, r, path.Base(name), time.Time{}, bytes.NewReader(content))
+
+	return true
+}
@@ -3,21 +3,30 @@ package ironic
 import (
 	"log/slog"
 	"net/http"
+	"time"
 
+	"github.com/metal3-community/metal-boot/internal/config"
 	"github.com/metal3-community/metal-boot/internal/ironic"
 )
 
 // handler handles metrics requests.
 type handler struct {
 	logger      *slog.Logger
-	socketProxy *ironic.SocketProxy
+	socketProxy http.Handler
 }
 
-// New creates a new metrics handler.
-func New(logger *slog.Logger, socketPath string) http.Handler {
+// New creates a new metrics handler. Reads of the endpoints cache selects are
+// answered from a short-lived cache.
+func New(logger *slog.Logger, socketPath string, cache config.IronicCacheConfig) http.Handler {
+	paths := cache.Paths
+	if len(paths) == 0 {
+		paths = ironic.DefaultCachedPaths
+	}
+
 	return &handler{
-		logger:      logger,
-		socketProxy: ironic.NewSocketProxy(logger, socketPath),
+		logger: logger,
+		socketProxy: ironic.NewCache(ironic.NewSocketProxy(logger, socketPath),
+			time.Duration(cache.TTLSec)*time.Second, paths),
 	}
 }
 
@@ -21,6 +21,9 @@ import (
 	"github.com/metal3-community/metal-boot/internal/backend"
 	"github.com/metal3-community/metal-boot/internal/config"
 	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+	"github.com/metal3-community/metal-boot/internal/hoststate"
+	"github.com/metal3-community/metal-boot/internal/imagecatalog"
+	"github.com/metal3-community/metal-boot/internal/logging"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 )
 
@@ -31,7 +34,19 @@ const (
 // Context key type for patch data.
 type patchCtxKeyType string
 
-const isoPatchCtxKey patchCtxKeyType = "iso-patch"
+const (
+	isoPatchCtxKey  patchCtxKeyType = "iso-patch"
+	isoSourceCtxKey patchCtxKeyType = "iso-source"
+)
+
+// MediaFile is the file name under which the ISO handler serves the virtual
+// media inserted for a host.
+const MediaFile = "virtual-media.iso"
+
+// MediaPath returns the path of the virtual media inserted for mac.
+func MediaPath(mac net.HardwareAddr) string {
+	return path.Join("/iso", mac.String(), MediaFile)
+}
 
 // withPatch adds patch data to the context.
 func withPatch(ctx context.Context, patch []byte) context.Context {
@@ -47,6 +62,19 @@ func getPatch(ctx context.Context) []byte {
 	return patch
 }
 
+// withSource adds the URL of the ISO served for a request to the context.
+func withSource(ctx context.Context, source *url.URL) context.Context {
+	return context.WithValue(ctx, isoSourceCtxKey, source)
+}
+
+// sourceURL returns the URL of the ISO served for the request of ctx.
+func (h *isoHandler) sourceURL(ctx context.Context) *url.URL {
+	if u, ok := ctx.Value(isoSourceCtxKey).(*url.URL); ok {
+		return u
+	}
+	return h.parsedURL
+}
+
 // isoHandler is a struct that contains the necessary fields to patch an ISO file with
 // relevant information for the Tink worker.
 type isoHandler struct {
@@ -60,13 +88,21 @@ type isoHandler struct {
 	MagicString string
 	// SourceISO is the source url where the unmodified iso lives.
 	// It must be a valid url.URL{} object and must have a url.URL{}.Scheme of HTTP or HTTPS.
-	SourceISO         string
+	SourceISO string
+	// Image names the catalog image of kind iso that replaces SourceISO. It
+	// is looked up on every request so that catalog changes apply at once.
+	Image             string
+	Images            *imagecatalog.Catalog
 	Syslog            string
 	UseTLS            bool
 	GRPCAddr          string
 	StaticIPAMEnabled bool
-	// parsedURL derives a url.URL from the SourceISO field.
-	// It needed for validation of SourceISO and easier modification.
+	// Hosts holds the virtual media inserted through Redfish, which is
+	// served as MediaFile instead of the source ISO. Nil disables it.
+	Hosts *hoststate.Store
+	// parsedURL derives a url.URL from the SourceISO field. ServeHTTP passes
+	// the source of each request in its context instead; parsedURL is used
+	// for requests without one.
 	parsedURL       *url.URL
 	magicStrPadding []byte
 }
@@ -75,6 +111,8 @@ func New(
 	logger logr.Logger,
 	cfg *config.Config,
 	backend backend.BackendReader,
+	images *imagecatalog.Catalog,
+	hosts *hoststate.Store,
 ) http.Handler {
 	return &isoHandler{
 		Backend:           backend,
@@ -82,6 +120,9 @@ func New(
 		Logger:            logger,
 		MagicString:       cfg.Iso.MagicString,
 		SourceISO:         cfg.Iso.Url,
+		Image:             cfg.Iso.Image,
+		Images:            images,
+		Hosts:             hosts,
 		Syslog:            cfg.Dhcp.SyslogIP,
 		UseTLS:            cfg.IpxeHttpScript.UseTLS,
 		StaticIPAMEnabled: cfg.Dhcp.StaticIPAMEnabled,
@@ -92,12 +133,35 @@ func New(
 func (h *isoHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	h.Logger.V(1).Info("Handling metrics request", "path", r.URL.Path, "method", r.Method)
 
-	target, err := url.Parse(h.SourceISO)
+	source := h.SourceISO
+	if path.Base(r.URL.Path) == MediaFile {
+		mac, err := getMAC(r.URL.Path)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		media, ok := h.Hosts.InsertedMedia(mac)
+		if !ok {
+			http.Error(w, "no virtual media inserted", http.StatusNotFound)
+			return
+		}
+		source = media.Image
+	} else if h.Image != "" {
+		u, err := h.Images.Resolve(h.Image, imagecatalog.KindISO)
+		if err != nil {
+			h.Logger.Error(err, "failed to resolve ISO image", "image", h.Image)
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		source = u
+	}
+
+	target, err := url.Parse(source)
 	if err != nil {
-		h.Logger.Error(err, "failed to parse SourceISO", "sourceISO", h.SourceISO)
+		h.Logger.Error(err, "failed to parse SourceISO", "sourceISO", source)
 		return
 	}
-	h.parsedURL = target
+	r = r.WithContext(withSource(r.Context(), target))
 
 	proxy := &httputil.ReverseProxy{
 		Rewrite: func(r *httputil.ProxyRequest) {
@@ -168,12 +232,12 @@ func (h *isoHandler) Copy(
 // This method is called by the internal.NewSingleHostReverseProxy to handle the incoming request.
 // The method is responsible for validating the incoming request and getting the source ISO.
 func (h *isoHandler) RoundTrip(req *http.Request) (*http.Response, error) {
-	log := h.Logger.WithValues(
+	log := logging.Logr(req.Context(), h.Logger).WithValues(
 		"method",
 		req.Method,
 		"inboundURI",
 		req.RequestURI,
-		"remoteAddr",
+		"remote_addr",
 		req.RemoteAddr,
 	)
 	log.V(1).Info("starting the ISO patching HTTP handler")
@@ -214,7 +278,7 @@ func (h *isoHandler) RoundTrip(req *http.Request) (*http.Response, error) {
 
 	fac, dhcpData, err := h.getFacility(req.Context(), ha, h.Backend)
 	if err != nil {
-		log.Info("unable to get the hardware object", "error", err, "mac", ha)
+		log.Info("unable to get the hardware object", "error", err, "mac", ha.String())
 		if apierrors.IsNotFound(err) {
 			return &http.Response{
 				Status: fmt.Sprintf(
@@ -260,30 +324,76 @@ func (h *isoHandler) RoundTrip(req *http.Request) (*http.Response, error) {
 	// The internal.NewSingleHostReverseProxy takes the incoming request url and adds the path to the target (h.SourceISO).
 	// This function is more than a pass through proxy. The MAC address in the url path is required to do hardware lookups using the backend reader
 	// and is not used when making http calls to the target (h.SourceISO). All valid requests are passed through to the target.
-	req.URL.Path = h.parsedURL.Path
+	source := h.sourceURL(req.Context())
+	req.URL.Path = source.Path
 	log = log.WithValues("outboundURL", req.URL.String())
 
+	// Conditions on the entity tag refer to the patched ISO, so they are
+	// evaluated here rather than by the source.
+	patch := getPatch(req.Context())
+	cond := takeConditions(req)
+	if cond.ifRange != "" && req.Header.Get("Range") != "" {
+		// A resumed download only gets the rest of the ISO if the ISO it
+		// started is still the one served.
+		header, err := sourceValidators(req)
+		if err != nil {
+			log.Error(err, "issue getting the source ISO", "sourceIso", source.String())
+			return nil, err
+		}
+		if !matchesETag(cond.ifRange, etag(header, patch), true) {
+			req.Header.Del("Range")
+		}
+	}
+
 	// RoundTripper needs a Transport to execute a HTTP transaction
 	// For our use case the default transport will suffice.
 	resp, err := http.DefaultTransport.RoundTrip(req)
 	if err != nil {
-		log.Error(err, "issue getting the source ISO", "sourceIso", h.SourceISO)
+		log.Error(err, "issue getting the source ISO", "sourceIso", source.String())
 		return nil, err
 	}
 	// by setting this header we are telling the logging middleware to not log its default log message.
 	// we do this because there are a lot of partial content requests and it allow this handler to take care of logging.
 	resp.Header.Set("X-Global-Logging", "false")
 
+	tag := etag(resp.Header, patch)
+	resp.Header.Del("ETag")
+	if tag != "" {
+		resp.Header.Set("ETag", tag)
+	}
+	if resp.StatusCode/100 == 2 {
+		if cond.ifMatch != "" && !matchesETag(cond.ifMatch, tag, true) {
+			resp.Body.Close()
+			return statusResponse(req, http.StatusPreconditionFailed, nil), nil
+		}
+		if cond.ifNoneMatch != "" && matchesETag(cond.ifNoneMatch, tag, false) {
+			resp.Body.Close()
+			header := http.Header{"X-Global-Logging": {"false"}, "ETag": {tag}}
+			if lm := resp.Header.Get("Last-Modified"); lm != "" {
+				header.Set("Last-Modified", lm)
+			}
+			return statusResponse(req, http.StatusNotModified, header), nil
+		}
+	}
+	// Sources that do not support range requests send the whole ISO, which
+	// is cut down to the requested range here.
+	if resp.StatusCode == http.StatusOK && req.Header.Get("Range") != "" {
+		sliceResponse(resp, req.Header.Get("Range"))
+	}
+	if resp.Header.Get("Accept-Ranges") == "" && resp.ContentLength >= 0 {
+		resp.Header.Set("Accept-Ranges", "bytes")
+	}
+
 	if resp.StatusCode == http.StatusPartialContent {
 		// 0.002% of the time we log a 206 request message.
 		// In testing, it was observed that about 3000 HTTP 206 requests are made per ISO mount.
 		// 0.002% gives us about 5 - 10, log messages per ISO mount.
 		// We're optimizing for showing "enough" log messages so that progress can be observed.
 		if p := randomPercentage(100000); p < 0.002 {
-			log.Info("206 status code response", "sourceIso", h.SourceISO, "status", resp.Status)
+			log.Info("206 status code response", "sourceIso", source.String(), "status", resp.Status)
 		}
 	} else {
-		log.Info("response received", "sourceIso", h.SourceISO, "status", resp.Status)
+		log.Info("response received", "sourceIso", source.String(), "status", resp.Status)
 	}
 
 	log.V(1).Info("roundtrip complete")
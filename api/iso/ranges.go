@@ -0,0 +1,227 @@
+package iso
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The ISO is patched per host, so the entity tags of the source ISO do not
+// identify what a client receives. The handler answers with its own entity
+// tag, derived from the source's validators and the patch, and evaluates the
+// conditional requests that refer to it itself. Last-Modified is passed
+// through, so date based conditions are left to the source.
+
+// etag returns the entity tag of the source ISO whose response headers are
+// header once patched with patch, or "" if the source has no validator.
+func etag(header http.Header, patch []byte) string {
+	v := header.Get("ETag")
+	if v == "" {
+		v = header.Get("Last-Modified")
+	}
+	if v == "" {
+		return ""
+	}
+
+	sum := sha256.New()
+	io.WriteString(sum, v)
+	sum.Write([]byte{0})
+	sum.Write(patch)
+	tag := `"` + hex.EncodeToString(sum.Sum(nil)[:16]) + `"`
+	if strings.HasPrefix(v, "W/") || header.Get("ETag") == "" {
+		// A modification date alone is a weak validator.
+		return "W/" + tag
+	}
+
+	return tag
+}
+
+// matchesETag reports whether the If-Match or If-None-Match list matches tag,
+// using the weak comparison unless strong is set.
+func matchesETag(list, tag string, strong bool) bool {
+	if tag == "" {
+		return false
+	}
+	for v := range strings.SplitSeq(list, ",") {
+		v = strings.TrimSpace(v)
+		if v == "*" {
+			return true
+		}
+		if strong && (strings.HasPrefix(v, "W/") || strings.HasPrefix(tag, "W/")) {
+			continue
+		}
+		if strings.TrimPrefix(v, "W/") == strings.TrimPrefix(tag, "W/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// conditions holds the conditional headers of a request that refer to the
+// entity tag of the patched ISO.
+type conditions struct {
+	ifMatch, ifNoneMatch, ifRange string
+}
+
+// takeConditions removes the conditions on the patched ISO's entity tag from
+// req, which is sent to the source ISO, and returns them. An If-Range date is
+// left in place for the source to evaluate.
+func takeConditions(req *http.Request) conditions {
+	c := conditions{
+		ifMatch:     req.Header.Get("If-Match"),
+		ifNoneMatch: req.Header.Get("If-None-Match"),
+		ifRange:     req.Header.Get("If-Range"),
+	}
+	req.Header.Del("If-Match")
+	req.Header.Del("If-None-Match")
+	if isETag(c.ifRange) {
+		req.Header.Del("If-Range")
+	} else {
+		c.ifRange = ""
+	}
+
+	return c
+}
+
+func isETag(v string) bool {
+	return strings.HasPrefix(v, `"`) || strings.HasPrefix(v, "W/")
+}
+
+// sourceValidators returns the response headers of a HEAD request for the
+// source ISO of req.
+func sourceValidators(req *http.Request) (http.Header, error) {
+	head := req.Clone(req.Context())
+	head.Method = http.MethodHead
+	head.Body = http.NoBody
+	head.Header.Del("Range")
+
+	resp, err := http.DefaultTransport.RoundTrip(head)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	return resp.Header, nil
+}
+
+// byteRange is a single range of a Range header, resolved against the size
+// of the representation.
+type byteRange struct {
+	start, length int64
+}
+
+// parseRange resolves the Range header v against size. It returns false for
+// headers it does not support, such as several ranges, which are answered
+// with the full representation, and an error for unsatisfiable ranges.
+func parseRange(v string, size int64) (byteRange, bool, error) {
+	spec, ok := strings.CutPrefix(v, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return byteRange{}, false, nil
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return byteRange{}, false, nil
+	}
+
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil {
+			return byteRange{}, false, nil
+		}
+		if n <= 0 || size == 0 {
+			return byteRange{}, true, fmt.Errorf("unsatisfiable range %q", v)
+		}
+		n = min(n, size)
+		return byteRange{start: size - n, length: n}, true, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return byteRange{}, false, nil
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return byteRange{}, false, nil
+		}
+		end = min(end, size-1)
+	}
+	if start >= size {
+		return byteRange{}, true, fmt.Errorf("unsatisfiable range %q", v)
+	}
+
+	return byteRange{start: start, length: end - start + 1}, true, nil
+}
+
+// sliceResponse turns resp, the full representation of a source ISO that
+// does not support range requests, into the partial response for the Range
+// header v. Responses of unknown size are left as they are.
+func sliceResponse(resp *http.Response, v string) {
+	size := resp.ContentLength
+	if size < 0 {
+		return
+	}
+	r, ok, err := parseRange(v, size)
+	if !ok {
+		return
+	}
+	if err != nil {
+		resp.Body.Close()
+		resp.StatusCode = http.StatusRequestedRangeNotSatisfiable
+		resp.Status = fmt.Sprintf("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+		resp.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		resp.Header.Set("Content-Length", "0")
+		resp.ContentLength = 0
+		resp.Body = http.NoBody
+		return
+	}
+
+	resp.StatusCode = http.StatusPartialContent
+	resp.Status = fmt.Sprintf("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+	resp.Header.Set("Content-Range",
+		fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size))
+	resp.Header.Set("Content-Length", strconv.FormatInt(r.length, 10))
+	resp.ContentLength = r.length
+	resp.Body = &rangeBody{
+		Reader: io.LimitReader(resp.Body, r.length),
+		Closer: resp.Body,
+		skip:   r.start,
+		src:    resp.Body,
+	}
+}
+
+// rangeBody discards the bytes before the range on the first read.
+type rangeBody struct {
+	io.Reader
+	io.Closer
+	skip int64
+	src  io.Reader
+}
+
+func (b *rangeBody) Read(p []byte) (int, error) {
+	if b.skip > 0 {
+		n, err := io.CopyN(io.Discard, b.src, b.skip)
+		b.skip -= n
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return b.Reader.Read(p)
+}
+
+// statusResponse returns a response for req with code and no body.
+func statusResponse(req *http.Request, code int, header http.Header) *http.Response {
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", code, http.StatusText(code)),
+		StatusCode: code,
+		Header:     header,
+		Body:       http.NoBody,
+		Request:    req,
+	}
+}
@@ -0,0 +1,145 @@
+package iso
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestRangeRequests(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	// The source sends the whole ISO whatever the Range header asks for.
+	src := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.Write(content)
+	}))
+	defer src.Close()
+
+	u, err := url.Parse(src.URL + "/hook.iso")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &isoHandler{
+		Logger:      logr.Discard(),
+		Backend:     &mockBackend{},
+		SourceISO:   u.String(),
+		parsedURL:   u,
+		MagicString: magicString,
+	}
+	get := func(header http.Header) *http.Response {
+		r := httptest.NewRequest(http.MethodGet, "/iso/de-ed-be-ef-fe-ed/hook.iso", nil)
+		r.Header = header
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Result()
+	}
+
+	full := get(http.Header{})
+	tag := full.Header.Get("ETag")
+	if tag == "" || tag == `"v1"` {
+		t.Fatalf("ETag = %q, want the entity tag of the patched ISO", tag)
+	}
+	if got := full.Header.Get("Accept-Ranges"); got != "bytes" {
+		t.Errorf("Accept-Ranges = %q, want bytes", got)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		header http.Header
+		code   int
+		body   []byte
+		cr     string
+	}{
+		{
+			name:   "range",
+			header: http.Header{"Range": {"bytes=10-19"}},
+			code:   http.StatusPartialContent,
+			body:   content[10:20],
+			cr:     "bytes 10-19/1000",
+		},
+		{
+			name:   "suffix range",
+			header: http.Header{"Range": {"bytes=-5"}},
+			code:   http.StatusPartialContent,
+			body:   content[995:],
+			cr:     "bytes 995-999/1000",
+		},
+		{
+			name:   "resumed",
+			header: http.Header{"Range": {"bytes=990-"}, "If-Range": {tag}},
+			code:   http.StatusPartialContent,
+			body:   content[990:],
+			cr:     "bytes 990-999/1000",
+		},
+		{
+			name:   "resumed after a change",
+			header: http.Header{"Range": {"bytes=990-"}, "If-Range": {`"v1"`}},
+			code:   http.StatusOK,
+			body:   content,
+		},
+		{
+			name:   "unsatisfiable",
+			header: http.Header{"Range": {"bytes=2000-"}},
+			code:   http.StatusRequestedRangeNotSatisfiable,
+			cr:     "bytes */1000",
+		},
+		{
+			name:   "not modified",
+			header: http.Header{"If-None-Match": {tag}},
+			code:   http.StatusNotModified,
+		},
+		{
+			name:   "precondition failed",
+			header: http.Header{"If-Match": {`"v1"`}},
+			code:   http.StatusPreconditionFailed,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := get(tt.header)
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.code {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.code)
+			}
+			if !bytes.Equal(body, tt.body) {
+				t.Errorf("body = %q, want %q", body, tt.body)
+			}
+			if got := resp.Header.Get("Content-Range"); got != tt.cr {
+				t.Errorf("Content-Range = %q, want %q", got, tt.cr)
+			}
+		})
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	for _, tt := range []struct {
+		v     string
+		want  byteRange
+		ok    bool
+		unsat bool
+	}{
+		{v: "bytes=0-99", want: byteRange{0, 100}, ok: true},
+		{v: "bytes=900-2000", want: byteRange{900, 100}, ok: true},
+		{v: "bytes=-2000", want: byteRange{0, 1000}, ok: true},
+		{v: "bytes=1000-", ok: true, unsat: true},
+		{v: "bytes=0-1,5-6"},
+		{v: "bytes=9-1"},
+		{v: "items=0-1"},
+	} {
+		got, ok, err := parseRange(tt.v, 1000)
+		if ok != tt.ok || (err != nil) != tt.unsat || (err == nil && got != tt.want) {
+			t.Errorf("parseRange(%q) = %v, %v, %v, want %v, %v, unsatisfiable %v",
+				tt.v, got, ok, err, tt.want, tt.ok, tt.unsat)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+// Package phonehome serves the URL that nodes request once their operating
+// system is up, closing the provisioning loop without Ironic.
+package phonehome
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/metal3-community/metal-boot/internal/bootauth"
+	"github.com/metal3-community/metal-boot/internal/config"
+	"github.com/metal3-community/metal-boot/internal/events"
+	"github.com/metal3-community/metal-boot/internal/hoststate"
+	"github.com/metal3-community/metal-boot/internal/logging"
+	"github.com/metal3-community/metal-boot/internal/util"
+)
+
+// Path is the route of the phone-home endpoint.
+const Path = "/v1/boot/{mac}/phone-home"
+
+// SensorsPath is the route nodes post their temperature and throttling
+// state to. It takes the token of the phone-home URL, so a node can derive it
+// by replacing the trailing "phone-home" of that URL with "sensors".
+const SensorsPath = "/v1/boot/{mac}/sensors"
+
+// maxBodySize bounds the form a node may post, such as the one sent by the
+// cloud-init phone_home module.
+const maxBodySize = 64 << 10
+
+// Handler records that a node finished provisioning.
+type Handler struct {
+	logger         *slog.Logger
+	hosts          *hoststate.Store
+	events         *events.Bus
+	tokens         *bootauth.Verifier
+	disableNetboot bool
+}
+
+// New returns the phone-home handler, or nil if phone home is disabled.
+func New(
+	logger *slog.Logger,
+	cfg *config.Config,
+	hosts *hoststate.Store,
+	bus *events.Bus,
+) (*Handler, error) {
+	if !cfg.PhoneHome.Enabled {
+		return nil, nil
+	}
+	if cfg.PhoneHome.TokenSecret == "" {
+		return nil, errors.New("phone_home requires a token_secret")
+	}
+
+	return &Handler{
+		logger: logger,
+		hosts:  hosts,
+		events: bus,
+		tokens: &bootauth.Verifier{
+			Secret: []byte(cfg.PhoneHome.TokenSecret),
+			TTL:    time.Duration(cfg.PhoneHome.TokenTTLSec) * time.Second,
+		},
+		disableNetboot: cfg.PhoneHome.DisableNetboot,
+	}, nil
+}
+
+// URL returns the phone-home URL of mac, with a token, on the server that r
+// was sent to. A nil Handler returns "".
+func (h *Handler) URL(r *http.Request, mac net.HardwareAddr) string {
+	if h == nil {
+		return ""
+	}
+	u := &url.URL{
+		Scheme: "http",
+		Host:   r.Host,
+		Path:   "/v1/boot/" + mac.String() + "/phone-home",
+	}
+	if r.TLS != nil {
+		u.Scheme = "https"
+	}
+
+	return h.tokens.SignURL(u, mac).String()
+}
+
+// ServeHTTP marks the host as provisioned. Both GET and POST are accepted so
+// that a plain curl and cloud-init can call it.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	mac, log, ok := h.authorize(w, r)
+	if !ok {
+		return
+	}
+	message := "phoned home"
+	if hostname := r.PostForm.Get("hostname"); hostname != "" {
+		message = "phoned home as " + hostname
+	}
+
+	if err := h.hosts.RecordPhoneHome(mac, message, h.disableNetboot); err != nil {
+		log.Error("Failed to record phone home", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	h.events.Publish(events.Event{
+		Type:    events.ProvisioningComplete,
+		MAC:     hoststate.Key(mac),
+		IP:      ip,
+		State:   string(hoststate.StateProvisioned),
+		Message: message,
+	})
+
+	log.Info("Host phoned home", "netboot_disabled", h.disableNetboot)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authorize checks the token of a request for the host in its path and
+// parses its form. It writes the error response when it fails.
+func (h *Handler) authorize(w http.ResponseWriter, r *http.Request) (
+	net.HardwareAddr, *slog.Logger, bool,
+) {
+	mac, err := util.ParseMAC(r.PathValue("mac"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, nil, false
+	}
+	log := logging.Logger(r.Context(), h.logger).
+		With("mac", mac.String(), "remote_addr", r.RemoteAddr)
+
+	if err := h.tokens.VerifyToken(mac, r.URL.Query().Get(bootauth.TokenParam)); err != nil {
+		log.Warn("Rejected phone home", "path", r.URL.Path, "error", err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return nil, nil, false
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	return mac, log, true
+}
+
+// ServeSensors records the readings a node posts: cpu_temp, the output of
+// `vcgencmd measure_temp` or the content of
+// /sys/class/thermal/thermal_zone0/temp, and throttled, the output of
+// `vcgencmd get_throttled`. Either may be left out.
+func (h *Handler) ServeSensors(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	mac, log, ok := h.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	var sensors hoststate.Sensors
+	if v := r.PostForm.Get("cpu_temp"); v != "" {
+		celsius, err := parseTemperature(v)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sensors.CPUTemperature = &celsius
+	}
+	if v := r.PostForm.Get("throttled"); v != "" {
+		v = strings.TrimPrefix(strings.TrimSpace(v), "throttled=")
+		bits, err := strconv.ParseUint(v, 0, 32)
+		if err != nil {
+			http.Error(w, "invalid throttled state: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		throttled := uint32(bits)
+		sensors.Throttled = &throttled
+	}
+
+	if err := h.hosts.RecordSensors(mac, sensors); err != nil {
+		log.Error("Failed to record sensors", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	log.Debug("Recorded sensors", "cpu_temp", r.PostForm.Get("cpu_temp"),
+		"throttled", r.PostForm.Get("throttled"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseTemperature reads a temperature in degrees Celsius, given as
+// "temp=48.3'C", "48.3" or, as the kernel reports it, in millidegrees.
+func parseTemperature(v string) (float64, error) {
+	v = strings.TrimSpace(v)
+	v = strings.TrimSuffix(strings.TrimPrefix(v, "temp="), "'C")
+	celsius, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid temperature: %w", err)
+	}
+	if celsius > 1000 {
+		celsius /= 1000
+	}
+
+	return celsius, nil
+}
@@ -0,0 +1,155 @@
+package phonehome
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/metal3-community/metal-boot/internal/config"
+	"github.com/metal3-community/metal-boot/internal/events"
+	"github.com/metal3-community/metal-boot/internal/hoststate"
+)
+
+func TestPhoneHome(t *testing.T) {
+	mac, _ := net.ParseMAC("d8:3a:dd:5a:44:36")
+	hosts, err := hoststate.NewStore("")
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	var published []events.Event
+	bus := &events.Bus{}
+	bus.Subscribe(func(e events.Event) { published = append(published, e) })
+
+	cfg := &config.Config{}
+	if h, err := New(slog.New(slog.DiscardHandler), cfg, hosts, bus); h != nil || err != nil {
+		t.Fatalf("New() while disabled = %v, %v", h, err)
+	}
+	cfg.PhoneHome = config.PhoneHomeConfig{Enabled: true, TokenTTLSec: 60, DisableNetboot: true}
+	if _, err := New(slog.New(slog.DiscardHandler), cfg, hosts, bus); err == nil {
+		t.Fatal("New() without a token secret succeeded")
+	}
+	cfg.PhoneHome.TokenSecret = "secret"
+	h, err := New(slog.New(slog.DiscardHandler), cfg, hosts, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(Path, h)
+
+	u, err := url.Parse(h.URL(httptest.NewRequest(http.MethodGet, "http://10.0.0.1:8080/", nil), mac))
+	if err != nil {
+		t.Fatalf("URL() is not a URL: %v", err)
+	}
+	if u.Host != "10.0.0.1:8080" || u.Path != "/v1/boot/d8:3a:dd:5a:44:36/phone-home" {
+		t.Errorf("URL() = %s", u)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		want   int
+	}{
+		{"no token", http.MethodGet, u.Path, http.StatusForbidden},
+		{"token of another host", http.MethodGet,
+			"/v1/boot/d8:3a:dd:5a:44:37/phone-home?" + u.RawQuery, http.StatusForbidden},
+		{"wrong method", http.MethodDelete, u.RequestURI(), http.StatusMethodNotAllowed},
+		{"cloud-init", http.MethodPost, u.RequestURI(), http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader("hostname=node1"))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+
+	host, err := hosts.Get(mac)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if host.State != hoststate.StateProvisioned || host.Message != "phoned home as node1" ||
+		host.PhonedHomeAt.IsZero() {
+		t.Errorf("host = %+v, want it provisioned", host)
+	}
+	if !hosts.NetbootDisabled(mac) {
+		t.Error("NetbootDisabled() = false after phoning home")
+	}
+	if len(published) != 1 || published[0].Type != events.ProvisioningComplete ||
+		published[0].MAC != "d8:3a:dd:5a:44:36" || published[0].IP != "192.0.2.1" {
+		t.Errorf("published events = %+v", published)
+	}
+
+	if err := hosts.ResetBootAttempts(mac); err != nil {
+		t.Fatalf("ResetBootAttempts() error = %v", err)
+	}
+	if hosts.NetbootDisabled(mac) {
+		t.Error("NetbootDisabled() = true after ResetBootAttempts")
+	}
+}
+
+func TestSensors(t *testing.T) {
+	mac, _ := net.ParseMAC("d8:3a:dd:5a:44:36")
+	hosts, err := hoststate.NewStore("")
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	cfg := &config.Config{PhoneHome: config.PhoneHomeConfig{
+		Enabled: true, TokenSecret: "secret", TokenTTLSec: 60,
+	}}
+	h, err := New(slog.New(slog.DiscardHandler), cfg, hosts, &events.Bus{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(SensorsPath, h.ServeSensors)
+
+	u, _ := url.Parse(h.URL(httptest.NewRequest(http.MethodGet, "http://10.0.0.1/", nil), mac))
+	path := strings.TrimSuffix(u.Path, "phone-home") + "sensors"
+	target := path + "?" + u.RawQuery
+
+	tests := []struct {
+		name   string
+		target string
+		body   string
+		want   int
+	}{
+		{"no token", path, "cpu_temp=40", http.StatusForbidden},
+		{"invalid temperature", target, "cpu_temp=hot", http.StatusBadRequest},
+		{"invalid throttled", target, "throttled=0xzz", http.StatusBadRequest},
+		{"millidegrees", target, "cpu_temp=48312", http.StatusNoContent},
+		{"vcgencmd", target, "throttled=throttled%3D0x50000", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+
+	sensors, ok := hosts.LatestSensors(mac)
+	if !ok || sensors.CPUTemperature == nil || *sensors.CPUTemperature != 48.312 ||
+		sensors.Throttled == nil || *sensors.Throttled != 0x50000 {
+		t.Fatalf("LatestSensors() = %+v, %v", sensors, ok)
+	}
+	if sensors.Current() != 0 || sensors.Occurred() != hoststate.ThrottledUnderVoltage|
+		hoststate.ThrottledThrottling {
+		t.Errorf("Current() = %#x, Occurred() = %#x", sensors.Current(), sensors.Occurred())
+	}
+	if host, _ := hosts.Get(mac); host.State == hoststate.StateProvisioned {
+		t.Error("posting sensors marked the host provisioned")
+	}
+}
@@ -0,0 +1,24 @@
+package redfish
+
+import (
+	"context"
+	"net"
+
+	"github.com/metal3-community/metal-boot/internal/audit"
+)
+
+// systemSettings are the settings of a system a PATCH may change.
+type systemSettings struct {
+	PowerState *PowerState      `json:"PowerState,omitempty"`
+	Boot       *bootWithOptions `json:"Boot,omitempty"`
+}
+
+// auditOldSystem records the settings of the system of mac as the value the
+// request of ctx replaces.
+func (s *RedfishServer) auditOldSystem(ctx context.Context, mac net.HardwareAddr) {
+	sys, err := s.computerSystem(ctx, mac)
+	if err != nil {
+		return
+	}
+	audit.SetOld(ctx, systemSettings{PowerState: sys.PowerState, Boot: sys.Boot})
+}
@@ -0,0 +1,416 @@
+package redfish
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/metal3-community/metal-boot/internal/biosattr"
+	"github.com/metal3-community/metal-boot/internal/util"
+	"github.com/metal3-community/uefi-firmware-manager/edk2"
+	"github.com/metal3-community/uefi-firmware-manager/efi"
+	"github.com/metal3-community/uefi-firmware-manager/manager"
+	"github.com/metal3-community/uefi-firmware-manager/varstore"
+	"go.opentelemetry.io/otel"
+)
+
+const (
+	registriesPath = "/redfish/v1/Registries"
+	// biosAttributeRegistryId names the AttributeRegistry generated from the
+	// Bios attribute definitions.
+	biosAttributeRegistryId = "BiosAttributeRegistry.v1_0_0"
+)
+
+type bios struct {
+	OdataId           string         `json:"@odata.id"`
+	OdataType         string         `json:"@odata.type"`
+	Id                string         `json:"Id"`
+	Name              string         `json:"Name"`
+	AttributeRegistry string         `json:"AttributeRegistry"`
+	Attributes        map[string]any `json:"Attributes"`
+	// Settings points the Bios resource to its pending settings.
+	Settings *redfishSettings `json:"@Redfish.Settings,omitempty"`
+	// SettingsApplyTime is when the pending settings are applied.
+	SettingsApplyTime *settingsApplyTime `json:"@Redfish.SettingsApplyTime,omitempty"`
+}
+
+// bootAttribute is a Bios attribute the firmware keeps as boot options
+// rather than in a variable of its own: the attribute is true while any boot
+// option whose title contains match is active.
+type bootAttribute struct {
+	name        string
+	displayName string
+	helpText    string
+	match       string
+	enable      func(m manager.FirmwareManager, enable bool) error
+}
+
+var bootAttributes = []bootAttribute{
+	{
+		name:        "PXEBootEnable",
+		displayName: "PXE Boot",
+		helpText:    "Enables the PXE boot options of the network interface.",
+		match:       "PXE",
+		enable:      manager.FirmwareManager.EnablePXEBoot,
+	},
+	{
+		name:        "HTTPBootEnable",
+		displayName: "HTTP Boot",
+		helpText:    "Enables the UEFI HTTP boot options of the network interface.",
+		match:       "HTTP",
+		enable:      manager.FirmwareManager.EnableHTTPBoot,
+	},
+}
+
+type messageRegistryFile struct {
+	OdataId   string               `json:"@odata.id"`
+	OdataType string               `json:"@odata.type"`
+	Id        string               `json:"Id"`
+	Name      string               `json:"Name"`
+	Languages []string             `json:"Languages"`
+	Registry  string               `json:"Registry"`
+	Location  []jsonSchemaLocation `json:"Location"`
+}
+
+type attributeRegistry struct {
+	OdataType       string                   `json:"@odata.type"`
+	Id              string                   `json:"Id"`
+	Name            string                   `json:"Name"`
+	Language        string                   `json:"Language"`
+	RegistryVersion string                   `json:"RegistryVersion"`
+	OwningEntity    string                   `json:"OwningEntity"`
+	RegistryEntries attributeRegistryEntries `json:"RegistryEntries"`
+}
+
+type attributeRegistryEntries struct {
+	Attributes []registryAttribute `json:"Attributes"`
+}
+
+type registryAttribute struct {
+	AttributeName string                   `json:"AttributeName"`
+	DisplayName   string                   `json:"DisplayName,omitempty"`
+	HelpText      string                   `json:"HelpText,omitempty"`
+	Type          string                   `json:"Type"`
+	ReadOnly      bool                     `json:"ReadOnly"`
+	DefaultValue  any                      `json:"DefaultValue"`
+	LowerBound    *int64                   `json:"LowerBound,omitempty"`
+	UpperBound    *int64                   `json:"UpperBound,omitempty"`
+	MaxLength     int                      `json:"MaxLength,omitempty"`
+	Value         []registryAttributeValue `json:"Value,omitempty"`
+}
+
+type registryAttributeValue struct {
+	ValueName        string `json:"ValueName"`
+	ValueDisplayName string `json:"ValueDisplayName,omitempty"`
+}
+
+// varList adapts the variables of a varstore that is only read to
+// biosattr.Variables.
+type varList efi.EfiVarList
+
+func (l varList) GetVariable(name string) (*efi.EfiVar, error) {
+	if v, ok := l[name]; ok {
+		return v, nil
+	}
+	return nil, fmt.Errorf("variable not found: %s", name)
+}
+
+func (l varList) SetVariable(name string, value *efi.EfiVar) error {
+	l[name] = value
+	return nil
+}
+
+// read reports whether a boot option of the attribute is active in vars.
+func (a bootAttribute) read(vars biosattr.Variables) bool {
+	var list efi.EfiVarList
+	switch v := vars.(type) {
+	case varList:
+		list = efi.EfiVarList(v)
+	case manager.FirmwareManager:
+		list, _ = v.GetVarList()
+	}
+	entries, err := list.ListBootEntries()
+	if err != nil {
+		return false
+	}
+	for _, e := range entries {
+		if e != nil && e.Attr&efi.LOAD_OPTION_ACTIVE != 0 &&
+			strings.Contains(e.Title.String(), a.match) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readBiosAttributes returns the value of every Bios attribute in vars.
+func (s *RedfishServer) readBiosAttributes(vars biosattr.Variables) map[string]any {
+	values := s.bios.Read(vars)
+	for _, a := range bootAttributes {
+		values[a.name] = a.read(vars)
+	}
+
+	return values
+}
+
+// splitBiosAttributes separates the values of boot attributes from the
+// values of variables, checking both.
+func (s *RedfishServer) splitBiosAttributes(
+	values map[string]any,
+) (map[string]any, map[*bootAttribute]bool, error) {
+	vars := make(map[string]any, len(values))
+	boot := map[*bootAttribute]bool{}
+	for name, value := range values {
+		i := slices.IndexFunc(bootAttributes, func(a bootAttribute) bool { return a.name == name })
+		if i < 0 {
+			vars[name] = value
+			continue
+		}
+		enable, ok := value.(bool)
+		if !ok {
+			return nil, nil, fmt.Errorf("%s: %w: %v is not a boolean",
+				name, biosattr.ErrInvalidValue, value)
+		}
+		boot[&bootAttributes[i]] = enable
+	}
+	if err := s.bios.Check(vars); err != nil {
+		return nil, nil, err
+	}
+
+	return vars, boot, nil
+}
+
+// checkBiosAttributes reports whether applyBiosAttributes would accept
+// values, without changing any.
+func (s *RedfishServer) checkBiosAttributes(values map[string]any) error {
+	_, _, err := s.splitBiosAttributes(values)
+	return err
+}
+
+// applyBiosAttributes sets the Bios attributes of values in the firmware of
+// mac, all of them or, when one is unknown or invalid, none.
+func (s *RedfishServer) applyBiosAttributes(mac net.HardwareAddr, values map[string]any) error {
+	vars, boot, err := s.splitBiosAttributes(values)
+	if err != nil {
+		return err
+	}
+	tx, err := s.beginFirmwareTx(mac)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := s.bios.Apply(tx, vars); err != nil {
+		return err
+	}
+	for a, enable := range boot {
+		if err := a.enable(tx, enable); err != nil {
+			return fmt.Errorf("%s: %w", a.name, err)
+		}
+	}
+
+	return tx.Apply()
+}
+
+// biosErrorStatus is the status of a response to a request with Bios
+// attributes that failed with err.
+func biosErrorStatus(err error) int {
+	if errors.Is(err, biosattr.ErrUnknownAttribute) ||
+		errors.Is(err, biosattr.ErrReadOnly) ||
+		errors.Is(err, biosattr.ErrInvalidValue) {
+		return http.StatusBadRequest
+	}
+
+	return http.StatusInternalServerError
+}
+
+func biosPath(systemId string) string {
+	return fmt.Sprintf("/redfish/v1/Systems/%s/Bios", systemId)
+}
+
+func biosAttributeRegistryPath() string {
+	return registriesPath + "/" + biosAttributeRegistryId + "/Registry"
+}
+
+// systemVariables returns the UEFI variables of systemId. Systems that have
+// no varstore yet report the variables of the embedded firmware, without
+// creating one.
+func (s *RedfishServer) systemVariables(systemId string) (biosattr.Variables, error) {
+	mac, err := s.systemMAC(systemId)
+	if err != nil {
+		return nil, err
+	}
+	f := systemFirmware{component: componentUEFI, mac: mac}
+	if _, err := os.Stat(s.systemFirmwarePath(f)); err == nil {
+		return s.GetEdk2FirmwareManager(mac)
+	}
+
+	vs, err := varstore.New(edk2.RpiEfi)
+	if err != nil {
+		return nil, err
+	}
+	vars, err := vs.GetVarList()
+	if err != nil {
+		return nil, err
+	}
+
+	return varList(vars), nil
+}
+
+// GetBIOS returns the Bios attributes of a system, decoded from its UEFI
+// variables as the attribute definitions describe.
+func (s *RedfishServer) GetBIOS(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer(tracerName).Start(r.Context(), "redfish.RedfishServer.GetBIOS")
+	defer span.End()
+
+	systemId := r.PathValue("systemId")
+	vars, err := s.systemVariables(systemId)
+	if err != nil {
+		s.Log.Error(err, "failed to read BIOS settings", "system_id", systemId)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(bios{
+		OdataId:           biosPath(systemId),
+		OdataType:         odataType(ctx, "Bios"),
+		Id:                "Bios",
+		Name:              "UEFI BIOS Settings",
+		AttributeRegistry: biosAttributeRegistryId,
+		Attributes:        s.readBiosAttributes(vars),
+		Settings:          s.biosSettingsAnnotation(ctx, systemId),
+	})
+}
+
+// UpdateBIOS sets Bios attributes of a system. Either every attribute of the
+// request is written or, when one is unknown or invalid, none is.
+func (s *RedfishServer) UpdateBIOS(w http.ResponseWriter, r *http.Request) {
+	_, span := otel.Tracer(tracerName).Start(r.Context(), "redfish.RedfishServer.UpdateBIOS")
+	defer span.End()
+
+	systemId := r.PathValue("systemId")
+	request, err := decodeBody[BiosUpdateRequest](r)
+	if err != nil {
+		s.Log.Error(err, "failed to parse request body")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
+	mac, err := s.systemMAC(systemId)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+	if err := s.applyBiosAttributes(mac, request.Attributes); err != nil {
+		s.Log.Error(err, "failed to update BIOS settings", "system_id", systemId)
+		w.WriteHeader(biosErrorStatus(err))
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+	s.Log.Info("updated BIOS settings",
+		"system_id", systemId, "attributes", len(request.Attributes))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListRegistries lists the registries the service publishes.
+func (s *RedfishServer) ListRegistries(w http.ResponseWriter, r *http.Request) {
+	_, span := otel.Tracer(tracerName).Start(r.Context(), "redfish.RedfishServer.ListRegistries")
+	defer span.End()
+
+	members := []IdRef{{OdataId: util.Ptr(registriesPath + "/" + biosAttributeRegistryId)}}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(certificateCollection{
+		OdataId:      registriesPath,
+		OdataType:    "#MessageRegistryFileCollection.MessageRegistryFileCollection",
+		Name:         "Registry File Collection",
+		Members:      members,
+		MembersCount: len(members),
+	})
+}
+
+// GetRegistryFile returns where to find one registry.
+func (s *RedfishServer) GetRegistryFile(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer(tracerName).Start(r.Context(), "redfish.RedfishServer.GetRegistryFile")
+	defer span.End()
+
+	id := r.PathValue("registryId")
+	if id != biosAttributeRegistryId {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(redfishError(fmt.Errorf("unknown registry %q", id)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(messageRegistryFile{
+		OdataId:   registriesPath + "/" + id,
+		OdataType: odataType(ctx, "MessageRegistryFile"),
+		Id:        id,
+		Name:      "Bios Attribute Registry File",
+		Languages: []string{"en"},
+		Registry:  "BiosAttributeRegistry.1.0",
+		Location: []jsonSchemaLocation{
+			{Language: "en", Uri: biosAttributeRegistryPath()},
+		},
+	})
+}
+
+// GetBiosAttributeRegistry describes the Bios attributes from the same
+// definitions GetBIOS and UpdateBIOS use.
+func (s *RedfishServer) GetBiosAttributeRegistry(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer(tracerName).
+		Start(r.Context(), "redfish.RedfishServer.GetBiosAttributeRegistry")
+	defer span.End()
+
+	attrs := []registryAttribute{}
+	for _, a := range s.bios.Attributes() {
+		entry := registryAttribute{
+			AttributeName: a.Name,
+			DisplayName:   a.DisplayName,
+			HelpText:      a.HelpText,
+			Type:          a.Type,
+			ReadOnly:      a.ReadOnly,
+			DefaultValue:  a.Default,
+			LowerBound:    a.LowerBound,
+			UpperBound:    a.UpperBound,
+			MaxLength:     a.MaxLength,
+		}
+		for _, v := range a.Values {
+			entry.Value = append(entry.Value, registryAttributeValue{
+				ValueName:        v.Name,
+				ValueDisplayName: v.DisplayName,
+			})
+		}
+		attrs = append(attrs, entry)
+	}
+	for _, a := range bootAttributes {
+		attrs = append(attrs, registryAttribute{
+			AttributeName: a.name,
+			DisplayName:   a.displayName,
+			HelpText:      a.helpText,
+			Type:          biosattr.TypeBoolean,
+			DefaultValue:  false,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(attributeRegistry{
+		OdataType:       odataType(ctx, "AttributeRegistry"),
+		Id:              biosAttributeRegistryId,
+		Name:            "Bios Attribute Registry",
+		Language:        "en",
+		RegistryVersion: "1.0.0",
+		OwningEntity:    "MetalBoot",
+		RegistryEntries: attributeRegistryEntries{Attributes: attrs},
+	})
+}
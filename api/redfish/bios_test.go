@@ -0,0 +1,170 @@
+package redfish
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/metal3-community/metal-boot/internal/biosattr"
+	"github.com/metal3-community/metal-boot/internal/config"
+	"github.com/metal3-community/metal-boot/internal/hoststate"
+)
+
+func TestBios(t *testing.T) {
+	registry, err := biosattr.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &RedfishServer{
+		Config: &config.Config{Tftp: config.TftpConfig{RootDirectory: t.TempDir()}},
+		Log:    logr.Discard(),
+		bios:   registry,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /redfish/v1/Systems/{systemId}/Bios", s.GetBIOS)
+	mux.HandleFunc("PATCH /redfish/v1/Systems/{systemId}/Bios", s.UpdateBIOS)
+	mux.HandleFunc("GET "+biosAttributeRegistryPath(), s.GetBiosAttributeRegistry)
+	path := biosPath("d8:3a:dd:01:02:03")
+
+	get := func() bios {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		var b bios
+		if err := json.NewDecoder(rec.Body).Decode(&b); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("GET %s = %d, %v", path, rec.Code, err)
+		}
+		return b
+	}
+	patch := func(body string) int {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPatch, path, strings.NewReader(body)))
+		return rec.Code
+	}
+
+	if b := get(); b.AttributeRegistry != biosAttributeRegistryId ||
+		b.Attributes["ConsolePref"] != "Auto" {
+		t.Errorf("GET %s = %+v, want the defaults", path, b)
+	}
+
+	body := `{"Attributes": {"BootTimeout": 12, "ConsolePref": "Serial"}}`
+	if code := patch(body); code != http.StatusNoContent {
+		t.Fatalf("PATCH %s = %d, want %d", path, code, http.StatusNoContent)
+	}
+	b := get()
+	if b.Attributes["BootTimeout"] != float64(12) || b.Attributes["ConsolePref"] != "Serial" {
+		t.Errorf("GET %s after PATCH = %v", path, b.Attributes)
+	}
+
+	// One invalid attribute rejects the whole request.
+	body = `{"Attributes": {"BootTimeout": 3, "ConsolePref": "HDMI"}}`
+	if code := patch(body); code != http.StatusBadRequest {
+		t.Fatalf("PATCH %s = %d, want %d", path, code, http.StatusBadRequest)
+	}
+	if got := get().Attributes["BootTimeout"]; got != float64(12) {
+		t.Errorf("BootTimeout = %v after a rejected PATCH, want 12", got)
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, biosAttributeRegistryPath(), nil))
+	var reg attributeRegistry
+	if err := json.NewDecoder(rec.Body).Decode(&reg); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("GET %s = %d, %v", biosAttributeRegistryPath(), rec.Code, err)
+	}
+	want := len(registry.Attributes()) + len(bootAttributes)
+	if n := len(reg.RegistryEntries.Attributes); n != want {
+		t.Errorf("registry has %d attributes, want %d", n, want)
+	}
+}
+
+func TestBiosSettings(t *testing.T) {
+	registry, err := biosattr.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts, err := hoststate.NewStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &RedfishServer{
+		Config: &config.Config{Tftp: config.TftpConfig{RootDirectory: t.TempDir()}},
+		Log:    logr.Discard(),
+		bios:   registry,
+		hosts:  hosts,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /redfish/v1/Systems/{systemId}/Bios", s.GetBIOS)
+	mux.HandleFunc("GET /redfish/v1/Systems/{systemId}/Bios/Settings", s.GetBIOSSettings)
+	mux.HandleFunc("PATCH /redfish/v1/Systems/{systemId}/Bios/Settings", s.UpdateBIOSSettings)
+	system := "d8:3a:dd:01:02:03"
+	mac, _ := net.ParseMAC(system)
+
+	get := func(path string) bios {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		var b bios
+		if err := json.NewDecoder(rec.Body).Decode(&b); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("GET %s = %d, %v", path, rec.Code, err)
+		}
+		return b
+	}
+	patch := func(body string) int {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPatch, biosSettingsPath(system),
+			strings.NewReader(body)))
+		return rec.Code
+	}
+
+	b := get(biosPath(system))
+	if b.Settings == nil || *b.Settings.SettingsObject.OdataId != biosSettingsPath(system) ||
+		b.Attributes["HTTPBootEnable"] != false {
+		t.Errorf("GET Bios = %+v, want a settings object and HTTP boot disabled", b)
+	}
+
+	// Settings wait for the next reset.
+	body := `{"Attributes": {"ConsolePref": "Serial", "HTTPBootEnable": true}}`
+	if code := patch(body); code != http.StatusNoContent {
+		t.Fatalf("PATCH Bios/Settings = %d", code)
+	}
+	if got := get(biosSettingsPath(system)).Attributes; got["ConsolePref"] != "Serial" {
+		t.Errorf("pending settings = %v", got)
+	}
+	if got := get(biosPath(system)).Attributes["ConsolePref"]; got != "Auto" {
+		t.Errorf("ConsolePref = %v before the reset, want Auto", got)
+	}
+	if code := patch(`{"Attributes": {"ConsolePref": "HDMI"}}`); code != http.StatusBadRequest {
+		t.Errorf("PATCH of an invalid setting = %d, want %d", code, http.StatusBadRequest)
+	}
+	body = `{"Attributes": {"BootTimeout": 3},
+		"@Redfish.SettingsApplyTime": {"ApplyTime": "AtMaintenanceWindowStart"}}`
+	if code := patch(body); code != http.StatusBadRequest {
+		t.Errorf("PATCH with an unsupported apply time = %d", code)
+	}
+
+	if err := s.applyPendingBios(mac); err != nil {
+		t.Fatalf("applyPendingBios() error = %v", err)
+	}
+	b = get(biosPath(system))
+	if b.Attributes["ConsolePref"] != "Serial" || b.Attributes["HTTPBootEnable"] != true {
+		t.Errorf("attributes after the reset = %v", b.Attributes)
+	}
+	if got := get(biosSettingsPath(system)).Attributes; len(got) != 0 {
+		t.Errorf("pending settings after the reset = %v, want none", got)
+	}
+
+	body = `{"Attributes": {"BootTimeout": 7},
+		"@Redfish.SettingsApplyTime": {"ApplyTime": "Immediate"}}`
+	if code := patch(body); code != http.StatusNoContent {
+		t.Fatalf("PATCH Bios/Settings = %d", code)
+	}
+	if got := get(biosPath(system)).Attributes["BootTimeout"]; got != float64(7) {
+		t.Errorf("BootTimeout = %v after an immediate PATCH, want 7", got)
+	}
+}
@@ -0,0 +1,174 @@
+package redfish
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/metal3-community/metal-boot/internal/util"
+	"go.opentelemetry.io/otel"
+)
+
+// Times the pending Bios settings of a system are applied at.
+const (
+	applyTimeImmediate = "Immediate"
+	applyTimeOnReset   = "OnReset"
+)
+
+var errBiosSettingsUnavailable = errors.New(
+	"BIOS settings applied on reset require the host state store")
+
+// redfishSettings is the @Redfish.Settings annotation of a resource with
+// pending settings.
+type redfishSettings struct {
+	OdataType           string   `json:"@odata.type"`
+	SettingsObject      IdRef    `json:"SettingsObject"`
+	SupportedApplyTimes []string `json:"SupportedApplyTimes"`
+	// Time is when the pending settings were last changed.
+	Time string `json:"Time,omitempty"`
+}
+
+type settingsApplyTime struct {
+	OdataType string `json:"@odata.type,omitempty"`
+	ApplyTime string `json:"ApplyTime"`
+}
+
+// BiosSettingsRequest is a PATCH of the pending Bios settings of a system.
+type BiosSettingsRequest struct {
+	Attributes        map[string]any     `json:"Attributes,omitempty"`
+	SettingsApplyTime *settingsApplyTime `json:"@Redfish.SettingsApplyTime,omitempty"`
+}
+
+func biosSettingsPath(systemId string) string {
+	return biosPath(systemId) + "/Settings"
+}
+
+// biosSettingsAnnotation links the Bios resource of a system to its pending
+// settings.
+func (s *RedfishServer) biosSettingsAnnotation(
+	ctx context.Context,
+	systemId string,
+) *redfishSettings {
+	settings := &redfishSettings{
+		OdataType:           odataType(ctx, "Settings"),
+		SettingsObject:      IdRef{OdataId: util.Ptr(biosSettingsPath(systemId))},
+		SupportedApplyTimes: []string{applyTimeImmediate, applyTimeOnReset},
+	}
+	if mac, err := s.systemMAC(systemId); err == nil {
+		if pending, ok := s.hosts.PendingBiosSettings(mac); ok {
+			settings.Time = pending.RequestedAt.Format(time.RFC3339)
+		}
+	}
+
+	return settings
+}
+
+// GetBIOSSettings returns the Bios attributes of a system waiting for its
+// next reset.
+func (s *RedfishServer) GetBIOSSettings(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer(tracerName).Start(r.Context(), "redfish.RedfishServer.GetBIOSSettings")
+	defer span.End()
+
+	systemId := r.PathValue("systemId")
+	mac, err := s.systemMAC(systemId)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+	attributes := map[string]any{}
+	if pending, ok := s.hosts.PendingBiosSettings(mac); ok {
+		attributes = pending.Attributes
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(bios{
+		OdataId:           biosSettingsPath(systemId),
+		OdataType:         odataType(ctx, "Bios"),
+		Id:                "Settings",
+		Name:              "UEFI BIOS Pending Settings",
+		AttributeRegistry: biosAttributeRegistryId,
+		Attributes:        attributes,
+		SettingsApplyTime: &settingsApplyTime{
+			OdataType: "#" + schemaID(ctx, "Settings") + ".PreferredApplyTime",
+			ApplyTime: applyTimeOnReset,
+		},
+	})
+}
+
+// UpdateBIOSSettings changes the pending Bios settings of a system. They are
+// applied at its next reset or, with an Immediate apply time, right away.
+// The attributes are checked either way, so a bad request stages nothing.
+func (s *RedfishServer) UpdateBIOSSettings(w http.ResponseWriter, r *http.Request) {
+	_, span := otel.Tracer(tracerName).
+		Start(r.Context(), "redfish.RedfishServer.UpdateBIOSSettings")
+	defer span.End()
+
+	systemId := r.PathValue("systemId")
+	request, err := decodeBody[BiosSettingsRequest](r)
+	if err != nil {
+		s.Log.Error(err, "failed to parse request body")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+	mac, err := s.systemMAC(systemId)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
+	applyTime := applyTimeOnReset
+	if request.SettingsApplyTime != nil {
+		applyTime = request.SettingsApplyTime.ApplyTime
+	}
+	switch applyTime {
+	case applyTimeImmediate:
+		err = s.applyBiosAttributes(mac, request.Attributes)
+	case applyTimeOnReset:
+		if s.hosts == nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(redfishError(errBiosSettingsUnavailable))
+			return
+		}
+		if err = s.checkBiosAttributes(request.Attributes); err == nil {
+			err = s.hosts.StageBiosSettings(mac, request.Attributes)
+		}
+	default:
+		err := fmt.Errorf("unsupported apply time %q", applyTime)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+	if err != nil {
+		s.Log.Error(err, "failed to update BIOS settings", "system_id", systemId)
+		w.WriteHeader(biosErrorStatus(err))
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+	s.Log.Info("updated BIOS settings", "system_id", systemId,
+		"attributes", len(request.Attributes), "applyTime", applyTime)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// applyPendingBios writes the pending Bios settings of mac to its firmware,
+// as the system is about to boot.
+func (s *RedfishServer) applyPendingBios(mac net.HardwareAddr) error {
+	pending, ok := s.hosts.PendingBiosSettings(mac)
+	if !ok {
+		return nil
+	}
+	if err := s.applyBiosAttributes(mac, pending.Attributes); err != nil {
+		return err
+	}
+	s.Log.Info("applied pending BIOS settings", "mac", mac.String(),
+		"attributes", len(pending.Attributes))
+
+	return s.hosts.ClearBiosSettings(mac)
+}
@@ -0,0 +1,268 @@
+package redfish
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/metal3-community/metal-boot/internal/util"
+	"github.com/metal3-community/uefi-firmware-manager/efi"
+	"github.com/metal3-community/uefi-firmware-manager/types"
+	"go.opentelemetry.io/otel"
+)
+
+// bootWithOptions extends the generated Boot model with the BootOptions link
+// and the BootOrder, which the OpenAPI document does not describe.
+type bootWithOptions struct {
+	*Boot
+	BootOptions *IdRef   `json:"BootOptions,omitempty"`
+	BootOrder   []string `json:"BootOrder,omitempty"`
+}
+
+// bootOption is a BootXXXX variable of the system's UEFI varstore.
+type bootOption struct {
+	OdataId             string `json:"@odata.id"`
+	OdataType           string `json:"@odata.type"`
+	Id                  string `json:"Id"`
+	Name                string `json:"Name"`
+	BootOptionReference string `json:"BootOptionReference"`
+	DisplayName         string `json:"DisplayName"`
+	UefiDevicePath      string `json:"UefiDevicePath"`
+	BootOptionEnabled   bool   `json:"BootOptionEnabled"`
+}
+
+// bootOptionPatch holds the writable properties of a bootOption.
+type bootOptionPatch struct {
+	BootOptionEnabled *bool `json:"BootOptionEnabled"`
+}
+
+var errUnknownBootOption = errors.New("unknown boot option")
+
+func bootOptionsPath(systemId string) string {
+	return fmt.Sprintf("/redfish/v1/Systems/%s/BootOptions", systemId)
+}
+
+func newBootOption(ctx context.Context, systemId string, e types.BootEntry) bootOption {
+	return bootOption{
+		OdataId:             bootOptionsPath(systemId) + "/" + e.ID,
+		OdataType:           odataType(ctx, "BootOption"),
+		Id:                  e.ID,
+		Name:                "Boot Option " + e.ID,
+		BootOptionReference: "Boot" + e.ID,
+		DisplayName:         e.Name,
+		UefiDevicePath:      e.DevPath,
+		BootOptionEnabled:   e.Enabled,
+	}
+}
+
+// systemBoot adds the BootOptions link and the BootOrder of the system's
+// varstore to boot. The BootOrder is left out when the system has no varstore
+// yet or it cannot be read.
+func (s *RedfishServer) systemBoot(systemId string, boot *Boot) *bootWithOptions {
+	b := &bootWithOptions{
+		Boot:        boot,
+		BootOptions: &IdRef{OdataId: util.Ptr(bootOptionsPath(systemId))},
+	}
+
+	mac, err := s.systemMAC(systemId)
+	if err != nil {
+		return b
+	}
+	// Opening the varstore of a system without one creates it, which a GET
+	// of the system should not do.
+	f := systemFirmware{component: componentUEFI, mac: mac}
+	if _, err := os.Stat(s.systemFirmwarePath(f)); err != nil {
+		return b
+	}
+	firmwareMgr, err := s.GetEdk2FirmwareManager(mac)
+	if err != nil {
+		return b
+	}
+	if order, err := firmwareMgr.GetBootOrder(); err == nil {
+		for _, id := range order {
+			b.BootOrder = append(b.BootOrder, "Boot"+id)
+		}
+	}
+
+	return b
+}
+
+// bootEntries returns the boot entries of systemId sorted by id.
+func (s *RedfishServer) bootEntries(systemId string) ([]types.BootEntry, error) {
+	mac, err := s.systemMAC(systemId)
+	if err != nil {
+		return nil, err
+	}
+	firmwareMgr, err := s.GetEdk2FirmwareManager(mac)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := firmwareMgr.GetBootEntries()
+	if err != nil {
+		return nil, err
+	}
+	for i, e := range entries {
+		if v, err := firmwareMgr.GetVariable("Boot" + e.ID); err == nil {
+			entries[i].Enabled = loadOptionActive(v)
+		}
+	}
+	slices.SortFunc(entries, func(a, b types.BootEntry) int { return strings.Compare(a.ID, b.ID) })
+
+	return entries, nil
+}
+
+// loadOptionActive reports whether the LOAD_OPTION_ACTIVE attribute of the
+// EFI_LOAD_OPTION held by a BootXXXX variable is set. GetBootEntries derives
+// Enabled from the attributes of the variable itself, which are always
+// non-volatile and so always read as active.
+func loadOptionActive(v *efi.EfiVar) bool {
+	return len(v.Data) >= 4 && binary.LittleEndian.Uint32(v.Data)&efi.LOAD_OPTION_ACTIVE != 0
+}
+
+// ListBootOptions lists the BootXXXX variables of a system.
+func (s *RedfishServer) ListBootOptions(w http.ResponseWriter, r *http.Request) {
+	_, span := otel.Tracer(tracerName).Start(r.Context(), "redfish.RedfishServer.ListBootOptions")
+	defer span.End()
+
+	systemId := r.PathValue("systemId")
+	entries, err := s.bootEntries(systemId)
+	if err != nil {
+		s.Log.Error(err, "failed to read boot options", "system_id", systemId)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
+	members := make([]IdRef, 0, len(entries))
+	for _, e := range entries {
+		members = append(members, IdRef{OdataId: util.Ptr(bootOptionsPath(systemId) + "/" + e.ID)})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(certificateCollection{
+		OdataId:      bootOptionsPath(systemId),
+		OdataType:    "#BootOptionCollection.BootOptionCollection",
+		Name:         "Boot Options",
+		Members:      members,
+		MembersCount: len(members),
+	})
+}
+
+// GetBootOption returns one BootXXXX variable of a system.
+func (s *RedfishServer) GetBootOption(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer(tracerName).Start(r.Context(), "redfish.RedfishServer.GetBootOption")
+	defer span.End()
+
+	systemId, id := r.PathValue("systemId"), r.PathValue("bootOptionId")
+	entry, status, err := s.bootEntry(systemId, id)
+	if err != nil {
+		s.Log.Error(err, "failed to read boot option", "system_id", systemId, "bootOption", id)
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newBootOption(ctx, systemId, entry))
+}
+
+// SetBootOption enables or disables one BootXXXX variable of a system.
+func (s *RedfishServer) SetBootOption(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer(tracerName).Start(r.Context(), "redfish.RedfishServer.SetBootOption")
+	defer span.End()
+
+	systemId, id := r.PathValue("systemId"), r.PathValue("bootOptionId")
+	patch, err := decodeBody[bootOptionPatch](r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
+	entry, status, err := s.bootEntry(systemId, id)
+	if err != nil {
+		s.Log.Error(err, "failed to read boot option", "system_id", systemId, "bootOption", id)
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
+	if patch.BootOptionEnabled != nil && *patch.BootOptionEnabled != entry.Enabled {
+		entry.Enabled = *patch.BootOptionEnabled
+		if err := s.setBootEntryEnabled(systemId, entry.ID, entry.Enabled); err != nil {
+			s.Log.Error(err, "failed to update boot option",
+				"system_id", systemId, "bootOption", id)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(redfishError(err))
+			return
+		}
+		s.Log.Info("updated boot option",
+			"system_id", systemId,
+			"bootOption", id,
+			"enabled", entry.Enabled)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newBootOption(ctx, systemId, entry))
+}
+
+// bootEntry returns the boot entry id of systemId, and the status to answer
+// with when it cannot.
+func (s *RedfishServer) bootEntry(systemId, id string) (types.BootEntry, int, error) {
+	entries, err := s.bootEntries(systemId)
+	if err != nil {
+		return types.BootEntry{}, http.StatusInternalServerError, err
+	}
+	id = strings.ToUpper(strings.TrimPrefix(id, "Boot"))
+	i := slices.IndexFunc(entries, func(e types.BootEntry) bool { return e.ID == id })
+	if i < 0 {
+		err := fmt.Errorf("%w: %s", errUnknownBootOption, id)
+		return types.BootEntry{}, http.StatusNotFound, err
+	}
+
+	return entries[i], http.StatusOK, nil
+}
+
+// setBootEntryEnabled sets the LOAD_OPTION_ACTIVE attribute of the boot
+// entry id of systemId. The variable is patched in place rather than through
+// UpdateBootEntry, which rebuilds it from the string form of its device path
+// and loses the nodes it cannot parse back.
+func (s *RedfishServer) setBootEntryEnabled(systemId, id string, enabled bool) error {
+	mac, err := s.systemMAC(systemId)
+	if err != nil {
+		return err
+	}
+	firmwareMgr, err := s.beginFirmwareTx(mac)
+	if err != nil {
+		return err
+	}
+	defer firmwareMgr.Rollback()
+
+	v, err := firmwareMgr.GetVariable("Boot" + id)
+	if err != nil {
+		return err
+	}
+	if len(v.Data) < 4 {
+		return fmt.Errorf("boot option %s is too short", id)
+	}
+
+	attr := binary.LittleEndian.Uint32(v.Data)
+	if enabled {
+		attr |= efi.LOAD_OPTION_ACTIVE
+	} else {
+		attr &^= efi.LOAD_OPTION_ACTIVE
+	}
+	v.Data = slices.Clone(v.Data)
+	binary.LittleEndian.PutUint32(v.Data, attr)
+	if err := firmwareMgr.SetVariable("Boot"+id, v); err != nil {
+		return err
+	}
+
+	return firmwareMgr.Apply()
+}
@@ -0,0 +1,66 @@
+package redfish
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/metal3-community/metal-boot/internal/config"
+)
+
+func TestBootOptions(t *testing.T) {
+	s := &RedfishServer{
+		Config: &config.Config{Tftp: config.TftpConfig{RootDirectory: t.TempDir()}},
+		Log:    logr.Discard(),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /redfish/v1/Systems/{systemId}/BootOptions", s.ListBootOptions)
+	mux.HandleFunc("GET /redfish/v1/Systems/{systemId}/BootOptions/{bootOptionId}", s.GetBootOption)
+	mux.HandleFunc("PATCH /redfish/v1/Systems/{systemId}/BootOptions/{bootOptionId}", s.SetBootOption)
+	path := bootOptionsPath("d8:3a:dd:01:02:03")
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	var list certificateCollection
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("GET %s = %d, %v", path, rec.Code, err)
+	}
+	if list.MembersCount == 0 {
+		t.Fatal("the embedded firmware has no boot options")
+	}
+	member := *list.Members[0].OdataId
+
+	get := func() bootOption {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, member, nil))
+		var opt bootOption
+		if err := json.NewDecoder(rec.Body).Decode(&opt); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("GET %s = %d, %v", member, rec.Code, err)
+		}
+		return opt
+	}
+	before := get()
+	if before.BootOptionReference != "Boot"+before.Id || before.UefiDevicePath == "" {
+		t.Errorf("GET %s = %+v", member, before)
+	}
+
+	body := `{"BootOptionEnabled": ` + map[bool]string{true: "false", false: "true"}[before.BootOptionEnabled] + `}`
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPatch, member, strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PATCH %s = %d: %s", member, rec.Code, rec.Body)
+	}
+	if after := get(); after.BootOptionEnabled == before.BootOptionEnabled {
+		t.Errorf("BootOptionEnabled = %v after PATCH, want %v", after.BootOptionEnabled, !before.BootOptionEnabled)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path+"/BEEF", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET of an unknown boot option = %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,386 @@
+package redfish
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/metal3-community/metal-boot/internal/tlscert"
+	"github.com/metal3-community/metal-boot/internal/util"
+	"go.opentelemetry.io/otel"
+)
+
+const (
+	certificateServicePath = "/redfish/v1/CertificateService"
+	replaceCertificatePath = certificateServicePath + "/Actions/CertificateService.ReplaceCertificate"
+	generateCSRPath        = certificateServicePath + "/Actions/CertificateService.GenerateCSR"
+
+	// serviceManagerId is the manager that represents metal-boot itself, as
+	// opposed to the per-system managers.
+	serviceManagerId      = "metal-boot"
+	httpsCertificatesPath = "/redfish/v1/Managers/" + serviceManagerId + "/NetworkProtocol/HTTPS/Certificates"
+	httpsCertificatePath  = httpsCertificatesPath + "/1"
+)
+
+var errCertificatesDisabled = errors.New("TLS is not enabled")
+
+// rootWithCertificateService extends the generated Root model with the
+// CertificateService, TelemetryService, EventService, JsonSchemas,
+// Registries and SessionService links and ProtocolFeaturesSupported, which
+// the OpenAPI document does not describe.
+type rootWithCertificateService struct {
+	Root
+	Chassis                   IdRef            `json:"Chassis"`
+	JsonSchemas               IdRef            `json:"JsonSchemas"`
+	Registries                IdRef            `json:"Registries"`
+	SessionService            IdRef            `json:"SessionService"`
+	Tasks                     IdRef            `json:"Tasks"`
+	Links                     rootLinks        `json:"Links"`
+	CertificateService        *IdRef           `json:"CertificateService,omitempty"`
+	TelemetryService          *IdRef           `json:"TelemetryService,omitempty"`
+	EventService              *IdRef           `json:"EventService,omitempty"`
+	ProtocolFeaturesSupported protocolFeatures `json:"ProtocolFeaturesSupported"`
+}
+
+// rootLinks holds the Sessions link clients POST to for a session.
+type rootLinks struct {
+	Sessions IdRef `json:"Sessions"`
+}
+
+// protocolFeatures advertises the optional query parameters the service
+// supports; $filter and $expand are supported on the Systems collection.
+type protocolFeatures struct {
+	FilterQuery bool        `json:"FilterQuery"`
+	ExpandQuery expandQuery `json:"ExpandQuery"`
+}
+
+type expandQuery struct {
+	ExpandAll bool `json:"ExpandAll"`
+	NoLinks   bool `json:"NoLinks"`
+	Levels    bool `json:"Levels"`
+	MaxLevels int  `json:"MaxLevels"`
+}
+
+type certificateService struct {
+	OdataId              string                    `json:"@odata.id"`
+	OdataType            string                    `json:"@odata.type"`
+	Id                   string                    `json:"Id"`
+	Name                 string                    `json:"Name"`
+	Actions              certificateServiceActions `json:"Actions"`
+	CertificateLocations IdRef                     `json:"CertificateLocations"`
+}
+
+type certificateServiceActions struct {
+	ReplaceCertificate actionTarget `json:"#CertificateService.ReplaceCertificate"`
+	GenerateCSR        actionTarget `json:"#CertificateService.GenerateCSR"`
+}
+
+type actionTarget struct {
+	Target string `json:"target"`
+}
+
+type certificateCollection struct {
+	OdataId      string  `json:"@odata.id"`
+	OdataType    string  `json:"@odata.type"`
+	Name         string  `json:"Name"`
+	Members      []IdRef `json:"Members"`
+	MembersCount int     `json:"Members@odata.count"`
+}
+
+type certificate struct {
+	OdataId           string             `json:"@odata.id"`
+	OdataType         string             `json:"@odata.type"`
+	Id                string             `json:"Id"`
+	Name              string             `json:"Name"`
+	CertificateString string             `json:"CertificateString"`
+	CertificateType   string             `json:"CertificateType"`
+	Issuer            certificateSubject `json:"Issuer"`
+	Subject           certificateSubject `json:"Subject"`
+	ValidNotBefore    time.Time          `json:"ValidNotBefore"`
+	ValidNotAfter     time.Time          `json:"ValidNotAfter"`
+	SerialNumber      string             `json:"SerialNumber"`
+	Fingerprint       string             `json:"Fingerprint"`
+	FingerprintHash   string             `json:"FingerprintHashAlgorithm"`
+	// UefiSignatureOwner is the owner GUID of a certificate in a Secure Boot
+	// database.
+	UefiSignatureOwner string `json:"UefiSignatureOwner,omitempty"`
+}
+
+type certificateSubject struct {
+	CommonName   string `json:"CommonName,omitempty"`
+	Organization string `json:"Organization,omitempty"`
+}
+
+// ReplaceCertificateRequest is the body of CertificateService.ReplaceCertificate.
+type ReplaceCertificateRequest struct {
+	CertificateString string `json:"CertificateString"`
+	CertificateType   string `json:"CertificateType"`
+	CertificateUri    IdRef  `json:"CertificateUri"`
+}
+
+// GenerateCSRRequest is the body of CertificateService.GenerateCSR.
+type GenerateCSRRequest struct {
+	CertificateCollection IdRef    `json:"CertificateCollection"`
+	CommonName            string   `json:"CommonName"`
+	Organization          string   `json:"Organization,omitempty"`
+	OrganizationalUnit    string   `json:"OrganizationalUnit,omitempty"`
+	City                  string   `json:"City,omitempty"`
+	State                 string   `json:"State,omitempty"`
+	Country               string   `json:"Country,omitempty"`
+	AlternativeNames      []string `json:"AlternativeNames,omitempty"`
+	KeyPairAlgorithm      string   `json:"KeyPairAlgorithm,omitempty"`
+	KeyBitLength          int      `json:"KeyBitLength,omitempty"`
+}
+
+type generateCSRResponse struct {
+	CSRString             string `json:"CSRString"`
+	CertificateCollection IdRef  `json:"CertificateCollection"`
+}
+
+// requireCertificates writes a 404 when TLS certificate management is off.
+func (s *RedfishServer) requireCertificates(w http.ResponseWriter) bool {
+	if s.certs == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(redfishError(errCertificatesDisabled))
+		return false
+	}
+
+	return true
+}
+
+// GetCertificateService returns the CertificateService resource.
+func (s *RedfishServer) GetCertificateService(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer(tracerName).Start(r.Context(), "redfish.RedfishServer.GetCertificateService")
+	defer span.End()
+
+	if !s.requireCertificates(w) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(certificateService{
+		OdataId:   certificateServicePath,
+		OdataType: odataType(ctx, "CertificateService"),
+		Id:        "CertificateService",
+		Name:      "Certificate Service",
+		Actions: certificateServiceActions{
+			ReplaceCertificate: actionTarget{Target: replaceCertificatePath},
+			GenerateCSR:        actionTarget{Target: generateCSRPath},
+		},
+		CertificateLocations: IdRef{
+			OdataId: util.Ptr(certificateServicePath + "/CertificateLocations"),
+		},
+	})
+}
+
+// GetCertificateLocations lists every certificate managed by the service.
+func (s *RedfishServer) GetCertificateLocations(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer(tracerName).Start(r.Context(), "redfish.RedfishServer.GetCertificateLocations")
+	defer span.End()
+
+	if !s.requireCertificates(w) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"@odata.id":   certificateServicePath + "/CertificateLocations",
+		"@odata.type": odataType(ctx, "CertificateLocations"),
+		"Id":          "CertificateLocations",
+		"Name":        "Certificate Locations",
+		"Links": map[string]any{
+			"Certificates":             []IdRef{{OdataId: util.Ptr(httpsCertificatePath)}},
+			"Certificates@odata.count": 1,
+		},
+	})
+}
+
+// GetHTTPSCertificates returns the collection holding the server certificate.
+func (s *RedfishServer) GetHTTPSCertificates(w http.ResponseWriter, r *http.Request) {
+	_, span := otel.Tracer(tracerName).Start(r.Context(), "redfish.RedfishServer.GetHTTPSCertificates")
+	defer span.End()
+
+	if !s.requireCertificates(w) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(certificateCollection{
+		OdataId:      httpsCertificatesPath,
+		OdataType:    "#CertificateCollection.CertificateCollection",
+		Name:         "HTTPS Certificates",
+		Members:      []IdRef{{OdataId: util.Ptr(httpsCertificatePath)}},
+		MembersCount: 1,
+	})
+}
+
+// GetHTTPSCertificate returns the certificate currently served over HTTPS.
+func (s *RedfishServer) GetHTTPSCertificate(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer(tracerName).Start(r.Context(), "redfish.RedfishServer.GetHTTPSCertificate")
+	defer span.End()
+
+	if !s.requireCertificates(w) {
+		return
+	}
+
+	leaf, err := s.certs.Leaf()
+	if err != nil {
+		s.Log.Error(err, "failed to parse server certificate")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+	cert := certificateResource(ctx, httpsCertificatePath, "1", "HTTPS Certificate", leaf)
+	cert.CertificateString = string(s.certs.CertificatePEM())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cert)
+}
+
+// certificateResource describes the X.509 certificate c at odataId.
+func certificateResource(
+	ctx context.Context,
+	odataId, id, name string,
+	c *x509.Certificate,
+) certificate {
+	fingerprint := sha256.Sum256(c.Raw)
+
+	return certificate{
+		OdataId:   odataId,
+		OdataType: odataType(ctx, "Certificate"),
+		Id:        id,
+		Name:      name,
+		CertificateString: string(pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: c.Raw,
+		})),
+		CertificateType: "PEM",
+		Issuer: certificateSubject{
+			CommonName:   c.Issuer.CommonName,
+			Organization: strings.Join(c.Issuer.Organization, ", "),
+		},
+		Subject: certificateSubject{
+			CommonName:   c.Subject.CommonName,
+			Organization: strings.Join(c.Subject.Organization, ", "),
+		},
+		ValidNotBefore:  c.NotBefore,
+		ValidNotAfter:   c.NotAfter,
+		SerialNumber:    c.SerialNumber.Text(16),
+		Fingerprint:     strings.ToUpper(hex.EncodeToString(fingerprint[:])),
+		FingerprintHash: "TPM_ALG_SHA256",
+	}
+}
+
+// ReplaceCertificate installs a new server certificate. It takes effect on the
+// next TLS handshake. Only admins may replace it.
+func (s *RedfishServer) ReplaceCertificate(w http.ResponseWriter, r *http.Request) {
+	_, span := otel.Tracer(tracerName).Start(r.Context(), "redfish.RedfishServer.ReplaceCertificate")
+	defer span.End()
+
+	if !s.requireAdmin(w, r) || !s.requireCertificates(w) {
+		return
+	}
+
+	req, err := decodeBody[ReplaceCertificateRequest](r)
+	if err != nil {
+		s.Log.Error(err, "error decoding request")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
+	if req.CertificateType != "" && req.CertificateType != "PEM" && req.CertificateType != "PEMchain" {
+		err := errors.New("unsupported CertificateType: " + req.CertificateType)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+	if uri := req.CertificateUri.OdataId; uri != nil && *uri != "" && *uri != httpsCertificatePath {
+		err := errors.New("unknown certificate: " + *uri)
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
+	if err := s.certs.Replace([]byte(req.CertificateString)); err != nil {
+		s.Log.Error(err, "failed to replace server certificate")
+		status := http.StatusInternalServerError
+		if errors.Is(err, tlscert.ErrInvalid) || errors.Is(err, tlscert.ErrKeyMismatch) {
+			status = http.StatusBadRequest
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
+	s.Log.Info("server certificate replaced")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GenerateCSR creates a new key pair and returns a CSR for it. The key is
+// used once a matching certificate is passed to ReplaceCertificate. Only admins
+// may generate one.
+func (s *RedfishServer) GenerateCSR(w http.ResponseWriter, r *http.Request) {
+	_, span := otel.Tracer(tracerName).Start(r.Context(), "redfish.RedfishServer.GenerateCSR")
+	defer span.End()
+
+	if !s.requireAdmin(w, r) || !s.requireCertificates(w) {
+		return
+	}
+
+	req, err := decodeBody[GenerateCSRRequest](r)
+	if err != nil {
+		s.Log.Error(err, "error decoding request")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
+	var algorithm string
+	switch req.KeyPairAlgorithm {
+	case "", "TPM_ALG_ECDSA", "TPM_ALG_ECC":
+		algorithm = "ECDSA"
+	case "TPM_ALG_RSA":
+		algorithm = "RSA"
+	default:
+		err := errors.New("unsupported KeyPairAlgorithm: " + req.KeyPairAlgorithm)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
+	csr, err := s.certs.GenerateCSR(tlscert.CSRRequest{
+		CommonName:         req.CommonName,
+		Organization:       req.Organization,
+		OrganizationalUnit: req.OrganizationalUnit,
+		City:               req.City,
+		State:              req.State,
+		Country:            req.Country,
+		AlternativeNames:   req.AlternativeNames,
+		KeyAlgorithm:       algorithm,
+		KeyBitLength:       req.KeyBitLength,
+	})
+	if err != nil {
+		s.Log.Error(err, "failed to generate CSR")
+		status := http.StatusInternalServerError
+		if errors.Is(err, tlscert.ErrInvalid) {
+			status = http.StatusBadRequest
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(generateCSRResponse{
+		CSRString:             string(csr),
+		CertificateCollection: IdRef{OdataId: util.Ptr(httpsCertificatesPath)},
+	})
+}
@@ -0,0 +1,68 @@
+package redfish
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/metal3-community/metal-boot/internal/adminauth"
+	"github.com/metal3-community/metal-boot/internal/tlscert"
+)
+
+func TestCertificateWritesRequireAdmin(t *testing.T) {
+	dir := t.TempDir()
+	certs, err := tlscert.NewStore(filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &RedfishServer{Log: logr.Discard(), certs: certs}
+	before := string(certs.CertificatePEM())
+
+	csr := `{"CommonName": "metal-boot", "CertificateCollection": {"@odata.id": "` +
+		httpsCertificatesPath + `"}}`
+	actions := []struct {
+		name    string
+		handler http.HandlerFunc
+		path    string
+		body    string
+		// admin is the status of an admin's request.
+		admin int
+	}{
+		{name: "GenerateCSR", handler: s.GenerateCSR, path: generateCSRPath, body: csr, admin: http.StatusOK},
+		{name: "ReplaceCertificate", handler: s.ReplaceCertificate, path: replaceCertificatePath, body: `{}`, admin: http.StatusBadRequest},
+	}
+	principals := []struct {
+		name      string
+		principal *adminauth.Principal
+		want      int
+	}{
+		{name: "anonymous", want: http.StatusUnauthorized},
+		{name: "viewer", principal: &adminauth.Principal{Role: adminauth.RoleViewer}, want: http.StatusForbidden},
+		{name: "admin", principal: &adminauth.Principal{Role: adminauth.RoleAdmin}},
+	}
+	for _, a := range actions {
+		for _, p := range principals {
+			t.Run(a.name+"/"+p.name, func(t *testing.T) {
+				req := httptest.NewRequest(http.MethodPost, a.path, strings.NewReader(a.body))
+				want := p.want
+				if p.principal != nil {
+					req = req.WithContext(adminauth.WithPrincipal(req.Context(), p.principal))
+				}
+				if want == 0 {
+					want = a.admin
+				}
+				rec := httptest.NewRecorder()
+				a.handler(rec, req)
+				if rec.Code != want {
+					t.Errorf("status = %d, want %d: %s", rec.Code, want, rec.Body)
+				}
+			})
+		}
+	}
+	if got := string(certs.CertificatePEM()); got != before {
+		t.Error("certificate replaced")
+	}
+}
@@ -0,0 +1,325 @@
+package redfish
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/metal3-community/metal-boot/internal/hoststate"
+	"github.com/metal3-community/metal-boot/internal/telemetry"
+	"github.com/metal3-community/metal-boot/internal/util"
+	"go.opentelemetry.io/otel"
+)
+
+const chassisCollectionPath = "/redfish/v1/Chassis"
+
+// CPU temperatures at which a Raspberry Pi 4 starts throttling its cores
+// and at which it throttles them as far as it can.
+const (
+	cpuTemperatureNonCritical = 80.0
+	cpuTemperatureCritical    = 85.0
+)
+
+// throttledConditions name the throttling state bits in the Oem section of
+// Thermal.
+var throttledConditions = []struct {
+	bit  uint32
+	name string
+}{
+	{hoststate.ThrottledUnderVoltage, "UnderVoltage"},
+	{hoststate.ThrottledFrequencyCap, "FrequencyCapped"},
+	{hoststate.ThrottledThrottling, "Throttled"},
+	{hoststate.ThrottledSoftTempLimit, "SoftTemperatureLimit"},
+}
+
+// chassis is the board of a system. Every system is its own chassis, named
+// by the id of the system.
+type chassis struct {
+	OdataId     string       `json:"@odata.id"`
+	OdataType   string       `json:"@odata.type"`
+	Id          string       `json:"Id"`
+	Name        string       `json:"Name"`
+	ChassisType string       `json:"ChassisType"`
+	PowerState  *PowerState  `json:"PowerState,omitempty"`
+	Status      Status       `json:"Status"`
+	Thermal     IdRef        `json:"Thermal"`
+	Power       IdRef        `json:"Power"`
+	Links       chassisLinks `json:"Links"`
+}
+
+type chassisLinks struct {
+	ComputerSystems []IdRef `json:"ComputerSystems"`
+	ManagedBy       []IdRef `json:"ManagedBy"`
+}
+
+type thermal struct {
+	OdataId      string        `json:"@odata.id"`
+	OdataType    string        `json:"@odata.type"`
+	Id           string        `json:"Id"`
+	Name         string        `json:"Name"`
+	Temperatures []temperature `json:"Temperatures"`
+	Oem          *thermalOem   `json:"Oem,omitempty"`
+}
+
+type temperature struct {
+	OdataId                   string   `json:"@odata.id"`
+	MemberId                  string   `json:"MemberId"`
+	Name                      string   `json:"Name"`
+	PhysicalContext           string   `json:"PhysicalContext"`
+	ReadingCelsius            *float64 `json:"ReadingCelsius"`
+	UpperThresholdNonCritical float64  `json:"UpperThresholdNonCritical"`
+	UpperThresholdCritical    float64  `json:"UpperThresholdCritical"`
+	Status                    Status   `json:"Status"`
+}
+
+type thermalOem struct {
+	MetalBoot throttlingOem `json:"MetalBoot"`
+}
+
+// throttlingOem is the throttling state a Raspberry Pi reported.
+type throttlingOem struct {
+	OdataType string `json:"@odata.type"`
+	// Throttling lists the conditions active when the state was reported,
+	// ThrottlingOccurred those seen since the system booted.
+	Throttling         []string  `json:"Throttling"`
+	ThrottlingOccurred []string  `json:"ThrottlingOccurred"`
+	ReportedAt         time.Time `json:"ReportedAt"`
+}
+
+type power struct {
+	OdataId      string         `json:"@odata.id"`
+	OdataType    string         `json:"@odata.type"`
+	Id           string         `json:"Id"`
+	Name         string         `json:"Name"`
+	PowerControl []powerControl `json:"PowerControl"`
+}
+
+type powerControl struct {
+	OdataId            string   `json:"@odata.id"`
+	MemberId           string   `json:"MemberId"`
+	Name               string   `json:"Name"`
+	PhysicalContext    string   `json:"PhysicalContext"`
+	PowerConsumedWatts *float64 `json:"PowerConsumedWatts"`
+	Status             Status   `json:"Status"`
+}
+
+func chassisPath(chassisId string) string {
+	return chassisCollectionPath + "/" + chassisId
+}
+
+// ListChassis lists the chassis of every system.
+func (s *RedfishServer) ListChassis(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer(tracerName).Start(r.Context(), "redfish.RedfishServer.ListChassis")
+	defer span.End()
+
+	keys, err := s.reader.GetKeys(ctx)
+	if err != nil {
+		s.Log.Error(err, "error getting keys")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+	members := make([]IdRef, 0, len(keys))
+	for _, mac := range keys {
+		members = append(members, IdRef{OdataId: util.Ptr(chassisPath(mac.String()))})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(certificateCollection{
+		OdataId:      chassisCollectionPath,
+		OdataType:    "#ChassisCollection.ChassisCollection",
+		Name:         "Chassis Collection",
+		Members:      members,
+		MembersCount: len(members),
+	})
+}
+
+// GetChassis returns the chassis of a system.
+func (s *RedfishServer) GetChassis(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer(tracerName).Start(r.Context(), "redfish.RedfishServer.GetChassis")
+	defer span.End()
+
+	chassisId := r.PathValue("chassisId")
+	mac, err := s.systemMAC(chassisId)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
+	state, health := StateEnabled, HealthOK
+	resp := chassis{
+		OdataId:     chassisPath(chassisId),
+		OdataType:   odataType(ctx, "Chassis"),
+		Id:          chassisId,
+		Name:        fmt.Sprintf("Chassis %s", chassisId),
+		ChassisType: "Card",
+		Status:      Status{State: &state, Health: &health},
+		Thermal:     IdRef{OdataId: util.Ptr(chassisPath(chassisId) + "/Thermal")},
+		Power:       IdRef{OdataId: util.Ptr(chassisPath(chassisId) + "/Power")},
+		Links: chassisLinks{
+			ComputerSystems: []IdRef{{OdataId: util.Ptr("/redfish/v1/Systems/" + chassisId)}},
+			ManagedBy:       []IdRef{{OdataId: util.Ptr("/redfish/v1/Managers/1")}},
+		},
+	}
+	if pwr, err := s.power.GetPower(ctx, mac); err == nil && pwr != nil {
+		resp.PowerState = util.Ptr(redfishPowerState(*pwr))
+	} else if err != nil {
+		s.Log.V(1).Info("failed to read power state", "chassis", chassisId, "error", err)
+	}
+	if sensors, ok := s.hosts.LatestSensors(mac); ok {
+		resp.Status.Health = util.Ptr(sensorHealth(sensors))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// GetThermal returns the CPU temperature and throttling state a system last
+// reported.
+func (s *RedfishServer) GetThermal(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer(tracerName).Start(r.Context(), "redfish.RedfishServer.GetThermal")
+	defer span.End()
+
+	chassisId := r.PathValue("chassisId")
+	mac, err := s.systemMAC(chassisId)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
+	path := chassisPath(chassisId) + "/Thermal"
+	cpu := temperature{
+		OdataId:                   path + "#/Temperatures/0",
+		MemberId:                  "0",
+		Name:                      "CPU Temperature",
+		PhysicalContext:           "CPU",
+		UpperThresholdNonCritical: cpuTemperatureNonCritical,
+		UpperThresholdCritical:    cpuTemperatureCritical,
+		Status:                    Status{State: util.Ptr(StateAbsent)},
+	}
+	resp := thermal{
+		OdataId:   path,
+		OdataType: odataType(ctx, "Thermal"),
+		Id:        "Thermal",
+		Name:      "Thermal",
+	}
+	if sensors, ok := s.hosts.LatestSensors(mac); ok {
+		if c := sensors.CPUTemperature; c != nil {
+			cpu.ReadingCelsius = c
+			cpu.Status = Status{
+				State:  util.Ptr(StateEnabled),
+				Health: util.Ptr(temperatureHealth(*c)),
+			}
+		}
+		if sensors.Throttled != nil {
+			resp.Oem = &thermalOem{MetalBoot: throttlingOem{
+				OdataType:          "#MetalBoot.v1_0_0.Thermal",
+				Throttling:         throttledNames(sensors.Current()),
+				ThrottlingOccurred: throttledNames(sensors.Occurred()),
+				ReportedAt:         sensors.ReportedAt,
+			}}
+		}
+	}
+	resp.Temperatures = []temperature{cpu}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// GetPower returns the power a system draws from its PoE switch port, as
+// the power backend reports it.
+func (s *RedfishServer) GetPower(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer(tracerName).Start(r.Context(), "redfish.RedfishServer.GetPower")
+	defer span.End()
+
+	chassisId := r.PathValue("chassisId")
+	mac, err := s.systemMAC(chassisId)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
+	path := chassisPath(chassisId) + "/Power"
+	poe := powerControl{
+		OdataId:         path + "#/PowerControl/0",
+		MemberId:        "0",
+		Name:            "PoE Power",
+		PhysicalContext: "PowerSupply",
+		Status:          Status{State: util.Ptr(StateAbsent)},
+	}
+	if watts, ok := s.poePower(ctx, mac); ok {
+		health := HealthOK
+		if sensors, ok := s.hosts.LatestSensors(mac); ok &&
+			sensors.Current()&hoststate.ThrottledUnderVoltage != 0 {
+			health = HealthWarning
+		}
+		poe.PowerConsumedWatts = &watts
+		poe.Status = Status{State: util.Ptr(StateEnabled), Health: &health}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(power{
+		OdataId:      path,
+		OdataType:    odataType(ctx, "Power"),
+		Id:           "Power",
+		Name:         "Power",
+		PowerControl: []powerControl{poe},
+	})
+}
+
+// poePower reads the PoE power draw of mac, if the power backend can.
+func (s *RedfishServer) poePower(ctx context.Context, mac net.HardwareAddr) (float64, bool) {
+	poe, ok := telemetry.FindPower[telemetry.PoEReader](s.power)
+	if !ok {
+		return 0, false
+	}
+	watts, err := poe.PoEPower(ctx, mac)
+	if err != nil {
+		s.Log.V(1).Info("failed to read PoE power", "mac", mac.String(), "error", err)
+		return 0, false
+	}
+
+	return watts, true
+}
+
+func temperatureHealth(celsius float64) Health {
+	switch {
+	case celsius >= cpuTemperatureCritical:
+		return HealthCritical
+	case celsius >= cpuTemperatureNonCritical:
+		return HealthWarning
+	default:
+		return HealthOK
+	}
+}
+
+// sensorHealth is the health of a chassis given its latest readings: the
+// health of its CPU temperature, and at least Warning while it throttles.
+func sensorHealth(sensors hoststate.Sensors) Health {
+	health := HealthOK
+	if c := sensors.CPUTemperature; c != nil {
+		health = temperatureHealth(*c)
+	}
+	if health == HealthOK && sensors.Current() != 0 {
+		health = HealthWarning
+	}
+
+	return health
+}
+
+func throttledNames(bits uint32) []string {
+	names := []string{}
+	for _, c := range throttledConditions {
+		if bits&c.bit != 0 {
+			names = append(names, c.name)
+		}
+	}
+
+	return names
+}
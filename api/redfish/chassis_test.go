@@ -0,0 +1,89 @@
+package redfish
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+	"github.com/metal3-community/metal-boot/internal/hoststate"
+)
+
+// poePower is a power backend that reads the PoE power draw of a port.
+type poePower struct {
+	fakePower
+	watts float64
+}
+
+func (p *poePower) PoEPower(context.Context, net.HardwareAddr) (float64, error) {
+	return p.watts, nil
+}
+
+func TestChassis(t *testing.T) {
+	hosts, err := hoststate.NewStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &RedfishServer{
+		Log:   logr.Discard(),
+		power: &poePower{fakePower: fakePower{state: data.PowerOn}, watts: 5.2},
+		hosts: hosts,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+chassisPath("{chassisId}"), s.GetChassis)
+	mux.HandleFunc("GET "+chassisPath("{chassisId}")+"/Thermal", s.GetThermal)
+	mux.HandleFunc("GET "+chassisPath("{chassisId}")+"/Power", s.GetPower)
+	get := func(path string, v any) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if err := json.NewDecoder(rec.Body).Decode(v); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("GET %s = %d, %v", path, rec.Code, err)
+		}
+	}
+	const system = "d8:3a:dd:01:02:03"
+	mac, _ := net.ParseMAC(system)
+
+	var th thermal
+	get(chassisPath(system)+"/Thermal", &th)
+	if len(th.Temperatures) != 1 || th.Temperatures[0].ReadingCelsius != nil ||
+		*th.Temperatures[0].Status.State != StateAbsent || th.Oem != nil {
+		t.Errorf("Thermal without readings = %+v", th)
+	}
+
+	celsius, throttled := 81.5, uint32(0x50005)
+	sensors := hoststate.Sensors{CPUTemperature: &celsius, Throttled: &throttled}
+	if err := hosts.RecordSensors(mac, sensors); err != nil {
+		t.Fatal(err)
+	}
+
+	var c chassis
+	get(chassisPath(system), &c)
+	if *c.PowerState != On || *c.Status.Health != HealthWarning ||
+		*c.Links.ComputerSystems[0].OdataId != "/redfish/v1/Systems/"+system {
+		t.Errorf("Chassis = %+v", c)
+	}
+
+	get(chassisPath(system)+"/Thermal", &th)
+	cpu := th.Temperatures[0]
+	if *cpu.ReadingCelsius != celsius || *cpu.Status.Health != HealthWarning {
+		t.Errorf("CPU temperature = %+v", cpu)
+	}
+	if th.Oem == nil ||
+		!slices.Equal(th.Oem.MetalBoot.Throttling, []string{"UnderVoltage", "Throttled"}) ||
+		!slices.Equal(th.Oem.MetalBoot.ThrottlingOccurred, []string{"UnderVoltage", "Throttled"}) {
+		t.Errorf("Thermal Oem = %+v", th.Oem)
+	}
+
+	var p power
+	get(chassisPath(system)+"/Power", &p)
+	if len(p.PowerControl) != 1 || *p.PowerControl[0].PowerConsumedWatts != 5.2 ||
+		*p.PowerControl[0].Status.Health != HealthWarning {
+		t.Errorf("Power = %+v", p)
+	}
+}
@@ -0,0 +1,327 @@
+package redfish
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/metal3-community/metal-boot/internal/bootauth"
+	"github.com/metal3-community/metal-boot/internal/config"
+	"github.com/metal3-community/metal-boot/internal/hoststate"
+	"go.opentelemetry.io/otel"
+)
+
+const (
+	secureEraseAction      = "MetalBoot.SecureErase"
+	cleaningCompleteAction = "MetalBoot.CleaningComplete"
+
+	// cleaningBackupSuffix is appended to a node's existing iPXE config while
+	// the cleaning script temporarily replaces it.
+	cleaningBackupSuffix = ".pre-clean"
+)
+
+var errCleaningDisabled = errors.New("cleaning is not enabled")
+
+// SecureEraseRequest is the body accepted by the MetalBoot.SecureErase OEM action.
+type SecureEraseRequest struct {
+	// WipeMethod overrides the configured wipe method ("quick" or "secure").
+	WipeMethod *string `json:"WipeMethod,omitempty"`
+}
+
+// CleaningCompleteRequest is the body posted by the cleaning image when it finishes.
+type CleaningCompleteRequest struct {
+	Success bool   `json:"Success"`
+	Message string `json:"Message,omitempty"`
+}
+
+// SecureErase boots a system into the configured cleaning image, which wipes
+// its disks and reports back through the CleaningComplete callback.
+func (s *RedfishServer) SecureErase(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	tracer := otel.Tracer(tracerName)
+	_, span := tracer.Start(ctx, "redfish.RedfishServer.SecureErase")
+	defer span.End()
+
+	systemId := r.PathValue("systemId")
+
+	if !s.Config.Cleaning.Enabled || s.cleaningTokens == nil {
+		s.Log.Error(errCleaningDisabled, "secure erase requested", "system_id", systemId)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(redfishError(errCleaningDisabled))
+		return
+	}
+
+	systemIdAddr, err := s.systemMAC(systemId)
+	if err != nil {
+		s.Log.Error(err, "error parsing system id", "system_id", systemId)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
+	req := SecureEraseRequest{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.Log.Error(err, "error decoding request", "system_id", systemId)
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(redfishError(err))
+			return
+		}
+	}
+
+	wipeMethod := s.Config.Cleaning.WipeMethod
+	if req.WipeMethod != nil {
+		wipeMethod = *req.WipeMethod
+	}
+	if wipeMethod != "quick" && wipeMethod != "secure" {
+		err := fmt.Errorf("invalid wipe method: %s", wipeMethod)
+		s.Log.Error(err, "invalid secure erase request", "system_id", systemId)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
+	// A failed start puts the host back as it was, so that no wipe is left
+	// armed for its next boot.
+	prev, _ := s.hosts.Get(systemIdAddr)
+
+	if err := s.writeCleaningScript(systemIdAddr, wipeMethod); err != nil {
+		s.Log.Error(err, "failed to write cleaning script", "system_id", systemId)
+		s.abortSecureErase(systemIdAddr, prev)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
+	if err := s.hosts.ResetBootAttempts(systemIdAddr); err != nil {
+		s.Log.Error(err, "failed to reset boot attempts", "system_id", systemId)
+	}
+
+	if err := s.hosts.SetState(systemIdAddr, hoststate.StateCleaning, wipeMethod); err != nil {
+		s.Log.Error(err, "failed to record cleaning state", "system_id", systemId)
+		s.abortSecureErase(systemIdAddr, prev)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
+	if err := s.power.PowerCycle(ctx, systemIdAddr); err != nil {
+		s.Log.Error(err, "error power cycling system", "system_id", systemId)
+		s.abortSecureErase(systemIdAddr, prev)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
+	s.Log.Info("secure erase started", "system_id", systemId, "wipeMethod", wipeMethod)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// abortSecureErase disarms a secure erase that failed to start: it restores
+// the iPXE config of mac and its state before, prev.
+func (s *RedfishServer) abortSecureErase(mac net.HardwareAddr, prev hoststate.Host) {
+	if err := s.restoreCleaningScript(mac); err != nil {
+		s.Log.Error(err, "failed to restore iPXE config after aborted secure erase", "mac", mac.String())
+	}
+	err := s.hosts.Update(mac, func(h *hoststate.Host) {
+		h.State = prev.State
+		h.Message = prev.Message
+	})
+	if err != nil {
+		s.Log.Error(err, "failed to restore state after aborted secure erase", "mac", mac.String())
+	}
+}
+
+// CleaningComplete is called by the cleaning image once the wipe has finished.
+// It restores the node's previous iPXE config and records the outcome. The
+// callback URL carries a token for the node, which is checked.
+func (s *RedfishServer) CleaningComplete(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	tracer := otel.Tracer(tracerName)
+	_, span := tracer.Start(ctx, "redfish.RedfishServer.CleaningComplete")
+	defer span.End()
+
+	systemId := r.PathValue("systemId")
+
+	systemIdAddr, err := s.systemMAC(systemId)
+	if err != nil {
+		s.Log.Error(err, "error parsing system id", "system_id", systemId)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
+	if err := s.verifyCleaningToken(systemIdAddr, r); err != nil {
+		s.Log.Info("rejected cleaning callback", "system_id", systemId, "error", err.Error())
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
+	host, err := s.hosts.Get(systemIdAddr)
+	if err != nil || host.State != hoststate.StateCleaning {
+		err := fmt.Errorf("system %s is not cleaning", systemId)
+		s.Log.Error(err, "unexpected cleaning callback", "system_id", systemId)
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
+	req := CleaningCompleteRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.Log.Error(err, "error decoding request", "system_id", systemId)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
+	if err := s.restoreCleaningScript(systemIdAddr); err != nil {
+		s.Log.Error(err, "failed to restore iPXE config after cleaning", "system_id", systemId)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
+	state := hoststate.StateCleaned
+	if !req.Success {
+		state = hoststate.StateCleanFailed
+	}
+	if err := s.hosts.SetState(systemIdAddr, state, req.Message); err != nil {
+		s.Log.Error(err, "failed to record cleaning result", "system_id", systemId)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
+	s.Log.Info("cleaning finished", "system_id", systemId, "state", state, "message", req.Message)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// newCleaningTokens returns the verifier of the tokens of cleaning callback
+// URLs, with the secret of cfg or else the one kept in its secret file.
+func newCleaningTokens(cfg config.CleaningConfig) (*bootauth.Verifier, error) {
+	secret := []byte(cfg.TokenSecret)
+	if len(secret) == 0 {
+		var err error
+		if secret, err = bootauth.LoadSecret(cfg.TokenSecretFile); err != nil {
+			return nil, fmt.Errorf("cleaning token secret: %w", err)
+		}
+	}
+
+	return &bootauth.Verifier{
+		Secret: secret,
+		TTL:    time.Duration(cfg.TokenTTLSec) * time.Second,
+	}, nil
+}
+
+// verifyCleaningToken checks the token of a cleaning callback of mac.
+func (s *RedfishServer) verifyCleaningToken(mac net.HardwareAddr, r *http.Request) error {
+	if s.cleaningTokens == nil {
+		return errCleaningDisabled
+	}
+
+	return s.cleaningTokens.VerifyToken(mac, r.URL.Query().Get(bootauth.TokenParam))
+}
+
+// pxeConfigPath returns the per-node iPXE config served by the script handler.
+func (s *RedfishServer) pxeConfigPath(mac net.HardwareAddr) string {
+	return filepath.Join(
+		s.Config.Static.RootDirectory,
+		"pxelinux.cfg",
+		strings.ReplaceAll(mac.String(), ":", "-"),
+	)
+}
+
+// lastBootAddr returns the address mac last fetched a boot artifact from, or
+// nil if it is unknown.
+func (s *RedfishServer) lastBootAddr(mac net.HardwareAddr) net.IP {
+	if s.hosts == nil {
+		return nil
+	}
+	host, err := s.hosts.Get(mac)
+	if err != nil || host.ObservedBootSource == nil {
+		return nil
+	}
+	// HTTP observations hold host:port, TFTP ones a bare IP.
+	remote := host.ObservedBootSource.RemoteAddr
+	addr, err := netip.ParseAddr(remote)
+	if err != nil {
+		addrPort, err := netip.ParseAddrPort(remote)
+		if err != nil {
+			return nil
+		}
+		addr = addrPort.Addr()
+	}
+
+	return net.IP(addr.Unmap().AsSlice())
+}
+
+// writeCleaningScript replaces the node's iPXE config with one that boots the
+// cleaning image, keeping any existing config aside for restoreCleaningScript.
+func (s *RedfishServer) writeCleaningScript(mac net.HardwareAddr, wipeMethod string) error {
+	cfg := s.Config.Cleaning
+	if cfg.KernelURL == "" || cfg.InitrdURL == "" {
+		return errors.New("cleaning kernel_url and initrd_url must be configured")
+	}
+
+	callback := s.cleaningTokens.SignURL(s.Config.APIURL(
+		s.lastBootAddr(mac),
+		"/redfish/v1/Systems",
+		mac.String(),
+		"Actions/Oem",
+		cleaningCompleteAction,
+	), mac)
+
+	args := append([]string{
+		"initrd=" + filepath.Base(cfg.InitrdURL),
+		"metalboot.wipe=" + wipeMethod,
+		"metalboot.callback=" + callback.String(),
+	}, cfg.KernelArgs...)
+
+	script := fmt.Sprintf("#!ipxe\nkernel %s %s\ninitrd %s\nboot\n",
+		cfg.KernelURL, strings.Join(args, " "), cfg.InitrdURL)
+
+	cfgPath := s.pxeConfigPath(mac)
+	if err := os.MkdirAll(filepath.Dir(cfgPath), 0o755); err != nil {
+		return fmt.Errorf("failed to create pxelinux.cfg directory: %w", err)
+	}
+
+	backup := cfgPath + cleaningBackupSuffix
+	if _, err := os.Stat(backup); errors.Is(err, os.ErrNotExist) {
+		if err := os.Rename(cfgPath, backup); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to back up iPXE config: %w", err)
+		}
+	}
+
+	if err := os.WriteFile(cfgPath, []byte(script), 0o644); err != nil {
+		return fmt.Errorf("failed to write cleaning iPXE config: %w", err)
+	}
+
+	return nil
+}
+
+// restoreCleaningScript puts back the config saved by writeCleaningScript, or
+// removes the cleaning config if the node had none.
+func (s *RedfishServer) restoreCleaningScript(mac net.HardwareAddr) error {
+	cfgPath := s.pxeConfigPath(mac)
+	backup := cfgPath + cleaningBackupSuffix
+
+	if err := os.Rename(backup, cfgPath); err == nil {
+		return nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if err := os.Remove(cfgPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
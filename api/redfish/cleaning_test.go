@@ -0,0 +1,194 @@
+package redfish
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/metal3-community/metal-boot/internal/config"
+	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+	"github.com/metal3-community/metal-boot/internal/hoststate"
+)
+
+const cleaningSystem = "d8:3a:dd:01:02:03"
+
+func newCleaningServer(t *testing.T, power *fakePower) (*RedfishServer, *http.ServeMux) {
+	t.Helper()
+	hosts, err := hoststate.NewStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{}
+	cfg.Cleaning = config.CleaningConfig{
+		Enabled:     true,
+		KernelURL:   "http://10.0.0.1/vmlinuz",
+		InitrdURL:   "http://10.0.0.1/initrd",
+		WipeMethod:  "quick",
+		TokenSecret: "secret",
+		TokenTTLSec: 3600,
+	}
+	cfg.Address = "0.0.0.0"
+	cfg.Port = 8080
+	cfg.Static.RootDirectory = t.TempDir()
+	cfg.Dhcp.IpxeBinaryUrl = config.IpxeUrl{Address: "10.0.0.1", Port: 9090, Scheme: "http", Path: "/ipxe/"}
+	tokens, err := newCleaningTokens(cfg.Cleaning)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &RedfishServer{
+		Config:         cfg,
+		Log:            logr.Discard(),
+		hosts:          hosts,
+		power:          power,
+		cleaningTokens: tokens,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /redfish/v1/Systems/{systemId}/Actions/Oem/"+secureEraseAction, s.SecureErase)
+	mux.HandleFunc("POST /redfish/v1/Systems/{systemId}/Actions/Oem/"+cleaningCompleteAction, s.CleaningComplete)
+
+	return s, mux
+}
+
+// cleaningCallback returns the callback URL of the cleaning script of mac.
+func cleaningCallback(t *testing.T, s *RedfishServer, mac string) *url.URL {
+	t.Helper()
+	m, _ := s.systemMAC(mac)
+	script, err := os.ReadFile(s.pxeConfigPath(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	match := regexp.MustCompile(`metalboot\.callback=(\S+)`).FindSubmatch(script)
+	if match == nil {
+		t.Fatalf("no callback in cleaning script %q", script)
+	}
+	u, err := url.Parse(string(match[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return u
+}
+
+func TestCleaningCallbackToken(t *testing.T) {
+	s, mux := newCleaningServer(t, &fakePower{state: data.PowerOn})
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost,
+		"/redfish/v1/Systems/"+cleaningSystem+"/Actions/Oem/"+secureEraseAction, nil))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("SecureErase status = %d: %s", rec.Code, rec.Body)
+	}
+	callback := cleaningCallback(t, s, cleaningSystem)
+	if callback.Scheme != "http" || callback.Host != "10.0.0.1:8080" {
+		t.Errorf("callback = %s, want it on the API at 10.0.0.1:8080", callback)
+	}
+
+	other, _ := s.systemMAC("d8:3a:dd:01:02:04")
+	for name, query := range map[string]string{
+		"no token":         "",
+		"invalid token":    "token=1.abc",
+		"other node token": s.cleaningTokens.SignURL(&url.URL{}, other).RawQuery,
+	} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost,
+			callback.Path+"?"+query, strings.NewReader(`{"Success": true}`)))
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("callback with %s status = %d, want %d", name, rec.Code, http.StatusForbidden)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost,
+		callback.RequestURI(), strings.NewReader(`{"Success": true}`)))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("callback status = %d: %s", rec.Code, rec.Body)
+	}
+	mac, _ := s.systemMAC(cleaningSystem)
+	if h, _ := s.hosts.Get(mac); h.State != hoststate.StateCleaned {
+		t.Errorf("State = %q, want %q", h.State, hoststate.StateCleaned)
+	}
+}
+
+func TestCleaningTokensSurviveRestart(t *testing.T) {
+	cfg := config.CleaningConfig{
+		TokenSecretFile: filepath.Join(t.TempDir(), "keys", "cleaning-token.key"),
+		TokenTTLSec:     3600,
+	}
+	before, err := newCleaningTokens(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := newCleaningTokens(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mac, _ := net.ParseMAC(cleaningSystem)
+	if err := after.VerifyToken(mac, before.Token(mac)); err != nil {
+		t.Errorf("token issued before a restart: %v", err)
+	}
+
+	if _, err := newCleaningTokens(config.CleaningConfig{}); err == nil {
+		t.Error("newCleaningTokens() without a secret or secret file succeeded")
+	}
+}
+
+func TestLastBootAddr(t *testing.T) {
+	s, _ := newCleaningServer(t, &fakePower{})
+	mac, _ := s.systemMAC(cleaningSystem)
+
+	for remote, want := range map[string]string{
+		"10.0.0.7:41234":   "10.0.0.7",
+		"10.0.0.8":         "10.0.0.8",
+		"[fd00::9]:8080":   "fd00::9",
+		"::ffff:10.0.0.10": "10.0.0.10",
+		"not an address":   "<nil>",
+	} {
+		if err := s.hosts.RecordBootSource(mac, hoststate.BootSource{Protocol: "tftp", RemoteAddr: remote}); err != nil {
+			t.Fatal(err)
+		}
+		if got := s.lastBootAddr(mac).String(); got != want {
+			t.Errorf("lastBootAddr() with %q = %s, want %s", remote, got, want)
+		}
+	}
+}
+
+func TestSecureEraseAbort(t *testing.T) {
+	s, mux := newCleaningServer(t, &fakePower{cycleErr: errors.New("no power switch")})
+	mac, _ := s.systemMAC(cleaningSystem)
+	if err := s.hosts.SetState(mac, hoststate.StateProvisioned, "phoned home"); err != nil {
+		t.Fatal(err)
+	}
+	cfgPath := s.pxeConfigPath(mac)
+	if err := os.MkdirAll(filepath.Dir(cfgPath), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cfgPath, []byte("#!ipxe\nexit\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost,
+		"/redfish/v1/Systems/"+cleaningSystem+"/Actions/Oem/"+secureEraseAction, nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("SecureErase status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+
+	if got, err := os.ReadFile(cfgPath); err != nil || string(got) != "#!ipxe\nexit\n" {
+		t.Errorf("iPXE config = %q, %v, want the previous one", got, err)
+	}
+	if _, err := os.Stat(cfgPath + cleaningBackupSuffix); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("backup of the iPXE config left behind: %v", err)
+	}
+	if h, _ := s.hosts.Get(mac); h.State != hoststate.StateProvisioned || h.Message != "phoned home" {
+		t.Errorf("host = %q %q, want it provisioned again", h.State, h.Message)
+	}
+}
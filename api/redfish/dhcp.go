@@ -0,0 +1,136 @@
+package redfish
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/metal3-community/metal-boot/internal/backend"
+	"github.com/metal3-community/metal-boot/internal/backend/dnsmasq"
+	"go.opentelemetry.io/otel"
+)
+
+// dhcpResource is the Oem resource of the DHCP data of a system: what the
+// backend answers the system with and, for dnsmasq backends, the host entry,
+// lease and options that answer is made of.
+type dhcpResource struct {
+	OdataId   string              `json:"@odata.id"`
+	OdataType string              `json:"@odata.type"`
+	Id        string              `json:"Id"`
+	Name      string              `json:"Name"`
+	Answer    dhcpAnswer          `json:"Answer"`
+	Dnsmasq   *dnsmasq.HostRecord `json:"Dnsmasq,omitempty"`
+}
+
+// dhcpAnswer is the part of the backend data of a system that goes into its
+// DHCP replies.
+type dhcpAnswer struct {
+	IPAddress      string       `json:"IPAddress,omitempty"`
+	IPv6Address    string       `json:"IPv6Address,omitempty"`
+	SubnetMask     string       `json:"SubnetMask,omitempty"`
+	DefaultGateway string       `json:"DefaultGateway,omitempty"`
+	Hostname       string       `json:"Hostname,omitempty"`
+	DomainName     string       `json:"DomainName,omitempty"`
+	LeaseTime      uint32       `json:"LeaseTime"`
+	Disabled       bool         `json:"Disabled"`
+	AllowNetboot   bool         `json:"AllowNetboot"`
+	Options        []dhcpOption `json:"Options"`
+}
+
+// dhcpOption is an encoded option, with its value in hexadecimal.
+type dhcpOption struct {
+	Code  uint8  `json:"Code"`
+	Value string `json:"Value"`
+}
+
+func dhcpPath(systemId string) string {
+	return fmt.Sprintf("/redfish/v1/Systems/%s/Oem/MetalBoot/DHCP", systemId)
+}
+
+// hostRecorder returns r, or a backend it wraps, if it keeps the dnsmasq
+// records of hosts.
+func hostRecorder(r backend.BackendReader) (*dnsmasq.Backend, bool) {
+	for r != nil {
+		if b, ok := r.(*dnsmasq.Backend); ok {
+			return b, true
+		}
+		u, ok := r.(interface{ Unwrap() backend.BackendReader })
+		if !ok {
+			break
+		}
+		r = u.Unwrap()
+	}
+
+	return nil, false
+}
+
+// GetDHCP returns the DHCP data of a system, so that it can be reconciled
+// with the ComputerSystem without reading the dnsmasq files.
+func (s *RedfishServer) GetDHCP(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer(tracerName).Start(r.Context(), "redfish.RedfishServer.GetDHCP")
+	defer span.End()
+
+	systemId := r.PathValue("systemId")
+	mac, err := s.systemMAC(systemId)
+	if err != nil {
+		s.Log.Error(err, "error parsing system id", "system_id", systemId)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+	systemId = mac.String()
+
+	dhcp, netboot, err := s.reader.GetByMac(ctx, mac)
+	if err != nil {
+		s.Log.Error(err, "error getting system by mac", "system_id", systemId)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
+	resp := dhcpResource{
+		OdataId:   dhcpPath(systemId),
+		OdataType: "#MetalBoot.v1_0_0.DHCP",
+		Id:        "DHCP",
+		Name:      fmt.Sprintf("DHCP data of %s", systemId),
+		Answer:    dhcpAnswer{Options: []dhcpOption{}},
+	}
+	if dhcp != nil {
+		a := &resp.Answer
+		if dhcp.IPAddress.IsValid() {
+			a.IPAddress = dhcp.IPAddress.String()
+		}
+		if dhcp.IPv6Address.IsValid() {
+			a.IPv6Address = dhcp.IPv6Address.String()
+		}
+		if dhcp.SubnetMask != nil {
+			a.SubnetMask = net.IP(dhcp.SubnetMask).String()
+		}
+		if dhcp.DefaultGateway.IsValid() {
+			a.DefaultGateway = dhcp.DefaultGateway.String()
+		}
+		a.Hostname = dhcp.Hostname
+		a.DomainName = dhcp.DomainName
+		a.LeaseTime = dhcp.LeaseTime
+		a.Disabled = dhcp.Disabled
+		for _, o := range dhcp.Options {
+			a.Options = append(a.Options, dhcpOption{
+				Code:  o.Code,
+				Value: hex.EncodeToString(o.Value),
+			})
+		}
+	}
+	if netboot != nil {
+		resp.Answer.AllowNetboot = netboot.AllowNetboot
+	}
+	if b, ok := hostRecorder(s.reader); ok {
+		if rec, ok := b.HostRecord(mac); ok {
+			resp.Dnsmasq = &rec
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
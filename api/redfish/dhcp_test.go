@@ -0,0 +1,58 @@
+package redfish
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/metal3-community/metal-boot/internal/backend/dnsmasq"
+)
+
+func TestGetDHCP(t *testing.T) {
+	root := t.TempDir()
+	for path, content := range map[string]string{
+		"hosts/node-1.conf": "d8:3a:dd:01:02:03,set:node-1,192.168.1.50,node-1\n",
+		"opts/node-1.conf":  "tag:node-1,26,1500\n",
+	} {
+		full := filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := dnsmasq.NewBackend(logr.Discard(), dnsmasq.Config{RootDir: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	s := &RedfishServer{reader: b, Log: logr.Discard()}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+dhcpPath("{systemId}"), s.GetDHCP)
+	system := "d8:3a:dd:01:02:03"
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, dhcpPath(system), nil))
+	var resp dhcpResource
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("GET %s = %d, %v", dhcpPath(system), rec.Code, err)
+	}
+	if resp.Answer.IPAddress != "192.168.1.50" || resp.Answer.Hostname != "node-1" {
+		t.Errorf("Answer = %+v, want the reserved address and host name", resp.Answer)
+	}
+	if len(resp.Answer.Options) != 1 || resp.Answer.Options[0].Value != "05dc" {
+		t.Errorf("Answer.Options = %+v, want MTU 1500", resp.Answer.Options)
+	}
+	if resp.Dnsmasq == nil || resp.Dnsmasq.Host == nil || len(resp.Dnsmasq.Options) != 1 {
+		t.Fatalf("Dnsmasq = %+v, want the host entry and its option", resp.Dnsmasq)
+	}
+	if tags := resp.Dnsmasq.Host.Tags; len(tags) != 1 || tags[0] != "node-1" {
+		t.Errorf("Dnsmasq.Host.Tags = %v, want [node-1]", tags)
+	}
+}
@@ -0,0 +1,150 @@
+package redfish
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/metal3-community/metal-boot/internal/backend"
+	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+)
+
+const (
+	// dryRunParam is the query parameter that requests a dry run of a power
+	// operation.
+	dryRunParam = "dryRun"
+	// dryRunHeader requests a dry run like dryRunParam, for clients that
+	// cannot change the URL of an action.
+	dryRunHeader = "X-Dry-Run"
+)
+
+// PowerDryRun reports what a Reset action or a power PATCH of a system would
+// do, without doing it.
+type PowerDryRun struct {
+	DryRun bool   `json:"DryRun"`
+	System string `json:"System"`
+	// Action is the reset type or SetPower for a PATCH of PowerState.
+	Action            string     `json:"Action,omitempty"`
+	CurrentPowerState PowerState `json:"CurrentPowerState,omitempty"`
+	TargetPowerState  PowerState `json:"TargetPowerState,omitempty"`
+	// BootSourceOverrideTarget is the boot override a PATCH would set.
+	BootSourceOverrideTarget BootSource `json:"BootSourceOverrideTarget,omitempty"`
+	// WouldChange is false if the system is already in the requested state.
+	WouldChange bool `json:"WouldChange"`
+	// Device and Port identify what switches the power of the system, if
+	// the power backend can tell.
+	Device     string `json:"Device,omitempty"`
+	DeviceName string `json:"DeviceName,omitempty"`
+	Port       int    `json:"Port,omitempty"`
+}
+
+// dryRun reports whether r asks for a dry run, through the dryRun query
+// parameter or the X-Dry-Run header.
+func dryRun(r *http.Request) bool {
+	v := r.URL.Query().Get(dryRunParam)
+	if v == "" {
+		v = r.Header.Get(dryRunHeader)
+	}
+	ok, _ := strconv.ParseBool(v)
+
+	return ok
+}
+
+// resetTarget returns the power state a reset of resetType leads to, and
+// whether the power is cycled to reach it.
+func resetTarget(resetType ResetType) (target data.PowerState, cycle bool, err error) {
+	switch resetType {
+	case ResetTypePowerCycle:
+		return data.PowerOn, true, nil
+	case ResetTypeForceOff:
+		return data.PowerOff, false, nil
+	case ResetTypeForceOn, ResetTypeOn:
+		return data.PowerOn, false, nil
+	default:
+		return data.PowerOff, false, fmt.Errorf("unsupported reset type: %s", resetType)
+	}
+}
+
+// dryRunSetSystem validates a PATCH of a system like SetSystem and writes
+// what it would change.
+func (s *RedfishServer) dryRunSetSystem(
+	ctx context.Context,
+	w http.ResponseWriter,
+	systemId string,
+	mac net.HardwareAddr,
+	req SetSystemJSONRequestBody,
+	current data.PowerState,
+) {
+	plan := PowerDryRun{System: systemId, CurrentPowerState: redfishPowerState(current)}
+
+	if req.Boot != nil && req.Boot.BootSourceOverrideTarget != nil {
+		switch target := *req.Boot.BootSourceOverrideTarget; target {
+		case Pxe, Hdd, None:
+			plan.BootSourceOverrideTarget = target
+			plan.WouldChange = true
+		default:
+			err := fmt.Errorf("invalid boot source override target: %s", target)
+			s.Log.Error(err, "invalid boot source override target", "system_id", systemId)
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(redfishError(err))
+			return
+		}
+	}
+
+	// Transitional states are accepted and ignored, as SetSystem does.
+	if req.PowerState != nil && (*req.PowerState == On || *req.PowerState == Off) {
+		plan.Action = "SetPower"
+		plan.TargetPowerState = *req.PowerState
+		if plan.TargetPowerState != plan.CurrentPowerState {
+			plan.WouldChange = true
+		}
+	}
+
+	s.writeDryRun(ctx, w, mac, plan)
+}
+
+// writeDryRun completes plan with the power target of mac and writes it.
+func (s *RedfishServer) writeDryRun(
+	ctx context.Context,
+	w http.ResponseWriter,
+	mac net.HardwareAddr,
+	plan PowerDryRun,
+) {
+	plan.DryRun = true
+	if t, ok := powerTargeter(s.power); ok {
+		target, err := t.PowerTarget(ctx, mac)
+		if err != nil {
+			s.Log.Error(err, "failed to find power target", "system_id", plan.System)
+		}
+		plan.Device = target.Device
+		plan.DeviceName = target.DeviceName
+		plan.Port = target.Port
+	}
+
+	s.Log.Info("power dry run", "system_id", plan.System, "action", plan.Action,
+		"wouldChange", plan.WouldChange)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(plan); err != nil {
+		s.Log.Error(err, "error encoding response", "system_id", plan.System)
+	}
+}
+
+// powerTargeter returns p, or a backend it wraps, if it can tell which
+// device powers a system.
+func powerTargeter(p backend.BackendPower) (backend.BackendPowerTarget, bool) {
+	for p != nil {
+		if t, ok := p.(backend.BackendPowerTarget); ok {
+			return t, true
+		}
+		u, ok := p.(interface{ Unwrap() backend.BackendPower })
+		if !ok {
+			break
+		}
+		p = u.Unwrap()
+	}
+
+	return nil, false
+}
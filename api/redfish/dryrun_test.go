@@ -0,0 +1,146 @@
+package redfish
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/metal3-community/metal-boot/internal/backend"
+	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+)
+
+// fakePower is a power backend that records the operations it performs.
+type fakePower struct {
+	state data.PowerState
+	ops   []string
+	// cycleErr fails power cycles.
+	cycleErr error
+}
+
+func (p *fakePower) GetPower(context.Context, net.HardwareAddr) (*data.PowerState, error) {
+	return &p.state, nil
+}
+
+func (p *fakePower) SetPower(_ context.Context, _ net.HardwareAddr, state data.PowerState) error {
+	p.ops = append(p.ops, "set "+state.String())
+	p.state = state
+	return nil
+}
+
+func (p *fakePower) PowerCycle(context.Context, net.HardwareAddr) error {
+	if p.cycleErr != nil {
+		return p.cycleErr
+	}
+	p.ops = append(p.ops, "cycle")
+	return nil
+}
+
+func (p *fakePower) PowerTarget(context.Context, net.HardwareAddr) (backend.PowerTarget, error) {
+	return backend.PowerTarget{Device: "f4:e2:c6:00:00:01", DeviceName: "rack-switch", Port: 7}, nil
+}
+
+func TestPowerDryRun(t *testing.T) {
+	power := &fakePower{state: data.PowerOn}
+	s := &RedfishServer{Log: logr.Discard(), power: power}
+	const system = "d8:3a:dd:01:02:03"
+
+	for _, tt := range []struct {
+		name   string
+		target string
+		header bool
+		body   string
+		reset  bool
+		code   int
+		want   PowerDryRun
+	}{
+		{
+			name:   "reset query",
+			target: "/redfish/v1/Systems/" + system + "/Actions/ComputerSystem.Reset?dryRun=true",
+			body:   `{"ResetType": "ForceOff"}`,
+			reset:  true,
+			code:   http.StatusOK,
+			want: PowerDryRun{Action: "ForceOff", CurrentPowerState: On, TargetPowerState: Off,
+				WouldChange: true},
+		},
+		{
+			name:   "reset header",
+			target: "/redfish/v1/Systems/" + system + "/Actions/ComputerSystem.Reset",
+			header: true,
+			body:   `{"ResetType": "On"}`,
+			reset:  true,
+			code:   http.StatusOK,
+			want:   PowerDryRun{Action: "On", CurrentPowerState: On, TargetPowerState: On},
+		},
+		{
+			name:   "unsupported reset",
+			target: "/redfish/v1/Systems/" + system + "/Actions/ComputerSystem.Reset?dryRun=1",
+			body:   `{"ResetType": "Nmi"}`,
+			reset:  true,
+			code:   http.StatusBadRequest,
+		},
+		{
+			name:   "patch",
+			target: "/redfish/v1/Systems/" + system + "?dryRun=true",
+			body:   `{"PowerState": "Off", "Boot": {"BootSourceOverrideTarget": "Pxe"}}`,
+			code:   http.StatusOK,
+			want: PowerDryRun{Action: "SetPower", CurrentPowerState: On, TargetPowerState: Off,
+				BootSourceOverrideTarget: Pxe, WouldChange: true},
+		},
+		{
+			name:   "invalid boot override",
+			target: "/redfish/v1/Systems/" + system + "?dryRun=true",
+			body:   `{"Boot": {"BootSourceOverrideTarget": "Floppy"}}`,
+			code:   http.StatusBadRequest,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			method := http.MethodPatch
+			if tt.reset {
+				method = http.MethodPost
+			}
+			r := httptest.NewRequest(method, tt.target, strings.NewReader(tt.body))
+			if tt.header {
+				r.Header.Set(dryRunHeader, "true")
+			}
+			w := httptest.NewRecorder()
+			if tt.reset {
+				s.ResetSystem(w, r, system)
+			} else {
+				s.SetSystem(w, r, system)
+			}
+
+			if w.Code != tt.code {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.code, w.Body)
+			}
+			if len(power.ops) != 0 {
+				t.Fatalf("dry run performed %v", power.ops)
+			}
+			if tt.code != http.StatusOK {
+				return
+			}
+			var got PowerDryRun
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			want := tt.want
+			want.DryRun, want.System = true, system
+			want.Device, want.DeviceName, want.Port = "f4:e2:c6:00:00:01", "rack-switch", 7
+			if got != want {
+				t.Errorf("dry run = %+v, want %+v", got, want)
+			}
+		})
+	}
+
+	r := httptest.NewRequest(http.MethodPost,
+		"/redfish/v1/Systems/"+system+"/Actions/ComputerSystem.Reset",
+		strings.NewReader(`{"ResetType": "ForceOff"}`))
+	s.ResetSystem(httptest.NewRecorder(), r, system)
+	if len(power.ops) != 1 || power.ops[0] != "set off" {
+		t.Errorf("reset without dry run performed %v, want [set off]", power.ops)
+	}
+}
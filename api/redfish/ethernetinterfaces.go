@@ -0,0 +1,179 @@
+package redfish
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+	"github.com/metal3-community/metal-boot/internal/util"
+	"go.opentelemetry.io/otel"
+)
+
+// ethernetInterface is the EthernetInterface resource of the NIC a system
+// netboots with, as the DHCP backend describes it.
+type ethernetInterface struct {
+	OdataId             string        `json:"@odata.id"`
+	OdataType           string        `json:"@odata.type"`
+	Id                  string        `json:"Id"`
+	Name                string        `json:"Name"`
+	Description         string        `json:"Description"`
+	Status              Status        `json:"Status"`
+	InterfaceEnabled    bool          `json:"InterfaceEnabled"`
+	MACAddress          string        `json:"MACAddress"`
+	PermanentMACAddress string        `json:"PermanentMACAddress"`
+	HostName            string        `json:"HostName,omitempty"`
+	FQDN                string        `json:"FQDN,omitempty"`
+	IPv4Addresses       []ipv4Address `json:"IPv4Addresses"`
+	IPv6Addresses       []ipv6Address `json:"IPv6Addresses"`
+	NameServers         []string      `json:"NameServers"`
+	VLAN                *ethernetVLAN `json:"VLAN,omitempty"`
+}
+
+type ipv4Address struct {
+	Address       string `json:"Address"`
+	SubnetMask    string `json:"SubnetMask,omitempty"`
+	Gateway       string `json:"Gateway,omitempty"`
+	AddressOrigin string `json:"AddressOrigin"`
+}
+
+type ipv6Address struct {
+	Address       string `json:"Address"`
+	AddressOrigin string `json:"AddressOrigin"`
+}
+
+type ethernetVLAN struct {
+	VLANEnable bool  `json:"VLANEnable"`
+	VLANId     int64 `json:"VLANId"`
+}
+
+func ethernetInterfacesPath(systemId string) string {
+	return fmt.Sprintf("/redfish/v1/Systems/%s/EthernetInterfaces", systemId)
+}
+
+// ethernetInterfaceId names the interface of mac, its MAC address with
+// dashes so that it reads like the per-system directories of the TFTP root.
+func ethernetInterfaceId(mac net.HardwareAddr) string {
+	return strings.ReplaceAll(mac.String(), ":", "-")
+}
+
+// ListEthernetInterfaces lists the NIC of a system the DHCP backend has a
+// record of.
+func (s *RedfishServer) ListEthernetInterfaces(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer(tracerName).
+		Start(r.Context(), "redfish.RedfishServer.ListEthernetInterfaces")
+	defer span.End()
+
+	systemId := r.PathValue("systemId")
+	mac, err := s.systemMAC(systemId)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
+	members := []IdRef{}
+	if _, _, err := s.reader.GetByMac(ctx, mac); err == nil {
+		members = append(members, IdRef{OdataId: util.Ptr(
+			ethernetInterfacesPath(systemId) + "/" + ethernetInterfaceId(mac))})
+	} else {
+		s.Log.V(1).Info("no DHCP record for system", "system_id", systemId, "error", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(certificateCollection{
+		OdataId:      ethernetInterfacesPath(systemId),
+		OdataType:    "#EthernetInterfaceCollection.EthernetInterfaceCollection",
+		Name:         "Ethernet Interface Collection",
+		Members:      members,
+		MembersCount: len(members),
+	})
+}
+
+// GetEthernetInterface returns the addresses, host name and VLAN the DHCP
+// backend assigns the NIC of a system.
+func (s *RedfishServer) GetEthernetInterface(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer(tracerName).
+		Start(r.Context(), "redfish.RedfishServer.GetEthernetInterface")
+	defer span.End()
+
+	systemId, id := r.PathValue("systemId"), r.PathValue("interfaceId")
+	mac, err := s.systemMAC(systemId)
+	if err == nil && id != ethernetInterfaceId(mac) {
+		err = fmt.Errorf("unknown ethernet interface %q", id)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+	d, _, err := s.reader.GetByMac(ctx, mac)
+	if err != nil || d == nil {
+		if err == nil {
+			err = fmt.Errorf("no DHCP record for %s", mac)
+		}
+		s.Log.Error(err, "error getting system by mac", "system_id", systemId)
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newEthernetInterface(ctx, ethernetInterfacesPath(systemId), mac, d))
+}
+
+// newEthernetInterface describes the NIC mac from its DHCP record d.
+func newEthernetInterface(
+	ctx context.Context,
+	base string, mac net.HardwareAddr, d *data.DHCP) ethernetInterface {
+	id := ethernetInterfaceId(mac)
+	state, health := StateEnabled, HealthOK
+	if d.Disabled {
+		state = StateDisabled
+	}
+	nic := ethernetInterface{
+		OdataId:             base + "/" + id,
+		OdataType:           odataType(ctx, "EthernetInterface"),
+		Id:                  id,
+		Name:                "Ethernet Interface " + mac.String(),
+		Description:         "Network interface the system netboots with",
+		Status:              Status{State: &state, Health: &health},
+		InterfaceEnabled:    !d.Disabled,
+		MACAddress:          mac.String(),
+		PermanentMACAddress: mac.String(),
+		HostName:            d.Hostname,
+		IPv4Addresses:       []ipv4Address{},
+		IPv6Addresses:       []ipv6Address{},
+		NameServers:         []string{},
+	}
+	if d.Hostname != "" && d.DomainName != "" {
+		nic.FQDN = d.Hostname + "." + d.DomainName
+	}
+	if d.IPAddress.IsValid() {
+		a := ipv4Address{Address: d.IPAddress.String(), AddressOrigin: "DHCP"}
+		if d.SubnetMask != nil {
+			a.SubnetMask = net.IP(d.SubnetMask).String()
+		}
+		if d.DefaultGateway.IsValid() {
+			a.Gateway = d.DefaultGateway.String()
+		}
+		nic.IPv4Addresses = append(nic.IPv4Addresses, a)
+	}
+	if d.IPv6Address.IsValid() {
+		nic.IPv6Addresses = append(nic.IPv6Addresses,
+			ipv6Address{Address: d.IPv6Address.String(), AddressOrigin: "DHCPv6"})
+	}
+	for _, ns := range slices.Concat(d.NameServers, d.IPv6NameServers) {
+		nic.NameServers = append(nic.NameServers, ns.String())
+	}
+	if id, err := strconv.ParseInt(d.VLANID, 10, 64); err == nil && id > 0 {
+		nic.VLAN = &ethernetVLAN{VLANEnable: true, VLANId: id}
+	}
+
+	return nic
+}
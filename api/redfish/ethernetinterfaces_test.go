@@ -0,0 +1,69 @@
+package redfish
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/metal3-community/metal-boot/internal/backend/dnsmasq"
+)
+
+func TestEthernetInterfaces(t *testing.T) {
+	root := t.TempDir()
+	hosts := filepath.Join(root, "hosts", "node-1.conf")
+	if err := os.MkdirAll(filepath.Dir(hosts), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	err := os.WriteFile(hosts, []byte("d8:3a:dd:01:02:03,192.168.1.50,node-1\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := dnsmasq.NewBackend(logr.Discard(), dnsmasq.Config{RootDir: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	s := &RedfishServer{reader: b, Log: logr.Discard()}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+ethernetInterfacesPath("{systemId}"), s.ListEthernetInterfaces)
+	mux.HandleFunc("GET "+ethernetInterfacesPath("{systemId}")+"/{interfaceId}",
+		s.GetEthernetInterface)
+	get := func(path string, v any) int {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code == http.StatusOK {
+			if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+				t.Fatalf("GET %s: %v", path, err)
+			}
+		}
+		return rec.Code
+	}
+
+	var list certificateCollection
+	path := ethernetInterfacesPath("d8:3a:dd:01:02:03")
+	if code := get(path, &list); code != http.StatusOK || list.MembersCount != 1 {
+		t.Fatalf("GET %s = %d, %+v", path, code, list)
+	}
+	var nic ethernetInterface
+	if code := get(*list.Members[0].OdataId, &nic); code != http.StatusOK {
+		t.Fatalf("GET %s = %d", *list.Members[0].OdataId, code)
+	}
+	if nic.MACAddress != "d8:3a:dd:01:02:03" || nic.HostName != "node-1" ||
+		len(nic.IPv4Addresses) != 1 || nic.IPv4Addresses[0].Address != "192.168.1.50" {
+		t.Errorf("GET %s = %+v", nic.OdataId, nic)
+	}
+
+	if code := get(path+"/eth1", &nic); code != http.StatusNotFound {
+		t.Errorf("GET of an unknown interface = %d, want 404", code)
+	}
+	path = ethernetInterfacesPath("d8:3a:dd:09:09:09")
+	if code := get(path, &list); code != http.StatusOK || list.MembersCount != 0 {
+		t.Errorf("GET %s of a system without a record = %d, %+v", path, code, list)
+	}
+}
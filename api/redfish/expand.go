@@ -0,0 +1,73 @@
+package redfish
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+var errInvalidExpand = errors.New("invalid $expand")
+
+// expandedSystem is a member of the Systems collection inlined by $expand. It
+// carries the primary EthernetInterface in its EthernetInterfaces collection
+// so that clients get the power state and the lease of every system in one
+// request.
+type expandedSystem struct {
+	*computerSystemOem
+	EthernetInterfaces *ethernetInterfaceCollection `json:"EthernetInterfaces,omitempty"`
+}
+
+type ethernetInterfaceCollection struct {
+	OdataId      string              `json:"@odata.id"`
+	Members      []ethernetInterface `json:"Members"`
+	MembersCount int                 `json:"Members@odata.count"`
+}
+
+// parseExpand reports whether a $expand value asks for the members of a
+// collection to be inlined. The service expands a single level, so "." and
+// "*" mean the same and $levels may only be 1.
+func parseExpand(expr string) (bool, error) {
+	if expr == "" {
+		return false, nil
+	}
+	kind, levels, hasLevels := strings.Cut(expr, "(")
+	if kind != "." && kind != "*" {
+		return false, fmt.Errorf("%w: unsupported expansion %q", errInvalidExpand, kind)
+	}
+	if hasLevels && levels != "$levels=1)" {
+		return false, fmt.Errorf("%w: only $levels=1 is supported", errInvalidExpand)
+	}
+
+	return true, nil
+}
+
+// expandSystem returns the inlined member of the Systems collection for mac.
+// Systems that cannot be described are returned as a link so that one
+// unreachable power backend does not fail the whole collection.
+func (s *RedfishServer) expandSystem(ctx context.Context, mac net.HardwareAddr) any {
+	odataId := fmt.Sprintf("/redfish/v1/Systems/%s", mac)
+	system, err := s.computerSystem(ctx, mac)
+	if err != nil {
+		s.Log.Error(err, "error expanding system", "system_id", mac.String())
+		return IdRef{OdataId: &odataId}
+	}
+
+	nics := &ethernetInterfaceCollection{
+		OdataId: ethernetInterfacesPath(mac.String()),
+		Members: []ethernetInterface{},
+	}
+	if d, _, err := s.reader.GetByMac(ctx, mac); err == nil && d != nil {
+		nics.Members = append(nics.Members, newEthernetInterface(ctx, nics.OdataId, mac, d))
+	}
+	nics.MembersCount = len(nics.Members)
+
+	return expandedSystem{computerSystemOem: system, EthernetInterfaces: nics}
+}
+
+// expandedSystems is the Systems collection with its members inlined.
+type expandedSystems struct {
+	Collection
+	Members []any `json:"Members"`
+}
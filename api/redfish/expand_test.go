@@ -0,0 +1,108 @@
+package redfish
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/metal3-community/metal-boot/internal/backend/dnsmasq"
+	"github.com/metal3-community/metal-boot/internal/config"
+	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+)
+
+func TestParseExpand(t *testing.T) {
+	for expr, want := range map[string]bool{
+		"":              false,
+		".":             true,
+		"*":             true,
+		".($levels=1)":  true,
+		"~":             false,
+		".($levels=2)":  false,
+		"Members":       false,
+		"*($levels=1":   false,
+		"*($levels=1)x": false,
+	} {
+		got, err := parseExpand(expr)
+		if want != got {
+			t.Errorf("parseExpand(%q) = %v, %v, want %v", expr, got, err, want)
+		}
+		if !got && expr != "" && !errors.Is(err, errInvalidExpand) {
+			t.Errorf("parseExpand(%q) error = %v, want errInvalidExpand", expr, err)
+		}
+	}
+}
+
+func TestListSystemsExpand(t *testing.T) {
+	root := t.TempDir()
+	hosts := filepath.Join(root, "hosts", "nodes.conf")
+	if err := os.MkdirAll(filepath.Dir(hosts), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	err := os.WriteFile(hosts, []byte(
+		"d8:3a:dd:01:02:03,192.168.1.50,node-1\nd8:3a:dd:01:02:04,192.168.1.51,node-2\n",
+	), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Systems are listed from the active leases.
+	expiry := time.Now().Add(time.Hour).Unix()
+	err = os.WriteFile(filepath.Join(root, "dnsmasq.leases"), fmt.Appendf(nil,
+		"%[1]d d8:3a:dd:01:02:03 192.168.1.50 node-1 *\n%[1]d d8:3a:dd:01:02:04 192.168.1.51 node-2 *\n",
+		expiry), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := dnsmasq.NewBackend(logr.Discard(), dnsmasq.Config{RootDir: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	s := &RedfishServer{
+		Config: &config.Config{Tftp: config.TftpConfig{RootDirectory: t.TempDir()}},
+		Log:    logr.Discard(),
+		reader: b,
+		power:  &fakePower{state: data.PowerOn},
+	}
+
+	rec := httptest.NewRecorder()
+	s.ListSystems(rec, httptest.NewRequest(http.MethodGet,
+		"/redfish/v1/Systems?$expand=.&$filter=HostName%20eq%20'node-2'", nil))
+	var resp struct {
+		Count   int `json:"Members@odata.count"`
+		Members []struct {
+			Id                 string
+			Name               string
+			PowerState         string
+			EthernetInterfaces ethernetInterfaceCollection
+		}
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("GET /redfish/v1/Systems?$expand=. = %d, %v", rec.Code, err)
+	}
+	if resp.Count != 1 || len(resp.Members) != 1 {
+		t.Fatalf("Members = %+v, want node-2 only", resp.Members)
+	}
+	system := resp.Members[0]
+	if system.Id != "d8:3a:dd:01:02:04" || system.Name != "node-2" || system.PowerState != "On" {
+		t.Errorf("Members[0] = %+v, want node-2 powered on", system)
+	}
+	nics := system.EthernetInterfaces.Members
+	if len(nics) != 1 || len(nics[0].IPv4Addresses) != 1 ||
+		nics[0].IPv4Addresses[0].Address != "192.168.1.51" {
+		t.Errorf("EthernetInterfaces = %+v, want the lease of node-2", system.EthernetInterfaces)
+	}
+
+	rec = httptest.NewRecorder()
+	s.ListSystems(rec, httptest.NewRequest(http.MethodGet, "/redfish/v1/Systems?$expand=~", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("GET /redfish/v1/Systems?$expand=~ = %d, want 400", rec.Code)
+	}
+}
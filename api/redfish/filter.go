@@ -0,0 +1,230 @@
+package redfish
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// Properties of a system that a $filter on the Systems collection can test.
+const (
+	filterId          = "Id"
+	filterUUID        = "UUID"
+	filterHostName    = "HostName"
+	filterIPAddress   = "IPAddress"
+	filterPowerState  = "PowerState"
+	filterBootProfile = "BootProfile"
+)
+
+var filterProperties = map[string]bool{
+	filterId:          true,
+	filterUUID:        true,
+	filterHostName:    true,
+	filterIPAddress:   true,
+	filterPowerState:  true,
+	filterBootProfile: true,
+}
+
+var errInvalidFilter = errors.New("invalid $filter")
+
+// systemFilter reports whether a system matches. lookup returns the value of
+// a property of the system and is only called for properties the filter uses.
+type systemFilter func(lookup func(property string) string) bool
+
+// parseFilter parses a Redfish $filter expression such as
+//
+//	HostName eq 'node-1' or (PowerState eq On and not BootProfile eq inspector)
+//
+// Comparisons use eq or ne and are case-insensitive. An IPAddress compared
+// with a prefix such as 10.0.0.0/24 matches every address in the prefix.
+func parseFilter(expr string) (systemFilter, error) {
+	tokens, err := tokenizeFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("%w: empty expression", errInvalidFilter)
+	}
+
+	p := &filterParser{tokens: tokens}
+	filter, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("%w: unexpected %q", errInvalidFilter, p.tokens[p.pos].text)
+	}
+
+	return filter, nil
+}
+
+type filterToken struct {
+	text   string
+	quoted bool
+}
+
+func tokenizeFilter(expr string) ([]filterToken, error) {
+	var tokens []filterToken
+	for i := 0; i < len(expr); {
+		switch c := expr[i]; {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, filterToken{text: string(c)})
+			i++
+		case c == '\'':
+			// Quotes inside a literal are escaped by doubling them.
+			var b strings.Builder
+			for i++; ; i++ {
+				if i >= len(expr) {
+					return nil, fmt.Errorf("%w: unterminated string", errInvalidFilter)
+				}
+				if expr[i] == '\'' {
+					if i+1 < len(expr) && expr[i+1] == '\'' {
+						b.WriteByte('\'')
+						i++
+						continue
+					}
+					i++
+					break
+				}
+				b.WriteByte(expr[i])
+			}
+			tokens = append(tokens, filterToken{text: b.String(), quoted: true})
+		default:
+			end := strings.IndexAny(expr[i:], " \t()'")
+			if end < 0 {
+				end = len(expr) - i
+			}
+			tokens = append(tokens, filterToken{text: expr[i : i+end]})
+			i += end
+		}
+	}
+
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+// keyword consumes the next token if it is the unquoted keyword word.
+func (p *filterParser) keyword(word string) bool {
+	if p.pos < len(p.tokens) && !p.tokens[p.pos].quoted && p.tokens[p.pos].text == word {
+		p.pos++
+		return true
+	}
+
+	return false
+}
+
+func (p *filterParser) next() (filterToken, error) {
+	if p.pos >= len(p.tokens) {
+		return filterToken{}, fmt.Errorf("%w: unexpected end of expression", errInvalidFilter)
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+
+	return t, nil
+}
+
+func (p *filterParser) or() (systemFilter, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(lookup func(string) string) bool { return l(lookup) || right(lookup) }
+	}
+
+	return left, nil
+}
+
+func (p *filterParser) and() (systemFilter, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(lookup func(string) string) bool { return l(lookup) && right(lookup) }
+	}
+
+	return left, nil
+}
+
+func (p *filterParser) unary() (systemFilter, error) {
+	if p.keyword("not") {
+		inner, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(lookup func(string) string) bool { return !inner(lookup) }, nil
+	}
+	if p.keyword("(") {
+		inner, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if !p.keyword(")") {
+			return nil, fmt.Errorf("%w: missing )", errInvalidFilter)
+		}
+		return inner, nil
+	}
+
+	return p.comparison()
+}
+
+func (p *filterParser) comparison() (systemFilter, error) {
+	property, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if property.quoted || !filterProperties[property.text] {
+		return nil, fmt.Errorf("%w: unsupported property %q", errInvalidFilter, property.text)
+	}
+	op, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if op.quoted || (op.text != "eq" && op.text != "ne") {
+		return nil, fmt.Errorf("%w: unsupported operator %q", errInvalidFilter, op.text)
+	}
+	value, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if !value.quoted && (value.text == "(" || value.text == ")") {
+		return nil, fmt.Errorf("%w: missing value for %s", errInvalidFilter, property.text)
+	}
+
+	match := func(got string) bool { return strings.EqualFold(got, value.text) }
+	if property.text == filterIPAddress {
+		if prefix, err := netip.ParsePrefix(value.text); err == nil {
+			match = func(got string) bool {
+				addr, err := netip.ParseAddr(got)
+				return err == nil && prefix.Contains(addr.Unmap())
+			}
+		} else if want, err := netip.ParseAddr(value.text); err == nil {
+			match = func(got string) bool {
+				addr, err := netip.ParseAddr(got)
+				return err == nil && addr.Unmap() == want.Unmap()
+			}
+		}
+	}
+
+	name, negate := property.text, op.text == "ne"
+
+	return func(lookup func(string) string) bool { return match(lookup(name)) != negate }, nil
+}
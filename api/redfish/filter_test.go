@@ -0,0 +1,62 @@
+package redfish
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+	system := map[string]string{
+		filterId:          "aa:bb:cc:dd:ee:ff",
+		filterHostName:    "node-1",
+		filterIPAddress:   "10.0.0.12",
+		filterPowerState:  "On",
+		filterBootProfile: "inspector",
+	}
+	lookup := func(property string) string { return system[property] }
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{expr: "HostName eq 'node-1'", want: true},
+		{expr: "HostName eq 'NODE-1'", want: true},
+		{expr: "HostName ne 'node-1'", want: false},
+		{expr: "Id eq 'AA:BB:CC:DD:EE:FF'", want: true},
+		{expr: "IPAddress eq 10.0.0.12", want: true},
+		{expr: "IPAddress eq 10.0.0.0/24", want: true},
+		{expr: "IPAddress eq 10.0.1.0/24", want: false},
+		{expr: "PowerState eq On and BootProfile eq inspector", want: true},
+		{expr: "PowerState eq Off or BootProfile eq 'inspector'", want: true},
+		{expr: "not PowerState eq Off and HostName eq 'node-1'", want: true},
+		{expr: "PowerState eq Off and (HostName eq 'node-1' or HostName eq 'node-2')", want: false},
+		{expr: "HostName eq 'it''s'", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			filter, err := parseFilter(tt.expr)
+			if err != nil {
+				t.Fatalf("parseFilter() error = %v", err)
+			}
+			if got := filter(lookup); got != tt.want {
+				t.Errorf("filter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"Model eq 'pi4'",
+		"HostName gt 'a'",
+		"HostName eq",
+		"HostName eq 'node-1",
+		"(HostName eq 'node-1'",
+		"HostName eq 'node-1' PowerState eq On",
+	} {
+		if _, err := parseFilter(expr); !errors.Is(err, errInvalidFilter) {
+			t.Errorf("parseFilter(%q) error = %v, want errInvalidFilter", expr, err)
+		}
+	}
+}
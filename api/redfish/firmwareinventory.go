@@ -0,0 +1,263 @@
+package redfish
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/metal3-community/metal-boot/internal/util"
+	"github.com/metal3-community/uefi-firmware-manager/edk2"
+	"github.com/metal3-community/uefi-firmware-manager/manager"
+	"github.com/metal3-community/uefi-firmware-manager/varstore"
+)
+
+const firmwareInventoryPath = "/redfish/v1/UpdateService/FirmwareInventory"
+
+// Components of the firmware of a system. Their FirmwareInventory members are
+// named "<component>-<MAC with dashes>", for example "uefi-d8-3a-dd-01-02-03",
+// and stay the same across restarts so that updates can target them.
+const (
+	componentUEFI   = "uefi"
+	componentEEPROM = "eeprom"
+
+	// eepromFileName is the Raspberry Pi bootloader EEPROM image.
+	eepromFileName = "pieeprom.bin"
+)
+
+var errUnknownFirmwareTarget = errors.New("unknown firmware update target")
+
+// systemFirmware is one firmware component of one system.
+type systemFirmware struct {
+	component string
+	mac       net.HardwareAddr
+}
+
+// id returns the FirmwareInventory member id of f.
+func (f systemFirmware) id() string {
+	return f.component + "-" + strings.ReplaceAll(f.mac.String(), ":", "-")
+}
+
+// parseSystemFirmwareId is the inverse of systemFirmware.id.
+func parseSystemFirmwareId(id string) (systemFirmware, bool) {
+	component, macDir, ok := strings.Cut(id, "-")
+	if !ok || (component != componentUEFI && component != componentEEPROM) {
+		return systemFirmware{}, false
+	}
+	mac, err := net.ParseMAC(strings.ReplaceAll(macDir, "-", ":"))
+	if err != nil {
+		return systemFirmware{}, false
+	}
+
+	return systemFirmware{component: component, mac: mac}, true
+}
+
+// systemFirmwareDir is the directory below the TFTP root that holds the
+// firmware of mac.
+func (s *RedfishServer) systemFirmwareDir(mac net.HardwareAddr) string {
+	return filepath.Join(s.Config.Tftp.RootDirectory, strings.ReplaceAll(mac.String(), ":", "-"))
+}
+
+// systemFirmwarePath returns the file the system boots f from. The UEFI image
+// lives in the system's own directory below the TFTP root; the EEPROM image
+// falls back to the shared one the TFTP server hands out when the system has
+// none.
+func (s *RedfishServer) systemFirmwarePath(f systemFirmware) string {
+	dir := s.systemFirmwareDir(f.mac)
+	if f.component == componentUEFI {
+		return filepath.Join(dir, edk2.FirmwareFileName)
+	}
+
+	path := filepath.Join(dir, eepromFileName)
+	if _, err := os.Stat(path); err != nil {
+		return filepath.Join(s.Config.Tftp.RootDirectory, eepromFileName)
+	}
+
+	return path
+}
+
+// systemFirmwareMembers lists the firmware components of mac. The EEPROM is
+// only listed when an image is available for it.
+func (s *RedfishServer) systemFirmwareMembers(mac net.HardwareAddr) []IdRef {
+	members := []IdRef{}
+	for _, component := range []string{componentUEFI, componentEEPROM} {
+		f := systemFirmware{component: component, mac: mac}
+		if component == componentEEPROM {
+			if _, err := os.Stat(s.systemFirmwarePath(f)); err != nil {
+				continue
+			}
+		}
+		members = append(members, IdRef{OdataId: util.Ptr(firmwareInventoryPath + "/" + f.id())})
+	}
+
+	return members
+}
+
+// systemSoftwareInventory describes the firmware component f.
+func (s *RedfishServer) systemSoftwareInventory(
+	ctx context.Context,
+	f systemFirmware,
+) (SoftwareInventory, error) {
+	path := s.systemFirmwarePath(f)
+	data, err := os.ReadFile(path)
+
+	var name, version, description string
+	var released *time.Time
+	switch f.component {
+	case componentUEFI:
+		name = "UEFI Firmware"
+		description = "EDK2 firmware at " + path
+		if errors.Is(err, os.ErrNotExist) {
+			// Systems without their own image get the embedded one.
+			data, err = edk2.RpiEfi, nil
+			description = "Embedded EDK2 firmware"
+		}
+		if err == nil {
+			version = uefiVersion(data)
+		}
+	case componentEEPROM:
+		name = "Bootloader EEPROM"
+		description = "Raspberry Pi bootloader EEPROM image at " + path
+		if err == nil {
+			version, released = eepromVersion(data)
+		}
+	}
+	if err != nil {
+		return SoftwareInventory{}, err
+	}
+
+	return SoftwareInventory{
+		OdataId:     util.Ptr(firmwareInventoryPath + "/" + f.id()),
+		OdataType:   util.Ptr(odataType(ctx, "SoftwareInventory")),
+		Id:          util.Ptr(f.id()),
+		Name:        util.Ptr(name),
+		Description: util.Ptr(description),
+		Version:     util.Ptr(version),
+		ReleaseDate: released,
+		SoftwareId:  util.Ptr(f.component),
+		Status: &Status{
+			State:  util.Ptr(StateEnabled),
+			Health: util.Ptr(HealthOK),
+		},
+		Updateable: util.Ptr(true),
+		RelatedItem: &[]IdRef{
+			{OdataId: util.Ptr("/redfish/v1/Systems/" + f.mac.String())},
+		},
+		RelatedItemOdataCount: util.Ptr(1),
+	}, nil
+}
+
+// firmwareTarget returns the system firmware component a SimpleUpdate is
+// aimed at. Targets may name a FirmwareInventory member or a system, which
+// selects its UEFI firmware. ok is false when no target names a system, in
+// which case the shared firmware is updated.
+func (s *RedfishServer) firmwareTarget(targets []string) (f systemFirmware, ok bool, err error) {
+	for _, target := range targets {
+		target = strings.TrimSuffix(target, "/")
+		if id, found := strings.CutPrefix(target, firmwareInventoryPath+"/"); found {
+			if f, ok := parseSystemFirmwareId(id); ok {
+				return f, true, nil
+			}
+			if id == filepath.Base(s.firmwarePath) {
+				continue
+			}
+			return systemFirmware{}, false, fmt.Errorf("%w: %s", errUnknownFirmwareTarget, target)
+		}
+		if systemId, found := strings.CutPrefix(target, "/redfish/v1/Systems/"); found {
+			mac, err := s.systemMAC(systemId)
+			if err != nil {
+				err = fmt.Errorf("%w: %s", errUnknownFirmwareTarget, target)
+				return systemFirmware{}, false, err
+			}
+			return systemFirmware{component: componentUEFI, mac: mac}, true, nil
+		}
+	}
+
+	return systemFirmware{}, false, nil
+}
+
+// firmwareInventoryURI returns the FirmwareInventory member an update of
+// target replaces: the component of a system if targetsSystem, the shared
+// firmware otherwise.
+func (s *RedfishServer) firmwareInventoryURI(target systemFirmware, targetsSystem bool) string {
+	if targetsSystem {
+		return firmwareInventoryPath + "/" + target.id()
+	}
+
+	return firmwareInventoryPath + "/" + filepath.Base(s.firmwarePath)
+}
+
+// installSystemFirmware replaces the firmware component f with data.
+func (s *RedfishServer) installSystemFirmware(f systemFirmware, data []byte) error {
+	if f.component == componentUEFI {
+		firmwareMgr, err := manager.NewEDK2Manager(s.systemFirmwarePath(f), s.Log)
+		if err != nil {
+			return err
+		}
+		return firmwareMgr.UpdateFirmware(data)
+	}
+
+	// A system with no EEPROM image of its own gets one, leaving the shared
+	// image alone.
+	path := filepath.Join(s.systemFirmwareDir(f.mac), eepromFileName)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// uefiVersion returns the FirmwareRevision variable of an EDK2 image.
+func uefiVersion(data []byte) string {
+	vs, err := varstore.New(data)
+	if err != nil {
+		return "Unknown"
+	}
+	vars, err := vs.GetVarList()
+	if err != nil {
+		return "Unknown"
+	}
+	if rev, ok := vars["FirmwareRevision"]; ok && len(rev.Data) > 0 {
+		return string(rev.Data)
+	}
+
+	return "Unknown"
+}
+
+// eepromVersion reads the VERSION and BUILD_TIMESTAMP strings the Raspberry Pi
+// bootloader build embeds in its EEPROM images.
+func eepromVersion(data []byte) (string, *time.Time) {
+	version := "Unknown"
+	if _, after, ok := bytes.Cut(data, []byte("VERSION:")); ok {
+		if end := bytes.IndexFunc(after, isNotHex); end > 0 {
+			version = string(after[:end])
+		}
+	}
+
+	var released *time.Time
+	if _, after, ok := bytes.Cut(data, []byte("BUILD_TIMESTAMP=")); ok {
+		end := bytes.IndexFunc(after, func(r rune) bool { return r < '0' || r > '9' })
+		if end < 0 {
+			end = len(after)
+		}
+		if ts, err := strconv.ParseInt(string(after[:end]), 10, 64); err == nil {
+			released = util.Ptr(time.Unix(ts, 0).UTC())
+		}
+	}
+
+	return version, released
+}
+
+func isNotHex(r rune) bool {
+	return !strings.ContainsRune("0123456789abcdefABCDEF", r)
+}
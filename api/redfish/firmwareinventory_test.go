@@ -0,0 +1,102 @@
+package redfish
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/metal3-community/metal-boot/internal/config"
+)
+
+func TestFirmwareTarget(t *testing.T) {
+	s := &RedfishServer{firmwarePath: "/tftp/RPI_EFI.fd"}
+	mac, _ := net.ParseMAC("d8:3a:dd:01:02:03")
+
+	tests := []struct {
+		name       string
+		targets    []string
+		want       systemFirmware
+		wantSystem bool
+		wantErr    error
+	}{
+		{name: "no targets"},
+		{name: "shared firmware", targets: []string{firmwareInventoryPath + "/RPI_EFI.fd"}},
+		{
+			name:       "system uefi",
+			targets:    []string{firmwareInventoryPath + "/uefi-d8-3a-dd-01-02-03"},
+			want:       systemFirmware{component: componentUEFI, mac: mac},
+			wantSystem: true,
+		},
+		{
+			name:       "system eeprom",
+			targets:    []string{firmwareInventoryPath + "/eeprom-d8-3a-dd-01-02-03/"},
+			want:       systemFirmware{component: componentEEPROM, mac: mac},
+			wantSystem: true,
+		},
+		{
+			name:       "system",
+			targets:    []string{"/redfish/v1/Systems/d8:3a:dd:01:02:03"},
+			want:       systemFirmware{component: componentUEFI, mac: mac},
+			wantSystem: true,
+		},
+		{
+			name:    "unknown member",
+			targets: []string{firmwareInventoryPath + "/gpu-d8-3a-dd-01-02-03"},
+			wantErr: errUnknownFirmwareTarget,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok, err := s.firmwareTarget(tt.targets)
+			if !errors.Is(err, tt.wantErr) || ok != tt.wantSystem {
+				t.Fatalf("firmwareTarget() = %v, %v, want %v, %v", ok, err, tt.wantSystem, tt.wantErr)
+			}
+			if ok && (got.component != tt.want.component || got.mac.String() != tt.want.mac.String()) {
+				t.Errorf("firmwareTarget() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSystemSoftwareInventory(t *testing.T) {
+	root := t.TempDir()
+	s := &RedfishServer{
+		Config: &config.Config{Tftp: config.TftpConfig{RootDirectory: root}},
+		Log:    logr.Discard(),
+	}
+	mac, _ := net.ParseMAC("d8:3a:dd:01:02:03")
+
+	if got := s.systemFirmwareMembers(mac); len(got) != 1 {
+		t.Fatalf("systemFirmwareMembers() without an EEPROM image = %d members, want 1", len(got))
+	}
+	uefi, err := s.systemSoftwareInventory(t.Context(), systemFirmware{component: componentUEFI, mac: mac})
+	if err != nil || *uefi.Id != "uefi-d8-3a-dd-01-02-03" {
+		t.Fatalf("systemSoftwareInventory(uefi) = %+v, %v", uefi, err)
+	}
+
+	eeprom := []byte("\x00FREEZE_VERSION\x00VERSION:75c1e570\x00BUILD_TIMESTAMP=1739293213\x00")
+	if err := os.WriteFile(filepath.Join(root, eepromFileName), eeprom, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.systemFirmwareMembers(mac); len(got) != 2 {
+		t.Fatalf("systemFirmwareMembers() with a shared EEPROM image = %d members, want 2", len(got))
+	}
+
+	f := systemFirmware{component: componentEEPROM, mac: mac}
+	if err := s.installSystemFirmware(f, eeprom); err != nil {
+		t.Fatalf("installSystemFirmware() error = %v", err)
+	}
+	inv, err := s.systemSoftwareInventory(t.Context(), f)
+	if err != nil {
+		t.Fatalf("systemSoftwareInventory(eeprom) error = %v", err)
+	}
+	if *inv.Version != "75c1e570" || inv.ReleaseDate == nil || inv.ReleaseDate.Unix() != 1739293213 {
+		t.Errorf("Version = %s, ReleaseDate = %v", *inv.Version, inv.ReleaseDate)
+	}
+	if want := filepath.Join(root, "d8-3a-dd-01-02-03", eepromFileName); s.systemFirmwarePath(f) != want {
+		t.Errorf("systemFirmwarePath() after install = %s, want %s", s.systemFirmwarePath(f), want)
+	}
+}
@@ -0,0 +1,241 @@
+package redfish
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/metal3-community/metal-boot/internal/util"
+	"github.com/metal3-community/uefi-firmware-manager/varstore"
+	"go.opentelemetry.io/otel"
+)
+
+// firmwareImageFields are the multipart fields that may carry the image: the
+// UpdateFile field of the Redfish multipart push and the name older clients
+// use.
+var firmwareImageFields = []string{"UpdateFile", "softwareImage"}
+
+var (
+	errNoFirmwareImage   = errors.New("no firmware image in the request")
+	errInvalidFirmware   = errors.New("invalid firmware image")
+	errFirmwarePathUnset = errors.New("firmware path not configured")
+)
+
+// firmwareUpload is an uploaded image waiting in a temporary file next to the
+// live firmware.
+type firmwareUpload struct {
+	path   string
+	size   int64
+	sha256 string
+	// checksum is the SHA-256 the client expects, taken from
+	// UpdateParameters.Oem.MetalBoot.Sha256. Empty skips the comparison.
+	checksum string
+}
+
+// FirmwareInventoryDownloadImage implements ServerInterface. The image is
+// streamed to a temporary file, verified and only then renamed over the live
+// firmware, so that a truncated or corrupt upload never reaches a node.
+func (s *RedfishServer) FirmwareInventoryDownloadImage(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer(tracerName).
+		Start(r.Context(), "redfish.RedfishServer.FirmwareInventoryDownloadImage")
+	defer span.End()
+
+	if s.firmwarePath == "" {
+		s.Log.Error(errFirmwarePathUnset, "firmware path not set")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(redfishError(errFirmwarePathUnset))
+		return
+	}
+
+	if limit := s.Config.FirmwareUpload.MaxSizeMB << 20; limit > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+	}
+	upload, err := s.receiveFirmware(r)
+	if err != nil {
+		s.Log.Error(err, "error receiving firmware image")
+		status := http.StatusBadRequest
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+	s.Log.Info("received firmware image", "size", upload.size, "sha256", upload.sha256)
+
+	if threshold := s.Config.FirmwareUpload.TaskThresholdMB << 20; threshold > 0 &&
+		upload.size > threshold {
+		s.acceptFirmwareUpload(ctx, w, upload)
+		return
+	}
+
+	if err := s.installFirmware(upload); err != nil {
+		s.Log.Error(err, "error installing firmware image", "path", s.firmwarePath)
+		status := http.StatusInternalServerError
+		if errors.Is(err, errInvalidFirmware) {
+			status = http.StatusBadRequest
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// acceptFirmwareUpload answers with a Task and installs upload in the
+// background.
+func (s *RedfishServer) acceptFirmwareUpload(
+	ctx context.Context,
+	w http.ResponseWriter,
+	upload *firmwareUpload,
+) {
+	inventoryPath := "/redfish/v1/UpdateService/FirmwareInventory/" + filepath.Base(s.firmwarePath)
+	taskId := fmt.Sprintf("firmware-upload-%d", time.Now().Unix())
+	response := Task{
+		OdataId:     util.Ptr(fmt.Sprintf("/redfish/v1/TaskService/Tasks/%s", taskId)),
+		OdataType:   util.Ptr(odataType(ctx, "Task")),
+		Id:          &taskId,
+		Name:        util.Ptr("Firmware Upload Task"),
+		Description: util.Ptr("The new image is reported on " + inventoryPath),
+		TaskState:   util.Ptr(TaskStateRunning),
+		StartTime:   util.Ptr(time.Now()),
+		TaskMonitor: util.Ptr(taskMonitorPath + "/" + taskId),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", inventoryPath)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(response)
+
+	tk := s.tasks.Start(taskId, "Firmware Upload Task", *response.Description, inventoryPath)
+	go func() {
+		err := s.installFirmware(upload)
+		tk.Done(err)
+		if err != nil {
+			s.Log.Error(err, "error installing firmware image", "path", s.firmwarePath)
+			return
+		}
+		s.Log.Info("installed firmware image", "path", s.firmwarePath, "sha256", upload.sha256)
+	}()
+}
+
+// receiveFirmware streams the image of the multipart request r to a temporary
+// file next to the firmware path and reads the optional UpdateParameters.
+func (s *RedfishServer) receiveFirmware(r *http.Request) (*firmwareUpload, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	var upload *firmwareUpload
+	var params struct {
+		Oem *simpleUpdateOem `json:"Oem"`
+	}
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			discardUpload(upload)
+			return nil, err
+		}
+
+		switch name := part.FormName(); {
+		case name == "UpdateParameters":
+			err = json.NewDecoder(io.LimitReader(part, 64<<10)).Decode(&params)
+		case slices.Contains(firmwareImageFields, name) && upload == nil:
+			upload, err = s.spoolFirmware(part)
+		}
+		part.Close()
+		if err != nil {
+			discardUpload(upload)
+			return nil, err
+		}
+	}
+
+	if upload == nil {
+		return nil, errNoFirmwareImage
+	}
+	if params.Oem != nil {
+		upload.checksum = params.Oem.MetalBoot.Sha256
+	}
+
+	return upload, nil
+}
+
+// spoolFirmware copies src to a temporary file in the directory of the
+// firmware path, so that installing it is a rename.
+func (s *RedfishServer) spoolFirmware(src io.Reader) (*firmwareUpload, error) {
+	f, err := os.CreateTemp(
+		filepath.Dir(s.firmwarePath),
+		"."+filepath.Base(s.firmwarePath)+".upload-*",
+	)
+	if err != nil {
+		return nil, err
+	}
+	upload := &firmwareUpload{path: f.Name()}
+
+	h := sha256.New()
+	upload.size, err = io.Copy(io.MultiWriter(f, h), src)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		discardUpload(upload)
+		return nil, err
+	}
+	upload.sha256 = hex.EncodeToString(h.Sum(nil))
+
+	return upload, nil
+}
+
+// installFirmware verifies upload and renames it over the firmware path. The
+// temporary file is removed when verification fails.
+func (s *RedfishServer) installFirmware(upload *firmwareUpload) error {
+	defer discardUpload(upload)
+
+	if upload.size == 0 {
+		return fmt.Errorf("%w: empty image", errInvalidFirmware)
+	}
+	if upload.checksum != "" && !strings.EqualFold(upload.checksum, upload.sha256) {
+		return fmt.Errorf("%w: sha256 is %s, want %s",
+			errInvalidFirmware, upload.sha256, upload.checksum)
+	}
+	data, err := os.ReadFile(upload.path)
+	if err != nil {
+		return err
+	}
+	if _, err := varstore.New(data); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidFirmware, err)
+	}
+
+	// CreateTemp makes the file private; the firmware is served over TFTP.
+	if err := os.Chmod(upload.path, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(upload.path, s.firmwarePath)
+}
+
+// discardUpload removes the temporary file of upload, if any is left.
+func discardUpload(upload *firmwareUpload) {
+	if upload != nil {
+		_ = os.Remove(upload.path)
+	}
+}
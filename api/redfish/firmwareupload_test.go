@@ -0,0 +1,119 @@
+package redfish
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/metal3-community/metal-boot/internal/config"
+	"github.com/metal3-community/uefi-firmware-manager/edk2"
+)
+
+func TestFirmwareInventoryDownloadImage(t *testing.T) {
+	sum := sha256.Sum256(edk2.RpiEfi)
+
+	tests := []struct {
+		name       string
+		image      []byte
+		params     string
+		upload     config.FirmwareUploadConfig
+		wantStatus int
+		wantSwap   bool
+	}{
+		{
+			name:       "valid image",
+			image:      edk2.RpiEfi,
+			params:     `{"Oem":{"MetalBoot":{"Sha256":"` + hex.EncodeToString(sum[:]) + `"}}}`,
+			upload:     config.FirmwareUploadConfig{MaxSizeMB: 64},
+			wantStatus: http.StatusNoContent,
+			wantSwap:   true,
+		},
+		{
+			name:       "large image becomes a task",
+			image:      edk2.RpiEfi,
+			upload:     config.FirmwareUploadConfig{MaxSizeMB: 64, TaskThresholdMB: 1},
+			wantStatus: http.StatusAccepted,
+			wantSwap:   true,
+		},
+		{
+			name:       "not a firmware volume",
+			image:      bytes.Repeat([]byte{0xff}, 4096),
+			upload:     config.FirmwareUploadConfig{MaxSizeMB: 64},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "checksum mismatch",
+			image:      edk2.RpiEfi,
+			params:     `{"Oem":{"MetalBoot":{"Sha256":"00"}}}`,
+			upload:     config.FirmwareUploadConfig{MaxSizeMB: 64},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "over the size limit",
+			image:      make([]byte, 2<<20),
+			upload:     config.FirmwareUploadConfig{MaxSizeMB: 1},
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			firmware := filepath.Join(dir, edk2.FirmwareFileName)
+			if err := os.WriteFile(firmware, []byte("old"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			s := &RedfishServer{
+				Config:       &config.Config{FirmwareUpload: tt.upload},
+				Log:          logr.Discard(),
+				firmwarePath: firmware,
+			}
+
+			var body bytes.Buffer
+			mw := multipart.NewWriter(&body)
+			if tt.params != "" {
+				_ = mw.WriteField("UpdateParameters", tt.params)
+			}
+			fw, _ := mw.CreateFormFile("UpdateFile", "RPI_EFI.fd")
+			_, _ = fw.Write(tt.image)
+			_ = mw.Close()
+
+			req := httptest.NewRequest(http.MethodPost, "/redfish/v1/UpdateService/FirmwareInventory", &body)
+			req.Header.Set("Content-Type", mw.FormDataContentType())
+			rec := httptest.NewRecorder()
+			s.FirmwareInventoryDownloadImage(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+
+			want := []byte("old")
+			if tt.wantSwap {
+				want = edk2.RpiEfi
+			}
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				got, _ := os.ReadFile(firmware)
+				if bytes.Equal(got, want) {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("firmware has %d bytes, want %d", len(got), len(want))
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			if tt.wantStatus != http.StatusAccepted {
+				if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+					t.Errorf("%d files left in the firmware directory, want 1", len(entries))
+				}
+			}
+		})
+	}
+}
@@ -1,19 +1,49 @@
 package redfish
 
 import (
+	"encoding/json"
 	"log/slog"
 	"net/http"
 
 	"github.com/metal3-community/metal-boot/internal/backend"
+	"github.com/metal3-community/metal-boot/internal/biosattr"
 	"github.com/metal3-community/metal-boot/internal/config"
+	"github.com/metal3-community/metal-boot/internal/download"
+	"github.com/metal3-community/metal-boot/internal/hoststate"
+	"github.com/metal3-community/metal-boot/internal/readonly"
+	"github.com/metal3-community/metal-boot/internal/selfupdate"
+	"github.com/metal3-community/metal-boot/internal/session"
+	"github.com/metal3-community/metal-boot/internal/task"
+	"github.com/metal3-community/metal-boot/internal/telemetry"
+	"github.com/metal3-community/metal-boot/internal/tlscert"
 )
 
+// New returns the Redfish handler. While readOnly is enabled, requests that
+// would change a system, such as resets, PATCHes and firmware updates, are
+// rejected with 403. When telemetry is non-nil its metric reports are served
+// under the TelemetryService and streamed by the EventService. bios defines
+// the attributes of the Bios resources. Downloads of update tasks are
+// recorded on downloads and reported as Tasks. sessions issues the tokens of
+// the SessionService; a request that presents an unknown token is rejected
+// with 401. tasks records the firmware updates and power operations the
+// TaskService reports. The schema versions advertised to each client follow
+// cfg.RedfishSchemas.
+//
 //go:generate go tool oapi-codegen -package redfish -o server.gen.go -generate std-http-server,models openapi.yaml
 func New(
 	logger *slog.Logger,
 	cfg *config.Config,
 	reader backend.BackendReader,
 	pwrBackend backend.BackendPower,
+	hosts *hoststate.Store,
+	certs *tlscert.Store,
+	updater *selfupdate.Updater,
+	readOnly *readonly.Switch,
+	telemetry *telemetry.Service,
+	bios *biosattr.Registry,
+	downloads *download.Tracker,
+	sessions *session.Store,
+	tasks *task.Store,
 ) http.Handler {
 	mux := http.NewServeMux()
 
@@ -23,8 +53,125 @@ func New(
 		reader:       reader,
 		firmwarePath: cfg.FirmwarePath,
 		power:        pwrBackend,
+		hosts:        hosts,
+		certs:        certs,
+		updater:      updater,
+		telemetry:    telemetry,
+		bios:         bios,
+		downloads:    downloads,
+		sessions:     sessions,
+		tasks:        tasks,
+	}
+	if cfg.Cleaning.Enabled {
+		// Without a secret SecureErase is refused rather than wiping hosts
+		// whose callbacks would be rejected.
+		tokens, err := newCleaningTokens(cfg.Cleaning)
+		if err != nil {
+			server.Log.Error(err, "disabling secure erase")
+		}
+		server.cleaningTokens = tokens
+	}
+
+	schemas, err := newSchemaPolicy(cfg.RedfishSchemas)
+	if err != nil {
+		// The built-in versions are what every client saw before the
+		// versions became configurable.
+		server.Log.Error(err, "ignoring redfish schema versions")
+		schemas = nil
 	}
 
+	mux.HandleFunc(
+		"POST /redfish/v1/Systems/{systemId}/Actions/Oem/"+secureEraseAction,
+		server.SecureErase,
+	)
+	mux.HandleFunc(
+		"POST /redfish/v1/Systems/{systemId}/Actions/Oem/"+cleaningCompleteAction,
+		server.CleaningComplete,
+	)
+	mux.HandleFunc("GET "+certificateServicePath, server.GetCertificateService)
+	mux.HandleFunc(
+		"GET "+certificateServicePath+"/CertificateLocations",
+		server.GetCertificateLocations,
+	)
+	mux.HandleFunc("POST "+replaceCertificatePath, server.ReplaceCertificate)
+	mux.HandleFunc("POST "+generateCSRPath, server.GenerateCSR)
+	mux.HandleFunc("GET "+httpsCertificatesPath, server.GetHTTPSCertificates)
+	mux.HandleFunc("GET "+httpsCertificatePath, server.GetHTTPSCertificate)
+	mux.HandleFunc("GET "+telemetryServicePath, server.GetTelemetryService)
+	mux.HandleFunc("GET "+metricReportDefinitionsPath, server.ListMetricReportDefinitions)
+	mux.HandleFunc(
+		"GET "+metricReportDefinitionsPath+"/{definitionId}",
+		server.GetMetricReportDefinition,
+	)
+	mux.HandleFunc("GET "+metricReportsPath, server.ListMetricReports)
+	mux.HandleFunc("GET "+metricReportsPath+"/{reportId}", server.GetMetricReport)
+	mux.HandleFunc("GET "+eventServicePath, server.GetEventService)
+	mux.HandleFunc("GET "+eventServiceSSEPath, server.StreamEvents)
+	mux.HandleFunc("GET /redfish/v1/Systems/{systemId}/BootOptions", server.ListBootOptions)
+	mux.HandleFunc(
+		"GET /redfish/v1/Systems/{systemId}/BootOptions/{bootOptionId}",
+		server.GetBootOption,
+	)
+	mux.HandleFunc(
+		"PATCH /redfish/v1/Systems/{systemId}/BootOptions/{bootOptionId}",
+		server.SetBootOption,
+	)
+	mux.HandleFunc("GET /redfish/v1/Systems/{systemId}/Bios", server.GetBIOS)
+	mux.HandleFunc("PATCH /redfish/v1/Systems/{systemId}/Bios", server.UpdateBIOS)
+	mux.HandleFunc("GET /redfish/v1/Systems/{systemId}/Bios/Settings", server.GetBIOSSettings)
+	mux.HandleFunc("PATCH /redfish/v1/Systems/{systemId}/Bios/Settings", server.UpdateBIOSSettings)
+	mux.HandleFunc("GET "+variablesPath("{systemId}"), server.ListVariables)
+	mux.HandleFunc("GET "+variablesPath("{systemId}")+"/Diff", server.DiffVariables)
+	mux.HandleFunc("GET "+dhcpPath("{systemId}"), server.GetDHCP)
+	mux.HandleFunc("GET "+ethernetInterfacesPath("{systemId}"), server.ListEthernetInterfaces)
+	mux.HandleFunc(
+		"GET "+ethernetInterfacesPath("{systemId}")+"/{interfaceId}",
+		server.GetEthernetInterface,
+	)
+	mux.HandleFunc("GET "+chassisCollectionPath, server.ListChassis)
+	mux.HandleFunc("GET "+chassisPath("{chassisId}"), server.GetChassis)
+	mux.HandleFunc("GET "+chassisPath("{chassisId}")+"/Thermal", server.GetThermal)
+	mux.HandleFunc("GET "+chassisPath("{chassisId}")+"/Power", server.GetPower)
+	mux.HandleFunc("GET "+secureBootPath("{systemId}"), server.GetSecureBoot)
+	mux.HandleFunc("PATCH "+secureBootPath("{systemId}"), server.UpdateSecureBoot)
+	mux.HandleFunc(
+		"GET "+secureBootPath("{systemId}")+"/SecureBootDatabases",
+		server.ListSecureBootDatabases,
+	)
+	mux.HandleFunc(
+		"GET "+secureBootDatabasePath("{systemId}", "{databaseId}"),
+		server.GetSecureBootDatabase,
+	)
+	mux.HandleFunc(
+		"GET "+secureBootCertificatesPath("{systemId}", "{databaseId}"),
+		server.ListSecureBootCertificates,
+	)
+	mux.HandleFunc(
+		"POST "+secureBootCertificatesPath("{systemId}", "{databaseId}"),
+		server.EnrollSecureBootCertificate,
+	)
+	mux.HandleFunc(
+		"GET "+secureBootCertificatesPath("{systemId}", "{databaseId}")+"/{certificateId}",
+		server.GetSecureBootCertificate,
+	)
+	mux.HandleFunc(
+		"DELETE "+secureBootCertificatesPath("{systemId}", "{databaseId}")+"/{certificateId}",
+		server.DeleteSecureBootCertificate,
+	)
+	mux.HandleFunc("GET "+registriesPath, server.ListRegistries)
+	mux.HandleFunc("GET "+registriesPath+"/{registryId}", server.GetRegistryFile)
+	mux.HandleFunc("GET "+biosAttributeRegistryPath(), server.GetBiosAttributeRegistry)
+	mux.HandleFunc("GET "+jsonSchemasPath, server.ListJsonSchemas)
+	mux.HandleFunc("GET "+jsonSchemasPath+"/{schemaId}", server.GetJsonSchema)
+	mux.HandleFunc("GET "+localSchemasPath+"/{file}", server.GetLocalSchema)
+	mux.HandleFunc("GET "+sessionServicePath, server.GetSessionService)
+	mux.HandleFunc("GET "+sessionsPath, server.ListSessions)
+	mux.HandleFunc("POST "+sessionsPath, server.CreateSession)
+	mux.HandleFunc("GET "+sessionsPath+"/{sessionId}", server.GetSession)
+	mux.HandleFunc("DELETE "+sessionsPath+"/{sessionId}", server.DeleteSession)
+	mux.HandleFunc("GET "+taskServicePath, server.GetTaskService)
+	mux.HandleFunc("GET "+taskMonitorPath+"/{taskId}", server.GetTaskMonitor)
+
 	options := StdHTTPServerOptions{
 		BaseURL:    "",
 		BaseRouter: mux,
@@ -43,5 +190,21 @@ func New(
 
 	// server.refreshSystems(context.Background())
 
-	return HandlerWithOptions(server, options)
+	handler := HandlerWithOptions(server, options)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !server.authenticateSession(w, r) {
+			return
+		}
+		if err := readOnly.Check(r.Method); err != nil && !sessionRequest(r) {
+			server.Log.Info("rejected redfish request in read-only mode",
+				"path", r.URL.Path,
+				"method", r.Method)
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(redfishError(err))
+			return
+		}
+		r = r.WithContext(withSchemaVersions(r.Context(), schemas.negotiate(r)))
+		handler.ServeHTTP(w, r)
+	})
 }
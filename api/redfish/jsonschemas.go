@@ -0,0 +1,127 @@
+package redfish
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/metal3-community/metal-boot/internal/util"
+	"go.opentelemetry.io/otel"
+)
+
+const (
+	jsonSchemasPath = "/redfish/v1/JsonSchemas"
+	// localSchemasPath serves the schema files bundled with the service, as
+	// recommended by DSP0266 for schemas that are not published by the DMTF.
+	localSchemasPath = "/redfish/v1/Schemas"
+	dmtfSchemasURL   = "https://redfish.dmtf.org/schemas/v1/"
+)
+
+// localSchemas holds the schemas the DMTF does not publish, such as the one
+// of the MetalBoot Oem section.
+//
+//go:embed schemas/*.json
+var localSchemas embed.FS
+
+type jsonSchemaFile struct {
+	OdataId     string               `json:"@odata.id"`
+	OdataType   string               `json:"@odata.type"`
+	Id          string               `json:"Id"`
+	Name        string               `json:"Name"`
+	Description string               `json:"Description"`
+	Languages   []string             `json:"Languages"`
+	Schema      string               `json:"Schema"`
+	Location    []jsonSchemaLocation `json:"Location"`
+}
+
+type jsonSchemaLocation struct {
+	Language       string `json:"Language"`
+	Uri            string `json:"Uri,omitempty"`
+	PublicationUri string `json:"PublicationUri,omitempty"`
+}
+
+// ListJsonSchemas lists a JsonSchemaFile for every schema the service emits.
+func (s *RedfishServer) ListJsonSchemas(w http.ResponseWriter, r *http.Request) {
+	_, span := otel.Tracer(tracerName).Start(r.Context(), "redfish.RedfishServer.ListJsonSchemas")
+	defer span.End()
+
+	ids := advertisedSchemas(r.Context())
+	members := make([]IdRef, 0, len(ids))
+	for _, id := range ids {
+		members = append(members, IdRef{OdataId: util.Ptr(jsonSchemasPath + "/" + id)})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(certificateCollection{
+		OdataId:      jsonSchemasPath,
+		OdataType:    "#JsonSchemaFileCollection.JsonSchemaFileCollection",
+		Name:         "JSON Schema File Collection",
+		Members:      members,
+		MembersCount: len(members),
+	})
+}
+
+// GetJsonSchema returns where to find one schema. Schemas bundled with the
+// service are linked through Uri, the others through their DMTF
+// PublicationUri.
+func (s *RedfishServer) GetJsonSchema(w http.ResponseWriter, r *http.Request) {
+	_, span := otel.Tracer(tracerName).Start(r.Context(), "redfish.RedfishServer.GetJsonSchema")
+	defer span.End()
+
+	id := r.PathValue("schemaId")
+	if !slices.Contains(advertisedSchemas(r.Context()), id) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(redfishError(fmt.Errorf("unknown schema %q", id)))
+		return
+	}
+
+	file := id + ".json"
+	location := jsonSchemaLocation{Language: "en"}
+	if _, err := fs.Stat(localSchemas, "schemas/"+file); err == nil {
+		location.Uri = localSchemasPath + "/" + file
+	} else {
+		location.PublicationUri = dmtfSchemasURL + file
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jsonSchemaFile{
+		OdataId:     jsonSchemasPath + "/" + id,
+		OdataType:   odataType(r.Context(), "JsonSchemaFile"),
+		Id:          id,
+		Name:        id + " Schema File",
+		Description: id + " Schema File Location",
+		Languages:   []string{"en"},
+		Schema:      "#" + id + "." + schemaTypeName(id),
+		Location:    []jsonSchemaLocation{location},
+	})
+}
+
+// GetLocalSchema serves a schema file bundled with the service.
+func (s *RedfishServer) GetLocalSchema(w http.ResponseWriter, r *http.Request) {
+	file := r.PathValue("file")
+	b, err := localSchemas.ReadFile("schemas/" + file)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(redfishError(fmt.Errorf("unknown schema file %q", file)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.Write(b)
+}
+
+// schemaTypeName returns the name of the type a schema describes: its
+// namespace for DMTF schemas, and ComputerSystem for the MetalBoot Oem
+// schema, which extends it.
+func schemaTypeName(id string) string {
+	namespace, _, _ := strings.Cut(id, ".")
+	if namespace == "MetalBoot" {
+		return "ComputerSystem"
+	}
+
+	return namespace
+}
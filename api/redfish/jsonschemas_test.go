@@ -0,0 +1,68 @@
+package redfish
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestJsonSchemas(t *testing.T) {
+	s := &RedfishServer{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+jsonSchemasPath+"/{schemaId}", s.GetJsonSchema)
+	mux.HandleFunc("GET "+localSchemasPath+"/{file}", s.GetLocalSchema)
+
+	tests := []struct {
+		name       string
+		schema     string
+		wantStatus int
+		want       jsonSchemaLocation
+	}{
+		{
+			name:       "dmtf schema",
+			schema:     "ComputerSystem.v1_11_0",
+			wantStatus: http.StatusOK,
+			want: jsonSchemaLocation{
+				Language:       "en",
+				PublicationUri: "https://redfish.dmtf.org/schemas/v1/ComputerSystem.v1_11_0.json",
+			},
+		},
+		{
+			name:       "bundled oem schema",
+			schema:     "MetalBoot.v1_0_0",
+			wantStatus: http.StatusOK,
+			want:       jsonSchemaLocation{Language: "en", Uri: "/redfish/v1/Schemas/MetalBoot.v1_0_0.json"},
+		},
+		{name: "unknown", schema: "Chassis.v1_0_0", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, jsonSchemasPath+"/"+tt.schema, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var got jsonSchemaFile
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatalf("decoding response: %v", err)
+			}
+			if len(got.Location) != 1 || got.Location[0] != tt.want {
+				t.Errorf("Location = %+v, want %+v", got.Location, tt.want)
+			}
+			if got.Location[0].Uri == "" {
+				return
+			}
+
+			rec = httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, got.Location[0].Uri, nil))
+			if rec.Code != http.StatusOK || !json.Valid(rec.Body.Bytes()) {
+				t.Errorf("GET %s = %d, valid JSON %v", got.Location[0].Uri, rec.Code, json.Valid(rec.Body.Bytes()))
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package redfish
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/metal3-community/metal-boot/internal/hoststate"
+	"github.com/metal3-community/metal-boot/internal/util"
+)
+
+// computerSystemOem extends the generated ComputerSystem model with the
+// MetalBoot Oem section, the BootOptions of Boot and the SecureBoot link,
+// which the OpenAPI document does not describe.
+type computerSystemOem struct {
+	ComputerSystem
+	Boot       *bootWithOptions `json:"Boot,omitempty"`
+	SecureBoot *IdRef           `json:"SecureBoot,omitempty"`
+	Oem        *systemOem       `json:"Oem,omitempty"`
+}
+
+type systemOem struct {
+	MetalBoot metalBootSystemOem `json:"MetalBoot"`
+}
+
+// metalBootSystemOem holds the metal-boot specific view of a system.
+type metalBootSystemOem struct {
+	OdataType       string          `json:"@odata.type"`
+	State           hoststate.State `json:"State,omitempty"`
+	StateMessage    string          `json:"StateMessage,omitempty"`
+	BootAttempts    int             `json:"BootAttempts"`
+	NetbootFallback bool            `json:"NetbootFallback"`
+	// RequestedBootSource and ObservedBootSource are read-only; together with
+	// BootSourceDrift they show whether the node booted what it was told to.
+	RequestedBootSource string                 `json:"RequestedBootSource,omitempty"`
+	ObservedBootSource  *observedBootSourceOem `json:"ObservedBootSource,omitempty"`
+	BootSourceDrift     bool                   `json:"BootSourceDrift"`
+	// Metadata is the free-form key/value data managed through the admin API.
+	Metadata map[string]string `json:"Metadata,omitempty"`
+	// DHCPClient is the client environment guessed from the latest DHCP
+	// packet of the node, such as "uefi-pxe", "ipxe" or an installed OS.
+	DHCPClient *dhcpClientOem `json:"DHCPClient,omitempty"`
+	// DHCP links to the DHCP data of the node.
+	DHCP    IdRef                `json:"DHCP"`
+	Actions map[string]oemAction `json:"Actions"`
+}
+
+// dhcpClientOem is the Redfish rendering of hoststate.DHCPClient.
+type dhcpClientOem struct {
+	Environment          string    `json:"Environment"`
+	VendorClass          string    `json:"VendorClass,omitempty"`
+	UserClass            string    `json:"UserClass,omitempty"`
+	ParameterRequestList string    `json:"ParameterRequestList,omitempty"`
+	Since                time.Time `json:"Since"`
+}
+
+// observedBootSourceOem is the Redfish rendering of hoststate.BootSource.
+type observedBootSourceOem struct {
+	Protocol   string    `json:"Protocol"`
+	File       string    `json:"File"`
+	Profile    string    `json:"Profile,omitempty"`
+	ObservedAt time.Time `json:"ObservedAt"`
+	// SHA256 and GitRev identify the iPXE build the system received.
+	SHA256 string `json:"SHA256,omitempty"`
+	GitRev string `json:"GitRev,omitempty"`
+}
+
+type oemAction struct {
+	Target string `json:"target"`
+}
+
+// systemOem builds the Oem section for a system from the host state store.
+func (s *RedfishServer) systemOem(mac net.HardwareAddr) *systemOem {
+	oem := &systemOem{
+		MetalBoot: metalBootSystemOem{
+			OdataType: "#MetalBoot.v1_0_0.ComputerSystem",
+			DHCP:      IdRef{OdataId: util.Ptr(dhcpPath(mac.String()))},
+			Actions: map[string]oemAction{
+				"#" + secureEraseAction: {
+					Target: fmt.Sprintf(
+						"/redfish/v1/Systems/%s/Actions/Oem/%s",
+						mac,
+						secureEraseAction,
+					),
+				},
+			},
+		},
+	}
+
+	if s.hosts == nil {
+		return oem
+	}
+
+	if host, err := s.hosts.Get(mac); err == nil {
+		oem.MetalBoot.State = host.State
+		oem.MetalBoot.StateMessage = host.Message
+		oem.MetalBoot.BootAttempts = host.BootAttempts
+		oem.MetalBoot.NetbootFallback = host.NetbootFallback
+		oem.MetalBoot.RequestedBootSource = host.RequestedBootSource
+		oem.MetalBoot.BootSourceDrift = host.BootSourceDrift()
+		oem.MetalBoot.Metadata = host.Metadata
+		if src := host.ObservedBootSource; src != nil {
+			oem.MetalBoot.ObservedBootSource = &observedBootSourceOem{
+				Protocol:   src.Protocol,
+				File:       src.File,
+				Profile:    src.Profile,
+				ObservedAt: src.ObservedAt,
+				SHA256:     src.SHA256,
+				GitRev:     src.GitRev,
+			}
+		}
+		if c := host.DHCPClient; c != nil {
+			oem.MetalBoot.DHCPClient = &dhcpClientOem{
+				Environment:          c.Environment,
+				VendorClass:          c.VendorClass,
+				UserClass:            c.UserClass,
+				ParameterRequestList: c.ParamRequestList,
+				Since:                c.Since,
+			}
+		}
+	}
+
+	return oem
+}
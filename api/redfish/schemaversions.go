@@ -0,0 +1,196 @@
+package redfish
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/metal3-community/metal-boot/internal/config"
+)
+
+// defaultSchemaVersions are the versions of the resource schemas advertised
+// in @odata.type, keyed by namespace, unless redfish_schemas selects others.
+var defaultSchemaVersions = map[string]string{
+	"AttributeRegistry":      "v1_3_6",
+	"Bios":                   "v1_2_0",
+	"BootOption":             "v1_0_4",
+	"Certificate":            "v1_5_0",
+	"CertificateLocations":   "v1_0_2",
+	"CertificateService":     "v1_0_4",
+	"Chassis":                "v1_14_0",
+	"ComputerSystem":         "v1_11_0",
+	"EthernetInterface":      "v1_6_2",
+	"EventService":           "v1_10_0",
+	"JsonSchemaFile":         "v1_1_4",
+	"Manager":                "v1_11_0",
+	"MessageRegistryFile":    "v1_1_3",
+	"MetricReport":           "v1_4_2",
+	"MetricReportDefinition": "v1_4_2",
+	"Power":                  "v1_7_1",
+	"SecureBoot":             "v1_1_0",
+	"SecureBootDatabase":     "v1_0_1",
+	"ServiceRoot":            "v1_11_0",
+	"Session":                "v1_3_0",
+	"SessionService":         "v1_1_8",
+	"Settings":               "v1_3_5",
+	"SoftwareInventory":      "v1_5_0",
+	"Task":                   "v1_6_0",
+	"TaskService":            "v1_2_0",
+	"TelemetryService":       "v1_3_1",
+	"Thermal":                "v1_7_1",
+	"UpdateService":          "v1_9_0",
+	"VirtualMedia":           "v1_3_0",
+}
+
+// fixedSchemaTypes are the schemas whose version cannot be selected: the
+// collections, which are not versioned, and the MetalBoot Oem schema bundled
+// with the service.
+var fixedSchemaTypes = []string{
+	"BootOptionCollection",
+	"CertificateCollection",
+	"ChassisCollection",
+	"ComputerSystemCollection",
+	"EthernetInterfaceCollection",
+	"JsonSchemaFileCollection",
+	"ManagerCollection",
+	"MessageRegistryFileCollection",
+	"MetalBoot.v1_0_0",
+	"MetricReportCollection",
+	"MetricReportDefinitionCollection",
+	"SecureBootDatabaseCollection",
+	"SessionCollection",
+	"TaskCollection",
+	"VirtualMediaCollection",
+}
+
+var schemaVersionPattern = regexp.MustCompile(`^v[0-9]+_[0-9]+_[0-9]+$`)
+
+// schemaPolicy selects the schema versions advertised to a client, so that
+// clients pinned to older versions, such as older Ironic releases, keep
+// working while the others see newer ones.
+type schemaPolicy struct {
+	versions map[string]string
+	clients  []schemaClient
+}
+
+// schemaClient pins the clients whose User-Agent contains userAgent to
+// versions.
+type schemaClient struct {
+	userAgent string
+	versions  map[string]string
+}
+
+// newSchemaPolicy applies the versions of cfg over the built-in ones. The
+// versions of a client apply over those advertised to every client.
+func newSchemaPolicy(cfg config.RedfishSchemasConfig) (*schemaPolicy, error) {
+	versions, err := overrideSchemaVersions(defaultSchemaVersions, cfg.Versions)
+	if err != nil {
+		return nil, fmt.Errorf("redfish_schemas.versions: %w", err)
+	}
+
+	p := &schemaPolicy{versions: versions}
+	for i, c := range cfg.Clients {
+		if c.UserAgent == "" {
+			return nil, fmt.Errorf("redfish_schemas.clients[%d]: user_agent is required", i)
+		}
+		cv, err := overrideSchemaVersions(versions, c.Versions)
+		if err != nil {
+			return nil, fmt.Errorf("redfish_schemas.clients[%d]: %w", i, err)
+		}
+		p.clients = append(p.clients, schemaClient{userAgent: c.UserAgent, versions: cv})
+	}
+
+	return p, nil
+}
+
+// overrideSchemaVersions returns a copy of base with the versions of
+// overrides. Resource names are matched case-insensitively.
+func overrideSchemaVersions(
+	base map[string]string,
+	overrides []config.RedfishSchemaVersion,
+) (map[string]string, error) {
+	versions := maps.Clone(base)
+	for _, o := range overrides {
+		namespace, ok := schemaNamespace(o.Resource)
+		if !ok {
+			return nil, fmt.Errorf("unknown resource %q", o.Resource)
+		}
+		if !schemaVersionPattern.MatchString(o.Version) {
+			return nil, fmt.Errorf("%s: version %q is not of the form v1_11_0", namespace, o.Version)
+		}
+		versions[namespace] = o.Version
+	}
+
+	return versions, nil
+}
+
+func schemaNamespace(resource string) (string, bool) {
+	for namespace := range defaultSchemaVersions {
+		if strings.EqualFold(namespace, resource) {
+			return namespace, true
+		}
+	}
+
+	return "", false
+}
+
+// negotiate returns the versions advertised to the client of r: those of the
+// first client whose User-Agent matches, or those of every client.
+func (p *schemaPolicy) negotiate(r *http.Request) map[string]string {
+	if p == nil {
+		return defaultSchemaVersions
+	}
+	ua := r.UserAgent()
+	for _, c := range p.clients {
+		if strings.Contains(ua, c.userAgent) {
+			return c.versions
+		}
+	}
+
+	return p.versions
+}
+
+type schemaVersionsKey struct{}
+
+func withSchemaVersions(ctx context.Context, versions map[string]string) context.Context {
+	return context.WithValue(ctx, schemaVersionsKey{}, versions)
+}
+
+// schemaVersions returns the versions negotiated for the request of ctx, or
+// the built-in ones.
+func schemaVersions(ctx context.Context) map[string]string {
+	if v, ok := ctx.Value(schemaVersionsKey{}).(map[string]string); ok {
+		return v
+	}
+
+	return defaultSchemaVersions
+}
+
+// schemaID returns the versioned namespace of a resource advertised for the
+// request of ctx, such as ComputerSystem.v1_11_0.
+func schemaID(ctx context.Context, namespace string) string {
+	return namespace + "." + schemaVersions(ctx)[namespace]
+}
+
+// odataType returns the @odata.type of a resource advertised for the request
+// of ctx, such as #ComputerSystem.v1_11_0.ComputerSystem.
+func odataType(ctx context.Context, namespace string) string {
+	return "#" + schemaID(ctx, namespace) + "." + namespace
+}
+
+// advertisedSchemas returns the schemas advertised for the request of ctx,
+// sorted.
+func advertisedSchemas(ctx context.Context) []string {
+	versions := schemaVersions(ctx)
+	ids := slices.Clone(fixedSchemaTypes)
+	for namespace, version := range versions {
+		ids = append(ids, namespace+"."+version)
+	}
+	slices.Sort(ids)
+
+	return ids
+}
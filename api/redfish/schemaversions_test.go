@@ -0,0 +1,107 @@
+package redfish
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/metal3-community/metal-boot/internal/config"
+)
+
+func TestSchemaPolicy(t *testing.T) {
+	p, err := newSchemaPolicy(config.RedfishSchemasConfig{
+		Versions: []config.RedfishSchemaVersion{{Resource: "ComputerSystem", Version: "v1_20_0"}},
+		Clients: []config.RedfishSchemaClient{{
+			UserAgent: "python-sushy/3.",
+			Versions:  []config.RedfishSchemaVersion{{Resource: "computersystem", Version: "v1_11_0"}},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("newSchemaPolicy() error = %v", err)
+	}
+
+	tests := []struct {
+		userAgent string
+		want      string
+	}{
+		{userAgent: "python-sushy/3.12.0", want: "#ComputerSystem.v1_11_0.ComputerSystem"},
+		{userAgent: "gofish/0.20", want: "#ComputerSystem.v1_20_0.ComputerSystem"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/redfish/v1/Systems", nil)
+		r.Header.Set("User-Agent", tt.userAgent)
+		ctx := withSchemaVersions(r.Context(), p.negotiate(r))
+		if got := odataType(ctx, "ComputerSystem"); got != tt.want {
+			t.Errorf("%s: odataType() = %q, want %q", tt.userAgent, got, tt.want)
+		}
+		if got := odataType(ctx, "Chassis"); got != "#Chassis.v1_14_0.Chassis" {
+			t.Errorf("%s: odataType(Chassis) = %q, want the built-in version", tt.userAgent, got)
+		}
+	}
+
+	if got := odataType(t.Context(), "ComputerSystem"); got != "#ComputerSystem.v1_11_0.ComputerSystem" {
+		t.Errorf("odataType() without negotiation = %q, want the built-in version", got)
+	}
+}
+
+func TestSchemaPolicyInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.RedfishSchemasConfig
+	}{
+		{
+			name: "unknown resource",
+			cfg: config.RedfishSchemasConfig{
+				Versions: []config.RedfishSchemaVersion{{Resource: "Drive", Version: "v1_0_0"}},
+			},
+		},
+		{
+			name: "malformed version",
+			cfg: config.RedfishSchemasConfig{
+				Versions: []config.RedfishSchemaVersion{{Resource: "Manager", Version: "1.11.0"}},
+			},
+		},
+		{
+			name: "client without user agent",
+			cfg:  config.RedfishSchemasConfig{Clients: []config.RedfishSchemaClient{{}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newSchemaPolicy(tt.cfg); err == nil {
+				t.Error("newSchemaPolicy() error = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestJsonSchemasFollowNegotiatedVersions(t *testing.T) {
+	s := &RedfishServer{}
+	versions, err := overrideSchemaVersions(defaultSchemaVersions,
+		[]config.RedfishSchemaVersion{{Resource: "ComputerSystem", Version: "v1_20_0"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, jsonSchemasPath, nil)
+	r = r.WithContext(withSchemaVersions(r.Context(), versions))
+	rec := httptest.NewRecorder()
+	s.ListJsonSchemas(rec, r)
+
+	var got struct {
+		Members []IdRef `json:"Members"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	var ids []string
+	for _, m := range got.Members {
+		ids = append(ids, *m.OdataId)
+	}
+	if !slices.Contains(ids, jsonSchemasPath+"/ComputerSystem.v1_20_0") ||
+		slices.Contains(ids, jsonSchemasPath+"/ComputerSystem.v1_11_0") {
+		t.Errorf("Members = %v, want ComputerSystem.v1_20_0 only", ids)
+	}
+}
@@ -0,0 +1,534 @@
+package redfish
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/metal3-community/metal-boot/internal/biosattr"
+	"github.com/metal3-community/metal-boot/internal/efivars"
+	"github.com/metal3-community/metal-boot/internal/util"
+	"github.com/metal3-community/uefi-firmware-manager/efi"
+	"github.com/metal3-community/uefi-firmware-manager/manager"
+	"go.opentelemetry.io/otel"
+)
+
+// secureBootOwner is the owner GUID of the signatures metal-boot enrolls
+// when the request names none.
+const secureBootOwner = "4d42a1e5-6d65-7461-6c2d-626f6f740001"
+
+// secureBootDatabases are the key databases of the SecureBootDatabases
+// collection, in the order of the Secure Boot chain of trust.
+var secureBootDatabases = []string{
+	efivars.DatabasePK,
+	efivars.DatabaseKEK,
+	efivars.DatabaseDB,
+	efivars.DatabaseDBX,
+}
+
+var (
+	errUnknownDatabase    = errors.New("unknown Secure Boot database")
+	errUnknownCertificate = errors.New("unknown certificate")
+	errNoPlatformKey      = errors.New("enroll a platform key (PK) before enabling Secure Boot")
+)
+
+type secureBoot struct {
+	OdataId               string `json:"@odata.id"`
+	OdataType             string `json:"@odata.type"`
+	Id                    string `json:"Id"`
+	Name                  string `json:"Name"`
+	SecureBootEnable      bool   `json:"SecureBootEnable"`
+	SecureBootCurrentBoot string `json:"SecureBootCurrentBoot"`
+	SecureBootMode        string `json:"SecureBootMode"`
+	SecureBootDatabases   IdRef  `json:"SecureBootDatabases"`
+}
+
+type secureBootDatabase struct {
+	OdataId      string `json:"@odata.id"`
+	OdataType    string `json:"@odata.type"`
+	Id           string `json:"Id"`
+	Name         string `json:"Name"`
+	DatabaseId   string `json:"DatabaseId"`
+	Certificates IdRef  `json:"Certificates"`
+}
+
+// SecureBootUpdateRequest is the body of a PATCH of the SecureBoot resource.
+type SecureBootUpdateRequest struct {
+	SecureBootEnable *bool `json:"SecureBootEnable"`
+}
+
+// EnrollCertificateRequest is the body of a POST to the certificates of a
+// Secure Boot database.
+type EnrollCertificateRequest struct {
+	CertificateString  string `json:"CertificateString"`
+	CertificateType    string `json:"CertificateType"`
+	UefiSignatureOwner string `json:"UefiSignatureOwner,omitempty"`
+}
+
+func secureBootPath(systemId string) string {
+	return fmt.Sprintf("/redfish/v1/Systems/%s/SecureBoot", systemId)
+}
+
+func secureBootDatabasePath(systemId, databaseId string) string {
+	return secureBootPath(systemId) + "/SecureBootDatabases/" + databaseId
+}
+
+func secureBootCertificatesPath(systemId, databaseId string) string {
+	return secureBootDatabasePath(systemId, databaseId) + "/Certificates"
+}
+
+// boolVariable reports whether the one byte boolean variable name is set.
+func boolVariable(vars biosattr.Variables, name string) bool {
+	v, err := vars.GetVariable(name)
+	return err == nil && len(v.Data) > 0 && v.Data[0] == 1
+}
+
+// databaseSignatures returns the signatures in the key database name of vars.
+// A database that was never enrolled is empty.
+func databaseSignatures(vars biosattr.Variables, name string) ([]efivars.Signature, error) {
+	v, err := vars.GetVariable(name)
+	if err != nil {
+		return nil, nil
+	}
+	return efivars.ParseSignatureLists(v.Data)
+}
+
+// setDatabaseSignatures replaces the content of the key database name with
+// sigs, and removes the database when sigs is empty.
+func setDatabaseSignatures(
+	mgr manager.FirmwareManager,
+	name string,
+	sigs []efivars.Signature,
+) error {
+	if len(sigs) == 0 {
+		if _, err := mgr.GetVariable(name); err != nil {
+			return nil
+		}
+		return mgr.DeleteVariable(name)
+	}
+	guid, _ := efivars.DatabaseGuid(name)
+	now := time.Now().UTC()
+
+	return mgr.SetVariable(name, &efi.EfiVar{
+		Name: efi.NewUCS16String(name),
+		Guid: efi.StringToGUID(guid),
+		Attr: efivars.AttrAuthenticated,
+		Data: efivars.EncodeSignatureLists(sigs),
+		Time: &now,
+	})
+}
+
+// secureBootMode returns the Redfish SecureBootMode of vars: setup mode until
+// a platform key is enrolled.
+func secureBootMode(vars biosattr.Variables) string {
+	if _, err := vars.GetVariable(efivars.DatabasePK); err != nil {
+		return "SetupMode"
+	}
+	switch {
+	case boolVariable(vars, "DeployedMode"):
+		return "DeployedMode"
+	case boolVariable(vars, "AuditMode"):
+		return "AuditMode"
+	}
+	return "UserMode"
+}
+
+// writeSecureBootError writes err with the status it maps to.
+func (s *RedfishServer) writeSecureBootError(w http.ResponseWriter, systemId string, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, errUnknownDatabase), errors.Is(err, errUnknownCertificate):
+		status = http.StatusNotFound
+	case errors.Is(err, errNoPlatformKey), errors.Is(err, efivars.ErrInvalidSignatureList):
+		status = http.StatusBadRequest
+	default:
+		s.Log.Error(err, "failed to change Secure Boot settings", "system_id", systemId)
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(redfishError(err))
+}
+
+// updateFirmware runs fn on a transaction on the firmware of systemId and
+// applies it when fn succeeds. It writes the error response and returns false
+// otherwise.
+func (s *RedfishServer) updateFirmware(
+	w http.ResponseWriter,
+	systemId string,
+	fn func(manager.FirmwareManager) error,
+) bool {
+	mac, err := s.systemMAC(systemId)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return false
+	}
+	tx, err := s.beginFirmwareTx(mac)
+	if err != nil {
+		s.writeSecureBootError(w, systemId, err)
+		return false
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		s.writeSecureBootError(w, systemId, err)
+		return false
+	}
+	if err := tx.Apply(); err != nil {
+		s.writeSecureBootError(w, systemId, err)
+		return false
+	}
+
+	return true
+}
+
+// GetSecureBoot returns the Secure Boot state of a system, read from its
+// varstore.
+func (s *RedfishServer) GetSecureBoot(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer(tracerName).Start(r.Context(), "redfish.RedfishServer.GetSecureBoot")
+	defer span.End()
+
+	systemId := r.PathValue("systemId")
+	vars, err := s.systemVariables(systemId)
+	if err != nil {
+		s.writeSecureBootError(w, systemId, err)
+		return
+	}
+
+	enabled := boolVariable(vars, "SecureBootEnable")
+	// The firmware sets SecureBoot at boot; a varstore that was never booted
+	// enforces Secure Boot once it is enabled and a platform key is enrolled.
+	current := enabled && secureBootMode(vars) != "SetupMode"
+	if _, err := vars.GetVariable("SecureBoot"); err == nil {
+		current = boolVariable(vars, "SecureBoot")
+	}
+	currentBoot := "Disabled"
+	if current {
+		currentBoot = "Enabled"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(secureBoot{
+		OdataId:               secureBootPath(systemId),
+		OdataType:             odataType(ctx, "SecureBoot"),
+		Id:                    "SecureBoot",
+		Name:                  "UEFI Secure Boot",
+		SecureBootEnable:      enabled,
+		SecureBootCurrentBoot: currentBoot,
+		SecureBootMode:        secureBootMode(vars),
+		SecureBootDatabases: IdRef{
+			OdataId: util.Ptr(secureBootPath(systemId) + "/SecureBootDatabases"),
+		},
+	})
+}
+
+// UpdateSecureBoot enables or disables Secure Boot on the next boot of a
+// system. Enabling it requires a platform key.
+func (s *RedfishServer) UpdateSecureBoot(w http.ResponseWriter, r *http.Request) {
+	_, span := otel.Tracer(tracerName).Start(r.Context(), "redfish.RedfishServer.UpdateSecureBoot")
+	defer span.End()
+
+	systemId := r.PathValue("systemId")
+	req, err := decodeBody[SecureBootUpdateRequest](r)
+	if err != nil || req.SecureBootEnable == nil {
+		if err == nil {
+			err = errors.New("SecureBootEnable is required")
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
+	ok := s.updateFirmware(w, systemId, func(mgr manager.FirmwareManager) error {
+		if _, err := mgr.GetVariable(efivars.DatabasePK); err != nil && *req.SecureBootEnable {
+			return errNoPlatformKey
+		}
+		v := &efi.EfiVar{
+			Name: efi.NewUCS16String("SecureBootEnable"),
+			Guid: efi.StringToGUID(efi.EfiSecureBootEnableDisable),
+			Attr: efi.EfiVariableNonVolatile | efi.EfiVariableBootserviceAccess,
+		}
+		v.SetBool(*req.SecureBootEnable)
+		return mgr.SetVariable("SecureBootEnable", v)
+	})
+	if !ok {
+		return
+	}
+	s.Log.Info("updated Secure Boot", "system_id", systemId, "enable", *req.SecureBootEnable)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListSecureBootDatabases lists the key databases of a system.
+func (s *RedfishServer) ListSecureBootDatabases(w http.ResponseWriter, r *http.Request) {
+	_, span := otel.Tracer(tracerName).
+		Start(r.Context(), "redfish.RedfishServer.ListSecureBootDatabases")
+	defer span.End()
+
+	systemId := r.PathValue("systemId")
+	members := make([]IdRef, 0, len(secureBootDatabases))
+	for _, db := range secureBootDatabases {
+		members = append(members, IdRef{OdataId: util.Ptr(secureBootDatabasePath(systemId, db))})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(certificateCollection{
+		OdataId:      secureBootPath(systemId) + "/SecureBootDatabases",
+		OdataType:    "#SecureBootDatabaseCollection.SecureBootDatabaseCollection",
+		Name:         "UEFI Secure Boot Database Collection",
+		Members:      members,
+		MembersCount: len(members),
+	})
+}
+
+// GetSecureBootDatabase returns one key database of a system.
+func (s *RedfishServer) GetSecureBootDatabase(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer(tracerName).
+		Start(r.Context(), "redfish.RedfishServer.GetSecureBootDatabase")
+	defer span.End()
+
+	systemId, db := r.PathValue("systemId"), r.PathValue("databaseId")
+	if !slices.Contains(secureBootDatabases, db) {
+		s.writeSecureBootError(w, systemId, fmt.Errorf("%w %q", errUnknownDatabase, db))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(secureBootDatabase{
+		OdataId:    secureBootDatabasePath(systemId, db),
+		OdataType:  odataType(ctx, "SecureBootDatabase"),
+		Id:         db,
+		Name:       db + " Database",
+		DatabaseId: db,
+		Certificates: IdRef{
+			OdataId: util.Ptr(secureBootCertificatesPath(systemId, db)),
+		},
+	})
+}
+
+// readDatabase returns the signatures of the key database named in the path
+// of r, writing the error response when it cannot.
+func (s *RedfishServer) readDatabase(
+	w http.ResponseWriter,
+	r *http.Request,
+) ([]efivars.Signature, bool) {
+	systemId, db := r.PathValue("systemId"), r.PathValue("databaseId")
+	if !slices.Contains(secureBootDatabases, db) {
+		s.writeSecureBootError(w, systemId, fmt.Errorf("%w %q", errUnknownDatabase, db))
+		return nil, false
+	}
+	vars, err := s.systemVariables(systemId)
+	if err == nil {
+		var sigs []efivars.Signature
+		if sigs, err = databaseSignatures(vars, db); err == nil {
+			return sigs, true
+		}
+	}
+	s.writeSecureBootError(w, systemId, err)
+
+	return nil, false
+}
+
+// ListSecureBootCertificates lists the X.509 certificates of a key database.
+// Certificates are numbered by their position among all the signatures of
+// the database, hashes included.
+func (s *RedfishServer) ListSecureBootCertificates(w http.ResponseWriter, r *http.Request) {
+	_, span := otel.Tracer(tracerName).
+		Start(r.Context(), "redfish.RedfishServer.ListSecureBootCertificates")
+	defer span.End()
+
+	sigs, ok := s.readDatabase(w, r)
+	if !ok {
+		return
+	}
+	path := secureBootCertificatesPath(r.PathValue("systemId"), r.PathValue("databaseId"))
+	members := []IdRef{}
+	for i, sig := range sigs {
+		if sig.Type == efi.EfiCertX509 {
+			members = append(members, IdRef{OdataId: util.Ptr(path + "/" + strconv.Itoa(i+1))})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(certificateCollection{
+		OdataId:      path,
+		OdataType:    "#CertificateCollection.CertificateCollection",
+		Name:         "Certificate Collection",
+		Members:      members,
+		MembersCount: len(members),
+	})
+}
+
+// GetSecureBootCertificate returns one certificate of a key database.
+func (s *RedfishServer) GetSecureBootCertificate(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer(tracerName).
+		Start(r.Context(), "redfish.RedfishServer.GetSecureBootCertificate")
+	defer span.End()
+
+	sigs, ok := s.readDatabase(w, r)
+	if !ok {
+		return
+	}
+	systemId, db := r.PathValue("systemId"), r.PathValue("databaseId")
+	id := r.PathValue("certificateId")
+	i, err := strconv.Atoi(id)
+	if err != nil || i < 1 || i > len(sigs) || sigs[i-1].Type != efi.EfiCertX509 {
+		s.writeSecureBootError(w, systemId, fmt.Errorf("%w %q", errUnknownCertificate, id))
+		return
+	}
+	cert, err := x509.ParseCertificate(sigs[i-1].Data)
+	if err != nil {
+		s.writeSecureBootError(w, systemId, err)
+		return
+	}
+
+	path := secureBootCertificatesPath(systemId, db) + "/" + id
+	resource := certificateResource(ctx, path, id, db+" Certificate "+id, cert)
+	resource.UefiSignatureOwner = sigs[i-1].Owner
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resource)
+}
+
+// EnrollSecureBootCertificate adds the PEM certificates of the request to a
+// key database. The platform key database holds one certificate, which an
+// enrollment replaces; certificates already enrolled are skipped.
+func (s *RedfishServer) EnrollSecureBootCertificate(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer(tracerName).
+		Start(r.Context(), "redfish.RedfishServer.EnrollSecureBootCertificate")
+	defer span.End()
+
+	systemId, db := r.PathValue("systemId"), r.PathValue("databaseId")
+	if !slices.Contains(secureBootDatabases, db) {
+		s.writeSecureBootError(w, systemId, fmt.Errorf("%w %q", errUnknownDatabase, db))
+		return
+	}
+	req, err := decodeBody[EnrollCertificateRequest](r)
+	if err == nil && req.CertificateType != "" && req.CertificateType != "PEM" &&
+		req.CertificateType != "PEMchain" {
+		err = errors.New("unsupported CertificateType: " + req.CertificateType)
+	}
+	var certs []*x509.Certificate
+	if err == nil {
+		certs, err = parseCertificates(req.CertificateString)
+	}
+	owner := secureBootOwner
+	if err == nil && req.UefiSignatureOwner != "" {
+		var guid efi.GUID
+		guid, err = efi.ParseGUID(req.UefiSignatureOwner)
+		owner = guid.String()
+	}
+	if err == nil && db == efivars.DatabasePK && len(certs) != 1 {
+		err = errors.New("the platform key is a single certificate")
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
+	var index int
+	ok := s.updateFirmware(w, systemId, func(mgr manager.FirmwareManager) error {
+		sigs, err := databaseSignatures(mgr, db)
+		if err != nil {
+			return err
+		}
+		if db == efivars.DatabasePK {
+			sigs = nil
+		}
+		for _, cert := range certs {
+			index = slices.IndexFunc(sigs, func(sig efivars.Signature) bool {
+				return sig.Type == efi.EfiCertX509 && string(sig.Data) == string(cert.Raw)
+			})
+			if index < 0 {
+				index = len(sigs)
+				sigs = append(sigs, efivars.Signature{
+					Type:  efi.EfiCertX509,
+					Owner: owner,
+					Data:  cert.Raw,
+				})
+			}
+		}
+		return setDatabaseSignatures(mgr, db, sigs)
+	})
+	if !ok {
+		return
+	}
+	s.Log.Info("enrolled Secure Boot certificates", "system_id", systemId, "database", db,
+		"certificates", len(certs))
+
+	id := strconv.Itoa(index + 1)
+	path := secureBootCertificatesPath(systemId, db) + "/" + id
+	resource := certificateResource(ctx, path, id, db+" Certificate "+id, certs[len(certs)-1])
+	resource.UefiSignatureOwner = owner
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", path)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resource)
+}
+
+// DeleteSecureBootCertificate removes a certificate from a key database.
+// Removing the platform key puts the system back in setup mode.
+func (s *RedfishServer) DeleteSecureBootCertificate(w http.ResponseWriter, r *http.Request) {
+	_, span := otel.Tracer(tracerName).
+		Start(r.Context(), "redfish.RedfishServer.DeleteSecureBootCertificate")
+	defer span.End()
+
+	systemId, db := r.PathValue("systemId"), r.PathValue("databaseId")
+	if !slices.Contains(secureBootDatabases, db) {
+		s.writeSecureBootError(w, systemId, fmt.Errorf("%w %q", errUnknownDatabase, db))
+		return
+	}
+	id := r.PathValue("certificateId")
+
+	ok := s.updateFirmware(w, systemId, func(mgr manager.FirmwareManager) error {
+		sigs, err := databaseSignatures(mgr, db)
+		if err != nil {
+			return err
+		}
+		i, err := strconv.Atoi(id)
+		if err != nil || i < 1 || i > len(sigs) || sigs[i-1].Type != efi.EfiCertX509 {
+			return fmt.Errorf("%w %q", errUnknownCertificate, id)
+		}
+		return setDatabaseSignatures(mgr, db, slices.Delete(sigs, i-1, i))
+	})
+	if !ok {
+		return
+	}
+	s.Log.Info("removed Secure Boot certificate", "system_id", systemId, "database", db,
+		"certificate", id)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseCertificates decodes the PEM certificates of s.
+func parseCertificates(s string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	rest := []byte(s)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no PEM certificate in CertificateString")
+	}
+
+	return certs, nil
+}
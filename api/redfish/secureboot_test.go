@@ -0,0 +1,137 @@
+package redfish
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/metal3-community/metal-boot/internal/config"
+)
+
+func testCertificatePEM(t *testing.T, cn string) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestSecureBoot(t *testing.T) {
+	s := &RedfishServer{
+		Config: &config.Config{Tftp: config.TftpConfig{RootDirectory: t.TempDir()}},
+		Log:    logr.Discard(),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+secureBootPath("{systemId}"), s.GetSecureBoot)
+	mux.HandleFunc("PATCH "+secureBootPath("{systemId}"), s.UpdateSecureBoot)
+	certs := secureBootCertificatesPath("{systemId}", "{databaseId}")
+	mux.HandleFunc("GET "+certs, s.ListSecureBootCertificates)
+	mux.HandleFunc("POST "+certs, s.EnrollSecureBootCertificate)
+	mux.HandleFunc("GET "+certs+"/{certificateId}", s.GetSecureBootCertificate)
+	mux.HandleFunc("DELETE "+certs+"/{certificateId}", s.DeleteSecureBootCertificate)
+	system := "d8:3a:dd:01:02:03"
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+	getSecureBoot := func() secureBoot {
+		t.Helper()
+		var sb secureBoot
+		rec := do(http.MethodGet, secureBootPath(system), "")
+		err := json.NewDecoder(rec.Body).Decode(&sb)
+		if err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("GET %s = %d, %v", secureBootPath(system), rec.Code, err)
+		}
+		return sb
+	}
+	enroll := func(db, cn string) *httptest.ResponseRecorder {
+		t.Helper()
+		body, _ := json.Marshal(EnrollCertificateRequest{
+			CertificateString: testCertificatePEM(t, cn),
+			CertificateType:   "PEM",
+		})
+		return do(http.MethodPost, secureBootCertificatesPath(system, db), string(body))
+	}
+
+	path, enable := secureBootPath(system), `{"SecureBootEnable": true}`
+	pk := secureBootCertificatesPath(system, "PK") + "/1"
+
+	if sb := getSecureBoot(); sb.SecureBootMode != "SetupMode" || sb.SecureBootEnable {
+		t.Errorf("GET SecureBoot = %+v, want setup mode", sb)
+	}
+	if rec := do(http.MethodPatch, path, enable); rec.Code != http.StatusBadRequest {
+		t.Errorf("enabling Secure Boot without a platform key = %d", rec.Code)
+	}
+
+	for _, db := range []string{"PK", "KEK", "db", "db"} {
+		if rec := enroll(db, db+" key"); rec.Code != http.StatusCreated {
+			t.Fatalf("POST %s certificate = %d: %s", db, rec.Code, rec.Body)
+		}
+	}
+	if rec := enroll("PK", "new platform key"); rec.Code != http.StatusCreated ||
+		rec.Header().Get("Location") != pk {
+		t.Errorf("POST PK certificate = %d, %s", rec.Code, rec.Header().Get("Location"))
+	}
+	if rec := enroll("MOK", "key"); rec.Code != http.StatusNotFound {
+		t.Errorf("POST MOK certificate = %d, want 404", rec.Code)
+	}
+
+	var list certificateCollection
+	rec := do(http.MethodGet, secureBootCertificatesPath(system, "db"), "")
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil || list.MembersCount != 2 {
+		t.Fatalf("GET db certificates = %d, %+v, %v", rec.Code, list, err)
+	}
+	var cert certificate
+	rec = do(http.MethodGet, pk, "")
+	if err := json.NewDecoder(rec.Body).Decode(&cert); err != nil ||
+		cert.Subject.CommonName != "new platform key" ||
+		cert.UefiSignatureOwner != secureBootOwner {
+		t.Errorf("GET PK certificate = %d, %+v, %v", rec.Code, cert, err)
+	}
+
+	if rec := do(http.MethodPatch, path, enable); rec.Code != http.StatusNoContent {
+		t.Fatalf("enabling Secure Boot = %d: %s", rec.Code, rec.Body)
+	}
+	sb := getSecureBoot()
+	if !sb.SecureBootEnable || sb.SecureBootMode != "UserMode" ||
+		sb.SecureBootCurrentBoot != "Enabled" {
+		t.Errorf("GET SecureBoot = %+v, want enabled in user mode", sb)
+	}
+
+	// Removing the platform key goes back to setup mode.
+	if rec := do(http.MethodDelete, pk, ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE PK certificate = %d: %s", rec.Code, rec.Body)
+	}
+	if rec := do(http.MethodDelete, pk, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("DELETE of a removed certificate = %d, want 404", rec.Code)
+	}
+	sb = getSecureBoot()
+	if sb.SecureBootMode != "SetupMode" || sb.SecureBootCurrentBoot != "Disabled" {
+		t.Errorf("GET SecureBoot = %+v, want setup mode", sb)
+	}
+}
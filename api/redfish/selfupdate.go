@@ -0,0 +1,171 @@
+package redfish
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/metal3-community/metal-boot/internal/download"
+	"github.com/metal3-community/metal-boot/internal/selfupdate"
+	"github.com/metal3-community/metal-boot/internal/task"
+	"github.com/metal3-community/metal-boot/internal/util"
+)
+
+const (
+	// managerFirmwareId is the FirmwareInventory member for the metal-boot
+	// binary itself.
+	managerFirmwareId   = serviceManagerId
+	managerFirmwarePath = "/redfish/v1/UpdateService/FirmwareInventory/" + managerFirmwareId
+	managerPath         = "/redfish/v1/Managers/" + serviceManagerId
+)
+
+var errSelfUpdateDisabled = errors.New("self update is not enabled")
+
+// simpleUpdateOem carries the checksum of a manager image. Without it the
+// checksum is read from ImageURI + ".sha256".
+type simpleUpdateOem struct {
+	MetalBoot struct {
+		Sha256 string `json:"Sha256,omitempty"`
+	} `json:"MetalBoot"`
+}
+
+// targetsManager reports whether a SimpleUpdate is aimed at metal-boot itself.
+func targetsManager(targets []string) bool {
+	return slices.Contains(targets, managerPath) || slices.Contains(targets, managerFirmwarePath)
+}
+
+// managerSoftwareInventory describes the running metal-boot binary.
+func (s *RedfishServer) managerSoftwareInventory(ctx context.Context) SoftwareInventory {
+	status := s.updater.Status()
+
+	state := StateEnabled
+	if status.State == selfupdate.StateDownloading || status.State == selfupdate.StateApplying {
+		state = StateUpdating
+	}
+	health := HealthOK
+	if status.State == selfupdate.StateFailed {
+		health = HealthWarning
+	}
+
+	description := "metal-boot"
+	if status.State != selfupdate.StateIdle {
+		description = fmt.Sprintf("metal-boot (update %s: %s)", status.State, status.ImageURI)
+		if status.Message != "" {
+			description += ": " + status.Message
+		}
+	}
+
+	return SoftwareInventory{
+		OdataId:     util.Ptr(managerFirmwarePath),
+		OdataType:   util.Ptr(odataType(ctx, "SoftwareInventory")),
+		Id:          util.Ptr(managerFirmwareId),
+		Name:        util.Ptr("metal-boot"),
+		Description: util.Ptr(description),
+		Version:     util.Ptr(s.updater.Version),
+		SoftwareId:  util.Ptr("metal-boot"),
+		Status: &Status{
+			State:  util.Ptr(state),
+			Health: util.Ptr(health),
+		},
+		Updateable: util.Ptr(s.Config.SelfUpdate.Enabled),
+		RelatedItem: &[]IdRef{
+			{OdataId: util.Ptr(managerPath)},
+		},
+		RelatedItemOdataCount: util.Ptr(1),
+	}
+}
+
+// updateManager stages the image in request and, once verified, hands the
+// process over to it. Only admins may update the manager. The download runs in
+// the background; the response is a Task the client can poll through the
+// manager's FirmwareInventory member.
+func (s *RedfishServer) updateManager(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	request SimpleUpdateRequest,
+) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+	if s.updater == nil || !s.Config.SelfUpdate.Enabled {
+		s.Log.Error(errSelfUpdateDisabled, "manager update requested")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(redfishError(errSelfUpdateDisabled))
+		return
+	}
+
+	if state := s.updater.Status().State; state == selfupdate.StateDownloading ||
+		state == selfupdate.StateApplying {
+		s.Log.Error(selfupdate.ErrInProgress, "manager update requested")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(redfishError(selfupdate.ErrInProgress))
+		return
+	}
+
+	checksum := ""
+	if request.Oem != nil {
+		checksum = request.Oem.MetalBoot.Sha256
+	}
+
+	taskId := fmt.Sprintf("manager-update-%d", time.Now().Unix())
+	progress := s.downloads.Start(taskId, "Manager Update Task", *request.ImageURI)
+	tk := s.tasks.Start(taskId, "Manager Update Task", "Update from "+*request.ImageURI,
+		managerFirmwarePath)
+	response := Task{
+		OdataId:     util.Ptr(fmt.Sprintf("/redfish/v1/TaskService/Tasks/%s", taskId)),
+		OdataType:   util.Ptr(odataType(ctx, "Task")),
+		Id:          &taskId,
+		Name:        util.Ptr("Manager Update Task"),
+		Description: util.Ptr("Progress is reported on " + managerFirmwarePath),
+		TaskState:   util.Ptr(TaskStateRunning),
+		StartTime:   util.Ptr(time.Now()),
+		TaskMonitor: util.Ptr(taskMonitorPath + "/" + taskId),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", managerFirmwarePath)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(response)
+
+	ctx = download.NewContext(context.WithoutCancel(ctx), progress)
+	go s.processManagerUpdate(ctx, tk, *request.ImageURI, checksum)
+}
+
+// processManagerUpdate downloads and verifies imageURI and re-executes into it.
+// tk completes once the image is staged, as the re-executed process cannot
+// finish it; a failure to apply is reported on the manager's
+// FirmwareInventory member.
+func (s *RedfishServer) processManagerUpdate(
+	ctx context.Context,
+	tk *task.Task,
+	imageURI, checksum string,
+) {
+	s.Log.Info("staging manager update", "uri", imageURI, "version", s.updater.Version)
+
+	if err := s.updater.Stage(ctx, imageURI, checksum); err != nil {
+		tk.Done(err)
+		s.Log.Error(err, "failed to stage manager update", "uri", imageURI)
+		return
+	}
+	tk.Message(task.SeverityOK, "The image is verified, restarting into it.")
+	tk.Done(nil)
+
+	s.Log.Info("applying manager update", "uri", imageURI)
+	if err := s.updater.Apply(); err != nil {
+		s.Log.Error(err, "failed to apply manager update", "uri", imageURI)
+	}
+}
+
+// managerFirmwareVersion is the version reported on the Managers.
+func (s *RedfishServer) managerFirmwareVersion() string {
+	if s.updater == nil || s.updater.Version == "" {
+		return "1.0.0"
+	}
+
+	return s.updater.Version
+}
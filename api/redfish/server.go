@@ -14,13 +14,23 @@ import (
 	"time"
 
 	"github.com/go-logr/logr"
+	"github.com/metal3-community/metal-boot/internal/audit"
 	"github.com/metal3-community/metal-boot/internal/backend"
+	"github.com/metal3-community/metal-boot/internal/biosattr"
+	"github.com/metal3-community/metal-boot/internal/bootauth"
 	"github.com/metal3-community/metal-boot/internal/config"
 	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+	"github.com/metal3-community/metal-boot/internal/download"
+	"github.com/metal3-community/metal-boot/internal/firmwaretx"
+	"github.com/metal3-community/metal-boot/internal/hoststate"
+	"github.com/metal3-community/metal-boot/internal/selfupdate"
+	"github.com/metal3-community/metal-boot/internal/session"
+	"github.com/metal3-community/metal-boot/internal/task"
+	"github.com/metal3-community/metal-boot/internal/telemetry"
+	"github.com/metal3-community/metal-boot/internal/tlscert"
 	"github.com/metal3-community/metal-boot/internal/util"
 	"github.com/metal3-community/uefi-firmware-manager/edk2"
 	"github.com/metal3-community/uefi-firmware-manager/manager"
-	"github.com/metal3-community/uefi-firmware-manager/types"
 	"github.com/metal3-community/uefi-firmware-manager/varstore"
 	"go.opentelemetry.io/otel"
 )
@@ -103,8 +113,21 @@ type RedfishServer struct {
 
 	Log logr.Logger
 
-	reader backend.BackendReader
-	power  backend.BackendPower
+	reader  backend.BackendReader
+	power   backend.BackendPower
+	hosts   *hoststate.Store
+	certs   *tlscert.Store
+	updater *selfupdate.Updater
+	// telemetry is nil when the TelemetryService is disabled.
+	telemetry *telemetry.Service
+	bios      *biosattr.Registry
+	// downloads tracks the downloads of update tasks.
+	downloads *download.Tracker
+	sessions  *session.Store
+	// tasks tracks firmware updates and power operations.
+	tasks *task.Store
+	// cleaningTokens signs and checks the callback URLs of cleaning images.
+	cleaningTokens *bootauth.Verifier
 
 	firmwarePath string
 }
@@ -136,6 +159,26 @@ func (f *RedfishServer) GetEdk2FirmwareManager(
 	return firmwareMgr, nil
 }
 
+// beginFirmwareTx starts a transaction on the firmware of macAddress. Handlers
+// that change several variables use it so that a failure half way leaves the
+// varstore as it was.
+func (f *RedfishServer) beginFirmwareTx(macAddress net.HardwareAddr) (*firmwaretx.Tx, error) {
+	path := f.systemFirmwarePath(systemFirmware{component: componentUEFI, mac: macAddress})
+	tx, err := firmwaretx.Begin(path, f.Log)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create firmware manager: %w", err)
+	}
+
+	if _, err = tx.GetMacAddress(); err != nil {
+		if err := tx.SetMacAddress(macAddress); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	return tx, nil
+}
+
 func NewRedfishServer(cfg *config.Config, backend backend.BackendReader) *RedfishServer {
 	server := &RedfishServer{
 		Config:       cfg,
@@ -164,7 +207,8 @@ func (s *RedfishServer) CreateVirtualDisk(
 		s.Log.Error(err, "error decoding request")
 		return
 	} else {
-		s.Log.Info("creating virtual disk", "system", systemId, "storageController", storageControllerId, "request", req)
+		s.Log.Info("creating virtual disk", "system_id", systemId,
+			"storageController", storageControllerId, "request", req)
 	}
 
 	panic("unimplemented")
@@ -180,16 +224,6 @@ func (s *RedfishServer) DeleteVirtualdisk(
 	panic("unimplemented")
 }
 
-// EjectVirtualMedia implements ServerInterface.
-func (s *RedfishServer) EjectVirtualMedia(
-	w http.ResponseWriter,
-	r *http.Request,
-	managerId string,
-	virtualMediaId string,
-) {
-	panic("unimplemented")
-}
-
 // FirmwareInventory implements ServerInterface.
 func (s *RedfishServer) FirmwareInventory(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
@@ -199,46 +233,32 @@ func (s *RedfishServer) FirmwareInventory(w http.ResponseWriter, r *http.Request
 
 	s.Log.Info("getting firmware inventory")
 
-	// Check if firmware file exists
-	if s.firmwarePath == "" {
-		err := errors.New("firmware path not configured")
-		s.Log.Error(err, "firmware path not set")
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(redfishError(err))
-		return
+	members := []IdRef{}
+	if s.updater != nil {
+		members = append(members, IdRef{OdataId: util.Ptr(managerFirmwarePath)})
 	}
 
-	// Create firmware inventory response
-	firmwareName := filepath.Base(s.firmwarePath)
-	inventory := Collection{
-		OdataId:   "/redfish/v1/UpdateService/FirmwareInventory",
-		OdataType: "#FirmwareInventory.SoftwareInventoryCollection",
-		Name:      util.Ptr("Firmware Inventory Collection"),
-		Members: &[]IdRef{
-			{
-				OdataId: util.Ptr(
-					fmt.Sprintf("/redfish/v1/UpdateService/FirmwareInventory/%s", firmwareName),
-				),
-			},
-		},
-		MembersOdataCount: util.Ptr(1),
+	if s.firmwarePath != "" {
+		firmwareName := filepath.Base(s.firmwarePath)
+		members = append(members, IdRef{
+			OdataId: util.Ptr(
+				fmt.Sprintf("/redfish/v1/UpdateService/FirmwareInventory/%s", firmwareName),
+			),
+		})
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(inventory)
-}
-
-// FirmwareInventoryDownloadImage implements ServerInterface.
-func (s *RedfishServer) FirmwareInventoryDownloadImage(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	tracer := otel.Tracer(tracerName)
-	_, span := tracer.Start(ctx, "redfish.RedfishServer.FirmwareInventoryDownloadImage")
-	defer span.End()
-
-	s.Log.Info("downloading firmware image")
+	// Every system has its own UEFI firmware, and EEPROM where available.
+	if s.reader != nil {
+		keys, err := s.reader.GetKeys(ctx)
+		if err != nil {
+			s.Log.Error(err, "error getting keys")
+		}
+		for _, mac := range keys {
+			members = append(members, s.systemFirmwareMembers(mac)...)
+		}
+	}
 
-	// Check if firmware file exists
-	if s.firmwarePath == "" {
+	if len(members) == 0 {
 		err := errors.New("firmware path not configured")
 		s.Log.Error(err, "firmware path not set")
 		w.WriteHeader(http.StatusNotFound)
@@ -246,47 +266,17 @@ func (s *RedfishServer) FirmwareInventoryDownloadImage(w http.ResponseWriter, r
 		return
 	}
 
-	err := r.ParseMultipartForm(32 << 20) // 32MB limit
-	if err != nil {
-		s.Log.Error(err, "error parsing multipart form")
-		w.WriteHeader(http.StatusBadRequest)
+	// Create firmware inventory response
+	inventory := Collection{
+		OdataId:           "/redfish/v1/UpdateService/FirmwareInventory",
+		OdataType:         "#FirmwareInventory.SoftwareInventoryCollection",
+		Name:              util.Ptr("Firmware Inventory Collection"),
+		Members:           &members,
+		MembersOdataCount: util.Ptr(len(members)),
 	}
 
-	if fi, ok := r.MultipartForm.File["softwareImage"]; ok && len(fi) > 0 {
-		file, err := fi[0].Open()
-		if err != nil {
-			s.Log.Error(err, "error opening firmware file")
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(redfishError(err))
-			return
-		}
-		defer file.Close()
-
-		_, err = os.Stat(s.firmwarePath) // Check if firmware file exists
-		if err != nil && !os.IsNotExist(err) {
-			s.Log.Error(err, "error checking firmware file", "path", s.firmwarePath)
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(redfishError(err))
-			return
-		}
-
-		targetFile, err := os.Create(s.firmwarePath) // Create or overwrite the firmware file
-		if err != nil {
-			s.Log.Error(err, "error creating firmware file", "path", s.firmwarePath)
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(redfishError(err))
-			return
-		}
-
-		defer targetFile.Close()
-		if _, err := io.Copy(targetFile, file); err != nil {
-			s.Log.Error(err, "error copying firmware file", "path", s.firmwarePath)
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(redfishError(err))
-			return
-		}
-		// Create a temporary file to store the firmware image
-	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(inventory)
 }
 
 // GetManager implements ServerInterface.
@@ -301,14 +291,14 @@ func (s *RedfishServer) GetManager(w http.ResponseWriter, r *http.Request, manag
 	manager := Manager{
 		Id:        &managerId,
 		OdataId:   util.Ptr(fmt.Sprintf("/redfish/v1/Managers/%s", managerId)),
-		OdataType: util.Ptr("#Manager.v1_11_0.Manager"),
+		OdataType: util.Ptr(odataType(ctx, "Manager")),
 		Name:      util.Ptr("Manager"),
 		Status: &Status{
 			State: util.Ptr(StateEnabled),
 		},
 		ManagerType:     util.Ptr(ManagerTypeBMC),
 		Model:           util.Ptr("Raspberry Pi BMC"),
-		FirmwareVersion: util.Ptr("1.0.0"),
+		FirmwareVersion: util.Ptr(s.managerFirmwareVersion()),
 		// Add virtual media reference
 		VirtualMedia: &IdRef{
 			OdataId: util.Ptr(fmt.Sprintf("/redfish/v1/Managers/%s/VirtualMedia", managerId)),
@@ -327,21 +317,11 @@ func (s *RedfishServer) GetManager(w http.ResponseWriter, r *http.Request, manag
 	json.NewEncoder(w).Encode(manager)
 }
 
-// GetManagerVirtualMedia implements ServerInterface.
-func (s *RedfishServer) GetManagerVirtualMedia(
-	w http.ResponseWriter,
-	r *http.Request,
-	managerId string,
-	virtualMediaId string,
-) {
-	panic("unimplemented")
-}
-
 // GetRoot implements ServerInterface.
 func (s *RedfishServer) GetRoot(w http.ResponseWriter, r *http.Request) {
 	root := Root{
 		OdataId:        util.Ptr("/redfish/v1"),
-		OdataType:      util.Ptr("#ServiceRoot.v1_11_0.ServiceRoot"),
+		OdataType:      util.Ptr(odataType(r.Context(), "ServiceRoot")),
 		Id:             util.Ptr("RootService"),
 		Name:           util.Ptr("Root Service"),
 		RedfishVersion: util.Ptr("1.11.0"),
@@ -356,7 +336,28 @@ func (s *RedfishServer) GetRoot(w http.ResponseWriter, r *http.Request) {
 		},
 	}
 
-	err := json.NewEncoder(w).Encode(root)
+	resp := rootWithCertificateService{
+		Root:           root,
+		Chassis:        IdRef{OdataId: util.Ptr(chassisCollectionPath)},
+		JsonSchemas:    IdRef{OdataId: util.Ptr(jsonSchemasPath)},
+		Registries:     IdRef{OdataId: util.Ptr(registriesPath)},
+		SessionService: IdRef{OdataId: util.Ptr(sessionServicePath)},
+		Tasks:          IdRef{OdataId: util.Ptr(taskServicePath)},
+		Links:          rootLinks{Sessions: IdRef{OdataId: util.Ptr(sessionsPath)}},
+		ProtocolFeaturesSupported: protocolFeatures{
+			FilterQuery: true,
+			ExpandQuery: expandQuery{ExpandAll: true, NoLinks: true, Levels: true, MaxLevels: 1},
+		},
+	}
+	if s.certs != nil {
+		resp.CertificateService = &IdRef{OdataId: util.Ptr(certificateServicePath)}
+	}
+	if s.telemetry != nil {
+		resp.TelemetryService = &IdRef{OdataId: util.Ptr(telemetryServicePath)}
+		resp.EventService = &IdRef{OdataId: util.Ptr(eventServicePath)}
+	}
+
+	err := json.NewEncoder(w).Encode(resp)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		s.Log.Error(err, "error encoding response")
@@ -378,6 +379,25 @@ func (s *RedfishServer) GetSoftwareInventory(
 
 	s.Log.Info("getting software inventory", "id", softwareId)
 
+	if softwareId == managerFirmwareId && s.updater != nil {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.managerSoftwareInventory(ctx))
+		return
+	}
+
+	if f, ok := parseSystemFirmwareId(softwareId); ok {
+		inventory, err := s.systemSoftwareInventory(ctx, f)
+		if err != nil {
+			s.Log.Error(err, "failed to read system firmware", "id", softwareId)
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(redfishError(err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(inventory)
+		return
+	}
+
 	// Check if firmware file exists
 	if s.firmwarePath == "" {
 		err := errors.New("firmware path not configured")
@@ -433,7 +453,7 @@ func (s *RedfishServer) GetSoftwareInventory(
 		OdataId: util.Ptr(
 			fmt.Sprintf("/redfish/v1/UpdateService/FirmwareInventory/%s", softwareId),
 		),
-		OdataType:   util.Ptr("#SoftwareInventory.v1_5_0.SoftwareInventory"),
+		OdataType:   util.Ptr(odataType(ctx, "SoftwareInventory")),
 		Id:          &softwareId,
 		Name:        util.Ptr("UEFI Firmware"),
 		Description: util.Ptr(description),
@@ -456,47 +476,62 @@ func (s *RedfishServer) GetSystem(w http.ResponseWriter, r *http.Request, system
 	_, span := tracer.Start(ctx, "redfish.RedfishServer.GetSystem")
 	defer span.End()
 
-	s.Log.Info("getting system", "system", systemId)
+	s.Log.Info("getting system", "system_id", systemId)
 
-	systemIdAddr, err := net.ParseMAC(systemId)
+	systemIdAddr, err := s.systemMAC(systemId)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		s.Log.Error(err, "error parsing system id", "system", systemId)
+		s.Log.Error(err, "error parsing system id", "system_id", systemId)
 		return
 	}
+	// Systems looked up by UUID are still identified by their MAC.
+	systemId = systemIdAddr.String()
 
-	dhcp, _, err := s.reader.GetByMac(ctx, systemIdAddr)
+	system, err := s.computerSystem(ctx, systemIdAddr)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		s.Log.Error(err, "error getting system by mac", "system", systemId)
+		if errors.Is(err, errSystemNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		s.Log.Error(err, "error getting system", "system_id", systemId)
 		return
 	}
 
-	pwr, err := s.power.GetPower(ctx, systemIdAddr)
-	if err != nil {
+	if err := json.NewEncoder(w).Encode(system); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		s.Log.Error(err, "error getting system power state", "system", systemId)
+		s.Log.Error(err, "error marshalling response", "system_id", systemId)
 		return
 	}
+}
+
+var errSystemNotFound = errors.New("power state not found")
+
+// computerSystem builds the ComputerSystem resource of mac from its DHCP
+// record, power state and host state. It fails with errSystemNotFound when
+// the power backend does not know the system.
+func (s *RedfishServer) computerSystem(
+	ctx context.Context,
+	mac net.HardwareAddr,
+) (*computerSystemOem, error) {
+	systemId := mac.String()
+
+	dhcp, _, err := s.reader.GetByMac(ctx, mac)
+	if err != nil {
+		return nil, fmt.Errorf("error getting system by mac: %w", err)
+	}
+
+	pwr, err := s.power.GetPower(ctx, mac)
+	if err != nil {
+		return nil, fmt.Errorf("error getting system power state: %w", err)
+	}
 	if pwr == nil {
-		w.WriteHeader(http.StatusNotFound)
-		s.Log.Error(err, "power state not found", "system", systemId)
-		return
+		return nil, errSystemNotFound
 	}
 
 	defaultName := fmt.Sprintf("System %s", systemId)
 
-	var pwrState PowerState
-	switch *pwr {
-	case data.PowerOn:
-		pwrState = On
-	case data.PowerOff:
-		pwrState = Off
-	case data.PoweringOn:
-		pwrState = PoweringOn
-	case data.PoweringOff:
-		pwrState = PoweringOff
-	}
+	pwrState := redfishPowerState(*pwr)
 
 	if dhcp != nil {
 		if dhcp.Hostname != "" {
@@ -507,7 +542,7 @@ func (s *RedfishServer) GetSystem(w http.ResponseWriter, r *http.Request, system
 		Id:         &systemId,
 		PowerState: &pwrState,
 		Links: &SystemLinks{
-			Chassis:   &[]IdRef{{OdataId: util.Ptr("/redfish/v1/Chassis/1")}},
+			Chassis:   &[]IdRef{{OdataId: util.Ptr(chassisPath(systemId))}},
 			ManagedBy: &[]IdRef{{OdataId: util.Ptr("/redfish/v1/Managers/1")}},
 		},
 		Boot: &Boot{
@@ -534,106 +569,28 @@ func (s *RedfishServer) GetSystem(w http.ResponseWriter, r *http.Request, system
 			},
 		},
 		OdataId:   util.Ptr(fmt.Sprintf("/redfish/v1/Systems/%s", systemId)),
-		OdataType: util.Ptr("#ComputerSystem.v1_11_0.ComputerSystem"),
+		OdataType: util.Ptr(odataType(ctx, "ComputerSystem")),
 		Name:      util.Ptr(defaultName),
 		Status: &Status{
 			State: util.Ptr(StateEnabled),
 		},
-		UUID: util.Ptr(systemIdAddr.String()),
+		UUID: util.Ptr(s.systemUUID(mac)),
 		Bios: &IdRef{
-			OdataId: util.Ptr(fmt.Sprintf("/redfish/v1/Systems/%s/BIOS", systemId)),
+			OdataId: util.Ptr(biosPath(systemId)),
+		},
+		EthernetInterfaces: &IdRef{
+			OdataId: util.Ptr(ethernetInterfacesPath(systemId)),
 		},
 	}
 
-	if err := json.NewEncoder(w).Encode(resp); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		s.Log.Error(err, "error marshalling response", "system", systemId)
-		return
-	}
+	return &computerSystemOem{
+		ComputerSystem: resp,
+		Boot:           s.systemBoot(systemId, resp.Boot),
+		SecureBoot:     &IdRef{OdataId: util.Ptr(secureBootPath(systemId))},
+		Oem:            s.systemOem(mac),
+	}, nil
 }
 
-// Add a new handler for BIOS settings
-// func (s *RedfishServer) GetBIOS(w http.ResponseWriter, r *http.Request, systemId string) {
-// 	ctx := r.Context()
-// 	tracer := otel.Tracer(tracerName)
-// 	_, span := tracer.Start(ctx, "redfish.RedfishServer.GetBIOS")
-// 	defer span.End()
-
-// 	s.Log.Info("getting BIOS settings", "system", systemId)
-
-// 	// Check if firmware file exists
-// 	if s.firmwarePath == "" {
-// 		err := errors.New("firmware path not configured")
-// 		s.Log.Error(err, "firmware path not set")
-// 		w.WriteHeader(http.StatusNotFound)
-// 		json.NewEncoder(w).Encode(redfishError(err))
-// 		return
-// 	}
-
-// 	// Create firmware manager for the system
-// 	firmwareMgr, err := manager.NewEDK2Manager(s.firmwarePath, s.Log)
-// 	if err != nil {
-// 		s.Log.Error(err, "failed to create firmware manager")
-// 		w.WriteHeader(http.StatusInternalServerError)
-// 		json.NewEncoder(w).Encode(redfishError(err))
-// 		return
-// 	}
-
-// 	// Get system info
-// 	sysInfo, err := firmwareMgr.GetSystemInfo()
-// 	if err != nil {
-// 		s.Log.Error(err, "failed to get system info")
-// 		w.WriteHeader(http.StatusInternalServerError)
-// 		json.NewEncoder(w).Encode(redfishError(err))
-// 		return
-// 	}
-
-// 	// Get network settings
-// 	netSettings, err := firmwareMgr.GetNetworkSettings()
-// 	if err != nil {
-// 		s.Log.Error(err, "failed to get network settings")
-// 		w.WriteHeader(http.StatusInternalServerError)
-// 		json.NewEncoder(w).Encode(redfishError(err))
-// 		return
-// 	}
-
-// 	// Get boot entries
-// 	bootEntries, err := firmwareMgr.GetBootEntries()
-// 	if err != nil {
-// 		s.Log.Error(err, "failed to get boot entries")
-// 		w.WriteHeader(http.StatusInternalServerError)
-// 		json.NewEncoder(w).Encode(redfishError(err))
-// 		return
-// 	}
-
-// 	// Create BIOS response
-// 	biosSettings := Bios{
-// 		OdataId:   util.Ptr(fmt.Sprintf("/redfish/v1/Systems/%s/BIOS", systemId)),
-// 		OdataType: util.Ptr("#Bios.v1_2_0.Bios"),
-// 		Id:        util.Ptr("BIOS"),
-// 		Name:      util.Ptr("UEFI BIOS Settings"),
-// 		Attributes: map[string]interface{}{
-// 			"SystemInfo":      sysInfo,
-// 			"NetworkSettings": netSettings,
-// 			"BootEntries":     bootEntries,
-// 		},
-// 		Actions: &BiosActions{
-// 			HashBiosReset: &BiosReset{
-// 				Title:  util.Ptr("Reset BIOS Settings"),
-// 				Target: util.Ptr(fmt.Sprintf("/redfish/v1/Systems/%s/BIOS/Actions/Bios.Reset", systemId)),
-// 			},
-// 		},
-// 		Links: &BiosLinks{
-// 			ActiveSoftwareImage: &IdRef{
-// 				OdataId: util.Ptr(fmt.Sprintf("/redfish/v1/UpdateService/FirmwareInventory/%s", filepath.Base(s.firmwarePath))),
-// 			},
-// 		},
-// 	}
-
-// 	w.Header().Set("Content-Type", "application/json")
-// 	json.NewEncoder(w).Encode(biosSettings)
-// }
-
 // Handler for BIOS settings reset.
 func (s *RedfishServer) ResetBIOS(w http.ResponseWriter, r *http.Request, systemId string) {
 	ctx := r.Context()
@@ -641,7 +598,7 @@ func (s *RedfishServer) ResetBIOS(w http.ResponseWriter, r *http.Request, system
 	_, span := tracer.Start(ctx, "redfish.RedfishServer.ResetBIOS")
 	defer span.End()
 
-	s.Log.Info("resetting BIOS settings", "system", systemId)
+	s.Log.Info("resetting BIOS settings", "system_id", systemId)
 
 	// Check if firmware file exists
 	if s.firmwarePath == "" {
@@ -652,14 +609,16 @@ func (s *RedfishServer) ResetBIOS(w http.ResponseWriter, r *http.Request, system
 		return
 	}
 
-	// Create firmware manager for the system
-	firmwareMgr, err := manager.NewEDK2Manager(s.firmwarePath, s.Log)
+	// Stage the changes in a transaction so that a failure leaves the
+	// firmware untouched
+	firmwareMgr, err := firmwaretx.Begin(s.firmwarePath, s.Log)
 	if err != nil {
 		s.Log.Error(err, "failed to create firmware manager")
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(redfishError(err))
 		return
 	}
+	defer firmwareMgr.Rollback()
 
 	// Reset to defaults
 	err = firmwareMgr.ResetToDefaults()
@@ -671,113 +630,7 @@ func (s *RedfishServer) ResetBIOS(w http.ResponseWriter, r *http.Request, system
 	}
 
 	// Save changes
-	err = firmwareMgr.SaveChanges()
-	if err != nil {
-		s.Log.Error(err, "failed to save BIOS settings")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(redfishError(err))
-		return
-	}
-
-	// Return success
-	w.WriteHeader(http.StatusNoContent)
-}
-
-// Handler for updating BIOS settings.
-func (s *RedfishServer) UpdateBIOS(w http.ResponseWriter, r *http.Request, systemId string) {
-	ctx := r.Context()
-	tracer := otel.Tracer(tracerName)
-	_, span := tracer.Start(ctx, "redfish.RedfishServer.UpdateBIOS")
-	defer span.End()
-
-	s.Log.Info("updating BIOS settings", "system", systemId)
-
-	// Check if firmware file exists
-	if s.firmwarePath == "" {
-		err := errors.New("firmware path not configured")
-		s.Log.Error(err, "firmware path not set")
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(redfishError(err))
-		return
-	}
-
-	// Read request body
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		s.Log.Error(err, "failed to read request body")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(redfishError(err))
-		return
-	}
-
-	// Parse request body
-	var request BiosUpdateRequest
-	err = json.Unmarshal(body, &request)
-	if err != nil {
-		s.Log.Error(err, "failed to parse request body")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(redfishError(err))
-		return
-	}
-
-	// Create firmware manager for the system
-	firmwareMgr, err := manager.NewEDK2Manager(s.firmwarePath, s.Log)
-	if err != nil {
-		s.Log.Error(err, "failed to create firmware manager")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(redfishError(err))
-		return
-	}
-
-	// Apply settings
-	if attrs := request.Attributes; attrs != nil {
-		// Update network settings if provided
-		if netSettings, ok := attrs["NetworkSettings"].(map[string]any); ok {
-			ns := types.NetworkSettings{}
-
-			if mac, ok := netSettings["MacAddress"].(string); ok {
-				ns.MacAddress = mac
-			}
-
-			if dhcp, ok := netSettings["EnableDHCP"].(bool); ok {
-				ns.EnableDHCP = dhcp
-			}
-
-			if ipv6, ok := netSettings["EnableIPv6"].(bool); ok {
-				ns.EnableIPv6 = ipv6
-			}
-
-			if vlan, ok := netSettings["VLANEnabled"].(bool); ok {
-				ns.VLANEnabled = vlan
-			}
-
-			if vlanid, ok := netSettings["VLANID"].(string); ok {
-				ns.VLANID = vlanid
-			}
-
-			err = firmwareMgr.SetNetworkSettings(ns)
-			if err != nil {
-				s.Log.Error(err, "failed to update network settings")
-				w.WriteHeader(http.StatusInternalServerError)
-				json.NewEncoder(w).Encode(redfishError(err))
-				return
-			}
-		}
-
-		// Update boot timeout if provided
-		if timeout, ok := attrs["BootTimeout"].(float64); ok {
-			err = firmwareMgr.SetFirmwareTimeoutSeconds(int(timeout))
-			if err != nil {
-				s.Log.Error(err, "failed to update boot timeout")
-				w.WriteHeader(http.StatusInternalServerError)
-				json.NewEncoder(w).Encode(redfishError(err))
-				return
-			}
-		}
-	}
-
-	// Save changes
-	err = firmwareMgr.SaveChanges()
+	err = firmwareMgr.Apply()
 	if err != nil {
 		s.Log.Error(err, "failed to save BIOS settings")
 		w.WriteHeader(http.StatusInternalServerError)
@@ -789,16 +642,6 @@ func (s *RedfishServer) UpdateBIOS(w http.ResponseWriter, r *http.Request, syste
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// GetTask implements ServerInterface.
-func (s *RedfishServer) GetTask(w http.ResponseWriter, r *http.Request, taskId string) {
-	panic("unimplemented")
-}
-
-// GetTaskList implements ServerInterface.
-func (s *RedfishServer) GetTaskList(w http.ResponseWriter, r *http.Request) {
-	panic("unimplemented")
-}
-
 // GetVolumes implements ServerInterface.
 func (s *RedfishServer) GetVolumes(
 	w http.ResponseWriter,
@@ -809,66 +652,6 @@ func (s *RedfishServer) GetVolumes(
 	panic("unimplemented")
 }
 
-// InsertVirtualMedia implements ServerInterface.
-func (s *RedfishServer) InsertVirtualMedia(
-	w http.ResponseWriter,
-	r *http.Request,
-	managerId string,
-	virtualMediaId string,
-) {
-	req := InsertMediaRequestBody{}
-
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		s.Log.Error(
-			err,
-			"error decoding request",
-			"manager",
-			managerId,
-			"virtualMedia",
-			virtualMediaId,
-		)
-		return
-	} else {
-		w.WriteHeader(http.StatusNoContent)
-	}
-}
-
-// ListManagerVirtualMedia implements ServerInterface.
-func (s *RedfishServer) ListManagerVirtualMedia(
-	w http.ResponseWriter,
-	r *http.Request,
-	managerId string,
-) {
-	ctx := r.Context()
-	tracer := otel.Tracer(tracerName)
-	_, span := tracer.Start(ctx, "redfish.RedfishServer.ListManagerVirtualMedia")
-	defer span.End()
-
-	ids := make([]IdRef, 0)
-
-	odataId := "/redfish/v1/Managers/1/VirtualMedia/1"
-	ids = append(ids, IdRef{
-		OdataId: &odataId,
-	})
-
-	response := Collection{
-		Members: &ids,
-		OdataContext: util.Ptr(
-			"/redfish/v1/$metadata#VirtualMediaCollection.VirtualMediaCollection",
-		),
-		OdataType:         "#VirtualMediaCollection.VirtualMediaCollection",
-		Name:              util.Ptr("Virtual Media Collection"),
-		OdataId:           "/redfish/v1/Managers/1/VirtualMedia",
-		MembersOdataCount: util.Ptr(len(ids)),
-	}
-
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		s.Log.Error(err, "error encoding response", "manager", managerId)
-	}
-}
-
 // ListManagers implements ServerInterface.
 func (s *RedfishServer) ListManagers(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
@@ -909,6 +692,22 @@ func (s *RedfishServer) ListSystems(w http.ResponseWriter, r *http.Request) {
 
 	ids := make([]IdRef, 0)
 
+	var filter systemFilter
+	if expr := r.URL.Query().Get("$filter"); expr != "" {
+		var err error
+		if filter, err = parseFilter(expr); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(redfishError(err))
+			return
+		}
+	}
+	expand, err := parseExpand(r.URL.Query().Get("$expand"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
 	keys, err := s.reader.GetKeys(r.Context())
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -916,7 +715,15 @@ func (s *RedfishServer) ListSystems(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	expanded := []any{}
 	for _, m := range keys {
+		if filter != nil && !filter(s.systemProperties(ctx, m)) {
+			continue
+		}
+		if expand {
+			expanded = append(expanded, s.expandSystem(ctx, m))
+			continue
+		}
 		odataId := fmt.Sprintf("/redfish/v1/Systems/%s", m)
 		ids = append(ids, IdRef{
 			OdataId: &odataId,
@@ -934,12 +741,117 @@ func (s *RedfishServer) ListSystems(w http.ResponseWriter, r *http.Request) {
 		MembersOdataCount: util.Ptr(len(ids)),
 	}
 
-	if err := json.NewEncoder(w).Encode(response); err != nil {
+	var body any = response
+	if expand {
+		response.MembersOdataCount = util.Ptr(len(expanded))
+		body = expandedSystems{Collection: response, Members: expanded}
+	}
+	if err := json.NewEncoder(w).Encode(body); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		s.Log.Error(err, "error encoding response")
 	}
 }
 
+// systemMAC resolves a system id, which is the MAC address of the system or
+// the machine UUID it sent in DHCP option 97.
+func (s *RedfishServer) systemMAC(systemId string) (net.HardwareAddr, error) {
+	mac, err := util.ParseMAC(systemId)
+	if err == nil || s.hosts == nil {
+		return mac, err
+	}
+	host, herr := s.hosts.FindByUUID(systemId)
+	if herr != nil {
+		return nil, err
+	}
+
+	return util.ParseMAC(host.MAC)
+}
+
+// systemUUID returns the machine UUID of mac as recorded from DHCP option 97.
+// Systems that never sent one fall back to their MAC address.
+func (s *RedfishServer) systemUUID(mac net.HardwareAddr) string {
+	if s.hosts != nil {
+		if host, err := s.hosts.Get(mac); err == nil && host.UUID != "" {
+			return host.UUID
+		}
+	}
+
+	return mac.String()
+}
+
+// systemProperties returns a lookup of the $filter properties of the system
+// mac. Each property is read from its source on first use, so filters that do
+// not test PowerState do not query the power backend.
+func (s *RedfishServer) systemProperties(
+	ctx context.Context,
+	mac net.HardwareAddr,
+) func(string) string {
+	var (
+		dhcp       *data.DHCP
+		dhcpLoaded bool
+	)
+	lease := func() *data.DHCP {
+		if !dhcpLoaded {
+			dhcpLoaded = true
+			var err error
+			if dhcp, _, err = s.reader.GetByMac(ctx, mac); err != nil {
+				s.Log.V(1).Info("error getting system by mac", "mac", mac.String(), "error", err)
+			}
+		}
+		return dhcp
+	}
+
+	return func(property string) string {
+		switch property {
+		case filterId:
+			return mac.String()
+		case filterUUID:
+			return s.systemUUID(mac)
+		case filterHostName:
+			if d := lease(); d != nil {
+				return d.Hostname
+			}
+		case filterIPAddress:
+			if d := lease(); d != nil && d.IPAddress.IsValid() {
+				return d.IPAddress.String()
+			}
+		case filterPowerState:
+			if s.power == nil {
+				return ""
+			}
+			pwr, err := s.power.GetPower(ctx, mac)
+			if err != nil || pwr == nil {
+				return ""
+			}
+			return string(redfishPowerState(*pwr))
+		case filterBootProfile:
+			if s.hosts == nil {
+				return ""
+			}
+			if host, err := s.hosts.Get(mac); err == nil && host.ObservedBootSource != nil {
+				return host.ObservedBootSource.Profile
+			}
+		}
+		return ""
+	}
+}
+
+// redfishPowerState maps a backend power state to its Redfish value.
+func redfishPowerState(pwr data.PowerState) PowerState {
+	switch pwr {
+	case data.PowerOn:
+		return On
+	case data.PowerOff:
+		return Off
+	case data.PoweringOn:
+		return PoweringOn
+	case data.PoweringOff:
+		return PoweringOff
+	default:
+		return ""
+	}
+}
+
 // ResetIdrac implements ServerInterface.
 func (s *RedfishServer) ResetIdrac(w http.ResponseWriter, r *http.Request) {
 	panic("unimplemented")
@@ -959,9 +871,9 @@ func (s *RedfishServer) ResetSystem(w http.ResponseWriter, r *http.Request, syst
 		return
 	}
 
-	s.Log.Info("resetting system", "system", systemId, "resetType", req.ResetType)
+	s.Log.Info("resetting system", "system_id", systemId, "resetType", req.ResetType)
 
-	systemIdAddr, err := net.ParseMAC(systemId)
+	systemIdAddr, err := s.systemMAC(systemId)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		s.Log.Error(err, "error parsing system id")
@@ -977,7 +889,7 @@ func (s *RedfishServer) ResetSystem(w http.ResponseWriter, r *http.Request, syst
 
 	if pwr == nil {
 		w.WriteHeader(http.StatusNotFound)
-		s.Log.Error(errors.New("power not found"), "system not found", "system", systemId)
+		s.Log.Error(errors.New("power not found"), "system not found", "system_id", systemId)
 		return
 	}
 
@@ -986,32 +898,62 @@ func (s *RedfishServer) ResetSystem(w http.ResponseWriter, r *http.Request, syst
 		resetType = *req.ResetType
 	}
 
-	var desiredResetState data.PowerState
+	desiredResetState, cycle, err := resetTarget(resetType)
+	if err != nil {
+		s.Log.Error(err, "invalid reset request", "system_id", systemId)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
+	if dryRun(r) {
+		s.writeDryRun(ctx, w, systemIdAddr, PowerDryRun{
+			System:            systemId,
+			Action:            string(resetType),
+			CurrentPowerState: redfishPowerState(*pwr),
+			TargetPowerState:  redfishPowerState(desiredResetState),
+			WouldChange:       cycle || desiredResetState != *pwr,
+		})
+		return
+	}
+	audit.SetOld(ctx, systemSettings{PowerState: util.Ptr(redfishPowerState(*pwr))})
+
+	tk := s.tasks.Start(
+		fmt.Sprintf("reset-%d", time.Now().UnixNano()),
+		"Reset Task",
+		fmt.Sprintf("%s of system %s", resetType, systemId),
+		"/redfish/v1/Systems/"+systemId,
+	)
+
+	if cycle || (desiredResetState == data.PowerOn && *pwr != data.PowerOn) {
+		// The firmware reads its settings as the system boots.
+		if err := s.applyPendingBios(systemIdAddr); err != nil {
+			s.Log.Error(err, "failed to apply pending BIOS settings", "system_id", systemId)
+		}
+	}
 
-	switch resetType {
-	case ResetTypePowerCycle:
+	if cycle {
 		err := s.power.PowerCycle(ctx, systemIdAddr)
+		tk.Done(err)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
-			s.Log.Error(err, "error power cycling system", "system", systemId)
+			s.Log.Error(err, "error power cycling system", "system_id", systemId)
 			return
 		}
 		w.WriteHeader(http.StatusNoContent)
 		return
-	case ResetTypeForceOff:
-		desiredResetState = data.PowerOff
-	case ResetTypeForceOn, ResetTypeOn:
-		desiredResetState = data.PowerOn
 	}
 
 	if desiredResetState != *pwr {
 		err := s.power.SetPower(ctx, systemIdAddr, desiredResetState)
 		if err != nil {
+			tk.Done(err)
 			w.WriteHeader(http.StatusInternalServerError)
-			s.Log.Error(err, "error forcing on system", "system", systemId)
+			s.Log.Error(err, "error forcing on system", "system_id", systemId)
 			return
 		}
 	}
+	tk.Done(nil)
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -1029,9 +971,9 @@ func (s *RedfishServer) SetSystem(w http.ResponseWriter, r *http.Request, system
 		return
 	}
 
-	s.Log.Info("setting system", "system", systemId, "systemInfo", req)
+	s.Log.Info("setting system", "system_id", systemId, "systemInfo", req)
 
-	systemIdAddr, err := net.ParseMAC(systemId)
+	systemIdAddr, err := s.systemMAC(systemId)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		s.Log.Error(err, "error parsing system id")
@@ -1045,10 +987,16 @@ func (s *RedfishServer) SetSystem(w http.ResponseWriter, r *http.Request, system
 		return
 	}
 
+	if dryRun(r) {
+		s.dryRunSetSystem(ctx, w, systemId, systemIdAddr, req, *pwr)
+		return
+	}
+	s.auditOldSystem(ctx, systemIdAddr)
+
 	if req.Boot.BootSourceOverrideTarget != nil {
 		s.Log.Info(
 			"setting boot source override",
-			"system",
+			"system_id",
 			systemId,
 			"bootSourceOverrideTarget",
 			*req.Boot.BootSourceOverrideTarget,
@@ -1058,34 +1006,38 @@ func (s *RedfishServer) SetSystem(w http.ResponseWriter, r *http.Request, system
 
 		switch *req.Boot.BootSourceOverrideTarget {
 		case Pxe:
-			s.Log.Info("setting boot source override to PXE", "system", systemId)
+			s.Log.Info("setting boot source override to PXE", "system_id", systemId)
 			nextBootIndex = 99
+			if err := s.hosts.ResetBootAttempts(systemIdAddr); err != nil {
+				s.Log.Error(err, "failed to reset boot attempts", "system_id", systemId)
+			}
 		case Hdd:
-			s.Log.Info("setting boot source override to HDD", "system", systemId)
+			s.Log.Info("setting boot source override to HDD", "system_id", systemId)
 			nextBootIndex = 0
 		case None:
-			s.Log.Info("clearing boot source override", "system", systemId)
+			s.Log.Info("clearing boot source override", "system_id", systemId)
 		default:
 			err := fmt.Errorf(
 				"invalid boot source override target: %s",
 				*req.Boot.BootSourceOverrideTarget,
 			)
-			s.Log.Error(err, "invalid boot source override target", "system", systemId)
+			s.Log.Error(err, "invalid boot source override target", "system_id", systemId)
 			w.WriteHeader(http.StatusBadRequest)
 			json.NewEncoder(w).Encode(redfishError(err))
 			return
 		}
 
-		firmwareMgr, err := s.GetEdk2FirmwareManager(systemIdAddr)
+		firmwareMgr, err := s.beginFirmwareTx(systemIdAddr)
 		if err != nil {
 			s.Log.Error(err, "failed to create firmware manager")
 			w.WriteHeader(http.StatusInternalServerError)
 			json.NewEncoder(w).Encode(redfishError(err))
 			return
 		}
+		defer firmwareMgr.Rollback()
 
 		if err := firmwareMgr.SetMacAddress(systemIdAddr); err != nil {
-			s.Log.Error(err, "failed to set MAC address", "system", systemId)
+			s.Log.Error(err, "failed to set MAC address", "system_id", systemId)
 			w.WriteHeader(http.StatusInternalServerError)
 			json.NewEncoder(w).Encode(redfishError(err))
 			return
@@ -1093,26 +1045,33 @@ func (s *RedfishServer) SetSystem(w http.ResponseWriter, r *http.Request, system
 
 		if nextBootIndex == 0 {
 			if err := firmwareMgr.DeleteBootNext(); err != nil {
-				s.Log.Error(err, "failed to delete boot next", "system", systemId)
+				s.Log.Error(err, "failed to delete boot next", "system_id", systemId)
 				w.WriteHeader(http.StatusInternalServerError)
 				json.NewEncoder(w).Encode(redfishError(err))
 				return
 			}
 		} else {
 			if err = firmwareMgr.SetBootNext(nextBootIndex); err != nil {
-				s.Log.Error(err, "failed to set boot next", "system", systemId)
+				s.Log.Error(err, "failed to set boot next", "system_id", systemId)
 				w.WriteHeader(http.StatusInternalServerError)
 				json.NewEncoder(w).Encode(redfishError(err))
 				return
 			}
 		}
 
-		if err = firmwareMgr.SaveChanges(); err != nil {
-			s.Log.Error(err, "failed to save boot settings", "system", systemId)
+		if err = firmwareMgr.Apply(); err != nil {
+			s.Log.Error(err, "failed to save boot settings", "system_id", systemId)
 			w.WriteHeader(http.StatusInternalServerError)
 			json.NewEncoder(w).Encode(redfishError(err))
 			return
 		}
+
+		if err := s.hosts.RecordRequestedBootSource(
+			systemIdAddr,
+			string(*req.Boot.BootSourceOverrideTarget),
+		); err != nil {
+			s.Log.Error(err, "failed to record requested boot source", "system_id", systemId)
+		}
 	}
 
 	var powerState PowerState
@@ -1138,13 +1097,13 @@ func (s *RedfishServer) SetSystem(w http.ResponseWriter, r *http.Request, system
 		err := s.power.SetPower(ctx, systemIdAddr, targetPowerState)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
-			s.Log.Error(err, "error setting power state", "system", systemId)
+			s.Log.Error(err, "error setting power state", "system_id", systemId)
 			return
 		}
 	}
 
 	w.WriteHeader(http.StatusNoContent)
-	s.Log.Info("system updated", "system", systemId)
+	s.Log.Info("system updated", "system_id", systemId)
 }
 
 // UpdateService implements ServerInterface.
@@ -1159,7 +1118,7 @@ func (s *RedfishServer) UpdateService(w http.ResponseWriter, r *http.Request) {
 	// Create response for update service
 	response := UpdateService{
 		OdataId:        util.Ptr("/redfish/v1/UpdateService"),
-		OdataType:      util.Ptr("#UpdateService.v1_9_0.UpdateService"),
+		OdataType:      util.Ptr(odataType(ctx, "UpdateService")),
 		Id:             util.Ptr("UpdateService"),
 		Name:           util.Ptr("Update Service"),
 		Description:    util.Ptr("Service enables updating firmware"),
@@ -1188,15 +1147,6 @@ func (s *RedfishServer) UpdateServiceSimpleUpdate(w http.ResponseWriter, r *http
 
 	s.Log.Info("processing firmware update")
 
-	// Check if firmware file exists
-	if s.firmwarePath == "" {
-		err := errors.New("firmware path not configured")
-		s.Log.Error(err, "firmware path not set")
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(redfishError(err))
-		return
-	}
-
 	// Read request body
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
@@ -1225,6 +1175,28 @@ func (s *RedfishServer) UpdateServiceSimpleUpdate(w http.ResponseWriter, r *http
 		return
 	}
 
+	if targetsManager(request.Targets) {
+		s.updateManager(ctx, w, r, request)
+		return
+	}
+
+	target, targetsSystem, err := s.firmwareTarget(request.Targets)
+	if err != nil {
+		s.Log.Error(err, "invalid update target", "targets", request.Targets)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
+	// Check if firmware file exists
+	if s.firmwarePath == "" && !targetsSystem {
+		err := errors.New("firmware path not configured")
+		s.Log.Error(err, "firmware path not set")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
 	// Handle local file update
 	if strings.HasPrefix(*request.ImageURI, "file://") {
 		localPath := strings.TrimPrefix(*request.ImageURI, "file://")
@@ -1238,6 +1210,18 @@ func (s *RedfishServer) UpdateServiceSimpleUpdate(w http.ResponseWriter, r *http
 			return
 		}
 
+		if targetsSystem {
+			if err := s.installSystemFirmware(target, firmwareData); err != nil {
+				s.Log.Error(err, "failed to update firmware", "id", target.id())
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(redfishError(err))
+				return
+			}
+			s.Log.Info("firmware updated successfully", "id", target.id())
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+
 		// Create firmware manager
 		firmwareMgr, err := manager.NewEDK2Manager(s.firmwarePath, s.Log)
 		if err != nil {
@@ -1263,12 +1247,15 @@ func (s *RedfishServer) UpdateServiceSimpleUpdate(w http.ResponseWriter, r *http
 
 	// For remote URIs (HTTP, HTTPS), return a task that client can monitor
 	taskId := fmt.Sprintf("firmware-update-%d", time.Now().Unix())
+	progress := s.downloads.Start(taskId, "Firmware Update Task", *request.ImageURI)
+	tk := s.tasks.Start(taskId, "Firmware Update Task", "Update from "+*request.ImageURI,
+		s.firmwareInventoryURI(target, targetsSystem))
 	response := Task{
 		OdataId:     util.Ptr(fmt.Sprintf("/redfish/v1/TaskService/Tasks/%s", taskId)),
-		OdataType:   util.Ptr("#Task.v1_6_0.Task"),
+		OdataType:   util.Ptr(odataType(ctx, "Task")),
 		Id:          &taskId,
 		Name:        util.Ptr("Firmware Update Task"),
-		TaskState:   util.Ptr(TaskStateNew),
+		TaskState:   util.Ptr(TaskStateRunning),
 		StartTime:   util.Ptr(time.Now()),
 		TaskMonitor: util.Ptr(fmt.Sprintf("/redfish/v1/TaskMonitor/%s", taskId)),
 	}
@@ -1278,21 +1265,61 @@ func (s *RedfishServer) UpdateServiceSimpleUpdate(w http.ResponseWriter, r *http
 	w.WriteHeader(http.StatusAccepted)
 	json.NewEncoder(w).Encode(response)
 
-	// Start background task to download and update firmware
-	go s.processFirmwareUpdate(ctx, *request.ImageURI, taskId)
+	// Start background task to download and update firmware. The request
+	// context ends with this response.
+	go func() {
+		err := s.processFirmwareUpdate(
+			context.WithoutCancel(ctx), *request.ImageURI, progress, target, targetsSystem)
+		progress.Done(err)
+		tk.Done(err)
+		if err != nil {
+			s.Log.Error(err, "firmware update task failed", "uri", *request.ImageURI,
+				"taskId", taskId)
+			return
+		}
+		s.Log.Info("firmware update task completed", "uri", *request.ImageURI, "taskId", taskId)
+	}()
 }
 
-// processFirmwareUpdate handles the firmware update process in the background.
-func (s *RedfishServer) processFirmwareUpdate(_ context.Context, imageURI string, taskId string) {
-	s.Log.Info("starting firmware update task", "uri", imageURI, "taskId", taskId)
-
-	// Placeholder for task update mechanism
-	// In a real implementation, you would:
-	// 1. Download the firmware from imageURI
-	// 2. Validate the firmware image
-	// 3. Update the task status as it progresses
-	// 4. Apply the firmware update using the firmware manager
-	// 5. Complete the task
+// processFirmwareUpdate downloads imageURI, recording its progress on
+// progress, and installs it on target, or on the shared firmware when the
+// update does not target a system.
+func (s *RedfishServer) processFirmwareUpdate(
+	ctx context.Context,
+	imageURI string,
+	progress *download.Download,
+	target systemFirmware,
+	targetsSystem bool,
+) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, imageURI, nil)
+	if err != nil {
+		return fmt.Errorf("invalid image URI %q: %w", imageURI, err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s: %w", imageURI, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch %s: %s", imageURI, resp.Status)
+	}
+	progress.SetTotal(resp.ContentLength)
+	body := io.TeeReader(resp.Body, progress)
+
+	if targetsSystem {
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return fmt.Errorf("failed to download %s: %w", imageURI, err)
+		}
+		return s.installSystemFirmware(target, data)
+	}
+
+	upload, err := s.spoolFirmware(body)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", imageURI, err)
+	}
+
+	return s.installFirmware(upload)
 }
 
 // Additional response types needed for firmware management.
@@ -1301,7 +1328,8 @@ type BiosUpdateRequest struct {
 }
 
 type SimpleUpdateRequest struct {
-	ImageURI         *string  `json:"ImageURI,omitempty"`
-	TransferProtocol *string  `json:"TransferProtocol,omitempty"`
-	Targets          []string `json:"Targets,omitempty"`
+	ImageURI         *string          `json:"ImageURI,omitempty"`
+	TransferProtocol *string          `json:"TransferProtocol,omitempty"`
+	Targets          []string         `json:"Targets,omitempty"`
+	Oem              *simpleUpdateOem `json:"Oem,omitempty"`
 }
@@ -0,0 +1,238 @@
+package redfish
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/metal3-community/metal-boot/internal/adminauth"
+	"github.com/metal3-community/metal-boot/internal/audit"
+	"github.com/metal3-community/metal-boot/internal/session"
+	"github.com/metal3-community/metal-boot/internal/util"
+	"go.opentelemetry.io/otel"
+)
+
+const (
+	sessionServicePath = "/redfish/v1/SessionService"
+	sessionsPath       = sessionServicePath + "/Sessions"
+	authTokenHeader    = "X-Auth-Token"
+)
+
+var (
+	errSessionUserRequired = errors.New("UserName is required")
+	errAdminRequired       = errors.New("this action requires an admin authenticated through admin_auth")
+)
+
+type sessionService struct {
+	OdataId        string `json:"@odata.id"`
+	OdataType      string `json:"@odata.type"`
+	Id             string `json:"Id"`
+	Name           string `json:"Name"`
+	ServiceEnabled bool   `json:"ServiceEnabled"`
+	SessionTimeout int    `json:"SessionTimeout"`
+	Sessions       IdRef  `json:"Sessions"`
+}
+
+// redfishSession is a Session resource. Password is only read; it is always
+// null in responses.
+type redfishSession struct {
+	OdataId     string  `json:"@odata.id,omitempty"`
+	OdataType   string  `json:"@odata.type,omitempty"`
+	Id          string  `json:"Id,omitempty"`
+	Name        string  `json:"Name,omitempty"`
+	UserName    string  `json:"UserName"`
+	Password    *string `json:"Password"`
+	CreatedTime string  `json:"CreatedTime,omitempty"`
+}
+
+func sessionPath(id string) string {
+	return sessionsPath + "/" + id
+}
+
+func sessionResource(ctx context.Context, sess session.Session) redfishSession {
+	return redfishSession{
+		OdataId:     sessionPath(sess.Id),
+		OdataType:   odataType(ctx, "Session"),
+		Id:          sess.Id,
+		Name:        "User Session",
+		UserName:    sess.UserName,
+		CreatedTime: sess.Created.Format(time.RFC3339),
+	}
+}
+
+// authenticateSession rejects requests carrying an X-Auth-Token that is not
+// the token of a live session. The emulation does not require a session, so
+// requests without the header pass.
+func (s *RedfishServer) authenticateSession(w http.ResponseWriter, r *http.Request) bool {
+	token := r.Header.Get(authTokenHeader)
+	if token == "" {
+		return true
+	}
+	sess, err := s.sessions.Authenticate(token)
+	if err != nil {
+		s.Log.Info("rejected redfish request with an unknown session token",
+			"path", r.URL.Path,
+			"method", r.Method)
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return false
+	}
+	audit.SetActor(r.Context(), sess.UserName)
+
+	return true
+}
+
+// sessionRequest reports whether r logs in or out. Clients need a session to
+// read state, so read-only mode lets these through.
+func sessionRequest(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodPost:
+		return r.URL.Path == sessionsPath
+	case http.MethodDelete:
+		id, ok := strings.CutPrefix(r.URL.Path, sessionsPath+"/")
+		return ok && id != "" && !strings.Contains(id, "/")
+	default:
+		return false
+	}
+}
+
+// requireAdmin rejects requests that do not come from an admin of the admin
+// API, for actions that change metal-boot itself. Without admin_auth such
+// actions are refused.
+func (s *RedfishServer) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	principal := adminauth.FromContext(r.Context())
+	if principal != nil && principal.Role == adminauth.RoleAdmin {
+		return true
+	}
+
+	status := http.StatusUnauthorized
+	if principal != nil {
+		status = http.StatusForbidden
+	}
+	s.Log.Info("rejected redfish request requiring an admin",
+		"path", r.URL.Path,
+		"method", r.Method)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(redfishError(errAdminRequired))
+	return false
+}
+
+// GetSessionService describes the SessionService.
+func (s *RedfishServer) GetSessionService(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer(tracerName).Start(r.Context(), "redfish.RedfishServer.GetSessionService")
+	defer span.End()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessionService{
+		OdataId:        sessionServicePath,
+		OdataType:      odataType(ctx, "SessionService"),
+		Id:             "SessionService",
+		Name:           "Session Service",
+		ServiceEnabled: true,
+		SessionTimeout: int(s.sessions.Timeout().Seconds()),
+		Sessions:       IdRef{OdataId: util.Ptr(sessionsPath)},
+	})
+}
+
+// ListSessions lists the live sessions.
+func (s *RedfishServer) ListSessions(w http.ResponseWriter, r *http.Request) {
+	_, span := otel.Tracer(tracerName).Start(r.Context(), "redfish.RedfishServer.ListSessions")
+	defer span.End()
+
+	members := []IdRef{}
+	for _, sess := range s.sessions.List() {
+		members = append(members, IdRef{OdataId: util.Ptr(sessionPath(sess.Id))})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(certificateCollection{
+		OdataId:      sessionsPath,
+		OdataType:    "#SessionCollection.SessionCollection",
+		Name:         "Session Collection",
+		Members:      members,
+		MembersCount: len(members),
+	})
+}
+
+// CreateSession issues a session and returns its token in X-Auth-Token. The
+// emulation has no user database, so any UserName is accepted, up to the
+// session limits of the store.
+func (s *RedfishServer) CreateSession(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer(tracerName).Start(r.Context(), "redfish.RedfishServer.CreateSession")
+	defer span.End()
+
+	req, err := decodeBody[redfishSession](r)
+	if err == nil && req.UserName == "" {
+		err = errSessionUserRequired
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
+	sess, err := s.sessions.Create(req.UserName)
+	if errors.Is(err, session.ErrTooManySessions) {
+		s.Log.Info("rejected session", "user", req.UserName, "reason", err.Error())
+		w.Header().Set("Retry-After", strconv.Itoa(int(s.sessions.Timeout().Seconds())))
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+	if err != nil {
+		s.Log.Error(err, "failed to create session", "user", req.UserName)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+	s.Log.Info("created session", "id", sess.Id, "user", sess.UserName)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", sessionPath(sess.Id))
+	w.Header().Set(authTokenHeader, sess.Token)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(sessionResource(ctx, sess))
+}
+
+// GetSession returns one session.
+func (s *RedfishServer) GetSession(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer(tracerName).Start(r.Context(), "redfish.RedfishServer.GetSession")
+	defer span.End()
+
+	sess, err := s.sessions.Get(r.PathValue("sessionId"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessionResource(ctx, sess))
+}
+
+// DeleteSession ends a session.
+func (s *RedfishServer) DeleteSession(w http.ResponseWriter, r *http.Request) {
+	_, span := otel.Tracer(tracerName).Start(r.Context(), "redfish.RedfishServer.DeleteSession")
+	defer span.End()
+
+	id := r.PathValue("sessionId")
+	err := s.sessions.Delete(id)
+	switch {
+	case errors.Is(err, session.ErrNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	case err != nil:
+		s.Log.Error(err, "failed to delete session", "id", id)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+	s.Log.Info("deleted session", "id", id)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,107 @@
+package redfish
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/metal3-community/metal-boot/internal/session"
+)
+
+func TestSessions(t *testing.T) {
+	sessions, err := session.New("", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &RedfishServer{Log: logr.Discard(), sessions: sessions}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+sessionsPath, s.CreateSession)
+	mux.HandleFunc("GET "+sessionsPath+"/{sessionId}", s.GetSession)
+	mux.HandleFunc("DELETE "+sessionsPath+"/{sessionId}", s.DeleteSession)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.authenticateSession(w, r) {
+			mux.ServeHTTP(w, r)
+		}
+	})
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set(authTokenHeader, token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(http.MethodPost, sessionsPath, "", `{"Password": "x"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("POST without UserName = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	rec := do(http.MethodPost, sessionsPath, "", `{"UserName": "ironic", "Password": "secret"}`)
+	token, location := rec.Header().Get(authTokenHeader), rec.Header().Get("Location")
+	if rec.Code != http.StatusCreated || token == "" || location == "" {
+		t.Fatalf("POST %s = %d, token %q, location %q", sessionsPath, rec.Code, token, location)
+	}
+	if strings.Contains(rec.Body.String(), "secret") {
+		t.Error("session response echoes the password")
+	}
+
+	if rec := do(http.MethodGet, location, token, ""); rec.Code != http.StatusOK {
+		t.Errorf("GET %s with the token = %d, want %d", location, rec.Code, http.StatusOK)
+	}
+	if rec := do(http.MethodGet, location, "stale", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("GET %s with an unknown token = %d, want %d", location, rec.Code, http.StatusUnauthorized)
+	}
+	if rec := do(http.MethodDelete, location, token, ""); rec.Code != http.StatusNoContent {
+		t.Errorf("DELETE %s = %d, want %d", location, rec.Code, http.StatusNoContent)
+	}
+	if rec := do(http.MethodGet, location, token, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("GET %s with a deleted token = %d, want %d", location, rec.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestSessionLimit(t *testing.T) {
+	sessions, err := session.New("", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &RedfishServer{Log: logr.Discard(), sessions: sessions}
+
+	create := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, sessionsPath, strings.NewReader(`{"UserName": "ironic"}`))
+		rec := httptest.NewRecorder()
+		s.CreateSession(rec, req)
+		return rec
+	}
+	for i := range session.MaxUserSessions {
+		if rec := create(); rec.Code != http.StatusCreated {
+			t.Fatalf("POST %d = %d, want %d", i, rec.Code, http.StatusCreated)
+		}
+	}
+	rec := create()
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("POST past the limit = %d, Retry-After %q, want %d",
+			rec.Code, rec.Header().Get("Retry-After"), http.StatusServiceUnavailable)
+	}
+}
+
+func TestSessionRequest(t *testing.T) {
+	for _, tt := range []struct {
+		method, path string
+		want         bool
+	}{
+		{http.MethodPost, sessionsPath, true},
+		{http.MethodDelete, sessionsPath + "/abc", true},
+		{http.MethodGet, sessionsPath, false},
+		{http.MethodDelete, sessionsPath, false},
+		{http.MethodDelete, sessionsPath + "/abc/def", false},
+		{http.MethodPost, "/redfish/v1/Systems/x/Actions/ComputerSystem.Reset", false},
+	} {
+		if got := sessionRequest(httptest.NewRequest(tt.method, tt.path, nil)); got != tt.want {
+			t.Errorf("sessionRequest(%s %s) = %v, want %v", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
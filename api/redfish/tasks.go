@@ -0,0 +1,280 @@
+package redfish
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/metal3-community/metal-boot/internal/download"
+	"github.com/metal3-community/metal-boot/internal/task"
+	"github.com/metal3-community/metal-boot/internal/util"
+	"go.opentelemetry.io/otel"
+)
+
+const (
+	taskServicePath = "/redfish/v1/TaskService"
+	tasksPath       = taskServicePath + "/Tasks"
+	taskMonitorPath = "/redfish/v1/TaskMonitor"
+	// taskMonitorRetryAfter is how long, in seconds, clients of a task
+	// monitor are asked to wait before polling again.
+	taskMonitorRetryAfter = "5"
+)
+
+type taskService struct {
+	OdataId                         string `json:"@odata.id"`
+	OdataType                       string `json:"@odata.type"`
+	Id                              string `json:"Id"`
+	Name                            string `json:"Name"`
+	ServiceEnabled                  bool   `json:"ServiceEnabled"`
+	DateTime                        string `json:"DateTime"`
+	CompletedTaskOverWritePolicy    string `json:"CompletedTaskOverWritePolicy"`
+	LifeCycleEventOnTaskStateChange bool   `json:"LifeCycleEventOnTaskStateChange"`
+	Tasks                           IdRef  `json:"Tasks"`
+}
+
+// taskWithProgress extends the generated Task model with PercentComplete and
+// the MetalBoot Oem section, which the OpenAPI document does not describe.
+type taskWithProgress struct {
+	Task
+	PercentComplete *int     `json:"PercentComplete,omitempty"`
+	Oem             *taskOem `json:"Oem,omitempty"`
+}
+
+type taskOem struct {
+	MetalBoot metalBootTaskOem `json:"MetalBoot"`
+}
+
+// metalBootTaskOem reports the transfer of a download task.
+type metalBootTaskOem struct {
+	OdataType string            `json:"@odata.type"`
+	Download  download.Progress `json:"Download"`
+}
+
+func taskPath(id string) string {
+	return tasksPath + "/" + id
+}
+
+// downloadTask renders a tracked download as a Task.
+func downloadTask(ctx context.Context, p download.Progress) taskWithProgress {
+	state := TaskStateRunning
+	var messages []Message
+	switch p.State {
+	case download.StateCompleted:
+		state = TaskStateCompleted
+	case download.StateFailed:
+		state = TaskStateException
+		messages = append(messages, Message{
+			MessageId: util.Ptr("Base.1.8.GeneralError"),
+			Message:   util.Ptr(p.Error),
+			Severity:  util.Ptr("Critical"),
+		})
+	}
+	if p.Stalled {
+		messages = append(messages, stalledMessage(p))
+	}
+
+	t := taskWithProgress{
+		Task: Task{
+			OdataId:     util.Ptr(taskPath(p.Id)),
+			OdataType:   util.Ptr(odataType(ctx, "Task")),
+			Id:          util.Ptr(p.Id),
+			Name:        util.Ptr(p.Name),
+			Description: util.Ptr("Download of " + p.URL),
+			TaskState:   &state,
+			StartTime:   util.Ptr(p.StartTime),
+		},
+		Oem: &taskOem{MetalBoot: metalBootTaskOem{
+			OdataType: "#MetalBoot.v1_0_0.Task",
+			Download:  p,
+		}},
+	}
+	if p.EndTime != nil {
+		t.EndTime = util.Ptr(p.EndTime.UTC().Format(time.RFC3339))
+	}
+	if len(messages) > 0 {
+		t.Messages = &messages
+	}
+	if percent := p.Percent(); percent >= 0 {
+		t.PercentComplete = &percent
+	}
+
+	return t
+}
+
+// trackedTask renders a task of the store as a Task. p is the download of the
+// task, if it has one, which fills in the progress of the transfer.
+func trackedTask(ctx context.Context, i task.Info, p *download.Progress) taskWithProgress {
+	health := HealthOK
+	switch i.State {
+	case task.StateException:
+		health = HealthCritical
+	case task.StateInterrupted:
+		health = HealthWarning
+	}
+
+	t := taskWithProgress{
+		Task: Task{
+			OdataId:     util.Ptr(taskPath(i.Id)),
+			OdataType:   util.Ptr(odataType(ctx, "Task")),
+			Id:          util.Ptr(i.Id),
+			Name:        util.Ptr(i.Name),
+			Description: util.Ptr(i.Description),
+			TaskState:   util.Ptr(TaskState(i.State)),
+			TaskStatus:  &health,
+			StartTime:   util.Ptr(i.StartTime),
+			TaskMonitor: util.Ptr(taskMonitorPath + "/" + i.Id),
+		},
+	}
+	if i.Target != "" {
+		t.Payload = &Payload{TargetUri: util.Ptr(i.Target)}
+	}
+	if i.EndTime != nil {
+		t.EndTime = util.Ptr(i.EndTime.UTC().Format(time.RFC3339))
+	}
+	if i.PercentComplete >= 0 {
+		t.PercentComplete = util.Ptr(i.PercentComplete)
+	}
+
+	var messages []Message
+	for _, m := range i.Messages {
+		id := "Base.1.8.GeneralError"
+		if m.Severity == task.SeverityOK {
+			id = "Base.1.8.Success"
+		}
+		messages = append(messages, Message{
+			MessageId: util.Ptr(id),
+			Message:   util.Ptr(m.Message),
+			Severity:  util.Ptr(m.Severity),
+		})
+	}
+
+	if p != nil {
+		d := downloadTask(ctx, *p)
+		t.Oem = d.Oem
+		if t.PercentComplete == nil && !i.Finished() {
+			t.PercentComplete = d.PercentComplete
+		}
+		if p.Stalled && !i.Finished() {
+			messages = append(messages, stalledMessage(*p))
+		}
+	}
+	if len(messages) > 0 {
+		t.Messages = &messages
+	}
+
+	return t
+}
+
+// stalledMessage is the warning on a download that receives no data.
+func stalledMessage(p download.Progress) Message {
+	return Message{
+		MessageId: util.Ptr("Base.1.8.GeneralError"),
+		Message: util.Ptr(fmt.Sprintf("No data received since %s",
+			p.LastProgressAt.UTC().Format(time.RFC3339))),
+		Severity: util.Ptr("Warning"),
+	}
+}
+
+// task returns the task with id: a tracked task, or a download that no task
+// was recorded for.
+func (s *RedfishServer) task(ctx context.Context, id string) (taskWithProgress, bool) {
+	i, ok := s.tasks.Get(id)
+	p, downloading := s.downloads.Get(id)
+	switch {
+	case ok && downloading:
+		return trackedTask(ctx, i, &p), true
+	case ok:
+		return trackedTask(ctx, i, nil), true
+	case downloading:
+		return downloadTask(ctx, p), true
+	}
+
+	return taskWithProgress{}, false
+}
+
+// GetTaskService describes the TaskService.
+func (s *RedfishServer) GetTaskService(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer(tracerName).Start(r.Context(), "redfish.RedfishServer.GetTaskService")
+	defer span.End()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(taskService{
+		OdataId:                         taskServicePath,
+		OdataType:                       odataType(ctx, "TaskService"),
+		Id:                              "TaskService",
+		Name:                            "Task Service",
+		ServiceEnabled:                  true,
+		DateTime:                        time.Now().UTC().Format(time.RFC3339),
+		CompletedTaskOverWritePolicy:    "Oldest",
+		LifeCycleEventOnTaskStateChange: false,
+		Tasks:                           IdRef{OdataId: util.Ptr(tasksPath)},
+	})
+}
+
+// GetTaskMonitor answers 202 Accepted while the task is running and the Task
+// once it has finished.
+func (s *RedfishServer) GetTaskMonitor(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer(tracerName).Start(r.Context(), "redfish.RedfishServer.GetTaskMonitor")
+	defer span.End()
+
+	id := r.PathValue("taskId")
+	t, ok := s.task(ctx, id)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(redfishError(fmt.Errorf("unknown task %q", id)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if *t.TaskState == TaskStateRunning {
+		w.Header().Set("Location", taskMonitorPath+"/"+id)
+		w.Header().Set("Retry-After", taskMonitorRetryAfter)
+		w.WriteHeader(http.StatusAccepted)
+	}
+	json.NewEncoder(w).Encode(t)
+}
+
+// GetTask implements ServerInterface.
+func (s *RedfishServer) GetTask(w http.ResponseWriter, r *http.Request, taskId string) {
+	ctx, span := otel.Tracer(tracerName).Start(r.Context(), "redfish.RedfishServer.GetTask")
+	defer span.End()
+
+	t, ok := s.task(ctx, taskId)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(redfishError(fmt.Errorf("unknown task %q", taskId)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
+}
+
+// GetTaskList implements ServerInterface.
+func (s *RedfishServer) GetTaskList(w http.ResponseWriter, r *http.Request) {
+	_, span := otel.Tracer(tracerName).Start(r.Context(), "redfish.RedfishServer.GetTaskList")
+	defer span.End()
+
+	members := []IdRef{}
+	tracked := make(map[string]bool)
+	for _, i := range s.tasks.List() {
+		tracked[i.Id] = true
+		members = append(members, IdRef{OdataId: util.Ptr(taskPath(i.Id))})
+	}
+	for _, p := range s.downloads.List() {
+		if !tracked[p.Id] {
+			members = append(members, IdRef{OdataId: util.Ptr(taskPath(p.Id))})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(certificateCollection{
+		OdataId:      tasksPath,
+		OdataType:    "#TaskCollection.TaskCollection",
+		Name:         "Task Collection",
+		Members:      members,
+		MembersCount: len(members),
+	})
+}
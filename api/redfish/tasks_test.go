@@ -0,0 +1,168 @@
+package redfish
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/metal3-community/metal-boot/internal/adminauth"
+	"github.com/metal3-community/metal-boot/internal/config"
+	"github.com/metal3-community/metal-boot/internal/download"
+	"github.com/metal3-community/metal-boot/internal/task"
+	"github.com/metal3-community/uefi-firmware-manager/edk2"
+)
+
+func TestSimpleUpdateDownloadTask(t *testing.T) {
+	images := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(edk2.RpiEfi)
+	}))
+	t.Cleanup(images.Close)
+
+	firmware := filepath.Join(t.TempDir(), edk2.FirmwareFileName)
+	if err := os.WriteFile(firmware, []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tasks, _ := task.New("")
+	s := &RedfishServer{
+		Config:       &config.Config{},
+		Log:          logr.Discard(),
+		firmwarePath: firmware,
+		downloads:    download.NewTracker(),
+		tasks:        tasks,
+	}
+
+	body := `{"ImageURI": "` + images.URL + `/RPI_EFI.fd"}`
+	rec := httptest.NewRecorder()
+	s.UpdateServiceSimpleUpdate(rec, httptest.NewRequest(http.MethodPost,
+		"/redfish/v1/UpdateService/Actions/UpdateService.SimpleUpdate", strings.NewReader(body)))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("SimpleUpdate status = %d, want %d: %s", rec.Code, http.StatusAccepted, rec.Body)
+	}
+	var accepted Task
+	if err := json.NewDecoder(rec.Body).Decode(&accepted); err != nil || accepted.Id == nil {
+		t.Fatalf("SimpleUpdate returned no task: %v", err)
+	}
+
+	var task taskWithProgress
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rec := httptest.NewRecorder()
+		s.GetTask(rec, httptest.NewRequest(http.MethodGet, taskPath(*accepted.Id), nil), *accepted.Id)
+		if err := json.NewDecoder(rec.Body).Decode(&task); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("GET %s = %d, %v", taskPath(*accepted.Id), rec.Code, err)
+		}
+		if *task.TaskState != TaskStateRunning {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("task is still running")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if *task.TaskState != TaskStateCompleted || task.PercentComplete == nil ||
+		*task.PercentComplete != 100 {
+		t.Errorf("task = %s at %v%%, want Completed at 100%%", *task.TaskState, task.PercentComplete)
+	}
+	if got := task.Oem.MetalBoot.Download.Bytes; got != int64(len(edk2.RpiEfi)) {
+		t.Errorf("task downloaded %d bytes, want %d", got, len(edk2.RpiEfi))
+	}
+	if got, _ := os.ReadFile(firmware); !bytes.Equal(got, edk2.RpiEfi) {
+		t.Errorf("firmware has %d bytes, want the downloaded image", len(got))
+	}
+
+	rec = httptest.NewRecorder()
+	s.GetTaskList(rec, httptest.NewRequest(http.MethodGet, tasksPath, nil))
+	var list certificateCollection
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil || list.MembersCount != 1 {
+		t.Errorf("GET %s = %+v, %v, want 1 task", tasksPath, list, err)
+	}
+
+	rec = httptest.NewRecorder()
+	s.GetTask(rec, httptest.NewRequest(http.MethodGet, taskPath("missing"), nil), "missing")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET %s = %d, want %d", taskPath("missing"), rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestSimpleUpdateManagerRequiresAdmin(t *testing.T) {
+	s := &RedfishServer{Config: &config.Config{}, Log: logr.Discard()}
+
+	tests := []struct {
+		name      string
+		principal *adminauth.Principal
+		want      int
+	}{
+		{name: "anonymous", want: http.StatusUnauthorized},
+		{name: "viewer", principal: &adminauth.Principal{Role: adminauth.RoleViewer}, want: http.StatusForbidden},
+		// Admins get through to the disabled self update.
+		{name: "admin", principal: &adminauth.Principal{Role: adminauth.RoleAdmin}, want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"ImageURI": "http://example.com/metal-boot", "Targets": ["` + managerPath + `"]}`
+			req := httptest.NewRequest(http.MethodPost,
+				"/redfish/v1/UpdateService/Actions/UpdateService.SimpleUpdate", strings.NewReader(body))
+			if tt.principal != nil {
+				req = req.WithContext(adminauth.WithPrincipal(req.Context(), tt.principal))
+			}
+			rec := httptest.NewRecorder()
+			s.UpdateServiceSimpleUpdate(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("SimpleUpdate status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+}
+
+func TestTaskMonitor(t *testing.T) {
+	tasks, _ := task.New("")
+	s := &RedfishServer{Log: logr.Discard(), tasks: tasks}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+taskMonitorPath+"/{taskId}", s.GetTaskMonitor)
+
+	tk := tasks.Start("reset-1", "Reset Task", "On of system 1", "/redfish/v1/Systems/1")
+	get := func() (*httptest.ResponseRecorder, taskWithProgress) {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, taskMonitorPath+"/reset-1", nil))
+		var got taskWithProgress
+		json.NewDecoder(rec.Body).Decode(&got)
+		return rec, got
+	}
+
+	rec, got := get()
+	location := rec.Header().Get("Location")
+	if rec.Code != http.StatusAccepted || location != taskMonitorPath+"/reset-1" {
+		t.Errorf("running task monitor = %d at %q, want 202 at the monitor", rec.Code, location)
+	}
+	if got.TaskState == nil || *got.TaskState != TaskStateRunning {
+		t.Errorf("running task state = %v, want Running", got.TaskState)
+	}
+
+	tk.Done(errors.New("bmc unreachable"))
+	rec, got = get()
+	if rec.Code != http.StatusOK {
+		t.Errorf("finished task monitor = %d, want 200", rec.Code)
+	}
+	if *got.TaskState != TaskStateException || *got.TaskStatus != HealthCritical ||
+		got.Messages == nil || *(*got.Messages)[0].Message != "bmc unreachable" {
+		t.Errorf("failed task = %s, %s, %v", *got.TaskState, *got.TaskStatus, got.Messages)
+	}
+	if got.Payload == nil || *got.Payload.TargetUri != "/redfish/v1/Systems/1" {
+		t.Errorf("task payload = %+v, want the system as target", got.Payload)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, taskMonitorPath+"/missing", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown task monitor = %d, want 404", rec.Code)
+	}
+}
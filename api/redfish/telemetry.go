@@ -0,0 +1,347 @@
+package redfish
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/metal3-community/metal-boot/internal/telemetry"
+	"github.com/metal3-community/metal-boot/internal/util"
+	"go.opentelemetry.io/otel"
+)
+
+const (
+	telemetryServicePath        = "/redfish/v1/TelemetryService"
+	metricReportDefinitionsPath = telemetryServicePath + "/MetricReportDefinitions"
+	metricReportsPath           = telemetryServicePath + "/MetricReports"
+	eventServicePath            = "/redfish/v1/EventService"
+	eventServiceSSEPath         = eventServicePath + "/SSE"
+)
+
+var errTelemetryDisabled = errors.New("telemetry is not enabled")
+
+type telemetryService struct {
+	OdataId                 string `json:"@odata.id"`
+	OdataType               string `json:"@odata.type"`
+	Id                      string `json:"Id"`
+	Name                    string `json:"Name"`
+	ServiceEnabled          bool   `json:"ServiceEnabled"`
+	MetricReportDefinitions IdRef  `json:"MetricReportDefinitions"`
+	MetricReports           IdRef  `json:"MetricReports"`
+}
+
+type metricReportDefinition struct {
+	OdataId                    string               `json:"@odata.id"`
+	OdataType                  string               `json:"@odata.type"`
+	Id                         string               `json:"Id"`
+	Name                       string               `json:"Name"`
+	Description                string               `json:"Description"`
+	MetricReportDefinitionType string               `json:"MetricReportDefinitionType"`
+	ReportActions              []string             `json:"ReportActions"`
+	Schedule                   metricReportSchedule `json:"Schedule"`
+	Metrics                    []definitionMetric   `json:"Metrics"`
+	MetricReport               IdRef                `json:"MetricReport"`
+}
+
+type metricReportSchedule struct {
+	RecurrenceInterval string `json:"RecurrenceInterval"`
+}
+
+type definitionMetric struct {
+	MetricId         string   `json:"MetricId"`
+	MetricProperties []string `json:"MetricProperties"`
+	Units            string   `json:"Units,omitempty"`
+}
+
+type metricReport struct {
+	OdataId                string        `json:"@odata.id"`
+	OdataType              string        `json:"@odata.type"`
+	Id                     string        `json:"Id"`
+	Name                   string        `json:"Name"`
+	ReportSequence         string        `json:"ReportSequence"`
+	Timestamp              time.Time     `json:"Timestamp"`
+	MetricReportDefinition IdRef         `json:"MetricReportDefinition"`
+	MetricValues           []metricValue `json:"MetricValues"`
+}
+
+type metricValue struct {
+	MetricId       string    `json:"MetricId"`
+	MetricValue    string    `json:"MetricValue"`
+	MetricProperty string    `json:"MetricProperty"`
+	Timestamp      time.Time `json:"Timestamp"`
+}
+
+type eventService struct {
+	OdataId            string   `json:"@odata.id"`
+	OdataType          string   `json:"@odata.type"`
+	Id                 string   `json:"Id"`
+	Name               string   `json:"Name"`
+	ServiceEnabled     bool     `json:"ServiceEnabled"`
+	EventFormatTypes   []string `json:"EventFormatTypes"`
+	ServerSentEventUri string   `json:"ServerSentEventUri"`
+}
+
+// requireTelemetry writes a 404 when the telemetry service is off.
+func (s *RedfishServer) requireTelemetry(w http.ResponseWriter) bool {
+	if s.telemetry == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(redfishError(errTelemetryDisabled))
+		return false
+	}
+
+	return true
+}
+
+// GetTelemetryService returns the TelemetryService resource.
+func (s *RedfishServer) GetTelemetryService(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer(tracerName).Start(r.Context(), "redfish.RedfishServer.GetTelemetryService")
+	defer span.End()
+
+	if !s.requireTelemetry(w) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(telemetryService{
+		OdataId:                 telemetryServicePath,
+		OdataType:               odataType(ctx, "TelemetryService"),
+		Id:                      "TelemetryService",
+		Name:                    "Telemetry Service",
+		ServiceEnabled:          true,
+		MetricReportDefinitions: IdRef{OdataId: util.Ptr(metricReportDefinitionsPath)},
+		MetricReports:           IdRef{OdataId: util.Ptr(metricReportsPath)},
+	})
+}
+
+// ListMetricReportDefinitions lists the generated metric reports.
+func (s *RedfishServer) ListMetricReportDefinitions(w http.ResponseWriter, r *http.Request) {
+	_, span := otel.Tracer(tracerName).Start(r.Context(), "redfish.RedfishServer.ListMetricReportDefinitions")
+	defer span.End()
+
+	if !s.requireTelemetry(w) {
+		return
+	}
+
+	members := make([]IdRef, 0, len(telemetry.Definitions))
+	for _, d := range telemetry.Definitions {
+		path := metricReportDefinitionsPath + "/" + d.Id
+		members = append(members, IdRef{OdataId: util.Ptr(path)})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(certificateCollection{
+		OdataId:      metricReportDefinitionsPath,
+		OdataType:    "#MetricReportDefinitionCollection.MetricReportDefinitionCollection",
+		Name:         "Metric Report Definitions",
+		Members:      members,
+		MembersCount: len(members),
+	})
+}
+
+// GetMetricReportDefinition returns one metric report definition.
+func (s *RedfishServer) GetMetricReportDefinition(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer(tracerName).Start(r.Context(), "redfish.RedfishServer.GetMetricReportDefinition")
+	defer span.End()
+
+	if !s.requireTelemetry(w) {
+		return
+	}
+
+	id := r.PathValue("definitionId")
+	d, ok := telemetry.DefinitionByID(id)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(redfishError(fmt.Errorf("unknown metric report definition %q", id)))
+		return
+	}
+
+	metrics := make([]definitionMetric, 0, len(d.Metrics))
+	for _, m := range d.Metrics {
+		metrics = append(metrics, definitionMetric{
+			MetricId:         m,
+			MetricProperties: []string{"/redfish/v1/Systems/{SystemId}"},
+			Units:            d.Units,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(metricReportDefinition{
+		OdataId:                    metricReportDefinitionsPath + "/" + d.Id,
+		OdataType:                  odataType(ctx, "MetricReportDefinition"),
+		Id:                         d.Id,
+		Name:                       d.Name,
+		Description:                d.Description,
+		MetricReportDefinitionType: "Periodic",
+		ReportActions:              []string{"RedfishEvent", "LogToMetricReportsCollection"},
+		Schedule: metricReportSchedule{
+			RecurrenceInterval: isoDuration(s.telemetry.Interval),
+		},
+		Metrics:      metrics,
+		MetricReport: IdRef{OdataId: util.Ptr(metricReportsPath + "/" + d.Id)},
+	})
+}
+
+// ListMetricReports lists the metric reports generated so far.
+func (s *RedfishServer) ListMetricReports(w http.ResponseWriter, r *http.Request) {
+	_, span := otel.Tracer(tracerName).Start(r.Context(), "redfish.RedfishServer.ListMetricReports")
+	defer span.End()
+
+	if !s.requireTelemetry(w) {
+		return
+	}
+
+	members := make([]IdRef, 0, len(telemetry.Definitions))
+	for _, d := range telemetry.Definitions {
+		if _, ok := s.telemetry.Latest(d.Id); ok {
+			members = append(members, IdRef{OdataId: util.Ptr(metricReportsPath + "/" + d.Id)})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(certificateCollection{
+		OdataId:      metricReportsPath,
+		OdataType:    "#MetricReportCollection.MetricReportCollection",
+		Name:         "Metric Reports",
+		Members:      members,
+		MembersCount: len(members),
+	})
+}
+
+// GetMetricReport returns the latest report of a definition.
+func (s *RedfishServer) GetMetricReport(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer(tracerName).Start(r.Context(), "redfish.RedfishServer.GetMetricReport")
+	defer span.End()
+
+	if !s.requireTelemetry(w) {
+		return
+	}
+
+	id := r.PathValue("reportId")
+	report, ok := s.telemetry.Latest(id)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(redfishError(fmt.Errorf("no metric report %q", id)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toMetricReport(ctx, report))
+}
+
+// GetEventService returns the EventService resource. Events are only
+// delivered over the server-sent event stream.
+func (s *RedfishServer) GetEventService(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer(tracerName).Start(r.Context(), "redfish.RedfishServer.GetEventService")
+	defer span.End()
+
+	if !s.requireTelemetry(w) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(eventService{
+		OdataId:            eventServicePath,
+		OdataType:          odataType(ctx, "EventService"),
+		Id:                 "EventService",
+		Name:               "Event Service",
+		ServiceEnabled:     true,
+		EventFormatTypes:   []string{"MetricReport"},
+		ServerSentEventUri: eventServiceSSEPath,
+	})
+}
+
+// StreamEvents streams metric reports as server-sent events until the client
+// disconnects. Each event id is the report sequence, so a client reconnecting
+// with Last-Event-ID first receives the reports it missed that are still
+// kept.
+func (s *RedfishServer) StreamEvents(w http.ResponseWriter, r *http.Request) {
+	if !s.requireTelemetry(w) {
+		return
+	}
+
+	var after uint64
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		after, _ = strconv.ParseUint(id, 10, 64)
+	}
+	replay, reports, cancel := s.telemetry.Subscribe(after)
+	defer cancel()
+
+	// The stream outlives the server write timeout where the response writer
+	// allows lifting it; otherwise clients reconnect when it expires.
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if _, err := fmt.Fprint(w, "retry: 5000\n\n"); err != nil {
+		return
+	}
+
+	send := func(report telemetry.Report) error {
+		b, err := json.Marshal(toMetricReport(r.Context(), report))
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "id: %d\ndata: %s\n\n", report.Sequence, b); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	for _, report := range replay {
+		if err := send(report); err != nil {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case report := <-reports:
+			if err := send(report); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func toMetricReport(ctx context.Context, r telemetry.Report) metricReport {
+	values := make([]metricValue, 0, len(r.Values))
+	for _, v := range r.Values {
+		values = append(values, metricValue{
+			MetricId:       v.Metric,
+			MetricValue:    strconv.FormatFloat(v.Value, 'f', -1, 64),
+			MetricProperty: "/redfish/v1/Systems/" + v.MAC,
+			Timestamp:      v.Timestamp,
+		})
+	}
+
+	definition := metricReportDefinitionsPath + "/" + r.Definition
+	return metricReport{
+		OdataId:                metricReportsPath + "/" + r.Definition,
+		OdataType:              odataType(ctx, "MetricReport"),
+		Id:                     r.Definition,
+		Name:                   r.Definition + " metric report",
+		ReportSequence:         strconv.FormatUint(r.Sequence, 10),
+		Timestamp:              r.Timestamp,
+		MetricReportDefinition: IdRef{OdataId: util.Ptr(definition)},
+		MetricValues:           values,
+	}
+}
+
+// isoDuration formats d as an ISO 8601 duration in seconds, e.g. "PT60S".
+func isoDuration(d time.Duration) string {
+	if d <= 0 {
+		d = time.Minute
+	}
+
+	return fmt.Sprintf("PT%dS", int(d.Seconds()))
+}
@@ -0,0 +1,138 @@
+package redfish
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/metal3-community/metal-boot/internal/efivars"
+	"github.com/metal3-community/uefi-firmware-manager/edk2"
+	"github.com/metal3-community/uefi-firmware-manager/efi"
+	"github.com/metal3-community/uefi-firmware-manager/varstore"
+	"go.opentelemetry.io/otel"
+)
+
+// variableList is the Oem collection of the decoded UEFI variables of a
+// system.
+type variableList struct {
+	OdataId   string             `json:"@odata.id"`
+	Variables []efivars.Variable `json:"Variables"`
+}
+
+// variableDiff is the Oem resource of the differences between the varstore
+// of a system and the varstore it is compared against.
+type variableDiff struct {
+	OdataId string           `json:"@odata.id"`
+	Against string           `json:"Against"`
+	Changes []efivars.Change `json:"Changes"`
+}
+
+func variablesPath(systemId string) string {
+	return fmt.Sprintf("/redfish/v1/Systems/%s/Oem/MetalBoot/Variables", systemId)
+}
+
+// systemVarList returns all UEFI variables of systemId, as systemVariables
+// finds them.
+func (s *RedfishServer) systemVarList(systemId string) (map[string]*efi.EfiVar, error) {
+	vars, err := s.systemVariables(systemId)
+	if err != nil {
+		return nil, err
+	}
+	switch v := vars.(type) {
+	case varList:
+		return v, nil
+	case interface {
+		ListVariables() (map[string]*efi.EfiVar, error)
+	}:
+		return v.ListVariables()
+	default:
+		return nil, errors.New("varstore cannot list its variables")
+	}
+}
+
+// templateVarList returns the variables of the firmware systems start from:
+// the firmware image of the TFTP root, or the embedded firmware without one.
+func (s *RedfishServer) templateVarList() (map[string]*efi.EfiVar, error) {
+	image := edk2.RpiEfi
+	if s.firmwarePath != "" {
+		b, err := os.ReadFile(s.firmwarePath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		if err == nil {
+			image = b
+		}
+	}
+
+	vs, err := varstore.New(image)
+	if err != nil {
+		return nil, err
+	}
+
+	return vs.GetVarList()
+}
+
+// ListVariables returns the UEFI variables of a system, with the data of the
+// variables metal-boot knows decoded.
+func (s *RedfishServer) ListVariables(w http.ResponseWriter, r *http.Request) {
+	_, span := otel.Tracer(tracerName).Start(r.Context(), "redfish.RedfishServer.ListVariables")
+	defer span.End()
+
+	systemId := r.PathValue("systemId")
+	vars, err := s.systemVarList(systemId)
+	if err != nil {
+		s.Log.Error(err, "failed to read UEFI variables", "system_id", systemId)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(variableList{
+		OdataId:   variablesPath(systemId),
+		Variables: efivars.DecodeAll(vars),
+	})
+}
+
+// DiffVariables returns the UEFI variables that differ between the varstore
+// of a system and the firmware it started from, or the varstore of the system
+// named by the "against" query parameter.
+func (s *RedfishServer) DiffVariables(w http.ResponseWriter, r *http.Request) {
+	_, span := otel.Tracer(tracerName).Start(r.Context(), "redfish.RedfishServer.DiffVariables")
+	defer span.End()
+
+	systemId := r.PathValue("systemId")
+	against := r.URL.Query().Get("against")
+	var base map[string]*efi.EfiVar
+	var err error
+	if against == "" {
+		base, err = s.templateVarList()
+	} else {
+		base, err = s.systemVarList(against)
+	}
+	if err != nil {
+		s.Log.Error(err, "failed to read UEFI variables", "system_id", against)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+	vars, err := s.systemVarList(systemId)
+	if err != nil {
+		s.Log.Error(err, "failed to read UEFI variables", "system_id", systemId)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
+	if against == "" {
+		against = "firmware"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(variableDiff{
+		OdataId: variablesPath(systemId) + "/Diff",
+		Against: against,
+		Changes: efivars.Diff(base, vars),
+	})
+}
@@ -0,0 +1,77 @@
+package redfish
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/metal3-community/metal-boot/internal/biosattr"
+	"github.com/metal3-community/metal-boot/internal/config"
+)
+
+func TestVariables(t *testing.T) {
+	registry, err := biosattr.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &RedfishServer{
+		Config: &config.Config{Tftp: config.TftpConfig{RootDirectory: t.TempDir()}},
+		Log:    logr.Discard(),
+		bios:   registry,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("PATCH /redfish/v1/Systems/{systemId}/Bios", s.UpdateBIOS)
+	mux.HandleFunc("GET "+variablesPath("{systemId}"), s.ListVariables)
+	mux.HandleFunc("GET "+variablesPath("{systemId}")+"/Diff", s.DiffVariables)
+	system := "d8:3a:dd:01:02:03"
+
+	rec := httptest.NewRecorder()
+	body := `{"Attributes": {"BootTimeout": 12, "ConsolePref": "Serial"}}`
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPatch, biosPath(system),
+		strings.NewReader(body)))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("PATCH %s = %d", biosPath(system), rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, variablesPath(system), nil))
+	var list variableList
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("GET %s = %d, %v", variablesPath(system), rec.Code, err)
+	}
+	values := make(map[string]any)
+	for _, v := range list.Variables {
+		values[v.Name] = v.Value
+	}
+	if values["Timeout"] != float64(12) || values["ConsolePref"] != "Serial" {
+		t.Errorf("GET %s = %v", variablesPath(system), values)
+	}
+
+	path := variablesPath(system) + "/Diff"
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	var diff variableDiff
+	if err := json.NewDecoder(rec.Body).Decode(&diff); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("GET %s = %d, %v", path, rec.Code, err)
+	}
+	changed := make(map[string]any)
+	for _, c := range diff.Changes {
+		if c.After != nil {
+			changed[c.Name] = c.After.Value
+		}
+	}
+	if changed["Timeout"] != float64(12) || changed["ConsolePref"] != "Serial" {
+		t.Errorf("GET %s = %+v, want Timeout and ConsolePref changed", path, diff.Changes)
+	}
+
+	// A system compared against itself has no differences.
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path+"?against="+system, nil))
+	diff = variableDiff{}
+	if err := json.NewDecoder(rec.Body).Decode(&diff); err != nil || len(diff.Changes) != 0 {
+		t.Errorf("GET %s?against=%s = %+v, %v", path, system, diff.Changes, err)
+	}
+}
@@ -0,0 +1,212 @@
+package redfish
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+
+	"github.com/metal3-community/metal-boot/internal/util"
+	"go.opentelemetry.io/otel"
+)
+
+var errVirtualMediaUnavailable = errors.New("virtual media requires the host state store")
+
+// virtualMediaPath returns the URI of the virtual CD drive of a system, which
+// is identified by the MAC address of the system.
+func virtualMediaPath(managerId, systemId string) string {
+	return fmt.Sprintf("/redfish/v1/Managers/%s/VirtualMedia/%s", managerId, systemId)
+}
+
+// ListManagerVirtualMedia implements ServerInterface.
+func (s *RedfishServer) ListManagerVirtualMedia(
+	w http.ResponseWriter,
+	r *http.Request,
+	managerId string,
+) {
+	ctx := r.Context()
+	tracer := otel.Tracer(tracerName)
+	_, span := tracer.Start(ctx, "redfish.RedfishServer.ListManagerVirtualMedia")
+	defer span.End()
+
+	keys, err := s.reader.GetKeys(ctx)
+	if err != nil {
+		s.Log.Error(err, "error getting keys", "manager", managerId)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
+	ids := make([]IdRef, 0, len(keys))
+	for _, mac := range keys {
+		ids = append(ids, IdRef{OdataId: util.Ptr(virtualMediaPath(managerId, mac.String()))})
+	}
+
+	response := Collection{
+		Members: &ids,
+		OdataContext: util.Ptr(
+			"/redfish/v1/$metadata#VirtualMediaCollection.VirtualMediaCollection",
+		),
+		OdataType:         "#VirtualMediaCollection.VirtualMediaCollection",
+		Name:              util.Ptr("Virtual Media Collection"),
+		OdataId:           fmt.Sprintf("/redfish/v1/Managers/%s/VirtualMedia", managerId),
+		MembersOdataCount: util.Ptr(len(ids)),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		s.Log.Error(err, "error encoding response", "manager", managerId)
+	}
+}
+
+// GetManagerVirtualMedia implements ServerInterface.
+func (s *RedfishServer) GetManagerVirtualMedia(
+	w http.ResponseWriter,
+	r *http.Request,
+	managerId string,
+	virtualMediaId string,
+) {
+	ctx := r.Context()
+	tracer := otel.Tracer(tracerName)
+	_, span := tracer.Start(ctx, "redfish.RedfishServer.GetManagerVirtualMedia")
+	defer span.End()
+
+	mac, err := s.systemMAC(virtualMediaId)
+	if err != nil {
+		s.Log.Error(err, "error parsing virtual media id", "virtualMedia", virtualMediaId)
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
+	uri := virtualMediaPath(managerId, mac.String())
+	media := VirtualMedia{
+		OdataId:        util.Ptr(uri),
+		OdataType:      util.Ptr(odataType(ctx, "VirtualMedia")),
+		Id:             util.Ptr(mac.String()),
+		Name:           util.Ptr("Virtual CD"),
+		Description:    util.Ptr(fmt.Sprintf("Virtual CD of system %s", mac)),
+		MediaTypes:     &[]string{"CD", "DVD"},
+		ConnectedVia:   util.Ptr(NotConnected),
+		Inserted:       util.Ptr(false),
+		WriteProtected: util.Ptr(true),
+		Actions: &VirtualMediaActions{
+			HashVirtualMediaEjectMedia: &VirtualMediaActionsVirtualMediaEjectMedia{
+				Target: util.Ptr(uri + "/Actions/VirtualMedia.EjectMedia"),
+			},
+			HashVirtualMediaInsertMedia: &VirtualMediaActionsVirtualMediaEjectMedia{
+				Target: util.Ptr(uri + "/Actions/VirtualMedia.InsertMedia"),
+			},
+		},
+	}
+	if inserted, ok := s.hosts.InsertedMedia(mac); ok {
+		media.Image = util.Ptr(inserted.Image)
+		media.ImageName = util.Ptr(path.Base(inserted.Image))
+		media.Inserted = util.Ptr(true)
+		media.ConnectedVia = util.Ptr(URI)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(media); err != nil {
+		s.Log.Error(err, "error encoding response", "virtualMedia", virtualMediaId)
+	}
+}
+
+// InsertVirtualMedia implements ServerInterface. The image is recorded for
+// the system and booted over SAN by its next iPXE script.
+func (s *RedfishServer) InsertVirtualMedia(
+	w http.ResponseWriter,
+	r *http.Request,
+	managerId string,
+	virtualMediaId string,
+) {
+	ctx := r.Context()
+	tracer := otel.Tracer(tracerName)
+	_, span := tracer.Start(ctx, "redfish.RedfishServer.InsertVirtualMedia")
+	defer span.End()
+
+	mac, ok := s.virtualMediaSystem(w, virtualMediaId)
+	if !ok {
+		return
+	}
+
+	req, err := decodeBody[InsertMediaRequestBody](r)
+	if err != nil {
+		s.Log.Error(err, "error decoding request", "virtualMedia", virtualMediaId)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+	if u, err := url.Parse(req.Image); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		err := fmt.Errorf("image must be an http or https URL: %q", req.Image)
+		s.Log.Error(err, "invalid insert media request", "virtualMedia", virtualMediaId)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
+	if err := s.hosts.InsertMedia(mac, req.Image); err != nil {
+		s.Log.Error(err, "failed to insert virtual media", "virtualMedia", virtualMediaId)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
+	s.Log.Info("virtual media inserted", "mac", mac.String(), "image", req.Image)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// EjectVirtualMedia implements ServerInterface.
+func (s *RedfishServer) EjectVirtualMedia(
+	w http.ResponseWriter,
+	r *http.Request,
+	managerId string,
+	virtualMediaId string,
+) {
+	ctx := r.Context()
+	tracer := otel.Tracer(tracerName)
+	_, span := tracer.Start(ctx, "redfish.RedfishServer.EjectVirtualMedia")
+	defer span.End()
+
+	mac, ok := s.virtualMediaSystem(w, virtualMediaId)
+	if !ok {
+		return
+	}
+
+	if err := s.hosts.EjectMedia(mac); err != nil {
+		s.Log.Error(err, "failed to eject virtual media", "virtualMedia", virtualMediaId)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return
+	}
+
+	s.Log.Info("virtual media ejected", "mac", mac.String())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// virtualMediaSystem resolves the system of a virtual media id for a change
+// of its media, writing an error response on failure.
+func (s *RedfishServer) virtualMediaSystem(
+	w http.ResponseWriter,
+	virtualMediaId string,
+) (net.HardwareAddr, bool) {
+	if s.hosts == nil {
+		s.Log.Error(errVirtualMediaUnavailable, "virtual media not available")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(redfishError(errVirtualMediaUnavailable))
+		return nil, false
+	}
+
+	addr, err := s.systemMAC(virtualMediaId)
+	if err != nil {
+		s.Log.Error(err, "error parsing virtual media id", "virtualMedia", virtualMediaId)
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(redfishError(err))
+		return nil, false
+	}
+
+	return addr, true
+}
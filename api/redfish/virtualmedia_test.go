@@ -0,0 +1,81 @@
+package redfish
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/metal3-community/metal-boot/internal/hoststate"
+)
+
+func TestVirtualMedia(t *testing.T) {
+	hosts, err := hoststate.NewStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &RedfishServer{Log: logr.Discard(), hosts: hosts}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /redfish/v1/Managers/{managerId}/VirtualMedia/{virtualMediaId}",
+		func(w http.ResponseWriter, r *http.Request) {
+			s.GetManagerVirtualMedia(w, r, r.PathValue("managerId"), r.PathValue("virtualMediaId"))
+		})
+	mux.HandleFunc("POST /redfish/v1/Managers/{managerId}/VirtualMedia/{virtualMediaId}/Actions/"+
+		"VirtualMedia.InsertMedia", func(w http.ResponseWriter, r *http.Request) {
+		s.InsertVirtualMedia(w, r, r.PathValue("managerId"), r.PathValue("virtualMediaId"))
+	})
+	mux.HandleFunc("POST /redfish/v1/Managers/{managerId}/VirtualMedia/{virtualMediaId}/Actions/"+
+		"VirtualMedia.EjectMedia", func(w http.ResponseWriter, r *http.Request) {
+		s.EjectVirtualMedia(w, r, r.PathValue("managerId"), r.PathValue("virtualMediaId"))
+	})
+	path := virtualMediaPath("1", "d8:3a:dd:01:02:03")
+
+	get := func() VirtualMedia {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		var media VirtualMedia
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s = %d, want %d", path, rec.Code, http.StatusOK)
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&media); err != nil {
+			t.Fatal(err)
+		}
+		return media
+	}
+	post := func(action, body string) int {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost,
+			path+"/Actions/VirtualMedia."+action, strings.NewReader(body)))
+		return rec.Code
+	}
+
+	if media := get(); *media.Inserted {
+		t.Error("virtual media is inserted before InsertMedia")
+	}
+
+	code := post("InsertMedia", `{"Image": "ftp://images/boot.iso"}`)
+	if code != http.StatusBadRequest {
+		t.Errorf("InsertMedia of an ftp image = %d, want %d", code, http.StatusBadRequest)
+	}
+	code = post("InsertMedia", `{"Image": "http://images/boot.iso"}`)
+	if code != http.StatusNoContent {
+		t.Fatalf("InsertMedia = %d, want %d", code, http.StatusNoContent)
+	}
+	media := get()
+	if !*media.Inserted || *media.Image != "http://images/boot.iso" ||
+		*media.ImageName != "boot.iso" {
+		t.Errorf("inserted virtual media = %v %v %v",
+			*media.Inserted, *media.Image, *media.ImageName)
+	}
+
+	if code := post("EjectMedia", `{}`); code != http.StatusNoContent {
+		t.Fatalf("EjectMedia = %d, want %d", code, http.StatusNoContent)
+	}
+	if media := get(); *media.Inserted || media.Image != nil {
+		t.Error("virtual media is still inserted after EjectMedia")
+	}
+}
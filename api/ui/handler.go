@@ -0,0 +1,56 @@
+// Package ui serves the operator dashboard, a single page listing the systems
+// metal-boot knows with their power state, lease and last boot. The page is
+// static; it reads and acts on the systems through the Redfish and admin
+// APIs, so it needs no API of its own and is subject to the same
+// authentication as any other client of them.
+package ui
+
+import (
+	"embed"
+	"io/fs"
+	"log/slog"
+	"net/http"
+)
+
+// Path is the route prefix the dashboard is served under.
+const Path = "/ui/"
+
+//go:embed static
+var static embed.FS
+
+// handler serves the embedded dashboard assets.
+type handler struct {
+	logger *slog.Logger
+	files  http.Handler
+}
+
+// New creates the dashboard handler, to be registered under Path.
+func New(logger *slog.Logger) http.Handler {
+	root, err := fs.Sub(static, "static")
+	if err != nil {
+		// The embedded directory is fixed at build time.
+		panic(err)
+	}
+
+	return &handler{
+		logger: logger,
+		files:  http.StripPrefix(Path, http.FileServerFS(root)),
+	}
+}
+
+// ServeHTTP serves the dashboard assets.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	h.logger.Debug("Serving dashboard", "path", r.URL.Path)
+	// The assets change with every release of the binary.
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy",
+		"default-src 'self'; frame-ancestors 'none'; base-uri 'none'")
+	h.files.ServeHTTP(w, r)
+}
@@ -0,0 +1,40 @@
+package ui
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle(Path, New(slog.New(slog.DiscardHandler)))
+
+	tests := []struct {
+		method   string
+		path     string
+		want     int
+		contains string
+	}{
+		{method: http.MethodGet, path: "/ui/", want: http.StatusOK, contains: "<title>metal-boot</title>"},
+		{method: http.MethodGet, path: "/ui/app.js", want: http.StatusOK, contains: "$expand=."},
+		{method: http.MethodGet, path: "/ui/style.css", want: http.StatusOK},
+		{method: http.MethodGet, path: "/ui", want: http.StatusTemporaryRedirect},
+		{method: http.MethodGet, path: "/ui/missing.js", want: http.StatusNotFound},
+		{method: http.MethodPost, path: "/ui/", want: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if !strings.Contains(rec.Body.String(), tt.contains) {
+				t.Errorf("body does not contain %q", tt.contains)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+"use strict";
+
+// The dashboard reads the systems from the Redfish Systems collection,
+// expanded so that one request returns the power state and lease of every
+// system, and their netboot setting from the admin API.
+
+const systemsURL = "/redfish/v1/Systems?$expand=.";
+const machinesURL = "/api/v1/machines?limit=1000";
+
+const el = (id) => document.getElementById(id);
+
+async function request(method, url, body) {
+  const init = { method, headers: { Accept: "application/json" } };
+  if (body !== undefined) {
+    init.headers["Content-Type"] = "application/json";
+    init.body = JSON.stringify(body);
+  }
+  const resp = await fetch(url, init);
+  if (!resp.ok) {
+    let message = `${method} ${url}: ${resp.status} ${resp.statusText}`;
+    try {
+      const data = await resp.json();
+      // The admin API returns {"error": "..."}, Redfish an error object.
+      message =
+        typeof data.error === "string" ? data.error : data.error?.message || message;
+    } catch {
+      // Not every error has a JSON body.
+    }
+    const err = new Error(message);
+    err.status = resp.status;
+    throw err;
+  }
+  return resp.status === 204 ? null : resp.json();
+}
+
+// machines returns the admin API inventory by MAC. Without access to the
+// admin API the table is still filled from Redfish, without netboot toggles.
+async function machines() {
+  try {
+    const page = await request("GET", machinesURL);
+    return new Map(page.items.map((m) => [m.mac, m]));
+  } catch (err) {
+    if (err.status === 401) {
+      showError("Log in at /auth/login to change netboot settings.");
+    } else {
+      showError(err.message);
+    }
+    return new Map();
+  }
+}
+
+function showError(message) {
+  el("error").textContent = message;
+  el("error").hidden = !message;
+}
+
+function lastBoot(system, machine) {
+  const times = [
+    system.Oem?.MetalBoot?.ObservedBootSource?.ObservedAt,
+    machine?.lastDiscover,
+  ]
+    .map((t) => (t ? new Date(t) : null))
+    .filter((t) => t && t.getFullYear() > 1);
+  if (times.length === 0) {
+    return "never";
+  }
+  return new Date(Math.max(...times)).toLocaleString();
+}
+
+function cell(row, text, className) {
+  const td = row.insertCell();
+  td.textContent = text ?? "";
+  if (className) {
+    td.className = className;
+  }
+  return td;
+}
+
+function button(label, action) {
+  const b = document.createElement("button");
+  b.type = "button";
+  b.textContent = label;
+  b.addEventListener("click", async () => {
+    b.disabled = true;
+    try {
+      await action();
+      showError("");
+      await refresh();
+    } catch (err) {
+      showError(err.message);
+    } finally {
+      b.disabled = false;
+    }
+  });
+  return b;
+}
+
+function render(systems, inventory) {
+  const body = el("systems");
+  body.replaceChildren();
+  for (const system of systems) {
+    // Systems the service could not describe are listed as links only.
+    const mac = system.Id ?? system["@odata.id"].split("/").pop();
+    const machine = inventory.get(mac);
+    const nic = system.EthernetInterfaces?.Members?.[0];
+    const row = body.insertRow();
+
+    cell(row, system.Name ?? mac);
+    cell(row, mac, "mac");
+    cell(row, nic?.IPv4Addresses?.[0]?.Address ?? nic?.IPv6Addresses?.[0]?.Address);
+    cell(row, system.PowerState ?? "unknown", `power-${system.PowerState}`);
+    cell(row, system.Oem?.MetalBoot?.State);
+    cell(row, lastBoot(system, machine));
+
+    const netboot = cell(row, machine ? (machine.netboot ? "on " : "off ") : "");
+    if (machine) {
+      netboot.append(
+        button(machine.netbootDisabled ? "Enable" : "Disable", () =>
+          request("PUT", `/api/v1/machines/${mac}/netboot`, {
+            enabled: Boolean(machine.netbootDisabled),
+          }),
+        ),
+      );
+    }
+
+    const reset = system.Actions?.["#ComputerSystem.Reset"]?.target;
+    const actions = cell(row, "");
+    if (reset) {
+      actions.append(
+        button("Power cycle", () => {
+          if (!confirm(`Power cycle ${system.Name}?`)) {
+            return Promise.resolve();
+          }
+          return request("POST", reset, { ResetType: "PowerCycle" });
+        }),
+      );
+    }
+  }
+  el("empty").hidden = systems.length > 0;
+}
+
+async function refresh() {
+  el("status").textContent = "Loading…";
+  try {
+    const [collection, inventory] = await Promise.all([
+      request("GET", systemsURL),
+      machines(),
+    ]);
+    render(collection.Members ?? [], inventory);
+    el("status").textContent = `Updated ${new Date().toLocaleTimeString()}`;
+  } catch (err) {
+    showError(err.message);
+    el("status").textContent = "";
+  }
+}
+
+el("refresh").addEventListener("click", refresh);
+refresh();
+setInterval(refresh, 30000);
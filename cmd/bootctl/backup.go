@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"time"
+)
+
+// backupCmd downloads an archive of all server state to file.
+//
+//	bootctl backup <file>
+func backupCmd(c *client, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	f, err := os.Create(args[0])
+	if err != nil {
+		return err
+	}
+	// Archives of large fleets take longer than a regular API call.
+	c.http.Timeout = 10 * time.Minute
+	if err := c.do(http.MethodGet, "/api/v1/backup", nil, f); err != nil {
+		f.Close()
+		os.Remove(args[0])
+		return err
+	}
+
+	return f.Close()
+}
+
+// restoreCmd stages the archive in file to replace all server state when the
+// server next restarts.
+//
+//	bootctl restore <file>
+func restoreCmd(c *client, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	c.http.Timeout = 10 * time.Minute
+	var resp json.RawMessage
+	if err := c.do(http.MethodPost, "/api/v1/restore", f, &resp); err != nil {
+		return err
+	}
+
+	return printJSON(resp)
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// bootSourceCmd prints the requested and observed boot source of a host.
+//
+//	bootctl boot-source <mac>
+func bootSourceCmd(c *client, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	mac, err := net.ParseMAC(args[0])
+	if err != nil {
+		return err
+	}
+
+	var resp json.RawMessage
+	if err := c.do(http.MethodGet, fmt.Sprintf("/api/v1/systems/%s/boot-source", mac), nil, &resp); err != nil {
+		return err
+	}
+
+	return printJSON(resp)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// gpuFirmwareGroup mirrors gpufw.Group on the wire.
+type gpuFirmwareGroup struct {
+	Name    string   `json:"name,omitempty"`
+	Version string   `json:"version"`
+	Hosts   []string `json:"hosts,omitempty"`
+}
+
+// gpuFirmwareCmd manages Raspberry Pi GPU firmware versions and the groups of
+// hosts pinned to them. Upgrading a group is a pin with the new version.
+//
+//	bootctl gpu-firmware versions
+//	bootctl gpu-firmware upload <version> <file>...
+//	bootctl gpu-firmware remove <version>
+//	bootctl gpu-firmware groups
+//	bootctl gpu-firmware pin <group> <version> [mac...]
+//	bootctl gpu-firmware unpin <group>
+//	bootctl gpu-firmware show <mac>
+func gpuFirmwareCmd(c *client, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	verb, args := args[0], args[1:]
+
+	var resp json.RawMessage
+	switch {
+	case verb == "versions" && len(args) == 0:
+		if err := c.do(http.MethodGet, "/api/v1/gpu-firmware/versions", nil, &resp); err != nil {
+			return err
+		}
+	case verb == "upload" && len(args) >= 2:
+		for _, file := range args[1:] {
+			if err := uploadGPUFirmwareFile(c, args[0], file, &resp); err != nil {
+				return err
+			}
+		}
+	case verb == "remove" && len(args) == 1:
+		return c.do(http.MethodDelete, "/api/v1/gpu-firmware/versions/"+args[0], nil, nil)
+	case verb == "groups" && len(args) == 0:
+		if err := c.do(http.MethodGet, "/api/v1/gpu-firmware/groups", nil, &resp); err != nil {
+			return err
+		}
+	case verb == "pin" && len(args) >= 2:
+		group := gpuFirmwareGroup{Version: args[1], Hosts: args[2:]}
+		path := "/api/v1/gpu-firmware/groups/" + args[0]
+		if err := c.do(http.MethodPut, path, group, &resp); err != nil {
+			return err
+		}
+	case verb == "unpin" && len(args) == 1:
+		return c.do(http.MethodDelete, "/api/v1/gpu-firmware/groups/"+args[0], nil, nil)
+	case verb == "show" && len(args) == 1:
+		mac, err := net.ParseMAC(args[0])
+		if err != nil {
+			return err
+		}
+		path := fmt.Sprintf("/api/v1/systems/%s/gpu-firmware", mac)
+		if err := c.do(http.MethodGet, path, nil, &resp); err != nil {
+			return err
+		}
+	default:
+		return errUsage
+	}
+
+	return printJSON(resp)
+}
+
+// uploadGPUFirmwareFile uploads a local boot file under its base name.
+func uploadGPUFirmwareFile(c *client, version, file string, out any) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	path := fmt.Sprintf("/api/v1/gpu-firmware/versions/%s/files/%s", version, filepath.Base(file))
+	return c.do(http.MethodPut, path, f, out)
+}
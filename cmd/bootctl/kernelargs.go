@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+)
+
+// kernelArgs mirrors hoststate.KernelArgs on the wire.
+type kernelArgs struct {
+	Add    []string `json:"add,omitempty"`
+	Remove []string `json:"remove,omitempty"`
+}
+
+// kernelArgsCmd edits the per-host kernel arg overrides.
+//
+//	bootctl kernel-args get <mac>
+//	bootctl kernel-args add <mac> <arg>...
+//	bootctl kernel-args remove <mac> <arg>...
+//	bootctl kernel-args clear <mac>
+func kernelArgsCmd(c *client, args []string) error {
+	if len(args) < 2 {
+		return errUsage
+	}
+	verb, rawMAC, values := args[0], args[1], args[2:]
+	mac, err := net.ParseMAC(rawMAC)
+	if err != nil {
+		return err
+	}
+	path := fmt.Sprintf("/api/v1/systems/%s/kernel-args", mac)
+
+	switch verb {
+	case "get":
+		var current kernelArgs
+		if err := c.do(http.MethodGet, path, nil, &current); err != nil {
+			return err
+		}
+		return printJSON(current)
+	case "clear":
+		return c.do(http.MethodDelete, path, nil, nil)
+	case "add", "remove":
+		if len(values) == 0 {
+			return errUsage
+		}
+		var current kernelArgs
+		if err := c.do(http.MethodGet, path, nil, &current); err != nil {
+			return err
+		}
+		for _, v := range values {
+			if verb == "add" {
+				current.Remove = slices.DeleteFunc(current.Remove, func(s string) bool { return s == v })
+				if !slices.Contains(current.Add, v) {
+					current.Add = append(current.Add, v)
+				}
+			} else {
+				current.Add = slices.DeleteFunc(current.Add, func(s string) bool { return s == v })
+				if !slices.Contains(current.Remove, v) {
+					current.Remove = append(current.Remove, v)
+				}
+			}
+		}
+		var updated kernelArgs
+		if err := c.do(http.MethodPut, path, current, &updated); err != nil {
+			return err
+		}
+		return printJSON(updated)
+	default:
+		return errUsage
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// leaseCmd converts between dynamic leases and static reservations.
+//
+//	bootctl lease list
+//	bootctl lease pin <mac>
+//	bootctl lease release <mac>
+func leaseCmd(c *client, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	if args[0] == "list" {
+		var resp json.RawMessage
+		if err := c.do(http.MethodGet, "/api/v1/dnsmasq/reservations", nil, &resp); err != nil {
+			return err
+		}
+		return printJSON(resp)
+	}
+
+	if len(args) != 2 {
+		return errUsage
+	}
+	mac, err := net.ParseMAC(args[1])
+	if err != nil {
+		return err
+	}
+	path := fmt.Sprintf("/api/v1/dnsmasq/reservations/%s", mac)
+
+	switch args[0] {
+	case "pin":
+		var resp json.RawMessage
+		if err := c.do(http.MethodPost, path, nil, &resp); err != nil {
+			return err
+		}
+		return printJSON(resp)
+	case "release":
+		return c.do(http.MethodDelete, path, nil, nil)
+	default:
+		return errUsage
+	}
+}
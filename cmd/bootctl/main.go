@@ -0,0 +1,214 @@
+// Command bootctl is a command line client for the metal-boot admin API.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// command is a bootctl sub command.
+type command struct {
+	usage string
+	run   func(c *client, args []string) error
+}
+
+var commands = map[string]command{
+	"kernel-args": {
+		usage: "kernel-args get|add|remove|clear <mac> [arg...]",
+		run:   kernelArgsCmd,
+	},
+	"metadata": {
+		usage: "metadata get|set|unset|clear <mac> [key=value|key...]",
+		run:   metadataCmd,
+	},
+	"boot-source": {
+		usage: "boot-source <mac>",
+		run:   bootSourceCmd,
+	},
+	"rendered": {
+		usage: "rendered <mac> [script]",
+		run:   renderedCmd,
+	},
+	"lease": {
+		usage: "lease list|pin|release [mac]",
+		run:   leaseCmd,
+	},
+	"gpu-firmware": {
+		usage: "gpu-firmware versions|upload|remove|groups|pin|unpin|show [args...]",
+		run:   gpuFirmwareCmd,
+	},
+	"backup": {
+		usage: "backup <file>",
+		run:   backupCmd,
+	},
+	"restore": {
+		usage: "restore <file>",
+		run:   restoreCmd,
+	},
+	"read-only": {
+		usage: "read-only [on|off]",
+		run:   readOnlyCmd,
+	},
+	"whoami": {
+		usage: "whoami",
+		run:   whoamiCmd,
+	},
+}
+
+func main() {
+	server := flag.String(
+		"server",
+		envOr("METAL_BOOT_URL", "http://127.0.0.1:8080"),
+		"metal-boot API base URL (env METAL_BOOT_URL)",
+	)
+	token := flag.String(
+		"token",
+		os.Getenv("METAL_BOOT_TOKEN"),
+		"OIDC ID token sent as a bearer token (env METAL_BOOT_TOKEN)",
+	)
+	socket := flag.String(
+		"socket",
+		os.Getenv("METAL_BOOT_SOCKET"),
+		"metal-boot API unix socket, used instead of the server URL (env METAL_BOOT_SOCKET)",
+	)
+	flag.Usage = usage
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "bootctl: unknown command %q\n", args[0])
+		usage()
+		os.Exit(2)
+	}
+
+	c := &client{
+		base:  strings.TrimSuffix(*server, "/"),
+		token: *token,
+		http:  &http.Client{Timeout: 30 * time.Second},
+	}
+	if *socket != "" {
+		c.base = "http://metal-boot"
+		c.http.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", *socket)
+			},
+		}
+	}
+	if err := cmd.run(c, args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "bootctl: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: bootctl [-server URL | -socket PATH] [-token TOKEN] "+
+		"<command> [args]\n\nCommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s\n", commands[name].usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+func envOr(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
+}
+
+// client performs admin API requests.
+type client struct {
+	base  string
+	token string
+	http  *http.Client
+}
+
+// do sends a request with an optional body and decodes a JSON response into
+// out when out is non-nil. An io.Reader body is sent as is, anything else is
+// encoded as JSON. An io.Writer out receives the raw response body.
+func (c *client) do(method, path string, body, out any) error {
+	var rd io.Reader
+	contentType := "application/json"
+	switch b := body.(type) {
+	case nil:
+	case io.Reader:
+		rd = b
+		contentType = "application/octet-stream"
+	default:
+		enc, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		rd = bytes.NewReader(enc)
+	}
+
+	req, err := http.NewRequest(method, c.base+path, rd)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, apiErr.Error)
+		}
+		return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if w, ok := out.(io.Writer); ok {
+		_, err := io.Copy(w, resp.Body)
+		return err
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// printJSON writes v to stdout as indented JSON.
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+var errUsage = errors.New("invalid arguments, see bootctl -h")
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// metadataCmd edits the free-form metadata of a host.
+//
+//	bootctl metadata get <mac>
+//	bootctl metadata set <mac> <key>=<value>...
+//	bootctl metadata unset <mac> <key>...
+//	bootctl metadata clear <mac>
+func metadataCmd(c *client, args []string) error {
+	if len(args) < 2 {
+		return errUsage
+	}
+	verb, rawMAC, values := args[0], args[1], args[2:]
+	mac, err := net.ParseMAC(rawMAC)
+	if err != nil {
+		return err
+	}
+	path := fmt.Sprintf("/api/v1/systems/%s/metadata", mac)
+
+	switch verb {
+	case "get":
+		var current map[string]string
+		if err := c.do(http.MethodGet, path, nil, &current); err != nil {
+			return err
+		}
+		return printJSON(current)
+	case "clear":
+		return c.do(http.MethodDelete, path, nil, nil)
+	case "set":
+		if len(values) == 0 {
+			return errUsage
+		}
+		var current map[string]string
+		for _, v := range values {
+			key, value, ok := strings.Cut(v, "=")
+			if !ok {
+				return fmt.Errorf("%q is not of the form key=value", v)
+			}
+			keyPath := path + "/" + url.PathEscape(key)
+			if err := c.do(http.MethodPut, keyPath, value, &current); err != nil {
+				return err
+			}
+		}
+		return printJSON(current)
+	case "unset":
+		if len(values) == 0 {
+			return errUsage
+		}
+		for _, key := range values {
+			if err := c.do(http.MethodDelete, path+"/"+url.PathEscape(key), nil, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return errUsage
+	}
+}
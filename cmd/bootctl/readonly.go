@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+)
+
+// readOnlyCmd shows or toggles read-only mode.
+//
+//	bootctl read-only [on|off]
+func readOnlyCmd(c *client, args []string) error {
+	var state struct {
+		Enabled bool `json:"enabled"`
+	}
+
+	switch {
+	case len(args) == 0:
+		if err := c.do(http.MethodGet, "/api/v1/read-only", nil, &state); err != nil {
+			return err
+		}
+	case len(args) == 1 && (args[0] == "on" || args[0] == "off"):
+		state.Enabled = args[0] == "on"
+		if err := c.do(http.MethodPut, "/api/v1/read-only", state, &state); err != nil {
+			return err
+		}
+	default:
+		return errUsage
+	}
+
+	return printJSON(state)
+}
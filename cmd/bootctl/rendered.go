@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// renderedCmd prints what would currently be served to a host. With "script"
+// only the iPXE script is printed.
+//
+//	bootctl rendered <mac> [script]
+func renderedCmd(c *client, args []string) error {
+	if len(args) < 1 || len(args) > 2 || (len(args) == 2 && args[1] != "script") {
+		return errUsage
+	}
+	mac, err := net.ParseMAC(args[0])
+	if err != nil {
+		return err
+	}
+
+	var resp json.RawMessage
+	path := fmt.Sprintf("/api/v1/systems/%s/rendered", mac)
+	if err := c.do(http.MethodGet, path, nil, &resp); err != nil {
+		return err
+	}
+
+	if len(args) == 2 {
+		var rendered struct {
+			IPXE struct {
+				Content string `json:"content"`
+			} `json:"ipxe"`
+		}
+		if err := json.Unmarshal(resp, &rendered); err != nil {
+			return err
+		}
+		fmt.Print(rendered.IPXE.Content)
+		return nil
+	}
+
+	return printJSON(resp)
+}
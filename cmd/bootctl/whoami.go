@@ -0,0 +1,22 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// whoamiCmd prints the operator the admin API authenticated and its role.
+//
+//	bootctl whoami
+func whoamiCmd(c *client, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	var resp json.RawMessage
+	if err := c.do(http.MethodGet, "/api/v1/whoami", nil, &resp); err != nil {
+		return err
+	}
+
+	return printJSON(resp)
+}
@@ -0,0 +1,70 @@
+// Command dnsmasq-migrate converts the configuration of a hand-managed
+// dnsmasq into the layout of the metal-boot dnsmasq backend.
+//
+// It prints what would change in the target layout and the input lines it
+// cannot migrate; nothing is written unless -apply is given.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/go-logr/stdr"
+	"github.com/metal3-community/metal-boot/internal/backend/dnsmasq/migrate"
+)
+
+// listFlag is a flag that may be repeated.
+type listFlag []string
+
+func (l *listFlag) String() string { return strings.Join(*l, ",") }
+
+func (l *listFlag) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+func main() {
+	var (
+		in    migrate.Input
+		hosts listFlag
+	)
+	flag.StringVar(&in.ConfFile, "conf", "/etc/dnsmasq.conf",
+		"dnsmasq configuration file, empty for none")
+	flag.Var(&hosts, "hostsfile", "additional dhcp-hostsfile file or directory (repeatable)")
+	flag.StringVar(&in.LeaseFile, "leases", "", "lease file, overriding dhcp-leasefile")
+	root := flag.String("root", "",
+		"root directory of the metal-boot dnsmasq layout (dnsmasq.root_directory)")
+	apply := flag.Bool("apply", false, "write the layout instead of only reporting the changes")
+	flag.Parse()
+	in.HostsFiles = hosts
+
+	if *root == "" {
+		fmt.Fprintln(os.Stderr, "dnsmasq-migrate: -root is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	layout, err := migrate.Parse(in)
+	if err != nil {
+		log.Fatalf("dnsmasq-migrate: %v", err)
+	}
+	report, err := migrate.Diff(*root, layout)
+	if err != nil {
+		log.Fatalf("dnsmasq-migrate: %v", err)
+	}
+	if err := report.Write(os.Stdout); err != nil {
+		log.Fatalf("dnsmasq-migrate: %v", err)
+	}
+
+	if !*apply {
+		fmt.Println("dry run; rerun with -apply to write the layout")
+		return
+	}
+	if err := migrate.Apply(stdr.New(log.Default()), *root, layout); err != nil {
+		log.Fatalf("dnsmasq-migrate: %v", err)
+	}
+	fmt.Printf("wrote the layout to %s\n", *root)
+}
@@ -2,9 +2,12 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"maps"
 	"net"
 	"net/http"
 	"net/netip"
@@ -12,32 +15,91 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv4/server4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
 	"github.com/metal3-community/metal-boot/api"
+	"github.com/metal3-community/metal-boot/api/admin"
+	artifactsapi "github.com/metal3-community/metal-boot/api/artifacts"
 	"github.com/metal3-community/metal-boot/api/health"
 	"github.com/metal3-community/metal-boot/api/images/talos"
 	"github.com/metal3-community/metal-boot/api/ipxe"
+	ipxebinary "github.com/metal3-community/metal-boot/api/ipxe/binary"
 	"github.com/metal3-community/metal-boot/api/ipxe/script"
 	"github.com/metal3-community/metal-boot/api/ironic"
 	"github.com/metal3-community/metal-boot/api/iso"
 	"github.com/metal3-community/metal-boot/api/metrics"
+	"github.com/metal3-community/metal-boot/api/phonehome"
 	"github.com/metal3-community/metal-boot/api/redfish"
+	"github.com/metal3-community/metal-boot/api/ui"
+	"github.com/metal3-community/metal-boot/internal/adminauth"
+	"github.com/metal3-community/metal-boot/internal/admission"
+	"github.com/metal3-community/metal-boot/internal/artifact"
+	"github.com/metal3-community/metal-boot/internal/audit"
 	"github.com/metal3-community/metal-boot/internal/backend"
+	"github.com/metal3-community/metal-boot/internal/backend/alias"
 	"github.com/metal3-community/metal-boot/internal/backend/dnsmasq"
+	dnsmasqconfig "github.com/metal3-community/metal-boot/internal/backend/dnsmasq/config"
 	"github.com/metal3-community/metal-boot/internal/backend/dnsmasq/lease"
+	"github.com/metal3-community/metal-boot/internal/backend/power"
+	"github.com/metal3-community/metal-boot/internal/backend/power/gpio"
+	"github.com/metal3-community/metal-boot/internal/backend/power/ipmi"
+	"github.com/metal3-community/metal-boot/internal/backend/power/qemu"
+	redfishpower "github.com/metal3-community/metal-boot/internal/backend/power/redfish"
+	"github.com/metal3-community/metal-boot/internal/backend/power/tasmota"
 	"github.com/metal3-community/metal-boot/internal/backend/unifi"
+	"github.com/metal3-community/metal-boot/internal/backup"
+	"github.com/metal3-community/metal-boot/internal/bandwidth"
+	"github.com/metal3-community/metal-boot/internal/biosattr"
+	"github.com/metal3-community/metal-boot/internal/bootauth"
+	"github.com/metal3-community/metal-boot/internal/bootflow"
+	"github.com/metal3-community/metal-boot/internal/canary"
 	"github.com/metal3-community/metal-boot/internal/config"
+	"github.com/metal3-community/metal-boot/internal/cors"
+	"github.com/metal3-community/metal-boot/internal/decommission"
+	"github.com/metal3-community/metal-boot/internal/dhcp"
+	"github.com/metal3-community/metal-boot/internal/dhcp/guard"
 	"github.com/metal3-community/metal-boot/internal/dhcp/handler/proxy"
 	"github.com/metal3-community/metal-boot/internal/dhcp/handler/reservation"
 	dhcpServer "github.com/metal3-community/metal-boot/internal/dhcp/server"
+	"github.com/metal3-community/metal-boot/internal/download"
+	"github.com/metal3-community/metal-boot/internal/events"
+	"github.com/metal3-community/metal-boot/internal/faultinject"
+	"github.com/metal3-community/metal-boot/internal/gpufw"
+	"github.com/metal3-community/metal-boot/internal/hooks"
+	"github.com/metal3-community/metal-boot/internal/hoststate"
+	"github.com/metal3-community/metal-boot/internal/imagecache"
+	"github.com/metal3-community/metal-boot/internal/imagecatalog"
+	"github.com/metal3-community/metal-boot/internal/integrity"
+	"github.com/metal3-community/metal-boot/internal/ipxe/binary"
+	"github.com/metal3-community/metal-boot/internal/ipxe/scripttemplate"
 	ironicManager "github.com/metal3-community/metal-boot/internal/ironic"
+	"github.com/metal3-community/metal-boot/internal/leasedb"
+	"github.com/metal3-community/metal-boot/internal/logging"
+	"github.com/metal3-community/metal-boot/internal/metric"
+	"github.com/metal3-community/metal-boot/internal/otel"
+	"github.com/metal3-community/metal-boot/internal/outbound"
+	"github.com/metal3-community/metal-boot/internal/preflight"
+	"github.com/metal3-community/metal-boot/internal/provisioning"
+	"github.com/metal3-community/metal-boot/internal/readonly"
+	"github.com/metal3-community/metal-boot/internal/selfupdate"
+	"github.com/metal3-community/metal-boot/internal/session"
+	"github.com/metal3-community/metal-boot/internal/snapshot"
+	"github.com/metal3-community/metal-boot/internal/streamlimit"
+	"github.com/metal3-community/metal-boot/internal/task"
+	"github.com/metal3-community/metal-boot/internal/telemetry"
 	"github.com/metal3-community/metal-boot/internal/tftp"
+	"github.com/metal3-community/metal-boot/internal/tlscert"
 	"github.com/metal3-community/metal-boot/internal/util"
+	"github.com/metal3-community/uefi-firmware-manager/edk2"
+	"github.com/prometheus/client_golang/prometheus"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -56,6 +118,16 @@ func main() {
 		slog.Error("Failed to load configuration", "error", err)
 		os.Exit(1)
 	}
+	slog.SetDefault(cfg.Slog())
+
+	if err := outbound.Install(outbound.Config{
+		ProxyURL: cfg.OutboundProxy.URL,
+		NoProxy:  cfg.OutboundProxy.NoProxy,
+		CABundle: cfg.OutboundProxy.CABundle,
+	}); err != nil {
+		slog.Error("Failed to configure the outbound proxy", "error", err)
+		os.Exit(1)
+	}
 
 	httpUrl := getHttpUrl(cfg)
 
@@ -71,6 +143,23 @@ func main() {
 	logger := cfg.Log
 	logger.Info("Metal Boot starting", "version", GitRev, "start_time", startTime)
 
+	shutdownTracing, err := setupTracing(logger, cfg)
+	if err != nil {
+		logger.Error(err, "failed to set up tracing")
+		os.Exit(1)
+	}
+	defer shutdownTracing()
+
+	// Apply a restore staged before the last shutdown, before anything loads
+	// the state it replaces.
+	if m, err := createArchiver(cfg).ApplyPending(); err != nil {
+		logger.Error(err, "failed to apply the pending restore")
+		os.Exit(1)
+	} else if m != nil {
+		logger.Info("applied pending restore", "version", m.Version, "created", m.Created,
+			"files", len(m.Files))
+	}
+
 	// Create readerBackend
 	readerBackend, err := createReaderBackend(context.Background(), logger, cfg)
 	if err != nil {
@@ -79,12 +168,55 @@ func main() {
 	}
 
 	// Create pwrBackend
-	pwrBackend, err := createPowerBackend(context.Background(), logger, cfg)
+	pwrBackend, err := createPowerBackend(context.Background(), logger, cfg, readerBackend)
 	if err != nil {
 		logger.Error(err, "failed to create backend")
 		os.Exit(1)
 	}
 
+	if cfg.Preflight.Enabled {
+		if err := runPreflight(logger, cfg, readerBackend); err != nil {
+			logger.Error(err, "preflight checks failed")
+			os.Exit(1)
+		}
+	}
+
+	if cfg.FaultInjection.Enabled {
+		logger.Info("WARNING: fault injection enabled, backend calls, TFTP reads and " +
+			"power actions may be delayed or fail")
+		readerBackend = faultinject.Reader(readerBackend, faultFor(cfg.FaultInjection.Backend))
+		pwrBackend = faultinject.Power(pwrBackend, faultFor(cfg.FaultInjection.Power))
+	}
+
+	if cfg.HostMetrics.Enabled {
+		prometheus.MustRegister(createHostCollector(cfg, readerBackend, pwrBackend))
+	}
+
+	// Create host state store
+	hostStore, err := hoststate.NewStore(filepath.Join(cfg.StatePath, "hosts.json"))
+	if err != nil {
+		logger.Error(err, "failed to create host state store")
+		os.Exit(1)
+	}
+	identity, err := hoststate.ParseIdentity(cfg.HostIdentity.LocallyAdministered)
+	if err != nil {
+		logger.Error(err, "invalid host_identity.locally_administered")
+		os.Exit(1)
+	}
+	matches, err := hoststate.ParseMatches(cfg.HostIdentity.Match)
+	if err != nil {
+		logger.Error(err, "invalid host_identity.match")
+		os.Exit(1)
+	}
+	if identity != hoststate.IdentityMAC || len(matches) > 0 {
+		hostStore.SetIdentity(identity)
+		hostStore.SetMatches(matches)
+		readerBackend = alias.Reader(readerBackend, hostStore)
+	}
+
+	bootTracker := createBootTracker(logger, cfg, hostStore)
+	bootVerifier := createBootVerifier(cfg, readerBackend)
+
 	// Set up graceful shutdown context
 	ctx, cancel := signal.NotifyContext(
 		context.Background(),
@@ -95,14 +227,60 @@ func main() {
 	defer cancel()
 
 	// Start all services
-	if err := startServices(ctx, cfg, logger, readerBackend, pwrBackend); err != nil {
+	if err := startServices(
+		ctx,
+		cfg,
+		logger,
+		readerBackend,
+		pwrBackend,
+		hostStore,
+		bootTracker,
+		bootVerifier,
+	); err != nil {
 		logger.Error(err, "failed to start services")
 		os.Exit(1)
 	}
 
+	if err := hostStore.Flush(); err != nil {
+		logger.Error(err, "failed to save host state")
+	}
+
+	// Virtual machines of the qemu driver do not outlive the server.
+	if closer, ok := pwrBackend.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			logger.Error(err, "failed to stop power backend")
+		}
+	}
+
 	logger.Info("Metal Boot shutdown complete")
 }
 
+// setupTracing installs the OpenTelemetry tracer provider exporting to the
+// otel endpoint. The returned function flushes the spans not exported yet.
+func setupTracing(logger logr.Logger, cfg *config.Config) (func(), error) {
+	if !cfg.Otel.Enabled {
+		return func() {}, nil
+	}
+	if cfg.Otel.Endpoint == "" {
+		return nil, errors.New("otel.endpoint is required when tracing is enabled")
+	}
+
+	_, shutdown, err := otel.Init(context.Background(), otel.Config{
+		Servicename: "metal-boot",
+		Endpoint:    cfg.Otel.Endpoint,
+		Insecure:    cfg.Otel.Insecure,
+		SampleRatio: cfg.Otel.SampleRatio,
+		Logger:      logger.WithName("otel"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("tracing enabled", "endpoint", cfg.Otel.Endpoint,
+		"sample_ratio", cfg.Otel.SampleRatio)
+
+	return shutdown, nil
+}
+
 func getHttpUrl(cfg *config.Config) *url.URL {
 	if cfg.Ironic.PublicEndpoint != "" {
 		if publicUrl, err := url.Parse(cfg.Ironic.PublicEndpoint); err == nil {
@@ -123,17 +301,129 @@ func getHttpUrl(cfg *config.Config) *url.URL {
 	return nil
 }
 
-// createPowerBackend initializes and starts the backend service.
+// createPowerBackend returns the registry of power drivers, which hands each
+// request to the driver named in the backend data of the machine.
 func createPowerBackend(
 	ctx context.Context,
 	log logr.Logger,
 	cfg *config.Config,
+	reader backend.BackendReader,
 ) (backend.BackendPower, error) {
-	backend, err := unifi.NewRemote(ctx, log, cfg)
+	def := cfg.Power.DefaultDriver
+	if def == "" {
+		def = "unifi"
+		if cfg.Qemu.Enabled {
+			def = "qemu"
+		}
+	}
+	registry := power.NewRegistry(reader, def)
+
+	if cfg.Qemu.Enabled {
+		driver, err := createQemuBackend(log, cfg)
+		if err != nil {
+			return nil, err
+		}
+		registry.Register("qemu", driver)
+	}
+	if def == "unifi" || cfg.Unifi.Endpoint != "" {
+		driver, err := unifi.NewRemote(ctx, log, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create backend: %w", err)
+		}
+		registry.Register("unifi", driver)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: cfg.Power.Redfish.Insecure}
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   time.Duration(cfg.Power.TimeoutSec) * time.Second,
+	}
+	registry.Register("tasmota", tasmota.New(tasmota.Config{
+		Username:   cfg.Power.Tasmota.Username,
+		Password:   cfg.Power.Tasmota.Password,
+		CycleDelay: time.Duration(cfg.Power.Tasmota.CycleDelayMs) * time.Millisecond,
+		Client:     client,
+	}, registry.Settings))
+	registry.Register("redfish", redfishpower.New(redfishpower.Config{
+		Username: cfg.Power.Redfish.Username,
+		Password: cfg.Power.Redfish.Password,
+		Client:   client,
+	}, registry.Settings))
+	registry.Register("ipmi", ipmi.New(ipmi.Config{
+		Binary:    cfg.Power.IPMI.Binary,
+		Username:  cfg.Power.IPMI.Username,
+		Password:  cfg.Power.IPMI.Password,
+		Interface: cfg.Power.IPMI.Interface,
+		Timeout:   time.Duration(cfg.Power.TimeoutSec) * time.Second,
+	}, registry.Settings))
+	gpioDriver, err := gpio.New(gpio.Config{
+		Root:       cfg.Power.GPIO.SysfsRoot,
+		Lines:      cfg.Power.GPIO.Lines,
+		ActiveLow:  cfg.Power.GPIO.ActiveLow,
+		CycleDelay: time.Duration(cfg.Power.GPIO.CycleDelayMs) * time.Millisecond,
+	}, registry.Settings)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create backend: %w", err)
+		return nil, fmt.Errorf("invalid gpio power configuration: %w", err)
 	}
-	return backend, nil
+	registry.Register("gpio", gpioDriver)
+
+	log.Info("power drivers registered", "drivers", registry.Drivers(), "default", def)
+
+	return registry, nil
+}
+
+// createQemuBackend returns the power backend that runs systems as QEMU
+// VMs booting from the varstore Redfish manages for their MAC.
+func createQemuBackend(log logr.Logger, cfg *config.Config) (backend.BackendPower, error) {
+	log.Info("WARNING: qemu enabled, systems are powered as virtual machines",
+		"bridge", cfg.Qemu.Bridge)
+
+	driver, err := qemu.New(log, qemu.Config{
+		Binary:           cfg.Qemu.Binary,
+		Machine:          cfg.Qemu.Machine,
+		CPU:              cfg.Qemu.CPU,
+		KVM:              cfg.Qemu.KVM,
+		MemoryMB:         cfg.Qemu.MemoryMB,
+		Firmware:         cfg.Qemu.Firmware,
+		VarstoreTemplate: cfg.Qemu.VarstoreTemplate,
+		Varstore: func(mac net.HardwareAddr) string {
+			return filepath.Join(
+				cfg.Tftp.RootDirectory,
+				strings.ReplaceAll(mac.String(), ":", "-"),
+				edk2.FirmwareFileName,
+			)
+		},
+		Bridge:    cfg.Qemu.Bridge,
+		StateDir:  cfg.Qemu.StateDir,
+		ExtraArgs: cfg.Qemu.ExtraArgs,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create qemu backend: %w", err)
+	}
+
+	return driver, nil
+}
+
+// dnsmasqPools converts the configured IP pools for the dnsmasq backend.
+func dnsmasqPools(pools []config.DnsmasqPoolConfig) []dnsmasq.Pool {
+	out := make([]dnsmasq.Pool, 0, len(pools))
+	for _, p := range pools {
+		out = append(out, dnsmasq.Pool{
+			Name:       p.Name,
+			Subnet:     p.Subnet,
+			Start:      p.Start,
+			End:        p.End,
+			Gateway:    p.Gateway,
+			DNS:        p.DNS,
+			Domain:     p.Domain,
+			LeaseTime:  p.LeaseTime,
+			CircuitIDs: p.CircuitIDs,
+			RemoteIDs:  p.RemoteIDs,
+		})
+	}
+
+	return out
 }
 
 func createReaderBackend(
@@ -154,6 +444,10 @@ func createReaderBackend(
 		DefaultSubnet:     cfg.Dnsmasq.DefaultSubnet,
 		DefaultDNS:        cfg.Dnsmasq.DefaultDNS,
 		DefaultDomain:     cfg.Dnsmasq.DefaultDomain,
+		Pools:             dnsmasqPools(cfg.Dnsmasq.Pools),
+		Sharding:          dnsmasqconfig.Sharding(cfg.Dnsmasq.Sharding),
+		PIDFile:           cfg.Dnsmasq.PIDFile,
+		Watch:             cfg.FileWatch.WatchOptions(),
 	})
 	if err != nil {
 		log.Error(err, "failed to create dnsmasq backend")
@@ -166,6 +460,623 @@ func createReaderBackend(
 	return backend, nil
 }
 
+// createCertStore returns the TLS certificate store, or nil if TLS is disabled.
+func createCertStore(cfg *config.Config) (*tlscert.Store, error) {
+	if !cfg.TLS.Enabled {
+		return nil, nil
+	}
+	return tlscert.NewStore(cfg.TLS.CertFile, cfg.TLS.KeyFile, cfg.Address)
+}
+
+// createArchiver returns the archiver of the state kept with cfg. Restores
+// are staged and applied at the next start, as the running stores would
+// overwrite restored files.
+func createArchiver(cfg *config.Config) *backup.Archiver {
+	return &backup.Archiver{
+		Version:    GitRev,
+		Sources:    backup.Sources(cfg),
+		PendingDir: backup.PendingDir(cfg),
+	}
+}
+
+// createUpdater returns the self updater describing the running binary. It
+// only replaces the binary when self_update is enabled, with images signed by
+// the publisher key.
+func createUpdater(cfg *config.Config) (*selfupdate.Updater, error) {
+	u := &selfupdate.Updater{
+		Version:    GitRev,
+		StagingDir: cfg.SelfUpdate.StagingDir,
+	}
+	if exe, err := os.Executable(); err == nil {
+		u.Executable = exe
+	}
+	if !cfg.SelfUpdate.Enabled {
+		return u, nil
+	}
+	if cfg.SelfUpdate.PublicKeyFile == "" {
+		return nil, errors.New("self_update.public_key_file is required")
+	}
+	key, err := selfupdate.LoadPublicKey(cfg.SelfUpdate.PublicKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	u.PublicKey = key
+	return u, nil
+}
+
+// createSessionStore returns the store of Redfish sessions, persisted below
+// the state path unless redfish_sessions.persist is disabled.
+func createSessionStore(cfg *config.Config) (*session.Store, error) {
+	timeout := time.Duration(cfg.RedfishSessions.TimeoutSec) * time.Second
+	if !cfg.RedfishSessions.Persist {
+		return session.New("", "", timeout)
+	}
+	return session.New(
+		filepath.Join(cfg.StatePath, "redfish-sessions.enc"),
+		cfg.RedfishSessions.KeyFile,
+		timeout,
+	)
+}
+
+// createLeaseDB returns the lease database of the reservation handler, or
+// nil if it is disabled or the proxy handler, which hands out no leases, is
+// used.
+func createLeaseDB(cfg *config.Config) (*leasedb.DB, error) {
+	if !cfg.Leases.Enabled || !cfg.Dhcp.Enabled || cfg.Dhcp.ProxyEnabled {
+		return nil, nil
+	}
+	path := cfg.Leases.Path
+	if path == "" {
+		path = filepath.Join(cfg.StatePath, "leases.json")
+	}
+	return leasedb.Open(path, leasedb.Options{
+		OfferTimeout: time.Duration(cfg.Leases.OfferTimeoutSec) * time.Second,
+		Retain:       time.Duration(cfg.Leases.RetainHours) * time.Hour,
+	})
+}
+
+// createDecommissioner returns the decommissioner of hosts, or nil if
+// decommissioning is disabled. Hosts are removed from Ironic when it is
+// reachable on its socket.
+func createDecommissioner(
+	cfg *config.Config,
+	logger logr.Logger,
+	readerBackend backend.BackendReader,
+	pwrBackend backend.BackendPower,
+	hostStore *hoststate.Store,
+	leases *leasedb.DB,
+	tasks *task.Store,
+) *decommission.Decommissioner {
+	if !cfg.Decommission.Enabled {
+		return nil
+	}
+	archiveDir := cfg.Decommission.ArchiveDirectory
+	if archiveDir == "" {
+		archiveDir = filepath.Join(cfg.StatePath, "decommissioned")
+	}
+	d := &decommission.Decommissioner{
+		Backend:      readerBackend,
+		Power:        pwrBackend,
+		Leases:       leases,
+		Hosts:        hostStore,
+		Tasks:        tasks,
+		FirmwareRoot: cfg.Tftp.RootDirectory,
+		ArchiveDir:   archiveDir,
+		Version:      GitRev,
+		Timeout:      time.Duration(cfg.Decommission.TimeoutSec) * time.Second,
+		Log:          logger.WithName("decommission"),
+	}
+	if w := cfg.Tftp.Writes; w.Enabled && w.PerMACDirectory {
+		d.LogRoot = w.Directory
+		if d.LogRoot == "" {
+			d.LogRoot = cfg.Tftp.RootDirectory
+		}
+	}
+	if cfg.Decommission.RemoveFromIronic && cfg.Ironic.Socket.Path != "" {
+		d.Ironic = ironicManager.NewClient(cfg.Ironic.Socket.Path, cfg.Ironic.UserName, cfg.Ironic.Password)
+	}
+
+	return d
+}
+
+// createAuditLog returns the audit log of the HTTP APIs, or nil if it is
+// disabled.
+func createAuditLog(cfg *config.Config, slogger *slog.Logger) (*audit.Log, error) {
+	if !cfg.Audit.Enabled {
+		return nil, nil
+	}
+	path := cfg.Audit.Path
+	if path == "" {
+		path = filepath.Join(cfg.StatePath, "audit.jsonl")
+	}
+	return audit.Open(audit.Options{
+		FileOptions: logging.FileOptions{
+			Path:       path,
+			MaxSizeMB:  cfg.Audit.MaxSizeMB,
+			MaxBackups: cfg.Audit.MaxBackups,
+		},
+		Retain: cfg.Audit.Retain,
+		Logger: slogger.With("component", "audit"),
+	})
+}
+
+// artifactCacheDir returns the directory of the built artifacts.
+func artifactCacheDir(cfg *config.Config) string {
+	if cfg.Artifacts.CacheDir != "" {
+		return cfg.Artifacts.CacheDir
+	}
+	return filepath.Join(cfg.StatePath, "artifacts")
+}
+
+// createArtifactServer returns the server of the artifacts derived by the
+// pipelines of boot profiles, or nil if they are disabled. Sign steps use the
+// integrity signing key.
+func createArtifactServer(
+	cfg *config.Config,
+	manifests *integrity.Manifests,
+) (*artifact.Server, error) {
+	if !cfg.Artifacts.Enabled {
+		return nil, nil
+	}
+
+	var signer artifact.Signer
+	if manifests != nil && manifests.Signer != nil {
+		signer = manifests.Signer
+	}
+	s := &artifact.Server{
+		Root:     cfg.Static.RootDirectory,
+		CacheDir: artifactCacheDir(cfg),
+		Profiles: make(map[string][]*artifact.Pipeline),
+	}
+	for profile, pipelines := range cfg.Artifacts.Profiles {
+		for _, pc := range pipelines {
+			p := &artifact.Pipeline{Name: pc.Name, Sources: pc.Sources}
+			for _, sc := range pc.Steps {
+				opts := artifact.StepOptions{Signer: signer}
+				for _, f := range sc.Files {
+					mode := uint64(0)
+					if f.Mode != "" {
+						var err error
+						if mode, err = strconv.ParseUint(f.Mode, 8, 32); err != nil {
+							return nil, fmt.Errorf("invalid mode %q of %s: %w", f.Mode, f.Path, err)
+						}
+					}
+					opts.Files = append(opts.Files, artifact.OverlayFile{
+						Path:     f.Path,
+						Template: f.Template,
+						Mode:     os.FileMode(mode),
+					})
+				}
+				step, err := artifact.NewStep(sc.Type, opts)
+				if err != nil {
+					return nil, fmt.Errorf("artifact %s of profile %s: %w", pc.Name, profile, err)
+				}
+				p.Steps = append(p.Steps, step)
+			}
+			s.Profiles[profile] = append(s.Profiles[profile], p)
+		}
+	}
+
+	return s, nil
+}
+
+// createTaskStore returns the store of Redfish tasks, persisted below the
+// state path unless redfish_tasks.persist is disabled.
+func createTaskStore(cfg *config.Config) (*task.Store, error) {
+	if !cfg.RedfishTasks.Persist {
+		return task.New("")
+	}
+	return task.New(filepath.Join(cfg.StatePath, "redfish-tasks.json"))
+}
+
+// snapshotCaches returns the in-memory state served at /api/v1/snapshot and
+// imported by a standby.
+func snapshotCaches(
+	readerBackend backend.BackendReader,
+	hostStore *hoststate.Store,
+	leases *leasedb.DB,
+	tasks *task.Store,
+) *snapshot.Caches {
+	return &snapshot.Caches{
+		Hosts:   hostStore,
+		Leases:  leases,
+		Dnsmasq: dnsmasqConfigManager(readerBackend),
+		Tasks:   tasks,
+		Version: GitRev,
+	}
+}
+
+// importSnapshot loads the state of the active instance into caches when
+// standby.import_from is set. A failed import is logged and the state files
+// loaded already are kept.
+func importSnapshot(ctx context.Context, cfg *config.Config, logger logr.Logger, caches *snapshot.Caches) {
+	if cfg.Standby.ImportFrom == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(cfg.Standby.TimeoutSec)*time.Second)
+	defer cancel()
+
+	s, err := snapshot.Load(ctx, cfg.Standby.ImportFrom, cfg.Standby.Token)
+	if err == nil {
+		err = caches.Import(s)
+	}
+	if err != nil {
+		logger.Error(err, "failed to import standby snapshot, continuing from the state files",
+			"source", cfg.Standby.ImportFrom)
+		return
+	}
+	logger.Info("imported standby snapshot",
+		"source", cfg.Standby.ImportFrom,
+		"version", s.Version,
+		"created", s.Created,
+		"hosts", len(s.Hosts),
+		"leases", len(s.Leases),
+		"tasks", len(s.Tasks),
+	)
+}
+
+// createManifests returns the integrity manifest server, or nil if integrity
+// manifests are disabled. Signatures are only served with a signing key.
+func createManifests(cfg *config.Config, logger logr.Logger) (*integrity.Manifests, error) {
+	if !cfg.Integrity.Enabled {
+		return nil, nil
+	}
+	m := &integrity.Manifests{}
+	if cfg.Integrity.SigningCert != "" || cfg.Integrity.SigningKey != "" {
+		signer, err := integrity.LoadSigner(cfg.Integrity.SigningCert, cfg.Integrity.SigningKey)
+		if err != nil {
+			return nil, err
+		}
+		m.Signer = signer
+	}
+	if cfg.Integrity.VerifyIPXE && m.Signer == nil {
+		return nil, errors.New("integrity.verify_ipxe requires a signing certificate and key")
+	}
+	logger.Info("integrity manifests enabled",
+		"signed", m.Signer != nil,
+		"verify_ipxe", cfg.Integrity.VerifyIPXE,
+	)
+	return m, nil
+}
+
+// createImageCatalog opens the boot image catalog and resolves the Talos
+// Image Factory when it names a catalog image.
+func createImageCatalog(cfg *config.Config) (*imagecatalog.Catalog, error) {
+	images, err := imagecatalog.New(filepath.Join(cfg.StatePath, "images.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open image catalog: %w", err)
+	}
+	if cfg.Talos.Enabled && cfg.Talos.Image != "" {
+		u, err := images.Resolve(cfg.Talos.Image, imagecatalog.KindTalos)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve talos image: %w", err)
+		}
+		cfg.Talos.BaseURL = u
+	}
+
+	return images, nil
+}
+
+// createTemplateStore loads the iPXE script templates and reloads them when
+// they change, or returns nil if templates are disabled.
+func createTemplateStore(
+	ctx context.Context,
+	g *errgroup.Group,
+	cfg *config.Config,
+	logger logr.Logger,
+) (*scripttemplate.Store, error) {
+	dir := cfg.IpxeHttpScript.TemplateDir
+	if dir == "" {
+		return nil, nil
+	}
+	templates, err := scripttemplate.New(
+		logger.WithName("ipxe-templates"),
+		dir,
+		cfg.FileWatch.WatchOptions(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load iPXE templates: %w", err)
+	}
+	g.Go(func() error {
+		templates.Start(ctx)
+		return templates.Close()
+	})
+
+	return templates, nil
+}
+
+// createGPUFirmwareStore returns the Raspberry Pi GPU firmware store, or nil
+// if GPU firmware management is disabled.
+func createGPUFirmwareStore(cfg *config.Config) (*gpufw.Store, error) {
+	if !cfg.GPUFirmware.Enabled {
+		return nil, nil
+	}
+	return gpufw.NewStore(cfg.GPUFirmware.Directory, cfg.GPUFirmware.DefaultVersion)
+}
+
+// dnsmasqConfigManager returns the dnsmasq host/option file manager of b, or
+// nil if b is not a dnsmasq backend. Fault injection wrappers are looked
+// through.
+func dnsmasqConfigManager(b backend.BackendReader) *dnsmasqconfig.ConfigManager {
+	if u, ok := b.(interface{ Unwrap() backend.BackendReader }); ok {
+		b = u.Unwrap()
+	}
+	if d, ok := b.(*dnsmasq.Backend); ok {
+		return d.ConfigManager()
+	}
+	return nil
+}
+
+// runPreflight checks that every enabled service can bind its port and write
+// its directories, that the boot binaries are embedded and that the backend
+// answers. All checks run and every failure is reported in the returned error.
+func runPreflight(log logr.Logger, cfg *config.Config, reader backend.BackendReader) error {
+	checks := []preflight.Check{
+		preflight.TCPPort("http port", fmt.Sprintf("%s:%d", cfg.Address, cfg.Port)),
+		preflight.Writable(cfg.StatePath),
+		preflight.Backend(reader),
+	}
+	for _, l := range cfg.Listeners.HTTP {
+		checks = append(checks, preflight.TCPPort("http listener", l.String()))
+	}
+	for _, l := range cfg.Listeners.Redfish {
+		checks = append(checks, preflight.TCPPort("redfish listener", l.String()))
+	}
+	tftpAddr, _ := cfg.TftpAddrPort()
+	if cfg.Dhcp.Enabled {
+		checks = append(checks,
+			preflight.UDPPort("dhcp port", fmt.Sprintf("%s:%d", cfg.Dhcp.Address, cfg.Dhcp.Port)))
+		if cfg.Dhcp.Interface != "" {
+			checks = append(checks, preflight.Broadcast(cfg.Dhcp.Interface))
+		}
+	}
+	if cfg.Tftp.Enabled {
+		checks = append(checks,
+			preflight.UDPPort("tftp port", tftpAddr.String()),
+			preflight.Writable(cfg.Tftp.RootDirectory),
+			preflight.Files("ipxe binaries", binary.Files,
+				slices.Compact(slices.Sorted(maps.Values(dhcp.ArchToBootFile)))...),
+			preflight.Files("uefi firmware", edk2.Files, edk2.FirmwareFileName),
+		)
+	}
+	if cfg.IPv6.Enabled {
+		checks = append(checks,
+			preflight.IPv6Listener("http", net.JoinHostPort(cfg.Address, strconv.Itoa(cfg.Port))),
+			preflight.Check{Name: "server ipv6 address", Run: func(context.Context) error {
+				_, err := cfg.ServerIPv6()
+				return err
+			}},
+		)
+		if cfg.Tftp.Enabled {
+			checks = append(checks,
+				preflight.IPv6Listener("tftp", tftpAddr.String()))
+		}
+	}
+	if cfg.Static.Enabled {
+		checks = append(checks, preflight.Writable(cfg.Static.RootDirectory))
+	}
+	if cfg.Dnsmasq.Enabled {
+		checks = append(checks, preflight.Writable(cfg.Dnsmasq.RootDirectory))
+	}
+	if cfg.GPUFirmware.Enabled {
+		checks = append(checks, preflight.Writable(cfg.GPUFirmware.Directory))
+	}
+
+	report := preflight.Run(
+		context.Background(),
+		time.Duration(cfg.Preflight.TimeoutSec)*time.Second,
+		checks...,
+	)
+	for _, res := range report {
+		if res.Err == nil {
+			log.V(1).Info("preflight check passed", "check", res.Name, "duration", res.Duration)
+		}
+	}
+
+	return report.Err()
+}
+
+// createDHCPStats returns the statistics of the DHCP server, registered with
+// Prometheus, or nil when the DHCP server is disabled.
+func createDHCPStats(cfg *config.Config, reader backend.BackendReader) *metric.DHCPStats {
+	if !cfg.Dhcp.Enabled {
+		return nil
+	}
+
+	stats := metric.NewDHCPStats(reader)
+	prometheus.MustRegister(stats)
+
+	return stats
+}
+
+// createHostCollector returns the collector of per-host gauges.
+func createHostCollector(
+	cfg *config.Config,
+	reader backend.BackendReader,
+	power backend.BackendPower,
+) *metric.HostCollector {
+	c := &metric.HostCollector{
+		Reader:  reader,
+		Timeout: time.Duration(cfg.HostMetrics.TimeoutSec) * time.Second,
+	}
+	if cfg.HostMetrics.Power {
+		c.Power = power
+	}
+	return c
+}
+
+// faultFor returns the fault configured by fc, or nil if it injects nothing.
+func faultFor(fc config.FaultConfig) *faultinject.Fault {
+	if fc.ErrorPercent <= 0 && (fc.DelayPercent <= 0 || fc.DelayMS <= 0) {
+		return nil
+	}
+	return &faultinject.Fault{
+		ErrorPercent: fc.ErrorPercent,
+		DelayPercent: fc.DelayPercent,
+		Delay:        time.Duration(fc.DelayMS) * time.Millisecond,
+	}
+}
+
+// createBootTracker returns the boot attempt tracker, or nil if tracking is disabled.
+func createBootTracker(
+	log logr.Logger,
+	cfg *config.Config,
+	hostStore *hoststate.Store,
+) *hoststate.AttemptTracker {
+	slo := createBootSLO(cfg)
+	if !cfg.BootAttempts.Enabled {
+		if slo == nil {
+			return nil
+		}
+		// Attempts are still followed for the SLO metrics, without a limit.
+		return &hoststate.AttemptTracker{SLO: slo}
+	}
+	return &hoststate.AttemptTracker{
+		Store:       hostStore,
+		Log:         log.WithName("boot-attempts"),
+		MaxAttempts: cfg.BootAttempts.MaxAttempts,
+		Window:      time.Duration(cfg.BootAttempts.WindowSec) * time.Second,
+		Fallback:    hoststate.FallbackMode(cfg.BootAttempts.Fallback),
+		SLO:         slo,
+	}
+}
+
+// createBootFlow returns the boot flow of the hosts, or nil if it is not
+// followed.
+func createBootFlow(
+	log logr.Logger,
+	cfg *config.Config,
+	reader backend.BackendReader,
+	hostStore *hoststate.Store,
+	bus *events.Bus,
+) (*provisioning.Flow, error) {
+	if !cfg.BootFlow.Enabled {
+		return nil, nil
+	}
+	mode, err := provisioning.ParseMode(cfg.BootFlow.Mode)
+	if err != nil {
+		return nil, fmt.Errorf("invalid boot_flow.mode: %w", err)
+	}
+	flow := &provisioning.Flow{
+		Hosts:   hostStore,
+		Backend: reader,
+		Events:  bus,
+		Mode:    mode,
+		Log:     log.WithName("boot-flow"),
+	}
+	if err := flow.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid boot_flow.mode: %w", err)
+	}
+	hostStore.OnNetbootChange(flow.NetbootChanged)
+
+	return flow, nil
+}
+
+// createBootSLO returns the boot SLO metrics, registered with Prometheus, or
+// nil if they are disabled.
+func createBootSLO(cfg *config.Config) *metric.BootSLO {
+	if !cfg.BootSLO.Enabled {
+		return nil
+	}
+
+	slo := &metric.BootSLO{
+		Window:         time.Duration(cfg.BootAttempts.WindowSec) * time.Second,
+		Timeout:        time.Duration(cfg.BootSLO.TimeoutSec) * time.Second,
+		ExpectCallback: cfg.PhoneHome.Enabled,
+		RatioWindow:    time.Duration(cfg.BootSLO.RatioWindowSec) * time.Second,
+	}
+	prometheus.MustRegister(slo)
+
+	return slo
+}
+
+// createBootVerifier returns the boot artifact request verifier, or nil if
+// verification is disabled.
+func createBootVerifier(cfg *config.Config, reader backend.BackendReader) *bootauth.Verifier {
+	if !cfg.BootAuth.Enabled {
+		return nil
+	}
+	return &bootauth.Verifier{
+		Backend:        reader,
+		Log:            cfg.Slog().With("component", "bootauth"),
+		VerifySourceIP: cfg.BootAuth.VerifySourceIP,
+		Secret:         []byte(cfg.BootAuth.TokenSecret),
+		TTL:            time.Duration(cfg.BootAuth.TokenTTLSec) * time.Second,
+	}
+}
+
+// createAdminOIDC returns the OIDC provider that authenticates admin API
+// operators, or nil if admin authentication is disabled.
+func createAdminOIDC(cfg *config.Config, slogger *slog.Logger) (*adminauth.OIDC, error) {
+	if !cfg.AdminAuth.Enabled {
+		return nil, nil
+	}
+	oidc := cfg.AdminAuth.OIDC
+	if oidc.Issuer == "" || oidc.ClientID == "" {
+		return nil, errors.New("admin_auth.oidc requires an issuer and a client_id")
+	}
+	defaultRole, err := adminauth.ParseRole(oidc.DefaultRole)
+	if err != nil {
+		return nil, fmt.Errorf("admin_auth.oidc.default_role: %w", err)
+	}
+	roles := make(map[string]adminauth.Role)
+	for _, group := range oidc.ViewerGroups {
+		roles[group] = adminauth.RoleViewer
+	}
+	for _, group := range oidc.AdminGroups {
+		roles[group] = adminauth.RoleAdmin
+	}
+	return &adminauth.OIDC{
+		Issuer:       oidc.Issuer,
+		ClientID:     oidc.ClientID,
+		ClientSecret: oidc.ClientSecret,
+		RedirectURL:  oidc.RedirectURL,
+		Scopes:       oidc.Scopes,
+		GroupsClaim:  oidc.GroupsClaim,
+		Roles:        roles,
+		DefaultRole:  defaultRole,
+		Log:          slogger.With("component", "adminauth"),
+	}, nil
+}
+
+// services are the components shared by the servers startServices starts.
+// Nil components are disabled.
+type services struct {
+	readerBackend backend.BackendReader
+	pwrBackend    backend.BackendPower
+	hostStore     *hoststate.Store
+	bootTracker   *hoststate.AttemptTracker
+	flow          *provisioning.Flow
+	bootVerifier  *bootauth.Verifier
+	manifests     *integrity.Manifests
+	gpuFirmware   *gpufw.Store
+	images        *imagecatalog.Catalog
+	rollouts      *canary.Store
+	templates     *scripttemplate.Store
+	shaper        *bandwidth.Shaper
+	bootFlows     *bootflow.Registry
+	readOnly      *readonly.Switch
+	telemetrySvc  *telemetry.Service
+	eventBus      *events.Bus
+	dhcpStats     *metric.DHCPStats
+	leases        *leasedb.DB
+	tasks         *task.Store
+}
+
+// apiServices are the services of the HTTP API handlers on top of the
+// shared ones.
+type apiServices struct {
+	*services
+	certStore      *tlscert.Store
+	adminOIDC      *adminauth.OIDC
+	biosAttributes *biosattr.Registry
+	sessions       *session.Store
+	updater        *selfupdate.Updater
+	phoneHome      *phonehome.Handler
+	auditLog       *audit.Log
+	artifacts      *artifact.Server
+}
+
 // startServices initializes and starts all configured services.
 func startServices(
 	ctx context.Context,
@@ -173,9 +1084,85 @@ func startServices(
 	logger logr.Logger,
 	readerBackend backend.BackendReader,
 	pwrBackend backend.BackendPower,
+	hostStore *hoststate.Store,
+	bootTracker *hoststate.AttemptTracker,
+	bootVerifier *bootauth.Verifier,
 ) error {
 	g, ctx := errgroup.WithContext(ctx)
 
+	manifests, err := createManifests(cfg, logger)
+	if err != nil {
+		return fmt.Errorf("failed to set up integrity manifests: %w", err)
+	}
+
+	gpuFirmware, err := createGPUFirmwareStore(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to open gpu firmware store: %w", err)
+	}
+
+	images, err := createImageCatalog(cfg)
+	if err != nil {
+		return err
+	}
+	rollouts, err := canary.New(filepath.Join(cfg.StatePath, "rollouts.json"))
+	if err != nil {
+		return fmt.Errorf("failed to open rollouts: %w", err)
+	}
+	templates, err := createTemplateStore(ctx, g, cfg, logger)
+	if err != nil {
+		return err
+	}
+
+	shaper := createBandwidthShaper(cfg, logger)
+
+	// Correlates the DHCP, TFTP and HTTP requests of each boot.
+	bootFlows := &bootflow.Registry{}
+
+	readOnly := readonly.New(cfg.ReadOnly)
+	if readOnly.Enabled() {
+		logger.Info("read-only mode enabled, mutating API requests are rejected")
+	}
+
+	eventBus, hookRunner := createEventBus(logger, cfg, hostStore)
+	if bootTracker != nil {
+		// Phone-home callbacks end the boot attempts of the SLO metrics.
+		eventBus.Subscribe(bootTracker.SLO.HandleEvent)
+	}
+	flow, err := createBootFlow(logger, cfg, readerBackend, hostStore, eventBus)
+	if err != nil {
+		return err
+	}
+
+	dhcpStats := createDHCPStats(cfg, readerBackend)
+
+	leases, err := createLeaseDB(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to open lease database: %w", err)
+	}
+	tasks, err := createTaskStore(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to load Redfish tasks: %w", err)
+	}
+	importSnapshot(ctx, cfg, logger, snapshotCaches(readerBackend, hostStore, leases, tasks))
+	if leases != nil {
+		sweeper := &leasedb.Sweeper{
+			DB:       leases,
+			Reader:   readerBackend,
+			Interval: time.Duration(cfg.Leases.SweepIntervalSec) * time.Second,
+			Log:      logger.WithName("leases"),
+		}
+		g.Go(func() error {
+			return sweeper.Run(ctx)
+		})
+	}
+
+	telemetrySvc := createTelemetry(cfg, logger, readerBackend, pwrBackend, hostStore)
+	if telemetrySvc != nil {
+		g.Go(func() error {
+			return telemetrySvc.Run(ctx)
+		})
+	}
+
 	// Start Ironic supervisor if enabled
 	if cfg.Ironic.SupervisorEnabled {
 		logger.Info("Ironic supervisor enabled", "socket_path", cfg.Ironic.Socket.Path)
@@ -190,15 +1177,44 @@ func startServices(
 		}
 	}
 
+	svc := &services{
+		readerBackend: readerBackend,
+		pwrBackend:    pwrBackend,
+		hostStore:     hostStore,
+		bootTracker:   bootTracker,
+		flow:          flow,
+		bootVerifier:  bootVerifier,
+		manifests:     manifests,
+		gpuFirmware:   gpuFirmware,
+		images:        images,
+		rollouts:      rollouts,
+		templates:     templates,
+		shaper:        shaper,
+		bootFlows:     bootFlows,
+		readOnly:      readOnly,
+		telemetrySvc:  telemetrySvc,
+		eventBus:      eventBus,
+		dhcpStats:     dhcpStats,
+		leases:        leases,
+		tasks:         tasks,
+	}
+
 	// Start HTTP API server
-	if err := startHTTPServer(ctx, g, cfg, logger, readerBackend, pwrBackend); err != nil {
+	if err := startHTTPServer(ctx, g, cfg, logger, svc); err != nil {
 		return fmt.Errorf("failed to start HTTP server: %w", err)
 	}
 
+	// Start image garbage collection if enabled
+	if cfg.ImageGC.Enabled {
+		startImageJanitor(ctx, g, cfg, logger)
+	}
+
 	// Start TFTP server if enabled
 	if cfg.Tftp.Enabled {
 		logger.Info("TFTP server enabled", "root_directory", cfg.Tftp.RootDirectory)
-		startTFTPServer(ctx, g, cfg, logger, readerBackend)
+		if err := startTFTPServer(ctx, g, cfg, logger, svc); err != nil {
+			return fmt.Errorf("failed to start TFTP server: %w", err)
+		}
 	}
 
 	// Start DHCP server if enabled
@@ -210,13 +1226,16 @@ func startServices(
 			"address",
 			cfg.Dhcp.Address,
 		)
-		if err := startDHCPServer(ctx, g, cfg, logger, readerBackend); err != nil {
+		if err := startDHCPServer(ctx, g, cfg, logger, svc); err != nil {
 			return fmt.Errorf("failed to start DHCP server: %w", err)
 		}
 	}
 
 	// Wait for all services or shutdown signal
-	if err := g.Wait(); err != nil && !errors.Is(err, context.Canceled) {
+	err = g.Wait()
+	// Hooks still running were started by events before the shutdown.
+	hookRunner.Wait()
+	if err != nil && !errors.Is(err, context.Canceled) {
 		return fmt.Errorf("service error: %w", err)
 	}
 
@@ -228,11 +1247,10 @@ func startJsonRpcServer(
 	g *errgroup.Group,
 	cfg *config.Config,
 ) error {
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-		Level: slog.LevelInfo,
-	}))
+	logger := cfg.Slog()
 
-	httpHandler := ironic.New(logger, cfg.Ironic.Rpc.Socket.Path)
+	// JSON-RPC calls are POSTs, which are never cached.
+	httpHandler := ironic.New(logger, cfg.Ironic.Rpc.Socket.Path, config.IronicCacheConfig{})
 	httpServer := &http.Server{
 		Addr:    fmt.Sprintf(":%d", cfg.Ironic.Rpc.Port),
 		Handler: httpHandler,
@@ -285,19 +1303,89 @@ func startHTTPServer(
 	g *errgroup.Group,
 	cfg *config.Config,
 	logger logr.Logger,
-	readerBackend backend.BackendReader,
-	pwrBackend backend.BackendPower,
+	svc *services,
 ) error {
 	// Create structured logger for HTTP server
-	slogger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-		Level: slog.LevelInfo,
-	}))
+	slogger := cfg.Slog()
 
 	// Create API instance
 	apiServer := api.New(cfg, slogger)
 
+	certStore, err := createCertStore(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	adminOIDC, err := createAdminOIDC(cfg, slogger)
+	if err != nil {
+		return fmt.Errorf("failed to set up admin authentication: %w", err)
+	}
+	apiServer.UseTLS(certStore)
+
+	corsPolicy, err := createCORSPolicy(cfg, logger)
+	if err != nil {
+		return err
+	}
+	apiServer.UseCORS(corsPolicy)
+
+	biosAttributes, err := biosattr.Load(cfg.BiosAttributesPath)
+	if err != nil {
+		return err
+	}
+
+	sessions, err := createSessionStore(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to load Redfish sessions: %w", err)
+	}
+
+	updater, err := createUpdater(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to set up self update: %w", err)
+	}
+
+	auditLog, err := createAuditLog(cfg, slogger)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer auditLog.Close()
+
+	artifacts, err := createArtifactServer(cfg, svc.manifests)
+	if err != nil {
+		return fmt.Errorf("failed to set up artifact pipelines: %w", err)
+	}
+
+	phoneHomeLog := slogger.With("component", "phonehome")
+	phoneHome, err := phonehome.New(phoneHomeLog, cfg, svc.hostStore, svc.eventBus)
+	if err != nil {
+		return fmt.Errorf("failed to set up phone home: %w", err)
+	}
+
 	// Configure API handlers
-	configureAPIHandlers(apiServer, cfg, logger, readerBackend, pwrBackend, slogger)
+	configureAPIHandlers(apiServer, cfg, logger, slogger, apiServices{
+		services:       svc,
+		certStore:      certStore,
+		adminOIDC:      adminOIDC,
+		biosAttributes: biosAttributes,
+		sessions:       sessions,
+		updater:        updater,
+		phoneHome:      phoneHome,
+		auditLog:       auditLog,
+		artifacts:      artifacts,
+	})
+
+	for _, l := range cfg.Listeners.HTTP {
+		apiServer.AddListener(l.String())
+	}
+	if cfg.APISocket.Path != "" {
+		mode, err := strconv.ParseUint(cfg.APISocket.Mode, 8, 32)
+		if err != nil {
+			return fmt.Errorf("invalid api_socket.mode %q: %w", cfg.APISocket.Mode, err)
+		}
+		apiServer.UseUnixSocket(cfg.APISocket.Path, os.FileMode(mode))
+	}
+	for _, l := range cfg.Listeners.Redfish {
+		apiServer.AddListener(l.String(), "/redfish/")
+	}
 
 	// Start the server in a goroutine
 	bindAddr := fmt.Sprintf("%s:%d", cfg.Address, cfg.Port)
@@ -343,10 +1431,13 @@ func configureAPIHandlers(
 	apiServer *api.Api,
 	cfg *config.Config,
 	logger logr.Logger,
-	readerBackend backend.BackendReader,
-	pwrBackend backend.BackendPower,
 	slogger *slog.Logger,
+	svc apiServices,
 ) {
+	// Downloads of update tasks and IPA images are reported by Redfish and
+	// the admin API.
+	downloads := download.NewTracker()
+
 	// Add health check handler
 	apiServer.AddHandler("/healthcheck", health.New(slogger, GitRev, startTime))
 	logger.V(1).Info("registered health check handler", "path", "/healthcheck")
@@ -355,61 +1446,388 @@ func configureAPIHandlers(
 	apiServer.AddHandler("/metrics", metrics.New(slogger))
 	logger.V(1).Info("registered metrics handler", "path", "/metrics")
 
+	// Operators of the admin API authenticate when admin_auth is enabled.
+	// Of the Redfish emulation, only actions that change metal-boot itself
+	// require an admin.
+	var adminAuth *adminauth.Authenticator
+	if svc.adminOIDC != nil {
+		adminAuth = &adminauth.Authenticator{
+			Providers: []adminauth.Provider{svc.adminOIDC},
+			Log:       svc.adminOIDC.Log,
+		}
+		apiServer.AddHandler("/auth/", svc.adminOIDC.Handler())
+		logger.V(1).Info("registered admin login handler", "path", "/auth/")
+	}
+
 	// Add Redfish handler
-	apiServer.AddHandler("/redfish/v1/", redfish.New(slogger, cfg, readerBackend, pwrBackend))
+	// Mutating requests to the Redfish and admin APIs are audited. The admin
+	// API audits inside its authentication, so that the operator is known.
+	apiServer.AddHandler(
+		"/redfish/v1/",
+		adminAuth.Identify(svc.auditLog.Middleware(redfish.New(
+			slogger,
+			cfg,
+			svc.readerBackend,
+			svc.pwrBackend,
+			svc.hostStore,
+			svc.certStore,
+			svc.updater,
+			svc.readOnly,
+			svc.telemetrySvc,
+			svc.biosAttributes,
+			downloads,
+			svc.sessions,
+			svc.tasks,
+		))),
+	)
 	logger.V(1).Info("registered Redfish handler", "path", "/redfish/v1/")
 
-	apiServer.AddHandler("/v1/boot/{mac}/boot.ipxe", script.New(slogger, cfg, readerBackend))
+	apiServer.AddHandler(
+		"/v1/boot/{mac}/boot.ipxe",
+		svc.bootVerifier.Middleware(
+			bootauth.PathValueMAC("mac"),
+			svc.bootFlows.Middleware(
+				script.New(slogger, cfg, svc.readerBackend, script.Options{
+					Hosts:     svc.hostStore,
+					Tracker:   svc.bootTracker,
+					PhoneHome: svc.phoneHome,
+					Images:    svc.images,
+					Rollouts:  svc.rollouts,
+					Templates: svc.templates,
+					Flow:      svc.flow,
+				}),
+			),
+		),
+	)
 	logger.V(1).Info("registered iPXE script handler", "path", "/v1/boot/{mac}/boot.ipxe")
 
-	apiServer.AddHandler("/v1/", ironic.New(slogger, cfg.Ironic.Socket.Path))
+	if svc.phoneHome != nil {
+		apiServer.AddHandler(phonehome.Path, svc.phoneHome)
+		apiServer.AddHandler(phonehome.SensorsPath, http.HandlerFunc(svc.phoneHome.ServeSensors))
+		logger.V(1).Info("registered phone home handler", "path", phonehome.Path)
+	}
+
+	apiServer.AddHandler(
+		"/api/v1/",
+		adminAuth.Middleware(svc.auditLog.Middleware(admin.New(
+			slogger,
+			cfg,
+			svc.readerBackend,
+			svc.pwrBackend,
+			svc.hostStore,
+			dnsmasqConfigManager(svc.readerBackend),
+			svc.gpuFirmware,
+			svc.images,
+			svc.readOnly,
+			createArchiver(cfg),
+			downloads,
+			svc.dhcpStats,
+			svc.rollouts,
+			svc.auditLog,
+			svc.leases,
+			createDecommissioner(
+				cfg,
+				logger,
+				svc.readerBackend,
+				svc.pwrBackend,
+				svc.hostStore,
+				svc.leases,
+				svc.tasks,
+			),
+			snapshotCaches(svc.readerBackend, svc.hostStore, svc.leases, svc.tasks),
+		))),
+	)
+	logger.V(1).Info("registered admin API handler", "path", "/api/v1/")
+
+	if cfg.UI.Enabled {
+		apiServer.AddHandler(ui.Path, ui.New(slogger))
+		logger.V(1).Info("registered dashboard handler", "path", ui.Path)
+	}
+
+	apiServer.AddHandler("/v1/", ironic.New(slogger, cfg.Ironic.Socket.Path, cfg.Ironic.Cache))
 	logger.V(1).Info("registered Ironic handler", "path", "/v1/")
 
-	if err := util.DownloadIpaImages(filepath.Join(cfg.Static.RootDirectory, "images")); err != nil {
+	ipaRoot := filepath.Join(cfg.Static.RootDirectory, "images")
+	if err := util.DownloadIpaImages(ipaRoot, downloads); err != nil {
 		logger.Error(err, "failed to download iPXE images")
 	}
 
+	streams := createStreamLimiter(cfg, slogger)
+
 	// Add iPXE handlers if enabled
 	if cfg.IpxeHttpScript.Enabled {
-		apiServer.AddHandler("/", ipxe.New(slogger, cfg, readerBackend))
+		ipxeHandler := svc.bootFlows.Middleware(ipxe.New(slogger, cfg, svc.readerBackend, svc.manifests))
+		apiServer.AddHandler("/", ipxeHandler)
 		logger.Info("iPXE HTTP script handler enabled", "path", "/")
+
+		// Images below the static root are large; limit concurrent streams.
+		apiServer.AddHandler("/images/", streams.Middleware(svc.shaper.Middleware(ipxeHandler)))
 	}
 
+	// iPXE binaries, for the ipxe_binary_url DHCP hands to HTTP boot clients.
+	binaryHandler := ipxebinary.New(slogger.With("component", "binary"), cfg, svc.readerBackend)
+	apiServer.AddHandler("/ipxe/", svc.bootFlows.Middleware(svc.shaper.Middleware(binaryHandler)))
+	logger.V(1).Info("registered iPXE binary handler", "path", "/ipxe/")
+
 	// Add ISO handler if enabled
 	if cfg.Iso.Enabled {
-		apiServer.AddHandler("/iso/", iso.New(logger, cfg, readerBackend))
+		apiServer.AddHandler(
+			"/iso/",
+			streams.Middleware(svc.shaper.Middleware(
+				svc.bootVerifier.Middleware(
+					bootauth.ParentDirMAC,
+					svc.bootFlows.Middleware(iso.New(logger, cfg, svc.readerBackend, svc.images, svc.hostStore)),
+				),
+			)),
+		)
 		logger.Info("ISO handler enabled", "path", "/iso/")
 	}
 
+	if svc.artifacts != nil {
+		apiServer.AddHandler(
+			artifactsapi.Path,
+			streams.Middleware(svc.shaper.Middleware(
+				svc.bootVerifier.Middleware(
+					bootauth.ParentDirMAC,
+					svc.bootFlows.Middleware(
+						artifactsapi.New(slogger, svc.artifacts, svc.readerBackend, svc.hostStore),
+					),
+				),
+			)),
+		)
+		logger.Info("artifact pipelines enabled", "path", artifactsapi.Path)
+	}
+
 	// Add Talos image handler if enabled
 	if cfg.Talos.Enabled {
-		apiServer.AddHandler("/images/talos/", talos.New(slogger, &cfg.Talos))
+		apiServer.AddHandler(
+			"/images/talos/",
+			streams.Middleware(
+				svc.shaper.Middleware(svc.bootFlows.Middleware(talos.New(slogger, &cfg.Talos))),
+			),
+		)
 		logger.Info("Talos image handler enabled", "path", "/images/talos/")
 	}
 }
 
-// startTFTPServer configures and starts the TFTP server.
-func startTFTPServer(
+// createTelemetry returns the Redfish telemetry service, or nil if telemetry
+// is disabled.
+func createTelemetry(
+	cfg *config.Config,
+	logger logr.Logger,
+	reader backend.BackendReader,
+	power backend.BackendPower,
+	hosts *hoststate.Store,
+) *telemetry.Service {
+	if !cfg.Telemetry.Enabled {
+		return nil
+	}
+	logger.Info("Redfish telemetry enabled", "interval_sec", cfg.Telemetry.IntervalSec)
+	return &telemetry.Service{
+		Reader:   reader,
+		Power:    power,
+		Hosts:    hosts,
+		Interval: time.Duration(cfg.Telemetry.IntervalSec) * time.Second,
+		Log:      logger.WithName("telemetry"),
+	}
+}
+
+// createEventBus returns the bus that provisioning events are published on,
+// with every event logged and handed to the configured hooks, and the runner
+// of the hooks, if any. Hosts the host state store records for the first time
+// are published as discovered.
+func createEventBus(
+	logger logr.Logger,
+	cfg *config.Config,
+	hostStore *hoststate.Store,
+) (*events.Bus, *hooks.Runner) {
+	log := logger.WithName("events")
+	bus := &events.Bus{}
+	bus.Subscribe(func(e events.Event) {
+		log.Info("provisioning event", "type", e.Type, "mac", e.MAC, "ip", e.IP,
+			"state", e.State, "message", e.Message)
+	})
+
+	var runner *hooks.Runner
+	if len(cfg.Hooks) > 0 {
+		runner = &hooks.Runner{Log: logger.WithName("hooks")}
+		for _, h := range cfg.Hooks {
+			hook := hooks.Hook{
+				Name:    h.Name,
+				Command: h.Command,
+				Args:    h.Args,
+				Timeout: time.Duration(h.TimeoutSec) * time.Second,
+			}
+			for _, t := range h.Events {
+				hook.Events = append(hook.Events, events.Type(t))
+			}
+			runner.Hooks = append(runner.Hooks, hook)
+		}
+		bus.Subscribe(runner.Handle)
+		log.Info("event hooks configured", "hooks", len(runner.Hooks))
+	}
+
+	if hostStore != nil {
+		hostStore.OnCreate(func(h hoststate.Host) {
+			bus.Publish(events.Event{
+				Type:    events.NodeDiscovered,
+				MAC:     h.MAC,
+				State:   string(h.State),
+				Message: "first seen",
+			})
+		})
+	}
+
+	return bus, runner
+}
+
+// createBandwidthShaper returns the shaper of TFTP transfers and large HTTP
+// downloads, or nil if bandwidth limiting is disabled.
+func createBandwidthShaper(cfg *config.Config, logger logr.Logger) *bandwidth.Shaper {
+	if !cfg.Bandwidth.Enabled {
+		return nil
+	}
+	logger.Info("bandwidth limiting enabled",
+		"global_mbps", cfg.Bandwidth.GlobalMbps,
+		"per_client_mbps", cfg.Bandwidth.PerClientMbps,
+	)
+	// Megabits per second to bytes per second.
+	return &bandwidth.Shaper{
+		Global:    int64(cfg.Bandwidth.GlobalMbps) * 1_000_000 / 8,
+		PerClient: int64(cfg.Bandwidth.PerClientMbps) * 1_000_000 / 8,
+	}
+}
+
+// createCORSPolicy returns the CORS policy of the HTTP APIs, or nil if CORS is
+// disabled.
+func createCORSPolicy(cfg *config.Config, logger logr.Logger) (*cors.Policy, error) {
+	if !cfg.CORS.Enabled {
+		return nil, nil
+	}
+	if cfg.CORS.AllowCredentials && slices.Contains(cfg.CORS.AllowedOrigins, "*") {
+		return nil, errors.New("cors.allow_credentials cannot be combined with any origin (\"*\")")
+	}
+	logger.Info("CORS enabled", "allowed_origins", cfg.CORS.AllowedOrigins)
+	return &cors.Policy{
+		AllowedOrigins:   cfg.CORS.AllowedOrigins,
+		AllowedMethods:   cfg.CORS.AllowedMethods,
+		AllowedHeaders:   cfg.CORS.AllowedHeaders,
+		ExposedHeaders:   cfg.CORS.ExposedHeaders,
+		AllowCredentials: cfg.CORS.AllowCredentials,
+		MaxAge:           cfg.CORS.MaxAgeSec,
+	}, nil
+}
+
+// createStreamLimiter returns the limiter for large artifact downloads, or
+// nil if stream limiting is disabled.
+func createStreamLimiter(cfg *config.Config, slogger *slog.Logger) *streamlimit.Limiter {
+	if !cfg.StreamLimit.Enabled {
+		return nil
+	}
+	return &streamlimit.Limiter{
+		MaxStreams:   cfg.StreamLimit.MaxStreams,
+		PerClient:    cfg.StreamLimit.PerClient,
+		MaxQueue:     cfg.StreamLimit.MaxQueue,
+		QueueTimeout: time.Duration(cfg.StreamLimit.QueueTimeoutSec) * time.Second,
+		Log:          slogger,
+	}
+}
+
+// startImageJanitor keeps downloaded images within the configured disk budget.
+func startImageJanitor(
 	ctx context.Context,
 	g *errgroup.Group,
 	cfg *config.Config,
 	logger logr.Logger,
-	backend backend.BackendReader,
 ) {
+	dirs := cfg.ImageGC.Directories
+	if len(dirs) == 0 {
+		dirs = []string{filepath.Join(cfg.Static.RootDirectory, "images")}
+		if cfg.Talos.Enabled && cfg.Talos.CacheDirectory != "" {
+			dirs = append(dirs, cfg.Talos.CacheDirectory)
+		}
+		if cfg.Artifacts.Enabled {
+			dirs = append(dirs, artifactCacheDir(cfg))
+		}
+	}
+
+	// The IPA images are only downloaded at startup, so evicting them would
+	// break inspection and deployment until a restart.
+	janitor := &imagecache.Janitor{
+		Dirs:     dirs,
+		Pinned:   util.IpaImageDirs(filepath.Join(cfg.Static.RootDirectory, "images")),
+		Budget:   cfg.ImageGC.BudgetMB << 20,
+		Interval: time.Duration(cfg.ImageGC.IntervalSec) * time.Second,
+		Log:      logger.WithName("image-gc"),
+	}
+
+	logger.Info("image garbage collection enabled",
+		"dirs", dirs,
+		"budget_mb", cfg.ImageGC.BudgetMB,
+		"pinned", janitor.Pinned,
+	)
+	g.Go(func() error {
+		return janitor.Run(ctx)
+	})
+}
+
+// startTFTPServer configures and starts the TFTP server on its address and
+// every TFTP listener.
+func startTFTPServer(
+	ctx context.Context,
+	g *errgroup.Group,
+	cfg *config.Config,
+	logger logr.Logger,
+	svc *services,
+) error {
+	patches, err := cfg.Tftp.IpxePatcher()
+	if err != nil {
+		return fmt.Errorf("invalid iPXE patches: %w", err)
+	}
 	ts := &tftp.Server{
 		Logger:        logger.WithName("tftp"),
 		RootDirectory: cfg.Tftp.RootDirectory,
 		Patch:         cfg.Tftp.IpxePatch,
+		Patches:       patches,
+		Hosts:         svc.hostStore,
+		Integrity:     svc.manifests,
+		GPUFirmware:   svc.gpuFirmware,
+		BootFlows:     svc.bootFlows,
+		Bandwidth:     svc.shaper,
+	}
+	if cfg.FaultInjection.Enabled {
+		ts.Faults = faultFor(cfg.FaultInjection.Tftp)
+	}
+	if w := cfg.Tftp.Writes; w.Enabled {
+		ts.Writes = &tftp.WritePolicy{
+			Directory:    w.Directory,
+			AllowedPaths: w.AllowedPaths,
+			MaxSize:      w.MaxSizeBytes,
+			PerMAC:       w.PerMACDirectory,
+		}
 	}
 
-	logger.Info("starting TFTP server", "addr", cfg.Address)
-	g.Go(func() error {
-		return ts.ListenAndServe(
-			ctx,
-			netip.AddrPortFrom(netip.MustParseAddr(cfg.Address), 69),
-			backend,
-		)
-	})
+	addr, err := cfg.TftpAddrPort()
+	if err != nil {
+		return err
+	}
+	addrs := []netip.AddrPort{addr}
+	for _, l := range cfg.Listeners.TFTP {
+		addr, err := netip.ParseAddrPort(l.String())
+		if err != nil {
+			return fmt.Errorf("invalid tftp listener: %w", err)
+		}
+		addrs = append(addrs, addr)
+	}
+
+	for _, addr := range addrs {
+		logger.Info("starting TFTP server", "addr", addr)
+		g.Go(func() error {
+			return ts.ListenAndServe(ctx, addr, svc.readerBackend)
+		})
+	}
+
+	return nil
 }
 
 // startDHCPServer configures and starts the DHCP server.
@@ -418,18 +1836,43 @@ func startDHCPServer(
 	g *errgroup.Group,
 	cfg *config.Config,
 	logger logr.Logger,
-	backend backend.BackendReader,
+	svc *services,
 ) error {
-	dh, err := createDHCPHandler(cfg, logger, backend)
+	dh, err := createDHCPHandler(cfg, logger, svc)
 	if err != nil {
 		return fmt.Errorf("failed to create DHCP handler: %w", err)
 	}
 
+	handlers := []dhcpServer.Handler{dh}
+	if cfg.Dhcp.Guard.Enabled {
+		dg, err := createDHCPGuard(cfg, logger, svc.eventBus)
+		if err != nil {
+			return fmt.Errorf("failed to create DHCP guard: %w", err)
+		}
+		handlers = append(handlers, dg)
+
+		logger.Info("starting DHCP guard", "interface", cfg.Dhcp.Interface)
+		g.Go(func() error {
+			return serveDHCPGuard(ctx, cfg, dg)
+		})
+	}
+
 	logger.Info("starting DHCP server", "bind_addr", cfg.Dhcp.Address)
 	g.Go(func() error {
-		return serveDHCP(ctx, cfg, logger, dh)
+		return serveDHCP(ctx, cfg, logger, handlers...)
 	})
 
+	if cfg.DHCPv6.Enabled {
+		dh6, ok := dh.(dhcpServer.Handler6)
+		if !ok {
+			return fmt.Errorf("DHCP handler %T does not support DHCPv6", dh)
+		}
+		logger.Info("starting DHCPv6 server", "bind_addr", cfg.DHCPv6.Address)
+		g.Go(func() error {
+			return serveDHCPv6(ctx, cfg, logger, dh6)
+		})
+	}
+
 	// Start lease cleanup routine if using reservation handler with lease management
 	// if !cfg.Dhcp.ProxyEnabled && (cfg.Dhcp.LeaseFile != "" || cfg.Dhcp.ConfigFile != "") {
 	// 	g.Go(func() error {
@@ -445,7 +1888,7 @@ func serveDHCP(
 	ctx context.Context,
 	cfg *config.Config,
 	logger logr.Logger,
-	dh dhcpServer.Handler,
+	handlers ...dhcpServer.Handler,
 ) error {
 	dhcpAddr, err := netip.ParseAddrPort(
 		fmt.Sprintf("%s:%d", cfg.Dhcp.Address, cfg.Dhcp.Port),
@@ -466,7 +1909,7 @@ func serveDHCP(
 	ds := &dhcpServer.DHCP{
 		Logger:   logger,
 		Conn:     conn,
-		Handlers: []dhcpServer.Handler{dh},
+		Handlers: handlers,
 	}
 
 	// Handle shutdown gracefully
@@ -480,13 +1923,139 @@ func serveDHCP(
 	return ds.Serve(ctx)
 }
 
+// createDHCPGuard creates the detector of other DHCP servers. Every address
+// of the host counts as metal-boot.
+func createDHCPGuard(
+	cfg *config.Config,
+	logger logr.Logger,
+	eventBus *events.Bus,
+) (*guard.Guard, error) {
+	dg := &guard.Guard{
+		AlertInterval: time.Duration(cfg.Dhcp.Guard.AlertIntervalSec) * time.Second,
+		Log:           logger.WithName("dhcp-guard"),
+		Events:        eventBus,
+	}
+	for _, s := range cfg.Dhcp.Guard.AllowedServers {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed DHCP server %q: %w", s, err)
+		}
+		dg.Allowed = append(dg.Allowed, addr.Unmap())
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list interface addresses: %w", err)
+	}
+	for _, a := range addrs {
+		if ipNet, ok := a.(*net.IPNet); ok {
+			if addr, ok := netip.AddrFromSlice(ipNet.IP); ok {
+				dg.Ours = append(dg.Ours, addr.Unmap())
+			}
+		}
+	}
+	if addr, err := netip.ParseAddr(cfg.Dhcp.Address); err == nil && !addr.IsUnspecified() {
+		dg.Ours = append(dg.Ours, addr.Unmap())
+	}
+
+	return dg, nil
+}
+
+// serveDHCPGuard reads the DHCP replies sent to clients on the DHCP interface
+// until ctx is done.
+func serveDHCPGuard(ctx context.Context, cfg *config.Config, dg *guard.Guard) error {
+	conn, err := server4.NewIPv4UDPConn(
+		cfg.Dhcp.Interface,
+		&net.UDPAddr{IP: net.IPv4zero, Port: dhcpv4.ClientPort},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create DHCP guard connection: %w", err)
+	}
+
+	return dg.Listen(ctx, conn)
+}
+
+// serveDHCPv6 runs the DHCPv6 server until ctx is done.
+func serveDHCPv6(
+	ctx context.Context,
+	cfg *config.Config,
+	logger logr.Logger,
+	dh dhcpServer.Handler6,
+) error {
+	addr, err := netip.ParseAddr(cfg.DHCPv6.Address)
+	if err != nil || !addr.Is6() {
+		return fmt.Errorf("invalid DHCPv6 bind address %q", cfg.DHCPv6.Address)
+	}
+
+	ds, err := dhcpServer.NewServer6(
+		dhcpv6Interface(cfg),
+		net.UDPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(cfg.DHCPv6.Port))),
+		dh,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create DHCPv6 connection: %w", err)
+	}
+	ds.Logger = logger
+	defer ds.Close()
+
+	return ds.Serve(ctx)
+}
+
+// dhcpv6Interface returns the interface the DHCPv6 server listens on.
+func dhcpv6Interface(cfg *config.Config) string {
+	if cfg.DHCPv6.Interface != "" {
+		return cfg.DHCPv6.Interface
+	}
+
+	return cfg.Dhcp.Interface
+}
+
+// dhcpv6Netboot holds the netboot settings for DHCPv6 clients, which must be
+// given URLs on the global IPv6 address of the server.
+type dhcpv6Netboot struct {
+	duid       dhcpv6.DUID
+	tftp       netip.AddrPort
+	http       *url.URL
+	ipxeScript func(net.HardwareAddr) *url.URL
+}
+
+func newDHCPv6Netboot(c *config.Config, bootVerifier *bootauth.Verifier) (dhcpv6Netboot, error) {
+	duid, err := dhcpServer.DUID(dhcpv6Interface(c))
+	if err != nil {
+		return dhcpv6Netboot{}, fmt.Errorf("failed to get DHCPv6 server DUID: %w", err)
+	}
+	ip, err := c.ServerIPv6()
+	if err != nil {
+		return dhcpv6Netboot{}, fmt.Errorf("failed to get IPv6 address for DHCPv6: %w", err)
+	}
+	addr, _ := netip.AddrFromSlice(ip.To16())
+
+	binURL := c.Dhcp.IpxeBinaryUrl
+	binURL.Address = addr.String()
+
+	return dhcpv6Netboot{
+		duid: duid,
+		tftp: netip.AddrPortFrom(addr, uint16(c.Dhcp.TftpPort)),
+		http: binURL.GetUrl(),
+		ipxeScript: func(mac net.HardwareAddr) *url.URL {
+			return scriptURL(binURL, bootVerifier, mac)
+		},
+	}, nil
+}
+
+// scriptURL returns the URL of the iPXE script of mac on the HTTP server of u,
+// the route bootVerifier guards, with a boot token if tokens are enabled.
+func scriptURL(u config.IpxeUrl, bootVerifier *bootauth.Verifier, mac net.HardwareAddr) *url.URL {
+	return bootVerifier.SignURL(u.GetUrl("/v1/boot", mac.String(), "boot.ipxe"), mac)
+}
+
 // createDHCPHandler creates a DHCP handler with proper configuration.
 func createDHCPHandler(
 	cfg *config.Config,
 	logger logr.Logger,
-	backend backend.BackendReader,
+	svc *services,
 ) (dhcpServer.Handler, error) {
-	return dhcpHandler(cfg, context.Background(), logger, backend)
+	return dhcpHandler(cfg, context.Background(), logger, svc)
 }
 
 // dhcpHandler configures a DHCP proxy handler with network boot capabilities.
@@ -494,7 +2063,7 @@ func dhcpHandler(
 	c *config.Config,
 	_ context.Context,
 	log logr.Logger,
-	backend backend.BackendReader,
+	svc *services,
 ) (dhcpServer.Handler, error) {
 	pktIP, err := netip.ParseAddr(c.Dhcp.Address)
 	if err != nil {
@@ -510,47 +2079,115 @@ func dhcpHandler(
 		return nil, fmt.Errorf("invalid http ipxe binary url: %w", err)
 	}
 
+	bootFiles, err := dhcp.ParseBootFiles(c.Dhcp.BootFiles)
+	if err != nil {
+		return nil, err
+	}
+	bootStorm := createAdmission(c)
+
 	ipxeScript := func(d *dhcpv4.DHCPv4) *url.URL {
-		return c.Dhcp.IpxeBinaryUrl.GetUrl("/boot.ipxe")
+		return scriptURL(c.Dhcp.IpxeBinaryUrl, svc.bootVerifier, d.ClientHWAddr)
+	}
+
+	var v6 dhcpv6Netboot
+	if c.DHCPv6.Enabled {
+		if v6, err = newDHCPv6Netboot(c, svc.bootVerifier); err != nil {
+			return nil, err
+		}
 	}
 
 	var dh dhcpServer.Handler
 
 	if c.Dhcp.ProxyEnabled {
-		dh = &proxy.Handler{
-			Backend: backend,
+		proxyHandler := &proxy.Handler{
+			Backend: svc.readerBackend,
 			IPAddr:  pktIP,
 			Log:     log,
 			Netboot: proxy.Netboot{
-				IPXEBinServerTFTP: tftpIP,
-				IPXEBinServerHTTP: httpBinaryURL,
-				IPXEScriptURL:     ipxeScript,
-				Enabled:           true,
+				IPXEBinServerTFTP:  tftpIP,
+				IPXEBinServerHTTP:  httpBinaryURL,
+				IPXEScriptURL:      ipxeScript,
+				IPXEBinServerTFTP6: v6.tftp,
+				IPXEBinServerHTTP6: v6.http,
+				IPXEScriptURL6:     v6.ipxeScript,
+				BootFiles:          bootFiles,
+				Enabled:            true,
 			},
-			OTELEnabled:      false, // Disabled since we removed OpenTelemetry
+			ServerDUID:       v6.duid,
+			OTELEnabled:      c.Otel.Enabled,
 			AutoProxyEnabled: true,
+			BootFlows:        svc.bootFlows,
+		}
+		if svc.bootTracker != nil {
+			proxyHandler.BootTracker = svc.bootTracker
 		}
+		if svc.flow != nil {
+			proxyHandler.BootFlow = svc.flow
+		}
+		if svc.hostStore != nil {
+			proxyHandler.MachineIDs = svc.hostStore
+			proxyHandler.Clients = svc.hostStore
+			proxyHandler.NetbootGate = svc.hostStore
+			proxyHandler.Identities = svc.hostStore
+		}
+		if svc.dhcpStats != nil {
+			proxyHandler.Stats = svc.dhcpStats
+		}
+		if bootStorm != nil {
+			proxyHandler.Admission = bootStorm
+		}
+
+		dh = proxyHandler
 	} else {
 		leaseBackend, err := lease.NewLeaseManager(
 			log,
 			filepath.Join(c.Dnsmasq.RootDirectory, "dnsmasq.leases"),
+			c.FileWatch.WatchOptions(),
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create lease manager: %w", err)
 		}
 		// Use reservation handler with lease management
 		reservationHandler := &reservation.Handler{
-			Backend:      backend,
+			Backend:      svc.readerBackend,
 			LeaseBackend: leaseBackend,
 			IPAddr:       pktIP,
 			Log:          log,
 			Netboot: reservation.Netboot{
-				IPXEBinServerTFTP: tftpIP,
-				IPXEBinServerHTTP: httpBinaryURL,
-				IPXEScriptURL:     ipxeScript,
-				Enabled:           true,
+				IPXEBinServerTFTP:  tftpIP,
+				IPXEBinServerHTTP:  httpBinaryURL,
+				IPXEScriptURL:      ipxeScript,
+				IPXEBinServerTFTP6: v6.tftp,
+				IPXEBinServerHTTP6: v6.http,
+				IPXEScriptURL6:     v6.ipxeScript,
+				SignURL:            svc.bootVerifier.SignURL,
+				BootFiles:          bootFiles,
+				Enabled:            true,
 			},
-			OTELEnabled: false, // Disabled since we removed OpenTelemetry
+			ServerDUID:       v6.duid,
+			OTELEnabled:      c.Otel.Enabled,
+			BootFlows:        svc.bootFlows,
+			ReservationsOnly: c.Dhcp.ReservationsOnly,
+			Leases:           svc.leases,
+		}
+		if svc.bootTracker != nil {
+			reservationHandler.BootTracker = svc.bootTracker
+		}
+		if svc.flow != nil {
+			reservationHandler.BootFlow = svc.flow
+		}
+		if svc.hostStore != nil {
+			reservationHandler.MachineIDs = svc.hostStore
+			reservationHandler.Clients = svc.hostStore
+			reservationHandler.NetbootGate = svc.hostStore
+			reservationHandler.Identities = svc.hostStore
+			reservationHandler.HostOptions = svc.hostStore
+		}
+		if svc.dhcpStats != nil {
+			reservationHandler.Stats = svc.dhcpStats
+		}
+		if bootStorm != nil {
+			reservationHandler.Admission = bootStorm
 		}
 
 		dh = reservationHandler
@@ -558,6 +2195,21 @@ func dhcpHandler(
 	return dh, nil
 }
 
+// createAdmission returns the controller that staggers netboot offers, or nil
+// if boot storm scheduling is disabled.
+func createAdmission(cfg *config.Config) *admission.Controller {
+	if !cfg.BootStorm.Enabled {
+		return nil
+	}
+	return &admission.Controller{
+		Rate:      cfg.BootStorm.OffersPerSec,
+		Burst:     cfg.BootStorm.Burst,
+		MaxWait:   time.Duration(cfg.BootStorm.MaxWaitMs) * time.Millisecond,
+		MaxJitter: time.Duration(cfg.BootStorm.JitterMs) * time.Millisecond,
+		Log:       cfg.Slog().With("component", "admission"),
+	}
+}
+
 // startIronicSupervisor configures and starts the Ironic process supervisor.
 func startIronicSupervisor(
 	ctx context.Context,
@@ -566,9 +2218,7 @@ func startIronicSupervisor(
 	logger logr.Logger,
 ) error {
 	// Create structured logger for Ironic supervisor
-	slogger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-		Level: slog.LevelInfo,
-	}))
+	slogger := cfg.Slog()
 
 	httpUrl := cfg.Ironic.Url
 	if cfg.Ironic.PublicEndpoint != "" {
@@ -0,0 +1,179 @@
+// Package adminauth authenticates operators of the admin API.
+//
+// Authentication is delegated to Providers; the first provider that finds
+// credentials on a request decides who the caller is. Every principal has a
+// Role, and viewers may only issue read requests. The Redfish emulation is
+// only identified, for its actions that require an admin.
+package adminauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// Role is what an authenticated principal may do.
+type Role string
+
+const (
+	// RoleViewer may read but not change anything.
+	RoleViewer Role = "viewer"
+	// RoleAdmin has full access.
+	RoleAdmin Role = "admin"
+)
+
+// ParseRole parses a role name. The empty string is no role.
+func ParseRole(s string) (Role, error) {
+	switch Role(s) {
+	case "", RoleViewer, RoleAdmin:
+		return Role(s), nil
+	default:
+		return "", fmt.Errorf("unknown role %q", s)
+	}
+}
+
+// allows reports whether r may issue a request with method.
+func (r Role) allows(method string) bool {
+	switch r {
+	case RoleAdmin:
+		return true
+	case RoleViewer:
+		return method == http.MethodGet || method == http.MethodHead
+	default:
+		return false
+	}
+}
+
+var (
+	// ErrNoCredentials is returned by a Provider when a request carries no
+	// credentials it understands.
+	ErrNoCredentials = errors.New("no credentials")
+	// ErrInvalidCredentials is returned by a Provider when a request carries
+	// credentials that fail validation.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Principal is an authenticated caller.
+type Principal struct {
+	// Provider is the name of the Provider that authenticated the caller.
+	Provider string   `json:"provider"`
+	Subject  string   `json:"subject"`
+	Name     string   `json:"name,omitempty"`
+	Groups   []string `json:"groups,omitempty"`
+	Role     Role     `json:"role"`
+}
+
+// Provider authenticates requests.
+type Provider interface {
+	// Name identifies the provider in logs and principals.
+	Name() string
+	// Authenticate returns the caller of r. It returns ErrNoCredentials when
+	// r carries no credentials for this provider.
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// Authenticator guards handlers with a set of Providers. A nil Authenticator
+// lets every request through.
+type Authenticator struct {
+	Providers []Provider
+	Log       *slog.Logger
+}
+
+// Authenticate returns the caller of r as identified by the first provider
+// that finds credentials on it.
+func (a *Authenticator) Authenticate(r *http.Request) (*Principal, error) {
+	for _, p := range a.Providers {
+		principal, err := p.Authenticate(r)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", p.Name(), err)
+		}
+		principal.Provider = p.Name()
+		return principal, nil
+	}
+
+	return nil, ErrNoCredentials
+}
+
+// Middleware rejects requests to next from unauthenticated callers with 401
+// and requests the caller's role does not allow with 403. The principal is
+// available to next through FromContext.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	if a == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := a.Authenticate(r)
+		if err != nil {
+			if !errors.Is(err, ErrNoCredentials) {
+				a.Log.Info("Rejected admin request", "path", r.URL.Path, "error", err)
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metal-boot"`)
+			writeError(w, http.StatusUnauthorized, err)
+			return
+		}
+		if !principal.Role.allows(r.Method) {
+			a.Log.Info("Denied admin request",
+				"path", r.URL.Path,
+				"method", r.Method,
+				"subject", principal.Subject,
+				"role", principal.Role,
+			)
+			writeError(w, http.StatusForbidden,
+				fmt.Errorf("role %q may not %s %s", principal.Role, r.Method, r.URL.Path))
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+	})
+}
+
+// Identify makes the caller of requests to next available through
+// FromContext when the request carries valid credentials, and lets every
+// request through. Handlers check the principal where they need one.
+func (a *Authenticator) Identify(next http.Handler) http.Handler {
+	if a == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := a.Authenticate(r)
+		if err != nil {
+			if !errors.Is(err, ErrNoCredentials) {
+				a.Log.Info("Ignored invalid credentials", "path", r.URL.Path, "error", err)
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+	})
+}
+
+// writeError writes err in the admin API error format.
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{Error: err.Error()})
+}
+
+type contextKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying p.
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// FromContext returns the principal carried by ctx, or nil.
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(contextKey{}).(*Principal)
+	return p
+}
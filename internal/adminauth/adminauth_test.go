@@ -0,0 +1,321 @@
+package adminauth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testIssuer is an OpenID provider serving discovery, keys and a token
+// endpoint that hands out idToken for any code.
+type testIssuer struct {
+	*httptest.Server
+	rsaKey  *rsa.PrivateKey
+	ecKey   *ecdsa.PrivateKey
+	idToken string
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss := &testIssuer{rsaKey: rsaKey, ecKey: ecKey}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, _ *http.Request) {
+		json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                iss.URL,
+			AuthorizationEndpoint: iss.URL + "/authorize",
+			TokenEndpoint:         iss.URL + "/token",
+			JWKSURI:               iss.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, _ *http.Request) {
+		b64 := base64.RawURLEncoding.EncodeToString
+		json.NewEncoder(w).Encode(map[string][]jsonWebKey{"keys": {
+			{
+				Kty: "RSA", Kid: "rsa", Use: "sig",
+				N: b64(rsaKey.N.Bytes()), E: b64(big.NewInt(int64(rsaKey.E)).Bytes()),
+			},
+			{
+				Kty: "EC", Kid: "ec", Crv: "P-256",
+				X: b64(ecKey.X.FillBytes(make([]byte, 32))), Y: b64(ecKey.Y.FillBytes(make([]byte, 32))),
+			},
+		}})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("client_secret") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": iss.idToken})
+	})
+	iss.Server = httptest.NewServer(mux)
+	t.Cleanup(iss.Close)
+
+	return iss
+}
+
+// sign returns a token with claims signed by the RSA or EC key.
+func (iss *testIssuer) sign(t *testing.T, kid string, claims map[string]any) string {
+	t.Helper()
+	alg := map[string]string{"rsa": "RS256", "ec": "ES256"}[kid]
+	header, _ := json.Marshal(jwtHeader{Alg: alg, Kid: kid})
+	payload, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." +
+		base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(input))
+
+	var sig []byte
+	if kid == "ec" {
+		r, s, err := ecdsa.Sign(rand.Reader, iss.ecKey, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	} else {
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, iss.rsaKey, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func (iss *testIssuer) claims(groups ...string) map[string]any {
+	return map[string]any{
+		"iss":                iss.URL,
+		"aud":                "metal-boot",
+		"sub":                "1234",
+		"preferred_username": "operator",
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"groups":             groups,
+	}
+}
+
+func newTestOIDC(iss *testIssuer) *OIDC {
+	return &OIDC{
+		Issuer:       iss.URL,
+		ClientID:     "metal-boot",
+		ClientSecret: "secret",
+		RedirectURL:  "https://metal-boot.example.com" + CallbackPath,
+		Roles:        map[string]Role{"lab-admins": RoleAdmin, "lab-users": RoleViewer},
+		Log:          slog.New(slog.DiscardHandler),
+	}
+}
+
+func TestOIDCAuthenticate(t *testing.T) {
+	iss := newTestIssuer(t)
+	o := newTestOIDC(iss)
+
+	expired := iss.claims("lab-admins")
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	otherAudience := iss.claims("lab-admins")
+	otherAudience["aud"] = []string{"someone-else"}
+	tampered := iss.sign(t, "rsa", iss.claims("lab-users"))
+	tampered = tampered[:strings.LastIndex(tampered, ".")] + ".AAAA"
+
+	tests := []struct {
+		name     string
+		token    string
+		wantRole Role
+		wantErr  error
+	}{
+		{name: "admin", token: iss.sign(t, "rsa", iss.claims("lab-admins")), wantRole: RoleAdmin},
+		{name: "viewer ec", token: iss.sign(t, "ec", iss.claims("lab-users")), wantRole: RoleViewer},
+		{name: "highest role", token: iss.sign(t, "rsa", iss.claims("lab-users", "lab-admins")), wantRole: RoleAdmin},
+		{name: "unmapped", token: iss.sign(t, "rsa", iss.claims("guests"))},
+		{name: "expired", token: iss.sign(t, "rsa", expired), wantErr: ErrInvalidCredentials},
+		{name: "audience", token: iss.sign(t, "rsa", otherAudience), wantErr: ErrInvalidCredentials},
+		{name: "signature", token: tampered, wantErr: ErrInvalidCredentials},
+		{name: "none", wantErr: ErrNoCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/whoami", nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			p, err := o.Authenticate(req)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Authenticate() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate() error = %v", err)
+			}
+			if p.Role != tt.wantRole || p.Name != "operator" {
+				t.Errorf("principal = %+v, want role %q", p, tt.wantRole)
+			}
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	iss := newTestIssuer(t)
+	a := &Authenticator{
+		Providers: []Provider{newTestOIDC(iss)},
+		Log:       slog.New(slog.DiscardHandler),
+	}
+	h := a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if FromContext(r.Context()) == nil {
+			t.Error("principal missing from context")
+		}
+	}))
+
+	viewer := iss.sign(t, "rsa", iss.claims("lab-users"))
+	tests := []struct {
+		name   string
+		method string
+		token  string
+		want   int
+	}{
+		{name: "anonymous", method: http.MethodGet, want: http.StatusUnauthorized},
+		{name: "viewer read", method: http.MethodGet, token: viewer, want: http.StatusOK},
+		{name: "viewer write", method: http.MethodPut, token: viewer, want: http.StatusForbidden},
+		{name: "no role", method: http.MethodGet, token: iss.sign(t, "rsa", iss.claims()), want: http.StatusForbidden},
+		{name: "admin write", method: http.MethodDelete, token: iss.sign(t, "ec", iss.claims("lab-admins")), want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/v1/systems/aa:bb:cc:dd:ee:ff/kernel-args", nil)
+			if tt.token != "" {
+				req.AddCookie(&http.Cookie{Name: SessionCookie, Value: tt.token})
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestIdentify(t *testing.T) {
+	iss := newTestIssuer(t)
+	a := &Authenticator{
+		Providers: []Provider{newTestOIDC(iss)},
+		Log:       slog.New(slog.DiscardHandler),
+	}
+	var got *Principal
+	h := a.Identify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = FromContext(r.Context())
+	}))
+
+	tests := []struct {
+		name     string
+		token    string
+		wantRole Role
+	}{
+		{name: "anonymous"},
+		{name: "invalid", token: "not-a-token"},
+		{name: "admin", token: iss.sign(t, "ec", iss.claims("lab-admins")), wantRole: RoleAdmin},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = nil
+			req := httptest.NewRequest(http.MethodPost, "/redfish/v1/Managers/metal-boot", nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Errorf("status = %d, want the request let through", rec.Code)
+			}
+			var role Role
+			if got != nil {
+				role = got.Role
+			}
+			if role != tt.wantRole {
+				t.Errorf("principal role = %q, want %q", role, tt.wantRole)
+			}
+		})
+	}
+}
+
+func TestLoginFlow(t *testing.T) {
+	iss := newTestIssuer(t)
+	iss.idToken = iss.sign(t, "rsa", iss.claims("lab-admins"))
+	h := newTestOIDC(iss).Handler()
+
+	req := httptest.NewRequest(http.MethodGet, LoginPath+"?redirect=/api/v1/whoami", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login status = %d, want %d", rec.Code, http.StatusFound)
+	}
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := location.Query().Get("state")
+	if !strings.HasPrefix(location.String(), iss.URL+"/authorize?") || state == "" {
+		t.Fatalf("login redirect = %s, want the authorization endpoint", location)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, CallbackPath+"?code=abc&state="+state, nil)
+	for _, c := range rec.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/api/v1/whoami" {
+		t.Fatalf("callback = %d %s, want redirect to /api/v1/whoami: %s",
+			rec.Code, rec.Header().Get("Location"), rec.Body.String())
+	}
+	var session string
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == SessionCookie {
+			session = c.Value
+		}
+	}
+	if session != iss.idToken {
+		t.Errorf("session cookie = %q, want the ID token", session)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, CallbackPath+"?code=abc&state=forged", nil)
+	req.AddCookie(&http.Cookie{Name: stateCookie, Value: state + "|/"})
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("forged state status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestLocalRedirect(t *testing.T) {
+	for target, want := range map[string]string{
+		"/api/v1/whoami":      "/api/v1/whoami",
+		"":                    "/",
+		"//evil.example.com":  "/",
+		"/\\evil.example.com": "/",
+		"https://evil.com/":   "/",
+	} {
+		if got := localRedirect(target); got != want {
+			t.Errorf("localRedirect(%q) = %q, want %q", target, got, want)
+		}
+	}
+}
@@ -0,0 +1,453 @@
+package adminauth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Paths served by OIDC.Handler.
+const (
+	LoginPath    = "/auth/login"
+	CallbackPath = "/auth/callback"
+	LogoutPath   = "/auth/logout"
+)
+
+const (
+	// SessionCookie holds the ID token of a browser session.
+	SessionCookie = "metal_boot_session"
+	stateCookie   = "metal_boot_oidc_state"
+
+	// clockSkew is the leeway allowed on token timestamps.
+	clockSkew = time.Minute
+	// keyRefreshInterval limits how often the key set is refetched when a
+	// token is signed with an unknown key.
+	keyRefreshInterval = time.Minute
+)
+
+// OIDC authenticates callers with ID tokens of an OpenID Connect provider,
+// sent as a bearer token by API clients or kept in a session cookie by
+// browsers that logged in through Handler.
+type OIDC struct {
+	// Issuer is the issuer URL; its discovery document is fetched from
+	// Issuer/.well-known/openid-configuration.
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the absolute URL of CallbackPath registered with the
+	// provider. Browser login is disabled when it is empty.
+	RedirectURL string
+	// Scopes are requested in addition to "openid".
+	Scopes []string
+	// GroupsClaim names the claim listing the caller's groups. Zero means
+	// "groups".
+	GroupsClaim string
+	// Roles maps groups to roles. A caller gets the highest role of its
+	// groups, or DefaultRole when none of them is mapped.
+	Roles       map[string]Role
+	DefaultRole Role
+	HTTPClient  *http.Client
+	Log         *slog.Logger
+
+	mu          sync.Mutex
+	discovery   *oidcDiscovery
+	keys        map[string]crypto.PublicKey
+	keysFetched time.Time
+	now         func() time.Time
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Name implements Provider.
+func (o *OIDC) Name() string {
+	return "oidc"
+}
+
+func (o *OIDC) clock() time.Time {
+	if o.now != nil {
+		return o.now()
+	}
+	return time.Now()
+}
+
+func (o *OIDC) client() *http.Client {
+	if o.HTTPClient != nil {
+		return o.HTTPClient
+	}
+	return &http.Client{Timeout: 10 * time.Second}
+}
+
+// Authenticate implements Provider. The token is taken from a bearer
+// Authorization header or, failing that, the session cookie.
+func (o *OIDC) Authenticate(r *http.Request) (*Principal, error) {
+	var token string
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		scheme, value, ok := strings.Cut(auth, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			return nil, ErrNoCredentials
+		}
+		token = strings.TrimSpace(value)
+	} else if cookie, err := r.Cookie(SessionCookie); err == nil {
+		token = cookie.Value
+	}
+	if token == "" {
+		return nil, ErrNoCredentials
+	}
+
+	claims, err := o.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return o.principal(claims), nil
+}
+
+// Verify checks the signature, issuer, audience and lifetime of an ID token
+// and returns its claims.
+func (o *OIDC) Verify(token string) (map[string]any, error) {
+	disc, err := o.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := verifyJWT(token, o.key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCredentials, err)
+	}
+
+	if iss, _ := claims["iss"].(string); iss != disc.Issuer {
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidCredentials, iss)
+	}
+	if !audienceContains(claims["aud"], o.ClientID) {
+		return nil, fmt.Errorf("%w: token not issued for %q", ErrInvalidCredentials, o.ClientID)
+	}
+	now := o.clock()
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(clockSkew)) {
+		return nil, fmt.Errorf("%w: token expired", ErrInvalidCredentials)
+	}
+	nbf, ok := claims["nbf"].(float64)
+	if ok && now.Add(clockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return nil, fmt.Errorf("%w: token not yet valid", ErrInvalidCredentials)
+	}
+
+	return claims, nil
+}
+
+func audienceContains(aud any, clientID string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == clientID
+	case []any:
+		for _, v := range a {
+			if s, _ := v.(string); s == clientID {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// principal maps verified claims to a principal and its role.
+func (o *OIDC) principal(claims map[string]any) *Principal {
+	p := &Principal{Role: o.DefaultRole}
+	p.Subject, _ = claims["sub"].(string)
+	for _, claim := range []string{"preferred_username", "email", "name"} {
+		if name, _ := claims[claim].(string); name != "" {
+			p.Name = name
+			break
+		}
+	}
+
+	groupsClaim := o.GroupsClaim
+	if groupsClaim == "" {
+		groupsClaim = "groups"
+	}
+	switch groups := claims[groupsClaim].(type) {
+	case string:
+		p.Groups = []string{groups}
+	case []any:
+		for _, g := range groups {
+			if s, ok := g.(string); ok {
+				p.Groups = append(p.Groups, s)
+			}
+		}
+	}
+
+	var mapped Role
+	for _, g := range p.Groups {
+		switch o.Roles[g] {
+		case RoleAdmin:
+			mapped = RoleAdmin
+		case RoleViewer:
+			if mapped == "" {
+				mapped = RoleViewer
+			}
+		}
+	}
+	if mapped != "" {
+		p.Role = mapped
+	}
+
+	return p
+}
+
+// discover returns the provider's discovery document, fetching it once.
+func (o *OIDC) discover() (*oidcDiscovery, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.discovery != nil {
+		return o.discovery, nil
+	}
+
+	var disc oidcDiscovery
+	wellKnown := strings.TrimSuffix(o.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := o.getJSON(wellKnown, &disc); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if disc.Issuer != o.Issuer {
+		return nil, fmt.Errorf(
+			"oidc discovery: issuer %q does not match %q", disc.Issuer, o.Issuer,
+		)
+	}
+	o.discovery = &disc
+
+	return o.discovery, nil
+}
+
+// key returns the signing key kid, refetching the key set when kid is not
+// known so that key rotation is picked up.
+func (o *OIDC) key(kid string) (crypto.PublicKey, error) {
+	disc, err := o.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if key, ok := o.lookupKey(kid); ok {
+		return key, nil
+	}
+	if o.keys != nil && o.clock().Sub(o.keysFetched) < keyRefreshInterval {
+		return nil, errUnknownKey
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := o.getJSON(disc.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("oidc key set: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			o.Log.Debug("Skipping oidc signing key", "kid", k.Kid, "error", err)
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+	o.keys, o.keysFetched = keys, o.clock()
+
+	if key, ok := o.lookupKey(kid); ok {
+		return key, nil
+	}
+
+	return nil, errUnknownKey
+}
+
+// lookupKey finds kid in the cached key set. A token without a key ID is
+// accepted when the set holds a single key. Callers must hold o.mu.
+func (o *OIDC) lookupKey(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(o.keys) == 1 {
+		for _, key := range o.keys {
+			return key, true
+		}
+	}
+	key, ok := o.keys[kid]
+
+	return key, ok
+}
+
+func (o *OIDC) getJSON(u string, v any) error {
+	resp, err := o.client().Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Handler serves the browser login flow: LoginPath redirects to the
+// provider, CallbackPath exchanges the authorization code for an ID token and
+// stores it in the session cookie, and LogoutPath clears the cookie.
+func (o *OIDC) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+LoginPath, o.login)
+	mux.HandleFunc("GET "+CallbackPath, o.callback)
+	mux.HandleFunc("GET "+LogoutPath, o.logout)
+	mux.HandleFunc("POST "+LogoutPath, o.logout)
+
+	return mux
+}
+
+func (o *OIDC) login(w http.ResponseWriter, r *http.Request) {
+	if o.RedirectURL == "" {
+		writeError(w, http.StatusNotFound, errors.New("browser login is not configured"))
+		return
+	}
+	disc, err := o.discover()
+	if err != nil {
+		o.Log.Error("OIDC login failed", "error", err)
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	state := hex.EncodeToString(nonce)
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookie,
+		Value:    state + "|" + localRedirect(r.URL.Query().Get("redirect")),
+		Path:     CallbackPath,
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	q := url.Values{
+		"response_type": {"code"},
+		"client_id":     {o.ClientID},
+		"redirect_uri":  {o.RedirectURL},
+		"scope":         {strings.Join(append([]string{"openid"}, o.Scopes...), " ")},
+		"state":         {state},
+	}
+	http.Redirect(w, r, disc.AuthorizationEndpoint+"?"+q.Encode(), http.StatusFound)
+}
+
+func (o *OIDC) callback(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(stateCookie)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("missing login state"))
+		return
+	}
+	state, redirect, _ := strings.Cut(cookie.Value, "|")
+	http.SetCookie(w, &http.Cookie{Name: stateCookie, Path: CallbackPath, MaxAge: -1})
+
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		writeError(w, http.StatusUnauthorized, fmt.Errorf("login failed: %s", e))
+		return
+	}
+	if q.Get("state") != state {
+		writeError(w, http.StatusBadRequest, errors.New("login state mismatch"))
+		return
+	}
+
+	token, err := o.exchange(q.Get("code"))
+	if err != nil {
+		o.Log.Error("OIDC code exchange failed", "error", err)
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	claims, err := o.Verify(token)
+	if err != nil {
+		o.Log.Info("Rejected OIDC login", "error", err)
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	p := o.principal(claims)
+	o.Log.Info("OIDC login", "subject", p.Subject, "name", p.Name, "role", p.Role)
+	exp, _ := claims["exp"].(float64)
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  time.Unix(int64(exp), 0),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+// exchange trades an authorization code for an ID token.
+func (o *OIDC) exchange(code string) (string, error) {
+	disc, err := o.discover()
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := o.client().PostForm(disc.TokenEndpoint, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {o.RedirectURL},
+		"client_id":     {o.ClientID},
+		"client_secret": {o.ClientSecret},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint: %s", resp.Status)
+	}
+	var body struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("token endpoint: %w", err)
+	}
+	if body.IDToken == "" {
+		return "", errors.New("token endpoint returned no id_token")
+	}
+
+	return body.IDToken, nil
+}
+
+func (o *OIDC) logout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{Name: SessionCookie, Path: "/", MaxAge: -1})
+	http.Redirect(w, r, localRedirect(r.URL.Query().Get("redirect")), http.StatusFound)
+}
+
+// localRedirect returns target if it is a path on this server, "/" otherwise,
+// so login and logout cannot be used as open redirects.
+func localRedirect(target string) string {
+	if !strings.HasPrefix(target, "/") ||
+		strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\") {
+		return "/"
+	}
+
+	return target
+}
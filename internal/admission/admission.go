@@ -0,0 +1,139 @@
+// Package admission staggers netboot offers so that a rack of nodes powered on
+// at once does not fetch its iPXE binaries, kernels and images at the same
+// moment, overwhelming TFTP, HTTP image serving and the upstream network.
+//
+// Netboot DHCPDISCOVERs take a token from a bucket refilled at Rate per
+// second. A DISCOVER that has to wait for a token is held for up to MaxWait
+// before it is answered; if the wait would be longer it is not answered at
+// all, and the client's own retransmission competes again a few seconds
+// later. Admitted offers are additionally delayed by a random jitter so that
+// nodes admitted together do not fetch their binaries in lockstep.
+package admission
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/metal3-community/metal-boot/internal/metric"
+)
+
+// Controller admits netboot offers. The zero value admits every offer at
+// once, as does a nil Controller.
+type Controller struct {
+	// Rate is the number of netboot offers admitted per second. Zero means
+	// unlimited.
+	Rate float64
+	// Burst is the number of offers admitted at once before Rate applies.
+	// Zero means 1.
+	Burst int
+	// MaxWait bounds the time an offer waits for a token. Offers that would
+	// wait longer are deferred to the client's next DHCPDISCOVER.
+	MaxWait time.Duration
+	// MaxJitter delays every admitted offer by a random duration of up to
+	// MaxJitter.
+	MaxJitter time.Duration
+	// Log is used to log deferred offers.
+	Log *slog.Logger
+
+	mu sync.Mutex
+	// tokens is the content of the bucket at last. It is negative while
+	// admitted offers wait for tokens that are yet to be added.
+	tokens float64
+	last   time.Time
+
+	// now and sleep are replaced in tests.
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// Admit waits until mac may be offered netboot options and reports whether
+// it may. It returns false without waiting if the wait would exceed MaxWait,
+// and false if ctx is done first.
+func (c *Controller) Admit(ctx context.Context, mac net.HardwareAddr) bool {
+	if c == nil {
+		return true
+	}
+
+	wait, ok := c.reserve()
+	if !ok {
+		metric.AdmissionDeferred.Inc()
+		if c.Log != nil {
+			c.Log.Info("deferring netboot offer during boot storm", "mac", mac.String())
+		}
+		return false
+	}
+	if c.MaxJitter > 0 {
+		wait += rand.N(c.MaxJitter)
+	}
+	metric.AdmissionDelay.Observe(wait.Seconds())
+	if wait <= 0 {
+		metric.AdmissionAdmitted.Inc()
+		return true
+	}
+
+	if err := c.wait(ctx, wait); err != nil {
+		metric.AdmissionDeferred.Inc()
+		return false
+	}
+	metric.AdmissionAdmitted.Inc()
+
+	return true
+}
+
+// reserve takes a token and returns how long to wait until it is available,
+// or false if that is longer than MaxWait, in which case no token is taken.
+func (c *Controller) reserve() (time.Duration, bool) {
+	if c.Rate <= 0 {
+		return 0, true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	burst := float64(max(c.Burst, 1))
+	now := c.time()
+	if c.last.IsZero() {
+		c.tokens = burst
+	} else {
+		c.tokens = min(c.tokens+now.Sub(c.last).Seconds()*c.Rate, burst)
+	}
+	c.last = now
+
+	var wait time.Duration
+	if c.tokens < 1 {
+		wait = time.Duration((1 - c.tokens) / c.Rate * float64(time.Second))
+	}
+	if wait > c.MaxWait {
+		return 0, false
+	}
+	c.tokens--
+
+	return wait, true
+}
+
+func (c *Controller) time() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+
+	return time.Now()
+}
+
+func (c *Controller) wait(ctx context.Context, d time.Duration) error {
+	if c.sleep != nil {
+		return c.sleep(ctx, d)
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
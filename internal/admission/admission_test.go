@@ -0,0 +1,90 @@
+package admission
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock that stands still and records sleeps.
+type fakeClock struct {
+	now    time.Time
+	sleeps []time.Duration
+}
+
+func (c *fakeClock) sleep(_ context.Context, d time.Duration) error {
+	c.sleeps = append(c.sleeps, d)
+	return nil
+}
+
+func newController(clock *fakeClock) *Controller {
+	return &Controller{
+		Rate:    2,
+		Burst:   2,
+		MaxWait: time.Second,
+		now:     func() time.Time { return clock.now },
+		sleep:   clock.sleep,
+	}
+}
+
+func TestAdmit(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	c := newController(clock)
+	mac := net.HardwareAddr{0xd8, 0x3a, 0xdd, 0, 0, 1}
+	ctx := context.Background()
+
+	// The burst is admitted at once, the next two wait for the refill at
+	// 2 tokens per second and the fifth would wait longer than a second.
+	for n, want := range []bool{true, true, true, true, false} {
+		if got := c.Admit(ctx, mac); got != want {
+			t.Errorf("Admit() #%d = %v, want %v", n, got, want)
+		}
+	}
+	want := []time.Duration{500 * time.Millisecond, time.Second}
+	if len(clock.sleeps) != len(want) || clock.sleeps[0] != want[0] || clock.sleeps[1] != want[1] {
+		t.Errorf("waits = %v, want %v", clock.sleeps, want)
+	}
+
+	// Once the reserved tokens are paid off, offers are admitted again.
+	clock.now = clock.now.Add(2 * time.Second)
+	if !c.Admit(ctx, mac) {
+		t.Error("Admit() after the refill = false, want true")
+	}
+}
+
+func TestAdmitJitter(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	c := newController(clock)
+	c.Rate = 0
+	c.MaxJitter = 100 * time.Millisecond
+
+	for range 10 {
+		if !c.Admit(context.Background(), nil) {
+			t.Fatal("Admit() without a rate = false, want true")
+		}
+	}
+	for _, d := range clock.sleeps {
+		if d < 0 || d >= c.MaxJitter {
+			t.Errorf("jitter %v outside [0, %v)", d, c.MaxJitter)
+		}
+	}
+}
+
+func TestAdmitCancelled(t *testing.T) {
+	c := &Controller{Rate: 1, MaxWait: time.Minute}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if !c.Admit(ctx, nil) {
+		t.Error("Admit() of the first offer = false, want true")
+	}
+	if c.Admit(ctx, nil) {
+		t.Error("Admit() with a cancelled context = true, want false")
+	}
+
+	var nilController *Controller
+	if !nilController.Admit(ctx, nil) {
+		t.Error("Admit() of a nil Controller = false, want true")
+	}
+}
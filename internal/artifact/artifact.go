@@ -0,0 +1,275 @@
+// Package artifact derives boot artifacts from the files of the static root
+// on demand, in place of artifacts that operators build by hand: initrds that
+// are decompressed and concatenated, given a cpio overlay carrying the
+// configuration of one host, and signed again.
+//
+// A Pipeline names the source files and the Steps run over them. Steps are
+// looked up by type in a registry, so that new transformations plug in with
+// Register. The output of a pipeline is cached below the cache directory of
+// the Server under a key covering its sources, its steps and, for pipelines
+// with steps that depend on the host, the host, so that it is only built
+// again when one of them changes.
+package artifact
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// SignatureSuffix is the suffix of the detached signature of an artifact.
+const SignatureSuffix = ".sig"
+
+// DefaultProfile names the pipelines serving hosts whose boot profile has
+// none of its own.
+const DefaultProfile = "default"
+
+var (
+	// ErrNotFound is returned for artifacts no pipeline produces.
+	ErrNotFound = errors.New("artifact not found")
+	// ErrUnknownStep is returned by NewStep for unregistered types.
+	ErrUnknownStep = errors.New("unknown pipeline step")
+)
+
+// Node is the host an artifact is built for.
+type Node struct {
+	MAC      string
+	Hostname string
+	IP       string
+	Arch     string
+	// Profile is the boot profile the pipeline was selected for.
+	Profile  string
+	Metadata map[string]string
+}
+
+// Step is one transformation of a pipeline.
+type Step interface {
+	// ID identifies the step and its settings in cache keys.
+	ID() string
+	// PerNode reports whether the output of the step depends on the node.
+	PerNode() bool
+	// Apply transforms the files in, which it must not modify, into the files
+	// it returns. New files are created in dir.
+	Apply(ctx context.Context, dir string, in []string, node Node) ([]string, error)
+}
+
+// StepOptions are the settings of a step. Each type uses those it needs.
+type StepOptions struct {
+	// Files are the files of an overlay.
+	Files []OverlayFile
+	// Signer signs artifacts for a sign step.
+	Signer Signer
+}
+
+// Signer makes detached signatures.
+type Signer interface {
+	Sign(content []byte) ([]byte, error)
+}
+
+// StepFactory creates a step from its options.
+type StepFactory func(opts StepOptions) (Step, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]StepFactory{}
+)
+
+// Register makes the steps of type typ available to NewStep, replacing any
+// registered before.
+func Register(typ string, f StepFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	registry[typ] = f
+}
+
+// StepTypes returns the registered step types, sorted.
+func StepTypes() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	types := make([]string, 0, len(registry))
+	for typ := range registry {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+
+	return types
+}
+
+// NewStep creates a step of type typ.
+func NewStep(typ string, opts StepOptions) (Step, error) {
+	registryMu.RLock()
+	f, ok := registry[typ]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w %q, want one of %v", ErrUnknownStep, typ, StepTypes())
+	}
+
+	return f(opts)
+}
+
+// Pipeline builds one artifact.
+type Pipeline struct {
+	// Name is the name the artifact is served under.
+	Name string
+	// Sources are the paths of the input files below the root of the
+	// Server, in order.
+	Sources []string
+	Steps   []Step
+}
+
+// perNode reports whether the output of p depends on the node.
+func (p *Pipeline) perNode() bool {
+	return slices.ContainsFunc(p.Steps, Step.PerNode)
+}
+
+// Server builds and caches the artifacts of the pipelines of boot profiles.
+type Server struct {
+	// Root is the directory the sources of pipelines are read from.
+	Root string
+	// CacheDir holds the built artifacts.
+	CacheDir string
+	// Profiles maps boot profiles to their pipelines. The pipelines of
+	// DefaultProfile serve the profiles without any.
+	Profiles map[string][]*Pipeline
+
+	group singleflight.Group
+}
+
+// Pipeline returns the pipeline of the artifact name for profile.
+func (s *Server) Pipeline(profile, name string) (*Pipeline, error) {
+	pipelines, ok := s.Profiles[profile]
+	if !ok {
+		pipelines = s.Profiles[DefaultProfile]
+	}
+	for _, p := range pipelines {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+}
+
+// Build returns the path of the artifact name of node, building it unless
+// it is cached. The detached signature of the artifact, if a step signed it,
+// is next to it with SignatureSuffix.
+func (s *Server) Build(ctx context.Context, name string, node Node) (string, error) {
+	p, err := s.Pipeline(node.Profile, name)
+	if err != nil {
+		return "", err
+	}
+	key, err := s.key(p, node)
+	if err != nil {
+		return "", err
+	}
+	out := filepath.Join(s.CacheDir, key+"-"+filepath.Base(p.Name))
+	if _, err := os.Stat(out); err == nil {
+		return out, nil
+	}
+
+	_, err, _ = s.group.Do(key, func() (any, error) {
+		if _, err := os.Stat(out); err == nil {
+			return nil, nil
+		}
+		return nil, s.build(ctx, p, node, out)
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to build %s: %w", name, err)
+	}
+
+	return out, nil
+}
+
+// build runs p for node, moving its output to out.
+func (s *Server) build(ctx context.Context, p *Pipeline, node Node, out string) error {
+	if err := os.MkdirAll(s.CacheDir, 0o755); err != nil {
+		return err
+	}
+	dir, err := os.MkdirTemp(s.CacheDir, "build-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	files := make([]string, len(p.Sources))
+	for i, src := range p.Sources {
+		files[i] = filepath.Join(s.Root, filepath.FromSlash(src))
+	}
+	for _, step := range p.Steps {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if files, err = step.Apply(ctx, dir, files, node); err != nil {
+			return fmt.Errorf("step %s: %w", step.ID(), err)
+		}
+	}
+	if len(files) != 1 {
+		return fmt.Errorf("pipeline produced %d files, want 1; add a concat step", len(files))
+	}
+
+	// The output is still a source when no step changed it.
+	result := files[0]
+	if filepath.Dir(result) != dir {
+		result = filepath.Join(dir, "result")
+		if err := copyFile(files[0], result); err != nil {
+			return err
+		}
+	}
+	if _, err := os.Stat(result + SignatureSuffix); err == nil {
+		if err := os.Rename(result+SignatureSuffix, out+SignatureSuffix); err != nil {
+			return err
+		}
+	}
+
+	return os.Rename(result, out)
+}
+
+// key identifies the output of p for node: its steps, the sources as they
+// are on disk and, if the output depends on it, the node.
+func (s *Server) key(p *Pipeline, node Node) (string, error) {
+	type source struct {
+		Path    string
+		Size    int64
+		ModTime int64
+	}
+	k := struct {
+		Name    string
+		Sources []source
+		Steps   []string
+		Node    *Node `json:",omitempty"`
+	}{Name: p.Name}
+
+	for _, src := range p.Sources {
+		info, err := os.Stat(filepath.Join(s.Root, filepath.FromSlash(src)))
+		if err != nil {
+			return "", fmt.Errorf("source of %s: %w", p.Name, err)
+		}
+		k.Sources = append(k.Sources, source{src, info.Size(), info.ModTime().UnixNano()})
+	}
+	for _, step := range p.Steps {
+		k.Steps = append(k.Steps, step.ID())
+	}
+	if p.perNode() {
+		k.Node = &node
+	}
+
+	b, err := json.Marshal(k)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+
+	return hex.EncodeToString(sum[:16]), nil
+}
@@ -0,0 +1,132 @@
+package artifact
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type fakeSigner struct{}
+
+func (fakeSigner) Sign(content []byte) ([]byte, error) {
+	return []byte("signed " + string(content[:4])), nil
+}
+
+func gzipped(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(s))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func newStep(t *testing.T, typ string, opts StepOptions) Step {
+	t.Helper()
+	step, err := NewStep(typ, opts)
+	if err != nil {
+		t.Fatalf("NewStep(%s) error = %v", typ, err)
+	}
+	return step
+}
+
+func TestBuild(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "base.gz"), gzipped(t, "base"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "extra"), []byte("extra"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	initrd := &Pipeline{
+		Name:    "initrd",
+		Sources: []string{"base.gz", "extra"},
+		Steps: []Step{
+			newStep(t, StepDecompress, StepOptions{}),
+			newStep(t, StepConcat, StepOptions{}),
+			newStep(t, StepOverlay, StepOptions{Files: []OverlayFile{{
+				Path:     "/etc/metal-boot/node.env",
+				Template: "MAC={{.MAC}}\nHOSTNAME={{.Hostname}}\n",
+			}}}),
+			newStep(t, StepSign, StepOptions{Signer: fakeSigner{}}),
+		},
+	}
+	s := &Server{
+		Root:     root,
+		CacheDir: t.TempDir(),
+		Profiles: map[string][]*Pipeline{
+			DefaultProfile: {initrd},
+			"inspector":    {{Name: "initrd", Sources: []string{"extra"}}},
+		},
+	}
+	ctx := context.Background()
+	node := Node{MAC: "aa:bb:cc:dd:ee:ff", Hostname: "node1", Profile: "config"}
+
+	out, err := s.Build(ctx, "initrd", node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(got, []byte("baseextra070701")) {
+		t.Errorf("artifact starts with %q, want the sources and an overlay", got[:min(len(got), 20)])
+	}
+	for _, want := range []string{"etc/metal-boot/node.env\x00", "MAC=aa:bb:cc:dd:ee:ff\nHOSTNAME=node1\n", "TRAILER!!!"} {
+		if !bytes.Contains(got, []byte(want)) {
+			t.Errorf("artifact does not contain %q", want)
+		}
+	}
+	if sig, err := os.ReadFile(out + SignatureSuffix); err != nil || string(sig) != "signed base" {
+		t.Errorf("signature = %q, %v", sig, err)
+	}
+
+	// The artifact is cached per node.
+	again, err := s.Build(ctx, "initrd", node)
+	if err != nil || again != out {
+		t.Errorf("second Build() = %s, %v, want the cached %s", again, err, out)
+	}
+	node.MAC = "aa:bb:cc:dd:ee:01"
+	other, err := s.Build(ctx, "initrd", node)
+	if err != nil || other == out {
+		t.Errorf("Build() of another node = %s, %v, want a new artifact", other, err)
+	}
+
+	// Profiles have pipelines of their own.
+	node.Profile = "inspector"
+	out, err = s.Build(ctx, "initrd", node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(out); string(got) != "extra" {
+		t.Errorf("inspector artifact = %q, want %q", got, "extra")
+	}
+
+	if _, err := s.Build(ctx, "kernel", node); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Build() of unknown artifact error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestNewStep(t *testing.T) {
+	if _, err := NewStep("encrypt", StepOptions{}); !errors.Is(err, ErrUnknownStep) {
+		t.Errorf("NewStep(encrypt) error = %v, want ErrUnknownStep", err)
+	}
+	if _, err := NewStep(StepSign, StepOptions{}); err == nil {
+		t.Error("NewStep(sign) without a signer succeeded")
+	}
+	if _, err := NewStep(StepOverlay, StepOptions{Files: []OverlayFile{{Path: "etc/x"}}}); err == nil {
+		t.Error("NewStep(overlay) with a relative path succeeded")
+	}
+	if got := strings.Join(StepTypes(), ","); got != "compress,concat,decompress,overlay,sign" {
+		t.Errorf("StepTypes() = %s", got)
+	}
+}
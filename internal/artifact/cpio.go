@@ -0,0 +1,60 @@
+package artifact
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+)
+
+// cpioWriter writes an archive in the "newc" format the kernel unpacks
+// initrds from.
+type cpioWriter struct {
+	buf bytes.Buffer
+	ino int
+}
+
+func newCPIOWriter() *cpioWriter {
+	return &cpioWriter{ino: 1}
+}
+
+// dir adds the directory name.
+func (w *cpioWriter) dir(name string) {
+	w.entry(name, 0o040000|0o755, 2, nil)
+}
+
+// file adds the regular file name.
+func (w *cpioWriter) file(name string, mode os.FileMode, data []byte) {
+	w.entry(name, 0o100000|uint32(mode.Perm()), 1, data)
+}
+
+// close adds the trailer and returns the archive.
+func (w *cpioWriter) close() []byte {
+	w.entry("TRAILER!!!", 0, 1, nil)
+
+	return w.buf.Bytes()
+}
+
+func (w *cpioWriter) entry(name string, mode uint32, nlink int, data []byte) {
+	ino := 0
+	if name != "TRAILER!!!" {
+		ino = w.ino
+		w.ino++
+	}
+	// The fields are ino, mode, uid, gid, nlink, mtime, filesize, devmajor,
+	// devminor, rdevmajor, rdevminor, namesize and check. The mtime is zero
+	// so that the archive only depends on its content.
+	fmt.Fprintf(&w.buf, "070701%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X",
+		ino, mode, 0, 0, nlink, 0, len(data), 0, 0, 0, 0, len(name)+1, 0)
+	w.buf.WriteString(name)
+	w.buf.WriteByte(0)
+	w.pad()
+	w.buf.Write(data)
+	w.pad()
+}
+
+// pad aligns the archive to four bytes.
+func (w *cpioWriter) pad() {
+	for w.buf.Len()%4 != 0 {
+		w.buf.WriteByte(0)
+	}
+}
@@ -0,0 +1,319 @@
+package artifact
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Step types registered by the package.
+const (
+	StepDecompress = "decompress"
+	StepCompress   = "compress"
+	StepConcat     = "concat"
+	StepOverlay    = "overlay"
+	StepSign       = "sign"
+)
+
+func init() {
+	Register(StepDecompress, func(StepOptions) (Step, error) { return decompress{}, nil })
+	Register(StepCompress, func(StepOptions) (Step, error) { return compress{}, nil })
+	Register(StepConcat, func(StepOptions) (Step, error) { return concat{}, nil })
+	Register(StepOverlay, newOverlay)
+	Register(StepSign, newSign)
+}
+
+// createIn creates a new file of step in dir.
+func createIn(dir, step string) (*os.File, error) {
+	return os.CreateTemp(dir, step+"-*")
+}
+
+// copyFile copies the file src to dst.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	return writeFile(dst, in)
+}
+
+// writeFile writes the content of r to the new file name.
+func writeFile(name string, r io.Reader) error {
+	out, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+// mapFiles writes fn of every file of in to a new file of dir.
+func mapFiles(dir, step string, in []string, fn func(w io.Writer, r io.Reader) error) ([]string, error) {
+	out := make([]string, len(in))
+	for i, name := range in {
+		src, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		dst, err := createIn(dir, step)
+		if err != nil {
+			src.Close()
+			return nil, err
+		}
+		err = fn(dst, src)
+		src.Close()
+		if cerr := dst.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(name), err)
+		}
+		out[i] = dst.Name()
+	}
+
+	return out, nil
+}
+
+// decompress decompresses gzip, zstd and bzip2 files, leaving the others as
+// they are.
+type decompress struct{}
+
+func (decompress) ID() string    { return StepDecompress }
+func (decompress) PerNode() bool { return false }
+
+func (decompress) Apply(_ context.Context, dir string, in []string, _ Node) ([]string, error) {
+	return mapFiles(dir, StepDecompress, in, func(w io.Writer, r io.Reader) error {
+		br := bufio.NewReader(r)
+		magic, _ := br.Peek(4)
+
+		var dec io.Reader = br
+		switch {
+		case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+			zr, err := gzip.NewReader(br)
+			if err != nil {
+				return err
+			}
+			defer zr.Close()
+			dec = zr
+		case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+			zr, err := zstd.NewReader(br)
+			if err != nil {
+				return err
+			}
+			defer zr.Close()
+			dec = zr
+		case bytes.HasPrefix(magic, []byte("BZh")):
+			dec = bzip2.NewReader(br)
+		case bytes.HasPrefix(magic, []byte{0xfd, '7', 'z', 'X'}):
+			return errors.New("xz compression is not supported")
+		}
+
+		_, err := io.Copy(w, dec)
+		return err
+	})
+}
+
+// compress compresses every file with gzip, which every kernel unpacks.
+type compress struct{}
+
+func (compress) ID() string    { return StepCompress }
+func (compress) PerNode() bool { return false }
+
+func (compress) Apply(_ context.Context, dir string, in []string, _ Node) ([]string, error) {
+	return mapFiles(dir, StepCompress, in, func(w io.Writer, r io.Reader) error {
+		zw := gzip.NewWriter(w)
+		if _, err := io.Copy(zw, r); err != nil {
+			return err
+		}
+		return zw.Close()
+	})
+}
+
+// concat joins its files into one, as the kernel accepts an initrd made of
+// several cpio archives.
+type concat struct{}
+
+func (concat) ID() string    { return StepConcat }
+func (concat) PerNode() bool { return false }
+
+func (concat) Apply(_ context.Context, dir string, in []string, _ Node) ([]string, error) {
+	dst, err := createIn(dir, StepConcat)
+	if err != nil {
+		return nil, err
+	}
+	defer dst.Close()
+
+	for _, name := range in {
+		src, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.Copy(dst, src)
+		src.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return []string{dst.Name()}, dst.Close()
+}
+
+// OverlayFile is a file of an overlay.
+type OverlayFile struct {
+	// Path is the absolute path of the file in the initrd.
+	Path string
+	// Template is the text/template of the content of the file, executed
+	// with the Node.
+	Template string
+	// Mode is the permission bits of the file. Zero is 0644.
+	Mode os.FileMode
+}
+
+// overlay appends a cpio archive of files rendered for the node to its file,
+// which the kernel unpacks over the initrd.
+type overlay struct {
+	files     []OverlayFile
+	templates []*template.Template
+	id        string
+}
+
+func newOverlay(opts StepOptions) (Step, error) {
+	if len(opts.Files) == 0 {
+		return nil, errors.New("overlay step needs files")
+	}
+
+	o := &overlay{files: opts.Files}
+	h := sha256.New()
+	for _, f := range opts.Files {
+		if !path.IsAbs(f.Path) || path.Clean(f.Path) != f.Path {
+			return nil, fmt.Errorf("overlay file path %q must be absolute and clean", f.Path)
+		}
+		tmpl, err := template.New(f.Path).Option("missingkey=zero").Parse(f.Template)
+		if err != nil {
+			return nil, fmt.Errorf("overlay file %s: %w", f.Path, err)
+		}
+		o.templates = append(o.templates, tmpl)
+		fmt.Fprintf(h, "%s\x00%o\x00%s\x00", f.Path, f.Mode, f.Template)
+	}
+	o.id = StepOverlay + ":" + hex.EncodeToString(h.Sum(nil)[:8])
+
+	return o, nil
+}
+
+func (o *overlay) ID() string    { return o.id }
+func (o *overlay) PerNode() bool { return true }
+
+func (o *overlay) Apply(_ context.Context, dir string, in []string, node Node) ([]string, error) {
+	if len(in) != 1 {
+		return nil, fmt.Errorf("overlay needs one file, got %d; add a concat step", len(in))
+	}
+
+	cw := newCPIOWriter()
+	dirs := map[string]bool{}
+	for i, f := range o.files {
+		var buf bytes.Buffer
+		if err := o.templates[i].Execute(&buf, node); err != nil {
+			return nil, fmt.Errorf("overlay file %s: %w", f.Path, err)
+		}
+		// The parent directories come first, as the kernel does not
+		// create them.
+		parts := strings.Split(strings.TrimPrefix(path.Dir(f.Path), "/"), "/")
+		for j := range parts {
+			d := strings.Join(parts[:j+1], "/")
+			if d != "" && !dirs[d] {
+				dirs[d] = true
+				cw.dir(d)
+			}
+		}
+		mode := f.Mode
+		if mode == 0 {
+			mode = 0o644
+		}
+		cw.file(strings.TrimPrefix(f.Path, "/"), mode, buf.Bytes())
+	}
+
+	out, err := mapFiles(dir, StepOverlay, in, func(w io.Writer, r io.Reader) error {
+		if _, err := io.Copy(w, r); err != nil {
+			return err
+		}
+		_, err := w.Write(cw.close())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// sign makes a detached signature of its file.
+type sign struct {
+	signer Signer
+}
+
+func newSign(opts StepOptions) (Step, error) {
+	if opts.Signer == nil {
+		return nil, errors.New("sign step needs a signing certificate and key")
+	}
+
+	return sign{signer: opts.Signer}, nil
+}
+
+func (sign) ID() string    { return StepSign }
+func (sign) PerNode() bool { return false }
+
+func (s sign) Apply(_ context.Context, dir string, in []string, _ Node) ([]string, error) {
+	if len(in) != 1 {
+		return nil, fmt.Errorf("sign needs one file, got %d; add a concat step", len(in))
+	}
+	content, err := os.ReadFile(in[0])
+	if err != nil {
+		return nil, err
+	}
+	sig, err := s.signer.Sign(content)
+	if err != nil {
+		return nil, err
+	}
+
+	// The signature goes next to the file, which must be one of the build.
+	out := in[0]
+	if filepath.Dir(out) != dir {
+		f, err := createIn(dir, StepSign)
+		if err != nil {
+			return nil, err
+		}
+		out = f.Name()
+		_, err = f.Write(content)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := os.WriteFile(out+SignatureSuffix, sig, 0o644); err != nil {
+		return nil, err
+	}
+
+	return []string{out}, nil
+}
@@ -0,0 +1,343 @@
+// Package audit records who changed what through the HTTP APIs.
+//
+// Every mutating request to the Redfish and admin APIs, such as a power
+// action, a PATCH of a system, a firmware update or a change to a DHCP
+// reservation, becomes an Entry naming the actor, the host it was about, the
+// outcome and the new value sent. Handlers that know the value the request
+// replaced add it with SetOld. Entries are appended as JSON lines to a
+// rotating file, the record kept for compliance, and the most recent ones
+// are kept in memory to be served by the admin API.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/metal3-community/metal-boot/internal/adminauth"
+	"github.com/metal3-community/metal-boot/internal/logging"
+	"github.com/metal3-community/metal-boot/internal/redact"
+	"github.com/metal3-community/metal-boot/internal/util"
+)
+
+// Defaults of Options.
+const (
+	defaultRetain = 1000
+	// maxBodyBytes bounds the request bodies recorded as the new value.
+	// Larger bodies, such as firmware images, are recorded without it.
+	maxBodyBytes = 64 << 10
+)
+
+// Entry is one audited request.
+type Entry struct {
+	Time time.Time `json:"time"`
+	// Actor is the admin user, the Redfish session or basic auth user, or
+	// "anonymous".
+	Actor  string `json:"actor"`
+	Remote string `json:"remote,omitempty"`
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	// MAC is the normalized MAC address of the host the request was about.
+	MAC    string `json:"mac,omitempty"`
+	Status int    `json:"status"`
+	// Old is the value the request replaced, when the handler recorded it.
+	Old json.RawMessage `json:"old,omitempty"`
+	// New is the JSON body of the request, with credentials masked.
+	New       json.RawMessage `json:"new,omitempty"`
+	RequestID string          `json:"requestId,omitempty"`
+}
+
+// Options configure a Log.
+type Options struct {
+	logging.FileOptions
+	// Retain is how many of the latest entries are kept in memory. Zero is
+	// 1000.
+	Retain int
+	// Logger reports entries that could not be written.
+	Logger *slog.Logger
+}
+
+// Log is the audit log. A nil Log records nothing.
+type Log struct {
+	w      io.WriteCloser
+	retain int
+	logger *slog.Logger
+
+	mu sync.Mutex
+	// recent holds the latest entries, oldest first.
+	recent []Entry
+}
+
+// Open opens the audit log file of opts for appending and loads its latest
+// entries.
+func Open(opts Options) (*Log, error) {
+	if opts.Retain <= 0 {
+		opts.Retain = defaultRetain
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+
+	l := &Log{retain: opts.Retain, logger: opts.Logger}
+	if err := l.load(opts.Path); err != nil {
+		return nil, err
+	}
+	w, err := logging.OpenRotatingFile(opts.FileOptions)
+	if err != nil {
+		return nil, err
+	}
+	l.w = w
+
+	return l, nil
+}
+
+// load reads the entries of the current file at path into recent.
+func (l *Log) load(path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 4*maxBodyBytes)
+	for scanner.Scan() {
+		var e Entry
+		if json.Unmarshal(scanner.Bytes(), &e) == nil {
+			l.keep(e)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Record appends e to the log, stamping it with the current time if it has
+// none.
+func (l *Log) Record(e Entry) error {
+	if l == nil {
+		return nil
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.keep(e)
+	_, err = l.w.Write(append(line, '\n'))
+
+	return err
+}
+
+func (l *Log) keep(e Entry) {
+	l.recent = append(l.recent, e)
+	if over := len(l.recent) - l.retain; over > 0 {
+		l.recent = append(l.recent[:0], l.recent[over:]...)
+	}
+}
+
+// Entries returns the entries kept in memory, newest first.
+func (l *Log) Entries() []Entry {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	out := make([]Entry, len(l.recent))
+	for i, e := range l.recent {
+		out[len(out)-1-i] = e
+	}
+
+	return out
+}
+
+// Close closes the log file.
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	return l.w.Close()
+}
+
+type entryKey struct{}
+
+// SetOld records v as the value the request of ctx replaces. It does nothing
+// outside an audited request.
+func SetOld(ctx context.Context, v any) {
+	e, ok := ctx.Value(entryKey{}).(*Entry)
+	if !ok {
+		return
+	}
+	if b, err := json.Marshal(v); err == nil {
+		e.Old = b
+	}
+}
+
+// SetActor names the actor of the request of ctx, for handlers that
+// authenticate requests themselves, such as with Redfish sessions.
+func SetActor(ctx context.Context, actor string) {
+	if e, ok := ctx.Value(entryKey{}).(*Entry); ok && actor != "" {
+		e.Actor = actor
+	}
+}
+
+// Middleware records the mutating requests next serves. It must run inside
+// the authentication of the admin API, so that it sees the principal.
+func (l *Log) Middleware(next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		e := &Entry{
+			Actor:  actor(r),
+			Remote: r.RemoteAddr,
+			Method: r.Method,
+			Path:   r.URL.Path,
+			MAC:    pathMAC(r.URL.Path),
+			New:    requestBody(r),
+		}
+		for _, f := range logging.Fields(r.Context()) {
+			if f.Key == logging.KeyRequestID {
+				e.RequestID = f.Value.String()
+			}
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), entryKey{}, e)))
+		e.Status = rec.status
+
+		// A failure to audit does not undo the request.
+		if err := l.Record(*e); err != nil {
+			l.logger.Error("Failed to write audit entry",
+				"method", e.Method, "path", e.Path, "actor", e.Actor, "error", err)
+		}
+	})
+}
+
+// actor names the principal of the admin API or the basic auth user of r.
+func actor(r *http.Request) string {
+	if p := adminauth.FromContext(r.Context()); p != nil {
+		if p.Name != "" {
+			return p.Name
+		}
+		return p.Subject
+	}
+	if user, _, ok := r.BasicAuth(); ok && user != "" {
+		return user
+	}
+
+	return "anonymous"
+}
+
+// pathMAC returns the first segment of path that is a MAC address.
+func pathMAC(path string) string {
+	for seg := range strings.SplitSeq(path, "/") {
+		if len(seg) < 12 {
+			continue
+		}
+		if mac, err := util.ParseMAC(seg); err == nil && len(mac) == 6 {
+			return mac.String()
+		}
+	}
+
+	return ""
+}
+
+// requestBody returns the JSON body of r with credentials masked, leaving
+// the body for the handler to read. Bodies that are not JSON or too large
+// are not recorded.
+func requestBody(r *http.Request) json.RawMessage {
+	if r.Body == nil || r.ContentLength > maxBodyBytes {
+		return nil
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodyBytes+1))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+	if err != nil || len(body) > maxBodyBytes {
+		return nil
+	}
+
+	var v any
+	if json.Unmarshal(body, &v) != nil {
+		return nil
+	}
+	masked, err := json.Marshal(mask(v))
+	if err != nil {
+		return nil
+	}
+
+	return masked
+}
+
+// mask replaces the values of credential fields in v.
+func mask(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, child := range v {
+			if redact.Sensitive(k) {
+				v[k] = redact.Mask
+			} else {
+				v[k] = mask(child)
+			}
+		}
+	case []any:
+		for i, child := range v {
+			v[i] = mask(child)
+		}
+	}
+
+	return v
+}
+
+// statusRecorder captures the status code of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	wrote  bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wrote {
+		r.status, r.wrote = status, true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wrote = true
+
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
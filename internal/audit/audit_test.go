@@ -0,0 +1,91 @@
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/metal3-community/metal-boot/internal/adminauth"
+	"github.com/metal3-community/metal-boot/internal/logging"
+)
+
+func TestMiddleware(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := Open(Options{FileOptions: logging.FileOptions{Path: path}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Method == http.MethodPatch && !strings.Contains(string(body), "s3cret") {
+			t.Errorf("handler read body %q, want the original", body)
+		}
+		SetOld(r.Context(), map[string]string{"PowerState": "Off"})
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	req := httptest.NewRequest(http.MethodPatch, "/redfish/v1/Systems/aa-bb-cc-dd-ee-ff",
+		strings.NewReader(`{"PowerState":"On","Password":"s3cret"}`))
+	req = req.WithContext(adminauth.WithPrincipal(req.Context(), &adminauth.Principal{Subject: "1234", Name: "alice"}))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	// Reads are not audited.
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/redfish/v1/Systems", nil))
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/reservations/aa:bb:cc:dd:ee:01/release", nil)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	entries := l.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	if e := entries[0]; e.Actor != "anonymous" || e.MAC != "aa:bb:cc:dd:ee:01" || e.New != nil {
+		t.Errorf("newest entry = %+v", e)
+	}
+	e := entries[1]
+	if e.Actor != "alice" || e.Method != http.MethodPatch || e.MAC != "aa:bb:cc:dd:ee:ff" ||
+		e.Status != http.StatusNoContent || e.Time.IsZero() {
+		t.Errorf("oldest entry = %+v", e)
+	}
+	if got, want := string(e.Old), `{"PowerState":"Off"}`; got != want {
+		t.Errorf("old = %s, want %s", got, want)
+	}
+	if got, want := string(e.New), `{"Password":"[REDACTED]","PowerState":"On"}`; got != want {
+		t.Errorf("new = %s, want %s", got, want)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The entries survive a restart.
+	l, err = Open(Options{FileOptions: logging.FileOptions{Path: path}, Retain: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	reloaded := l.Entries()
+	if len(reloaded) != 1 {
+		t.Fatalf("reloaded %d entries, want 1", len(reloaded))
+	}
+	a, _ := json.Marshal(reloaded[0])
+	b, _ := json.Marshal(entries[0])
+	if string(a) != string(b) {
+		t.Errorf("reloaded %s, want %s", a, b)
+	}
+}
+
+func TestNilLog(t *testing.T) {
+	var l *Log
+	called := false
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		SetOld(r.Context(), "ignored")
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+	if !called || l.Entries() != nil || l.Record(Entry{}) != nil || l.Close() != nil {
+		t.Error("nil log should pass requests through and record nothing")
+	}
+}
@@ -0,0 +1,81 @@
+// Package alias reads a backend under the MAC address a host was first
+// recorded with, for hosts that came back with a locally administered one,
+// so that a randomized address gets the reservation of the host instead of a
+// record of its own.
+package alias
+
+import (
+	"context"
+	"net"
+
+	"github.com/metal3-community/metal-boot/internal/backend"
+	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+)
+
+// Resolver returns the MAC address a host is recorded under, such as
+// hoststate.Store.
+type Resolver interface {
+	Resolve(mac net.HardwareAddr) net.HardwareAddr
+}
+
+// Reader returns a BackendReader that looks up every MAC address of next
+// under the address r resolves it to. A nil r returns next.
+func Reader(next backend.BackendReader, r Resolver) backend.BackendReader {
+	if r == nil {
+		return next
+	}
+
+	return &reader{next: next, resolver: r}
+}
+
+type reader struct {
+	next     backend.BackendReader
+	resolver Resolver
+}
+
+// Unwrap returns the wrapped backend, for callers that need its concrete type.
+func (r *reader) Unwrap() backend.BackendReader {
+	return r.next
+}
+
+// GetByMac returns the record of the host mac resolves to, addressed to mac.
+func (r *reader) GetByMac(
+	ctx context.Context,
+	mac net.HardwareAddr,
+) (*data.DHCP, *data.Netboot, error) {
+	d, n, err := r.next.GetByMac(ctx, r.resolver.Resolve(mac))
+	if d != nil {
+		c := *d
+		c.MACAddress = mac
+		d = &c
+	}
+
+	return d, n, err
+}
+
+func (r *reader) GetByIP(ctx context.Context, ip net.IP) (*data.DHCP, *data.Netboot, error) {
+	return r.next.GetByIP(ctx, ip)
+}
+
+func (r *reader) GetKeys(ctx context.Context) ([]net.HardwareAddr, error) {
+	return r.next.GetKeys(ctx)
+}
+
+// HasReservation reports whether the host mac resolves to has a static
+// reservation in the first backend of the chain that tells reservations
+// apart. Without one, every record counts as a reservation.
+func (r *reader) HasReservation(mac net.HardwareAddr) bool {
+	mac = r.resolver.Resolve(mac)
+	for b := r.next; b != nil; {
+		if rs, ok := b.(interface{ HasReservation(net.HardwareAddr) bool }); ok {
+			return rs.HasReservation(mac)
+		}
+		u, ok := b.(interface{ Unwrap() backend.BackendReader })
+		if !ok {
+			break
+		}
+		b = u.Unwrap()
+	}
+
+	return true
+}
@@ -0,0 +1,71 @@
+package alias
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+)
+
+type fakeReader struct {
+	asked    net.HardwareAddr
+	reserved bool
+}
+
+func (f *fakeReader) GetByMac(
+	_ context.Context,
+	mac net.HardwareAddr,
+) (*data.DHCP, *data.Netboot, error) {
+	f.asked = mac
+	return &data.DHCP{MACAddress: mac, Hostname: "node1"}, &data.Netboot{}, nil
+}
+
+func (f *fakeReader) GetByIP(context.Context, net.IP) (*data.DHCP, *data.Netboot, error) {
+	return nil, nil, nil
+}
+
+func (f *fakeReader) GetKeys(context.Context) ([]net.HardwareAddr, error) {
+	return nil, nil
+}
+
+func (f *fakeReader) HasReservation(mac net.HardwareAddr) bool {
+	f.asked = mac
+	return f.reserved
+}
+
+type resolver map[string]net.HardwareAddr
+
+func (r resolver) Resolve(mac net.HardwareAddr) net.HardwareAddr {
+	if host, ok := r[mac.String()]; ok {
+		return host
+	}
+	return mac
+}
+
+func TestReader(t *testing.T) {
+	host, _ := net.ParseMAC("d8:3a:dd:01:02:03")
+	random, _ := net.ParseMAC("d2:11:22:33:44:55")
+	next := &fakeReader{reserved: true}
+	r := Reader(next, resolver{random.String(): host})
+
+	d, _, err := r.GetByMac(context.Background(), random)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.asked.String() != host.String() {
+		t.Errorf("looked up %v, want the host's %v", next.asked, host)
+	}
+	if d.MACAddress.String() != random.String() || d.Hostname != "node1" {
+		t.Errorf("GetByMac() = %+v, want the host's record for %v", d, random)
+	}
+
+	rs := r.(interface{ HasReservation(net.HardwareAddr) bool })
+	if !rs.HasReservation(random) || next.asked.String() != host.String() {
+		t.Errorf("HasReservation() asked for %v, want %v", next.asked, host)
+	}
+
+	if got := Reader(next, nil); got != next {
+		t.Error("Reader() with a nil resolver wrapped the backend")
+	}
+}
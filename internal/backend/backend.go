@@ -3,6 +3,7 @@ package backend
 
 import (
 	"context"
+	"errors"
 	"net"
 
 	"github.com/metal3-community/metal-boot/internal/dhcp/data"
@@ -14,7 +15,10 @@ import (
 type BackendReader interface {
 	// Read data (from a backend) based on a mac address
 	// and return DHCP headers and options, including netboot info.
+	// The IPv6 reservation of the host, if the backend has one, is returned
+	// alongside the IPv4 one in data.DHCP.IPv6Address.
 	GetByMac(context.Context, net.HardwareAddr) (*data.DHCP, *data.Netboot, error)
+	// GetByIP reads data based on an IPv4 or IPv6 address.
 	GetByIP(context.Context, net.IP) (*data.DHCP, *data.Netboot, error)
 	GetKeys(context.Context) ([]net.HardwareAddr, error)
 }
@@ -30,6 +34,57 @@ type BackendWriter interface {
 	) error
 }
 
+// Errors of BackendHostWriter.
+var (
+	// ErrHostNotFound is returned for a host the backend has no record of.
+	ErrHostNotFound = errors.New("host not found")
+	// ErrHostExists is returned when creating a host the backend already has.
+	ErrHostExists = errors.New("host already exists")
+	// ErrInvalidHost is returned for a host or option that fails validation.
+	ErrInvalidHost = errors.New("invalid host")
+)
+
+// Host is the record of a host in a backend that manages hosts.
+type Host struct {
+	MAC net.HardwareAddr
+	// IP and IPv6 are the fixed addresses of the host, if it has them.
+	IP   net.IP
+	IPv6 net.IP
+	// Hostname is the name assigned to the host, if any.
+	Hostname string
+	// Disabled hosts get no answer to DHCP requests.
+	Disabled bool
+	// Options are the DHCP options served to the host only.
+	Options []HostOption
+}
+
+// HostOption is a DHCP option of a Host, with its value as the backend
+// writes it, such as "10.0.0.1" for option 3.
+type HostOption struct {
+	Code  uint8
+	Value string
+}
+
+// BackendHostWriter is implemented by backends whose hosts can be created,
+// changed and removed at runtime.
+type BackendHostWriter interface {
+	// Hosts returns every host of the backend.
+	Hosts(ctx context.Context) ([]Host, error)
+	// GetHost returns the host of mac, or ErrHostNotFound.
+	GetHost(ctx context.Context, mac net.HardwareAddr) (Host, error)
+	// CreateHost adds h, or returns ErrHostExists.
+	CreateHost(ctx context.Context, h Host) error
+	// UpdateHost replaces the host of h.MAC, or returns ErrHostNotFound. The
+	// options of the host are kept when h.Options is nil.
+	UpdateHost(ctx context.Context, h Host) error
+	// DeleteHost removes the host of mac and its options, or returns
+	// ErrHostNotFound.
+	DeleteHost(ctx context.Context, mac net.HardwareAddr) error
+	// SetOptions replaces the options of the host of mac, or returns
+	// ErrHostNotFound.
+	SetOptions(ctx context.Context, mac net.HardwareAddr, opts []HostOption) error
+}
+
 type BackendPower interface {
 	GetPower(context.Context, net.HardwareAddr) (*data.PowerState, error)
 	SetPower(ctx context.Context, mac net.HardwareAddr, state data.PowerState) error
@@ -37,6 +92,24 @@ type BackendPower interface {
 	PowerCycle(ctx context.Context, mac net.HardwareAddr) error
 }
 
+// PowerTarget identifies what a power backend switches to power a host.
+type PowerTarget struct {
+	// Device is the address of the device that powers the host, such as its
+	// PoE switch.
+	Device string
+	// DeviceName is the name of the device, if it has one.
+	DeviceName string
+	// Port is the port of the device the host is connected to, 0 if the
+	// device has no ports.
+	Port int
+}
+
+// BackendPowerTarget is implemented by power backends that can tell which
+// device and port power a host without changing its power.
+type BackendPowerTarget interface {
+	PowerTarget(ctx context.Context, mac net.HardwareAddr) (PowerTarget, error)
+}
+
 type BackendSyncer interface {
 	// Sync the backend with the file.
 	Sync(ctx context.Context) error
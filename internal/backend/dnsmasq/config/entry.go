@@ -0,0 +1,296 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/metal3-community/metal-boot/internal/dhcp/option"
+	"github.com/metal3-community/metal-boot/internal/util"
+)
+
+// HostEntry is a dhcp-host line, e.g. "aa:bb:cc:dd:ee:ff,set:node,set:ironic"
+// or "aa:bb:cc:dd:ee:ff,ignore".
+type HostEntry struct {
+	// MAC is the hardware address the entry matches.
+	MAC net.HardwareAddr
+	// Tags are the tags set for the host ("set:<tag>").
+	Tags []string
+	// IP is the fixed address assigned to the host, if any.
+	IP net.IP
+	// IPv6 is the fixed DHCPv6 address assigned to the host, if any. dnsmasq
+	// writes it in brackets: "[2001:db8::5]".
+	IPv6 net.IP
+	// Hostname is the name assigned to the host, if any.
+	Hostname string
+	// Ignore makes dnsmasq ignore DHCP requests from the host.
+	Ignore bool
+	// Extra holds fields that are not modeled (lease times, client ids) so
+	// they survive a rewrite.
+	Extra []string
+}
+
+// hostEntryJSON is the wire form of HostEntry, with the MAC as a string.
+type hostEntryJSON struct {
+	MAC      string   `json:"mac"`
+	Tags     []string `json:"tags,omitempty"`
+	IP       net.IP   `json:"ip,omitempty"`
+	IPv6     net.IP   `json:"ipv6,omitempty"`
+	Hostname string   `json:"hostname,omitempty"`
+	Ignore   bool     `json:"ignore,omitempty"`
+	Extra    []string `json:"extra,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (h HostEntry) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hostEntryJSON{
+		MAC:      h.MAC.String(),
+		Tags:     h.Tags,
+		IP:       h.IP,
+		IPv6:     h.IPv6,
+		Hostname: h.Hostname,
+		Ignore:   h.Ignore,
+		Extra:    h.Extra,
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (h *HostEntry) UnmarshalJSON(b []byte) error {
+	var v hostEntryJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	mac, err := util.ParseMAC(v.MAC)
+	if err != nil {
+		return fmt.Errorf("invalid MAC address: %s", v.MAC)
+	}
+	*h = HostEntry{
+		MAC:      mac,
+		Tags:     v.Tags,
+		IP:       v.IP,
+		IPv6:     v.IPv6,
+		Hostname: v.Hostname,
+		Ignore:   v.Ignore,
+		Extra:    v.Extra,
+	}
+
+	return nil
+}
+
+// ParseHostEntry parses a dhcp-host line.
+func ParseHostEntry(line string) (*HostEntry, error) {
+	fields := strings.Split(line, ",")
+	mac, err := util.ParseMAC(fields[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid MAC address: %s", fields[0])
+	}
+
+	entry := &HostEntry{MAC: mac}
+	for _, f := range fields[1:] {
+		f = strings.TrimSpace(f)
+		switch {
+		case f == "ignore":
+			entry.Ignore = true
+		case strings.HasPrefix(f, "set:"):
+			entry.Tags = append(entry.Tags, strings.TrimPrefix(f, "set:"))
+		case net.ParseIP(f) != nil:
+			entry.IP = net.ParseIP(f)
+		case strings.HasPrefix(f, "[") && strings.HasSuffix(f, "]") &&
+			net.ParseIP(f[1:len(f)-1]) != nil:
+			entry.IPv6 = net.ParseIP(f[1 : len(f)-1])
+		case entry.Hostname == "" && isHostname(f):
+			entry.Hostname = f
+		default:
+			entry.Extra = append(entry.Extra, f)
+		}
+	}
+
+	return entry, nil
+}
+
+// Validate checks that the entry can be written as a dhcp-host line.
+func (h HostEntry) Validate() error {
+	if len(h.MAC) == 0 {
+		return errors.New("MAC address is required")
+	}
+	for _, t := range h.Tags {
+		if err := validateTag(t); err != nil {
+			return err
+		}
+	}
+	if h.IP != nil && h.IP.To4() == nil {
+		return fmt.Errorf("%s is not an IPv4 address", h.IP)
+	}
+	if h.IPv6 != nil && h.IPv6.To4() != nil {
+		return fmt.Errorf("%s is not an IPv6 address", h.IPv6)
+	}
+	if h.Hostname != "" && !isHostname(h.Hostname) {
+		return fmt.Errorf("invalid hostname %q", h.Hostname)
+	}
+	for _, e := range h.Extra {
+		if e == "" || strings.ContainsAny(e, ",\n") {
+			return fmt.Errorf("invalid host field %q", e)
+		}
+	}
+
+	return nil
+}
+
+// HasTag reports whether tag is set for the host.
+func (h HostEntry) HasTag(tag string) bool {
+	for _, t := range h.Tags {
+		if t == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// String renders the entry as a dhcp-host line.
+func (h HostEntry) String() string {
+	fields := []string{h.MAC.String()}
+	for _, t := range h.Tags {
+		fields = append(fields, "set:"+t)
+	}
+	if h.IP != nil {
+		fields = append(fields, h.IP.String())
+	}
+	if h.IPv6 != nil {
+		fields = append(fields, "["+h.IPv6.String()+"]")
+	}
+	if h.Hostname != "" {
+		fields = append(fields, h.Hostname)
+	}
+	fields = append(fields, h.Extra...)
+	if h.Ignore {
+		fields = append(fields, "ignore")
+	}
+
+	return strings.Join(fields, ",")
+}
+
+// isHostname reports whether s looks like a host name rather than a lease
+// time or other dhcp-host field.
+func isHostname(s string) bool {
+	if s == "" || s == "infinite" || strings.ContainsAny(s, ":=, \t\n") {
+		return false
+	}
+	if _, err := strconv.Atoi(strings.TrimRight(s, "smhdw")); err == nil {
+		return false
+	}
+
+	return true
+}
+
+// DHCPOption is a dhcp-option line, e.g. "tag:node,tag:!ipxe,67,ipxe.efi".
+type DHCPOption struct {
+	// Tags are the tag conditions of the option. A leading "!" negates a tag.
+	Tags []string `json:"tags,omitempty"`
+	// Code is the DHCP option code.
+	Code uint8 `json:"code"`
+	// Value is the option value as written in the dnsmasq file.
+	Value string `json:"value"`
+}
+
+// ParseDHCPOption parses a dhcp-option line. The option may be given by
+// number or as "option:<name>" for the names in OptionNames.
+func ParseDHCPOption(line string) (*DHCPOption, error) {
+	fields := strings.Split(line, ",")
+
+	opt := &DHCPOption{}
+	i := 0
+	for ; i < len(fields) && strings.HasPrefix(strings.TrimSpace(fields[i]), "tag:"); i++ {
+		opt.Tags = append(opt.Tags, strings.TrimPrefix(strings.TrimSpace(fields[i]), "tag:"))
+	}
+	if i >= len(fields) {
+		return nil, errors.New("missing option code")
+	}
+
+	code, err := ParseOptionCode(strings.TrimSpace(fields[i]))
+	if err != nil {
+		return nil, err
+	}
+	opt.Code = code
+	opt.Value = strings.Join(fields[i+1:], ",")
+
+	return opt, nil
+}
+
+// String renders the option as a dhcp-option line.
+func (o DHCPOption) String() string {
+	fields := make([]string, 0, len(o.Tags)+2)
+	for _, t := range o.Tags {
+		fields = append(fields, "tag:"+t)
+	}
+	fields = append(fields, strconv.Itoa(int(o.Code)), o.Value)
+
+	return strings.Join(fields, ",")
+}
+
+// HasTag reports whether the option is conditional on tag.
+func (o DHCPOption) HasTag(tag string) bool {
+	for _, t := range o.Tags {
+		if t == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// OptionNames maps the dnsmasq option names accepted in "option:<name>" to
+// their codes.
+var OptionNames = map[string]uint8{
+	"netmask":           1,
+	"router":            3,
+	"dns-server":        6,
+	"hostname":          12,
+	"domain-name":       15,
+	"broadcast":         28,
+	"ntp-server":        42,
+	"server-identifier": 54,
+	"tftp-server":       66,
+	"bootfile-name":     67,
+	"server-ip-address": 150,
+}
+
+// ParseOptionCode parses an option number or "option:<name>".
+func ParseOptionCode(s string) (uint8, error) {
+	if name, ok := strings.CutPrefix(s, "option:"); ok {
+		code, ok := OptionNames[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown option name %q", name)
+		}
+		return code, nil
+	}
+
+	n, err := strconv.ParseUint(s, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid option code %q", s)
+	}
+
+	return uint8(n), nil
+}
+
+// Validate checks the tags and that the value can be encoded for the option.
+func (o DHCPOption) Validate() error {
+	for _, t := range o.Tags {
+		if err := validateTag(strings.TrimPrefix(t, "!")); err != nil {
+			return err
+		}
+	}
+	if err := option.Validate(o.Code, o.Value); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Encode returns the option value in DHCP wire format.
+func (o DHCPOption) Encode() ([]byte, error) {
+	return option.Encode(o.Code, o.Value)
+}
@@ -0,0 +1,410 @@
+// Package config manages DNSMasq host and DHCP option files in the layout used
+// by Ironic's dnsmasq provider (dhcp-hostsdir and dhcp-optsdir).
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/go-logr/logr"
+)
+
+const (
+	// HostsDir is the directory, relative to the root, holding dhcp-host files.
+	HostsDir = "hosts"
+	// OptsDir is the directory, relative to the root, holding dhcp-option files.
+	OptsDir = "opts"
+
+	filePrefix = "ironic-"
+	fileSuffix = ".conf"
+)
+
+var (
+	// ErrNotFound is returned when a host entry or option tag does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrInvalid is returned when a tag or option fails validation.
+	ErrInvalid = errors.New("invalid")
+)
+
+// ConfigManager keeps an in-memory model of the DNSMasq hosts and opts
+// directories and writes changes back to disk.
+type ConfigManager struct {
+	mu sync.RWMutex
+
+	// RootDir is the directory containing the hosts and opts directories.
+	RootDir string
+	// Log is the logger to be used in the ConfigManager.
+	Log logr.Logger
+	// Sharding is where new files are written. Files are read from every
+	// layout, and a file in another layout is moved when it is next written.
+	Sharding Sharding
+
+	hosts     map[string]*HostEntry   // hosts maps MAC addresses to host entries
+	options   map[string][]DHCPOption // options maps tags to their option lines
+	hostFiles map[string]string       // hostFiles maps MAC addresses to their file
+	optFiles  map[string]string       // optFiles maps tags to their file
+	index     map[string]*indexedFile // index maps files to their parsed content
+}
+
+// NewConfigManager creates a ConfigManager for rootDir and loads existing files.
+func NewConfigManager(log logr.Logger, rootDir string) (*ConfigManager, error) {
+	m := &ConfigManager{
+		RootDir:   rootDir,
+		Log:       log,
+		hosts:     make(map[string]*HostEntry),
+		options:   make(map[string][]DHCPOption),
+		hostFiles: make(map[string]string),
+		optFiles:  make(map[string]string),
+	}
+
+	if err := m.Load(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Load re-reads the hosts and opts directories, replacing the in-memory model.
+// Files whose modification time and size did not change since the previous
+// Load are not parsed again. Lines that cannot be parsed are logged and skipped.
+func (m *ConfigManager) Load() error {
+	files, err := m.listFiles()
+	if err != nil {
+		return err
+	}
+
+	m.mu.RLock()
+	previous := m.index
+	m.mu.RUnlock()
+
+	index := make(map[string]*indexedFile, len(files))
+	for _, rel := range slices.Sorted(maps.Keys(files)) {
+		info := files[rel]
+		if f, ok := previous[rel]; ok && f.modTime.Equal(info.ModTime()) && f.size == info.Size() {
+			index[rel] = f
+			continue
+		}
+		f, err := m.parseFile(rel, info)
+		if err != nil {
+			return err
+		}
+		index[rel] = f
+	}
+
+	hosts := make(map[string]*HostEntry)
+	hostFiles := make(map[string]string)
+	options := make(map[string][]DHCPOption)
+	optFiles := make(map[string]string)
+	for _, rel := range slices.Sorted(maps.Keys(index)) {
+		f := index[rel]
+		for _, h := range f.hosts {
+			entry := *h
+			hosts[entry.MAC.String()] = &entry
+			hostFiles[entry.MAC.String()] = rel
+		}
+		if len(f.options) > 0 {
+			tag := tagFromFile(filepath.Base(rel))
+			options[tag] = append(options[tag], cloneOptions(f.options)...)
+			optFiles[tag] = rel
+		}
+	}
+
+	m.mu.Lock()
+	m.hosts = hosts
+	m.options = options
+	m.hostFiles = hostFiles
+	m.optFiles = optFiles
+	m.index = index
+	m.mu.Unlock()
+
+	return nil
+}
+
+// Hosts returns copies of all host entries ordered by MAC.
+func (m *ConfigManager) Hosts() []HostEntry {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	out := make([]HostEntry, 0, len(m.hosts))
+	for _, h := range m.hosts {
+		out = append(out, *h)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].MAC.String() < out[j].MAC.String() })
+
+	return out
+}
+
+// GetHost returns the host entry for mac.
+func (m *ConfigManager) GetHost(mac net.HardwareAddr) (HostEntry, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	h, ok := m.hosts[mac.String()]
+	if !ok {
+		return HostEntry{}, false
+	}
+
+	return *h, true
+}
+
+// SetHost validates entry and writes it to its host file, replacing any
+// existing entry for the same MAC.
+func (m *ConfigManager) SetHost(entry HostEntry) error {
+	if err := entry.Validate(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := entry.MAC.String()
+	file := m.hostFile(entry.MAC)
+	if err := m.moveFile(m.hostFiles[key], file, []string{entry.String()}); err != nil {
+		return err
+	}
+	m.hostFiles[key] = file
+	stored := entry
+	stored.Tags = append([]string(nil), entry.Tags...)
+	stored.Extra = append([]string(nil), entry.Extra...)
+	m.hosts[key] = &stored
+
+	return nil
+}
+
+// RemoveHost deletes the host file for mac.
+func (m *ConfigManager) RemoveHost(mac net.HardwareAddr) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.hosts[mac.String()]; !ok {
+		return fmt.Errorf("%w: host %s", ErrNotFound, mac)
+	}
+
+	file, ok := m.hostFiles[mac.String()]
+	if !ok {
+		file = m.hostFile(mac)
+	}
+	if err := m.removeFile(file); err != nil {
+		return fmt.Errorf("failed to remove host file: %w", err)
+	}
+	delete(m.hosts, mac.String())
+	delete(m.hostFiles, mac.String())
+
+	return nil
+}
+
+// ReservedIPs returns the fixed IPv4 and IPv6 addresses of all host entries
+// keyed by IP.
+func (m *ConfigManager) ReservedIPs() map[string]net.HardwareAddr {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	out := make(map[string]net.HardwareAddr)
+	for _, h := range m.hosts {
+		if h.IP != nil {
+			out[h.IP.String()] = h.MAC
+		}
+		if h.IPv6 != nil {
+			out[h.IPv6.String()] = h.MAC
+		}
+	}
+
+	return out
+}
+
+// Tags returns the tags that have an options file, sorted.
+func (m *ConfigManager) Tags() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	tags := make([]string, 0, len(m.options))
+	for tag := range m.options {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	return tags
+}
+
+// Options returns a copy of the options for tag.
+func (m *ConfigManager) Options(tag string) ([]DHCPOption, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	opts, ok := m.options[tag]
+	if !ok {
+		return nil, fmt.Errorf("%w: tag %s", ErrNotFound, tag)
+	}
+
+	return cloneOptions(opts), nil
+}
+
+// SetOptions replaces all options for tag and writes the options file.
+func (m *ConfigManager) SetOptions(tag string, opts []DHCPOption) error {
+	if err := validateTag(tag); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+	for i := range opts {
+		if err := opts[i].Validate(); err != nil {
+			return fmt.Errorf("%w: option %d: %w", ErrInvalid, i, err)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.writeOptions(tag, cloneOptions(opts))
+}
+
+// AddOption appends opt to the options for tag, creating the file if needed.
+func (m *ConfigManager) AddOption(tag string, opt DHCPOption) error {
+	if err := validateTag(tag); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+	if err := opt.Validate(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	opts := append(cloneOptions(m.options[tag]), opt)
+
+	return m.writeOptions(tag, opts)
+}
+
+// RemoveOption removes every option with code from tag. It returns
+// ErrNotFound if no option matched.
+func (m *ConfigManager) RemoveOption(tag string, code uint8) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, ok := m.options[tag]
+	if !ok {
+		return fmt.Errorf("%w: tag %s", ErrNotFound, tag)
+	}
+
+	opts := make([]DHCPOption, 0, len(current))
+	for _, o := range current {
+		if o.Code != code {
+			opts = append(opts, o)
+		}
+	}
+	if len(opts) == len(current) {
+		return fmt.Errorf("%w: option %d for tag %s", ErrNotFound, code, tag)
+	}
+
+	return m.writeOptions(tag, opts)
+}
+
+// DeleteOptions removes the options file for tag.
+func (m *ConfigManager) DeleteOptions(tag string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.options[tag]; !ok {
+		return fmt.Errorf("%w: tag %s", ErrNotFound, tag)
+	}
+
+	file, ok := m.optFiles[tag]
+	if !ok {
+		file = m.optionsFile(tag)
+	}
+	if err := m.removeFile(file); err != nil {
+		return fmt.Errorf("failed to remove options file: %w", err)
+	}
+	delete(m.options, tag)
+	delete(m.optFiles, tag)
+
+	return nil
+}
+
+// writeOptions persists opts for tag and updates the model. Callers must hold m.mu.
+func (m *ConfigManager) writeOptions(tag string, opts []DHCPOption) error {
+	lines := make([]string, 0, len(opts))
+	for _, o := range opts {
+		lines = append(lines, o.String())
+	}
+
+	file := m.optionsFile(tag)
+	if err := m.moveFile(m.optFiles[tag], file, lines); err != nil {
+		return err
+	}
+	m.options[tag] = opts
+	m.optFiles[tag] = file
+
+	return nil
+}
+
+// removeFile removes the file at rel, relative to RootDir, if it exists.
+func (m *ConfigManager) removeFile(rel string) error {
+	err := os.Remove(filepath.Join(m.RootDir, rel))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// moveFile writes lines to the file at rel and removes the file at old, the
+// previous location of the same entry in another layout. A file under another
+// name may hold other entries as well and is kept. Both paths are relative to
+// RootDir.
+func (m *ConfigManager) moveFile(old, rel string, lines []string) error {
+	if err := writeFileAtomic(filepath.Join(m.RootDir, rel), lines); err != nil {
+		return err
+	}
+	if old == "" || old == rel || filepath.Base(old) != filepath.Base(rel) {
+		return nil
+	}
+	if err := m.removeFile(old); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", old, err)
+	}
+
+	return nil
+}
+
+// writeFileAtomic writes lines to path through a temporary file, unless the
+// file already holds them.
+func writeFileAtomic(path string, lines []string) error {
+	return writeContentAtomic(path, fileContent(lines))
+}
+
+// tagFromFile returns the tag an options file name refers to.
+func tagFromFile(name string) string {
+	return strings.TrimPrefix(strings.TrimSuffix(name, fileSuffix), filePrefix)
+}
+
+// validateTag checks that tag can be written into dnsmasq lines and used as
+// a file name: separators, path elements and control characters such as line
+// breaks, which would start another line, are rejected.
+func validateTag(tag string) error {
+	if tag == "" {
+		return errors.New("tag must not be empty")
+	}
+	if strings.ContainsAny(tag, ", :/\\") || strings.ContainsFunc(tag, unicode.IsControl) ||
+		tag == "." || tag == ".." {
+		return fmt.Errorf("invalid tag %q", tag)
+	}
+
+	return nil
+}
+
+func cloneOptions(opts []DHCPOption) []DHCPOption {
+	out := make([]DHCPOption, len(opts))
+	for i, o := range opts {
+		out[i] = o
+		out[i].Tags = append([]string(nil), o.Tags...)
+	}
+
+	return out
+}
@@ -0,0 +1,317 @@
+package config
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestParseHostEntry(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{line: "9c:6b:00:70:59:8a,set:node-1,set:ironic", want: "9c:6b:00:70:59:8a,set:node-1,set:ironic"},
+		{line: "d8:3a:dd:61:4d:15,ignore", want: "d8:3a:dd:61:4d:15,ignore"},
+		{line: "aa:bb:cc:dd:ee:ff,192.168.1.10,node-2,12h", want: "aa:bb:cc:dd:ee:ff,192.168.1.10,node-2,12h"},
+		{
+			line: "aa:bb:cc:dd:ee:ff,192.168.1.10,[2001:db8:0::10],node-2",
+			want: "aa:bb:cc:dd:ee:ff,192.168.1.10,[2001:db8::10],node-2",
+		},
+	}
+	for _, tt := range tests {
+		entry, err := ParseHostEntry(tt.line)
+		if err != nil {
+			t.Fatalf("ParseHostEntry(%q) error = %v", tt.line, err)
+		}
+		if got := entry.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+
+	if _, err := ParseHostEntry("not-a-mac,ignore"); err == nil {
+		t.Error("expected error for invalid MAC")
+	}
+}
+
+func TestParseDHCPOption(t *testing.T) {
+	opt, err := ParseDHCPOption("tag:node-1,tag:!ipxe,option:bootfile-name,ipxe.efi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opt.Code != 67 || opt.Value != "ipxe.efi" || len(opt.Tags) != 2 || opt.Tags[1] != "!ipxe" {
+		t.Fatalf("unexpected option: %+v", opt)
+	}
+	if got := opt.String(); got != "tag:node-1,tag:!ipxe,67,ipxe.efi" {
+		t.Errorf("String() = %q", got)
+	}
+
+	opt, err = ParseDHCPOption("tag:node-1,6,1.1.1.1,8.8.8.8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opt.Value != "1.1.1.1,8.8.8.8" {
+		t.Errorf("Value = %q, want list preserved", opt.Value)
+	}
+}
+
+func TestDHCPOptionValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opt     DHCPOption
+		wantErr bool
+	}{
+		{name: "bootfile", opt: DHCPOption{Code: 67, Value: "ipxe.efi"}},
+		{name: "dns list", opt: DHCPOption{Code: 6, Value: "1.1.1.1,8.8.8.8"}},
+		{name: "reserved code", opt: DHCPOption{Code: 255, Value: "x"}, wantErr: true},
+		{name: "bad router", opt: DHCPOption{Code: 3, Value: "gateway"}, wantErr: true},
+		{name: "bad lease time", opt: DHCPOption{Code: 51, Value: "-1"}, wantErr: true},
+		{name: "empty value", opt: DHCPOption{Code: 66}, wantErr: true},
+		{name: "bad tag", opt: DHCPOption{Tags: []string{"a,b"}, Code: 66, Value: "x"}, wantErr: true},
+		{
+			name:    "tag with a line break",
+			opt:     DHCPOption{Tags: []string{"a\ndhcp-option=3,10.0.0.1"}, Code: 66, Value: "x"},
+			wantErr: true,
+		},
+		{name: "tag with a carriage return", opt: DHCPOption{Tags: []string{"a\rb"}, Code: 66, Value: "x"}, wantErr: true},
+		{name: "tag with a colon", opt: DHCPOption{Tags: []string{"set:a"}, Code: 66, Value: "x"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.opt.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHostEntryValidateTags(t *testing.T) {
+	mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:ff")
+	for _, tag := range []string{"node\ndhcp-host=11:22:33:44:55:66,10.0.0.9", "node\x00", "tag:node"} {
+		if err := (HostEntry{MAC: mac, Tags: []string{tag}}).Validate(); err == nil {
+			t.Errorf("Validate() of tag %q = nil, want an error", tag)
+		}
+	}
+}
+
+func TestConfigManager(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, HostsDir), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(
+		filepath.Join(root, HostsDir, "ironic-9c:6b:00:70:59:8a.conf"),
+		[]byte("9c:6b:00:70:59:8a,set:node-1,set:ironic\n"),
+		0o644,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewConfigManager(logr.Discard(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hosts := m.Hosts(); len(hosts) != 1 || !hosts[0].HasTag("ironic") {
+		t.Fatalf("Hosts() = %+v", hosts)
+	}
+
+	if err := m.AddOption("node-1", DHCPOption{Tags: []string{"node-1", "!ipxe"}, Code: 67, Value: "ipxe.efi"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddOption("node-1", DHCPOption{Tags: []string{"node-1"}, Code: 66, Value: "192.168.1.1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddOption("node-1", DHCPOption{Code: 3, Value: "nope"}); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("AddOption(invalid) error = %v, want ErrInvalid", err)
+	}
+
+	b, err := os.ReadFile(filepath.Join(root, OptsDir, "ironic-node-1.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "tag:node-1,tag:!ipxe,67,ipxe.efi\ntag:node-1,66,192.168.1.1\n"; string(b) != want {
+		t.Errorf("options file = %q, want %q", b, want)
+	}
+
+	if err := m.RemoveOption("node-1", 67); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RemoveOption("node-1", 67); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("RemoveOption(missing) error = %v, want ErrNotFound", err)
+	}
+
+	reloaded, err := NewConfigManager(logr.Discard(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts, err := reloaded.Options("node-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(opts) != 1 || opts[0].Code != 66 {
+		t.Fatalf("Options() after reload = %+v", opts)
+	}
+
+	if err := reloaded.DeleteOptions("node-1"); err != nil {
+		t.Fatal(err)
+	}
+	if tags := reloaded.Tags(); len(tags) != 0 {
+		t.Errorf("Tags() after delete = %v", tags)
+	}
+}
+
+func TestSaveConfig(t *testing.T) {
+	root := t.TempDir()
+	m, err := NewConfigManager(logr.Discard(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetOptions("node-1", []DHCPOption{
+		{Tags: []string{"node-1"}, Code: 66, Value: "10.0.0.1"},
+		{Tags: []string{"node-1"}, Code: 67, Value: "ipxe.efi"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetOptions("old", []DHCPOption{{Code: 66, Value: "10.0.0.2"}}); err != nil {
+		t.Fatal(err)
+	}
+	hostFile := filepath.Join(HostsDir, "ironic-9c:6b:00:70:59:8a.conf")
+	host, err := ParseHostEntry("9c:6b:00:70:59:8a,set:node-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := m.Snapshot()
+	snapshot.Hosts = append(snapshot.Hosts, *host)
+	delete(snapshot.Options, "old")
+	snapshot.Options["node-1"][1].Value = "snp.efi"
+
+	changes, err := m.SaveConfig(snapshot, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []FileChange{
+		{File: hostFile, Op: ChangeCreate, Diff: "+9c:6b:00:70:59:8a,set:node-1\n"},
+		{File: filepath.Join(OptsDir, "ironic-node-1.conf"), Op: ChangeUpdate,
+			Diff: "-tag:node-1,67,ipxe.efi\n+tag:node-1,67,snp.efi\n"},
+		{File: filepath.Join(OptsDir, "ironic-old.conf"), Op: ChangeDelete, Diff: "-66,10.0.0.2\n"},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("SaveConfig(dry run) = %+v, want %+v", changes, want)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Errorf("change %d = %+v, want %+v", i, changes[i], want[i])
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, hostFile)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("dry run created %s", hostFile)
+	}
+
+	optsFile := filepath.Join(root, OptsDir, "ironic-node-1.conf")
+	if _, err := m.SaveConfig(snapshot, false); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.Stat(optsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, OptsDir, "ironic-old.conf")); !errors.Is(err, os.ErrNotExist) {
+		t.Error("SaveConfig() kept the options file of a removed tag")
+	}
+	if _, ok := m.GetHost(host.MAC); !ok {
+		t.Error("SaveConfig() did not update the in-memory hosts")
+	}
+
+	changes, err = m.SaveConfig(snapshot, false)
+	if err != nil || len(changes) != 0 {
+		t.Fatalf("SaveConfig(unchanged) = %+v, %v, want no changes", changes, err)
+	}
+	after, err := os.Stat(optsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(before, after) {
+		t.Error("SaveConfig(unchanged) replaced an unchanged file")
+	}
+}
+
+func TestSharding(t *testing.T) {
+	root := t.TempDir()
+	flat := filepath.Join(root, HostsDir, "ironic-9c:6b:00:70:59:8a.conf")
+	if err := os.MkdirAll(filepath.Dir(flat), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(flat, []byte("9c:6b:00:70:59:8a,set:node-1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewConfigManager(logr.Discard(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Sharding = ShardOUI
+
+	entry, ok := m.GetHost(mustMAC(t, "9c:6b:00:70:59:8a"))
+	if !ok {
+		t.Fatal("GetHost() did not find the flat host file")
+	}
+	entry.Tags = append(entry.Tags, "ironic")
+	if err := m.SetHost(entry); err != nil {
+		t.Fatal(err)
+	}
+	sharded := filepath.Join(root, HostsDir, "9c6b00", "ironic-9c:6b:00:70:59:8a.conf")
+	if _, err := os.Stat(sharded); err != nil {
+		t.Errorf("SetHost() did not write %s: %v", sharded, err)
+	}
+	if _, err := os.Stat(flat); !errors.Is(err, os.ErrNotExist) {
+		t.Error("SetHost() kept the host file in the old layout")
+	}
+
+	m.Sharding = ShardHash
+	if err := m.SetOptions("node-1", []DHCPOption{{Code: 66, Value: "10.0.0.1"}}); err != nil {
+		t.Fatal(err)
+	}
+	optsFile := filepath.Join(root, m.optionsFile("node-1"))
+	if filepath.Base(filepath.Dir(optsFile)) == OptsDir {
+		t.Errorf("options file %s is not in a shard", optsFile)
+	}
+
+	// A file changed behind the manager's back is parsed again on Load.
+	if err := os.WriteFile(optsFile, []byte("66,10.0.0.1\n67,snp.efi\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if opts, err := m.Options("node-1"); err != nil || len(opts) != 2 {
+		t.Errorf("Options() after Load = %+v, %v, want 2 options", opts, err)
+	}
+	if h, ok := m.GetHost(entry.MAC); !ok || !h.HasTag("ironic") {
+		t.Errorf("GetHost() after Load = %+v, %v", h, ok)
+	}
+
+	if err := m.RemoveHost(entry.MAC); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(sharded); !errors.Is(err, os.ErrNotExist) {
+		t.Error("RemoveHost() kept the sharded host file")
+	}
+	if err := Sharding("bogus").Validate(); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Validate(bogus) = %v, want ErrInvalid", err)
+	}
+}
+
+func mustMAC(t *testing.T, s string) net.HardwareAddr {
+	t.Helper()
+	mac, err := net.ParseMAC(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return mac
+}
@@ -0,0 +1,264 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Snapshot is the complete content of the hosts and opts directories.
+type Snapshot struct {
+	Hosts   []HostEntry             `json:"hosts"`
+	Options map[string][]DHCPOption `json:"options"`
+}
+
+// ChangeOp is what SaveConfig does to a file.
+type ChangeOp string
+
+// File changes.
+const (
+	ChangeCreate ChangeOp = "create"
+	ChangeUpdate ChangeOp = "update"
+	ChangeDelete ChangeOp = "delete"
+)
+
+// FileChange describes how SaveConfig changes one file.
+type FileChange struct {
+	// File is the path of the file relative to RootDir.
+	File string   `json:"file"`
+	Op   ChangeOp `json:"op"`
+	// Diff lists the removed lines prefixed with "-" and the added lines
+	// prefixed with "+".
+	Diff string `json:"diff"`
+}
+
+// Snapshot returns the in-memory model of both directories.
+func (m *ConfigManager) Snapshot() Snapshot {
+	hosts := m.Hosts()
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	options := make(map[string][]DHCPOption, len(m.options))
+	for tag, opts := range m.options {
+		options[tag] = cloneOptions(opts)
+	}
+
+	return Snapshot{Hosts: hosts, Options: options}
+}
+
+// SaveConfig makes the hosts and opts directories hold exactly s. Only files
+// whose content differs are written or removed, so that unchanged entries do
+// not wake up anything watching the directories. With dryRun set nothing is
+// written and the returned changes describe what would happen.
+func (m *ConfigManager) SaveConfig(s Snapshot, dryRun bool) ([]FileChange, error) {
+	want, err := m.snapshotFiles(s)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	have, err := m.readFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []FileChange
+	for _, file := range slices.Sorted(maps.Keys(want)) {
+		current, exists := have[file]
+		if exists && current == want[file] {
+			continue
+		}
+		op := ChangeCreate
+		if exists {
+			op = ChangeUpdate
+		}
+		changes = append(changes, FileChange{
+			File: file,
+			Op:   op,
+			Diff: diffLines(current, want[file]),
+		})
+	}
+	for _, file := range slices.Sorted(maps.Keys(have)) {
+		if _, ok := want[file]; !ok {
+			changes = append(changes, FileChange{
+				File: file,
+				Op:   ChangeDelete,
+				Diff: diffLines(have[file], ""),
+			})
+		}
+	}
+	if dryRun {
+		return changes, nil
+	}
+
+	for _, c := range changes {
+		if c.Op == ChangeDelete {
+			if err := m.removeFile(c.File); err != nil {
+				return nil, fmt.Errorf("failed to remove %s: %w", c.File, err)
+			}
+			continue
+		}
+		if err := writeContentAtomic(filepath.Join(m.RootDir, c.File), want[c.File]); err != nil {
+			return nil, err
+		}
+	}
+
+	m.hosts = make(map[string]*HostEntry, len(s.Hosts))
+	m.hostFiles = make(map[string]string, len(s.Hosts))
+	for _, h := range s.Hosts {
+		stored := h
+		stored.Tags = append([]string(nil), h.Tags...)
+		stored.Extra = append([]string(nil), h.Extra...)
+		m.hosts[h.MAC.String()] = &stored
+		m.hostFiles[h.MAC.String()] = m.hostFile(h.MAC)
+	}
+	m.options = make(map[string][]DHCPOption, len(s.Options))
+	m.optFiles = make(map[string]string, len(s.Options))
+	for tag, opts := range s.Options {
+		m.options[tag] = cloneOptions(opts)
+		m.optFiles[tag] = m.optionsFile(tag)
+	}
+	if len(changes) > 0 {
+		m.Log.Info("saved dnsmasq config", "changed_files", len(changes))
+	}
+
+	return changes, nil
+}
+
+// snapshotFiles validates s and renders it into file contents keyed by the
+// path relative to RootDir.
+func (m *ConfigManager) snapshotFiles(s Snapshot) (map[string]string, error) {
+	files := make(map[string]string)
+	for _, h := range s.Hosts {
+		if err := h.Validate(); err != nil {
+			return nil, fmt.Errorf("%w: host %s: %w", ErrInvalid, h.MAC, err)
+		}
+		file := m.hostFile(h.MAC)
+		if _, ok := files[file]; ok {
+			return nil, fmt.Errorf("%w: duplicate host %s", ErrInvalid, h.MAC)
+		}
+		files[file] = fileContent([]string{h.String()})
+	}
+	for tag, opts := range s.Options {
+		if err := validateTag(tag); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalid, err)
+		}
+		lines := make([]string, 0, len(opts))
+		for i, o := range opts {
+			if err := o.Validate(); err != nil {
+				return nil, fmt.Errorf("%w: tag %s option %d: %w", ErrInvalid, tag, i, err)
+			}
+			lines = append(lines, o.String())
+		}
+		files[m.optionsFile(tag)] = fileContent(lines)
+	}
+
+	return files, nil
+}
+
+// readFiles returns the content of every managed file keyed by the path
+// relative to RootDir. Callers must hold m.mu.
+func (m *ConfigManager) readFiles() (map[string]string, error) {
+	infos, err := m.listFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]string, len(infos))
+	for rel := range infos {
+		if !strings.HasPrefix(filepath.Base(rel), filePrefix) {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(m.RootDir, rel))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", rel, err)
+		}
+		files[rel] = string(content)
+	}
+
+	return files, nil
+}
+
+// fileContent joins lines into the content of a config file.
+func fileContent(lines []string) string {
+	content := strings.Join(lines, "\n")
+	if content != "" {
+		content += "\n"
+	}
+
+	return content
+}
+
+// writeContentAtomic writes content to path through a temporary file. It
+// leaves the file alone if it already holds content.
+func writeContentAtomic(path, content string) error {
+	if current, err := os.ReadFile(path); err == nil && bytes.Equal(current, []byte(content)) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(content), 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filepath.Base(path), err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", filepath.Base(path), err)
+	}
+
+	return nil
+}
+
+// diffLines returns the lines removed from a and added in b, in the order of
+// a longest common subsequence of both.
+func diffLines(a, b string) string {
+	x := strings.Split(strings.TrimSuffix(a, "\n"), "\n")
+	y := strings.Split(strings.TrimSuffix(b, "\n"), "\n")
+	if a == "" {
+		x = nil
+	}
+	if b == "" {
+		y = nil
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of x[i:] and y[j:].
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var out strings.Builder
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			i++
+			j++
+		case i < len(x) && (j == len(y) || lcs[i+1][j] >= lcs[i][j+1]):
+			out.WriteString("-" + x[i] + "\n")
+			i++
+		default:
+			out.WriteString("+" + y[j] + "\n")
+			j++
+		}
+	}
+
+	return out.String()
+}
@@ -0,0 +1,175 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Sharding selects how host and options files are spread over subdirectories
+// of the hosts and opts directories. dnsmasq does not descend into
+// subdirectories, so every shard has to be listed as a dhcp-hostsdir or
+// dhcp-optsdir of its own when dnsmasq reads the files as well.
+type Sharding string
+
+const (
+	// ShardNone keeps every file directly in the hosts and opts directories.
+	ShardNone Sharding = ""
+	// ShardOUI puts host files into a subdirectory per vendor prefix of the
+	// MAC, such as hosts/9c6b00, and options files into hash shards.
+	ShardOUI Sharding = "oui"
+	// ShardHash puts files into one of 256 subdirectories, hosts/00 to
+	// hosts/ff, by a hash of the MAC or tag.
+	ShardHash Sharding = "hash"
+)
+
+// Validate reports whether s is a known sharding.
+func (s Sharding) Validate() error {
+	switch s {
+	case ShardNone, ShardOUI, ShardHash:
+		return nil
+	default:
+		return fmt.Errorf("%w: unknown sharding %q", ErrInvalid, s)
+	}
+}
+
+// hostShard returns the subdirectory of the host file of mac.
+func (s Sharding) hostShard(mac net.HardwareAddr) string {
+	if s == ShardOUI && len(mac) >= 3 {
+		return fmt.Sprintf("%02x%02x%02x", mac[0], mac[1], mac[2])
+	}
+
+	return s.hashShard(mac.String())
+}
+
+// hashShard returns the subdirectory of the file of name, or "" when files
+// are not sharded.
+func (s Sharding) hashShard(name string) string {
+	if s == ShardNone {
+		return ""
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name))
+
+	return fmt.Sprintf("%02x", h.Sum32()&0xff)
+}
+
+// hostFile returns the path of the host file of mac relative to RootDir.
+func (m *ConfigManager) hostFile(mac net.HardwareAddr) string {
+	return filepath.Join(HostsDir, m.Sharding.hostShard(mac), filePrefix+mac.String()+fileSuffix)
+}
+
+// optionsFile returns the path of the options file of tag relative to RootDir.
+func (m *ConfigManager) optionsFile(tag string) string {
+	return filepath.Join(OptsDir, m.Sharding.hashShard(tag), filePrefix+tag+fileSuffix)
+}
+
+// indexedFile is a parsed config file together with the modification time
+// and size it had when it was parsed, so that Load can skip unchanged files.
+type indexedFile struct {
+	modTime time.Time
+	size    int64
+	hosts   []*HostEntry
+	options []DHCPOption
+}
+
+// listFiles returns every .conf file in the hosts and opts directories and
+// their shard subdirectories, keyed by the path relative to RootDir.
+func (m *ConfigManager) listFiles() (map[string]os.FileInfo, error) {
+	files := make(map[string]os.FileInfo)
+	for _, dir := range []string{HostsDir, OptsDir} {
+		if err := m.listDir(dir, true, files); err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
+
+func (m *ConfigManager) listDir(dir string, shards bool, files map[string]os.FileInfo) error {
+	entries, err := os.ReadDir(filepath.Join(m.RootDir, dir))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s directory: %w", dir, err)
+	}
+
+	for _, e := range entries {
+		rel := filepath.Join(dir, e.Name())
+		if e.IsDir() {
+			if shards {
+				if err := m.listDir(rel, false, files); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if !strings.HasSuffix(e.Name(), fileSuffix) {
+			continue
+		}
+		info, err := e.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to stat %s: %w", rel, err)
+		}
+		files[rel] = info
+	}
+
+	return nil
+}
+
+// parseFile parses the host or options file at rel. Lines that cannot be
+// parsed are logged and skipped.
+func (m *ConfigManager) parseFile(rel string, info os.FileInfo) (*indexedFile, error) {
+	file, err := os.Open(filepath.Join(m.RootDir, rel))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", rel, err)
+	}
+	defer file.Close()
+
+	f := &indexedFile{modTime: info.ModTime(), size: info.Size()}
+	isHosts := strings.HasPrefix(rel, HostsDir+string(filepath.Separator))
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if isHosts {
+			entry, err := ParseHostEntry(line)
+			if err == nil {
+				f.hosts = append(f.hosts, entry)
+				continue
+			}
+			m.logParseError(err, rel, lineNum, line)
+			continue
+		}
+		opt, err := ParseDHCPOption(line)
+		if err != nil {
+			m.logParseError(err, rel, lineNum, line)
+			continue
+		}
+		f.options = append(f.options, *opt)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", rel, err)
+	}
+
+	return f, nil
+}
+
+func (m *ConfigManager) logParseError(err error, file string, line int, content string) {
+	m.Log.Error(err, "failed to parse dnsmasq config line",
+		"file", file, "line", line, "content", content)
+}
@@ -16,8 +16,10 @@ import (
 	"time"
 
 	"github.com/go-logr/logr"
+	dnsmasqconfig "github.com/metal3-community/metal-boot/internal/backend/dnsmasq/config"
 	"github.com/metal3-community/metal-boot/internal/backend/dnsmasq/lease"
 	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+	"github.com/metal3-community/metal-boot/internal/filewatch"
 	"github.com/metal3-community/metal-boot/internal/util"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/codes"
@@ -36,24 +38,23 @@ var (
 // Backend implements the BackendReader and BackendWriter interfaces using DNSMasq-compatible
 // lease and configuration files.
 type Backend struct {
-	mu           sync.RWMutex
-	leaseManager *lease.LeaseManager
-	log          logr.Logger
+	mu            sync.RWMutex
+	leaseManager  *lease.LeaseManager
+	configManager *dnsmasqconfig.ConfigManager
+	log           logr.Logger
 
 	// Configuration
 	rootDir    string
 	tftpServer string
 	httpServer string
+	pidFile    string
 
 	// Automatic lease assignment
 	autoAssignEnabled bool
-	ipPoolStart       net.IP
-	ipPoolEnd         net.IP
-	defaultLeaseTime  uint32
-	defaultGateway    string
-	defaultSubnet     string
-	defaultDNS        []string
-	defaultDomain     string
+	// defaultPool holds the default network settings, and the range of
+	// IPPoolStart and IPPoolEnd.
+	defaultPool *pool
+	pools       []*pool
 }
 
 // Config holds configuration for the DNSMasq backend.
@@ -71,55 +72,72 @@ type Config struct {
 	DefaultSubnet     string
 	DefaultDNS        []string
 	DefaultDomain     string
+	// Pools are further ranges assigned to the hosts of other networks,
+	// selected by the relay agent their packets come through.
+	Pools []Pool
+
+	// Sharding is the layout of newly written host and options files.
+	Sharding dnsmasqconfig.Sharding
+
+	// PIDFile is the pid file of the dnsmasq to send SIGHUP after a host is
+	// written through BackendHostWriter. Empty sends none.
+	PIDFile string
+
+	// Watch selects how the lease file is watched for changes.
+	Watch filewatch.Options
 }
 
 // NewBackend creates a new DNSMasq backend.
 func NewBackend(log logr.Logger, config Config) (*Backend, error) {
-	leaseManager, err := lease.NewLeaseManager(log, filepath.Join(config.RootDir, "dnsmasq.leases"))
+	leaseFile := filepath.Join(config.RootDir, "dnsmasq.leases")
+	leaseManager, err := lease.NewLeaseManager(log, leaseFile, config.Watch)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create lease manager: %w", err)
 	}
 
+	if err := config.Sharding.Validate(); err != nil {
+		leaseManager.Close()
+		return nil, err
+	}
+	configManager, err := dnsmasqconfig.NewConfigManager(log, config.RootDir)
+	if err != nil {
+		leaseManager.Close()
+		return nil, fmt.Errorf("failed to create config manager: %w", err)
+	}
+	configManager.Sharding = config.Sharding
+
 	backend := &Backend{
-		leaseManager: leaseManager,
-		log:          log,
-		rootDir:      config.RootDir,
-		tftpServer:   config.TFTPServer,
-		httpServer:   config.HTTPServer,
+		leaseManager:  leaseManager,
+		configManager: configManager,
+		log:           log,
+		rootDir:       config.RootDir,
+		tftpServer:    config.TFTPServer,
+		httpServer:    config.HTTPServer,
+		pidFile:       config.PIDFile,
 
 		// Auto assignment settings
 		autoAssignEnabled: config.AutoAssignEnabled,
-		defaultLeaseTime:  config.DefaultLeaseTime,
-		defaultGateway:    config.DefaultGateway,
-		defaultSubnet:     config.DefaultSubnet,
-		defaultDNS:        config.DefaultDNS,
-		defaultDomain:     config.DefaultDomain,
-	}
-
-	// Parse IP pool range if auto assignment is enabled
-	if config.AutoAssignEnabled {
-		if config.IPPoolStart != "" {
-			ipStart := net.ParseIP(config.IPPoolStart)
-			if ipStart == nil {
-				leaseManager.Close()
-				return nil, fmt.Errorf("invalid IP pool start address: %s", config.IPPoolStart)
-			}
-			backend.ipPoolStart = ipStart
-		}
+	}
 
-		if config.IPPoolEnd != "" {
-			ipEnd := net.ParseIP(config.IPPoolEnd)
-			if ipEnd == nil {
-				leaseManager.Close()
-				return nil, fmt.Errorf("invalid IP pool end address: %s", config.IPPoolEnd)
-			}
-			backend.ipPoolEnd = ipEnd
+	// Parse the IP pools; their ranges are only used with auto assignment
+	backend.defaultPool, err = newDefaultPool(config)
+	if err != nil {
+		leaseManager.Close()
+		return nil, err
+	}
+	names := map[string]bool{}
+	for _, c := range config.Pools {
+		p, err := newPool(c, backend.defaultPool)
+		if err != nil {
+			leaseManager.Close()
+			return nil, err
 		}
-
-		// Set default lease time if not specified
-		if backend.defaultLeaseTime == 0 {
-			backend.defaultLeaseTime = 604800 // 1 week default
+		if names[p.name] {
+			leaseManager.Close()
+			return nil, fmt.Errorf("duplicate IP pool %s", p.name)
 		}
+		names[p.name] = true
+		backend.pools = append(backend.pools, p)
 	}
 
 	// Load existing data
@@ -137,9 +155,18 @@ func (b *Backend) loadData() error {
 		return fmt.Errorf("failed to load leases: %w", err)
 	}
 
+	if err := b.configManager.Load(); err != nil {
+		return fmt.Errorf("failed to load host and option files: %w", err)
+	}
+
 	return nil
 }
 
+// ConfigManager returns the manager for the hosts and opts directories.
+func (b *Backend) ConfigManager() *dnsmasqconfig.ConfigManager {
+	return b.configManager
+}
+
 // GetByMac implements BackendReader.GetByMac.
 func (b *Backend) GetByMac(
 	ctx context.Context,
@@ -149,15 +176,37 @@ func (b *Backend) GetByMac(
 	_, span := tracer.Start(ctx, "backend.dnsmasq.GetByMac")
 	defer span.End()
 
+	relay, relayed := data.RelayFromContext(ctx)
+	p := b.selectPool(relay, relayed)
+
 	b.mu.RLock()
-	lease, exists := b.leaseManager.GetLease(mac)
+	lease, exists := b.reservedLease(mac)
+	if !exists {
+		lease, exists = b.leaseManager.GetLease(mac)
+		// A host that moved to another network is given an address of the
+		// pool of its new network.
+		if exists && relayed && b.autoAssignEnabled && p.hasRange() && !p.holds(lease.IP) {
+			b.log.Info("lease is outside the pool of the relay agent, reassigning",
+				"mac", mac.String(), "ip", lease.IP.String(), "pool", p.name)
+			exists = false
+		}
+	}
+	ipv6Only, hasIPv6 := b.reservedIPv6(mac)
 	b.mu.RUnlock()
 
+	// A host with only an IPv6 reservation is not given an IPv4 lease.
+	if !exists && hasIPv6 {
+		netbootData := b.getNetbootData(mac)
+		span.SetAttributes(ipv6Only.EncodeToAttributes()...)
+		span.SetStatus(codes.Ok, "")
+		return ipv6Only, netbootData, nil
+	}
+
 	if !exists && b.autoAssignEnabled {
 		// Automatically assign a lease for unknown MAC addresses
-		b.log.Info("MAC address not found, auto-assigning lease", "mac", mac.String())
+		b.log.Info("MAC address not found, auto-assigning lease", "mac", mac.String(), "pool", p.name)
 
-		assignedIP, err := b.assignIPForMAC(mac)
+		assignedIP, err := b.assignIPForMAC(mac, p)
 		if err != nil {
 			err = fmt.Errorf("failed to auto-assign IP for MAC %s: %w", mac.String(), err)
 			span.SetStatus(codes.Error, err.Error())
@@ -167,7 +216,7 @@ func (b *Backend) GetByMac(
 		// Create and store the new lease
 		hostname := fmt.Sprintf("auto-%s", mac.String())
 		b.mu.Lock()
-		b.leaseManager.AddLease(mac, assignedIP, hostname, b.defaultLeaseTime)
+		b.leaseManager.AddLease(mac, assignedIP, hostname, p.leaseTime)
 
 		b.mu.Unlock()
 
@@ -202,6 +251,11 @@ func (b *Backend) GetByMac(
 		return nil, nil, err
 	}
 
+	dhcpData.Options = b.hostOptions(mac)
+	if hasIPv6 {
+		dhcpData.IPv6Address = ipv6Only.IPv6Address
+	}
+
 	// Get netboot options from config
 	netbootData := b.getNetbootData(mac)
 
@@ -224,6 +278,36 @@ func (b *Backend) GetByIP(
 	b.mu.RLock()
 	defer b.mu.RUnlock()
 
+	// Static reservations take precedence over dynamic leases
+	if mac, ok := b.configManager.ReservedIPs()[ip.String()]; ok {
+		ipv6Only, hasIPv6 := b.reservedIPv6(mac)
+		lease, ok := b.reservedLease(mac)
+		if !ok && hasIPv6 {
+			netbootData := b.getNetbootData(mac)
+			span.SetAttributes(ipv6Only.EncodeToAttributes()...)
+			span.SetStatus(codes.Ok, "")
+			return ipv6Only, netbootData, nil
+		}
+		if ok {
+			dhcpData, err := b.leaseToDHCP(lease)
+			if err != nil {
+				span.SetStatus(codes.Error, err.Error())
+				return nil, nil, err
+			}
+			dhcpData.Options = b.hostOptions(mac)
+			if hasIPv6 {
+				dhcpData.IPv6Address = ipv6Only.IPv6Address
+			}
+			netbootData := b.getNetbootData(mac)
+
+			span.SetAttributes(dhcpData.EncodeToAttributes()...)
+			span.SetAttributes(netbootData.EncodeToAttributes()...)
+			span.SetStatus(codes.Ok, "")
+
+			return dhcpData, netbootData, nil
+		}
+	}
+
 	// Find lease by IP
 	leases := b.leaseManager.GetActiveLeases()
 	for _, lease := range leases {
@@ -234,6 +318,7 @@ func (b *Backend) GetByIP(
 				return nil, nil, err
 			}
 
+			dhcpData.Options = b.hostOptions(lease.MAC)
 			netbootData := b.getNetbootData(lease.MAC)
 
 			span.SetAttributes(dhcpData.EncodeToAttributes()...)
@@ -269,6 +354,108 @@ func (b *Backend) GetKeys(ctx context.Context) ([]net.HardwareAddr, error) {
 	return keys, nil
 }
 
+// HasReservation reports whether mac has a static reservation, as opposed to
+// a dynamically assigned lease.
+func (b *Backend) HasReservation(mac net.HardwareAddr) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	_, ok := b.reservedLease(mac)
+	return ok
+}
+
+// LeaseExpiry returns when the lease of mac expires, if it has one.
+func (b *Backend) LeaseExpiry(mac net.HardwareAddr) (time.Time, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	l, ok := b.leaseManager.GetLease(mac)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	return time.Unix(l.Expiry, 0), true
+}
+
+// ActiveLeases returns the number of unexpired leases.
+func (b *Backend) ActiveLeases() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return len(b.leaseManager.GetActiveLeases())
+}
+
+// PoolUsage returns how many addresses of the automatic assignment pools are
+// leased or reserved, and the size of the pools together. size is 0 when
+// automatic assignment is disabled.
+func (b *Backend) PoolUsage() (used, size int) {
+	for _, u := range b.PoolsUsage() {
+		used += u.Used
+		size += u.Size
+	}
+
+	return used, size
+}
+
+// PoolUsage is the usage of one automatic assignment pool.
+type PoolUsage struct {
+	Name string `json:"name"`
+	// Subnet is empty for the default pool.
+	Subnet string `json:"subnet,omitempty"`
+	Start  string `json:"start"`
+	End    string `json:"end"`
+	Used   int    `json:"used"`
+	Size   int    `json:"size"`
+}
+
+// PoolsUsage returns the usage of every automatic assignment pool with a
+// range, the default pool first. It returns none when automatic assignment
+// is disabled.
+func (b *Backend) PoolsUsage() []PoolUsage {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if !b.autoAssignEnabled {
+		return nil
+	}
+
+	inUse := make(map[uint32]bool)
+	for _, l := range b.leaseManager.GetActiveLeases() {
+		if ip := l.IP.To4(); ip != nil {
+			inUse[ipToInt(ip)] = true
+		}
+	}
+	for ip := range b.configManager.ReservedIPs() {
+		if addr := net.ParseIP(ip).To4(); addr != nil {
+			inUse[ipToInt(addr)] = true
+		}
+	}
+
+	var out []PoolUsage
+	for _, p := range b.allPools() {
+		if !p.hasRange() {
+			continue
+		}
+		u := PoolUsage{
+			Name:  p.name,
+			Start: intToIP(p.start).String(),
+			End:   intToIP(p.end).String(),
+			Size:  int(p.end - p.start + 1),
+		}
+		if p.subnet.IsValid() {
+			u.Subnet = p.subnet.String()
+		}
+		for ip := range inUse {
+			if ip >= p.start && ip <= p.end {
+				u.Used++
+			}
+		}
+		out = append(out, u)
+	}
+
+	return out
+}
+
 // Put implements BackendWriter.Put.
 func (b *Backend) Put(
 	ctx context.Context,
@@ -350,66 +537,84 @@ func (b *Backend) save() error {
 	return nil
 }
 
-func (b *Backend) getNameServers() []net.IP {
-	var nameServers []net.IP
+// leaseToDHCP converts a Lease to data.DHCP, with the network settings of the
+// pool of its address.
+func (b *Backend) leaseToDHCP(lease *lease.Lease) (*data.DHCP, error) {
+	ipAddr, err := netip.ParseAddr(lease.IP.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse IP address: %w", err)
+	}
 
-	for _, dnsStr := range b.defaultDNS {
-		if ip := net.ParseIP(dnsStr); ip != nil {
-			nameServers = append(nameServers, ip)
-		}
+	p := b.poolOf(lease.IP)
+	dhcp := &data.DHCP{
+		MACAddress:     lease.MAC,
+		IPAddress:      ipAddr,
+		Hostname:       lease.Hostname,
+		LeaseTime:      uint32(lease.Expiry - time.Now().Unix()),
+		ClientID:       lease.ClientID,
+		SubnetMask:     p.mask,
+		DefaultGateway: p.gateway,
+		NameServers:    p.dns,
+		DomainName:     p.domain,
 	}
 
-	return nameServers
+	return dhcp, nil
 }
 
-func (b *Backend) getDefaultSubnetMask() net.IPMask {
-	if b.defaultSubnet == "" {
-		return net.IPv4Mask(255, 255, 255, 0) // Default /24 subnet
+// hostOptions returns the encoded options from the opts files of the tags set
+// for mac in the hosts directory. Options conditional on the "ipxe" tag are
+// left to the netboot logic, which decides per request whether the client is
+// iPXE.
+func (b *Backend) hostOptions(mac net.HardwareAddr) []data.Option {
+	host, ok := b.configManager.GetHost(mac)
+	if !ok || host.Ignore {
+		return nil
 	}
 
-	// Parse CIDR notation (e.g., "192.168.1.0/24")
-	_, ipNet, err := net.ParseCIDR(b.defaultSubnet)
-	if err != nil {
-		// If not CIDR, try parsing as subnet mask (e.g., "255.255.255.0")
-		mask := net.ParseIP(b.defaultSubnet)
-		if mask != nil {
-			return net.IPv4Mask(mask[12], mask[13], mask[14], mask[15])
+	var out []data.Option
+	for _, tag := range host.Tags {
+		opts, err := b.configManager.Options(tag)
+		if err != nil {
+			continue
+		}
+		for _, o := range opts {
+			if !optionApplies(o, host) {
+				continue
+			}
+			value, err := o.Encode()
+			if err != nil {
+				b.log.Error(err, "skipping invalid dnsmasq option", "mac", mac.String(), "tag", tag)
+				continue
+			}
+			out = append(out, data.Option{Code: o.Code, Value: value})
 		}
-		return net.IPv4Mask(255, 255, 255, 0) // Default /24 subnet
 	}
 
-	return ipNet.Mask
+	return out
 }
 
-// leaseToDHCP converts a Lease to data.DHCP.
-func (b *Backend) leaseToDHCP(lease *lease.Lease) (*data.DHCP, error) {
-	ipAddr, err := netip.ParseAddr(lease.IP.String())
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse IP address: %w", err)
-	}
-
-	dhcp := &data.DHCP{
-		MACAddress:  lease.MAC,
-		IPAddress:   ipAddr,
-		Hostname:    lease.Hostname,
-		LeaseTime:   uint32(lease.Expiry - time.Now().Unix()),
-		ClientID:    lease.ClientID,
-		SubnetMask:  b.getDefaultSubnetMask(),
-		NameServers: b.getNameServers(),
-		DomainName:  b.defaultDomain,
-	}
-
-	defaultGateway, err := netip.ParseAddr(b.defaultGateway)
-	if err == nil {
-		dhcp.DefaultGateway = defaultGateway
+// optionApplies reports whether every tag condition of o is satisfied by host.
+func optionApplies(o dnsmasqconfig.DHCPOption, host dnsmasqconfig.HostEntry) bool {
+	for _, t := range o.Tags {
+		negated := strings.HasPrefix(t, "!")
+		t = strings.TrimPrefix(t, "!")
+		if t == "ipxe" {
+			return false
+		}
+		if host.HasTag(t) == negated {
+			return false
+		}
 	}
 
-	return dhcp, nil
+	return true
 }
 
 // getNetbootData gets netboot configuration for a MAC address.
 func (b *Backend) getNetbootData(mac net.HardwareAddr) *data.Netboot {
 	ipxeUrl := filepath.Join("v1", "boot", mac.String(), "boot.ipxe")
+	if host, ok := b.configManager.GetHost(mac); ok && host.Ignore {
+		return &data.Netboot{AllowNetboot: false}
+	}
 	// Get the host entry for this MAC
 	if util.IsRaspberryPI(mac) {
 		cfgPath := fmt.Sprintf("pxelinux.cfg/%s", mac.String())
@@ -478,22 +683,22 @@ func (b *Backend) Close() error {
 	return nil
 }
 
-// assignIPForMAC assigns an IP address from the pool for a given MAC address.
+// assignIPForMAC assigns an IP address from pool p for a given MAC address.
 // It uses a deterministic hash-based approach to ensure the same MAC gets the same IP.
-func (b *Backend) assignIPForMAC(mac net.HardwareAddr) (net.IP, error) {
-	if !b.autoAssignEnabled || b.ipPoolStart == nil || b.ipPoolEnd == nil {
+func (b *Backend) assignIPForMAC(mac net.HardwareAddr, p *pool) (net.IP, error) {
+	if !b.autoAssignEnabled || p.start == 0 || p.end == 0 {
 		return nil, fmt.Errorf("automatic IP assignment not configured")
 	}
 
 	// Convert IP addresses to integers for calculation
-	startInt := ipToInt(b.ipPoolStart)
-	endInt := ipToInt(b.ipPoolEnd)
+	startInt := p.start
+	endInt := p.end
 
 	if startInt > endInt {
 		return nil, fmt.Errorf(
 			"invalid IP pool range: start %s > end %s",
-			b.ipPoolStart.String(),
-			b.ipPoolEnd.String(),
+			intToIP(startInt).String(),
+			intToIP(endInt).String(),
 		)
 	}
 
@@ -514,6 +719,7 @@ func (b *Backend) assignIPForMAC(mac net.HardwareAddr) (net.IP, error) {
 
 	// Check if this IP is already assigned to a different MAC
 	activeLeases := b.leaseManager.GetActiveLeases()
+	reserved := b.configManager.ReservedIPs()
 
 	// Try the calculated IP first, then search sequentially if occupied
 	for i := range poolSize {
@@ -533,6 +739,11 @@ func (b *Backend) assignIPForMAC(mac net.HardwareAddr) (net.IP, error) {
 			}
 		}
 
+		// Static reservations of other hosts are never handed out
+		if owner, ok := reserved[testIP.String()]; ok && owner.String() != mac.String() {
+			occupied = true
+		}
+
 		// Also check if this IP is currently declined
 		if !occupied && b.leaseManager.IsIPDeclined(testIP.String()) {
 			occupied = true
@@ -544,8 +755,8 @@ func (b *Backend) assignIPForMAC(mac net.HardwareAddr) (net.IP, error) {
 	}
 
 	// If we get here, the pool is full
-	return nil, fmt.Errorf("IP pool exhausted: no available IPs in range %s-%s",
-		b.ipPoolStart.String(), b.ipPoolEnd.String())
+	return nil, fmt.Errorf("IP pool %s exhausted: no available IPs in range %s-%s",
+		p.name, intToIP(startInt).String(), intToIP(endInt).String())
 }
 
 // ipToInt converts an IPv4 address to a uint32.
@@ -637,6 +848,11 @@ func NewConfigFromDnsmasqConfig(dnsmasqConfig any) (Config, error) {
 				config.DefaultDomain = s
 			}
 		}
+		if sharding, exists := cfg["sharding"]; exists {
+			if s, ok := sharding.(string); ok {
+				config.Sharding = dnsmasqconfig.Sharding(s)
+			}
+		}
 	}
 
 	return config, nil
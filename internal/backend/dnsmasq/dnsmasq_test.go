@@ -2,18 +2,24 @@ package dnsmasq
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/netip"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/go-logr/logr"
+	"github.com/metal3-community/metal-boot/internal/backend"
+	dnsmasqconfig "github.com/metal3-community/metal-boot/internal/backend/dnsmasq/config"
 	"github.com/metal3-community/metal-boot/internal/backend/dnsmasq/lease"
 	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+	"github.com/metal3-community/metal-boot/internal/filewatch"
 )
 
 func TestBackendIntegration(t *testing.T) {
@@ -219,7 +225,7 @@ func TestLeaseManagerFileWatching(t *testing.T) {
 	logger := logr.Discard()
 
 	// Create lease manager
-	manager, err := lease.NewLeaseManager(logger, leaseFile)
+	manager, err := lease.NewLeaseManager(logger, leaseFile, filewatch.Options{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -460,3 +466,510 @@ func TestDHCPDeclineWithBackendWriter(t *testing.T) {
 
 	t.Logf("Successfully completed DHCP DECLINE simulation with BackendWriter")
 }
+
+func TestHostOptions(t *testing.T) {
+	tmpDir := t.TempDir()
+	mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:ff")
+
+	for path, content := range map[string]string{
+		"hosts/ironic-aa:bb:cc:dd:ee:ff.conf": "aa:bb:cc:dd:ee:ff,set:node-1,set:ironic\n",
+		"opts/ironic-node-1.conf": strings.Join([]string{
+			"tag:node-1,119,example.com,lab.example.com",
+			"tag:node-1,tag:!ipxe,67,ipxe.efi",
+			"tag:node-1,tag:!ironic,26,1500",
+			"tag:node-1,121,10.0.0.0/8,192.168.1.1",
+		}, "\n"),
+	} {
+		full := filepath.Join(tmpDir, path)
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backend, err := NewBackend(logr.Discard(), Config{RootDir: tmpDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	opts := backend.hostOptions(mac)
+	if len(opts) != 2 {
+		t.Fatalf("hostOptions() = %+v, want options 119 and 121", opts)
+	}
+	if opts[0].Code != 119 || opts[0].Value[len(opts[0].Value)-2] != 0xc0 {
+		t.Errorf("option 119 = %v, want compressed search list", opts[0].Value)
+	}
+	if opts[1].Code != 121 {
+		t.Errorf("second option code = %d, want 121", opts[1].Code)
+	}
+}
+
+func TestHostRecord(t *testing.T) {
+	tmpDir := t.TempDir()
+	mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:ff")
+
+	for path, content := range map[string]string{
+		"hosts/node-1.conf": "aa:bb:cc:dd:ee:ff,set:node-1,set:ironic,192.168.1.50\n",
+		"opts/ironic-node-1.conf": strings.Join([]string{
+			"tag:node-1,26,1500",
+			"tag:node-1,tag:!ironic,26,9000",
+			"tag:node-1,tag:!ipxe,67,ipxe.efi",
+		}, "\n"),
+	} {
+		full := filepath.Join(tmpDir, path)
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backend, err := NewBackend(logr.Discard(), Config{RootDir: tmpDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	rec, ok := backend.HostRecord(mac)
+	if !ok {
+		t.Fatal("HostRecord() found no record")
+	}
+	if rec.Host == nil || !strings.HasPrefix(rec.HostLine, "aa:bb:cc:dd:ee:ff,set:node-1") {
+		t.Errorf("HostLine = %q", rec.HostLine)
+	}
+	if len(rec.Options) != 3 {
+		t.Fatalf("Options = %+v, want 3", rec.Options)
+	}
+	for i, want := range []bool{true, false, false} {
+		if o := rec.Options[i]; o.Applies != want {
+			t.Errorf("option %q applies = %v, want %v", o.Line, o.Applies, want)
+		}
+	}
+	if rec.Options[0].Tag != "node-1" || rec.Options[0].Encoded != "05dc" {
+		t.Errorf("first option = %+v, want MTU 1500 of node-1", rec.Options[0])
+	}
+
+	other, _ := net.ParseMAC("11:22:33:44:55:66")
+	if _, ok := backend.HostRecord(other); ok {
+		t.Error("HostRecord() of an unknown MAC found a record")
+	}
+}
+
+func TestIPv6Reservation(t *testing.T) {
+	tmpDir := t.TempDir()
+	dual, _ := net.ParseMAC("aa:bb:cc:dd:ee:01")
+	v6Only, _ := net.ParseMAC("aa:bb:cc:dd:ee:02")
+
+	hosts := filepath.Join(tmpDir, "hosts")
+	if err := os.MkdirAll(hosts, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		"ironic-aa:bb:cc:dd:ee:01.conf": "aa:bb:cc:dd:ee:01,192.168.1.50,[2001:db8::50],node-1\n",
+		"ironic-aa:bb:cc:dd:ee:02.conf": "aa:bb:cc:dd:ee:02,[2001:db8::51]\n",
+	} {
+		if err := os.WriteFile(filepath.Join(hosts, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backend, err := NewBackend(logr.Discard(), Config{RootDir: tmpDir, AutoAssignEnabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	ctx := context.Background()
+
+	d, _, err := backend.GetByMac(ctx, dual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.IPAddress.String() != "192.168.1.50" || d.IPv6Address.String() != "2001:db8::50" {
+		t.Errorf("GetByMac(%s) = %s, %s; want both reservations", dual, d.IPAddress, d.IPv6Address)
+	}
+
+	d, _, err = backend.GetByMac(ctx, v6Only)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.IPAddress.IsValid() || d.IPv6Address.String() != "2001:db8::51" {
+		t.Errorf("GetByMac(%s) = %s, %s; want only the IPv6 reservation",
+			v6Only, d.IPAddress, d.IPv6Address)
+	}
+
+	d, _, err = backend.GetByIP(ctx, net.ParseIP("2001:db8::50"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.MACAddress.String() != dual.String() {
+		t.Errorf("GetByIP(2001:db8::50) MAC = %s, want %s", d.MACAddress, dual)
+	}
+}
+
+func TestPinAndReleaseLease(t *testing.T) {
+	tmpDir := t.TempDir()
+	ctx := context.Background()
+	mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:ff")
+
+	backend, err := NewBackend(logr.Discard(), Config{
+		RootDir:        tmpDir,
+		DefaultGateway: "192.168.1.1",
+		DefaultDNS:     []string{"192.168.1.2"},
+		DefaultDomain:  "lab",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	if _, err := backend.PinLease(ctx, mac); err == nil {
+		t.Fatal("expected error pinning a MAC without a lease")
+	}
+
+	ip := netip.MustParseAddr("192.168.1.50")
+	if err := backend.Put(ctx, mac, &data.DHCP{IPAddress: ip, Hostname: "node-1", LeaseTime: 60}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	entry, err := backend.PinLease(ctx, mac)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.IP.String() != "192.168.1.50" || entry.Hostname != "node-1" {
+		t.Fatalf("PinLease() = %+v", entry)
+	}
+
+	hostFile, err := os.ReadFile(filepath.Join(tmpDir, "hosts", "ironic-aa:bb:cc:dd:ee:ff.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "aa:bb:cc:dd:ee:ff,set:aabbccddeeff,192.168.1.50,node-1\n"; string(hostFile) != want {
+		t.Errorf("host file = %q, want %q", hostFile, want)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "opts", "ironic-aabbccddeeff.conf")); err != nil {
+		t.Errorf("options file not written: %v", err)
+	}
+
+	// The reservation outlives the lease.
+	backend.leaseManager.RemoveLease(mac)
+	d, _, err := backend.GetByMac(ctx, mac)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.IPAddress != ip {
+		t.Errorf("GetByMac() IP = %s, want %s", d.IPAddress, ip)
+	}
+
+	if err := backend.ReleaseReservation(ctx, mac); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := backend.GetByMac(ctx, mac); err == nil {
+		t.Error("expected no record after releasing the reservation")
+	}
+	if err := backend.ReleaseReservation(ctx, mac); err == nil {
+		t.Error("expected error releasing twice")
+	}
+}
+
+func TestPinLeaseRollsBackOptions(t *testing.T) {
+	tmpDir := t.TempDir()
+	ctx := context.Background()
+	mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:ff")
+
+	backend, err := NewBackend(logr.Discard(), Config{RootDir: tmpDir, DefaultGateway: "192.168.1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	ip := netip.MustParseAddr("192.168.1.50")
+	if err := backend.Put(ctx, mac, &data.DHCP{IPAddress: ip, LeaseTime: 60}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// A file in place of the hosts directory makes writing the host fail.
+	hostsDir := filepath.Join(tmpDir, "hosts")
+	if err := os.RemoveAll(hostsDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(hostsDir, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := backend.PinLease(ctx, mac); err == nil {
+		t.Fatal("expected error pinning with an unwritable host file")
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "opts", "ironic-aabbccddeeff.conf")); !os.IsNotExist(err) {
+		t.Errorf("options file left behind: %v", err)
+	}
+	if _, err := backend.configManager.Options("aabbccddeeff"); err == nil {
+		t.Error("options still listed after a failed pin")
+	}
+}
+
+func TestPoolUsage(t *testing.T) {
+	ctx := context.Background()
+	backend, err := NewBackend(logr.Discard(), Config{
+		RootDir:           t.TempDir(),
+		AutoAssignEnabled: true,
+		IPPoolStart:       "192.168.1.100",
+		IPPoolEnd:         "192.168.1.103",
+		DefaultLeaseTime:  3600,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	if used, size := backend.PoolUsage(); used != 0 || size != 4 {
+		t.Errorf("PoolUsage() = %d, %d, want 0, 4", used, size)
+	}
+
+	mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:01")
+	if _, _, err := backend.GetByMac(ctx, mac); err != nil {
+		t.Fatal(err)
+	}
+	// Leases outside the pool count as active but do not use it.
+	outside, _ := net.ParseMAC("aa:bb:cc:dd:ee:02")
+	d := &data.DHCP{IPAddress: netip.MustParseAddr("192.168.1.50"), LeaseTime: 60}
+	if err := backend.Put(ctx, outside, d, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if used, size := backend.PoolUsage(); used != 1 || size != 4 {
+		t.Errorf("PoolUsage() = %d, %d, want 1, 4", used, size)
+	}
+	if got := backend.ActiveLeases(); got != 2 {
+		t.Errorf("ActiveLeases() = %d, want 2", got)
+	}
+}
+
+func TestPools(t *testing.T) {
+	ctx := context.Background()
+	backend, err := NewBackend(logr.Discard(), Config{
+		RootDir:           t.TempDir(),
+		AutoAssignEnabled: true,
+		IPPoolStart:       "192.168.1.100",
+		IPPoolEnd:         "192.168.1.103",
+		DefaultGateway:    "192.168.1.1",
+		DefaultLeaseTime:  3600,
+		Pools: []Pool{
+			{
+				Name:      "rack-b",
+				Subnet:    "10.20.0.0/24",
+				Start:     "10.20.0.100",
+				End:       "10.20.0.102",
+				Gateway:   "10.20.0.1",
+				LeaseTime: 600,
+			},
+			{
+				Name:       "rack-c",
+				Subnet:     "10.30.0.0/16",
+				Start:      "10.30.0.100",
+				End:        "10.30.0.100",
+				CircuitIDs: []string{"rack-c:*"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	tests := []struct {
+		name    string
+		relay   *data.Relay
+		wantIP  string
+		wantGW  string
+		wantTTL uint32
+	}{
+		{name: "direct", wantIP: "192.168.1.", wantGW: "192.168.1.1", wantTTL: 3600},
+		{
+			name:    "giaddr",
+			relay:   &data.Relay{GatewayIP: netip.MustParseAddr("10.20.0.1")},
+			wantIP:  "10.20.0.",
+			wantGW:  "10.20.0.1",
+			wantTTL: 600,
+		},
+		{
+			name: "link selection",
+			relay: &data.Relay{
+				GatewayIP:     netip.MustParseAddr("172.16.0.1"),
+				LinkSelection: netip.MustParseAddr("10.20.0.0"),
+			},
+			wantIP:  "10.20.0.",
+			wantGW:  "10.20.0.1",
+			wantTTL: 600,
+		},
+		{
+			name: "circuit id",
+			relay: &data.Relay{
+				GatewayIP: netip.MustParseAddr("10.20.0.1"),
+				CircuitID: "rack-c:eth1/7",
+			},
+			wantIP:  "10.30.0.100",
+			wantGW:  "192.168.1.1",
+			wantTTL: 3600,
+		},
+		{
+			name:    "unknown network",
+			relay:   &data.Relay{GatewayIP: netip.MustParseAddr("172.16.0.1")},
+			wantIP:  "192.168.1.",
+			wantGW:  "192.168.1.1",
+			wantTTL: 3600,
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := ctx
+			if tt.relay != nil {
+				ctx = data.WithRelay(ctx, *tt.relay)
+			}
+			mac := net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, byte(i)}
+			d, _, err := backend.GetByMac(ctx, mac)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(d.IPAddress.String(), tt.wantIP) ||
+				d.DefaultGateway.String() != tt.wantGW ||
+				d.LeaseTime > tt.wantTTL || d.LeaseTime < tt.wantTTL-5 {
+				t.Errorf("GetByMac() = %s via %s for %ds, want %s* via %s for %ds",
+					d.IPAddress, d.DefaultGateway, d.LeaseTime, tt.wantIP, tt.wantGW, tt.wantTTL)
+			}
+		})
+	}
+
+	// A host that moved to another network is given an address there.
+	mac := net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0}
+	relayed := data.WithRelay(ctx, data.Relay{GatewayIP: netip.MustParseAddr("10.20.0.1")})
+	if d, _, err := backend.GetByMac(relayed, mac); err != nil || !strings.HasPrefix(d.IPAddress.String(), "10.20.0.") {
+		t.Errorf("GetByMac() after moving = %v, %v, want an address of rack-b", d, err)
+	}
+
+	usage := backend.PoolsUsage()
+	if len(usage) != 3 || usage[0].Name != "default" || usage[1].Name != "rack-b" ||
+		usage[1].Used != 3 || usage[1].Size != 3 || usage[2].Used != 1 {
+		t.Errorf("PoolsUsage() = %+v", usage)
+	}
+	if used, size := backend.PoolUsage(); used != 5 || size != 8 {
+		t.Errorf("PoolUsage() = %d, %d, want 5, 8", used, size)
+	}
+}
+
+func TestPoolsInvalid(t *testing.T) {
+	for name, p := range map[string]Pool{
+		"no name":         {Subnet: "10.0.0.0/24", Start: "10.0.0.1", End: "10.0.0.2"},
+		"reserved name":   {Name: "default", Subnet: "10.0.0.0/24", Start: "10.0.0.1", End: "10.0.0.2"},
+		"bad subnet":      {Name: "a", Subnet: "10.0.0.0", Start: "10.0.0.1", End: "10.0.0.2"},
+		"outside subnet":  {Name: "a", Subnet: "10.0.0.0/24", Start: "10.0.1.1", End: "10.0.0.2"},
+		"reversed range":  {Name: "a", Subnet: "10.0.0.0/24", Start: "10.0.0.9", End: "10.0.0.2"},
+		"gateway outside": {Name: "a", Subnet: "10.0.0.0/24", Start: "10.0.0.1", End: "10.0.0.2", Gateway: "10.1.0.1"},
+		"bad pattern":     {Name: "a", Subnet: "10.0.0.0/24", Start: "10.0.0.1", End: "10.0.0.2", CircuitIDs: []string{"["}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			b, err := NewBackend(logr.Discard(), Config{RootDir: t.TempDir(), Pools: []Pool{p}})
+			if err == nil {
+				b.Close()
+				t.Fatal("NewBackend() error = nil")
+			}
+		})
+	}
+}
+
+func TestHostWriter(t *testing.T) {
+	ctx := context.Background()
+	tmpDir := t.TempDir()
+	pidFile := filepath.Join(tmpDir, "dnsmasq.pid")
+	if err := os.WriteFile(pidFile, fmt.Appendf(nil, "%d\n", os.Getpid()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	hup := make(chan os.Signal, 8)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	b, err := NewBackend(logr.Discard(), Config{RootDir: tmpDir, PIDFile: pidFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:ff")
+	h := backend.Host{
+		MAC:      mac,
+		IP:       net.ParseIP("192.168.1.50").To4(),
+		Hostname: "node-1",
+		Options:  []backend.HostOption{{Code: 26, Value: "1500"}},
+	}
+	if err := b.CreateHost(ctx, h); err != nil {
+		t.Fatalf("CreateHost() error = %v", err)
+	}
+	if err := b.CreateHost(ctx, h); !errors.Is(err, backend.ErrHostExists) {
+		t.Errorf("CreateHost() of an existing host error = %v, want ErrHostExists", err)
+	}
+	select {
+	case <-hup:
+	case <-time.After(5 * time.Second):
+		t.Error("CreateHost() sent no SIGHUP")
+	}
+
+	want := "aa:bb:cc:dd:ee:ff,set:aabbccddeeff,192.168.1.50,node-1\n"
+	hostFile := filepath.Join(tmpDir, "hosts", "ironic-aa:bb:cc:dd:ee:ff.conf")
+	if got, _ := os.ReadFile(hostFile); string(got) != want {
+		t.Errorf("host file = %q, want %q", got, want)
+	}
+
+	// Options with further conditions survive SetOptions.
+	cm := b.ConfigManager()
+	ipxe := dnsmasqconfig.DHCPOption{
+		Tags:  []string{"aabbccddeeff", "!ipxe"},
+		Code:  67,
+		Value: "ipxe.efi",
+	}
+	if err := cm.AddOption("aabbccddeeff", ipxe); err != nil {
+		t.Fatal(err)
+	}
+	opts := []backend.HostOption{{Code: 66, Value: "10.0.0.1"}}
+	if err := b.SetOptions(ctx, mac, opts); err != nil {
+		t.Fatalf("SetOptions() error = %v", err)
+	}
+	got, err := b.GetHost(ctx, mac)
+	if err != nil {
+		t.Fatalf("GetHost() error = %v", err)
+	}
+	if len(got.Options) != 1 || got.Options[0].Code != 66 {
+		t.Errorf("GetHost() options = %+v, want option 66", got.Options)
+	}
+	if opts, _ := cm.Options("aabbccddeeff"); len(opts) != 2 {
+		t.Errorf("options of the tag = %+v, want 67 and 66", opts)
+	}
+
+	h.Hostname = "node-2"
+	h.Options = nil
+	if err := b.UpdateHost(ctx, h); err != nil {
+		t.Fatalf("UpdateHost() error = %v", err)
+	}
+	if got, _ := b.GetHost(ctx, mac); got.Hostname != "node-2" || len(got.Options) != 1 {
+		t.Errorf("GetHost() after update = %+v", got)
+	}
+	h.IP = net.ParseIP("2001:db8::5")
+	if err := b.UpdateHost(ctx, h); !errors.Is(err, backend.ErrInvalidHost) {
+		t.Errorf("UpdateHost() with an IPv6 address as IP error = %v, want ErrInvalidHost", err)
+	}
+
+	if err := b.DeleteHost(ctx, mac); err != nil {
+		t.Fatalf("DeleteHost() error = %v", err)
+	}
+	if _, err := b.GetHost(ctx, mac); !errors.Is(err, backend.ErrHostNotFound) {
+		t.Errorf("GetHost() after delete error = %v, want ErrHostNotFound", err)
+	}
+	if _, err := cm.Options("aabbccddeeff"); err == nil {
+		t.Error("options of a deleted host were kept")
+	}
+	if err := b.DeleteHost(ctx, mac); !errors.Is(err, backend.ErrHostNotFound) {
+		t.Errorf("DeleteHost() of a missing host error = %v, want ErrHostNotFound", err)
+	}
+}
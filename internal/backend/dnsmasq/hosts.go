@@ -0,0 +1,305 @@
+package dnsmasq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/metal3-community/metal-boot/internal/backend"
+	dnsmasqconfig "github.com/metal3-community/metal-boot/internal/backend/dnsmasq/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// Hosts implements BackendHostWriter.Hosts.
+func (b *Backend) Hosts(ctx context.Context) ([]backend.Host, error) {
+	tracer := otel.Tracer(tracerName)
+	_, span := tracer.Start(ctx, "backend.dnsmasq.Hosts")
+	defer span.End()
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	entries := b.configManager.Hosts()
+	out := make([]backend.Host, 0, len(entries))
+	for _, entry := range entries {
+		out = append(out, b.toHost(entry))
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return out, nil
+}
+
+// GetHost implements BackendHostWriter.GetHost.
+func (b *Backend) GetHost(ctx context.Context, mac net.HardwareAddr) (backend.Host, error) {
+	tracer := otel.Tracer(tracerName)
+	_, span := tracer.Start(ctx, "backend.dnsmasq.GetHost")
+	defer span.End()
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	entry, ok := b.configManager.GetHost(mac)
+	if !ok {
+		err := fmt.Errorf("%w: %s", backend.ErrHostNotFound, mac)
+		span.SetStatus(codes.Error, err.Error())
+		return backend.Host{}, err
+	}
+
+	span.SetStatus(codes.Ok, "")
+	return b.toHost(entry), nil
+}
+
+// CreateHost implements BackendHostWriter.CreateHost. The host is written
+// with its generated node tag, which its options are conditional on.
+func (b *Backend) CreateHost(ctx context.Context, h backend.Host) error {
+	tracer := otel.Tracer(tracerName)
+	_, span := tracer.Start(ctx, "backend.dnsmasq.CreateHost")
+	defer span.End()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.configManager.GetHost(h.MAC); ok {
+		err := fmt.Errorf("%w: %s", backend.ErrHostExists, h.MAC)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	entry := dnsmasqconfig.HostEntry{MAC: h.MAC, Tags: []string{nodeTag(h.MAC)}}
+	if err := b.writeHost(entry, h); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	b.log.Info("created host", "mac", h.MAC.String())
+	span.SetStatus(codes.Ok, "")
+	return nil
+}
+
+// UpdateHost implements BackendHostWriter.UpdateHost. The tags and the fields
+// the backend does not model, such as lease times, are kept.
+func (b *Backend) UpdateHost(ctx context.Context, h backend.Host) error {
+	tracer := otel.Tracer(tracerName)
+	_, span := tracer.Start(ctx, "backend.dnsmasq.UpdateHost")
+	defer span.End()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	entry, ok := b.configManager.GetHost(h.MAC)
+	if !ok {
+		err := fmt.Errorf("%w: %s", backend.ErrHostNotFound, h.MAC)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	if err := b.writeHost(entry, h); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	b.log.Info("updated host", "mac", h.MAC.String())
+	span.SetStatus(codes.Ok, "")
+	return nil
+}
+
+// DeleteHost implements BackendHostWriter.DeleteHost. The options file of the
+// host is removed with it when the host owns it, that is when it is the file
+// of the node tag of the host.
+func (b *Backend) DeleteHost(ctx context.Context, mac net.HardwareAddr) error {
+	tracer := otel.Tracer(tracerName)
+	_, span := tracer.Start(ctx, "backend.dnsmasq.DeleteHost")
+	defer span.End()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.configManager.RemoveHost(mac); err != nil {
+		err = hostError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	err := b.configManager.DeleteOptions(nodeTag(mac))
+	if err != nil && !errors.Is(err, dnsmasqconfig.ErrNotFound) {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	b.reload()
+
+	b.log.Info("deleted host", "mac", mac.String())
+	span.SetStatus(codes.Ok, "")
+	return nil
+}
+
+// SetOptions implements BackendHostWriter.SetOptions. Options of the tag of
+// the host with further conditions, such as on the "ipxe" tag, are kept.
+func (b *Backend) SetOptions(
+	ctx context.Context,
+	mac net.HardwareAddr,
+	opts []backend.HostOption,
+) error {
+	tracer := otel.Tracer(tracerName)
+	_, span := tracer.Start(ctx, "backend.dnsmasq.SetOptions")
+	defer span.End()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	entry, ok := b.configManager.GetHost(mac)
+	if !ok {
+		err := fmt.Errorf("%w: %s", backend.ErrHostNotFound, mac)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	if len(entry.Tags) == 0 {
+		entry.Tags = []string{nodeTag(mac)}
+		if err := b.configManager.SetHost(entry); err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return hostError(err)
+		}
+	}
+	if err := b.writeOptions(entry.Tags[0], opts); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	b.reload()
+
+	b.log.Info("set host options", "mac", mac.String(), "count", len(opts))
+	span.SetStatus(codes.Ok, "")
+	return nil
+}
+
+// writeHost writes entry with the fields of h, and the options of h unless
+// they are nil. Callers must hold b.mu.
+func (b *Backend) writeHost(entry dnsmasqconfig.HostEntry, h backend.Host) error {
+	entry.IP = h.IP
+	entry.IPv6 = h.IPv6
+	entry.Hostname = h.Hostname
+	entry.Ignore = h.Disabled
+	if err := entry.Validate(); err != nil {
+		return fmt.Errorf("%w: %w", backend.ErrInvalidHost, err)
+	}
+
+	if h.Options != nil {
+		if len(entry.Tags) == 0 {
+			entry.Tags = []string{nodeTag(h.MAC)}
+		}
+		if err := b.writeOptions(entry.Tags[0], h.Options); err != nil {
+			return err
+		}
+	}
+	if err := b.configManager.SetHost(entry); err != nil {
+		return hostError(err)
+	}
+	b.reload()
+
+	return nil
+}
+
+// writeOptions replaces the options of tag conditional on it alone with
+// opts. The options file is removed when no option is left. Callers must
+// hold b.mu.
+func (b *Backend) writeOptions(tag string, opts []backend.HostOption) error {
+	current, err := b.configManager.Options(tag)
+	if err != nil && !errors.Is(err, dnsmasqconfig.ErrNotFound) {
+		return err
+	}
+
+	var out []dnsmasqconfig.DHCPOption
+	for _, o := range current {
+		if !ownOption(o, tag) {
+			out = append(out, o)
+		}
+	}
+	for _, o := range opts {
+		out = append(out, dnsmasqconfig.DHCPOption{
+			Tags:  []string{tag},
+			Code:  o.Code,
+			Value: o.Value,
+		})
+	}
+
+	if len(out) == 0 {
+		if current == nil {
+			return nil
+		}
+		return b.configManager.DeleteOptions(tag)
+	}
+
+	return hostError(b.configManager.SetOptions(tag, out))
+}
+
+// toHost returns entry with the options of its first tag conditional on
+// that tag alone.
+func (b *Backend) toHost(entry dnsmasqconfig.HostEntry) backend.Host {
+	h := backend.Host{
+		MAC:      entry.MAC,
+		IP:       entry.IP,
+		IPv6:     entry.IPv6,
+		Hostname: entry.Hostname,
+		Disabled: entry.Ignore,
+	}
+	if len(entry.Tags) == 0 {
+		return h
+	}
+	opts, _ := b.configManager.Options(entry.Tags[0])
+	for _, o := range opts {
+		if ownOption(o, entry.Tags[0]) {
+			h.Options = append(h.Options, backend.HostOption{Code: o.Code, Value: o.Value})
+		}
+	}
+
+	return h
+}
+
+// ownOption reports whether o is conditional on tag and nothing else.
+func ownOption(o dnsmasqconfig.DHCPOption, tag string) bool {
+	return slices.Equal(o.Tags, []string{tag})
+}
+
+// hostError maps the errors of the config manager to those of
+// BackendHostWriter.
+func hostError(err error) error {
+	switch {
+	case errors.Is(err, dnsmasqconfig.ErrNotFound):
+		return fmt.Errorf("%w: %w", backend.ErrHostNotFound, err)
+	case errors.Is(err, dnsmasqconfig.ErrInvalid):
+		return fmt.Errorf("%w: %w", backend.ErrInvalidHost, err)
+	default:
+		return err
+	}
+}
+
+// reload sends SIGHUP to the dnsmasq of the pid file, if one is configured,
+// for it to re-read the host and options files. Without one, dnsmasq is left
+// to see the changes through inotify on dhcp-hostsdir and dhcp-optsdir. The
+// files are written already, so a failure is only logged. Callers must hold
+// b.mu.
+func (b *Backend) reload() {
+	if b.pidFile == "" {
+		return
+	}
+	if err := signalPIDFile(b.pidFile, syscall.SIGHUP); err != nil {
+		b.log.Error(err, "failed to signal dnsmasq", "pid_file", b.pidFile)
+	}
+}
+
+// signalPIDFile sends sig to the process whose pid is in path.
+func signalPIDFile(path string, sig syscall.Signal) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil || pid <= 0 {
+		return fmt.Errorf("invalid pid in %s", path)
+	}
+
+	return syscall.Kill(pid, sig)
+}
@@ -3,11 +3,14 @@ package lease
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"fmt"
+	"maps"
 	"net"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -15,6 +18,7 @@ import (
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/go-logr/logr"
+	"github.com/metal3-community/metal-boot/internal/filewatch"
 )
 
 // Lease represents a DHCP lease entry compatible with DNSMasq format.
@@ -45,9 +49,9 @@ type LeaseManager struct {
 	// Log is the logger to be used in the LeaseManager
 	Log logr.Logger
 
-	dataMu  sync.RWMutex      // protects leases
-	leases  map[string]*Lease // leases maps MAC addresses to lease entries
-	watcher *fsnotify.Watcher // file system watcher
+	dataMu  sync.RWMutex       // protects leases
+	leases  map[string]*Lease  // leases maps MAC addresses to lease entries
+	watcher *filewatch.Watcher // file system watcher
 
 	// selfWrite tracks when we're writing to prevent unnecessary reloads
 	selfWriteMu   sync.RWMutex
@@ -55,11 +59,14 @@ type LeaseManager struct {
 }
 
 // NewLeaseManager creates a new lease manager with file watching capabilities.
-func NewLeaseManager(log logr.Logger, leaseFile string) (*LeaseManager, error) {
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		return nil, fmt.Errorf("failed to create file watcher: %w", err)
-	}
+// watch selects whether the lease file is polled instead of watched with
+// fsnotify; it is polled anyway when fsnotify cannot watch it.
+func NewLeaseManager(
+	log logr.Logger,
+	leaseFile string,
+	watch filewatch.Options,
+) (*LeaseManager, error) {
+	watcher := filewatch.New(log, watch)
 
 	m := &LeaseManager{
 		LeaseFile: leaseFile,
@@ -277,34 +284,18 @@ func (m *LeaseManager) ClearDeclinedIPs() error {
 
 // SaveLeases writes all leases to the lease file in DNSMasq format.
 func (m *LeaseManager) SaveLeases() error {
-	// Record that we're writing to the file
-	m.selfWriteMu.Lock()
-	m.selfWriteTime = time.Now()
-	m.selfWriteMu.Unlock()
-
-	// Create directory if it doesn't exist
-	if dir := filepath.Dir(m.LeaseFile); dir != "." {
-		if err := os.MkdirAll(dir, 0o755); err != nil {
-			return fmt.Errorf("failed to create lease directory: %w", err)
-		}
-	}
-
-	// Write to temporary file first
-	tmpFile := m.LeaseFile + ".tmp"
-	file, err := os.Create(tmpFile)
-	if err != nil {
-		return fmt.Errorf("failed to create temporary lease file: %w", err)
-	}
-	defer file.Close()
+	var buf bytes.Buffer
 
 	// Write header comment
-	fmt.Fprintf(file, "# DHCP leases file - DNSMasq compatible format\n")
-	fmt.Fprintf(file, "# <expiry-time> <mac-address> <ip-address> <hostname> <client-id>\n")
+	fmt.Fprintf(&buf, "# DHCP leases file - DNSMasq compatible format\n")
+	fmt.Fprintf(&buf, "# <expiry-time> <mac-address> <ip-address> <hostname> <client-id>\n")
 
-	// Write all leases
+	// Write all leases, ordered by MAC so that unchanged leases give an
+	// unchanged file
 	now := time.Now().Unix()
 	m.dataMu.RLock()
-	for _, lease := range m.leases {
+	for _, mac := range slices.Sorted(maps.Keys(m.leases)) {
+		lease := m.leases[mac]
 		// Skip expired leases
 		if lease.Expiry < now {
 			continue
@@ -321,12 +312,31 @@ func (m *LeaseManager) SaveLeases() error {
 			line += " " + lease.ClientID
 		}
 
-		fmt.Fprintln(file, line)
+		fmt.Fprintln(&buf, line)
 	}
 	m.dataMu.RUnlock()
 
-	if err := file.Close(); err != nil {
-		return fmt.Errorf("failed to close temporary lease file: %w", err)
+	// Leave the file, and anything watching it, alone if nothing changed
+	if current, err := os.ReadFile(m.LeaseFile); err == nil && bytes.Equal(current, buf.Bytes()) {
+		return nil
+	}
+
+	// Record that we're writing to the file
+	m.selfWriteMu.Lock()
+	m.selfWriteTime = time.Now()
+	m.selfWriteMu.Unlock()
+
+	// Create directory if it doesn't exist
+	if dir := filepath.Dir(m.LeaseFile); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("failed to create lease directory: %w", err)
+		}
+	}
+
+	// Write to temporary file first
+	tmpFile := m.LeaseFile + ".tmp"
+	if err := os.WriteFile(tmpFile, buf.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("failed to write temporary lease file: %w", err)
 	}
 
 	// Check if the file is being watched before replacing
@@ -393,6 +403,10 @@ func (m *LeaseManager) Start(ctx context.Context) {
 				continue
 			}
 			if event.Has(fsnotify.Write) {
+				if m.wroteRecently() {
+					m.Log.V(1).Info("ignoring our own lease file write", "file", m.LeaseFile)
+					continue
+				}
 				m.Log.Info("lease file changed, updating cache", "file", m.LeaseFile)
 				if err := m.LoadLeases(); err != nil {
 					m.Log.Error(err, "failed to reload lease file", "file", m.LeaseFile)
@@ -407,6 +421,19 @@ func (m *LeaseManager) Start(ctx context.Context) {
 	}
 }
 
+// selfWriteWindow is how long after SaveLeases a change of the lease file
+// is taken to be the result of that save.
+const selfWriteWindow = time.Second
+
+// wroteRecently reports whether SaveLeases wrote the lease file within
+// selfWriteWindow, so that the change notification it caused can be ignored.
+func (m *LeaseManager) wroteRecently() bool {
+	m.selfWriteMu.RLock()
+	defer m.selfWriteMu.RUnlock()
+
+	return time.Since(m.selfWriteTime) < selfWriteWindow
+}
+
 // Close closes the file watcher and cleans up resources.
 func (m *LeaseManager) Close() error {
 	if m.watcher != nil {
@@ -0,0 +1,230 @@
+package migrate
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/metal3-community/metal-boot/internal/backend/dnsmasq/config"
+)
+
+// Action is what applying a Layout does to one host, options file or lease.
+type Action string
+
+const (
+	ActionAdd       Action = "add"
+	ActionUpdate    Action = "update"
+	ActionUnchanged Action = "unchanged"
+)
+
+// Change is the effect of a Layout on one item of the target layout.
+type Change struct {
+	// Kind is "host", "options" or "lease".
+	Kind string
+	// Name is the MAC address of a host or lease, or the tag of an options file.
+	Name   string
+	Action Action
+	Old    string
+	New    string
+}
+
+// Report is the difference between a Layout and an existing target layout.
+// Items of the target that the Layout does not mention are kept and not
+// reported.
+type Report struct {
+	Changes []Change
+	Skipped []Skipped
+}
+
+// Count returns the number of changes with action a.
+func (r *Report) Count(a Action) int {
+	n := 0
+	for _, c := range r.Changes {
+		if c.Action == a {
+			n++
+		}
+	}
+
+	return n
+}
+
+// Write renders the report for operators: one line per added or updated
+// item, the skipped input lines and a summary.
+func (r *Report) Write(w io.Writer) error {
+	b := &strings.Builder{}
+	for _, c := range r.Changes {
+		switch c.Action {
+		case ActionAdd:
+			fmt.Fprintf(b, "+ %s %s\n", c.Kind, c.Name)
+			writeIndented(b, "    + ", c.New)
+		case ActionUpdate:
+			fmt.Fprintf(b, "~ %s %s\n", c.Kind, c.Name)
+			writeIndented(b, "    - ", c.Old)
+			writeIndented(b, "    + ", c.New)
+		}
+	}
+	for _, s := range r.Skipped {
+		fmt.Fprintf(b, "! skipped %s\n", s)
+	}
+	fmt.Fprintf(b, "%d to add, %d to update, %d unchanged, %d skipped\n",
+		r.Count(ActionAdd), r.Count(ActionUpdate), r.Count(ActionUnchanged), len(r.Skipped))
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeIndented(b *strings.Builder, prefix, text string) {
+	for _, line := range strings.Split(text, "\n") {
+		b.WriteString(prefix + line + "\n")
+	}
+}
+
+// Diff compares l with the metal-boot layout in root, which may be empty.
+func Diff(root string, l *Layout) (*Report, error) {
+	current, err := config.NewConfigManager(logr.Discard(), root)
+	if err != nil {
+		return nil, err
+	}
+	leases, err := readLeaseFile(filepath.Join(root, LeaseFile))
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Report{Skipped: l.Skipped}
+	for _, h := range l.Hosts {
+		old := ""
+		if existing, ok := current.GetHost(h.MAC); ok {
+			old = existing.String()
+		}
+		r.add("host", h.MAC.String(), old, h.String())
+	}
+
+	tags := make([]string, 0, len(l.Options))
+	for tag := range l.Options {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	for _, tag := range tags {
+		old := ""
+		if existing, err := current.Options(tag); err == nil {
+			old = optionLines(existing)
+		}
+		r.add("options", tag, old, optionLines(l.Options[tag]))
+	}
+
+	for _, mac := range leaseKeys(l.Leases) {
+		r.add("lease", mac, leases[mac], l.Leases[mac])
+	}
+
+	return r, nil
+}
+
+func (r *Report) add(kind, name, old, next string) {
+	c := Change{Kind: kind, Name: name, Old: old, New: next}
+	switch old {
+	case "":
+		c.Action = ActionAdd
+	case next:
+		c.Action, c.Old = ActionUnchanged, ""
+	default:
+		c.Action = ActionUpdate
+	}
+	r.Changes = append(r.Changes, c)
+}
+
+func optionLines(opts []config.DHCPOption) string {
+	lines := make([]string, 0, len(opts))
+	for _, o := range opts {
+		lines = append(lines, o.String())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Apply writes l to the metal-boot layout in root. Hosts, options files and
+// leases in l replace those in root; everything else in root is kept.
+func Apply(log logr.Logger, root string, l *Layout) error {
+	m, err := config.NewConfigManager(log, root)
+	if err != nil {
+		return err
+	}
+	for _, h := range l.Hosts {
+		if err := m.SetHost(h); err != nil {
+			return fmt.Errorf("host %s: %w", h.MAC, err)
+		}
+	}
+	for tag, opts := range l.Options {
+		if err := m.SetOptions(tag, opts); err != nil {
+			return fmt.Errorf("options %s: %w", tag, err)
+		}
+	}
+
+	if len(l.Leases) == 0 {
+		return nil
+	}
+	path := filepath.Join(root, LeaseFile)
+	leases, err := readLeaseFile(path)
+	if err != nil {
+		return err
+	}
+	for mac, line := range l.Leases {
+		leases[mac] = line
+	}
+	lines := make([]string, 0, len(leases))
+	for _, mac := range leaseKeys(leases) {
+		lines = append(lines, leases[mac])
+	}
+
+	return writeFileAtomic(path, lines)
+}
+
+// readLeaseFile returns the lines of a lease file by MAC. A missing file has
+// no leases.
+func readLeaseFile(path string) (map[string]string, error) {
+	leases := make(map[string]string)
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return leases, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		if mac, err := net.ParseMAC(fields[1]); err == nil {
+			leases[mac.String()] = strings.TrimSpace(scanner.Text())
+		}
+	}
+
+	return leases, scanner.Err()
+}
+
+// writeFileAtomic writes lines to path through a temporary file.
+func writeFileAtomic(path string, lines []string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filepath.Base(path), err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", filepath.Base(path), err)
+	}
+
+	return nil
+}
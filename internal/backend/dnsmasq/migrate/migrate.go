@@ -0,0 +1,370 @@
+// Package migrate converts the files of a hand-managed dnsmasq (dnsmasq.conf,
+// dhcp-hostsfile and lease files) into the layout of the metal-boot dnsmasq
+// backend, and reports how the result differs from an existing layout before
+// anything is written.
+package migrate
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/metal3-community/metal-boot/internal/backend/dnsmasq/config"
+)
+
+// LeaseFile is the lease file of the metal-boot layout, relative to its root.
+const LeaseFile = "dnsmasq.leases"
+
+// Input names the files of an existing dnsmasq installation. Relative paths
+// in directives are resolved against the directory of the file naming them.
+type Input struct {
+	// ConfFile is the main dnsmasq configuration. Its dhcp-hostsfile,
+	// dhcp-hostsdir, dhcp-optsfile, dhcp-optsdir, dhcp-leasefile, conf-file
+	// and conf-dir directives are followed.
+	ConfFile string
+	// HostsFiles are dhcp-hostsfile files read in addition to those named by
+	// ConfFile.
+	HostsFiles []string
+	// LeaseFile overrides the dhcp-leasefile of ConfFile.
+	LeaseFile string
+}
+
+// Layout is the content of a metal-boot dnsmasq layout.
+type Layout struct {
+	Hosts []config.HostEntry
+	// Options maps tags to the options of their options file.
+	Options map[string][]config.DHCPOption
+	// Leases maps MAC addresses to lease file lines.
+	Leases map[string]string
+	// Skipped lists the input lines that were not migrated.
+	Skipped []Skipped
+}
+
+// Skipped is an input line that was not migrated.
+type Skipped struct {
+	File    string
+	Line    int
+	Content string
+	Reason  string
+}
+
+func (s Skipped) String() string {
+	return fmt.Sprintf("%s:%d: %s (%s)", s.File, s.Line, s.Content, s.Reason)
+}
+
+// hints tells operators where the settings of directives that have no place
+// in the layout live in the metal-boot config.
+var hints = map[string]string{
+	"dhcp-range":     "set dnsmasq.ip_pool_start, ip_pool_end and default_lease_time",
+	"interface":      "set dhcp.interface",
+	"listen-address": "set dhcp.address",
+	"domain":         "set dnsmasq.default_domain",
+	"enable-tftp":    "set tftp.enabled",
+	"tftp-root":      "set tftp.root_directory",
+	"dhcp-boot":      "netboot files are chosen by metal-boot",
+	"dhcp-match":     "client classes are detected by metal-boot",
+	"dhcp-userclass": "client classes are detected by metal-boot",
+}
+
+// untaggedHints covers untagged options that map to dnsmasq.default_* config.
+var untaggedHints = map[uint8]string{
+	1:  "set dnsmasq.default_subnet",
+	3:  "set dnsmasq.default_gateway",
+	6:  "set dnsmasq.default_dns",
+	15: "set dnsmasq.default_domain",
+}
+
+// parser accumulates a Layout while reading input files.
+type parser struct {
+	layout    *Layout
+	hostIndex map[string]int
+	leaseFile string
+	seen      map[string]bool
+}
+
+// Parse reads the files of in into a Layout.
+func Parse(in Input) (*Layout, error) {
+	p := &parser{
+		layout: &Layout{
+			Options: make(map[string][]config.DHCPOption),
+			Leases:  make(map[string]string),
+		},
+		hostIndex: make(map[string]int),
+		seen:      make(map[string]bool),
+	}
+
+	if in.ConfFile != "" {
+		if err := p.readConf(in.ConfFile); err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range in.HostsFiles {
+		if err := p.readPath(f, p.hostLine); err != nil {
+			return nil, err
+		}
+	}
+	if in.LeaseFile != "" {
+		p.leaseFile = in.LeaseFile
+	}
+	if p.leaseFile != "" {
+		if err := p.readLeases(p.leaseFile); err != nil {
+			return nil, err
+		}
+	}
+
+	return p.layout, nil
+}
+
+// readLines calls fn for every non-comment line of file.
+func (p *parser) readLines(file string, fn func(file string, num int, line string)) error {
+	if p.seen[file] {
+		return nil
+	}
+	p.seen[file] = true
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	num := 0
+	for scanner.Scan() {
+		num++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fn(file, num, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading %s: %w", file, err)
+	}
+
+	return nil
+}
+
+// readPath reads file, or every file of a directory, with fn.
+func (p *parser) readPath(path string, fn func(file string, num int, line string)) error {
+	files, err := listFiles(path, nil)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := p.readLines(f, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// listFiles returns path if it is a file, or the files of the directory path
+// accepted by keep. Like dnsmasq, it skips dotfiles and backups ending in "~".
+func listFiles(path string, keep func(name string) bool) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~") {
+			continue
+		}
+		if keep == nil || keep(name) {
+			files = append(files, filepath.Join(path, name))
+		}
+	}
+
+	return files, nil
+}
+
+func (p *parser) readConf(file string) error {
+	var errs []error
+	err := p.readLines(file, func(file string, num int, line string) {
+		key, value, _ := strings.Cut(line, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		resolve := func(path string) string {
+			if filepath.IsAbs(path) {
+				return path
+			}
+			return filepath.Join(filepath.Dir(file), path)
+		}
+
+		switch key {
+		case "dhcp-host":
+			p.hostLine(file, num, value)
+		case "dhcp-option":
+			p.optionLine(file, num, value)
+		case "dhcp-hostsfile", "dhcp-hostsdir":
+			errs = append(errs, p.readPath(resolve(value), p.hostLine))
+		case "dhcp-optsfile", "dhcp-optsdir":
+			errs = append(errs, p.readPath(resolve(value), p.optionLine))
+		case "dhcp-leasefile":
+			p.leaseFile = resolve(value)
+		case "conf-file":
+			errs = append(errs, p.readConf(resolve(value)))
+		case "conf-dir":
+			dir, filters, _ := strings.Cut(value, ",")
+			files, err := listFiles(resolve(dir), confDirFilter(filters))
+			errs = append(errs, err)
+			for _, f := range files {
+				errs = append(errs, p.readConf(f))
+			}
+		default:
+			reason := "no equivalent in the metal-boot dnsmasq layout"
+			if hint, ok := hints[key]; ok {
+				reason = hint
+			}
+			p.skip(file, num, line, reason)
+		}
+	})
+
+	return errors.Join(append(errs, err)...)
+}
+
+// confDirFilter returns the name filter of a conf-dir directive: "*.ext"
+// entries select files by extension and other entries exclude them.
+func confDirFilter(filters string) func(name string) bool {
+	if filters == "" {
+		return nil
+	}
+
+	var include, exclude []string
+	for _, f := range strings.Split(filters, ",") {
+		if ext, ok := strings.CutPrefix(strings.TrimSpace(f), "*"); ok {
+			include = append(include, ext)
+		} else if f = strings.TrimSpace(f); f != "" {
+			exclude = append(exclude, f)
+		}
+	}
+
+	return func(name string) bool {
+		for _, ext := range exclude {
+			if strings.HasSuffix(name, ext) {
+				return false
+			}
+		}
+		if len(include) == 0 {
+			return true
+		}
+		for _, ext := range include {
+			if strings.HasSuffix(name, ext) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func (p *parser) skip(file string, num int, line, reason string) {
+	p.layout.Skipped = append(p.layout.Skipped, Skipped{
+		File:    file,
+		Line:    num,
+		Content: line,
+		Reason:  reason,
+	})
+}
+
+func (p *parser) hostLine(file string, num int, line string) {
+	entry, err := config.ParseHostEntry(line)
+	if err != nil {
+		p.skip(file, num, line, "host entries must start with a MAC address")
+		return
+	}
+	if err := entry.Validate(); err != nil {
+		p.skip(file, num, line, err.Error())
+		return
+	}
+
+	key := entry.MAC.String()
+	if i, ok := p.hostIndex[key]; ok {
+		p.layout.Hosts[i] = *entry
+		p.skip(file, num, line, "replaces an earlier entry for "+key)
+		return
+	}
+	p.hostIndex[key] = len(p.layout.Hosts)
+	p.layout.Hosts = append(p.layout.Hosts, *entry)
+}
+
+func (p *parser) optionLine(file string, num int, line string) {
+	// "net:" is the older spelling of "tag:".
+	fields := strings.Split(line, ",")
+	for i, f := range fields {
+		if rest, ok := strings.CutPrefix(strings.TrimSpace(f), "net:"); ok {
+			fields[i] = "tag:" + rest
+		}
+	}
+
+	opt, err := config.ParseDHCPOption(strings.Join(fields, ","))
+	if err != nil {
+		p.skip(file, num, line, err.Error())
+		return
+	}
+	if err := opt.Validate(); err != nil {
+		p.skip(file, num, line, err.Error())
+		return
+	}
+
+	tag := ""
+	for _, t := range opt.Tags {
+		if !strings.HasPrefix(t, "!") {
+			tag = t
+			break
+		}
+	}
+	if tag == "" {
+		reason := "untagged options apply to every host; metal-boot sets them per tag"
+		if hint, ok := untaggedHints[opt.Code]; ok {
+			reason = hint
+		}
+		p.skip(file, num, line, reason)
+		return
+	}
+
+	p.layout.Options[tag] = append(p.layout.Options[tag], *opt)
+}
+
+// readLeases reads a dnsmasq lease file: "<expiry> <mac> <ip> <hostname>
+// <client-id>" per line.
+func (p *parser) readLeases(file string) error {
+	return p.readLines(file, func(file string, num int, line string) {
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			p.skip(file, num, line, "not an IPv4 lease")
+			return
+		}
+		mac, err := net.ParseMAC(fields[1])
+		if err != nil || net.ParseIP(fields[2]).To4() == nil {
+			p.skip(file, num, line, "not an IPv4 lease")
+			return
+		}
+		p.layout.Leases[mac.String()] = line
+	})
+}
+
+// leaseKeys returns the MACs of leases, sorted.
+func leaseKeys(leases map[string]string) []string {
+	keys := make([]string, 0, len(leases))
+	for k := range leases {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,148 @@
+package migrate
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/metal3-community/metal-boot/internal/backend/dnsmasq/config"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestInput writes a dnsmasq installation spread over a main config, a
+// conf-dir snippet, a hosts file and a lease file.
+func newTestInput(t *testing.T) Input {
+	t.Helper()
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "dnsmasq.conf"), `# lab dnsmasq
+interface=eth1
+dhcp-range=10.0.0.100,10.0.0.200,12h
+dhcp-option=option:router,10.0.0.1
+dhcp-hostsfile=hosts.txt
+dhcp-leasefile=dnsmasq.leases
+conf-dir=dnsmasq.d,*.conf
+`)
+	writeFile(t, filepath.Join(dir, "dnsmasq.d", "nodes.conf"), `dhcp-host=aa:bb:cc:dd:ee:01,set:rack1,10.0.0.11,node-1
+dhcp-option=net:rack1,option:ntp-server,10.0.0.2
+dhcp-option=tag:rack1,tag:!ipxe,67,ipxe.efi
+`)
+	writeFile(t, filepath.Join(dir, "dnsmasq.d", "old.bak"), "dhcp-host=aa:bb:cc:dd:ee:99,ignore\n")
+	writeFile(t, filepath.Join(dir, "hosts.txt"), `aa:bb:cc:dd:ee:02,set:rack1,10.0.0.12,node-2
+id:01:02:03,10.0.0.13
+aa:bb:cc:dd:ee:03,ignore
+`)
+	writeFile(t, filepath.Join(dir, "dnsmasq.leases"), `1700000000 aa:bb:cc:dd:ee:01 10.0.0.11 node-1 *
+duid 00:01:00:01:2c:2d:2e:2f
+`)
+
+	return Input{ConfFile: filepath.Join(dir, "dnsmasq.conf")}
+}
+
+func TestParse(t *testing.T) {
+	l, err := Parse(newTestInput(t))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	var hosts []string
+	for _, h := range l.Hosts {
+		hosts = append(hosts, h.String())
+	}
+	want := []string{
+		"aa:bb:cc:dd:ee:02,set:rack1,10.0.0.12,node-2",
+		"aa:bb:cc:dd:ee:03,ignore",
+		"aa:bb:cc:dd:ee:01,set:rack1,10.0.0.11,node-1",
+	}
+	if strings.Join(hosts, "\n") != strings.Join(want, "\n") {
+		t.Errorf("hosts =\n%s\nwant\n%s", strings.Join(hosts, "\n"), strings.Join(want, "\n"))
+	}
+
+	if got := optionLines(l.Options["rack1"]); got != "tag:rack1,42,10.0.0.2\ntag:rack1,tag:!ipxe,67,ipxe.efi" {
+		t.Errorf("rack1 options = %q", got)
+	}
+	if len(l.Leases) != 1 || l.Leases["aa:bb:cc:dd:ee:01"] == "" {
+		t.Errorf("leases = %v, want the lease of aa:bb:cc:dd:ee:01", l.Leases)
+	}
+
+	reasons := make(map[string]string)
+	for _, s := range l.Skipped {
+		reasons[s.Content] = s.Reason
+	}
+	for content, reason := range map[string]string{
+		"interface=eth1":                       "set dhcp.interface",
+		"dhcp-range=10.0.0.100,10.0.0.200,12h": "set dnsmasq.ip_pool_start, ip_pool_end and default_lease_time",
+		"option:router,10.0.0.1":               "set dnsmasq.default_gateway",
+		"id:01:02:03,10.0.0.13":                "host entries must start with a MAC address",
+		"duid 00:01:00:01:2c:2d:2e:2f":         "not an IPv4 lease",
+	} {
+		if reasons[content] != reason {
+			t.Errorf("skipped %q with reason %q, want %q", content, reasons[content], reason)
+		}
+	}
+	if len(l.Skipped) != 5 {
+		t.Errorf("skipped %d lines, want 5: %v", len(l.Skipped), l.Skipped)
+	}
+}
+
+func TestDiffApply(t *testing.T) {
+	l, err := Parse(newTestInput(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := t.TempDir()
+	m, err := config.NewConfigManager(logr.Discard(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	existing, _ := config.ParseHostEntry("aa:bb:cc:dd:ee:02,set:rack1,10.0.0.50,node-2")
+	kept, _ := config.ParseHostEntry("aa:bb:cc:dd:ee:ff,set:other")
+	for _, h := range []*config.HostEntry{existing, kept} {
+		if err := m.SetHost(*h); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := Diff(root, l)
+	if err != nil {
+		t.Fatalf("Diff() error = %v", err)
+	}
+	if r.Count(ActionAdd) != 4 || r.Count(ActionUpdate) != 1 {
+		t.Errorf("report = %+v, want 4 adds and 1 update", r.Changes)
+	}
+	var out strings.Builder
+	if err := r.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "    - aa:bb:cc:dd:ee:02,set:rack1,10.0.0.50,node-2\n") {
+		t.Errorf("report does not show the replaced host entry:\n%s", out.String())
+	}
+
+	if err := Apply(logr.Discard(), root, l); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	r, err = Diff(root, l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := r.Count(ActionUnchanged); n != len(r.Changes) {
+		t.Errorf("after Apply, %d of %d items are unchanged", n, len(r.Changes))
+	}
+	if err := m.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.GetHost(kept.MAC); !ok {
+		t.Error("Apply() removed a host the input does not mention")
+	}
+}
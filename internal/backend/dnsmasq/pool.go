@@ -0,0 +1,267 @@
+package dnsmasq
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"path"
+	"strings"
+
+	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+)
+
+// defaultPoolName is the name of the pool of Config.IPPoolStart and
+// Config.IPPoolEnd.
+const defaultPoolName = "default"
+
+// Pool is a range of addresses assigned automatically to the hosts of one
+// provisioning network, with the network settings the leases of the network
+// are served with.
+//
+// A relayed packet is served from the first pool whose CircuitIDs or
+// RemoteIDs match the option 82 sub-options of the relay agent, else from
+// the first pool whose Subnet holds the link selection address or giaddr of
+// the packet. Packets that were not relayed, or that match no pool, are
+// served from the default pool of Config.
+type Pool struct {
+	Name string
+	// Subnet is the network of the pool in CIDR notation. Its mask is the
+	// subnet mask of the leases of the pool.
+	Subnet string
+	// Start and End are the first and last address assigned.
+	Start string
+	End   string
+	// Gateway, DNS and Domain default to those of Config.
+	Gateway string
+	DNS     []string
+	Domain  string
+	// LeaseTime is in seconds and defaults to Config.DefaultLeaseTime.
+	LeaseTime uint32
+	// CircuitIDs and RemoteIDs are glob patterns of the agent circuit ID and
+	// remote ID sub-options of option 82, as matched by globMatch.
+	CircuitIDs []string
+	RemoteIDs  []string
+}
+
+// pool is a parsed Pool.
+type pool struct {
+	name string
+	// subnet is invalid for the default pool.
+	subnet netip.Prefix
+	// start and end are 0 for a pool without a range.
+	start, end uint32
+	mask       net.IPMask
+	gateway    netip.Addr
+	dns        []net.IP
+	domain     string
+	leaseTime  uint32
+	circuitIDs []string
+	remoteIDs  []string
+}
+
+// hasRange reports whether p assigns addresses.
+func (p *pool) hasRange() bool {
+	return p.start != 0 && p.end != 0 && p.start <= p.end
+}
+
+// holds reports whether ip belongs to the range or subnet of p.
+func (p *pool) holds(ip net.IP) bool {
+	if p.hasRange() {
+		if i := ipToInt(ip); i >= p.start && i <= p.end {
+			return true
+		}
+	}
+	addr, ok := netip.AddrFromSlice(ip.To4())
+
+	return ok && p.subnet.IsValid() && p.subnet.Contains(addr)
+}
+
+// matches reports whether the relay agent r selects p by its option 82
+// sub-options.
+func (p *pool) matches(r data.Relay) bool {
+	match := func(patterns []string, value string) bool {
+		for _, pattern := range patterns {
+			if ok, _ := globMatch(pattern, value); ok && value != "" {
+				return true
+			}
+		}
+		return false
+	}
+
+	return match(p.circuitIDs, r.CircuitID) || match(p.remoteIDs, r.RemoteID)
+}
+
+// globMatch is path.Match, except that wildcards match "/" too, which is
+// common in circuit IDs such as "sw1:Gi1/0/7".
+func globMatch(pattern, value string) (bool, error) {
+	return path.Match(strings.ReplaceAll(pattern, "/", "\x00"), strings.ReplaceAll(value, "/", "\x00"))
+}
+
+// newDefaultPool returns the default pool of config.
+func newDefaultPool(config Config) (*pool, error) {
+	p := &pool{
+		name:      defaultPoolName,
+		mask:      parseSubnetMask(config.DefaultSubnet),
+		dns:       parseNameServers(config.DefaultDNS),
+		domain:    config.DefaultDomain,
+		leaseTime: config.DefaultLeaseTime,
+	}
+	if p.leaseTime == 0 {
+		p.leaseTime = 604800 // 1 week default
+	}
+	if gw, err := netip.ParseAddr(config.DefaultGateway); err == nil {
+		p.gateway = gw
+	}
+	if !config.AutoAssignEnabled {
+		return p, nil
+	}
+
+	if config.IPPoolStart != "" {
+		ip := net.ParseIP(config.IPPoolStart)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP pool start address: %s", config.IPPoolStart)
+		}
+		p.start = ipToInt(ip)
+	}
+	if config.IPPoolEnd != "" {
+		ip := net.ParseIP(config.IPPoolEnd)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP pool end address: %s", config.IPPoolEnd)
+		}
+		p.end = ipToInt(ip)
+	}
+
+	return p, nil
+}
+
+// newPool parses c, taking the settings it leaves empty from def.
+func newPool(c Pool, def *pool) (*pool, error) {
+	if c.Name == "" {
+		return nil, fmt.Errorf("IP pool without a name")
+	}
+	if c.Name == defaultPoolName {
+		return nil, fmt.Errorf("IP pool name %q is reserved", c.Name)
+	}
+	subnet, err := netip.ParsePrefix(c.Subnet)
+	if err != nil || !subnet.Addr().Is4() {
+		return nil, fmt.Errorf("IP pool %s: invalid subnet %q", c.Name, c.Subnet)
+	}
+	subnet = subnet.Masked()
+
+	p := &pool{
+		name:       c.Name,
+		subnet:     subnet,
+		mask:       net.CIDRMask(subnet.Bits(), 32),
+		gateway:    def.gateway,
+		dns:        def.dns,
+		domain:     def.domain,
+		leaseTime:  def.leaseTime,
+		circuitIDs: c.CircuitIDs,
+		remoteIDs:  c.RemoteIDs,
+	}
+	for _, pattern := range append(append([]string{}, c.CircuitIDs...), c.RemoteIDs...) {
+		if _, err := globMatch(pattern, ""); err != nil {
+			return nil, fmt.Errorf("IP pool %s: invalid pattern %q: %w", c.Name, pattern, err)
+		}
+	}
+
+	for _, r := range []struct {
+		name, value string
+		dst         *uint32
+	}{{"start", c.Start, &p.start}, {"end", c.End, &p.end}} {
+		addr, err := netip.ParseAddr(r.value)
+		if err != nil || !subnet.Contains(addr) {
+			return nil, fmt.Errorf("IP pool %s: %s address %q is not in %s", c.Name, r.name, r.value, subnet)
+		}
+		*r.dst = ipToInt(net.IP(addr.AsSlice()))
+	}
+	if p.start > p.end {
+		return nil, fmt.Errorf("IP pool %s: start %s is after end %s", c.Name, c.Start, c.End)
+	}
+
+	if c.Gateway != "" {
+		gw, err := netip.ParseAddr(c.Gateway)
+		if err != nil || !subnet.Contains(gw) {
+			return nil, fmt.Errorf("IP pool %s: gateway %q is not in %s", c.Name, c.Gateway, subnet)
+		}
+		p.gateway = gw
+	}
+	if len(c.DNS) > 0 {
+		p.dns = parseNameServers(c.DNS)
+	}
+	if c.Domain != "" {
+		p.domain = c.Domain
+	}
+	if c.LeaseTime != 0 {
+		p.leaseTime = c.LeaseTime
+	}
+
+	return p, nil
+}
+
+// selectPool returns the pool that assigns the addresses of clients behind
+// the relay agent r, or the default pool if the packet was not relayed.
+func (b *Backend) selectPool(r data.Relay, relayed bool) *pool {
+	if !relayed {
+		return b.defaultPool
+	}
+	for _, p := range b.pools {
+		if p.matches(r) {
+			return p
+		}
+	}
+	if link := r.Link(); link.IsValid() {
+		for _, p := range b.pools {
+			if p.subnet.Contains(link) {
+				return p
+			}
+		}
+	}
+
+	return b.defaultPool
+}
+
+// poolOf returns the pool ip belongs to, or the default pool.
+func (b *Backend) poolOf(ip net.IP) *pool {
+	for _, p := range b.pools {
+		if p.holds(ip) {
+			return p
+		}
+	}
+
+	return b.defaultPool
+}
+
+// allPools returns the default pool followed by the named ones.
+func (b *Backend) allPools() []*pool {
+	return append([]*pool{b.defaultPool}, b.pools...)
+}
+
+// parseNameServers returns the addresses of dns that parse.
+func parseNameServers(dns []string) []net.IP {
+	var nameServers []net.IP
+	for _, s := range dns {
+		if ip := net.ParseIP(s); ip != nil {
+			nameServers = append(nameServers, ip)
+		}
+	}
+
+	return nameServers
+}
+
+// parseSubnetMask parses a subnet given in CIDR notation (such as
+// "192.168.1.0/24") or as a mask (such as "255.255.255.0"), defaulting to
+// /24.
+func parseSubnetMask(subnet string) net.IPMask {
+	if subnet == "" {
+		return net.IPv4Mask(255, 255, 255, 0)
+	}
+	if _, ipNet, err := net.ParseCIDR(subnet); err == nil {
+		return ipNet.Mask
+	}
+	if mask := net.ParseIP(subnet).To4(); mask != nil {
+		return net.IPv4Mask(mask[0], mask[1], mask[2], mask[3])
+	}
+
+	return net.IPv4Mask(255, 255, 255, 0)
+}
@@ -0,0 +1,94 @@
+package dnsmasq
+
+import (
+	"encoding/hex"
+	"net"
+	"time"
+
+	dnsmasqconfig "github.com/metal3-community/metal-boot/internal/backend/dnsmasq/config"
+)
+
+// HostRecord is the dnsmasq data of a host as it is on disk: its dhcp-host
+// entry, its lease and the dhcp-option lines of its tags.
+type HostRecord struct {
+	// Host is the dhcp-host entry of the host and HostLine the line it is
+	// written as, if the host has one.
+	Host     *dnsmasqconfig.HostEntry `json:"host,omitempty"`
+	HostLine string                   `json:"hostLine,omitempty"`
+	Lease    *LeaseRecord             `json:"lease,omitempty"`
+	// Options are the options of the tags of the host, including those
+	// whose tag conditions it does not meet.
+	Options []HostOption `json:"options"`
+}
+
+// LeaseRecord is a line of the lease file.
+type LeaseRecord struct {
+	IP       net.IP    `json:"ip"`
+	Hostname string    `json:"hostname,omitempty"`
+	ClientID string    `json:"clientId,omitempty"`
+	Expiry   time.Time `json:"expiry"`
+	Declined bool      `json:"declined,omitempty"`
+}
+
+// HostOption is a dhcp-option line of one of the tags of a host.
+type HostOption struct {
+	// Tag is the tag whose options file holds the line.
+	Tag    string                   `json:"tag"`
+	Option dnsmasqconfig.DHCPOption `json:"option"`
+	Line   string                   `json:"line"`
+	// Applies is false for options whose tag conditions the host does not
+	// meet, and for those left to the netboot logic, which decides per
+	// request whether the client is iPXE.
+	Applies bool `json:"applies"`
+	// Encoded is the option value sent on the wire, in hexadecimal.
+	Encoded string `json:"encoded,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// HostRecord returns the dnsmasq data of mac, and false if there is none.
+func (b *Backend) HostRecord(mac net.HardwareAddr) (HostRecord, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	rec := HostRecord{Options: []HostOption{}}
+	host, hasHost := b.configManager.GetHost(mac)
+	if hasHost {
+		rec.Host = &host
+		rec.HostLine = host.String()
+	}
+	if l, ok := b.leaseManager.GetLease(mac); ok {
+		rec.Lease = &LeaseRecord{
+			IP:       l.IP,
+			Hostname: l.Hostname,
+			ClientID: l.ClientID,
+			Expiry:   time.Unix(l.Expiry, 0).UTC(),
+			Declined: l.Declined,
+		}
+	}
+	if !hasHost && rec.Lease == nil {
+		return HostRecord{}, false
+	}
+
+	for _, tag := range host.Tags {
+		opts, err := b.configManager.Options(tag)
+		if err != nil {
+			continue
+		}
+		for _, o := range opts {
+			opt := HostOption{
+				Tag:     tag,
+				Option:  o,
+				Line:    o.String(),
+				Applies: !host.Ignore && optionApplies(o, host),
+			}
+			if value, err := o.Encode(); err != nil {
+				opt.Error = err.Error()
+			} else {
+				opt.Encoded = hex.EncodeToString(value)
+			}
+			rec.Options = append(rec.Options, opt)
+		}
+	}
+
+	return rec, true
+}
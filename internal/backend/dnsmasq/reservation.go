@@ -0,0 +1,200 @@
+package dnsmasq
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	dnsmasqconfig "github.com/metal3-community/metal-boot/internal/backend/dnsmasq/config"
+	"github.com/metal3-community/metal-boot/internal/backend/dnsmasq/lease"
+	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// PinLease converts the current dynamic lease of mac into a static
+// reservation: the lease IP and hostname are written to the host file, and the
+// default network options the lease was served with are written to the host's
+// options file unless it already has one. An options file written here is
+// removed again if the host file cannot be written.
+func (b *Backend) PinLease(ctx context.Context, mac net.HardwareAddr) (dnsmasqconfig.HostEntry, error) {
+	tracer := otel.Tracer(tracerName)
+	_, span := tracer.Start(ctx, "backend.dnsmasq.PinLease")
+	defer span.End()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	l, ok := b.leaseManager.GetLease(mac)
+	if !ok || l.Expiry < time.Now().Unix() {
+		err := fmt.Errorf("%w: no active lease for %s", dnsmasqconfig.ErrNotFound, mac)
+		span.SetStatus(codes.Error, err.Error())
+		return dnsmasqconfig.HostEntry{}, err
+	}
+
+	entry, exists := b.configManager.GetHost(mac)
+	if !exists {
+		entry = dnsmasqconfig.HostEntry{MAC: mac}
+	}
+	if len(entry.Tags) == 0 {
+		entry.Tags = []string{nodeTag(mac)}
+	}
+	entry.IP = l.IP.To4()
+	entry.Hostname = leaseHostname(l)
+
+	tag := entry.Tags[0]
+	wroteOptions := false
+	if _, err := b.configManager.Options(tag); err != nil {
+		if err := b.configManager.SetOptions(tag, b.defaultOptions(tag, l.IP)); err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return dnsmasqconfig.HostEntry{}, fmt.Errorf("failed to write options: %w", err)
+		}
+		wroteOptions = true
+	}
+
+	if err := b.configManager.SetHost(entry); err != nil {
+		if wroteOptions {
+			if rerr := b.configManager.DeleteOptions(tag); rerr != nil {
+				b.log.Error(rerr, "failed to remove options of unpinned lease", "mac", mac.String(), "tag", tag)
+			}
+		}
+		span.SetStatus(codes.Error, err.Error())
+		return dnsmasqconfig.HostEntry{}, err
+	}
+
+	b.log.Info("pinned lease as reservation", "mac", mac.String(), "ip", entry.IP.String())
+	span.SetStatus(codes.Ok, "")
+	return entry, nil
+}
+
+// ReleaseReservation returns the reserved address of mac to the dynamic pool.
+// The host keeps its current lease until it expires; tags and options are
+// left untouched.
+func (b *Backend) ReleaseReservation(ctx context.Context, mac net.HardwareAddr) error {
+	tracer := otel.Tracer(tracerName)
+	_, span := tracer.Start(ctx, "backend.dnsmasq.ReleaseReservation")
+	defer span.End()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	entry, ok := b.configManager.GetHost(mac)
+	if !ok || entry.IP == nil {
+		err := fmt.Errorf("%w: no reservation for %s", dnsmasqconfig.ErrNotFound, mac)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	entry.IP = nil
+	entry.Hostname = ""
+
+	var err error
+	if len(entry.Tags) == 0 && len(entry.Extra) == 0 && !entry.Ignore {
+		err = b.configManager.RemoveHost(mac)
+	} else {
+		err = b.configManager.SetHost(entry)
+	}
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	b.log.Info("released reservation", "mac", mac.String())
+	span.SetStatus(codes.Ok, "")
+	return nil
+}
+
+// reservedLease returns a lease synthesized from the static reservation of
+// mac, if it has one.
+func (b *Backend) reservedLease(mac net.HardwareAddr) (*lease.Lease, bool) {
+	entry, ok := b.configManager.GetHost(mac)
+	if !ok || entry.IP == nil || entry.Ignore {
+		return nil, false
+	}
+
+	leaseTime := b.poolOf(entry.IP).leaseTime
+	hostname := entry.Hostname
+	if hostname == "" {
+		hostname = "*"
+	}
+
+	return &lease.Lease{
+		Expiry:   time.Now().Add(time.Duration(leaseTime) * time.Second).Unix(),
+		MAC:      mac,
+		IP:       entry.IP,
+		Hostname: hostname,
+		ClientID: mac.String(),
+	}, true
+}
+
+// reservedIPv6 returns DHCP data holding only the static IPv6 reservation of
+// mac, if it has one.
+func (b *Backend) reservedIPv6(mac net.HardwareAddr) (*data.DHCP, bool) {
+	entry, ok := b.configManager.GetHost(mac)
+	if !ok || entry.IPv6 == nil || entry.Ignore {
+		return nil, false
+	}
+	ip, ok := netip.AddrFromSlice(entry.IPv6)
+	if !ok {
+		return nil, false
+	}
+
+	return &data.DHCP{
+		MACAddress:  mac,
+		IPv6Address: ip,
+		Hostname:    entry.Hostname,
+		LeaseTime:   b.defaultPool.leaseTime,
+		DomainName:  b.defaultPool.domain,
+	}, true
+}
+
+// defaultOptions returns the network options a dynamic lease of ip is served
+// with, those of its pool, as dnsmasq options conditional on tag.
+func (b *Backend) defaultOptions(tag string, ip net.IP) []dnsmasqconfig.DHCPOption {
+	var opts []dnsmasqconfig.DHCPOption
+	add := func(code uint8, value string) {
+		opts = append(opts, dnsmasqconfig.DHCPOption{Tags: []string{tag}, Code: code, Value: value})
+	}
+
+	p := b.poolOf(ip)
+	add(1, net.IP(p.mask).String())
+	if p.gateway.Is4() {
+		add(3, p.gateway.String())
+	}
+	var dns []string
+	for _, ip := range p.dns {
+		if ip.To4() != nil {
+			dns = append(dns, ip.String())
+		}
+	}
+	if len(dns) > 0 {
+		add(6, strings.Join(dns, ","))
+	}
+	if p.domain != "" {
+		add(15, p.domain)
+	}
+
+	return opts
+}
+
+// nodeTag is the tag generated for hosts that have none: the MAC without colons.
+func nodeTag(mac net.HardwareAddr) string {
+	return strings.ReplaceAll(mac.String(), ":", "")
+}
+
+// leaseHostname returns the lease hostname in a form valid for a host file,
+// or "" if the lease has none.
+func leaseHostname(l *lease.Lease) string {
+	if l.Hostname == "" || l.Hostname == "*" {
+		return ""
+	}
+	name := strings.ReplaceAll(l.Hostname, ":", "-")
+	if (dnsmasqconfig.HostEntry{MAC: l.MAC, Hostname: name}).Validate() != nil {
+		return ""
+	}
+
+	return name
+}
@@ -4,12 +4,13 @@ package file
 import (
 	"context"
 	"fmt"
+	"maps"
 	"net"
 	"net/netip"
 	"net/url"
 	"os"
 	"path/filepath"
-	"strings"
+	"slices"
 	"sync"
 
 	"github.com/ccoveille/go-safecast/v2"
@@ -17,6 +18,9 @@ import (
 	"github.com/ghodss/yaml"
 	"github.com/go-logr/logr"
 	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+	"github.com/metal3-community/metal-boot/internal/dhcp/option"
+	"github.com/metal3-community/metal-boot/internal/filewatch"
+	"github.com/metal3-community/metal-boot/internal/util"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/codes"
 )
@@ -48,6 +52,10 @@ type power struct {
 	Port     int    `json:"port"`
 	DeviceId string `json:"device_id"`
 	Mode     string `json:"mode"`
+	// Driver, Address and Options select the power driver of the machine.
+	Driver  string            `yaml:"driver"`
+	Address string            `yaml:"address"`
+	Options map[string]string `yaml:"options"`
 }
 
 // dhcp is the structure for the data expected in a file.
@@ -66,8 +74,13 @@ type dhcp struct {
 	Arch             string           `yaml:"arch"`             // DHCP option 93.
 	DomainSearch     []string         `yaml:"domainSearch"`     // DHCP option 119.
 	Disabled         bool             // If true, no DHCP response should be sent.
+	IPv6Address      string           `yaml:"ipv6Address"`     // DHCPv6 IA_NA address.
+	IPv6NameServers  []string         `yaml:"ipv6NameServers"` // DHCPv6 option 23.
 	Netboot          netboot          `yaml:"netboot"`
 	Power            power            `yaml:"power"`
+	// Options are extra DHCPv4 options by code, in the textual form of
+	// dnsmasq option files. They override options of the same code.
+	Options map[uint8]string `yaml:"options"`
 }
 
 // Watcher represents the backend for watching a file for changes and updating the in memory DHCP data.
@@ -81,18 +94,17 @@ type Watcher struct {
 	Log     logr.Logger
 	dataMu  sync.RWMutex // protects data
 	data    []byte       // data from file
-	watcher *fsnotify.Watcher
+	watcher *filewatch.Watcher
 
 	entries map[string]dhcp
 }
 
-// NewWatcher creates a new file watcher.
+// NewWatcher creates a new file watcher. The file is polled when fsnotify
+// cannot watch it.
 func NewWatcher(l logr.Logger, f string) (*Watcher, error) {
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		return nil, err
-	}
+	watcher := filewatch.New(l, filewatch.Options{})
 	if err := watcher.Add(f); err != nil {
+		watcher.Close()
 		return nil, err
 	}
 
@@ -104,11 +116,13 @@ func NewWatcher(l logr.Logger, f string) (*Watcher, error) {
 	}
 
 	w.fileMu.RLock()
-	w.data, err = os.ReadFile(filepath.Clean(f))
+	data, err := os.ReadFile(filepath.Clean(f))
 	w.fileMu.RUnlock()
 	if err != nil {
+		watcher.Close()
 		return nil, err
 	}
+	w.data = data
 
 	return w, nil
 }
@@ -136,7 +150,7 @@ func (w *Watcher) GetByMac(
 		return nil, nil, err
 	}
 	for k, v := range r {
-		if strings.EqualFold(k, mac.String()) {
+		if util.NormalizeMAC(k) == mac.String() {
 			// found a record for this mac address
 			v.MACAddress = mac
 			d, n, err := w.translate(v)
@@ -182,10 +196,10 @@ func (w *Watcher) GetByIP(
 		return nil, nil, err
 	}
 	for k, v := range r {
-		if v.IPAddress == ip.String() {
+		if v.IPAddress == ip.String() || sameIP(v.IPv6Address, ip) {
 			// found a record for this ip address
 			v.IPAddress = ip.String()
-			mac, err := net.ParseMAC(k)
+			mac, err := util.ParseMAC(k)
 			if err != nil {
 				err := fmt.Errorf("%w: %w", err, errFileFormat)
 				w.Log.Error(err, "failed to parse mac address")
@@ -251,7 +265,8 @@ func (w *Watcher) Put(
 		r = map[string]dhcp{}
 	}
 
-	if v, ok := r[mac.String()]; ok {
+	key := recordKey(r, mac)
+	if v, ok := r[key]; ok {
 		// found a record for this mac address
 		v.MACAddress = mac
 		d, n, err := w.translate(v)
@@ -303,6 +318,9 @@ func (w *Watcher) Put(
 			if d.Disabled != v.Disabled {
 				v.Disabled = d.Disabled
 			}
+			if d.IPv6Address.IsValid() && d.IPv6Address.String() != v.IPv6Address {
+				v.IPv6Address = d.IPv6Address.String()
+			}
 		}
 
 		if n != nil {
@@ -324,7 +342,7 @@ func (w *Watcher) Put(
 			}
 		}
 
-		r[mac.String()] = v
+		r[key] = v
 	} else {
 		dhcpValue := dhcp{
 			MACAddress:       mac,
@@ -342,6 +360,9 @@ func (w *Watcher) Put(
 			DomainSearch:     d.DomainSearch,
 			Disabled:         d.Disabled,
 		}
+		if d.IPv6Address.IsValid() {
+			dhcpValue.IPv6Address = d.IPv6Address.String()
+		}
 
 		if n != nil {
 			dhcpValue.Netboot = netboot{
@@ -353,7 +374,7 @@ func (w *Watcher) Put(
 			}
 		}
 
-		r[mac.String()] = dhcpValue
+		r[key] = dhcpValue
 	}
 
 	newData, err := yaml.Marshal(r)
@@ -372,8 +393,6 @@ func (w *Watcher) Put(
 	}
 	w.fileMu.RUnlock()
 
-	w.dataMu.RUnlock()
-
 	return nil
 }
 
@@ -436,7 +455,7 @@ func (w *Watcher) GetKeys(ctx context.Context) ([]net.HardwareAddr, error) {
 
 	keys := make([]net.HardwareAddr, 0, len(r))
 	for k := range r {
-		mac, err := net.ParseMAC(k)
+		mac, err := util.ParseMAC(k)
 		if err != nil {
 			err := fmt.Errorf("%w: %w", err, errFileFormat)
 			w.Log.Error(err, "failed to parse mac address")
@@ -541,6 +560,36 @@ func (w *Watcher) translate(r dhcp) (*data.DHCP, *data.Netboot, error) {
 	// disabled
 	d.Disabled = r.Disabled
 
+	// ipv6 address, optional, but if provided it must be a valid IPv6 address
+	if r.IPv6Address != "" {
+		ip6, err := netip.ParseAddr(r.IPv6Address)
+		if err != nil || !ip6.Is6() || ip6.Is4In6() {
+			return nil, nil, fmt.Errorf("%q: %w", r.IPv6Address, errParseIP)
+		}
+		d.IPv6Address = ip6
+	}
+
+	// ipv6 name servers, optional
+	for _, s := range r.IPv6NameServers {
+		ip := net.ParseIP(s)
+		if ip == nil || ip.To4() != nil {
+			w.Log.Info("failed to parse ipv6 name server", "nameServer", s)
+			break
+		}
+		d.IPv6NameServers = append(d.IPv6NameServers, ip)
+	}
+
+	// extra options, optional
+	codes := slices.Sorted(maps.Keys(r.Options))
+	for _, code := range codes {
+		value, err := option.Encode(code, r.Options[code])
+		if err != nil {
+			w.Log.Info("failed to encode dhcp option", "code", code, "err", err)
+			continue
+		}
+		d.Options = append(d.Options, data.Option{Code: code, Value: value})
+	}
+
 	// allow machine to netboot
 	n.AllowNetboot = r.Netboot.AllowPXE
 
@@ -568,9 +617,44 @@ func (w *Watcher) translate(r dhcp) (*data.DHCP, *data.Netboot, error) {
 		n.Facility = r.Netboot.Facility
 	}
 
+	// power driver
+	n.Power = data.Power{
+		Driver:  r.Power.Driver,
+		Address: r.Power.Address,
+		Options: r.Power.Options,
+	}
+
 	return d, n, nil
 }
 
 func (w *Watcher) Sync(ctx context.Context) error {
 	return nil
 }
+
+// recordKey returns the key of the record of mac in r. Keys written by hand
+// may use any MAC format, so an existing record is updated in place instead
+// of a duplicate being added under the normalized key.
+func recordKey(r map[string]dhcp, mac net.HardwareAddr) string {
+	for k := range r {
+		if util.NormalizeMAC(k) == mac.String() {
+			return k
+		}
+	}
+
+	return mac.String()
+}
+
+// sameIP reports whether the address s from the file is ip. IPv6 addresses
+// can be written in several forms, so they are compared parsed.
+func sameIP(s string, ip net.IP) bool {
+	if s == "" {
+		return false
+	}
+	a, err := netip.ParseAddr(s)
+	if err != nil {
+		return false
+	}
+	b, ok := netip.AddrFromSlice(ip)
+
+	return ok && a == b
+}
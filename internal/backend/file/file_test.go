@@ -20,6 +20,7 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+	"github.com/metal3-community/metal-boot/internal/filewatch"
 )
 
 func TestNewWatcher(t *testing.T) {
@@ -89,10 +90,7 @@ func TestStartAndStop(t *testing.T) {
 	l := stdr.New(log.New(out, "", 0))
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel()
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		t.Fatal(err)
-	}
+	watcher := filewatch.New(logr.Discard(), filewatch.Options{Poll: true})
 	w := &Watcher{Log: l, watcher: watcher}
 	w.Start(ctx)
 	if diff := cmp.Diff(out.String(), tt.expectedOut); diff != "" {
@@ -159,10 +157,7 @@ func TestStartFileUpdateClosedChan(t *testing.T) {
 	l := stdr.New(log.New(out, "", 0))
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		t.Fatal(err)
-	}
+	watcher := filewatch.New(logr.Discard(), filewatch.Options{Poll: true})
 	w := &Watcher{Log: l, watcher: watcher}
 	go w.Start(ctx)
 	close(w.watcher.Events)
@@ -179,10 +174,7 @@ func TestStartError(t *testing.T) {
 	out := &bytes.Buffer{}
 	l := stdr.New(log.New(out, "", 0))
 	ctx, cancel := context.WithCancel(context.Background())
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		t.Fatal(err)
-	}
+	watcher := filewatch.New(logr.Discard(), filewatch.Options{Poll: true})
 	w := &Watcher{Log: l, watcher: watcher}
 	go func() {
 		time.Sleep(time.Millisecond)
@@ -200,10 +192,7 @@ func TestStartErrorContinue(t *testing.T) {
 	l := stdr.New(log.New(out, "", 0))
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		t.Fatal(err)
-	}
+	watcher := filewatch.New(logr.Discard(), filewatch.Options{Poll: true})
 	w := &Watcher{Log: l, watcher: watcher}
 	go w.Start(ctx)
 	close(w.watcher.Errors)
@@ -248,6 +237,7 @@ func TestTranslate(t *testing.T) {
 		LeaseTime:        86400,
 		Arch:             "x86_64",
 		DomainSearch:     []string{"example.com"},
+		Options:          map[uint8]string{43: "01:04:c0:a8:01:01", 26: "1500"},
 		Netboot: netboot{
 			AllowPXE:      true,
 			IPXEScriptURL: "http://boot.netboot.xyz",
@@ -270,6 +260,10 @@ func TestTranslate(t *testing.T) {
 		LeaseTime:        86400,
 		Arch:             "x86_64",
 		DomainSearch:     []string{"example.com"},
+		Options: []data.Option{
+			{Code: 26, Value: []byte{0x05, 0xdc}},
+			{Code: 43, Value: []byte{0x01, 0x04, 0xc0, 0xa8, 0x01, 0x01}},
+		},
 	}
 	wantNetboot := &data.Netboot{
 		AllowNetboot:  true,
@@ -329,6 +323,14 @@ func TestTranslateErrors(t *testing.T) {
 			},
 			wantErr: nil,
 		},
+		"invalid dhcp option": {
+			input: dhcp{
+				IPAddress:  "1.1.1.1",
+				SubnetMask: "255.255.255.0",
+				Options:    map[uint8]string{26: "not a number"},
+			},
+			wantErr: nil,
+		},
 		"invalid ipxe script url": {
 			input: dhcp{
 				IPAddress:  "1.1.1.1",
@@ -400,6 +402,7 @@ func TestGetByIP(t *testing.T) {
 	}{
 		"no record found":   {ip: net.IPv4(172, 168, 2, 1), wantErr: errRecordNotFound},
 		"record found":      {ip: net.IPv4(192, 168, 2, 153), wantErr: nil},
+		"ipv6 record found": {ip: net.ParseIP("2001:db8:0::158"), wantErr: nil},
 		"fail parsing file": {badData: true, wantErr: errFileFormat},
 	}
 
@@ -425,3 +428,37 @@ func TestGetByIP(t *testing.T) {
 		})
 	}
 }
+
+func TestPutExistingKeyFormat(t *testing.T) {
+	f, err := createFile([]byte(`08-00-27-29-4E-67:
+  ipAddress: "192.168.2.153"
+  subnetMask: "255.255.255.0"
+  netboot:
+    allowPxe: true
+    ipxeScriptUrl: "http://10.1.1.1/auto.ipxe"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f)
+	w, err := NewWatcher(logr.Discard(), f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac := net.HardwareAddr{0x08, 0x00, 0x27, 0x29, 0x4e, 0x67}
+	if _, _, err := w.GetByMac(context.Background(), mac); err != nil {
+		t.Fatalf("GetByMac() error = %v", err)
+	}
+
+	d := &data.DHCP{IPAddress: netip.MustParseAddr("192.168.2.154"), Hostname: "node1"}
+	if err := w.Put(context.Background(), mac, d, nil); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(b, []byte(mac.String())) || !bytes.Contains(b, []byte("08-00-27-29-4E-67")) {
+		t.Errorf("Put() added a duplicate record instead of updating 08-00-27-29-4E-67:\n%s", b)
+	}
+}
@@ -0,0 +1,201 @@
+// Package gpio is a power driver for Raspberry Pis wired to the relays of a
+// relay HAT, switched through GPIO lines of the machine metal-boot runs on.
+//
+// The line of a machine is taken from the configured lines, keyed by MAC
+// address, or else from the power address in its backend data. Lines are
+// driven through the sysfs GPIO interface. The "active_low" option of a
+// machine, or ActiveLow, inverts the level that powers it.
+package gpio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/metal3-community/metal-boot/internal/backend/power"
+	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+)
+
+// exportWait is how long a line exported through sysfs may take to appear.
+const exportWait = time.Second
+
+// Config configures the driver.
+type Config struct {
+	// Root is the sysfs GPIO directory (default: /sys/class/gpio).
+	Root string
+	// Lines maps the MAC addresses of machines to their GPIO line.
+	Lines map[string]int
+	// ActiveLow powers machines when their line is low, as many relay boards
+	// do.
+	ActiveLow bool
+	// CycleDelay is how long PowerCycle keeps the power off (default: 5s).
+	CycleDelay time.Duration
+}
+
+// Driver switches relays through GPIO lines.
+type Driver struct {
+	cfg      Config
+	settings power.SettingsFunc
+	lines    map[string]int
+
+	// mu serializes the exports of lines.
+	mu sync.Mutex
+}
+
+// New returns a Driver finding the lines of machines that are not in
+// cfg.Lines with settings.
+func New(cfg Config, settings power.SettingsFunc) (*Driver, error) {
+	if cfg.Root == "" {
+		cfg.Root = "/sys/class/gpio"
+	}
+	if cfg.CycleDelay == 0 {
+		cfg.CycleDelay = 5 * time.Second
+	}
+	lines := make(map[string]int, len(cfg.Lines))
+	for k, line := range cfg.Lines {
+		mac, err := net.ParseMAC(k)
+		if err != nil {
+			return nil, fmt.Errorf("gpio line of %q: %w", k, err)
+		}
+		lines[mac.String()] = line
+	}
+
+	return &Driver{cfg: cfg, settings: settings, lines: lines}, nil
+}
+
+// GetPower implements backend.BackendPower.
+func (d *Driver) GetPower(ctx context.Context, mac net.HardwareAddr) (*data.PowerState, error) {
+	dir, activeLow, err := d.line(ctx, mac)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "value"))
+	if err != nil {
+		return nil, err
+	}
+
+	state := data.PowerOff
+	if (strings.TrimSpace(string(b)) == "1") != activeLow {
+		state = data.PowerOn
+	}
+
+	return &state, nil
+}
+
+// SetPower implements backend.BackendPower.
+func (d *Driver) SetPower(ctx context.Context, mac net.HardwareAddr, state data.PowerState) error {
+	dir, activeLow, err := d.line(ctx, mac)
+	if err != nil {
+		return err
+	}
+
+	return set(dir, state == data.PowerOn, activeLow)
+}
+
+// PowerCycle turns the power off, waits CycleDelay and turns it on again.
+func (d *Driver) PowerCycle(ctx context.Context, mac net.HardwareAddr) error {
+	dir, activeLow, err := d.line(ctx, mac)
+	if err != nil {
+		return err
+	}
+	if err := set(dir, false, activeLow); err != nil {
+		return err
+	}
+
+	t := time.NewTimer(d.cfg.CycleDelay)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("power cycle of %s interrupted with the power off: %w", mac, ctx.Err())
+	case <-t.C:
+	}
+
+	return set(dir, true, activeLow)
+}
+
+// line returns the sysfs directory of the line of mac, exported and set up
+// as an output, and whether it is active low.
+func (d *Driver) line(ctx context.Context, mac net.HardwareAddr) (string, bool, error) {
+	activeLow := d.cfg.ActiveLow
+	n, ok := d.lines[mac.String()]
+	if !ok {
+		p, err := d.settings.Addressed(ctx, mac)
+		if err != nil {
+			return "", false, err
+		}
+		n, err = strconv.Atoi(p.Address)
+		if err != nil {
+			return "", false, fmt.Errorf("invalid gpio line %q for %s", p.Address, mac)
+		}
+		if v, ok := p.Options["active_low"]; ok {
+			activeLow, _ = strconv.ParseBool(v)
+		}
+	}
+
+	dir, err := d.export(n, activeLow)
+	if err != nil {
+		return "", false, fmt.Errorf("gpio line %d of %s: %w", n, mac, err)
+	}
+
+	return dir, activeLow, nil
+}
+
+// export exports line n if it is not exported yet and makes it an output.
+func (d *Driver) export(n int, activeLow bool) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	dir := filepath.Join(d.cfg.Root, "gpio"+strconv.Itoa(n))
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		err := os.WriteFile(filepath.Join(d.cfg.Root, "export"), []byte(strconv.Itoa(n)), 0)
+		if err != nil {
+			return "", err
+		}
+		// udev may still be fixing the permissions of the new line.
+		deadline := time.Now().Add(exportWait)
+		for {
+			if _, err = os.Stat(filepath.Join(dir, "direction")); err == nil {
+				break
+			}
+			if time.Now().After(deadline) {
+				return "", err
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	direction, err := os.ReadFile(filepath.Join(dir, "direction"))
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(string(direction)) != "out" {
+		// "low" and "high" make the line an output at the level that keeps
+		// the machine off, without glitching it on.
+		level := "low"
+		if activeLow {
+			level = "high"
+		}
+		if err := os.WriteFile(filepath.Join(dir, "direction"), []byte(level), 0); err != nil {
+			return "", err
+		}
+	}
+
+	return dir, nil
+}
+
+// set drives the line in dir to power the machine on or off.
+func set(dir string, on, activeLow bool) error {
+	value := "0"
+	if on != activeLow {
+		value = "1"
+	}
+
+	return os.WriteFile(filepath.Join(dir, "value"), []byte(value), 0)
+}
@@ -0,0 +1,105 @@
+package gpio
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+)
+
+// fakeLine creates the sysfs files of an exported input line below root.
+func fakeLine(t *testing.T, root, name string) string {
+	t.Helper()
+	dir := filepath.Join(root, name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for file, content := range map[string]string{"direction": "in\n", "value": "0\n"} {
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func read(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func TestDriver(t *testing.T) {
+	root := t.TempDir()
+	line17 := fakeLine(t, root, "gpio17")
+	line22 := fakeLine(t, root, "gpio22")
+	settings := func(context.Context, net.HardwareAddr) (data.Power, error) {
+		return data.Power{Address: "22", Options: map[string]string{"active_low": "true"}}, nil
+	}
+	d, err := New(Config{
+		Root:       root,
+		Lines:      map[string]int{"D8:3A:DD:00:00:01": 17},
+		CycleDelay: time.Millisecond,
+	}, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	configured := net.HardwareAddr{0xd8, 0x3a, 0xdd, 0, 0, 1}
+	backend := net.HardwareAddr{0xd8, 0x3a, 0xdd, 0, 0, 2}
+
+	if err := d.SetPower(ctx, configured, data.PowerOn); err != nil {
+		t.Fatalf("SetPower() error = %v", err)
+	}
+	if dir, v := read(t, line17+"/direction"), read(t, line17+"/value"); dir != "low" || v != "1" {
+		t.Errorf("line 17 direction %q value %q, want low and 1", dir, v)
+	}
+	state, err := d.GetPower(ctx, configured)
+	if err != nil || *state != data.PowerOn {
+		t.Errorf("GetPower() = %v, %v, want on", state, err)
+	}
+
+	// The line of the backend data is active low.
+	if err := d.PowerCycle(ctx, backend); err != nil {
+		t.Fatalf("PowerCycle() error = %v", err)
+	}
+	if dir, v := read(t, line22+"/direction"), read(t, line22+"/value"); dir != "high" || v != "0" {
+		t.Errorf("line 22 direction %q value %q, want high and 0", dir, v)
+	}
+	state, err = d.GetPower(ctx, backend)
+	if err != nil || *state != data.PowerOn {
+		t.Errorf("GetPower() after PowerCycle = %v, %v, want on", state, err)
+	}
+}
+
+func TestExport(t *testing.T) {
+	root := t.TempDir()
+	export := filepath.Join(root, "export")
+	if err := os.WriteFile(export, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := New(Config{Root: root, Lines: map[string]int{"d8:3a:dd:00:00:01": 5}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The fake sysfs never creates the exported line.
+	mac := net.HardwareAddr{0xd8, 0x3a, 0xdd, 0, 0, 1}
+	if _, err := d.GetPower(context.Background(), mac); err == nil {
+		t.Error("GetPower() of a line that never appeared succeeded")
+	}
+	if got := read(t, export); got != "5" {
+		t.Errorf("exported %q, want 5", got)
+	}
+
+	if _, err := New(Config{Lines: map[string]int{"pi-1": 5}}, nil); err == nil {
+		t.Error("New() with an invalid MAC succeeded")
+	}
+}
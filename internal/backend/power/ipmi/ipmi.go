@@ -0,0 +1,154 @@
+// Package ipmi is a power driver for servers with an IPMI BMC, such as the
+// x86 machines that share a lab with the Raspberry Pis. It runs ipmitool.
+//
+// The power address of a machine is the host name or address of its BMC,
+// optionally with a port. The "username" and "password" options replace the
+// configured credentials for one machine, and the "interface" option the
+// ipmitool interface (default: lanplus).
+package ipmi
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/metal3-community/metal-boot/internal/backend/power"
+	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+)
+
+// Config configures the driver.
+type Config struct {
+	// Binary is the ipmitool binary (default: ipmitool).
+	Binary string
+	// Username and Password authenticate to the BMCs that have no
+	// credentials of their own in the backend data.
+	Username string
+	Password string
+	// Interface is the ipmitool interface (default: lanplus).
+	Interface string
+	// Timeout bounds each ipmitool run (default: 10s).
+	Timeout time.Duration
+}
+
+// Driver powers machines through their BMC.
+type Driver struct {
+	cfg      Config
+	settings power.SettingsFunc
+}
+
+// New returns a Driver finding the BMC of each machine with settings.
+func New(cfg Config, settings power.SettingsFunc) *Driver {
+	if cfg.Binary == "" {
+		cfg.Binary = "ipmitool"
+	}
+	if cfg.Interface == "" {
+		cfg.Interface = "lanplus"
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+
+	return &Driver{cfg: cfg, settings: settings}
+}
+
+// GetPower implements backend.BackendPower.
+func (d *Driver) GetPower(ctx context.Context, mac net.HardwareAddr) (*data.PowerState, error) {
+	out, err := d.chassisPower(ctx, mac, "status")
+	if err != nil {
+		return nil, err
+	}
+
+	// ipmitool prints "Chassis Power is on" or "Chassis Power is off".
+	var state data.PowerState
+	switch {
+	case strings.HasSuffix(out, " on"):
+		state = data.PowerOn
+	case strings.HasSuffix(out, " off"):
+		state = data.PowerOff
+	default:
+		return nil, fmt.Errorf("unexpected chassis power status %q", out)
+	}
+
+	return &state, nil
+}
+
+// SetPower implements backend.BackendPower.
+func (d *Driver) SetPower(ctx context.Context, mac net.HardwareAddr, state data.PowerState) error {
+	arg := "off"
+	if state == data.PowerOn {
+		arg = "on"
+	}
+	_, err := d.chassisPower(ctx, mac, arg)
+
+	return err
+}
+
+// PowerCycle implements backend.BackendPower. The BMC keeps the power off for
+// its own interval before turning it on again.
+func (d *Driver) PowerCycle(ctx context.Context, mac net.HardwareAddr) error {
+	_, err := d.chassisPower(ctx, mac, "cycle")
+
+	return err
+}
+
+// chassisPower runs "chassis power arg" on the BMC of mac and returns its
+// output.
+func (d *Driver) chassisPower(
+	ctx context.Context,
+	mac net.HardwareAddr,
+	arg string,
+) (string, error) {
+	p, err := d.settings.Addressed(ctx, mac)
+	if err != nil {
+		return "", err
+	}
+
+	args := []string{"-I", d.cfg.Interface}
+	if v := p.Options["interface"]; v != "" {
+		args[1] = v
+	}
+	host, port, err := net.SplitHostPort(p.Address)
+	if err != nil {
+		host, port = p.Address, ""
+	}
+	args = append(args, "-H", host)
+	if port != "" {
+		args = append(args, "-p", port)
+	}
+	username := d.cfg.Username
+	if v := p.Options["username"]; v != "" {
+		username = v
+	}
+	if username != "" {
+		args = append(args, "-U", username)
+	}
+	password := d.cfg.Password
+	if v := p.Options["password"]; v != "" {
+		password = v
+	}
+	// -E reads the password from IPMI_PASSWORD, which keeps it off the
+	// command line other users can see.
+	args = append(args, "-E", "chassis", "power", arg)
+
+	ctx, cancel := context.WithTimeout(ctx, d.cfg.Timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, d.cfg.Binary, args...)
+	cmd.Env = append(os.Environ(), "IPMI_PASSWORD="+password)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return "", fmt.Errorf("BMC %s: chassis power %s: %s", p.Address, arg, msg)
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
@@ -0,0 +1,95 @@
+package ipmi
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+)
+
+// fakeIpmitool writes a script that logs its arguments and password and
+// keeps the chassis power state in a file, as a BMC would.
+func fakeIpmitool(t *testing.T) (binary, log string) {
+	t.Helper()
+	dir := t.TempDir()
+	binary = filepath.Join(dir, "ipmitool")
+	log = filepath.Join(dir, "log")
+	script := `#!/bin/sh
+echo "$* $IPMI_PASSWORD" >> ` + log + `
+state=` + filepath.Join(dir, "state") + `
+for last; do :; done
+case "$last" in
+on|off) echo "$last" > "$state"; echo "Chassis Power Control: Up/On" ;;
+cycle) echo "Chassis Power Control: Cycle" ;;
+status) echo "Chassis Power is $(cat "$state" 2>/dev/null || echo off)" ;;
+*) echo "bad command" >&2; exit 1 ;;
+esac
+`
+	if err := os.WriteFile(binary, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return binary, log
+}
+
+func TestDriver(t *testing.T) {
+	binary, log := fakeIpmitool(t)
+	settings := func(_ context.Context, mac net.HardwareAddr) (data.Power, error) {
+		if mac[5] == 2 {
+			return data.Power{Address: "10.0.0.6:6230", Options: map[string]string{
+				"username": "root", "password": "calvin", "interface": "lan",
+			}}, nil
+		}
+		return data.Power{Address: "10.0.0.5"}, nil
+	}
+	d := New(Config{Binary: binary, Username: "admin", Password: "secret"}, settings)
+	ctx := context.Background()
+	mac := net.HardwareAddr{0x52, 0x54, 0, 0, 0, 1}
+
+	if err := d.SetPower(ctx, mac, data.PowerOn); err != nil {
+		t.Fatalf("SetPower() error = %v", err)
+	}
+	state, err := d.GetPower(ctx, mac)
+	if err != nil || *state != data.PowerOn {
+		t.Fatalf("GetPower() = %v, %v, want on", state, err)
+	}
+	other := net.HardwareAddr{0x52, 0x54, 0, 0, 0, 2}
+	if err := d.PowerCycle(ctx, other); err != nil {
+		t.Fatalf("PowerCycle() error = %v", err)
+	}
+
+	b, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"-I lanplus -H 10.0.0.5 -U admin -E chassis power on secret",
+		"-I lanplus -H 10.0.0.5 -U admin -E chassis power status secret",
+		"-I lan -H 10.0.0.6 -p 6230 -U root -E chassis power cycle calvin",
+	}
+	if got := strings.TrimSpace(string(b)); got != strings.Join(want, "\n") {
+		t.Errorf("ipmitool runs:\n%s\nwant:\n%s", got, strings.Join(want, "\n"))
+	}
+}
+
+func TestDriverErrors(t *testing.T) {
+	binary, _ := fakeIpmitool(t)
+	settings := func(context.Context, net.HardwareAddr) (data.Power, error) {
+		return data.Power{}, nil
+	}
+	d := New(Config{Binary: binary}, settings)
+	if _, err := d.GetPower(context.Background(), net.HardwareAddr{0, 0, 0, 0, 0, 1}); err == nil {
+		t.Error("GetPower() without an address succeeded")
+	}
+
+	d = New(Config{Binary: filepath.Join(t.TempDir(), "missing")},
+		func(context.Context, net.HardwareAddr) (data.Power, error) {
+			return data.Power{Address: "10.0.0.5"}, nil
+		})
+	if err := d.SetPower(context.Background(), nil, data.PowerOff); err == nil {
+		t.Error("SetPower() with a missing ipmitool succeeded")
+	}
+}
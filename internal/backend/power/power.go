@@ -0,0 +1,242 @@
+// Package power selects the power driver of each machine, so that a lab can
+// mix machines on a UniFi PoE switch with machines behind a BMC or a relay.
+//
+// Drivers are backend.BackendPower implementations registered by name. The
+// backend data of a machine names its driver in data.Netboot.Power, along
+// with the address drivers use to reach the machine's power control;
+// machines that name none use the default driver.
+package power
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"slices"
+
+	"github.com/metal3-community/metal-boot/internal/backend"
+	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/metal3-community/metal-boot/backend/power"
+
+var (
+	// ErrUnknownDriver is returned for machines whose driver is not
+	// registered.
+	ErrUnknownDriver = errors.New("unknown power driver")
+	// ErrUnsupported is returned when the driver of a machine does not
+	// support an operation.
+	ErrUnsupported = errors.New("operation not supported by the power driver")
+	// ErrNoAddress is returned by drivers for machines without an address.
+	ErrNoAddress = errors.New("no power address in the backend data")
+)
+
+// Registry is a backend.BackendPower that hands every request to the driver
+// of the machine it is for.
+type Registry struct {
+	reader  backend.BackendReader
+	def     string
+	drivers map[string]backend.BackendPower
+}
+
+// NewRegistry returns a Registry looking up the driver of machines with
+// reader, and using the driver named def for machines that name none.
+func NewRegistry(reader backend.BackendReader, def string) *Registry {
+	return &Registry{
+		reader:  reader,
+		def:     def,
+		drivers: make(map[string]backend.BackendPower),
+	}
+}
+
+// Register makes d the driver called name, replacing any driver registered
+// under that name before.
+func (r *Registry) Register(name string, d backend.BackendPower) {
+	r.drivers[name] = d
+}
+
+// Drivers returns the names of the registered drivers in order.
+func (r *Registry) Drivers() []string {
+	return slices.Sorted(maps.Keys(r.drivers))
+}
+
+// Settings returns the power settings of mac from its backend data. Drivers
+// call it to find the address of the machine's power control.
+func (r *Registry) Settings(ctx context.Context, mac net.HardwareAddr) (data.Power, error) {
+	if r.reader == nil {
+		return data.Power{}, nil
+	}
+	_, n, err := r.reader.GetByMac(ctx, mac)
+	if err != nil {
+		return data.Power{}, err
+	}
+	if n == nil {
+		return data.Power{}, nil
+	}
+
+	return n.Power, nil
+}
+
+// Driver returns the driver of mac and its name. Machines the backend does
+// not know use the default driver.
+func (r *Registry) Driver(
+	ctx context.Context,
+	mac net.HardwareAddr,
+) (backend.BackendPower, string, error) {
+	name := r.def
+	if p, err := r.Settings(ctx, mac); err == nil && p.Driver != "" {
+		name = p.Driver
+	}
+	d, ok := r.drivers[name]
+	if !ok {
+		return nil, name, fmt.Errorf("%w %q for %s", ErrUnknownDriver, name, mac)
+	}
+
+	return d, name, nil
+}
+
+// GetPower implements backend.BackendPower.
+func (r *Registry) GetPower(ctx context.Context, mac net.HardwareAddr) (*data.PowerState, error) {
+	ctx, span := startSpan(ctx, "backend.power.GetPower", mac)
+	defer span.End()
+
+	d, name, err := r.Driver(ctx, mac)
+	if err != nil {
+		return nil, endSpan(span, name, err)
+	}
+	state, err := d.GetPower(ctx, mac)
+
+	return state, endSpan(span, name, err)
+}
+
+// SetPower implements backend.BackendPower.
+func (r *Registry) SetPower(
+	ctx context.Context,
+	mac net.HardwareAddr,
+	state data.PowerState,
+) error {
+	ctx, span := startSpan(ctx, "backend.power.SetPower", mac,
+		attribute.String("power.state", state.String()))
+	defer span.End()
+
+	d, name, err := r.Driver(ctx, mac)
+	if err != nil {
+		return endSpan(span, name, err)
+	}
+
+	return endSpan(span, name, d.SetPower(ctx, mac, state))
+}
+
+// PowerCycle implements backend.BackendPower.
+func (r *Registry) PowerCycle(ctx context.Context, mac net.HardwareAddr) error {
+	ctx, span := startSpan(ctx, "backend.power.PowerCycle", mac)
+	defer span.End()
+
+	d, name, err := r.Driver(ctx, mac)
+	if err != nil {
+		return endSpan(span, name, err)
+	}
+
+	return endSpan(span, name, d.PowerCycle(ctx, mac))
+}
+
+// startSpan starts the span of a power operation on mac. Its backend read
+// and driver calls become children of it.
+func startSpan(
+	ctx context.Context,
+	name string,
+	mac net.HardwareAddr,
+	attrs ...attribute.KeyValue,
+) (context.Context, trace.Span) {
+	attrs = append(attrs, attribute.String("mac", mac.String()))
+
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan records on span the driver that handled the operation and its
+// outcome, and returns err.
+func endSpan(span trace.Span, driver string, err error) error {
+	span.SetAttributes(attribute.String("power.driver", driver))
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		span.RecordError(err)
+	} else {
+		span.SetStatus(codes.Ok, "")
+	}
+
+	return err
+}
+
+// PowerTarget implements backend.BackendPowerTarget for the machines whose
+// driver does.
+func (r *Registry) PowerTarget(
+	ctx context.Context,
+	mac net.HardwareAddr,
+) (backend.PowerTarget, error) {
+	d, name, err := r.Driver(ctx, mac)
+	if err != nil {
+		return backend.PowerTarget{}, err
+	}
+	t, ok := d.(backend.BackendPowerTarget)
+	if !ok {
+		return backend.PowerTarget{}, fmt.Errorf("%w: %s has no power target", ErrUnsupported, name)
+	}
+
+	return t.PowerTarget(ctx, mac)
+}
+
+// PoEPower returns the power mac draws from its PoE port, for the machines
+// whose driver can read it.
+func (r *Registry) PoEPower(ctx context.Context, mac net.HardwareAddr) (float64, error) {
+	d, name, err := r.Driver(ctx, mac)
+	if err != nil {
+		return 0, err
+	}
+	poe, ok := d.(interface {
+		PoEPower(ctx context.Context, mac net.HardwareAddr) (float64, error)
+	})
+	if !ok {
+		return 0, fmt.Errorf("%w: %s does not read PoE power", ErrUnsupported, name)
+	}
+
+	return poe.PoEPower(ctx, mac)
+}
+
+// Close closes the drivers that need closing, such as the qemu driver whose
+// virtual machines do not outlive the server.
+func (r *Registry) Close() error {
+	var errs []error
+	for _, name := range r.Drivers() {
+		if c, ok := r.drivers[name].(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// SettingsFunc returns the power settings of a machine. Registry.Settings is
+// one; drivers that need an address take one.
+type SettingsFunc func(ctx context.Context, mac net.HardwareAddr) (data.Power, error)
+
+// Addressed returns the settings of mac, or ErrNoAddress if they have no
+// address.
+func (f SettingsFunc) Addressed(ctx context.Context, mac net.HardwareAddr) (data.Power, error) {
+	p, err := f(ctx, mac)
+	if err != nil {
+		return data.Power{}, err
+	}
+	if p.Address == "" {
+		return data.Power{}, fmt.Errorf("%w for %s", ErrNoAddress, mac)
+	}
+
+	return p, nil
+}
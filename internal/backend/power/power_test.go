@@ -0,0 +1,124 @@
+package power
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// reader is a backend.BackendReader returning the power settings of a map.
+type reader map[string]data.Power
+
+func (r reader) GetByMac(
+	_ context.Context,
+	mac net.HardwareAddr,
+) (*data.DHCP, *data.Netboot, error) {
+	p, ok := r[mac.String()]
+	if !ok {
+		return nil, nil, errors.New("not found")
+	}
+	return &data.DHCP{MACAddress: mac}, &data.Netboot{Power: p}, nil
+}
+
+func (r reader) GetByIP(context.Context, net.IP) (*data.DHCP, *data.Netboot, error) {
+	return nil, nil, errors.New("not found")
+}
+
+func (r reader) GetKeys(context.Context) ([]net.HardwareAddr, error) {
+	return nil, nil
+}
+
+// driver is a power driver recording the machines it powered.
+type driver struct {
+	powered []string
+}
+
+func (d *driver) GetPower(context.Context, net.HardwareAddr) (*data.PowerState, error) {
+	state := data.PowerOn
+	return &state, nil
+}
+
+func (d *driver) SetPower(_ context.Context, mac net.HardwareAddr, _ data.PowerState) error {
+	d.powered = append(d.powered, mac.String())
+	return nil
+}
+
+func (d *driver) PowerCycle(ctx context.Context, mac net.HardwareAddr) error {
+	return d.SetPower(ctx, mac, data.PowerOn)
+}
+
+func TestRegistry(t *testing.T) {
+	poe, relay := &driver{}, &driver{}
+	r := NewRegistry(reader{
+		"d8:3a:dd:00:00:01": {},
+		"d8:3a:dd:00:00:02": {Driver: "tasmota", Address: "10.1.1.60"},
+		"d8:3a:dd:00:00:03": {Driver: "ipmi"},
+	}, "unifi")
+	r.Register("unifi", poe)
+	r.Register("tasmota", relay)
+	ctx := context.Background()
+
+	for _, s := range []string{"d8:3a:dd:00:00:01", "d8:3a:dd:00:00:02", "d8:3a:dd:00:00:09"} {
+		mac, _ := net.ParseMAC(s)
+		if err := r.SetPower(ctx, mac, data.PowerOn); err != nil {
+			t.Errorf("SetPower(%s) error = %v", s, err)
+		}
+	}
+	if len(poe.powered) != 2 || len(relay.powered) != 1 || relay.powered[0] != "d8:3a:dd:00:00:02" {
+		t.Errorf("unifi powered %v and tasmota %v, want the machine naming tasmota on tasmota",
+			poe.powered, relay.powered)
+	}
+
+	mac, _ := net.ParseMAC("d8:3a:dd:00:00:03")
+	if err := r.PowerCycle(ctx, mac); !errors.Is(err, ErrUnknownDriver) {
+		t.Errorf("PowerCycle() with an unregistered driver error = %v, want %v",
+			err, ErrUnknownDriver)
+	}
+	mac, _ = net.ParseMAC("d8:3a:dd:00:00:02")
+	if _, err := r.PowerTarget(ctx, mac); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("PowerTarget() error = %v, want %v", err, ErrUnsupported)
+	}
+
+	settings := SettingsFunc(r.Settings)
+	if p, err := settings.Addressed(ctx, mac); err != nil || p.Address != "10.1.1.60" {
+		t.Errorf("Addressed() = %+v, %v, want address 10.1.1.60", p, err)
+	}
+	mac, _ = net.ParseMAC("d8:3a:dd:00:00:01")
+	if _, err := settings.Addressed(ctx, mac); !errors.Is(err, ErrNoAddress) {
+		t.Errorf("Addressed() without an address error = %v, want %v", err, ErrNoAddress)
+	}
+}
+
+func TestRegistrySpans(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	r := NewRegistry(reader{"d8:3a:dd:00:00:02": {Driver: "tasmota"}}, "unifi")
+	r.Register("tasmota", &driver{})
+	mac, _ := net.ParseMAC("d8:3a:dd:00:00:02")
+	if err := r.PowerCycle(context.Background(), mac); err != nil {
+		t.Fatalf("PowerCycle() error = %v", err)
+	}
+
+	spans := rec.Ended()
+	if len(spans) != 1 || spans[0].Name() != "backend.power.PowerCycle" {
+		t.Fatalf("ended spans = %v, want backend.power.PowerCycle", spans)
+	}
+	want := attribute.String("power.driver", "tasmota")
+	found := false
+	for _, a := range spans[0].Attributes() {
+		found = found || a == want
+	}
+	if !found {
+		t.Errorf("attributes = %v, want %v", spans[0].Attributes(), want)
+	}
+}
@@ -0,0 +1,265 @@
+// Package qemu is a power backend that runs every system as a QEMU aarch64
+// virtual machine, so that the DHCP, TFTP and HTTP boot paths can be tested
+// end to end without Raspberry Pi hardware.
+//
+// Powering a system on starts a VM whose network card has the system's MAC
+// address and whose UEFI variables are the varstore metal-boot manages for
+// that MAC, so Redfish boot overrides and Bios settings apply to the VM as
+// they would to a board. Powering off kills the VM.
+package qemu
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+)
+
+// Config describes the virtual machines.
+type Config struct {
+	// Binary is the QEMU system emulator (default: qemu-system-aarch64).
+	Binary string
+	// Machine and CPU are passed to -machine and -cpu (default: virt and
+	// cortex-a72, or host with KVM).
+	Machine string
+	CPU     string
+	// KVM enables hardware acceleration on aarch64 hosts.
+	KVM bool
+	// MemoryMB is the memory of each VM (default: 1024).
+	MemoryMB int
+	// Firmware is the UEFI code image attached as the first pflash device,
+	// for example /usr/share/AAVMF/AAVMF_CODE.fd.
+	Firmware string
+	// Varstore returns the varstore of mac, attached as the second pflash
+	// device. The virt machine needs both images padded to 64 MiB.
+	Varstore func(mac net.HardwareAddr) string
+	// VarstoreTemplate, for example a padded AAVMF_VARS.fd, is copied to the
+	// varstore of a VM that has none when it is powered on.
+	VarstoreTemplate string
+	// Bridge is the host bridge the VMs' network cards are attached to. It
+	// must reach the interface the DHCP server listens on.
+	Bridge string
+	// StateDir holds the serial console log of each VM.
+	StateDir string
+	// ExtraArgs are appended to the QEMU command line.
+	ExtraArgs []string
+	// StopTimeout is how long PowerOff waits for a VM to exit (default: 10s).
+	StopTimeout time.Duration
+}
+
+// Driver powers QEMU virtual machines on and off. It implements
+// backend.BackendPower.
+type Driver struct {
+	config Config
+	log    logr.Logger
+
+	mu  sync.Mutex
+	vms map[string]*vm
+}
+
+type vm struct {
+	cmd  *exec.Cmd
+	done chan struct{}
+}
+
+// New returns a Driver for config.
+func New(log logr.Logger, config Config) (*Driver, error) {
+	if config.Firmware == "" {
+		return nil, errors.New("qemu firmware is required")
+	}
+	if config.Varstore == nil {
+		return nil, errors.New("qemu varstore is required")
+	}
+	if config.Binary == "" {
+		config.Binary = "qemu-system-aarch64"
+	}
+	if config.Machine == "" {
+		config.Machine = "virt"
+	}
+	if config.CPU == "" {
+		config.CPU = "cortex-a72"
+		if config.KVM {
+			config.CPU = "host"
+		}
+	}
+	if config.MemoryMB == 0 {
+		config.MemoryMB = 1024
+	}
+	if config.StopTimeout == 0 {
+		config.StopTimeout = 10 * time.Second
+	}
+	if _, err := exec.LookPath(config.Binary); err != nil {
+		return nil, fmt.Errorf("qemu binary: %w", err)
+	}
+
+	return &Driver{
+		config: config,
+		log:    log.WithName("qemu"),
+		vms:    make(map[string]*vm),
+	}, nil
+}
+
+// GetPower reports whether the VM of mac is running.
+func (d *Driver) GetPower(ctx context.Context, mac net.HardwareAddr) (*data.PowerState, error) {
+	d.mu.Lock()
+	_, running := d.vms[mac.String()]
+	d.mu.Unlock()
+
+	state := data.PowerOff
+	if running {
+		state = data.PowerOn
+	}
+
+	return &state, nil
+}
+
+// SetPower starts or kills the VM of mac.
+func (d *Driver) SetPower(ctx context.Context, mac net.HardwareAddr, state data.PowerState) error {
+	switch state {
+	case data.PowerOn, data.PoweringOn:
+		return d.start(mac)
+	default:
+		return d.stop(mac)
+	}
+}
+
+// PowerCycle kills the VM of mac, if running, and starts it again.
+func (d *Driver) PowerCycle(ctx context.Context, mac net.HardwareAddr) error {
+	if err := d.stop(mac); err != nil {
+		return err
+	}
+
+	return d.start(mac)
+}
+
+// Close kills every VM.
+func (d *Driver) Close() error {
+	d.mu.Lock()
+	macs := make([]string, 0, len(d.vms))
+	for mac := range d.vms {
+		macs = append(macs, mac)
+	}
+	d.mu.Unlock()
+
+	var errs []error
+	for _, mac := range macs {
+		hw, _ := net.ParseMAC(mac)
+		errs = append(errs, d.stop(hw))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Args returns the QEMU command line of the VM of mac, without the binary.
+func (d *Driver) Args(mac net.HardwareAddr) []string {
+	c := d.config
+	name := strings.ReplaceAll(mac.String(), ":", "-")
+	args := []string{
+		"-name", "metal-boot-" + name,
+		"-machine", c.Machine,
+		"-cpu", c.CPU,
+		"-m", strconv.Itoa(c.MemoryMB),
+		"-nodefaults",
+		"-display", "none",
+		"-drive", "if=pflash,format=raw,unit=0,readonly=on,file=" + c.Firmware,
+		"-drive", "if=pflash,format=raw,unit=1,file=" + c.Varstore(mac),
+		"-netdev", "bridge,id=net0,br=" + c.Bridge,
+		"-device", "virtio-net-pci,netdev=net0,romfile=,mac=" + mac.String(),
+		"-serial", "file:" + filepath.Join(c.StateDir, name+".console.log"),
+	}
+	if c.KVM {
+		args = append(args, "-accel", "kvm")
+	}
+
+	return append(args, c.ExtraArgs...)
+}
+
+func (d *Driver) start(mac net.HardwareAddr) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, running := d.vms[mac.String()]; running {
+		return nil
+	}
+	if err := os.MkdirAll(d.config.StateDir, 0o755); err != nil {
+		return err
+	}
+	if err := d.seedVarstore(mac); err != nil {
+		return fmt.Errorf("failed to create varstore of vm %s: %w", mac, err)
+	}
+
+	cmd := exec.Command(d.config.Binary, d.Args(mac)...)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start vm %s: %w", mac, err)
+	}
+	v := &vm{cmd: cmd, done: make(chan struct{})}
+	d.vms[mac.String()] = v
+	d.log.Info("started vm", "mac", mac.String(), "pid", cmd.Process.Pid)
+
+	go func() {
+		err := cmd.Wait()
+		d.mu.Lock()
+		if d.vms[mac.String()] == v {
+			delete(d.vms, mac.String())
+		}
+		d.mu.Unlock()
+		close(v.done)
+		d.log.Info("vm exited", "mac", mac.String(), "error", err)
+	}()
+
+	return nil
+}
+
+func (d *Driver) stop(mac net.HardwareAddr) error {
+	d.mu.Lock()
+	v, running := d.vms[mac.String()]
+	d.mu.Unlock()
+	if !running {
+		return nil
+	}
+
+	// QEMU exits cleanly on SIGTERM; a VM does not get to shut down its
+	// guest either way, as with pulling the power of a board.
+	_ = v.cmd.Process.Signal(syscall.SIGTERM)
+	select {
+	case <-v.done:
+		return nil
+	case <-time.After(d.config.StopTimeout):
+	}
+	_ = v.cmd.Process.Kill()
+	select {
+	case <-v.done:
+		return nil
+	case <-time.After(d.config.StopTimeout):
+		return fmt.Errorf("vm %s did not exit", mac)
+	}
+}
+
+// seedVarstore copies the varstore template to the varstore of mac when it
+// has none yet.
+func (d *Driver) seedVarstore(mac net.HardwareAddr) error {
+	path := d.config.Varstore(mac)
+	if _, err := os.Stat(path); err == nil || d.config.VarstoreTemplate == "" {
+		return nil
+	}
+	b, err := os.ReadFile(d.config.VarstoreTemplate)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, b, 0o644)
+}
@@ -0,0 +1,126 @@
+package qemu
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+)
+
+// fakeQemu writes a script that records its arguments and runs until it is
+// signalled, standing in for qemu-system-aarch64.
+func fakeQemu(t *testing.T, dir string) string {
+	t.Helper()
+	path := filepath.Join(dir, "qemu-system-aarch64")
+	script := "#!/bin/sh\necho \"$@\" >> " + filepath.Join(dir, "args") + "\nexec sleep 60\n"
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDriver(t *testing.T) {
+	dir := t.TempDir()
+	mac, _ := net.ParseMAC("d8:3a:dd:01:02:03")
+	template := filepath.Join(dir, "VARS.fd")
+	if err := os.WriteFile(template, []byte("vars"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	varstore := filepath.Join(dir, "tftp", "d8-3a-dd-01-02-03", "RPI_EFI.fd")
+
+	d, err := New(logr.Discard(), Config{
+		Binary:           fakeQemu(t, dir),
+		Firmware:         filepath.Join(dir, "CODE.fd"),
+		VarstoreTemplate: template,
+		Varstore:         func(net.HardwareAddr) string { return varstore },
+		Bridge:           "br-test",
+		StateDir:         filepath.Join(dir, "state"),
+		StopTimeout:      2 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	t.Cleanup(func() { d.Close() })
+	ctx := context.Background()
+
+	// runs waits for the fake qemu to have started n times.
+	runs := func(n int) []string {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			b, _ := os.ReadFile(filepath.Join(dir, "args"))
+			lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+			if len(b) > 0 && len(lines) >= n {
+				return lines
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("qemu ran %d times, want %d", len(lines), n)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	power := func() data.PowerState {
+		t.Helper()
+		state, err := d.GetPower(ctx, mac)
+		if err != nil {
+			t.Fatalf("GetPower() error = %v", err)
+		}
+		return *state
+	}
+
+	if got := power(); got != data.PowerOff {
+		t.Errorf("GetPower() before SetPower = %v, want off", got)
+	}
+	if err := d.SetPower(ctx, mac, data.PowerOn); err != nil {
+		t.Fatalf("SetPower(on) error = %v", err)
+	}
+	if got := power(); got != data.PowerOn {
+		t.Errorf("GetPower() after SetPower(on) = %v, want on", got)
+	}
+	if b, err := os.ReadFile(varstore); err != nil || string(b) != "vars" {
+		t.Errorf("varstore = %q, %v, want a copy of the template", b, err)
+	}
+	args := runs(1)[0]
+
+	if err := d.PowerCycle(ctx, mac); err != nil {
+		t.Fatalf("PowerCycle() error = %v", err)
+	}
+	runs(2)
+	if err := d.SetPower(ctx, mac, data.PowerOff); err != nil {
+		t.Fatalf("SetPower(off) error = %v", err)
+	}
+	if got := power(); got != data.PowerOff {
+		t.Errorf("GetPower() after SetPower(off) = %v, want off", got)
+	}
+
+	for _, want := range []string{
+		"mac=d8:3a:dd:01:02:03",
+		"unit=1,file=" + varstore,
+		"br=br-test",
+		"-machine virt",
+	} {
+		if !strings.Contains(args, want) {
+			t.Errorf("qemu args %q do not contain %q", args, want)
+		}
+	}
+}
+
+func TestNewRequiresFirmware(t *testing.T) {
+	varstore := func(net.HardwareAddr) string { return "" }
+	if _, err := New(logr.Discard(), Config{Varstore: varstore}); err == nil {
+		t.Error("New() without firmware error = nil")
+	}
+	if _, err := New(logr.Discard(), Config{
+		Binary:   "/nonexistent/qemu",
+		Firmware: "CODE.fd",
+		Varstore: varstore,
+	}); err == nil {
+		t.Error("New() with a missing binary error = nil")
+	}
+}
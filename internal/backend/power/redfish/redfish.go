@@ -0,0 +1,150 @@
+// Package redfish is a power driver that passes power requests through to
+// the Redfish service of a machine's own BMC.
+//
+// The power address of a machine is the URL of its ComputerSystem resource,
+// for example https://10.0.0.5/redfish/v1/Systems/1. The "cycle_reset_type"
+// option replaces the ResetType PowerCycle sends, for BMCs that do not
+// support PowerCycle.
+package redfish
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/metal3-community/metal-boot/internal/backend/power"
+	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+)
+
+// Config configures the driver.
+type Config struct {
+	// Username and Password authenticate to the BMCs with HTTP basic
+	// authentication.
+	Username string
+	Password string
+	// Client sends the requests (default: http.DefaultClient).
+	Client *http.Client
+}
+
+// Driver powers machines through their BMC.
+type Driver struct {
+	cfg      Config
+	settings power.SettingsFunc
+}
+
+// New returns a Driver finding the BMC of each machine with settings.
+func New(cfg Config, settings power.SettingsFunc) *Driver {
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+
+	return &Driver{cfg: cfg, settings: settings}
+}
+
+// GetPower implements backend.BackendPower.
+func (d *Driver) GetPower(ctx context.Context, mac net.HardwareAddr) (*data.PowerState, error) {
+	p, err := d.settings.Addressed(ctx, mac)
+	if err != nil {
+		return nil, err
+	}
+
+	var system struct {
+		PowerState string
+	}
+	if err := d.do(ctx, http.MethodGet, p.Address, nil, &system); err != nil {
+		return nil, err
+	}
+	var state data.PowerState
+	switch system.PowerState {
+	case "On":
+		state = data.PowerOn
+	case "Off":
+		state = data.PowerOff
+	case "PoweringOn":
+		state = data.PoweringOn
+	case "PoweringOff":
+		state = data.PoweringOff
+	default:
+		return nil, fmt.Errorf("BMC %s reported power state %q", p.Address, system.PowerState)
+	}
+
+	return &state, nil
+}
+
+// SetPower implements backend.BackendPower.
+func (d *Driver) SetPower(ctx context.Context, mac net.HardwareAddr, state data.PowerState) error {
+	resetType := "ForceOff"
+	if state == data.PowerOn {
+		resetType = "On"
+	}
+
+	return d.reset(ctx, mac, resetType)
+}
+
+// PowerCycle implements backend.BackendPower.
+func (d *Driver) PowerCycle(ctx context.Context, mac net.HardwareAddr) error {
+	return d.reset(ctx, mac, "")
+}
+
+// reset sends a ComputerSystem.Reset of resetType to the BMC of mac. An empty
+// resetType is the power cycle reset type of the machine.
+func (d *Driver) reset(ctx context.Context, mac net.HardwareAddr, resetType string) error {
+	p, err := d.settings.Addressed(ctx, mac)
+	if err != nil {
+		return err
+	}
+	if resetType == "" {
+		resetType = p.Options["cycle_reset_type"]
+	}
+	if resetType == "" {
+		resetType = "PowerCycle"
+	}
+
+	target := strings.TrimSuffix(p.Address, "/") + "/Actions/ComputerSystem.Reset"
+	body := map[string]string{"ResetType": resetType}
+
+	return d.do(ctx, http.MethodPost, target, body, nil)
+}
+
+// do sends a request with the JSON body in and decodes the JSON response into
+// out, if it is not nil.
+func (d *Driver) do(ctx context.Context, method, target string, in, out any) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, &body)
+	if err != nil {
+		return fmt.Errorf("invalid BMC address %q: %w", target, err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if d.cfg.Username != "" {
+		req.SetBasicAuth(d.cfg.Username, d.cfg.Password)
+	}
+
+	resp, err := d.cfg.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("BMC %s: %w", target, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("BMC %s: %s", target, resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("BMC %s: invalid response: %w", target, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package redfish
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+)
+
+func TestDriver(t *testing.T) {
+	var resets []string
+	bmc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "root" || pass != "calvin" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/redfish/v1/Systems/1":
+			json.NewEncoder(w).Encode(map[string]string{"PowerState": "On"})
+		case "/redfish/v1/Systems/1/Actions/ComputerSystem.Reset":
+			var body struct{ ResetType string }
+			json.NewDecoder(r.Body).Decode(&body)
+			resets = append(resets, body.ResetType)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer bmc.Close()
+
+	settings := func(context.Context, net.HardwareAddr) (data.Power, error) {
+		return data.Power{
+			Address: bmc.URL + "/redfish/v1/Systems/1",
+			Options: map[string]string{"cycle_reset_type": "ForceRestart"},
+		}, nil
+	}
+	d := New(Config{Username: "root", Password: "calvin"}, settings)
+	ctx := context.Background()
+	mac := net.HardwareAddr{0xd8, 0x3a, 0xdd, 0, 0, 1}
+
+	state, err := d.GetPower(ctx, mac)
+	if err != nil || *state != data.PowerOn {
+		t.Fatalf("GetPower() = %v, %v, want on", state, err)
+	}
+	if err := d.SetPower(ctx, mac, data.PowerOff); err != nil {
+		t.Fatalf("SetPower() error = %v", err)
+	}
+	if err := d.PowerCycle(ctx, mac); err != nil {
+		t.Fatalf("PowerCycle() error = %v", err)
+	}
+	if len(resets) != 2 || resets[0] != "ForceOff" || resets[1] != "ForceRestart" {
+		t.Errorf("resets = %v, want [ForceOff ForceRestart]", resets)
+	}
+}
@@ -0,0 +1,166 @@
+// Package tasmota is a power driver for machines powered through the relay of
+// a Tasmota smart plug or another web relay speaking its HTTP command API.
+//
+// The power address of a machine is the host name or URL of the relay. The
+// "relay" option selects one relay of a device with several, numbered from 1.
+package tasmota
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/metal3-community/metal-boot/internal/backend/power"
+	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+)
+
+// Config configures the driver.
+type Config struct {
+	// Username and Password authenticate to relays with a web password.
+	Username string
+	Password string
+	// CycleDelay is how long PowerCycle keeps the relay off (default: 3s).
+	CycleDelay time.Duration
+	// Client sends the requests (default: http.DefaultClient).
+	Client *http.Client
+}
+
+// Driver switches relays over HTTP.
+type Driver struct {
+	cfg      Config
+	settings power.SettingsFunc
+}
+
+// New returns a Driver finding the relay of each machine with settings.
+func New(cfg Config, settings power.SettingsFunc) *Driver {
+	if cfg.CycleDelay == 0 {
+		cfg.CycleDelay = 3 * time.Second
+	}
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+
+	return &Driver{cfg: cfg, settings: settings}
+}
+
+// GetPower implements backend.BackendPower.
+func (d *Driver) GetPower(ctx context.Context, mac net.HardwareAddr) (*data.PowerState, error) {
+	state, err := d.command(ctx, mac, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return &state, nil
+}
+
+// SetPower implements backend.BackendPower.
+func (d *Driver) SetPower(ctx context.Context, mac net.HardwareAddr, state data.PowerState) error {
+	arg := "Off"
+	if state == data.PowerOn {
+		arg = "On"
+	}
+	_, err := d.command(ctx, mac, arg)
+
+	return err
+}
+
+// PowerCycle turns the relay off and on again. The relay times the delay, so
+// the machine is powered on again even if metal-boot stops in between.
+func (d *Driver) PowerCycle(ctx context.Context, mac net.HardwareAddr) error {
+	p, err := d.settings.Addressed(ctx, mac)
+	if err != nil {
+		return err
+	}
+	relay := "Power" + p.Options["relay"]
+	// Delay counts tenths of a second.
+	backlog := fmt.Sprintf("Backlog %s Off; Delay %d; %s On",
+		relay, d.cfg.CycleDelay.Milliseconds()/100, relay)
+	_, err = d.send(ctx, p, backlog)
+
+	return err
+}
+
+// command sends the Power command with arg to the relay of mac and returns
+// the state the relay reports.
+func (d *Driver) command(
+	ctx context.Context,
+	mac net.HardwareAddr,
+	arg string,
+) (data.PowerState, error) {
+	p, err := d.settings.Addressed(ctx, mac)
+	if err != nil {
+		return data.PowerOff, err
+	}
+	relay := "Power" + p.Options["relay"]
+	cmnd := relay
+	if arg != "" {
+		cmnd += " " + arg
+	}
+	resp, err := d.send(ctx, p, cmnd)
+	if err != nil {
+		return data.PowerOff, err
+	}
+
+	v, ok := resp[strings.ToUpper(relay)]
+	if !ok {
+		v, ok = resp["POWER"]
+	}
+	if !ok {
+		return data.PowerOff, fmt.Errorf("relay %s did not report %s", p.Address, relay)
+	}
+	if v == "ON" {
+		return data.PowerOn, nil
+	}
+
+	return data.PowerOff, nil
+}
+
+// send runs cmnd on the relay of p and returns its JSON response.
+func (d *Driver) send(ctx context.Context, p data.Power, cmnd string) (map[string]string, error) {
+	base := p.Address
+	if !strings.Contains(base, "://") {
+		base = "http://" + base
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, fmt.Errorf("invalid relay address %q: %w", p.Address, err)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/cm"
+	q := url.Values{"cmnd": {cmnd}}
+	if d.cfg.Username != "" || d.cfg.Password != "" {
+		q.Set("user", d.cfg.Username)
+		q.Set("password", d.cfg.Password)
+	}
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.cfg.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("relay %s: %w", p.Address, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("relay %s: %s", p.Address, resp.Status)
+	}
+
+	out := make(map[string]string)
+	var raw map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("relay %s: invalid response: %w", p.Address, err)
+	}
+	for k, v := range raw {
+		if s, ok := v.(string); ok {
+			out[k] = s
+		}
+	}
+
+	return out, nil
+}
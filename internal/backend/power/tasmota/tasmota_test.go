@@ -0,0 +1,57 @@
+package tasmota
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+)
+
+func TestDriver(t *testing.T) {
+	var commands []string
+	relay := "OFF"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/cm" || r.URL.Query().Get("password") != "secret" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		cmnd := r.URL.Query().Get("cmnd")
+		commands = append(commands, cmnd)
+		switch {
+		case strings.HasSuffix(cmnd, " On"):
+			relay = "ON"
+		case strings.HasSuffix(cmnd, " Off"):
+			relay = "OFF"
+		}
+		json.NewEncoder(w).Encode(map[string]string{"POWER2": relay})
+	}))
+	defer srv.Close()
+
+	settings := func(context.Context, net.HardwareAddr) (data.Power, error) {
+		return data.Power{Address: srv.URL, Options: map[string]string{"relay": "2"}}, nil
+	}
+	d := New(Config{Username: "admin", Password: "secret"}, settings)
+	ctx := context.Background()
+	mac := net.HardwareAddr{0xd8, 0x3a, 0xdd, 0, 0, 1}
+
+	if err := d.SetPower(ctx, mac, data.PowerOn); err != nil {
+		t.Fatalf("SetPower() error = %v", err)
+	}
+	state, err := d.GetPower(ctx, mac)
+	if err != nil || *state != data.PowerOn {
+		t.Fatalf("GetPower() = %v, %v, want on", state, err)
+	}
+	if err := d.PowerCycle(ctx, mac); err != nil {
+		t.Fatalf("PowerCycle() error = %v", err)
+	}
+
+	want := []string{"Power2 On", "Power2", "Backlog Power2 Off; Delay 30; Power2 On"}
+	if strings.Join(commands, "|") != strings.Join(want, "|") {
+		t.Errorf("commands = %q, want %q", commands, want)
+	}
+}
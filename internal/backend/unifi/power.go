@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"net"
 	"slices"
+	"strconv"
 
+	"github.com/metal3-community/metal-boot/internal/backend"
 	"github.com/metal3-community/metal-boot/internal/dhcp/data"
 	"github.com/metal3-community/metal-boot/internal/util"
 	"github.com/ubiquiti-community/go-unifi/unifi"
@@ -54,6 +56,55 @@ func (w *Remote) GetPower(
 	return &power, nil
 }
 
+// PoEPower returns the power in watts the host draws from its switch port.
+func (w *Remote) PoEPower(ctx context.Context, mac net.HardwareAddr) (float64, error) {
+	tracer := otel.Tracer(tracerName)
+	_, span := tracer.Start(ctx, "backend.remote.PoEPower")
+	defer span.End()
+
+	device, err := w.getDevice(ctx, mac)
+	if err != nil {
+		return 0, err
+	}
+
+	pt, err := w.getPortTable(ctx, mac, device)
+	if err != nil {
+		return 0, err
+	}
+	if pt.PoePower == "" {
+		return 0, nil
+	}
+
+	watts, err := strconv.ParseFloat(pt.PoePower, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid PoE power %q for %s: %w", pt.PoePower, mac, err)
+	}
+
+	return watts, nil
+}
+
+// PowerTarget returns the switch and PoE port that power the host.
+func (w *Remote) PowerTarget(
+	ctx context.Context,
+	mac net.HardwareAddr,
+) (backend.PowerTarget, error) {
+	tracer := otel.Tracer(tracerName)
+	_, span := tracer.Start(ctx, "backend.remote.PowerTarget")
+	defer span.End()
+
+	device, err := w.getDevice(ctx, mac)
+	if err != nil {
+		return backend.PowerTarget{}, err
+	}
+
+	port, err := w.getPortIdx(mac, device)
+	if err != nil {
+		return backend.PowerTarget{}, err
+	}
+
+	return backend.PowerTarget{Device: device.MAC, DeviceName: device.Name, Port: port}, nil
+}
+
 func (w *Remote) SetPower(ctx context.Context, mac net.HardwareAddr, state data.PowerState) error {
 	tracer := otel.Tracer(tracerName)
 	_, span := tracer.Start(ctx, "backend.remote.SetPower")
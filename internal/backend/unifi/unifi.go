@@ -17,6 +17,7 @@ import (
 	"github.com/metal3-community/metal-boot/internal/backend"
 	"github.com/metal3-community/metal-boot/internal/config"
 	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+	"github.com/metal3-community/metal-boot/internal/util"
 	"github.com/ubiquiti-community/go-unifi/unifi"
 )
 
@@ -147,7 +148,7 @@ func (w *Remote) clientToDHCP(ctx context.Context, client *unifi.ClientInfo, dhc
 		dhcp.IPAddress = ipAddr
 	}
 
-	if macAddress, err := net.ParseMAC(client.Mac); err == nil {
+	if macAddress, err := util.ParseMAC(client.Mac); err == nil {
 		dhcp.MACAddress = macAddress
 	}
 
@@ -0,0 +1,510 @@
+// Package backup exports metal-boot's on-disk state to a single archive and
+// restores it, to make node reprovisioning and disaster recovery
+// straightforward.
+//
+// An archive is a gzipped tar holding one directory per Source and a
+// manifest.json, written last, that records the format version, the
+// metal-boot version that wrote it and the SHA-256 of every file. Restores
+// are staged and verified against the manifest before anything is written,
+// and can be left pending to be applied at the next start.
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// FormatVersion is the archive format written by Export. Restore accepts
+// archives up to this version.
+const FormatVersion = 1
+
+// manifestName is the archive member holding the Manifest.
+const manifestName = "manifest.json"
+
+// ErrInvalid is returned by Restore for archives that are malformed, of an
+// unsupported format, or do not match their manifest.
+var ErrInvalid = errors.New("invalid backup archive")
+
+// Source is a file or directory of state included in backups.
+type Source struct {
+	// Name is the slash-separated archive path of the source. Names must not
+	// be prefixes of each other.
+	Name string
+	// Path is the file or directory on disk.
+	Path string
+	// Match selects the files below a directory Path by their slash-separated
+	// path relative to it. Nil matches every file.
+	Match func(rel string) bool
+}
+
+func (s Source) matches(rel string) bool {
+	return s.Match == nil || s.Match(rel)
+}
+
+// Manifest describes an archive.
+type Manifest struct {
+	Format  int       `json:"format"`
+	Version string    `json:"version"`
+	Created time.Time `json:"created"`
+	// Sources lists every source in the archive, including empty ones.
+	Sources []string `json:"sources"`
+	Files   []File   `json:"files"`
+}
+
+// File is a file in an archive.
+type File struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Archiver exports and restores a set of Sources.
+type Archiver struct {
+	// Version is the metal-boot version recorded in exported manifests.
+	Version string
+	Sources []Source
+	// PendingDir, if set, makes Restore stage verified archives there instead
+	// of writing them, for ApplyPending to apply before the stores holding
+	// the state are loaded. Running stores would otherwise overwrite
+	// restored files with their in-memory state.
+	PendingDir string
+
+	now func() time.Time
+}
+
+func (a *Archiver) clock() time.Time {
+	if a.now != nil {
+		return a.now()
+	}
+	return time.Now()
+}
+
+// Export writes an archive of all sources to w.
+func (a *Archiver) Export(w io.Writer) (*Manifest, error) {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	m := &Manifest{Format: FormatVersion, Version: a.Version, Created: a.clock().UTC()}
+	for _, src := range a.Sources {
+		files, err := src.files()
+		if err != nil {
+			return nil, fmt.Errorf("backup %s: %w", src.Name, err)
+		}
+		m.Sources = append(m.Sources, src.Name)
+		for _, rel := range files {
+			name, file := src.Name, src.Path
+			if rel != "" {
+				name, file = src.Name+"/"+rel, filepath.Join(src.Path, filepath.FromSlash(rel))
+			}
+			f, err := addFile(tw, name, file)
+			if err != nil {
+				return nil, fmt.Errorf("backup %s: %w", name, err)
+			}
+			m.Files = append(m.Files, f)
+		}
+	}
+
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    manifestName,
+		Mode:    0o644,
+		Size:    int64(len(manifest)),
+		ModTime: m.Created,
+	}); err != nil {
+		return nil, err
+	}
+	if _, err := tw.Write(manifest); err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// files lists the files of s, relative to a directory Path, or [""] for an
+// existing file Path. A missing Path has no files.
+func (s Source) files() ([]string, error) {
+	info, err := os.Stat(s.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{""}, nil
+	}
+
+	var files []string
+	err = filepath.WalkDir(s.Path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(s.Path, p)
+		if err != nil {
+			return err
+		}
+		if rel = filepath.ToSlash(rel); s.matches(rel) {
+			files = append(files, rel)
+		}
+		return nil
+	})
+
+	return files, err
+}
+
+func addFile(tw *tar.Writer, name, file string) (File, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return File{}, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return File{}, err
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    int64(info.Mode().Perm()),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}); err != nil {
+		return File{}, err
+	}
+
+	h := sha256.New()
+	if _, err := io.CopyN(io.MultiWriter(tw, h), f, info.Size()); err != nil {
+		return File{}, err
+	}
+
+	return File{Path: name, Size: info.Size(), SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// Restore replaces the sources listed in the archive read from r with their
+// archived content: archived files are written and other files of those
+// sources are removed. Sources not in the archive are left alone. Nothing is
+// written unless the whole archive matches its manifest. With a PendingDir,
+// the archive replaces any pending one and is applied by ApplyPending.
+func (a *Archiver) Restore(r io.Reader) (*Manifest, error) {
+	if a.PendingDir != "" {
+		return a.stagePending(r)
+	}
+
+	staging, err := os.MkdirTemp("", "metal-boot-restore-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(staging)
+
+	m, err := a.stageVerified(r, staging)
+	if err != nil {
+		return nil, err
+	}
+
+	return m, a.apply(m, staging)
+}
+
+// stagePending stages the archive read from r in PendingDir, through a
+// rename so ApplyPending never sees a partial archive.
+func (a *Archiver) stagePending(r io.Reader) (*Manifest, error) {
+	parent := filepath.Dir(a.PendingDir)
+	if err := os.MkdirAll(parent, 0o700); err != nil {
+		return nil, err
+	}
+	staging, err := os.MkdirTemp(parent, filepath.Base(a.PendingDir)+".tmp-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(staging)
+
+	m, err := a.stageVerified(r, staging)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(staging, manifestName), manifest, 0o600); err != nil {
+		return nil, err
+	}
+	if err := os.RemoveAll(a.PendingDir); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(staging, a.PendingDir); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// ApplyPending applies the archive staged in PendingDir by Restore, if any,
+// and removes it. It returns a nil Manifest when nothing is pending.
+func (a *Archiver) ApplyPending() (*Manifest, error) {
+	if a.PendingDir == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(filepath.Join(a.PendingDir, manifestName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("%w: manifest: %w", ErrInvalid, err)
+	}
+	if err := a.apply(m, a.PendingDir); err != nil {
+		return nil, err
+	}
+
+	return m, os.RemoveAll(a.PendingDir)
+}
+
+// stageVerified extracts the archive read from r into dir and checks it
+// against its manifest and the known sources.
+func (a *Archiver) stageVerified(r io.Reader, dir string) (*Manifest, error) {
+	m, staged, err := a.stage(r, dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := verify(m, staged); err != nil {
+		return nil, err
+	}
+	for _, name := range m.Sources {
+		if _, ok := a.source(name); !ok {
+			return nil, fmt.Errorf("%w: unknown source %q", ErrInvalid, name)
+		}
+	}
+
+	return m, nil
+}
+
+// apply restores every source of m from the archive staged in dir.
+func (a *Archiver) apply(m *Manifest, dir string) error {
+	for _, name := range m.Sources {
+		src, ok := a.source(name)
+		if !ok {
+			return fmt.Errorf("%w: unknown source %q", ErrInvalid, name)
+		}
+		if err := src.restore(m, dir); err != nil {
+			return fmt.Errorf("restore %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+func (a *Archiver) source(name string) (Source, bool) {
+	for _, src := range a.Sources {
+		if src.Name == name {
+			return src, true
+		}
+	}
+
+	return Source{}, false
+}
+
+// sourceOf returns the source an archive path belongs to.
+func (a *Archiver) sourceOf(name string) (Source, bool) {
+	for _, src := range a.Sources {
+		if name == src.Name || strings.HasPrefix(name, src.Name+"/") {
+			return src, true
+		}
+	}
+
+	return Source{}, false
+}
+
+// stage extracts the archive into dir and returns its manifest and the
+// checksums of the extracted files.
+func (a *Archiver) stage(r io.Reader, dir string) (*Manifest, map[string]File, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+	tr := tar.NewReader(gz)
+
+	var m *Manifest
+	staged := make(map[string]File)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %w", ErrInvalid, err)
+		}
+
+		if hdr.Name == manifestName {
+			m = &Manifest{}
+			if err := json.NewDecoder(tr).Decode(m); err != nil {
+				return nil, nil, fmt.Errorf("%w: manifest: %w", ErrInvalid, err)
+			}
+			continue
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return nil, nil, fmt.Errorf("%w: %s is not a regular file", ErrInvalid, hdr.Name)
+		}
+		if !fs.ValidPath(hdr.Name) {
+			return nil, nil, fmt.Errorf("%w: invalid path %q", ErrInvalid, hdr.Name)
+		}
+		if _, ok := a.sourceOf(hdr.Name); !ok {
+			return nil, nil, fmt.Errorf("%w: %s belongs to no known source", ErrInvalid, hdr.Name)
+		}
+
+		f, err := stageFile(tr, filepath.Join(dir, filepath.FromSlash(hdr.Name)))
+		if err != nil {
+			return nil, nil, err
+		}
+		f.Path = hdr.Name
+		staged[hdr.Name] = f
+	}
+
+	if m == nil {
+		return nil, nil, fmt.Errorf("%w: missing %s", ErrInvalid, manifestName)
+	}
+
+	return m, staged, nil
+}
+
+func stageFile(r io.Reader, file string) (File, error) {
+	if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+		return File{}, err
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return File{}, fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, h), r)
+	if err != nil {
+		return File{}, fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+
+	return File{Size: n, SHA256: hex.EncodeToString(h.Sum(nil))}, f.Close()
+}
+
+// verify checks that the staged files are exactly those of the manifest.
+func verify(m *Manifest, staged map[string]File) error {
+	if m.Format < 1 || m.Format > FormatVersion {
+		return fmt.Errorf(
+			"%w: format %d is not supported (metal-boot supports up to %d)",
+			ErrInvalid, m.Format, FormatVersion,
+		)
+	}
+	if len(m.Files) != len(staged) {
+		return fmt.Errorf("%w: archive holds %d files, manifest lists %d",
+			ErrInvalid, len(staged), len(m.Files))
+	}
+	for _, want := range m.Files {
+		got, ok := staged[want.Path]
+		if !ok {
+			return fmt.Errorf("%w: %s is missing", ErrInvalid, want.Path)
+		}
+		if got.Size != want.Size || got.SHA256 != want.SHA256 {
+			return fmt.Errorf("%w: %s does not match its checksum", ErrInvalid, want.Path)
+		}
+		if !slices.ContainsFunc(m.Sources, func(name string) bool {
+			return want.Path == name || strings.HasPrefix(want.Path, name+"/")
+		}) {
+			return fmt.Errorf("%w: %s belongs to no listed source", ErrInvalid, want.Path)
+		}
+	}
+
+	return nil
+}
+
+// restore replaces the files of s with the staged files of the archive.
+func (s Source) restore(m *Manifest, staging string) error {
+	archived := make(map[string]bool)
+	for _, f := range m.Files {
+		if f.Path == s.Name {
+			archived[""] = true
+		} else if rel, ok := strings.CutPrefix(f.Path, s.Name+"/"); ok {
+			archived[rel] = true
+		}
+	}
+
+	existing, err := s.files()
+	if err != nil {
+		return err
+	}
+	for _, rel := range existing {
+		if !archived[rel] {
+			if err := os.Remove(filepath.Join(s.Path, filepath.FromSlash(rel))); err != nil {
+				return err
+			}
+		}
+	}
+
+	for rel := range archived {
+		name, dst := s.Name, s.Path
+		if rel != "" {
+			name, dst = s.Name+"/"+rel, filepath.Join(s.Path, filepath.FromSlash(rel))
+		}
+		if err := copyFile(filepath.Join(staging, filepath.FromSlash(name)), dst); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// copyFile replaces dst with a copy of src through a rename, so readers never
+// see a partial file.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".restore-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, in); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), dst)
+}
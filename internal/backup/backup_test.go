@@ -0,0 +1,237 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/metal3-community/metal-boot/internal/config"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func newTestArchiver(t *testing.T) (*Archiver, string) {
+	t.Helper()
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "hosts", "ironic-aa.conf"), "aa:bb:cc:dd:ee:ff,set:node\n")
+	writeFile(t, filepath.Join(root, "leases"), "1 aa:bb:cc:dd:ee:ff 10.0.0.2 node *\n")
+	writeFile(t, filepath.Join(root, "tftp", "aa-bb-cc-dd-ee-ff", "RPI_EFI.fd"), "varstore")
+	writeFile(t, filepath.Join(root, "tftp", "ipxe.efi"), "not state")
+
+	return &Archiver{
+		Version: "v1.2.3",
+		Sources: []Source{
+			{Name: "dnsmasq/hosts", Path: filepath.Join(root, "hosts")},
+			{Name: "dnsmasq/leases", Path: filepath.Join(root, "leases")},
+			{
+				Name:  "firmware/varstores",
+				Path:  filepath.Join(root, "tftp"),
+				Match: func(rel string) bool { return strings.HasSuffix(rel, "/RPI_EFI.fd") },
+			},
+		},
+	}, root
+}
+
+func TestExportRestore(t *testing.T) {
+	a, root := newTestArchiver(t)
+
+	var archive bytes.Buffer
+	m, err := a.Export(&archive)
+	if err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	if len(m.Files) != 3 || m.Version != "v1.2.3" {
+		t.Fatalf("manifest = %+v, want 3 files of v1.2.3", m)
+	}
+
+	// Drift from the backup: a new host, a changed varstore, a lost lease.
+	writeFile(t, filepath.Join(root, "hosts", "ironic-bb.conf"), "bb:bb:cc:dd:ee:ff,set:node\n")
+	writeFile(t, filepath.Join(root, "tftp", "aa-bb-cc-dd-ee-ff", "RPI_EFI.fd"), "changed")
+	if err := os.Remove(filepath.Join(root, "leases")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := a.Restore(bytes.NewReader(archive.Bytes())); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "hosts", "ironic-bb.conf")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("host added after the backup survived the restore: %v", err)
+	}
+	if got := readFile(t, filepath.Join(root, "tftp", "aa-bb-cc-dd-ee-ff", "RPI_EFI.fd")); got != "varstore" {
+		t.Errorf("varstore = %q, want %q", got, "varstore")
+	}
+	if got := readFile(t, filepath.Join(root, "leases")); !strings.Contains(got, "10.0.0.2") {
+		t.Errorf("leases = %q, want the archived lease", got)
+	}
+	if got := readFile(t, filepath.Join(root, "tftp", "ipxe.efi")); got != "not state" {
+		t.Errorf("unmatched file = %q, want it untouched", got)
+	}
+}
+
+func TestRestorePending(t *testing.T) {
+	a, root := newTestArchiver(t)
+	a.PendingDir = filepath.Join(root, "pending")
+
+	var archive bytes.Buffer
+	if _, err := a.Export(&archive); err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	varstore := filepath.Join(root, "tftp", "aa-bb-cc-dd-ee-ff", "RPI_EFI.fd")
+	writeFile(t, varstore, "changed")
+
+	if _, err := a.Restore(bytes.NewReader(archive.Bytes())); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	if got := readFile(t, varstore); got != "changed" {
+		t.Errorf("varstore = %q before ApplyPending, want it untouched", got)
+	}
+
+	m, err := a.ApplyPending()
+	if err != nil {
+		t.Fatalf("ApplyPending() error = %v", err)
+	}
+	if m == nil || len(m.Files) != 3 {
+		t.Fatalf("ApplyPending() manifest = %+v, want 3 files", m)
+	}
+	if got := readFile(t, varstore); got != "varstore" {
+		t.Errorf("varstore = %q, want %q", got, "varstore")
+	}
+	if _, err := os.Stat(a.PendingDir); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("pending restore survived ApplyPending: %v", err)
+	}
+
+	if m, err := a.ApplyPending(); m != nil || err != nil {
+		t.Errorf("ApplyPending() with nothing pending = %+v, %v, want nil, nil", m, err)
+	}
+}
+
+func TestSourcesSkipSessionKeys(t *testing.T) {
+	var state Source
+	for _, s := range Sources(&config.Config{StatePath: "/shared/state"}) {
+		if s.Name == "state" {
+			state = s
+		}
+	}
+	for rel, want := range map[string]bool{
+		"hosts.json":                          true,
+		"qemu/node.json":                      true,
+		"redfish-sessions.enc":                false,
+		"redfish-sessions.key":                false,
+		"qemu/node-tpm/tpm.key":               false,
+		"restore-pending/state/hosts.json":    false,
+		"restore-pending.tmp-1/manifest.json": false,
+	} {
+		if got := state.matches(rel); got != want {
+			t.Errorf("state source matches %s = %v, want %v", rel, got, want)
+		}
+	}
+}
+
+// buildArchive writes a raw archive of members, in order.
+func buildArchive(t *testing.T, members map[string]string, order ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, name := range order {
+		content := members[name]
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRestoreRejects(t *testing.T) {
+	a, root := newTestArchiver(t)
+	manifest := func(m Manifest) string {
+		b, _ := json.Marshal(m)
+		return string(b)
+	}
+	hostFile := "dnsmasq/hosts/ironic-aa.conf"
+
+	tests := []struct {
+		name    string
+		archive []byte
+	}{
+		{
+			name:    "not gzip",
+			archive: []byte("plain text"),
+		},
+		{
+			name:    "no manifest",
+			archive: buildArchive(t, map[string]string{hostFile: "x"}, hostFile),
+		},
+		{
+			name: "newer format",
+			archive: buildArchive(t, map[string]string{
+				manifestName: manifest(Manifest{Format: FormatVersion + 1}),
+			}, manifestName),
+		},
+		{
+			name: "checksum mismatch",
+			archive: buildArchive(t, map[string]string{
+				hostFile: "tampered",
+				manifestName: manifest(Manifest{
+					Format:  FormatVersion,
+					Sources: []string{"dnsmasq/hosts"},
+					Files:   []File{{Path: hostFile, Size: 8, SHA256: strings.Repeat("0", 64)}},
+				}),
+			}, hostFile, manifestName),
+		},
+		{
+			name: "path traversal",
+			archive: buildArchive(t, map[string]string{
+				"dnsmasq/hosts/../../etc/passwd": "root",
+			}, "dnsmasq/hosts/../../etc/passwd"),
+		},
+		{
+			name: "unknown source",
+			archive: buildArchive(t, map[string]string{
+				"etc/passwd": "root",
+			}, "etc/passwd"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := a.Restore(bytes.NewReader(tt.archive)); !errors.Is(err, ErrInvalid) {
+				t.Fatalf("Restore() error = %v, want ErrInvalid", err)
+			}
+			if got := readFile(t, filepath.Join(root, "hosts", "ironic-aa.conf")); got != "aa:bb:cc:dd:ee:ff,set:node\n" {
+				t.Errorf("host file = %q, want it untouched", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package backup
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+
+	dnsmasqconfig "github.com/metal3-community/metal-boot/internal/backend/dnsmasq/config"
+	"github.com/metal3-community/metal-boot/internal/config"
+	"github.com/metal3-community/uefi-firmware-manager/edk2"
+)
+
+// Sources returns the state metal-boot keeps on disk with cfg: dnsmasq hosts,
+// options and leases, the host state store, per-node iPXE and grub boot
+// configs, per-node firmware varstores and GPU firmware groups.
+func Sources(cfg *config.Config) []Source {
+	sources := []Source{
+		{Name: "state", Path: cfg.StatePath, Match: stateFile},
+		{
+			Name: "boot/pxelinux.cfg",
+			Path: filepath.Join(cfg.Static.RootDirectory, "pxelinux.cfg"),
+		},
+		{
+			Name: "boot/grub",
+			Path: cfg.Tftp.RootDirectory,
+			Match: func(rel string) bool {
+				return !strings.Contains(rel, "/") && strings.HasPrefix(rel, "grub.cfg-")
+			},
+		},
+		{
+			Name: "firmware/varstores",
+			Path: cfg.Tftp.RootDirectory,
+			Match: func(rel string) bool {
+				dir, file := path.Split(rel)
+				return file == edk2.FirmwareFileName && dir != "" &&
+					!strings.Contains(strings.TrimSuffix(dir, "/"), "/")
+			},
+		},
+	}
+
+	root := cfg.Dnsmasq.RootDirectory
+	sources = append(sources,
+		Source{Name: "dnsmasq/hosts", Path: filepath.Join(root, dnsmasqconfig.HostsDir)},
+		Source{Name: "dnsmasq/opts", Path: filepath.Join(root, dnsmasqconfig.OptsDir)},
+		Source{Name: "dnsmasq/leases", Path: filepath.Join(root, "dnsmasq.leases")},
+	)
+	if cfg.GPUFirmware.Enabled {
+		sources = append(sources, Source{
+			Name: "gpu-firmware/groups.json",
+			Path: filepath.Join(cfg.GPUFirmware.Directory, "groups.json"),
+		})
+	}
+
+	return sources
+}
+
+// pendingName is the directory below the state path holding a restore that
+// is applied at the next start.
+const pendingName = "restore-pending"
+
+// PendingDir returns the directory a restore is staged in with cfg until
+// metal-boot restarts.
+func PendingDir(cfg *config.Config) string {
+	return filepath.Join(cfg.StatePath, pendingName)
+}
+
+// stateFile reports whether the file rel below the state path is backed up.
+// Keys are not, nor the Redfish sessions encrypted with one: an archive must
+// not carry a secret next to its ciphertext, and sessions go stale anyway.
+// Nor is a pending restore, which is not state yet.
+func stateFile(rel string) bool {
+	name := path.Base(rel)
+	return !strings.HasSuffix(name, ".key") && !strings.HasPrefix(name, "redfish-sessions.") &&
+		!strings.HasPrefix(rel, pendingName)
+}
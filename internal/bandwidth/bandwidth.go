@@ -0,0 +1,219 @@
+// Package bandwidth caps the throughput of TFTP and HTTP artifact transfers,
+// in total and per client, so that provisioning traffic cannot saturate an
+// uplink shared with production workloads.
+//
+// Rates are enforced with token buckets that may go into debt: a transfer
+// sends a chunk and then sleeps until the bucket has paid for it, so that the
+// average rate holds however large the chunks are.
+package bandwidth
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// chunkSize bounds how much is written or read before waiting, which keeps
+// the bursts on the wire short.
+const chunkSize = 32 << 10
+
+// Shaper limits the rate of transfers.
+type Shaper struct {
+	// Global is the combined rate of all transfers in bytes per second. Zero
+	// means unlimited.
+	Global int64
+	// PerClient is the combined rate of the transfers of one client in bytes
+	// per second. Zero means unlimited.
+	PerClient int64
+
+	once   sync.Once
+	global *bucket
+
+	mu      sync.Mutex
+	clients map[string]*client
+}
+
+type client struct {
+	bucket *bucket
+	// transfers counts the transfers using bucket, which is dropped once
+	// none are left.
+	transfers int
+}
+
+// Reader returns r limited to the rates of s as a transfer of the client key,
+// and a function to call when the transfer is done. A nil Shaper returns r.
+func (s *Shaper) Reader(ctx context.Context, key string, r io.Reader) (io.Reader, func()) {
+	if s == nil {
+		return r, func() {}
+	}
+	c, done := s.acquire(key)
+
+	return &reader{ctx: ctx, shaper: s, client: c, r: r}, done
+}
+
+// Middleware limits the rate of the responses of next, keyed by client IP.
+// A nil Shaper returns next unchanged.
+func (s *Shaper) Middleware(next http.Handler) http.Handler {
+	if s == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, done := s.acquire(clientKey(r.RemoteAddr))
+		defer done()
+
+		next.ServeHTTP(&writer{ResponseWriter: w, ctx: r.Context(), shaper: s, client: c}, r)
+	})
+}
+
+// acquire returns the bucket of the client key and a function that releases it.
+func (s *Shaper) acquire(key string) (*bucket, func()) {
+	s.once.Do(func() { s.global = newBucket(s.Global) })
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.clients == nil {
+		s.clients = make(map[string]*client)
+	}
+	c := s.clients[key]
+	if c == nil {
+		c = &client{bucket: newBucket(s.PerClient)}
+		s.clients[key] = c
+	}
+	c.transfers++
+
+	var once sync.Once
+	return c.bucket, func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if c.transfers--; c.transfers == 0 {
+				delete(s.clients, key)
+			}
+		})
+	}
+}
+
+// wait blocks until n bytes sent by the client with bucket c fit the rates.
+func (s *Shaper) wait(ctx context.Context, c *bucket, n int) error {
+	now := time.Now()
+	d := max(s.global.reserve(now, n), c.reserve(now, n))
+	if d <= 0 {
+		return nil
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// bucket is a token bucket holding up to burst bytes, refilled at rate bytes
+// per second. A nil bucket is unlimited.
+type bucket struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate int64) *bucket {
+	if rate <= 0 {
+		return nil
+	}
+	burst := float64(max(rate/10, chunkSize))
+
+	return &bucket{rate: float64(rate), burst: burst, tokens: burst, last: time.Now()}
+}
+
+// reserve takes n bytes from the bucket and returns how long the caller has
+// to wait until they are paid for.
+func (b *bucket) reserve(now time.Time, n int) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = min(b.burst, b.tokens+elapsed.Seconds()*b.rate)
+		b.last = now
+	}
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+type reader struct {
+	ctx    context.Context
+	shaper *Shaper
+	client *bucket
+	r      io.Reader
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if len(p) > chunkSize {
+		p = p[:chunkSize]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.shaper.wait(r.ctx, r.client, n); werr != nil {
+			return n, werr
+		}
+	}
+
+	return n, err
+}
+
+type writer struct {
+	http.ResponseWriter
+	ctx    context.Context
+	shaper *Shaper
+	client *bucket
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), chunkSize)]
+		n, err := w.ResponseWriter.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		if err := w.shaper.wait(w.ctx, w.client, n); err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+
+	return written, nil
+}
+
+// Unwrap lets http.ResponseController reach the Flusher of the underlying
+// ResponseWriter.
+func (w *writer) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// clientKey returns the IP of a request's remote address.
+func clientKey(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+
+	return host
+}
@@ -0,0 +1,82 @@
+package bandwidth
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBucketReserve(t *testing.T) {
+	now := time.Now()
+	b := &bucket{rate: 1000, burst: 500, tokens: 500, last: now}
+
+	tests := []struct {
+		name  string
+		after time.Duration
+		n     int
+		want  time.Duration
+	}{
+		{"within burst", 0, 500, 0},
+		{"into debt", 0, 250, 250 * time.Millisecond},
+		{"debt paid off", 500 * time.Millisecond, 0, 0},
+		{"refill is capped at burst", 10 * time.Second, 1000, 500 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = now.Add(tt.after)
+			if got := b.reserve(now, tt.n); got != tt.want {
+				t.Errorf("reserve(%d) = %v, want %v", tt.n, got, tt.want)
+			}
+		})
+	}
+
+	var unlimited *bucket
+	if got := unlimited.reserve(now, 1<<30); got != 0 {
+		t.Errorf("nil reserve() = %v, want 0", got)
+	}
+}
+
+func TestReaderRate(t *testing.T) {
+	// 64 KiB at 256 KiB/s with a 32 KiB burst takes about 125ms.
+	s := &Shaper{PerClient: 256 << 10}
+	r, done := s.Reader(context.Background(), "10.0.0.1", bytes.NewReader(make([]byte, 64<<10)))
+	start := time.Now()
+	n, err := io.Copy(io.Discard, r)
+	done()
+	elapsed := time.Since(start)
+	if err != nil || n != 64<<10 {
+		t.Fatalf("Copy() = %d, %v", n, err)
+	}
+	if elapsed < 100*time.Millisecond || elapsed > time.Second {
+		t.Errorf("transfer took %v, want about 125ms", elapsed)
+	}
+
+	s.mu.Lock()
+	clients := len(s.clients)
+	s.mu.Unlock()
+	if clients != 0 {
+		t.Errorf("%d client buckets left after the transfer", clients)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	body := make([]byte, 100<<10)
+	h := (&Shaper{Global: 1 << 20}).Middleware(http.HandlerFunc(
+		func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write(body)
+			if err := http.NewResponseController(w).Flush(); err != nil {
+				t.Errorf("Flush() error = %v", err)
+			}
+		},
+	))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/iso/boot.iso", nil))
+	if rec.Body.Len() != len(body) || !rec.Flushed {
+		t.Errorf("got %d bytes, flushed %v", rec.Body.Len(), rec.Flushed)
+	}
+}
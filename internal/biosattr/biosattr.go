@@ -0,0 +1,369 @@
+// Package biosattr maps Redfish Bios attributes to the UEFI variables of an
+// EDK2 varstore.
+//
+// The attributes are defined in a YAML file, by default the embedded
+// attributes.yaml, so that supporting another firmware setting does not need
+// a code change. The same definitions encode and decode the variables and
+// describe the attributes in the Redfish AttributeRegistry.
+package biosattr
+
+import (
+	_ "embed"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/metal3-community/uefi-firmware-manager/efi"
+)
+
+// Attribute types, as named by the Redfish AttributeRegistry.
+const (
+	TypeEnumeration = "Enumeration"
+	TypeInteger     = "Integer"
+	TypeBoolean     = "Boolean"
+	TypeString      = "String"
+)
+
+// Encodings of attribute values in variable data.
+const (
+	EncodingUint8  = "uint8"
+	EncodingUint16 = "uint16"
+	EncodingUint32 = "uint32"
+	EncodingUint64 = "uint64"
+	EncodingASCII  = "ascii"
+)
+
+var (
+	// ErrUnknownAttribute is returned for attributes the registry does not
+	// define.
+	ErrUnknownAttribute = errors.New("unknown bios attribute")
+	// ErrReadOnly is returned when setting a read-only attribute.
+	ErrReadOnly = errors.New("bios attribute is read-only")
+	// ErrInvalidValue is returned for values of the wrong type or out of range.
+	ErrInvalidValue = errors.New("invalid bios attribute value")
+)
+
+//go:embed attributes.yaml
+var defaultAttributes []byte
+
+// Value is one value of an Enumeration attribute.
+type Value struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName,omitempty"`
+	// Data is the number stored in the variable for this value.
+	Data uint64 `json:"data"`
+}
+
+// Attribute is a Bios attribute and the variable that holds it.
+type Attribute struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName,omitempty"`
+	HelpText    string `json:"helpText,omitempty"`
+	Type        string `json:"type"`
+	ReadOnly    bool   `json:"readOnly,omitempty"`
+
+	Variable string `json:"variable"`
+	Guid     string `json:"guid"`
+	Encoding string `json:"encoding"`
+
+	Values     []Value `json:"values,omitempty"`
+	LowerBound *int64  `json:"lowerBound,omitempty"`
+	UpperBound *int64  `json:"upperBound,omitempty"`
+	MaxLength  int     `json:"maxLength,omitempty"`
+
+	// Default is reported while the varstore does not hold the variable.
+	Default any `json:"default"`
+}
+
+// Variables is the part of the firmware manager the registry reads and
+// writes variables through.
+type Variables interface {
+	GetVariable(name string) (*efi.EfiVar, error)
+	SetVariable(name string, value *efi.EfiVar) error
+}
+
+// Registry is a set of attribute definitions.
+type Registry struct {
+	attributes []Attribute
+	byName     map[string]*Attribute
+}
+
+// Load reads the attribute definitions at path, or the embedded ones when
+// path is empty.
+func Load(path string) (*Registry, error) {
+	data := defaultAttributes
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("failed to read bios attributes: %w", err)
+		}
+	}
+
+	return Parse(data)
+}
+
+// Parse parses and validates YAML attribute definitions.
+func Parse(data []byte) (*Registry, error) {
+	var file struct {
+		Attributes []Attribute `json:"attributes"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse bios attributes: %w", err)
+	}
+
+	r := &Registry{
+		attributes: file.Attributes,
+		byName:     make(map[string]*Attribute, len(file.Attributes)),
+	}
+	for i := range r.attributes {
+		a := &r.attributes[i]
+		if err := a.validate(); err != nil {
+			return nil, fmt.Errorf("bios attribute %q: %w", a.Name, err)
+		}
+		if _, dup := r.byName[a.Name]; dup {
+			return nil, fmt.Errorf("bios attribute %q is defined twice", a.Name)
+		}
+		r.byName[a.Name] = a
+	}
+
+	return r, nil
+}
+
+func (a *Attribute) validate() error {
+	if a.Name == "" || a.Variable == "" {
+		return errors.New("name and variable are required")
+	}
+	if _, err := efi.ParseGUID(a.Guid); err != nil {
+		return fmt.Errorf("guid: %w", err)
+	}
+
+	switch a.Encoding {
+	case EncodingUint8, EncodingUint16, EncodingUint32, EncodingUint64:
+		if a.Type == TypeString {
+			return fmt.Errorf("%s attributes need the %s encoding", TypeString, EncodingASCII)
+		}
+	case EncodingASCII:
+		if a.Type != TypeString {
+			return fmt.Errorf("the %s encoding is only for %s attributes",
+				EncodingASCII, TypeString)
+		}
+	default:
+		return fmt.Errorf("unknown encoding %q", a.Encoding)
+	}
+
+	switch a.Type {
+	case TypeEnumeration:
+		if len(a.Values) == 0 {
+			return errors.New("enumerations need values")
+		}
+	case TypeInteger, TypeBoolean, TypeString:
+	default:
+		return fmt.Errorf("unknown type %q", a.Type)
+	}
+
+	if _, err := a.encode(a.Default); err != nil {
+		return fmt.Errorf("default: %w", err)
+	}
+
+	return nil
+}
+
+// Attributes returns the definitions in the order of the file.
+func (r *Registry) Attributes() []Attribute {
+	return r.attributes
+}
+
+// Read returns the value of every attribute in vars. Attributes whose
+// variable is missing or cannot be decoded report their default.
+func (r *Registry) Read(vars Variables) map[string]any {
+	values := make(map[string]any, len(r.attributes))
+	for _, a := range r.attributes {
+		values[a.Name] = a.Default
+		v, err := vars.GetVariable(a.Variable)
+		if err != nil || !strings.EqualFold(v.Guid.String(), a.Guid) {
+			continue
+		}
+		if value, err := a.decode(v.Data); err == nil {
+			values[a.Name] = value
+		}
+	}
+
+	return values
+}
+
+// Check reports whether Apply would accept values, without setting any.
+func (r *Registry) Check(values map[string]any) error {
+	_, err := r.encodeAll(values)
+	return err
+}
+
+// Apply sets the attributes of values in vars. Every value is checked before
+// the first variable is set, so a request with one bad attribute changes
+// nothing.
+func (r *Registry) Apply(vars Variables, values map[string]any) error {
+	encoded, err := r.encodeAll(values)
+	if err != nil {
+		return err
+	}
+
+	for a, data := range encoded {
+		v, err := vars.GetVariable(a.Variable)
+		if err != nil {
+			guid := a.Guid
+			v, err = efi.NewEfiVar(a.Variable, &guid,
+				efi.EFI_VARIABLE_NON_VOLATILE|
+					efi.EFI_VARIABLE_BOOTSERVICE_ACCESS|
+					efi.EFI_VARIABLE_RUNTIME_ACCESS,
+				nil, 0)
+			if err != nil {
+				return err
+			}
+		}
+		v.Data = data
+		if err := vars.SetVariable(a.Variable, v); err != nil {
+			return fmt.Errorf("failed to set %s: %w", a.Variable, err)
+		}
+	}
+
+	return nil
+}
+
+// encodeAll returns the variable data of every attribute of values.
+func (r *Registry) encodeAll(values map[string]any) (map[*Attribute][]byte, error) {
+	encoded := make(map[*Attribute][]byte, len(values))
+	for name, value := range values {
+		a, ok := r.byName[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownAttribute, name)
+		}
+		if a.ReadOnly {
+			return nil, fmt.Errorf("%w: %s", ErrReadOnly, name)
+		}
+		data, err := a.encode(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		encoded[a] = data
+	}
+
+	return encoded, nil
+}
+
+// encode returns the variable data holding value.
+func (a *Attribute) encode(value any) ([]byte, error) {
+	var n uint64
+	switch a.Type {
+	case TypeString:
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%w: %v is not a string", ErrInvalidValue, value)
+		}
+		if a.MaxLength > 0 && len(s) > a.MaxLength {
+			return nil, fmt.Errorf("%w: longer than %d", ErrInvalidValue, a.MaxLength)
+		}
+		return append([]byte(s), 0), nil
+	case TypeBoolean:
+		b, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%w: %v is not a boolean", ErrInvalidValue, value)
+		}
+		if b {
+			n = 1
+		}
+	case TypeEnumeration:
+		s, _ := value.(string)
+		i := -1
+		for j, v := range a.Values {
+			if v.Name == s {
+				i = j
+			}
+		}
+		if i < 0 {
+			return nil, fmt.Errorf("%w: %v is not one of the values", ErrInvalidValue, value)
+		}
+		n = a.Values[i].Data
+	case TypeInteger:
+		i, ok := toInt64(value)
+		if !ok {
+			return nil, fmt.Errorf("%w: %v is not an integer", ErrInvalidValue, value)
+		}
+		if (a.LowerBound != nil && i < *a.LowerBound) ||
+			(a.UpperBound != nil && i > *a.UpperBound) || i < 0 {
+			return nil, fmt.Errorf("%w: %d is out of range", ErrInvalidValue, i)
+		}
+		n = uint64(i)
+	}
+
+	size := encodingSize(a.Encoding)
+	if size < 8 && n >= 1<<(8*size) {
+		return nil, fmt.Errorf("%w: %d does not fit in %s", ErrInvalidValue, n, a.Encoding)
+	}
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, n)
+
+	return data[:size], nil
+}
+
+// decode is the inverse of encode.
+func (a *Attribute) decode(data []byte) (any, error) {
+	if a.Encoding == EncodingASCII {
+		s, _, _ := strings.Cut(string(data), "\x00")
+		return s, nil
+	}
+
+	size := encodingSize(a.Encoding)
+	if len(data) < size {
+		return nil, fmt.Errorf("%s needs %d bytes, variable has %d", a.Encoding, size, len(data))
+	}
+	buf := make([]byte, 8)
+	copy(buf, data[:size])
+	n := binary.LittleEndian.Uint64(buf)
+
+	switch a.Type {
+	case TypeBoolean:
+		return n != 0, nil
+	case TypeEnumeration:
+		for _, v := range a.Values {
+			if v.Data == n {
+				return v.Name, nil
+			}
+		}
+		return nil, fmt.Errorf("no value for %d", n)
+	default:
+		return int64(n), nil
+	}
+}
+
+func encodingSize(encoding string) int {
+	switch encoding {
+	case EncodingUint8:
+		return 1
+	case EncodingUint16:
+		return 2
+	case EncodingUint32:
+		return 4
+	default:
+		return 8
+	}
+}
+
+// toInt64 converts the numbers encoding/json and YAML decode to.
+func toInt64(value any) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int64:
+		return v, true
+	case float64:
+		if v != math.Trunc(v) || v > math.MaxInt64 || v < math.MinInt64 {
+			return 0, false
+		}
+		return int64(v), true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,167 @@
+package biosattr
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/metal3-community/uefi-firmware-manager/efi"
+)
+
+type vars map[string]*efi.EfiVar
+
+func (v vars) GetVariable(name string) (*efi.EfiVar, error) {
+	if ev, ok := v[name]; ok {
+		return ev, nil
+	}
+	return nil, errors.New("not found")
+}
+
+func (v vars) SetVariable(name string, value *efi.EfiVar) error {
+	v[name] = value
+	return nil
+}
+
+func TestLoadDefault(t *testing.T) {
+	r, err := Load("")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(r.Attributes()) == 0 {
+		t.Fatal("Load() returned no attributes")
+	}
+
+	got := r.Read(vars{})
+	if got["ConsolePref"] != "Auto" || got["IPv6Support"] != false {
+		t.Errorf("Read() of an empty varstore = %v, want the defaults", got)
+	}
+}
+
+func TestApplyRoundTrip(t *testing.T) {
+	r, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := vars{}
+
+	// Values as encoding/json decodes them.
+	want := map[string]any{
+		"BootTimeout":     float64(10),
+		"IPv6Support":     true,
+		"ConsolePref":     "Serial",
+		"SystemTableMode": "DeviceTree",
+	}
+	if err := r.Apply(v, want); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	if d := v["Timeout"].Data; !reflect.DeepEqual(d, []byte{10, 0}) {
+		t.Errorf("Timeout data = %v, want [10 0]", d)
+	}
+	if g := v["ConsolePref"].Guid.String(); !strings.EqualFold(g, "2d2358b4-e96c-484d-b2dd-7c2edfc7d56f") {
+		t.Errorf("ConsolePref guid = %s", g)
+	}
+
+	got := r.Read(v)
+	for name, value := range want {
+		if n, ok := value.(float64); ok {
+			value = int64(n)
+		}
+		if got[name] != value {
+			t.Errorf("Read()[%s] = %v (%T), want %v", name, got[name], got[name], value)
+		}
+	}
+}
+
+func TestApplyRejectsWithoutPartialWrites(t *testing.T) {
+	r, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		values map[string]any
+		want   error
+	}{
+		{"unknown", map[string]any{"BootTimeout": float64(1), "Bogus": 1}, ErrUnknownAttribute},
+		{"enum", map[string]any{"BootTimeout": float64(1), "ConsolePref": "HDMI"}, ErrInvalidValue},
+		{"range", map[string]any{"BootTimeout": float64(1), "VLANID": float64(5000)}, ErrInvalidValue},
+		{"type", map[string]any{"BootTimeout": float64(1), "IPv6Support": "yes"}, ErrInvalidValue},
+		{"fraction", map[string]any{"BootTimeout": 1.5}, ErrInvalidValue},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := r.Check(tt.values); !errors.Is(err, tt.want) {
+				t.Errorf("Check() error = %v, want %v", err, tt.want)
+			}
+			v := vars{}
+			if err := r.Apply(v, tt.values); !errors.Is(err, tt.want) {
+				t.Errorf("Apply() error = %v, want %v", err, tt.want)
+			}
+			if len(v) != 0 {
+				t.Errorf("Apply() set %d variables despite the error", len(v))
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	custom := `
+attributes:
+  - name: AssetTag
+    type: String
+    variable: AssetTag
+    guid: cd7cc258-31db-22e6-9f22-63b0b8eed6b5
+    encoding: ascii
+    maxLength: 8
+    default: ""
+  - name: Serial
+    type: String
+    readOnly: true
+    variable: SerialNumber
+    guid: cd7cc258-31db-22e6-9f22-63b0b8eed6b5
+    encoding: ascii
+    default: unknown
+`
+	r, err := Parse([]byte(custom))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	v := vars{}
+	if err := r.Apply(v, map[string]any{"AssetTag": "rack-3"}); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if got := string(v["AssetTag"].Data); got != "rack-3\x00" {
+		t.Errorf("AssetTag data = %q, want NUL terminated", got)
+	}
+	if got := r.Read(v)["AssetTag"]; got != "rack-3" {
+		t.Errorf("Read()[AssetTag] = %v, want rack-3", got)
+	}
+	if err := r.Apply(v, map[string]any{"AssetTag": "too-long-tag"}); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("Apply() of a long string error = %v, want %v", err, ErrInvalidValue)
+	}
+	if err := r.Apply(v, map[string]any{"Serial": "x"}); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Apply() of a read-only attribute error = %v, want %v", err, ErrReadOnly)
+	}
+
+	invalid := []string{
+		"attributes: [{name: A, type: Integer, variable: A, guid: nope, encoding: uint8, default: 0}]",
+		"attributes: [{name: A, type: Integer, variable: A, " +
+			"guid: 8be4df61-93ca-11d2-aa0d-00e098032b8c, encoding: int7, default: 0}]",
+		"attributes: [{name: A, type: Enumeration, variable: A, " +
+			"guid: 8be4df61-93ca-11d2-aa0d-00e098032b8c, encoding: uint8, default: X}]",
+		"attributes: [{name: A, type: Integer, variable: A, " +
+			"guid: 8be4df61-93ca-11d2-aa0d-00e098032b8c, encoding: uint8, default: 300}]",
+		"attributes: [{name: A, type: Boolean, variable: A, " +
+			"guid: 8be4df61-93ca-11d2-aa0d-00e098032b8c, encoding: uint8, default: false}," +
+			" {name: A, type: Boolean, variable: B, " +
+			"guid: 8be4df61-93ca-11d2-aa0d-00e098032b8c, encoding: uint8, default: false}]",
+	}
+	for _, data := range invalid {
+		if _, err := Parse([]byte(data)); err == nil {
+			t.Errorf("Parse(%q) error = nil", data)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+// Package bootauth binds boot artifact requests to the node they are meant for,
+// so one host cannot fetch another host's iPXE script or images.
+//
+// Two independent checks are supported: the request source IP must match the
+// IP the backend has on record (lease or reservation) for the MAC in the URL,
+// and the URL may carry a short-lived HMAC token minted when the URL was handed
+// out over DHCP.
+package bootauth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/metal3-community/metal-boot/internal/backend"
+)
+
+// TokenParam is the query parameter carrying the boot token.
+const TokenParam = "token"
+
+var (
+	errMissingToken = errors.New("missing boot token")
+	errInvalidToken = errors.New("invalid boot token")
+	errExpiredToken = errors.New("expired boot token")
+)
+
+// MACFunc extracts the MAC address a request is for.
+type MACFunc func(r *http.Request) (net.HardwareAddr, error)
+
+// Verifier checks that boot artifact requests come from the node they name.
+type Verifier struct {
+	// Backend is used to look up the IP on record for a MAC.
+	Backend backend.BackendReader
+	// Log is used to log rejected requests.
+	Log *slog.Logger
+	// VerifySourceIP rejects requests whose source IP does not match the
+	// backend record for the MAC.
+	VerifySourceIP bool
+	// Secret is the HMAC key for boot tokens. Tokens are required when set.
+	Secret []byte
+	// TTL is how long a minted token stays valid.
+	TTL time.Duration
+
+	now func() time.Time
+}
+
+func (v *Verifier) clock() time.Time {
+	if v.now != nil {
+		return v.now()
+	}
+	return time.Now()
+}
+
+// Token mints a token for mac that expires after v.TTL.
+func (v *Verifier) Token(mac net.HardwareAddr) string {
+	exp := strconv.FormatInt(v.clock().Add(v.TTL).Unix(), 10)
+	return exp + "." + v.sign(mac, exp)
+}
+
+// SignURL returns a copy of u with a boot token for mac appended. u is
+// returned unchanged when tokens are not enabled.
+func (v *Verifier) SignURL(u *url.URL, mac net.HardwareAddr) *url.URL {
+	if v == nil || len(v.Secret) == 0 || u == nil {
+		return u
+	}
+	signed := *u
+	q := signed.Query()
+	q.Set(TokenParam, v.Token(mac))
+	signed.RawQuery = q.Encode()
+
+	return &signed
+}
+
+// VerifyToken checks that token was minted for mac and has not expired.
+func (v *Verifier) VerifyToken(mac net.HardwareAddr, token string) error {
+	if token == "" {
+		return errMissingToken
+	}
+	exp, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return errInvalidToken
+	}
+	if !hmac.Equal([]byte(sig), []byte(v.sign(mac, exp))) {
+		return errInvalidToken
+	}
+	expUnix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return errInvalidToken
+	}
+	if v.clock().Unix() > expUnix {
+		return errExpiredToken
+	}
+
+	return nil
+}
+
+// VerifySource checks that remoteAddr is the IP on record for mac.
+func (v *Verifier) VerifySource(ctx context.Context, mac net.HardwareAddr, remoteAddr string) error {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	src, err := netip.ParseAddr(host)
+	if err != nil {
+		return fmt.Errorf("invalid source address %q: %w", remoteAddr, err)
+	}
+
+	d, _, err := v.Backend.GetByMac(ctx, mac)
+	if err != nil {
+		return fmt.Errorf("no lease or reservation for %s: %w", mac, err)
+	}
+	if d.IPAddress.Unmap() != src.Unmap() {
+		return fmt.Errorf("source %s does not match %s on record for %s", src, d.IPAddress, mac)
+	}
+
+	return nil
+}
+
+// Middleware wraps next so that requests failing verification get a 403.
+// A nil Verifier returns next unchanged.
+func (v *Verifier) Middleware(macFn MACFunc, next http.Handler) http.Handler {
+	if v == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mac, err := macFn(r)
+		if err != nil {
+			// Let the wrapped handler produce its usual error for bad paths.
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if err := v.verify(r, mac); err != nil {
+			v.Log.Warn("rejected boot artifact request",
+				"mac", mac.String(),
+				"remote_addr", r.RemoteAddr,
+				"path", r.URL.Path,
+				"error", err,
+			)
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (v *Verifier) verify(r *http.Request, mac net.HardwareAddr) error {
+	if len(v.Secret) > 0 {
+		if err := v.VerifyToken(mac, r.URL.Query().Get(TokenParam)); err != nil {
+			return err
+		}
+	}
+	if v.VerifySourceIP {
+		if err := v.VerifySource(r.Context(), mac, r.RemoteAddr); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (v *Verifier) sign(mac net.HardwareAddr, exp string) string {
+	m := hmac.New(sha256.New, v.Secret)
+	m.Write([]byte(strings.ToLower(mac.String())))
+	m.Write([]byte{0})
+	m.Write([]byte(exp))
+
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// PathValueMAC returns a MACFunc reading the named path wildcard.
+func PathValueMAC(name string) MACFunc {
+	return func(r *http.Request) (net.HardwareAddr, error) {
+		return net.ParseMAC(r.PathValue(name))
+	}
+}
+
+// ParentDirMAC is a MACFunc for URLs of the form /<prefix>/<mac>/<file>.
+func ParentDirMAC(r *http.Request) (net.HardwareAddr, error) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 2 {
+		return nil, errors.New("no mac address in path")
+	}
+
+	return net.ParseMAC(parts[len(parts)-2])
+}
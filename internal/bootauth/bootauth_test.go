@@ -0,0 +1,118 @@
+package bootauth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+)
+
+type mockBackend struct {
+	ip netip.Addr
+}
+
+func (m *mockBackend) GetByMac(
+	_ context.Context,
+	mac net.HardwareAddr,
+) (*data.DHCP, *data.Netboot, error) {
+	if !m.ip.IsValid() {
+		return nil, nil, errors.New("not found")
+	}
+	return &data.DHCP{MACAddress: mac, IPAddress: m.ip}, &data.Netboot{}, nil
+}
+
+func (m *mockBackend) GetByIP(context.Context, net.IP) (*data.DHCP, *data.Netboot, error) {
+	return nil, nil, errors.New("not implemented")
+}
+
+func (m *mockBackend) GetKeys(context.Context) ([]net.HardwareAddr, error) {
+	return nil, nil
+}
+
+func TestVerifyToken(t *testing.T) {
+	mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:ff")
+	other, _ := net.ParseMAC("11:22:33:44:55:66")
+	now := time.Unix(1700000000, 0)
+
+	v := &Verifier{Secret: []byte("s3cret"), TTL: time.Minute, now: func() time.Time { return now }}
+	token := v.Token(mac)
+
+	if err := v.VerifyToken(mac, token); err != nil {
+		t.Errorf("VerifyToken() valid token error = %v", err)
+	}
+	if err := v.VerifyToken(other, token); !errors.Is(err, errInvalidToken) {
+		t.Errorf("VerifyToken() other mac error = %v, want %v", err, errInvalidToken)
+	}
+	if err := v.VerifyToken(mac, ""); !errors.Is(err, errMissingToken) {
+		t.Errorf("VerifyToken() empty error = %v, want %v", err, errMissingToken)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if err := v.VerifyToken(mac, token); !errors.Is(err, errExpiredToken) {
+		t.Errorf("VerifyToken() expired error = %v, want %v", err, errExpiredToken)
+	}
+}
+
+func TestMiddlewareSourceIP(t *testing.T) {
+	v := &Verifier{
+		Backend:        &mockBackend{ip: netip.MustParseAddr("10.0.0.5")},
+		Log:            slog.New(slog.DiscardHandler),
+		VerifySourceIP: true,
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	h := v.Middleware(ParentDirMAC, ok)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		want       int
+	}{
+		{name: "matching source", remoteAddr: "10.0.0.5:4000", want: http.StatusOK},
+		{name: "other source", remoteAddr: "10.0.0.6:4000", want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/iso/aa:bb:cc:dd:ee:ff/hook.iso", nil)
+			req.RemoteAddr = tt.remoteAddr
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadSecret(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys", "token.key")
+
+	first, err := LoadSecret(path)
+	if err != nil || len(first) == 0 {
+		t.Fatalf("LoadSecret() = %q, %v", first, err)
+	}
+	again, err := LoadSecret(path)
+	if err != nil || string(again) != string(first) {
+		t.Errorf("LoadSecret() again = %q, %v, want %q", again, err, first)
+	}
+
+	if err := os.WriteFile(path, []byte("\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadSecret(path); err == nil {
+		t.Error("LoadSecret() of an empty file succeeded")
+	}
+	if _, err := LoadSecret(""); err == nil {
+		t.Error("LoadSecret() without a path succeeded")
+	}
+}
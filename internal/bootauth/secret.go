@@ -0,0 +1,48 @@
+package bootauth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// secretSize is the size of the secrets LoadSecret generates.
+const secretSize = 32
+
+// LoadSecret returns the token secret kept in path, creating the file with a
+// random secret when it does not exist, so that tokens outlive a restart.
+func LoadSecret(path string) ([]byte, error) {
+	if path == "" {
+		return nil, errors.New("a secret file is required")
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		secret := make([]byte, secretSize)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		encoded := hex.EncodeToString(secret)
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create secret directory: %w", err)
+		}
+		if err := os.WriteFile(path, []byte(encoded+"\n"), 0o600); err != nil {
+			return nil, fmt.Errorf("failed to write secret: %w", err)
+		}
+		return []byte(encoded), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret: %w", err)
+	}
+
+	secret := strings.TrimSpace(string(b))
+	if secret == "" {
+		return nil, fmt.Errorf("secret file %s is empty", path)
+	}
+
+	return []byte(secret), nil
+}
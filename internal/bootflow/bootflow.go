@@ -0,0 +1,218 @@
+// Package bootflow correlates the DHCP, TFTP and HTTP requests that make up a
+// single netboot.
+//
+// A boot starts with a DHCPDISCOVER; its ID is derived from the client MAC and
+// the DISCOVER transaction ID, so retransmits map to the same boot. Later
+// requests from the client, identified by MAC or by the IP it was given, are
+// tagged with that ID under LogKey, which lets a single log query reconstruct
+// a boot attempt.
+package bootflow
+
+import (
+	"context"
+	"encoding/hex"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogKey is the log and event attribute holding the boot ID.
+const LogKey = "boot_id"
+
+// defaultTTL bounds how long a boot is remembered after its last request.
+const defaultTTL = time.Hour
+
+// Registry tracks the current boot of every client. A nil Registry tracks
+// nothing and returns empty IDs.
+type Registry struct {
+	// TTL is how long a boot is kept after its last request. Zero means an
+	// hour.
+	TTL time.Duration
+
+	mu    sync.Mutex
+	byMAC map[string]*flow
+	byIP  map[netip.Addr]string
+}
+
+type flow struct {
+	id       string
+	xid      [4]byte
+	lastSeen time.Time
+}
+
+// ID returns the boot ID for a DISCOVER with transaction ID xid from mac.
+func ID(mac net.HardwareAddr, xid [4]byte) string {
+	client := strings.ReplaceAll(strings.ToLower(mac.String()), ":", "")
+	return client + "-" + hex.EncodeToString(xid[:])
+}
+
+// Observe records a DHCP packet from mac and returns the ID of the boot it
+// belongs to. A DISCOVER with a new transaction ID starts a new boot, as does
+// any packet from a client without a current boot.
+func (r *Registry) Observe(mac net.HardwareAddr, xid [4]byte, discover bool) string {
+	if r == nil || len(mac) == 0 {
+		return ""
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expire()
+
+	key := strings.ToLower(mac.String())
+	f, ok := r.byMAC[key]
+	if !ok || (discover && f.xid != xid) {
+		if r.byMAC == nil {
+			r.byMAC = make(map[string]*flow)
+		}
+		f = &flow{id: ID(mac, xid), xid: xid}
+		r.byMAC[key] = f
+	}
+	f.lastSeen = time.Now()
+
+	return f.id
+}
+
+// BindIP associates ip with the current boot of mac, so requests that only
+// carry the client address can be correlated.
+func (r *Registry) BindIP(mac net.HardwareAddr, ip net.IP) {
+	addr, ok := netip.AddrFromSlice(ip)
+	if r == nil || !ok || addr.IsUnspecified() {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := strings.ToLower(mac.String())
+	if _, ok := r.byMAC[key]; !ok {
+		return
+	}
+	if r.byIP == nil {
+		r.byIP = make(map[netip.Addr]string)
+	}
+	r.byIP[addr.Unmap()] = key
+}
+
+// ForMAC returns the ID of the current boot of mac, or "".
+func (r *Registry) ForMAC(mac net.HardwareAddr) string {
+	if r == nil || len(mac) == 0 {
+		return ""
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.touch(strings.ToLower(mac.String()))
+}
+
+// ForIP returns the ID of the current boot of the client at ip, or "".
+func (r *Registry) ForIP(ip net.IP) string {
+	addr, ok := netip.AddrFromSlice(ip)
+	if r == nil || !ok {
+		return ""
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.touch(r.byIP[addr.Unmap()])
+}
+
+// touch returns the ID of the boot of key and extends it. Callers must hold
+// r.mu.
+func (r *Registry) touch(key string) string {
+	f, ok := r.byMAC[key]
+	if !ok {
+		return ""
+	}
+	f.lastSeen = time.Now()
+
+	return f.id
+}
+
+// expire drops boots idle for longer than TTL. Callers must hold r.mu.
+func (r *Registry) expire() {
+	ttl := r.TTL
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+	cutoff := time.Now().Add(-ttl)
+	for key, f := range r.byMAC {
+		if f.lastSeen.Before(cutoff) {
+			delete(r.byMAC, key)
+		}
+	}
+	for ip, key := range r.byIP {
+		if _, ok := r.byMAC[key]; !ok {
+			delete(r.byIP, ip)
+		}
+	}
+}
+
+type contextKey struct{}
+
+// WithID returns a copy of ctx carrying the boot ID.
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the boot ID carried by ctx, or "".
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// Logger returns logger with the boot ID carried by ctx attached, or logger
+// itself when ctx carries none.
+func Logger(ctx context.Context, logger *slog.Logger) *slog.Logger {
+	if id := FromContext(ctx); id != "" {
+		return logger.With(LogKey, id)
+	}
+
+	return logger
+}
+
+// Middleware tags requests to next with the boot ID of the client. The client
+// is identified by a {mac} path wildcard, a MAC address path segment, or the
+// remote address. The ID is also returned in the X-Boot-ID
+// response header. A nil Registry returns next unchanged.
+func (r *Registry) Middleware(next http.Handler) http.Handler {
+	if r == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if id := r.forRequest(req); id != "" {
+			w.Header().Set("X-Boot-ID", id)
+			req = req.WithContext(WithID(req.Context(), id))
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+func (r *Registry) forRequest(req *http.Request) string {
+	if mac, err := net.ParseMAC(req.PathValue("mac")); err == nil {
+		if id := r.ForMAC(mac); id != "" {
+			return id
+		}
+	}
+	for segment := range strings.SplitSeq(path.Clean(req.URL.Path), "/") {
+		if mac, err := net.ParseMAC(segment); err == nil {
+			if id := r.ForMAC(mac); id != "" {
+				return id
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+
+	return r.ForIP(net.ParseIP(host))
+}
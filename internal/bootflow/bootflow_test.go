@@ -0,0 +1,87 @@
+package bootflow
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestObserve(t *testing.T) {
+	r := &Registry{}
+	mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:ff")
+
+	first := r.Observe(mac, [4]byte{1, 2, 3, 4}, true)
+	if first != "aabbccddeeff-01020304" {
+		t.Fatalf("Observe() = %q, want aabbccddeeff-01020304", first)
+	}
+	if got := r.Observe(mac, [4]byte{1, 2, 3, 4}, true); got != first {
+		t.Errorf("retransmit = %q, want %q", got, first)
+	}
+	if got := r.Observe(mac, [4]byte{9, 9, 9, 9}, false); got != first {
+		t.Errorf("request = %q, want %q", got, first)
+	}
+
+	second := r.Observe(mac, [4]byte{5, 6, 7, 8}, true)
+	if second == first {
+		t.Errorf("new DISCOVER kept boot %q", first)
+	}
+	if got := r.ForMAC(mac); got != second {
+		t.Errorf("ForMAC() = %q, want %q", got, second)
+	}
+}
+
+func TestBindIP(t *testing.T) {
+	r := &Registry{}
+	mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:ff")
+	ip := net.ParseIP("192.168.1.10")
+
+	r.BindIP(mac, ip)
+	if got := r.ForIP(ip); got != "" {
+		t.Errorf("ForIP() before boot = %q, want empty", got)
+	}
+
+	id := r.Observe(mac, [4]byte{1, 2, 3, 4}, true)
+	r.BindIP(mac, ip)
+	if got := r.ForIP(ip.To4()); got != id {
+		t.Errorf("ForIP() = %q, want %q", got, id)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	r := &Registry{}
+	mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:ff")
+	id := r.Observe(mac, [4]byte{1, 2, 3, 4}, true)
+	r.BindIP(mac, net.ParseIP("192.168.1.10"))
+
+	var got string
+	h := r.Middleware(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+		got = FromContext(req.Context())
+	}))
+
+	tests := []struct {
+		name   string
+		path   string
+		remote string
+		want   string
+	}{
+		{name: "mac segment", path: "/iso/aa:bb:cc:dd:ee:ff/boot.iso", remote: "10.0.0.1:1234", want: id},
+		{name: "remote address", path: "/ipxe.efi", remote: "192.168.1.10:1234", want: id},
+		{name: "unknown client", path: "/ipxe.efi", remote: "10.0.0.1:1234"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = ""
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.RemoteAddr = tt.remote
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if got != tt.want {
+				t.Errorf("context ID = %q, want %q", got, tt.want)
+			}
+			if header := rec.Header().Get("X-Boot-ID"); header != tt.want {
+				t.Errorf("X-Boot-ID = %q, want %q", header, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,310 @@
+// Package canary rolls boot changes out to part of a fleet first. A rollout
+// boots a percentage of a host group from canary images, such as a new kernel
+// or IPA build, in place of the stable images the iPXE scripts refer to, until
+// it is promoted or rolled back.
+//
+// Hosts are picked by hashing the rollout name with their MAC address, so the
+// same hosts stay in the canary across restarts, and raising the percentage
+// only adds hosts to it.
+package canary
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned for unknown rollouts.
+	ErrNotFound = errors.New("rollout not found")
+	// ErrInvalid is returned for rollouts that fail validation.
+	ErrInvalid = errors.New("invalid rollout")
+
+	nameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,62}$`)
+)
+
+// Rollout boots the canary images of Images to Percent percent of its host
+// group.
+type Rollout struct {
+	Name string `json:"name"`
+	// Hosts are the MAC addresses of the hosts of the group and Match the
+	// metadata values a host must have to be in it. Without either the group
+	// is every host.
+	Hosts []string          `json:"hosts,omitempty"`
+	Match map[string]string `json:"match,omitempty"`
+	// Percent is the share of the group that boots the canary images, from 0
+	// to 100.
+	Percent int `json:"percent"`
+	// Images maps the names of stable catalog images to the canary images
+	// that replace them.
+	Images    map[string]string `json:"images"`
+	CreatedAt time.Time         `json:"createdAt"`
+	UpdatedAt time.Time         `json:"updatedAt"`
+}
+
+// Validate reports whether the rollout is complete and well formed, and
+// normalizes its host addresses.
+func (r *Rollout) Validate() error {
+	if !nameRe.MatchString(r.Name) {
+		return fmt.Errorf("%w: name %q", ErrInvalid, r.Name)
+	}
+	if r.Percent < 0 || r.Percent > 100 {
+		return fmt.Errorf("%w: percent %d is not between 0 and 100", ErrInvalid, r.Percent)
+	}
+	if len(r.Images) == 0 {
+		return fmt.Errorf("%w: no images", ErrInvalid)
+	}
+	for stable, canary := range r.Images {
+		if !nameRe.MatchString(stable) || !nameRe.MatchString(canary) || stable == canary {
+			return fmt.Errorf("%w: image %q replaced by %q", ErrInvalid, stable, canary)
+		}
+	}
+	for i, h := range r.Hosts {
+		mac, err := net.ParseMAC(h)
+		if err != nil {
+			return fmt.Errorf("%w: host %q", ErrInvalid, h)
+		}
+		r.Hosts[i] = mac.String()
+	}
+
+	return nil
+}
+
+// Includes reports whether the host with mac and metadata is in the group of
+// the rollout, whether or not it is in the canary.
+func (r Rollout) Includes(mac net.HardwareAddr, metadata map[string]string) bool {
+	if len(r.Hosts) > 0 && !slices.Contains(r.Hosts, mac.String()) {
+		return false
+	}
+	for k, v := range r.Match {
+		if metadata[k] != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Selects reports whether the host with mac and metadata boots the canary
+// images of the rollout.
+func (r Rollout) Selects(mac net.HardwareAddr, metadata map[string]string) bool {
+	return r.Includes(mac, metadata) && bucket(r.Name, mac) < r.Percent
+}
+
+// bucket places mac in one of 100 buckets of the rollout called name.
+func bucket(name string, mac net.HardwareAddr) int {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write(mac)
+
+	return int(h.Sum32() % 100)
+}
+
+// Store holds the rollouts and persists them to a JSON file.
+type Store struct {
+	path string
+
+	mu       sync.RWMutex
+	rollouts map[string]*Rollout
+}
+
+// New opens the rollouts persisted at path. An empty path keeps them in
+// memory only.
+func New(path string) (*Store, error) {
+	s := &Store{
+		path:     path,
+		rollouts: make(map[string]*Rollout),
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	b, err := os.ReadFile(filepath.Clean(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rollouts: %w", err)
+	}
+
+	var rollouts []*Rollout
+	if err := json.Unmarshal(b, &rollouts); err != nil {
+		return nil, fmt.Errorf("failed to parse rollouts: %w", err)
+	}
+	for _, r := range rollouts {
+		s.rollouts[r.Name] = r
+	}
+
+	return s, nil
+}
+
+// List returns every rollout sorted by name. A nil Store is empty.
+func (s *Store) List() []Rollout {
+	if s == nil {
+		return []Rollout{}
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make([]Rollout, 0, len(s.rollouts))
+	for _, r := range s.rollouts {
+		out = append(out, clone(r))
+	}
+	slices.SortFunc(out, func(a, b Rollout) int { return strings.Compare(a.Name, b.Name) })
+
+	return out
+}
+
+// Get returns the rollout called name.
+func (s *Store) Get(name string) (Rollout, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, ok := s.rollouts[name]
+	if !ok {
+		return Rollout{}, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+
+	return clone(r), nil
+}
+
+// Put creates or replaces the rollout and reports whether it was created.
+func (s *Store) Put(r Rollout) (Rollout, bool, error) {
+	r = clone(&r)
+	if err := r.Validate(); err != nil {
+		return Rollout{}, false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	r.CreatedAt, r.UpdatedAt = now, now
+	old, exists := s.rollouts[r.Name]
+	if exists {
+		r.CreatedAt = old.CreatedAt
+	}
+	s.rollouts[r.Name] = &r
+	if err := s.save(); err != nil {
+		if exists {
+			s.rollouts[r.Name] = old
+		} else {
+			delete(s.rollouts, r.Name)
+		}
+		return Rollout{}, false, err
+	}
+
+	return clone(&r), !exists, nil
+}
+
+// SetPercent changes the share of the group of the rollout called name that
+// boots the canary images.
+func (s *Store) SetPercent(name string, percent int) (Rollout, error) {
+	r, err := s.Get(name)
+	if err != nil {
+		return Rollout{}, err
+	}
+	r.Percent = percent
+	r, _, err = s.Put(r)
+
+	return r, err
+}
+
+// Delete removes the rollout called name.
+func (s *Store) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.rollouts[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	delete(s.rollouts, name)
+	if err := s.save(); err != nil {
+		s.rollouts[name] = old
+		return err
+	}
+
+	return nil
+}
+
+// Images returns the canary images of the host with mac and metadata, keyed
+// by the stable image they replace, and the names of the rollouts they come
+// from. When rollouts replace the same image the first by name wins. A nil
+// Store replaces nothing.
+func (s *Store) Images(
+	mac net.HardwareAddr,
+	metadata map[string]string,
+) (map[string]string, []string) {
+	images := make(map[string]string)
+	var names []string
+	for _, r := range s.List() {
+		if !r.Selects(mac, metadata) {
+			continue
+		}
+		added := false
+		for stable, canary := range r.Images {
+			if _, ok := images[stable]; !ok {
+				images[stable] = canary
+				added = true
+			}
+		}
+		if added {
+			names = append(names, r.Name)
+		}
+	}
+
+	return images, names
+}
+
+// save writes the rollouts atomically. Callers must hold s.mu.
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	rollouts := make([]*Rollout, 0, len(s.rollouts))
+	for _, r := range s.rollouts {
+		rollouts = append(rollouts, r)
+	}
+	slices.SortFunc(rollouts, func(a, b *Rollout) int { return strings.Compare(a.Name, b.Name) })
+
+	b, err := json.MarshalIndent(rollouts, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal rollouts: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create rollouts directory: %w", err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return fmt.Errorf("failed to write rollouts: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to replace rollouts: %w", err)
+	}
+
+	return nil
+}
+
+// clone returns a copy of r that shares no slices or maps with it.
+func clone(r *Rollout) Rollout {
+	c := *r
+	c.Hosts = slices.Clone(r.Hosts)
+	c.Match = maps.Clone(r.Match)
+	c.Images = maps.Clone(r.Images)
+
+	return c
+}
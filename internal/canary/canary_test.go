@@ -0,0 +1,121 @@
+package canary
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "rollouts.json")
+	s, err := New(path)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	invalid := []Rollout{
+		{Name: "Bad Name", Images: map[string]string{"ipa-kernel": "ipa-kernel-2"}},
+		{Name: "ipa", Percent: 101, Images: map[string]string{"ipa-kernel": "ipa-kernel-2"}},
+		{Name: "ipa"},
+		{Name: "ipa", Images: map[string]string{"ipa-kernel": "ipa-kernel"}},
+		{Name: "ipa", Hosts: []string{"nope"}, Images: map[string]string{"a": "b"}},
+	}
+	for _, r := range invalid {
+		if _, _, err := s.Put(r); !errors.Is(err, ErrInvalid) {
+			t.Errorf("Put(%+v) error = %v, want ErrInvalid", r, err)
+		}
+	}
+
+	r := Rollout{
+		Name:    "ipa",
+		Hosts:   []string{"D8-3A-DD-01-02-03"},
+		Percent: 100,
+		Images:  map[string]string{"ipa-kernel": "ipa-kernel-2"},
+	}
+	got, created, err := s.Put(r)
+	if err != nil || !created || got.Hosts[0] != "d8:3a:dd:01:02:03" {
+		t.Fatalf("Put() = %+v, %v, %v", got, created, err)
+	}
+	if _, err := s.SetPercent("ipa", 0); err != nil {
+		t.Fatalf("SetPercent() error = %v", err)
+	}
+	if _, err := s.SetPercent("nope", 50); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SetPercent() of an unknown rollout error = %v, want ErrNotFound", err)
+	}
+
+	reopened, err := New(path)
+	if err != nil {
+		t.Fatalf("New() of the saved rollouts error = %v", err)
+	}
+	if got, err := reopened.Get("ipa"); err != nil || got.Percent != 0 {
+		t.Errorf("Get() after reopening = %+v, %v", got, err)
+	}
+	if err := reopened.Delete("ipa"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if err := reopened.Delete("ipa"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second Delete() error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestImages(t *testing.T) {
+	s, err := New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []Rollout{
+		{
+			Name:    "a-kernel",
+			Match:   map[string]string{"rack": "r1"},
+			Percent: 100,
+			Images:  map[string]string{"kernel": "kernel-2"},
+		},
+		{
+			Name:    "b-ipa",
+			Percent: 100,
+			Images:  map[string]string{"kernel": "kernel-3", "initrd": "initrd-3"},
+		},
+	} {
+		if _, _, err := s.Put(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mac, _ := net.ParseMAC("d8:3a:dd:01:02:03")
+
+	images, names := s.Images(mac, map[string]string{"rack": "r1"})
+	if images["kernel"] != "kernel-2" || images["initrd"] != "initrd-3" || len(names) != 2 {
+		t.Errorf("Images() in rack r1 = %v, %v", images, names)
+	}
+	images, names = s.Images(mac, nil)
+	if images["kernel"] != "kernel-3" || len(names) != 1 || names[0] != "b-ipa" {
+		t.Errorf("Images() outside rack r1 = %v, %v", images, names)
+	}
+
+	var nilStore *Store
+	if images, names := nilStore.Images(mac, nil); len(images) != 0 || names != nil {
+		t.Errorf("nil Images() = %v, %v", images, names)
+	}
+}
+
+func TestSelects(t *testing.T) {
+	r := Rollout{Name: "ipa", Percent: 25}
+	selected := 0
+	for i := range 1000 {
+		mac, _ := net.ParseMAC(fmt.Sprintf("d8:3a:dd:00:%02x:%02x", i/256, i%256))
+		in := r.Selects(mac, nil)
+		if in {
+			selected++
+		}
+		// Raising the percentage keeps the hosts already in the canary.
+		r.Percent = 50
+		if in && !r.Selects(mac, nil) {
+			t.Fatalf("%s left the canary when the percentage was raised", mac)
+		}
+		r.Percent = 25
+	}
+	if selected < 200 || selected > 300 {
+		t.Errorf("Selects() picked %d of 1000 hosts at 25%%", selected)
+	}
+}
@@ -6,14 +6,20 @@ import (
 	"log"
 	"log/slog"
 	"net"
+	"net/netip"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/go-logr/logr"
+	"github.com/metal3-community/metal-boot/internal/filewatch"
+	"github.com/metal3-community/metal-boot/internal/ipxe/binary"
+	"github.com/metal3-community/metal-boot/internal/logging"
+	"github.com/metal3-community/metal-boot/internal/redact"
 	"github.com/spf13/viper"
 )
 
@@ -33,6 +39,18 @@ type RpcConfig struct {
 	Port    int          `mapstructure:"port"`
 }
 
+// IronicCacheConfig configures the cache of the Ironic API proxy. Identical
+// concurrent GET requests of Paths are always coalesced into one request to
+// Ironic.
+type IronicCacheConfig struct {
+	// TTLSec is how long responses of Paths are served from the cache. 0
+	// disables the cache.
+	TTLSec int `mapstructure:"ttl_sec"`
+	// Paths are the cached and coalesced endpoints. Empty caches the driver
+	// and node lists.
+	Paths []string `mapstructure:"paths"`
+}
+
 type IronicConfig struct {
 	Url                string       `mapstructure:"url"`
 	PublicEndpoint     string       `mapstructure:"public_endpoint"`
@@ -45,6 +63,8 @@ type IronicConfig struct {
 	DatabaseConnection string       `mapstructure:"database_connection"`
 	ConfigPath         string       `mapstructure:"config_path"`
 	SkipDBSync         bool         `mapstructure:"skip_db_sync"`
+	// Cache configures the cache of the proxied Ironic API.
+	Cache IronicCacheConfig `mapstructure:"cache"`
 }
 
 type TalosConfig struct {
@@ -53,6 +73,9 @@ type TalosConfig struct {
 	CacheDirectory    string   `mapstructure:"cache_directory"`
 	MaxCacheSize      int64    `mapstructure:"max_cache_size"`
 	DefaultExtensions []string `mapstructure:"default_extensions"`
+	// Image names the image catalog entry of kind talos whose URL replaces
+	// BaseURL.
+	Image string `mapstructure:"image"`
 }
 
 type UnifiConfig struct {
@@ -66,11 +89,64 @@ type UnifiConfig struct {
 }
 
 type TftpConfig struct {
-	Enabled       bool   `mapstructure:"enabled"`
+	Enabled bool `mapstructure:"enabled"`
+	// Address defaults to the HTTP address.
 	Address       string `mapstructure:"address"`
 	Port          int    `mapstructure:"port"`
 	RootDirectory string `mapstructure:"root_directory"`
-	IpxePatch     string `mapstructure:"ipxe_patch"`
+	// IpxePatch is the default script slot of the patches of the iPXE
+	// binaries served over TFTP and HTTP.
+	IpxePatch string `mapstructure:"ipxe_patch"`
+	// IpxePatches set patch slots of the iPXE binaries. Entries without MACs
+	// apply to every host; the slots of later entries win.
+	IpxePatches []IpxePatchConfig `mapstructure:"ipxe_patches"`
+	// Writes controls the files nodes may write over TFTP.
+	Writes TftpWriteConfig `mapstructure:"writes"`
+}
+
+// IpxePatchConfig sets the named slots of the patch area of the embedded
+// script of the iPXE binaries. Empty slots are left as they are.
+type IpxePatchConfig struct {
+	// MACs are glob patterns of the MAC addresses of the hosts the entry
+	// applies to, such as "d8:3a:dd:*".
+	MACs   []string `mapstructure:"macs"`
+	Trust  string   `mapstructure:"trust"`
+	Crypto string   `mapstructure:"crypto"`
+	Script string   `mapstructure:"script"`
+}
+
+// IpxePatcher returns the patcher of the iPXE binaries of c.
+func (c TftpConfig) IpxePatcher() (*binary.Patcher, error) {
+	p := &binary.Patcher{Default: binary.Patches{Script: c.IpxePatch}}
+	for _, e := range c.IpxePatches {
+		patches := binary.Patches{Trust: e.Trust, Crypto: e.Crypto, Script: e.Script}
+		if len(e.MACs) == 0 {
+			p.Default = p.Default.Override(patches)
+			continue
+		}
+		p.Hosts = append(p.Hosts, binary.HostPatches{MACs: e.MACs, Patches: patches})
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// TftpWriteConfig controls TFTP write requests, which let nodes push
+// inspection data, EDK2 NVRAM dumps or crash logs during early boot.
+type TftpWriteConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Directory defaults to the TFTP root directory.
+	Directory string `mapstructure:"directory"`
+	// AllowedPaths are glob patterns of the paths, relative to the node's
+	// directory, that may be written. An empty list allows any path.
+	AllowedPaths []string `mapstructure:"allowed_paths"`
+	// MaxSizeBytes is the largest file that may be written; 0 is unlimited.
+	MaxSizeBytes int64 `mapstructure:"max_size_bytes"`
+	// PerMACDirectory stores each node's files in a directory named after its
+	// MAC address and refuses writes from unknown nodes.
+	PerMACDirectory bool `mapstructure:"per_mac_directory"`
 }
 
 type IpxeUrl struct {
@@ -80,6 +156,8 @@ type IpxeUrl struct {
 	Path    string `mapstructure:"path"`
 }
 
+// GetUrl returns the URL, with paths joined in place of Path if given. An IPv6
+// Address is bracketed in the host.
 func (u IpxeUrl) GetUrl(paths ...string) *url.URL {
 	path := u.Path
 	if len(paths) > 0 {
@@ -101,12 +179,27 @@ func (u IpxeUrl) GetUrl(paths ...string) *url.URL {
 					}
 				}
 			}
-			return fmt.Sprintf("%s:%d", u.Address, u.Port)
+			host := strings.TrimSuffix(strings.TrimPrefix(u.Address, "["), "]")
+			return net.JoinHostPort(host, strconv.Itoa(u.Port))
 		}(),
 		Path: path,
 	}
 }
 
+// DHCPGuardConfig configures the detection of DHCP servers other than
+// metal-boot answering on the provisioning network.
+type DHCPGuardConfig struct {
+	// Enabled listens on the DHCP client port for the replies of other
+	// servers. The port is shared with any DHCP client of the host.
+	Enabled bool `mapstructure:"enabled"`
+	// AllowedServers are the addresses of the DHCP servers expected on the
+	// network, such as the one serving addresses next to metal-boot in proxy
+	// mode.
+	AllowedServers []string `mapstructure:"allowed_servers"`
+	// AlertIntervalSec is how often a server that keeps answering is reported.
+	AlertIntervalSec int `mapstructure:"alert_interval_sec"`
+}
+
 type DhcpConfig struct {
 	Enabled           bool    `mapstructure:"enabled"`
 	Interface         string  `mapstructure:"interface"`
@@ -121,8 +214,15 @@ type DhcpConfig struct {
 	TftpPort          int     `mapstructure:"tftp_port"`
 	SyslogIP          string  `mapstructure:"syslog_ip"`
 	StaticIPAMEnabled bool    `mapstructure:"static_ipam_enabled"`
+	ReservationsOnly  bool    `mapstructure:"reservations_only"`
 	LeaseFile         string  `mapstructure:"lease_file"`
 	ConfigFile        string  `mapstructure:"config_file"`
+	// BootFiles overrides the iPXE binary offered per client architecture,
+	// keyed by bios, x86_64_efi, arm32_efi, arm64_efi, rpi or an option 93
+	// architecture type.
+	BootFiles map[string]string `mapstructure:"boot_files"`
+	// Guard watches the provisioning network for other DHCP servers.
+	Guard DHCPGuardConfig `mapstructure:"guard"`
 }
 
 type IpxeHttpScript struct {
@@ -135,17 +235,82 @@ type IpxeHttpScript struct {
 	ExtraKernelArgs    []string `mapstructure:"extra_kernel_args"`
 	StaticIPXEEnabled  bool     `mapstructure:"static_ipxe_enabled"`
 	StaticFilesEnabled bool     `mapstructure:"static_files_enabled"`
+	// RetryBackoff doubles the retry delay after every retry.
+	RetryBackoff bool `mapstructure:"retry_backoff"`
+	// Fallback is what a script does once its retries are used up.
+	Fallback  ScriptFallback `mapstructure:"fallback"`
+	RescueURL string         `mapstructure:"rescue_url"`
+	// Profiles replaces the retry policy above for the scripts served under a
+	// profile: "config", "cleaning", "inspector", "template" or "fallback".
+	Profiles map[string]ScriptRetryPolicy `mapstructure:"profiles"`
+	// TemplateDir holds text/template iPXE scripts named <name>.tmpl, reloaded
+	// when they change. A node is served the template named after its MAC
+	// address ("d8-3a-dd-01-02-03") or host name before its pxelinux.cfg
+	// entry, and the "default" template after it. Empty disables templates.
+	TemplateDir string `mapstructure:"template_dir"`
+}
+
+// ScriptFallback is the final step of an iPXE script whose downloads or boot
+// kept failing.
+type ScriptFallback string
+
+const (
+	// ScriptFallbackLocal exits iPXE so the firmware boots the next device.
+	ScriptFallbackLocal ScriptFallback = "local"
+	// ScriptFallbackRescue chains the rescue script at RescueURL.
+	ScriptFallbackRescue ScriptFallback = "rescue"
+	// ScriptFallbackShell drops to the iPXE shell.
+	ScriptFallbackShell ScriptFallback = "shell"
+	// ScriptFallbackReboot reboots the node.
+	ScriptFallbackReboot ScriptFallback = "reboot"
+)
+
+// ScriptRetryPolicy controls how a served iPXE script retries failed
+// downloads and boots.
+type ScriptRetryPolicy struct {
+	// Retries is the number of times the script is restarted; 0 disables it.
+	Retries int `mapstructure:"retries"`
+	// RetryDelay is the delay before the first retry, in seconds.
+	RetryDelay   int            `mapstructure:"retry_delay"`
+	RetryBackoff bool           `mapstructure:"retry_backoff"`
+	Fallback     ScriptFallback `mapstructure:"fallback"`
+	RescueURL    string         `mapstructure:"rescue_url"`
+}
+
+// RetryPolicy returns the retry policy of the scripts served under profile.
+func (s IpxeHttpScript) RetryPolicy(profile string) ScriptRetryPolicy {
+	if p, ok := s.Profiles[profile]; ok {
+		return p
+	}
+
+	return ScriptRetryPolicy{
+		Retries:      s.Retries,
+		RetryDelay:   s.RetryDelay,
+		RetryBackoff: s.RetryBackoff,
+		Fallback:     s.Fallback,
+		RescueURL:    s.RescueURL,
+	}
 }
 
 type IsoConfig struct {
 	Enabled     bool   `mapstructure:"enabled"`
 	Url         string `mapstructure:"url"`
 	MagicString string `mapstructure:"magic_string"`
+	// Image names the image catalog entry of kind iso that replaces Url.
+	Image string `mapstructure:"image"`
 }
 
+// OtelConfig configures the export of OpenTelemetry traces.
 type OtelConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Endpoint is the host:port of the OTLP gRPC collector.
 	Endpoint string `mapstructure:"endpoint"`
-	Insecure bool   `mapstructure:"insecure"`
+	// Insecure connects to Endpoint without TLS.
+	Insecure bool `mapstructure:"insecure"`
+	// SampleRatio is the share of the traces started here that are
+	// recorded, from 0 to 1. Traces continued from a caller follow its
+	// sampling decision.
+	SampleRatio float64 `mapstructure:"sample_ratio"`
 }
 
 type ImageURL struct {
@@ -170,28 +335,652 @@ type DnsmasqConfig struct {
 	DefaultSubnet     string   `mapstructure:"default_subnet"`
 	DefaultDNS        []string `mapstructure:"default_dns"`
 	DefaultDomain     string   `mapstructure:"default_domain"`
+	// Pools are further ranges assigned automatically to the hosts of other
+	// networks, selected by the relay agent their packets come through.
+	Pools []DnsmasqPoolConfig `mapstructure:"pools"`
+	// Sharding spreads host and options files over subdirectories: "" for
+	// none, "oui" or "hash".
+	Sharding string `mapstructure:"sharding"`
+	// PIDFile is the pid file of a dnsmasq reading the files, which is sent
+	// SIGHUP after every host change. Empty relies on dnsmasq watching
+	// dhcp-hostsdir and dhcp-optsdir with inotify.
+	PIDFile string `mapstructure:"pid_file"`
+}
+
+// DnsmasqPoolConfig is a range of addresses assigned automatically to the
+// hosts of one provisioning network. Relayed packets are served from the
+// first pool whose circuit_ids or remote_ids match the option 82
+// sub-options of the relay agent, else from the pool whose subnet holds the
+// link selection address or giaddr. Other packets use ip_pool_start and
+// ip_pool_end.
+type DnsmasqPoolConfig struct {
+	Name string `mapstructure:"name"`
+	// Subnet is the network of the pool in CIDR notation.
+	Subnet string `mapstructure:"subnet"`
+	Start  string `mapstructure:"start"`
+	End    string `mapstructure:"end"`
+	// Gateway, DNS, Domain and LeaseTime default to those of the dnsmasq
+	// section.
+	Gateway   string   `mapstructure:"gateway"`
+	DNS       []string `mapstructure:"dns"`
+	Domain    string   `mapstructure:"domain"`
+	LeaseTime uint32   `mapstructure:"lease_time"`
+	// CircuitIDs and RemoteIDs are glob patterns of the agent circuit ID and
+	// remote ID sub-options of option 82.
+	CircuitIDs []string `mapstructure:"circuit_ids"`
+	RemoteIDs  []string `mapstructure:"remote_ids"`
+}
+
+type CleaningConfig struct {
+	Enabled    bool     `mapstructure:"enabled"`
+	KernelURL  string   `mapstructure:"kernel_url"`
+	InitrdURL  string   `mapstructure:"initrd_url"`
+	KernelArgs []string `mapstructure:"kernel_args"`
+	WipeMethod string   `mapstructure:"wipe_method"`
+	// TokenSecret signs the per-node tokens of cleaning callback URLs. Empty
+	// uses the secret in TokenSecretFile, generated when missing, so that
+	// callbacks of wipes started before a restart are still accepted.
+	TokenSecret     string `mapstructure:"token_secret"`
+	TokenSecretFile string `mapstructure:"token_secret_file"`
+	TokenTTLSec     int    `mapstructure:"token_ttl_sec"`
+}
+
+type BootAttemptsConfig struct {
+	Enabled        bool   `mapstructure:"enabled"`
+	MaxAttempts    int    `mapstructure:"max_attempts"`
+	WindowSec      int    `mapstructure:"window_sec"`
+	Fallback       string `mapstructure:"fallback"`
+	FallbackScript string `mapstructure:"fallback_script"`
+}
+
+// BootSLOConfig configures the metrics following netboot attempts from
+// DHCPDISCOVER to the iPXE script fetch and the phone-home callback.
+type BootSLOConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// TimeoutSec is how long an attempt may take before it counts as failed.
+	TimeoutSec int `mapstructure:"timeout_sec"`
+	// RatioWindowSec is the time over which boot_success_ratio is computed.
+	RatioWindowSec int `mapstructure:"ratio_window_sec"`
+}
+
+type BootAuthConfig struct {
+	Enabled        bool   `mapstructure:"enabled"`
+	VerifySourceIP bool   `mapstructure:"verify_source_ip"`
+	TokenSecret    string `mapstructure:"token_secret"`
+	TokenTTLSec    int    `mapstructure:"token_ttl_sec"`
+}
+
+type TLSConfig struct {
+	Enabled  bool   `mapstructure:"enabled"`
+	CertFile string `mapstructure:"cert_file"`
+	KeyFile  string `mapstructure:"key_file"`
+}
+
+type SelfUpdateConfig struct {
+	Enabled    bool   `mapstructure:"enabled"`
+	StagingDir string `mapstructure:"staging_dir"`
+	// PublicKeyFile is the PEM Ed25519 public key of the publisher of
+	// metal-boot builds. Updates not signed by it are refused.
+	PublicKeyFile string `mapstructure:"public_key_file"`
+}
+
+type ImageGCConfig struct {
+	Enabled     bool     `mapstructure:"enabled"`
+	BudgetMB    int64    `mapstructure:"budget_mb"`
+	IntervalSec int      `mapstructure:"interval_sec"`
+	Directories []string `mapstructure:"directories"`
+}
+
+type StreamLimitConfig struct {
+	Enabled         bool `mapstructure:"enabled"`
+	MaxStreams      int  `mapstructure:"max_streams"`
+	PerClient       int  `mapstructure:"per_client"`
+	MaxQueue        int  `mapstructure:"max_queue"`
+	QueueTimeoutSec int  `mapstructure:"queue_timeout_sec"`
+}
+
+type BandwidthConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// GlobalMbps caps all TFTP transfers and large HTTP downloads together,
+	// in megabits per second. Zero means unlimited.
+	GlobalMbps int `mapstructure:"global_mbps"`
+	// PerClientMbps caps the transfers of one client IP. Zero means unlimited.
+	PerClientMbps int `mapstructure:"per_client_mbps"`
+}
+
+// ArtifactsConfig configures the artifacts derived on demand from the files
+// of the static root, served at /artifacts/<mac>/<name>.
+type ArtifactsConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// CacheDir holds the built artifacts. Empty is <state_path>/artifacts.
+	CacheDir string `mapstructure:"cache_dir"`
+	// Profiles maps boot profiles, such as "config", "inspector" or
+	// "cleaning", to their pipelines. Those of "default" serve the hosts
+	// whose profile has none.
+	Profiles map[string][]ArtifactPipelineConfig `mapstructure:"profiles"`
+}
+
+// ArtifactPipelineConfig derives one artifact from files of the static root.
+type ArtifactPipelineConfig struct {
+	Name string `mapstructure:"name"`
+	// Sources are paths below the static root, in order.
+	Sources []string             `mapstructure:"sources"`
+	Steps   []ArtifactStepConfig `mapstructure:"steps"`
+}
+
+// ArtifactStepConfig is one transformation of a pipeline.
+type ArtifactStepConfig struct {
+	// Type is "decompress", "compress", "concat", "overlay" or "sign".
+	Type string `mapstructure:"type"`
+	// Files are the files of an overlay.
+	Files []ArtifactFileConfig `mapstructure:"files"`
+}
+
+// ArtifactFileConfig is a file of an overlay, rendered for each host.
+type ArtifactFileConfig struct {
+	Path     string `mapstructure:"path"`
+	Template string `mapstructure:"template"`
+	// Mode is the octal permission bits of the file, "0644" if empty.
+	Mode string `mapstructure:"mode"`
+}
+
+// AuditConfig configures the audit log of the mutating requests to the
+// Redfish and admin APIs.
+type AuditConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Path is the JSON lines file of the log. Empty is
+	// <state_path>/audit.jsonl.
+	Path       string `mapstructure:"path"`
+	MaxSizeMB  int    `mapstructure:"max_size_mb"`
+	MaxBackups int    `mapstructure:"max_backups"`
+	// Retain is how many of the latest entries /api/v1/audit serves.
+	Retain int `mapstructure:"retain"`
+}
+
+// LeasesConfig configures the lease database of the reservation handler,
+// which tracks every lease from offer to expiry and flags addresses in
+// conflict.
+type LeasesConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Path is the state file of the database. Empty is
+	// <state_path>/leases.json.
+	Path string `mapstructure:"path"`
+	// OfferTimeoutSec is how long an offered address is held for a client
+	// that has not requested it yet.
+	OfferTimeoutSec int `mapstructure:"offer_timeout_sec"`
+	// RetainHours is how long released, declined and expired leases are
+	// kept.
+	RetainHours int `mapstructure:"retain_hours"`
+	// SweepIntervalSec is the time between expiry and conflict sweeps.
+	SweepIntervalSec int `mapstructure:"sweep_interval_sec"`
+}
+
+// DecommissionConfig configures the decommission of hosts through
+// POST /api/v1/machines/{mac}/decommission.
+type DecommissionConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// ArchiveDirectory receives an archive of the firmware varstore and logs
+	// of every decommissioned host. Empty is <state_path>/decommissioned.
+	ArchiveDirectory string `mapstructure:"archive_directory"`
+	// RemoveFromIronic deletes the Ironic node of the host.
+	RemoveFromIronic bool `mapstructure:"remove_from_ironic"`
+	// TimeoutSec bounds a decommission.
+	TimeoutSec int `mapstructure:"timeout_sec"`
+}
+
+// StandbyConfig configures the import of the state of another instance at
+// startup, so that a warm standby takes over after a failover without a cold
+// resync. The active instance serves its state at GET /api/v1/snapshot.
+type StandbyConfig struct {
+	// ImportFrom is the /api/v1/snapshot URL of the active instance or the
+	// path of a snapshot file. Empty disables the import.
+	ImportFrom string `mapstructure:"import_from"`
+	// Token is sent as a bearer token when ImportFrom is a URL.
+	Token string `mapstructure:"token"`
+	// TimeoutSec bounds the import. Startup continues from the state files
+	// when it fails.
+	TimeoutSec int `mapstructure:"timeout_sec"`
+}
+
+// UIConfig configures the operator dashboard served under /ui/.
+type UIConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+}
+
+// CORSConfig lets browser-based clients served from other origins call the
+// HTTP APIs.
+type CORSConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// AllowedOrigins are scheme://host[:port] origins; "*" allows any and
+	// https://*.example.com any subdomain.
+	AllowedOrigins   []string `mapstructure:"allowed_origins"`
+	AllowedMethods   []string `mapstructure:"allowed_methods"`
+	AllowedHeaders   []string `mapstructure:"allowed_headers"`
+	ExposedHeaders   []string `mapstructure:"exposed_headers"`
+	AllowCredentials bool     `mapstructure:"allow_credentials"`
+	MaxAgeSec        int      `mapstructure:"max_age_sec"`
+}
+
+// DHCPv6Config serves IA_NA reservations and option 59 boot file URLs to IPv6
+// clients. It runs in proxy or reservation mode like the DHCPv4 server, as set
+// by dhcp.proxy_enabled.
+type DHCPv6Config struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Interface defaults to dhcp.interface.
+	Interface string `mapstructure:"interface"`
+	Address   string `mapstructure:"address"`
+	Port      int    `mapstructure:"port"`
+}
+
+// ListenerConfig is an address and port a service listens on.
+type ListenerConfig struct {
+	Address string `mapstructure:"address"`
+	Port    int    `mapstructure:"port"`
+}
+
+// String returns the listener as host:port.
+func (l ListenerConfig) String() string {
+	return net.JoinHostPort(l.Address, strconv.Itoa(l.Port))
+}
+
+// ListenersConfig adds listeners to the services besides their own address
+// and port, e.g. to serve Redfish on a management VLAN only while the boot
+// services stay on the provisioning VLAN.
+type ListenersConfig struct {
+	// HTTP listeners serve the same routes as address and port.
+	HTTP []ListenerConfig `mapstructure:"http"`
+	// Redfish listeners serve only the Redfish API, which address, port and
+	// the HTTP listeners then no longer serve.
+	Redfish []ListenerConfig `mapstructure:"redfish"`
+	TFTP    []ListenerConfig `mapstructure:"tftp"`
+}
+
+type IntegrityConfig struct {
+	Enabled     bool   `mapstructure:"enabled"`
+	SigningCert string `mapstructure:"signing_cert"`
+	SigningKey  string `mapstructure:"signing_key"`
+	VerifyIPXE  bool   `mapstructure:"verify_ipxe"`
+}
+
+type GPUFirmwareConfig struct {
+	Enabled        bool   `mapstructure:"enabled"`
+	Directory      string `mapstructure:"directory"`
+	DefaultVersion string `mapstructure:"default_version"`
+}
+
+type AdminAuthConfig struct {
+	Enabled bool       `mapstructure:"enabled"`
+	OIDC    OIDCConfig `mapstructure:"oidc"`
+}
+
+type OIDCConfig struct {
+	Issuer       string   `mapstructure:"issuer"`
+	ClientID     string   `mapstructure:"client_id"`
+	ClientSecret string   `mapstructure:"client_secret"`
+	RedirectURL  string   `mapstructure:"redirect_url"`
+	Scopes       []string `mapstructure:"scopes"`
+	GroupsClaim  string   `mapstructure:"groups_claim"`
+	AdminGroups  []string `mapstructure:"admin_groups"`
+	ViewerGroups []string `mapstructure:"viewer_groups"`
+	DefaultRole  string   `mapstructure:"default_role"`
+}
+
+type FaultInjectionConfig struct {
+	Enabled bool        `mapstructure:"enabled"`
+	Backend FaultConfig `mapstructure:"backend"`
+	Tftp    FaultConfig `mapstructure:"tftp"`
+	Power   FaultConfig `mapstructure:"power"`
+}
+
+type FaultConfig struct {
+	ErrorPercent float64 `mapstructure:"error_percent"`
+	DelayPercent float64 `mapstructure:"delay_percent"`
+	DelayMS      int     `mapstructure:"delay_ms"`
+}
+
+type PreflightConfig struct {
+	Enabled    bool `mapstructure:"enabled"`
+	TimeoutSec int  `mapstructure:"timeout_sec"`
+}
+
+type HostMetricsConfig struct {
+	Enabled    bool `mapstructure:"enabled"`
+	Power      bool `mapstructure:"power"`
+	TimeoutSec int  `mapstructure:"timeout_sec"`
+}
+
+type TelemetryConfig struct {
+	Enabled     bool `mapstructure:"enabled"`
+	IntervalSec int  `mapstructure:"interval_sec"`
+}
+
+type IPv6Config struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Addresses maps an interface name to the global IPv6 address that boot
+	// URLs for IPv6 clients on it use. Interfaces without an entry use their
+	// first global unicast address.
+	Addresses map[string]string `mapstructure:"addresses"`
+}
+
+type PhoneHomeConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// TokenSecret signs the per-host tokens of phone-home URLs.
+	TokenSecret string `mapstructure:"token_secret"`
+	TokenTTLSec int    `mapstructure:"token_ttl_sec"`
+	// DisableNetboot stops offering netboot options to a host once it phoned home.
+	DisableNetboot bool `mapstructure:"disable_netboot"`
+}
+
+// BootFlowConfig follows hosts along the boot flow: unknown, discovering,
+// netboot-enabled, provisioning and provisioned.
+type BootFlowConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Mode is how phone_home.disable_netboot keeps a provisioned host from
+	// netbooting: "local_boot" withholds netboot options, "ignore" also
+	// switches its dnsmasq host entry to ignore.
+	Mode string `mapstructure:"mode"`
+}
+
+type FileWatchConfig struct {
+	// Poll watches files by polling their modification time and size, for
+	// NFS and SMB mounts where inotify never reports remote changes. Files
+	// that inotify refuses to watch are polled regardless.
+	Poll            bool `mapstructure:"poll"`
+	PollIntervalSec int  `mapstructure:"poll_interval_sec"`
+}
+
+// WatchOptions returns the filewatch options of c.
+func (c FileWatchConfig) WatchOptions() filewatch.Options {
+	return filewatch.Options{
+		Poll:     c.Poll,
+		Interval: time.Duration(c.PollIntervalSec) * time.Second,
+	}
+}
+
+type FirmwareUploadConfig struct {
+	// MaxSizeMB rejects multipart firmware uploads larger than this.
+	MaxSizeMB int64 `mapstructure:"max_size_mb"`
+	// TaskThresholdMB verifies and installs uploads larger than this in the
+	// background and answers with a Task instead of waiting.
+	TaskThresholdMB int64 `mapstructure:"task_threshold_mb"`
+}
+
+// QemuConfig replaces the power backend with QEMU aarch64 virtual machines,
+// one per system, for integration tests without hardware.
+type QemuConfig struct {
+	Enabled  bool   `mapstructure:"enabled"`
+	Binary   string `mapstructure:"binary"`
+	Machine  string `mapstructure:"machine"`
+	CPU      string `mapstructure:"cpu"`
+	KVM      bool   `mapstructure:"kvm"`
+	MemoryMB int    `mapstructure:"memory_mb"`
+	// Firmware is the UEFI code image, VarstoreTemplate the variable image
+	// copied below the TFTP root for systems that have none.
+	Firmware         string   `mapstructure:"firmware"`
+	VarstoreTemplate string   `mapstructure:"varstore_template"`
+	Bridge           string   `mapstructure:"bridge"`
+	StateDir         string   `mapstructure:"state_dir"`
+	ExtraArgs        []string `mapstructure:"extra_args"`
+}
+
+// OutboundProxyConfig is the proxy of requests to other hosts, such as image
+// and ISO downloads. Empty fields fall back to HTTP_PROXY, HTTPS_PROXY and
+// NO_PROXY.
+type OutboundProxyConfig struct {
+	URL     string `mapstructure:"url"`
+	NoProxy string `mapstructure:"no_proxy"`
+	// CABundle is a PEM file of certificates trusted besides the system roots.
+	CABundle string `mapstructure:"ca_bundle"`
+}
+
+// RedfishSessionsConfig configures the Redfish SessionService.
+type RedfishSessionsConfig struct {
+	// Persist keeps issued sessions in <state_path>/redfish-sessions.enc,
+	// encrypted with the key in KeyFile, so that they survive restarts. The
+	// key belongs outside the state path, which backups archive.
+	Persist bool   `mapstructure:"persist"`
+	KeyFile string `mapstructure:"key_file"`
+	// TimeoutSec is the idle time after which a session expires.
+	TimeoutSec int `mapstructure:"timeout_sec"`
+}
+
+// RedfishTasksConfig configures the Redfish TaskService.
+type RedfishTasksConfig struct {
+	// Persist keeps tasks in <state_path>/redfish-tasks.json, so that the
+	// outcome of firmware updates and power operations survives restarts.
+	Persist bool `mapstructure:"persist"`
+}
+
+// RedfishSchemasConfig selects the schema versions the Redfish service
+// advertises in @odata.type and under /redfish/v1/JsonSchemas.
+type RedfishSchemasConfig struct {
+	// Versions replace the built-in version of a resource for every client.
+	Versions []RedfishSchemaVersion `mapstructure:"versions"`
+	// Clients pin the clients whose User-Agent contains UserAgent to other
+	// versions, such as older Ironic releases that reject newer ones. The
+	// first matching client applies.
+	Clients []RedfishSchemaClient `mapstructure:"clients"`
+}
+
+// RedfishSchemaVersion advertises Version, such as v1_11_0, for the resource
+// schema Resource, such as ComputerSystem.
+type RedfishSchemaVersion struct {
+	Resource string `mapstructure:"resource"`
+	Version  string `mapstructure:"version"`
+}
+
+// RedfishSchemaClient selects the versions advertised to some clients.
+type RedfishSchemaClient struct {
+	UserAgent string                 `mapstructure:"user_agent"`
+	Versions  []RedfishSchemaVersion `mapstructure:"versions"`
+}
+
+// BootStormConfig staggers netboot offers when many nodes boot at once.
+type BootStormConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// OffersPerSec is the rate at which netboot offers are sent once Burst
+	// offers went out.
+	OffersPerSec float64 `mapstructure:"offers_per_sec"`
+	Burst        int     `mapstructure:"burst"`
+	// MaxWaitMs is the longest an offer is held back. DHCPDISCOVERs that
+	// would wait longer are left for the client to retransmit.
+	MaxWaitMs int `mapstructure:"max_wait_ms"`
+	// JitterMs delays every offer by a random time up to it.
+	JitterMs int `mapstructure:"jitter_ms"`
+}
+
+// PowerConfig selects and configures the power drivers. The backend data of
+// a machine can name its driver; the others use DefaultDriver.
+type PowerConfig struct {
+	// DefaultDriver is "unifi", "qemu", "tasmota", "redfish", "ipmi" or
+	// "gpio". It defaults to qemu when qemu is enabled and to unifi otherwise.
+	DefaultDriver string `mapstructure:"default_driver"`
+	// TimeoutSec bounds the requests of the tasmota, redfish and ipmi
+	// drivers.
+	TimeoutSec int                `mapstructure:"timeout_sec"`
+	Tasmota    PowerTasmotaConfig `mapstructure:"tasmota"`
+	Redfish    PowerRedfishConfig `mapstructure:"redfish"`
+	IPMI       PowerIPMIConfig    `mapstructure:"ipmi"`
+	GPIO       PowerGPIOConfig    `mapstructure:"gpio"`
+}
+
+// PowerTasmotaConfig configures the driver for Tasmota and compatible web
+// relays.
+type PowerTasmotaConfig struct {
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	// CycleDelayMs is how long a power cycle keeps the relay off.
+	CycleDelayMs int `mapstructure:"cycle_delay_ms"`
+}
+
+// PowerRedfishConfig configures the driver passing power requests through to
+// the Redfish service of machine BMCs.
+type PowerRedfishConfig struct {
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	// Insecure skips the verification of BMC certificates.
+	Insecure bool `mapstructure:"insecure"`
+}
+
+// PowerIPMIConfig configures the driver running ipmitool against machine
+// BMCs.
+type PowerIPMIConfig struct {
+	// Binary is the ipmitool binary.
+	Binary string `mapstructure:"binary"`
+	// Username and Password are used for the BMCs whose backend data has no
+	// credentials.
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	// Interface is the ipmitool interface, such as lanplus.
+	Interface string `mapstructure:"interface"`
+}
+
+// PowerGPIOConfig configures the driver switching relay HATs through the GPIO
+// lines of the metal-boot host.
+type PowerGPIOConfig struct {
+	// SysfsRoot is the sysfs GPIO directory.
+	SysfsRoot string `mapstructure:"sysfs_root"`
+	// Lines maps MAC addresses to GPIO lines, for machines whose backend
+	// data has no power address.
+	Lines map[string]int `mapstructure:"lines"`
+	// ActiveLow powers machines when their line is low.
+	ActiveLow bool `mapstructure:"active_low"`
+	// CycleDelayMs is how long a power cycle keeps the power off.
+	CycleDelayMs int `mapstructure:"cycle_delay_ms"`
+}
+
+// HookConfig is a command run on provisioning events.
+type HookConfig struct {
+	Name string `mapstructure:"name"`
+	// Events are the event types the command runs on, such as
+	// node-discovered or provisioning-complete. Empty runs it on every event.
+	Events  []string `mapstructure:"events"`
+	Command string   `mapstructure:"command"`
+	Args    []string `mapstructure:"args"`
+	// TimeoutSec is how long the command may run before it is killed.
+	TimeoutSec int `mapstructure:"timeout_sec"`
+}
+
+// LogConfig selects the format and destination of the log records of every
+// logger.
+type LogConfig struct {
+	// Format is "json" or "logfmt".
+	Format string `mapstructure:"format"`
+	// Output is "stdout", "stderr", "file", "syslog" or "journald".
+	Output string          `mapstructure:"output"`
+	File   LogFileConfig   `mapstructure:"file"`
+	Syslog LogSyslogConfig `mapstructure:"syslog"`
+	// Tag identifies metal-boot in syslog and the journal.
+	Tag string `mapstructure:"tag"`
+}
+
+// LogFileConfig configures the log file of the "file" output, which is
+// rotated when it reaches MaxSizeMB, keeping MaxBackups rotated files.
+type LogFileConfig struct {
+	Path       string `mapstructure:"path"`
+	MaxSizeMB  int    `mapstructure:"max_size_mb"`
+	MaxBackups int    `mapstructure:"max_backups"`
+}
+
+// LogSyslogConfig configures the "syslog" output. An empty address logs to
+// the local syslog daemon.
+type LogSyslogConfig struct {
+	Network  string `mapstructure:"network"`
+	Address  string `mapstructure:"address"`
+	Facility string `mapstructure:"facility"`
+}
+
+// HostIdentityConfig selects how hosts are told apart.
+type HostIdentityConfig struct {
+	// LocallyAdministered is how a host netbooting with a locally
+	// administered, possibly randomized, MAC address is matched to a host
+	// already recorded under another address: "mac" keeps every address a
+	// host of its own, "uuid" matches the machine UUID of DHCP option 97 and
+	// "duid" the client identifier of DHCP option 61.
+	LocallyAdministered string `mapstructure:"locally_administered"`
+	// Match are the identifiers, tried in order, by which a host netbooting
+	// with a MAC address of no record is matched to a recorded host, falling
+	// back to the MAC address: "circuit_id" the switch port of the DHCP relay
+	// agent (option 82), "client_id" the client identifier of DHCP option 61
+	// and "rpi_serial" a Raspberry Pi serial number in option 60 or 77.
+	Match []string `mapstructure:"match"`
 }
 
 type Config struct {
-	Address         string         `mapstructure:"address"`
-	Port            int            `mapstructure:"port"`
-	Unifi           UnifiConfig    `mapstructure:"unifi"`
-	Tftp            TftpConfig     `mapstructure:"tftp"`
-	Dhcp            DhcpConfig     `mapstructure:"dhcp"`
-	LogLevel        string         `mapstructure:"log_level"`
-	BackendFilePath string         `mapstructure:"backend_file_path"`
-	Log             logr.Logger    `mapstructure:"-"`
-	Iso             IsoConfig      `mapstructure:"iso"`
-	IpxeHttpScript  IpxeHttpScript `mapstructure:"ipxe_http_script"`
-	TrustedProxies  string         `mapstructure:"trusted_proxies"`
-	Otel            OtelConfig     `mapstructure:"otel"`
-	Static          StaticConfig   `mapstructure:"static"`
-	Dnsmasq         DnsmasqConfig  `mapstructure:"dnsmasq"`
-	ResetDelaySec   int            `mapstructure:"reset_delay_sec"`
-	FirmwarePath    string         `mapstructure:"firmware_path"`
-	Ironic          IronicConfig   `mapstructure:"ironic"`
-	Talos           TalosConfig    `mapstructure:"talos"`
-	SharedPath      string         `mapstructure:"shared_path"`
+	Address         string               `mapstructure:"address"`
+	Port            int                  `mapstructure:"port"`
+	Unifi           UnifiConfig          `mapstructure:"unifi"`
+	Tftp            TftpConfig           `mapstructure:"tftp"`
+	Dhcp            DhcpConfig           `mapstructure:"dhcp"`
+	LogLevel        string               `mapstructure:"log_level"`
+	Logging         LogConfig            `mapstructure:"log"`
+	BackendFilePath string               `mapstructure:"backend_file_path"`
+	Log             logr.Logger          `mapstructure:"-"`
+	Iso             IsoConfig            `mapstructure:"iso"`
+	IpxeHttpScript  IpxeHttpScript       `mapstructure:"ipxe_http_script"`
+	TrustedProxies  string               `mapstructure:"trusted_proxies"`
+	Otel            OtelConfig           `mapstructure:"otel"`
+	Static          StaticConfig         `mapstructure:"static"`
+	Dnsmasq         DnsmasqConfig        `mapstructure:"dnsmasq"`
+	ResetDelaySec   int                  `mapstructure:"reset_delay_sec"`
+	FirmwarePath    string               `mapstructure:"firmware_path"`
+	Ironic          IronicConfig         `mapstructure:"ironic"`
+	Talos           TalosConfig          `mapstructure:"talos"`
+	SharedPath      string               `mapstructure:"shared_path"`
+	StatePath       string               `mapstructure:"state_path"`
+	Cleaning        CleaningConfig       `mapstructure:"cleaning"`
+	BootAttempts    BootAttemptsConfig   `mapstructure:"boot_attempts"`
+	BootSLO         BootSLOConfig        `mapstructure:"boot_slo"`
+	BootAuth        BootAuthConfig       `mapstructure:"boot_auth"`
+	TLS             TLSConfig            `mapstructure:"tls"`
+	SelfUpdate      SelfUpdateConfig     `mapstructure:"self_update"`
+	ImageGC         ImageGCConfig        `mapstructure:"image_gc"`
+	StreamLimit     StreamLimitConfig    `mapstructure:"stream_limit"`
+	Integrity       IntegrityConfig      `mapstructure:"integrity"`
+	GPUFirmware     GPUFirmwareConfig    `mapstructure:"gpu_firmware"`
+	AdminAuth       AdminAuthConfig      `mapstructure:"admin_auth"`
+	ReadOnly        bool                 `mapstructure:"read_only"`
+	FaultInjection  FaultInjectionConfig `mapstructure:"fault_injection"`
+	Preflight       PreflightConfig      `mapstructure:"preflight"`
+	HostMetrics     HostMetricsConfig    `mapstructure:"host_metrics"`
+	Telemetry       TelemetryConfig      `mapstructure:"telemetry"`
+	IPv6            IPv6Config           `mapstructure:"ipv6"`
+	PhoneHome       PhoneHomeConfig      `mapstructure:"phone_home"`
+	BootFlow        BootFlowConfig       `mapstructure:"boot_flow"`
+	FileWatch       FileWatchConfig      `mapstructure:"file_watch"`
+	Bandwidth       BandwidthConfig      `mapstructure:"bandwidth"`
+	FirmwareUpload  FirmwareUploadConfig `mapstructure:"firmware_upload"`
+	// BiosAttributesPath is a YAML file mapping Redfish Bios attributes to
+	// UEFI variables. Empty uses the built-in definitions.
+	BiosAttributesPath string                `mapstructure:"bios_attributes_path"`
+	Qemu               QemuConfig            `mapstructure:"qemu"`
+	OutboundProxy      OutboundProxyConfig   `mapstructure:"outbound_proxy"`
+	RedfishSessions    RedfishSessionsConfig `mapstructure:"redfish_sessions"`
+	CORS               CORSConfig            `mapstructure:"cors"`
+	UI                 UIConfig              `mapstructure:"ui"`
+	Audit              AuditConfig           `mapstructure:"audit"`
+	Leases             LeasesConfig          `mapstructure:"leases"`
+	Decommission       DecommissionConfig    `mapstructure:"decommission"`
+	Standby            StandbyConfig         `mapstructure:"standby"`
+	Artifacts          ArtifactsConfig       `mapstructure:"artifacts"`
+	DHCPv6             DHCPv6Config          `mapstructure:"dhcpv6"`
+	Listeners          ListenersConfig       `mapstructure:"listeners"`
+	// APISocket is a unix socket serving the HTTP API to local clients. An
+	// empty path disables it.
+	APISocket      SocketConfig         `mapstructure:"api_socket"`
+	RedfishTasks   RedfishTasksConfig   `mapstructure:"redfish_tasks"`
+	RedfishSchemas RedfishSchemasConfig `mapstructure:"redfish_schemas"`
+	// Redactor masks the secrets of the configuration in the output of Log
+	// and Slog. It follows configuration reloads.
+	Redactor *redact.Redactor `mapstructure:"-"`
+	// logHandler is the handler behind Log and Slog, set up once from
+	// Logging when the configuration is first loaded.
+	logHandler slog.Handler
+	BootStorm  BootStormConfig `mapstructure:"boot_storm"`
+	Power      PowerConfig     `mapstructure:"power"`
+	// Hooks are commands run on provisioning events.
+	Hooks        []HookConfig       `mapstructure:"hooks"`
+	HostIdentity HostIdentityConfig `mapstructure:"host_identity"`
 }
 
 func (c *Config) GetIpxeHttpUrl() (*url.URL, error) {
@@ -210,6 +999,98 @@ func (c *Config) GetOsieUrl() (*url.URL, error) {
 	}
 }
 
+// TftpAddrPort returns the address the TFTP server listens on.
+func (c *Config) TftpAddrPort() (netip.AddrPort, error) {
+	addr := c.Tftp.Address
+	if addr == "" {
+		addr = c.Address
+	}
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("invalid tftp address: %w", err)
+	}
+
+	return netip.AddrPortFrom(ip, uint16(c.Tftp.Port)), nil
+}
+
+// ServerIPv6 returns the global IPv6 address of the server on the DHCP
+// interface: the one configured in ipv6.addresses, or else the first global
+// unicast address of the interface, preferring public over unique local ones.
+func (c *Config) ServerIPv6() (net.IP, error) {
+	iface := c.Dhcp.Interface
+	if addr, ok := c.IPv6.Addresses[iface]; ok {
+		ip := net.ParseIP(addr)
+		if ip == nil || ip.To4() != nil {
+			return nil, fmt.Errorf("ipv6.addresses.%s: %q is not an IPv6 address", iface, addr)
+		}
+		return ip, nil
+	}
+
+	return GlobalIPv6(iface)
+}
+
+// BootURL returns u for a client at client. IPv6 clients get a URL on the
+// server's global IPv6 address when IPv6 is enabled, as the IPv4 address in u
+// is unreachable for them. A nil client is treated as an IPv4 client.
+func (c *Config) BootURL(u IpxeUrl, client net.IP, paths ...string) *url.URL {
+	if c.IPv6.Enabled && client != nil && client.To4() == nil {
+		if ip, err := c.ServerIPv6(); err == nil {
+			u.Address = ip.String()
+		}
+	}
+
+	return u.GetUrl(paths...)
+}
+
+// APIURL returns the URL of paths on the HTTP API for a client at client, on
+// Address and Port over https when TLS is enabled. A wildcard Address is
+// replaced by the address of ipxe_binary_url, the server's address on the
+// provisioning network.
+func (c *Config) APIURL(client net.IP, paths ...string) *url.URL {
+	u := IpxeUrl{Address: c.Address, Port: c.Port, Scheme: "http"}
+	if c.TLS.Enabled {
+		u.Scheme = "https"
+	}
+	if ip, err := netip.ParseAddr(u.Address); u.Address == "" || err == nil && ip.IsUnspecified() {
+		u.Address = c.Dhcp.IpxeBinaryUrl.Address
+	}
+
+	return c.BootURL(u, client, paths...)
+}
+
+// GlobalIPv6 returns the first global unicast IPv6 address of the network
+// interface iface. Unique local addresses are only returned if the interface
+// has no public one.
+func GlobalIPv6(iface string) (net.IP, error) {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	var ula net.IP
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.To4() != nil || !ipnet.IP.IsGlobalUnicast() {
+			continue
+		}
+		if !ipnet.IP.IsPrivate() {
+			return ipnet.IP, nil
+		}
+		if ula == nil {
+			ula = ipnet.IP
+		}
+	}
+	if ula == nil {
+		return nil, fmt.Errorf("interface %s has no global IPv6 address", iface)
+	}
+
+	return ula, nil
+}
+
 type defaultNetworkInfo struct {
 	BindIP     string
 	ExternalIP string
@@ -284,6 +1165,8 @@ func NewConfig() (conf *Config, err error) {
 
 	viper.SetDefault("shared_path", sharedPath)
 
+	viper.SetDefault("state_path", filepath.Join(sharedPath, "state"))
+
 	viper.SetDefault("reset_delay_sec", 45)
 
 	viper.SetDefault("address", netInfo.BindIP)
@@ -298,10 +1181,17 @@ func NewConfig() (conf *Config, err error) {
 	viper.SetDefault("unifi.api_key", "your_api_key")
 
 	viper.SetDefault("tftp.enabled", false)
-	viper.SetDefault("tftp.address", netInfo.BindIP)
+	viper.SetDefault("tftp.address", "")
 	viper.SetDefault("tftp.port", 69)
 	viper.SetDefault("tftp.root_directory", "/tftpboot")
 	viper.SetDefault("tftp.ipxe_patch", ipxePatchDefault)
+	viper.SetDefault("tftp.ipxe_patches", []IpxePatchConfig{})
+	viper.SetDefault("tftp.writes.enabled", false)
+	viper.SetDefault("tftp.writes.directory", "")
+	viper.SetDefault("tftp.writes.allowed_paths",
+		[]string{"RPI_EFI.fd", "inspection/*", "nvram/*", "crash/*"})
+	viper.SetDefault("tftp.writes.max_size_bytes", 64<<20)
+	viper.SetDefault("tftp.writes.per_mac_directory", true)
 
 	viper.SetDefault("dhcp.enabled", false)
 	viper.SetDefault("dhcp.interface", netInfo.Iface)
@@ -322,6 +1212,10 @@ func NewConfig() (conf *Config, err error) {
 	viper.SetDefault("dhcp.syslog_ip", "")
 	viper.SetDefault("dhcp.lease_file", "")
 	viper.SetDefault("dhcp.static_ipam_enabled", false)
+	viper.SetDefault("dhcp.reservations_only", false)
+	viper.SetDefault("dhcp.guard.enabled", false)
+	viper.SetDefault("dhcp.guard.allowed_servers", []string{})
+	viper.SetDefault("dhcp.guard.alert_interval_sec", 600)
 
 	viper.SetDefault("static.enabled", true)
 	viper.SetDefault("static.image_urls", []ImageURL{})
@@ -337,6 +1231,9 @@ func NewConfig() (conf *Config, err error) {
 	viper.SetDefault("dnsmasq.default_subnet", "255.255.255.0")
 	viper.SetDefault("dnsmasq.default_dns", []string{"8.8.8.8", "8.8.4.4"})
 	viper.SetDefault("dnsmasq.default_domain", "local")
+	viper.SetDefault("dnsmasq.pools", []DnsmasqPoolConfig{})
+	viper.SetDefault("dnsmasq.sharding", "")
+	viper.SetDefault("dnsmasq.pid_file", "")
 
 	viper.SetDefault("ipxe_http_script.enabled", true)
 	viper.SetDefault("ipxe_http_script.retries", 3)
@@ -347,6 +1244,10 @@ func NewConfig() (conf *Config, err error) {
 	viper.SetDefault("ipxe_http_script.extra_kernel_args", []string{})
 	viper.SetDefault("ipxe_http_script.static_ipxe_enabled", false)
 	viper.SetDefault("ipxe_http_script.static_files_enabled", false)
+	viper.SetDefault("ipxe_http_script.retry_backoff", false)
+	viper.SetDefault("ipxe_http_script.fallback", "local")
+	viper.SetDefault("ipxe_http_script.rescue_url", "")
+	viper.SetDefault("ipxe_http_script.template_dir", "")
 
 	viper.SetDefault("ironic.url", fmt.Sprintf("http://127.0.0.1:%d", netInfo.Port))
 	viper.SetDefault("ironic.username", "")
@@ -363,21 +1264,236 @@ func NewConfig() (conf *Config, err error) {
 	viper.SetDefault("ironic.supervisor_enabled", false)
 	viper.SetDefault("ironic.database_connection", "sqlite:///var/lib/ironic/ironic.db")
 	viper.SetDefault("ironic.skip_db_sync", false)
+	viper.SetDefault("ironic.cache.ttl_sec", 2)
+	viper.SetDefault("ironic.cache.paths", []string{})
 
 	viper.SetDefault("talos.enabled", false)
 	viper.SetDefault("talos.base_url", "https://factory.talos.dev")
 	viper.SetDefault("talos.cache_directory", "/tmp/")
 	viper.SetDefault("talos.max_cache_size", int64(0)) // 0 = unlimited
 	viper.SetDefault("talos.default_extensions", []string{})
+	viper.SetDefault("talos.image", "")
 
+	viper.SetDefault("otel.enabled", false)
 	viper.SetDefault("otel.endpoint", "")
 	viper.SetDefault("otel.insecure", true)
+	viper.SetDefault("otel.sample_ratio", 1.0)
 
 	viper.SetDefault("iso.enabled", true)
 	viper.SetDefault("iso.url", "")
 	viper.SetDefault("iso.magic_string", magicString)
+	viper.SetDefault("iso.image", "")
+
+	viper.SetDefault("cleaning.enabled", false)
+	viper.SetDefault("cleaning.kernel_url", "")
+	viper.SetDefault("cleaning.initrd_url", "")
+	viper.SetDefault("cleaning.kernel_args", []string{})
+	viper.SetDefault("cleaning.wipe_method", "quick")
+	viper.SetDefault("cleaning.token_secret", "")
+	viper.SetDefault("cleaning.token_secret_file",
+		filepath.Join(sharedPath, "keys", "cleaning-token.key"))
+	viper.SetDefault("cleaning.token_ttl_sec", 86400)
+
+	viper.SetDefault("boot_attempts.enabled", false)
+	viper.SetDefault("boot_attempts.max_attempts", 5)
+	viper.SetDefault("boot_attempts.window_sec", 120)
+	viper.SetDefault("boot_attempts.fallback", "disable")
+	viper.SetDefault("boot_attempts.fallback_script", "fallback.ipxe")
+
+	viper.SetDefault("boot_slo.enabled", true)
+	viper.SetDefault("boot_slo.timeout_sec", 3600)
+	viper.SetDefault("boot_slo.ratio_window_sec", 3600)
+
+	viper.SetDefault("boot_auth.enabled", false)
+	viper.SetDefault("boot_auth.verify_source_ip", true)
+	viper.SetDefault("boot_auth.token_secret", "")
+	viper.SetDefault("boot_auth.token_ttl_sec", 600)
+
+	viper.SetDefault("tls.enabled", false)
+	viper.SetDefault("tls.cert_file", filepath.Join(sharedPath, "tls", "tls.crt"))
+	viper.SetDefault("tls.key_file", filepath.Join(sharedPath, "tls", "tls.key"))
+
+	viper.SetDefault("self_update.enabled", false)
+	viper.SetDefault("self_update.staging_dir", filepath.Join(sharedPath, "update"))
+	viper.SetDefault("self_update.public_key_file", "")
+
+	viper.SetDefault("image_gc.enabled", false)
+	viper.SetDefault("image_gc.budget_mb", 20480)
+	viper.SetDefault("image_gc.interval_sec", 300)
+	viper.SetDefault("image_gc.directories", []string{})
+
+	viper.SetDefault("stream_limit.enabled", false)
+	viper.SetDefault("stream_limit.max_streams", 16)
+	viper.SetDefault("stream_limit.per_client", 2)
+	viper.SetDefault("stream_limit.max_queue", 256)
+	viper.SetDefault("stream_limit.queue_timeout_sec", 300)
+
+	viper.SetDefault("integrity.enabled", false)
+	viper.SetDefault("integrity.signing_cert", "")
+	viper.SetDefault("integrity.signing_key", "")
+	viper.SetDefault("integrity.verify_ipxe", false)
+
+	viper.SetDefault("gpu_firmware.enabled", false)
+	viper.SetDefault("gpu_firmware.directory", filepath.Join(sharedPath, "gpu-firmware"))
+	viper.SetDefault("gpu_firmware.default_version", "builtin")
+
+	viper.SetDefault("admin_auth.enabled", false)
+	viper.SetDefault("admin_auth.oidc.issuer", "")
+	viper.SetDefault("admin_auth.oidc.client_id", "")
+	viper.SetDefault("admin_auth.oidc.client_secret", "")
+	viper.SetDefault("admin_auth.oidc.redirect_url", "")
+	viper.SetDefault("admin_auth.oidc.scopes", []string{"profile", "email", "groups"})
+	viper.SetDefault("admin_auth.oidc.groups_claim", "groups")
+	viper.SetDefault("admin_auth.oidc.admin_groups", []string{})
+	viper.SetDefault("admin_auth.oidc.viewer_groups", []string{})
+	viper.SetDefault("admin_auth.oidc.default_role", "")
+
+	viper.SetDefault("read_only", false)
+
+	viper.SetDefault("fault_injection.enabled", false)
+	for _, target := range []string{"backend", "tftp", "power"} {
+		viper.SetDefault("fault_injection."+target+".error_percent", 0)
+		viper.SetDefault("fault_injection."+target+".delay_percent", 0)
+		viper.SetDefault("fault_injection."+target+".delay_ms", 0)
+	}
+
+	viper.SetDefault("preflight.enabled", true)
+	viper.SetDefault("preflight.timeout_sec", 10)
+
+	viper.SetDefault("host_metrics.enabled", true)
+	viper.SetDefault("host_metrics.power", true)
+	viper.SetDefault("host_metrics.timeout_sec", 10)
+	viper.SetDefault("telemetry.enabled", true)
+	viper.SetDefault("telemetry.interval_sec", 60)
+	viper.SetDefault("ipv6.enabled", false)
+	viper.SetDefault("phone_home.enabled", false)
+	viper.SetDefault("phone_home.token_secret", "")
+	viper.SetDefault("phone_home.token_ttl_sec", 86400)
+	viper.SetDefault("phone_home.disable_netboot", true)
+
+	viper.SetDefault("boot_flow.enabled", true)
+	viper.SetDefault("boot_flow.mode", "local_boot")
+	viper.SetDefault("file_watch.poll", false)
+	viper.SetDefault("file_watch.poll_interval_sec", 2)
+	viper.SetDefault("bandwidth.enabled", false)
+	viper.SetDefault("bandwidth.global_mbps", 0)
+	viper.SetDefault("bandwidth.per_client_mbps", 0)
+	viper.SetDefault("firmware_upload.max_size_mb", 64)
+	viper.SetDefault("firmware_upload.task_threshold_mb", 16)
+
+	viper.SetDefault("bios_attributes_path", "")
+
+	viper.SetDefault("qemu.enabled", false)
+	viper.SetDefault("qemu.binary", "qemu-system-aarch64")
+	viper.SetDefault("qemu.machine", "virt")
+	viper.SetDefault("qemu.cpu", "")
+	viper.SetDefault("qemu.kvm", false)
+	viper.SetDefault("qemu.memory_mb", 1024)
+	viper.SetDefault("qemu.firmware", "/usr/share/AAVMF/AAVMF_CODE.fd")
+	viper.SetDefault("qemu.varstore_template", "/usr/share/AAVMF/AAVMF_VARS.fd")
+	viper.SetDefault("qemu.bridge", "br0")
+	viper.SetDefault("qemu.state_dir", filepath.Join(sharedPath, "state", "qemu"))
+	viper.SetDefault("qemu.extra_args", []string{})
+
+	viper.SetDefault("outbound_proxy.url", "")
+	viper.SetDefault("outbound_proxy.no_proxy", "")
+	viper.SetDefault("outbound_proxy.ca_bundle", "")
+
+	viper.SetDefault("redfish_sessions.persist", true)
+	viper.SetDefault("redfish_sessions.key_file",
+		filepath.Join(sharedPath, "keys", "redfish-sessions.key"))
+	viper.SetDefault("redfish_sessions.timeout_sec", 1800)
+
+	viper.SetDefault("cors.enabled", false)
+	viper.SetDefault("cors.allowed_origins", []string{})
+	viper.SetDefault("cors.allowed_methods",
+		[]string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"})
+	viper.SetDefault("cors.allowed_headers",
+		[]string{"Authorization", "Content-Type", "If-Match", "X-Auth-Token"})
+	viper.SetDefault("cors.exposed_headers", []string{"ETag", "Location", "X-Auth-Token"})
+	viper.SetDefault("cors.allow_credentials", false)
+	viper.SetDefault("cors.max_age_sec", 600)
+
+	viper.SetDefault("ui.enabled", true)
+
+	viper.SetDefault("audit.enabled", true)
+	viper.SetDefault("audit.path", "")
+	viper.SetDefault("audit.max_size_mb", 100)
+	viper.SetDefault("audit.max_backups", 5)
+	viper.SetDefault("audit.retain", 1000)
+
+	viper.SetDefault("leases.enabled", true)
+	viper.SetDefault("leases.path", "")
+	viper.SetDefault("leases.offer_timeout_sec", 60)
+	viper.SetDefault("leases.retain_hours", 24)
+	viper.SetDefault("leases.sweep_interval_sec", 60)
+
+	viper.SetDefault("decommission.enabled", true)
+	viper.SetDefault("decommission.archive_directory", "")
+	viper.SetDefault("decommission.remove_from_ironic", true)
+	viper.SetDefault("decommission.timeout_sec", 600)
+
+	viper.SetDefault("standby.import_from", "")
+	viper.SetDefault("standby.token", "")
+	viper.SetDefault("standby.timeout_sec", 30)
+
+	viper.SetDefault("artifacts.enabled", false)
+	viper.SetDefault("artifacts.cache_dir", "")
+	viper.SetDefault("artifacts.profiles", map[string][]ArtifactPipelineConfig{})
+
+	viper.SetDefault("dhcpv6.enabled", false)
+	viper.SetDefault("dhcpv6.interface", "")
+	viper.SetDefault("dhcpv6.address", "::")
+	viper.SetDefault("dhcpv6.port", 547)
+
+	viper.SetDefault("listeners.http", []ListenerConfig{})
+	viper.SetDefault("listeners.redfish", []ListenerConfig{})
+	viper.SetDefault("listeners.tftp", []ListenerConfig{})
+
+	viper.SetDefault("api_socket.path", "")
+	viper.SetDefault("api_socket.mode", "0660")
+
+	viper.SetDefault("redfish_tasks.persist", true)
+	viper.SetDefault("redfish_schemas.versions", []RedfishSchemaVersion{})
+	viper.SetDefault("redfish_schemas.clients", []RedfishSchemaClient{})
+
+	viper.SetDefault("boot_storm.enabled", false)
+	viper.SetDefault("boot_storm.offers_per_sec", 5)
+	viper.SetDefault("boot_storm.burst", 10)
+	viper.SetDefault("boot_storm.max_wait_ms", 2000)
+	viper.SetDefault("boot_storm.jitter_ms", 500)
+
+	viper.SetDefault("power.default_driver", "")
+	viper.SetDefault("power.timeout_sec", 10)
+	viper.SetDefault("power.tasmota.username", "")
+	viper.SetDefault("power.tasmota.password", "")
+	viper.SetDefault("power.tasmota.cycle_delay_ms", 3000)
+	viper.SetDefault("power.redfish.username", "")
+	viper.SetDefault("power.redfish.password", "")
+	viper.SetDefault("power.redfish.insecure", false)
+	viper.SetDefault("power.ipmi.binary", "ipmitool")
+	viper.SetDefault("power.ipmi.username", "")
+	viper.SetDefault("power.ipmi.password", "")
+	viper.SetDefault("power.ipmi.interface", "lanplus")
+	viper.SetDefault("power.gpio.sysfs_root", "/sys/class/gpio")
+	viper.SetDefault("power.gpio.lines", map[string]int{})
+	viper.SetDefault("power.gpio.active_low", false)
+	viper.SetDefault("power.gpio.cycle_delay_ms", 5000)
+	viper.SetDefault("hooks", []HookConfig{})
+
+	viper.SetDefault("host_identity.locally_administered", "mac")
+	viper.SetDefault("host_identity.match", []string{})
 
 	viper.SetDefault("log_level", "info")
+	viper.SetDefault("log.format", logging.FormatJSON)
+	viper.SetDefault("log.output", logging.OutputStdout)
+	viper.SetDefault("log.file.path", "")
+	viper.SetDefault("log.file.max_size_mb", 100)
+	viper.SetDefault("log.file.max_backups", 5)
+	viper.SetDefault("log.syslog.network", "")
+	viper.SetDefault("log.syslog.address", "")
+	viper.SetDefault("log.syslog.facility", "daemon")
+	viper.SetDefault("log.tag", "metal-boot")
 
 	viper.SetConfigType("yaml")
 
@@ -404,7 +1520,7 @@ func NewConfig() (conf *Config, err error) {
 		}
 	}
 
-	conf.Log = defaultLogger(conf.LogLevel)
+	conf.Redactor = redact.New()
 
 	// Load the Config the first time we start the app.
 	err = loadConfig(conf)
@@ -412,6 +1528,12 @@ func NewConfig() (conf *Config, err error) {
 		return conf, err
 	}
 
+	// The loggers are set up once. Changes to their configuration take
+	// effect on restart.
+	if err := conf.setupLogging(); err != nil {
+		return conf, err
+	}
+
 	// Tell viper to watch the config file.
 	viper.WatchConfig()
 
@@ -436,10 +1558,48 @@ func loadConfig(conf *Config) (err error) {
 	if err != nil {
 		return
 	}
+	conf.Redactor.SetSecrets(conf.Secrets()...)
 
 	return
 }
 
+// Secrets returns the credentials and signing secrets of the configuration,
+// which must not appear in logs.
+func (c *Config) Secrets() []string {
+	secrets := []string{
+		c.Unifi.APIKey,
+		c.Unifi.Password,
+		c.Ironic.Password,
+		c.BootAuth.TokenSecret,
+		c.AdminAuth.OIDC.ClientSecret,
+		c.PhoneHome.TokenSecret,
+		c.Cleaning.TokenSecret,
+		c.Power.Tasmota.Password,
+		c.Power.Redfish.Password,
+		c.Power.IPMI.Password,
+		c.Standby.Token,
+	}
+	if u, err := url.Parse(c.OutboundProxy.URL); err == nil {
+		if password, ok := u.User.Password(); ok {
+			secrets = append(secrets, password)
+		}
+	}
+
+	return secrets
+}
+
+// Slog returns a logger writing through the same handler as Log, for the
+// components that log through slog. Before the configuration is loaded it
+// writes JSON to stdout.
+func (c *Config) Slog() *slog.Logger {
+	h := c.logHandler
+	if h == nil {
+		h = redact.NewHandler(slog.NewJSONHandler(os.Stdout, nil), c.Redactor)
+	}
+
+	return slog.New(h)
+}
+
 func GetLocalIP() (string, string, error) {
 	ifaces, err := net.Interfaces()
 	if err != nil {
@@ -473,38 +1633,45 @@ func GetLocalIP() (string, string, error) {
 	return "", "", nil
 }
 
-// defaultLogger uses the slog logr implementation.
-func defaultLogger(level string) logr.Logger {
-	// source file and function can be long. This makes the logs less readable.
-	// truncate source file and function to last 3 parts for improved readability.
-	customAttr := func(_ []string, a slog.Attr) slog.Attr {
-		if a.Key == slog.SourceKey {
-			ss, ok := a.Value.Any().(*slog.Source)
-			if !ok || ss == nil {
-				return a
-			}
-			f := strings.Split(ss.Function, "/")
-			if len(f) > 3 {
-				ss.Function = filepath.Join(f[len(f)-3:]...)
-			}
-			p := strings.Split(ss.File, "/")
-			if len(p) > 3 {
-				ss.File = filepath.Join(p[len(p)-3:]...)
-			}
+// setupLogging builds the handler of Log and Slog from the log level and
+// Logging, redacting secrets with the Redactor and adding the correlation
+// fields of the context of a record.
+func (c *Config) setupLogging() error {
+	h, _, err := logging.NewHandler(logging.Options{
+		Level:  logLevel(c.LogLevel),
+		Format: c.Logging.Format,
+		Output: c.Logging.Output,
+		File: logging.FileOptions{
+			Path:       c.Logging.File.Path,
+			MaxSizeMB:  c.Logging.File.MaxSizeMB,
+			MaxBackups: c.Logging.File.MaxBackups,
+		},
+		Syslog: logging.SyslogOptions{
+			Network:  c.Logging.Syslog.Network,
+			Address:  c.Logging.Syslog.Address,
+			Facility: c.Logging.Syslog.Facility,
+		},
+		Tag: c.Logging.Tag,
+	})
+	if err != nil {
+		return fmt.Errorf("setting up logging: %w", err)
+	}
+	c.logHandler = redact.NewHandler(logging.NewContextHandler(h), c.Redactor)
+	c.Log = logging.ToLogr(slog.New(c.logHandler))
 
-			return a
-		}
+	return nil
+}
 
-		return a
-	}
-	opts := &slog.HandlerOptions{AddSource: true, ReplaceAttr: customAttr}
-	switch level {
+// logLevel is the slog level of the log_level setting.
+func logLevel(level string) slog.Level {
+	switch strings.ToLower(level) {
 	case "debug":
-		opts.Level = slog.LevelDebug
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
 	default:
-		opts.Level = slog.LevelInfo
+		return slog.LevelInfo
 	}
-	log := slog.New(slog.NewJSONHandler(os.Stdout, opts))
-
-	return logr.FromSlogHandler(log.Handler())
 }
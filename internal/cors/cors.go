@@ -0,0 +1,135 @@
+// Package cors answers Cross-Origin Resource Sharing requests, so that a UI
+// or dashboard served from another origin can call the Redfish and admin APIs
+// from a browser without a proxy in front of metal-boot.
+package cors
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Policy decides which origins may call the API and what they may send.
+type Policy struct {
+	// AllowedOrigins are scheme://host[:port] origins. "*" allows any origin
+	// and a "*." host prefix, as in https://*.example.com, any subdomain.
+	AllowedOrigins []string
+	// AllowedMethods are the methods a preflight may ask for.
+	AllowedMethods []string
+	// AllowedHeaders are the request headers a preflight may ask for. "*"
+	// allows any.
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers scripts may read, such as
+	// Location and X-Auth-Token.
+	ExposedHeaders []string
+	// AllowCredentials lets browsers send cookies and HTTP authentication.
+	AllowCredentials bool
+	// MaxAge is how many seconds browsers may cache a preflight answer. Zero
+	// leaves it to the browser.
+	MaxAge int
+}
+
+// Middleware adds the CORS headers of p to the responses of next and answers
+// preflight requests itself. A nil Policy returns next unchanged.
+func (p *Policy) Middleware(next http.Handler) http.Handler {
+	if p == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		preflight := r.Method == http.MethodOptions &&
+			r.Header.Get("Access-Control-Request-Method") != ""
+
+		if !p.allowsOrigin(origin) {
+			if preflight {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if slices.Contains(p.AllowedOrigins, "*") && !p.AllowCredentials {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Set("Access-Control-Allow-Origin", origin)
+		}
+		if p.AllowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			if len(p.ExposedHeaders) > 0 {
+				h.Set("Access-Control-Expose-Headers", strings.Join(p.ExposedHeaders, ", "))
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h.Add("Vary", "Access-Control-Request-Method")
+		h.Add("Vary", "Access-Control-Request-Headers")
+		method := r.Header.Get("Access-Control-Request-Method")
+		if !slices.ContainsFunc(p.AllowedMethods, func(m string) bool {
+			return strings.EqualFold(m, method)
+		}) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		requested := r.Header.Get("Access-Control-Request-Headers")
+		if !p.allowsHeaders(requested) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		h.Set("Access-Control-Allow-Methods", strings.Join(p.AllowedMethods, ", "))
+		if requested != "" {
+			h.Set("Access-Control-Allow-Headers", requested)
+		}
+		if p.MaxAge > 0 {
+			h.Set("Access-Control-Max-Age", strconv.Itoa(p.MaxAge))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func (p *Policy) allowsOrigin(origin string) bool {
+	for _, allowed := range p.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		scheme, host, ok := strings.Cut(allowed, "://*.")
+		if ok && strings.HasPrefix(strings.ToLower(origin), strings.ToLower(scheme)+"://") &&
+			strings.HasSuffix(strings.ToLower(origin), "."+strings.ToLower(host)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allowsHeaders reports whether every header of the comma separated list
+// requested may be sent.
+func (p *Policy) allowsHeaders(requested string) bool {
+	if slices.Contains(p.AllowedHeaders, "*") {
+		return true
+	}
+	for header := range strings.SplitSeq(requested, ",") {
+		header = strings.TrimSpace(header)
+		if header != "" && !slices.ContainsFunc(p.AllowedHeaders, func(h string) bool {
+			return strings.EqualFold(h, header)
+		}) {
+			return false
+		}
+	}
+
+	return true
+}
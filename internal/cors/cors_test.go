@@ -0,0 +1,137 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	p := &Policy{
+		AllowedOrigins: []string{"https://ui.example.com", "https://*.dash.example.com"},
+		AllowedMethods: []string{"GET", "PATCH"},
+		AllowedHeaders: []string{"Content-Type", "X-Auth-Token"},
+		ExposedHeaders: []string{"Location"},
+		MaxAge:         600,
+	}
+	handler := p.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	tests := []struct {
+		name       string
+		method     string
+		origin     string
+		reqMethod  string
+		reqHeaders string
+		wantStatus int
+		wantOrigin string
+		wantHeader map[string]string
+	}{
+		{name: "same origin", method: http.MethodGet, wantStatus: http.StatusTeapot},
+		{
+			name:       "allowed origin",
+			method:     http.MethodGet,
+			origin:     "https://ui.example.com",
+			wantStatus: http.StatusTeapot,
+			wantOrigin: "https://ui.example.com",
+			wantHeader: map[string]string{"Access-Control-Expose-Headers": "Location"},
+		},
+		{
+			name:       "allowed subdomain",
+			method:     http.MethodGet,
+			origin:     "https://a.dash.example.com",
+			wantStatus: http.StatusTeapot,
+			wantOrigin: "https://a.dash.example.com",
+		},
+		{
+			name:       "other origin",
+			method:     http.MethodGet,
+			origin:     "https://evil.example.org",
+			wantStatus: http.StatusTeapot,
+		},
+		{
+			name:       "preflight",
+			method:     http.MethodOptions,
+			origin:     "https://ui.example.com",
+			reqMethod:  "PATCH",
+			reqHeaders: "content-type, x-auth-token",
+			wantStatus: http.StatusNoContent,
+			wantOrigin: "https://ui.example.com",
+			wantHeader: map[string]string{
+				"Access-Control-Allow-Methods": "GET, PATCH",
+				"Access-Control-Allow-Headers": "content-type, x-auth-token",
+				"Access-Control-Max-Age":       "600",
+			},
+		},
+		{
+			name:       "preflight with a method not allowed",
+			method:     http.MethodOptions,
+			origin:     "https://ui.example.com",
+			reqMethod:  "DELETE",
+			wantStatus: http.StatusForbidden,
+			wantOrigin: "https://ui.example.com",
+		},
+		{
+			name:       "preflight with a header not allowed",
+			method:     http.MethodOptions,
+			origin:     "https://ui.example.com",
+			reqMethod:  "GET",
+			reqHeaders: "X-Other",
+			wantStatus: http.StatusForbidden,
+			wantOrigin: "https://ui.example.com",
+		},
+		{
+			name:       "preflight from other origin",
+			method:     http.MethodOptions,
+			origin:     "https://evil.example.org",
+			reqMethod:  "GET",
+			wantStatus: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/redfish/v1/Systems", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.reqMethod != "" {
+				req.Header.Set("Access-Control-Request-Method", tt.reqMethod)
+			}
+			if tt.reqHeaders != "" {
+				req.Header.Set("Access-Control-Request-Headers", tt.reqHeaders)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			for k, want := range tt.wantHeader {
+				if got := rec.Header().Get(k); got != want {
+					t.Errorf("%s = %q, want %q", k, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestWildcardOrigin(t *testing.T) {
+	p := &Policy{AllowedOrigins: []string{"*"}}
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/images", nil)
+	req.Header.Set("Origin", "http://localhost:3000")
+	p.Middleware(http.NotFoundHandler()).ServeHTTP(rec, req)
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want *", got)
+	}
+
+	var nilPolicy *Policy
+	next := http.NotFoundHandler()
+	if nilPolicy.Middleware(next) == nil {
+		t.Error("Middleware() of a nil policy = nil, want next")
+	}
+}
@@ -0,0 +1,309 @@
+// Package decommission retires hosts for good. Decommissioning a host powers
+// it off, revokes its DHCP leases and reservations, archives its firmware
+// varstore and the logs it wrote, removes it from Ironic and marks it
+// retired, as one operation reported by a task.
+//
+// Every step is idempotent: a step with nothing left to do is skipped, so a
+// decommission that failed half way is completed by starting it again.
+package decommission
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/metal3-community/metal-boot/internal/backend"
+	dnsmasqconfig "github.com/metal3-community/metal-boot/internal/backend/dnsmasq/config"
+	"github.com/metal3-community/metal-boot/internal/backup"
+	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+	"github.com/metal3-community/metal-boot/internal/hoststate"
+	"github.com/metal3-community/metal-boot/internal/ironic"
+	"github.com/metal3-community/metal-boot/internal/leasedb"
+	"github.com/metal3-community/metal-boot/internal/task"
+)
+
+// defaultTimeout bounds a decommission when Decommissioner.Timeout is 0.
+const defaultTimeout = 10 * time.Minute
+
+// reservationBackend is implemented by backends that hold static
+// reservations apart from their hosts.
+type reservationBackend interface {
+	ReleaseReservation(ctx context.Context, mac net.HardwareAddr) error
+}
+
+// Decommissioner decommissions hosts. Every dependency but Tasks may be nil,
+// in which case the steps that need it are skipped.
+type Decommissioner struct {
+	// Backend holds the hosts and their reservations. Hosts are only marked
+	// retired in backends that are a backend.BackendHostWriter.
+	Backend backend.BackendReader
+	Power   backend.BackendPower
+	Leases  *leasedb.DB
+	Hosts   *hoststate.Store
+	Ironic  *ironic.Client
+	Tasks   *task.Store
+	// FirmwareRoot holds the per-host firmware directories, named after the
+	// MAC address of the host with dashes.
+	FirmwareRoot string
+	// LogRoot holds the per-host directories of the files hosts wrote over
+	// TFTP. Empty if hosts do not write files of their own.
+	LogRoot string
+	// ArchiveDir receives an archive of the firmware and logs of every
+	// decommissioned host.
+	ArchiveDir string
+	// Version is the metal-boot version recorded in the archives.
+	Version string
+	// Timeout bounds a decommission.
+	Timeout time.Duration
+	Log     logr.Logger
+
+	mu      sync.Mutex
+	running map[string]bool
+}
+
+// TaskID returns the id of the task that decommissions mac.
+func TaskID(mac net.HardwareAddr) string {
+	return "decommission-" + dirName(mac)
+}
+
+// dirName is the name of the per-host directories of mac.
+func dirName(mac net.HardwareAddr) string {
+	return strings.ReplaceAll(strings.ToLower(mac.String()), ":", "-")
+}
+
+// Start decommissions mac in the background and returns the id of its task.
+// While a decommission of mac runs, Start returns its task instead of
+// starting another.
+func (d *Decommissioner) Start(mac net.HardwareAddr) string {
+	id := TaskID(mac)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.running[id] {
+		return id
+	}
+	if d.running == nil {
+		d.running = map[string]bool{}
+	}
+	d.running[id] = true
+
+	tk := d.Tasks.Start(id, "Decommission Task", "Decommission of "+mac.String(),
+		"/api/v1/machines/"+mac.String())
+	go func() {
+		timeout := d.Timeout
+		if timeout <= 0 {
+			timeout = defaultTimeout
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		err := d.Run(ctx, mac, tk)
+		if err != nil {
+			d.Log.Error(err, "failed to decommission host", "mac", mac.String())
+		} else {
+			d.Log.Info("decommissioned host", "mac", mac.String())
+		}
+		tk.Done(err)
+
+		d.mu.Lock()
+		delete(d.running, id)
+		d.mu.Unlock()
+	}()
+
+	return id
+}
+
+// Task returns the latest decommission task of mac.
+func (d *Decommissioner) Task(mac net.HardwareAddr) (task.Info, bool) {
+	return d.Tasks.Get(TaskID(mac))
+}
+
+// step is one step of a decommission. It returns a message describing what
+// it did.
+type step struct {
+	name string
+	run  func(ctx context.Context, mac net.HardwareAddr) (string, error)
+}
+
+// Run decommissions mac, reporting the progress of each step on tk, and
+// stops at the first step that fails.
+func (d *Decommissioner) Run(ctx context.Context, mac net.HardwareAddr, tk *task.Task) error {
+	steps := []step{
+		{"power off", d.powerOff},
+		{"revoke leases", d.revokeLeases},
+		{"archive", d.archive},
+		{"remove from Ironic", d.removeFromIronic},
+		{"retire", d.retire},
+	}
+	for i, s := range steps {
+		msg, err := s.run(ctx, mac)
+		if err != nil {
+			return fmt.Errorf("%s: %w", s.name, err)
+		}
+		tk.Message(task.SeverityOK, msg)
+		tk.SetPercent((i + 1) * 100 / len(steps))
+	}
+
+	return nil
+}
+
+// powerOff switches mac off unless it is off already.
+func (d *Decommissioner) powerOff(ctx context.Context, mac net.HardwareAddr) (string, error) {
+	if d.Power == nil {
+		return "No power backend; the host was not powered off.", nil
+	}
+
+	state, err := d.Power.GetPower(ctx, mac)
+	if err != nil {
+		return "", err
+	}
+	if *state == data.PowerOff {
+		return "The host is already powered off.", nil
+	}
+	if err := d.Power.SetPower(ctx, mac, data.PowerOff); err != nil {
+		return "", err
+	}
+
+	return "Powered off the host.", nil
+}
+
+// revokeLeases ends the leases of mac and releases its static reservation.
+func (d *Decommissioner) revokeLeases(ctx context.Context, mac net.HardwareAddr) (string, error) {
+	if err := d.Leases.Release(mac); err != nil {
+		return "", err
+	}
+
+	switch b := d.Backend.(type) {
+	case reservationBackend:
+		err := b.ReleaseReservation(ctx, mac)
+		if errors.Is(err, dnsmasqconfig.ErrNotFound) {
+			return "Released the leases; the host had no reservation.", nil
+		}
+		if err != nil {
+			return "", err
+		}
+	case backend.BackendHostWriter:
+		host, err := b.GetHost(ctx, mac)
+		if errors.Is(err, backend.ErrHostNotFound) || err == nil && host.IP == nil && host.IPv6 == nil {
+			return "Released the leases; the host had no reservation.", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		host.IP, host.IPv6 = nil, nil
+		if err := b.UpdateHost(ctx, host); err != nil {
+			return "", err
+		}
+	default:
+		return "Released the leases; the backend holds no reservations.", nil
+	}
+
+	return "Released the leases and the reservation of the host.", nil
+}
+
+// archive writes the firmware directory and the logs of mac to an archive in
+// ArchiveDir and removes them.
+func (d *Decommissioner) archive(_ context.Context, mac net.HardwareAddr) (string, error) {
+	var sources []backup.Source
+	add := func(name, root string) {
+		if root == "" {
+			return
+		}
+		dir := filepath.Join(root, dirName(mac))
+		for _, s := range sources {
+			if s.Path == dir {
+				return
+			}
+		}
+		if _, err := os.Stat(dir); err == nil {
+			sources = append(sources, backup.Source{Name: name, Path: dir})
+		}
+	}
+	add("firmware", d.FirmwareRoot)
+	add("logs", d.LogRoot)
+	if len(sources) == 0 {
+		return "The host has no firmware or logs to archive.", nil
+	}
+
+	if err := os.MkdirAll(d.ArchiveDir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create archive directory: %w", err)
+	}
+	path := filepath.Join(d.ArchiveDir,
+		fmt.Sprintf("%s-%s.tar.gz", dirName(mac), time.Now().UTC().Format("20060102T150405Z")))
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return "", fmt.Errorf("failed to create archive: %w", err)
+	}
+	a := &backup.Archiver{Version: d.Version, Sources: sources}
+	if _, err := a.Export(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return "", fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return "", fmt.Errorf("failed to replace archive: %w", err)
+	}
+
+	for _, s := range sources {
+		if err := os.RemoveAll(s.Path); err != nil {
+			return "", fmt.Errorf("failed to remove archived %s: %w", s.Name, err)
+		}
+	}
+
+	return "Archived the firmware and logs of the host to " + path + ".", nil
+}
+
+// removeFromIronic deletes the Ironic node of mac.
+func (d *Decommissioner) removeFromIronic(ctx context.Context, mac net.HardwareAddr) (string, error) {
+	if d.Ironic == nil {
+		return "Ironic is not configured; no node was removed.", nil
+	}
+
+	node, err := d.Ironic.DeleteNodeByMAC(ctx, mac)
+	if err != nil {
+		return "", err
+	}
+	if node == "" {
+		return "The host has no Ironic node.", nil
+	}
+
+	return "Removed Ironic node " + node + ".", nil
+}
+
+// retire disables the host of mac in the backend, so that it gets no answer
+// to DHCP requests, and records it as retired.
+func (d *Decommissioner) retire(ctx context.Context, mac net.HardwareAddr) (string, error) {
+	if hw, ok := d.Backend.(backend.BackendHostWriter); ok {
+		host, err := hw.GetHost(ctx, mac)
+		switch {
+		case errors.Is(err, backend.ErrHostNotFound):
+			err = hw.CreateHost(ctx, backend.Host{MAC: mac, Disabled: true})
+		case err == nil && !host.Disabled:
+			host.Disabled = true
+			err = hw.UpdateHost(ctx, host)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if d.Hosts != nil {
+		if err := d.Hosts.Retire(mac, "decommissioned"); err != nil {
+			return "", err
+		}
+	}
+
+	return "Marked the host retired.", nil
+}
@@ -0,0 +1,156 @@
+package decommission
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/metal3-community/metal-boot/internal/backend"
+	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+	"github.com/metal3-community/metal-boot/internal/hoststate"
+	"github.com/metal3-community/metal-boot/internal/leasedb"
+	"github.com/metal3-community/metal-boot/internal/task"
+)
+
+var mac = net.HardwareAddr{0xd8, 0x3a, 0xdd, 0, 0, 1}
+
+// fakeBackend holds hosts in memory and powers them.
+type fakeBackend struct {
+	hosts map[string]backend.Host
+	power data.PowerState
+}
+
+func (b *fakeBackend) GetByMac(context.Context, net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+	return nil, nil, backend.ErrHostNotFound
+}
+
+func (b *fakeBackend) GetByIP(context.Context, net.IP) (*data.DHCP, *data.Netboot, error) {
+	return nil, nil, backend.ErrHostNotFound
+}
+
+func (b *fakeBackend) GetKeys(context.Context) ([]net.HardwareAddr, error) { return nil, nil }
+
+func (b *fakeBackend) Hosts(context.Context) ([]backend.Host, error) { return nil, nil }
+
+func (b *fakeBackend) GetHost(_ context.Context, mac net.HardwareAddr) (backend.Host, error) {
+	h, ok := b.hosts[mac.String()]
+	if !ok {
+		return backend.Host{}, backend.ErrHostNotFound
+	}
+	return h, nil
+}
+
+func (b *fakeBackend) CreateHost(_ context.Context, h backend.Host) error {
+	b.hosts[h.MAC.String()] = h
+	return nil
+}
+
+func (b *fakeBackend) UpdateHost(_ context.Context, h backend.Host) error {
+	b.hosts[h.MAC.String()] = h
+	return nil
+}
+
+func (b *fakeBackend) DeleteHost(_ context.Context, mac net.HardwareAddr) error {
+	delete(b.hosts, mac.String())
+	return nil
+}
+
+func (b *fakeBackend) SetOptions(context.Context, net.HardwareAddr, []backend.HostOption) error {
+	return nil
+}
+
+func (b *fakeBackend) GetPower(context.Context, net.HardwareAddr) (*data.PowerState, error) {
+	state := b.power
+	return &state, nil
+}
+
+func (b *fakeBackend) SetPower(_ context.Context, _ net.HardwareAddr, state data.PowerState) error {
+	b.power = state
+	return nil
+}
+
+func (b *fakeBackend) PowerCycle(context.Context, net.HardwareAddr) error { return nil }
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "tftp")
+	firmware := filepath.Join(root, "d8-3a-dd-00-00-01", "RPI_EFI.fd")
+	if err := os.MkdirAll(filepath.Dir(firmware), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(firmware, []byte("varstore"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	b := &fakeBackend{
+		hosts: map[string]backend.Host{
+			mac.String(): {MAC: mac, IP: net.IPv4(10, 0, 0, 10), Hostname: "node-1"},
+		},
+		power: data.PowerOn,
+	}
+	leases, err := leasedb.Open("", leasedb.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := leases.Ack(mac, netip.MustParseAddr("10.0.0.10"), "node-1", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	hosts, err := hoststate.NewStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tasks, err := task.New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &Decommissioner{
+		Backend:      b,
+		Power:        b,
+		Leases:       leases,
+		Hosts:        hosts,
+		Tasks:        tasks,
+		FirmwareRoot: root,
+		LogRoot:      root,
+		ArchiveDir:   filepath.Join(dir, "archive"),
+		Log:          logr.Discard(),
+	}
+
+	// A second run finds nothing left to do and succeeds as well.
+	for range 2 {
+		tk := tasks.Start(TaskID(mac), "Decommission Task", "", "")
+		if err := d.Run(context.Background(), mac, tk); err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+		tk.Done(nil)
+	}
+
+	if b.power != data.PowerOff {
+		t.Errorf("power = %v, want off", b.power)
+	}
+	if l, _ := leases.Get(mac); l.State != leasedb.StateReleased {
+		t.Errorf("lease state = %s, want %s", l.State, leasedb.StateReleased)
+	}
+	if h := b.hosts[mac.String()]; h.IP != nil || !h.Disabled {
+		t.Errorf("backend host = %+v, want it disabled without an address", h)
+	}
+	if h, err := hosts.Get(mac); err != nil || h.State != hoststate.StateRetired || !h.NetbootDisabled {
+		t.Errorf("host state = %+v, %v, want it retired", h, err)
+	}
+	if _, err := os.Stat(filepath.Dir(firmware)); !os.IsNotExist(err) {
+		t.Errorf("firmware directory still exists after the decommission: %v", err)
+	}
+	archives, _ := filepath.Glob(filepath.Join(dir, "archive", "d8-3a-dd-00-00-01-*.tar.gz"))
+	if len(archives) != 1 {
+		t.Errorf("archives = %v, want one", archives)
+	}
+
+	info, ok := d.Task(mac)
+	if !ok || info.State != task.StateCompleted || len(info.Messages) != 5 {
+		t.Errorf("task = %+v, want completed with a message per step", info)
+	}
+}
@@ -0,0 +1,92 @@
+package dhcp
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+// Architecture families that BootFiles can override the boot file of.
+const (
+	// ArchBIOS is x86 legacy BIOS and other pre-UEFI PXE clients.
+	ArchBIOS = "bios"
+	// ArchX86EFI is x86 UEFI, booting over PXE or HTTP.
+	ArchX86EFI = "x86_64_efi"
+	// ArchARM32EFI is 32 bit ARM UEFI, booting over PXE or HTTP.
+	ArchARM32EFI = "arm32_efi"
+	// ArchARM64EFI is ARM64 UEFI, booting over PXE or HTTP.
+	ArchARM64EFI = "arm64_efi"
+	// ArchRPi is the Raspberry Pi boot ROM.
+	ArchRPi = "rpi"
+)
+
+// archFamilies maps the architectures of ArchToBootFile to their family.
+var archFamilies = map[iana.Arch]string{
+	iana.INTEL_X86PC:       ArchBIOS,
+	iana.NEC_PC98:          ArchBIOS,
+	iana.EFI_ITANIUM:       ArchBIOS,
+	iana.DEC_ALPHA:         ArchBIOS,
+	iana.ARC_X86:           ArchBIOS,
+	iana.INTEL_LEAN_CLIENT: ArchBIOS,
+	iana.EFI_IA32:          ArchX86EFI,
+	iana.EFI_X86_64:        ArchX86EFI,
+	iana.EFI_XSCALE:        ArchX86EFI,
+	iana.EFI_BC:            ArchX86EFI,
+	iana.EFI_X86_HTTP:      ArchX86EFI,
+	iana.EFI_X86_64_HTTP:   ArchX86EFI,
+	iana.EFI_ARM32:         ArchARM32EFI,
+	iana.EFI_ARM32_HTTP:    ArchARM32EFI,
+	iana.EFI_ARM64:         ArchARM64EFI,
+	iana.EFI_ARM64_HTTP:    ArchARM64EFI,
+	iana.Arch(41):          ArchRPi,
+}
+
+// ArchFamily returns the family of a, or "" if a has no boot file.
+func ArchFamily(a iana.Arch) string {
+	return archFamilies[a]
+}
+
+// BootFiles overrides the boot files of ArchToBootFile. Keys are an
+// architecture family, such as "bios" or "arm64_efi", or an option 93
+// architecture type in decimal, which takes precedence over its family.
+// A nil BootFiles overrides nothing.
+type BootFiles map[string]string
+
+// ParseBootFiles returns the BootFiles of m, as configured, and an error if
+// a key names neither a family nor an architecture type.
+func ParseBootFiles(m map[string]string) (BootFiles, error) {
+	families := slices.Compact(slices.Sorted(maps.Values(archFamilies)))
+	b := make(BootFiles, len(m))
+	for key, file := range m {
+		key = strings.ToLower(key)
+		if _, err := strconv.ParseUint(key, 10, 16); err != nil &&
+			!slices.Contains(families, key) {
+			return nil, fmt.Errorf("unknown architecture %q in boot files, want one of %s "+
+				"or an option 93 architecture type", key, strings.Join(families, ", "))
+		}
+		if file == "" {
+			return nil, fmt.Errorf("empty boot file for architecture %q", key)
+		}
+		b[key] = file
+	}
+
+	return b, nil
+}
+
+// File returns the boot file of a: its override, if any, and its entry in
+// ArchToBootFile otherwise. It returns "" for architectures without a boot
+// file.
+func (b BootFiles) File(a iana.Arch) string {
+	if file, ok := b[strconv.Itoa(int(a))]; ok {
+		return file
+	}
+	if file, ok := b[archFamilies[a]]; ok && archFamilies[a] != "" {
+		return file
+	}
+
+	return ArchToBootFile[a]
+}
@@ -0,0 +1,53 @@
+package dhcp
+
+import (
+	"testing"
+
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+func TestBootFiles(t *testing.T) {
+	var defaults BootFiles
+	for arch, want := range map[iana.Arch]string{
+		iana.INTEL_X86PC:     "undionly.kpxe",
+		iana.EFI_X86_64:      "ipxe.efi",
+		iana.EFI_X86_64_HTTP: "ipxe.efi",
+		iana.EFI_ARM64:       "snp.efi",
+		iana.Arch(41):        "snp.efi",
+		iana.Arch(255):       "",
+	} {
+		if got := defaults.File(arch); got != want {
+			t.Errorf("File(%s) without overrides = %q, want %q", arch, got, want)
+		}
+	}
+
+	b, err := ParseBootFiles(map[string]string{
+		"BIOS":      "ipxe.pxe",
+		"arm64_efi": "ipxe-arm64.efi",
+		"16":        "ipxe-http.efi",
+	})
+	if err != nil {
+		t.Fatalf("ParseBootFiles() error = %v", err)
+	}
+	for arch, want := range map[iana.Arch]string{
+		iana.INTEL_X86PC:     "ipxe.pxe",
+		iana.EFI_X86_64:      "ipxe.efi",
+		iana.EFI_X86_64_HTTP: "ipxe-http.efi",
+		iana.EFI_ARM64_HTTP:  "ipxe-arm64.efi",
+		iana.Arch(41):        "snp.efi",
+		iana.Arch(255):       "",
+	} {
+		if got := b.File(arch); got != want {
+			t.Errorf("File(%s) = %q, want %q", arch, got, want)
+		}
+	}
+
+	for _, m := range []map[string]string{
+		{"x86": "ipxe.efi"},
+		{"bios": ""},
+	} {
+		if _, err := ParseBootFiles(m); err == nil {
+			t.Errorf("ParseBootFiles(%v) succeeded, want an error", m)
+		}
+	}
+}
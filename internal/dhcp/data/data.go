@@ -8,6 +8,7 @@ import (
 	"strings"
 
 	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
 	"go.opentelemetry.io/otel/attribute"
 )
 
@@ -21,6 +22,16 @@ type Packet struct {
 	Md *Metadata
 }
 
+// Packet6 holds the data that is passed to a DHCPv6 handler.
+type Packet6 struct {
+	// Peer is the address of the client or relay agent that sent the message.
+	Peer net.Addr
+	// Pkt is the DHCPv6 message, a relay message if it came through a relay.
+	Pkt dhcpv6.DHCPv6
+	// Md is the metadata that was passed to the DHCPv6 server.
+	Md *Metadata
+}
+
 // Metadata holds metadata about the DHCP packet that was received.
 type Metadata struct {
 	// IfName is the name of the interface that the DHCP message was received on.
@@ -46,7 +57,17 @@ type DHCP struct {
 	LeaseTime        uint32           // DHCP option 51.
 	Arch             string           // DHCP option 93.
 	DomainSearch     []string         // DHCP option 119.
+	Options          []Option         // Additional options, already encoded.
 	Disabled         bool             // If true, no DHCP response should be sent.
+	IPv6Address      netip.Addr       // DHCPv6 IA_NA address (option 5).
+	IPv6NameServers  []net.IP         // DHCPv6 option 23.
+}
+
+// Option is an additional DHCP option in wire format, for options that have no
+// dedicated field in DHCP.
+type Option struct {
+	Code  uint8
+	Value []byte
 }
 
 // Netboot holds info used in netbooting a client.
@@ -57,6 +78,20 @@ type Netboot struct {
 	Console       string   `yaml:"console,omitempty"`
 	Facility      string   `yaml:"facility,omitempty"`
 	OSIE          OSIE     `yaml:"osie,omitempty"`
+	Power         Power    `yaml:"power,omitempty"`
+}
+
+// Power selects the power driver of a machine and tells it how to reach the
+// machine's power control.
+type Power struct {
+	// Driver names a registered power driver, such as "unifi" or "tasmota".
+	// Empty selects the default driver.
+	Driver string `yaml:"driver,omitempty"`
+	// Address is the driver specific address of the power control, such as
+	// the URL of a BMC or of a relay.
+	Address string `yaml:"address,omitempty"`
+	// Options holds further driver specific settings.
+	Options map[string]string `yaml:"options,omitempty"`
 }
 
 // OSIE or OS Installation Environment is the data about where the OSIE parts are located.
@@ -121,6 +156,11 @@ func (d *DHCP) EncodeToAttributes() []attribute.KeyValue {
 		ba = d.BroadcastAddress.String()
 	}
 
+	var ip6 string
+	if d.IPv6Address.IsValid() {
+		ip6 = d.IPv6Address.String()
+	}
+
 	return []attribute.KeyValue{
 		attribute.String("DHCP.MACAddress", d.MACAddress.String()),
 		attribute.String("DHCP.IPAddress", ip),
@@ -133,6 +173,7 @@ func (d *DHCP) EncodeToAttributes() []attribute.KeyValue {
 		attribute.String("DHCP.NTPServers", strings.Join(ntp, ",")),
 		attribute.Int64("DHCP.LeaseTime", int64(d.LeaseTime)),
 		attribute.String("DHCP.DomainSearch", strings.Join(d.DomainSearch, ",")),
+		attribute.String("DHCP.IPv6Address", ip6),
 	}
 }
 
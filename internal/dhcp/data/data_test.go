@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/insomniacslk/dhcp/dhcpv4"
 	"go.opentelemetry.io/otel/attribute"
 )
 
@@ -29,6 +30,7 @@ func TestDHCPEncodeToAttributes(t *testing.T) {
 				attribute.String("DHCP.NTPServers", ""),
 				attribute.Int64("DHCP.LeaseTime", 0),
 				attribute.String("DHCP.DomainSearch", ""),
+				attribute.String("DHCP.IPv6Address", ""),
 			},
 		},
 		"successful encode of populated DHCP struct": {
@@ -44,6 +46,7 @@ func TestDHCPEncodeToAttributes(t *testing.T) {
 				NTPServers:       []net.IP{{132, 163, 96, 2}},
 				LeaseTime:        86400,
 				DomainSearch:     []string{"example.com", "example.org"},
+				IPv6Address:      netip.MustParseAddr("2001:db8::150"),
 			},
 			want: []attribute.KeyValue{
 				attribute.String("DHCP.MACAddress", "00:01:02:03:04:05"),
@@ -57,6 +60,7 @@ func TestDHCPEncodeToAttributes(t *testing.T) {
 				attribute.String("DHCP.NTPServers", "132.163.96.2"),
 				attribute.Int64("DHCP.LeaseTime", 86400),
 				attribute.String("DHCP.DomainSearch", "example.com,example.org"),
+				attribute.String("DHCP.IPv6Address", "2001:db8::150"),
 			},
 		},
 	}
@@ -106,3 +110,45 @@ func TestNetbootEncodeToAttributes(t *testing.T) {
 		})
 	}
 }
+
+func TestRelayFromPacket(t *testing.T) {
+	pkt, err := dhcpv4.New(
+		dhcpv4.WithGatewayIP(net.IPv4(10, 20, 0, 1)),
+		dhcpv4.WithOption(dhcpv4.OptRelayAgentInfo(
+			dhcpv4.OptGeneric(dhcpv4.AgentCircuitIDSubOption, []byte("sw1:Gi1/0/7")),
+			dhcpv4.OptGeneric(dhcpv4.AgentRemoteIDSubOption, []byte("sw1")),
+			dhcpv4.OptGeneric(dhcpv4.LinkSelectionSubOption, []byte{10, 30, 0, 0}),
+		)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, ok := RelayFromPacket(pkt)
+	want := Relay{
+		GatewayIP:     netip.MustParseAddr("10.20.0.1"),
+		LinkSelection: netip.MustParseAddr("10.30.0.0"),
+		CircuitID:     "sw1:Gi1/0/7",
+		RemoteID:      "sw1",
+	}
+	if !ok || r != want {
+		t.Errorf("RelayFromPacket() = %+v, %v, want %+v", r, ok, want)
+	}
+	if got := r.Link(); got != want.LinkSelection {
+		t.Errorf("Link() = %s, want the link selection address %s", got, want.LinkSelection)
+	}
+	wantValues := []any{
+		"giaddr", "10.20.0.1", "linkSelection", "10.30.0.0", "circuitID", "sw1:Gi1/0/7", "remoteID", "sw1",
+	}
+	if diff := cmp.Diff(wantValues, r.LogValues()); diff != "" {
+		t.Errorf("LogValues() mismatch (-want +got):\n%s", diff)
+	}
+
+	direct, err := dhcpv4.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, ok := RelayFromPacket(direct); ok {
+		t.Errorf("RelayFromPacket() of a direct packet = %+v, want none", r)
+	}
+}
@@ -0,0 +1,87 @@
+package data
+
+import (
+	"context"
+	"net/netip"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// Relay describes the DHCP relay agent a DHCPv4 packet came through.
+type Relay struct {
+	// GatewayIP is the giaddr of the packet, the address of the relay agent
+	// on the network of the client.
+	GatewayIP netip.Addr
+	// LinkSelection is the address of the network of the client the relay
+	// agent selected with the link selection sub-option (RFC 3527) of option
+	// 82, if it sent one. It takes precedence over GatewayIP.
+	LinkSelection netip.Addr
+	// CircuitID and RemoteID are the agent circuit ID and remote ID
+	// sub-options of option 82, such as the switch port and the switch the
+	// client is connected to.
+	CircuitID string
+	RemoteID  string
+}
+
+// Link returns the address that identifies the network of the client: the
+// link selection address if the relay agent sent one, else GatewayIP.
+func (r Relay) Link() netip.Addr {
+	if r.LinkSelection.IsValid() {
+		return r.LinkSelection
+	}
+
+	return r.GatewayIP
+}
+
+// LogValues returns the key/value pairs that identify r in logs.
+func (r Relay) LogValues() []any {
+	var values []any
+	if r.GatewayIP.IsValid() {
+		values = append(values, "giaddr", r.GatewayIP.String())
+	}
+	if r.LinkSelection.IsValid() {
+		values = append(values, "linkSelection", r.LinkSelection.String())
+	}
+	if r.CircuitID != "" {
+		values = append(values, "circuitID", r.CircuitID)
+	}
+	if r.RemoteID != "" {
+		values = append(values, "remoteID", r.RemoteID)
+	}
+
+	return values
+}
+
+// RelayFromPacket returns the relay agent of pkt, and false if pkt was not
+// relayed.
+func RelayFromPacket(pkt *dhcpv4.DHCPv4) (Relay, bool) {
+	var r Relay
+	if gw, ok := netip.AddrFromSlice(pkt.GatewayIPAddr.To4()); ok && !gw.IsUnspecified() {
+		r.GatewayIP = gw
+	}
+	if info := pkt.RelayAgentInfo(); info != nil {
+		r.CircuitID = string(info.Get(dhcpv4.AgentCircuitIDSubOption))
+		r.RemoteID = string(info.Get(dhcpv4.AgentRemoteIDSubOption))
+		if ls, ok := netip.AddrFromSlice(info.Get(dhcpv4.LinkSelectionSubOption)); ok && ls.Is4() {
+			r.LinkSelection = ls
+		}
+	}
+
+	return r, r.GatewayIP.IsValid() || r.LinkSelection.IsValid() ||
+		r.CircuitID != "" || r.RemoteID != ""
+}
+
+type relayKey struct{}
+
+// WithRelay returns a copy of ctx carrying the relay agent of the packet
+// being handled, so that backends can tell the network of the client.
+func WithRelay(ctx context.Context, r Relay) context.Context {
+	return context.WithValue(ctx, relayKey{}, r)
+}
+
+// RelayFromContext returns the relay agent carried by ctx, and false if the
+// packet being handled was not relayed.
+func RelayFromContext(ctx context.Context) (Relay, bool) {
+	r, ok := ctx.Value(relayKey{}).(Relay)
+	return r, ok
+}
@@ -1,16 +1,21 @@
 package dhcp
 
 import (
+	"context"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"net"
 	"net/netip"
 	"net/url"
+	"regexp"
 	"strings"
 
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/iana"
+	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+	"github.com/metal3-community/metal-boot/internal/dhcp/fingerprint"
+	"github.com/metal3-community/metal-boot/internal/hoststate"
 	"github.com/metal3-community/metal-boot/internal/util"
 )
 
@@ -40,6 +45,72 @@ type UserClass string
 // ClientType is from DHCP option 60. Normally only PXEClient or HTTPClient.
 type ClientType string
 
+// BootTracker records netboot attempts per client so that clients stuck in a
+// PXE loop can have netboot options withheld.
+type BootTracker interface {
+	// RecordDiscover registers a netboot DHCPDISCOVER and reports whether
+	// netboot options should be withheld from the client.
+	RecordDiscover(mac net.HardwareAddr) bool
+	// NetbootWithheld reports whether netboot options are withheld from the
+	// client without recording an attempt.
+	NetbootWithheld(mac net.HardwareAddr) bool
+}
+
+// BootFlow follows clients along the boot flow as they netboot.
+type BootFlow interface {
+	// Discovered records a DHCPDISCOVER of a netboot client and whether it
+	// was offered netboot options.
+	Discovered(mac net.HardwareAddr, netboot bool)
+}
+
+// NetbootGate withholds netboot options from clients that must boot from
+// their own disk, such as hosts that finished provisioning.
+type NetbootGate interface {
+	NetbootDisabled(mac net.HardwareAddr) bool
+}
+
+// HostOptions supplies extra DHCP options declared for a client outside the
+// backend, such as through the admin API.
+type HostOptions interface {
+	EncodeDHCPOptions(mac net.HardwareAddr) []data.Option
+}
+
+// Admission staggers netboot offers during boot storms.
+type Admission interface {
+	// Admit waits until the client may be offered netboot options and
+	// reports whether it may. Clients that are not admitted get no reply and
+	// compete again with their next DHCPDISCOVER or SOLICIT.
+	Admit(ctx context.Context, mac net.HardwareAddr) bool
+}
+
+// MachineIDRecorder stores the machine UUID that a client sent in DHCP
+// option 97.
+type MachineIDRecorder interface {
+	RecordUUID(mac net.HardwareAddr, uuid string) error
+}
+
+// IdentityLinker links the MAC addresses of clients to the hosts they belong
+// to, by the identifiers they sent, such as their machine UUID, client
+// identifier, switch port or serial number.
+type IdentityLinker interface {
+	Link(mac net.HardwareAddr, ids hoststate.Identifiers) (net.HardwareAddr, error)
+}
+
+// ClientRecorder stores what the DHCP fingerprint of a client revealed about
+// the software that sent it.
+type ClientRecorder interface {
+	RecordDHCPClient(mac net.HardwareAddr, c hoststate.DHCPClient) error
+}
+
+// StatsRecorder counts the replies of a DHCP server for its statistics.
+type StatsRecorder interface {
+	// RecordReply records a reply sent to a client.
+	RecordReply(mt dhcpv4.MessageType)
+	// RecordUnknownDiscover records a DHCPDISCOVER from a client without a
+	// reservation.
+	RecordUnknownDiscover()
+}
+
 // ArchToBootFile maps supported hardware PXE architectures types to iPXE binary files.
 var ArchToBootFile = map[iana.Arch]string{
 	iana.INTEL_X86PC:       "undionly.kpxe",
@@ -249,6 +320,114 @@ func IsNetbootClient(pkt *dhcpv4.DHCPv4) error {
 	return err
 }
 
+// MachineUUID returns the client machine identifier of DHCP option 97 in the
+// lowercase RFC 4122 text form, or "" if the option is absent or malformed.
+//
+// PXE firmware copies the SMBIOS system UUID into the option as it is stored
+// in memory, with the first three fields little-endian, so they are swapped to
+// match the UUID that the operating system and the BMC report.
+//
+// See: https://www.rfc-editor.org/rfc/rfc4578.html#section-2.3
+func MachineUUID(pkt *dhcpv4.DHCPv4) string {
+	guid := pkt.GetOneOption(dhcpv4.OptionClientMachineIdentifier)
+	if len(guid) != 17 || guid[0] != 0 {
+		return ""
+	}
+	b := guid[1:]
+	// A zero UUID means the firmware does not know the machine UUID.
+	if strings.Trim(hex.EncodeToString(b), "0") == "" {
+		return ""
+	}
+
+	return fmt.Sprintf("%02x%02x%02x%02x-%02x%02x-%02x%02x-%x-%x",
+		b[3], b[2], b[1], b[0], b[5], b[4], b[7], b[6], b[8:10], b[10:])
+}
+
+// RecordMachineUUID passes the machine UUID of pkt, if it has one, to r. A nil
+// r is a no-op.
+func RecordMachineUUID(r MachineIDRecorder, pkt *dhcpv4.DHCPv4) error {
+	if r == nil {
+		return nil
+	}
+	uuid := MachineUUID(pkt)
+	if uuid == "" {
+		return nil
+	}
+
+	return r.RecordUUID(pkt.ClientHWAddr, uuid)
+}
+
+// ClientID returns the client identifier of DHCP option 61 in lowercase
+// hexadecimal, or "" if the option is absent.
+func ClientID(pkt *dhcpv4.DHCPv4) string {
+	return hex.EncodeToString(pkt.GetOneOption(dhcpv4.OptionClientIdentifier))
+}
+
+// rpiSerial matches a Raspberry Pi serial number announced as "serial=" or
+// "sn=", in its 8 digit form or the 16 digit form of /proc/cpuinfo.
+var rpiSerial = regexp.MustCompile(`(?i)\b(?:serial|sn)[=:]\s*([0-9a-f]{16}|[0-9a-f]{8})\b`)
+
+// RPiSerial returns the Raspberry Pi serial number a client announced in its
+// vendor class (option 60) or user class (option 77), such as
+// "PXEClient:Arch:00000:UNDI:002001:serial=e4a3c2d1", as its last 8
+// lowercase hexadecimal digits, or "" if it announced none. The MAC address
+// of the client is not checked, as a Pi may boot from a USB NIC.
+func RPiSerial(pkt *dhcpv4.DHCPv4) string {
+	for _, opt := range []dhcpv4.OptionCode{
+		dhcpv4.OptionClassIdentifier,
+		dhcpv4.OptionUserClassInformation,
+	} {
+		if m := rpiSerial.FindSubmatch(pkt.GetOneOption(opt)); m != nil {
+			serial := strings.ToLower(string(m[1]))
+			return serial[len(serial)-8:]
+		}
+	}
+
+	return ""
+}
+
+// Identifiers returns what the client of pkt sent that may identify it apart
+// from its MAC address.
+func Identifiers(pkt *dhcpv4.DHCPv4) hoststate.Identifiers {
+	ids := hoststate.Identifiers{
+		UUID:     MachineUUID(pkt),
+		ClientID: ClientID(pkt),
+		Serial:   RPiSerial(pkt),
+	}
+	if relay, ok := data.RelayFromPacket(pkt); ok {
+		ids.CircuitID = relay.CircuitID
+	}
+
+	return ids
+}
+
+// LinkIdentity passes the MAC address and identifiers of pkt to l. A nil l
+// is a no-op.
+func LinkIdentity(l IdentityLinker, pkt *dhcpv4.DHCPv4) error {
+	if l == nil {
+		return nil
+	}
+	_, err := l.Link(pkt.ClientHWAddr, Identifiers(pkt))
+
+	return err
+}
+
+// RecordClient passes the fingerprint of pkt to r. A nil r is a no-op.
+func RecordClient(r ClientRecorder, pkt *dhcpv4.DHCPv4) error {
+	if r == nil {
+		return nil
+	}
+	f := fingerprint.Of(pkt)
+
+	return r.RecordDHCPClient(pkt.ClientHWAddr, hoststate.DHCPClient{
+		Environment:      f.Environment(),
+		VendorClass:      f.VendorClass,
+		UserClass:        f.UserClass,
+		ParamRequestList: f.ParamRequestList,
+		Netboot:          IsNetbootClient(pkt) == nil,
+	})
+}
+
 func wrapNonNil(err error, format string) error {
 	if err == nil {
 		return errors.New(format)
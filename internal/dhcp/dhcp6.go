@@ -0,0 +1,256 @@
+package dhcp
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/metal3-community/metal-boot/internal/util"
+)
+
+// PXEEnterpriseNumber is the IANA enterprise number that UEFI firmware uses in
+// the PXEClient and HTTPClient vendor classes of DHCPv6 option 16.
+const PXEEnterpriseNumber = 343
+
+// Info6 holds details about a DHCPv6 request. Use NewInfo6 to populate the
+// struct fields from a DHCPv6 packet.
+type Info6 struct {
+	// Pkt is the packet that was received, a relay message if it came
+	// through relay agents.
+	Pkt dhcpv6.DHCPv6
+	// Msg is the client message, decapsulated from any relay messages.
+	Msg *dhcpv6.Message
+	// Arch is the architecture of the client from option 61.
+	Arch iana.Arch
+	// Mac is the mac address of the client. DHCPv6 clients are not required
+	// to send it, so it is taken from the relay agent (option 79), the client
+	// DUID or the EUI-64 interface identifier of its link-local address, in
+	// that order. It is nil if none of them has it.
+	Mac net.HardwareAddr
+	// UserClass is the first user class of the client from option 15.
+	UserClass UserClass
+	// ClientType is the client type from the vendor class (option 16).
+	ClientType ClientType
+	// IsNetbootClient is nil if the client is a valid netboot client.
+	IsNetbootClient error
+	// IPXEBinary is the iPXE binary file to boot.
+	IPXEBinary string
+}
+
+// NewInfo6 returns the details of pkt, which was received from peer. It
+// returns an error if pkt holds no client message.
+func NewInfo6(pkt dhcpv6.DHCPv6, peer net.Addr) (Info6, error) {
+	msg, err := pkt.GetInnerMessage()
+	if err != nil {
+		return Info6{}, fmt.Errorf("no client message in DHCPv6 packet: %w", err)
+	}
+
+	i := Info6{Pkt: pkt, Msg: msg}
+	i.Mac = ClientMAC6(pkt, peer)
+	i.Arch = Arch6(msg, i.Mac)
+	if uc := msg.Options.UserClasses(); len(uc) > 0 {
+		i.UserClass = UserClass(uc[0])
+	}
+	i.ClientType = clientType6(msg, i.Arch)
+	i.IsNetbootClient = IsNetbootClient6(msg)
+	if bin, found := ArchToBootFile[i.Arch]; found {
+		i.IPXEBinary = bin
+	}
+
+	return i, nil
+}
+
+// ClientMAC6 returns the mac address of the client that sent pkt, or nil if
+// it cannot be determined.
+//
+// See: https://www.rfc-editor.org/rfc/rfc6939.html
+func ClientMAC6(pkt dhcpv6.DHCPv6, peer net.Addr) net.HardwareAddr {
+	linkLocal := peerIP(peer)
+
+	// The relay agent closest to the client knows its mac address.
+	if relay, ok := pkt.(*dhcpv6.RelayMessage); ok {
+		inner := relay
+		for {
+			next, ok := inner.Options.RelayMessage().(*dhcpv6.RelayMessage)
+			if !ok {
+				break
+			}
+			inner = next
+		}
+		if _, mac := inner.Options.ClientLinkLayerAddress(); len(mac) == 6 {
+			return mac
+		}
+		linkLocal = inner.PeerAddr
+	}
+
+	if msg, err := pkt.GetInnerMessage(); err == nil {
+		switch duid := msg.Options.ClientID().(type) {
+		case *dhcpv6.DUIDLL:
+			if duid.HWType == iana.HWTypeEthernet && len(duid.LinkLayerAddr) == 6 {
+				return duid.LinkLayerAddr
+			}
+		case *dhcpv6.DUIDLLT:
+			if duid.HWType == iana.HWTypeEthernet && len(duid.LinkLayerAddr) == 6 {
+				return duid.LinkLayerAddr
+			}
+		}
+	}
+
+	return eui64MAC(linkLocal)
+}
+
+// eui64MAC returns the mac address encoded in the modified EUI-64 interface
+// identifier of the link-local address ip, or nil if it has none.
+func eui64MAC(ip net.IP) net.HardwareAddr {
+	if ip == nil || ip.To4() != nil || !ip.IsLinkLocalUnicast() {
+		return nil
+	}
+	id := ip.To16()[8:]
+	if id[3] != 0xff || id[4] != 0xfe {
+		return nil
+	}
+
+	return net.HardwareAddr{id[0] ^ 0x02, id[1], id[2], id[5], id[6], id[7]}
+}
+
+func peerIP(peer net.Addr) net.IP {
+	if u, ok := peer.(*net.UDPAddr); ok {
+		return u.IP
+	}
+
+	return nil
+}
+
+// Arch6 returns the Arch of the client pulled from DHCPv6 option 61.
+func Arch6(msg *dhcpv6.Message, mac net.HardwareAddr) iana.Arch {
+	for _, a := range msg.Options.ArchTypes() {
+		if strings.Contains(a.String(), "unknown") {
+			continue
+		}
+		if a == iana.INTEL_X86PC && util.IsRaspberryPI(mac) {
+			return iana.Arch(41)
+		}
+		return a
+	}
+	if util.IsRaspberryPI(mac) {
+		return iana.Arch(41)
+	}
+
+	return iana.Arch(255) // unknown arch
+}
+
+// clientType6 returns HTTPClient if the vendor class or the architecture
+// of the client is for UEFI HTTP boot, and PXEClient otherwise.
+func clientType6(msg *dhcpv6.Message, arch iana.Arch) ClientType {
+	for _, vc := range msg.Options.VendorClasses() {
+		for _, d := range vc.Data {
+			if strings.HasPrefix(string(d), HTTPClient.String()) {
+				return HTTPClient
+			}
+		}
+	}
+	switch arch {
+	case iana.EFI_X86_HTTP, iana.EFI_X86_64_HTTP, iana.EFI_ARM32_HTTP, iana.EFI_ARM64_HTTP:
+		return HTTPClient
+	}
+
+	return PXEClient
+}
+
+// IsNetbootClient6 returns nil if msg is from a valid netboot client.
+// Otherwise it returns an error.
+//
+// A valid netboot client will have the following in its DHCPv6 message:
+// 1. is a Solicit, Request, Renew, Rebind or Information-request message.
+// 2. option 59 (boot file URL) is requested in option 6.
+// 3. option 61 is set.
+//
+// See: https://www.rfc-editor.org/rfc/rfc5970.html
+func IsNetbootClient6(msg *dhcpv6.Message) error {
+	var err error
+	switch msg.Type() {
+	case dhcpv6.MessageTypeSolicit, dhcpv6.MessageTypeRequest, dhcpv6.MessageTypeRenew,
+		dhcpv6.MessageTypeRebind, dhcpv6.MessageTypeInformationRequest:
+	default:
+		err = wrapNonNil(err,
+			"message type must be Solicit, Request, Renew, Rebind or Information-request")
+	}
+	if !msg.IsOptionRequested(dhcpv6.OptionBootfileURL) {
+		err = wrapNonNil(err, "option 59 not requested")
+	}
+	if len(msg.Options.ArchTypes()) == 0 {
+		err = wrapNonNil(err, "option 61 not set")
+	}
+
+	return err
+}
+
+// BootFileURL returns the DHCPv6 option 59 value. Unlike the DHCPv4 boot file
+// it is always a URL, so the binary servers must be on an address the IPv6
+// client can reach. It returns "" if there is nothing to boot.
+//
+// See: https://www.rfc-editor.org/rfc/rfc5970.html#section-3.1
+func (i Info6) BootFileURL(
+	customUC UserClass,
+	ipxeScript, ipxeHTTPBinServer *url.URL,
+	ipxeTFTPBinServer netip.AddrPort,
+) string {
+	paths := []string{i.IPXEBinary}
+	if i.Mac != nil {
+		paths = append([]string{strings.ReplaceAll(i.Mac.String(), ":", "-")}, paths...)
+	}
+
+	switch { // order matters here.
+	case i.UserClass == Ironic, customUC != "" && i.UserClass == customUC:
+		// this case gets us out of an ipxe boot loop.
+		if ipxeScript != nil {
+			return ipxeScript.String()
+		}
+	case i.UserClass == IPXE:
+		if ipxeTFTPBinServer.IsValid() {
+			return fmt.Sprintf("tftp://%s/%s",
+				ipxeTFTPBinServer.String(), strings.Join(paths, "/"))
+		}
+	case i.ClientType == HTTPClient:
+		if ipxeHTTPBinServer != nil {
+			return ipxeHTTPBinServer.JoinPath(paths...).String()
+		}
+	default:
+		if ipxeTFTPBinServer.IsValid() {
+			return fmt.Sprintf("tftp://%s/%s", ipxeTFTPBinServer.String(), i.IPXEBinary)
+		}
+	}
+
+	return ""
+}
+
+// VendorClass6 returns the DHCPv6 vendor class option that identifies the
+// server as c. UEFI HTTP boot only accepts offers that identify as HTTPClient.
+func VendorClass6(c ClientType) *dhcpv6.OptVendorClass {
+	return &dhcpv6.OptVendorClass{
+		EnterpriseNumber: PXEEnterpriseNumber,
+		Data:             [][]byte{[]byte(c)},
+	}
+}
+
+// ForServer6 reports whether msg is for the server identified by duid:
+// messages that name a server in option 2 are only answered by that server.
+func ForServer6(msg *dhcpv6.Message, duid dhcpv6.DUID) bool {
+	sid := msg.Options.ServerID()
+
+	return sid == nil || duid != nil && sid.Equal(duid)
+}
+
+// Reply6 returns reply ready to be sent back to the sender of req. Replies to
+// relayed requests are wrapped in Relay-reply messages.
+func Reply6(req dhcpv6.DHCPv6, reply *dhcpv6.Message) (dhcpv6.DHCPv6, error) {
+	if relay, ok := req.(*dhcpv6.RelayMessage); ok {
+		return dhcpv6.NewRelayReplFromRelayForw(relay, reply)
+	}
+
+	return reply, nil
+}
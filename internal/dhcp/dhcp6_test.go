@@ -0,0 +1,135 @@
+package dhcp
+
+import (
+	"net"
+	"net/netip"
+	"net/url"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+func solicit6(t *testing.T, arch iana.Arch, mods ...dhcpv6.Modifier) *dhcpv6.Message {
+	t.Helper()
+	msg, err := dhcpv6.NewMessage(append([]dhcpv6.Modifier{
+		dhcpv6.WithClientID(&dhcpv6.DUIDUUID{UUID: [16]byte{1, 2, 3}}),
+		dhcpv6.WithRequestedOptions(dhcpv6.OptionBootfileURL),
+		dhcpv6.WithArchType(arch),
+	}, mods...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.MessageType = dhcpv6.MessageTypeSolicit
+
+	return msg
+}
+
+func TestClientMAC6(t *testing.T) {
+	mac := net.HardwareAddr{0x52, 0x54, 0x00, 0xaa, 0x88, 0x2a}
+	linkLocal := &net.UDPAddr{IP: net.ParseIP("fe80::5054:ff:feaa:882a"), Port: 546}
+
+	tests := map[string]struct {
+		pkt  func(t *testing.T) dhcpv6.DHCPv6
+		peer net.Addr
+		want net.HardwareAddr
+	}{
+		"from the link-local address": {
+			pkt:  func(t *testing.T) dhcpv6.DHCPv6 { return solicit6(t, iana.EFI_ARM64) },
+			peer: linkLocal,
+			want: mac,
+		},
+		"from the DUID": {
+			pkt: func(t *testing.T) dhcpv6.DHCPv6 {
+				return solicit6(t, iana.EFI_ARM64, dhcpv6.WithClientID(&dhcpv6.DUIDLL{
+					HWType:        iana.HWTypeEthernet,
+					LinkLayerAddr: mac,
+				}))
+			},
+			peer: &net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: 546},
+			want: mac,
+		},
+		"from the relay agent": {
+			pkt: func(t *testing.T) dhcpv6.DHCPv6 {
+				relay, err := dhcpv6.EncapsulateRelay(
+					solicit6(t, iana.EFI_ARM64),
+					dhcpv6.MessageTypeRelayForward,
+					net.ParseIP("2001:db8::1"),
+					net.ParseIP("fe80::1"),
+				)
+				if err != nil {
+					t.Fatal(err)
+				}
+				relay.AddOption(dhcpv6.OptClientLinkLayerAddress(iana.HWTypeEthernet, mac))
+				return relay
+			},
+			peer: &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 547},
+			want: mac,
+		},
+		"unknown": {
+			pkt:  func(t *testing.T) dhcpv6.DHCPv6 { return solicit6(t, iana.EFI_ARM64) },
+			peer: &net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: 546},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := ClientMAC6(tt.pkt(t), tt.peer); got.String() != tt.want.String() {
+				t.Errorf("ClientMAC6() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBootFileURL(t *testing.T) {
+	tftp := netip.MustParseAddrPort("[2001:db8::1]:69")
+	http := &url.URL{Scheme: "http", Host: "[2001:db8::1]:8080", Path: "/ipxe"}
+	script := &url.URL{Scheme: "http", Host: "[2001:db8::1]:8080", Path: "/boot.ipxe"}
+	peer := &net.UDPAddr{IP: net.ParseIP("fe80::5054:ff:feaa:882a"), Port: 546}
+
+	tests := map[string]struct {
+		arch iana.Arch
+		mods []dhcpv6.Modifier
+		want string
+	}{
+		"pxe": {arch: iana.EFI_ARM64, want: "tftp://[2001:db8::1]:69/snp.efi"},
+		"http boot": {
+			arch: iana.EFI_ARM64_HTTP,
+			want: "http://[2001:db8::1]:8080/ipxe/52-54-00-aa-88-2a/snp.efi",
+		},
+		"ironic": {
+			arch: iana.EFI_ARM64,
+			mods: []dhcpv6.Modifier{dhcpv6.WithUserClass([]byte(Ironic))},
+			want: script.String(),
+		},
+		"ipxe": {
+			arch: iana.EFI_ARM64,
+			mods: []dhcpv6.Modifier{dhcpv6.WithUserClass([]byte(IPXE))},
+			want: "tftp://[2001:db8::1]:69/52-54-00-aa-88-2a/snp.efi",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			i, err := NewInfo6(solicit6(t, tt.arch, tt.mods...), peer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if i.IsNetbootClient != nil {
+				t.Fatalf("IsNetbootClient = %v", i.IsNetbootClient)
+			}
+			if got := i.BootFileURL("", script, http, tftp); got != tt.want {
+				t.Errorf("BootFileURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsNetbootClient6(t *testing.T) {
+	msg, err := dhcpv6.NewMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.MessageType = dhcpv6.MessageTypeSolicit
+	if IsNetbootClient6(msg) == nil {
+		t.Error("IsNetbootClient6() of a solicit without options 6 and 61 = nil")
+	}
+}
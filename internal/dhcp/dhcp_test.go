@@ -1,6 +1,7 @@
 package dhcp
 
 import (
+	"bytes"
 	"encoding/hex"
 	"errors"
 	"net"
@@ -12,6 +13,7 @@ import (
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/iana"
+	"github.com/metal3-community/metal-boot/internal/hoststate"
 	"github.com/metal3-community/metal-boot/internal/util"
 )
 
@@ -433,3 +435,124 @@ func TestIsRaspberryPI(t *testing.T) {
 		})
 	}
 }
+
+func TestMachineUUID(t *testing.T) {
+	guid := []byte{
+		0x00,
+		0x33, 0x22, 0x11, 0x00, 0x55, 0x44, 0x77, 0x66,
+		0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff,
+	}
+	tests := map[string]struct {
+		opt  []byte
+		want string
+	}{
+		"smbios order": {opt: guid, want: "00112233-4455-6677-8899-aabbccddeeff"},
+		"missing":      {},
+		"wrong length": {opt: guid[:9]},
+		"wrong type":   {opt: append([]byte{0x01}, guid[1:]...)},
+		"zero uuid":    {opt: make([]byte, 17)},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			pkt, _ := dhcpv4.New()
+			if tt.opt != nil {
+				pkt.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionClientMachineIdentifier, tt.opt))
+			}
+			if diff := cmp.Diff(tt.want, MachineUUID(pkt)); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestReplyDestination(t *testing.T) {
+	relayInfo := dhcpv4.OptRelayAgentInfo(
+		dhcpv4.OptGeneric(dhcpv4.AgentCircuitIDSubOption, []byte("sw1:Gi1/0/7")),
+		dhcpv4.OptGeneric(dhcpv4.LinkSelectionSubOption, []byte{10, 30, 0, 0}),
+	)
+	tests := map[string]struct {
+		mods []dhcpv4.Modifier
+		want string
+	}{
+		"relayed": {
+			mods: []dhcpv4.Modifier{dhcpv4.WithGatewayIP(net.IPv4(10, 20, 0, 1)), dhcpv4.WithOption(relayInfo)},
+			want: "10.20.0.1:67",
+		},
+		"relayed broadcast": {
+			mods: []dhcpv4.Modifier{dhcpv4.WithGatewayIP(net.IPv4(10, 20, 0, 1)), dhcpv4.WithBroadcast(true)},
+			want: "10.20.0.1:67",
+		},
+		"renewing": {
+			mods: []dhcpv4.Modifier{dhcpv4.WithClientIP(net.IPv4(192, 168, 1, 50)), dhcpv4.WithBroadcast(true)},
+			want: "192.168.1.50:68",
+		},
+		"broadcast": {
+			mods: []dhcpv4.Modifier{dhcpv4.WithBroadcast(true)},
+			want: "255.255.255.255:68",
+		},
+		"unicast": {
+			want: "192.168.1.100:68",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			pkt, err := dhcpv4.New(tt.mods...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reply, err := dhcpv4.NewReplyFromRequest(pkt, dhcpv4.WithYourIP(net.IPv4(192, 168, 1, 100)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ReplyDestination(pkt, reply).String(); got != tt.want {
+				t.Errorf("ReplyDestination() = %s, want %s", got, tt.want)
+			}
+			// Relayed replies echo the relay agent information unchanged.
+			if got, want := reply.Options.Get(dhcpv4.OptionRelayAgentInformation),
+				pkt.Options.Get(dhcpv4.OptionRelayAgentInformation); !bytes.Equal(got, want) {
+				t.Errorf("reply option 82 = %x, want %x", got, want)
+			}
+		})
+	}
+}
+
+func TestIdentifiers(t *testing.T) {
+	tests := map[string]struct {
+		mods []dhcpv4.Modifier
+		want hoststate.Identifiers
+	}{
+		"vendor class serial": {
+			mods: []dhcpv4.Modifier{dhcpv4.WithOption(
+				dhcpv4.OptClassIdentifier("PXEClient:Arch:00000:UNDI:002001:serial=E4A3C2D1"))},
+			want: hoststate.Identifiers{Serial: "e4a3c2d1"},
+		},
+		"user class cpuinfo serial": {
+			mods: []dhcpv4.Modifier{dhcpv4.WithOption(dhcpv4.OptRFC3004UserClass([]string{"iPXE", "sn=10000000e4a3c2d1"}))},
+			want: hoststate.Identifiers{Serial: "e4a3c2d1"},
+		},
+		"no serial": {
+			mods: []dhcpv4.Modifier{dhcpv4.WithOption(dhcpv4.OptClassIdentifier(examplePXEClient))},
+		},
+		"relayed with client identifier": {
+			mods: []dhcpv4.Modifier{
+				dhcpv4.WithGatewayIP(net.IPv4(10, 20, 0, 1)),
+				dhcpv4.WithOption(dhcpv4.OptRelayAgentInfo(
+					dhcpv4.OptGeneric(dhcpv4.AgentCircuitIDSubOption, []byte("sw1:Gi1/0/7")),
+				)),
+				dhcpv4.WithOption(dhcpv4.OptClientIdentifier([]byte{0xff, 0x01, 0x02})),
+			},
+			want: hoststate.Identifiers{ClientID: "ff0102", CircuitID: "sw1:Gi1/0/7"},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			pkt, err := dhcpv4.New(tt.mods...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.want, Identifiers(pkt)); diff != "" {
+				t.Errorf("Identifiers() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+// Package fingerprint guesses which software sent a DHCP packet, such as a
+// PXE ROM, iPXE, the Raspberry Pi bootloader or an installed operating
+// system, from the options it sent. It tells which stage of a boot is
+// talking to the server.
+package fingerprint
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/metal3-community/metal-boot/internal/util"
+)
+
+// Environments reported by Environment.
+const (
+	// IPXE is any iPXE build, including the one chainloaded by metal-boot.
+	IPXE = "ipxe"
+	// UEFIHTTP is UEFI firmware booting over HTTP.
+	UEFIHTTP = "uefi-http"
+	// UEFIPXE is UEFI firmware booting over PXE.
+	UEFIPXE = "uefi-pxe"
+	// BIOSPXE is a legacy BIOS PXE ROM.
+	BIOSPXE = "bios-pxe"
+	// RPiBootloader is the network boot of the Raspberry Pi EEPROM or
+	// bootcode.bin, which identifies as a BIOS PXE client.
+	RPiBootloader = "rpi-bootloader"
+	// Windows is an installed Windows.
+	Windows = "windows"
+	// Android is an installed Android.
+	Android = "android"
+	// Busybox is the udhcpc client of busybox based ramdisks and installers.
+	Busybox = "busybox"
+	// Anaconda is the Fedora and RHEL installer.
+	Anaconda = "anaconda"
+	// Dhcpcd is an installed OS using dhcpcd, such as Raspberry Pi OS.
+	Dhcpcd = "dhcpcd"
+	// Dhclient is an installed OS using ISC dhclient, such as older Debian
+	// and Ubuntu releases.
+	Dhclient = "dhclient"
+	// OS is an installed operating system that no rule matched.
+	OS = "os"
+)
+
+// Fingerprint is what a DHCP client reveals about itself.
+type Fingerprint struct {
+	// ParamRequestList is option 55 as comma separated codes in the order
+	// the client sent them.
+	ParamRequestList string
+	// VendorClass is option 60.
+	VendorClass string
+	// UserClass is the first entry of option 77.
+	UserClass string
+	// MAC is the client hardware address.
+	MAC net.HardwareAddr
+}
+
+// Of returns the fingerprint of pkt.
+func Of(pkt *dhcpv4.DHCPv4) Fingerprint {
+	f := Fingerprint{
+		VendorClass: pkt.ClassIdentifier(),
+		MAC:         pkt.ClientHWAddr,
+	}
+	if uc := pkt.UserClass(); len(uc) > 0 {
+		f.UserClass = uc[0]
+	}
+
+	codes := make([]string, 0, len(pkt.ParameterRequestList()))
+	for _, c := range pkt.ParameterRequestList() {
+		codes = append(codes, strconv.Itoa(int(c.Code())))
+	}
+	f.ParamRequestList = strings.Join(codes, ",")
+
+	return f
+}
+
+// rule matches a fingerprint to an environment. Empty fields match anything.
+type rule struct {
+	env          string
+	userClass    string
+	vendorPrefix string
+	prl          string
+	raspberryPi  bool
+}
+
+func (r rule) matches(f Fingerprint) bool {
+	return (r.userClass == "" || strings.EqualFold(f.UserClass, r.userClass)) &&
+		strings.HasPrefix(f.VendorClass, r.vendorPrefix) &&
+		(r.prl == "" || f.ParamRequestList == r.prl) &&
+		(!r.raspberryPi || util.IsRaspberryPI(f.MAC))
+}
+
+// rules are tried in order; the first match wins. PXE vendor classes carry
+// the client architecture of option 93 as "Arch:<5 digits>".
+var rules = []rule{
+	{env: IPXE, userClass: "iPXE"},
+	{env: IPXE, userClass: "Ironic"},
+	{env: UEFIHTTP, vendorPrefix: "HTTPClient"},
+	{env: RPiBootloader, vendorPrefix: "PXEClient:Arch:00000", raspberryPi: true},
+	{env: BIOSPXE, vendorPrefix: "PXEClient:Arch:00000"},
+	{env: UEFIPXE, vendorPrefix: "PXEClient"},
+	{env: Windows, vendorPrefix: "MSFT"},
+	{env: Android, vendorPrefix: "android-dhcp"},
+	{env: Busybox, vendorPrefix: "udhcp"},
+	{env: Anaconda, vendorPrefix: "anaconda"},
+	{env: Dhcpcd, vendorPrefix: "dhcpcd"},
+	{env: Dhclient, prl: "1,28,2,3,15,6,119,12,44,47,26,121,42"},
+}
+
+// Environment returns the environment that most likely sent the packet, or
+// OS when no rule matches.
+func (f Fingerprint) Environment() string {
+	for _, r := range rules {
+		if r.matches(f) {
+			return r.env
+		}
+	}
+
+	return OS
+}
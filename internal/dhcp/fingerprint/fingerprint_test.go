@@ -0,0 +1,88 @@
+package fingerprint
+
+import (
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+func TestEnvironment(t *testing.T) {
+	var dhclientPRL []dhcpv4.OptionCode
+	for _, c := range []uint8{1, 28, 2, 3, 15, 6, 119, 12, 44, 47, 26, 121, 42} {
+		dhclientPRL = append(dhclientPRL, dhcpv4.GenericOptionCode(c))
+	}
+
+	tests := []struct {
+		name string
+		mac  string
+		opts []dhcpv4.Option
+		want string
+	}{
+		{
+			name: "ipxe",
+			opts: []dhcpv4.Option{
+				dhcpv4.OptClassIdentifier("PXEClient:Arch:00007:UNDI:003010"),
+				dhcpv4.OptUserClass("iPXE"),
+			},
+			want: IPXE,
+		},
+		{
+			name: "uefi http",
+			opts: []dhcpv4.Option{dhcpv4.OptClassIdentifier("HTTPClient:Arch:00016:UNDI:003001")},
+			want: UEFIHTTP,
+		},
+		{
+			name: "uefi pxe",
+			opts: []dhcpv4.Option{dhcpv4.OptClassIdentifier("PXEClient:Arch:00007:UNDI:003016")},
+			want: UEFIPXE,
+		},
+		{
+			name: "bios pxe",
+			opts: []dhcpv4.Option{dhcpv4.OptClassIdentifier("PXEClient:Arch:00000:UNDI:002001")},
+			want: BIOSPXE,
+		},
+		{
+			name: "raspberry pi bootloader",
+			mac:  "d8:3a:dd:01:02:03",
+			opts: []dhcpv4.Option{dhcpv4.OptClassIdentifier("PXEClient:Arch:00000:UNDI:002001")},
+			want: RPiBootloader,
+		},
+		{
+			name: "windows",
+			opts: []dhcpv4.Option{dhcpv4.OptClassIdentifier("MSFT 5.0")},
+			want: Windows,
+		},
+		{
+			name: "dhclient",
+			opts: []dhcpv4.Option{dhcpv4.OptParameterRequestList(dhclientPRL...)},
+			want: Dhclient,
+		},
+		{
+			name: "unknown",
+			opts: []dhcpv4.Option{dhcpv4.OptParameterRequestList(dhcpv4.OptionSubnetMask)},
+			want: OS,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mac := tt.mac
+			if mac == "" {
+				mac = "02:00:00:00:00:01"
+			}
+			hw, _ := net.ParseMAC(mac)
+			pkt, err := dhcpv4.NewDiscovery(hw)
+			if err != nil {
+				t.Fatalf("NewDiscovery() error = %v", err)
+			}
+			pkt.Options.Del(dhcpv4.OptionParameterRequestList)
+			for _, o := range tt.opts {
+				pkt.UpdateOption(o)
+			}
+
+			if got := Of(pkt).Environment(); got != tt.want {
+				t.Errorf("Environment() = %q, want %q (fingerprint %+v)", got, tt.want, Of(pkt))
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+// Package guard detects DHCP servers other than metal-boot answering on the
+// provisioning network, the most common cause of broken PXE boots.
+//
+// It listens passively: it reads the replies broadcast to clients on the DHCP
+// client port, and it inspects the requests metal-boot receives anyway for
+// clients selecting the offer of another server. It never sends packets.
+package guard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+	"github.com/metal3-community/metal-boot/internal/events"
+	"github.com/metal3-community/metal-boot/internal/metric"
+	"golang.org/x/net/ipv4"
+)
+
+// DefaultAlertInterval is how often a server that keeps answering is reported
+// when Guard.AlertInterval is unset.
+const DefaultAlertInterval = 10 * time.Minute
+
+// ErrRogueServer is logged with every report of another DHCP server.
+var ErrRogueServer = errors.New("rogue DHCP server")
+
+// Sighting is another DHCP server seen on the network.
+type Sighting struct {
+	// Server is the server identifier of the server, or the address its
+	// packets came from.
+	Server netip.Addr `json:"server"`
+	// Client is the MAC address of the latest client it answered, or that
+	// selected it, and Type the latest message type seen.
+	Client    string    `json:"client"`
+	Type      string    `json:"type"`
+	Interface string    `json:"interface,omitempty"`
+	Count     int       `json:"count"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+
+	reported time.Time
+}
+
+// Guard reports the DHCP servers it sees that are neither metal-boot nor
+// allowed.
+type Guard struct {
+	// Ours are the addresses metal-boot answers from.
+	Ours []netip.Addr
+	// Allowed are other servers expected on the network, such as the DHCP
+	// server next to metal-boot in proxy mode.
+	Allowed []netip.Addr
+	// AlertInterval is how often a server that keeps answering is reported.
+	AlertInterval time.Duration
+	Log           logr.Logger
+	// Events receives a RogueDHCPServer event for every report. It may be
+	// nil.
+	Events *events.Bus
+
+	mu   sync.Mutex
+	seen map[netip.Addr]*Sighting
+}
+
+// Handle implements server.Handler. It looks at the requests sent to
+// metal-boot for clients that selected the offer of another server.
+func (g *Guard) Handle(_ context.Context, _ *ipv4.PacketConn, d data.Packet) {
+	m := d.Pkt
+	if m == nil || m.MessageType() != dhcpv4.MessageTypeRequest {
+		return
+	}
+	server, ok := netip.AddrFromSlice(m.ServerIdentifier())
+	if !ok {
+		// Clients renewing or rebooting name no server.
+		return
+	}
+	var ifName string
+	if d.Md != nil {
+		ifName = d.Md.IfName
+	}
+	g.observe(server.Unmap(), m, ifName)
+}
+
+// Listen reads the replies sent to clients on conn, which must be bound to
+// the DHCP client port, until ctx is done.
+func (g *Guard) Listen(ctx context.Context, conn net.PacketConn) error {
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+
+	buf := make([]byte, 4096)
+	for {
+		n, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to read DHCP replies: %w", err)
+		}
+		m, err := dhcpv4.FromBytes(buf[:n])
+		if err != nil || m.OpCode != dhcpv4.OpcodeBootReply {
+			continue
+		}
+		server, ok := netip.AddrFromSlice(m.ServerIdentifier())
+		if !ok {
+			if u, isUDP := peer.(*net.UDPAddr); isUDP {
+				server, ok = netip.AddrFromSlice(u.IP)
+			}
+		}
+		if !ok {
+			continue
+		}
+		g.observe(server.Unmap(), m, "")
+	}
+}
+
+// observe records m, sent by or to server, and reports server if it is not
+// one of ours and was not reported within AlertInterval.
+func (g *Guard) observe(server netip.Addr, m *dhcpv4.DHCPv4, ifName string) {
+	if slices.Contains(g.Ours, server) || slices.Contains(g.Allowed, server) {
+		return
+	}
+	now := time.Now().UTC()
+	msgType := m.MessageType().String()
+	metric.RogueDHCPMessages.WithLabelValues(server.String(), msgType).Inc()
+
+	g.mu.Lock()
+	if g.seen == nil {
+		g.seen = make(map[netip.Addr]*Sighting)
+	}
+	s, ok := g.seen[server]
+	if !ok {
+		s = &Sighting{Server: server, FirstSeen: now}
+		g.seen[server] = s
+	}
+	s.Client = m.ClientHWAddr.String()
+	s.Type = msgType
+	s.Interface = ifName
+	s.Count++
+	s.LastSeen = now
+	interval := g.AlertInterval
+	if interval <= 0 {
+		interval = DefaultAlertInterval
+	}
+	report := now.Sub(s.reported) >= interval
+	if report {
+		s.reported = now
+	}
+	sighting := *s
+	g.mu.Unlock()
+
+	if !report {
+		return
+	}
+	message := fmt.Sprintf("DHCP server %s sent %s to %s", server, msgType, sighting.Client)
+	if m.OpCode == dhcpv4.OpcodeBootRequest {
+		message = fmt.Sprintf("%s selected the offer of DHCP server %s", sighting.Client, server)
+	}
+	g.Log.Error(ErrRogueServer, "another DHCP server is answering on the provisioning network",
+		"server", server.String(), "client", sighting.Client, "type", msgType,
+		"interface", ifName, "count", sighting.Count)
+	g.Events.Publish(events.Event{
+		Type:    events.RogueDHCPServer,
+		MAC:     sighting.Client,
+		IP:      server.String(),
+		Message: message,
+	})
+}
+
+// Sightings returns the other servers seen so far, ordered by address.
+func (g *Guard) Sightings() []Sighting {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	out := make([]Sighting, 0, len(g.seen))
+	for _, s := range g.seen {
+		out = append(out, *s)
+	}
+	slices.SortFunc(out, func(a, b Sighting) int { return a.Server.Compare(b.Server) })
+
+	return out
+}
@@ -0,0 +1,123 @@
+package guard
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+	"github.com/metal3-community/metal-boot/internal/events"
+)
+
+func message(t *testing.T, mt dhcpv4.MessageType, server string) *dhcpv4.DHCPv4 {
+	t.Helper()
+	mac, _ := net.ParseMAC("d8:3a:dd:01:02:03")
+	mods := []dhcpv4.Modifier{dhcpv4.WithMessageType(mt), dhcpv4.WithHwAddr(mac)}
+	if server != "" {
+		mods = append(mods, dhcpv4.WithServerIP(net.ParseIP(server)),
+			dhcpv4.WithOption(dhcpv4.OptServerIdentifier(net.ParseIP(server))))
+	}
+	m, err := dhcpv4.New(mods...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mt == dhcpv4.MessageTypeOffer || mt == dhcpv4.MessageTypeAck {
+		m.OpCode = dhcpv4.OpcodeBootReply
+	}
+	return m
+}
+
+func TestGuard(t *testing.T) {
+	var got []events.Event
+	bus := &events.Bus{}
+	bus.Subscribe(func(e events.Event) { got = append(got, e) })
+	g := &Guard{
+		Ours:    []netip.Addr{netip.MustParseAddr("10.1.1.1")},
+		Allowed: []netip.Addr{netip.MustParseAddr("10.1.1.2")},
+		Log:     logr.Discard(),
+		Events:  bus,
+	}
+	packet := func(mt dhcpv4.MessageType, server string) data.Packet {
+		return data.Packet{Pkt: message(t, mt, server), Md: &data.Metadata{IfName: "eth0"}}
+	}
+
+	g.Handle(context.Background(), nil, packet(dhcpv4.MessageTypeRequest, "10.1.1.1"))
+	g.Handle(context.Background(), nil, packet(dhcpv4.MessageTypeRequest, "10.1.1.2"))
+	g.Handle(context.Background(), nil, packet(dhcpv4.MessageTypeRequest, ""))
+	g.Handle(context.Background(), nil, packet(dhcpv4.MessageTypeDiscover, ""))
+	if len(got) != 0 {
+		t.Fatalf("events for our own or allowed servers: %+v", got)
+	}
+
+	// A second report of the same server waits for the alert interval.
+	g.Handle(context.Background(), nil, packet(dhcpv4.MessageTypeRequest, "10.1.1.66"))
+	g.Handle(context.Background(), nil, packet(dhcpv4.MessageTypeRequest, "10.1.1.66"))
+	if len(got) != 1 {
+		t.Fatalf("got %d events, want 1", len(got))
+	}
+	if e := got[0]; e.Type != events.RogueDHCPServer || e.IP != "10.1.1.66" ||
+		e.MAC != "d8:3a:dd:01:02:03" {
+		t.Errorf("event = %+v", e)
+	}
+
+	s := g.Sightings()
+	if len(s) != 1 || s[0].Count != 2 || s[0].Interface != "eth0" || s[0].Type != "REQUEST" {
+		t.Errorf("Sightings() = %+v", s)
+	}
+
+	g.AlertInterval = time.Nanosecond
+	g.Handle(context.Background(), nil, packet(dhcpv4.MessageTypeRequest, "10.1.1.66"))
+	if len(got) != 2 {
+		t.Errorf("got %d events after the alert interval, want 2", len(got))
+	}
+}
+
+func TestListen(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reported := make(chan events.Event, 1)
+	bus := &events.Bus{}
+	bus.Subscribe(func(e events.Event) { reported <- e })
+	g := &Guard{
+		Ours:   []netip.Addr{netip.MustParseAddr("10.1.1.1")},
+		Log:    logr.Discard(),
+		Events: bus,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- g.Listen(ctx, conn) }()
+
+	sender, err := net.DialUDP("udp4", nil, conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	for _, server := range []string{"10.1.1.1", "192.168.0.1"} {
+		offer := message(t, dhcpv4.MessageTypeOffer, server)
+		if _, err := sender.Write(offer.ToBytes()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case e := <-reported:
+		want := "DHCP server 192.168.0.1 sent OFFER to d8:3a:dd:01:02:03"
+		if e.IP != "192.168.0.1" || e.Message != want {
+			t.Errorf("event = %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("offer of another server was not reported")
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Listen() error = %v", err)
+	}
+}
@@ -20,13 +20,17 @@ import (
 	"net"
 	"net/netip"
 	"net/url"
+	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
 	"github.com/metal3-community/metal-boot/internal/backend"
+	"github.com/metal3-community/metal-boot/internal/bootflow"
 	"github.com/metal3-community/metal-boot/internal/dhcp"
 	"github.com/metal3-community/metal-boot/internal/dhcp/data"
 	oteldhcp "github.com/metal3-community/metal-boot/internal/dhcp/otel"
+	"github.com/metal3-community/metal-boot/internal/metric"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
@@ -62,6 +66,43 @@ type Handler struct {
 	// AutoProxyEnabled is used to determine if the proxyDHCP handler should do any Backend calls or not.
 	// When enabled no Backend calls are made and responses are sent to all valid network boot clients.
 	AutoProxyEnabled bool
+
+	// BootTracker counts netboot attempts per client. If nil, attempts are not tracked.
+	BootTracker dhcp.BootTracker
+
+	// BootFlows assigns boot correlation IDs. If nil, packets are not correlated.
+	BootFlows *bootflow.Registry
+
+	// MachineIDs records the machine UUID that clients send in option 97.
+	// If nil, UUIDs are not recorded.
+	MachineIDs dhcp.MachineIDRecorder
+
+	// Clients records the DHCP fingerprint of clients. If nil, fingerprints
+	// are not recorded.
+	Clients dhcp.ClientRecorder
+
+	// Identities links locally administered MAC addresses to the hosts they
+	// belong to before the backend is read. If nil, every MAC address is a
+	// host of its own.
+	Identities dhcp.IdentityLinker
+
+	// NetbootGate withholds netboot options from provisioned hosts. If nil,
+	// every netboot client is offered them.
+	NetbootGate dhcp.NetbootGate
+
+	// BootFlow follows netboot clients along the boot flow. If nil, it is
+	// not followed.
+	BootFlow dhcp.BootFlow
+
+	// ServerDUID identifies the server in DHCPv6 option 2.
+	ServerDUID dhcpv6.DUID
+
+	// Stats counts the replies sent. If nil, they are not counted.
+	Stats dhcp.StatsRecorder
+
+	// Admission staggers netboot offers during boot storms. If nil, offers
+	// are sent at once.
+	Admission dhcp.Admission
 }
 
 // Netboot holds the netboot configuration details used in running a DHCP server.
@@ -80,6 +121,21 @@ type Netboot struct {
 
 	// UserClass (for network booting) allows a custom DHCP option 77 to be used to break out of an iPXE loop.
 	UserClass dhcp.UserClass
+
+	// IPXEBinServerTFTP6 is the iPXE binary TFTP server on an IPv6 address,
+	// for the boot file URLs of DHCPv6 clients.
+	IPXEBinServerTFTP6 netip.AddrPort
+
+	// IPXEBinServerHTTP6 is the URL of the iPXE binary HTTP server on an IPv6
+	// address, for the boot file URLs of DHCPv6 clients.
+	IPXEBinServerHTTP6 *url.URL
+
+	// IPXEScriptURL6 is the URL of the iPXE script for DHCPv6 clients.
+	IPXEScriptURL6 func(net.HardwareAddr) *url.URL
+
+	// BootFiles overrides the iPXE binary offered to the architectures of
+	// clients. If nil, dhcp.ArchToBootFile is used.
+	BootFiles dhcp.BootFiles
 }
 
 // Redirection name comes from section 2.5 of http://www.pix.net/software/pxeboot/archive/pxespec.pdf
@@ -111,11 +167,18 @@ func (h *Handler) Handle(ctx context.Context, conn *ipv4.PacketConn, dp data.Pac
 		)
 		return
 	}
+	metric.RecordDHCPPacket(metric.DHCPHandlerProxy, metric.DirectionReceived, dp.Pkt.MessageType())
+	defer metric.ObserveDHCPHandle(metric.DHCPHandlerProxy, dp.Pkt.MessageType(), time.Now())
 
 	var ifName string
 	if dp.Md != nil {
 		ifName = dp.Md.IfName
 	}
+	bootID := h.BootFlows.Observe(
+		dp.Pkt.ClientHWAddr,
+		dp.Pkt.TransactionID,
+		dp.Pkt.MessageType() == dhcpv4.MessageTypeDiscover,
+	)
 	log := h.Log.WithValues(
 		"mac",
 		dp.Pkt.ClientHWAddr.String(),
@@ -123,6 +186,8 @@ func (h *Handler) Handle(ctx context.Context, conn *ipv4.PacketConn, dp data.Pac
 		dp.Pkt.TransactionID.String(),
 		"interface",
 		ifName,
+		bootflow.LogKey,
+		bootID,
 	)
 	tracer := otel.Tracer(tracerName)
 	var span trace.Span
@@ -132,10 +197,22 @@ func (h *Handler) Handle(ctx context.Context, conn *ipv4.PacketConn, dp data.Pac
 		trace.WithAttributes(h.encodeToAttributes(dp.Pkt, "request")...),
 		trace.WithAttributes(attribute.String("DHCP.peer", dp.Peer.String())),
 		trace.WithAttributes(attribute.String("DHCP.server.ifname", ifName)),
+		trace.WithAttributes(attribute.String("DHCP.boot_id", bootID)),
 	)
 
 	defer span.End()
 
+	// Admission and backend lookups may tell clients apart by the switch port
+	// of the relay agent.
+	if relay, ok := data.RelayFromPacket(dp.Pkt); ok {
+		ctx = data.WithRelay(ctx, relay)
+		log = log.WithValues(relay.LogValues()...)
+	}
+
+	if err := dhcp.LinkIdentity(h.Identities, dp.Pkt); err != nil {
+		log.Error(err, "failed to link client identity")
+	}
+
 	// We ignore the error here because:
 	// 1. it's only non-nil if the generation of a transaction id (XID) fails.
 	// 2. We always use the clients transaction id (XID) in responses. See dhcpv4.WithReply().
@@ -164,6 +241,10 @@ func (h *Handler) Handle(ctx context.Context, conn *ipv4.PacketConn, dp data.Pac
 	)
 
 	i := dhcp.NewInfo(dp.Pkt)
+	i.IPXEBinary = h.Netboot.BootFiles.File(i.Arch)
+	if err := dhcp.RecordClient(h.Clients, dp.Pkt); err != nil {
+		log.Error(err, "failed to record DHCP client")
+	}
 
 	if !h.Netboot.Enabled {
 		log.V(1).Info("Ignoring packet: netboot is not enabled")
@@ -187,6 +268,43 @@ func (h *Handler) Handle(ctx context.Context, conn *ipv4.PacketConn, dp data.Pac
 		return
 	}
 
+	if err := dhcp.RecordMachineUUID(h.MachineIDs, dp.Pkt); err != nil {
+		log.Error(err, "failed to record machine UUID")
+	}
+
+	if h.NetbootGate != nil && h.NetbootGate.NetbootDisabled(dp.Pkt.ClientHWAddr) {
+		log.Info("Ignoring packet: netboot disabled after provisioning")
+		span.SetStatus(codes.Ok, "Ignoring packet: netboot disabled after provisioning")
+
+		return
+	}
+
+	if h.BootTracker != nil {
+		withheld := false
+		if dp.Pkt.MessageType() == dhcpv4.MessageTypeDiscover {
+			withheld = h.BootTracker.RecordDiscover(dp.Pkt.ClientHWAddr)
+		} else {
+			withheld = h.BootTracker.NetbootWithheld(dp.Pkt.ClientHWAddr)
+		}
+		if withheld {
+			log.Info("Ignoring packet: boot attempts exhausted")
+			span.SetStatus(codes.Ok, "Ignoring packet: boot attempts exhausted")
+
+			return
+		}
+	}
+
+	if h.Admission != nil && dp.Pkt.MessageType() == dhcpv4.MessageTypeDiscover &&
+		!h.Admission.Admit(ctx, dp.Pkt.ClientHWAddr) {
+		log.Info("Ignoring packet: netboot offer deferred during boot storm")
+		span.SetStatus(codes.Ok, "Ignoring packet: netboot offer deferred during boot storm")
+
+		return
+	}
+	if h.BootFlow != nil && dp.Pkt.MessageType() == dhcpv4.MessageTypeDiscover {
+		h.BootFlow.Discovered(dp.Pkt.ClientHWAddr, true)
+	}
+
 	// Set option 43
 	opts := dhcpv4.Options{
 		6: []byte{8},
@@ -225,10 +343,11 @@ func (h *Handler) Handle(ctx context.Context, conn *ipv4.PacketConn, dp data.Pac
 		"received DHCP packet",
 		"type", dp.Pkt.MessageType().String(),
 		"clientType", i.ClientTypeFrom().String(),
+		"arch", i.Arch.String(),
 		"userClass", i.UserClassFrom().String(),
 	)
 
-	dst := replyDestination(dp.Peer, dp.Pkt.GatewayIPAddr)
+	dst := replyDestination(dp.Peer, dp.Pkt)
 	cm := &ipv4.ControlMessage{}
 	if dp.Md != nil {
 		cm.IfIndex = dp.Md.IfIndex
@@ -247,6 +366,13 @@ func (h *Handler) Handle(ctx context.Context, conn *ipv4.PacketConn, dp data.Pac
 
 		return
 	}
+	if h.Stats != nil {
+		h.Stats.RecordReply(reply.MessageType())
+	}
+	metric.RecordDHCPPacket(metric.DHCPHandlerProxy, metric.DirectionSent, reply.MessageType())
+	if reply.MessageType() == dhcpv4.MessageTypeOffer {
+		metric.RecordDHCPOffer(metric.DHCPHandlerProxy, dp.Pkt)
+	}
 	log.Info("Sent ProxyDHCP response")
 	span.SetAttributes(h.encodeToAttributes(reply, "reply")...)
 	span.SetStatus(codes.Ok, "sent DHCP response")
@@ -286,16 +412,16 @@ func (e IgnorePacketError) Error() string {
 }
 
 // replyDestination determines the destination address for the DHCP reply.
-// If the giaddr is set, then the reply should be sent to the giaddr.
+// If pkt was relayed, then the reply should be sent to the relay agent.
 // Otherwise, the reply should be sent to the direct peer.
 //
 // From page 22 of https://www.ietf.org/rfc/rfc2131.txt:
 // "If the 'giaddr' field in a DHCP message from a client is non-zero,
 // the server sends any return messages to the 'DHCP server' port on
 // the BOOTP relay agent whose address appears in 'giaddr'.".
-func replyDestination(directPeer net.Addr, giaddr net.IP) net.Addr {
-	if !giaddr.IsUnspecified() && giaddr != nil {
-		return &net.UDPAddr{IP: giaddr, Port: dhcpv4.ServerPort}
+func replyDestination(directPeer net.Addr, pkt *dhcpv4.DHCPv4) net.Addr {
+	if relay, ok := dhcp.RelayAgent(pkt); ok {
+		return relay
 	}
 
 	return directPeer
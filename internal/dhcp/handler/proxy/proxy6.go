@@ -0,0 +1,229 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/metal3-community/metal-boot/internal/bootflow"
+	"github.com/metal3-community/metal-boot/internal/dhcp"
+	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Handle6 answers DHCPv6 netboot clients with a boot file URL in option 59
+// and no addresses, leaving address assignment to the DHCPv6 server or SLAAC
+// of the network. UEFI PXE and HTTP boot combine such an advertisement with
+// the one carrying their address.
+func (h *Handler) Handle6(ctx context.Context, conn net.PacketConn, dp data.Packet6) {
+	if dp.Pkt == nil {
+		h.Log.Error(
+			errors.New("incoming packet is nil"),
+			"not able to respond when the incoming packet is nil",
+		)
+		return
+	}
+	if _, ok := dp.Peer.(*net.UDPAddr); !ok {
+		h.Log.Error(
+			errors.New("peer is not a UDP connection"),
+			"not able to respond when the peer is not a UDP connection",
+		)
+		return
+	}
+	if conn == nil {
+		h.Log.Error(
+			errors.New("connection is nil"),
+			"not able to respond when the connection is nil",
+		)
+		return
+	}
+	if h.ServerDUID == nil {
+		h.Log.Error(errors.New("server DUID is nil"), "not able to respond without a server DUID")
+		return
+	}
+
+	i, err := dhcp.NewInfo6(dp.Pkt, dp.Peer)
+	if err != nil {
+		h.Log.V(1).Info("Ignoring packet", "error", err.Error())
+		return
+	}
+	i.IPXEBinary = h.Netboot.BootFiles.File(i.Arch)
+	msg := i.Msg
+
+	var ifName string
+	if dp.Md != nil {
+		ifName = dp.Md.IfName
+	}
+	xid := msg.TransactionID
+	bootID := h.BootFlows.Observe(
+		i.Mac,
+		[4]byte{0, xid[0], xid[1], xid[2]},
+		msg.Type() == dhcpv6.MessageTypeSolicit,
+	)
+	log := h.Log.WithValues(
+		"mac", i.Mac.String(),
+		"xid", xid.String(),
+		"interface", ifName,
+		bootflow.LogKey, bootID,
+	)
+	tracer := otel.Tracer(tracerName)
+	var span trace.Span
+	ctx, span = tracer.Start(
+		ctx,
+		fmt.Sprintf("DHCPv6 Packet Received: %v", msg.Type().String()),
+		trace.WithAttributes(attribute.String("DHCP.peer", dp.Peer.String())),
+		trace.WithAttributes(attribute.String("DHCP.server.ifname", ifName)),
+		trace.WithAttributes(attribute.String("DHCP.boot_id", bootID)),
+	)
+	defer span.End()
+
+	if !h.Netboot.Enabled {
+		log.V(1).Info("Ignoring packet: netboot is not enabled")
+		span.SetStatus(codes.Ok, "Ignoring packet: netboot is not enabled")
+
+		return
+	}
+	if err := i.IsNetbootClient; err != nil {
+		log.V(1).Info("Ignoring packet: not from a PXE enabled client", "error", err.Error())
+		span.SetStatus(
+			codes.Ok,
+			fmt.Sprintf("Ignoring packet: not from a PXE enabled client: %s", err.Error()),
+		)
+
+		return
+	}
+	if i.Mac == nil {
+		log.V(1).Info("Ignoring packet: client mac address unknown")
+		span.SetStatus(codes.Ok, "Ignoring packet: client mac address unknown")
+
+		return
+	}
+	if i.IPXEBinary == "" {
+		log.V(1).Info("Ignoring packet: no iPXE binary was able to be determined")
+		span.SetStatus(codes.Ok, "Ignoring packet: no iPXE binary was able to be determined")
+
+		return
+	}
+	if !dhcp.ForServer6(msg, h.ServerDUID) {
+		log.V(1).Info("Ignoring packet: for another server")
+		span.SetStatus(codes.Ok, "Ignoring packet: for another server")
+
+		return
+	}
+
+	var reply *dhcpv6.Message
+	switch msg.Type() {
+	case dhcpv6.MessageTypeSolicit:
+		reply, err = dhcpv6.NewAdvertiseFromSolicit(msg)
+	case dhcpv6.MessageTypeRequest, dhcpv6.MessageTypeInformationRequest:
+		reply, err = dhcpv6.NewReplyFromMessage(msg)
+	default:
+		log.V(1).Info("Ignoring packet", "type", msg.Type().String(),
+			"details", "proxyDHCPv6 only responds to Solicit, Request or Information-request")
+		span.SetStatus(codes.Ok, "Ignoring packet: message type")
+
+		return
+	}
+	if err != nil {
+		log.Info("failed to build DHCPv6 reply", "error", err)
+		span.SetStatus(codes.Error, err.Error())
+
+		return
+	}
+
+	if !h.AutoProxyEnabled {
+		_, n, err := h.Backend.GetByMac(ctx, i.Mac)
+		if err != nil || !n.AllowNetboot {
+			log.V(1).Info("Ignoring packet: netboot not allowed", "error", err)
+			span.SetStatus(codes.Ok, "Ignoring packet: netboot not allowed")
+
+			return
+		}
+	}
+	if h.NetbootGate != nil && h.NetbootGate.NetbootDisabled(i.Mac) {
+		log.Info("Ignoring packet: netboot disabled after provisioning")
+		span.SetStatus(codes.Ok, "Ignoring packet: netboot disabled after provisioning")
+
+		return
+	}
+	if h.BootTracker != nil {
+		withheld := false
+		if msg.Type() == dhcpv6.MessageTypeSolicit {
+			withheld = h.BootTracker.RecordDiscover(i.Mac)
+		} else {
+			withheld = h.BootTracker.NetbootWithheld(i.Mac)
+		}
+		if withheld {
+			log.Info("Ignoring packet: boot attempts exhausted")
+			span.SetStatus(codes.Ok, "Ignoring packet: boot attempts exhausted")
+
+			return
+		}
+	}
+
+	if h.Admission != nil && msg.Type() == dhcpv6.MessageTypeSolicit &&
+		!h.Admission.Admit(ctx, i.Mac) {
+		log.Info("Ignoring packet: netboot offer deferred during boot storm")
+		span.SetStatus(codes.Ok, "Ignoring packet: netboot offer deferred during boot storm")
+
+		return
+	}
+	if h.BootFlow != nil && msg.Type() == dhcpv6.MessageTypeSolicit {
+		h.BootFlow.Discovered(i.Mac, true)
+	}
+
+	bootURL := i.BootFileURL(
+		h.Netboot.UserClass,
+		h.ipxeScriptURL6(i),
+		h.Netboot.IPXEBinServerHTTP6,
+		h.Netboot.IPXEBinServerTFTP6,
+	)
+	if bootURL == "" {
+		log.Info("Ignoring packet: no IPv6 boot server configured")
+		span.SetStatus(codes.Ok, "Ignoring packet: no IPv6 boot server configured")
+
+		return
+	}
+	reply.AddOption(dhcpv6.OptServerID(h.ServerDUID))
+	reply.AddOption(dhcpv6.OptBootFileURL(bootURL))
+	if i.ClientType == dhcp.HTTPClient {
+		reply.AddOption(dhcp.VendorClass6(dhcp.HTTPClient))
+	}
+
+	out, err := dhcp.Reply6(dp.Pkt, reply)
+	if err != nil {
+		log.Error(err, "failed to build ProxyDHCPv6 response")
+		span.SetStatus(codes.Error, err.Error())
+
+		return
+	}
+	log = log.WithValues(
+		"destination", dp.Peer.String(),
+		"bootFileURL", bootURL,
+		"messageType", reply.Type().String(),
+	)
+	if _, err := conn.WriteTo(out.ToBytes(), dp.Peer); err != nil {
+		log.Error(err, "failed to send ProxyDHCPv6 response")
+		span.SetStatus(codes.Error, err.Error())
+
+		return
+	}
+	log.Info("Sent ProxyDHCPv6 response")
+	span.SetStatus(codes.Ok, "sent DHCPv6 response")
+}
+
+// ipxeScriptURL6 returns the iPXE script URL for a DHCPv6 client, or nil if
+// none is configured.
+func (h *Handler) ipxeScriptURL6(i dhcp.Info6) *url.URL {
+	if h.Netboot.IPXEScriptURL6 == nil {
+		return nil
+	}
+
+	return h.Netboot.IPXEScriptURL6(i.Mac)
+}
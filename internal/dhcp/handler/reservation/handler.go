@@ -7,13 +7,17 @@ import (
 	"net"
 	"net/netip"
 	"strings"
+	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/metal3-community/metal-boot/internal/bootflow"
 	"github.com/metal3-community/metal-boot/internal/dhcp"
 	"github.com/metal3-community/metal-boot/internal/dhcp/arp"
 	"github.com/metal3-community/metal-boot/internal/dhcp/data"
 	oteldhcp "github.com/metal3-community/metal-boot/internal/dhcp/otel"
+	"github.com/metal3-community/metal-boot/internal/leasedb"
+	"github.com/metal3-community/metal-boot/internal/metric"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
@@ -71,11 +75,18 @@ func (h *Handler) Handle(ctx context.Context, conn *ipv4.PacketConn, p data.Pack
 		)
 		return
 	}
+	metric.RecordDHCPPacket(metric.DHCPHandlerReservation, metric.DirectionReceived, p.Pkt.MessageType())
+	defer metric.ObserveDHCPHandle(metric.DHCPHandlerReservation, p.Pkt.MessageType(), time.Now())
 
 	var ifName string
 	if p.Md != nil {
 		ifName = p.Md.IfName
 	}
+	bootID := h.BootFlows.Observe(
+		p.Pkt.ClientHWAddr,
+		p.Pkt.TransactionID,
+		p.Pkt.MessageType() == dhcpv4.MessageTypeDiscover,
+	)
 	log := h.Log.WithValues(
 		"mac",
 		p.Pkt.ClientHWAddr.String(),
@@ -83,6 +94,8 @@ func (h *Handler) Handle(ctx context.Context, conn *ipv4.PacketConn, p data.Pack
 		p.Pkt.TransactionID.String(),
 		"interface",
 		ifName,
+		bootflow.LogKey,
+		bootID,
 	)
 	tracer := otel.Tracer(tracerName)
 	var span trace.Span
@@ -92,16 +105,42 @@ func (h *Handler) Handle(ctx context.Context, conn *ipv4.PacketConn, p data.Pack
 		trace.WithAttributes(h.encodeToAttributes(p.Pkt, "request")...),
 		trace.WithAttributes(attribute.String("DHCP.peer", p.Peer.String())),
 		trace.WithAttributes(attribute.String("DHCP.server.ifname", ifName)),
+		trace.WithAttributes(attribute.String("DHCP.boot_id", bootID)),
 	)
 
 	defer span.End()
 
+	// The backend assigns addresses from the pool of the relay agent's network
+	// and may identify the switch port of the client by its circuit ID.
+	if relay, ok := data.RelayFromPacket(p.Pkt); ok {
+		ctx = data.WithRelay(ctx, relay)
+		log = log.WithValues(relay.LogValues()...)
+	}
+
+	if err := dhcp.LinkIdentity(h.Identities, p.Pkt); err != nil {
+		log.Error(err, "failed to link client identity")
+	}
+
+	if h.ReservationsOnly {
+		if reason := h.notReserved(p.Pkt); reason != "" {
+			if reason == reasonNoReservation && p.Pkt.MessageType() == dhcpv4.MessageTypeDiscover {
+				h.recordUnknownDiscover()
+			}
+			log.V(1).Info("ignoring DHCP packet", "type", p.Pkt.MessageType().String(),
+				"reason", reason)
+			span.SetStatus(codes.Ok, reason)
+
+			return
+		}
+	}
+
 	var reply *dhcpv4.DHCPv4
 	switch mt := p.Pkt.MessageType(); mt {
 	case dhcpv4.MessageTypeDiscover:
 		d, n, err := h.readBackend(ctx, p.Pkt.ClientHWAddr)
 		if err != nil {
 			if hardwareNotFound(err) {
+				h.recordUnknownDiscover()
 				span.SetStatus(codes.Ok, "no reservation found")
 				return
 			}
@@ -110,6 +149,9 @@ func (h *Handler) Handle(ctx context.Context, conn *ipv4.PacketConn, p data.Pack
 
 			return
 		}
+		if r := reserverOf(h.Backend); r != nil && !r.HasReservation(p.Pkt.ClientHWAddr) {
+			h.recordUnknownDiscover()
+		}
 		if d.Disabled {
 			log.Info(
 				"DHCP is disabled for this MAC address, no response sent",
@@ -121,6 +163,28 @@ func (h *Handler) Handle(ctx context.Context, conn *ipv4.PacketConn, p data.Pack
 			return
 		}
 
+		h.recordClient(log, p.Pkt)
+		if h.BootTracker != nil && n.AllowNetboot && dhcp.IsNetbootClient(p.Pkt) == nil &&
+			h.BootTracker.RecordDiscover(p.Pkt.ClientHWAddr) {
+			log.Info("boot attempts exhausted, withholding netboot options")
+			n = withoutNetboot(n)
+		}
+		if h.netbootDisabled(p.Pkt.ClientHWAddr) {
+			log.Info("host is provisioned, withholding netboot options")
+			n = withoutNetboot(n)
+		}
+		if h.Admission != nil && n.AllowNetboot && dhcp.IsNetbootClient(p.Pkt) == nil &&
+			!h.Admission.Admit(ctx, p.Pkt.ClientHWAddr) {
+			log.Info("deferring netboot offer during boot storm")
+			span.SetStatus(codes.Ok, "netboot offer deferred")
+
+			return
+		}
+
+		if h.BootFlow != nil && h.Netboot.Enabled && dhcp.IsNetbootClient(p.Pkt) == nil {
+			h.BootFlow.Discovered(p.Pkt.ClientHWAddr, n.AllowNetboot)
+		}
+
 		log.Info("received DHCP packet", "type", p.Pkt.MessageType().String())
 		reply = h.updateMsg(ctx, p.Pkt, d, n, dhcpv4.MessageTypeOffer)
 		log = log.WithValues("type", dhcpv4.MessageTypeOffer.String())
@@ -146,9 +210,35 @@ func (h *Handler) Handle(ctx context.Context, conn *ipv4.PacketConn, p data.Pack
 
 			return
 		}
+		h.recordClient(log, p.Pkt)
+		if h.BootTracker != nil && h.BootTracker.NetbootWithheld(p.Pkt.ClientHWAddr) ||
+			h.netbootDisabled(p.Pkt.ClientHWAddr) {
+			n = withoutNetboot(n)
+		}
 		reply = h.updateMsg(ctx, p.Pkt, d, n, dhcpv4.MessageTypeAck)
 		log = log.WithValues("type", dhcpv4.MessageTypeAck.String())
 		span.SetStatus(codes.Ok, "processed request")
+	case dhcpv4.MessageTypeRelease:
+		if err := h.Leases.Release(p.Pkt.ClientHWAddr); err != nil {
+			log.Error(err, "failed to record lease release")
+		}
+		log.Info("received DHCP packet", "type", p.Pkt.MessageType().String())
+		span.SetStatus(codes.Ok, "lease released")
+
+		return
+	case dhcpv4.MessageTypeDecline:
+		if err := h.Leases.Decline(p.Pkt.ClientHWAddr); err != nil {
+			log.Error(err, "failed to record lease decline")
+		}
+		if ip := p.Pkt.RequestedIPAddress(); ip != nil && h.LeaseBackend != nil {
+			if err := h.LeaseBackend.MarkIPDeclined(ip.String()); err != nil {
+				log.Error(err, "failed to mark IP as declined", "ip", ip.String())
+			}
+		}
+		log.Info("received DHCP packet", "type", p.Pkt.MessageType().String())
+		span.SetStatus(codes.Ok, "lease declined")
+
+		return
 	default:
 		log.Info("received unknown message type", "type", p.Pkt.MessageType().String())
 		span.SetStatus(codes.Error, "received unknown message type")
@@ -156,10 +246,11 @@ func (h *Handler) Handle(ctx context.Context, conn *ipv4.PacketConn, p data.Pack
 		return
 	}
 
+	// Later TFTP and HTTP requests only carry the address handed out here.
+	h.BootFlows.BindIP(p.Pkt.ClientHWAddr, reply.YourIPAddr)
+
 	if strings.HasPrefix("http://", reply.BootFileName) {
-		ipxeScriptUrl := *h.Netboot.IPXEScriptURL(reply)
-		ipxeScriptUrl.Path = "/boot.ipxe"
-		reply.BootFileName = ipxeScriptUrl.String()
+		reply.BootFileName = h.Netboot.IPXEScriptURL(reply).String()
 	}
 
 	if bf := reply.BootFileName; bf != "" {
@@ -182,33 +273,7 @@ func (h *Handler) Handle(ctx context.Context, conn *ipv4.PacketConn, p data.Pack
 		"broadcastFlag", p.Pkt.IsBroadcast(),
 		"messageType", p.Pkt.MessageType().String())
 
-	// Handle destination based on DHCP RFC 2131
-	var dst net.Addr
-	if p.Pkt.IsBroadcast() {
-		// Client requested broadcast response
-		dst = &net.UDPAddr{
-			IP:   net.IPv4bcast,
-			Port: 68,
-		}
-		log.Info("Using broadcast destination due to broadcast flag")
-	} else {
-		// Client can receive unicast - send to relay or assigned IP
-		if !p.Pkt.GatewayIPAddr.IsUnspecified() && !p.Pkt.GatewayIPAddr.Equal(net.IPv4zero) {
-			// Send via relay agent
-			dst = &net.UDPAddr{
-				IP:   p.Pkt.GatewayIPAddr,
-				Port: 67, // DHCP server port for relay
-			}
-			log.Info("Using relay destination", "giaddr", p.Pkt.GatewayIPAddr.String())
-		} else {
-			// Send directly to assigned IP
-			dst = &net.UDPAddr{
-				IP:   reply.YourIPAddr,
-				Port: 68,
-			}
-			log.Info("Using direct unicast destination", "yourIP", reply.YourIPAddr.String())
-		}
-	}
+	dst := dhcp.ReplyDestination(p.Pkt, reply)
 	log = log.WithValues("ipAddress", reply.YourIPAddr.String(), "destination", dst.String())
 	cm := &ipv4.ControlMessage{}
 	if p.Md != nil {
@@ -222,11 +287,80 @@ func (h *Handler) Handle(ctx context.Context, conn *ipv4.PacketConn, p data.Pack
 		return
 	}
 
+	h.recordLease(log, p.Pkt, reply)
+	if h.Stats != nil {
+		h.Stats.RecordReply(reply.MessageType())
+	}
+	metric.RecordDHCPPacket(metric.DHCPHandlerReservation, metric.DirectionSent, reply.MessageType())
+	if reply.MessageType() == dhcpv4.MessageTypeOffer {
+		metric.RecordDHCPOffer(metric.DHCPHandlerReservation, p.Pkt)
+	}
 	log.Info("sent DHCP response")
 	span.SetAttributes(h.encodeToAttributes(reply, "reply")...)
 	span.SetStatus(codes.Ok, "sent DHCP response")
 }
 
+// recordLease records the address reply offers or acknowledges to the
+// client of pkt in the lease database.
+func (h *Handler) recordLease(log logr.Logger, pkt, reply *dhcpv4.DHCPv4) {
+	ip, ok := netip.AddrFromSlice(reply.YourIPAddr.To4())
+	if !ok || ip.IsUnspecified() {
+		return
+	}
+
+	var err error
+	switch reply.MessageType() {
+	case dhcpv4.MessageTypeOffer:
+		_, err = h.Leases.Offer(pkt.ClientHWAddr, ip, pkt.HostName())
+	case dhcpv4.MessageTypeAck:
+		_, err = h.Leases.Ack(pkt.ClientHWAddr, ip, pkt.HostName(), reply.IPAddressLeaseTime(0))
+	}
+	if errors.Is(err, leasedb.ErrConflict) {
+		log.Info("WARNING: lease in conflict", "error", err.Error())
+	} else if err != nil {
+		log.Error(err, "failed to record lease")
+	}
+}
+
+// notReserved returns why pkt must be left to another server in reservations
+// only mode, or "" if it is for this server. It is checked before reading the
+// backend, which may assign a lease from its pool.
+func (h *Handler) notReserved(pkt *dhcpv4.DHCPv4) string {
+	if pkt.MessageType() == dhcpv4.MessageTypeRequest {
+		if id := pkt.ServerIdentifier(); id != nil && !id.Equal(net.IP(h.IPAddr.AsSlice())) {
+			return "request for another server"
+		}
+	}
+	if r := reserverOf(h.Backend); r != nil && !r.HasReservation(pkt.ClientHWAddr) {
+		return reasonNoReservation
+	}
+
+	return ""
+}
+
+// reasonNoReservation is the notReserved reason for clients without a
+// reservation.
+const reasonNoReservation = "no reservation"
+
+// recordUnknownDiscover counts a DHCPDISCOVER from a client without a
+// reservation.
+func (h *Handler) recordUnknownDiscover() {
+	if h.Stats != nil {
+		h.Stats.RecordUnknownDiscover()
+	}
+}
+
+// recordClient records the option 97 machine UUID and the DHCP fingerprint
+// of a client with a reservation.
+func (h *Handler) recordClient(log logr.Logger, pkt *dhcpv4.DHCPv4) {
+	if err := dhcp.RecordMachineUUID(h.MachineIDs, pkt); err != nil {
+		log.Error(err, "failed to record machine UUID")
+	}
+	if err := dhcp.RecordClient(h.Clients, pkt); err != nil {
+		log.Error(err, "failed to record DHCP client")
+	}
+}
+
 // readBackend encapsulates the backend read and opentelemetry handling.
 func (h *Handler) readBackend(
 	ctx context.Context,
@@ -277,6 +411,7 @@ func (h *Handler) updateMsg(
 	if h.Netboot.Enabled && dhcp.IsNetbootClient(pkt) == nil {
 		mods = append(mods, h.setNetworkBootOpts(ctx, pkt, n))
 	}
+	mods = append(mods, h.setExtraOpts(d)...)
 	// We ignore the error here because:
 	// 1. it's only non-nil if the generation of a transaction id (XID) fails.
 	// 2. We always use the clients transaction id (XID) in responses. See dhcpv4.WithReply().
@@ -285,6 +420,20 @@ func (h *Handler) updateMsg(
 	return reply
 }
 
+// netbootDisabled reports whether the NetbootGate withholds netboot options
+// from mac.
+func (h *Handler) netbootDisabled(mac net.HardwareAddr) bool {
+	return h.NetbootGate != nil && h.NetbootGate.NetbootDisabled(mac)
+}
+
+// withoutNetboot returns a copy of n with netbooting disallowed.
+func withoutNetboot(n *data.Netboot) *data.Netboot {
+	nb := *n
+	nb.AllowNetboot = false
+
+	return &nb
+}
+
 // encodeToAttributes takes a DHCP packet and returns opentelemetry key/value attributes.
 func (h *Handler) encodeToAttributes(d *dhcpv4.DHCPv4, namespace string) []attribute.KeyValue {
 	h.setDefaults()
@@ -0,0 +1,275 @@
+package reservation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/metal3-community/metal-boot/internal/bootflow"
+	"github.com/metal3-community/metal-boot/internal/dhcp"
+	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Handle6 responds to DHCPv6 messages with the IPv6 reservation of the
+// client in IA_NA and, for netboot clients, a boot file URL in option 59.
+func (h *Handler) Handle6(ctx context.Context, conn net.PacketConn, p data.Packet6) {
+	h.setDefaults()
+	if p.Pkt == nil {
+		h.Log.Error(
+			errors.New("incoming packet is nil"),
+			"not able to respond when the incoming packet is nil",
+		)
+		return
+	}
+	if _, ok := p.Peer.(*net.UDPAddr); !ok {
+		h.Log.Error(
+			errors.New("peer is not a UDP connection"),
+			"not able to respond when the peer is not a UDP connection",
+		)
+		return
+	}
+	if conn == nil {
+		h.Log.Error(
+			errors.New("connection is nil"),
+			"not able to respond when the connection is nil",
+		)
+		return
+	}
+	if h.ServerDUID == nil {
+		h.Log.Error(errors.New("server DUID is nil"), "not able to respond without a server DUID")
+		return
+	}
+
+	i, err := dhcp.NewInfo6(p.Pkt, p.Peer)
+	if err != nil {
+		h.Log.V(1).Info("ignoring DHCPv6 packet", "error", err.Error())
+		return
+	}
+	i.IPXEBinary = h.Netboot.BootFiles.File(i.Arch)
+	msg := i.Msg
+
+	var ifName string
+	if p.Md != nil {
+		ifName = p.Md.IfName
+	}
+	xid := msg.TransactionID
+	bootID := h.BootFlows.Observe(
+		i.Mac,
+		[4]byte{0, xid[0], xid[1], xid[2]},
+		msg.Type() == dhcpv6.MessageTypeSolicit,
+	)
+	log := h.Log.WithValues(
+		"mac", i.Mac.String(),
+		"xid", xid.String(),
+		"interface", ifName,
+		bootflow.LogKey, bootID,
+	)
+	tracer := otel.Tracer(tracerName)
+	var span trace.Span
+	ctx, span = tracer.Start(
+		ctx,
+		fmt.Sprintf("DHCPv6 Packet Received: %v", msg.Type().String()),
+		trace.WithAttributes(attribute.String("DHCP.peer", p.Peer.String())),
+		trace.WithAttributes(attribute.String("DHCP.server.ifname", ifName)),
+		trace.WithAttributes(attribute.String("DHCP.boot_id", bootID)),
+	)
+	defer span.End()
+
+	if i.Mac == nil {
+		log.V(1).Info("ignoring DHCPv6 packet: client mac address unknown")
+		span.SetStatus(codes.Ok, "client mac address unknown")
+
+		return
+	}
+	if !dhcp.ForServer6(msg, h.ServerDUID) {
+		log.V(1).Info("ignoring DHCPv6 packet: for another server")
+		span.SetStatus(codes.Ok, "for another server")
+
+		return
+	}
+
+	var reply *dhcpv6.Message
+	switch msg.Type() {
+	case dhcpv6.MessageTypeSolicit:
+		if msg.GetOneOption(dhcpv6.OptionRapidCommit) != nil {
+			reply, err = dhcpv6.NewReplyFromMessage(msg)
+		} else {
+			reply, err = dhcpv6.NewAdvertiseFromSolicit(msg)
+		}
+	case dhcpv6.MessageTypeRequest, dhcpv6.MessageTypeConfirm, dhcpv6.MessageTypeRenew,
+		dhcpv6.MessageTypeRebind, dhcpv6.MessageTypeRelease, dhcpv6.MessageTypeDecline,
+		dhcpv6.MessageTypeInformationRequest:
+		reply, err = dhcpv6.NewReplyFromMessage(msg)
+	default:
+		log.Info("received unknown message type", "type", msg.Type().String())
+		span.SetStatus(codes.Error, "received unknown message type")
+
+		return
+	}
+	if err != nil {
+		log.Info("failed to build DHCPv6 reply", "error", err)
+		span.SetStatus(codes.Error, err.Error())
+
+		return
+	}
+
+	d, n, err := h.readBackend(ctx, i.Mac)
+	if err != nil {
+		if hardwareNotFound(err) {
+			span.SetStatus(codes.Ok, "no reservation found")
+			return
+		}
+		log.Info("error reading from backend", "error", err)
+		span.SetStatus(codes.Error, err.Error())
+
+		return
+	}
+	if d.Disabled {
+		log.Info("DHCP is disabled for this MAC address, no response sent",
+			"type", msg.Type().String())
+		span.SetStatus(codes.Ok, "disabled DHCP response")
+
+		return
+	}
+	if h.ReservationsOnly && !d.IPv6Address.IsValid() {
+		log.V(1).Info("ignoring DHCPv6 packet", "type", msg.Type().String(),
+			"reason", "no IPv6 reservation")
+		span.SetStatus(codes.Ok, "no IPv6 reservation")
+
+		return
+	}
+
+	reply.AddOption(dhcpv6.OptServerID(h.ServerDUID))
+	switch msg.Type() {
+	case dhcpv6.MessageTypeRelease, dhcpv6.MessageTypeDecline:
+		// The reservation stays with the host, there is nothing to free.
+		reply.AddOption(&dhcpv6.OptStatusCode{StatusCode: iana.StatusSuccess})
+	case dhcpv6.MessageTypeInformationRequest:
+		h.setDHCPv6Opts(reply, d)
+	default:
+		h.setIANA(reply, msg, d)
+		h.setDHCPv6Opts(reply, d)
+		if d.IPv6Address.IsValid() {
+			// Later TFTP and HTTP requests only carry the address handed out here.
+			h.BootFlows.BindIP(i.Mac, d.IPv6Address.AsSlice())
+		}
+	}
+
+	if h.Netboot.Enabled && i.IsNetbootClient == nil {
+		withheld := !n.AllowNetboot || h.netbootDisabled(i.Mac)
+		if h.BootTracker != nil && !withheld {
+			if msg.Type() == dhcpv6.MessageTypeSolicit {
+				withheld = h.BootTracker.RecordDiscover(i.Mac)
+			} else {
+				withheld = h.BootTracker.NetbootWithheld(i.Mac)
+			}
+		}
+		if !withheld && h.Admission != nil && msg.Type() == dhcpv6.MessageTypeSolicit &&
+			!h.Admission.Admit(ctx, i.Mac) {
+			log.Info("deferring netboot offer during boot storm")
+			span.SetStatus(codes.Ok, "netboot offer deferred")
+
+			return
+		}
+		if h.BootFlow != nil && msg.Type() == dhcpv6.MessageTypeSolicit {
+			h.BootFlow.Discovered(i.Mac, !withheld)
+		}
+		if withheld {
+			log.Info("withholding netboot options")
+		} else if u := h.bootFileURL6(i, n); u != "" {
+			reply.AddOption(dhcpv6.OptBootFileURL(u))
+			if i.ClientType == dhcp.HTTPClient {
+				reply.AddOption(dhcp.VendorClass6(dhcp.HTTPClient))
+			}
+			log = log.WithValues("bootFileURL", u)
+		}
+	}
+
+	if err := h.send6(conn, p, reply); err != nil {
+		log.Error(err, "failed to send DHCPv6 response")
+		span.SetStatus(codes.Error, err.Error())
+
+		return
+	}
+	if d.IPv6Address.IsValid() {
+		log = log.WithValues("ipAddress", d.IPv6Address.String())
+	}
+	log.Info("sent DHCPv6 response", "type", reply.Type().String(), "destination", p.Peer.String())
+	span.SetStatus(codes.Ok, "sent DHCPv6 response")
+}
+
+// setIANA answers the IA_NA of msg with the IPv6 reservation in d, or with a
+// NoAddrsAvail status if there is none.
+func (h *Handler) setIANA(reply, msg *dhcpv6.Message, d *data.DHCP) {
+	ia := msg.Options.OneIANA()
+	if ia == nil {
+		return
+	}
+	out := &dhcpv6.OptIANA{IaId: ia.IaId}
+	if !d.IPv6Address.IsValid() {
+		out.Options.Add(&dhcpv6.OptStatusCode{
+			StatusCode:    iana.StatusNoAddrsAvail,
+			StatusMessage: "no IPv6 reservation",
+		})
+		reply.AddOption(out)
+
+		return
+	}
+
+	lease := time.Duration(d.LeaseTime) * time.Second
+	out.T1 = lease / 2
+	out.T2 = lease * 4 / 5
+	out.Options.Add(&dhcpv6.OptIAAddress{
+		IPv6Addr:          d.IPv6Address.AsSlice(),
+		PreferredLifetime: lease,
+		ValidLifetime:     lease,
+	})
+	reply.AddOption(out)
+}
+
+// setDHCPv6Opts sets the network options of d that have a DHCPv6 form.
+func (h *Handler) setDHCPv6Opts(reply *dhcpv6.Message, d *data.DHCP) {
+	if len(d.IPv6NameServers) > 0 {
+		reply.AddOption(dhcpv6.OptDNS(d.IPv6NameServers...))
+	}
+	if len(d.DomainSearch) > 0 {
+		dhcpv6.WithDomainSearchList(d.DomainSearch...)(reply)
+	}
+}
+
+// bootFileURL6 returns the option 59 boot file URL for the client.
+func (h *Handler) bootFileURL6(i dhcp.Info6, n *data.Netboot) string {
+	var ipxeScript *url.URL
+	if n.IPXEScriptURL != nil {
+		ipxeScript = h.Netboot.backendScriptURL(n.IPXEScriptURL, i.Mac)
+	} else if h.Netboot.IPXEScriptURL6 != nil {
+		ipxeScript = h.Netboot.IPXEScriptURL6(i.Mac)
+	}
+
+	return i.BootFileURL(
+		h.Netboot.UserClass,
+		ipxeScript,
+		h.Netboot.IPXEBinServerHTTP6,
+		h.Netboot.IPXEBinServerTFTP6,
+	)
+}
+
+// send6 sends reply to the client or relay agent that sent p.
+func (h *Handler) send6(conn net.PacketConn, p data.Packet6, reply *dhcpv6.Message) error {
+	out, err := dhcp.Reply6(p.Pkt, reply)
+	if err != nil {
+		return err
+	}
+	_, err = conn.WriteTo(out.ToBytes(), p.Peer)
+
+	return err
+}
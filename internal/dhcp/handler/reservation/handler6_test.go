@@ -0,0 +1,114 @@
+package reservation
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+)
+
+// capture6 is a net.PacketConn that keeps the last packet written to it.
+type capture6 struct {
+	net.PacketConn
+	out []byte
+}
+
+func (c *capture6) WriteTo(b []byte, _ net.Addr) (int, error) {
+	c.out = append([]byte(nil), b...)
+	return len(b), nil
+}
+
+func TestHandle6(t *testing.T) {
+	serverDUID := &dhcpv6.DUIDLL{
+		HWType:        iana.HWTypeEthernet,
+		LinkLayerAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 1},
+	}
+	peer := &net.UDPAddr{IP: net.ParseIP("fe80::5054:ff:feaa:882a"), Port: 546}
+
+	tests := map[string]struct {
+		backend  *mockBackend
+		wantType dhcpv6.MessageType
+		wantAddr string
+		wantURL  string
+	}{
+		"reservation and netboot": {
+			backend:  &mockBackend{allowNetboot: true, ipv6: netip.MustParseAddr("2001:db8::10")},
+			wantType: dhcpv6.MessageTypeAdvertise,
+			wantAddr: "2001:db8::10",
+			wantURL:  "tftp://[2001:db8::1]:69/snp.efi",
+		},
+		"no IPv6 reservation": {
+			backend:  &mockBackend{allowNetboot: true},
+			wantType: dhcpv6.MessageTypeAdvertise,
+			wantURL:  "tftp://[2001:db8::1]:69/snp.efi",
+		},
+		"netboot not allowed": {
+			backend:  &mockBackend{ipv6: netip.MustParseAddr("2001:db8::10")},
+			wantType: dhcpv6.MessageTypeAdvertise,
+			wantAddr: "2001:db8::10",
+		},
+		"hardware not found": {backend: &mockBackend{hardwareNotFound: true}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := &Handler{
+				Backend:    tt.backend,
+				Log:        logr.Discard(),
+				ServerDUID: serverDUID,
+				Netboot: Netboot{
+					Enabled:            true,
+					IPXEBinServerTFTP6: netip.MustParseAddrPort("[2001:db8::1]:69"),
+				},
+			}
+			req, err := dhcpv6.NewMessage(
+				dhcpv6.WithClientID(&dhcpv6.DUIDUUID{UUID: [16]byte{1, 2, 3}}),
+				dhcpv6.WithRequestedOptions(dhcpv6.OptionBootfileURL),
+				dhcpv6.WithArchType(iana.EFI_ARM64),
+				dhcpv6.WithIAID([4]byte{0, 0, 0, 1}),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.MessageType = dhcpv6.MessageTypeSolicit
+
+			conn := &capture6{}
+			h.Handle6(context.Background(), conn, data.Packet6{Peer: peer, Pkt: req})
+
+			if tt.wantType == 0 {
+				if conn.out != nil {
+					t.Fatalf("Handle6() sent a response, want none")
+				}
+				return
+			}
+			got, err := dhcpv6.MessageFromBytes(conn.out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Type() != tt.wantType {
+				t.Errorf("message type = %s, want %s", got.Type(), tt.wantType)
+			}
+			if !got.Options.ServerID().Equal(serverDUID) {
+				t.Errorf("server ID = %s, want %s", got.Options.ServerID(), serverDUID)
+			}
+			var addr string
+			if a := got.Options.OneIANA().Options.OneAddress(); a != nil {
+				addr = a.IPv6Addr.String()
+				if a.ValidLifetime != 60*time.Second {
+					t.Errorf("valid lifetime = %s, want 1m0s", a.ValidLifetime)
+				}
+			}
+			if addr != tt.wantAddr {
+				t.Errorf("IA_NA address = %q, want %q", addr, tt.wantAddr)
+			}
+			if u := got.Options.BootFileURL(); u != tt.wantURL {
+				t.Errorf("boot file URL = %q, want %q", u, tt.wantURL)
+			}
+		})
+	}
+}
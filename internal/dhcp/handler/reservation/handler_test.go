@@ -33,6 +33,7 @@ type mockBackend struct {
 	allowNetboot     bool
 	ipxeScript       *url.URL
 	hardwareNotFound bool
+	ipv6             netip.Addr
 }
 
 type hwNotFoundError struct{}
@@ -68,6 +69,7 @@ func (m *mockBackend) GetByMac(
 		DomainSearch: []string{
 			"mydomain.com",
 		},
+		IPv6Address: m.ipv6,
 	}
 	n := &data.Netboot{
 		AllowNetboot:  m.allowNetboot,
@@ -809,3 +811,54 @@ func TestLeaseManagerAdapter(t *testing.T) {
 		t.Errorf("Unexpected error clearing declined IPs: %v", err)
 	}
 }
+
+// reservingBackend has a reservation only for reservedMAC.
+type reservingBackend struct{ mockBackend }
+
+var reservedMAC = net.HardwareAddr{0x01, 0x02, 0x03, 0x04, 0x05, 0x06}
+
+func (reservingBackend) HasReservation(mac net.HardwareAddr) bool {
+	return mac.String() == reservedMAC.String()
+}
+
+func TestNotReserved(t *testing.T) {
+	h := &Handler{Backend: &reservingBackend{}, IPAddr: netip.MustParseAddr("192.168.1.1")}
+	other := net.HardwareAddr{0x01, 0x02, 0x03, 0x04, 0x05, 0x07}
+
+	tests := map[string]struct {
+		handler *Handler
+		mac     net.HardwareAddr
+		mods    []dhcpv4.Modifier
+		want    string
+	}{
+		"reserved discover": {handler: h, mac: reservedMAC,
+			mods: []dhcpv4.Modifier{dhcpv4.WithMessageType(dhcpv4.MessageTypeDiscover)}},
+		"unreserved discover": {handler: h, mac: other,
+			mods: []dhcpv4.Modifier{dhcpv4.WithMessageType(dhcpv4.MessageTypeDiscover)},
+			want: "no reservation"},
+		"request for this server": {handler: h, mac: reservedMAC, mods: []dhcpv4.Modifier{
+			dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest),
+			dhcpv4.WithOption(dhcpv4.OptServerIdentifier(net.IP{192, 168, 1, 1})),
+		}},
+		"request for another server": {handler: h, mac: reservedMAC, mods: []dhcpv4.Modifier{
+			dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest),
+			dhcpv4.WithOption(dhcpv4.OptServerIdentifier(net.IP{192, 168, 1, 2})),
+		}, want: "request for another server"},
+		"backend without reservations": {
+			handler: &Handler{Backend: &mockBackend{}, IPAddr: netip.MustParseAddr("192.168.1.1")},
+			mac:     other,
+			mods:    []dhcpv4.Modifier{dhcpv4.WithMessageType(dhcpv4.MessageTypeDiscover)},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			pkt, err := dhcpv4.New(append(tt.mods, dhcpv4.WithHwAddr(tt.mac))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.handler.notReserved(pkt); got != tt.want {
+				t.Errorf("notReserved() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"net"
 	"net/netip"
 	"net/url"
+	"slices"
 	"strings"
 
 	"github.com/insomniacslk/dhcp/dhcpv4"
@@ -28,7 +29,9 @@ func (h *Handler) setDHCPOpts(
 		dhcpv4.WithYourIP(d.IPAddress.AsSlice()),
 		dhcpv4.WithClientIP(pkt.ClientIPAddr),
 		dhcpv4.WithGeneric(dhcpv4.OptionTFTPServerName, []byte(pkt.ServerIPAddr)),
-		dhcpv4.WithGeneric(dhcpv4.OptionBootfileName, []byte("snp.efi")),
+	}
+	if file := h.Netboot.BootFiles.File(dhcp.Arch(pkt)); file != "" {
+		mods = append(mods, dhcpv4.WithGeneric(dhcpv4.OptionBootfileName, []byte(file)))
 	}
 
 	// arch := dhcp.Arch(pkt)
@@ -102,6 +105,24 @@ func (h *Handler) setDHCPOpts(
 	return mods
 }
 
+// setExtraOpts returns modifiers for the arbitrary options declared for the
+// client, by its backend record and then by HostOptions. They are applied
+// after every other option of the reply, so that an operator can override
+// any of them, such as option 43 or the MTU.
+func (h *Handler) setExtraOpts(d *data.DHCP) []dhcpv4.Modifier {
+	opts := d.Options
+	if h.HostOptions != nil {
+		opts = append(slices.Clip(opts), h.HostOptions.EncodeDHCPOptions(d.MACAddress)...)
+	}
+
+	mods := make([]dhcpv4.Modifier, 0, len(opts))
+	for _, o := range opts {
+		mods = append(mods, dhcpv4.WithGeneric(dhcpv4.GenericOptionCode(o.Code), o.Value))
+	}
+
+	return mods
+}
+
 // setNetworkBootOpts purpose is to sets 3 or 4 values. 2 DHCP headers, option 43 and optionally option (60).
 // These headers and options are returned as a dhcvp4.Modifier that can be used to modify a dhcp response.
 // github.com/insomniacslk/dhcp uses this method to simplify packet manipulation.
@@ -134,13 +155,13 @@ func (h *Handler) setNetworkBootOpts(
 		d.ServerIPAddr = net.IPv4(0, 0, 0, 0)
 		if n.AllowNetboot {
 			i := dhcp.NewInfo(m)
-			if i.IPXEBinary == "" {
+			if h.Netboot.BootFiles.File(i.Arch) == "" {
 				return
 			}
 			var ipxeScript *url.URL
 			// If the global IPXEScriptURL is set, use that.
 			if n.IPXEScriptURL != nil {
-				ipxeScript = n.IPXEScriptURL
+				ipxeScript = h.Netboot.backendScriptURL(n.IPXEScriptURL, m.ClientHWAddr)
 			} else if h.Netboot.IPXEScriptURL != nil {
 				ipxeScript = h.Netboot.IPXEScriptURL(m)
 			}
@@ -179,6 +200,7 @@ func (h *Handler) bootfileAndNextServer(
 	var nextServer net.IP
 	var bootfile string
 	i := dhcp.NewInfo(pkt)
+	i.IPXEBinary = h.Netboot.BootFiles.File(i.Arch)
 	if tp := otel.TraceparentStringFromContext(ctx); h.OTELEnabled && tp != "" {
 		i.IPXEBinary = fmt.Sprintf("%s-%v", i.IPXEBinary, tp)
 	}
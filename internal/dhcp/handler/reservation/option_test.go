@@ -2,9 +2,13 @@ package reservation
 
 import (
 	"context"
+	"log/slog"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"net/netip"
 	"net/url"
+	"slices"
 	"testing"
 	"time"
 
@@ -14,6 +18,7 @@ import (
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/iana"
 	"github.com/insomniacslk/dhcp/rfc1035label"
+	"github.com/metal3-community/metal-boot/internal/bootauth"
 	"github.com/metal3-community/metal-boot/internal/dhcp"
 	"github.com/metal3-community/metal-boot/internal/dhcp/data"
 	oteldhcp "github.com/metal3-community/metal-boot/internal/dhcp/otel"
@@ -126,6 +131,43 @@ func TestSetDHCPOpts(t *testing.T) {
 	}
 }
 
+// hostOptions is a dhcp.HostOptions with fixed options for every host.
+type hostOptions []data.Option
+
+func (o hostOptions) EncodeDHCPOptions(net.HardwareAddr) []data.Option {
+	return o
+}
+
+func TestSetExtraOpts(t *testing.T) {
+	d := &data.DHCP{
+		MACAddress: net.HardwareAddr{0x00, 0x00, 0x00, 0x00, 0x00, 0x01},
+		Hostname:   "test-server",
+		Options:    []data.Option{{Code: 26, Value: []byte{0x05, 0xdc}}},
+	}
+	h := &Handler{
+		Log:         logr.Discard(),
+		HostOptions: hostOptions{{Code: 43, Value: []byte{0x01, 0x01, 0x01}}},
+	}
+	// Extra options are applied last and so override the reply's own.
+	d.Options = append(d.Options, data.Option{Code: 12, Value: []byte("override")})
+
+	mods := h.setDHCPOpts(context.Background(), &dhcpv4.DHCPv4{}, d)
+	pkt, err := dhcpv4.New(append(mods, h.setExtraOpts(d)...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := pkt.Options.Get(dhcpv4.OptionInterfaceMTU); !slices.Equal(got, []byte{0x05, 0xdc}) {
+		t.Errorf("option 26 = %x, want 05dc", got)
+	}
+	got := pkt.Options.Get(dhcpv4.OptionVendorSpecificInformation)
+	if !slices.Equal(got, []byte{1, 1, 1}) {
+		t.Errorf("option 43 = %x, want 010101", got)
+	}
+	if got := pkt.HostName(); got != "override" {
+		t.Errorf("host name = %q, want override", got)
+	}
+}
+
 func TestBootfileAndNextServer(t *testing.T) {
 	type args struct {
 		pkt     *dhcpv4.DHCPv4
@@ -381,3 +423,68 @@ func TestSetNetworkBootOpts(t *testing.T) {
 		})
 	}
 }
+
+func TestSignedScriptURL(t *testing.T) {
+	v := &bootauth.Verifier{
+		Log:    slog.New(slog.DiscardHandler),
+		Secret: []byte("secret"),
+		TTL:    time.Minute,
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/v1/boot/{mac}/boot.ipxe", v.Middleware(
+		bootauth.PathValueMAC("mac"),
+		http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.Write([]byte("#!ipxe\n")) }),
+	))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	base, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac := net.HardwareAddr{0x01, 0x02, 0x03, 0x04, 0x05, 0x06}
+	script := &url.URL{Scheme: "http", Host: base.Host, Path: "/v1/boot/" + mac.String() + "/boot.ipxe"}
+
+	tests := map[string]*data.Netboot{
+		"handler URL": {AllowNetboot: true},
+		"backend URL": {AllowNetboot: true, IPXEScriptURL: script},
+	}
+	for name, n := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := &Handler{
+				Log: logr.Discard(),
+				Netboot: Netboot{
+					IPXEScriptURL: func(d *dhcpv4.DHCPv4) *url.URL { return v.SignURL(script, d.ClientHWAddr) },
+					SignURL:       v.SignURL,
+				},
+			}
+			m := &dhcpv4.DHCPv4{
+				ClientHWAddr: mac,
+				Options: dhcpv4.OptionsFromList(
+					dhcpv4.OptUserClass(dhcp.Ironic.String()),
+					dhcpv4.OptClassIdentifier("HTTPClient:xxxxx"),
+					dhcpv4.OptClientArch(iana.EFI_X86_64_HTTP),
+				),
+			}
+			offer := new(dhcpv4.DHCPv4)
+			h.setNetworkBootOpts(context.Background(), m, n)(offer)
+
+			resp, err := http.Get(offer.BootFileName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("GET %s = %d, want %d", offer.BootFileName, resp.StatusCode, http.StatusOK)
+			}
+		})
+	}
+
+	resp, err := http.Get(script.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("GET of the unsigned URL = %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+}
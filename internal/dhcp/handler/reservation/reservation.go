@@ -1,4 +1,5 @@
-// Package reservation is the handler for responding to DHCPv4 messages with only host reservations.
+// Package reservation is the handler for responding to DHCPv4 and DHCPv6 messages with only host
+// reservations.
 package reservation
 
 import (
@@ -8,9 +9,12 @@ import (
 
 	"github.com/go-logr/logr"
 	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
 	"github.com/metal3-community/metal-boot/internal/backend"
+	"github.com/metal3-community/metal-boot/internal/bootflow"
 	"github.com/metal3-community/metal-boot/internal/dhcp"
 	"github.com/metal3-community/metal-boot/internal/dhcp/arp"
+	"github.com/metal3-community/metal-boot/internal/leasedb"
 )
 
 // Handler holds the configuration details for the running the DHCP server.
@@ -22,6 +26,10 @@ type Handler struct {
 	// This should typically be the same instance as Backend if it implements lease management.
 	LeaseBackend LeaseManager
 
+	// Leases records the lifecycle of the leases handed out and detects
+	// addresses in conflict. If nil, leases are not tracked.
+	Leases *leasedb.DB
+
 	// ARPDetector provides ARP-based IP conflict detection.
 	// If nil, ARP conflict detection will be disabled.
 	ARPDetector *arp.ConflictDetector
@@ -49,6 +57,77 @@ type Handler struct {
 
 	// Interface name for ARP operations. If empty, ARP detection is disabled.
 	InterfaceName string
+
+	// BootTracker counts netboot attempts per client. If nil, attempts are not tracked.
+	BootTracker dhcp.BootTracker
+
+	// BootFlows assigns boot correlation IDs. If nil, packets are not correlated.
+	BootFlows *bootflow.Registry
+
+	// ReservationsOnly makes the handler answer only clients with a static
+	// reservation and stay silent for everyone else, including requests that
+	// select another server, so that it can run next to another DHCP server.
+	ReservationsOnly bool
+
+	// MachineIDs records the machine UUID that clients send in option 97.
+	// If nil, UUIDs are not recorded.
+	MachineIDs dhcp.MachineIDRecorder
+
+	// Clients records the DHCP fingerprint of clients. If nil, fingerprints
+	// are not recorded.
+	Clients dhcp.ClientRecorder
+
+	// Identities links locally administered MAC addresses to the hosts they
+	// belong to before the backend is read. If nil, every MAC address is a
+	// host of its own.
+	Identities dhcp.IdentityLinker
+
+	// NetbootGate withholds netboot options from provisioned hosts. If nil,
+	// every netboot client is offered them.
+	NetbootGate dhcp.NetbootGate
+
+	// BootFlow follows netboot clients along the boot flow. If nil, it is
+	// not followed.
+	BootFlow dhcp.BootFlow
+
+	// ServerDUID identifies the server in DHCPv6 option 2.
+	ServerDUID dhcpv6.DUID
+
+	// Stats counts replies and DISCOVERs from unknown clients. If nil, they
+	// are not counted.
+	Stats dhcp.StatsRecorder
+
+	// Admission staggers netboot offers during boot storms. If nil, offers
+	// are sent at once.
+	Admission dhcp.Admission
+
+	// HostOptions adds DHCP options declared for a host outside the backend.
+	// They override the options of the backend. If nil, only the backend
+	// declares extra options.
+	HostOptions dhcp.HostOptions
+}
+
+// Reserver is implemented by backends that tell static reservations apart
+// from dynamically assigned leases. Every record of a backend that does not
+// implement it counts as a reservation.
+type Reserver interface {
+	HasReservation(mac net.HardwareAddr) bool
+}
+
+// reserverOf returns the Reserver of b or of a backend it wraps.
+func reserverOf(b backend.BackendReader) Reserver {
+	for b != nil {
+		if r, ok := b.(Reserver); ok {
+			return r
+		}
+		u, ok := b.(interface{ Unwrap() backend.BackendReader })
+		if !ok {
+			return nil
+		}
+		b = u.Unwrap()
+	}
+
+	return nil
 }
 
 // LeaseManager provides methods for lease management and IP conflict tracking.
@@ -91,4 +170,33 @@ type Netboot struct {
 
 	// UserClass (for network booting) allows a custom DHCP option 77 to be used to break out of an iPXE loop.
 	UserClass dhcp.UserClass
+
+	// IPXEBinServerTFTP6 is the iPXE binary TFTP server on an IPv6 address,
+	// for the boot file URLs of DHCPv6 clients.
+	IPXEBinServerTFTP6 netip.AddrPort
+
+	// IPXEBinServerHTTP6 is the URL of the iPXE binary HTTP server on an IPv6
+	// address, for the boot file URLs of DHCPv6 clients.
+	IPXEBinServerHTTP6 *url.URL
+
+	// IPXEScriptURL6 is the URL of the iPXE script for DHCPv6 clients.
+	IPXEScriptURL6 func(net.HardwareAddr) *url.URL
+
+	// SignURL, if set, signs the iPXE script URLs backends provide for a
+	// client, as IPXEScriptURL and IPXEScriptURL6 sign their own.
+	SignURL func(*url.URL, net.HardwareAddr) *url.URL
+
+	// BootFiles overrides the iPXE binary offered to the architectures of
+	// clients. If nil, dhcp.ArchToBootFile is used.
+	BootFiles dhcp.BootFiles
+}
+
+// backendScriptURL returns the iPXE script URL u a backend provides for mac,
+// signed if n signs URLs.
+func (n Netboot) backendScriptURL(u *url.URL, mac net.HardwareAddr) *url.URL {
+	if n.SignURL == nil {
+		return u
+	}
+
+	return n.SignURL(u, mac)
 }
@@ -0,0 +1,214 @@
+package option
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Built-in encoders.
+var (
+	// String sends the value as is.
+	String Encoder = EncoderFunc(encodeString)
+	// IPv4 encodes a single IPv4 address.
+	IPv4 Encoder = EncoderFunc(encodeIPv4)
+	// IPv4List encodes a comma separated list of IPv4 addresses.
+	IPv4List Encoder = EncoderFunc(encodeIPv4List)
+	// Bool encodes "true"/"false" (or "1"/"0") as a single byte.
+	Bool Encoder = EncoderFunc(encodeBool)
+	// Uint8 encodes an unsigned 8-bit integer.
+	Uint8 Encoder = uintEncoder(8)
+	// Uint16 encodes an unsigned 16-bit integer in network byte order.
+	Uint16 Encoder = uintEncoder(16)
+	// Uint32 encodes an unsigned 32-bit integer in network byte order.
+	Uint32 Encoder = uintEncoder(32)
+	// Int32 encodes a signed 32-bit integer in network byte order.
+	Int32 Encoder = EncoderFunc(encodeInt32)
+	// Hex encodes a hex blob written as "01:02:ff", "0102ff" or "0x0102ff".
+	Hex Encoder = EncoderFunc(encodeHex)
+	// DomainSearch encodes a comma separated list of domains as an RFC 3397
+	// search list, using RFC 1035 name compression.
+	DomainSearch Encoder = EncoderFunc(encodeDomainSearch)
+	// ClasslessRoutes encodes comma separated "<cidr>,<router>" pairs as an
+	// RFC 3442 classless static route list.
+	ClasslessRoutes Encoder = EncoderFunc(encodeClasslessRoutes)
+)
+
+var errEmpty = errors.New("value must not be empty")
+
+func encodeString(value string) ([]byte, error) {
+	if value == "" {
+		return nil, errEmpty
+	}
+	if strings.ContainsAny(value, "\n\r") {
+		return nil, errors.New("value must be a single line")
+	}
+
+	return []byte(value), nil
+}
+
+func parseIPv4(s string) (net.IP, error) {
+	ip := net.ParseIP(strings.TrimSpace(s)).To4()
+	if ip == nil {
+		return nil, fmt.Errorf("%q is not an IPv4 address", s)
+	}
+
+	return ip, nil
+}
+
+func encodeIPv4(value string) ([]byte, error) {
+	return parseIPv4(value)
+}
+
+func encodeIPv4List(value string) ([]byte, error) {
+	if value == "" {
+		return nil, errEmpty
+	}
+
+	var out []byte
+	for _, s := range strings.Split(value, ",") {
+		ip, err := parseIPv4(s)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, ip...)
+	}
+
+	return out, nil
+}
+
+func encodeBool(value string) ([]byte, error) {
+	b, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a boolean", value)
+	}
+	if b {
+		return []byte{1}, nil
+	}
+
+	return []byte{0}, nil
+}
+
+func uintEncoder(bits int) Encoder {
+	return EncoderFunc(func(value string) ([]byte, error) {
+		n, err := strconv.ParseUint(strings.TrimSpace(value), 10, bits)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an unsigned %d-bit integer", value, bits)
+		}
+
+		out := make([]byte, 8)
+		binary.BigEndian.PutUint64(out, n)
+		return out[8-bits/8:], nil
+	})
+}
+
+func encodeInt32(value string) ([]byte, error) {
+	n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a 32-bit integer", value)
+	}
+
+	return binary.BigEndian.AppendUint32(nil, uint32(int32(n))), nil
+}
+
+func encodeHex(value string) ([]byte, error) {
+	s := strings.TrimPrefix(strings.TrimSpace(value), "0x")
+	s = strings.ReplaceAll(s, ":", "")
+	if s == "" {
+		return nil, errEmpty
+	}
+
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a hex string", value)
+	}
+
+	return b, nil
+}
+
+// encodeDomainSearch encodes domains with RFC 1035 section 4.1.4 compression:
+// a name whose suffix was already written ends with a pointer to it.
+func encodeDomainSearch(value string) ([]byte, error) {
+	if value == "" {
+		return nil, errEmpty
+	}
+
+	var out []byte
+	offsets := make(map[string]int)
+	for _, domain := range strings.Split(value, ",") {
+		domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+		if domain == "" {
+			return nil, errors.New("empty domain in search list")
+		}
+		labels := strings.Split(domain, ".")
+		for _, l := range labels {
+			if l == "" || len(l) > 63 {
+				return nil, fmt.Errorf("invalid label in domain %q", domain)
+			}
+		}
+
+		pointer := -1
+		for i := range labels {
+			suffix := strings.Join(labels[i:], ".")
+			if off, ok := offsets[suffix]; ok {
+				pointer = off
+				labels = labels[:i]
+				break
+			}
+			if len(out) < 0x3fff {
+				offsets[suffix] = len(out) + labelsLen(labels[:i])
+			}
+		}
+
+		for _, l := range labels {
+			out = append(out, byte(len(l)))
+			out = append(out, l...)
+		}
+		if pointer >= 0 {
+			out = binary.BigEndian.AppendUint16(out, 0xc000|uint16(pointer))
+		} else {
+			out = append(out, 0)
+		}
+	}
+
+	return out, nil
+}
+
+func labelsLen(labels []string) int {
+	n := 0
+	for _, l := range labels {
+		n += 1 + len(l)
+	}
+
+	return n
+}
+
+func encodeClasslessRoutes(value string) ([]byte, error) {
+	fields := strings.Split(value, ",")
+	if value == "" || len(fields)%2 != 0 {
+		return nil, errors.New("routes must be comma separated <cidr>,<router> pairs")
+	}
+
+	var out []byte
+	for i := 0; i < len(fields); i += 2 {
+		_, dest, err := net.ParseCIDR(strings.TrimSpace(fields[i]))
+		if err != nil || dest.IP.To4() == nil {
+			return nil, fmt.Errorf("%q is not an IPv4 network", fields[i])
+		}
+		router, err := parseIPv4(fields[i+1])
+		if err != nil {
+			return nil, err
+		}
+
+		ones, _ := dest.Mask.Size()
+		out = append(out, byte(ones))
+		out = append(out, dest.IP.To4()[:(ones+7)/8]...)
+		out = append(out, router...)
+	}
+
+	return out, nil
+}
@@ -0,0 +1,99 @@
+// Package option encodes DHCPv4 option values from their textual form (as
+// written in dnsmasq option files or the admin API) into wire format.
+//
+// Each option code has an Encoder; codes without a registered encoder are
+// sent as plain strings. Additional encoders can be plugged in with Register.
+package option
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Encoder converts an option value in textual form to its wire format.
+type Encoder interface {
+	Encode(value string) ([]byte, error)
+}
+
+// EncoderFunc adapts a function to the Encoder interface.
+type EncoderFunc func(value string) ([]byte, error)
+
+// Encode calls f(value).
+func (f EncoderFunc) Encode(value string) ([]byte, error) {
+	return f(value)
+}
+
+var (
+	mu       sync.RWMutex
+	encoders = map[uint8]Encoder{
+		1:   IPv4,         // subnet mask
+		2:   Int32,        // time offset
+		3:   IPv4List,     // routers
+		4:   IPv4List,     // time servers
+		6:   IPv4List,     // domain name servers
+		7:   IPv4List,     // log servers
+		12:  String,       // host name
+		15:  String,       // domain name
+		19:  Bool,         // IP forwarding
+		23:  Uint8,        // default IP TTL
+		26:  Uint16,       // interface MTU
+		28:  IPv4,         // broadcast address
+		42:  IPv4List,     // NTP servers
+		43:  Hex,          // vendor specific information
+		44:  IPv4List,     // NetBIOS name servers
+		51:  Uint32,       // lease time
+		54:  IPv4,         // server identifier
+		58:  Uint32,       // renewal time
+		59:  Uint32,       // rebinding time
+		60:  String,       // vendor class identifier
+		66:  String,       // TFTP server name
+		67:  String,       // bootfile name
+		119: DomainSearch, // domain search list
+		121: ClasslessRoutes,
+		150: IPv4List, // TFTP server addresses
+		249: ClasslessRoutes,
+	}
+)
+
+// Register sets the encoder for code, replacing any existing one.
+func Register(code uint8, enc Encoder) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	encoders[code] = enc
+}
+
+// Lookup returns the encoder for code, falling back to String.
+func Lookup(code uint8) Encoder {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if enc, ok := encoders[code]; ok {
+		return enc
+	}
+
+	return String
+}
+
+// Encode encodes value for option code.
+func Encode(code uint8, value string) ([]byte, error) {
+	if code == 0 || code == 255 {
+		return nil, fmt.Errorf("option code %d is reserved", code)
+	}
+
+	b, err := Lookup(code).Encode(value)
+	if err != nil {
+		return nil, fmt.Errorf("option %d: %w", code, err)
+	}
+	if len(b) > 255 {
+		return nil, fmt.Errorf("option %d: encoded value is %d bytes, longer than 255", code, len(b))
+	}
+
+	return b, nil
+}
+
+// Validate reports whether value can be encoded for option code.
+func Validate(code uint8, value string) error {
+	_, err := Encode(code, value)
+	return err
+}
@@ -0,0 +1,82 @@
+package option
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name    string
+		code    uint8
+		value   string
+		want    []byte
+		wantErr bool
+	}{
+		{name: "string", code: 67, value: "ipxe.efi", want: []byte("ipxe.efi")},
+		{name: "unknown code is a string", code: 224, value: "abc", want: []byte("abc")},
+		{name: "ip list", code: 6, value: "1.1.1.1, 8.8.8.8", want: []byte{1, 1, 1, 1, 8, 8, 8, 8}},
+		{name: "bad ip list", code: 3, value: "gateway", wantErr: true},
+		{name: "ipv6 rejected", code: 3, value: "::1", wantErr: true},
+		{name: "uint16", code: 26, value: "9000", want: []byte{0x23, 0x28}},
+		{name: "uint16 overflow", code: 26, value: "70000", wantErr: true},
+		{name: "uint32", code: 51, value: "3600", want: []byte{0, 0, 0x0e, 0x10}},
+		{name: "int32", code: 2, value: "-1", want: []byte{0xff, 0xff, 0xff, 0xff}},
+		{name: "bool", code: 19, value: "true", want: []byte{1}},
+		{name: "hex colons", code: 43, value: "01:02:ff", want: []byte{1, 2, 0xff}},
+		{name: "hex prefix", code: 43, value: "0x0102ff", want: []byte{1, 2, 0xff}},
+		{name: "bad hex", code: 43, value: "zz", wantErr: true},
+		{
+			// RFC 3397 section 2 example.
+			name:  "domain search compression",
+			code:  119,
+			value: "eng.apple.com,marketing.apple.com",
+			want: []byte{
+				3, 'e', 'n', 'g', 5, 'a', 'p', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0,
+				9, 'm', 'a', 'r', 'k', 'e', 't', 'i', 'n', 'g', 0xc0, 0x04,
+			},
+		},
+		{name: "domain search duplicate", code: 119, value: "example.com,example.com", want: []byte{
+			7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0, 0xc0, 0x00,
+		}},
+		{name: "domain search empty label", code: 119, value: "a..com", wantErr: true},
+		{
+			name:  "classless routes",
+			code:  121,
+			value: "10.0.0.0/8,192.168.1.1,0.0.0.0/0,192.168.1.254",
+			want:  []byte{8, 10, 192, 168, 1, 1, 0, 192, 168, 1, 254},
+		},
+		{name: "classless routes odd fields", code: 121, value: "10.0.0.0/8", wantErr: true},
+		{name: "reserved code", code: 255, value: "x", wantErr: true},
+		{name: "empty string", code: 66, value: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Encode(tt.code, tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Encode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !bytes.Equal(got, tt.want) {
+				t.Errorf("Encode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegister(t *testing.T) {
+	const code = 254
+	t.Cleanup(func() {
+		mu.Lock()
+		delete(encoders, code)
+		mu.Unlock()
+	})
+
+	Register(code, Uint8)
+	got, err := Encode(code, "7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, []byte{7}) {
+		t.Errorf("Encode() = %v, want [7]", got)
+	}
+}
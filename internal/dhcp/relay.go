@@ -0,0 +1,41 @@
+package dhcp
+
+import (
+	"net"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// RelayAgent returns the DHCP server port of the relay agent pkt came
+// through, and false if pkt was not relayed.
+//
+// Replies to relayed packets go back through the relay agent, which
+// delivers them on the network of the client. They must echo the relay agent
+// information (option 82) of pkt with all its sub-options (RFC 3046), which
+// replies built with dhcpv4.NewReplyFromRequest do.
+func RelayAgent(pkt *dhcpv4.DHCPv4) (*net.UDPAddr, bool) {
+	giaddr := pkt.GatewayIPAddr
+	if giaddr == nil || giaddr.IsUnspecified() {
+		return nil, false
+	}
+
+	return &net.UDPAddr{IP: giaddr, Port: dhcpv4.ServerPort}, true
+}
+
+// ReplyDestination returns where reply, the reply to pkt, is sent, following
+// section 4.1 of RFC 2131: the relay agent of a relayed pkt; else ciaddr of
+// a client that has an address; else the broadcast address if the client
+// asked for a broadcast reply; else the address assigned in reply.
+func ReplyDestination(pkt, reply *dhcpv4.DHCPv4) *net.UDPAddr {
+	if relay, ok := RelayAgent(pkt); ok {
+		return relay
+	}
+	if ci := pkt.ClientIPAddr; ci != nil && !ci.IsUnspecified() {
+		return &net.UDPAddr{IP: ci, Port: dhcpv4.ClientPort}
+	}
+	if pkt.IsBroadcast() {
+		return &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort}
+	}
+
+	return &net.UDPAddr{IP: reply.YourIPAddr, Port: dhcpv4.ClientPort}
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/go-logr/logr"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/dhcpv6/server6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+	"golang.org/x/net/ipv6"
+)
+
+// Handler6 is a type that defines the handler function to be called every
+// time a valid DHCPv6 message is received.
+type Handler6 interface {
+	Handle6(ctx context.Context, conn net.PacketConn, d data.Packet6)
+}
+
+// DHCP6 represents a DHCPv6 server object.
+type DHCP6 struct {
+	Conn     net.PacketConn
+	Handlers []Handler6
+	Logger   logr.Logger
+}
+
+// Serve serves requests.
+func (s *DHCP6) Serve(ctx context.Context) error {
+	go func() {
+		<-ctx.Done()
+		_ = s.Close()
+	}()
+	s.Logger.Info("DHCPv6 server listening on", "addr", s.Conn.LocalAddr())
+
+	nConn := ipv6.NewPacketConn(s.Conn)
+	if err := nConn.SetControlMessage(ipv6.FlagInterface, true); err != nil {
+		s.Logger.Info("error setting control message", "err", err)
+		return err
+	}
+
+	for {
+		rbuf := make([]byte, 4096)
+		n, cm, peer, err := nConn.ReadFrom(rbuf)
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				return nil
+			default:
+			}
+			s.Logger.Info("error reading from packet conn", "err", err)
+			return err
+		}
+
+		m, err := dhcpv6.FromBytes(rbuf[:n])
+		if err != nil {
+			s.Logger.Info("error parsing DHCPv6 request", "err", err)
+			continue
+		}
+
+		upeer, ok := peer.(*net.UDPAddr)
+		if !ok {
+			s.Logger.Info("not a UDP connection? Peer is", "peer", peer)
+			continue
+		}
+
+		md := &data.Metadata{}
+		if cm != nil {
+			md.IfIndex = cm.IfIndex
+			if n, err := net.InterfaceByIndex(cm.IfIndex); err == nil {
+				md.IfName = n.Name
+			}
+		}
+		// Replies to link-local clients need the zone to leave on the right
+		// interface.
+		if upeer.IP.IsLinkLocalUnicast() && upeer.Zone == "" {
+			upeer = &net.UDPAddr{IP: upeer.IP, Port: upeer.Port, Zone: md.IfName}
+		}
+
+		for _, handler := range s.Handlers {
+			go handler.Handle6(ctx, s.Conn, data.Packet6{Peer: upeer, Pkt: m, Md: md})
+		}
+	}
+}
+
+// Close sends a termination request to the server, and closes the UDP listener.
+func (s *DHCP6) Close() error {
+	return s.Conn.Close()
+}
+
+// NewServer6 initializes and returns a new DHCPv6 Server object listening on
+// addr. On the wildcard address, the All_DHCP_Relay_Agents_and_Servers group
+// is joined on ifname so that clients on the link are heard.
+func NewServer6(ifname string, addr *net.UDPAddr, handler ...Handler6) (*DHCP6, error) {
+	s := &DHCP6{
+		Handlers: handler,
+		Logger:   logr.Discard(),
+	}
+
+	conn, err := server6.NewIPv6UDPConn(ifname, addr)
+	if err != nil {
+		return nil, err
+	}
+	if addr.IP == nil || addr.IP.IsUnspecified() {
+		var iface *net.Interface
+		if ifname != "" {
+			if iface, err = net.InterfaceByName(ifname); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+		group := &net.UDPAddr{IP: dhcpv6.AllDHCPRelayAgentsAndServers}
+		if err := ipv6.NewPacketConn(conn).JoinGroup(iface, group); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to join %s: %w", group.IP, err)
+		}
+	}
+	s.Conn = conn
+
+	return s, nil
+}
+
+// DUID returns the DUID-LL of the network interface ifname, which identifies
+// the server in DHCPv6 option 2.
+func DUID(ifname string) (dhcpv6.DUID, error) {
+	iface, err := net.InterfaceByName(ifname)
+	if err != nil {
+		return nil, err
+	}
+	if len(iface.HardwareAddr) == 0 {
+		return nil, fmt.Errorf("interface %s has no hardware address", ifname)
+	}
+
+	return &dhcpv6.DUIDLL{HWType: iana.HWTypeEthernet, LinkLayerAddr: iface.HardwareAddr}, nil
+}
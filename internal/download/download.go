@@ -0,0 +1,285 @@
+// Package download tracks the progress of downloads the server performs in
+// the background, such as self updates, remote firmware images and IPA image
+// pulls, so that operators can see how far they are and spot stuck ones.
+//
+// A Download is an io.Writer that counts the bytes written to it; callers tee
+// the body they fetch into it. All methods of a nil Tracker and a nil Download
+// do nothing, so tracking is optional wherever it is threaded through.
+package download
+
+import (
+	"context"
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+// State is the state of a download.
+type State string
+
+const (
+	StateRunning   State = "Running"
+	StateCompleted State = "Completed"
+	StateFailed    State = "Failed"
+)
+
+const (
+	// StallAfter is how long a running download may go without receiving a
+	// byte before it is reported as stalled.
+	StallAfter = 30 * time.Second
+	// rateWindow is the minimum interval the transfer rate is measured over.
+	rateWindow = time.Second
+	// keepFinished is the number of finished downloads a Tracker remembers.
+	keepFinished = 50
+)
+
+// Progress is a snapshot of a download.
+type Progress struct {
+	Id    string `json:"id"`
+	Name  string `json:"name"`
+	URL   string `json:"url"`
+	State State  `json:"state"`
+	// Bytes is the number of bytes received so far.
+	Bytes int64 `json:"bytes"`
+	// Total is the size of the download, or -1 when the source did not say.
+	Total int64 `json:"total"`
+	// BytesPerSecond is the recent transfer rate of a running download, or
+	// the average rate of a finished one.
+	BytesPerSecond float64 `json:"bytesPerSecond"`
+	// ETASeconds is the estimated time left, when the total and a rate are
+	// known.
+	ETASeconds *int64 `json:"etaSeconds,omitempty"`
+	// Stalled is set on running downloads that have not received a byte for
+	// StallAfter.
+	Stalled        bool       `json:"stalled"`
+	StartTime      time.Time  `json:"startTime"`
+	LastProgressAt time.Time  `json:"lastProgressAt"`
+	EndTime        *time.Time `json:"endTime,omitempty"`
+	Error          string     `json:"error,omitempty"`
+}
+
+// Percent returns how much of the download is done, or -1 when the total is
+// unknown.
+func (p Progress) Percent() int {
+	switch {
+	case p.State == StateCompleted:
+		return 100
+	case p.Total <= 0:
+		return -1
+	default:
+		return int(min(p.Bytes*100/p.Total, 100))
+	}
+}
+
+// Tracker records the downloads of the server.
+type Tracker struct {
+	mu        sync.Mutex
+	downloads []*Download
+
+	// now is time.Now outside tests.
+	now func() time.Time
+}
+
+// NewTracker returns an empty Tracker.
+func NewTracker() *Tracker {
+	return &Tracker{now: time.Now}
+}
+
+// Download is one tracked download.
+type Download struct {
+	t *Tracker
+
+	// The fields below are guarded by t.mu.
+	p Progress
+	// sampleAt and sampleBytes are the start of the current rate window.
+	sampleAt    time.Time
+	sampleBytes int64
+}
+
+// Start records a download of url with unknown size. id must be unique among
+// the downloads of t and is used in URLs.
+func (t *Tracker) Start(id, name, url string) *Download {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	d := &Download{
+		t: t,
+		p: Progress{
+			Id:             id,
+			Name:           name,
+			URL:            url,
+			State:          StateRunning,
+			Total:          -1,
+			StartTime:      now,
+			LastProgressAt: now,
+		},
+		sampleAt: now,
+	}
+	t.downloads = slices.DeleteFunc(t.downloads, func(o *Download) bool { return o.p.Id == id })
+	t.downloads = append(t.downloads, d)
+	t.prune()
+
+	return d
+}
+
+// prune forgets the oldest finished downloads beyond keepFinished. Callers
+// must hold t.mu.
+func (t *Tracker) prune() {
+	finished := 0
+	for i := len(t.downloads) - 1; i >= 0; i-- {
+		if t.downloads[i].p.State == StateRunning {
+			continue
+		}
+		if finished++; finished > keepFinished {
+			t.downloads = slices.Delete(t.downloads, i, i+1)
+		}
+	}
+}
+
+// List returns the downloads of t, oldest first.
+func (t *Tracker) List() []Progress {
+	if t == nil {
+		return []Progress{}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	list := make([]Progress, 0, len(t.downloads))
+	for _, d := range t.downloads {
+		list = append(list, d.snapshot(now))
+	}
+
+	return list
+}
+
+// Get returns the download with id.
+func (t *Tracker) Get(id string) (Progress, bool) {
+	if t == nil {
+		return Progress{}, false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, d := range t.downloads {
+		if d.p.Id == id {
+			return d.snapshot(t.now()), true
+		}
+	}
+
+	return Progress{}, false
+}
+
+// ID returns the id of d.
+func (d *Download) ID() string {
+	if d == nil {
+		return ""
+	}
+
+	return d.p.Id
+}
+
+// SetTotal records the size of the download, for example the Content-Length
+// of the response. Negative sizes mean unknown.
+func (d *Download) SetTotal(total int64) {
+	if d == nil {
+		return
+	}
+
+	d.t.mu.Lock()
+	defer d.t.mu.Unlock()
+
+	d.p.Total = max(total, -1)
+}
+
+// Write counts len(p) received bytes. It never fails.
+func (d *Download) Write(p []byte) (int, error) {
+	if d == nil || len(p) == 0 {
+		return len(p), nil
+	}
+
+	d.t.mu.Lock()
+	defer d.t.mu.Unlock()
+
+	now := d.t.now()
+	d.p.Bytes += int64(len(p))
+	d.p.LastProgressAt = now
+	if elapsed := now.Sub(d.sampleAt); elapsed >= rateWindow {
+		d.p.BytesPerSecond = float64(d.p.Bytes-d.sampleBytes) / elapsed.Seconds()
+		d.sampleAt = now
+		d.sampleBytes = d.p.Bytes
+	}
+
+	return len(p), nil
+}
+
+// Done marks d completed, or failed with err.
+func (d *Download) Done(err error) {
+	if d == nil {
+		return
+	}
+
+	d.t.mu.Lock()
+	defer d.t.mu.Unlock()
+
+	if d.p.State != StateRunning {
+		return
+	}
+	now := d.t.now()
+	d.p.EndTime = &now
+	d.p.State = StateCompleted
+	if err != nil {
+		d.p.State = StateFailed
+		d.p.Error = err.Error()
+	}
+	if elapsed := now.Sub(d.p.StartTime); elapsed > 0 {
+		d.p.BytesPerSecond = float64(d.p.Bytes) / elapsed.Seconds()
+	}
+	d.t.prune()
+}
+
+// snapshot returns the progress of d at now. Callers must hold d.t.mu.
+func (d *Download) snapshot(now time.Time) Progress {
+	p := d.p
+	if p.State != StateRunning {
+		return p
+	}
+
+	if now.Sub(p.LastProgressAt) >= StallAfter {
+		p.Stalled = true
+		p.BytesPerSecond = 0
+	} else if p.BytesPerSecond == 0 {
+		// No full rate window yet; use the average so far.
+		if elapsed := now.Sub(p.StartTime); elapsed > 0 {
+			p.BytesPerSecond = float64(p.Bytes) / elapsed.Seconds()
+		}
+	}
+	if p.Total >= 0 && p.BytesPerSecond > 0 {
+		eta := int64(math.Ceil(float64(max(p.Total-p.Bytes, 0)) / p.BytesPerSecond))
+		p.ETASeconds = &eta
+	}
+
+	return p
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying d, for functions that perform a
+// download on behalf of a caller that tracks it.
+func NewContext(ctx context.Context, d *Download) context.Context {
+	return context.WithValue(ctx, contextKey{}, d)
+}
+
+// FromContext returns the Download carried by ctx, or nil.
+func FromContext(ctx context.Context) *Download {
+	d, _ := ctx.Value(contextKey{}).(*Download)
+	return d
+}
@@ -0,0 +1,86 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestProgress(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	tr := NewTracker()
+	tr.now = func() time.Time { return now }
+
+	d := tr.Start("update-1", "Update", "http://example.com/image")
+	d.SetTotal(1000)
+	now = now.Add(2 * time.Second)
+	d.Write(make([]byte, 200))
+
+	p, ok := tr.Get("update-1")
+	if !ok {
+		t.Fatal("Get() did not find the download")
+	}
+	if p.Bytes != 200 || p.BytesPerSecond != 100 || p.Percent() != 20 {
+		t.Errorf("progress = %d bytes at %v B/s, %d%%, want 200 at 100, 20%%",
+			p.Bytes, p.BytesPerSecond, p.Percent())
+	}
+	if p.ETASeconds == nil || *p.ETASeconds != 8 {
+		t.Errorf("ETASeconds = %v, want 8", p.ETASeconds)
+	}
+
+	now = now.Add(StallAfter)
+	if p, _ := tr.Get("update-1"); !p.Stalled || p.ETASeconds != nil {
+		t.Errorf("progress after %s without data = %+v, want stalled without ETA", StallAfter, p)
+	}
+
+	d.Write(make([]byte, 800))
+	d.Done(nil)
+	p, _ = tr.Get("update-1")
+	if p.State != StateCompleted || p.Stalled || p.EndTime == nil || p.Percent() != 100 {
+		t.Errorf("progress after Done(nil) = %+v", p)
+	}
+
+	f := tr.Start("update-2", "Update", "http://example.com/missing")
+	f.Done(errors.New("404 Not Found"))
+	if p, _ := tr.Get("update-2"); p.State != StateFailed || p.Error != "404 Not Found" ||
+		p.Percent() != -1 {
+		t.Errorf("progress after Done(err) = %+v", p)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	tr := NewTracker()
+	running := tr.Start("running", "", "")
+	for i := range keepFinished + 5 {
+		tr.Start(fmt.Sprint(i), "", "").Done(nil)
+	}
+
+	list := tr.List()
+	if len(list) != keepFinished+1 {
+		t.Fatalf("List() has %d downloads, want %d", len(list), keepFinished+1)
+	}
+	if list[0].Id != "running" || list[1].Id != "5" {
+		t.Errorf("List() starts with %s, %s, want running, 5", list[0].Id, list[1].Id)
+	}
+	running.Done(nil)
+}
+
+func TestNil(t *testing.T) {
+	var tr *Tracker
+	d := tr.Start("id", "name", "url")
+	if n, err := d.Write([]byte("data")); n != 4 || err != nil {
+		t.Errorf("Write() on nil = %d, %v", n, err)
+	}
+	d.SetTotal(10)
+	d.Done(nil)
+	if list := tr.List(); list == nil || len(list) != 0 {
+		t.Errorf("List() on nil = %v, want empty", list)
+	}
+
+	ctx := NewContext(context.Background(), NewTracker().Start("id", "", ""))
+	if FromContext(ctx).ID() != "id" || FromContext(context.Background()) != nil {
+		t.Error("FromContext() did not return the download of NewContext()")
+	}
+}
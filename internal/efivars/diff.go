@@ -0,0 +1,79 @@
+package efivars
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+
+	"github.com/metal3-community/uefi-firmware-manager/efi"
+)
+
+// Change kinds of a Change.
+const (
+	Added   = "added"
+	Removed = "removed"
+	Changed = "changed"
+)
+
+// Change is a difference between two varstores.
+type Change struct {
+	Name string `json:"name"`
+	Guid string `json:"guid"`
+	// Kind is Added, Removed or Changed.
+	Kind string `json:"kind"`
+	// Before and After are the variable in each varstore, if it is in it.
+	Before *Variable `json:"before,omitempty"`
+	After  *Variable `json:"after,omitempty"`
+}
+
+// Diff returns the variables that differ in data or attributes between the
+// varstores before and after, decoded and sorted by name and GUID.
+func Diff(before, after map[string]*efi.EfiVar) []Change {
+	index := func(vars map[string]*efi.EfiVar) map[string]*efi.EfiVar {
+		out := make(map[string]*efi.EfiVar, len(vars))
+		for _, v := range vars {
+			out[v.Name.String()+"/"+v.Guid.String()] = v
+		}
+		return out
+	}
+	a, b := index(before), index(after)
+
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+
+	changes := []Change{}
+	for _, k := range keys {
+		va, vb := a[k], b[k]
+		var c Change
+		switch {
+		case va == nil:
+			c.Kind = Added
+		case vb == nil:
+			c.Kind = Removed
+		case va.Attr != vb.Attr || !bytes.Equal(va.Data, vb.Data):
+			c.Kind = Changed
+		default:
+			continue
+		}
+		c.Name, c.Guid, _ = strings.Cut(k, "/")
+		if va != nil {
+			d := Decode(va)
+			c.Before = &d
+		}
+		if vb != nil {
+			d := Decode(vb)
+			c.After = &d
+		}
+		changes = append(changes, c)
+	}
+
+	return changes
+}
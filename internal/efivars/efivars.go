@@ -0,0 +1,396 @@
+// Package efivars knows the UEFI variables found in the varstores of the
+// Raspberry Pi EDK2 firmware and decodes their data into values people can
+// read: boot options with their titles and device paths, boot orders, console
+// device paths, Raspberry Pi settings, network stack settings and so on.
+// Variables it does not know are shown by their data only.
+package efivars
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/metal3-community/uefi-firmware-manager/efi"
+)
+
+// Format is how the data of a variable is encoded.
+type Format string
+
+const (
+	// FormatUint8, FormatUint16, FormatUint32 and FormatUint64 are little
+	// endian unsigned integers.
+	FormatUint8  Format = "uint8"
+	FormatUint16 Format = "uint16"
+	FormatUint32 Format = "uint32"
+	FormatUint64 Format = "uint64"
+	// FormatBool8 and FormatBool32 are booleans stored in one or four bytes.
+	FormatBool8  Format = "bool8"
+	FormatBool32 Format = "bool32"
+	// FormatASCII and FormatUCS2 are NUL terminated strings.
+	FormatASCII Format = "ascii"
+	FormatUCS2  Format = "ucs2"
+	// FormatLoadOption is an EFI_LOAD_OPTION, such as a Boot#### variable.
+	FormatLoadOption Format = "load-option"
+	// FormatOptionOrder is a list of uint16 option numbers, such as
+	// BootOrder; FormatOptionNumber is one, such as BootNext.
+	FormatOptionOrder  Format = "option-order"
+	FormatOptionNumber Format = "option-number"
+	// FormatDevicePath is a device path, possibly of several instances.
+	FormatDevicePath Format = "device-path"
+	// FormatDUID is a DHCPv6 DUID.
+	FormatDUID Format = "duid"
+	// FormatEpoch is a uint64 count of seconds since the Unix epoch.
+	FormatEpoch Format = "epoch"
+	// FormatInterface is the configuration of a network interface, in a
+	// variable named after the MAC address of the interface. Its value is
+	// that address; the configuration itself is only shown as data.
+	FormatInterface Format = "interface"
+	// FormatOpaque is data that is not decoded, such as signature lists.
+	FormatOpaque Format = "opaque"
+)
+
+// Vendor GUIDs of the variables of the Raspberry Pi firmware and its network
+// stack that the efi package does not name.
+const (
+	RaspberryPiConfig = "cd7cc258-31db-22e6-9f22-63b0b8eed6b5"
+	ConsolePref       = "2d2358b4-e96c-484d-b2dd-7c2edfc7d56f"
+	Ip4Config2        = "5b446ed1-e30b-4faa-871a-3654eca36080"
+)
+
+// Definition describes a known variable.
+type Definition struct {
+	// Name is the name of the variable. Each # matches one hexadecimal
+	// digit, so that Boot#### matches Boot0001.
+	Name string
+	// Guid is the vendor GUID of the variable, in lower case. An empty Guid
+	// matches the variable name under any vendor.
+	Guid        string
+	Format      Format
+	Description string
+	// Values names the values of enumerations stored as integers.
+	Values map[uint64]string
+}
+
+// Short names of the vendors of most definitions.
+const (
+	global = efi.EfiGlobalVariable
+	rpi    = RaspberryPiConfig
+)
+
+var definitions = []Definition{
+	// Global variables of the UEFI specification.
+	{"Boot####", global, FormatLoadOption, "Boot option", nil},
+	{"Driver####", global, FormatLoadOption, "Driver load option", nil},
+	{"SysPrep####", global, FormatLoadOption, "System preparation application", nil},
+	{"PlatformRecovery####", global, FormatLoadOption, "Platform recovery option", nil},
+	{"BootOrder", global, FormatOptionOrder, "Order boot options are tried in", nil},
+	{"DriverOrder", global, FormatOptionOrder, "Order drivers are loaded in", nil},
+	{"SysPrepOrder", global, FormatOptionOrder, "Order SysPrep options run in", nil},
+	{"BootNext", global, FormatOptionNumber, "Boot option of the next boot only", nil},
+	{"BootCurrent", global, FormatOptionNumber, "Boot option of the current boot", nil},
+	{"Timeout", global, FormatUint16, "Seconds before the default boot option", nil},
+	{"ConIn", global, FormatDevicePath, "Console input device", nil},
+	{"ConOut", global, FormatDevicePath, "Console output device", nil},
+	{"ErrOut", global, FormatDevicePath, "Error output device", nil},
+	{"ConInDev", global, FormatDevicePath, "Available console input devices", nil},
+	{"ConOutDev", global, FormatDevicePath, "Available console output devices", nil},
+	{"ErrOutDev", global, FormatDevicePath, "Available error output devices", nil},
+	{"Lang", global, FormatASCII, "Language (ISO 639-2)", nil},
+	{"LangCodes", global, FormatASCII, "Supported languages (ISO 639-2)", nil},
+	{"PlatformLang", global, FormatASCII, "Language (RFC 4646)", nil},
+	{"PlatformLangCodes", global, FormatASCII, "Supported languages (RFC 4646)", nil},
+	{"OsIndications", global, FormatUint64, "Features requested by the OS", nil},
+	{"OsIndicationsSupported", global, FormatUint64, "Features the firmware supports", nil},
+	{"BootOptionSupport", global, FormatUint32, "Boot manager features", nil},
+	{"SecureBoot", global, FormatBool8, "Secure Boot is enforced", nil},
+	{"SetupMode", global, FormatBool8, "No platform key is enrolled", nil},
+	{"AuditMode", global, FormatBool8, "Secure Boot audit mode", nil},
+	{"DeployedMode", global, FormatBool8, "Secure Boot deployed mode", nil},
+	{"PK", global, FormatOpaque, "Platform key", nil},
+	{"KEK", global, FormatOpaque, "Key exchange keys", nil},
+	{"db", efi.EfiImageSecurityDatabase, FormatOpaque, "Allowed signature database", nil},
+	{"dbx", efi.EfiImageSecurityDatabase, FormatOpaque, "Forbidden signature database", nil},
+	{"SecureBootEnable", efi.EfiSecureBootEnableDisable, FormatBool8, "Secure Boot enabled", nil},
+	{"CustomMode", efi.EfiCustomModeEnable, FormatBool8, "Secure Boot keys change unsigned", nil},
+
+	// Network stack settings of the Raspberry Pi firmware, which keeps them
+	// under the global GUID.
+	{"IPv6Support", global, FormatBool32, "IPv6 PXE and HTTP boot options", nil},
+	{"VLANEnable", global, FormatBool32, "Network boot traffic is tagged", nil},
+	{"VLANID", global, FormatUint32, "VLAN of network boot traffic", nil},
+	{"ClientId", efi.EfiDhcp6ServiceBindingProtocol, FormatDUID, "DHCPv6 client DUID", nil},
+	{"############", Ip4Config2, FormatInterface, "IPv4 configuration of a NIC", nil},
+	{"############", efi.EfiIp6ConfigProtocol, FormatInterface, "IPv6 configuration of a NIC", nil},
+
+	// Settings of the Raspberry Pi configuration driver.
+	{"CpuClock", rpi, FormatUint32, "CPU clock", map[uint64]string{
+		0: "Low", 1: "Default", 2: "Max", 3: "Custom",
+	}},
+	{"CustomCpuClock", rpi, FormatUint32, "Custom CPU clock in MHz", nil},
+	{"RamMoreThan3GB", rpi, FormatBool32, "The board has more than 3 GB of RAM", nil},
+	{"RamLimitTo3GB", rpi, FormatBool32, "The OS is limited to 3 GB of RAM", nil},
+	{"SystemTableMode", rpi, FormatUint32, "Hardware description tables", map[uint64]string{
+		0: "ACPI", 1: "ACPI + Devicetree", 2: "Devicetree",
+	}},
+	{"AssetTag", rpi, FormatUCS2, "Asset tag", nil},
+	{"SdIsArasan", rpi, FormatBool32, "The SD card is on the Arasan controller", nil},
+	{"MmcDisableMulti", rpi, FormatBool32, "No multi-block MMC transfers", nil},
+	{"MmcForce1Bit", rpi, FormatBool32, "MMC transfers use one data line", nil},
+	{"MmcForceDefaultSpeed", rpi, FormatBool32, "MMC high speed is disabled", nil},
+	{"MmcSdDefaultSpeedMHz", rpi, FormatUint32, "SD default speed in MHz", nil},
+	{"MmcSdHighSpeedMHz", rpi, FormatUint32, "SD high speed in MHz", nil},
+	{"MmcEnableDma", rpi, FormatBool32, "MMC transfers use DMA", nil},
+	{"DisplayEnableScaledVModes", rpi, FormatUint32, "Enabled scaled video modes", nil},
+	{"DisplayEnableSShot", rpi, FormatBool32, "Screenshots are enabled", nil},
+	{"FanOnGpio", rpi, FormatUint32, "GPIO of the fan", nil},
+	{"FanTemp", rpi, FormatUint32, "Temperature the fan turns on at", nil},
+	{"XhciReload", rpi, FormatBool32, "The XHCI firmware is reloaded", nil},
+	{"ConsolePref", ConsolePref, FormatUint32, "Console preference", map[uint64]string{
+		0: "Auto", 1: "Serial", 2: "Graphical",
+	}},
+
+	{"RtcEpochSeconds", "", FormatEpoch, "Time of the virtual real time clock", nil},
+}
+
+// Lookup returns the definition of the variable name of vendor guid.
+func Lookup(name string, guid efi.GUID) (Definition, bool) {
+	vendor := guid.String()
+	for _, d := range definitions {
+		if (d.Guid == "" || strings.EqualFold(d.Guid, vendor)) && matchName(d.Name, name) {
+			return d, true
+		}
+	}
+
+	return Definition{}, false
+}
+
+// Definitions returns the definitions of the known variables.
+func Definitions() []Definition {
+	return slices.Clone(definitions)
+}
+
+// matchName reports whether name matches pattern, in which each # matches a
+// hexadecimal digit.
+func matchName(pattern, name string) bool {
+	if len(pattern) != len(name) {
+		return false
+	}
+	for i := range len(pattern) {
+		if pattern[i] == '#' {
+			if !isHex(name[i]) {
+				return false
+			}
+			continue
+		}
+		if pattern[i] != name[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// Variable is a variable with its data decoded.
+type Variable struct {
+	Name string `json:"name"`
+	Guid string `json:"guid"`
+	// Vendor names Guid, if it is a GUID the firmware manager knows.
+	Vendor      string   `json:"vendor,omitempty"`
+	Attributes  []string `json:"attributes"`
+	Description string   `json:"description,omitempty"`
+	Format      Format   `json:"format,omitempty"`
+	// Value is the decoded data, for known variables that decode.
+	Value any `json:"value,omitempty"`
+	// Error explains why the data of a known variable did not decode.
+	Error string `json:"error,omitempty"`
+	// Data is the data of the variable in hexadecimal.
+	Data string `json:"data"`
+}
+
+// LoadOption is the value of a FormatLoadOption variable.
+type LoadOption struct {
+	Title      string `json:"title"`
+	DevicePath string `json:"devicePath"`
+	Active     bool   `json:"active"`
+	Hidden     bool   `json:"hidden,omitempty"`
+	// OptionalData is the data passed to the loaded image, in hexadecimal.
+	OptionalData string `json:"optionalData,omitempty"`
+}
+
+var attributeNames = []struct {
+	bit  uint32
+	name string
+}{
+	{efi.EFI_VARIABLE_NON_VOLATILE, "NonVolatile"},
+	{efi.EFI_VARIABLE_BOOTSERVICE_ACCESS, "BootserviceAccess"},
+	{efi.EFI_VARIABLE_RUNTIME_ACCESS, "RuntimeAccess"},
+	{efi.EFI_VARIABLE_HARDWARE_ERROR_RECORD, "HardwareErrorRecord"},
+	{efi.EFI_VARIABLE_AUTHENTICATED_WRITE_ACCESS, "AuthenticatedWriteAccess"},
+	{efi.EFI_VARIABLE_TIME_BASED_AUTHENTICATED_WRITE_ACCESS, "TimeBasedAuthenticatedWriteAccess"},
+	{efi.EFI_VARIABLE_APPEND_WRITE, "AppendWrite"},
+}
+
+// attributes returns the names of the attribute bits set in attr.
+func attributes(attr uint32) []string {
+	out := []string{}
+	for _, a := range attributeNames {
+		if attr&a.bit != 0 {
+			out = append(out, a.name)
+			attr &^= a.bit
+		}
+	}
+	if attr != 0 {
+		out = append(out, fmt.Sprintf("0x%x", attr))
+	}
+
+	return out
+}
+
+// Decode returns v with its data decoded, if it is a known variable.
+func Decode(v *efi.EfiVar) Variable {
+	name := v.Name.String()
+	out := Variable{
+		Name:       name,
+		Guid:       v.Guid.String(),
+		Attributes: attributes(v.Attr),
+		Data:       hex.EncodeToString(v.Data),
+	}
+	if vendor := efi.GuidName(v.Guid); vendor != out.Guid {
+		out.Vendor = vendor
+	}
+
+	d, ok := Lookup(name, v.Guid)
+	if !ok {
+		return out
+	}
+	out.Description = d.Description
+	out.Format = d.Format
+	value, err := d.decode(name, v.Data)
+	if err != nil {
+		out.Error = err.Error()
+		return out
+	}
+	out.Value = value
+
+	return out
+}
+
+var errLength = errors.New("unexpected data length")
+
+// decode decodes data, the data of the variable name.
+func (d Definition) decode(name string, data []byte) (any, error) {
+	switch d.Format {
+	case FormatUint8, FormatUint16, FormatUint32, FormatUint64:
+		n, err := uintValue(d.Format, data)
+		if err != nil {
+			return nil, err
+		}
+		if s, ok := d.Values[n]; ok {
+			return s, nil
+		}
+		return n, nil
+	case FormatBool8, FormatBool32:
+		format := FormatUint8
+		if d.Format == FormatBool32 {
+			format = FormatUint32
+		}
+		n, err := uintValue(format, data)
+		return n != 0, err
+	case FormatASCII:
+		s, _, _ := strings.Cut(string(data), "\x00")
+		return s, nil
+	case FormatUCS2:
+		return efi.FromUCS16(data).String(), nil
+	case FormatLoadOption:
+		return loadOption(data)
+	case FormatOptionOrder:
+		if len(data)%2 != 0 {
+			return nil, errLength
+		}
+		prefix := strings.TrimSuffix(name, "Order")
+		order := make([]string, 0, len(data)/2)
+		for i := 0; i < len(data); i += 2 {
+			order = append(order, fmt.Sprintf("%s%04X", prefix,
+				binary.LittleEndian.Uint16(data[i:])))
+		}
+		return order, nil
+	case FormatOptionNumber:
+		n, err := uintValue(FormatUint16, data)
+		if err != nil {
+			return nil, err
+		}
+		return fmt.Sprintf("Boot%04X", n), nil
+	case FormatDevicePath:
+		return efi.NewDevicePath(data).String(), nil
+	case FormatDUID:
+		duid, err := efi.NewDhcp6Duid(data)
+		if err != nil {
+			return nil, err
+		}
+		return duid.String(), nil
+	case FormatEpoch:
+		n, err := uintValue(FormatUint64, data)
+		if err != nil {
+			return nil, err
+		}
+		return time.Unix(int64(n), 0).UTC(), nil
+	case FormatInterface:
+		mac, err := hex.DecodeString(name)
+		if err != nil {
+			return nil, err
+		}
+		return net.HardwareAddr(mac).String(), nil
+	default:
+		return nil, nil
+	}
+}
+
+// uintValue decodes data as a little endian integer of format.
+func uintValue(format Format, data []byte) (uint64, error) {
+	sizes := map[Format]int{FormatUint8: 1, FormatUint16: 2, FormatUint32: 4, FormatUint64: 8}
+	size := sizes[format]
+	if len(data) != size {
+		return 0, fmt.Errorf("%w: %d bytes for %s", errLength, len(data), format)
+	}
+	var buf [8]byte
+	copy(buf[:], data)
+
+	return binary.LittleEndian.Uint64(buf[:]), nil
+}
+
+func loadOption(data []byte) (LoadOption, error) {
+	entry, err := efi.ParseBootEntry(data)
+	if err != nil {
+		return LoadOption{}, err
+	}
+
+	return LoadOption{
+		Title:        entry.Title.String(),
+		DevicePath:   entry.DevicePath.String(),
+		Active:       entry.Attr&efi.LOAD_OPTION_ACTIVE != 0,
+		Hidden:       entry.Attr&efi.LOAD_OPTION_HIDDEN != 0,
+		OptionalData: hex.EncodeToString(entry.OptData),
+	}, nil
+}
+
+// DecodeAll decodes vars, sorted by name and GUID.
+func DecodeAll(vars map[string]*efi.EfiVar) []Variable {
+	out := make([]Variable, 0, len(vars))
+	for _, v := range vars {
+		out = append(out, Decode(v))
+	}
+	slices.SortFunc(out, func(a, b Variable) int {
+		return strings.Compare(a.Name+"/"+a.Guid, b.Name+"/"+b.Guid)
+	})
+
+	return out
+}
@@ -0,0 +1,134 @@
+package efivars
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/metal3-community/uefi-firmware-manager/efi"
+)
+
+func variable(name, guid string, data ...byte) *efi.EfiVar {
+	return &efi.EfiVar{
+		Name: efi.FromString(name),
+		Guid: efi.ParseGuid(guid),
+		Attr: efi.EfiVariableDefault | efi.EfiVariableRuntimeAccess,
+		Data: data,
+	}
+}
+
+func TestDecode(t *testing.T) {
+	mac, _ := net.ParseMAC("d8:3a:dd:01:02:03")
+	dp := (&efi.DevicePath{}).Mac(mac).IPv4()
+	title := efi.FromString("UEFI PXEv4 (MAC:D83ADD010203)")
+	boot := efi.NewBootEntry(nil, efi.LOAD_OPTION_ACTIVE, title, dp, nil).Bytes()
+
+	tests := []struct {
+		v         *efi.EfiVar
+		want      any
+		wantError bool
+	}{
+		{v: variable("BootOrder", efi.EfiGlobalVariable, 3, 0, 0x0a, 0),
+			want: "[Boot0003 Boot000A]"},
+		{v: variable("BootNext", efi.EfiGlobalVariable, 1, 0), want: "Boot0001"},
+		{v: variable("Timeout", efi.EfiGlobalVariable, 5, 0), want: uint64(5)},
+		{v: variable("CpuClock", RaspberryPiConfig, 2, 0, 0, 0), want: "Max"},
+		{v: variable("CpuClock", RaspberryPiConfig, 9, 0, 0, 0), want: uint64(9)},
+		{v: variable("RamLimitTo3GB", RaspberryPiConfig, 1, 0, 0, 0), want: true},
+		{v: variable("PlatformLang", efi.EfiGlobalVariable, 'e', 'n', 0), want: "en"},
+		{v: variable("D83ADD010203", Ip4Config2, 1, 2), want: "d8:3a:dd:01:02:03"},
+		{v: variable("Timeout", efi.EfiGlobalVariable, 5), wantError: true},
+		// A Timeout of another vendor is not the global Timeout.
+		{v: variable("Timeout", RaspberryPiConfig, 5, 0)},
+		{v: variable("Boot", efi.EfiGlobalVariable)},
+	}
+	for _, tt := range tests {
+		name := tt.v.Name.String()
+		got := Decode(tt.v)
+		if (got.Error != "") != tt.wantError {
+			t.Errorf("Decode(%s).Error = %q, want error %v", name, got.Error, tt.wantError)
+		}
+		var value any = got.Value
+		if order, ok := value.([]string); ok {
+			value = "[" + strings.Join(order, " ") + "]"
+		}
+		if value != tt.want {
+			t.Errorf("Decode(%s).Value = %#v, want %#v", name, got.Value, tt.want)
+		}
+	}
+
+	got := Decode(variable("Boot0001", efi.EfiGlobalVariable, boot...))
+	opt, ok := got.Value.(LoadOption)
+	if !ok || opt.Title != title.String() || !opt.Active ||
+		!strings.Contains(opt.DevicePath, "MAC(") {
+		t.Errorf("Decode(Boot0001) = %+v", got)
+	}
+	if got.Vendor != "EfiGlobalVariable" || got.Description == "" ||
+		strings.Join(got.Attributes, ",") != "NonVolatile,BootserviceAccess,RuntimeAccess" {
+		t.Errorf("Decode(Boot0001) = %+v", got)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	before := map[string]*efi.EfiVar{
+		"Timeout":  variable("Timeout", efi.EfiGlobalVariable, 5, 0),
+		"CpuClock": variable("CpuClock", RaspberryPiConfig, 1, 0, 0, 0),
+		"AssetTag": variable("AssetTag", RaspberryPiConfig, 'a', 0, 0, 0),
+	}
+	after := map[string]*efi.EfiVar{
+		"Timeout":  variable("Timeout", efi.EfiGlobalVariable, 5, 0),
+		"CpuClock": variable("CpuClock", RaspberryPiConfig, 2, 0, 0, 0),
+		"BootNext": variable("BootNext", efi.EfiGlobalVariable, 1, 0),
+	}
+
+	changes := Diff(before, after)
+	var got []string
+	for _, c := range changes {
+		got = append(got, c.Kind+" "+c.Name)
+	}
+	want := "removed AssetTag,added BootNext,changed CpuClock"
+	if strings.Join(got, ",") != want {
+		t.Fatalf("Diff = %v, want %s", got, want)
+	}
+	if c := changes[2]; c.Before.Value != "Default" || c.After.Value != "Max" {
+		t.Errorf("CpuClock changed from %v to %v, want Default to Max",
+			c.Before.Value, c.After.Value)
+	}
+	if c := changes[0]; c.Before.Value != "a" || c.After != nil {
+		t.Errorf("removed AssetTag = %+v", c)
+	}
+}
+
+func TestSignatureLists(t *testing.T) {
+	owner := "77fa9abd-0359-4d32-bd60-28f4e78f784b"
+	sigs := []Signature{
+		{Type: efi.EfiCertX509, Owner: owner, Data: []byte("certificate one")},
+		{Type: efi.EfiCertX509, Owner: owner, Data: []byte("cert two")},
+		{Type: efi.EfiCertSha256, Owner: owner, Data: make([]byte, 32)},
+		{Type: efi.EfiCertSha256, Owner: owner, Data: append(make([]byte, 31), 1)},
+	}
+
+	data := EncodeSignatureLists(sigs)
+	// Certificates of different sizes need lists of their own, hashes share
+	// one: 3 headers, 4 owners and the data.
+	if want := 3*28 + 4*16 + 15 + 8 + 2*32; len(data) != want {
+		t.Errorf("EncodeSignatureLists() is %d bytes, want %d", len(data), want)
+	}
+	got, err := ParseSignatureLists(data)
+	if err != nil {
+		t.Fatalf("ParseSignatureLists() error = %v", err)
+	}
+	if len(got) != len(sigs) {
+		t.Fatalf("ParseSignatureLists() = %d signatures, want %d", len(got), len(sigs))
+	}
+	for i := range sigs {
+		if got[i].Type != sigs[i].Type || got[i].Owner != owner ||
+			string(got[i].Data) != string(sigs[i].Data) {
+			t.Errorf("signature %d = %+v, want %+v", i, got[i], sigs[i])
+		}
+	}
+
+	if _, err := ParseSignatureLists(data[:len(data)-1]); err == nil {
+		t.Error("ParseSignatureLists() of truncated data succeeded")
+	}
+}
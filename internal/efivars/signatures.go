@@ -0,0 +1,110 @@
+package efivars
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/metal3-community/uefi-firmware-manager/efi"
+)
+
+// Secure Boot key databases. PK and KEK are global variables, db and dbx are
+// variables of the image security database.
+const (
+	DatabasePK  = "PK"
+	DatabaseKEK = "KEK"
+	DatabaseDB  = "db"
+	DatabaseDBX = "dbx"
+)
+
+// AttrAuthenticated are the attributes of the Secure Boot key databases.
+const AttrAuthenticated = efi.EfiVariableNonVolatile | efi.EfiVariableBootserviceAccess |
+	efi.EfiVariableRuntimeAccess | efi.EfiVariableTimeBasedAuthenticatedWriteAccess
+
+// ErrInvalidSignatureList is returned for data that is not a sequence of
+// EFI_SIGNATURE_LISTs.
+var ErrInvalidSignatureList = errors.New("invalid signature list")
+
+// signatureListHeaderSize is the size of the fixed EFI_SIGNATURE_LIST header:
+// the signature type GUID and three uint32 sizes.
+const signatureListHeaderSize = 16 + 3*4
+
+// Signature is one entry of a signature database, such as an X.509
+// certificate or the SHA-256 hash of a forbidden image.
+type Signature struct {
+	// Type is the signature type GUID, such as efi.EfiCertX509.
+	Type string
+	// Owner is the GUID of the agent that enrolled the signature.
+	Owner string
+	Data  []byte
+}
+
+// DatabaseGuid returns the vendor GUID of the key database name.
+func DatabaseGuid(name string) (string, bool) {
+	switch name {
+	case DatabasePK, DatabaseKEK:
+		return efi.EfiGlobalVariable, true
+	case DatabaseDB, DatabaseDBX:
+		return efi.EfiImageSecurityDatabase, true
+	}
+	return "", false
+}
+
+// ParseSignatureLists decodes the signatures of the EFI_SIGNATURE_LISTs in
+// data, the content of a key database variable.
+func ParseSignatureLists(data []byte) ([]Signature, error) {
+	var sigs []Signature
+	for len(data) > 0 {
+		if len(data) < signatureListHeaderSize {
+			return nil, fmt.Errorf("%w: truncated header", ErrInvalidSignatureList)
+		}
+		sigType := efi.ParseBinGUID(data, 0).String()
+		listSize := int(binary.LittleEndian.Uint32(data[16:]))
+		headerSize := int(binary.LittleEndian.Uint32(data[20:]))
+		sigSize := int(binary.LittleEndian.Uint32(data[24:]))
+		if listSize > len(data) || sigSize < 16 ||
+			signatureListHeaderSize+headerSize > listSize ||
+			(listSize-signatureListHeaderSize-headerSize)%sigSize != 0 {
+			return nil, fmt.Errorf("%w: inconsistent sizes", ErrInvalidSignatureList)
+		}
+
+		entries := data[signatureListHeaderSize+headerSize : listSize]
+		for ; len(entries) > 0; entries = entries[sigSize:] {
+			sigs = append(sigs, Signature{
+				Type:  sigType,
+				Owner: efi.ParseBinGUID(entries, 0).String(),
+				Data:  append([]byte(nil), entries[16:sigSize]...),
+			})
+		}
+		data = data[listSize:]
+	}
+
+	return sigs, nil
+}
+
+// EncodeSignatureLists encodes sigs as EFI_SIGNATURE_LISTs, one list for every
+// run of signatures of the same type and size.
+func EncodeSignatureLists(sigs []Signature) []byte {
+	var out []byte
+	for len(sigs) > 0 {
+		n := 1
+		for n < len(sigs) && sigs[n].Type == sigs[0].Type &&
+			len(sigs[n].Data) == len(sigs[0].Data) {
+			n++
+		}
+		sigSize := 16 + len(sigs[0].Data)
+
+		list := efi.StringToGUID(sigs[0].Type).Bytes()
+		list = binary.LittleEndian.AppendUint32(list, uint32(signatureListHeaderSize+n*sigSize))
+		list = binary.LittleEndian.AppendUint32(list, 0)
+		list = binary.LittleEndian.AppendUint32(list, uint32(sigSize))
+		for _, sig := range sigs[:n] {
+			list = append(list, efi.StringToGUID(sig.Owner).Bytes()...)
+			list = append(list, sig.Data...)
+		}
+		out = append(out, list...)
+		sigs = sigs[n:]
+	}
+
+	return out
+}
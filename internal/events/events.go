@@ -0,0 +1,73 @@
+// Package events distributes provisioning events, such as a host reporting
+// that its operating system is up, to the parts of metal-boot that act on them.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Type names what happened to a host.
+type Type string
+
+const (
+	// ProvisioningComplete is published when a host phones home after its
+	// operating system came up.
+	ProvisioningComplete Type = "provisioning-complete"
+	// NodeDiscovered is published when metal-boot first records a host, such
+	// as a new machine netbooting.
+	NodeDiscovered Type = "node-discovered"
+	// BootStateChanged is published when a host moves along the boot flow,
+	// such as when it is offered netboot or fetches its iPXE script.
+	BootStateChanged Type = "boot-state-changed"
+	// RogueDHCPServer is published when another DHCP server answers on the
+	// provisioning network. MAC is the client it answered and IP the server.
+	RogueDHCPServer Type = "rogue-dhcp-server"
+)
+
+// Event is something that happened to a host.
+type Event struct {
+	Type Type `json:"type"`
+	// MAC is the normalized MAC address of the host.
+	MAC string `json:"mac"`
+	// IP is the address the host was seen at, if known.
+	IP string `json:"ip,omitempty"`
+	// State is the host state after the event.
+	State     string    `json:"state,omitempty"`
+	Message   string    `json:"message,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Bus passes every published event to its subscribers. The zero value is
+// ready to use and a nil Bus drops events.
+type Bus struct {
+	mu   sync.RWMutex
+	subs []func(Event)
+}
+
+// Subscribe registers fn to be called with every event published from now on.
+// fn is called synchronously from Publish and must not block.
+func (b *Bus) Subscribe(fn func(Event)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.subs = append(b.subs, fn)
+}
+
+// Publish sends e to every subscriber, stamping it with the current time if
+// it has none.
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now().UTC()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, fn := range b.subs {
+		fn(e)
+	}
+}
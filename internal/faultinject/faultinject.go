@@ -0,0 +1,145 @@
+// Package faultinject delays or fails a share of backend calls, TFTP reads
+// and power actions, so operators can verify monitoring and retry behavior
+// before production. It is gated by the fault_injection config and must not
+// be enabled in production.
+package faultinject
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"time"
+
+	"github.com/metal3-community/metal-boot/internal/backend"
+	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+)
+
+// ErrInjected is returned by calls that a Fault made fail.
+var ErrInjected = errors.New("injected fault")
+
+// Fault describes the faults injected into one kind of call. A nil Fault
+// injects nothing.
+type Fault struct {
+	// ErrorPercent is the share of calls, from 0 to 100, that fail with
+	// ErrInjected.
+	ErrorPercent float64
+	// DelayPercent is the share of calls, from 0 to 100, delayed by Delay
+	// before they run or fail.
+	DelayPercent float64
+	Delay        time.Duration
+
+	// roll returns a number in [0, 100); tests replace it.
+	roll func() float64
+}
+
+func (f *Fault) dice() float64 {
+	if f.roll != nil {
+		return f.roll()
+	}
+	return rand.Float64() * 100
+}
+
+// Inject applies the fault to one call: it may sleep, and returns ErrInjected
+// if the call should fail. A canceled ctx ends the delay early with its error.
+func (f *Fault) Inject(ctx context.Context) error {
+	if f == nil {
+		return nil
+	}
+
+	if f.Delay > 0 && f.dice() < f.DelayPercent {
+		t := time.NewTimer(f.Delay)
+		defer t.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+	if f.dice() < f.ErrorPercent {
+		return ErrInjected
+	}
+
+	return nil
+}
+
+// Reader returns a BackendReader that injects f into every call to next.
+func Reader(next backend.BackendReader, f *Fault) backend.BackendReader {
+	if f == nil {
+		return next
+	}
+
+	return &reader{next: next, fault: f}
+}
+
+type reader struct {
+	next  backend.BackendReader
+	fault *Fault
+}
+
+// Unwrap returns the wrapped backend, for callers that need its concrete type.
+func (r *reader) Unwrap() backend.BackendReader {
+	return r.next
+}
+
+func (r *reader) GetByMac(
+	ctx context.Context,
+	mac net.HardwareAddr,
+) (*data.DHCP, *data.Netboot, error) {
+	if err := r.fault.Inject(ctx); err != nil {
+		return nil, nil, err
+	}
+	return r.next.GetByMac(ctx, mac)
+}
+
+func (r *reader) GetByIP(ctx context.Context, ip net.IP) (*data.DHCP, *data.Netboot, error) {
+	if err := r.fault.Inject(ctx); err != nil {
+		return nil, nil, err
+	}
+	return r.next.GetByIP(ctx, ip)
+}
+
+func (r *reader) GetKeys(ctx context.Context) ([]net.HardwareAddr, error) {
+	if err := r.fault.Inject(ctx); err != nil {
+		return nil, err
+	}
+	return r.next.GetKeys(ctx)
+}
+
+// Power returns a BackendPower that injects f into power actions of next.
+// Power state reads are passed through.
+func Power(next backend.BackendPower, f *Fault) backend.BackendPower {
+	if f == nil {
+		return next
+	}
+
+	return &power{next: next, fault: f}
+}
+
+type power struct {
+	next  backend.BackendPower
+	fault *Fault
+}
+
+// Unwrap returns the wrapped backend, for callers that need its concrete type.
+func (p *power) Unwrap() backend.BackendPower {
+	return p.next
+}
+
+func (p *power) GetPower(ctx context.Context, mac net.HardwareAddr) (*data.PowerState, error) {
+	return p.next.GetPower(ctx, mac)
+}
+
+func (p *power) SetPower(ctx context.Context, mac net.HardwareAddr, state data.PowerState) error {
+	if err := p.fault.Inject(ctx); err != nil {
+		return err
+	}
+	return p.next.SetPower(ctx, mac, state)
+}
+
+func (p *power) PowerCycle(ctx context.Context, mac net.HardwareAddr) error {
+	if err := p.fault.Inject(ctx); err != nil {
+		return err
+	}
+	return p.next.PowerCycle(ctx, mac)
+}
@@ -0,0 +1,85 @@
+package faultinject
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/metal3-community/metal-boot/internal/backend"
+	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+)
+
+type fakeReader struct{ calls int }
+
+func (f *fakeReader) GetByMac(context.Context, net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+	f.calls++
+	return &data.DHCP{}, &data.Netboot{}, nil
+}
+
+func (f *fakeReader) GetByIP(context.Context, net.IP) (*data.DHCP, *data.Netboot, error) {
+	f.calls++
+	return &data.DHCP{}, &data.Netboot{}, nil
+}
+
+func (f *fakeReader) GetKeys(context.Context) ([]net.HardwareAddr, error) {
+	f.calls++
+	return nil, nil
+}
+
+func TestInject(t *testing.T) {
+	var none *Fault
+	if err := none.Inject(context.Background()); err != nil {
+		t.Errorf("nil Fault Inject() error = %v", err)
+	}
+
+	rolls := []float64{10, 60}
+	f := &Fault{ErrorPercent: 50, roll: func() float64 {
+		r := rolls[0]
+		rolls = rolls[1:]
+		return r
+	}}
+	if err := f.Inject(context.Background()); !errors.Is(err, ErrInjected) {
+		t.Errorf("Inject() under ErrorPercent error = %v, want ErrInjected", err)
+	}
+	if err := f.Inject(context.Background()); err != nil {
+		t.Errorf("Inject() over ErrorPercent error = %v", err)
+	}
+}
+
+func TestInjectDelay(t *testing.T) {
+	f := &Fault{DelayPercent: 100, Delay: time.Hour}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := f.Inject(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Inject() error = %v, want the context error", err)
+	}
+
+	f = &Fault{DelayPercent: 100, Delay: 5 * time.Millisecond}
+	start := time.Now()
+	if err := f.Inject(context.Background()); err != nil {
+		t.Fatalf("Inject() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 5*time.Millisecond {
+		t.Errorf("Inject() returned after %s, want at least 5ms", elapsed)
+	}
+}
+
+func TestReader(t *testing.T) {
+	next := &fakeReader{}
+	r := Reader(next, &Fault{ErrorPercent: 100})
+	if _, _, err := r.GetByMac(context.Background(), nil); !errors.Is(err, ErrInjected) {
+		t.Errorf("GetByMac() error = %v, want ErrInjected", err)
+	}
+	if next.calls != 0 {
+		t.Errorf("backend called %d times, want 0", next.calls)
+	}
+	if u, ok := r.(interface{ Unwrap() backend.BackendReader }); !ok || u.Unwrap() != next {
+		t.Error("Unwrap() does not return the wrapped backend")
+	}
+
+	if Reader(next, nil) != next {
+		t.Error("Reader() with no fault wrapped the backend")
+	}
+}
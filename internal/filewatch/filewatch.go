@@ -0,0 +1,216 @@
+// Package filewatch reports changes to files through fsnotify where the
+// filesystem supports it and by polling their modification time and size
+// where it does not, such as on NFS and SMB mounts.
+package filewatch
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-logr/logr"
+)
+
+// DefaultInterval is the polling interval used when Options.Interval is unset.
+const DefaultInterval = 2 * time.Second
+
+// Options selects how files are watched.
+type Options struct {
+	// Poll watches every file by polling, for filesystems where fsnotify
+	// registers watches but never delivers events for remote changes.
+	Poll bool
+	// Interval is the time between polls.
+	Interval time.Duration
+}
+
+// stamp is what polling compares to detect a change.
+type stamp struct {
+	exists  bool
+	modTime time.Time
+	size    int64
+}
+
+func stat(path string) stamp {
+	info, err := os.Stat(path)
+	if err != nil {
+		return stamp{}
+	}
+
+	return stamp{exists: true, modTime: info.ModTime(), size: info.Size()}
+}
+
+// Watcher delivers fsnotify events for the files added to it. Files that
+// fsnotify cannot watch are polled instead, which only reports Create, Write
+// and Remove.
+type Watcher struct {
+	Events chan fsnotify.Event
+	Errors chan error
+
+	log      logr.Logger
+	notify   *fsnotify.Watcher
+	interval time.Duration
+
+	mu     sync.Mutex
+	polled map[string]stamp
+
+	done      chan struct{}
+	closeOnce sync.Once
+	pollOnce  sync.Once
+}
+
+// New returns a Watcher. When fsnotify is not available, for example because
+// the inotify instance limit is reached, every file is polled.
+func New(log logr.Logger, opts Options) *Watcher {
+	w := &Watcher{
+		Events:   make(chan fsnotify.Event),
+		Errors:   make(chan error),
+		log:      log,
+		interval: opts.Interval,
+		polled:   make(map[string]stamp),
+		done:     make(chan struct{}),
+	}
+	if w.interval <= 0 {
+		w.interval = DefaultInterval
+	}
+
+	if !opts.Poll {
+		notify, err := fsnotify.NewWatcher()
+		if err != nil {
+			log.Info("fsnotify is not available, polling files instead",
+				"error", err, "interval", w.interval)
+		} else {
+			w.notify = notify
+			go w.forward()
+		}
+	}
+
+	return w
+}
+
+// Add starts watching path. If fsnotify cannot watch it, path is polled.
+func (w *Watcher) Add(path string) error {
+	if w.notify != nil {
+		err := w.notify.Add(path)
+		if err == nil {
+			return nil
+		}
+		if errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		w.log.Info("cannot watch file with fsnotify, polling it instead",
+			"file", path, "error", err, "interval", w.interval)
+	}
+
+	w.mu.Lock()
+	w.polled[path] = stat(path)
+	w.mu.Unlock()
+	w.pollOnce.Do(func() { go w.poll() })
+
+	return nil
+}
+
+// Polling reports whether path is polled rather than watched by fsnotify.
+func (w *Watcher) Polling(path string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	_, ok := w.polled[path]
+	return ok
+}
+
+// Close stops watching every file. No events are sent afterwards.
+func (w *Watcher) Close() error {
+	var err error
+	w.closeOnce.Do(func() {
+		close(w.done)
+		if w.notify != nil {
+			err = w.notify.Close()
+		}
+	})
+
+	return err
+}
+
+// forward passes fsnotify events and errors on until the Watcher is closed.
+func (w *Watcher) forward() {
+	for {
+		select {
+		case <-w.done:
+			return
+		case event, ok := <-w.notify.Events:
+			if !ok {
+				return
+			}
+			w.send(event)
+		case err, ok := <-w.notify.Errors:
+			if !ok {
+				return
+			}
+			select {
+			case w.Errors <- err:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
+
+// poll compares the stamp of every polled file each interval until the
+// Watcher is closed.
+func (w *Watcher) poll() {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+
+		for _, event := range w.changes() {
+			if !w.send(event) {
+				return
+			}
+		}
+	}
+}
+
+// changes stats every polled file and returns an event for each one that
+// changed since the last poll.
+func (w *Watcher) changes() []fsnotify.Event {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var events []fsnotify.Event
+	for path, before := range w.polled {
+		after := stat(path)
+		if after == before {
+			continue
+		}
+		w.polled[path] = after
+
+		op := fsnotify.Write
+		switch {
+		case !after.exists:
+			op = fsnotify.Remove
+		case !before.exists:
+			op = fsnotify.Create
+		}
+		events = append(events, fsnotify.Event{Name: path, Op: op})
+	}
+
+	return events
+}
+
+// send delivers event and reports false once the Watcher is closed.
+func (w *Watcher) send(event fsnotify.Event) bool {
+	select {
+	case w.Events <- event:
+		return true
+	case <-w.done:
+		return false
+	}
+}
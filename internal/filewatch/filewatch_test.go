@@ -0,0 +1,74 @@
+package filewatch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-logr/logr"
+)
+
+func TestPolling(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dnsmasq.leases")
+	if err := os.WriteFile(path, []byte("a\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	w := New(logr.Discard(), Options{Poll: true, Interval: 10 * time.Millisecond})
+	defer w.Close()
+	if err := w.Add(path); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if !w.Polling(path) {
+		t.Fatal("Polling() = false with Options.Poll set")
+	}
+
+	next := func(want fsnotify.Op) {
+		t.Helper()
+		select {
+		case event := <-w.Events:
+			if event.Name != path || event.Op != want {
+				t.Fatalf("event = %v, want %v of %s", event, want, path)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no %v event", want)
+		}
+	}
+
+	if err := os.WriteFile(path, []byte("a\nb\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	next(fsnotify.Write)
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	next(fsnotify.Remove)
+	if err := os.WriteFile(path, []byte("c\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	next(fsnotify.Create)
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := os.WriteFile(path, []byte("d\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case event := <-w.Events:
+		t.Fatalf("event %v after Close()", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestAddMissingFile(t *testing.T) {
+	w := New(logr.Discard(), Options{})
+	defer w.Close()
+
+	path := filepath.Join(t.TempDir(), "missing")
+	if err := w.Add(path); err == nil && !w.Polling(path) {
+		t.Fatal("Add() of a missing file neither failed nor polled it")
+	}
+}
@@ -0,0 +1,140 @@
+// Package firmwaretx changes the UEFI variables of an EDK2 firmware image as
+// one transaction.
+//
+// The firmware manager keeps variables in memory until SaveChanges rewrites
+// the image in place, so a request that fails half way may still have written
+// some of its changes, a crash during the write truncates the image, and two
+// requests for the same system overwrite each other. A Tx holds the image
+// locked from Begin to Apply or Rollback and only replaces it, by renaming a
+// complete copy over it, when every change succeeded.
+package firmwaretx
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"github.com/metal3-community/uefi-firmware-manager/manager"
+	"github.com/metal3-community/uefi-firmware-manager/varstore"
+)
+
+// ErrDone is returned by Apply when the transaction was already applied or
+// rolled back.
+var ErrDone = errors.New("firmware transaction already finished")
+
+// locks serialises transactions on the same image.
+var locks sync.Map // path -> *sync.Mutex
+
+// Tx is a transaction on one firmware image. The embedded FirmwareManager
+// stages changes in memory until Apply.
+type Tx struct {
+	manager.FirmwareManager
+
+	path   string
+	store  *varstore.Edk2VarStore
+	log    logr.Logger
+	unlock func()
+	done   bool
+}
+
+// Begin locks the image at path and loads its variables. The image is
+// created from the embedded firmware when missing, as manager.NewEDK2Manager
+// does. Every Tx must be finished with Apply or Rollback; deferring Rollback
+// right after Begin is safe, as it is a no-op once the Tx was applied.
+func Begin(path string, log logr.Logger) (*Tx, error) {
+	mu, _ := locks.LoadOrStore(filepath.Clean(path), &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	unlock := mu.(*sync.Mutex).Unlock
+
+	mgr, err := manager.NewEDK2Manager(path, log)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	store, err := varstore.New(data)
+	if err != nil {
+		unlock()
+		return nil, fmt.Errorf("failed to parse varstore: %w", err)
+	}
+	store.Logger = log.WithName("edk2-varstore")
+
+	return &Tx{
+		FirmwareManager: mgr,
+		path:            path,
+		store:           store,
+		log:             log,
+		unlock:          unlock,
+	}, nil
+}
+
+// Apply writes every staged change to the image and releases it. The image
+// is left untouched when any part of the write fails.
+func (t *Tx) Apply() error {
+	if t.done {
+		return ErrDone
+	}
+	defer t.finish()
+
+	vars, err := t.GetVarList()
+	if err != nil {
+		return err
+	}
+	blob, err := t.store.ReadAll(vars)
+	if err != nil {
+		return fmt.Errorf("failed to serialise varstore: %w", err)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(t.path), "."+filepath.Base(t.path)+".tx-*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+
+	_, err = f.Write(blob)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		// CreateTemp makes the file private; the firmware is served over TFTP.
+		err = os.Chmod(tmp, 0o644)
+	}
+	if err == nil {
+		err = os.Rename(tmp, t.path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write firmware %s: %w", t.path, err)
+	}
+	t.log.V(1).Info("applied firmware transaction", "path", t.path)
+
+	return nil
+}
+
+// SaveChanges is Apply, so that code written against the FirmwareManager
+// interface cannot bypass the transaction.
+func (t *Tx) SaveChanges() error {
+	return t.Apply()
+}
+
+// Rollback discards the staged changes and releases the image.
+func (t *Tx) Rollback() {
+	if !t.done {
+		t.finish()
+	}
+}
+
+func (t *Tx) finish() {
+	t.done = true
+	t.unlock()
+}
@@ -0,0 +1,146 @@
+package firmwaretx
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/metal3-community/uefi-firmware-manager/edk2"
+)
+
+func newImage(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), edk2.FirmwareFileName)
+	if err := os.WriteFile(path, edk2.RpiEfi, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func timeout(t *testing.T, path string) int {
+	t.Helper()
+	tx, err := Begin(path, logr.Discard())
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	defer tx.Rollback()
+	v, err := tx.GetVariable("Timeout")
+	if err != nil {
+		t.Fatalf("GetVariable(Timeout) error = %v", err)
+	}
+	return int(v.Data[0]) | int(v.Data[1])<<8
+}
+
+func TestApply(t *testing.T) {
+	path := newImage(t)
+
+	tx, err := Begin(path, logr.Discard())
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	defer tx.Rollback()
+	if err := tx.SetFirmwareTimeoutSeconds(42); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Apply(); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if err := tx.Apply(); !errors.Is(err, ErrDone) {
+		t.Errorf("second Apply() error = %v, want %v", err, ErrDone)
+	}
+
+	if got := timeout(t, path); got != 42 {
+		t.Errorf("Timeout = %d after Apply, want 42", got)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0o644 {
+		t.Errorf("Stat() = %v, %v, want mode 0644", fi, err)
+	}
+	if m, _ := filepath.Glob(path + "*.tx-*"); len(m) != 0 {
+		t.Errorf("temporary files left behind: %v", m)
+	}
+}
+
+func TestRollbackLeavesImageUntouched(t *testing.T) {
+	path := newImage(t)
+
+	tx, err := Begin(path, logr.Discard())
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	if err := tx.SetFirmwareTimeoutSeconds(42); err != nil {
+		t.Fatal(err)
+	}
+	tx.Rollback()
+
+	if b, _ := os.ReadFile(path); !bytes.Equal(b, edk2.RpiEfi) {
+		t.Error("image changed after Rollback")
+	}
+}
+
+func TestFailedApplyLeavesImageUntouched(t *testing.T) {
+	path := newImage(t)
+
+	tx, err := Begin(path, logr.Discard())
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	defer tx.Rollback()
+	if err := tx.SetFirmwareTimeoutSeconds(42); err != nil {
+		t.Fatal(err)
+	}
+	// A variable larger than the varstore fails the write after the first
+	// change was staged.
+	v, err := tx.GetVariable("Timeout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	big := *v
+	big.Data = make([]byte, len(edk2.RpiEfi))
+	if err := tx.SetVariable("Huge", &big); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Apply(); err == nil {
+		t.Fatal("Apply() error = nil, want varstore too small")
+	}
+
+	if b, _ := os.ReadFile(path); !bytes.Equal(b, edk2.RpiEfi) {
+		t.Error("image changed after a failed Apply")
+	}
+}
+
+func TestBeginSerialisesTransactions(t *testing.T) {
+	path := newImage(t)
+
+	first, err := Begin(path, logr.Discard())
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+
+	started := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		close(started)
+		second, err := Begin(path, logr.Discard())
+		if err == nil {
+			second.Rollback()
+		}
+		close(done)
+	}()
+	<-started
+
+	select {
+	case <-done:
+		t.Fatal("second Begin() did not wait for the first transaction")
+	case <-time.After(50 * time.Millisecond):
+	}
+	first.Rollback()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("second Begin() still waiting after Rollback")
+	}
+}
@@ -0,0 +1,490 @@
+// Package gpufw manages the Raspberry Pi VideoCore boot files (bootcode.bin,
+// start*.elf and fixup*.dat) that the GPU fetches over TFTP before the ARM
+// cores run anything.
+//
+// Each version is a directory of boot files below the store directory; the
+// files embedded in the UEFI firmware form the built-in version. Hosts are
+// pinned to versions through named groups, and hosts outside any group use the
+// version of the default group. The start and fixup files must match each
+// other, so a version is always served as a whole.
+package gpufw
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/metal3-community/uefi-firmware-manager/edk2"
+)
+
+const (
+	// Builtin is the version embedded in the UEFI firmware.
+	Builtin = "builtin"
+	// DefaultGroup applies to hosts that are not in any other group. Its host
+	// list is ignored.
+	DefaultGroup = "default"
+
+	// groupsFile holds the persisted groups inside the store directory.
+	groupsFile = "groups.json"
+	// maxFileSize bounds uploaded boot files; start*.elf is a few MiB.
+	maxFileSize = 32 << 20
+)
+
+// Model is a family of Raspberry Pi boards that share the same boot files.
+type Model string
+
+const (
+	// ModelPi4 covers the BCM2711 boards: Pi 4, Pi 400 and CM4.
+	ModelPi4 Model = "pi4"
+	// ModelPi3 covers the BCM2835-7 boards: Pi Zero up to Pi 3 and CM3.
+	ModelPi3 Model = "pi3"
+)
+
+// bootFiles lists the VideoCore files each model fetches.
+var bootFiles = map[string]Model{
+	"start4.elf":   ModelPi4,
+	"start4x.elf":  ModelPi4,
+	"start4cd.elf": ModelPi4,
+	"start4db.elf": ModelPi4,
+	"fixup4.dat":   ModelPi4,
+	"fixup4x.dat":  ModelPi4,
+	"fixup4cd.dat": ModelPi4,
+	"fixup4db.dat": ModelPi4,
+	"bootcode.bin": ModelPi3,
+	"start.elf":    ModelPi3,
+	"start_x.elf":  ModelPi3,
+	"start_cd.elf": ModelPi3,
+	"start_db.elf": ModelPi3,
+	"fixup.dat":    ModelPi3,
+	"fixup_x.dat":  ModelPi3,
+	"fixup_cd.dat": ModelPi3,
+	"fixup_db.dat": ModelPi3,
+}
+
+// startFiles identify the build of a version, per model.
+var startFiles = map[Model]string{
+	ModelPi4: "start4.elf",
+	ModelPi3: "start.elf",
+}
+
+var (
+	// ErrNotFound is returned for unknown versions and groups.
+	ErrNotFound = errors.New("not found")
+	// ErrInUse is returned when removing a version a group is pinned to.
+	ErrInUse = errors.New("version is in use")
+	// ErrInvalid is returned for invalid names and files.
+	ErrInvalid = errors.New("invalid")
+
+	nameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+)
+
+// Version describes a set of boot files.
+type Version struct {
+	Name    string `json:"name"`
+	Builtin bool   `json:"builtin,omitempty"`
+	// Models lists the board families the version has a start file for.
+	Models []Model  `json:"models"`
+	Files  []string `json:"files"`
+	// Builds maps each model to the VC_BUILD_ID of its start file.
+	Builds map[Model]Build `json:"builds,omitempty"`
+}
+
+// Build is the build information embedded in a start*.elf file.
+type Build struct {
+	Commit string `json:"commit,omitempty"`
+	Date   string `json:"date,omitempty"`
+	Branch string `json:"branch,omitempty"`
+}
+
+// Group pins a set of hosts to a version.
+type Group struct {
+	Name    string   `json:"name"`
+	Version string   `json:"version"`
+	Hosts   []string `json:"hosts,omitempty"`
+}
+
+// Store holds the installed versions and the group assignments.
+type Store struct {
+	dir string
+
+	mu     sync.RWMutex
+	groups map[string]*Group
+}
+
+// IsBootFile reports whether name is a VideoCore boot file.
+func IsBootFile(name string) bool {
+	_, ok := bootFiles[name]
+	return ok
+}
+
+// NewStore opens the store in dir. defaultVersion seeds the default group when
+// no groups have been saved yet; empty means Builtin.
+func NewStore(dir, defaultVersion string) (*Store, error) {
+	if defaultVersion == "" {
+		defaultVersion = Builtin
+	}
+	s := &Store{
+		dir:    dir,
+		groups: map[string]*Group{DefaultGroup: {Name: DefaultGroup, Version: defaultVersion}},
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, groupsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read gpu firmware groups: %w", err)
+	}
+
+	var groups []*Group
+	if err := json.Unmarshal(b, &groups); err != nil {
+		return nil, fmt.Errorf("failed to parse gpu firmware groups: %w", err)
+	}
+	for _, g := range groups {
+		s.groups[g.Name] = g
+	}
+
+	return s, nil
+}
+
+// Resolve returns the group and version used for mac.
+func (s *Store) Resolve(mac net.HardwareAddr) (string, string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key := strings.ToLower(mac.String())
+	for _, g := range s.groups {
+		if g.Name != DefaultGroup && slices.Contains(g.Hosts, key) {
+			return g.Name, g.Version
+		}
+	}
+
+	return DefaultGroup, s.groups[DefaultGroup].Version
+}
+
+// Open returns the boot file name of the version mac is pinned to, and that
+// version. It returns an error wrapping os.ErrNotExist when the version does
+// not contain the file, for example because it lacks the mac's board family.
+func (s *Store) Open(mac net.HardwareAddr, name string) ([]byte, string, error) {
+	if !IsBootFile(name) {
+		return nil, "", fmt.Errorf("%s: %w", name, os.ErrNotExist)
+	}
+	_, version := s.Resolve(mac)
+
+	b, err := s.readFile(version, name)
+	if err != nil {
+		return nil, version, err
+	}
+
+	return b, version, nil
+}
+
+// Versions lists the built-in and installed versions ordered by name.
+func (s *Store) Versions() ([]Version, error) {
+	out := []Version{s.builtin()}
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to list gpu firmware versions: %w", err)
+	}
+	for _, e := range entries {
+		if !e.IsDir() || !nameRe.MatchString(e.Name()) || e.Name() == Builtin {
+			continue
+		}
+		v, err := s.version(e.Name())
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	sort.Slice(out[1:], func(i, j int) bool { return out[i+1].Name < out[j+1].Name })
+
+	return out, nil
+}
+
+// Version describes one version.
+func (s *Store) Version(name string) (Version, error) {
+	if name == Builtin {
+		return s.builtin(), nil
+	}
+	if !nameRe.MatchString(name) {
+		return Version{}, fmt.Errorf("version %q: %w", name, ErrInvalid)
+	}
+	if info, err := os.Stat(filepath.Join(s.dir, name)); err != nil || !info.IsDir() {
+		return Version{}, fmt.Errorf("version %q: %w", name, ErrNotFound)
+	}
+
+	return s.version(name)
+}
+
+// Install writes boot file name of version, creating the version if needed.
+func (s *Store) Install(version, name string, r io.Reader) error {
+	if version == Builtin || !nameRe.MatchString(version) {
+		return fmt.Errorf("version %q: %w", version, ErrInvalid)
+	}
+	if !IsBootFile(name) {
+		return fmt.Errorf("%q is not a VideoCore boot file: %w", name, ErrInvalid)
+	}
+
+	dir := filepath.Join(s.dir, version)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create version directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, name+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", name, err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	n, err := io.Copy(tmp, io.LimitReader(r, maxFileSize+1))
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if n > maxFileSize {
+		return fmt.Errorf("%s is larger than %d bytes: %w", name, maxFileSize, ErrInvalid)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(dir, name))
+}
+
+// RemoveVersion deletes an installed version that no group is pinned to.
+func (s *Store) RemoveVersion(name string) error {
+	if name == Builtin || !nameRe.MatchString(name) {
+		return fmt.Errorf("version %q: %w", name, ErrInvalid)
+	}
+
+	s.mu.RLock()
+	for _, g := range s.groups {
+		if g.Version == name {
+			s.mu.RUnlock()
+			return fmt.Errorf("%w by group %q", ErrInUse, g.Name)
+		}
+	}
+	s.mu.RUnlock()
+
+	dir := filepath.Join(s.dir, name)
+	if _, err := os.Stat(dir); err != nil {
+		return fmt.Errorf("version %q: %w", name, ErrNotFound)
+	}
+
+	return os.RemoveAll(dir)
+}
+
+// Groups returns copies of all groups ordered by name, default first.
+func (s *Store) Groups() []Group {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make([]Group, 0, len(s.groups))
+	for _, g := range s.groups {
+		out = append(out, copyGroup(g))
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Name == DefaultGroup || out[j].Name == DefaultGroup {
+			return out[i].Name == DefaultGroup
+		}
+		return out[i].Name < out[j].Name
+	})
+
+	return out
+}
+
+// SetGroup creates or replaces a group and persists the groups. Hosts are
+// moved out of any other group they were in.
+func (s *Store) SetGroup(g Group) (Group, error) {
+	if !nameRe.MatchString(g.Name) {
+		return Group{}, fmt.Errorf("group %q: %w", g.Name, ErrInvalid)
+	}
+	if _, err := s.Version(g.Version); err != nil {
+		return Group{}, err
+	}
+
+	hosts := make([]string, 0, len(g.Hosts))
+	for _, h := range g.Hosts {
+		mac, err := net.ParseMAC(h)
+		if err != nil {
+			return Group{}, fmt.Errorf("host %q: %w", h, ErrInvalid)
+		}
+		if key := strings.ToLower(mac.String()); !slices.Contains(hosts, key) {
+			hosts = append(hosts, key)
+		}
+	}
+	sort.Strings(hosts)
+	if g.Name == DefaultGroup {
+		hosts = nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, other := range s.groups {
+		if other.Name == g.Name {
+			continue
+		}
+		other.Hosts = slices.DeleteFunc(other.Hosts, func(h string) bool {
+			return slices.Contains(hosts, h)
+		})
+	}
+	s.groups[g.Name] = &Group{Name: g.Name, Version: g.Version, Hosts: hosts}
+
+	return copyGroup(s.groups[g.Name]), s.save()
+}
+
+// DeleteGroup removes a group; its hosts fall back to the default group.
+func (s *Store) DeleteGroup(name string) error {
+	if name == DefaultGroup {
+		return fmt.Errorf("the %s group cannot be deleted: %w", DefaultGroup, ErrInvalid)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.groups[name]; !ok {
+		return fmt.Errorf("group %q: %w", name, ErrNotFound)
+	}
+	delete(s.groups, name)
+
+	return s.save()
+}
+
+// save writes the groups atomically. Callers must hold s.mu.
+func (s *Store) save() error {
+	groups := make([]*Group, 0, len(s.groups))
+	for _, g := range s.groups {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+
+	b, err := json.MarshalIndent(groups, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal gpu firmware groups: %w", err)
+	}
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create gpu firmware directory: %w", err)
+	}
+
+	path := filepath.Join(s.dir, groupsFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return fmt.Errorf("failed to write gpu firmware groups: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace gpu firmware groups: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) readFile(version, name string) ([]byte, error) {
+	if version == Builtin {
+		b, ok := edk2.Files[name]
+		if !ok {
+			return nil, fmt.Errorf("%s/%s: %w", version, name, os.ErrNotExist)
+		}
+		return b, nil
+	}
+
+	b, err := os.ReadFile(filepath.Join(s.dir, version, name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s/%s: %w", version, name, err)
+	}
+
+	return b, nil
+}
+
+func (s *Store) builtin() Version {
+	v := Version{Name: Builtin, Builtin: true}
+	for name := range bootFiles {
+		if _, ok := edk2.Files[name]; ok {
+			v.Files = append(v.Files, name)
+		}
+	}
+	s.describe(&v)
+
+	return v
+}
+
+func (s *Store) version(name string) (Version, error) {
+	entries, err := os.ReadDir(filepath.Join(s.dir, name))
+	if err != nil {
+		return Version{}, fmt.Errorf("failed to list gpu firmware version %s: %w", name, err)
+	}
+
+	v := Version{Name: name}
+	for _, e := range entries {
+		if e.Type().IsRegular() && IsBootFile(e.Name()) {
+			v.Files = append(v.Files, e.Name())
+		}
+	}
+	s.describe(&v)
+
+	return v, nil
+}
+
+// describe fills in the models and builds of v from its files.
+func (s *Store) describe(v *Version) {
+	sort.Strings(v.Files)
+	v.Models = []Model{}
+	for _, model := range []Model{ModelPi3, ModelPi4} {
+		start := startFiles[model]
+		if !slices.Contains(v.Files, start) {
+			continue
+		}
+		v.Models = append(v.Models, model)
+		if b, err := s.readFile(v.Name, start); err == nil {
+			if v.Builds == nil {
+				v.Builds = make(map[Model]Build)
+			}
+			v.Builds[model] = parseBuild(b)
+		}
+	}
+}
+
+// parseBuild extracts the VC_BUILD_ID_* strings of a start*.elf file.
+func parseBuild(elf []byte) Build {
+	var b Build
+	var date, clock string
+	for _, line := range bytes.Split(elf, []byte{0}) {
+		key, value, ok := strings.Cut(string(line), ": ")
+		if !ok || !strings.HasPrefix(key, "VC_BUILD_ID_") {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "VC_BUILD_ID_VERSION":
+			b.Commit, _, _ = strings.Cut(value, " ")
+		case "VC_BUILD_ID_BRANCH":
+			b.Branch = value
+		case "VC_BUILD_ID_TIME":
+			if strings.Contains(value, ":") {
+				clock = value
+			} else {
+				date = strings.Join(strings.Fields(value), " ")
+			}
+		}
+	}
+	b.Date = strings.TrimSpace(date + " " + clock)
+
+	return b
+}
+
+func copyGroup(g *Group) Group {
+	c := *g
+	c.Hosts = slices.Clone(g.Hosts)
+
+	return c
+}
@@ -0,0 +1,96 @@
+package gpufw
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestResolveAndOpen(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewStore(dir, "")
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	pinned, _ := net.ParseMAC("aa:bb:cc:dd:ee:01")
+	other, _ := net.ParseMAC("aa:bb:cc:dd:ee:02")
+
+	// Hosts outside any group get the built-in Pi 4 files.
+	if _, version, err := s.Open(other, "start4.elf"); err != nil || version != Builtin {
+		t.Fatalf("Open(start4.elf) = %s, %v, want builtin", version, err)
+	}
+	if _, _, err := s.Open(other, "bootcode.bin"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Open(bootcode.bin) error = %v, want ErrNotExist", err)
+	}
+
+	if err := s.Install("next", "start4.elf", strings.NewReader("new start")); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+	if _, err := s.SetGroup(Group{Name: "canary", Version: "next", Hosts: []string{pinned.String()}}); err != nil {
+		t.Fatalf("SetGroup() error = %v", err)
+	}
+
+	b, version, err := s.Open(pinned, "start4.elf")
+	if err != nil || version != "next" || string(b) != "new start" {
+		t.Errorf("Open(pinned) = %q, %s, %v", b, version, err)
+	}
+	if _, _, err := s.Open(pinned, "fixup4.dat"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Open(missing file in version) error = %v, want ErrNotExist", err)
+	}
+	if _, version, _ := s.Open(other, "start4.elf"); version != Builtin {
+		t.Errorf("Open(other) version = %s, want builtin", version)
+	}
+
+	// Groups survive a restart.
+	s, err = NewStore(dir, "")
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	if group, version := s.Resolve(pinned); group != "canary" || version != "next" {
+		t.Errorf("Resolve() after reload = %s/%s, want canary/next", group, version)
+	}
+}
+
+func TestSetGroupMovesHosts(t *testing.T) {
+	s, err := NewStore(t.TempDir(), "")
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	mac := "aa:bb:cc:dd:ee:01"
+	for _, name := range []string{"a", "b"} {
+		if _, err := s.SetGroup(Group{Name: name, Version: Builtin, Hosts: []string{mac}}); err != nil {
+			t.Fatalf("SetGroup(%s) error = %v", name, err)
+		}
+	}
+
+	for _, g := range s.Groups() {
+		if g.Name == "a" && len(g.Hosts) != 0 {
+			t.Errorf("group a hosts = %v, want the host moved to b", g.Hosts)
+		}
+	}
+	if err := s.RemoveVersion(Builtin); !errors.Is(err, ErrInvalid) {
+		t.Errorf("RemoveVersion(builtin) error = %v, want ErrInvalid", err)
+	}
+	if _, err := s.SetGroup(Group{Name: "../x", Version: Builtin}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("SetGroup(../x) error = %v, want ErrInvalid", err)
+	}
+}
+
+func TestBuiltinBuild(t *testing.T) {
+	s, err := NewStore(t.TempDir(), "")
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	v, err := s.Version(Builtin)
+	if err != nil {
+		t.Fatalf("Version() error = %v", err)
+	}
+	if len(v.Models) != 1 || v.Models[0] != ModelPi4 {
+		t.Errorf("Models = %v, want [pi4]", v.Models)
+	}
+	if b := v.Builds[ModelPi4]; b.Commit == "" || b.Date == "" {
+		t.Errorf("Builds[pi4] = %+v, want commit and date", b)
+	}
+}
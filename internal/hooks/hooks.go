@@ -0,0 +1,159 @@
+// Package hooks runs operator supplied commands on provisioning events, for
+// integrations that cannot take a webhook, such as updating a local inventory
+// file or poking a serial console server.
+//
+// A command gets the event in its environment (METALBOOT_EVENT,
+// METALBOOT_MAC, METALBOOT_IP, METALBOOT_STATE, METALBOOT_MESSAGE and
+// METALBOOT_TIMESTAMP) and as JSON on its standard input.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/metal3-community/metal-boot/internal/events"
+)
+
+// defaultTimeout bounds the hooks that set no timeout.
+const defaultTimeout = 30 * time.Second
+
+// maxOutput is how much of the output of a command is logged.
+const maxOutput = 4096
+
+// waitDelay is how long a killed command may hold its output open, for
+// processes that left its process group.
+const waitDelay = time.Second
+
+// Hook is a command run on some events.
+type Hook struct {
+	// Name identifies the hook in logs. It defaults to Command.
+	Name string
+	// Events are the event types the hook runs on. An empty list runs it on
+	// every event.
+	Events []events.Type
+	// Command is the executable and Args its arguments. The command is not
+	// run through a shell.
+	Command string
+	Args    []string
+	// Timeout is how long the command may run before it is killed.
+	Timeout time.Duration
+}
+
+// Runner runs the hooks matching each event it handles.
+type Runner struct {
+	Hooks []Hook
+	Log   logr.Logger
+
+	wg sync.WaitGroup
+}
+
+// Handle starts the hooks that run on e and returns without waiting for
+// them, so that it can subscribe to an events.Bus.
+func (r *Runner) Handle(e events.Event) {
+	for _, h := range r.Hooks {
+		if len(h.Events) > 0 && !slices.Contains(h.Events, e.Type) {
+			continue
+		}
+		r.wg.Go(func() {
+			r.run(h, e)
+		})
+	}
+}
+
+// Wait waits for the hooks that are running to finish. A nil Runner has
+// none.
+func (r *Runner) Wait() {
+	if r == nil {
+		return
+	}
+	r.wg.Wait()
+}
+
+func (r *Runner) run(h Hook, e events.Event) {
+	name := h.Name
+	if name == "" {
+		name = h.Command
+	}
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	log := r.Log.WithValues("hook", name, "event", e.Type, "mac", e.MAC)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	input, err := json.Marshal(e)
+	if err != nil {
+		log.Error(err, "failed to encode event")
+		return
+	}
+	cmd := exec.CommandContext(ctx, h.Command, h.Args...)
+	cmd.Env = append(os.Environ(), Env(e)...)
+	cmd.Stdin = bytes.NewReader(input)
+	out := &cappedBuffer{}
+	cmd.Stdout = out
+	cmd.Stderr = out
+	// On timeout, kill the children of the command too: they would keep its
+	// output open and the hook running.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = waitDelay
+
+	start := time.Now()
+	err = cmd.Run()
+	output := out.String()
+	if ctx.Err() == context.DeadlineExceeded {
+		log.Error(ctx.Err(), "hook timed out", "timeout", timeout, "output", output)
+		return
+	}
+	if err != nil {
+		log.Error(err, "hook failed", "output", output)
+		return
+	}
+	log.Info("hook ran", "duration", time.Since(start), "output", output)
+}
+
+// cappedBuffer keeps the first maxOutput bytes written to it and drops the
+// rest.
+type cappedBuffer struct {
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := maxOutput - b.buf.Len(); len(p) > room {
+		b.buf.Write(p[:room])
+		b.truncated = true
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func (b *cappedBuffer) String() string {
+	if b.truncated {
+		return b.buf.String() + "..."
+	}
+	return b.buf.String()
+}
+
+// Env returns the environment variables describing e to a hook.
+func Env(e events.Event) []string {
+	return []string{
+		"METALBOOT_EVENT=" + string(e.Type),
+		"METALBOOT_MAC=" + e.MAC,
+		"METALBOOT_IP=" + e.IP,
+		"METALBOOT_STATE=" + e.State,
+		"METALBOOT_MESSAGE=" + e.Message,
+		"METALBOOT_TIMESTAMP=" + e.Timestamp.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,79 @@
+package hooks
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/metal3-community/metal-boot/internal/events"
+)
+
+func TestRunner(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "hook")
+	err := os.WriteFile(script, []byte(`#!/bin/sh
+{ echo "$1 $METALBOOT_EVENT $METALBOOT_MAC $METALBOOT_IP"; cat; echo; } > "$2"
+`), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	discovered := filepath.Join(dir, "discovered")
+	all := filepath.Join(dir, "any")
+	r := &Runner{
+		Log: logr.Discard(),
+		Hooks: []Hook{
+			{
+				Name:    "discovered",
+				Events:  []events.Type{events.NodeDiscovered},
+				Command: script,
+				Args:    []string{"d", discovered},
+			},
+			{Command: script, Args: []string{"a", all}},
+			{Command: "sleep", Args: []string{"5"}, Timeout: 10 * time.Millisecond},
+			// The shell's child keeps the output open after the shell is
+			// killed.
+			{Command: "sh", Args: []string{"-c", "sleep 5; true"}, Timeout: 10 * time.Millisecond},
+		},
+	}
+
+	start := time.Now()
+	r.Handle(events.Event{
+		Type: events.ProvisioningComplete,
+		MAC:  "d8:3a:dd:01:02:03",
+		IP:   "10.0.0.9",
+	})
+	r.Wait()
+	if d := time.Since(start); d > 3*time.Second {
+		t.Errorf("hooks took %v, want the timed out hook killed", d)
+	}
+
+	if _, err := os.Stat(discovered); err == nil {
+		t.Error("node-discovered hook ran on provisioning-complete")
+	}
+	b, err := os.ReadFile(all)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, input, _ := strings.Cut(string(b), "\n")
+	if first != "a provisioning-complete d8:3a:dd:01:02:03 10.0.0.9" {
+		t.Errorf("hook environment = %q", first)
+	}
+	if !strings.Contains(input, `"type":"provisioning-complete"`) {
+		t.Errorf("hook input = %q, want the event as JSON", input)
+	}
+}
+
+func TestCappedBuffer(t *testing.T) {
+	var b cappedBuffer
+	for range 3 {
+		if n, err := b.Write([]byte(strings.Repeat("x", maxOutput/2+1))); err != nil || n != maxOutput/2+1 {
+			t.Fatalf("Write() = %d, %v", n, err)
+		}
+	}
+	if got := b.String(); len(got) != maxOutput+len("...") || !strings.HasSuffix(got, "...") {
+		t.Errorf("String() has %d bytes, want the first %d and an ellipsis", len(got), maxOutput)
+	}
+}
@@ -0,0 +1,138 @@
+package hoststate
+
+import (
+	"net"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/metal3-community/metal-boot/internal/metric"
+)
+
+// FallbackMode selects what happens when a host exhausts its boot attempts.
+type FallbackMode string
+
+const (
+	// FallbackDisable stops offering netboot options to the host.
+	FallbackDisable FallbackMode = "disable"
+	// FallbackScript keeps netbooting the host but serves the fallback iPXE script.
+	FallbackScript FallbackMode = "script"
+)
+
+// AttemptTracker counts consecutive failed netboot attempts per host.
+//
+// An attempt starts with a DHCPDISCOVER from a netboot client; retransmits
+// within Window belong to the same attempt. An attempt is successful once the
+// host fetches its iPXE script. After MaxAttempts unsuccessful attempts in a
+// row the host is put into fallback until ResetBootAttempts is called. A
+// tracker without a Store or MaxAttempts only passes the attempts on to SLO.
+type AttemptTracker struct {
+	Store       *Store
+	Log         logr.Logger
+	MaxAttempts int
+	Window      time.Duration
+	Fallback    FallbackMode
+	// SLO follows the outcome of every attempt for the boot SLO metrics.
+	SLO *metric.BootSLO
+}
+
+// RecordDiscover registers a netboot DHCPDISCOVER for mac and reports whether
+// netboot options should be withheld from the host.
+func (t *AttemptTracker) RecordDiscover(mac net.HardwareAddr) bool {
+	if t == nil {
+		return false
+	}
+	t.SLO.Discover(mac)
+	if t.Store == nil || t.MaxAttempts <= 0 {
+		return false
+	}
+
+	h, _ := t.Store.Get(mac)
+	if h.NetbootFallback {
+		return t.Fallback == FallbackDisable
+	}
+	now := time.Now().UTC()
+	if now.Sub(h.LastDiscover) < t.Window {
+		return false
+	}
+
+	// Attempts are only counted in memory until the store is next written;
+	// falling back is written at once.
+	exhausted := h.BootAttempts >= t.MaxAttempts
+	record := t.Store.observe
+	if exhausted {
+		record = t.Store.Update
+	}
+	err := record(mac, func(h *Host) {
+		h.LastDiscover = now
+		if exhausted {
+			h.NetbootFallback = true
+			return
+		}
+		h.BootAttempts++
+	})
+	if err != nil {
+		t.Log.Error(err, "failed to record boot attempt", "mac", mac.String())
+	}
+
+	if !exhausted {
+		return false
+	}
+
+	t.Log.Error(nil, "boot attempts exhausted, falling back",
+		"mac", mac.String(),
+		"attempts", h.BootAttempts,
+		"fallback", t.Fallback,
+	)
+	metric.BootAttemptsExhausted.WithLabelValues(string(t.Fallback)).Inc()
+
+	return t.Fallback == FallbackDisable
+}
+
+// NetbootWithheld reports whether netboot options are currently withheld
+// from mac, without recording an attempt.
+func (t *AttemptTracker) NetbootWithheld(mac net.HardwareAddr) bool {
+	if t == nil || t.Store == nil || t.Fallback != FallbackDisable {
+		return false
+	}
+	h, _ := t.Store.Get(mac)
+
+	return h.NetbootFallback
+}
+
+// RecordScriptFetch marks the current attempt of mac as successful and
+// reports whether the fallback script should be served instead of the normal one.
+func (t *AttemptTracker) RecordScriptFetch(mac net.HardwareAddr) bool {
+	if t == nil {
+		return false
+	}
+	t.SLO.ScriptFetched(mac)
+	if t.Store == nil {
+		return false
+	}
+
+	h, err := t.Store.Get(mac)
+	if err != nil {
+		return false
+	}
+	if h.BootAttempts != 0 {
+		if err := t.Store.observe(mac, func(h *Host) { h.BootAttempts = 0 }); err != nil {
+			t.Log.Error(err, "failed to reset boot attempts", "mac", mac.String())
+		}
+	}
+
+	return h.NetbootFallback && t.Fallback == FallbackScript
+}
+
+// ResetBootAttempts clears the attempt counter and any fallback for mac, and
+// offers netboot to it again if it was disabled after provisioning.
+func (s *Store) ResetBootAttempts(mac net.HardwareAddr) error {
+	if _, err := s.Get(mac); err != nil {
+		return nil
+	}
+
+	return s.Update(mac, func(h *Host) {
+		h.BootAttempts = 0
+		h.NetbootFallback = false
+		h.NetbootDisabled = false
+	})
+}
@@ -0,0 +1,52 @@
+package hoststate
+
+import (
+	"maps"
+	"net"
+	"time"
+)
+
+// BiosSettings are Bios attribute changes requested through Redfish that wait
+// for the next reset of a host.
+type BiosSettings struct {
+	// Attributes are the pending values by attribute name.
+	Attributes map[string]any `json:"attributes"`
+	// RequestedAt is when the settings were last changed.
+	RequestedAt time.Time `json:"requestedAt"`
+}
+
+// StageBiosSettings adds attributes to the pending Bios settings of mac,
+// replacing the pending values of the same attributes.
+func (s *Store) StageBiosSettings(mac net.HardwareAddr, attributes map[string]any) error {
+	now := time.Now().UTC()
+
+	return s.Update(mac, func(h *Host) {
+		pending := map[string]any{}
+		if h.PendingBios != nil {
+			pending = maps.Clone(h.PendingBios.Attributes)
+		}
+		maps.Copy(pending, attributes)
+		h.PendingBios = &BiosSettings{Attributes: pending, RequestedAt: now}
+	})
+}
+
+// PendingBiosSettings returns the Bios settings waiting for the next reset of
+// mac. A nil Store has none.
+func (s *Store) PendingBiosSettings(mac net.HardwareAddr) (BiosSettings, bool) {
+	if s == nil {
+		return BiosSettings{}, false
+	}
+	h, err := s.Get(mac)
+	if err != nil || h.PendingBios == nil {
+		return BiosSettings{}, false
+	}
+
+	return *h.PendingBios, true
+}
+
+// ClearBiosSettings drops the pending Bios settings of mac, once applied.
+func (s *Store) ClearBiosSettings(mac net.HardwareAddr) error {
+	return s.Update(mac, func(h *Host) {
+		h.PendingBios = nil
+	})
+}
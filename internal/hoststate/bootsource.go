@@ -0,0 +1,88 @@
+package hoststate
+
+import (
+	"net"
+	"time"
+)
+
+// Boot protocols recorded in a BootSource.
+const (
+	ProtocolTFTP = "tftp"
+	ProtocolHTTP = "http"
+)
+
+// Requested boot sources that BootSourceDrift knows how to compare against.
+// They match the Redfish BootSourceOverrideTarget values.
+const (
+	BootSourcePxe = "Pxe"
+	BootSourceHdd = "Hdd"
+)
+
+// BootSource describes an artifact a host actually fetched while booting.
+type BootSource struct {
+	// Protocol is the protocol the artifact was fetched over.
+	Protocol string `json:"protocol"`
+	// File is the path of the artifact that was served.
+	File string `json:"file"`
+	// Profile names the kind of boot the artifact belongs to, for example
+	// "ipxe", "config", "inspector", "cleaning" or "fallback".
+	Profile string `json:"profile,omitempty"`
+	// RemoteAddr is the address the request came from.
+	RemoteAddr string `json:"remoteAddr,omitempty"`
+	// ObservedAt is when the artifact was served.
+	ObservedAt time.Time `json:"observedAt"`
+	// BootID correlates the artifact with the DHCP exchange of the same boot.
+	BootID string `json:"bootId,omitempty"`
+	// SHA256 and GitRev identify the build of an iPXE binary in the artifact
+	// registry. The digest is that of the binary before it was patched.
+	SHA256 string `json:"sha256,omitempty"`
+	GitRev string `json:"gitRev,omitempty"`
+}
+
+// RecordBootSource stores src as the latest observed boot source for mac.
+// Hosts fetch several artifacts per boot, so the store is written later.
+// A nil Store is a no-op.
+func (s *Store) RecordBootSource(mac net.HardwareAddr, src BootSource) error {
+	if s == nil {
+		return nil
+	}
+	if src.ObservedAt.IsZero() {
+		src.ObservedAt = time.Now().UTC()
+	}
+
+	return s.observe(mac, func(h *Host) {
+		h.ObservedBootSource = &src
+	})
+}
+
+// RecordRequestedBootSource stores the boot source last requested for mac
+// through the management API. A nil Store is a no-op.
+func (s *Store) RecordRequestedBootSource(mac net.HardwareAddr, target string) error {
+	if s == nil {
+		return nil
+	}
+
+	return s.Update(mac, func(h *Host) {
+		h.RequestedBootSource = target
+		h.RequestedBootSourceAt = time.Now().UTC()
+	})
+}
+
+// BootSourceDrift reports whether the host booted something other than what
+// was last requested: it netbooted after a disk boot was requested, or it was
+// sent the fallback script after a netboot was requested.
+func (h Host) BootSourceDrift() bool {
+	src := h.ObservedBootSource
+	if src == nil || src.ObservedAt.Before(h.RequestedBootSourceAt) {
+		return false
+	}
+
+	switch h.RequestedBootSource {
+	case BootSourceHdd:
+		return true
+	case BootSourcePxe:
+		return src.Profile == "fallback"
+	default:
+		return false
+	}
+}
@@ -0,0 +1,49 @@
+package hoststate
+
+import (
+	"net"
+	"time"
+)
+
+// DHCPClient is what the DHCP fingerprint of a host's latest packet revealed
+// about the software that sent it.
+type DHCPClient struct {
+	// Environment is the guessed sender, for example "uefi-pxe", "ipxe" or
+	// "dhclient".
+	Environment string `json:"environment"`
+	// VendorClass, UserClass and ParamRequestList are options 60, 77 and 55
+	// of the packet.
+	VendorClass      string `json:"vendorClass,omitempty"`
+	UserClass        string `json:"userClass,omitempty"`
+	ParamRequestList string `json:"paramRequestList,omitempty"`
+	// Netboot is set when the packet asked for netboot options.
+	Netboot bool `json:"netboot,omitempty"`
+	// Since is when the host started sending this fingerprint.
+	Since time.Time `json:"since"`
+}
+
+// RecordDHCPClient stores c as the DHCP client of mac. The record only
+// changes with the fingerprint, as clients send it with every DHCP packet,
+// and the store is written later. Packets that do not ask for netboot options only update hosts the
+// store already knows, so that a proxy DHCP server does not record every
+// device on the network. A nil Store is a no-op.
+func (s *Store) RecordDHCPClient(mac net.HardwareAddr, c DHCPClient) error {
+	if s == nil {
+		return nil
+	}
+	h, err := s.Get(mac)
+	if err != nil && !c.Netboot {
+		return nil
+	}
+	if old := h.DHCPClient; old != nil {
+		c.Since = old.Since
+		if *old == c {
+			return nil
+		}
+	}
+	c.Since = time.Now().UTC()
+
+	return s.observe(mac, func(h *Host) {
+		h.DHCPClient = &c
+	})
+}
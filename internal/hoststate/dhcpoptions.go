@@ -0,0 +1,55 @@
+package hoststate
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+	"github.com/metal3-community/metal-boot/internal/dhcp/option"
+)
+
+// DHCPOption is an extra DHCPv4 option offered to a host, in the textual
+// form of dnsmasq option files, such as "1500" for option 26 (MTU) or
+// "01:04:c0:a8:01:01" for option 43.
+type DHCPOption struct {
+	Code  uint8  `json:"code"`
+	Value string `json:"value"`
+}
+
+// ValidateDHCPOptions checks that every option can be encoded and that no
+// code is given twice.
+func ValidateDHCPOptions(opts []DHCPOption) error {
+	seen := make(map[uint8]bool, len(opts))
+	for _, o := range opts {
+		if seen[o.Code] {
+			return fmt.Errorf("option %d is given more than once", o.Code)
+		}
+		seen[o.Code] = true
+		if err := option.Validate(o.Code, o.Value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// EncodeDHCPOptions returns the extra DHCP options of mac in wire format.
+// Options that no longer encode are skipped. A nil Store has none.
+func (s *Store) EncodeDHCPOptions(mac net.HardwareAddr) []data.Option {
+	if s == nil {
+		return nil
+	}
+	h, err := s.Get(mac)
+	if err != nil {
+		return nil
+	}
+
+	out := make([]data.Option, 0, len(h.DHCPOptions))
+	for _, o := range h.DHCPOptions {
+		if value, err := option.Encode(o.Code, o.Value); err == nil {
+			out = append(out, data.Option{Code: o.Code, Value: value})
+		}
+	}
+
+	return out
+}
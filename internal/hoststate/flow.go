@@ -0,0 +1,76 @@
+package hoststate
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+)
+
+// Boot flow states. A host that netboots moves from StateUnknown through
+// StateDiscovering, StateNetbootEnabled and StateProvisioning to
+// StateProvisioned, though it may skip states.
+const (
+	// StateDiscovering means the host sent a DHCPDISCOVER as a netboot client
+	// but was not offered netboot options.
+	StateDiscovering State = "discovering"
+	// StateNetbootEnabled means the host was offered netboot options.
+	StateNetbootEnabled State = "netboot-enabled"
+	// StateProvisioning means the host fetched its iPXE script and is being
+	// deployed.
+	StateProvisioning State = "provisioning"
+)
+
+// ErrTransition is returned for a move the boot flow does not allow.
+var ErrTransition = errors.New("invalid boot flow transition")
+
+// flowTransitions are the states each state may move to along the boot flow.
+// A host that netboots again starts over; a provisioned host only does so
+// once it is offered netboot again. Hosts in other states, such as cleaning
+// or retired, leave them through SetState only.
+var flowTransitions = map[State][]State{
+	StateUnknown:        {StateDiscovering, StateNetbootEnabled, StateProvisioning, StateProvisioned},
+	StateDiscovering:    {StateNetbootEnabled, StateProvisioning, StateProvisioned},
+	StateNetbootEnabled: {StateDiscovering, StateProvisioning, StateProvisioned},
+	StateProvisioning:   {StateDiscovering, StateNetbootEnabled, StateProvisioned},
+	StateProvisioned:    {StateNetbootEnabled},
+	// A wiped host may be deployed again.
+	StateCleaned: {StateDiscovering, StateNetbootEnabled, StateProvisioning, StateProvisioned},
+}
+
+// CanTransition reports whether the boot flow lets a host in state from move
+// to state to.
+func CanTransition(from, to State) bool {
+	return slices.Contains(flowTransitions[from], to)
+}
+
+// Transition moves mac along the boot flow to state to and returns the state
+// it was in. A host in state to already is left as it is. If the flow does not
+// allow the move, the host is not changed and the error wraps ErrTransition.
+// The state is checked and changed at once, so of concurrent moves only those
+// that applied return another state than to without an error. A nil Store is
+// a no-op.
+func (s *Store) Transition(mac net.HardwareAddr, to State, message string) (State, error) {
+	if s == nil {
+		return StateUnknown, nil
+	}
+
+	from := StateUnknown
+	err := s.change(mac, "", 0, func(h *Host) bool {
+		from = h.State
+		if from == to || !CanTransition(from, to) {
+			return false
+		}
+		h.State = to
+		h.Message = message
+		return true
+	}, true)
+	if err != nil {
+		return from, err
+	}
+	if from != to && !CanTransition(from, to) {
+		return from, fmt.Errorf("%w: %q to %q", ErrTransition, from, to)
+	}
+
+	return from, nil
+}
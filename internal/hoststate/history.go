@@ -0,0 +1,188 @@
+package hoststate
+
+import (
+	"errors"
+	"maps"
+	"net"
+	"slices"
+	"time"
+)
+
+// maxHistory is the number of revisions kept per host. Older revisions are
+// dropped.
+const maxHistory = 20
+
+// ErrRevisionNotFound is returned when a host has no revision of a version.
+var ErrRevisionNotFound = errors.New("revision not found")
+
+// Settings are the operator controlled settings of a host. Every change to
+// them is recorded as a Revision in the history of the host.
+type Settings struct {
+	KernelArgs          KernelArgs        `json:"kernelArgs"`
+	Metadata            map[string]string `json:"metadata,omitempty"`
+	NetbootDisabled     bool              `json:"netbootDisabled,omitempty"`
+	RequestedBootSource string            `json:"requestedBootSource,omitempty"`
+	VirtualMedia        *VirtualMedia     `json:"virtualMedia,omitempty"`
+	DHCPOptions         []DHCPOption      `json:"dhcpOptions,omitempty"`
+}
+
+// Revision is the settings of a host after a change.
+type Revision struct {
+	// Version numbers the revisions of a host from 1.
+	Version int       `json:"version"`
+	Time    time.Time `json:"time"`
+	// Actor is who made the change through the admin API. It is empty for
+	// changes made through Redfish or by metal-boot itself.
+	Actor string `json:"actor,omitempty"`
+	// Changed lists the settings that differ from the previous revision.
+	Changed []string `json:"changed,omitempty"`
+	// RevertOf is the version this revision reverted to, if it is a revert.
+	RevertOf int      `json:"revertOf,omitempty"`
+	Settings Settings `json:"settings"`
+}
+
+// settings returns a copy of the settings of h that shares nothing with it.
+func (h *Host) settings() Settings {
+	s := Settings{
+		KernelArgs: KernelArgs{
+			Add:    slices.Clone(h.KernelArgs.Add),
+			Remove: slices.Clone(h.KernelArgs.Remove),
+		},
+		NetbootDisabled:     h.NetbootDisabled,
+		RequestedBootSource: h.RequestedBootSource,
+		DHCPOptions:         slices.Clone(h.DHCPOptions),
+	}
+	if len(h.Metadata) > 0 {
+		s.Metadata = maps.Clone(h.Metadata)
+	}
+	if h.VirtualMedia != nil {
+		vm := *h.VirtualMedia
+		s.VirtualMedia = &vm
+	}
+
+	return s
+}
+
+// clone returns a copy of s that shares nothing with it.
+func (s Settings) clone() Settings {
+	var h Host
+	h.apply(s)
+
+	return h.settings()
+}
+
+// apply replaces the settings of h with a copy of s.
+func (h *Host) apply(s Settings) {
+	h.KernelArgs = KernelArgs{
+		Add:    slices.Clone(s.KernelArgs.Add),
+		Remove: slices.Clone(s.KernelArgs.Remove),
+	}
+	h.Metadata = maps.Clone(s.Metadata)
+	h.NetbootDisabled = s.NetbootDisabled
+	h.RequestedBootSource = s.RequestedBootSource
+	h.DHCPOptions = slices.Clone(s.DHCPOptions)
+	h.VirtualMedia = nil
+	if s.VirtualMedia != nil {
+		vm := *s.VirtualMedia
+		h.VirtualMedia = &vm
+	}
+}
+
+// changed returns the JSON names of the settings that differ between a and b.
+// Nil and empty collections are equal.
+func changed(a, b Settings) []string {
+	var out []string
+	if !slices.Equal(a.KernelArgs.Add, b.KernelArgs.Add) ||
+		!slices.Equal(a.KernelArgs.Remove, b.KernelArgs.Remove) {
+		out = append(out, "kernelArgs")
+	}
+	if !maps.Equal(a.Metadata, b.Metadata) {
+		out = append(out, "metadata")
+	}
+	if a.NetbootDisabled != b.NetbootDisabled {
+		out = append(out, "netbootDisabled")
+	}
+	if a.RequestedBootSource != b.RequestedBootSource {
+		out = append(out, "requestedBootSource")
+	}
+	if (a.VirtualMedia == nil) != (b.VirtualMedia == nil) ||
+		(a.VirtualMedia != nil && *a.VirtualMedia != *b.VirtualMedia) {
+		out = append(out, "virtualMedia")
+	}
+	if !slices.Equal(a.DHCPOptions, b.DHCPOptions) {
+		out = append(out, "dhcpOptions")
+	}
+
+	return out
+}
+
+// record appends a revision to the history of h if its settings differ from
+// before. Callers must hold s.mu.
+func (h *Host) record(before Settings, actor string, revertOf int, now time.Time) {
+	after := h.settings()
+	diff := changed(before, after)
+	if len(diff) == 0 {
+		return
+	}
+
+	if len(h.History) == 0 {
+		// The settings before the first recorded change can be reverted to
+		// as well.
+		h.History = append(h.History, Revision{Version: 1, Time: now, Settings: before})
+	}
+	last := h.History[len(h.History)-1]
+	h.History = append(h.History, Revision{
+		Version:  last.Version + 1,
+		Time:     now,
+		Actor:    actor,
+		Changed:  diff,
+		RevertOf: revertOf,
+		Settings: after,
+	})
+	if len(h.History) > maxHistory {
+		h.History = slices.Clone(h.History[len(h.History)-maxHistory:])
+	}
+}
+
+// UpdateAs is Update for changes made by actor, who is recorded in the
+// history of the host if its settings change.
+func (s *Store) UpdateAs(mac net.HardwareAddr, actor string, fn func(h *Host)) error {
+	return s.update(mac, actor, 0, fn)
+}
+
+// History returns the revisions of the settings of mac, oldest first.
+func (s *Store) History(mac net.HardwareAddr) []Revision {
+	h, _ := s.Get(mac)
+
+	return slices.Clone(h.History)
+}
+
+// Revert restores the settings of mac to those of revision version on behalf
+// of actor, and returns the latest revision, which records the revert unless
+// the settings were already those of version. It returns
+// ErrRevisionNotFound if the host has no such revision.
+func (s *Store) Revert(mac net.HardwareAddr, version int, actor string) (Revision, error) {
+	rev, ok := findRevision(s.History(mac), version)
+	if !ok {
+		return Revision{}, ErrRevisionNotFound
+	}
+
+	if err := s.update(mac, actor, version, func(h *Host) {
+		h.apply(rev.Settings)
+	}); err != nil {
+		return Revision{}, err
+	}
+	history := s.History(mac)
+
+	return history[len(history)-1], nil
+}
+
+func findRevision(history []Revision, version int) (Revision, bool) {
+	for _, rev := range history {
+		if rev.Version == version {
+			return rev, true
+		}
+	}
+
+	return Revision{}, false
+}
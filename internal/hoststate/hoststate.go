@@ -0,0 +1,467 @@
+// Package hoststate tracks per-host lifecycle state that metal-boot itself
+// drives (cleaning, provisioning, boot attempts) and persists it to disk so it
+// survives restarts.
+package hoststate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/metal3-community/metal-boot/internal/util"
+)
+
+// State is the lifecycle state of a host as seen by metal-boot.
+type State string
+
+const (
+	// StateUnknown is the state of a host that has no record.
+	StateUnknown State = ""
+	// StateCleaning means the host has been booted into the cleaning image.
+	StateCleaning State = "cleaning"
+	// StateCleaned means the cleaning image reported a successful wipe.
+	StateCleaned State = "cleaned"
+	// StateCleanFailed means the cleaning image reported a failure.
+	StateCleanFailed State = "clean-failed"
+)
+
+// observeSaveDelay is how long observations may stay unwritten, see observe.
+const observeSaveDelay = 10 * time.Second
+
+// ErrNotFound is returned when no record exists for a host.
+var ErrNotFound = errors.New("host state not found")
+
+// Host is the persisted record of a single host.
+type Host struct {
+	// MAC is the normalized (lowercase, colon separated) MAC address of the host.
+	MAC string `json:"mac"`
+	// State is the current lifecycle state.
+	State State `json:"state"`
+	// Message is a free-form detail attached to the last transition.
+	Message string `json:"message,omitempty"`
+	// UpdatedAt is the time of the last transition.
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	// BootAttempts is the number of consecutive netboot attempts that did not
+	// get as far as fetching an iPXE script.
+	BootAttempts int `json:"bootAttempts,omitempty"`
+	// LastDiscover is when the most recent netboot attempt started.
+	LastDiscover time.Time `json:"lastDiscover"`
+	// NetbootFallback is set once BootAttempts exceeded the configured limit.
+	NetbootFallback bool `json:"netbootFallback,omitempty"`
+
+	// PhonedHomeAt is when the host last reported that its operating system
+	// was up.
+	PhonedHomeAt time.Time `json:"phonedHomeAt"`
+	// NetbootDisabled withholds netboot options from a host that finished
+	// provisioning.
+	NetbootDisabled bool `json:"netbootDisabled,omitempty"`
+	// NetbootIgnored is set while the backend entry of the host is switched
+	// to ignore its DHCP requests because netboot was disabled.
+	NetbootIgnored bool `json:"netbootIgnored,omitempty"`
+
+	// KernelArgs are per-host changes applied on top of the global kernel args.
+	KernelArgs KernelArgs `json:"kernelArgs"`
+
+	// UUID is the machine UUID the host sent in DHCP option 97, in the
+	// lowercase RFC 4122 text form.
+	UUID string `json:"uuid,omitempty"`
+
+	// Metadata is free-form key/value data about the host, such as its rack,
+	// owner or purchase date. It is exposed to the host's iPXE script.
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// RequestedBootSource is the boot source override last requested through
+	// Redfish (for example "Pxe" or "Hdd").
+	RequestedBootSource string `json:"requestedBootSource,omitempty"`
+	// RequestedBootSourceAt is when RequestedBootSource was last set.
+	RequestedBootSourceAt time.Time `json:"requestedBootSourceAt"`
+	// ObservedBootSource is the last boot artifact the host actually fetched.
+	ObservedBootSource *BootSource `json:"observedBootSource,omitempty"`
+
+	// DHCPClient is the client environment guessed from the DHCP fingerprint
+	// of the host's latest packet.
+	DHCPClient *DHCPClient `json:"dhcpClient,omitempty"`
+
+	// VirtualMedia is the ISO image inserted into the virtual CD drive of
+	// the host through Redfish. The host boots it until it is ejected.
+	VirtualMedia *VirtualMedia `json:"virtualMedia,omitempty"`
+
+	// History holds the latest revisions of the settings of the host.
+	History []Revision `json:"history,omitempty"`
+
+	// ClientID is the DHCP client identifier (option 61) of the host, in
+	// lowercase hexadecimal. It is recorded when hosts are identified by it.
+	ClientID string `json:"clientId,omitempty"`
+	// CircuitID is the agent circuit ID of the switch port the host was last
+	// seen on. It is recorded when hosts are matched by it.
+	CircuitID string `json:"circuitId,omitempty"`
+	// Serial is the Raspberry Pi serial number of the host. It is recorded
+	// when hosts are matched by it.
+	Serial string `json:"serial,omitempty"`
+	// Aliases are the other MAC addresses the host was seen with, in
+	// normalized form. They resolve to this record.
+	Aliases []string `json:"aliases,omitempty"`
+
+	// PendingBios are the Bios settings applied at the next reset of the
+	// host through Redfish.
+	PendingBios *BiosSettings `json:"pendingBios,omitempty"`
+
+	// Sensors are the temperature and throttling readings the host last
+	// reported.
+	Sensors *Sensors `json:"sensors,omitempty"`
+
+	// DHCPOptions are extra DHCP options offered to the host on top of those
+	// of the backend. They override options with the same code.
+	DHCPOptions []DHCPOption `json:"dhcpOptions,omitempty"`
+}
+
+// Store is a file backed, concurrency safe map of host records keyed by MAC.
+type Store struct {
+	mu       sync.RWMutex
+	path     string
+	hosts    map[string]*Host
+	onCreate []func(Host)
+	// onNetboot are called when the netboot setting of a host changes.
+	onNetboot []func(Host)
+	// aliases maps the aliases of hosts to their keys.
+	aliases  map[string]string
+	identity Identity
+	matches  []Match
+	// dirty is set while observations are not written yet, and flush is the
+	// timer that writes them.
+	dirty bool
+	flush *time.Timer
+}
+
+// NewStore creates a Store persisted at path. Existing records are loaded if
+// the file exists. An empty path keeps the store in memory only.
+func NewStore(path string) (*Store, error) {
+	s := &Store{
+		path:    path,
+		hosts:   make(map[string]*Host),
+		aliases: make(map[string]string),
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	b, err := os.ReadFile(filepath.Clean(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read host state file: %w", err)
+	}
+
+	var hosts []*Host
+	if err := json.Unmarshal(b, &hosts); err != nil {
+		return nil, fmt.Errorf("failed to parse host state file: %w", err)
+	}
+	for _, h := range hosts {
+		h.MAC = util.NormalizeMAC(h.MAC)
+		s.hosts[h.MAC] = h
+		for _, alias := range h.Aliases {
+			s.aliases[alias] = h.MAC
+		}
+	}
+
+	return s, nil
+}
+
+// Key returns the normalized map key for a MAC address.
+func Key(mac net.HardwareAddr) string {
+	return strings.ToLower(mac.String())
+}
+
+// key returns the key of the record of mac, following aliases. Callers must
+// hold s.mu.
+func (s *Store) key(mac net.HardwareAddr) string {
+	k := Key(mac)
+	if host, ok := s.aliases[k]; ok {
+		return host
+	}
+
+	return k
+}
+
+// clone returns a copy of h that shares nothing with it, so that callers of
+// the store cannot change records behind its lock.
+func (h *Host) clone() Host {
+	c := *h
+	c.apply(h.settings())
+	c.Aliases = slices.Clone(h.Aliases)
+	c.History = slices.Clone(h.History)
+	for i, rev := range c.History {
+		c.History[i].Changed = slices.Clone(rev.Changed)
+		c.History[i].Settings = rev.Settings.clone()
+	}
+	if h.ObservedBootSource != nil {
+		src := *h.ObservedBootSource
+		c.ObservedBootSource = &src
+	}
+	if h.DHCPClient != nil {
+		client := *h.DHCPClient
+		c.DHCPClient = &client
+	}
+	if h.PendingBios != nil {
+		bios := *h.PendingBios
+		bios.Attributes = maps.Clone(bios.Attributes)
+		c.PendingBios = &bios
+	}
+	if h.Sensors != nil {
+		sensors := *h.Sensors
+		if sensors.CPUTemperature != nil {
+			sensors.CPUTemperature = util.Ptr(*sensors.CPUTemperature)
+		}
+		if sensors.Throttled != nil {
+			sensors.Throttled = util.Ptr(*sensors.Throttled)
+		}
+		c.Sensors = &sensors
+	}
+
+	return c
+}
+
+// Get returns a copy of the record for mac.
+func (s *Store) Get(mac net.HardwareAddr) (Host, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	h, ok := s.hosts[s.key(mac)]
+	if !ok {
+		return Host{MAC: s.key(mac)}, ErrNotFound
+	}
+
+	return h.clone(), nil
+}
+
+// List returns copies of all records ordered by MAC.
+func (s *Store) List() []Host {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make([]Host, 0, len(s.hosts))
+	for _, h := range s.hosts {
+		out = append(out, h.clone())
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].MAC < out[j].MAC })
+
+	return out
+}
+
+// SetState transitions mac to state and persists the store.
+func (s *Store) SetState(mac net.HardwareAddr, state State, message string) error {
+	return s.Update(mac, func(h *Host) {
+		h.State = state
+		h.Message = message
+	})
+}
+
+// Update applies fn to the record for mac, creating it if needed, and
+// persists the store.
+func (s *Store) Update(mac net.HardwareAddr, fn func(h *Host)) error {
+	return s.update(mac, "", 0, fn)
+}
+
+// update is Update recording changes to the settings of the host as made by
+// actor, reverting to revertOf if it is not 0.
+func (s *Store) update(mac net.HardwareAddr, actor string, revertOf int, fn func(h *Host)) error {
+	return s.change(mac, actor, revertOf, always(fn), true)
+}
+
+// observe is Update for observations of the host, such as boot attempts and
+// fetched artifacts, that come with every boot. They are written with the
+// next update, or at most observeSaveDelay later, rather than each time.
+func (s *Store) observe(mac net.HardwareAddr, fn func(h *Host)) error {
+	return s.change(mac, "", 0, always(fn), false)
+}
+
+// always adapts fn to change, applying it unconditionally.
+func always(fn func(h *Host)) func(h *Host) bool {
+	return func(h *Host) bool {
+		fn(h)
+		return true
+	}
+}
+
+// change applies fn to the record for mac and writes the store now if
+// persist is set, or defers writing it otherwise. If fn returns false, it
+// must have left the record as it was: nothing is created or written.
+func (s *Store) change(
+	mac net.HardwareAddr,
+	actor string,
+	revertOf int,
+	fn func(h *Host) bool,
+	persist bool,
+) error {
+	s.mu.Lock()
+	key := s.key(mac)
+	h, ok := s.hosts[key]
+	if !ok {
+		h = &Host{MAC: key}
+	}
+	before := h.settings()
+	if !fn(h) {
+		s.mu.Unlock()
+		return nil
+	}
+	s.hosts[key] = h
+	h.UpdatedAt = time.Now().UTC()
+	h.record(before, actor, revertOf, h.UpdatedAt)
+	var err error
+	if persist {
+		err = s.save()
+	} else {
+		s.saveLater()
+	}
+	updated, onCreate, onNetboot := h.clone(), s.onCreate, s.onNetboot
+	s.mu.Unlock()
+
+	if !ok {
+		for _, fn := range onCreate {
+			fn(updated)
+		}
+	}
+	if before.NetbootDisabled != updated.NetbootDisabled {
+		for _, fn := range onNetboot {
+			fn(updated)
+		}
+	}
+
+	return err
+}
+
+// OnCreate registers fn to be called with every record created from now on,
+// after it is stored. fn must not block.
+func (s *Store) OnCreate(fn func(Host)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.onCreate = append(s.onCreate, fn)
+}
+
+// OnNetbootChange registers fn to be called with the record of every host
+// whose NetbootDisabled setting changes from now on, after it is stored. fn
+// may update the host but must not block.
+func (s *Store) OnNetbootChange(fn func(Host)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.onNetboot = append(s.onNetboot, fn)
+}
+
+// Delete removes the record for mac and persists the store. An alias is
+// unlinked from its host instead.
+func (s *Store) Delete(mac net.HardwareAddr) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := Key(mac)
+	if host, ok := s.aliases[key]; ok {
+		// Deleting an alias only unlinks it from its host.
+		delete(s.aliases, key)
+		h := s.hosts[host]
+		h.Aliases = slices.DeleteFunc(h.Aliases, func(a string) bool { return a == key })
+		return s.save()
+	}
+	if h, ok := s.hosts[key]; ok {
+		for _, alias := range h.Aliases {
+			delete(s.aliases, alias)
+		}
+	}
+	delete(s.hosts, key)
+
+	return s.save()
+}
+
+// saveLater schedules writing the store. Callers must hold s.mu.
+func (s *Store) saveLater() {
+	if s.path == "" {
+		return
+	}
+	s.dirty = true
+	if s.flush == nil {
+		s.flush = time.AfterFunc(observeSaveDelay, func() { _ = s.Flush() })
+	}
+}
+
+// Flush writes observations that are not written yet. It is called on
+// shutdown so that they are not lost.
+func (s *Store) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.flush = nil
+	if !s.dirty {
+		return nil
+	}
+	if err := s.save(); err != nil {
+		// Try again later rather than dropping the observations.
+		s.saveLater()
+		return err
+	}
+
+	return nil
+}
+
+// save writes all records atomically. Callers must hold s.mu.
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	hosts := make([]*Host, 0, len(s.hosts))
+	for _, h := range s.hosts {
+		hosts = append(hosts, h)
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].MAC < hosts[j].MAC })
+
+	b, err := json.MarshalIndent(hosts, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal host state: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create host state directory: %w", err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return fmt.Errorf("failed to write host state file: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to replace host state file: %w", err)
+	}
+	s.dirty = false
+
+	return nil
+}
+
+// Import replaces all records with hosts, such as the records of a snapshot
+// taken by another instance, and persists the store.
+func (s *Store) Import(hosts []Host) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.hosts = make(map[string]*Host, len(hosts))
+	s.aliases = make(map[string]string)
+	for _, h := range hosts {
+		h.MAC = util.NormalizeMAC(h.MAC)
+		s.hosts[h.MAC] = &h
+		for _, alias := range h.Aliases {
+			s.aliases[alias] = h.MAC
+		}
+	}
+
+	return s.save()
+}
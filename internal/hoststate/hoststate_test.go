@@ -0,0 +1,636 @@
+package hoststate
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestStorePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts.json")
+	mac, _ := net.ParseMAC("AA:BB:CC:DD:EE:FF")
+
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+
+	if _, err := s.Get(mac); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get() error = %v, want %v", err, ErrNotFound)
+	}
+
+	if err := s.SetState(mac, StateCleaning, "quick"); err != nil {
+		t.Fatalf("SetState() error = %v", err)
+	}
+
+	reloaded, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() reload error = %v", err)
+	}
+
+	h, err := reloaded.Get(mac)
+	if err != nil {
+		t.Fatalf("Get() after reload error = %v", err)
+	}
+	if h.MAC != "aa:bb:cc:dd:ee:ff" || h.State != StateCleaning || h.Message != "quick" {
+		t.Errorf("Get() = %+v, want cleaning record for aa:bb:cc:dd:ee:ff", h)
+	}
+
+	if err := reloaded.Delete(mac); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if got := reloaded.List(); len(got) != 0 {
+		t.Errorf("List() after Delete() = %v, want empty", got)
+	}
+}
+
+func TestAttemptTracker(t *testing.T) {
+	s, err := NewStore("")
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:ff")
+
+	tr := &AttemptTracker{
+		Store:       s,
+		Log:         logr.Discard(),
+		MaxAttempts: 2,
+		Fallback:    FallbackDisable,
+	}
+
+	// Two failed attempts are allowed, the third trips the fallback.
+	for i := range 2 {
+		if tr.RecordDiscover(mac) {
+			t.Fatalf("RecordDiscover() attempt %d withheld netboot too early", i+1)
+		}
+	}
+	if !tr.RecordDiscover(mac) {
+		t.Fatal("RecordDiscover() did not withhold netboot after max attempts")
+	}
+	if !tr.NetbootWithheld(mac) {
+		t.Error("NetbootWithheld() = false after fallback")
+	}
+
+	if err := s.ResetBootAttempts(mac); err != nil {
+		t.Fatalf("ResetBootAttempts() error = %v", err)
+	}
+	if tr.NetbootWithheld(mac) {
+		t.Error("NetbootWithheld() = true after reset")
+	}
+
+	// A script fetch between attempts resets the counter.
+	tr.RecordDiscover(mac)
+	tr.RecordScriptFetch(mac)
+	tr.RecordDiscover(mac)
+	if h, _ := s.Get(mac); h.BootAttempts != 1 {
+		t.Errorf("BootAttempts = %d after script fetch, want 1", h.BootAttempts)
+	}
+}
+
+func TestObservationsSavedLater(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts.json")
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:ff")
+	tr := &AttemptTracker{Store: s, Log: logr.Discard(), MaxAttempts: 3}
+
+	tr.RecordDiscover(mac)
+	if err := s.RecordBootSource(mac, BootSource{Protocol: ProtocolTFTP, File: "ipxe.efi"}); err != nil {
+		t.Fatalf("RecordBootSource() error = %v", err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("observations were written at once: %v", err)
+	}
+
+	if err := s.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	reloaded, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() reload error = %v", err)
+	}
+	h, _ := reloaded.Get(mac)
+	if h.BootAttempts != 1 || h.ObservedBootSource == nil {
+		t.Errorf("Get() after Flush() = %+v, want 1 attempt and a boot source", h)
+	}
+}
+
+func TestKernelArgsApply(t *testing.T) {
+	k := KernelArgs{
+		Add:    []string{"debug", "console=tty0"},
+		Remove: []string{"console", "quiet=1"},
+	}
+	got := k.Apply([]string{"console=ttyS0", "quiet", "quiet=1", "debug", "root=/dev/sda"})
+	want := []string{"quiet", "debug", "root=/dev/sda", "console=tty0"}
+
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("Apply() = %v, want %v", got, want)
+	}
+}
+
+func TestDHCPOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []DHCPOption
+		wantErr bool
+	}{
+		{name: "valid", opts: []DHCPOption{{Code: 26, Value: "1500"}, {Code: 43, Value: "01:02"}}},
+		{name: "duplicate", wantErr: true,
+			opts: []DHCPOption{{Code: 26, Value: "1500"}, {Code: 26, Value: "9000"}}},
+		{name: "invalid value", opts: []DHCPOption{{Code: 26, Value: "jumbo"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateDHCPOptions(tt.opts); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateDHCPOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	s, err := NewStore(filepath.Join(t.TempDir(), "hosts.json"))
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	mac, _ := net.ParseMAC("AA:BB:CC:DD:EE:FF")
+	if got := s.EncodeDHCPOptions(mac); len(got) != 0 {
+		t.Errorf("EncodeDHCPOptions() of unknown host = %v, want none", got)
+	}
+	if err := s.Update(mac, func(h *Host) {
+		h.DHCPOptions = []DHCPOption{{Code: 26, Value: "1500"}}
+	}); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	got := s.EncodeDHCPOptions(mac)
+	if len(got) != 1 || got[0].Code != 26 || string(got[0].Value) != "\x05\xdc" {
+		t.Errorf("EncodeDHCPOptions() = %v, want option 26 05dc", got)
+	}
+	if got := (*Store)(nil).EncodeDHCPOptions(mac); got != nil {
+		t.Errorf("nil Store EncodeDHCPOptions() = %v, want nil", got)
+	}
+}
+
+func TestBootSourceDrift(t *testing.T) {
+	mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:ff")
+	s, err := NewStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.RecordRequestedBootSource(mac, BootSourceHdd); err != nil {
+		t.Fatal(err)
+	}
+	h, _ := s.Get(mac)
+	if h.BootSourceDrift() {
+		t.Fatal("drift reported before the host booted")
+	}
+
+	if err := s.RecordBootSource(mac, BootSource{Protocol: ProtocolHTTP, File: "boot.ipxe", Profile: "static"}); err != nil {
+		t.Fatal(err)
+	}
+	h, _ = s.Get(mac)
+	if !h.BootSourceDrift() {
+		t.Fatal("expected drift after netboot when disk boot was requested")
+	}
+
+	if err := s.RecordRequestedBootSource(mac, BootSourcePxe); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RecordBootSource(mac, BootSource{Protocol: ProtocolHTTP, File: "pxelinux.cfg/aa-bb-cc-dd-ee-ff", Profile: "config"}); err != nil {
+		t.Fatal(err)
+	}
+	h, _ = s.Get(mac)
+	if h.BootSourceDrift() {
+		t.Fatal("unexpected drift for requested netboot")
+	}
+	if h.ObservedBootSource.Protocol != ProtocolHTTP || h.ObservedBootSource.ObservedAt.IsZero() {
+		t.Fatalf("unexpected observed boot source: %+v", h.ObservedBootSource)
+	}
+}
+
+func TestRecordUUID(t *testing.T) {
+	s, err := NewStore("")
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:ff")
+
+	if err := s.RecordUUID(mac, "00112233-4455-6677-8899-AABBCCDDEEFF"); err != nil {
+		t.Fatalf("RecordUUID() error = %v", err)
+	}
+	h, err := s.FindByUUID("00112233-4455-6677-8899-aabbccddeeff")
+	if err != nil {
+		t.Fatalf("FindByUUID() error = %v", err)
+	}
+	if h.MAC != "aa:bb:cc:dd:ee:ff" || h.UUID != "00112233-4455-6677-8899-aabbccddeeff" {
+		t.Errorf("FindByUUID() = %+v", h)
+	}
+
+	updated := h.UpdatedAt
+	if err := s.RecordUUID(mac, h.UUID); err != nil {
+		t.Fatalf("RecordUUID() error = %v", err)
+	}
+	if h, _ := s.Get(mac); !h.UpdatedAt.Equal(updated) {
+		t.Error("RecordUUID() rewrote an unchanged UUID")
+	}
+
+	if _, err := s.FindByUUID("ffffffff-4455-6677-8899-aabbccddeeff"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("FindByUUID() of an unknown UUID error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestRecordDHCPClient(t *testing.T) {
+	s, err := NewStore("")
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:ff")
+
+	if err := s.RecordDHCPClient(mac, DHCPClient{Environment: "dhclient"}); err != nil {
+		t.Fatalf("RecordDHCPClient() error = %v", err)
+	}
+	if _, err := s.Get(mac); !errors.Is(err, ErrNotFound) {
+		t.Errorf("RecordDHCPClient() of an unknown non-netboot client created a host: %v", err)
+	}
+
+	pxe := DHCPClient{Environment: "uefi-pxe", VendorClass: "PXEClient:Arch:00007", Netboot: true}
+	if err := s.RecordDHCPClient(mac, pxe); err != nil {
+		t.Fatalf("RecordDHCPClient() error = %v", err)
+	}
+	h, err := s.Get(mac)
+	if err != nil || h.DHCPClient == nil || h.DHCPClient.Environment != "uefi-pxe" {
+		t.Fatalf("Get() = %+v, %v", h, err)
+	}
+
+	updated := h.UpdatedAt
+	if err := s.RecordDHCPClient(mac, pxe); err != nil {
+		t.Fatalf("RecordDHCPClient() error = %v", err)
+	}
+	if h, _ := s.Get(mac); !h.UpdatedAt.Equal(updated) {
+		t.Error("RecordDHCPClient() rewrote an unchanged fingerprint")
+	}
+
+	if err := s.RecordDHCPClient(mac, DHCPClient{Environment: "dhclient"}); err != nil {
+		t.Fatalf("RecordDHCPClient() error = %v", err)
+	}
+	if h, _ := s.Get(mac); h.DHCPClient.Environment != "dhclient" {
+		t.Errorf("DHCPClient = %+v, want dhclient for a known host", h.DHCPClient)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	s, err := NewStore("")
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:ff")
+
+	if err := s.UpdateAs(mac, "alice", func(h *Host) {
+		h.KernelArgs = KernelArgs{Add: []string{"debug"}}
+	}); err != nil {
+		t.Fatalf("UpdateAs() error = %v", err)
+	}
+	// Changes to anything but the settings are not recorded.
+	if err := s.SetState(mac, StateCleaning, ""); err != nil {
+		t.Fatalf("SetState() error = %v", err)
+	}
+	if err := s.RecordRequestedBootSource(mac, "Pxe"); err != nil {
+		t.Fatalf("RecordRequestedBootSource() error = %v", err)
+	}
+
+	history := s.History(mac)
+	if len(history) != 3 {
+		t.Fatalf("History() = %+v, want 3 revisions", history)
+	}
+	if rev := history[1]; rev.Version != 2 || rev.Actor != "alice" ||
+		strings.Join(rev.Changed, ",") != "kernelArgs" {
+		t.Errorf("revision 2 = %+v, want kernelArgs changed by alice", rev)
+	}
+	if rev := history[2]; rev.Actor != "" ||
+		strings.Join(rev.Changed, ",") != "requestedBootSource" {
+		t.Errorf("revision 3 = %+v, want requestedBootSource changed by metal-boot", rev)
+	}
+
+	rev, err := s.Revert(mac, 1, "bob")
+	if err != nil {
+		t.Fatalf("Revert() error = %v", err)
+	}
+	if rev.Version != 4 || rev.RevertOf != 1 || rev.Actor != "bob" ||
+		strings.Join(rev.Changed, ",") != "kernelArgs,requestedBootSource" {
+		t.Errorf("Revert() = %+v, want revision 4 reverting to 1", rev)
+	}
+	h, _ := s.Get(mac)
+	if !h.KernelArgs.IsZero() || h.RequestedBootSource != "" || h.State != StateCleaning {
+		t.Errorf("host after Revert() = %+v, want the settings of revision 1", h)
+	}
+
+	if _, err := s.Revert(mac, 42, "bob"); !errors.Is(err, ErrRevisionNotFound) {
+		t.Errorf("Revert() of an unknown version error = %v, want %v", err, ErrRevisionNotFound)
+	}
+
+	for i := range 2 * maxHistory {
+		if err := s.UpdateAs(mac, "alice", func(h *Host) {
+			h.Metadata = map[string]string{"n": strconv.Itoa(i)}
+		}); err != nil {
+			t.Fatalf("UpdateAs() error = %v", err)
+		}
+	}
+	if history := s.History(mac); len(history) != maxHistory ||
+		history[len(history)-1].Version != 4+2*maxHistory {
+		t.Errorf("History() has %d revisions, want the latest %d", len(history), maxHistory)
+	}
+}
+
+func TestOnCreate(t *testing.T) {
+	s, err := NewStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var created []Host
+	s.OnCreate(func(h Host) {
+		// The store is usable from the callback.
+		if _, err := s.Get(net.HardwareAddr{0, 0, 0, 0, 0, 9}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get() from OnCreate error = %v", err)
+		}
+		created = append(created, h)
+	})
+
+	mac, _ := net.ParseMAC("d8:3a:dd:01:02:03")
+	for range 2 {
+		if err := s.SetState(mac, StateCleaning, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(created) != 1 || created[0].MAC != "d8:3a:dd:01:02:03" ||
+		created[0].State != StateCleaning {
+		t.Errorf("OnCreate called with %+v, want the new host once", created)
+	}
+}
+
+func TestCopiesShareNothing(t *testing.T) {
+	s, err := NewStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.OnCreate(func(h Host) {
+		h.KernelArgs.Add[0] = "changed"
+		h.Metadata["rack"] = "changed"
+	})
+
+	mac, _ := net.ParseMAC("d8:3a:dd:01:02:03")
+	if err := s.Update(mac, func(h *Host) {
+		h.KernelArgs.Add = []string{"quiet"}
+		h.Metadata = map[string]string{"rack": "r1"}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	h, _ := s.Get(mac)
+	h.History[len(h.History)-1].Settings.Metadata["rack"] = "changed"
+
+	h, _ = s.Get(mac)
+	if h.KernelArgs.Add[0] != "quiet" || h.Metadata["rack"] != "r1" ||
+		h.History[len(h.History)-1].Settings.Metadata["rack"] != "r1" {
+		t.Errorf("Get() = %+v, changed through a copy", h)
+	}
+}
+
+func TestLink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts.json")
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	s.SetIdentity(IdentityUUID)
+	uuid := "00112233-4455-6677-8899-AABBCCDDEEFF"
+	mac, _ := net.ParseMAC("d8:3a:dd:01:02:03")
+	random, _ := net.ParseMAC("d2:11:22:33:44:55")
+
+	if got, err := s.Link(mac, Identifiers{UUID: uuid}); err != nil || got.String() != mac.String() {
+		t.Fatalf("Link() = %v, %v", got, err)
+	}
+	if err := s.SetState(random, StateCleaning, ""); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.Link(random, Identifiers{UUID: uuid}); err != nil || got.String() != mac.String() {
+		t.Fatalf("Link() of a randomized MAC = %v, %v, want %v", got, err, mac)
+	}
+	if got := len(s.List()); got != 1 {
+		t.Errorf("List() has %d hosts after linking, want 1", got)
+	}
+
+	// Updates through the alias land on the host and survive a reload.
+	if err := s.SetState(random, StateCleaned, "alias"); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() reload error = %v", err)
+	}
+	h, err := reloaded.Get(random)
+	if err != nil || h.MAC != mac.String() || h.State != StateCleaned ||
+		len(h.Aliases) != 1 || h.Aliases[0] != random.String() {
+		t.Errorf("Get() of the alias after reload = %+v, %v", h, err)
+	}
+	if got := reloaded.Resolve(random); got.String() != mac.String() {
+		t.Errorf("Resolve() = %v, want %v", got, mac)
+	}
+
+	// A universally administered MAC is never linked.
+	other, _ := net.ParseMAC("d8:3a:dd:09:09:09")
+	if got, _ := reloaded.Link(other, Identifiers{UUID: uuid}); got.String() != other.String() {
+		t.Errorf("Link() of a universal MAC = %v, want %v", got, other)
+	}
+
+	if err := reloaded.Delete(random); err != nil {
+		t.Fatal(err)
+	}
+	if got := reloaded.Resolve(random); got.String() != random.String() {
+		t.Errorf("Resolve() after deleting the alias = %v", got)
+	}
+	if _, err := reloaded.Get(mac); err != nil {
+		t.Errorf("deleting an alias removed its host: %v", err)
+	}
+}
+
+func TestParseIdentity(t *testing.T) {
+	identities := map[string]Identity{
+		"": IdentityMAC, "uuid": IdentityUUID, "duid": IdentityClientID,
+	}
+	for s, want := range identities {
+		if got, err := ParseIdentity(s); err != nil || got != want {
+			t.Errorf("ParseIdentity(%q) = %q, %v, want %q", s, got, err, want)
+		}
+	}
+	if _, err := ParseIdentity("serial"); err == nil {
+		t.Error("ParseIdentity() of an unknown identity succeeded")
+	}
+}
+
+func TestLinkMatches(t *testing.T) {
+	s, err := NewStore("")
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	s.SetMatches([]Match{MatchCircuitID, MatchSerial})
+	node, _ := net.ParseMAC("d8:3a:dd:01:02:03")
+	usb, _ := net.ParseMAC("00:e0:4c:68:00:01")
+	rewritten, _ := net.ParseMAC("00:e0:4c:68:00:02")
+	other, _ := net.ParseMAC("d8:3a:dd:09:09:09")
+
+	if _, err := s.Link(node, Identifiers{CircuitID: "sw1:Gi1/0/7", Serial: "E4A3C2D1"}); err != nil {
+		t.Fatal(err)
+	}
+	if h, err := s.Get(node); err != nil || h.CircuitID != "sw1:Gi1/0/7" || h.Serial != "e4a3c2d1" {
+		t.Fatalf("Get() = %+v, %v, want the identifiers recorded", h, err)
+	}
+
+	// A new NIC on the same Pi, on another port, follows it by serial.
+	if got, err := s.Link(usb, Identifiers{CircuitID: "sw1:Gi1/0/8", Serial: "e4a3c2d1"}); err != nil ||
+		got.String() != node.String() {
+		t.Fatalf("Link() of a USB NIC = %v, %v, want %v", got, err, node)
+	}
+	// Its switch port is recorded on the host, as is that of the next
+	// address it is matched by.
+	if got, _ := s.Link(rewritten, Identifiers{CircuitID: "sw1:Gi1/0/8"}); got.String() != node.String() {
+		t.Errorf("Link() of a rewritten MAC = %v, want %v", got, node)
+	}
+	if h, _ := s.Get(node); len(h.Aliases) != 2 || h.CircuitID != "sw1:Gi1/0/8" {
+		t.Errorf("host = %+v, want both aliases and the new port", h)
+	}
+
+	// A host with a record of its own keeps it, and takes over the port it
+	// is seen on.
+	if err := s.SetState(other, StateCleaning, ""); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.Link(other, Identifiers{CircuitID: "sw1:Gi1/0/8"}); got.String() != other.String() {
+		t.Errorf("Link() of a recorded MAC = %v, want %v", got, other)
+	}
+	if h, _ := s.Get(node); h.CircuitID != "" {
+		t.Errorf("stale circuit ID %q kept on the host that left the port", h.CircuitID)
+	}
+
+	// Without identifiers the MAC address is the host.
+	unknown, _ := net.ParseMAC("d8:3a:dd:0a:0b:0c")
+	if got, _ := s.Link(unknown, Identifiers{}); got.String() != unknown.String() {
+		t.Errorf("Link() without identifiers = %v, want %v", got, unknown)
+	}
+}
+
+func TestParseMatches(t *testing.T) {
+	got, err := ParseMatches([]string{"rpi_serial", "circuit_id"})
+	if err != nil || len(got) != 2 || got[0] != MatchSerial || got[1] != MatchCircuitID {
+		t.Errorf("ParseMatches() = %v, %v", got, err)
+	}
+	if _, err := ParseMatches([]string{"serial"}); err == nil {
+		t.Error("ParseMatches() of an unknown match succeeded")
+	}
+}
+
+func TestTransition(t *testing.T) {
+	s, err := NewStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac, _ := net.ParseMAC("d8:3a:dd:01:02:03")
+
+	for _, step := range []struct {
+		to      State
+		from    State
+		wantErr bool
+	}{
+		{to: StateDiscovering, from: StateUnknown},
+		{to: StateDiscovering, from: StateDiscovering},
+		{to: StateNetbootEnabled, from: StateDiscovering},
+		{to: StateProvisioning, from: StateNetbootEnabled},
+		{to: StateProvisioned, from: StateProvisioning},
+		{to: StateDiscovering, from: StateProvisioned, wantErr: true},
+		{to: StateNetbootEnabled, from: StateProvisioned},
+	} {
+		from, err := s.Transition(mac, step.to, "")
+		if from != step.from || errors.Is(err, ErrTransition) != step.wantErr {
+			t.Fatalf("Transition(%q) = %q, %v, want %q, error %v", step.to, from, err, step.from, step.wantErr)
+		}
+	}
+	if h, _ := s.Get(mac); h.State != StateNetbootEnabled {
+		t.Errorf("State = %q, want %q", h.State, StateNetbootEnabled)
+	}
+
+	if err := s.SetState(mac, StateCleaning, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Transition(mac, StateProvisioning, ""); !errors.Is(err, ErrTransition) {
+		t.Errorf("Transition() of a cleaning host error = %v, want %v", err, ErrTransition)
+	}
+}
+
+func TestTransitionRace(t *testing.T) {
+	s, err := NewStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac, _ := net.ParseMAC("d8:3a:dd:01:02:03")
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	moved := 0
+	for range 8 {
+		wg.Go(func() {
+			from, err := s.Transition(mac, StateDiscovering, "")
+			if err != nil {
+				t.Errorf("Transition() error = %v", err)
+			}
+			if from != StateDiscovering {
+				mu.Lock()
+				moved++
+				mu.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+	if moved != 1 {
+		t.Errorf("%d concurrent transitions reported a move, want 1", moved)
+	}
+
+	h, _ := s.Get(mac)
+	if _, err := s.Transition(mac, StateDiscovering, ""); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := s.Get(mac); !again.UpdatedAt.Equal(h.UpdatedAt) {
+		t.Error("Transition() to the current state rewrote the host")
+	}
+}
+
+func TestOnNetbootChange(t *testing.T) {
+	s, err := NewStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var changed []bool
+	s.OnNetbootChange(func(h Host) {
+		changed = append(changed, h.NetbootDisabled)
+	})
+
+	mac, _ := net.ParseMAC("d8:3a:dd:01:02:03")
+	if err := s.RecordPhoneHome(mac, "", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RecordPhoneHome(mac, "", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ResetBootAttempts(mac); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(changed, []bool{true, false}) {
+		t.Errorf("OnNetbootChange called with %v, want [true false]", changed)
+	}
+}
@@ -0,0 +1,162 @@
+package hoststate
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/metal3-community/metal-boot/internal/util"
+)
+
+// Identity is what identifies hosts that netboot with a locally administered
+// MAC address, such as an address randomized by the firmware or operating
+// system, which changes without the host changing.
+type Identity string
+
+const (
+	// IdentityMAC identifies every host by its MAC address alone.
+	IdentityMAC Identity = "mac"
+	// IdentityUUID links a locally administered MAC address to the host
+	// with the same machine UUID (DHCP option 97).
+	IdentityUUID Identity = "uuid"
+	// IdentityClientID links a locally administered MAC address to the host
+	// with the same DHCP client identifier (option 61), which carries the
+	// DUID of RFC 4361 clients.
+	IdentityClientID Identity = "duid"
+)
+
+// ParseIdentity returns the Identity named s. An empty s is IdentityMAC.
+func ParseIdentity(s string) (Identity, error) {
+	switch id := Identity(s); id {
+	case "":
+		return IdentityMAC, nil
+	case IdentityMAC, IdentityUUID, IdentityClientID:
+		return id, nil
+	}
+
+	return "", fmt.Errorf("unknown host identity %q, want mac, uuid or duid", s)
+}
+
+// SetIdentity selects how Link identifies hosts with locally administered MAC
+// addresses. The default is IdentityMAC.
+func (s *Store) SetIdentity(id Identity) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.identity = id
+}
+
+// Resolve returns the MAC address of the record of mac: the host mac is an
+// alias of, or mac itself. A nil Store returns mac.
+func (s *Store) Resolve(mac net.HardwareAddr) net.HardwareAddr {
+	if s == nil {
+		return mac
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	host, ok := s.aliases[Key(mac)]
+	if !ok {
+		return mac
+	}
+	if resolved, err := net.ParseMAC(host); err == nil {
+		return resolved
+	}
+
+	return mac
+}
+
+// Identifiers are what a host sent in a DHCP packet that may identify it
+// apart from its MAC address. Empty fields were not sent.
+type Identifiers struct {
+	// UUID is the machine UUID of option 97.
+	UUID string
+	// ClientID is the client identifier of option 61 in hexadecimal.
+	ClientID string
+	// CircuitID is the agent circuit ID of the relay agent (option 82), which
+	// names the switch port the host is connected to.
+	CircuitID string
+	// Serial is the Raspberry Pi serial number the host sent in its vendor
+	// class (option 60) or user class (option 77).
+	Serial string
+}
+
+// Link records the identifiers a host sent with mac and makes mac an alias
+// of the host recorded under another MAC address when:
+//
+//   - mac is locally administered and the host has the same UUID or client
+//     identifier, as the identity of the store selects. A record created
+//     for mac before it was linked is dropped in favour of the host's.
+//   - mac has no record of its own and the host has the same identifier of
+//     the first match of the store that ids carries, so that the record of
+//     a host follows it to a new NIC or a rewritten MAC address.
+//
+// Link returns the MAC address of the record mac resolves to. A nil Store is
+// a no-op.
+func (s *Store) Link(mac net.HardwareAddr, ids Identifiers) (net.HardwareAddr, error) {
+	if s == nil {
+		return mac, nil
+	}
+	uuid, clientID := strings.ToLower(ids.UUID), strings.ToLower(ids.ClientID)
+	ids.UUID, ids.ClientID = uuid, clientID
+
+	s.mu.Lock()
+	id, key := s.identity, Key(mac)
+	match := func(h *Host) bool {
+		switch id {
+		case IdentityUUID:
+			return uuid != "" && h.UUID == uuid
+		case IdentityClientID:
+			return clientID != "" && h.ClientID == clientID
+		}
+		return false
+	}
+	_, linked := s.aliases[key]
+	_, known := s.hosts[key]
+	var host *Host
+	if !linked && util.IsLocallyAdministered(mac) {
+		for _, h := range s.hosts {
+			if h.MAC != key && match(h) {
+				host = h
+				break
+			}
+		}
+	}
+	if host == nil && !linked && !known {
+		host = s.matchHost(ids)
+	}
+	if host != nil {
+		delete(s.hosts, key)
+		host.Aliases = append(host.Aliases, key)
+		slices.Sort(host.Aliases)
+		s.aliases[key] = host.MAC
+		err := s.save()
+		s.mu.Unlock()
+		if err != nil {
+			return s.Resolve(mac), err
+		}
+		return s.Resolve(mac), s.recordMatch(mac, ids)
+	}
+	s.mu.Unlock()
+
+	if err := s.recordMatch(mac, ids); err != nil {
+		return s.Resolve(mac), err
+	}
+
+	// Record the identifiers the next alias of the host is matched by.
+	switch {
+	case id == IdentityUUID && uuid != "":
+		return s.Resolve(mac), s.RecordUUID(mac, uuid)
+	case id == IdentityClientID && clientID != "":
+		h, err := s.Get(mac)
+		if err == nil && h.ClientID == clientID {
+			return s.Resolve(mac), nil
+		}
+		return s.Resolve(mac), s.Update(mac, func(h *Host) {
+			h.ClientID = clientID
+		})
+	}
+
+	return s.Resolve(mac), nil
+}
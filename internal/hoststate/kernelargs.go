@@ -0,0 +1,50 @@
+package hoststate
+
+import (
+	"slices"
+	"strings"
+)
+
+// KernelArgs holds per-host kernel command line overrides.
+type KernelArgs struct {
+	// Add lists arguments appended to the command line.
+	Add []string `json:"add,omitempty"`
+	// Remove lists arguments dropped from the command line. A bare key such
+	// as "console" removes every "console=..." argument, while "console=ttyS0"
+	// only removes that exact argument.
+	Remove []string `json:"remove,omitempty"`
+}
+
+// IsZero reports whether k changes nothing.
+func (k KernelArgs) IsZero() bool {
+	return len(k.Add) == 0 && len(k.Remove) == 0
+}
+
+// Apply returns args with the removals and additions of k applied. Additions
+// are not subject to removals and are not duplicated.
+func (k KernelArgs) Apply(args []string) []string {
+	out := make([]string, 0, len(args)+len(k.Add))
+	for _, arg := range args {
+		if !k.removes(arg) {
+			out = append(out, arg)
+		}
+	}
+	for _, arg := range k.Add {
+		if !slices.Contains(out, arg) {
+			out = append(out, arg)
+		}
+	}
+
+	return out
+}
+
+func (k KernelArgs) removes(arg string) bool {
+	key, _, _ := strings.Cut(arg, "=")
+	for _, r := range k.Remove {
+		if r == arg || (!strings.Contains(r, "=") && r == key) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,126 @@
+package hoststate
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Match is an identifier by which a host is recognized whatever MAC address
+// it boots with, for switches that rewrite MAC addresses and hosts that boot
+// from USB NICs.
+type Match string
+
+const (
+	// MatchCircuitID recognizes a host by the switch port it is connected
+	// to: the agent circuit ID of DHCP option 82.
+	MatchCircuitID Match = "circuit_id"
+	// MatchClientID recognizes a host by its DHCP client identifier (option
+	// 61).
+	MatchClientID Match = "client_id"
+	// MatchSerial recognizes a Raspberry Pi by the serial number it sends in
+	// DHCP option 60 or 77.
+	MatchSerial Match = "rpi_serial"
+)
+
+// ParseMatches returns the Matches named by names, in order.
+func ParseMatches(names []string) ([]Match, error) {
+	matches := make([]Match, 0, len(names))
+	for _, name := range names {
+		switch m := Match(name); m {
+		case MatchCircuitID, MatchClientID, MatchSerial:
+			matches = append(matches, m)
+		default:
+			return nil, fmt.Errorf("unknown host match %q, want circuit_id, client_id or rpi_serial", name)
+		}
+	}
+
+	return matches, nil
+}
+
+// SetMatches selects the identifiers by which Link recognizes hosts that
+// boot with a MAC address of no record, tried in order. The default is none.
+func (s *Store) SetMatches(matches []Match) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.matches = matches
+}
+
+// value returns the identifier of ids that m matches by.
+func (ids Identifiers) value(m Match) string {
+	switch m {
+	case MatchCircuitID:
+		return ids.CircuitID
+	case MatchClientID:
+		return strings.ToLower(ids.ClientID)
+	case MatchSerial:
+		return strings.ToLower(ids.Serial)
+	}
+
+	return ""
+}
+
+// identifier returns the identifier of h that m matches by.
+func (h *Host) identifier(m Match) *string {
+	switch m {
+	case MatchCircuitID:
+		return &h.CircuitID
+	case MatchClientID:
+		return &h.ClientID
+	case MatchSerial:
+		return &h.Serial
+	}
+
+	return nil
+}
+
+// matchHost returns the host with the identifier of the first match of s
+// that ids carries, or nil. Callers must hold s.mu.
+func (s *Store) matchHost(ids Identifiers) *Host {
+	for _, m := range s.matches {
+		v := ids.value(m)
+		if v == "" {
+			continue
+		}
+		for _, h := range s.hosts {
+			if *h.identifier(m) == v {
+				return h
+			}
+		}
+	}
+
+	return nil
+}
+
+// recordMatch records the identifiers of ids that s matches by on the record
+// of mac. An identifier names a single host, so it is removed from the
+// records of other hosts, which it went stale on.
+func (s *Store) recordMatch(mac net.HardwareAddr, ids Identifiers) error {
+	s.mu.Lock()
+	key := s.key(mac)
+	host := s.hosts[key]
+	var changed []Match
+	for _, m := range s.matches {
+		if v := ids.value(m); v != "" && (host == nil || *host.identifier(m) != v) {
+			changed = append(changed, m)
+		}
+	}
+	for _, m := range changed {
+		for k, h := range s.hosts {
+			if k != key && *h.identifier(m) == ids.value(m) {
+				*h.identifier(m) = ""
+			}
+		}
+	}
+	s.mu.Unlock()
+	if len(changed) == 0 {
+		return nil
+	}
+
+	return s.Update(mac, func(h *Host) {
+		for _, m := range changed {
+			*h.identifier(m) = ids.value(m)
+		}
+	})
+}
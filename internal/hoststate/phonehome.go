@@ -0,0 +1,33 @@
+package hoststate
+
+import (
+	"net"
+	"time"
+)
+
+// StateProvisioned means the host phoned home once its operating system was up.
+const StateProvisioned State = "provisioned"
+
+// RecordPhoneHome marks mac as provisioned. When disableNetboot is set, the
+// host is no longer offered netboot options until ResetBootAttempts is called.
+func (s *Store) RecordPhoneHome(mac net.HardwareAddr, message string, disableNetboot bool) error {
+	now := time.Now().UTC()
+
+	return s.Update(mac, func(h *Host) {
+		h.State = StateProvisioned
+		h.Message = message
+		h.PhonedHomeAt = now
+		h.NetbootDisabled = disableNetboot
+	})
+}
+
+// NetbootDisabled reports whether netboot options are withheld from mac
+// because it finished provisioning.
+func (s *Store) NetbootDisabled(mac net.HardwareAddr) bool {
+	if s == nil {
+		return false
+	}
+	h, err := s.Get(mac)
+
+	return err == nil && h.NetbootDisabled
+}
@@ -0,0 +1,15 @@
+package hoststate
+
+import "net"
+
+// StateRetired means the host was decommissioned and is no longer managed.
+const StateRetired State = "retired"
+
+// Retire marks mac as retired and withholds netboot options from it.
+func (s *Store) Retire(mac net.HardwareAddr, message string) error {
+	return s.Update(mac, func(h *Host) {
+		h.State = StateRetired
+		h.Message = message
+		h.NetbootDisabled = true
+	})
+}
@@ -0,0 +1,79 @@
+package hoststate
+
+import (
+	"net"
+	"time"
+)
+
+// Bits of the throttling state a Raspberry Pi reports through
+// `vcgencmd get_throttled`. The low bits describe the current state, the
+// same bits 16 places up whether it happened since boot.
+const (
+	ThrottledUnderVoltage  uint32 = 1 << 0
+	ThrottledFrequencyCap  uint32 = 1 << 1
+	ThrottledThrottling    uint32 = 1 << 2
+	ThrottledSoftTempLimit uint32 = 1 << 3
+
+	throttledMask          uint32 = 0xf
+	throttledOccurredShift        = 16
+)
+
+// Sensors are the readings a host last reported about itself.
+type Sensors struct {
+	// CPUTemperature is the SoC temperature in degrees Celsius.
+	CPUTemperature *float64 `json:"cpuTemperature,omitempty"`
+	// Throttled is the throttling state bit field, see ThrottledUnderVoltage.
+	Throttled *uint32 `json:"throttled,omitempty"`
+	// ReportedAt is when the readings were reported.
+	ReportedAt time.Time `json:"reportedAt"`
+}
+
+// Current returns the throttling conditions that are active now.
+func (s Sensors) Current() uint32 {
+	if s.Throttled == nil {
+		return 0
+	}
+	return *s.Throttled & throttledMask
+}
+
+// Occurred returns the throttling conditions that happened since boot,
+// including those active now.
+func (s Sensors) Occurred() uint32 {
+	if s.Throttled == nil {
+		return 0
+	}
+	return (*s.Throttled>>throttledOccurredShift)&throttledMask | s.Current()
+}
+
+// RecordSensors stores sensors as the latest readings of mac. Readings that
+// are not set keep their previous value.
+func (s *Store) RecordSensors(mac net.HardwareAddr, sensors Sensors) error {
+	if sensors.ReportedAt.IsZero() {
+		sensors.ReportedAt = time.Now().UTC()
+	}
+
+	return s.Update(mac, func(h *Host) {
+		if h.Sensors != nil {
+			if sensors.CPUTemperature == nil {
+				sensors.CPUTemperature = h.Sensors.CPUTemperature
+			}
+			if sensors.Throttled == nil {
+				sensors.Throttled = h.Sensors.Throttled
+			}
+		}
+		h.Sensors = &sensors
+	})
+}
+
+// LatestSensors returns the readings mac last reported. A nil Store has none.
+func (s *Store) LatestSensors(mac net.HardwareAddr) (Sensors, bool) {
+	if s == nil {
+		return Sensors{}, false
+	}
+	h, err := s.Get(mac)
+	if err != nil || h.Sensors == nil {
+		return Sensors{}, false
+	}
+
+	return *h.Sensors, true
+}
@@ -0,0 +1,38 @@
+package hoststate
+
+import (
+	"net"
+	"strings"
+)
+
+// RecordUUID stores uuid as the machine UUID of mac. The store is only
+// written when the UUID changed, as clients send it with every DHCP packet.
+// A nil Store is a no-op.
+func (s *Store) RecordUUID(mac net.HardwareAddr, uuid string) error {
+	if s == nil {
+		return nil
+	}
+	uuid = strings.ToLower(uuid)
+	if h, err := s.Get(mac); err == nil && h.UUID == uuid {
+		return nil
+	}
+
+	return s.Update(mac, func(h *Host) {
+		h.UUID = uuid
+	})
+}
+
+// FindByUUID returns a copy of the record with the machine UUID uuid. The
+// comparison is case-insensitive.
+func (s *Store) FindByUUID(uuid string) (Host, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, h := range s.hosts {
+		if h.UUID != "" && strings.EqualFold(h.UUID, uuid) {
+			return h.clone(), nil
+		}
+	}
+
+	return Host{}, ErrNotFound
+}
@@ -0,0 +1,45 @@
+package hoststate
+
+import (
+	"net"
+	"time"
+)
+
+// VirtualMedia is an ISO image inserted into the virtual CD drive of a host.
+type VirtualMedia struct {
+	// Image is the URL of the ISO image.
+	Image string `json:"image"`
+	// InsertedAt is when the image was inserted.
+	InsertedAt time.Time `json:"insertedAt"`
+}
+
+// InsertMedia inserts the ISO image at the URL image into the virtual CD
+// drive of mac, replacing any image inserted before.
+func (s *Store) InsertMedia(mac net.HardwareAddr, image string) error {
+	now := time.Now().UTC()
+
+	return s.Update(mac, func(h *Host) {
+		h.VirtualMedia = &VirtualMedia{Image: image, InsertedAt: now}
+	})
+}
+
+// EjectMedia ejects the image from the virtual CD drive of mac.
+func (s *Store) EjectMedia(mac net.HardwareAddr) error {
+	return s.Update(mac, func(h *Host) {
+		h.VirtualMedia = nil
+	})
+}
+
+// InsertedMedia returns the image inserted into the virtual CD drive of mac.
+// A nil Store has none.
+func (s *Store) InsertedMedia(mac net.HardwareAddr) (VirtualMedia, bool) {
+	if s == nil {
+		return VirtualMedia{}, false
+	}
+	h, err := s.Get(mac)
+	if err != nil || h.VirtualMedia == nil {
+		return VirtualMedia{}, false
+	}
+
+	return *h.VirtualMedia, true
+}
@@ -0,0 +1,260 @@
+// Package imagecache keeps downloaded boot artifacts (ISOs, Talos images,
+// cached kernels and initrds) within a disk budget.
+//
+// Files are ordered by modification time, which Touch bumps every time a file
+// is served, so the least recently served artifacts are evicted first. Using
+// the file system rather than an in-memory index keeps the order across
+// restarts and lets any component mark a file as used.
+package imagecache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/metal3-community/metal-boot/internal/metric"
+)
+
+// checksumSuffix marks sidecar files that are evicted with the artifact they
+// describe.
+const checksumSuffix = ".sha256"
+
+// inProgressSuffixes mark partial downloads. They count towards usage but are
+// never evicted.
+var inProgressSuffixes = []string{".tmp", ".download", ".part"}
+
+// Janitor evicts the least recently served files from Dirs once their total
+// size exceeds Budget.
+type Janitor struct {
+	// Dirs are the directories whose files are managed. Missing directories
+	// are skipped.
+	Dirs []string
+	// Pinned are files and directories below Dirs that are never evicted,
+	// such as images that are only downloaded at startup. They count towards
+	// the budget.
+	Pinned []string
+	// Budget is the total size in bytes Dirs may use. Zero disables eviction.
+	Budget int64
+	// Interval is the time between collections in Run.
+	Interval time.Duration
+	// Log is used to log evictions.
+	Log logr.Logger
+}
+
+// Usage is the result of one collection.
+type Usage struct {
+	// Bytes is the size of all files after eviction.
+	Bytes int64 `json:"bytes"`
+	// Budget is the configured budget.
+	Budget int64 `json:"budget"`
+	// Evicted lists the removed files.
+	Evicted []string `json:"evicted,omitempty"`
+	// EvictedBytes is the size of the removed files.
+	EvictedBytes int64 `json:"evictedBytes"`
+}
+
+type entry struct {
+	path    string
+	dir     string
+	size    int64
+	modTime time.Time
+	evict   bool
+}
+
+// Run collects once immediately and then every Interval until ctx is done.
+func (j *Janitor) Run(ctx context.Context) error {
+	metric.ImageCacheBudgetBytes.Set(float64(j.Budget))
+
+	interval := j.Interval
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := j.Collect(); err != nil {
+			j.Log.Error(err, "image cache collection failed")
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Collect measures Dirs and, if they exceed Budget, removes the least
+// recently served files until they fit.
+func (j *Janitor) Collect() (Usage, error) {
+	usage := Usage{Budget: j.Budget}
+
+	entries, perDir, err := j.scan()
+	if err != nil {
+		return usage, err
+	}
+	for _, e := range entries {
+		usage.Bytes += e.size
+	}
+
+	if j.Budget > 0 && usage.Bytes > j.Budget {
+		candidates := make([]entry, 0, len(entries))
+		for _, e := range entries {
+			if e.evict {
+				candidates = append(candidates, e)
+			}
+		}
+		sort.Slice(candidates, func(a, b int) bool {
+			return candidates[a].modTime.Before(candidates[b].modTime)
+		})
+
+		for _, e := range candidates {
+			if usage.Bytes <= j.Budget {
+				break
+			}
+			freed, err := remove(e.path)
+			if err != nil {
+				j.Log.Error(err, "failed to evict cached image", "path", e.path)
+				continue
+			}
+			j.Log.Info("evicted cached image",
+				"path", e.path,
+				"bytes", freed,
+				"last_served", e.modTime,
+			)
+			usage.Bytes -= freed
+			usage.Evicted = append(usage.Evicted, e.path)
+			usage.EvictedBytes += freed
+			perDir[e.dir] -= freed
+			metric.ImageCacheEvictions.WithLabelValues(e.dir).Inc()
+			metric.ImageCacheEvictedBytes.WithLabelValues(e.dir).Add(float64(freed))
+		}
+
+		if usage.Bytes > j.Budget {
+			j.Log.Info("image cache still over budget after eviction",
+				"bytes", usage.Bytes,
+				"budget", j.Budget,
+			)
+		}
+	}
+
+	for dir, n := range perDir {
+		metric.ImageCacheBytes.WithLabelValues(dir).Set(float64(n))
+	}
+
+	return usage, nil
+}
+
+// scan lists the files below Dirs. Checksum sidecars are folded into the size
+// of the file they belong to.
+func (j *Janitor) scan() ([]entry, map[string]int64, error) {
+	var entries []entry
+	perDir := make(map[string]int64, len(j.Dirs))
+	sidecars := make(map[string]int64)
+
+	for _, dir := range j.Dirs {
+		perDir[dir] = 0
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+
+			perDir[dir] += info.Size()
+			if strings.HasSuffix(path, checksumSuffix) {
+				sidecars[strings.TrimSuffix(path, checksumSuffix)] += info.Size()
+				return nil
+			}
+			entries = append(entries, entry{
+				path:    path,
+				dir:     dir,
+				size:    info.Size(),
+				modTime: info.ModTime(),
+				evict:   !inProgress(path) && !j.pinned(path),
+			})
+			return nil
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to scan %s: %w", dir, err)
+		}
+	}
+
+	for i := range entries {
+		entries[i].size += sidecars[entries[i].path]
+	}
+
+	return entries, perDir, nil
+}
+
+// pinned reports whether path is or lies below one of Pinned.
+func (j *Janitor) pinned(path string) bool {
+	for _, p := range j.Pinned {
+		if path == p || strings.HasPrefix(path, p+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Touch marks path as served now, moving it to the back of the eviction order.
+func Touch(path string) error {
+	now := time.Now()
+	return os.Chtimes(path, now, now)
+}
+
+// RecordHit counts a request for an artifact that was present in cache.
+func RecordHit(cache string) {
+	metric.ImageCacheRequests.WithLabelValues(cache, "hit").Inc()
+}
+
+// RecordMiss counts a request for an artifact that was not present in cache.
+func RecordMiss(cache string) {
+	metric.ImageCacheRequests.WithLabelValues(cache, "miss").Inc()
+}
+
+// remove deletes path and its checksum sidecar and returns the bytes freed.
+func remove(path string) (int64, error) {
+	var freed int64
+	for _, p := range []string{path, path + checksumSuffix} {
+		info, err := os.Stat(p)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return freed, err
+		}
+		if err := os.Remove(p); err != nil {
+			return freed, err
+		}
+		freed += info.Size()
+	}
+
+	return freed, nil
+}
+
+func inProgress(path string) bool {
+	for _, s := range inProgressSuffixes {
+		if strings.HasSuffix(path, s) {
+			return true
+		}
+	}
+
+	return false
+}
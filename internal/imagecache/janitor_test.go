@@ -0,0 +1,111 @@
+package imagecache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+func writeFile(t *testing.T, path string, size int, age time.Duration) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, make([]byte, size), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Now().Add(-age)
+	if err := os.Chtimes(path, ts, ts); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestCollectEvictsLeastRecentlyServed(t *testing.T) {
+	dir := t.TempDir()
+	oldest := filepath.Join(dir, "amd64", "old.iso")
+	older := filepath.Join(dir, "talos", "older.raw")
+	recent := filepath.Join(dir, "recent.iso")
+	partial := filepath.Join(dir, "partial.iso.tmp")
+
+	writeFile(t, oldest, 100, 3*time.Hour)
+	writeFile(t, older, 100, 2*time.Hour)
+	writeFile(t, older+checksumSuffix, 10, 2*time.Hour)
+	writeFile(t, recent, 100, time.Hour)
+	writeFile(t, partial, 100, 4*time.Hour)
+
+	// Serving the oldest file moves it to the back of the eviction order.
+	if err := Touch(oldest); err != nil {
+		t.Fatal(err)
+	}
+
+	j := &Janitor{Dirs: []string{dir, filepath.Join(dir, "missing")}, Budget: 250, Log: logr.Discard()}
+	usage, err := j.Collect()
+	if err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+
+	if exists(older) || exists(older+checksumSuffix) {
+		t.Errorf("least recently served file and its checksum were not evicted")
+	}
+	if exists(recent) {
+		t.Errorf("%s was kept although usage was still over budget", recent)
+	}
+	if !exists(oldest) {
+		t.Errorf("recently touched file was evicted")
+	}
+	if !exists(partial) {
+		t.Errorf("in-progress download was evicted")
+	}
+	if usage.Bytes != 200 || usage.EvictedBytes != 210 || len(usage.Evicted) != 2 {
+		t.Errorf("usage = %+v, want 200 bytes left after evicting 210", usage)
+	}
+}
+
+func TestCollectKeepsPinned(t *testing.T) {
+	dir := t.TempDir()
+	kernel := filepath.Join(dir, "amd64", "ironic-python-agent.kernel")
+	iso := filepath.Join(dir, "recent.iso")
+	writeFile(t, kernel, 100, 2*time.Hour)
+	writeFile(t, iso, 100, time.Hour)
+
+	j := &Janitor{
+		Dirs:   []string{dir},
+		Pinned: []string{filepath.Join(dir, "amd64")},
+		Budget: 50,
+		Log:    logr.Discard(),
+	}
+	if _, err := j.Collect(); err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+	if !exists(kernel) {
+		t.Error("pinned file was evicted")
+	}
+	if exists(iso) {
+		t.Error("unpinned file was kept although usage was over budget")
+	}
+}
+
+func TestCollectWithinBudget(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "vmlinuz")
+	writeFile(t, path, 100, time.Hour)
+
+	for _, budget := range []int64{0, 100} {
+		j := &Janitor{Dirs: []string{dir}, Budget: budget, Log: logr.Discard()}
+		usage, err := j.Collect()
+		if err != nil {
+			t.Fatalf("Collect() error = %v", err)
+		}
+		if usage.Bytes != 100 || len(usage.Evicted) != 0 || !exists(path) {
+			t.Errorf("budget %d: usage = %+v, want nothing evicted", budget, usage)
+		}
+	}
+}
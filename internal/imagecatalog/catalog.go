@@ -0,0 +1,274 @@
+// Package imagecatalog keeps the boot images metal-boot knows about, such as
+// kernels, initrds, ISOs and Talos images, under one name each so that the
+// ISO and Talos handlers and the iPXE scripts refer to an image by name
+// instead of repeating its URL in several config fields.
+package imagecatalog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kind is what an image is used for.
+type Kind string
+
+// Image kinds.
+const (
+	KindKernel Kind = "kernel"
+	KindInitrd Kind = "initrd"
+	KindISO    Kind = "iso"
+	KindDisk   Kind = "disk"
+	// KindTalos is a Talos Image Factory that the Talos handler builds
+	// images from.
+	KindTalos Kind = "talos"
+)
+
+var (
+	// ErrNotFound is returned for unknown images.
+	ErrNotFound = errors.New("image not found")
+	// ErrInvalid is returned for images that fail validation.
+	ErrInvalid = errors.New("invalid image")
+	// ErrExists is returned when creating an image whose name is taken.
+	ErrExists = errors.New("image already exists")
+
+	nameRe     = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,62}$`)
+	checksumRe = regexp.MustCompile(`^(sha256:[0-9a-f]{64}|sha512:[0-9a-f]{128})$`)
+)
+
+// Image describes one boot image.
+type Image struct {
+	Name string `json:"name"`
+	Kind Kind   `json:"kind"`
+	// URL is where the image is downloaded from. Exactly one of URL and
+	// OCIRef is set.
+	URL string `json:"url,omitempty"`
+	// OCIRef is an OCI artifact reference such as ghcr.io/org/image:tag.
+	OCIRef string `json:"ociRef,omitempty"`
+	// Checksum is the digest of the image as "sha256:<hex>" or "sha512:<hex>".
+	Checksum string `json:"checksum,omitempty"`
+	// Arch is the architecture the image boots on, "amd64" or "arm64".
+	// Empty means any.
+	Arch      string    `json:"arch,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Validate reports whether the image is complete and well formed.
+func (i Image) Validate() error {
+	if !nameRe.MatchString(i.Name) {
+		return fmt.Errorf("%w: name %q", ErrInvalid, i.Name)
+	}
+	switch i.Kind {
+	case KindKernel, KindInitrd, KindISO, KindDisk, KindTalos:
+	default:
+		return fmt.Errorf("%w: unknown kind %q", ErrInvalid, i.Kind)
+	}
+	if (i.URL == "") == (i.OCIRef == "") {
+		return fmt.Errorf("%w: exactly one of url and ociRef must be set", ErrInvalid)
+	}
+	if i.URL != "" {
+		u, err := url.Parse(i.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%w: url %q is not an http or https URL", ErrInvalid, i.URL)
+		}
+	}
+	if i.OCIRef != "" && strings.ContainsAny(i.OCIRef, " \t\n") {
+		return fmt.Errorf("%w: ociRef %q", ErrInvalid, i.OCIRef)
+	}
+	if i.Checksum != "" && !checksumRe.MatchString(i.Checksum) {
+		return fmt.Errorf("%w: checksum must be sha256:<hex> or sha512:<hex>", ErrInvalid)
+	}
+	switch i.Arch {
+	case "", "amd64", "arm64":
+	default:
+		return fmt.Errorf("%w: unknown arch %q", ErrInvalid, i.Arch)
+	}
+
+	return nil
+}
+
+// Catalog holds the images and persists them to a JSON file.
+type Catalog struct {
+	path string
+
+	mu     sync.RWMutex
+	images map[string]*Image
+}
+
+// New opens the catalog persisted at path. An empty path keeps the catalog
+// in memory only.
+func New(path string) (*Catalog, error) {
+	c := &Catalog{
+		path:   path,
+		images: make(map[string]*Image),
+	}
+	if path == "" {
+		return c, nil
+	}
+
+	b, err := os.ReadFile(filepath.Clean(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image catalog: %w", err)
+	}
+
+	var images []*Image
+	if err := json.Unmarshal(b, &images); err != nil {
+		return nil, fmt.Errorf("failed to parse image catalog: %w", err)
+	}
+	for _, i := range images {
+		c.images[i.Name] = i
+	}
+
+	return c, nil
+}
+
+// List returns every image sorted by name. A nil Catalog is empty.
+func (c *Catalog) List() []Image {
+	if c == nil {
+		return []Image{}
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	out := make([]Image, 0, len(c.images))
+	for _, i := range c.images {
+		out = append(out, *i)
+	}
+	slices.SortFunc(out, func(a, b Image) int { return strings.Compare(a.Name, b.Name) })
+
+	return out
+}
+
+// Get returns the image called name. A nil Catalog has no images.
+func (c *Catalog) Get(name string) (Image, error) {
+	if c == nil {
+		return Image{}, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	i, ok := c.images[name]
+	if !ok {
+		return Image{}, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+
+	return *i, nil
+}
+
+// Resolve returns the URL of the image called name, which must be of kind.
+func (c *Catalog) Resolve(name string, kind Kind) (string, error) {
+	i, err := c.Get(name)
+	if err != nil {
+		return "", err
+	}
+	if i.Kind != kind {
+		return "", fmt.Errorf("%w: %s is a %s image, not %s", ErrInvalid, name, i.Kind, kind)
+	}
+	if i.URL == "" {
+		return "", fmt.Errorf("%w: %s has no url", ErrInvalid, name)
+	}
+
+	return i.URL, nil
+}
+
+// Create adds the image, failing with ErrExists if the name is taken.
+func (c *Catalog) Create(i Image) (Image, error) {
+	i, _, err := c.put(i, true)
+	return i, err
+}
+
+// Put creates or replaces the image and reports whether it was created.
+func (c *Catalog) Put(i Image) (Image, bool, error) {
+	return c.put(i, false)
+}
+
+func (c *Catalog) put(i Image, create bool) (Image, bool, error) {
+	if err := i.Validate(); err != nil {
+		return Image{}, false, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now().UTC()
+	i.CreatedAt, i.UpdatedAt = now, now
+	old, exists := c.images[i.Name]
+	if exists && create {
+		return Image{}, false, fmt.Errorf("%w: %s", ErrExists, i.Name)
+	}
+	if exists {
+		i.CreatedAt = old.CreatedAt
+	}
+	c.images[i.Name] = &i
+	if err := c.save(); err != nil {
+		if exists {
+			c.images[i.Name] = old
+		} else {
+			delete(c.images, i.Name)
+		}
+		return Image{}, false, err
+	}
+
+	return i, !exists, nil
+}
+
+// Delete removes the image called name.
+func (c *Catalog) Delete(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	old, ok := c.images[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	delete(c.images, name)
+	if err := c.save(); err != nil {
+		c.images[name] = old
+		return err
+	}
+
+	return nil
+}
+
+// save writes the images atomically. Callers must hold c.mu.
+func (c *Catalog) save() error {
+	if c.path == "" {
+		return nil
+	}
+
+	images := make([]*Image, 0, len(c.images))
+	for _, i := range c.images {
+		images = append(images, i)
+	}
+	slices.SortFunc(images, func(a, b *Image) int { return strings.Compare(a.Name, b.Name) })
+
+	b, err := json.MarshalIndent(images, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal image catalog: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create image catalog directory: %w", err)
+	}
+
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return fmt.Errorf("failed to write image catalog: %w", err)
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		return fmt.Errorf("failed to replace image catalog: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package imagecatalog
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestCatalog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "images.json")
+	c, err := New(path)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	iso := Image{Name: "ubuntu-iso", Kind: KindISO, URL: "https://example.com/ubuntu.iso"}
+	if _, err := c.Create(iso); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if _, err := c.Create(iso); !errors.Is(err, ErrExists) {
+		t.Errorf("Create() of a taken name error = %v, want ErrExists", err)
+	}
+	oci := Image{Name: "installer", Kind: KindDisk, OCIRef: "ghcr.io/org/installer:v1"}
+	if _, created, err := c.Put(oci); err != nil || !created {
+		t.Fatalf("Put() = %v, %v, want created", created, err)
+	}
+
+	tests := []struct {
+		name    string
+		image   string
+		kind    Kind
+		want    string
+		wantErr error
+	}{
+		{"iso", "ubuntu-iso", KindISO, "https://example.com/ubuntu.iso", nil},
+		{"wrong kind", "ubuntu-iso", KindTalos, "", ErrInvalid},
+		{"oci ref only", "installer", KindDisk, "", ErrInvalid},
+		{"missing", "nope", KindISO, "", ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := c.Resolve(tt.image, tt.kind)
+			if got != tt.want || !errors.Is(err, tt.wantErr) {
+				t.Errorf("Resolve() = %q, %v, want %q, %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+
+	reopened, err := New(path)
+	if err != nil {
+		t.Fatalf("New() of the saved catalog error = %v", err)
+	}
+	if got := reopened.List(); len(got) != 2 || got[0].Name != "installer" || got[1].Name != "ubuntu-iso" {
+		t.Errorf("List() after reopening = %+v", got)
+	}
+	if err := reopened.Delete("installer"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := reopened.Get("installer"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() after Delete() error = %v, want ErrNotFound", err)
+	}
+
+	var nilCatalog *Catalog
+	if got := nilCatalog.List(); len(got) != 0 {
+		t.Errorf("nil List() = %v", got)
+	}
+}
@@ -0,0 +1,153 @@
+// Package integrity serves SHA-256 manifests and detached signatures next to
+// boot artifacts.
+//
+// For every file "<name>" below a served tree, "<name>.sha256" is a
+// sha256sum(1) style manifest and, when a signer is configured, "<name>.sig"
+// is a detached CMS signature that iPXE's imgverify command accepts. Both are
+// computed on demand and cached until the artifact changes; files of the same
+// name that exist on disk take precedence.
+package integrity
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ManifestSuffix is the suffix of SHA-256 manifests.
+	ManifestSuffix = ".sha256"
+	// SignatureSuffix is the suffix of detached CMS signatures.
+	SignatureSuffix = ".sig"
+)
+
+// ErrNotDerived is returned by Derived for names that are not a manifest or
+// signature of an existing file.
+var ErrNotDerived = errors.New("not a manifest or signature of an existing file")
+
+// Manifests computes and caches manifests and signatures.
+type Manifests struct {
+	// Signer signs artifacts and manifests. Signatures are not served when nil.
+	Signer *Signer
+
+	mu    sync.Mutex
+	cache map[string]cached
+}
+
+type cached struct {
+	size    int64
+	modTime time.Time
+	data    []byte
+}
+
+// IsDerived reports whether name could be a manifest or signature.
+func (m *Manifests) IsDerived(name string) bool {
+	if m == nil {
+		return false
+	}
+
+	return strings.HasSuffix(name, ManifestSuffix) ||
+		(m.Signer != nil && strings.HasSuffix(name, SignatureSuffix))
+}
+
+// Derived returns the content of name, which is the manifest or signature of
+// another file below dir. "x.sha256.sig" signs the manifest of "x".
+func (m *Manifests) Derived(dir, name string) ([]byte, error) {
+	if m == nil {
+		return nil, ErrNotDerived
+	}
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+
+	switch {
+	case strings.HasSuffix(name, ManifestSuffix):
+		base := strings.TrimSuffix(name, ManifestSuffix)
+		sum, err := m.Sum(dir, base)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(sum + "  " + path.Base(base) + "\n"), nil
+	case m.Signer != nil && strings.HasSuffix(name, SignatureSuffix):
+		base := strings.TrimSuffix(name, SignatureSuffix)
+		content, err := m.content(dir, base)
+		if err != nil {
+			return nil, err
+		}
+		return m.Signer.Sign(content)
+	}
+
+	return nil, ErrNotDerived
+}
+
+// Sum returns the hex encoded SHA-256 of name below dir.
+func (m *Manifests) Sum(dir, name string) (string, error) {
+	f, info, err := openRegular(dir, name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	key := filepath.Join(dir, name)
+	m.mu.Lock()
+	if c, ok := m.cache[key]; ok && c.size == info.Size() && c.modTime.Equal(info.ModTime()) {
+		m.mu.Unlock()
+		return string(c.data), nil
+	}
+	m.mu.Unlock()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to hash %s: %w", name, err)
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cache == nil {
+		m.cache = make(map[string]cached)
+	}
+	m.cache[key] = cached{size: info.Size(), modTime: info.ModTime(), data: []byte(sum)}
+
+	return sum, nil
+}
+
+// content returns the bytes of name, which may itself be a manifest.
+func (m *Manifests) content(dir, name string) ([]byte, error) {
+	f, _, err := openRegular(dir, name)
+	if errors.Is(err, os.ErrNotExist) && strings.HasSuffix(name, ManifestSuffix) {
+		return m.Derived(dir, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return io.ReadAll(f)
+}
+
+// openRegular opens name below dir, refusing paths that escape dir and
+// anything that is not a regular file.
+func openRegular(dir, name string) (*os.File, os.FileInfo, error) {
+	f, err := os.OpenInRoot(dir, filepath.FromSlash(name))
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if !info.Mode().IsRegular() {
+		f.Close()
+		return nil, nil, fmt.Errorf("%s: %w", name, ErrNotDerived)
+	}
+
+	return f, info, nil
+}
@@ -0,0 +1,169 @@
+package integrity
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestSigner(t *testing.T) *Signer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{CommonName: "metal-boot signing"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate() error = %v", err)
+	}
+	s, err := NewSigner([][]byte{der}, key)
+	if err != nil {
+		t.Fatalf("NewSigner() error = %v", err)
+	}
+
+	return s
+}
+
+func TestManifest(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "images"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(dir, "images", "vmlinuz")
+	if err := os.WriteFile(name, []byte("kernel"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := &Manifests{}
+	sum := sha256.Sum256([]byte("kernel"))
+	want := hex.EncodeToString(sum[:]) + "  vmlinuz\n"
+
+	got, err := m.Derived(dir, "/images/vmlinuz.sha256")
+	if err != nil {
+		t.Fatalf("Derived() error = %v", err)
+	}
+	if string(got) != want {
+		t.Errorf("Derived() = %q, want %q", got, want)
+	}
+
+	// Rewriting the file invalidates the cached sum.
+	if err := os.WriteFile(name, []byte("new kernel"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(name, time.Now().Add(time.Minute), time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	got, err = m.Derived(dir, "images/vmlinuz.sha256")
+	if err != nil {
+		t.Fatalf("Derived() error = %v", err)
+	}
+	if string(got) == want {
+		t.Error("Derived() returned a stale manifest")
+	}
+}
+
+func TestDerivedErrors(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "images"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	m := &Manifests{}
+
+	if _, err := m.Derived(dir, "missing.sha256"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Derived(missing) error = %v, want ErrNotExist", err)
+	}
+	if _, err := m.Derived(dir, "images.sha256"); !errors.Is(err, ErrNotDerived) {
+		t.Errorf("Derived(directory) error = %v, want ErrNotDerived", err)
+	}
+	if _, err := m.Derived(dir, "vmlinuz.sig"); !errors.Is(err, ErrNotDerived) {
+		t.Errorf("Derived(sig without signer) error = %v, want ErrNotDerived", err)
+	}
+	if m.IsDerived("vmlinuz.sig") {
+		t.Error("IsDerived(sig) = true without a signer")
+	}
+
+	var nilManifests *Manifests
+	if nilManifests.IsDerived("vmlinuz.sha256") {
+		t.Error("IsDerived() = true on nil Manifests")
+	}
+}
+
+func TestSignature(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "initrd"), []byte("initrd"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	signer := newTestSigner(t)
+	m := &Manifests{Signer: signer}
+
+	for name, content := range map[string]func() []byte{
+		"initrd.sig": func() []byte { return []byte("initrd") },
+		"initrd.sha256.sig": func() []byte {
+			b, err := m.Derived(dir, "initrd.sha256")
+			if err != nil {
+				t.Fatal(err)
+			}
+			return b
+		},
+	} {
+		der, err := m.Derived(dir, name)
+		if err != nil {
+			t.Fatalf("Derived(%s) error = %v", name, err)
+		}
+		verifySignature(t, der, content(), signer.Certificate())
+	}
+}
+
+// verifySignature parses a detached CMS signature and checks it against
+// content and cert.
+func verifySignature(t *testing.T, der, content []byte, cert *x509.Certificate) {
+	t.Helper()
+
+	var ci contentInfo
+	if rest, err := asn1.Unmarshal(der, &ci); err != nil || len(rest) > 0 {
+		t.Fatalf("failed to parse ContentInfo: %v", err)
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		t.Fatalf("content type = %v, want signedData", ci.ContentType)
+	}
+	var sd signedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		t.Fatalf("failed to parse SignedData: %v", err)
+	}
+	if len(sd.SignerInfos) != 1 {
+		t.Fatalf("got %d signer infos, want 1", len(sd.SignerInfos))
+	}
+	certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+	if err != nil || len(certs) != 1 || !certs[0].Equal(cert) {
+		t.Fatalf("embedded certificates = %v, %v", certs, err)
+	}
+
+	si := sd.SignerInfos[0]
+	if si.SID.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+		t.Errorf("signer serial = %v, want %v", si.SID.SerialNumber, cert.SerialNumber)
+	}
+	digest := sha256.Sum256(content)
+	pub := cert.PublicKey.(*rsa.PublicKey)
+	if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], si.Signature); err != nil {
+		t.Errorf("signature does not verify: %v", err)
+	}
+}
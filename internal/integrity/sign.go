@@ -0,0 +1,156 @@
+package integrity
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidSHA256        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidRSAEncryption = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+)
+
+// ErrUnsupportedKey is returned for signing keys other than RSA, which is the
+// only algorithm iPXE verifies.
+var ErrUnsupportedKey = errors.New("signing key must be RSA")
+
+// Signer produces detached CMS signatures in DER form, as generated by
+// "openssl cms -sign -binary -noattr -outform DER" and verified by iPXE's
+// imgverify command.
+type Signer struct {
+	cert  *x509.Certificate
+	chain [][]byte
+	key   *rsa.PrivateKey
+}
+
+// LoadSigner reads a PEM certificate (optionally followed by intermediates)
+// and its RSA private key.
+func LoadSigner(certFile, keyFile string) (*Signer, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load signing certificate: %w", err)
+	}
+
+	return NewSigner(pair.Certificate, pair.PrivateKey)
+}
+
+// NewSigner returns a Signer for the DER certificate chain, leaf first, and
+// its private key.
+func NewSigner(chain [][]byte, key crypto.PrivateKey) (*Signer, error) {
+	if len(chain) == 0 {
+		return nil, errors.New("signing certificate chain is empty")
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, ErrUnsupportedKey
+	}
+	cert, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing certificate: %w", err)
+	}
+	if !rsaKey.PublicKey.Equal(cert.PublicKey) {
+		return nil, errors.New("signing key does not match certificate")
+	}
+
+	return &Signer{cert: cert, chain: chain, key: rsaKey}, nil
+}
+
+// Certificate returns the signing certificate.
+func (s *Signer) Certificate() *x509.Certificate {
+	return s.cert
+}
+
+type algorithmIdentifier struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters asn1.RawValue `asn1:"optional"`
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+type encapsulatedContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+}
+
+type issuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type signerInfo struct {
+	Version            int
+	SID                issuerAndSerialNumber
+	DigestAlgorithm    algorithmIdentifier
+	SignatureAlgorithm algorithmIdentifier
+	Signature          []byte
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []algorithmIdentifier `asn1:"set"`
+	EncapContentInfo encapsulatedContentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+// Sign returns a detached signature of content.
+func (s *Signer) Sign(content []byte) ([]byte, error) {
+	digest := sha256.Sum256(content)
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign: %w", err)
+	}
+
+	var certs []byte
+	for _, c := range s.chain {
+		certs = append(certs, c...)
+	}
+	null := asn1.RawValue{Tag: asn1.TagNull}
+	sd := signedData{
+		Version:          1,
+		DigestAlgorithms: []algorithmIdentifier{{Algorithm: oidSHA256, Parameters: null}},
+		EncapContentInfo: encapsulatedContentInfo{ContentType: oidData},
+		Certificates: asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        0,
+			IsCompound: true,
+			Bytes:      certs,
+		},
+		SignerInfos: []signerInfo{{
+			Version: 1,
+			SID: issuerAndSerialNumber{
+				Issuer:       asn1.RawValue{FullBytes: s.cert.RawIssuer},
+				SerialNumber: s.cert.SerialNumber,
+			},
+			DigestAlgorithm:    algorithmIdentifier{Algorithm: oidSHA256, Parameters: null},
+			SignatureAlgorithm: algorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: null},
+			Signature:          sig,
+		}},
+	}
+	inner, err := asn1.Marshal(sd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode signed data: %w", err)
+	}
+
+	return asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content: asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        0,
+			IsCompound: true,
+			Bytes:      inner,
+		},
+	})
+}
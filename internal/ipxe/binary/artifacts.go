@@ -0,0 +1,138 @@
+package binary
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ManifestFile is the name of the registry manifest the generator writes next
+// to the binaries it builds.
+const ManifestFile = "manifest.json"
+
+// Architectures and firmware platforms of the build matrix.
+const (
+	ArchAMD64 = "amd64"
+	ArchARM64 = "arm64"
+
+	// PlatformBIOS binaries are chainloaded by a legacy PXE ROM.
+	PlatformBIOS = "bios"
+	// PlatformEFI binaries carry iPXE's own network drivers.
+	PlatformEFI = "efi"
+	// PlatformSNP binaries drive the NIC through the firmware's Simple
+	// Network Protocol.
+	PlatformSNP = "snp"
+)
+
+// registry is the artifact registry directory: the binaries of the build
+// matrix and the manifest describing them.
+//
+//go:embed manifest.json *.efi *.kpxe *.iso
+var registry embed.FS
+
+// Artifact describes a binary of the iPXE build matrix.
+type Artifact struct {
+	// File is the name the binary is served as.
+	File     string `json:"file"`
+	Arch     string `json:"arch"`
+	Platform string `json:"platform"`
+	// SHA256 is the hex digest of the binary as built, before any patching.
+	SHA256 string `json:"sha256"`
+	Size   int    `json:"size"`
+	// GitRev is the iPXE source revision the binary was built from. It is
+	// also part of the version string iPXE prints at startup.
+	GitRev string `json:"gitRev,omitempty"`
+	// BuildOptions are the make arguments of the build.
+	BuildOptions []string `json:"buildOptions,omitempty"`
+	// Config are the headers copied into config/local for the build.
+	Config []string `json:"config,omitempty"`
+	// BuiltAt is when the generator built the binary, if it was recorded.
+	BuiltAt *time.Time `json:"builtAt,omitempty"`
+}
+
+// Artifacts returns the embedded binaries, ordered by file name.
+func Artifacts() []Artifact {
+	return slices.Clone(artifacts)
+}
+
+// Lookup returns the artifact served as file.
+func Lookup(file string) (Artifact, bool) {
+	i, found := slices.BinarySearchFunc(artifacts, file, func(a Artifact, file string) int {
+		return strings.Compare(a.File, file)
+	})
+	if !found {
+		return Artifact{}, false
+	}
+
+	return artifacts[i], true
+}
+
+// platformPreference orders the platforms Select picks from when it is not
+// given one: iPXE's own drivers first, then the firmware's.
+var platformPreference = []string{PlatformEFI, PlatformSNP, PlatformBIOS}
+
+// Select returns the network boot binary of arch and platform, or of arch on
+// its preferred platform when platform is empty. Disk images such as
+// ipxe.iso are never selected.
+func Select(arch, platform string) (Artifact, bool) {
+	platforms := platformPreference
+	if platform != "" {
+		platforms = []string{platform}
+	}
+	for _, p := range platforms {
+		for _, a := range artifacts {
+			if a.Arch == arch && a.Platform == p && !strings.HasSuffix(a.File, ".iso") {
+				return a, true
+			}
+		}
+	}
+
+	return Artifact{}, false
+}
+
+// Digest returns the hex SHA-256 digest recorded for content.
+func Digest(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// loadRegistry reads the manifest and the binaries it lists. Digests and
+// sizes are taken from the embedded binaries themselves, so they hold even
+// when a binary was replaced without regenerating the manifest. Entries
+// whose binary is not embedded, such as ipxe.lkrn, are skipped.
+func loadRegistry() (map[string][]byte, []Artifact) {
+	b, err := registry.ReadFile(ManifestFile)
+	if err != nil {
+		panic(err)
+	}
+	var manifest []Artifact
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		panic("invalid iPXE artifact manifest: " + err.Error())
+	}
+
+	files := make(map[string][]byte, len(manifest))
+	var list []Artifact
+	for _, a := range manifest {
+		content, err := registry.ReadFile(a.File)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			panic(err)
+		}
+		a.SHA256, a.Size = Digest(content), len(content)
+		files[a.File] = content
+		list = append(list, a)
+	}
+	slices.SortFunc(list, func(a, b Artifact) int {
+		return strings.Compare(a.File, b.File)
+	})
+
+	return files, list
+}
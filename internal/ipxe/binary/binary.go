@@ -1,32 +1,22 @@
 // Package binary handles embedding of the iPXE binaries.
 package binary
 
-// embed lib does the work of embedding the on disk iPXE binaries.
 import (
 	"bytes"
-	_ "embed"
 	"errors"
 )
 
 // IpxeEFI is the UEFI iPXE binary for x86 architectures.
-//
-//go:embed ipxe.efi
-var IpxeEFI []byte
+var IpxeEFI = Files["ipxe.efi"]
 
 // Undionly is the BIOS iPXE binary for x86 architectures.
-//
-//go:embed undionly.kpxe
-var Undionly []byte
+var Undionly = Files["undionly.kpxe"]
 
 // SNP is the UEFI iPXE binary for ARM architectures.
-//
-//go:embed snp.efi
-var SNP []byte
+var SNP = Files["snp.efi"]
 
 // IpxeISO is the iPXE ISO image.
-//
-//go:embed ipxe.iso
-var IpxeISO []byte
+var IpxeISO = Files["ipxe.iso"]
 
 // MagicString is included in each iPXE binary within the embedded script. It
 // can be overwritten to change the behavior at startup.
@@ -35,13 +25,9 @@ var magicString = []byte(`#a8b7e61f1075c37a793f2f92cee89f7bba00c4a8d7842ce3d40b5
 
 var magicStringPadding = bytes.Repeat([]byte{' '}, len(magicString))
 
-// Files is the mapping to the embedded iPXE binaries.
-var Files = map[string][]byte{
-	"undionly.kpxe": Undionly,
-	"ipxe.efi":      IpxeEFI,
-	"snp.efi":       SNP,
-	"ipxe.iso":      IpxeISO,
-}
+// Files is the mapping to the embedded iPXE binaries, every artifact of the
+// registry manifest.
+var Files, artifacts = loadRegistry()
 
 var ErrPatchTooLong = errors.New("patch string is too long")
 
@@ -2,6 +2,9 @@ package binary
 
 import (
 	"bytes"
+	"errors"
+	"net"
+	"strings"
 	"testing"
 )
 
@@ -72,3 +75,92 @@ func TestPatch(t *testing.T) {
 		})
 	}
 }
+
+func TestArtifacts(t *testing.T) {
+	list := Artifacts()
+	if len(list) != len(Files) {
+		t.Fatalf("Artifacts() = %d artifacts, want one per file (%d)", len(list), len(Files))
+	}
+	for _, a := range list {
+		if a.SHA256 != Digest(Files[a.File]) || a.Size != len(Files[a.File]) {
+			t.Errorf("artifact %s = %+v, does not match its binary", a.File, a)
+		}
+		if a.Arch == "" || a.Platform == "" {
+			t.Errorf("artifact %s has no arch or platform", a.File)
+		}
+		if got, ok := Lookup(a.File); !ok || got.SHA256 != a.SHA256 {
+			t.Errorf("Lookup(%q) = %+v, %v", a.File, got, ok)
+		}
+	}
+	if _, ok := Lookup("missing.efi"); ok {
+		t.Error("Lookup of an unknown file succeeded")
+	}
+}
+
+func TestSelect(t *testing.T) {
+	for _, tt := range []struct{ arch, platform, want string }{
+		{ArchAMD64, "", "ipxe.efi"},
+		{ArchAMD64, PlatformBIOS, "undionly.kpxe"},
+		{ArchARM64, "", "snp.efi"},
+		{ArchARM64, PlatformBIOS, ""},
+	} {
+		a, ok := Select(tt.arch, tt.platform)
+		if a.File != tt.want || ok != (tt.want != "") {
+			t.Errorf("Select(%q, %q) = %q, %v, want %q", tt.arch, tt.platform, a.File, ok, tt.want)
+		}
+	}
+}
+
+func TestPatcher(t *testing.T) {
+	p := &Patcher{
+		Default: Patches{Trust: "set trust aa", Script: "chain default"},
+		Hosts: []HostPatches{
+			{MACs: []string{"d8:3a:dd:*"}, Patches: Patches{Crypto: "set crosscert x"}},
+			{MACs: []string{"D8:3A:DD:00:00:01"}, Patches: Patches{Script: "chain host"}},
+		},
+	}
+	if err := p.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	rpi := net.HardwareAddr{0xd8, 0x3a, 0xdd, 0, 0, 1}
+	other := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	tests := []struct {
+		name   string
+		mac    net.HardwareAddr
+		script string
+		want   string
+	}{
+		{name: "default", mac: other, want: "set trust aa\nchain default"},
+		{name: "no mac", want: "set trust aa\nchain default"},
+		{name: "backend script", mac: other, script: "chain backend", want: "set trust aa\nchain backend"},
+		{name: "host slots", mac: rpi, want: "set trust aa\nset crosscert x\nchain host"},
+		{
+			name:   "host script wins",
+			mac:    rpi,
+			script: "chain backend",
+			want:   "set trust aa\nset crosscert x\nchain host",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(p.WithScript(tt.mac, tt.script).Bytes()); got != tt.want {
+				t.Errorf("WithScript() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if got := (*Patcher)(nil).For(rpi); got != (Patches{}) {
+		t.Errorf("nil For() = %+v, want none", got)
+	}
+
+	long := &Patcher{Hosts: []HostPatches{
+		{MACs: []string{"*"}, Patches: Patches{Script: strings.Repeat("x", MaxPatchLen+1)}},
+	}}
+	if err := long.Validate(); !errors.Is(err, ErrPatchTooLong) {
+		t.Errorf("Validate() error = %v, want %v", err, ErrPatchTooLong)
+	}
+	if err := (&Patcher{Hosts: []HostPatches{{MACs: []string{"["}}}}).Validate(); err == nil {
+		t.Error("Validate() accepted an invalid pattern")
+	}
+}
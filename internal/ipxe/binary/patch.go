@@ -0,0 +1,145 @@
+package binary
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"strings"
+)
+
+// MaxPatchLen is the size of the patch area of the embedded script, which
+// all slots of a Patches share.
+var MaxPatchLen = len(magicString)
+
+// Patches are the contents of the named slots of the patch area of the
+// embedded script, as iPXE script lines. Empty slots are left out.
+type Patches struct {
+	// Trust sets up the certificates iPXE trusts, such as
+	// "set trust <sha256 fingerprint>".
+	Trust string
+	// Crypto holds the other cryptography settings, such as
+	// "set crosscert http://ca.example.com/".
+	Crypto string
+	// Script is run after the other slots.
+	Script string
+}
+
+// Bytes returns the patch of p: its slots in the order trust, crypto and
+// script, one per line, so that the certificates are in place before the
+// script fetches anything over HTTPS.
+func (p Patches) Bytes() []byte {
+	var lines []string
+	for _, slot := range []string{p.Trust, p.Crypto, p.Script} {
+		if slot != "" {
+			lines = append(lines, slot)
+		}
+	}
+
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// Override returns p with the slots that o sets replaced.
+func (p Patches) Override(o Patches) Patches {
+	if o.Trust != "" {
+		p.Trust = o.Trust
+	}
+	if o.Crypto != "" {
+		p.Crypto = o.Crypto
+	}
+	if o.Script != "" {
+		p.Script = o.Script
+	}
+
+	return p
+}
+
+// Apply patches content with p.
+func (p Patches) Apply(content []byte) ([]byte, error) {
+	return Patch(content, p.Bytes())
+}
+
+// HostPatches override slots of the default patches for some hosts.
+type HostPatches struct {
+	// MACs are path.Match patterns of the lowercase, colon separated MAC
+	// addresses of the hosts, such as "d8:3a:dd:*".
+	MACs []string
+	Patches
+}
+
+// Patcher selects the patches of the iPXE binaries each host gets. All
+// methods of a nil Patcher return no patches.
+type Patcher struct {
+	// Default are the patches of every host.
+	Default Patches
+	// Hosts override slots of Default. The slots of later entries win.
+	Hosts []HostPatches
+}
+
+// For returns the patches of mac. A nil mac gets the default patches.
+func (p *Patcher) For(mac net.HardwareAddr) Patches {
+	if p == nil {
+		return Patches{}
+	}
+
+	return p.Default.Override(p.Host(mac))
+}
+
+// WithScript returns the patches of mac with script, the host's script from
+// a backend, in place of the default script slot. A script set in the Hosts
+// entries matching mac still wins. An empty script is ignored.
+func (p *Patcher) WithScript(mac net.HardwareAddr, script string) Patches {
+	if p == nil {
+		return Patches{Script: script}
+	}
+	patches := p.Default
+	if script != "" {
+		patches.Script = script
+	}
+
+	return patches.Override(p.Host(mac))
+}
+
+// Host returns only the slots that the Hosts entries matching mac set.
+func (p *Patcher) Host(mac net.HardwareAddr) Patches {
+	var patches Patches
+	if p == nil || mac == nil {
+		return patches
+	}
+	key := strings.ToLower(mac.String())
+	for _, h := range p.Hosts {
+		for _, pattern := range h.MACs {
+			if ok, _ := path.Match(strings.ToLower(pattern), key); ok {
+				patches = patches.Override(h.Patches)
+				break
+			}
+		}
+	}
+
+	return patches
+}
+
+// Validate checks the MAC patterns and that the patches of every entry fit
+// the patch area.
+func (p *Patcher) Validate() error {
+	if p == nil {
+		return nil
+	}
+	if n := len(p.Default.Bytes()); n > MaxPatchLen {
+		return fmt.Errorf("default iPXE patches: %w: %d bytes, at most %d", ErrPatchTooLong, n, MaxPatchLen)
+	}
+	for i, h := range p.Hosts {
+		if len(h.MACs) == 0 {
+			return fmt.Errorf("iPXE patches %d: no MAC addresses", i)
+		}
+		for _, pattern := range h.MACs {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("iPXE patches %d: invalid MAC pattern %q: %w", i, pattern, err)
+			}
+		}
+		if n := len(p.Default.Override(h.Patches).Bytes()); n > MaxPatchLen {
+			return fmt.Errorf("iPXE patches %d: %w: %d bytes, at most %d", i, ErrPatchTooLong, n, MaxPatchLen)
+		}
+	}
+
+	return nil
+}
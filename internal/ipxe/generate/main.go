@@ -2,15 +2,105 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/metal3-community/metal-boot/internal/ipxe/binary"
 )
 
+// image is the tag of the iPXE build environment.
+const image = "metal-boot-ipxe"
+
+// common are the customizations every target is built with.
+var common = []string{"colour.h", "common.h", "console.h", "crypto.h"}
+
+// target is one binary of the build matrix.
+type target struct {
+	file     string
+	arch     string
+	platform string
+	// cross is the toolchain prefix passed to make as CROSS.
+	cross string
+	// make is the make target, the path iPXE writes the binary to.
+	make string
+	// config are the customizations copied into config/local on top of
+	// common. A variant suffix, as in general.efi.h, is dropped on copy.
+	config []string
+}
+
+// matrix is every binary the generator builds into the artifact registry.
+var matrix = []target{
+	{
+		file: "undionly.kpxe", arch: binary.ArchAMD64, platform: binary.PlatformBIOS,
+		cross: "x86_64-linux-gnu-", make: "bin/undionly.kpxe",
+		config: []string{"general.undionly.h"},
+	},
+	{
+		file: "ipxe.lkrn", arch: binary.ArchAMD64, platform: binary.PlatformBIOS,
+		cross: "x86_64-linux-gnu-", make: "bin/ipxe.lkrn",
+		config: []string{"general.undionly.h"},
+	},
+	{
+		file: "ipxe.efi", arch: binary.ArchAMD64, platform: binary.PlatformEFI,
+		cross: "x86_64-linux-gnu-", make: "bin-x86_64-efi/ipxe.efi",
+		config: []string{"general.efi.h", "isa.h"},
+	},
+	{
+		file: "ipxe.iso", arch: binary.ArchAMD64, platform: binary.PlatformEFI,
+		cross: "x86_64-linux-gnu-", make: "bin-x86_64-efi/ipxe.iso",
+		config: []string{"general.efi.h", "isa.h"},
+	},
+	{
+		file: "snp-x86_64.efi", arch: binary.ArchAMD64, platform: binary.PlatformSNP,
+		cross: "x86_64-linux-gnu-", make: "bin-x86_64-efi/snp.efi",
+		config: []string{"general.efi.h", "isa.h"},
+	},
+	{
+		file: "ipxe-arm64.efi", arch: binary.ArchARM64, platform: binary.PlatformEFI,
+		cross: "aarch64-linux-gnu-", make: "bin-arm64-efi/ipxe.efi",
+		config: []string{"general.efi.h", "nap.h"},
+	},
+	{
+		file: "snp.efi", arch: binary.ArchARM64, platform: binary.PlatformSNP,
+		cross: "aarch64-linux-gnu-", make: "bin-arm64-efi/snp.efi",
+		config: []string{"general.efi.h", "nap.h"},
+	},
+}
+
+// buildOptions are the make arguments of t.
+func (t target) buildOptions() []string {
+	return []string{"EMBED=embed.ipxe", "CROSS=" + t.cross, t.make}
+}
+
+// script builds t inside the build environment.
+func (t target) script() string {
+	cmds := []string{"rm -f config/local/*.h"}
+	for _, h := range append(append([]string{}, common...), t.config...) {
+		dst := strings.SplitN(h, ".", 2)[0] + ".h"
+		cmds = append(cmds, fmt.Sprintf("cp /build/custom/%s config/local/%s", h, dst))
+	}
+	cmds = append(cmds,
+		"make -j4 "+strings.Join(t.buildOptions(), " "),
+		fmt.Sprintf("cp %s /output/%s", t.make, t.file),
+	)
+
+	return strings.Join(cmds, " && ")
+}
+
+func run(cmd *exec.Cmd) error {
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
 func main() {
-	fmt.Println("Building iPXE for ARM64 EFI...")
+	fmt.Println("Building the iPXE build matrix...")
 
 	// Get the current working directory
 	wd, err := os.Getwd()
@@ -19,78 +109,110 @@ func main() {
 	}
 	wd = filepath.Join(wd, "..", "..")
 
+	ref := os.Getenv("IPXE_REF")
+	if ref == "" {
+		ref = "master"
+	}
+
 	// Build the Docker image
 	fmt.Println("Building Docker image...")
 	dockerfilePath := filepath.Join(wd, "internal", "ipxe", "generate", "Dockerfile")
 
-	buildCmd := exec.Command(
+	ctx := context.Background()
+	buildCmd := exec.CommandContext(
+		ctx,
 		"docker",
 		"build",
 		"--platform",
 		"linux/arm64",
+		"--build-arg",
+		"IPXE_REF="+ref,
 		"-f",
 		dockerfilePath,
 		"-t",
-		"metal-boot-ipxe",
+		image,
 		"../../",
 	)
-	buildCmd.Stdout = os.Stdout
-	buildCmd.Stderr = os.Stderr
-
-	if err := buildCmd.Run(); err != nil {
+	if err := run(buildCmd); err != nil {
 		log.Fatalf("Failed to build Docker image: %v", err)
 	}
 
-	// Create output directory
+	revCmd := exec.CommandContext(ctx, "docker", "run", "--rm", image,
+		"-c", "git -C /build/ipxe rev-parse HEAD")
+	revBytes, err := revCmd.Output()
+	if err != nil {
+		log.Fatalf("Failed to read iPXE revision: %v", err)
+	}
+	rev := strings.TrimSpace(string(revBytes))
+	fmt.Printf("Building iPXE revision %s\n", rev)
+
+	// Create output directory, the artifact registry
 	outputDir := filepath.Join(wd, "internal", "ipxe", "binary")
 	if err := os.MkdirAll(outputDir, 0o755); err != nil {
 		log.Fatalf("Failed to create output directory: %v", err)
 	}
 
-	// Extract the iPXE EFI file
-	fmt.Println("Extracting iPXE EFI file...")
+	manifest := make([]binary.Artifact, 0, len(matrix))
+	for _, t := range matrix {
+		fmt.Printf("Building %s (%s/%s)...\n", t.file, t.arch, t.platform)
+		manifest = append(manifest, build(ctx, t, rev, outputDir))
+	}
 
-	// Create a temporary container
-	ctx := context.Background()
-	createCmd := exec.CommandContext(ctx, "docker", "create", "metal-boot-ipxe")
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		log.Fatalf("Failed to encode manifest: %v", err)
+	}
+	manifestFile := filepath.Join(outputDir, binary.ManifestFile)
+	if err := os.WriteFile(manifestFile, append(b, '\n'), 0o644); err != nil {
+		log.Fatalf("Failed to write manifest: %v", err)
+	}
+	fmt.Printf("iPXE build complete. Manifest available at: %s\n", manifestFile)
+}
+
+// build builds t in a container of its own and copies the binary into
+// outputDir, returning its registry entry.
+func build(ctx context.Context, t target, rev, outputDir string) binary.Artifact {
+	createCmd := exec.CommandContext(ctx, "docker", "create", image, "-c", t.script())
 	containerIDBytes, err := createCmd.Output()
 	if err != nil {
 		log.Fatalf("Failed to create container: %v", err)
 	}
-	containerID := string(containerIDBytes)[:12] // Take first 12 chars of container ID
+	containerID := strings.TrimSpace(string(containerIDBytes))
 	// Clean up container
 	defer exec.CommandContext(ctx, "docker", "rm", containerID).Run()
 
-	// Copy file from container
-	outputFiles := []string{
-		"undionly.kpxe",
-		"ipxe.lkrn",
-		"snp.efi",
-		"ipxe.efi",
-		"ipxe.iso",
+	if err := run(exec.CommandContext(ctx, "docker", "start", "-a", containerID)); err != nil {
+		log.Fatalf("Failed to build %s: %v", t.file, err)
+	}
+
+	outputFile := filepath.Join(outputDir, t.file)
+	copyCmd := exec.CommandContext(
+		ctx,
+		"docker",
+		"cp",
+		containerID+":/output/"+t.file,
+		outputFile,
+	)
+	if err := copyCmd.Run(); err != nil {
+		log.Fatalf("Failed to copy %s: %v", t.file, err)
+	}
+
+	content, err := os.ReadFile(outputFile)
+	if err != nil {
+		log.Fatalf("Failed to read output file: %v", err)
 	}
+	fmt.Printf("Successfully copied %s (%d bytes)\n", t.file, len(content))
 
-	for _, file := range outputFiles {
-		outputFile := filepath.Join(outputDir, file)
-		copyCmd := exec.CommandContext(
-			ctx,
-			"docker",
-			"cp",
-			containerID+":/output/"+file,
-			outputFile,
-		)
-		if err := copyCmd.Run(); err != nil {
-			// Clean up container
-			log.Fatalf("Failed to copy iPXE EFI file: %v", err)
-			break
-		}
-
-		// Check file size
-		fileInfo, err := os.Stat(outputFile)
-		if err != nil {
-			log.Fatalf("Failed to stat output file: %v", err)
-		}
-		fmt.Printf("Successfully copied %s (%d bytes)\n", file, fileInfo.Size())
-		fmt.Printf("iPXE build complete. EFI file available at: %s\n", outputFile)
+	builtAt := time.Now().UTC()
+	return binary.Artifact{
+		File:         t.file,
+		Arch:         t.arch,
+		Platform:     t.platform,
+		SHA256:       binary.Digest(content),
+		Size:         len(content),
+		GitRev:       rev,
+		BuildOptions: t.buildOptions(),
+		Config:       append(append([]string{}, common...), t.config...),
+		BuiltAt:      &builtAt,
 	}
 }
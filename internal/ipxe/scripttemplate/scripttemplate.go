@@ -0,0 +1,163 @@
+// Package scripttemplate loads user-defined iPXE script templates from a
+// directory and reloads them when the files change.
+//
+// Every file named <name>.tmpl in the directory is a text/template called
+// <name>, so that templates can include each other with {{template "name"}}.
+// A reload that fails to parse keeps the previous templates, so that a
+// half-written file never breaks booting.
+package scripttemplate
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-logr/logr"
+	"github.com/metal3-community/metal-boot/internal/filewatch"
+)
+
+// Suffix is the file name suffix of templates.
+const Suffix = ".tmpl"
+
+// funcs are the functions templates may call besides the text/template
+// builtins.
+var funcs = template.FuncMap{
+	"join":       strings.Join,
+	"hasPrefix":  strings.HasPrefix,
+	"trimSuffix": strings.TrimSuffix,
+}
+
+// Store holds the templates of a directory.
+type Store struct {
+	dir     string
+	log     logr.Logger
+	watcher *filewatch.Watcher
+
+	mu   sync.RWMutex
+	tmpl *template.Template
+}
+
+// New loads the templates of dir and watches it for changes, polling the
+// files when watch asks for it or fsnotify cannot watch them.
+func New(log logr.Logger, dir string, watch filewatch.Options) (*Store, error) {
+	s := &Store{
+		dir:     dir,
+		log:     log,
+		watcher: filewatch.New(log, watch),
+	}
+	if err := s.watcher.Add(dir); err != nil {
+		s.watcher.Close()
+		return nil, fmt.Errorf("failed to watch template directory: %w", err)
+	}
+	if err := s.Load(); err != nil {
+		s.watcher.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Load parses every template of the directory, replacing the loaded
+// templates only if all of them parse.
+func (s *Store) Load() error {
+	files, err := filepath.Glob(filepath.Join(s.dir, "*"+Suffix))
+	if err != nil {
+		return err
+	}
+	slices.Sort(files)
+
+	tmpl := template.New("").Funcs(funcs).Option("missingkey=zero")
+	for _, file := range files {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read template: %w", err)
+		}
+		name := strings.TrimSuffix(filepath.Base(file), Suffix)
+		if _, err := tmpl.New(name).Parse(string(b)); err != nil {
+			return fmt.Errorf("failed to parse template %s: %w", filepath.Base(file), err)
+		}
+		// Files written in place are only reported when watched one by one.
+		if err := s.watcher.Add(file); err != nil {
+			s.log.Error(err, "failed to watch template", "file", file)
+		}
+	}
+
+	s.mu.Lock()
+	s.tmpl = tmpl
+	s.mu.Unlock()
+	s.log.V(1).Info("loaded iPXE templates", "dir", s.dir, "count", len(files))
+
+	return nil
+}
+
+// Lookup returns the first of names that is a loaded template.
+func (s *Store) Lookup(names ...string) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, name := range names {
+		if name != "" && s.tmpl.Lookup(name) != nil {
+			return name, true
+		}
+	}
+
+	return "", false
+}
+
+// Execute applies the template called name to data.
+func (s *Store) Execute(name string, data any) ([]byte, error) {
+	s.mu.RLock()
+	tmpl := s.tmpl
+	s.mu.RUnlock()
+
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, name, data); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Start reloads the templates whenever the directory or a template changes.
+// Start is a blocking method. Use a context cancellation to exit.
+func (s *Store) Start(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			s.log.Info("stopping iPXE template watcher")
+			return
+		case event, ok := <-s.watcher.Events:
+			if !ok {
+				continue
+			}
+			if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) &&
+				!event.Has(fsnotify.Remove) && !event.Has(fsnotify.Rename) {
+				continue
+			}
+			s.log.Info("iPXE templates changed, reloading", "file", event.Name)
+			if err := s.Load(); err != nil {
+				s.log.Error(err, "failed to reload iPXE templates, keeping the previous ones")
+			}
+		case err, ok := <-s.watcher.Errors:
+			if !ok {
+				continue
+			}
+			s.log.Error(err, "error watching iPXE templates", "dir", s.dir)
+		}
+	}
+}
+
+// Close stops watching the templates.
+func (s *Store) Close() error {
+	return s.watcher.Close()
+}
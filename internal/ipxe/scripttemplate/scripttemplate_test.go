@@ -0,0 +1,73 @@
+package scripttemplate
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/metal3-community/metal-boot/internal/filewatch"
+)
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("default.tmpl", "#!ipxe\n{{template \"kernel\" .}}\nboot\n")
+	write("kernel.tmpl", "kernel {{.URL}} {{join .Args \" \"}}")
+	write("notes.txt", "{{ not a template")
+
+	watch := filewatch.Options{Poll: true, Interval: 10 * time.Millisecond}
+	s, err := New(logr.Discard(), dir, watch)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer s.Close()
+
+	if name, ok := s.Lookup("d8-3a-dd-01-02-03", "", "default"); !ok || name != "default" {
+		t.Errorf("Lookup() = %q, %v, want default", name, ok)
+	}
+	data := map[string]any{"URL": "http://x/vmlinuz", "Args": []string{"a=1", "b"}}
+	got, err := s.Execute("default", data)
+	if want := "#!ipxe\nkernel http://x/vmlinuz a=1 b\nboot\n"; err != nil || string(got) != want {
+		t.Errorf("Execute() = %q, %v, want %q", got, err, want)
+	}
+
+	// A template that does not parse leaves the loaded templates alone.
+	write("broken.tmpl", "{{ if }}")
+	if err := s.Load(); err == nil {
+		t.Error("Load() of a broken template succeeded")
+	}
+	if _, ok := s.Lookup("default"); !ok {
+		t.Error("Load() of a broken template dropped the loaded templates")
+	}
+	if err := os.Remove(filepath.Join(dir, "broken.tmpl")); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Start(ctx)
+	write("d8-3a-dd-01-02-03.tmpl", "#!ipxe\nexit\n")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if name, _ := s.Lookup("d8-3a-dd-01-02-03", "default"); name == "d8-3a-dd-01-02-03" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("new template was not loaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var nilStore *Store
+	if _, ok := nilStore.Lookup("default"); ok {
+		t.Error("nil Lookup() found a template")
+	}
+}
@@ -0,0 +1,179 @@
+package ironic
+
+import (
+	"bytes"
+	"context"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/metal3-community/metal-boot/internal/metric"
+	"golang.org/x/sync/singleflight"
+)
+
+// DefaultCachedPaths are the read endpoints clients poll the most: the driver
+// list and the node lists.
+var DefaultCachedPaths = []string{"/v1/drivers", "/v1/nodes", "/v1/nodes/detail"}
+
+// varyHeaders are the request headers an Ironic response depends on. They
+// are part of the cache key, so that clients with other credentials or API
+// versions never share a response.
+var varyHeaders = []string{
+	"Accept",
+	"Authorization",
+	"OpenStack-API-Version",
+	"X-Auth-Token",
+	"X-OpenStack-Ironic-API-Version",
+}
+
+// Cache is an http.Handler in front of the Ironic proxy that answers
+// identical concurrent GET requests of Paths with one upstream request and
+// keeps their successful responses for TTL. GET requests of other paths go
+// straight to Ironic, as they may not be safe to share. Any other request,
+// such as a node update, empties the cache.
+type Cache struct {
+	next  http.Handler
+	ttl   time.Duration
+	paths []string
+	group singleflight.Group
+
+	mu      sync.Mutex
+	entries map[string]*response
+	// generation counts the writes seen, so that a response fetched before
+	// a write is not cached after it.
+	generation uint64
+}
+
+// response is a buffered upstream response.
+type response struct {
+	status  int
+	header  http.Header
+	body    bytes.Buffer
+	expires time.Time
+}
+
+func (r *response) Header() http.Header { return r.header }
+
+func (r *response) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(b)
+}
+
+func (r *response) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+// writeTo copies the response to w.
+func (r *response) writeTo(w http.ResponseWriter) {
+	for k, v := range r.header {
+		w.Header()[k] = slices.Clone(v)
+	}
+	w.WriteHeader(r.status)
+	w.Write(r.body.Bytes())
+}
+
+// NewCache returns a Cache in front of next. A ttl of 0 only coalesces
+// requests of paths.
+func NewCache(next http.Handler, ttl time.Duration, paths []string) *Cache {
+	return &Cache{
+		next:    next,
+		ttl:     ttl,
+		paths:   paths,
+		entries: map[string]*response{},
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (c *Cache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		c.invalidate()
+		c.next.ServeHTTP(w, r)
+		return
+	}
+
+	if !slices.Contains(c.paths, r.URL.Path) {
+		metric.IronicProxyRequests.WithLabelValues("miss").Inc()
+		c.next.ServeHTTP(w, r)
+		return
+	}
+
+	key := cacheKey(r)
+	cacheable := c.ttl > 0
+	c.mu.Lock()
+	cached, ok := c.entries[key]
+	if ok && time.Now().After(cached.expires) {
+		delete(c.entries, key)
+		ok = false
+	}
+	generation := c.generation
+	c.mu.Unlock()
+	if cacheable && ok {
+		metric.IronicProxyRequests.WithLabelValues("hit").Inc()
+		cached.writeTo(w)
+		return
+	}
+
+	v, _, shared := c.group.Do(key, func() (any, error) {
+		// The request is answered for every caller, so the first one
+		// going away must not cancel it.
+		resp := &response{header: http.Header{}}
+		c.next.ServeHTTP(resp, r.WithContext(context.WithoutCancel(r.Context())))
+		if resp.status == 0 {
+			resp.status = http.StatusOK
+		}
+		if cacheable && resp.status == http.StatusOK {
+			c.store(key, generation, resp)
+		}
+		return resp, nil
+	})
+	if shared {
+		metric.IronicProxyRequests.WithLabelValues("coalesced").Inc()
+	} else {
+		metric.IronicProxyRequests.WithLabelValues("miss").Inc()
+	}
+	v.(*response).writeTo(w)
+}
+
+// store caches resp under key unless a write happened since generation.
+// Expired entries are dropped first: keys include credentials, so entries
+// that are never asked for again would otherwise pile up.
+func (c *Cache) store(key string, generation uint64, resp *response) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.generation != generation {
+		return
+	}
+	now := time.Now()
+	maps.DeleteFunc(c.entries, func(_ string, cached *response) bool {
+		return now.After(cached.expires)
+	})
+	resp.expires = now.Add(c.ttl)
+	c.entries[key] = resp
+}
+
+func (c *Cache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	clear(c.entries)
+}
+
+// cacheKey identifies the requests that get the same answer.
+func cacheKey(r *http.Request) string {
+	var b strings.Builder
+	b.WriteString(r.Method + " " + r.URL.RequestURI())
+	for _, h := range varyHeaders {
+		b.WriteString("\n" + h + ": " + strings.Join(r.Header.Values(h), ","))
+	}
+
+	return b.String()
+}
@@ -0,0 +1,110 @@
+package ironic
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	var calls atomic.Int32
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"path": "` + r.URL.Path + `"}`))
+	})
+	c := NewCache(upstream, time.Minute, DefaultCachedPaths)
+	do := func(method, path, token string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("X-Auth-Token", token)
+		rec := httptest.NewRecorder()
+		c.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for range 3 {
+		rec := do(http.MethodGet, "/v1/nodes", "a")
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "/v1/nodes") ||
+			rec.Header().Get("Content-Type") != "application/json" {
+			t.Fatalf("GET /v1/nodes = %d %v %s", rec.Code, rec.Header(), rec.Body)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("Ironic got %d requests for a cached path, want 1", n)
+	}
+
+	// Other credentials and uncached paths go to Ironic.
+	do(http.MethodGet, "/v1/nodes", "b")
+	do(http.MethodGet, "/v1/nodes/node-1", "a")
+	do(http.MethodGet, "/v1/nodes/node-1", "a")
+	if n := calls.Load(); n != 4 {
+		t.Errorf("Ironic got %d requests, want 4", n)
+	}
+
+	// A write empties the cache.
+	do(http.MethodPatch, "/v1/nodes/node-1", "a")
+	do(http.MethodGet, "/v1/nodes", "a")
+	if n := calls.Load(); n != 6 {
+		t.Errorf("Ironic got %d requests after a write, want 6", n)
+	}
+}
+
+func TestCacheCoalesces(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		w.WriteHeader(http.StatusAccepted)
+	})
+	c := NewCache(upstream, 0, DefaultCachedPaths)
+
+	var wg sync.WaitGroup
+	codes := make(chan int, 10)
+	for _, path := range []string{"/v1/nodes", "/v1/nodes/node-1/states"} {
+		for range 5 {
+			wg.Go(func() {
+				rec := httptest.NewRecorder()
+				c.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+				codes <- rec.Code
+			})
+		}
+	}
+	// Give the requests time to join the first one.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(codes)
+
+	for code := range codes {
+		if code != http.StatusAccepted {
+			t.Errorf("coalesced request = %d, want %d", code, http.StatusAccepted)
+		}
+	}
+	// The requests of the listed path share one, the others go to Ironic.
+	if n := calls.Load(); n != 6 {
+		t.Errorf("Ironic got %d requests for 5 concurrent identical ones of two paths, want 6", n)
+	}
+}
+
+func TestCacheSweepsExpired(t *testing.T) {
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	c := NewCache(upstream, time.Millisecond, DefaultCachedPaths)
+	for _, token := range []string{"a", "b", "c"} {
+		req := httptest.NewRequest(http.MethodGet, "/v1/nodes", nil)
+		req.Header.Set("X-Auth-Token", token)
+		c.ServeHTTP(httptest.NewRecorder(), req)
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n := len(c.entries); n != 1 {
+		t.Errorf("cache holds %d entries, want only the unexpired one", n)
+	}
+}
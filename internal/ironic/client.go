@@ -0,0 +1,135 @@
+package ironic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// apiVersion is the Ironic API microversion the Client requests.
+const apiVersion = "latest"
+
+// Client makes requests to the Ironic API on behalf of metal-boot itself,
+// such as removing the nodes of decommissioned hosts. All methods of a nil
+// Client do nothing.
+type Client struct {
+	// BaseURL is the URL of the Ironic API, without the /v1 prefix.
+	BaseURL string
+	// Username and Password are sent as HTTP basic credentials when set.
+	Username string
+	Password string
+	HTTP     *http.Client
+}
+
+// NewClient returns a Client of the Ironic API listening on the Unix socket
+// at socketPath.
+func NewClient(socketPath, username, password string) *Client {
+	dialer := &net.Dialer{}
+
+	return &Client{
+		BaseURL:  "http://unix",
+		Username: username,
+		Password: password,
+		HTTP: &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, "unix", socketPath)
+			},
+		}},
+	}
+}
+
+// DeleteNodeByMAC removes the node with a port of mac from Ironic. The node
+// is put into maintenance first, so that it is removed whatever its
+// provision state. It returns the UUID of the removed node, or "" when no
+// node has a port of mac.
+func (c *Client) DeleteNodeByMAC(ctx context.Context, mac net.HardwareAddr) (string, error) {
+	if c == nil {
+		return "", nil
+	}
+
+	var ports struct {
+		Ports []struct {
+			NodeUUID string `json:"node_uuid"`
+		} `json:"ports"`
+	}
+	query := url.Values{"address": {mac.String()}, "fields": {"node_uuid"}}
+	status, err := c.do(ctx, http.MethodGet, "/v1/ports?"+query.Encode(), nil, &ports)
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusOK {
+		return "", fmt.Errorf("failed to list ports of %s: Ironic returned %d", mac, status)
+	}
+	if len(ports.Ports) == 0 {
+		return "", nil
+	}
+	node := ports.Ports[0].NodeUUID
+
+	maintenance := []map[string]any{{"op": "replace", "path": "/maintenance", "value": true}}
+	status, err = c.do(ctx, http.MethodPatch, "/v1/nodes/"+node, maintenance, nil)
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusOK && status != http.StatusNotFound {
+		return "", fmt.Errorf("failed to put node %s into maintenance: Ironic returned %d", node, status)
+	}
+
+	status, err = c.do(ctx, http.MethodDelete, "/v1/nodes/"+node, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusNoContent && status != http.StatusNotFound {
+		return "", fmt.Errorf("failed to delete node %s: Ironic returned %d", node, status)
+	}
+
+	return node, nil
+}
+
+// do sends a request with body encoded as JSON and decodes a successful
+// response into out. It returns the status of the response.
+func (c *Client) do(ctx context.Context, method, path string, body, out any) (int, error) {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		r = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, r)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-OpenStack-Ironic-API-Version", apiVersion)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+
+	httpClient := c.HTTP
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to reach Ironic: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if out != nil && resp.StatusCode/100 == 2 {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return 0, fmt.Errorf("failed to parse Ironic response: %w", err)
+		}
+	}
+
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,53 @@
+package ironic
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDeleteNodeByMAC(t *testing.T) {
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch {
+		case r.Method == http.MethodGet && r.URL.Query().Get("address") == "aa:bb:cc:dd:ee:01":
+			w.Write([]byte(`{"ports": [{"node_uuid": "node-1"}]}`))
+		case r.Method == http.MethodGet:
+			w.Write([]byte(`{"ports": []}`))
+		case r.Method == http.MethodPatch:
+			w.Write([]byte(`{}`))
+		case r.Method == http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+	c := &Client{BaseURL: srv.URL, HTTP: srv.Client()}
+
+	mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:01")
+	node, err := c.DeleteNodeByMAC(context.Background(), mac)
+	if err != nil || node != "node-1" {
+		t.Fatalf("DeleteNodeByMAC() = %q, %v, want node-1", node, err)
+	}
+	want := []string{"GET /v1/ports", "PATCH /v1/nodes/node-1", "DELETE /v1/nodes/node-1"}
+	if len(requests) != len(want) {
+		t.Fatalf("requests = %v, want %v", requests, want)
+	}
+	for i := range want {
+		if requests[i] != want[i] {
+			t.Errorf("request %d = %s, want %s", i, requests[i], want[i])
+		}
+	}
+
+	other, _ := net.ParseMAC("aa:bb:cc:dd:ee:02")
+	if node, err := c.DeleteNodeByMAC(context.Background(), other); err != nil || node != "" {
+		t.Errorf("DeleteNodeByMAC() of an unknown MAC = %q, %v, want nothing removed", node, err)
+	}
+
+	var nilClient *Client
+	if node, err := nilClient.DeleteNodeByMAC(context.Background(), mac); err != nil || node != "" {
+		t.Errorf("DeleteNodeByMAC() on a nil Client = %q, %v", node, err)
+	}
+}
@@ -0,0 +1,495 @@
+// Package leasedb tracks the lifecycle of the DHCP leases the server hands
+// out: offered on DHCPOFFER, bound on DHCPACK, renewed on every later ACK of
+// the same address, and released, declined or expired afterwards.
+//
+// Leases are written to a state file so that they survive restarts. Besides
+// the lifecycle, the database detects addresses in conflict: an address
+// leased to two clients at once, or leased to a client other than the one a
+// static reservation names. All methods of a nil DB do nothing, so tracking
+// is optional wherever it is threaded through.
+package leasedb
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/metal3-community/metal-boot/internal/backend"
+)
+
+// State is the state of a lease.
+type State string
+
+const (
+	// StateOffered leases were offered and not requested yet.
+	StateOffered State = "offered"
+	// StateBound leases were acknowledged and have not expired.
+	StateBound State = "bound"
+	// StateReleased leases were given back by their client.
+	StateReleased State = "released"
+	// StateDeclined leases were refused by their client, which found the
+	// address in use.
+	StateDeclined State = "declined"
+	// StateExpired leases were not renewed in time.
+	StateExpired State = "expired"
+)
+
+// Defaults of Options.
+const (
+	defaultOfferTimeout = time.Minute
+	defaultRetain       = 24 * time.Hour
+)
+
+// ErrConflict is returned when an address is recorded for a client while it
+// is in conflict. The lease is recorded anyway, with its Conflict set.
+var ErrConflict = errors.New("address in conflict")
+
+// Lease is the lease of one client.
+type Lease struct {
+	MAC      string     `json:"mac"`
+	IP       netip.Addr `json:"ip"`
+	Hostname string     `json:"hostname,omitempty"`
+	State    State      `json:"state"`
+	// LeaseTime is the lease time granted by the latest ACK, in seconds.
+	LeaseTime uint32     `json:"leaseTime,omitempty"`
+	OfferedAt *time.Time `json:"offeredAt,omitempty"`
+	BoundAt   *time.Time `json:"boundAt,omitempty"`
+	RenewedAt *time.Time `json:"renewedAt,omitempty"`
+	// Renewals counts the ACKs of the address after the one that bound it.
+	Renewals int `json:"renewals"`
+	// ExpiresAt is when an offered or bound lease expires.
+	ExpiresAt time.Time `json:"expiresAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	// Conflict describes why the address is in conflict, if it is.
+	Conflict string `json:"conflict,omitempty"`
+}
+
+// Active reports whether the lease holds its address at now.
+func (l Lease) Active(now time.Time) bool {
+	return (l.State == StateOffered || l.State == StateBound) && now.Before(l.ExpiresAt)
+}
+
+// Options configure a DB.
+type Options struct {
+	// OfferTimeout is how long an offered address is held for the client
+	// before it expires. Zero is one minute.
+	OfferTimeout time.Duration
+	// Retain is how long released, declined and expired leases are kept.
+	// Zero is a day.
+	Retain time.Duration
+}
+
+// DB is the lease database.
+type DB struct {
+	path string
+	opts Options
+
+	mu     sync.Mutex
+	leases map[string]*Lease
+
+	// now is time.Now outside tests.
+	now func() time.Time
+}
+
+// Open returns a DB that persists leases to path. With an empty path leases
+// are only kept in memory.
+func Open(path string, opts Options) (*DB, error) {
+	if opts.OfferTimeout <= 0 {
+		opts.OfferTimeout = defaultOfferTimeout
+	}
+	if opts.Retain <= 0 {
+		opts.Retain = defaultRetain
+	}
+
+	db := &DB{path: path, opts: opts, leases: make(map[string]*Lease), now: time.Now}
+	if path == "" {
+		return db, nil
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return db, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read leases: %w", err)
+	}
+	var leases []*Lease
+	if err := json.Unmarshal(b, &leases); err != nil {
+		return nil, fmt.Errorf("failed to parse leases: %w", err)
+	}
+	for _, l := range leases {
+		db.leases[l.MAC] = l
+	}
+
+	return db, nil
+}
+
+func (db *DB) save() error {
+	if db.path == "" {
+		return nil
+	}
+
+	leases := slices.SortedFunc(maps.Values(db.leases), func(a, b *Lease) int {
+		return cmp.Compare(a.MAC, b.MAC)
+	})
+	b, err := json.Marshal(leases)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(db.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create lease directory: %w", err)
+	}
+	tmp := db.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return fmt.Errorf("failed to write leases: %w", err)
+	}
+	if err := os.Rename(tmp, db.path); err != nil {
+		return fmt.Errorf("failed to replace leases: %w", err)
+	}
+
+	return nil
+}
+
+// Offer records that ip was offered to mac.
+func (db *DB) Offer(mac net.HardwareAddr, ip netip.Addr, hostname string) (Lease, error) {
+	if db == nil {
+		return Lease{}, nil
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	now := db.now().UTC()
+	l := db.lease(mac, ip, hostname, now)
+	if l.State != StateBound {
+		l.State = StateOffered
+		l.OfferedAt = &now
+		l.ExpiresAt = now.Add(db.opts.OfferTimeout)
+	}
+
+	return db.record(l, now)
+}
+
+// Ack records that ip was acknowledged to mac for leaseTime. An ACK of the
+// address mac is already bound to renews the lease.
+func (db *DB) Ack(
+	mac net.HardwareAddr,
+	ip netip.Addr,
+	hostname string,
+	leaseTime time.Duration,
+) (Lease, error) {
+	if db == nil {
+		return Lease{}, nil
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	now := db.now().UTC()
+	l := db.lease(mac, ip, hostname, now)
+	if l.State == StateBound {
+		l.Renewals++
+		l.RenewedAt = &now
+	} else {
+		l.State = StateBound
+		l.BoundAt = &now
+		l.Renewals = 0
+		l.RenewedAt = nil
+	}
+	l.LeaseTime = uint32(leaseTime / time.Second)
+	l.ExpiresAt = now.Add(leaseTime)
+
+	return db.record(l, now)
+}
+
+// lease returns the lease of mac for ip, starting a new one if mac held
+// another address or none that is still active. Callers must hold db.mu.
+func (db *DB) lease(mac net.HardwareAddr, ip netip.Addr, hostname string, now time.Time) *Lease {
+	l, ok := db.leases[mac.String()]
+	if !ok || l.IP != ip || !l.Active(now) {
+		l = &Lease{MAC: mac.String(), IP: ip}
+		db.leases[l.MAC] = l
+	}
+	if hostname != "" {
+		l.Hostname = hostname
+	}
+
+	return l
+}
+
+// record checks l for a conflict with the other active leases and saves it.
+// Callers must hold db.mu.
+func (db *DB) record(l *Lease, now time.Time) (Lease, error) {
+	l.UpdatedAt = now
+	l.Conflict = ""
+	for _, other := range db.leases {
+		if other != l && other.IP == l.IP && other.Active(now) {
+			l.Conflict = "address also leased to " + other.MAC
+			break
+		}
+	}
+
+	var err error
+	if l.Conflict != "" {
+		err = fmt.Errorf("%w: %s for %s: %s", ErrConflict, l.IP, l.MAC, l.Conflict)
+	}
+
+	return *l, errors.Join(err, db.save())
+}
+
+// Release records that mac gave its lease back.
+func (db *DB) Release(mac net.HardwareAddr) error {
+	return db.finish(mac, StateReleased)
+}
+
+// Decline records that mac refused its address because it found it in use.
+func (db *DB) Decline(mac net.HardwareAddr) error {
+	return db.finish(mac, StateDeclined)
+}
+
+func (db *DB) finish(mac net.HardwareAddr, state State) error {
+	if db == nil {
+		return nil
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	l, ok := db.leases[mac.String()]
+	if !ok {
+		return nil
+	}
+	now := db.now().UTC()
+	l.State = state
+	l.ExpiresAt = now
+	l.UpdatedAt = now
+
+	return db.save()
+}
+
+// Get returns the lease of mac.
+func (db *DB) Get(mac net.HardwareAddr) (Lease, bool) {
+	if db == nil {
+		return Lease{}, false
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	l, ok := db.leases[mac.String()]
+	if !ok {
+		return Lease{}, false
+	}
+
+	return *l, true
+}
+
+// List returns every lease ordered by MAC address.
+func (db *DB) List() []Lease {
+	if db == nil {
+		return nil
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	out := make([]Lease, 0, len(db.leases))
+	for _, l := range db.leases {
+		out = append(out, *l)
+	}
+	slices.SortFunc(out, func(a, b Lease) int { return cmp.Compare(a.MAC, b.MAC) })
+
+	return out
+}
+
+// Import replaces every lease with leases, such as the leases of a snapshot
+// taken by another instance, and persists them.
+func (db *DB) Import(leases []Lease) error {
+	if db == nil {
+		return nil
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.leases = make(map[string]*Lease, len(leases))
+	for _, l := range leases {
+		db.leases[l.MAC] = &l
+	}
+
+	return db.save()
+}
+
+// Expire marks the offered and bound leases whose time is up as expired,
+// forgets the finished leases older than Retain, and returns the leases it
+// expired.
+func (db *DB) Expire() ([]Lease, error) {
+	if db == nil {
+		return nil, nil
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	now := db.now().UTC()
+	var expired []Lease
+	changed := false
+	for mac, l := range db.leases {
+		switch {
+		case l.State == StateOffered || l.State == StateBound:
+			if now.Before(l.ExpiresAt) {
+				continue
+			}
+			l.State = StateExpired
+			l.UpdatedAt = now
+			expired = append(expired, *l)
+		case now.Sub(l.UpdatedAt) >= db.opts.Retain:
+			delete(db.leases, mac)
+		default:
+			continue
+		}
+		changed = true
+	}
+	if !changed {
+		return nil, nil
+	}
+
+	return expired, db.save()
+}
+
+// CheckReservations flags the active leases of an address that reserved
+// reserves for another client, and clears the flag of those that no longer
+// conflict. It returns the leases in conflict.
+func (db *DB) CheckReservations(reserved map[netip.Addr]string) ([]Lease, error) {
+	if db == nil {
+		return nil, nil
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	now := db.now().UTC()
+	holders := make(map[netip.Addr][]string)
+	for _, l := range db.leases {
+		if l.Active(now) {
+			holders[l.IP] = append(holders[l.IP], l.MAC)
+		}
+	}
+
+	var conflicts []Lease
+	changed := false
+	for _, l := range db.leases {
+		conflict := ""
+		if l.Active(now) {
+			if owner, ok := reserved[l.IP]; ok && owner != l.MAC {
+				conflict = "address reserved for " + owner
+			}
+			for _, mac := range holders[l.IP] {
+				if conflict == "" && mac != l.MAC {
+					conflict = "address also leased to " + mac
+				}
+			}
+		}
+		if conflict != l.Conflict {
+			l.Conflict = conflict
+			changed = true
+		}
+		if conflict != "" {
+			conflicts = append(conflicts, *l)
+		}
+	}
+	slices.SortFunc(conflicts, func(a, b Lease) int { return cmp.Compare(a.MAC, b.MAC) })
+	if !changed {
+		return conflicts, nil
+	}
+
+	return conflicts, db.save()
+}
+
+// Reservations returns the static reservations of reader, by address.
+func Reservations(ctx context.Context, reader backend.BackendReader) (map[netip.Addr]string, error) {
+	keys, err := reader.GetKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reserved := make(map[netip.Addr]string, len(keys))
+	for _, mac := range keys {
+		d, _, err := reader.GetByMac(ctx, mac)
+		if err != nil || d == nil || !d.IPAddress.IsValid() {
+			continue
+		}
+		reserved[d.IPAddress] = mac.String()
+	}
+
+	return reserved, nil
+}
+
+// Sweeper expires leases and checks them against the static reservations of
+// Reader every Interval.
+type Sweeper struct {
+	DB *DB
+	// Reader holds the static reservations. If nil, leases are only checked
+	// against each other.
+	Reader backend.BackendReader
+	// Interval is the time between sweeps in Run.
+	Interval time.Duration
+	// Log is used to log expired and conflicting leases.
+	Log logr.Logger
+}
+
+// Run sweeps once immediately and then every Interval until ctx is done.
+func (s *Sweeper) Run(ctx context.Context) error {
+	interval := s.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.Sweep(ctx)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep expires the leases whose time is up and flags conflicts.
+func (s *Sweeper) Sweep(ctx context.Context) {
+	expired, err := s.DB.Expire()
+	if err != nil {
+		s.Log.Error(err, "failed to expire leases")
+	}
+	for _, l := range expired {
+		s.Log.V(1).Info("lease expired", "mac", l.MAC, "ip", l.IP.String())
+	}
+
+	reserved := map[netip.Addr]string{}
+	if s.Reader != nil {
+		if reserved, err = Reservations(ctx, s.Reader); err != nil {
+			s.Log.Error(err, "failed to read reservations")
+			return
+		}
+	}
+	conflicts, err := s.DB.CheckReservations(reserved)
+	if err != nil {
+		s.Log.Error(err, "failed to save lease conflicts")
+	}
+	for _, l := range conflicts {
+		s.Log.Info("lease in conflict", "mac", l.MAC, "ip", l.IP.String(), "conflict", l.Conflict)
+	}
+}
@@ -0,0 +1,113 @@
+package leasedb
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var (
+	macA = net.HardwareAddr{0xd8, 0x3a, 0xdd, 0, 0, 1}
+	macB = net.HardwareAddr{0xd8, 0x3a, 0xdd, 0, 0, 2}
+	ipA  = netip.MustParseAddr("10.0.0.10")
+)
+
+func TestLifecycle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leases.json")
+	db, err := Open(path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1000, 0).UTC()
+	db.now = func() time.Time { return now }
+
+	if _, err := db.Offer(macA, ipA, "node-1"); err != nil {
+		t.Fatalf("Offer() error = %v", err)
+	}
+	now = now.Add(time.Second)
+	if _, err := db.Ack(macA, ipA, "", time.Hour); err != nil {
+		t.Fatalf("Ack() error = %v", err)
+	}
+	now = now.Add(30 * time.Minute)
+	l, err := db.Ack(macA, ipA, "", time.Hour)
+	if err != nil {
+		t.Fatalf("renewing Ack() error = %v", err)
+	}
+	if l.State != StateBound || l.Renewals != 1 || l.Hostname != "node-1" ||
+		!l.ExpiresAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("renewed lease = %+v", l)
+	}
+
+	// The lease survives a restart.
+	db, err = Open(path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.now = func() time.Time { return now }
+	if got, ok := db.Get(macA); !ok || got.State != StateBound || got.Renewals != 1 {
+		t.Fatalf("reloaded lease = %+v, %v", got, ok)
+	}
+
+	now = now.Add(2 * time.Hour)
+	expired, err := db.Expire()
+	if err != nil || len(expired) != 1 || expired[0].State != StateExpired {
+		t.Fatalf("Expire() = %+v, %v, want the lease expired", expired, err)
+	}
+
+	now = now.Add(25 * time.Hour)
+	if _, err := db.Expire(); err != nil {
+		t.Fatal(err)
+	}
+	if leases := db.List(); len(leases) != 0 {
+		t.Errorf("List() after retention = %+v, want none", leases)
+	}
+}
+
+func TestConflicts(t *testing.T) {
+	db, err := Open("", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Ack(macA, ipA, "", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	l, err := db.Offer(macB, ipA, "")
+	if !errors.Is(err, ErrConflict) || l.Conflict == "" {
+		t.Errorf("Offer() of a leased address = %+v, %v, want %v", l, err, ErrConflict)
+	}
+
+	if err := db.Release(macB); err != nil {
+		t.Fatal(err)
+	}
+	conflicts, err := db.CheckReservations(map[netip.Addr]string{ipA: macB.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conflicts) != 1 || conflicts[0].MAC != macA.String() ||
+		conflicts[0].Conflict != "address reserved for "+macB.String() {
+		t.Errorf("CheckReservations() = %+v, want the lease of %s reserved for %s",
+			conflicts, macA, macB)
+	}
+
+	conflicts, err = db.CheckReservations(map[netip.Addr]string{ipA: macA.String()})
+	if err != nil || len(conflicts) != 0 {
+		t.Errorf("CheckReservations() with a matching reservation = %+v, %v", conflicts, err)
+	}
+	if got, _ := db.Get(macA); got.Conflict != "" {
+		t.Errorf("Conflict = %q after the reservation matches, want it cleared", got.Conflict)
+	}
+}
+
+func TestNilDB(t *testing.T) {
+	var db *DB
+	if _, err := db.Ack(macA, ipA, "", time.Hour); err != nil {
+		t.Errorf("Ack() on a nil DB error = %v", err)
+	}
+	if leases := db.List(); leases != nil {
+		t.Errorf("List() on a nil DB = %v", leases)
+	}
+}
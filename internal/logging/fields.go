@@ -0,0 +1,160 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net"
+	"net/http"
+
+	"github.com/go-logr/logr"
+)
+
+// Keys of the fields that correlate records across subsystems. Every logger,
+// logr or slog, uses these for the host and request a record is about.
+const (
+	// KeyMAC is the MAC address of the host, formatted by
+	// net.HardwareAddr.String.
+	KeyMAC = "mac"
+	// KeySystemID is the Redfish system id as it appears in the URL.
+	KeySystemID = "system_id"
+	// KeyRequestID is the id of the HTTP request, as sent and returned in
+	// the X-Request-Id header.
+	KeyRequestID = "request_id"
+)
+
+// RequestIDHeader carries the id of a request.
+const RequestIDHeader = "X-Request-Id"
+
+// MAC returns the field of the host mac.
+func MAC(mac net.HardwareAddr) slog.Attr {
+	return slog.String(KeyMAC, mac.String())
+}
+
+// SystemID returns the field of the Redfish system id.
+func SystemID(id string) slog.Attr {
+	return slog.String(KeySystemID, id)
+}
+
+// RequestID returns the field of the request id.
+func RequestID(id string) slog.Attr {
+	return slog.String(KeyRequestID, id)
+}
+
+type fieldsKey struct{}
+
+// With returns a copy of ctx carrying attrs in addition to the fields it
+// already carries. Records logged with the context, through the handler of
+// NewContextHandler, or by a logger of Logger or Logr, include them.
+func With(ctx context.Context, attrs ...slog.Attr) context.Context {
+	fields := append(Fields(ctx), attrs...)
+
+	return context.WithValue(ctx, fieldsKey{}, fields)
+}
+
+// Fields returns the fields ctx carries.
+func Fields(ctx context.Context) []slog.Attr {
+	fields, _ := ctx.Value(fieldsKey{}).([]slog.Attr)
+
+	return fields[:len(fields):len(fields)]
+}
+
+// Logger returns logger with the fields of ctx attached, for records logged
+// without the context, or logger itself when ctx carries none.
+func Logger(ctx context.Context, logger *slog.Logger) *slog.Logger {
+	fields := Fields(ctx)
+	if len(fields) == 0 {
+		return logger
+	}
+	args := make([]any, len(fields))
+	for i, a := range fields {
+		args[i] = a
+	}
+
+	return logger.With(args...)
+}
+
+// Logr is Logger for logr loggers, which never pass the context of a call on
+// to the handler.
+func Logr(ctx context.Context, logger logr.Logger) logr.Logger {
+	fields := Fields(ctx)
+	if len(fields) == 0 {
+		return logger
+	}
+	kv := make([]any, 0, 2*len(fields))
+	for _, a := range fields {
+		kv = append(kv, a.Key, a.Value.Any())
+	}
+
+	return logger.WithValues(kv...)
+}
+
+// ToLogr returns a logr logger writing through the handler of log.
+func ToLogr(log *slog.Logger) logr.Logger {
+	return logr.FromSlogHandler(log.Handler())
+}
+
+// ToSlog returns a slog logger writing through the sink of log.
+func ToSlog(log logr.Logger) *slog.Logger {
+	return slog.New(logr.ToSlogHandler(log))
+}
+
+// contextHandler adds the fields of the context of a record to it.
+type contextHandler struct {
+	next slog.Handler
+}
+
+// NewContextHandler returns a handler adding the fields a context carries
+// to the records logged with it before passing them to next.
+func NewContextHandler(next slog.Handler) slog.Handler {
+	return &contextHandler{next: next}
+}
+
+// Enabled implements slog.Handler.
+func (h *contextHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+// Handle implements slog.Handler.
+func (h *contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if fields := Fields(ctx); len(fields) > 0 {
+		record = record.Clone()
+		record.AddAttrs(fields...)
+	}
+
+	return h.next.Handle(ctx, record)
+}
+
+// WithAttrs implements slog.Handler.
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{next: h.next.WithAttrs(attrs)}
+}
+
+// WithGroup implements slog.Handler.
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{next: h.next.WithGroup(name)}
+}
+
+// Middleware gives every request an id, the one of its X-Request-Id header
+// or a new one, returns it in the response header and adds it to the fields
+// of the request context.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if id == "" {
+			id = newRequestID()
+			r.Header.Set(RequestIDHeader, id)
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		next.ServeHTTP(w, r.WithContext(With(r.Context(), RequestID(id))))
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,205 @@
+// Package logging builds the slog.Handler every logger of metal-boot writes
+// through, the logr loggers and the slog ones alike.
+//
+// Records are formatted as JSON or as logfmt and written to stdout or
+// stderr, to a file rotated by size, or to syslog or the systemd journal,
+// where the level of each record becomes its priority.
+//
+// Records about a host or a request carry the same keys whichever logger
+// wrote them, KeyMAC, KeySystemID and KeyRequestID, so that they can be
+// correlated across subsystems. Middleware puts the request id into the
+// request context, from which NewContextHandler, Logger and Logr take it.
+package logging
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Formats of log records.
+const (
+	FormatJSON   = "json"
+	FormatLogfmt = "logfmt"
+)
+
+// Outputs log records are written to.
+const (
+	OutputStdout   = "stdout"
+	OutputStderr   = "stderr"
+	OutputFile     = "file"
+	OutputSyslog   = "syslog"
+	OutputJournald = "journald"
+)
+
+// Options select the format and destination of log records.
+type Options struct {
+	// Level is the minimum level written.
+	Level slog.Leveler
+	// Format is FormatJSON or FormatLogfmt. Empty is FormatJSON.
+	Format string
+	// Output is where records are written. Empty is OutputStdout.
+	Output string
+	// File configures OutputFile.
+	File FileOptions
+	// Syslog configures OutputSyslog.
+	Syslog SyslogOptions
+	// Tag identifies metal-boot in syslog and the journal. Empty is the
+	// name of the executable.
+	Tag string
+}
+
+// NewHandler returns a handler writing records as opts select. The closer
+// releases the file or connection the records are written to.
+func NewHandler(opts Options) (slog.Handler, io.Closer, error) {
+	tag := opts.Tag
+	if tag == "" {
+		tag = filepath.Base(os.Args[0])
+	}
+
+	switch opts.Output {
+	case "", OutputStdout:
+		h, err := formatHandler(os.Stdout, opts)
+		return h, nopCloser{}, err
+	case OutputStderr:
+		h, err := formatHandler(os.Stderr, opts)
+		return h, nopCloser{}, err
+	case OutputFile:
+		f, err := OpenRotatingFile(opts.File)
+		if err != nil {
+			return nil, nil, err
+		}
+		h, err := formatHandler(f, opts)
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		return h, f, nil
+	case OutputSyslog:
+		s, err := dialSyslog(opts.Syslog, tag)
+		if err != nil {
+			return nil, nil, err
+		}
+		h, err := newSinkHandler(s, opts)
+		if err != nil {
+			s.Close()
+			return nil, nil, err
+		}
+		return h, s, nil
+	case OutputJournald:
+		j, err := dialJournal(tag)
+		if err != nil {
+			return nil, nil, err
+		}
+		h, err := newSinkHandler(j, opts)
+		if err != nil {
+			j.Close()
+			return nil, nil, err
+		}
+		return h, j, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown log output %q", opts.Output)
+	}
+}
+
+// formatHandler returns the handler of the format of opts writing to w.
+func formatHandler(w io.Writer, opts Options) (slog.Handler, error) {
+	ho := &slog.HandlerOptions{AddSource: true, Level: opts.Level, ReplaceAttr: shortenSource}
+	switch opts.Format {
+	case "", FormatJSON:
+		return slog.NewJSONHandler(w, ho), nil
+	case FormatLogfmt:
+		return slog.NewTextHandler(w, ho), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q", opts.Format)
+	}
+}
+
+// shortenSource truncates the source file and function of records to their
+// last 3 parts. They can be long, which makes the logs less readable.
+func shortenSource(_ []string, a slog.Attr) slog.Attr {
+	if a.Key != slog.SourceKey {
+		return a
+	}
+	ss, ok := a.Value.Any().(*slog.Source)
+	if !ok || ss == nil {
+		return a
+	}
+	if f := strings.Split(ss.Function, "/"); len(f) > 3 {
+		ss.Function = filepath.Join(f[len(f)-3:]...)
+	}
+	if p := strings.Split(ss.File, "/"); len(p) > 3 {
+		ss.File = filepath.Join(p[len(p)-3:]...)
+	}
+
+	return a
+}
+
+// sink receives formatted records one at a time, with their level, such as
+// syslog which takes the level as the priority of a message.
+type sink interface {
+	writeRecord(level slog.Level, line []byte) error
+}
+
+// sinkHandler formats records into a buffer and passes each to a sink.
+type sinkHandler struct {
+	next slog.Handler
+	sink sink
+	// mu guards buf, which next writes to and which all handlers derived
+	// with WithAttrs and WithGroup share.
+	mu  *sync.Mutex
+	buf *bytes.Buffer
+}
+
+func newSinkHandler(s sink, opts Options) (slog.Handler, error) {
+	buf := new(bytes.Buffer)
+	next, err := formatHandler(buf, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sinkHandler{next: next, sink: s, mu: new(sync.Mutex), buf: buf}, nil
+}
+
+// Enabled implements slog.Handler.
+func (h *sinkHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+// Handle implements slog.Handler.
+func (h *sinkHandler) Handle(ctx context.Context, record slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.buf.Reset()
+	if err := h.next.Handle(ctx, record); err != nil {
+		return err
+	}
+
+	return h.sink.writeRecord(record.Level, bytes.TrimSuffix(h.buf.Bytes(), []byte("\n")))
+}
+
+// WithAttrs implements slog.Handler.
+func (h *sinkHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &sinkHandler{next: h.next.WithAttrs(attrs), sink: h.sink, mu: h.mu, buf: h.buf}
+}
+
+// WithGroup implements slog.Handler.
+func (h *sinkHandler) WithGroup(name string) slog.Handler {
+	return &sinkHandler{next: h.next.WithGroup(name), sink: h.sink, mu: h.mu, buf: h.buf}
+}
+
+// errClosed is returned by writes after Close.
+var errClosed = errors.New("log output is closed")
+
+// nopCloser is the closer of stdout and stderr, which stay open.
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
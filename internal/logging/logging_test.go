@@ -0,0 +1,170 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFormats(t *testing.T) {
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{format: FormatJSON, want: `"msg":"hello","mac":"aa:bb"`},
+		{format: FormatLogfmt, want: `msg=hello mac=aa:bb`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "metal-boot.log")
+			h, closer, err := NewHandler(Options{
+				Format: tt.format,
+				Output: OutputFile,
+				File:   FileOptions{Path: path},
+			})
+			if err != nil {
+				t.Fatalf("NewHandler() error = %v", err)
+			}
+			slog.New(h).Info("hello", "mac", "aa:bb")
+			closer.Close()
+
+			b, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(b), tt.want) {
+				t.Errorf("log = %s, want %s", b, tt.want)
+			}
+		})
+	}
+
+	if _, _, err := NewHandler(Options{Format: "xml"}); err == nil {
+		t.Error("NewHandler() with unknown format succeeded")
+	}
+	if _, _, err := NewHandler(Options{Output: "printer"}); err == nil {
+		t.Error("NewHandler() with unknown output succeeded")
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metal-boot.log")
+	f, err := OpenRotatingFile(FileOptions{Path: path, MaxSizeMB: 1, MaxBackups: 2})
+	if err != nil {
+		t.Fatalf("OpenRotatingFile() error = %v", err)
+	}
+	defer f.Close()
+
+	line := bytes.Repeat([]byte("x"), 600<<10)
+	for i := range 4 {
+		if _, err := f.Write(line); err != nil {
+			t.Fatalf("Write(%d) error = %v", i, err)
+		}
+	}
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatalf("Stat(%s) error = %v", name, err)
+		}
+		if info.Size() != int64(len(line)) {
+			t.Errorf("size of %s = %d, want %d", name, info.Size(), len(line))
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Stat(%s.3) error = %v, want not exist", path, err)
+	}
+}
+
+func TestJournald(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "journal.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("unixgram sockets are unavailable: %v", err)
+	}
+	defer conn.Close()
+	defer func(orig string) { journalSocket = orig }(journalSocket)
+	journalSocket = socket
+
+	h, closer, err := NewHandler(Options{
+		Format: FormatLogfmt,
+		Output: OutputJournald,
+		Tag:    "metal-boot",
+	})
+	if err != nil {
+		t.Fatalf("NewHandler() error = %v", err)
+	}
+	defer closer.Close()
+	slog.New(h).Warn("disk full")
+
+	b := make([]byte, 4096)
+	n, err := conn.Read(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(b[:n])
+	wants := []string{"PRIORITY=4\n", "SYSLOG_IDENTIFIER=metal-boot\n", `msg="disk full"`}
+	for _, want := range wants {
+		if !strings.Contains(got, want) {
+			t.Errorf("journal message = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestWriteJournalField(t *testing.T) {
+	var buf bytes.Buffer
+	writeJournalField(&buf, "MESSAGE", []byte("a\nb"))
+
+	want := fmt.Sprintf("MESSAGE\n%s%s\n", binary.LittleEndian.AppendUint64(nil, 3), "a\nb")
+	if buf.String() != want {
+		t.Errorf("field = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewContextHandler(slog.NewJSONHandler(&buf, nil)))
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.InfoContext(r.Context(), "slog")
+		ToLogr(Logger(r.Context(), slog.New(slog.NewJSONHandler(&buf, nil)))).Info("logr")
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(RequestIDHeader, "abc")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get(RequestIDHeader); got != "abc" {
+		t.Errorf("%s = %q, want abc", RequestIDHeader, got)
+	}
+	if got := strings.Count(buf.String(), `"request_id":"abc"`); got != 2 {
+		t.Errorf("records with request id = %d, want 2: %s", got, buf.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := rec.Header().Get(RequestIDHeader); len(got) != 32 {
+		t.Errorf("generated %s = %q, want 32 hex digits", RequestIDHeader, got)
+	}
+}
+
+func TestLogr(t *testing.T) {
+	var buf bytes.Buffer
+	ctx := With(context.Background(), MAC(net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}))
+	ctx = With(ctx, SystemID("aa:bb:cc:dd:ee:ff"))
+
+	Logr(ctx, ToLogr(slog.New(slog.NewTextHandler(&buf, nil)))).Info("hello")
+
+	want := "mac=aa:bb:cc:dd:ee:ff system_id=aa:bb:cc:dd:ee:ff"
+	if !strings.Contains(buf.String(), want) {
+		t.Errorf("record = %s, want %s", buf.String(), want)
+	}
+}
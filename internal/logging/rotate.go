@@ -0,0 +1,128 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Defaults of FileOptions.
+const (
+	defaultMaxSizeMB  = 100
+	defaultMaxBackups = 5
+)
+
+// FileOptions configure a log file rotated by size.
+type FileOptions struct {
+	// Path is the log file. Rotated files are kept next to it as Path.1,
+	// the most recent, to Path.N.
+	Path string
+	// MaxSizeMB is the size at which the file is rotated. Zero is 100.
+	MaxSizeMB int
+	// MaxBackups is how many rotated files are kept. Zero is 5.
+	MaxBackups int
+}
+
+// RotatingFile is an io.WriteCloser appending to a file, which it rotates
+// before a write would take it over its maximum size.
+type RotatingFile struct {
+	opts FileOptions
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// OpenRotatingFile opens the log file of opts for appending, creating it and
+// its directory as needed.
+func OpenRotatingFile(opts FileOptions) (*RotatingFile, error) {
+	if opts.Path == "" {
+		return nil, fmt.Errorf("log file path is required")
+	}
+	if opts.MaxSizeMB <= 0 {
+		opts.MaxSizeMB = defaultMaxSizeMB
+	}
+	if opts.MaxBackups <= 0 {
+		opts.MaxBackups = defaultMaxBackups
+	}
+	if err := os.MkdirAll(filepath.Dir(opts.Path), 0o755); err != nil {
+		return nil, fmt.Errorf("creating log directory: %w", err)
+	}
+
+	r := &RotatingFile{opts: opts}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.opts.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("opening log file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("opening log file: %w", err)
+	}
+	r.f, r.size = f, info.Size()
+
+	return nil
+}
+
+// Write implements io.Writer. A write larger than the maximum size is
+// written to a file of its own rather than split.
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.f == nil {
+		return 0, errClosed
+	}
+	if r.size > 0 && r.size+int64(len(p)) > int64(r.opts.MaxSizeMB)<<20 {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+
+	return n, err
+}
+
+// rotate shifts the rotated files up by one, dropping the oldest, and moves
+// the current file to Path.1.
+func (r *RotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	r.f = nil
+
+	path := r.opts.Path
+	os.Remove(fmt.Sprintf("%s.%d", path, r.opts.MaxBackups))
+	for i := r.opts.MaxBackups - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", path, i), fmt.Sprintf("%s.%d", path, i+1))
+	}
+	if err := os.Rename(path, path+".1"); err != nil {
+		return fmt.Errorf("rotating log file: %w", err)
+	}
+
+	return r.open()
+}
+
+// Close implements io.Closer.
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+
+	return err
+}
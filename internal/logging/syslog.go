@@ -0,0 +1,156 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"log/syslog"
+	"net"
+	"sync"
+)
+
+// journalSocket is where systemd-journald receives native protocol messages.
+var journalSocket = "/run/systemd/journal/socket"
+
+// SyslogOptions configure the syslog output.
+type SyslogOptions struct {
+	// Network and Address are the syslog server, such as "udp" and
+	// "loghost:514". An empty Address is the local syslog daemon.
+	Network string
+	Address string
+	// Facility is the syslog facility, such as "daemon" or "local0". Empty
+	// is "daemon".
+	Facility string
+}
+
+var facilities = map[string]syslog.Priority{
+	"":       syslog.LOG_DAEMON,
+	"daemon": syslog.LOG_DAEMON,
+	"user":   syslog.LOG_USER,
+	"local0": syslog.LOG_LOCAL0,
+	"local1": syslog.LOG_LOCAL1,
+	"local2": syslog.LOG_LOCAL2,
+	"local3": syslog.LOG_LOCAL3,
+	"local4": syslog.LOG_LOCAL4,
+	"local5": syslog.LOG_LOCAL5,
+	"local6": syslog.LOG_LOCAL6,
+	"local7": syslog.LOG_LOCAL7,
+}
+
+// severity is the syslog severity of level, which is also the PRIORITY of
+// a journal entry.
+func severity(level slog.Level) syslog.Priority {
+	switch {
+	case level >= slog.LevelError:
+		return syslog.LOG_ERR
+	case level >= slog.LevelWarn:
+		return syslog.LOG_WARNING
+	case level >= slog.LevelInfo:
+		return syslog.LOG_INFO
+	default:
+		return syslog.LOG_DEBUG
+	}
+}
+
+// syslogSink writes records to a syslog server.
+type syslogSink struct {
+	w *syslog.Writer
+}
+
+func dialSyslog(opts SyslogOptions, tag string) (*syslogSink, error) {
+	facility, ok := facilities[opts.Facility]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility %q", opts.Facility)
+	}
+	w, err := syslog.Dial(opts.Network, opts.Address, facility|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to syslog: %w", err)
+	}
+
+	return &syslogSink{w: w}, nil
+}
+
+func (s *syslogSink) writeRecord(level slog.Level, line []byte) error {
+	msg := string(line)
+	switch severity(level) {
+	case syslog.LOG_ERR:
+		return s.w.Err(msg)
+	case syslog.LOG_WARNING:
+		return s.w.Warning(msg)
+	case syslog.LOG_INFO:
+		return s.w.Info(msg)
+	default:
+		return s.w.Debug(msg)
+	}
+}
+
+// Close implements io.Closer.
+func (s *syslogSink) Close() error {
+	return s.w.Close()
+}
+
+// journalSink writes records to systemd-journald with its native protocol,
+// one datagram of fields per record.
+type journalSink struct {
+	tag string
+
+	mu   sync.Mutex
+	conn *net.UnixConn
+	buf  bytes.Buffer
+}
+
+func dialJournal(tag string) (*journalSink, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("connecting to the journal: %w", err)
+	}
+
+	return &journalSink{tag: tag, conn: conn}, nil
+}
+
+func (j *journalSink) writeRecord(level slog.Level, line []byte) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.conn == nil {
+		return errClosed
+	}
+	j.buf.Reset()
+	writeJournalField(&j.buf, "MESSAGE", line)
+	writeJournalField(&j.buf, "PRIORITY", fmt.Appendf(nil, "%d", severity(level)))
+	writeJournalField(&j.buf, "SYSLOG_IDENTIFIER", []byte(j.tag))
+	_, err := j.conn.Write(j.buf.Bytes())
+
+	return err
+}
+
+// writeJournalField appends a field to a native protocol message. Values
+// with a newline are written in the binary form, prefixed with their length.
+func writeJournalField(buf *bytes.Buffer, name string, value []byte) {
+	buf.WriteString(name)
+	if bytes.IndexByte(value, '\n') < 0 {
+		buf.WriteByte('=')
+		buf.Write(value)
+		buf.WriteByte('\n')
+		return
+	}
+	buf.WriteByte('\n')
+	buf.Write(binary.LittleEndian.AppendUint64(nil, uint64(len(value))))
+	buf.Write(value)
+	buf.WriteByte('\n')
+}
+
+// Close implements io.Closer.
+func (j *journalSink) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.conn == nil {
+		return nil
+	}
+	err := j.conn.Close()
+	j.conn = nil
+
+	return err
+}
@@ -0,0 +1,339 @@
+package metric
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/metal3-community/metal-boot/internal/events"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	bootAttempts = prometheus.NewDesc(
+		"boot_attempts_total",
+		"Number of finished netboot attempts by outcome (success, no_script or no_callback).",
+		[]string{"outcome"}, nil,
+	)
+	hostBootAttempts = prometheus.NewDesc(
+		"host_boot_attempts_total",
+		"Number of finished netboot attempts of the host by outcome.",
+		[]string{"mac", "outcome"}, nil,
+	)
+	bootAttemptsInProgress = prometheus.NewDesc(
+		"boot_attempts_in_progress",
+		"Number of netboot attempts that have not finished yet.",
+		nil, nil,
+	)
+	bootSuccessRatio = prometheus.NewDesc(
+		"boot_success_ratio",
+		"Share of the netboot attempts finished within the ratio window that succeeded.",
+		nil, nil,
+	)
+	bootStageDuration = prometheus.NewDesc(
+		"boot_stage_duration_seconds",
+		"Time from the DHCPDISCOVER of a netboot attempt to the iPXE script fetch (script) "+
+			"and to the phone-home callback (callback).",
+		[]string{"stage"}, nil,
+	)
+)
+
+// Outcomes of a netboot attempt.
+const (
+	// BootSuccess is an attempt that reached its last expected stage.
+	BootSuccess = "success"
+	// BootNoScript is an attempt that never fetched its iPXE script.
+	BootNoScript = "no_script"
+	// BootNoCallback is an attempt that fetched its iPXE script but never
+	// phoned home.
+	BootNoCallback = "no_callback"
+)
+
+var bootOutcomes = []string{BootSuccess, BootNoScript, BootNoCallback}
+
+// Stages timed from the DHCPDISCOVER of an attempt.
+const (
+	stageScript   = "script"
+	stageCallback = "callback"
+)
+
+// bootStageBuckets cover a chainload within seconds up to an OS install of an
+// hour.
+var bootStageBuckets = []float64{5, 10, 30, 60, 120, 300, 600, 900, 1200, 1800, 2700, 3600}
+
+// BootSLO follows netboot attempts from DHCPDISCOVER through the iPXE script
+// fetch to the phone-home callback and exports their outcomes and timings,
+// so that provisioning reliability can be held to an SLO. It is a
+// prometheus.Collector. A nil BootSLO records nothing.
+//
+// DISCOVERs within Window of the start of an attempt that has not fetched its
+// script yet are retransmits or the DISCOVER of iPXE after the firmware
+// chainloaded it, and belong to that attempt. Any other DISCOVER ends the
+// open attempt of the host as failed and starts a new one, as does Timeout
+// passing without the attempt finishing.
+type BootSLO struct {
+	Window time.Duration
+	// Timeout is how long an attempt may take before it counts as failed.
+	// Zero waits for the next DISCOVER of the host.
+	Timeout time.Duration
+	// ExpectCallback makes attempts succeed when the host phones home rather
+	// than when it fetches its iPXE script.
+	ExpectCallback bool
+	// RatioWindow is the time over which boot_success_ratio is computed.
+	// Zero means an hour.
+	RatioWindow time.Duration
+
+	mu       sync.Mutex
+	attempts map[string]*bootAttempt
+	totals   map[string]uint64
+	perHost  map[string]map[string]uint64
+	stages   map[string]*bootHistogram
+	// finished holds the recent outcomes for the success ratio, oldest
+	// first.
+	finished []finishedBoot
+
+	// now overrides time.Now in tests.
+	now func() time.Time
+}
+
+type bootAttempt struct {
+	start  time.Time
+	script time.Time
+}
+
+type finishedBoot struct {
+	at      time.Time
+	success bool
+}
+
+type bootHistogram struct {
+	count   uint64
+	sum     float64
+	buckets map[float64]uint64
+}
+
+func newBootHistogram() *bootHistogram {
+	h := &bootHistogram{buckets: make(map[float64]uint64, len(bootStageBuckets))}
+	for _, le := range bootStageBuckets {
+		h.buckets[le] = 0
+	}
+
+	return h
+}
+
+// Discover records a netboot DHCPDISCOVER or SOLICIT from mac.
+func (s *BootSLO) Discover(mac net.HardwareAddr) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.time()
+	s.expire(now)
+	key := bootKey(mac)
+	if a, ok := s.attempts[key]; ok {
+		if a.script.IsZero() && now.Sub(a.start) < s.Window {
+			return
+		}
+		s.finish(key, a, now, false)
+	}
+	if s.attempts == nil {
+		s.attempts = make(map[string]*bootAttempt)
+	}
+	s.attempts[key] = &bootAttempt{start: now}
+}
+
+// ScriptFetched records that mac fetched its iPXE script.
+func (s *BootSLO) ScriptFetched(mac net.HardwareAddr) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.time()
+	s.expire(now)
+	key := bootKey(mac)
+	a, ok := s.attempts[key]
+	if !ok || !a.script.IsZero() {
+		// Scripts fetched outside an attempt, such as after a restart of
+		// metal-boot or by hand, are not timed.
+		return
+	}
+	a.script = now
+	s.observe(stageScript, now.Sub(a.start))
+	if !s.ExpectCallback {
+		s.finish(key, a, now, true)
+	}
+}
+
+// Callback records that mac phoned home.
+func (s *BootSLO) Callback(mac net.HardwareAddr) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.time()
+	s.expire(now)
+	key := bootKey(mac)
+	a, ok := s.attempts[key]
+	if !ok || !s.ExpectCallback {
+		return
+	}
+	s.observe(stageCallback, now.Sub(a.start))
+	s.finish(key, a, now, true)
+}
+
+// HandleEvent records the callbacks of hosts published on an events.Bus.
+func (s *BootSLO) HandleEvent(e events.Event) {
+	if e.Type != events.ProvisioningComplete {
+		return
+	}
+	if mac, err := net.ParseMAC(e.MAC); err == nil {
+		s.Callback(mac)
+	}
+}
+
+// finish ends the attempt a of key. Failed attempts are attributed to the
+// stage they did not reach.
+func (s *BootSLO) finish(key string, a *bootAttempt, now time.Time, success bool) {
+	delete(s.attempts, key)
+
+	outcome := BootSuccess
+	switch {
+	case success:
+	case a.script.IsZero():
+		outcome = BootNoScript
+	default:
+		outcome = BootNoCallback
+	}
+
+	if s.totals == nil {
+		s.totals = make(map[string]uint64)
+		s.perHost = make(map[string]map[string]uint64)
+	}
+	s.totals[outcome]++
+	if s.perHost[key] == nil {
+		s.perHost[key] = make(map[string]uint64)
+	}
+	s.perHost[key][outcome]++
+	s.finished = append(s.finished, finishedBoot{at: now, success: success})
+}
+
+// expire fails the attempts older than Timeout and forgets the outcomes
+// older than RatioWindow.
+func (s *BootSLO) expire(now time.Time) {
+	if s.Timeout > 0 {
+		for key, a := range s.attempts {
+			if now.Sub(a.start) >= s.Timeout {
+				s.finish(key, a, now, false)
+			}
+		}
+	}
+
+	window := s.RatioWindow
+	if window <= 0 {
+		window = time.Hour
+	}
+	drop := 0
+	for drop < len(s.finished) && now.Sub(s.finished[drop].at) >= window {
+		drop++
+	}
+	s.finished = s.finished[drop:]
+}
+
+func (s *BootSLO) observe(stage string, d time.Duration) {
+	if s.stages == nil {
+		s.stages = make(map[string]*bootHistogram)
+	}
+	h, ok := s.stages[stage]
+	if !ok {
+		h = newBootHistogram()
+		s.stages[stage] = h
+	}
+
+	sec := d.Seconds()
+	h.count++
+	h.sum += sec
+	for _, le := range bootStageBuckets {
+		if sec <= le {
+			h.buckets[le]++
+		}
+	}
+}
+
+func (s *BootSLO) time() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+
+	return time.Now()
+}
+
+// Describe implements prometheus.Collector.
+func (s *BootSLO) Describe(ch chan<- *prometheus.Desc) {
+	ch <- bootAttempts
+	ch <- hostBootAttempts
+	ch <- bootAttemptsInProgress
+	ch <- bootSuccessRatio
+	ch <- bootStageDuration
+}
+
+// Collect implements prometheus.Collector.
+func (s *BootSLO) Collect(ch chan<- prometheus.Metric) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire(s.time())
+
+	for _, outcome := range bootOutcomes {
+		ch <- prometheus.MustNewConstMetric(
+			bootAttempts, prometheus.CounterValue, float64(s.totals[outcome]), outcome)
+	}
+	for key, outcomes := range s.perHost {
+		for outcome, n := range outcomes {
+			ch <- prometheus.MustNewConstMetric(
+				hostBootAttempts, prometheus.CounterValue, float64(n), key, outcome)
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(
+		bootAttemptsInProgress, prometheus.GaugeValue, float64(len(s.attempts)))
+
+	// Without finished attempts there is no ratio to report, and reporting 0
+	// or 1 would fire or hide alerts.
+	if len(s.finished) > 0 {
+		succeeded := 0
+		for _, f := range s.finished {
+			if f.success {
+				succeeded++
+			}
+		}
+		ch <- prometheus.MustNewConstMetric(bootSuccessRatio, prometheus.GaugeValue,
+			float64(succeeded)/float64(len(s.finished)))
+	}
+
+	for _, stage := range []string{stageScript, stageCallback} {
+		h, ok := s.stages[stage]
+		if !ok {
+			h = newBootHistogram()
+		}
+		ch <- prometheus.MustNewConstHistogram(
+			bootStageDuration, h.count, h.sum, h.buckets, stage)
+	}
+}
+
+// bootKey is the normalized MAC address of a host.
+func bootKey(mac net.HardwareAddr) string {
+	return strings.ToLower(mac.String())
+}
@@ -0,0 +1,87 @@
+package metric
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/metal3-community/metal-boot/internal/events"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestBootSLO(t *testing.T) {
+	now := time.Unix(1000, 0)
+	s := &BootSLO{
+		Window:         time.Minute,
+		Timeout:        time.Hour,
+		ExpectCallback: true,
+		RatioWindow:    2 * time.Hour,
+	}
+	s.now = func() time.Time { return now }
+
+	// macA chainloads iPXE, which sends its own DISCOVER, and phones home.
+	s.Discover(macA)
+	now = now.Add(20 * time.Second)
+	s.Discover(macA)
+	now = now.Add(5 * time.Second)
+	s.ScriptFetched(macA)
+	now = now.Add(10 * time.Minute)
+	s.HandleEvent(events.Event{Type: events.ProvisioningComplete, MAC: macA.String()})
+
+	// macB never fetches its script and retries, then times out after it.
+	s.Discover(macB)
+	now = now.Add(2 * time.Minute)
+	s.Discover(macB)
+	s.ScriptFetched(macB)
+	now = now.Add(time.Hour)
+
+	want := `
+# HELP boot_attempts_total Number of finished netboot attempts by outcome (success, no_script or no_callback).
+# TYPE boot_attempts_total counter
+boot_attempts_total{outcome="no_callback"} 1
+boot_attempts_total{outcome="no_script"} 1
+boot_attempts_total{outcome="success"} 1
+# HELP boot_attempts_in_progress Number of netboot attempts that have not finished yet.
+# TYPE boot_attempts_in_progress gauge
+boot_attempts_in_progress 0
+# HELP boot_success_ratio Share of the netboot attempts finished within the ratio window that succeeded.
+# TYPE boot_success_ratio gauge
+boot_success_ratio 0.3333333333333333
+`
+	if err := testutil.CollectAndCompare(s, strings.NewReader(want),
+		"boot_attempts_total", "boot_attempts_in_progress", "boot_success_ratio"); err != nil {
+		t.Error(err)
+	}
+
+	if n := testutil.CollectAndCount(s, "host_boot_attempts_total"); n != 3 {
+		t.Errorf("collected %d host_boot_attempts_total series, want 3", n)
+	}
+	s.mu.Lock()
+	script, callback := s.stages[stageScript], s.stages[stageCallback]
+	s.mu.Unlock()
+	if script.count != 2 || script.sum != 25 || callback.count != 1 || callback.sum != 625 {
+		t.Errorf("stages script = %+v, callback = %+v", script, callback)
+	}
+}
+
+func TestBootSLOWithoutCallback(t *testing.T) {
+	var s *BootSLO
+	s.Discover(macA)
+
+	s = &BootSLO{}
+	s.Discover(macA)
+	s.ScriptFetched(macA)
+	s.Callback(macA)
+
+	want := `
+# HELP boot_attempts_total Number of finished netboot attempts by outcome (success, no_script or no_callback).
+# TYPE boot_attempts_total counter
+boot_attempts_total{outcome="no_callback"} 0
+boot_attempts_total{outcome="no_script"} 0
+boot_attempts_total{outcome="success"} 1
+`
+	if err := testutil.CollectAndCompare(s, strings.NewReader(want),
+		"boot_attempts_total"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,236 @@
+package metric
+
+import (
+	"sync"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/metal3-community/metal-boot/internal/backend"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	dhcpReplies = prometheus.NewDesc(
+		"dhcp_replies_total",
+		"Number of DHCP replies sent by type (offer, ack or nak).",
+		[]string{"type"}, nil,
+	)
+	dhcpUnknownDiscovers = prometheus.NewDesc(
+		"dhcp_unknown_discovers_total",
+		"Number of DHCPDISCOVERs from clients without a reservation.",
+		nil, nil,
+	)
+	dhcpActiveLeases = prometheus.NewDesc(
+		"dhcp_active_leases",
+		"Number of unexpired DHCP leases.",
+		nil, nil,
+	)
+	dhcpPoolSize = prometheus.NewDesc(
+		"dhcp_pool_size",
+		"Number of addresses in the dynamic DHCP pool.",
+		nil, nil,
+	)
+	dhcpPoolUtilization = prometheus.NewDesc(
+		"dhcp_pool_utilization_percent",
+		"Percentage of the dynamic DHCP pool that is leased or reserved.",
+		nil, nil,
+	)
+)
+
+// DHCP events counted by DHCPStats.
+const (
+	dhcpOffer = iota
+	dhcpAck
+	dhcpNak
+	dhcpUnknownDiscover
+	dhcpEvents
+)
+
+// leasePool is implemented by backends that hand out leases from a pool.
+type leasePool interface {
+	// ActiveLeases returns the number of unexpired leases.
+	ActiveLeases() int
+	// PoolUsage returns how many addresses of the pool are in use and the
+	// size of the pool, which is 0 without a pool.
+	PoolUsage() (used, size int)
+}
+
+// DHCPStats counts the replies of the DHCP server. It is a
+// prometheus.Collector, and Snapshot reports the same figures as rates over
+// the last minute for dashboards. The zero value and nil are ready to use;
+// nil records nothing.
+type DHCPStats struct {
+	pool leasePool
+
+	mu     sync.Mutex
+	totals [dhcpEvents]uint64
+	// recent counts events per second of the last minute, in a ring
+	// indexed by the unix second.
+	recent [60][dhcpEvents]uint64
+	stamps [60]int64
+
+	// now overrides time.Now in tests.
+	now func() time.Time
+}
+
+// NewDHCPStats returns DHCPStats that report the leases and pool usage of r
+// or of a backend it wraps, if it has a pool.
+func NewDHCPStats(r backend.BackendReader) *DHCPStats {
+	return &DHCPStats{pool: leasePoolOf(r)}
+}
+
+// RecordReply records a reply sent to a client. Types other than offers,
+// acks and naks are ignored.
+func (s *DHCPStats) RecordReply(mt dhcpv4.MessageType) {
+	switch mt {
+	case dhcpv4.MessageTypeOffer:
+		s.record(dhcpOffer)
+	case dhcpv4.MessageTypeAck:
+		s.record(dhcpAck)
+	case dhcpv4.MessageTypeNak:
+		s.record(dhcpNak)
+	}
+}
+
+// RecordUnknownDiscover records a DHCPDISCOVER from a client without a
+// reservation.
+func (s *DHCPStats) RecordUnknownDiscover() {
+	s.record(dhcpUnknownDiscover)
+}
+
+func (s *DHCPStats) record(event int) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sec := s.time().Unix()
+	slot := sec % int64(len(s.stamps))
+	if s.stamps[slot] != sec {
+		s.stamps[slot] = sec
+		s.recent[slot] = [dhcpEvents]uint64{}
+	}
+	s.recent[slot][event]++
+	s.totals[event]++
+}
+
+func (s *DHCPStats) time() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+
+	return time.Now()
+}
+
+// DHCPSnapshot is the state of the DHCP server at one point in time.
+type DHCPSnapshot struct {
+	OffersPerMinute           uint64 `json:"offersPerMinute"`
+	AcksPerMinute             uint64 `json:"acksPerMinute"`
+	NaksPerMinute             uint64 `json:"naksPerMinute"`
+	UnknownDiscoversPerMinute uint64 `json:"unknownDiscoversPerMinute"`
+
+	OffersTotal           uint64 `json:"offersTotal"`
+	AcksTotal             uint64 `json:"acksTotal"`
+	NaksTotal             uint64 `json:"naksTotal"`
+	UnknownDiscoversTotal uint64 `json:"unknownDiscoversTotal"`
+
+	// ActiveLeases, PoolSize and PoolUtilization are omitted when the
+	// backend does not hand out leases from a pool.
+	ActiveLeases    *int     `json:"activeLeases,omitempty"`
+	PoolSize        *int     `json:"poolSize,omitempty"`
+	PoolUtilization *float64 `json:"poolUtilizationPercent,omitempty"`
+}
+
+// Snapshot returns the current statistics.
+func (s *DHCPStats) Snapshot() DHCPSnapshot {
+	if s == nil {
+		return DHCPSnapshot{}
+	}
+
+	s.mu.Lock()
+	var minute [dhcpEvents]uint64
+	now := s.time().Unix()
+	for slot, stamp := range s.stamps {
+		if now-stamp >= int64(len(s.stamps)) {
+			continue
+		}
+		for event, n := range s.recent[slot] {
+			minute[event] += n
+		}
+	}
+	totals := s.totals
+	s.mu.Unlock()
+
+	snap := DHCPSnapshot{
+		OffersPerMinute:           minute[dhcpOffer],
+		AcksPerMinute:             minute[dhcpAck],
+		NaksPerMinute:             minute[dhcpNak],
+		UnknownDiscoversPerMinute: minute[dhcpUnknownDiscover],
+		OffersTotal:               totals[dhcpOffer],
+		AcksTotal:                 totals[dhcpAck],
+		NaksTotal:                 totals[dhcpNak],
+		UnknownDiscoversTotal:     totals[dhcpUnknownDiscover],
+	}
+	if s.pool != nil {
+		active := s.pool.ActiveLeases()
+		snap.ActiveLeases = &active
+		if used, size := s.pool.PoolUsage(); size > 0 {
+			utilization := float64(used) * 100 / float64(size)
+			snap.PoolSize = &size
+			snap.PoolUtilization = &utilization
+		}
+	}
+
+	return snap
+}
+
+// Describe implements prometheus.Collector.
+func (s *DHCPStats) Describe(ch chan<- *prometheus.Desc) {
+	ch <- dhcpReplies
+	ch <- dhcpUnknownDiscovers
+	ch <- dhcpActiveLeases
+	ch <- dhcpPoolSize
+	ch <- dhcpPoolUtilization
+}
+
+// Collect implements prometheus.Collector.
+func (s *DHCPStats) Collect(ch chan<- prometheus.Metric) {
+	snap := s.Snapshot()
+
+	ch <- prometheus.MustNewConstMetric(
+		dhcpReplies, prometheus.CounterValue, float64(snap.OffersTotal), "offer")
+	ch <- prometheus.MustNewConstMetric(
+		dhcpReplies, prometheus.CounterValue, float64(snap.AcksTotal), "ack")
+	ch <- prometheus.MustNewConstMetric(
+		dhcpReplies, prometheus.CounterValue, float64(snap.NaksTotal), "nak")
+	ch <- prometheus.MustNewConstMetric(
+		dhcpUnknownDiscovers, prometheus.CounterValue, float64(snap.UnknownDiscoversTotal))
+	if snap.ActiveLeases != nil {
+		ch <- prometheus.MustNewConstMetric(
+			dhcpActiveLeases, prometheus.GaugeValue, float64(*snap.ActiveLeases))
+	}
+	if snap.PoolSize != nil {
+		ch <- prometheus.MustNewConstMetric(
+			dhcpPoolSize, prometheus.GaugeValue, float64(*snap.PoolSize))
+		ch <- prometheus.MustNewConstMetric(
+			dhcpPoolUtilization, prometheus.GaugeValue, *snap.PoolUtilization)
+	}
+}
+
+// leasePoolOf returns the lease pool of r or of a backend it wraps.
+func leasePoolOf(r backend.BackendReader) leasePool {
+	for r != nil {
+		if p, ok := r.(leasePool); ok {
+			return p
+		}
+		u, ok := r.(interface{ Unwrap() backend.BackendReader })
+		if !ok {
+			return nil
+		}
+		r = u.Unwrap()
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package metric
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakePool is a backend with a lease pool.
+type fakePool struct{ fakeBackend }
+
+func (fakePool) ActiveLeases() int { return 3 }
+
+func (fakePool) PoolUsage() (used, size int) { return 2, 8 }
+
+func TestDHCPStats(t *testing.T) {
+	now := time.Unix(1000, 0)
+	s := NewDHCPStats(wrapped{next: fakePool{}})
+	s.now = func() time.Time { return now }
+
+	s.RecordReply(dhcpv4.MessageTypeOffer)
+	s.RecordReply(dhcpv4.MessageTypeAck)
+	s.RecordReply(dhcpv4.MessageTypeInform)
+	now = now.Add(59 * time.Second)
+	s.RecordReply(dhcpv4.MessageTypeOffer)
+	s.RecordReply(dhcpv4.MessageTypeNak)
+	s.RecordUnknownDiscover()
+
+	snap := s.Snapshot()
+	if snap.OffersPerMinute != 2 || snap.AcksPerMinute != 1 || snap.NaksPerMinute != 1 ||
+		snap.UnknownDiscoversPerMinute != 1 {
+		t.Errorf("Snapshot() per minute = %+v", snap)
+	}
+	if *snap.ActiveLeases != 3 || *snap.PoolSize != 8 || *snap.PoolUtilization != 25 {
+		t.Errorf("Snapshot() leases = %d, pool = %d at %v%%",
+			*snap.ActiveLeases, *snap.PoolSize, *snap.PoolUtilization)
+	}
+
+	// The first replies drop out of the window, the totals keep them.
+	now = now.Add(2 * time.Second)
+	snap = s.Snapshot()
+	if snap.OffersPerMinute != 1 || snap.AcksPerMinute != 0 || snap.OffersTotal != 2 {
+		t.Errorf("Snapshot() a minute later = %+v", snap)
+	}
+
+	want := `
+# HELP dhcp_replies_total Number of DHCP replies sent by type (offer, ack or nak).
+# TYPE dhcp_replies_total counter
+dhcp_replies_total{type="ack"} 1
+dhcp_replies_total{type="nak"} 1
+dhcp_replies_total{type="offer"} 2
+# HELP dhcp_pool_utilization_percent Percentage of the dynamic DHCP pool that is leased or reserved.
+# TYPE dhcp_pool_utilization_percent gauge
+dhcp_pool_utilization_percent 25
+`
+	if err := testutil.CollectAndCompare(s, strings.NewReader(want),
+		"dhcp_replies_total", "dhcp_pool_utilization_percent"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDHCPStatsWithoutPool(t *testing.T) {
+	var s *DHCPStats
+	s.RecordReply(dhcpv4.MessageTypeOffer)
+
+	s = NewDHCPStats(fakeBackend{})
+	snap := s.Snapshot()
+	if snap.ActiveLeases != nil || snap.PoolSize != nil {
+		t.Errorf("Snapshot() without a pool = %+v", snap)
+	}
+	if n := testutil.CollectAndCount(s, "dhcp_pool_size", "dhcp_active_leases"); n != 0 {
+		t.Errorf("collected %d pool series without a pool, want 0", n)
+	}
+}
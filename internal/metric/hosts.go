@@ -0,0 +1,134 @@
+package metric
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/metal3-community/metal-boot/internal/backend"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	hostNetbootEnabled = prometheus.NewDesc(
+		"netboot_enabled",
+		"Whether the host is allowed to netboot (1) or not (0).",
+		[]string{"mac", "hostname"}, nil,
+	)
+	hostPowerState = prometheus.NewDesc(
+		"power_state",
+		"Power state of the host: 0 off, 1 on, 2 powering off, 3 powering on.",
+		[]string{"mac", "hostname"}, nil,
+	)
+	hostLeaseExpiry = prometheus.NewDesc(
+		"lease_expiry_seconds",
+		"Seconds until the DHCP lease of the host expires; negative once it has expired.",
+		[]string{"mac", "hostname"}, nil,
+	)
+	hostScrapeErrors = prometheus.NewDesc(
+		"host_scrape_errors",
+		"Number of backend lookups that failed while collecting host metrics.",
+		nil, nil,
+	)
+)
+
+// leaseExpirer is implemented by backends that track lease expiry.
+type leaseExpirer interface {
+	LeaseExpiry(mac net.HardwareAddr) (time.Time, bool)
+}
+
+// HostCollector exports per-host gauges for every host of a backend. Values
+// are read from the backends on each scrape.
+type HostCollector struct {
+	Reader backend.BackendReader
+	// Power is queried for power states. Nil omits power_state.
+	Power backend.BackendPower
+	// Timeout bounds the backend lookups of one scrape.
+	Timeout time.Duration
+}
+
+// Describe implements prometheus.Collector.
+func (c *HostCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- hostNetbootEnabled
+	ch <- hostPowerState
+	ch <- hostLeaseExpiry
+	ch <- hostScrapeErrors
+}
+
+// Collect implements prometheus.Collector.
+func (c *HostCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx := context.Background()
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	failed := 0
+	defer func() {
+		ch <- prometheus.MustNewConstMetric(
+			hostScrapeErrors, prometheus.GaugeValue, float64(failed))
+	}()
+
+	macs, err := c.Reader.GetKeys(ctx)
+	if err != nil {
+		failed++
+		return
+	}
+	expirer := leaseExpirerOf(c.Reader)
+
+	for _, mac := range macs {
+		d, n, err := c.Reader.GetByMac(ctx, mac)
+		if err != nil {
+			failed++
+			continue
+		}
+		labels := []string{mac.String(), ""}
+		if d != nil {
+			labels[1] = d.Hostname
+		}
+
+		if n != nil {
+			ch <- prometheus.MustNewConstMetric(
+				hostNetbootEnabled, prometheus.GaugeValue, boolValue(n.AllowNetboot), labels...)
+		}
+		if expirer != nil {
+			if expiry, ok := expirer.LeaseExpiry(mac); ok {
+				ch <- prometheus.MustNewConstMetric(
+					hostLeaseExpiry, prometheus.GaugeValue, time.Until(expiry).Seconds(), labels...)
+			}
+		}
+		if c.Power != nil {
+			state, err := c.Power.GetPower(ctx, mac)
+			if err != nil || state == nil {
+				failed++
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(
+				hostPowerState, prometheus.GaugeValue, float64(*state), labels...)
+		}
+	}
+}
+
+// leaseExpirerOf returns the lease expiry of r or of a backend it wraps.
+func leaseExpirerOf(r backend.BackendReader) leaseExpirer {
+	for r != nil {
+		if e, ok := r.(leaseExpirer); ok {
+			return e
+		}
+		u, ok := r.(interface{ Unwrap() backend.BackendReader })
+		if !ok {
+			return nil
+		}
+		r = u.Unwrap()
+	}
+
+	return nil
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
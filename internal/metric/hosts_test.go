@@ -0,0 +1,91 @@
+package metric
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/metal3-community/metal-boot/internal/backend"
+	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var (
+	macA = net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0x01}
+	macB = net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0x02}
+)
+
+type fakeBackend struct{}
+
+func (fakeBackend) GetByMac(_ context.Context, mac net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+	if mac.String() == macB.String() {
+		return &data.DHCP{Hostname: "node-b"}, &data.Netboot{}, nil
+	}
+	return &data.DHCP{Hostname: "node-a"}, &data.Netboot{AllowNetboot: true}, nil
+}
+
+func (fakeBackend) GetByIP(context.Context, net.IP) (*data.DHCP, *data.Netboot, error) {
+	return nil, nil, errors.New("not implemented")
+}
+
+func (fakeBackend) GetKeys(context.Context) ([]net.HardwareAddr, error) {
+	return []net.HardwareAddr{macA, macB}, nil
+}
+
+func (fakeBackend) LeaseExpiry(mac net.HardwareAddr) (time.Time, bool) {
+	return time.Now().Add(time.Hour), mac.String() == macA.String()
+}
+
+func (fakeBackend) GetPower(_ context.Context, mac net.HardwareAddr) (*data.PowerState, error) {
+	if mac.String() == macB.String() {
+		return nil, errors.New("switch unreachable")
+	}
+	state := data.PowerOn
+	return &state, nil
+}
+
+func (fakeBackend) SetPower(context.Context, net.HardwareAddr, data.PowerState) error { return nil }
+
+func (fakeBackend) PowerCycle(context.Context, net.HardwareAddr) error { return nil }
+
+// wrapped hides the lease expiry of its backend behind Unwrap.
+type wrapped struct{ next backend.BackendReader }
+
+func (w wrapped) GetByMac(ctx context.Context, mac net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+	return w.next.GetByMac(ctx, mac)
+}
+
+func (w wrapped) GetByIP(ctx context.Context, ip net.IP) (*data.DHCP, *data.Netboot, error) {
+	return w.next.GetByIP(ctx, ip)
+}
+
+func (w wrapped) GetKeys(ctx context.Context) ([]net.HardwareAddr, error) { return w.next.GetKeys(ctx) }
+
+func (w wrapped) Unwrap() backend.BackendReader { return w.next }
+
+func TestHostCollector(t *testing.T) {
+	c := &HostCollector{Reader: wrapped{next: fakeBackend{}}, Power: fakeBackend{}}
+
+	want := `
+# HELP netboot_enabled Whether the host is allowed to netboot (1) or not (0).
+# TYPE netboot_enabled gauge
+netboot_enabled{hostname="node-a",mac="aa:bb:cc:dd:ee:01"} 1
+netboot_enabled{hostname="node-b",mac="aa:bb:cc:dd:ee:02"} 0
+# HELP power_state Power state of the host: 0 off, 1 on, 2 powering off, 3 powering on.
+# TYPE power_state gauge
+power_state{hostname="node-a",mac="aa:bb:cc:dd:ee:01"} 1
+# HELP host_scrape_errors Number of backend lookups that failed while collecting host metrics.
+# TYPE host_scrape_errors gauge
+host_scrape_errors 1
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want),
+		"netboot_enabled", "power_state", "host_scrape_errors"); err != nil {
+		t.Error(err)
+	}
+	if n := testutil.CollectAndCount(c, "lease_expiry_seconds"); n != 1 {
+		t.Errorf("collected %d lease_expiry_seconds series, want 1", n)
+	}
+}
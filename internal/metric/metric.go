@@ -17,6 +17,94 @@ var (
 	JobsInProgress *prometheus.GaugeVec
 )
 
+// BootAttemptsExhausted counts hosts put into boot fallback after too many
+// consecutive failed netboot attempts. It is registered at package load so
+// it is usable without Init.
+var BootAttemptsExhausted = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "boot_attempts_exhausted_total",
+	Help: "Number of hosts that exhausted their netboot attempts and were put into fallback.",
+}, []string{"fallback"})
+
+// Image cache metrics are maintained by the imagecache janitor and the
+// handlers serving cached artifacts. Like BootAttemptsExhausted they are
+// registered at package load.
+var (
+	ImageCacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "image_cache_requests_total",
+		Help: "Number of requests for cached boot artifacts by cache and result (hit or miss).",
+	}, []string{"cache", "result"})
+	ImageCacheBytes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "image_cache_bytes",
+		Help: "Disk space used by cached boot artifacts per directory.",
+	}, []string{"dir"})
+	ImageCacheBudgetBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "image_cache_budget_bytes",
+		Help: "Configured disk budget for cached boot artifacts.",
+	})
+	ImageCacheEvictions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "image_cache_evictions_total",
+		Help: "Number of cached boot artifacts evicted to stay within the disk budget.",
+	}, []string{"dir"})
+	ImageCacheEvictedBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "image_cache_evicted_bytes_total",
+		Help: "Bytes freed by evicting cached boot artifacts.",
+	}, []string{"dir"})
+)
+
+// Stream limiter metrics describe concurrent large artifact downloads.
+var (
+	StreamsActive = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "streams_active",
+		Help: "Number of large artifact streams currently being served.",
+	})
+	StreamQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "stream_queue_depth",
+		Help: "Number of artifact requests waiting for a stream slot.",
+	})
+	StreamQueueWait = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "stream_queue_wait_seconds",
+		Help:    "Time artifact requests waited for a stream slot.",
+		Buckets: prometheus.ExponentialBuckets(.01, 4, 8),
+	})
+	StreamRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "stream_rejected_total",
+		Help: "Number of artifact requests rejected by the stream limiter by reason.",
+	}, []string{"reason"})
+)
+
+// Admission metrics describe the staggering of netboot offers during boot
+// storms.
+var (
+	AdmissionAdmitted = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "netboot_admission_admitted_total",
+		Help: "Number of netboot offers admitted by the boot storm admission controller.",
+	})
+	AdmissionDeferred = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "netboot_admission_deferred_total",
+		Help: "Number of netboot DHCPDISCOVERs left unanswered to be retried during a boot storm.",
+	})
+	AdmissionDelay = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "netboot_admission_delay_seconds",
+		Help:    "Time admitted netboot offers were held back.",
+		Buckets: prometheus.ExponentialBuckets(.01, 4, 8),
+	})
+)
+
+// RogueDHCPMessages counts the messages of DHCP servers other than metal-boot
+// seen on the provisioning network.
+var RogueDHCPMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "dhcp_rogue_server_messages_total",
+	Help: "Number of messages from or to DHCP servers other than metal-boot by server and type.",
+}, []string{"server", "type"})
+
+// IronicProxyRequests counts the GET requests of the Ironic API proxy by how
+// they were answered: from the cache (hit), by a request already in flight
+// (coalesced) or by Ironic (miss).
+var IronicProxyRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "ironic_proxy_requests_total",
+	Help: "Number of read requests of the Ironic API proxy by result (hit, coalesced or miss).",
+}, []string{"result"})
+
 func Init() {
 	DHCPTotal = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "dhcp_total",
@@ -0,0 +1,90 @@
+package metric
+
+import (
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// DHCP handlers, as the handler label of the DHCP metrics.
+const (
+	DHCPHandlerReservation = "reservation"
+	DHCPHandlerProxy       = "proxy"
+)
+
+// Directions of DHCP packets.
+const (
+	DirectionReceived = "recv"
+	DirectionSent     = "sent"
+)
+
+// Serving metrics describe the packets and requests the DHCP and TFTP
+// servers and the iPXE script handler answer. Like the metrics above they
+// are registered at package load.
+var (
+	DHCPPackets = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dhcp_packets_total",
+		Help: "Number of DHCPv4 packets by handler (reservation or proxy), direction (recv or sent) and message type.",
+	}, []string{"handler", "direction", "type"})
+	DHCPOffers = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dhcp_offers_total",
+		Help: "Number of DHCPOFFERs sent by handler and client architecture (option 93).",
+	}, []string{"handler", "arch"})
+	DHCPHandleDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "dhcp_handle_duration_seconds",
+		Help:    "Time taken to answer DHCPv4 packets by handler and message type.",
+		Buckets: prometheus.ExponentialBuckets(.0005, 4, 8),
+	}, []string{"handler", "type"})
+
+	TFTPTransfers = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tftp_transfers_total",
+		Help: "Number of TFTP read transfers by result (success or failure).",
+	}, []string{"result"})
+	TFTPTransferBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "tftp_transfer_bytes_total",
+		Help: "Bytes sent by TFTP read transfers.",
+	})
+	TFTPTransferDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "tftp_transfer_duration_seconds",
+		Help:    "Duration of TFTP read transfers by result.",
+		Buckets: prometheus.ExponentialBuckets(.01, 4, 8),
+	}, []string{"result"})
+
+	IPXEScriptRenders = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipxe_script_renders_total",
+		Help: "Number of iPXE scripts served by MAC address and boot profile.",
+	}, []string{"mac", "profile"})
+	IPXEScriptRenderDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "ipxe_script_render_duration_seconds",
+		Help:    "Time taken to render iPXE scripts.",
+		Buckets: prometheus.ExponentialBuckets(.0005, 4, 8),
+	})
+)
+
+// RecordDHCPPacket counts a DHCPv4 packet of handler.
+func RecordDHCPPacket(handler, direction string, mt dhcpv4.MessageType) {
+	DHCPPackets.WithLabelValues(handler, direction, mt.String()).Inc()
+}
+
+// RecordDHCPOffer counts an offer of handler in reply to pkt.
+func RecordDHCPOffer(handler string, pkt *dhcpv4.DHCPv4) {
+	DHCPOffers.WithLabelValues(handler, clientArch(pkt)).Inc()
+}
+
+// clientArch names the first architecture of option 93 of pkt, or "none".
+func clientArch(pkt *dhcpv4.DHCPv4) string {
+	if archs := pkt.ClientArch(); len(archs) > 0 {
+		return iana.Arch(archs[0]).String()
+	}
+
+	return "none"
+}
+
+// ObserveDHCPHandle records the time handler took to answer a packet of type
+// mt since start.
+func ObserveDHCPHandle(handler string, mt dhcpv4.MessageType, start time.Time) {
+	DHCPHandleDuration.WithLabelValues(handler, mt.String()).Observe(time.Since(start).Seconds())
+}
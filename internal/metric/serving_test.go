@@ -0,0 +1,34 @@
+package metric
+
+import (
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRecordDHCP(t *testing.T) {
+	discover, err := dhcpv4.NewDiscovery(macA, dhcpv4.WithOption(dhcpv4.OptClientArch(iana.EFI_ARM64)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := dhcpv4.NewDiscovery(macB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	RecordDHCPPacket(DHCPHandlerProxy, DirectionReceived, discover.MessageType())
+	RecordDHCPOffer(DHCPHandlerProxy, discover)
+	RecordDHCPOffer(DHCPHandlerProxy, plain)
+
+	if got := testutil.ToFloat64(DHCPPackets.WithLabelValues(DHCPHandlerProxy, DirectionReceived, "DISCOVER")); got != 1 {
+		t.Errorf("received discovers = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(DHCPOffers.WithLabelValues(DHCPHandlerProxy, iana.EFI_ARM64.String())); got != 1 {
+		t.Errorf("arm64 offers = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(DHCPOffers.WithLabelValues(DHCPHandlerProxy, "none")); got != 1 {
+		t.Errorf("offers without arch = %v, want 1", got)
+	}
+}
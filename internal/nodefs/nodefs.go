@@ -0,0 +1,82 @@
+// Package nodefs gives every host a directory of its own below the TFTP and
+// HTTP file roots, nodes/<mac>, whose files replace the shared files of the
+// same name for that host only. A host asks for config.txt and is served
+// nodes/aa-bb-cc-dd-ee-ff/config.txt when it exists, and the shared
+// config.txt otherwise.
+//
+// The directories of hosts are private: a request naming the directory of
+// another host, below nodes/ or at the top of the root as the per-host
+// firmware directories are, is refused, so that a custom DTB, config.txt or
+// iPXE script is never served to the wrong host.
+package nodefs
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Dir is the directory below a root holding the directories of hosts.
+const Dir = "nodes"
+
+// ErrForeign is returned for a request of a file in the directory of another
+// host, or in the directory of any host from a client that is not known.
+var ErrForeign = errors.New("path belongs to another host")
+
+// HostDir returns the slash separated directory of mac below a root.
+func HostDir(mac net.HardwareAddr) string {
+	return path.Join(Dir, dirName(mac))
+}
+
+// dirName is the name of the directory of mac, its address with dashes, as
+// used for the per-host firmware directories.
+func dirName(mac net.HardwareAddr) string {
+	return strings.ReplaceAll(strings.ToLower(mac.String()), ":", "-")
+}
+
+// Resolve returns the slash separated path below root that the request of
+// the host mac for name is served from: the file of the same name in the
+// directory of the host if it has one, or name itself. mac is nil for a
+// client that is not known, which is only served shared files.
+func Resolve(root string, mac net.HardwareAddr, name string) (string, error) {
+	name = strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(name)), "/")
+	if err := checkOwner(mac, name); err != nil {
+		return "", err
+	}
+	if mac == nil || name == "" || strings.HasPrefix(name, Dir+"/") {
+		return name, nil
+	}
+
+	own := path.Join(HostDir(mac), name)
+	if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(own))); err == nil {
+		return own, nil
+	}
+
+	return name, nil
+}
+
+// checkOwner returns ErrForeign if name is in the directory of a host other
+// than mac.
+func checkOwner(mac net.HardwareAddr, name string) error {
+	first, rest, _ := strings.Cut(name, "/")
+	owner := first
+	if first == Dir {
+		if rest == "" {
+			return fmt.Errorf("%w: %s", ErrForeign, name)
+		}
+		owner, _, _ = strings.Cut(rest, "/")
+	} else if _, err := net.ParseMAC(first); err != nil || rest == "" {
+		// Only directories named after a MAC belong to a host.
+		return nil
+	}
+
+	if mac == nil || owner != dirName(mac) {
+		return fmt.Errorf("%w: %s", ErrForeign, name)
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package nodefs
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{
+		"config.txt",
+		"nodes/aa-bb-cc-dd-ee-01/config.txt",
+		"nodes/aa-bb-cc-dd-ee-01/overlays/custom.dtbo",
+		"aa-bb-cc-dd-ee-01/RPI_EFI.fd",
+	} {
+		full := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	host, _ := net.ParseMAC("aa:bb:cc:dd:ee:01")
+	other, _ := net.ParseMAC("aa:bb:cc:dd:ee:02")
+
+	tests := []struct {
+		name    string
+		mac     net.HardwareAddr
+		path    string
+		want    string
+		foreign bool
+	}{
+		{name: "override", mac: host, path: "config.txt", want: "nodes/aa-bb-cc-dd-ee-01/config.txt"},
+		{name: "override in subdirectory", mac: host, path: "/overlays/custom.dtbo", want: "nodes/aa-bb-cc-dd-ee-01/overlays/custom.dtbo"},
+		{name: "shared fallback", mac: other, path: "config.txt", want: "config.txt"},
+		{name: "missing", mac: host, path: "start4.elf", want: "start4.elf"},
+		{name: "unknown client", path: "config.txt", want: "config.txt"},
+		{name: "own directory", mac: host, path: "nodes/aa-bb-cc-dd-ee-01/config.txt", want: "nodes/aa-bb-cc-dd-ee-01/config.txt"},
+		{name: "own firmware directory", mac: host, path: "aa-bb-cc-dd-ee-01/RPI_EFI.fd", want: "aa-bb-cc-dd-ee-01/RPI_EFI.fd"},
+		{name: "other directory", mac: other, path: "nodes/aa-bb-cc-dd-ee-01/config.txt", foreign: true},
+		{name: "other firmware directory", mac: other, path: "aa-bb-cc-dd-ee-01/RPI_EFI.fd", foreign: true},
+		{name: "traversal", mac: other, path: "../nodes/./aa-bb-cc-dd-ee-01/config.txt", foreign: true},
+		{name: "unknown client in host directory", path: "nodes/aa-bb-cc-dd-ee-01/config.txt", foreign: true},
+		{name: "listing", mac: host, path: "nodes/", foreign: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Resolve(root, tt.mac, tt.path)
+			if tt.foreign {
+				if !errors.Is(err, ErrForeign) {
+					t.Errorf("Resolve() = %q, %v, want ErrForeign", got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("Resolve() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
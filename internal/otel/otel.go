@@ -104,6 +104,9 @@ type Config struct {
 	Servicename string `json:"service_name"`
 	Endpoint    string `json:"endpoint"`
 	Insecure    bool   `json:"insecure"`
+	// SampleRatio is the share of new traces that are recorded. Values
+	// outside (0, 1) record every trace.
+	SampleRatio float64 `json:"sample_ratio"`
 	Logger      logr.Logger
 }
 
@@ -167,6 +170,7 @@ func (c Config) initTracing(ctx context.Context) (context.Context, context.Cance
 	tracerProvider := sdktrace.NewTracerProvider(
 		sdktrace.WithResource(res),
 		sdktrace.WithSpanProcessor(bsp),
+		sdktrace.WithSampler(c.sampler()),
 	)
 
 	// set global propagator to tracecontext (the default is no-op).
@@ -203,6 +207,17 @@ func (c Config) initTracing(ctx context.Context) (context.Context, context.Cance
 	}, nil
 }
 
+// sampler records SampleRatio of the traces started here and follows the
+// decision of the caller for the others.
+func (c Config) sampler() sdktrace.Sampler {
+	root := sdktrace.AlwaysSample()
+	if c.SampleRatio > 0 && c.SampleRatio < 1 {
+		root = sdktrace.TraceIDRatioBased(c.SampleRatio)
+	}
+
+	return sdktrace.ParentBased(root)
+}
+
 func (c Config) Handle(err error) {
 	if err != nil {
 		c.Logger.Info("OpenTelemetry error", "err", err)
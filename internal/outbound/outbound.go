@@ -0,0 +1,90 @@
+// Package outbound configures the HTTP transport of requests metal-boot makes
+// to other hosts: Talos image factory pulls, ISO sources, image downloads and
+// self updates.
+//
+// The proxy comes from the configuration or, when none is configured, from
+// the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables. A CA bundle
+// adds the certificates of TLS intercepting proxies and private mirrors to
+// the system roots.
+package outbound
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// Config selects the proxy and trusted certificates of outbound requests.
+type Config struct {
+	// ProxyURL is used for http and https requests. Empty falls back to the
+	// environment.
+	ProxyURL string
+	// NoProxy lists hosts, domains and CIDRs reached directly, in the format
+	// of NO_PROXY. Empty uses NO_PROXY from the environment.
+	NoProxy string
+	// CABundle is a PEM file of certificates trusted besides the system
+	// roots.
+	CABundle string
+}
+
+// Transport returns a clone of http.DefaultTransport configured by c.
+func Transport(c Config) (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+
+	if c.ProxyURL != "" {
+		u, err := url.Parse(c.ProxyURL)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %q", c.ProxyURL)
+		}
+		noProxy := c.NoProxy
+		if noProxy == "" {
+			noProxy = httpproxy.FromEnvironment().NoProxy
+		}
+		proxy := (&httpproxy.Config{
+			HTTPProxy:  c.ProxyURL,
+			HTTPSProxy: c.ProxyURL,
+			NoProxy:    noProxy,
+		}).ProxyFunc()
+		t.Proxy = func(r *http.Request) (*url.URL, error) {
+			return proxy(r.URL)
+		}
+	} else {
+		t.Proxy = http.ProxyFromEnvironment
+	}
+
+	if c.CABundle != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := os.ReadFile(c.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates in CA bundle " + c.CABundle)
+		}
+		t.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return t, nil
+}
+
+// Install makes the transport of c the default one, so that every client
+// that does not set its own transport, including those of libraries such as
+// the Talos image factory client, goes through the proxy.
+func Install(c Config) error {
+	t, err := Transport(c)
+	if err != nil {
+		return err
+	}
+	http.DefaultTransport = t
+
+	return nil
+}
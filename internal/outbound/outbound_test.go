@@ -0,0 +1,63 @@
+package outbound
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTransportProxy(t *testing.T) {
+	t.Setenv("HTTP_PROXY", "http://env-proxy:3128")
+	t.Setenv("HTTPS_PROXY", "http://env-proxy:3128")
+	t.Setenv("NO_PROXY", "env.internal")
+
+	tests := []struct {
+		name   string
+		config Config
+		url    string
+		want   string
+	}{
+		{"config", Config{ProxyURL: "http://proxy:8080"}, "https://factory.talos.dev/x", "http://proxy:8080"},
+		{"config no_proxy", Config{ProxyURL: "http://proxy:8080", NoProxy: ".example.com"}, "http://mirror.example.com/a.iso", ""},
+		{"environment no_proxy", Config{ProxyURL: "http://proxy:8080"}, "http://env.internal/a.iso", ""},
+		{"environment", Config{}, "https://factory.talos.dev/x", "http://env-proxy:3128"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr, err := Transport(tt.config)
+			if err != nil {
+				t.Fatalf("Transport() error = %v", err)
+			}
+			req, _ := http.NewRequest(http.MethodGet, tt.url, nil)
+			u, err := tr.Proxy(req)
+			if err != nil {
+				t.Fatalf("Proxy() error = %v", err)
+			}
+			got := ""
+			if u != nil {
+				got = u.String()
+			}
+			if got != tt.want {
+				t.Errorf("Proxy(%s) = %q, want %q", tt.url, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransportErrors(t *testing.T) {
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(bundle, []byte("not a certificate"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []Config{
+		{ProxyURL: "proxy"},
+		{CABundle: bundle},
+		{CABundle: filepath.Join(t.TempDir(), "missing.pem")},
+	} {
+		if _, err := Transport(c); err == nil {
+			t.Errorf("Transport(%+v) error = nil", c)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+// Package preflight verifies at startup that metal-boot can do its job, so a
+// misconfigured host fails fast with one consolidated report instead of
+// failing lazily on the first DHCP, TFTP or HTTP request.
+package preflight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/metal3-community/metal-boot/internal/backend"
+)
+
+// DefaultTimeout bounds a single check when Run is given no timeout.
+const DefaultTimeout = 10 * time.Second
+
+// Check is a single startup check.
+type Check struct {
+	// Name identifies the check in the report, e.g. "tftp port".
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// Result is the outcome of a Check.
+type Result struct {
+	Name     string
+	Err      error
+	Duration time.Duration
+}
+
+// Report holds the results of all checks, in the order they ran.
+type Report []Result
+
+// Run runs every check, each bounded by timeout, and reports all results. A
+// failing check does not stop the others.
+func Run(ctx context.Context, timeout time.Duration, checks ...Check) Report {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	report := make(Report, 0, len(checks))
+	for _, c := range checks {
+		cctx, cancel := context.WithTimeout(ctx, timeout)
+		start := time.Now()
+		err := c.Run(cctx)
+		cancel()
+		report = append(report, Result{Name: c.Name, Err: err, Duration: time.Since(start)})
+	}
+
+	return report
+}
+
+// Failed returns the results of the checks that failed.
+func (r Report) Failed() []Result {
+	var failed []Result
+	for _, res := range r {
+		if res.Err != nil {
+			failed = append(failed, res)
+		}
+	}
+
+	return failed
+}
+
+// Err returns an error listing every failed check, or nil if all passed.
+func (r Report) Err() error {
+	failed := r.Failed()
+	if len(failed) == 0 {
+		return nil
+	}
+
+	lines := make([]string, 0, len(failed))
+	for _, res := range failed {
+		lines = append(lines, fmt.Sprintf("%s: %v", res.Name, res.Err))
+	}
+
+	return fmt.Errorf("%d of %d preflight checks failed:\n  %s",
+		len(failed), len(r), strings.Join(lines, "\n  "))
+}
+
+// UDPPort checks that addr, a host:port, can be bound for UDP.
+func UDPPort(name, addr string) Check {
+	return Check{Name: name, Run: func(context.Context) error {
+		conn, err := net.ListenPacket("udp4", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}}
+}
+
+// TCPPort checks that addr, a host:port, can be bound for TCP.
+func TCPPort(name, addr string) Check {
+	return Check{Name: name, Run: func(context.Context) error {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+		return ln.Close()
+	}}
+}
+
+// IPv6Listener checks that a listener on addr, a host:port, accepts IPv6
+// clients. The host must be an IPv6 address or unspecified; an unspecified
+// host such as 0.0.0.0 listens on both address families.
+func IPv6Listener(name, addr string) Check {
+	return Check{Name: name + " on ipv6", Run: func(context.Context) error {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return err
+		}
+		if host == "" {
+			return nil
+		}
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return fmt.Errorf("%s is not an IP address", host)
+		}
+		if ip.To4() != nil && !ip.IsUnspecified() {
+			return fmt.Errorf("bound to the IPv4 address %s only", host)
+		}
+		return nil
+	}}
+}
+
+// Broadcast checks that the network interface iface exists, is up and
+// supports broadcast, as DHCP replies to clients without an address require.
+func Broadcast(iface string) Check {
+	return Check{Name: "broadcast on " + iface, Run: func(context.Context) error {
+		ifi, err := net.InterfaceByName(iface)
+		if err != nil {
+			return err
+		}
+		if ifi.Flags&net.FlagUp == 0 {
+			return fmt.Errorf("interface %s is down", iface)
+		}
+		if ifi.Flags&net.FlagBroadcast == 0 {
+			return fmt.Errorf("interface %s does not support broadcast", iface)
+		}
+		return nil
+	}}
+}
+
+// Writable checks that dir exists, creating it if needed, and that files can
+// be created in it.
+func Writable(dir string) Check {
+	return Check{Name: "write access to " + dir, Run: func(context.Context) error {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+		f, err := os.CreateTemp(dir, ".metal-boot-preflight-")
+		if err != nil {
+			return err
+		}
+		return errors.Join(f.Close(), os.Remove(f.Name()))
+	}}
+}
+
+// Files checks that every one of names is present and non-empty in files.
+func Files(name string, files map[string][]byte, names ...string) Check {
+	return Check{Name: name, Run: func(context.Context) error {
+		var missing []string
+		for _, n := range names {
+			if len(files[n]) == 0 {
+				missing = append(missing, n)
+			}
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("missing %s", strings.Join(missing, ", "))
+		}
+		return nil
+	}}
+}
+
+// Backend checks that the hardware backend answers a listing of its hosts.
+func Backend(b backend.BackendReader) Check {
+	return Check{Name: "backend reachable", Run: func(ctx context.Context) error {
+		_, err := b.GetKeys(ctx)
+		return err
+	}}
+}
@@ -0,0 +1,117 @@
+package preflight
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunReportsEveryFailure(t *testing.T) {
+	errBoom := errors.New("boom")
+	report := Run(context.Background(), time.Second,
+		Check{Name: "first", Run: func(context.Context) error { return errBoom }},
+		Check{Name: "second", Run: func(context.Context) error { return nil }},
+		Check{Name: "third", Run: func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}},
+	)
+
+	if len(report) != 3 {
+		t.Fatalf("report has %d results, want 3", len(report))
+	}
+	failed := report.Failed()
+	if len(failed) != 2 || failed[0].Name != "first" || failed[1].Name != "third" {
+		t.Fatalf("Failed() = %+v, want first and third", failed)
+	}
+	err := report.Err()
+	if err == nil {
+		t.Fatal("Err() = nil, want an error")
+	}
+	for _, want := range []string{"2 of 3", "first: boom", "third: context deadline exceeded"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Err() = %q, want it to contain %q", err, want)
+		}
+	}
+
+	if err := Run(context.Background(), time.Second).Err(); err != nil {
+		t.Errorf("Err() of an empty report = %v, want nil", err)
+	}
+}
+
+func TestPorts(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	ctx := context.Background()
+	if err := TCPPort("http", ln.Addr().String()).Run(ctx); err == nil {
+		t.Error("TCPPort() on a bound port = nil, want an error")
+	}
+	if err := UDPPort("tftp", pc.LocalAddr().String()).Run(ctx); err == nil {
+		t.Error("UDPPort() on a bound port = nil, want an error")
+	}
+	if err := TCPPort("http", "127.0.0.1:0").Run(ctx); err != nil {
+		t.Errorf("TCPPort() on a free port = %v", err)
+	}
+	if err := UDPPort("tftp", "127.0.0.1:0").Run(ctx); err != nil {
+		t.Errorf("UDPPort() on a free port = %v", err)
+	}
+}
+
+func TestWritable(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "state")
+	if err := Writable(dir).Run(context.Background()); err != nil {
+		t.Fatalf("Writable() = %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Writable() left %d files behind", len(entries))
+	}
+
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Writable(file).Run(context.Background()); err == nil {
+		t.Error("Writable() on a regular file = nil, want an error")
+	}
+}
+
+func TestFiles(t *testing.T) {
+	files := map[string][]byte{"ipxe.efi": []byte("x"), "snp.efi": nil}
+	err := Files("ipxe binaries", files, "ipxe.efi", "snp.efi", "undionly.kpxe").Run(context.Background())
+	if err == nil || err.Error() != "missing snp.efi, undionly.kpxe" {
+		t.Errorf("Files() = %v, want snp.efi and undionly.kpxe missing", err)
+	}
+}
+
+func TestIPv6Listener(t *testing.T) {
+	for addr, ok := range map[string]bool{
+		"0.0.0.0:8080":     true,
+		"[::]:8080":        true,
+		":8080":            true,
+		"[2001:db8::1]:69": true,
+		"10.0.0.1:8080":    false,
+	} {
+		err := IPv6Listener("http", addr).Run(context.Background())
+		if (err == nil) != ok {
+			t.Errorf("IPv6Listener(%q) = %v, want ok %v", addr, err, ok)
+		}
+	}
+}
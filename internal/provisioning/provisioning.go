@@ -0,0 +1,187 @@
+// Package provisioning drives hosts through the boot flow, from their first
+// netboot DHCPDISCOVER to the phone home that reports a successful
+// deployment, and keeps provisioned hosts from netbooting again.
+//
+// The states are those of hoststate: unknown, discovering, netboot-enabled,
+// provisioning and provisioned. Hosts that phone home are provisioned and,
+// with netboot disabled on phone home, a Flow in ModeIgnore also switches
+// their backend entry to ignore, as dnsmasq's "ignore", and switches it back
+// when netboot is enabled again.
+package provisioning
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/go-logr/logr"
+	"github.com/metal3-community/metal-boot/internal/backend"
+	"github.com/metal3-community/metal-boot/internal/events"
+	"github.com/metal3-community/metal-boot/internal/hoststate"
+)
+
+// Mode selects how netboot is turned off for hosts that finished
+// provisioning.
+type Mode string
+
+const (
+	// ModeLocalBoot withholds netboot options from provisioned hosts, which
+	// still get their addresses and boot from their own disk.
+	ModeLocalBoot Mode = "local_boot"
+	// ModeIgnore also switches the backend entry of provisioned hosts to
+	// ignore their DHCP requests, for hosts that are addressed otherwise.
+	ModeIgnore Mode = "ignore"
+)
+
+// ParseMode returns the Mode named s.
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(s); m {
+	case ModeLocalBoot, ModeIgnore:
+		return m, nil
+	}
+
+	return "", fmt.Errorf("unknown mode %q, want %q or %q", s, ModeLocalBoot, ModeIgnore)
+}
+
+// Flow moves hosts along the boot flow as they netboot. All methods of a
+// nil Flow do nothing.
+type Flow struct {
+	Hosts *hoststate.Store
+	// Backend holds the entries of the hosts. In ModeIgnore it must be a
+	// backend.BackendHostWriter.
+	Backend backend.BackendReader
+	// Events, if set, receives a BootStateChanged event for every move.
+	Events *events.Bus
+	Mode   Mode
+	Log    logr.Logger
+}
+
+// Validate checks that the backend of f supports its mode.
+func (f *Flow) Validate() error {
+	if f == nil || f.Mode != ModeIgnore {
+		return nil
+	}
+	if _, ok := f.Backend.(backend.BackendHostWriter); !ok {
+		return errors.New("mode ignore needs a backend whose hosts can be changed, such as dnsmasq")
+	}
+
+	return nil
+}
+
+// Discovered records a DHCPDISCOVER of the netboot client mac and whether it
+// was offered netboot options.
+func (f *Flow) Discovered(mac net.HardwareAddr, netboot bool) {
+	if netboot {
+		f.transition(mac, hoststate.StateNetbootEnabled, "offered netboot")
+		return
+	}
+	f.transition(mac, hoststate.StateDiscovering, "netboot not offered")
+}
+
+// ScriptServed records that mac was served an iPXE script of profile. The
+// fallback script, which boots the host from its disk, does not start a
+// deployment.
+func (f *Flow) ScriptServed(mac net.HardwareAddr, profile string) {
+	if profile == "fallback" {
+		return
+	}
+	f.transition(mac, hoststate.StateProvisioning, "fetched "+profile+" script")
+}
+
+// NetbootChanged follows a change of the netboot setting of h. It is
+// registered with hoststate.Store.OnNetbootChange.
+func (f *Flow) NetbootChanged(h hoststate.Host) {
+	if f == nil {
+		return
+	}
+	mac, err := net.ParseMAC(h.MAC)
+	if err != nil {
+		return
+	}
+	log := f.Log.WithValues("mac", h.MAC)
+
+	if !h.NetbootDisabled {
+		if h.NetbootIgnored {
+			if err := f.ignore(context.Background(), mac, false); err != nil {
+				log.Error(err, "failed to stop ignoring the host in the backend")
+				return
+			}
+			log.Info("host entry no longer ignored, netboot enabled")
+		}
+		if h.State == hoststate.StateProvisioned {
+			f.transition(mac, hoststate.StateNetbootEnabled, "netboot enabled again")
+		}
+		return
+	}
+
+	if f.Mode == ModeIgnore && h.State == hoststate.StateProvisioned && !h.NetbootIgnored {
+		if err := f.ignore(context.Background(), mac, true); err != nil {
+			log.Error(err, "failed to ignore the provisioned host in the backend")
+			return
+		}
+		log.Info("host entry switched to ignore after provisioning")
+	}
+}
+
+// ignore switches the backend entry of mac to ignore its DHCP requests, or
+// back, and records it in the host state. An entry created only to be
+// ignored is removed again.
+func (f *Flow) ignore(ctx context.Context, mac net.HardwareAddr, ignore bool) error {
+	hw, ok := f.Backend.(backend.BackendHostWriter)
+	if !ok {
+		return errors.New("backend cannot change hosts")
+	}
+
+	host, err := hw.GetHost(ctx, mac)
+	switch {
+	case errors.Is(err, backend.ErrHostNotFound):
+		err = nil
+		if ignore {
+			err = hw.CreateHost(ctx, backend.Host{MAC: mac, Disabled: true})
+		}
+	case err != nil:
+		return err
+	case !ignore && host.IP == nil && host.IPv6 == nil && host.Hostname == "" &&
+		len(host.Options) == 0:
+		err = hw.DeleteHost(ctx, mac)
+	case host.Disabled != ignore:
+		host.Disabled = ignore
+		err = hw.UpdateHost(ctx, host)
+	}
+	if err != nil {
+		return err
+	}
+
+	return f.Hosts.Update(mac, func(h *hoststate.Host) {
+		h.NetbootIgnored = ignore
+	})
+}
+
+// transition moves mac to state to. Moves the boot flow does not allow, such
+// as of a host being cleaned, are skipped.
+func (f *Flow) transition(mac net.HardwareAddr, to hoststate.State, message string) {
+	if f == nil || f.Hosts == nil {
+		return
+	}
+	from, err := f.Hosts.Transition(mac, to, message)
+	if errors.Is(err, hoststate.ErrTransition) {
+		f.Log.V(1).Info("boot flow transition skipped", "mac", mac.String(), "error", err)
+		return
+	}
+	if err != nil {
+		f.Log.Error(err, "failed to record boot flow state", "mac", mac.String(), "state", to)
+		return
+	}
+	if from == to {
+		return
+	}
+
+	f.Log.Info("boot flow state changed", "mac", mac.String(), "from", from, "to", to)
+	f.Events.Publish(events.Event{
+		Type:    events.BootStateChanged,
+		MAC:     hoststate.Key(mac),
+		State:   string(to),
+		Message: message,
+	})
+}
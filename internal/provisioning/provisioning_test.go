@@ -0,0 +1,172 @@
+package provisioning
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/metal3-community/metal-boot/internal/backend"
+	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+	"github.com/metal3-community/metal-boot/internal/events"
+	"github.com/metal3-community/metal-boot/internal/hoststate"
+)
+
+var mac = net.HardwareAddr{0xd8, 0x3a, 0xdd, 0, 0, 1}
+
+// fakeBackend holds hosts in memory.
+type fakeBackend struct {
+	hosts map[string]backend.Host
+}
+
+func (b *fakeBackend) GetByMac(context.Context, net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+	return nil, nil, backend.ErrHostNotFound
+}
+
+func (b *fakeBackend) GetByIP(context.Context, net.IP) (*data.DHCP, *data.Netboot, error) {
+	return nil, nil, backend.ErrHostNotFound
+}
+
+func (b *fakeBackend) GetKeys(context.Context) ([]net.HardwareAddr, error) { return nil, nil }
+
+func (b *fakeBackend) Hosts(context.Context) ([]backend.Host, error) { return nil, nil }
+
+func (b *fakeBackend) GetHost(_ context.Context, mac net.HardwareAddr) (backend.Host, error) {
+	h, ok := b.hosts[mac.String()]
+	if !ok {
+		return backend.Host{}, backend.ErrHostNotFound
+	}
+	return h, nil
+}
+
+func (b *fakeBackend) CreateHost(_ context.Context, h backend.Host) error {
+	b.hosts[h.MAC.String()] = h
+	return nil
+}
+
+func (b *fakeBackend) UpdateHost(_ context.Context, h backend.Host) error {
+	b.hosts[h.MAC.String()] = h
+	return nil
+}
+
+func (b *fakeBackend) DeleteHost(_ context.Context, mac net.HardwareAddr) error {
+	delete(b.hosts, mac.String())
+	return nil
+}
+
+func (b *fakeBackend) SetOptions(context.Context, net.HardwareAddr, []backend.HostOption) error {
+	return nil
+}
+
+func newFlow(t *testing.T, b backend.BackendReader, mode Mode) (*Flow, *hoststate.Store, *[]events.Event) {
+	t.Helper()
+	hosts, err := hoststate.NewStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var published []events.Event
+	bus := &events.Bus{}
+	bus.Subscribe(func(e events.Event) { published = append(published, e) })
+	f := &Flow{Hosts: hosts, Backend: b, Events: bus, Mode: mode, Log: logr.Discard()}
+	if err := f.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	hosts.OnNetbootChange(f.NetbootChanged)
+
+	return f, hosts, &published
+}
+
+func state(t *testing.T, hosts *hoststate.Store) hoststate.Host {
+	t.Helper()
+	h, err := hosts.Get(mac)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestFlow(t *testing.T) {
+	b := &fakeBackend{hosts: map[string]backend.Host{
+		mac.String(): {MAC: mac, IP: net.IPv4(10, 0, 0, 10)},
+	}}
+	f, hosts, published := newFlow(t, b, ModeIgnore)
+
+	f.Discovered(mac, false)
+	f.Discovered(mac, true)
+	f.Discovered(mac, true)
+	f.ScriptServed(mac, "fallback")
+	if got := state(t, hosts).State; got != hoststate.StateNetbootEnabled {
+		t.Fatalf("State = %q, want %q", got, hoststate.StateNetbootEnabled)
+	}
+	f.ScriptServed(mac, "config")
+	if got := state(t, hosts).State; got != hoststate.StateProvisioning {
+		t.Fatalf("State = %q, want %q", got, hoststate.StateProvisioning)
+	}
+	if len(*published) != 3 || (*published)[2].Type != events.BootStateChanged {
+		t.Errorf("published %+v, want an event per change", *published)
+	}
+
+	// Phoning home with netboot disabled ignores the host in the backend.
+	if err := hosts.RecordPhoneHome(mac, "phoned home", true); err != nil {
+		t.Fatal(err)
+	}
+	if !b.hosts[mac.String()].Disabled || !state(t, hosts).NetbootIgnored {
+		t.Fatalf("backend host = %+v, host = %+v, want it ignored", b.hosts[mac.String()], state(t, hosts))
+	}
+	f.Discovered(mac, false)
+	if got := state(t, hosts).State; got != hoststate.StateProvisioned {
+		t.Errorf("State = %q, want %q", got, hoststate.StateProvisioned)
+	}
+
+	// Enabling netboot again reverts it.
+	if err := hosts.ResetBootAttempts(mac); err != nil {
+		t.Fatal(err)
+	}
+	h := state(t, hosts)
+	if b.hosts[mac.String()].Disabled || h.NetbootIgnored || h.State != hoststate.StateNetbootEnabled {
+		t.Errorf("backend host = %+v, host = %+v, want it offered netboot", b.hosts[mac.String()], h)
+	}
+}
+
+func TestFlowIgnoreUnknownHost(t *testing.T) {
+	b := &fakeBackend{hosts: map[string]backend.Host{}}
+	_, hosts, _ := newFlow(t, b, ModeIgnore)
+
+	if err := hosts.RecordPhoneHome(mac, "", true); err != nil {
+		t.Fatal(err)
+	}
+	if !b.hosts[mac.String()].Disabled {
+		t.Fatalf("backend hosts = %+v, want an ignored entry", b.hosts)
+	}
+	if err := hosts.ResetBootAttempts(mac); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := b.hosts[mac.String()]; ok {
+		t.Errorf("backend hosts = %+v, want the ignored entry removed", b.hosts)
+	}
+}
+
+func TestFlowLocalBoot(t *testing.T) {
+	b := &fakeBackend{hosts: map[string]backend.Host{}}
+	_, hosts, _ := newFlow(t, b, ModeLocalBoot)
+
+	if err := hosts.RecordPhoneHome(mac, "", true); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.hosts) != 0 || state(t, hosts).NetbootIgnored {
+		t.Errorf("backend hosts = %+v, want them untouched", b.hosts)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	reader := struct{ backend.BackendReader }{}
+	if err := (&Flow{Backend: reader, Mode: ModeIgnore}).Validate(); err == nil {
+		t.Error("Validate() accepted mode ignore with a read-only backend")
+	}
+	if err := (&Flow{Backend: reader, Mode: ModeLocalBoot}).Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+	if _, err := ParseMode("off"); err == nil {
+		t.Error("ParseMode() accepted an unknown mode")
+	}
+}
@@ -0,0 +1,48 @@
+// Package readonly implements a global switch that rejects mutating API
+// requests, for incident triage or when running a standby replica.
+package readonly
+
+import (
+	"errors"
+	"net/http"
+	"sync/atomic"
+)
+
+// ErrReadOnly is returned for mutating requests while read-only mode is on.
+var ErrReadOnly = errors.New("metal-boot is in read-only mode")
+
+// Switch is the read-only mode switch. A nil Switch is never read-only.
+type Switch struct {
+	enabled atomic.Bool
+}
+
+// New returns a Switch that starts out enabled or not.
+func New(enabled bool) *Switch {
+	s := &Switch{}
+	s.enabled.Store(enabled)
+	return s
+}
+
+// Enabled reports whether read-only mode is on.
+func (s *Switch) Enabled() bool {
+	return s != nil && s.enabled.Load()
+}
+
+// Set turns read-only mode on or off.
+func (s *Switch) Set(enabled bool) {
+	s.enabled.Store(enabled)
+}
+
+// Check returns ErrReadOnly if read-only mode is on and method can change
+// state.
+func (s *Switch) Check(method string) error {
+	if !s.Enabled() {
+		return nil
+	}
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return nil
+	default:
+		return ErrReadOnly
+	}
+}
@@ -0,0 +1,33 @@
+package readonly
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	var none *Switch
+	if err := none.Check(http.MethodPost); err != nil {
+		t.Errorf("nil Switch Check() error = %v", err)
+	}
+
+	s := New(false)
+	if err := s.Check(http.MethodPatch); err != nil {
+		t.Errorf("disabled Check() error = %v", err)
+	}
+
+	s.Set(true)
+	for method, want := range map[string]error{
+		http.MethodGet:    nil,
+		http.MethodHead:   nil,
+		http.MethodPost:   ErrReadOnly,
+		http.MethodPatch:  ErrReadOnly,
+		http.MethodPut:    ErrReadOnly,
+		http.MethodDelete: ErrReadOnly,
+	} {
+		if err := s.Check(method); !errors.Is(err, want) {
+			t.Errorf("Check(%s) error = %v, want %v", method, err, want)
+		}
+	}
+}
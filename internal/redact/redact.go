@@ -0,0 +1,190 @@
+// Package redact keeps credentials out of log output.
+//
+// A Redactor knows the secret values of the configuration, such as the UniFi
+// password and API key or the token signing secrets, and masks them wherever
+// they appear in a string. Its slog.Handler applies it to every record and
+// additionally masks the values of attributes whose keys name a credential,
+// tokens in URL query strings, bearer tokens and the passwords in URLs, so
+// that call sites need not take care of what they log.
+package redact
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Mask replaces redacted values.
+const Mask = "[REDACTED]"
+
+// minSecretLen is the length below which secrets are not masked in free
+// text, where they would match too much.
+const minSecretLen = 4
+
+var (
+	// queryParam matches the values of credential query parameters.
+	queryParam = regexp.MustCompile(
+		`(?i)\b((?:access_|auth_|session_)?token|api_?key|password|secret|session_?id)=[^&\s"']+`)
+	// userInfo matches the password in the user info of a URL.
+	userInfo = regexp.MustCompile(`(://[^:@/\s"']+):[^@/\s"']+@`)
+	// bearer matches the token of an Authorization header.
+	bearer = regexp.MustCompile(`(?i)\b(bearer)\s+[A-Za-z0-9._~+/=-]+`)
+)
+
+// sensitiveKeys are the substrings of attribute keys whose values are
+// always masked.
+var sensitiveKeys = []string{
+	"password",
+	"passwd",
+	"secret",
+	"token",
+	"apikey",
+	"api_key",
+	"authorization",
+	"cookie",
+	"session",
+}
+
+// Redactor masks secrets. The zero value and nil mask only what needs no
+// configured secrets.
+type Redactor struct {
+	mu      sync.RWMutex
+	secrets []string
+}
+
+// New returns a Redactor that masks secrets.
+func New(secrets ...string) *Redactor {
+	r := &Redactor{}
+	r.SetSecrets(secrets...)
+
+	return r
+}
+
+// SetSecrets replaces the secret values r masks, for instance after the
+// configuration was reloaded. Empty and very short values are ignored.
+func (r *Redactor) SetSecrets(secrets ...string) {
+	if r == nil {
+		return
+	}
+
+	kept := make([]string, 0, len(secrets))
+	for _, s := range secrets {
+		if len(s) >= minSecretLen && !slices.Contains(kept, s) {
+			kept = append(kept, s)
+		}
+	}
+	// Longer secrets first, so that a secret containing another one is
+	// masked as a whole.
+	slices.SortFunc(kept, func(a, b string) int { return len(b) - len(a) })
+
+	r.mu.Lock()
+	r.secrets = kept
+	r.mu.Unlock()
+}
+
+// String returns s with all secrets masked.
+func (r *Redactor) String(s string) string {
+	if r != nil {
+		r.mu.RLock()
+		for _, secret := range r.secrets {
+			s = strings.ReplaceAll(s, secret, Mask)
+		}
+		r.mu.RUnlock()
+	}
+	s = queryParam.ReplaceAllString(s, "$1="+Mask)
+	s = userInfo.ReplaceAllString(s, "$1:"+Mask+"@")
+	s = bearer.ReplaceAllString(s, "$1 "+Mask)
+
+	return s
+}
+
+// Sensitive reports whether the value of an attribute or field named key is
+// a credential.
+func Sensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, k := range sensitiveKeys {
+		if strings.Contains(key, k) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// handler is a slog.Handler that redacts records before passing them on.
+type handler struct {
+	next slog.Handler
+	r    *Redactor
+}
+
+// NewHandler returns a slog.Handler that masks the secrets of r in the
+// messages and attributes of records before passing them to next.
+func NewHandler(next slog.Handler, r *Redactor) slog.Handler {
+	return &handler{next: next, r: r}
+}
+
+// Enabled implements slog.Handler.
+func (h *handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+// Handle implements slog.Handler.
+func (h *handler) Handle(ctx context.Context, record slog.Record) error {
+	redacted := slog.NewRecord(record.Time, record.Level, h.r.String(record.Message), record.PC)
+	record.Attrs(func(a slog.Attr) bool {
+		redacted.AddAttrs(h.attr(a))
+		return true
+	})
+
+	return h.next.Handle(ctx, redacted)
+}
+
+// WithAttrs implements slog.Handler.
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = h.attr(a)
+	}
+
+	return &handler{next: h.next.WithAttrs(redacted), r: h.r}
+}
+
+// WithGroup implements slog.Handler.
+func (h *handler) WithGroup(name string) slog.Handler {
+	return &handler{next: h.next.WithGroup(name), r: h.r}
+}
+
+// attr returns a with its value redacted.
+func (h *handler) attr(a slog.Attr) slog.Attr {
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindGroup:
+		group := v.Group()
+		redacted := make([]slog.Attr, len(group))
+		for i, ga := range group {
+			redacted[i] = h.attr(ga)
+		}
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(redacted...)}
+	case slog.KindString:
+		if Sensitive(a.Key) && v.String() != "" {
+			return slog.String(a.Key, Mask)
+		}
+		return slog.String(a.Key, h.r.String(v.String()))
+	case slog.KindAny:
+		if Sensitive(a.Key) {
+			return slog.String(a.Key, Mask)
+		}
+		// Values are only flattened to strings when they hold a secret, so
+		// that structured values keep their encoding.
+		s := fmt.Sprint(v.Any())
+		if redacted := h.r.String(s); redacted != s {
+			return slog.String(a.Key, redacted)
+		}
+	}
+
+	return slog.Attr{Key: a.Key, Value: v}
+}
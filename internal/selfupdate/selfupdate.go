@@ -0,0 +1,361 @@
+// Package selfupdate replaces the running metal-boot binary with a new build.
+//
+// An update happens in two steps: Stage downloads the new binary into a
+// staging directory and verifies its SHA-256 checksum and the Ed25519
+// signature of its publisher, then Apply swaps it in place of the current
+// executable and re-executes the process with the same arguments and
+// environment.
+package selfupdate
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/metal3-community/metal-boot/internal/download"
+)
+
+// ChecksumSuffix is appended to an image URL to find its checksum file when
+// no checksum is given explicitly.
+const ChecksumSuffix = ".sha256"
+
+// SignatureSuffix is appended to an image URL to find its signature: the
+// Ed25519 signature of the SHA-256 digest of the image, raw or base64
+// encoded.
+const SignatureSuffix = ".sig"
+
+// State is the progress of the current or last update.
+type State string
+
+const (
+	// StateIdle means no update has been requested.
+	StateIdle State = "Idle"
+	// StateDownloading means the new binary is being fetched and verified.
+	StateDownloading State = "Downloading"
+	// StateStaged means a verified binary is waiting to be applied.
+	StateStaged State = "Staged"
+	// StateApplying means the binary is being swapped in and re-executed.
+	StateApplying State = "Applying"
+	// StateFailed means the last update did not complete.
+	StateFailed State = "Failed"
+)
+
+var (
+	// ErrInProgress is returned when an update is already running.
+	ErrInProgress = errors.New("an update is already in progress")
+	// ErrChecksumMismatch is returned when a download does not match its checksum.
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	// ErrNotStaged is returned by Apply when no verified binary is staged.
+	ErrNotStaged = errors.New("no update staged")
+	// ErrNoPublicKey is returned by Stage when no publisher key is pinned.
+	ErrNoPublicKey = errors.New("no publisher key to verify updates with")
+	// ErrBadSignature is returned when an image is not signed by the
+	// publisher key.
+	ErrBadSignature = errors.New("image is not signed by the publisher key")
+)
+
+// Status describes the current or last update.
+type Status struct {
+	State     State     `json:"state"`
+	ImageURI  string    `json:"imageUri,omitempty"`
+	Message   string    `json:"message,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Updater downloads, verifies and applies new metal-boot binaries.
+type Updater struct {
+	// Version is the version of the running binary.
+	Version string
+	// Executable is the path of the running binary that Apply replaces.
+	Executable string
+	// StagingDir holds downloaded binaries until they are applied.
+	StagingDir string
+	// PublicKey is the pinned key of the publisher of metal-boot builds.
+	// Stage only accepts images signed by it.
+	PublicKey ed25519.PublicKey
+	// Client is used for downloads. http.DefaultClient is used when nil.
+	Client *http.Client
+
+	mu     sync.Mutex
+	status Status
+	staged string
+
+	// exec replaces the process image; it is syscall.Exec outside tests.
+	exec func(argv0 string, argv []string, envv []string) error
+}
+
+// Status returns the state of the current or last update.
+func (u *Updater) Status() Status {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.status.State == "" {
+		return Status{State: StateIdle}
+	}
+
+	return u.status
+}
+
+// Stage downloads imageURI into the staging directory and verifies it against
+// checksum, a hex encoded SHA-256 digest, and against its signature by
+// u.PublicKey, read from imageURI + SignatureSuffix. When checksum is empty it
+// is read from imageURI + ChecksumSuffix. The progress of the download is
+// recorded on the download.Download of ctx, if any.
+func (u *Updater) Stage(ctx context.Context, imageURI, checksum string) (err error) {
+	progress := download.FromContext(ctx)
+	u.mu.Lock()
+	if u.status.State == StateDownloading || u.status.State == StateApplying {
+		u.mu.Unlock()
+		progress.Done(ErrInProgress)
+		return ErrInProgress
+	}
+	u.setStatus(StateDownloading, imageURI, "")
+	u.mu.Unlock()
+
+	defer func() {
+		progress.Done(err)
+		u.mu.Lock()
+		defer u.mu.Unlock()
+		if err != nil {
+			u.setStatus(StateFailed, imageURI, err.Error())
+		}
+	}()
+
+	if len(u.PublicKey) != ed25519.PublicKeySize {
+		return ErrNoPublicKey
+	}
+	sig, err := u.fetchSignature(ctx, imageURI+SignatureSuffix)
+	if err != nil {
+		return err
+	}
+	if checksum == "" {
+		if checksum, err = u.fetchChecksum(ctx, imageURI+ChecksumSuffix); err != nil {
+			return err
+		}
+	}
+	want, err := hex.DecodeString(strings.TrimSpace(checksum))
+	if err != nil || len(want) != sha256.Size {
+		return fmt.Errorf("invalid sha256 checksum %q", checksum)
+	}
+
+	if err := os.MkdirAll(u.StagingDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(u.StagingDir, "metal-boot-*.download")
+	if err != nil {
+		return fmt.Errorf("failed to create staging file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	body, size, err := u.get(ctx, imageURI)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	progress.SetTotal(size)
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h, progress), body); err != nil {
+		return fmt.Errorf("failed to download %s: %w", imageURI, err)
+	}
+	if got := h.Sum(nil); !bytes.Equal(got, want) {
+		return fmt.Errorf("%w: got %x, want %x", ErrChecksumMismatch, got, want)
+	}
+	if !ed25519.Verify(u.PublicKey, want, sig) {
+		return ErrBadSignature
+	}
+	if err := tmp.Chmod(0o755); err != nil {
+		return fmt.Errorf("failed to make staged binary executable: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write staged binary: %w", err)
+	}
+
+	staged := filepath.Join(u.StagingDir, "metal-boot")
+	if err := os.Rename(tmp.Name(), staged); err != nil {
+		return fmt.Errorf("failed to stage binary: %w", err)
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.staged = staged
+	u.setStatus(StateStaged, imageURI, "")
+
+	return nil
+}
+
+// Apply replaces the running executable with the staged binary and
+// re-executes the process. The previous binary is kept with a ".prev" suffix.
+// On success Apply does not return.
+func (u *Updater) Apply() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.status.State != StateStaged || u.staged == "" {
+		return ErrNotStaged
+	}
+	imageURI := u.status.ImageURI
+	u.setStatus(StateApplying, imageURI, "")
+
+	fail := func(err error) error {
+		u.setStatus(StateFailed, imageURI, err.Error())
+		return err
+	}
+
+	prev := u.Executable + ".prev"
+	if err := os.Rename(u.Executable, prev); err != nil {
+		return fail(fmt.Errorf("failed to back up current binary: %w", err))
+	}
+	if err := copyFile(u.staged, u.Executable); err != nil {
+		if rerr := os.Rename(prev, u.Executable); rerr != nil {
+			err = errors.Join(err, rerr)
+		}
+		return fail(fmt.Errorf("failed to install staged binary: %w", err))
+	}
+	os.Remove(u.staged)
+	u.staged = ""
+
+	exec := u.exec
+	if exec == nil {
+		exec = syscall.Exec
+	}
+	if err := exec(u.Executable, os.Args, os.Environ()); err != nil {
+		return fail(fmt.Errorf("failed to execute new binary: %w", err))
+	}
+
+	return nil
+}
+
+// setStatus records a transition. Callers must hold u.mu.
+func (u *Updater) setStatus(state State, imageURI, message string) {
+	u.status = Status{
+		State:     state,
+		ImageURI:  imageURI,
+		Message:   message,
+		UpdatedAt: time.Now().UTC(),
+	}
+}
+
+// get fetches uri and returns its body and Content-Length.
+func (u *Updater) get(ctx context.Context, uri string) (io.ReadCloser, int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid image URI %q: %w", uri, err)
+	}
+	client := u.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to fetch %s: %w", uri, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, 0, fmt.Errorf("failed to fetch %s: %s", uri, resp.Status)
+	}
+
+	return resp.Body, resp.ContentLength, nil
+}
+
+// fetchChecksum reads the first field of a sha256sum style checksum file.
+func (u *Updater) fetchChecksum(ctx context.Context, uri string) (string, error) {
+	body, _, err := u.get(ctx, uri)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	sc := bufio.NewScanner(io.LimitReader(body, 4096))
+	if !sc.Scan() {
+		return "", fmt.Errorf("empty checksum file %s", uri)
+	}
+	fields := strings.Fields(sc.Text())
+	if len(fields) == 0 {
+		return "", fmt.Errorf("empty checksum file %s", uri)
+	}
+
+	return fields[0], nil
+}
+
+// fetchSignature reads a raw or base64 encoded Ed25519 signature.
+func (u *Updater) fetchSignature(ctx context.Context, uri string) ([]byte, error) {
+	body, _, err := u.get(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	b, err := io.ReadAll(io.LimitReader(body, 4096))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signature %s: %w", uri, err)
+	}
+	if len(b) == ed25519.SignatureSize {
+		return b, nil
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("invalid signature file %s", uri)
+	}
+
+	return sig, nil
+}
+
+// LoadPublicKey reads a PEM encoded Ed25519 public key, as written by
+// "openssl pkey -pubout".
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("%s: no PEM public key", path)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an Ed25519 public key", path)
+	}
+
+	return pub, nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
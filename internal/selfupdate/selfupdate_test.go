@@ -0,0 +1,176 @@
+package selfupdate
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/metal3-community/metal-boot/internal/download"
+)
+
+var (
+	newBinary = []byte("#!/bin/sh\necho new\n")
+	publisher = ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize))
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	sum := sha256.Sum256(newBinary)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metal-boot", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(newBinary)
+	})
+	mux.HandleFunc("/metal-boot.sha256", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(hex.EncodeToString(sum[:]) + "  metal-boot\n"))
+	})
+	mux.HandleFunc("/metal-boot.sig", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(ed25519.Sign(publisher, sum[:]))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func newTestUpdater(t *testing.T) *Updater {
+	t.Helper()
+	dir := t.TempDir()
+	exe := filepath.Join(dir, "bin", "metal-boot")
+	if err := os.MkdirAll(filepath.Dir(exe), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(exe, []byte("old"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	return &Updater{
+		Version:    "v1",
+		Executable: exe,
+		StagingDir: filepath.Join(dir, "staging"),
+		PublicKey:  publisher.Public().(ed25519.PublicKey),
+	}
+}
+
+func TestStageAndApply(t *testing.T) {
+	srv := newTestServer(t)
+	u := newTestUpdater(t)
+
+	if err := u.Apply(); !errors.Is(err, ErrNotStaged) {
+		t.Fatalf("Apply() before Stage error = %v, want ErrNotStaged", err)
+	}
+
+	// The checksum is read from the .sha256 sidecar.
+	downloads := download.NewTracker()
+	ctx := download.NewContext(context.Background(), downloads.Start("update", "", ""))
+	if err := u.Stage(ctx, srv.URL+"/metal-boot", ""); err != nil {
+		t.Fatalf("Stage() error = %v", err)
+	}
+	if got := u.Status().State; got != StateStaged {
+		t.Fatalf("Status().State = %s, want %s", got, StateStaged)
+	}
+	if p, _ := downloads.Get("update"); p.State != download.StateCompleted ||
+		p.Bytes != int64(len(newBinary)) || p.Total != p.Bytes {
+		t.Errorf("download progress = %+v, want %d bytes completed", p, len(newBinary))
+	}
+
+	var execed string
+	u.exec = func(argv0 string, _ []string, _ []string) error {
+		execed = argv0
+		return nil
+	}
+	if err := u.Apply(); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if execed != u.Executable {
+		t.Errorf("exec(%q), want %q", execed, u.Executable)
+	}
+
+	got, err := os.ReadFile(u.Executable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(newBinary) {
+		t.Errorf("executable = %q, want the new binary", got)
+	}
+	prev, err := os.ReadFile(u.Executable + ".prev")
+	if err != nil || string(prev) != "old" {
+		t.Errorf("previous binary = %q, %v; want it kept as .prev", prev, err)
+	}
+}
+
+func TestStageChecksumMismatch(t *testing.T) {
+	srv := newTestServer(t)
+	u := newTestUpdater(t)
+
+	wrong := sha256.Sum256([]byte("something else"))
+	err := u.Stage(context.Background(), srv.URL+"/metal-boot", hex.EncodeToString(wrong[:]))
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("Stage() error = %v, want ErrChecksumMismatch", err)
+	}
+	if got := u.Status().State; got != StateFailed {
+		t.Errorf("Status().State = %s, want %s", got, StateFailed)
+	}
+	if err := u.Apply(); !errors.Is(err, ErrNotStaged) {
+		t.Errorf("Apply() after failed Stage error = %v, want ErrNotStaged", err)
+	}
+
+	if err := u.Stage(context.Background(), srv.URL+"/metal-boot", "not-hex"); err == nil {
+		t.Error("Stage() with a malformed checksum succeeded")
+	}
+	if err := u.Stage(context.Background(), srv.URL+"/missing", ""); err == nil {
+		t.Error("Stage() without a checksum file succeeded")
+	}
+}
+
+func TestStageSignature(t *testing.T) {
+	srv := newTestServer(t)
+
+	u := newTestUpdater(t)
+	u.PublicKey = nil
+	if err := u.Stage(context.Background(), srv.URL+"/metal-boot", ""); !errors.Is(err, ErrNoPublicKey) {
+		t.Errorf("Stage() without a key error = %v, want ErrNoPublicKey", err)
+	}
+
+	other := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{2}, ed25519.SeedSize))
+	u.PublicKey = other.Public().(ed25519.PublicKey)
+	if err := u.Stage(context.Background(), srv.URL+"/metal-boot", ""); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("Stage() with another key error = %v, want ErrBadSignature", err)
+	}
+
+	// The key is pinned from a PEM file; base64 signatures are accepted.
+	der, err := x509.MarshalPKIXPublicKey(publisher.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "publisher.pem")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if u.PublicKey, err = LoadPublicKey(keyFile); err != nil {
+		t.Fatalf("LoadPublicKey() error = %v", err)
+	}
+	sum := sha256.Sum256(newBinary)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metal-boot", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(newBinary)
+	})
+	mux.HandleFunc("/metal-boot.sig", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(base64.StdEncoding.EncodeToString(ed25519.Sign(publisher, sum[:])) + "\n"))
+	})
+	b64 := httptest.NewServer(mux)
+	t.Cleanup(b64.Close)
+	if err := u.Stage(context.Background(), b64.URL+"/metal-boot", hex.EncodeToString(sum[:])); err != nil {
+		t.Errorf("Stage() error = %v", err)
+	}
+}
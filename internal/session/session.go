@@ -0,0 +1,360 @@
+// Package session issues the tokens of Redfish sessions and keeps them across
+// restarts.
+//
+// Sessions are written to a state file encrypted with AES-256-GCM, so that a
+// restart of metal-boot during a long Ironic operation does not invalidate
+// the X-Auth-Token Ironic holds. The key is read from a separate file, which
+// is created with a random key when it does not exist.
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultTimeout is the idle time after which a session expires.
+	DefaultTimeout = 30 * time.Minute
+	// MaxSessions is the number of live sessions past which Create fails.
+	MaxSessions = 1024
+	// MaxUserSessions is the number of live sessions of one user past which
+	// Create fails.
+	MaxUserSessions = 256
+	// touchInterval is how stale the persisted last use of a session may get
+	// before a use is written to the state file.
+	touchInterval = time.Minute
+	keySize       = 32
+)
+
+var (
+	// ErrNotFound is returned for unknown or expired sessions.
+	ErrNotFound = errors.New("session not found")
+	// ErrInvalidKey is returned when the state file cannot be decrypted.
+	ErrInvalidKey = errors.New("session state cannot be decrypted with the key")
+	// ErrTooManySessions is returned by Create when the live sessions reach
+	// MaxSessions, or MaxUserSessions for the user.
+	ErrTooManySessions = errors.New("too many sessions")
+)
+
+// Session is an issued session.
+type Session struct {
+	Id       string    `json:"id"`
+	UserName string    `json:"userName"`
+	Token    string    `json:"token"`
+	Created  time.Time `json:"created"`
+	LastUsed time.Time `json:"lastUsed"`
+}
+
+// Store holds the sessions.
+type Store struct {
+	path    string
+	aead    cipher.AEAD
+	timeout time.Duration
+	// maxSessions and maxUserSessions are MaxSessions and MaxUserSessions
+	// outside tests.
+	maxSessions     int
+	maxUserSessions int
+
+	mu       sync.Mutex
+	sessions []*Session
+	// saved is the last use of each session as of the state file.
+	saved map[string]time.Time
+
+	// now is time.Now outside tests.
+	now func() time.Time
+}
+
+// New returns a Store that persists sessions to path, encrypted with the key
+// in keyFile. With an empty path sessions are kept in memory only. A timeout
+// of 0 uses DefaultTimeout.
+func New(path, keyFile string, timeout time.Duration) (*Store, error) {
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	s := &Store{
+		path:            path,
+		timeout:         timeout,
+		maxSessions:     MaxSessions,
+		maxUserSessions: MaxUserSessions,
+		saved:           make(map[string]time.Time),
+		now:             time.Now,
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	key, err := loadKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if s.aead, err = cipher.NewGCM(block); err != nil {
+		return nil, err
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// loadKey reads the hex encoded key in path, creating it when missing.
+func loadKey(path string) ([]byte, error) {
+	if path == "" {
+		return nil, errors.New("a key file is required to persist sessions")
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		key := make([]byte, keySize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create session key directory: %w", err)
+		}
+		if err := os.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0o600); err != nil {
+			return nil, fmt.Errorf("failed to write session key: %w", err)
+		}
+		return key, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session key: %w", err)
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(key) != keySize {
+		return nil, fmt.Errorf("session key %s must be %d hex encoded bytes", path, keySize)
+	}
+
+	return key, nil
+}
+
+// load reads the sessions of the state file, dropping expired ones.
+func (s *Store) load() error {
+	b, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read sessions: %w", err)
+	}
+
+	n := s.aead.NonceSize()
+	if len(b) < n {
+		return ErrInvalidKey
+	}
+	plain, err := s.aead.Open(nil, b[:n], b[n:], nil)
+	if err != nil {
+		return ErrInvalidKey
+	}
+	if err := json.Unmarshal(plain, &s.sessions); err != nil {
+		return fmt.Errorf("failed to parse sessions: %w", err)
+	}
+
+	s.expire()
+	for _, sess := range s.sessions {
+		s.saved[sess.Id] = sess.LastUsed
+	}
+
+	return nil
+}
+
+// save writes the sessions to the state file. Callers must hold s.mu.
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	plain, err := json.Marshal(s.sessions)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	b := s.aead.Seal(nonce, nonce, plain, nil)
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return fmt.Errorf("failed to create session directory: %w", err)
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return fmt.Errorf("failed to write sessions: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to replace sessions: %w", err)
+	}
+
+	for _, sess := range s.sessions {
+		s.saved[sess.Id] = sess.LastUsed
+	}
+
+	return nil
+}
+
+// expire drops the sessions idle for longer than the timeout and reports
+// whether any was dropped. Callers must hold s.mu.
+func (s *Store) expire() bool {
+	now := s.now()
+	n := len(s.sessions)
+	s.sessions = slices.DeleteFunc(s.sessions, func(sess *Session) bool {
+		if now.Sub(sess.LastUsed) > s.timeout {
+			delete(s.saved, sess.Id)
+			return true
+		}
+		return false
+	})
+
+	return len(s.sessions) != n
+}
+
+// Timeout returns the idle time after which sessions expire.
+func (s *Store) Timeout() time.Duration {
+	return s.timeout
+}
+
+// Create issues a session for userName. It fails with ErrTooManySessions
+// rather than grow the state file without bound.
+func (s *Store) Create(userName string) (Session, error) {
+	id, err := randomHex(8)
+	if err != nil {
+		return Session{}, err
+	}
+	token, err := randomHex(32)
+	if err != nil {
+		return Session{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expired := s.expire()
+	if err := s.checkLimits(userName); err != nil {
+		if expired {
+			_ = s.save()
+		}
+		return Session{}, err
+	}
+	now := s.now().UTC()
+	sess := &Session{Id: id, UserName: userName, Token: token, Created: now, LastUsed: now}
+	s.sessions = append(s.sessions, sess)
+	if err := s.save(); err != nil {
+		s.sessions = s.sessions[:len(s.sessions)-1]
+		return Session{}, err
+	}
+
+	return *sess, nil
+}
+
+// checkLimits returns ErrTooManySessions if another session, of userName or
+// at all, would exceed the limits. Callers must hold s.mu.
+func (s *Store) checkLimits(userName string) error {
+	if len(s.sessions) >= s.maxSessions {
+		return fmt.Errorf("%w: %d live sessions", ErrTooManySessions, len(s.sessions))
+	}
+	n := 0
+	for _, sess := range s.sessions {
+		if sess.UserName == userName {
+			n++
+		}
+	}
+	if n >= s.maxUserSessions {
+		return fmt.Errorf("%w: %d live sessions of %s", ErrTooManySessions, n, userName)
+	}
+
+	return nil
+}
+
+// Authenticate returns the session of token and records its use.
+func (s *Store) Authenticate(token string) (Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expired := s.expire()
+	for _, sess := range s.sessions {
+		if subtle.ConstantTimeCompare([]byte(sess.Token), []byte(token)) != 1 {
+			continue
+		}
+		sess.LastUsed = s.now().UTC()
+		if expired || sess.LastUsed.Sub(s.saved[sess.Id]) >= touchInterval {
+			// A failed write only shortens the session after a restart.
+			_ = s.save()
+		}
+		return *sess, nil
+	}
+	if expired {
+		_ = s.save()
+	}
+
+	return Session{}, ErrNotFound
+}
+
+// List returns the live sessions, oldest first.
+func (s *Store) List() []Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire()
+	list := make([]Session, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		list = append(list, *sess)
+	}
+
+	return list
+}
+
+// Get returns the session with id.
+func (s *Store) Get(id string) (Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire()
+	for _, sess := range s.sessions {
+		if sess.Id == id {
+			return *sess, nil
+		}
+	}
+
+	return Session{}, ErrNotFound
+}
+
+// Delete ends the session with id.
+func (s *Store) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.sessions, func(sess *Session) bool { return sess.Id == id })
+	if i < 0 {
+		return ErrNotFound
+	}
+	s.sessions = slices.Delete(s.sessions, i, i+1)
+	delete(s.saved, id)
+
+	return s.save()
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,123 @@
+package session
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPersistence(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sessions.enc")
+	keyFile := filepath.Join(dir, "sessions.key")
+
+	s, err := New(path, keyFile, time.Hour)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	sess, err := s.Create("ironic")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	gone, _ := s.Create("admin")
+	if err := s.Delete(gone.Id); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), sess.Token) || strings.Contains(string(b), "ironic") {
+		t.Error("state file holds the session in plain text")
+	}
+
+	// A restarted server accepts the token it issued before.
+	s, err = New(path, keyFile, time.Hour)
+	if err != nil {
+		t.Fatalf("New() after restart error = %v", err)
+	}
+	got, err := s.Authenticate(sess.Token)
+	if err != nil || got.Id != sess.Id || got.UserName != "ironic" {
+		t.Errorf("Authenticate() after restart = %+v, %v", got, err)
+	}
+	if _, err := s.Get(gone.Id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() of a deleted session error = %v, want ErrNotFound", err)
+	}
+
+	// Another key cannot read the sessions.
+	if err := os.WriteFile(keyFile, []byte(strings.Repeat("ab", keySize)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(path, keyFile, time.Hour); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("New() with another key error = %v, want ErrInvalidKey", err)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	s, err := New("", "", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.now = func() time.Time { return now }
+
+	sess, _ := s.Create("ironic")
+	now = now.Add(50 * time.Second)
+	if _, err := s.Authenticate(sess.Token); err != nil {
+		t.Fatalf("Authenticate() within the timeout error = %v", err)
+	}
+	// The use above restarted the idle timer.
+	now = now.Add(50 * time.Second)
+	if _, err := s.Authenticate(sess.Token); err != nil {
+		t.Fatalf("Authenticate() after a use error = %v", err)
+	}
+	now = now.Add(2 * time.Minute)
+	if _, err := s.Authenticate(sess.Token); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Authenticate() of an idle session error = %v, want ErrNotFound", err)
+	}
+	if list := s.List(); len(list) != 0 {
+		t.Errorf("List() = %v, want no sessions", list)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	s, err := New("", "", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.now = func() time.Time { return now }
+	s.maxSessions, s.maxUserSessions = 3, 2
+
+	for range 2 {
+		if _, err := s.Create("ironic"); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+	}
+	if _, err := s.Create("ironic"); !errors.Is(err, ErrTooManySessions) {
+		t.Errorf("Create() past the user limit error = %v, want ErrTooManySessions", err)
+	}
+	admin, err := s.Create("admin")
+	if err != nil {
+		t.Fatalf("Create() of another user error = %v", err)
+	}
+	if _, err := s.Create("other"); !errors.Is(err, ErrTooManySessions) {
+		t.Errorf("Create() past the total limit error = %v, want ErrTooManySessions", err)
+	}
+
+	// Ended and expired sessions free their slots.
+	if err := s.Delete(admin.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Create("other"); err != nil {
+		t.Errorf("Create() after a delete error = %v", err)
+	}
+	now = now.Add(2 * time.Minute)
+	if _, err := s.Create("ironic"); err != nil {
+		t.Errorf("Create() after expiry error = %v", err)
+	}
+}
@@ -0,0 +1,145 @@
+// Package snapshot serializes the in-memory state of a running instance, its
+// host records, DHCP leases, dnsmasq hosts and options and tasks, so that a
+// warm standby can load it at startup and take over after a failover without
+// a cold resync from the state files and external APIs.
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	dnsmasqconfig "github.com/metal3-community/metal-boot/internal/backend/dnsmasq/config"
+	"github.com/metal3-community/metal-boot/internal/hoststate"
+	"github.com/metal3-community/metal-boot/internal/leasedb"
+	"github.com/metal3-community/metal-boot/internal/task"
+)
+
+// Format is the version of the snapshot format. Snapshots of another format
+// are rejected.
+const Format = 1
+
+// ErrInvalid is returned for snapshots that cannot be imported.
+var ErrInvalid = errors.New("invalid snapshot")
+
+// Snapshot is the state of an instance at one point in time.
+type Snapshot struct {
+	Format int `json:"format"`
+	// Version is the metal-boot version that took the snapshot.
+	Version string           `json:"version"`
+	Created time.Time        `json:"created"`
+	Hosts   []hoststate.Host `json:"hosts"`
+	// Leases and Dnsmasq are omitted when the instance does not track
+	// leases or manage dnsmasq files.
+	Leases  []leasedb.Lease         `json:"leases,omitempty"`
+	Dnsmasq *dnsmasqconfig.Snapshot `json:"dnsmasq,omitempty"`
+	Tasks   []task.Info             `json:"tasks"`
+}
+
+// Caches are the in-memory state a Snapshot is taken of and imported into.
+// Leases, Dnsmasq and Tasks may be nil, in which case they are left out of
+// snapshots and their part of imported snapshots is ignored.
+type Caches struct {
+	Hosts   *hoststate.Store
+	Leases  *leasedb.DB
+	Dnsmasq *dnsmasqconfig.ConfigManager
+	Tasks   *task.Store
+	// Version is the metal-boot version recorded in snapshots.
+	Version string
+}
+
+// Export returns a snapshot of c.
+func (c *Caches) Export() Snapshot {
+	s := Snapshot{
+		Format:  Format,
+		Version: c.Version,
+		Created: time.Now().UTC(),
+		Hosts:   c.Hosts.List(),
+		Leases:  c.Leases.List(),
+		Tasks:   c.Tasks.List(),
+	}
+	if c.Dnsmasq != nil {
+		d := c.Dnsmasq.Snapshot()
+		s.Dnsmasq = &d
+	}
+
+	return s
+}
+
+// Import replaces the state of c with s and persists it, so that it is kept
+// across a restart of the standby as well. The dnsmasq files are rewritten
+// to match s.
+func (c *Caches) Import(s Snapshot) error {
+	if s.Format != Format {
+		return fmt.Errorf("%w: format %d, want %d", ErrInvalid, s.Format, Format)
+	}
+
+	if err := c.Hosts.Import(s.Hosts); err != nil {
+		return fmt.Errorf("failed to import hosts: %w", err)
+	}
+	if err := c.Leases.Import(s.Leases); err != nil {
+		return fmt.Errorf("failed to import leases: %w", err)
+	}
+	if c.Dnsmasq != nil && s.Dnsmasq != nil {
+		if _, err := c.Dnsmasq.SaveConfig(*s.Dnsmasq, false); err != nil {
+			return fmt.Errorf("failed to import dnsmasq configuration: %w", err)
+		}
+	}
+	if err := c.Tasks.Import(s.Tasks); err != nil {
+		return fmt.Errorf("failed to import tasks: %w", err)
+	}
+
+	return nil
+}
+
+// Read decodes a snapshot from r.
+func Read(r io.Reader) (Snapshot, error) {
+	var s Snapshot
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return Snapshot{}, fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+	if s.Format != Format {
+		return Snapshot{}, fmt.Errorf("%w: format %d, want %d", ErrInvalid, s.Format, Format)
+	}
+
+	return s, nil
+}
+
+// Load reads the snapshot at source: the /api/v1/snapshot URL of the active
+// instance, fetched with token as a bearer token unless it is empty, or the
+// path of a snapshot file.
+func Load(ctx context.Context, source, token string) (Snapshot, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		f, err := os.Open(source)
+		if err != nil {
+			return Snapshot{}, fmt.Errorf("failed to open snapshot: %w", err)
+		}
+		defer f.Close()
+
+		return Read(f)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("failed to fetch snapshot: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Snapshot{}, fmt.Errorf("failed to fetch snapshot: %s", resp.Status)
+	}
+
+	return Read(resp.Body)
+}
@@ -0,0 +1,160 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	dnsmasqconfig "github.com/metal3-community/metal-boot/internal/backend/dnsmasq/config"
+	"github.com/metal3-community/metal-boot/internal/hoststate"
+	"github.com/metal3-community/metal-boot/internal/leasedb"
+	"github.com/metal3-community/metal-boot/internal/task"
+)
+
+var mac = net.HardwareAddr{0xd8, 0x3a, 0xdd, 0, 0, 1}
+
+// newCaches returns caches persisted below dir.
+func newCaches(t *testing.T, dir string) *Caches {
+	t.Helper()
+
+	hosts, err := hoststate.NewStore(filepath.Join(dir, "hosts.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	leases, err := leasedb.Open(filepath.Join(dir, "leases.json"), leasedb.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "dnsmasq"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	cm, err := dnsmasqconfig.NewConfigManager(logr.Discard(), filepath.Join(dir, "dnsmasq"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tasks, err := task.New(filepath.Join(dir, "tasks.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Caches{Hosts: hosts, Leases: leases, Dnsmasq: cm, Tasks: tasks, Version: "test"}
+}
+
+func TestExportImport(t *testing.T) {
+	active := newCaches(t, t.TempDir())
+	if err := active.Hosts.SetState(mac, hoststate.StateCleaned, "wiped"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := active.Leases.Ack(mac, netip.MustParseAddr("10.0.0.10"), "node-1", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := active.Dnsmasq.SetHost(dnsmasqconfig.HostEntry{MAC: mac, IP: net.IPv4(10, 0, 0, 10)}); err != nil {
+		t.Fatal(err)
+	}
+	active.Tasks.Start("done", "Done Task", "", "").Done(nil)
+	active.Tasks.Start("running", "Running Task", "", "")
+
+	// The snapshot survives its wire form.
+	b, err := json.Marshal(active.Export())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := Read(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+
+	dir := t.TempDir()
+	standby := newCaches(t, dir)
+	if err := standby.Import(s); err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+
+	if h, err := standby.Hosts.Get(mac); err != nil || h.State != hoststate.StateCleaned {
+		t.Errorf("host = %+v, %v, want it cleaned", h, err)
+	}
+	if l, ok := standby.Leases.Get(mac); !ok || l.State != leasedb.StateBound {
+		t.Errorf("lease = %+v, %v, want it bound", l, ok)
+	}
+	if e, ok := standby.Dnsmasq.GetHost(mac); !ok || !e.IP.Equal(net.IPv4(10, 0, 0, 10)) {
+		t.Errorf("dnsmasq host = %+v, %v, want the reservation", e, ok)
+	}
+	if info, ok := standby.Tasks.Get("done"); !ok || info.State != task.StateCompleted {
+		t.Errorf("done task = %+v, %v, want it completed", info, ok)
+	}
+	if info, ok := standby.Tasks.Get("running"); !ok || info.State != task.StateInterrupted {
+		t.Errorf("running task = %+v, %v, want it interrupted", info, ok)
+	}
+
+	// The imported state is persisted for the next start of the standby.
+	reopened := newCaches(t, dir)
+	if _, err := reopened.Hosts.Get(mac); err != nil {
+		t.Errorf("host after reopening: %v", err)
+	}
+	if _, ok := reopened.Leases.Get(mac); !ok {
+		t.Error("lease lost after reopening")
+	}
+	if _, ok := reopened.Dnsmasq.GetHost(mac); !ok {
+		t.Error("dnsmasq host lost after reopening")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	b, err := json.Marshal(Snapshot{Format: Format, Version: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write(b)
+	}))
+	defer srv.Close()
+
+	file := filepath.Join(t.TempDir(), "snapshot.json")
+	if err := os.WriteFile(file, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	wrongFormat := filepath.Join(t.TempDir(), "v2.json")
+	if err := os.WriteFile(wrongFormat, []byte(`{"format":2}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, source, token string
+		wantErr             bool
+		wantInvalid         bool
+	}{
+		{name: "url", source: srv.URL, token: "secret"},
+		{name: "unauthorized", source: srv.URL, token: "wrong", wantErr: true},
+		{name: "file", source: file},
+		{name: "missing file", source: file + ".missing", wantErr: true},
+		{name: "other format", source: wrongFormat, wantErr: true, wantInvalid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Load(context.Background(), tt.source, tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantInvalid && !errors.Is(err, ErrInvalid) {
+				t.Errorf("Load() error = %v, want ErrInvalid", err)
+			}
+			if err == nil && s.Version != "test" {
+				t.Errorf("Version = %q, want test", s.Version)
+			}
+		})
+	}
+}
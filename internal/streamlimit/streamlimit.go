@@ -0,0 +1,270 @@
+// Package streamlimit bounds the number of concurrent large downloads (ISOs,
+// OS images) so that many nodes reimaging at once queue up instead of
+// exhausting file descriptors and bandwidth.
+//
+// Slots are handed out round-robin between clients, so a client with many
+// queued requests cannot starve the others, and no client holds more than
+// PerClient slots at a time.
+package streamlimit
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/metal3-community/metal-boot/internal/metric"
+)
+
+var (
+	// ErrQueueFull is returned when MaxQueue requests are already waiting.
+	ErrQueueFull = errors.New("stream queue is full")
+	// ErrQueueTimeout is returned when a request waited longer than QueueTimeout.
+	ErrQueueTimeout = errors.New("timed out waiting for a stream slot")
+)
+
+// Limiter hands out stream slots.
+type Limiter struct {
+	// MaxStreams is the number of streams served at once. Zero means unlimited.
+	MaxStreams int
+	// PerClient is the number of streams one client may hold at once. Zero
+	// means MaxStreams.
+	PerClient int
+	// MaxQueue is the number of requests that may wait for a slot. Zero means
+	// unlimited.
+	MaxQueue int
+	// QueueTimeout bounds the time a request waits for a slot. Zero means
+	// until the request is cancelled.
+	QueueTimeout time.Duration
+	// Log is used to log rejected requests.
+	Log *slog.Logger
+
+	mu      sync.Mutex
+	active  int
+	clients map[string]*client
+	// order lists clients with queued requests in round-robin order.
+	order  []string
+	queued int
+}
+
+type client struct {
+	active  int
+	waiters []chan struct{}
+}
+
+// Acquire waits for a slot for key and returns a function that releases it.
+func (l *Limiter) Acquire(ctx context.Context, key string) (func(), error) {
+	l.mu.Lock()
+	if l.clients == nil {
+		l.clients = make(map[string]*client)
+	}
+	c := l.clients[key]
+	if c == nil {
+		c = &client{}
+		l.clients[key] = c
+	}
+
+	if len(c.waiters) == 0 && l.available(c) {
+		l.grant(c)
+		l.mu.Unlock()
+		return l.releaser(key), nil
+	}
+
+	if l.MaxQueue > 0 && l.queued >= l.MaxQueue {
+		l.forget(key, c)
+		l.mu.Unlock()
+		metric.StreamRejected.WithLabelValues("queue_full").Inc()
+		return nil, ErrQueueFull
+	}
+
+	ready := make(chan struct{})
+	if len(c.waiters) == 0 {
+		l.order = append(l.order, key)
+	}
+	c.waiters = append(c.waiters, ready)
+	l.queued++
+	metric.StreamQueueDepth.Set(float64(l.queued))
+	l.mu.Unlock()
+
+	start := time.Now()
+	var timeout <-chan time.Time
+	if l.QueueTimeout > 0 {
+		t := time.NewTimer(l.QueueTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	var err error
+	select {
+	case <-ready:
+		metric.StreamQueueWait.Observe(time.Since(start).Seconds())
+		return l.releaser(key), nil
+	case <-timeout:
+		err = ErrQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case <-ready:
+		// Granted while giving up; hand the slot on.
+		l.release(key)
+	default:
+		l.dequeue(key, c, ready)
+	}
+	metric.StreamQueueWait.Observe(time.Since(start).Seconds())
+	if errors.Is(err, ErrQueueTimeout) {
+		metric.StreamRejected.WithLabelValues("timeout").Inc()
+	}
+
+	return nil, err
+}
+
+// Middleware limits concurrent requests to next, keyed by client IP. Requests
+// that cannot get a slot are answered with 503 and a Retry-After header.
+// A nil Limiter returns next unchanged.
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := clientKey(r.RemoteAddr)
+		release, err := l.Acquire(r.Context(), key)
+		if err != nil {
+			if r.Context().Err() == nil {
+				l.Log.Warn("rejected stream request",
+					"client", key,
+					"path", r.URL.Path,
+					"error", err,
+				)
+				w.Header().Set("Retry-After", strconv.Itoa(l.retryAfter()))
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			}
+			return
+		}
+		defer release()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// available reports whether c may take a slot now. Callers must hold l.mu.
+func (l *Limiter) available(c *client) bool {
+	if l.MaxStreams > 0 && l.active >= l.MaxStreams {
+		return false
+	}
+	perClient := l.PerClient
+	if perClient <= 0 {
+		perClient = l.MaxStreams
+	}
+
+	return perClient <= 0 || c.active < perClient
+}
+
+// grant gives c a slot. Callers must hold l.mu.
+func (l *Limiter) grant(c *client) {
+	l.active++
+	c.active++
+	metric.StreamsActive.Set(float64(l.active))
+}
+
+func (l *Limiter) releaser(key string) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			l.release(key)
+		})
+	}
+}
+
+// release frees a slot held by key and hands free slots to waiting clients
+// in round-robin order. Callers must hold l.mu.
+func (l *Limiter) release(key string) {
+	c := l.clients[key]
+	c.active--
+	l.active--
+	l.forget(key, c)
+
+	for i := 0; i < len(l.order); {
+		k := l.order[i]
+		w := l.clients[k]
+		if !l.available(w) {
+			if l.MaxStreams > 0 && l.active >= l.MaxStreams {
+				break
+			}
+			i++
+			continue
+		}
+
+		ready := w.waiters[0]
+		w.waiters = w.waiters[1:]
+		l.queued--
+		l.grant(w)
+		close(ready)
+
+		// Move the client to the back of the queue, or drop it when it has
+		// nothing left waiting.
+		l.order = append(l.order[:i], l.order[i+1:]...)
+		if len(w.waiters) > 0 {
+			l.order = append(l.order, k)
+		}
+	}
+
+	metric.StreamsActive.Set(float64(l.active))
+	metric.StreamQueueDepth.Set(float64(l.queued))
+}
+
+// dequeue removes an abandoned waiter. Callers must hold l.mu.
+func (l *Limiter) dequeue(key string, c *client, ready chan struct{}) {
+	for i, w := range c.waiters {
+		if w == ready {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			l.queued--
+			break
+		}
+	}
+	if len(c.waiters) == 0 {
+		for i, k := range l.order {
+			if k == key {
+				l.order = append(l.order[:i], l.order[i+1:]...)
+				break
+			}
+		}
+	}
+	l.forget(key, c)
+	metric.StreamQueueDepth.Set(float64(l.queued))
+}
+
+// forget drops idle clients so the map does not grow without bound.
+// Callers must hold l.mu.
+func (l *Limiter) forget(key string, c *client) {
+	if c.active == 0 && len(c.waiters) == 0 {
+		delete(l.clients, key)
+	}
+}
+
+func (l *Limiter) retryAfter() int {
+	if l.QueueTimeout > 0 {
+		return int(l.QueueTimeout.Seconds())
+	}
+
+	return 30
+}
+
+func clientKey(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+
+	return host
+}
@@ -0,0 +1,132 @@
+package streamlimit
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func mustAcquire(t *testing.T, l *Limiter, key string) func() {
+	t.Helper()
+	release, err := l.Acquire(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Acquire(%s) error = %v", key, err)
+	}
+	return release
+}
+
+// acquireAsync starts an Acquire and reports the key on granted once it succeeds.
+func acquireAsync(l *Limiter, key string, granted chan<- string) {
+	go func() {
+		release, err := l.Acquire(context.Background(), key)
+		if err != nil {
+			return
+		}
+		granted <- key
+		_ = release
+	}()
+}
+
+func waitQueued(t *testing.T, l *Limiter, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		l.mu.Lock()
+		q := l.queued
+		l.mu.Unlock()
+		if q == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("queue never reached %d", n)
+}
+
+func TestRoundRobinBetweenClients(t *testing.T) {
+	l := &Limiter{MaxStreams: 1, PerClient: 1}
+	release := mustAcquire(t, l, "a")
+
+	granted := make(chan string, 4)
+	// Client a queues three requests before b queues one; b must not wait
+	// for all of a's.
+	for range 3 {
+		acquireAsync(l, "a", granted)
+	}
+	waitQueued(t, l, 3)
+	acquireAsync(l, "b", granted)
+	waitQueued(t, l, 4)
+
+	release()
+	first := <-granted
+	l.releaser(first)()
+	second := <-granted
+
+	if first != "a" || second != "b" {
+		t.Errorf("grant order = %s, %s; want a, b", first, second)
+	}
+}
+
+func TestPerClientLimit(t *testing.T) {
+	l := &Limiter{MaxStreams: 3, PerClient: 1}
+	mustAcquire(t, l, "a")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := l.Acquire(ctx, "a"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("second Acquire(a) error = %v, want it to wait", err)
+	}
+	mustAcquire(t, l, "b")
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.queued != 0 {
+		t.Errorf("queued = %d after abandoned wait, want 0", l.queued)
+	}
+}
+
+func TestQueueLimits(t *testing.T) {
+	l := &Limiter{MaxStreams: 1, MaxQueue: 1, QueueTimeout: 20 * time.Millisecond}
+	mustAcquire(t, l, "a")
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := l.Acquire(context.Background(), "b")
+		done <- err
+	}()
+	waitQueued(t, l, 1)
+
+	if _, err := l.Acquire(context.Background(), "c"); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Acquire with full queue error = %v, want ErrQueueFull", err)
+	}
+	if err := <-done; !errors.Is(err, ErrQueueTimeout) {
+		t.Errorf("queued Acquire error = %v, want ErrQueueTimeout", err)
+	}
+}
+
+func TestMiddlewareRejects(t *testing.T) {
+	l := &Limiter{
+		MaxStreams:   1,
+		MaxQueue:     1,
+		QueueTimeout: 10 * time.Millisecond,
+		Log:          slog.New(slog.DiscardHandler),
+	}
+	release := mustAcquire(t, l, "192.0.2.1")
+	defer release()
+
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/iso/aa-bb-cc-dd-ee-ff/boot.iso", nil)
+	req.RemoteAddr = "192.0.2.2:1234"
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("status = %d, Retry-After = %q; want 503 with Retry-After",
+			rec.Code, rec.Header().Get("Retry-After"))
+	}
+}
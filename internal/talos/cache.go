@@ -8,6 +8,9 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+
+	"github.com/metal3-community/metal-boot/internal/imagecache"
+	"github.com/metal3-community/metal-boot/internal/logging"
 )
 
 // CacheManager handles caching of Talos images.
@@ -42,12 +45,15 @@ func (cm *CacheManager) Exists(key string) bool {
 
 	// Both files must exist
 	if _, err := os.Stat(filePath); err != nil {
+		imagecache.RecordMiss("talos")
 		return false
 	}
 	if _, err := os.Stat(checksumPath); err != nil {
+		imagecache.RecordMiss("talos")
 		return false
 	}
 
+	imagecache.RecordHit("talos")
 	return true
 }
 
@@ -58,6 +64,9 @@ func (cm *CacheManager) Get(key string) (*os.File, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to open cached file: %w", err)
 	}
+	if err := imagecache.Touch(filePath); err != nil {
+		cm.logger.Debug("Failed to mark cache entry as served", "key", key, "error", err)
+	}
 	return file, nil
 }
 
@@ -260,8 +269,14 @@ func (cm *CacheManager) Cleanup() error {
 
 	cm.logger.Info("Cache cleanup needed", "current_size", currentSize, "max_size", cm.maxSize)
 
-	// TODO: Implement LRU cleanup logic if needed
-	// For now, we'll just log that cleanup is needed
+	janitor := &imagecache.Janitor{
+		Dirs:   []string{cm.cacheDir},
+		Budget: cm.maxSize,
+		Log:    logging.ToLogr(cm.logger),
+	}
+	if _, err := janitor.Collect(); err != nil {
+		return fmt.Errorf("failed to evict cache entries: %w", err)
+	}
 
 	return nil
 }
@@ -0,0 +1,349 @@
+// Package task tracks the long running operations of the server, such as
+// firmware updates and power operations, which the Redfish TaskService
+// reports.
+//
+// Tasks can be written to a state file so that the outcome of an operation
+// is still known after a restart; tasks that were running when the server
+// stopped are loaded as Interrupted. All methods of a nil Store and a nil
+// Task do nothing, so tracking is optional wherever it is threaded through.
+package task
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// State is the state of a task.
+type State string
+
+const (
+	StateRunning     State = "Running"
+	StateCompleted   State = "Completed"
+	StateException   State = "Exception"
+	StateInterrupted State = "Interrupted"
+)
+
+// Severities of task messages, as in Redfish.
+const (
+	SeverityOK       = "OK"
+	SeverityWarning  = "Warning"
+	SeverityCritical = "Critical"
+)
+
+// keepFinished is the number of finished tasks a Store remembers.
+const keepFinished = 100
+
+// Message is a message recorded on a task.
+type Message struct {
+	Message  string    `json:"message"`
+	Severity string    `json:"severity"`
+	Time     time.Time `json:"time"`
+}
+
+// Info is a snapshot of a task.
+type Info struct {
+	Id          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Target is the URI of the resource the task acts on.
+	Target string `json:"target,omitempty"`
+	State  State  `json:"state"`
+	// PercentComplete is -1 while unknown.
+	PercentComplete int        `json:"percentComplete"`
+	Messages        []Message  `json:"messages,omitempty"`
+	StartTime       time.Time  `json:"startTime"`
+	EndTime         *time.Time `json:"endTime,omitempty"`
+}
+
+// Finished reports whether the task is no longer running.
+func (i Info) Finished() bool {
+	return i.State != StateRunning
+}
+
+// Store holds the tasks of the server.
+type Store struct {
+	path string
+
+	mu    sync.Mutex
+	tasks []*Info
+
+	// now is time.Now outside tests.
+	now func() time.Time
+}
+
+// New returns a Store that persists tasks to path. With an empty path tasks
+// are kept in memory only.
+func New(path string) (*Store, error) {
+	s := &Store{path: path, now: time.Now}
+	if path == "" {
+		return s, nil
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// load reads the tasks of the state file. Running tasks did not survive the
+// restart and are marked Interrupted.
+func (s *Store) load() error {
+	b, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read tasks: %w", err)
+	}
+	if err := json.Unmarshal(b, &s.tasks); err != nil {
+		return fmt.Errorf("failed to parse tasks: %w", err)
+	}
+
+	if s.interrupt("The server restarted while the task was running.") {
+		return s.save()
+	}
+
+	return nil
+}
+
+// interrupt marks the running tasks Interrupted with msg and reports whether
+// there were any. Callers must hold s.mu, or own s.
+func (s *Store) interrupt(msg string) bool {
+	interrupted := false
+	for _, t := range s.tasks {
+		if t.State != StateRunning {
+			continue
+		}
+		now := s.now().UTC()
+		t.State = StateInterrupted
+		t.EndTime = &now
+		t.Messages = append(t.Messages, Message{
+			Message:  msg,
+			Severity: SeverityWarning,
+			Time:     now,
+		})
+		interrupted = true
+	}
+
+	return interrupted
+}
+
+// Import replaces the tasks of s with tasks, such as the tasks of a snapshot
+// taken by another instance, and persists them. The operations of running
+// tasks did not move along with them, so they are marked Interrupted.
+func (s *Store) Import(tasks []Info) error {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tasks = make([]*Info, 0, len(tasks))
+	for _, t := range tasks {
+		c := t.snapshot()
+		s.tasks = append(s.tasks, &c)
+	}
+	s.interrupt("The instance running the task failed over.")
+	s.prune()
+
+	return s.save()
+}
+
+// save writes the tasks to the state file. Callers must hold s.mu.
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	b, err := json.Marshal(s.tasks)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create task directory: %w", err)
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return fmt.Errorf("failed to write tasks: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to replace tasks: %w", err)
+	}
+
+	return nil
+}
+
+// prune forgets the oldest finished tasks beyond keepFinished. Callers must
+// hold s.mu.
+func (s *Store) prune() {
+	finished := 0
+	for i := len(s.tasks) - 1; i >= 0; i-- {
+		if !s.tasks[i].Finished() {
+			continue
+		}
+		if finished++; finished > keepFinished {
+			s.tasks = slices.Delete(s.tasks, i, i+1)
+		}
+	}
+}
+
+// Task is a running task, through which its operation reports progress.
+type Task struct {
+	s  *Store
+	id string
+}
+
+// Start records a running task. id must be unique among the tasks of s and
+// is used in URLs; a task with the same id is replaced.
+func (s *Store) Start(id, name, description, target string) *Task {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tasks = slices.DeleteFunc(s.tasks, func(t *Info) bool { return t.Id == id })
+	s.tasks = append(s.tasks, &Info{
+		Id:              id,
+		Name:            name,
+		Description:     description,
+		Target:          target,
+		State:           StateRunning,
+		PercentComplete: -1,
+		StartTime:       s.now().UTC(),
+	})
+	s.prune()
+	// A failed write only loses the task across a restart.
+	_ = s.save()
+
+	return &Task{s: s, id: id}
+}
+
+// List returns the tasks of s, oldest first.
+func (s *Store) List() []Info {
+	if s == nil {
+		return []Info{}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]Info, 0, len(s.tasks))
+	for _, t := range s.tasks {
+		list = append(list, t.snapshot())
+	}
+
+	return list
+}
+
+// Get returns the task with id.
+func (s *Store) Get(id string) (Info, bool) {
+	if s == nil {
+		return Info{}, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t := s.find(id); t != nil {
+		return t.snapshot(), true
+	}
+
+	return Info{}, false
+}
+
+// find returns the task with id, or nil. Callers must hold s.mu.
+func (s *Store) find(id string) *Info {
+	for _, t := range s.tasks {
+		if t.Id == id {
+			return t
+		}
+	}
+
+	return nil
+}
+
+func (i *Info) snapshot() Info {
+	c := *i
+	c.Messages = slices.Clone(i.Messages)
+	if i.EndTime != nil {
+		end := *i.EndTime
+		c.EndTime = &end
+	}
+
+	return c
+}
+
+// ID returns the id of t.
+func (t *Task) ID() string {
+	if t == nil {
+		return ""
+	}
+
+	return t.id
+}
+
+// update applies fn to the task while it runs, and saves the tasks if fn
+// reports a change worth persisting.
+func (t *Task) update(fn func(i *Info, now time.Time) bool) {
+	if t == nil {
+		return
+	}
+
+	t.s.mu.Lock()
+	defer t.s.mu.Unlock()
+
+	i := t.s.find(t.id)
+	if i == nil || i.Finished() {
+		return
+	}
+	if fn(i, t.s.now().UTC()) {
+		_ = t.s.save()
+	}
+}
+
+// SetPercent records how much of the task is done. It is persisted with the
+// next message or state change.
+func (t *Task) SetPercent(percent int) {
+	t.update(func(i *Info, _ time.Time) bool {
+		i.PercentComplete = min(max(percent, 0), 100)
+		return false
+	})
+}
+
+// Message records a message on the task.
+func (t *Task) Message(severity, msg string) {
+	t.update(func(i *Info, now time.Time) bool {
+		i.Messages = append(i.Messages, Message{Message: msg, Severity: severity, Time: now})
+		return true
+	})
+}
+
+// Done finishes the task: Completed if err is nil, Exception with err as a
+// message otherwise.
+func (t *Task) Done(err error) {
+	t.update(func(i *Info, now time.Time) bool {
+		i.EndTime = &now
+		if err != nil {
+			i.State = StateException
+			i.Messages = append(i.Messages, Message{
+				Message:  err.Error(),
+				Severity: SeverityCritical,
+				Time:     now,
+			})
+		} else {
+			i.State = StateCompleted
+			i.PercentComplete = 100
+		}
+		t.s.prune()
+		return true
+	})
+}
@@ -0,0 +1,95 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestLifecycle(t *testing.T) {
+	s, err := New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok := s.Start("ok", "Power Task", "", "/redfish/v1/Systems/1")
+	ok.SetPercent(40)
+	if i, _ := s.Get("ok"); i.State != StateRunning || i.PercentComplete != 40 {
+		t.Errorf("running task = %s at %d%%, want Running at 40%%", i.State, i.PercentComplete)
+	}
+	ok.Done(nil)
+	if i, _ := s.Get("ok"); i.State != StateCompleted || i.PercentComplete != 100 ||
+		i.EndTime == nil {
+		t.Errorf("completed task = %+v", i)
+	}
+	// Finished tasks no longer change.
+	ok.Done(errors.New("late"))
+	if i, _ := s.Get("ok"); i.State != StateCompleted {
+		t.Errorf("task state after a second Done = %s, want Completed", i.State)
+	}
+
+	failed := s.Start("failed", "Firmware Update Task", "", "")
+	failed.Done(errors.New("boom"))
+	i, _ := s.Get("failed")
+	if i.State != StateException || len(i.Messages) != 1 || i.Messages[0].Message != "boom" {
+		t.Errorf("failed task = %+v", i)
+	}
+
+	if got := len(s.List()); got != 2 {
+		t.Errorf("List() has %d tasks, want 2", got)
+	}
+	if _, found := s.Get("missing"); found {
+		t.Error("Get() of an unknown task found it")
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.json")
+	s, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Start("done", "Done", "", "").Done(nil)
+	s.Start("running", "Running", "", "").Message(SeverityOK, "half way")
+
+	s, err = New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i, _ := s.Get("done"); i.State != StateCompleted {
+		t.Errorf("reloaded finished task = %s, want Completed", i.State)
+	}
+	i, _ := s.Get("running")
+	if i.State != StateInterrupted || len(i.Messages) != 2 {
+		t.Errorf("reloaded running task = %s with %d messages, want Interrupted with 2",
+			i.State, len(i.Messages))
+	}
+}
+
+func TestPrune(t *testing.T) {
+	s, _ := New("")
+	running := s.Start("running", "Running", "", "")
+	for n := range keepFinished + 5 {
+		s.Start(fmt.Sprint(n), "Done", "", "").Done(nil)
+	}
+	if got := len(s.List()); got != keepFinished+1 {
+		t.Errorf("List() has %d tasks, want %d", got, keepFinished+1)
+	}
+	if _, found := s.Get(running.ID()); !found {
+		t.Error("running task was pruned")
+	}
+	if _, found := s.Get("0"); found {
+		t.Error("oldest finished task was kept")
+	}
+}
+
+func TestNil(t *testing.T) {
+	var s *Store
+	task := s.Start("id", "name", "", "")
+	task.SetPercent(10)
+	task.Done(nil)
+	if len(s.List()) != 0 || task.ID() != "" {
+		t.Error("nil store recorded a task")
+	}
+}
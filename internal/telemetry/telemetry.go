@@ -0,0 +1,274 @@
+// Package telemetry samples per-host readings (temperature, PoE power draw
+// and boot counters) at a fixed interval and turns them into metric reports
+// that are kept for reads and streamed to subscribers.
+package telemetry
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/metal3-community/metal-boot/internal/backend"
+	"github.com/metal3-community/metal-boot/internal/hoststate"
+)
+
+// Definition describes a metric report.
+type Definition struct {
+	Id          string
+	Name        string
+	Description string
+	// Metrics are the ids of the metrics in each report.
+	Metrics []string
+	// Units is the UCUM unit of the metric values, if they have one.
+	Units string
+}
+
+// Metric report definitions.
+const (
+	TemperatureReport = "Temperature"
+	PoEPowerReport    = "PoEPower"
+	BootCounterReport = "BootCounters"
+)
+
+// Definitions lists the reports generated by a Service.
+var Definitions = []Definition{
+	{
+		Id:          TemperatureReport,
+		Name:        "Host temperature",
+		Description: "Temperature of every host, from power backends that report it.",
+		Metrics:     []string{"Temperature"},
+		Units:       "Cel",
+	},
+	{
+		Id:          PoEPowerReport,
+		Name:        "PoE power draw",
+		Description: "Power drawn by every host from its PoE switch port.",
+		Metrics:     []string{"PoEPower"},
+		Units:       "W",
+	},
+	{
+		Id:   BootCounterReport,
+		Name: "Boot counters",
+		Description: "Consecutive netboot attempts that did not reach the iPXE script, " +
+			"and whether the host was put into netboot fallback.",
+		Metrics: []string{"BootAttempts", "NetbootFallback"},
+	},
+}
+
+// DefinitionByID returns the definition with id.
+func DefinitionByID(id string) (Definition, bool) {
+	for _, d := range Definitions {
+		if d.Id == id {
+			return d, true
+		}
+	}
+
+	return Definition{}, false
+}
+
+// TemperatureReader is implemented by power backends that can read the
+// temperature of a host.
+type TemperatureReader interface {
+	Temperature(ctx context.Context, mac net.HardwareAddr) (celsius float64, err error)
+}
+
+// PoEReader is implemented by power backends that can read the power a host
+// draws from its PoE port.
+type PoEReader interface {
+	PoEPower(ctx context.Context, mac net.HardwareAddr) (watts float64, err error)
+}
+
+// Value is one reading of a report.
+type Value struct {
+	Metric    string
+	MAC       string
+	Value     float64
+	Timestamp time.Time
+}
+
+// Report is one generation of a Definition.
+type Report struct {
+	// Sequence orders the reports of a Service across definitions.
+	Sequence   uint64
+	Definition string
+	Timestamp  time.Time
+	Values     []Value
+}
+
+// historySize is the number of reports kept for subscribers that reconnect.
+const historySize = 64
+
+// Service generates a report for every Definition each Interval.
+type Service struct {
+	Reader backend.BackendReader
+	// Power is checked for TemperatureReader and PoEReader.
+	Power backend.BackendPower
+	Hosts *hoststate.Store
+	// Interval is the time between reports in Run.
+	Interval time.Duration
+	Log      logr.Logger
+
+	mu      sync.Mutex
+	seq     uint64
+	latest  map[string]Report
+	history []Report
+	subs    map[chan Report]struct{}
+}
+
+// Run reports once immediately and then every Interval until ctx is done.
+func (s *Service) Run(ctx context.Context) error {
+	interval := s.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.Collect(ctx)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Collect samples every host and publishes one report per Definition.
+func (s *Service) Collect(ctx context.Context) []Report {
+	now := time.Now().UTC()
+	values := make(map[string][]Value)
+
+	macs, err := s.Reader.GetKeys(ctx)
+	if err != nil {
+		s.Log.Error(err, "failed to list hosts for telemetry")
+	}
+	temps, _ := FindPower[TemperatureReader](s.Power)
+	poe, _ := FindPower[PoEReader](s.Power)
+
+	for _, mac := range macs {
+		key := mac.String()
+		if temps != nil {
+			if c, err := temps.Temperature(ctx, mac); err == nil {
+				values[TemperatureReport] = append(values[TemperatureReport],
+					Value{Metric: "Temperature", MAC: key, Value: c, Timestamp: now})
+			} else {
+				s.Log.V(1).Info("failed to read temperature", "mac", key, "error", err)
+			}
+		}
+		if poe != nil {
+			if w, err := poe.PoEPower(ctx, mac); err == nil {
+				values[PoEPowerReport] = append(values[PoEPowerReport],
+					Value{Metric: "PoEPower", MAC: key, Value: w, Timestamp: now})
+			} else {
+				s.Log.V(1).Info("failed to read PoE power", "mac", key, "error", err)
+			}
+		}
+		if s.Hosts == nil {
+			continue
+		}
+		if h, err := s.Hosts.Get(mac); err == nil {
+			attempts, fallback := float64(h.BootAttempts), 0.0
+			if h.NetbootFallback {
+				fallback = 1
+			}
+			values[BootCounterReport] = append(values[BootCounterReport],
+				Value{Metric: "BootAttempts", MAC: key, Value: attempts, Timestamp: now},
+				Value{Metric: "NetbootFallback", MAC: key, Value: fallback, Timestamp: now},
+			)
+		}
+	}
+
+	reports := make([]Report, 0, len(Definitions))
+	for _, d := range Definitions {
+		reports = append(reports, s.publish(Report{
+			Definition: d.Id,
+			Timestamp:  now,
+			Values:     values[d.Id],
+		}))
+	}
+
+	return reports
+}
+
+// FindPower returns p, or a backend it wraps, as a T.
+func FindPower[T any](p backend.BackendPower) (T, bool) {
+	for p != nil {
+		if t, ok := p.(T); ok {
+			return t, true
+		}
+		u, ok := p.(interface{ Unwrap() backend.BackendPower })
+		if !ok {
+			break
+		}
+		p = u.Unwrap()
+	}
+
+	var zero T
+	return zero, false
+}
+
+// publish numbers r, stores it and sends it to every subscriber. Subscribers
+// that are not keeping up miss it.
+func (s *Service) publish(r Report) Report {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	r.Sequence = s.seq
+	if s.latest == nil {
+		s.latest = make(map[string]Report)
+	}
+	s.latest[r.Definition] = r
+	s.history = append(s.history, r)
+	if len(s.history) > historySize {
+		s.history = s.history[len(s.history)-historySize:]
+	}
+
+	for ch := range s.subs {
+		select {
+		case ch <- r:
+		default:
+		}
+	}
+
+	return r
+}
+
+// Latest returns the most recent report of the definition id.
+func (s *Service) Latest(id string) (Report, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.latest[id]
+	return r, ok
+}
+
+// Subscribe returns the kept reports with a Sequence above after and a channel
+// receiving every report published from now on. cancel must be called once
+// the subscriber is done.
+func (s *Service) Subscribe(after uint64) (replay []Report, reports <-chan Report, cancel func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, r := range s.history {
+		if r.Sequence > after {
+			replay = append(replay, r)
+		}
+	}
+
+	ch := make(chan Report, len(Definitions))
+	if s.subs == nil {
+		s.subs = make(map[chan Report]struct{})
+	}
+	s.subs[ch] = struct{}{}
+
+	return replay, ch, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.subs, ch)
+	}
+}
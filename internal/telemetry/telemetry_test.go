@@ -0,0 +1,145 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/metal3-community/metal-boot/internal/backend"
+	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+	"github.com/metal3-community/metal-boot/internal/hoststate"
+)
+
+var (
+	macA = net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0x01}
+	macB = net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0x02}
+)
+
+type fakeBackend struct{}
+
+func (fakeBackend) GetByMac(context.Context, net.HardwareAddr) (*data.DHCP, *data.Netboot, error) {
+	return &data.DHCP{}, &data.Netboot{}, nil
+}
+
+func (fakeBackend) GetByIP(context.Context, net.IP) (*data.DHCP, *data.Netboot, error) {
+	return nil, nil, errors.New("not implemented")
+}
+
+func (fakeBackend) GetKeys(context.Context) ([]net.HardwareAddr, error) {
+	return []net.HardwareAddr{macA, macB}, nil
+}
+
+func (fakeBackend) GetPower(context.Context, net.HardwareAddr) (*data.PowerState, error) {
+	state := data.PowerOn
+	return &state, nil
+}
+
+func (fakeBackend) SetPower(context.Context, net.HardwareAddr, data.PowerState) error { return nil }
+
+func (fakeBackend) PowerCycle(context.Context, net.HardwareAddr) error { return nil }
+
+func (fakeBackend) PoEPower(_ context.Context, mac net.HardwareAddr) (float64, error) {
+	if mac.String() == macB.String() {
+		return 0, errors.New("port not found")
+	}
+	return 4.5, nil
+}
+
+// wrapped hides the PoE readings of its backend behind Unwrap.
+type wrapped struct{ next backend.BackendPower }
+
+func (w wrapped) GetPower(ctx context.Context, mac net.HardwareAddr) (*data.PowerState, error) {
+	return w.next.GetPower(ctx, mac)
+}
+
+func (w wrapped) SetPower(ctx context.Context, mac net.HardwareAddr, state data.PowerState) error {
+	return w.next.SetPower(ctx, mac, state)
+}
+
+func (w wrapped) PowerCycle(ctx context.Context, mac net.HardwareAddr) error {
+	return w.next.PowerCycle(ctx, mac)
+}
+
+func (w wrapped) Unwrap() backend.BackendPower { return w.next }
+
+func TestCollect(t *testing.T) {
+	hosts, err := hoststate.NewStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := hosts.Update(macB, func(h *hoststate.Host) {
+		h.BootAttempts = 3
+		h.NetbootFallback = true
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Service{
+		Reader: fakeBackend{},
+		Power:  wrapped{next: fakeBackend{}},
+		Hosts:  hosts,
+		Log:    logr.Discard(),
+	}
+	reports := s.Collect(context.Background())
+	if len(reports) != len(Definitions) {
+		t.Fatalf("Collect() returned %d reports, want %d", len(reports), len(Definitions))
+	}
+
+	byID := make(map[string]Report)
+	for i, r := range reports {
+		if r.Sequence != uint64(i+1) {
+			t.Errorf("report %s has sequence %d, want %d", r.Definition, r.Sequence, i+1)
+		}
+		byID[r.Definition] = r
+	}
+
+	if v := byID[TemperatureReport].Values; len(v) != 0 {
+		t.Errorf("temperature values = %v, want none without a TemperatureReader", v)
+	}
+	poe := byID[PoEPowerReport].Values
+	if len(poe) != 1 || poe[0].MAC != macA.String() || poe[0].Value != 4.5 {
+		t.Errorf("PoE values = %+v, want 4.5 W for %s", poe, macA)
+	}
+	boot := byID[BootCounterReport].Values
+	if len(boot) != 2 || boot[0].Metric != "BootAttempts" || boot[0].Value != 3 ||
+		boot[1].Metric != "NetbootFallback" || boot[1].Value != 1 {
+		t.Errorf("boot counter values = %+v, want 3 attempts and fallback for %s", boot, macB)
+	}
+
+	if r, ok := s.Latest(PoEPowerReport); !ok || r.Sequence != byID[PoEPowerReport].Sequence {
+		t.Errorf("Latest(%q) = %+v, %v", PoEPowerReport, r, ok)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	s := &Service{Reader: fakeBackend{}, Log: logr.Discard()}
+	s.Collect(context.Background())
+
+	replay, reports, cancel := s.Subscribe(1)
+	defer cancel()
+	if len(replay) != len(Definitions)-1 || replay[0].Sequence != 2 {
+		t.Errorf("replay = %+v, want the reports after sequence 1", replay)
+	}
+
+	s.Collect(context.Background())
+	for range Definitions {
+		select {
+		case r := <-reports:
+			if r.Sequence <= uint64(len(Definitions)) {
+				t.Errorf("received report %d from before subscribing", r.Sequence)
+			}
+		default:
+			t.Fatal("subscriber did not receive the new reports")
+		}
+	}
+
+	cancel()
+	s.Collect(context.Background())
+	select {
+	case r := <-reports:
+		t.Errorf("received report %d after cancel", r.Sequence)
+	default:
+	}
+}
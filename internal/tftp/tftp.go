@@ -11,13 +11,20 @@ import (
 	"net/netip"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strings"
 
 	"github.com/go-logr/logr"
 	"github.com/metal3-community/metal-boot/internal/backend"
+	"github.com/metal3-community/metal-boot/internal/bandwidth"
+	"github.com/metal3-community/metal-boot/internal/bootflow"
 	"github.com/metal3-community/metal-boot/internal/dhcp/data"
+	"github.com/metal3-community/metal-boot/internal/faultinject"
+	"github.com/metal3-community/metal-boot/internal/gpufw"
+	"github.com/metal3-community/metal-boot/internal/hoststate"
+	"github.com/metal3-community/metal-boot/internal/integrity"
 	"github.com/metal3-community/metal-boot/internal/ipxe/binary"
+	"github.com/metal3-community/metal-boot/internal/metric"
+	"github.com/metal3-community/metal-boot/internal/nodefs"
 	"github.com/metal3-community/uefi-firmware-manager/edk2"
 	"github.com/metal3-community/uefi-firmware-manager/manager"
 	"github.com/pin/tftp/v3"
@@ -26,7 +33,32 @@ import (
 type Server struct {
 	Logger        logr.Logger
 	RootDirectory string
-	Patch         string
+	// Patch is the script patched into iPXE binaries when Patches is unset.
+	Patch string
+	// Patches, when set, select the patch slots of the iPXE binaries each
+	// host gets. The script of a backend's netboot data takes precedence
+	// over the default script slot but not over a per-MAC one.
+	Patches *binary.Patcher
+	// Hosts, when set, records the files each host fetched as its observed
+	// boot source.
+	Hosts *hoststate.Store
+	// Integrity, when set, serves checksum manifests and signatures for
+	// files that do not have them on disk.
+	Integrity *integrity.Manifests
+	// GPUFirmware, when set, serves the Raspberry Pi VideoCore boot files of
+	// the version each host is pinned to.
+	GPUFirmware *gpufw.Store
+	// BootFlows, when set, tags log lines and observed boot sources with the
+	// correlation ID of the boot started by the client's DHCPDISCOVER.
+	BootFlows *bootflow.Registry
+	// Faults, when set, delays or fails a share of reads for resiliency
+	// testing.
+	Faults *faultinject.Fault
+	// Bandwidth, when set, limits the rate at which files are sent.
+	Bandwidth *bandwidth.Shaper
+	// Writes, when set, accepts write requests that it allows. Without it,
+	// write requests are refused.
+	Writes *WritePolicy
 }
 
 type Handler struct {
@@ -34,8 +66,18 @@ type Handler struct {
 	RootDirectory string
 	Patch         string
 	Log           logr.Logger
+	patches       *binary.Patcher
 	backend       backend.BackendReader
 	firmware      *manager.SimpleFirmwareManager
+	hosts         *hoststate.Store
+	manifests     *integrity.Manifests
+	gpu           *gpufw.Store
+	flows         *bootflow.Registry
+	faults        *faultinject.Fault
+	bandwidth     *bandwidth.Shaper
+	writes        *WritePolicy
+	// bootID is the correlation ID of the boot a request belongs to.
+	bootID string
 }
 
 // ListenAndServe sets up the listener and serves TFTP requests.
@@ -53,7 +95,15 @@ func (s *Server) ListenAndServe(
 		RootDirectory: s.RootDirectory,
 		Patch:         s.Patch,
 		Log:           s.Logger,
+		patches:       s.Patches,
 		backend:       backend,
+		hosts:         s.Hosts,
+		manifests:     s.Integrity,
+		gpu:           s.GPUFirmware,
+		flows:         s.BootFlows,
+		faults:        s.Faults,
+		bandwidth:     s.Bandwidth,
+		writes:        s.Writes,
 	}
 
 	var err error
@@ -97,11 +147,15 @@ func (s *Server) ListenAndServe(
 }
 
 func (h *Handler) OnSuccess(stats tftp.TransferStats) {
-	h.Log.Info("transfer complete", "remote", stats.RemoteAddr, "path", stats.Filename)
+	h.Log.Info("transfer complete", "remote_addr", stats.RemoteAddr, "path", stats.Filename)
+	metric.TFTPTransfers.WithLabelValues("success").Inc()
+	metric.TFTPTransferDuration.WithLabelValues("success").Observe(stats.Duration.Seconds())
 }
 
 func (h *Handler) OnFailure(stats tftp.TransferStats, err error) {
-	h.Log.Error(err, "transfer failed", "remote", stats.RemoteAddr, "path", stats.Filename)
+	h.Log.Error(err, "transfer failed", "remote_addr", stats.RemoteAddr, "path", stats.Filename)
+	metric.TFTPTransfers.WithLabelValues("failure").Inc()
+	metric.TFTPTransferDuration.WithLabelValues("failure").Observe(stats.Duration.Seconds())
 }
 
 // HandleRead handles TFTP GET requests.
@@ -124,10 +178,23 @@ func (h *Handler) HandleRead(fullfilepath string, rf io.ReaderFrom) (err error)
 		return fmt.Errorf("nil ReaderFrom parameter")
 	}
 
+	if err := h.faults.Inject(h.ctx); err != nil {
+		h.Log.Info("failing read with injected fault", "path", fullfilepath, "error", err)
+		return err
+	}
+
+	if ot, ok := rf.(tftp.OutgoingTransfer); ok && h.bandwidth != nil {
+		rf = &shapedTransfer{OutgoingTransfer: ot, rf: rf, ctx: h.ctx, shaper: h.bandwidth}
+	}
+	if ot, ok := rf.(tftp.OutgoingTransfer); ok {
+		rf = &countedTransfer{OutgoingTransfer: ot, rf: rf}
+	}
+
 	dhcpInfo, netboot, err := h.getDHCPInfo(rf)
 	if err != nil {
 		h.Log.Info("could not get DHCP info, proceeding without it", "error", err)
 	}
+	h = h.withBootFlow(rf, dhcpInfo)
 
 	filename := filepath.Base(fullfilepath)
 
@@ -146,8 +213,11 @@ func (h *Handler) HandleRead(fullfilepath string, rf io.ReaderFrom) (err error)
 			h.Log.Error(err, "failed to get firmware reader")
 			return err
 		}
-		_, err = rf.ReadFrom(reader)
-		return err
+		if _, err = rf.ReadFrom(reader); err != nil {
+			return err
+		}
+		h.observe(dhcpInfo, rf, fullfilepath, "firmware")
+		return nil
 	case "autoexec.ipxe":
 		_, err = rf.ReadFrom(bytes.NewReader([]byte("#!ipxe\n\n"))) // Serve a minimal iPXE script
 		return err
@@ -155,15 +225,48 @@ func (h *Handler) HandleRead(fullfilepath string, rf io.ReaderFrom) (err error)
 
 	// Serve iPXE binaries if requested
 	if content, ok := binary.Files[filename]; ok {
-		patch := h.Patch
-		if netboot != nil && len(netboot.IPXEScript) > 1 {
-			patch = netboot.IPXEScript
+		var mac net.HardwareAddr
+		if dhcpInfo != nil {
+			mac = dhcpInfo.MACAddress
+		}
+		if err := h.serveIPXE(rf, content, h.ipxePatches(mac, netboot)); err != nil {
+			return err
+		}
+		h.observe(dhcpInfo, rf, fullfilepath, "ipxe")
+		return nil
+	}
+
+	// Serve VideoCore boot files from the version the host is pinned to
+	if h.gpu != nil && dhcpInfo != nil && gpufw.IsBootFile(filename) {
+		content, version, err := h.gpu.Open(dhcpInfo.MACAddress, filename)
+		if err == nil {
+			h.Log.V(1).Info("serving gpu firmware",
+				"path", fullfilepath,
+				"mac", dhcpInfo.MACAddress.String(),
+				"version", version,
+			)
+			return h.serveContent(rf, content)
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			h.Log.Error(err, "failed to read gpu firmware",
+				"path", fullfilepath,
+				"version", version,
+			)
 		}
-		return h.serveIPXE(rf, content, patch)
 	}
 
-	// Resolve the file path, potentially swapping a serial for a MAC address
+	// Resolve the file path, potentially swapping a serial for a MAC address,
+	// then against the host's own directory
 	resolvedPath := h.resolvePath(fullfilepath, dhcpInfo)
+	var mac net.HardwareAddr
+	if dhcpInfo != nil {
+		mac = dhcpInfo.MACAddress
+	}
+	resolvedPath, err = nodefs.Resolve(h.RootDirectory, mac, resolvedPath)
+	if err != nil {
+		h.Log.Info("refusing read of another host's file", "path", fullfilepath, "error", err)
+		return os.ErrNotExist
+	}
 
 	// Serve from the filesystem if the file exists
 	root, err := NewRoot(h.RootDirectory)
@@ -174,8 +277,11 @@ func (h *Handler) HandleRead(fullfilepath string, rf io.ReaderFrom) (err error)
 
 	if file, err := root.Open(resolvedPath); err == nil {
 		defer file.Close()
-		_, err := rf.ReadFrom(file)
-		return err
+		if _, err := rf.ReadFrom(file); err != nil {
+			return err
+		}
+		h.observe(dhcpInfo, rf, resolvedPath, "file")
+		return nil
 	}
 
 	// If not on the filesystem, try serving from embedded EDK2 files
@@ -194,6 +300,17 @@ func (h *Handler) HandleRead(fullfilepath string, rf io.ReaderFrom) (err error)
 		}
 	}
 
+	if h.manifests.IsDerived(resolvedPath) {
+		content, err := h.manifests.Derived(h.RootDirectory, resolvedPath)
+		if err == nil {
+			return h.serveContent(rf, content)
+		}
+		if !errors.Is(err, os.ErrNotExist) && !errors.Is(err, integrity.ErrNotDerived) {
+			h.Log.Error(err, "failed to compute integrity manifest", "path", resolvedPath)
+			return err
+		}
+	}
+
 	h.Log.Info("file not found", "path", fullfilepath, "resolvedPath", resolvedPath)
 	return os.ErrNotExist
 }
@@ -218,39 +335,88 @@ func (h *Handler) HandleWrite(fullfilepath string, wt io.WriterTo) (err error) {
 		return fmt.Errorf("nil WriterTo parameter")
 	}
 
+	if h.writes == nil {
+		return fmt.Errorf("%w: TFTP writes are disabled", os.ErrPermission)
+	}
+
+	var mac net.HardwareAddr
 	dhcpInfo, _, err := h.getDHCPInfo(wt)
 	if err != nil {
 		h.Log.Info("could not get DHCP info, proceeding without it", "error", err)
+	} else {
+		mac = dhcpInfo.MACAddress
 	}
 
-	resolvedPath := h.resolvePath(fullfilepath, dhcpInfo)
+	name, err := h.writes.target(fullfilepath, mac)
+	if err != nil {
+		h.Log.Info("refusing TFTP write", "path", fullfilepath, "error", err)
+		return err
+	}
 
-	root, err := NewRoot(h.RootDirectory)
+	dir := h.writes.Directory
+	if dir == "" {
+		dir = h.RootDirectory
+	}
+	n, err := h.writes.write(dir, name, wt)
 	if err != nil {
-		return fmt.Errorf("failed to open root directory: %w", err)
+		return err
 	}
-	defer root.Close()
 
-	dir := filepath.Dir(resolvedPath)
-	if !root.Exists(dir) {
-		if err := root.MkdirAll(dir, 0o755); err != nil {
-			return fmt.Errorf("failed to create directory %s: %w", dir, err)
-		}
+	h.Log.Info("file written successfully", "path", name, "bytes", n)
+	return nil
+}
+
+// observe records file as the observed boot source of the host behind dhcpInfo.
+// Only transfers that can be tied to a host are recorded.
+func (h *Handler) observe(dhcpInfo *data.DHCP, rf io.ReaderFrom, file, profile string) {
+	if h.hosts == nil || dhcpInfo == nil || dhcpInfo.MACAddress == nil {
+		return
 	}
 
-	file, err := root.OpenFile(resolvedPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
-	if err != nil {
-		return fmt.Errorf("failed to open file for writing %s: %w", resolvedPath, err)
+	src := hoststate.BootSource{
+		Protocol: hoststate.ProtocolTFTP,
+		File:     file,
+		Profile:  profile,
+		BootID:   h.bootID,
+	}
+	if a, ok := binary.Lookup(filepath.Base(file)); ok && profile == "ipxe" {
+		src.SHA256, src.GitRev = a.SHA256, a.GitRev
+	}
+	if ip, err := getRemoteIP(rf); err == nil {
+		src.RemoteAddr = ip.String()
 	}
-	defer file.Close()
 
-	n, err := wt.WriteTo(file)
-	if err != nil {
-		return fmt.Errorf("failed to write to file %s: %w", resolvedPath, err)
+	if err := h.hosts.RecordBootSource(dhcpInfo.MACAddress, src); err != nil {
+		h.Log.Error(err, "failed to record observed boot source", "mac", dhcpInfo.MACAddress.String())
 	}
+}
 
-	h.Log.Info("file written successfully", "path", resolvedPath, "bytes", n)
-	return nil
+// withBootFlow returns a copy of h that tags its logs and observations with
+// the correlation ID of the requesting client's current boot, or h itself
+// when there is none.
+func (h *Handler) withBootFlow(rf io.ReaderFrom, dhcpInfo *data.DHCP) *Handler {
+	if h.flows == nil {
+		return h
+	}
+
+	ip, _ := getRemoteIP(rf)
+	var id string
+	if dhcpInfo != nil && dhcpInfo.MACAddress != nil {
+		id = h.flows.ForMAC(dhcpInfo.MACAddress)
+		// With proxy DHCP the client address is first seen here.
+		h.flows.BindIP(dhcpInfo.MACAddress, ip)
+	} else {
+		id = h.flows.ForIP(ip)
+	}
+	if id == "" {
+		return h
+	}
+
+	scoped := *h
+	scoped.Log = h.Log.WithValues(bootflow.LogKey, id)
+	scoped.bootID = id
+
+	return &scoped
 }
 
 func (h *Handler) getDHCPInfo(r any) (*data.DHCP, *data.Netboot, error) {
@@ -300,8 +466,7 @@ func (h *Handler) resolvePath(fullfilepath string, dhcpInfo *data.DHCP) string {
 	mac := dhcpInfo.MACAddress.String()
 	macDir := strings.ReplaceAll(mac, ":", "-")
 
-	isSerial, _ := regexp.MatchString(`^\d{2}[a-z]\d{5}$`, prefix)
-	if isSerial {
+	if serialPrefix.MatchString(prefix) {
 		if filename == edk2.FirmwareFileName {
 			return strings.Replace(fullfilepath, prefix, macDir, 1)
 		} else {
@@ -312,12 +477,25 @@ func (h *Handler) resolvePath(fullfilepath string, dhcpInfo *data.DHCP) string {
 	return fullfilepath
 }
 
-func (h *Handler) serveIPXE(rf io.ReaderFrom, content []byte, patch string) error {
-	if patch == "" {
-		patch = h.Patch
+// ipxePatches returns the patch slots of the iPXE binaries of mac. The
+// script slot comes from, in order, the host's patches, the backend's netboot
+// data and the default patches.
+func (h *Handler) ipxePatches(mac net.HardwareAddr, netboot *data.Netboot) binary.Patches {
+	var script string
+	if netboot != nil && len(netboot.IPXEScript) > 1 {
+		script = netboot.IPXEScript
 	}
 
-	patchedContent, err := binary.Patch(content, []byte(patch))
+	patches := h.patches
+	if patches == nil {
+		patches = &binary.Patcher{Default: binary.Patches{Script: h.Patch}}
+	}
+
+	return patches.WithScript(mac, script)
+}
+
+func (h *Handler) serveIPXE(rf io.ReaderFrom, content []byte, patches binary.Patches) error {
+	patchedContent, err := patches.Apply(content)
 	if err != nil {
 		return fmt.Errorf("failed to patch iPXE binary: %w", err)
 	}
@@ -338,6 +516,46 @@ func (h *Handler) serveContent(rf io.ReaderFrom, content []byte) error {
 	return err
 }
 
+// shapedTransfer sends files at the rates of a bandwidth.Shaper.
+type shapedTransfer struct {
+	tftp.OutgoingTransfer
+	rf     io.ReaderFrom
+	ctx    context.Context
+	shaper *bandwidth.Shaper
+}
+
+func (t *shapedTransfer) ReadFrom(r io.Reader) (int64, error) {
+	// The shaped reader hides the io.Seeker that the transfer sizes the
+	// tsize option with, so size it here.
+	if s, ok := r.(io.Seeker); ok {
+		if size, err := s.Seek(0, io.SeekEnd); err == nil {
+			if _, err := s.Seek(0, io.SeekStart); err != nil {
+				return 0, err
+			}
+			t.SetSize(size)
+		}
+	}
+
+	addr := t.RemoteAddr()
+	shaped, done := t.shaper.Reader(t.ctx, addr.IP.String(), r)
+	defer done()
+
+	return t.rf.ReadFrom(shaped)
+}
+
+// countedTransfer counts the bytes sent in tftp_transfer_bytes_total.
+type countedTransfer struct {
+	tftp.OutgoingTransfer
+	rf io.ReaderFrom
+}
+
+func (t *countedTransfer) ReadFrom(r io.Reader) (int64, error) {
+	n, err := t.rf.ReadFrom(r)
+	metric.TFTPTransferBytes.Add(float64(n))
+
+	return n, err
+}
+
 func getRemoteIP(r any) (net.IP, error) {
 	if r == nil {
 		return nil, fmt.Errorf("transfer object is nil")
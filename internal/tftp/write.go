@@ -0,0 +1,126 @@
+package tftp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// serialPrefix matches the Raspberry Pi serial number that the boot ROM and
+// EDK2 prefix their TFTP requests with.
+var serialPrefix = regexp.MustCompile(`^\d{2}[a-z]\d{5}$`)
+
+// errTooLarge is returned for writes larger than WritePolicy.MaxSize.
+var errTooLarge = errors.New("file exceeds the maximum TFTP write size")
+
+// WritePolicy controls which files clients may write over TFTP, such as
+// inspection data, EDK2 NVRAM dumps or crash logs pushed during early boot.
+type WritePolicy struct {
+	// Directory is where written files are stored. It defaults to the TFTP
+	// root directory.
+	Directory string
+	// AllowedPaths are path.Match patterns that the written path, relative to
+	// the client's directory, must match. No patterns allow any path.
+	AllowedPaths []string
+	// MaxSize is the largest file in bytes that may be written. Zero means
+	// unlimited.
+	MaxSize int64
+	// PerMAC, when set, stores the files of each client in a directory named
+	// after its MAC address and refuses writes from clients the backend does
+	// not know.
+	PerMAC bool
+}
+
+// target returns the path, relative to the write directory, that the client
+// with mac writes name to, or an error wrapping os.ErrPermission if the
+// policy does not allow it.
+func (p *WritePolicy) target(name string, mac net.HardwareAddr) (string, error) {
+	name = path.Clean(strings.TrimLeft(filepath.ToSlash(name), "/"))
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("%w: invalid path %q", os.ErrPermission, name)
+	}
+	if prefix, rest, ok := strings.Cut(name, "/"); ok && serialPrefix.MatchString(prefix) {
+		name = rest
+	}
+
+	allowed := len(p.AllowedPaths) == 0
+	for _, pattern := range p.AllowedPaths {
+		if ok, _ := path.Match(pattern, name); ok {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return "", fmt.Errorf("%w: path %q is not writable", os.ErrPermission, name)
+	}
+
+	if p.PerMAC {
+		if mac == nil {
+			return "", fmt.Errorf("%w: writes from unknown clients are refused", os.ErrPermission)
+		}
+		name = path.Join(strings.ReplaceAll(mac.String(), ":", "-"), name)
+	}
+
+	return filepath.FromSlash(name), nil
+}
+
+// write stores the file received by wt at name in dir. The file is replaced
+// only once it has been received completely and within MaxSize.
+func (p *WritePolicy) write(dir, name string, wt io.WriterTo) (int64, error) {
+	if it, ok := wt.(interface{ Size() (int64, bool) }); ok && p.MaxSize > 0 {
+		if n, ok := it.Size(); ok && n > p.MaxSize {
+			return 0, fmt.Errorf("%w: %d bytes", errTooLarge, n)
+		}
+	}
+
+	full := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+		return 0, fmt.Errorf("failed to create directory for %s: %w", name, err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(full), "."+filepath.Base(full)+".*")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create file for %s: %w", name, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	var w io.Writer = tmp
+	if p.MaxSize > 0 {
+		w = &limitWriter{w: tmp, n: p.MaxSize}
+	}
+	n, err := wt.WriteTo(w)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return n, fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return n, err
+	}
+	if err := os.Rename(tmp.Name(), full); err != nil {
+		return n, fmt.Errorf("failed to store %s: %w", name, err)
+	}
+
+	return n, nil
+}
+
+// limitWriter fails writes beyond n bytes.
+type limitWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (l *limitWriter) Write(b []byte) (int, error) {
+	if int64(len(b)) > l.n {
+		return 0, errTooLarge
+	}
+	l.n -= int64(len(b))
+
+	return l.w.Write(b)
+}
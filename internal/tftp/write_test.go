@@ -0,0 +1,66 @@
+package tftp
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWritePolicyTarget(t *testing.T) {
+	mac := net.HardwareAddr{0xd8, 0x3a, 0xdd, 0x01, 0x02, 0x03}
+	p := &WritePolicy{AllowedPaths: []string{"RPI_EFI.fd", "crash/*"}, PerMAC: true}
+
+	for _, tt := range []struct {
+		name string
+		mac  net.HardwareAddr
+		want string
+	}{
+		{name: "RPI_EFI.fd", mac: mac, want: "d8-3a-dd-01-02-03/RPI_EFI.fd"},
+		{name: "/10a01234/RPI_EFI.fd", mac: mac, want: "d8-3a-dd-01-02-03/RPI_EFI.fd"},
+		{name: "crash/kernel.log", mac: mac, want: "d8-3a-dd-01-02-03/crash/kernel.log"},
+		{name: "crash/../../other/RPI_EFI.fd", mac: mac},
+		{name: "../RPI_EFI.fd", mac: mac},
+		{name: "snp.efi", mac: mac},
+		{name: "crash/kernel.log"},
+	} {
+		got, err := p.target(tt.name, tt.mac)
+		if tt.want == "" {
+			if !errors.Is(err, os.ErrPermission) {
+				t.Errorf("target(%q, %v) = %q, %v, want a permission error",
+					tt.name, tt.mac, got, err)
+			}
+			continue
+		}
+		if err != nil || got != filepath.FromSlash(tt.want) {
+			t.Errorf("target(%q, %v) = %q, %v, want %q", tt.name, tt.mac, got, err, tt.want)
+		}
+	}
+}
+
+func TestWritePolicyWrite(t *testing.T) {
+	dir := t.TempDir()
+	p := &WritePolicy{MaxSize: 8}
+	name := filepath.Join("d8-3a-dd-01-02-03", "crash", "kernel.log")
+
+	if _, err := p.write(dir, name, bytes.NewBufferString("panic")); err != nil {
+		t.Fatalf("write() error = %v", err)
+	}
+	_, err := p.write(dir, name, bytes.NewBufferString("too large!"))
+	if !errors.Is(err, errTooLarge) {
+		t.Fatalf("write() of an oversized file error = %v, want %v", err, errTooLarge)
+	}
+
+	// The oversized write leaves the previous file in place and no temporary
+	// files behind.
+	got, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil || string(got) != "panic" {
+		t.Errorf("file = %q, %v, want %q", got, err, "panic")
+	}
+	entries, _ := os.ReadDir(filepath.Dir(filepath.Join(dir, name)))
+	if len(entries) != 1 {
+		t.Errorf("directory has %d entries, want 1", len(entries))
+	}
+}
@@ -0,0 +1,336 @@
+// Package tlscert manages the TLS certificate served by the HTTP API. The
+// certificate can be replaced and new keys generated at runtime; the server
+// picks up changes on the next handshake.
+package tlscert
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pendingSuffix is appended to the key file for a key generated by
+// GenerateCSR that has not yet been paired with a certificate.
+const pendingSuffix = ".pending"
+
+var (
+	// ErrInvalid is returned for malformed certificates or requests.
+	ErrInvalid = errors.New("invalid certificate")
+	// ErrKeyMismatch is returned when a certificate matches neither the
+	// current key nor a key from GenerateCSR.
+	ErrKeyMismatch = errors.New("certificate does not match any known private key")
+)
+
+// CSRRequest holds the subject and key parameters of a certificate signing request.
+type CSRRequest struct {
+	CommonName         string
+	Organization       string
+	OrganizationalUnit string
+	City               string
+	State              string
+	Country            string
+	AlternativeNames   []string
+	// KeyAlgorithm is "RSA" or "ECDSA" (the default).
+	KeyAlgorithm string
+	// KeyBitLength is the RSA key size. It defaults to 2048.
+	KeyBitLength int
+}
+
+// Store holds the current certificate and persists it to CertFile/KeyFile.
+type Store struct {
+	mu       sync.RWMutex
+	certFile string
+	keyFile  string
+	cert     *tls.Certificate
+}
+
+// NewStore loads the certificate pair from certFile and keyFile. If they do
+// not exist a self-signed certificate for hosts is generated and written.
+func NewStore(certFile, keyFile string, hosts ...string) (*Store, error) {
+	s := &Store{certFile: certFile, keyFile: keyFile}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	switch {
+	case err == nil:
+		s.cert = &cert
+		return s, nil
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	key, err := generateKey("ECDSA", 0)
+	if err != nil {
+		return nil, err
+	}
+	certPEM, err := selfSigned(key, hosts)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.install(certPEM, keyPEM); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (s *Store) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.cert, nil
+}
+
+// TLSConfig returns a server TLS configuration backed by the store.
+func (s *Store) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: s.GetCertificate,
+	}
+}
+
+// Leaf returns the parsed leaf certificate.
+func (s *Store) Leaf() (*x509.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.cert.Leaf != nil {
+		return s.cert.Leaf, nil
+	}
+
+	return x509.ParseCertificate(s.cert.Certificate[0])
+}
+
+// CertificatePEM returns the PEM encoded certificate chain.
+func (s *Store) CertificatePEM() []byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var buf bytes.Buffer
+	for _, der := range s.cert.Certificate {
+		pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}
+
+	return buf.Bytes()
+}
+
+// Replace installs the PEM certificate chain in data. data may also contain
+// the private key; otherwise the certificate must match the current key or
+// the key generated by the last GenerateCSR.
+func (s *Store) Replace(data []byte) error {
+	var certPEM, keyPEM []byte
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		switch {
+		case block.Type == "CERTIFICATE":
+			certPEM = append(certPEM, pem.EncodeToMemory(block)...)
+		case strings.HasSuffix(block.Type, "PRIVATE KEY"):
+			keyPEM = pem.EncodeToMemory(block)
+		}
+	}
+	if len(certPEM) == 0 {
+		return fmt.Errorf("%w: no PEM certificate found", ErrInvalid)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if keyPEM != nil {
+		if _, err := tls.X509KeyPair(certPEM, keyPEM); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalid, err)
+		}
+		return s.install(certPEM, keyPEM)
+	}
+
+	candidates := []string{s.keyFile + pendingSuffix, s.keyFile}
+	for _, path := range candidates {
+		keyPEM, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		if _, err := tls.X509KeyPair(certPEM, keyPEM); err != nil {
+			continue
+		}
+		if err := s.install(certPEM, keyPEM); err != nil {
+			return err
+		}
+		if path != s.keyFile {
+			os.Remove(path)
+		}
+		return nil
+	}
+
+	return ErrKeyMismatch
+}
+
+// GenerateCSR creates a new private key, keeps it pending until a matching
+// certificate is passed to Replace, and returns a PEM encoded CSR for it.
+func (s *Store) GenerateCSR(req CSRRequest) ([]byte, error) {
+	if req.CommonName == "" {
+		return nil, fmt.Errorf("%w: CommonName is required", ErrInvalid)
+	}
+
+	key, err := generateKey(req.KeyAlgorithm, req.KeyBitLength)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl := &x509.CertificateRequest{
+		Subject: pkix.Name{
+			CommonName:         req.CommonName,
+			Organization:       nonEmpty(req.Organization),
+			OrganizationalUnit: nonEmpty(req.OrganizationalUnit),
+			Locality:           nonEmpty(req.City),
+			Province:           nonEmpty(req.State),
+			Country:            nonEmpty(req.Country),
+		},
+	}
+	for _, name := range req.AlternativeNames {
+		if ip := net.ParseIP(name); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, name)
+		}
+	}
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, tmpl, key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := writeAtomic(s.keyFile+pendingSuffix, keyPEM, 0o600); err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), nil
+}
+
+// install writes the pair to disk and makes it current. Callers must hold
+// s.mu, except during construction.
+func (s *Store) install(certPEM, keyPEM []byte) error {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+
+	if err := writeAtomic(s.keyFile, keyPEM, 0o600); err != nil {
+		return err
+	}
+	if err := writeAtomic(s.certFile, certPEM, 0o644); err != nil {
+		return err
+	}
+	s.cert = &cert
+
+	return nil
+}
+
+func generateKey(algorithm string, bits int) (crypto.Signer, error) {
+	switch algorithm {
+	case "", "ECDSA":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "RSA":
+		if bits == 0 {
+			bits = 2048
+		}
+		if bits < 2048 || bits > 8192 {
+			return nil, fmt.Errorf("%w: unsupported RSA key length %d", ErrInvalid, bits)
+		}
+		return rsa.GenerateKey(rand.Reader, bits)
+	default:
+		return nil, fmt.Errorf("%w: unsupported key algorithm %q", ErrInvalid, algorithm)
+	}
+}
+
+func encodeKey(key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode private key: %w", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+func selfSigned(key crypto.Signer, hosts []string) ([]byte, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "metal-boot"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else if h != "" {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create self-signed certificate: %w", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
+
+func writeAtomic(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create certificate directory: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filepath.Base(path), err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", filepath.Base(path), err)
+	}
+
+	return nil
+}
+
+func nonEmpty(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	return []string{s}
+}
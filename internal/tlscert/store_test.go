@@ -0,0 +1,158 @@
+package tlscert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T) (*Store, string) {
+	t.Helper()
+	dir := t.TempDir()
+	s, err := NewStore(
+		filepath.Join(dir, "tls.crt"),
+		filepath.Join(dir, "tls.key"),
+		"localhost",
+		"127.0.0.1",
+	)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+
+	return s, dir
+}
+
+func TestNewStoreSelfSigned(t *testing.T) {
+	s, dir := newTestStore(t)
+
+	leaf, err := s.Leaf()
+	if err != nil {
+		t.Fatalf("Leaf() error = %v", err)
+	}
+	if err := leaf.VerifyHostname("localhost"); err != nil {
+		t.Errorf("VerifyHostname(localhost) error = %v", err)
+	}
+	if err := leaf.VerifyHostname("127.0.0.1"); err != nil {
+		t.Errorf("VerifyHostname(127.0.0.1) error = %v", err)
+	}
+
+	for _, name := range []string{"tls.crt", "tls.key"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s not written: %v", name, err)
+		}
+	}
+
+	// A second store over the same files keeps the existing certificate.
+	reopened, err := NewStore(filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"))
+	if err != nil {
+		t.Fatalf("NewStore() reopen error = %v", err)
+	}
+	if string(reopened.CertificatePEM()) != string(s.CertificatePEM()) {
+		t.Error("reopened store generated a new certificate")
+	}
+}
+
+func TestGenerateCSRAndReplace(t *testing.T) {
+	s, _ := newTestStore(t)
+
+	csrPEM, err := s.GenerateCSR(CSRRequest{
+		CommonName:       "metal-boot.example.com",
+		AlternativeNames: []string{"metal-boot.example.com", "10.0.0.1"},
+	})
+	if err != nil {
+		t.Fatalf("GenerateCSR() error = %v", err)
+	}
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		t.Fatalf("GenerateCSR() returned %q, want a CSR", csrPEM)
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		t.Fatalf("ParseCertificateRequest() error = %v", err)
+	}
+	if len(csr.DNSNames) != 1 || len(csr.IPAddresses) != 1 {
+		t.Errorf("CSR SANs = %v %v, want one DNS name and one IP", csr.DNSNames, csr.IPAddresses)
+	}
+
+	certPEM := signCSR(t, csr)
+	if err := s.Replace(certPEM); err != nil {
+		t.Fatalf("Replace() error = %v", err)
+	}
+
+	leaf, err := s.Leaf()
+	if err != nil {
+		t.Fatalf("Leaf() error = %v", err)
+	}
+	if leaf.Subject.CommonName != "metal-boot.example.com" {
+		t.Errorf("CommonName = %q, want metal-boot.example.com", leaf.Subject.CommonName)
+	}
+}
+
+func TestReplaceErrors(t *testing.T) {
+	s, _ := newTestStore(t)
+
+	if err := s.Replace([]byte("not a certificate")); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Replace(garbage) error = %v, want ErrInvalid", err)
+	}
+
+	// A certificate for a key the store has never seen is rejected.
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "stranger"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := s.Replace(certPEM); !errors.Is(err, ErrKeyMismatch) {
+		t.Errorf("Replace(foreign cert) error = %v, want ErrKeyMismatch", err)
+	}
+}
+
+// signCSR issues a certificate for csr from a throwaway CA.
+func signCSR(t *testing.T, csr *x509.CertificateRequest) []byte {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      csr.Subject,
+		DNSNames:     csr.DNSNames,
+		IPAddresses:  csr.IPAddresses,
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, csr.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
@@ -6,25 +6,51 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/google/go-containerregistry/pkg/crane"
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/metal3-community/metal-boot/internal/download"
 )
 
 const defaultImageRef = "ghcr.io/metal3-community/ironic-python-agent-image:latest"
 
-func DownloadIpaImages(rootpath string) error {
-	architectures := []string{"amd64", "arm64"}
-	for _, arch := range architectures {
-		if err := downloadImage(rootpath, arch); err != nil {
+// ipaArchitectures are the architectures DownloadIpaImages pulls.
+var ipaArchitectures = []string{"amd64", "arm64"}
+
+// IpaImageDirs returns the directories DownloadIpaImages extracts the images
+// to below rootpath.
+func IpaImageDirs(rootpath string) []string {
+	dirs := make([]string, 0, len(ipaArchitectures))
+	for _, arch := range ipaArchitectures {
+		dirs = append(dirs, filepath.Join(rootpath, arch))
+	}
+	return dirs
+}
+
+// DownloadIpaImages pulls the IPA image of every architecture and extracts
+// it below rootpath. The progress of each pull is recorded on downloads, which
+// may be nil.
+func DownloadIpaImages(rootpath string, downloads *download.Tracker) error {
+	for _, arch := range ipaArchitectures {
+		progress := downloads.Start(
+			fmt.Sprintf("ipa-download-%s-%d", arch, time.Now().Unix()),
+			"IPA image "+arch,
+			defaultImageRef,
+		)
+		err := downloadImage(rootpath, arch, progress)
+		progress.Done(err)
+		if err != nil {
 			return fmt.Errorf("failed to download image for %s: %w", arch, err)
 		}
 	}
 	return nil
 }
 
-func downloadImage(rootpath string, arch string) error {
+// downloadImage counts the uncompressed layer bytes on progress; registries
+// only report compressed sizes, so the total stays unknown.
+func downloadImage(rootpath string, arch string, progress *download.Download) error {
 	imageRef := defaultImageRef
 
 	plt := v1.Platform{
@@ -49,14 +75,14 @@ func downloadImage(rootpath string, arch string) error {
 		return fmt.Errorf("failed to create rootfs directory: %w", err)
 	}
 
-	if err := extractImage(img, rootfs); err != nil {
+	if err := extractImage(img, rootfs, progress); err != nil {
 		return fmt.Errorf("failed to extract image: %w", err)
 	}
 
 	return nil
 }
 
-func extractImage(img v1.Image, root string) error {
+func extractImage(img v1.Image, root string, progress io.Writer) error {
 	layers, err := img.Layers()
 	if err != nil {
 		return err
@@ -69,7 +95,7 @@ func extractImage(img v1.Image, root string) error {
 		}
 		defer r.Close()
 
-		if err := untar(r, root); err != nil {
+		if err := untar(io.TeeReader(r, progress), root); err != nil {
 			return err
 		}
 	}
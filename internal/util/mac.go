@@ -2,7 +2,9 @@ package util
 
 import (
 	"bytes"
+	"encoding/hex"
 	"net"
+	"strings"
 )
 
 // IsRaspberryPI checks if the mac address is from a Raspberry PI by matching prefixes against OUI registrations of the Raspberry Pi Trading Ltd.
@@ -25,3 +27,36 @@ func IsRaspberryPI(mac net.HardwareAddr) bool {
 
 	return false
 }
+
+// ParseMAC parses a MAC address in any of the forms backends and operators
+// write them: colon, dash or dot separated as net.ParseMAC accepts, or twelve
+// hexadecimal digits without separators, in either case. The result prints
+// in the normalized lowercase, colon separated form.
+func ParseMAC(s string) (net.HardwareAddr, error) {
+	s = strings.TrimSpace(s)
+	if len(s) == 12 && !strings.ContainsAny(s, ":-.") {
+		if b, err := hex.DecodeString(s); err == nil {
+			return net.HardwareAddr(b), nil
+		}
+	}
+
+	return net.ParseMAC(s)
+}
+
+// NormalizeMAC returns s in the normalized lowercase, colon separated form,
+// or s unchanged if it is not a MAC address ParseMAC accepts.
+func NormalizeMAC(s string) string {
+	mac, err := ParseMAC(s)
+	if err != nil {
+		return s
+	}
+
+	return mac.String()
+}
+
+// IsLocallyAdministered reports whether mac is a locally administered
+// address, such as the randomized addresses some operating systems and
+// firmware use instead of the address burnt into the NIC.
+func IsLocallyAdministered(mac net.HardwareAddr) bool {
+	return len(mac) > 0 && mac[0]&0x02 != 0
+}